@@ -5,6 +5,8 @@ import (
 	"ftrack/services"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/sirupsen/logrus"
@@ -18,6 +20,37 @@ type Config struct {
 	JWTSecret   string
 	BaseURL     string // Added for email links
 
+	// Previous JWT secrets that still verify tokens after JWTSecret changed
+	JWTPreviousSecrets []string
+
+	// Tokens issued without a key ID are accepted until this time, set once
+	// when rolling out key IDs. Unset, they are rejected.
+	JWTLegacyTokensUntil time.Time
+
+	// Key TOTP secrets are encrypted with. Falls back to JWTSecret.
+	MFAEncryptionKey string
+
+	// Key signed URLs are signed with. Falls back to JWTSecret.
+	URLSigningKey string
+
+	// Keys message content is encrypted with at rest, as "id:base64key"
+	// entries, and the ID of the one new content is encrypted with. Older
+	// keys stay listed until content is re-encrypted with the current one.
+	MessageEncryptionKeys  []string
+	MessageEncryptionKeyID string
+
+	// Password policy
+	PasswordMinLength    int
+	PasswordMinScore     int    // 0-4, zxcvbn style
+	PasswordBreachedList string // file of breached passwords, one per line
+
+	// Login lockouts after repeated failed logins
+	LoginLockoutAccountThreshold int // failures of one account
+	LoginLockoutIPThreshold      int // failures from one IP address
+	LoginFailureWindow           int // minutes failures are counted for
+	LoginLockoutBaseDelay        int // seconds, doubled by each lockout within a day
+	LoginLockoutMaxDelay         int // minutes
+
 	// Firebase Config
 	FirebaseCredentials string
 
@@ -28,10 +61,107 @@ type Config struct {
 
 	// App Settings
 	MaxCircleMembers  int
+	MaxMessageLength  int // characters
 	LocationRetention int // days
 	RateLimitRequest  int
 	RateLimitWindow   int // minutes
 
+	// List pagination. History lists like chat default to longer pages.
+	DefaultPageSize        int
+	DefaultHistoryPageSize int
+	MaxPageSize            int
+
+	// WebSocket compression (permessage-deflate)
+	WSCompressionEnabled   bool
+	WSCompressionLevel     int // 1-9
+	WSCompressionThreshold int // bytes, smaller frames are not compressed
+
+	// WebSocket broadcast fan-out
+	WSFanoutWorkers   int
+	WSFanoutQueueSize int // batches of recipients waiting for a worker
+	WSFanoutBatchSize int // recipients per batch
+
+	// PDF exports
+	ExportPDFPagesPerFile int
+	ExportPDFFontPath     string // UTF-8 TTF font for message text
+	ExportPDFFallbackFont string // TTF font for glyphs missing from the main font, e.g. emoji
+
+	// Member locations show as stale, then offline, after this long without
+	// an update, unless their circle sets its own
+	LocationStaleAfter   int // minutes
+	LocationOfflineAfter int // minutes
+
+	// Deactivated accounts
+	DeactivatedAccountRetention int // days before a deactivated account is deleted
+	DeactivationWarningDays     int // days before deletion that the user is warned
+
+	// Database circuit breaker
+	DBCircuitFailureThreshold int // consecutive connection failures that open the circuit
+	DBCircuitCooldown         int // seconds before probing the database again
+
+	// External providers: push, SMS, email, static maps and webhooks
+	ProviderTimeout                 int // seconds a single attempt may take
+	ProviderMaxRetries              int // attempts after the first
+	ProviderCircuitFailureThreshold int // consecutive failed attempts that open a provider's circuit
+	ProviderCircuitCooldown         int // seconds before a trial call is let through
+
+	// Repository query limits, in milliseconds
+	DBReadTimeout        int
+	DBAggregateTimeout   int
+	DBSlowQueryThreshold int // queries at least this slow are logged
+
+	// Push notification images
+	MediaUploadPath string
+	StaticMapsURL   string // provider URL with {lat}, {lon}, {zoom}, {width} and {height}
+
+	// Store identical uploads once, shared by reference
+	MediaDedupEnabled bool
+
+	// "s3" lets clients upload media straight to an S3-compatible bucket
+	// through pre-signed URLs; "local" only accepts uploads through the API
+	MediaStorageBackend  string
+	MediaS3Endpoint      string
+	MediaS3Region        string
+	MediaS3Bucket        string
+	MediaS3AccessKey     string
+	MediaS3SecretKey     string
+	MediaUploadIntentTTL int // minutes a pre-signed upload URL stays valid
+
+	// Places closer than this many meters are suspected duplicates
+	PlaceDuplicateDistance int
+
+	// Hours for a visit to lose half its weight in trending and popular
+	// places
+	PlaceTrendingHalfLifeHours int
+	PlacePopularHalfLifeHours  int
+
+	// Geofence radius bounds in meters. Plans can have their own bounds,
+	// as "plan:min-max" entries, e.g. "premium:10-20000".
+	PlaceRadiusMin        int
+	PlaceRadiusMax        int
+	PlaceRadiusPlanBounds []string
+
+	// Places suggested from location history: stays within a radius for
+	// a while, clustered within another radius, on enough different days
+	PlaceSuggestionsEnabled      bool
+	PlaceSuggestionStayRadius    int // meters
+	PlaceSuggestionMinStay       int // minutes
+	PlaceSuggestionClusterRadius int // meters
+	PlaceSuggestionMinVisits     int
+	PlaceSuggestionLookback      int // days, at most the location retention
+
+	// Words filtered from place reviews, on top of the built-in list
+	ProfanityWords []string
+
+	// Seconds a sender has to be quiet in a circle before their next
+	// message gets its own notification, instead of updating the last one.
+	// 0 turns coalescing off.
+	MessageNotificationWindow int
+
+	// Batching windows of notification types that differ from the
+	// defaults, as "type:seconds" entries, e.g. "message:30".
+	NotificationBatchWindows []string
+
 	EmailProvider string `env:"EMAIL_PROVIDER" envDefault:"smtp"`
 
 	// SMTP Settings
@@ -51,6 +181,27 @@ func Load() *Config {
 		JWTSecret:   getEnv("JWT_SECRET", "your-super-secret-jwt-key"),
 		BaseURL:     getEnv("BASE_URL", "http://localhost:8080"), // Added BaseURL
 
+		JWTPreviousSecrets:   getEnvAsList("JWT_PREVIOUS_SECRETS"),
+		JWTLegacyTokensUntil: getEnvAsTime("JWT_LEGACY_TOKENS_UNTIL"),
+
+		MFAEncryptionKey: getEnv("MFA_ENCRYPTION_KEY", ""),
+		URLSigningKey:    getEnv("URL_SIGNING_KEY", ""),
+
+		MessageEncryptionKeys:  getEnvAsList("MESSAGE_ENCRYPTION_KEYS"),
+		MessageEncryptionKeyID: getEnv("MESSAGE_ENCRYPTION_KEY_ID", ""),
+
+		// Password policy
+		PasswordMinLength:    getEnvAsInt("PASSWORD_MIN_LENGTH", 10),
+		PasswordMinScore:     getEnvAsInt("PASSWORD_MIN_SCORE", 3),
+		PasswordBreachedList: getEnv("PASSWORD_BREACHED_LIST", "./config/breached_passwords.txt"),
+
+		// Login lockouts
+		LoginLockoutAccountThreshold: getEnvAsInt("LOGIN_LOCKOUT_ACCOUNT_THRESHOLD", 5),
+		LoginLockoutIPThreshold:      getEnvAsInt("LOGIN_LOCKOUT_IP_THRESHOLD", 20),
+		LoginFailureWindow:           getEnvAsInt("LOGIN_FAILURE_WINDOW_MINUTES", 15),
+		LoginLockoutBaseDelay:        getEnvAsInt("LOGIN_LOCKOUT_BASE_DELAY_SECONDS", 30),
+		LoginLockoutMaxDelay:         getEnvAsInt("LOGIN_LOCKOUT_MAX_DELAY_MINUTES", 60),
+
 		// Firebase
 		FirebaseCredentials: getEnv("FIREBASE_CREDENTIALS", ""),
 
@@ -61,10 +212,83 @@ func Load() *Config {
 
 		// App Settings
 		MaxCircleMembers:  getEnvAsInt("MAX_CIRCLE_MEMBERS", 20),
+		MaxMessageLength:  getEnvAsInt("MAX_MESSAGE_LENGTH", 4000),
 		LocationRetention: getEnvAsInt("LOCATION_RETENTION_DAYS", 30),
 		RateLimitRequest:  getEnvAsInt("RATE_LIMIT_REQUESTS", 100),
 		RateLimitWindow:   getEnvAsInt("RATE_LIMIT_WINDOW_MINUTES", 1),
 
+		// Pagination
+		DefaultPageSize:        getEnvAsInt("DEFAULT_PAGE_SIZE", 20),
+		DefaultHistoryPageSize: getEnvAsInt("DEFAULT_HISTORY_PAGE_SIZE", 50),
+		MaxPageSize:            getEnvAsInt("MAX_PAGE_SIZE", 100),
+
+		// WebSocket compression
+		WSCompressionEnabled:   getEnvAsBool("WS_COMPRESSION_ENABLED", true),
+		WSCompressionLevel:     getEnvAsInt("WS_COMPRESSION_LEVEL", 1),
+		WSCompressionThreshold: getEnvAsInt("WS_COMPRESSION_THRESHOLD", 512),
+
+		// WebSocket broadcast fan-out
+		WSFanoutWorkers:   getEnvAsInt("WS_FANOUT_WORKERS", 8),
+		WSFanoutQueueSize: getEnvAsInt("WS_FANOUT_QUEUE_SIZE", 1024),
+		WSFanoutBatchSize: getEnvAsInt("WS_FANOUT_BATCH_SIZE", 50),
+
+		// PDF exports
+		ExportPDFPagesPerFile: getEnvAsInt("EXPORT_PDF_PAGES_PER_FILE", 500),
+		ExportPDFFontPath:     getEnv("EXPORT_PDF_FONT", ""),
+		ExportPDFFallbackFont: getEnv("EXPORT_PDF_FALLBACK_FONT", ""),
+
+		LocationStaleAfter:   getEnvAsInt("LOCATION_STALE_AFTER_MINUTES", 15),
+		LocationOfflineAfter: getEnvAsInt("LOCATION_OFFLINE_AFTER_MINUTES", 120),
+
+		// Deactivated accounts
+		DeactivatedAccountRetention: getEnvAsInt("DEACTIVATED_ACCOUNT_RETENTION_DAYS", 365),
+		DeactivationWarningDays:     getEnvAsInt("DEACTIVATION_WARNING_DAYS", 30),
+
+		DBCircuitFailureThreshold: getEnvAsInt("DB_CIRCUIT_FAILURE_THRESHOLD", 5),
+		DBCircuitCooldown:         getEnvAsInt("DB_CIRCUIT_COOLDOWN_SECONDS", 10),
+
+		ProviderTimeout:                 getEnvAsInt("PROVIDER_TIMEOUT_SECONDS", 10),
+		ProviderMaxRetries:              getEnvAsInt("PROVIDER_MAX_RETRIES", 2),
+		ProviderCircuitFailureThreshold: getEnvAsInt("PROVIDER_CIRCUIT_FAILURE_THRESHOLD", 5),
+		ProviderCircuitCooldown:         getEnvAsInt("PROVIDER_CIRCUIT_COOLDOWN_SECONDS", 30),
+
+		DBReadTimeout:        getEnvAsInt("DB_READ_TIMEOUT_MS", 5000),
+		DBAggregateTimeout:   getEnvAsInt("DB_AGGREGATE_TIMEOUT_MS", 10000),
+		DBSlowQueryThreshold: getEnvAsInt("DB_SLOW_QUERY_THRESHOLD_MS", 500),
+
+		// Push notification images
+		MediaUploadPath: getEnv("MEDIA_UPLOAD_PATH", "./uploads"),
+		StaticMapsURL:   getEnv("STATIC_MAPS_URL", ""),
+
+		MediaDedupEnabled: getEnvAsBool("MEDIA_DEDUP_ENABLED", true),
+
+		MediaStorageBackend:  getEnv("MEDIA_STORAGE_BACKEND", "local"),
+		MediaS3Endpoint:      getEnv("MEDIA_S3_ENDPOINT", ""),
+		MediaS3Region:        getEnv("MEDIA_S3_REGION", "us-east-1"),
+		MediaS3Bucket:        getEnv("MEDIA_S3_BUCKET", ""),
+		MediaS3AccessKey:     getEnv("MEDIA_S3_ACCESS_KEY", ""),
+		MediaS3SecretKey:     getEnv("MEDIA_S3_SECRET_KEY", ""),
+		MediaUploadIntentTTL: getEnvAsInt("MEDIA_UPLOAD_INTENT_TTL_MINUTES", 15),
+
+		PlaceDuplicateDistance: getEnvAsInt("PLACE_DUPLICATE_DISTANCE", 75),
+		PlaceRadiusMin:         getEnvAsInt("PLACE_RADIUS_MIN", 10),
+		PlaceRadiusMax:         getEnvAsInt("PLACE_RADIUS_MAX", 5000),
+		PlaceRadiusPlanBounds:  getEnvAsList("PLACE_RADIUS_PLAN_BOUNDS"),
+		ProfanityWords:         getEnvAsList("PROFANITY_WORDS"),
+
+		PlaceTrendingHalfLifeHours: getEnvAsInt("PLACE_TRENDING_HALF_LIFE_HOURS", 72),
+		PlacePopularHalfLifeHours:  getEnvAsInt("PLACE_POPULAR_HALF_LIFE_HOURS", 720),
+
+		PlaceSuggestionsEnabled:      getEnvAsBool("PLACE_SUGGESTIONS_ENABLED", true),
+		PlaceSuggestionStayRadius:    getEnvAsInt("PLACE_SUGGESTION_STAY_RADIUS", 100),
+		PlaceSuggestionMinStay:       getEnvAsInt("PLACE_SUGGESTION_MIN_STAY_MINUTES", 15),
+		PlaceSuggestionClusterRadius: getEnvAsInt("PLACE_SUGGESTION_CLUSTER_RADIUS", 150),
+		PlaceSuggestionMinVisits:     getEnvAsInt("PLACE_SUGGESTION_MIN_VISITS", 3),
+		PlaceSuggestionLookback:      getEnvAsInt("PLACE_SUGGESTION_LOOKBACK_DAYS", 30),
+
+		MessageNotificationWindow: getEnvAsInt("MESSAGE_NOTIFICATION_WINDOW_SECONDS", 60),
+		NotificationBatchWindows:  getEnvAsList("NOTIFICATION_BATCH_WINDOWS"),
+
 		// Email settings
 		EmailProvider: getEnv("EMAIL_PROVIDER", "smtp"),
 		SMTPHost:      getEnv("SMTP_HOST", "smtp.gmail.com"),
@@ -106,6 +330,37 @@ func getEnvAsInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+// getEnvAsList splits a comma-separated variable
+func getEnvAsList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getEnvAsTime parses an RFC 3339 time, zero if unset or invalid
+func getEnvAsTime(key string) time.Time {
+	if value := os.Getenv(key); value != "" {
+		if timeValue, err := time.Parse(time.RFC3339, value); err == nil {
+			return timeValue
+		}
+		logrus.Warnf("Ignoring %s: not an RFC 3339 time", key)
+	}
+	return time.Time{}
+}
+
+func getEnvAsBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
 // InitEmailService initializes the email service based on configuration
 func (c *Config) InitEmailService() services.EmailService {
 	switch c.EmailProvider {
@@ -125,3 +380,28 @@ func (c *Config) InitEmailService() services.EmailService {
 		return services.NewMockEmailService()
 	}
 }
+
+// InitMediaObjectStore initializes the bucket direct uploads go to, or
+// returns nil when media is only uploaded through the API
+func (c *Config) InitMediaObjectStore() *services.MediaObjectStore {
+	switch c.MediaStorageBackend {
+	case "s3":
+		store, err := services.NewMediaObjectStore(services.MediaObjectStoreConfig{
+			Endpoint:  c.MediaS3Endpoint,
+			Region:    c.MediaS3Region,
+			Bucket:    c.MediaS3Bucket,
+			AccessKey: c.MediaS3AccessKey,
+			SecretKey: c.MediaS3SecretKey,
+		})
+		if err != nil {
+			logrus.Errorf("Invalid media object store configuration, direct uploads disabled: %v", err)
+			return nil
+		}
+		return store
+	case "local", "":
+		return nil
+	default:
+		logrus.Warn("Unknown media storage backend, direct uploads disabled")
+		return nil
+	}
+}
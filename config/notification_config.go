@@ -71,11 +71,15 @@ func InitializeNotificationServices(
 	notificationRepo := repositories.NewNotificationRepository(db)
 	userRepo := repositories.NewUserRepository(db)
 	circleRepo := repositories.NewCircleRepository(db)
+	blockRepo := repositories.NewBlockRepository(db)
 
 	// Create database indexes
 	if err := notificationRepo.CreateIndexes(context.Background()); err != nil {
 		logrus.Errorf("Failed to create notification indexes: %v", err)
 	}
+	if err := blockRepo.CreateIndexes(context.Background()); err != nil {
+		logrus.Errorf("Failed to create block indexes: %v", err)
+	}
 
 	// Initialize Firebase/FCM client
 	var fcmClient *messaging.Client
@@ -114,6 +118,7 @@ func InitializeNotificationServices(
 		notificationRepo,
 		userRepo,
 		circleRepo,
+		blockRepo,
 		redis,
 		hub,
 		emailService,
@@ -5,8 +5,8 @@ import (
 	"ftrack/models"
 	"ftrack/services"
 	"ftrack/utils"
+	"net/http"
 	"time"
-	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
@@ -39,7 +39,7 @@ func (ac *AuthController) Register(c *gin.Context) {
 	var req models.RegisterRequest
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request body")
+		utils.BindErrorResponse(c, "Invalid request body", err)
 		return
 	}
 
@@ -53,7 +53,7 @@ func (ac *AuthController) Register(c *gin.Context) {
 		case "phone already exists":
 			utils.ConflictResponse(c, "User with this phone number already exists")
 		case "validation failed":
-			utils.BadRequestResponse(c, "Invalid input data")
+			utils.ValidationFailedResponse(c, "Invalid input data", err)
 		default:
 			utils.InternalServerErrorResponse(c, "Failed to create account")
 		}
@@ -81,6 +81,8 @@ func (ac *AuthController) Login(c *gin.Context) {
 		utils.BadRequestResponse(c, "Invalid request body")
 		return
 	}
+	// Lockouts go by the address the request came from
+	req.IPAddress = c.ClientIP()
 
 	response, err := ac.authService.Login(c.Request.Context(), req)
 	if err != nil {
@@ -91,10 +93,65 @@ func (ac *AuthController) Login(c *gin.Context) {
 			utils.UnauthorizedResponse(c, "Invalid email or password")
 		case "account is deactivated":
 			utils.UnauthorizedResponse(c, "Account is deactivated")
+		case "account reactivation required":
+			utils.ForbiddenResponse(c, "Account is deactivated. Log in again with reactivate set to true to reactivate it")
 		case "email not verified":
 			utils.UnauthorizedResponse(c, "Please verify your email address")
-		case "2fa required":
-			utils.UnauthorizedResponse(c, "Two-factor authentication required")
+		case "invalid 2fa code":
+			utils.UnauthorizedResponse(c, "Invalid two-factor authentication code")
+		case "too many 2fa attempts":
+			tooManyMFAAttemptsResponse(c)
+		case "too many login attempts":
+			utils.LoginLockedResponse(c, err)
+		case "validation failed":
+			utils.BadRequestResponse(c, "Invalid input data")
+		default:
+			utils.InternalServerErrorResponse(c, "Authentication failed")
+		}
+		return
+	}
+
+	if response.Requires2FA {
+		utils.SuccessResponse(c, "Two-factor authentication required", response.MFAChallenge)
+		return
+	}
+
+	utils.SuccessResponse(c, "Login successful", response)
+}
+
+// CompleteMFALogin handles the second step of a login with 2FA enabled
+// @Summary Complete 2FA login
+// @Description Complete a login with a TOTP code or a backup code. Each backup code works once.
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param request body models.MFALoginRequest true "MFA token from login and code"
+// @Success 200 {object} models.APIResponse{data=models.AuthResponse}
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 429 {object} models.APIResponse
+// @Router /auth/login/mfa [post]
+func (ac *AuthController) CompleteMFALogin(c *gin.Context) {
+	var req models.MFALoginRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request body")
+		return
+	}
+
+	response, err := ac.authService.CompleteMFALogin(c.Request.Context(), req)
+	if err != nil {
+		logrus.Errorf("MFA login failed: %v", err)
+
+		switch err.Error() {
+		case "invalid or expired mfa token":
+			utils.UnauthorizedResponse(c, "Login expired, please log in again")
+		case "invalid 2fa code":
+			utils.UnauthorizedResponse(c, "Invalid two-factor authentication code")
+		case "too many 2fa attempts":
+			tooManyMFAAttemptsResponse(c)
+		case "account is deactivated":
+			utils.UnauthorizedResponse(c, "Account is deactivated")
 		case "validation failed":
 			utils.BadRequestResponse(c, "Invalid input data")
 		default:
@@ -149,7 +206,7 @@ func (ac *AuthController) ForgotPassword(c *gin.Context) {
 func (ac *AuthController) ResetPassword(c *gin.Context) {
 	var req struct {
 		Token    string `json:"token" binding:"required"`
-		Password string `json:"password" binding:"required,min=6"`
+		Password string `json:"password" binding:"required"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -165,7 +222,7 @@ func (ac *AuthController) ResetPassword(c *gin.Context) {
 		case "invalid or expired token":
 			utils.UnauthorizedResponse(c, "Invalid or expired reset token")
 		case "validation failed":
-			utils.BadRequestResponse(c, "Password must be at least 6 characters")
+			utils.ValidationFailedResponse(c, "Password not allowed", err)
 		default:
 			utils.InternalServerErrorResponse(c, "Failed to reset password")
 		}
@@ -531,6 +588,32 @@ func (ac *AuthController) LogoutAllDevices(c *gin.Context) {
 	utils.SuccessResponse(c, "Logged out from all devices successfully", nil)
 }
 
+// IssueWSTicket issues a short-lived, single-use ticket for opening a WebSocket
+// @Summary Issue WebSocket ticket
+// @Description Issue a ticket to pass to the WebSocket endpoint instead of the access token
+// @Tags Authentication
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} models.APIResponse{data=models.WSTicketResponse}
+// @Failure 401 {object} models.APIResponse
+// @Router /auth/ws-ticket [post]
+func (ac *AuthController) IssueWSTicket(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	ticket, err := ac.authService.IssueWSTicket(c.Request.Context(), userID)
+	if err != nil {
+		logrus.Errorf("Issue WebSocket ticket failed: %v", err)
+		utils.InternalServerErrorResponse(c, "Failed to issue WebSocket ticket")
+		return
+	}
+
+	utils.SuccessResponse(c, "WebSocket ticket issued successfully", ticket)
+}
+
 // GetActiveSessions gets user's active sessions
 // @Summary Get active sessions
 // @Description Get list of user's active sessions
@@ -620,7 +703,7 @@ func (ac *AuthController) ChangePassword(c *gin.Context) {
 
 	var req struct {
 		CurrentPassword string `json:"currentPassword" binding:"required"`
-		NewPassword     string `json:"newPassword" binding:"required,min=6"`
+		NewPassword     string `json:"newPassword" binding:"required"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -636,7 +719,7 @@ func (ac *AuthController) ChangePassword(c *gin.Context) {
 		case "invalid current password":
 			utils.UnauthorizedResponse(c, "Current password is incorrect")
 		case "validation failed":
-			utils.BadRequestResponse(c, "New password must be at least 6 characters")
+			utils.ValidationFailedResponse(c, "Password not allowed", err)
 		case "same password":
 			utils.BadRequestResponse(c, "New password must be different from current password")
 		default:
@@ -748,11 +831,11 @@ func (ac *AuthController) Verify2FA(c *gin.Context) {
 
 	var req struct {
 		Code   string `json:"code" binding:"required"`
-		Secret string `json:"secret" binding:"required"`
+		Secret string `json:"secret"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "2FA code and secret are required")
+		utils.BadRequestResponse(c, "2FA code is required")
 		return
 	}
 
@@ -765,6 +848,10 @@ func (ac *AuthController) Verify2FA(c *gin.Context) {
 			utils.BadRequestResponse(c, "Invalid 2FA code")
 		case "invalid secret":
 			utils.BadRequestResponse(c, "Invalid 2FA secret")
+		case "no pending enrollment":
+			utils.BadRequestResponse(c, "Two-factor setup expired, please start again")
+		case "too many 2fa attempts":
+			tooManyMFAAttemptsResponse(c)
 		case "already enabled":
 			utils.BadRequestResponse(c, "Two-factor authentication is already enabled")
 		default:
@@ -817,6 +904,8 @@ func (ac *AuthController) Disable2FA(c *gin.Context) {
 			utils.UnauthorizedResponse(c, "Invalid password")
 		case "invalid code":
 			utils.BadRequestResponse(c, "Invalid 2FA code")
+		case "too many 2fa attempts":
+			tooManyMFAAttemptsResponse(c)
 		case "not enabled":
 			utils.BadRequestResponse(c, "Two-factor authentication is not enabled")
 		default:
@@ -910,8 +999,10 @@ func (ac *AuthController) RegenerateBackupCodes(c *gin.Context) {
 		switch err.Error() {
 		case "invalid password":
 			utils.UnauthorizedResponse(c, "Invalid password")
-		case "invalid code":
+		case "invalid code", "invalid 2fa code":
 			utils.BadRequestResponse(c, "Invalid 2FA code")
+		case "too many 2fa attempts":
+			tooManyMFAAttemptsResponse(c)
 		case "2fa not enabled":
 			utils.BadRequestResponse(c, "Two-factor authentication is not enabled")
 		default:
@@ -926,6 +1017,77 @@ func (ac *AuthController) RegenerateBackupCodes(c *gin.Context) {
 	})
 }
 
+// EnrollMFA starts TOTP enrollment for the current user
+// @Summary Enroll in MFA
+// @Description Issue a TOTP secret and provisioning URI. MFA is active once a code is verified with /users/me/mfa/verify.
+// @Tags Authentication
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} models.APIResponse{data=models.TwoFactorSetup}
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Router /users/me/mfa/enroll [post]
+func (ac *AuthController) EnrollMFA(c *gin.Context) {
+	ac.Setup2FA(c)
+}
+
+// VerifyMFA activates MFA with a code from the enrolled authenticator
+// @Summary Verify MFA enrollment
+// @Description Activate MFA with a TOTP code and receive single-use backup codes
+// @Tags Authentication
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body models.VerifyMFARequest true "TOTP code"
+// @Success 200 {object} models.APIResponse{data=models.TwoFactorBackupCodes}
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 429 {object} models.APIResponse
+// @Router /users/me/mfa/verify [post]
+func (ac *AuthController) VerifyMFA(c *gin.Context) {
+	ac.Verify2FA(c)
+}
+
+// DisableMFA turns off MFA for the current user
+// @Summary Disable MFA
+// @Description Disable MFA. Requires a recent login or a current code in the X-2FA-Code header.
+// @Tags Authentication
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
+// @Router /users/me/mfa [delete]
+func (ac *AuthController) DisableMFA(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	err := ac.authService.DisableMFA(c.Request.Context(), userID)
+	if err != nil {
+		logrus.Errorf("Disable MFA failed: %v", err)
+
+		switch err.Error() {
+		case "not enabled":
+			utils.BadRequestResponse(c, "Two-factor authentication is not enabled")
+		case "user not found":
+			utils.NotFoundResponse(c, "User")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to disable two-factor authentication")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Two-factor authentication disabled successfully", nil)
+}
+
+func tooManyMFAAttemptsResponse(c *gin.Context) {
+	utils.ErrorResponse(c, http.StatusTooManyRequests, "Too many verification attempts, please try again later", nil)
+}
+
 // ============== ACCOUNT SECURITY ==============
 
 // SecurityOverview gets user's security overview
@@ -971,25 +1133,8 @@ func (ac *AuthController) GetAuditLog(c *gin.Context) {
 		utils.UnauthorizedResponse(c, "User not authenticated")
 		return
 	}
-	page := 1
-	pageSize := 20
-	if p := c.Query("page"); p != "" {
-		if v, err := strconv.Atoi(p); err == nil {
-			page = v
-		}
-	}
-	if ps := c.Query("pageSize"); ps != "" {
-		if v, err := strconv.Atoi(ps); err == nil {
-			pageSize = v
-		}
-	}
-
-	if page < 1 {
-		page = 1
-	}
-	if pageSize < 1 || pageSize > 100 {
-		pageSize = 20
-	}
+	pagination := utils.ParsePagination(c)
+	page, pageSize := pagination.Page, pagination.PageSize
 
 	auditLog, total, err := ac.authService.GetAuditLog(c.Request.Context(), userID, page, pageSize)
 	if err != nil {
@@ -1041,3 +1186,133 @@ func (ac *AuthController) ReportSuspiciousActivity(c *gin.Context) {
 
 	utils.SuccessResponse(c, "Suspicious activity reported successfully. Our security team will investigate.", nil)
 }
+
+// ============== SIGNING KEYS (ADMIN) ==============
+
+// GetSigningKeys lists the stored token signing keys that still verify tokens
+// @Summary Get signing keys
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} models.APIResponse{data=[]models.SigningKey}
+// @Router /admin/auth/signing-keys [get]
+func (ac *AuthController) GetSigningKeys(c *gin.Context) {
+	keys, err := ac.authService.GetSigningKeys(c.Request.Context())
+	if err != nil {
+		logrus.Errorf("Get signing keys failed: %v", err)
+		utils.InternalServerErrorResponse(c, "Failed to get signing keys")
+		return
+	}
+
+	utils.SuccessResponse(c, "Signing keys retrieved successfully", keys)
+}
+
+// RotateSigningKey makes a new key sign tokens. Previous keys keep verifying
+// tokens until they expire, so users stay logged in.
+// @Summary Rotate signing key
+// @Tags Admin
+// @Security BearerAuth
+// @Produce json
+// @Success 201 {object} models.APIResponse{data=models.SigningKey}
+// @Router /admin/auth/signing-keys/rotate [post]
+func (ac *AuthController) RotateSigningKey(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	key, err := ac.authService.RotateSigningKey(c.Request.Context(), userID)
+	if err != nil {
+		logrus.Errorf("Rotate signing key failed: %v", err)
+		utils.InternalServerErrorResponse(c, "Failed to rotate signing key")
+		return
+	}
+
+	utils.CreatedResponse(c, "Signing key rotated successfully", key)
+}
+
+// UnlockUserAccount ends a user's login lockout
+// @Summary Unlock account
+// @Tags Admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param request body models.UnlockAccountRequest false "Why it is unlocked"
+// @Success 200 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Router /admin/users/{id}/unlock [post]
+func (ac *AuthController) UnlockUserAccount(c *gin.Context) {
+	adminID := c.GetString("userID")
+	if adminID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.UnlockAccountRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.BindErrorResponse(c, "Invalid request body", err)
+			return
+		}
+	}
+
+	if err := ac.authService.AdminUnlockAccount(c.Request.Context(), adminID, c.Param("id"), req); err != nil {
+		adminAccountSecurityErrorResponse(c, err, "Failed to unlock account")
+		return
+	}
+
+	utils.SuccessResponse(c, "Account unlocked", nil)
+}
+
+// SetPasswordRotation makes a user change their password before they can do
+// anything else, e.g. when their account was compromised, or lets them off
+// @Summary Require password change
+// @Tags Admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param request body models.PasswordRotationRequest true "Whether a change is required"
+// @Success 200 {object} models.APIResponse
+// @Failure 404 {object} models.APIResponse
+// @Router /admin/users/{id}/password-rotation [put]
+func (ac *AuthController) SetPasswordRotation(c *gin.Context) {
+	adminID := c.GetString("userID")
+	if adminID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.PasswordRotationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BindErrorResponse(c, "Invalid request body", err)
+		return
+	}
+
+	if err := ac.authService.SetPasswordRotation(c.Request.Context(), adminID, c.Param("id"), req); err != nil {
+		adminAccountSecurityErrorResponse(c, err, "Failed to update password rotation")
+		return
+	}
+
+	if req.Required {
+		utils.SuccessResponse(c, "The user must change their password", nil)
+		return
+	}
+	utils.SuccessResponse(c, "Password change no longer required", nil)
+}
+
+func adminAccountSecurityErrorResponse(c *gin.Context, err error, failure string) {
+	switch err.Error() {
+	case "validation failed":
+		utils.ValidationFailedResponse(c, "Invalid request", err)
+	case "invalid user ID":
+		utils.BadRequestResponse(c, "Invalid user ID")
+	case "user not found":
+		utils.NotFoundResponse(c, "User")
+	default:
+		logrus.Errorf("%s: %v", failure, err)
+		utils.InternalServerErrorResponse(c, failure)
+	}
+}
@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"ftrack/models"
+	"ftrack/services"
+	"ftrack/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+type CalendarFeedController struct {
+	calendarFeedService *services.CalendarFeedService
+}
+
+func NewCalendarFeedController(calendarFeedService *services.CalendarFeedService) *CalendarFeedController {
+	return &CalendarFeedController{
+		calendarFeedService: calendarFeedService,
+	}
+}
+
+// GetCalendarFeed serves a feed to calendar apps. They fetch it without a
+// session, so the token in the link is the only authorization.
+func (cfc *CalendarFeedController) GetCalendarFeed(c *gin.Context) {
+	token := strings.TrimSuffix(c.Param("feed"), ".ics")
+	if token == "" || token == c.Param("feed") {
+		utils.NotFoundResponse(c, "Calendar feed")
+		return
+	}
+
+	calendar, err := cfc.calendarFeedService.RenderFeed(c.Request.Context(), token)
+	if err != nil {
+		if err.Error() != "calendar feed not found" {
+			logrus.Errorf("Render calendar feed failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to render calendar feed")
+			return
+		}
+		utils.NotFoundResponse(c, "Calendar feed")
+		return
+	}
+
+	etag := fmt.Sprintf(`"%x"`, sha256.Sum256(calendar))
+	c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", int(models.CalendarFeedCacheMaxAge.Seconds())))
+	c.Header("ETag", etag)
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.Data(http.StatusOK, "text/calendar; charset=utf-8", calendar)
+}
+
+// CreateCalendarFeed creates a feed of the selected circles and returns its
+// link, which isn't shown again
+func (cfc *CalendarFeedController) CreateCalendarFeed(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.CreateCalendarFeedRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request body")
+		return
+	}
+
+	feed, err := cfc.calendarFeedService.CreateFeed(c.Request.Context(), userID, req)
+	if err != nil {
+		switch err.Error() {
+		case "validation failed":
+			utils.BadRequestResponse(c, "Invalid calendar feed: "+utils.ValidationFailureReason(err))
+		case "invalid circle ID":
+			utils.BadRequestResponse(c, "Invalid circle ID")
+		case "circle not found":
+			utils.NotFoundResponse(c, "Circle")
+		case "access denied":
+			utils.ForbiddenResponse(c, "You can only add circles you are a member of")
+		case "calendar feed limit reached":
+			utils.ErrorResponse(c, http.StatusConflict, fmt.Sprintf("You can have at most %d calendar feeds", models.MaxCalendarFeeds), nil)
+		default:
+			logrus.Errorf("Create calendar feed failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to create calendar feed")
+		}
+		return
+	}
+
+	utils.CreatedResponse(c, "Calendar feed created", feed)
+}
+
+// GetCalendarFeeds lists the user's feeds
+func (cfc *CalendarFeedController) GetCalendarFeeds(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	feeds, err := cfc.calendarFeedService.GetFeeds(c.Request.Context(), userID)
+	if err != nil {
+		logrus.Errorf("Get calendar feeds failed: %v", err)
+		utils.InternalServerErrorResponse(c, "Failed to get calendar feeds")
+		return
+	}
+
+	utils.SuccessResponse(c, "Calendar feeds retrieved", feeds)
+}
+
+// RevokeCalendarFeed deletes a feed; calendar apps stop getting updates
+func (cfc *CalendarFeedController) RevokeCalendarFeed(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	err := cfc.calendarFeedService.RevokeFeed(c.Request.Context(), userID, c.Param("feedId"))
+	if err != nil {
+		switch err.Error() {
+		case "invalid feed ID":
+			utils.BadRequestResponse(c, "Invalid feed ID")
+		case "calendar feed not found":
+			utils.NotFoundResponse(c, "Calendar feed")
+		default:
+			logrus.Errorf("Revoke calendar feed failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to revoke calendar feed")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Calendar feed revoked", nil)
+}
@@ -4,6 +4,7 @@ import (
 	"ftrack/models"
 	"ftrack/services"
 	"ftrack/utils"
+	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
@@ -34,7 +35,7 @@ func (cc *CircleController) CreateCircle(c *gin.Context) {
 
 	var req models.CreateCircleRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request body")
+		utils.BindErrorResponse(c, "Invalid request body", err)
 		return
 	}
 
@@ -43,7 +44,7 @@ func (cc *CircleController) CreateCircle(c *gin.Context) {
 		logrus.Errorf("Create circle failed: %v", err)
 		switch err.Error() {
 		case "validation failed":
-			utils.BadRequestResponse(c, "Invalid circle data")
+			utils.ValidationFailedResponse(c, "Invalid circle data", err)
 		case "circle limit reached":
 			utils.BadRequestResponse(c, "Circle limit reached")
 		default:
@@ -73,6 +74,35 @@ func (cc *CircleController) GetUserCircles(c *gin.Context) {
 	utils.SuccessResponse(c, "Circles retrieved successfully", circles)
 }
 
+// GetCirclesOverview sums up each of the user's circles for the circles list
+func (cc *CircleController) GetCirclesOverview(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.CirclesOverviewRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid query parameters")
+		return
+	}
+
+	overview, err := cc.circleService.GetCirclesOverview(c.Request.Context(), userID, req)
+	if err != nil {
+		logrus.Errorf("Get circles overview failed: %v", err)
+		switch err.Error() {
+		case "validation failed":
+			utils.BadRequestResponse(c, utils.ValidationFailureReason(err))
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to get circles overview")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Circles overview retrieved successfully", overview)
+}
+
 // GetCircle gets a specific circle
 func (cc *CircleController) GetCircle(c *gin.Context) {
 	userID := c.GetString("userID")
@@ -120,7 +150,7 @@ func (cc *CircleController) UpdateCircle(c *gin.Context) {
 
 	var req models.UpdateCircleRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request body")
+		utils.BindErrorResponse(c, "Invalid request body", err)
 		return
 	}
 
@@ -133,7 +163,11 @@ func (cc *CircleController) UpdateCircle(c *gin.Context) {
 		case "access denied":
 			utils.ForbiddenResponse(c, "Only circle admins can update circle settings")
 		case "validation failed":
-			utils.BadRequestResponse(c, "Invalid circle data")
+			utils.ValidationFailedResponse(c, "Invalid circle data", err)
+		case "circle is archived":
+			utils.ConflictResponse(c, "This circle was merged into another circle and is read-only")
+		case "message encryption not configured":
+			utils.BadRequestResponse(c, "Message encryption is not available on this server")
 		default:
 			utils.InternalServerErrorResponse(c, "Failed to update circle")
 		}
@@ -164,7 +198,7 @@ func (cc *CircleController) DeleteCircle(c *gin.Context) {
 		case "circle not found":
 			utils.NotFoundResponse(c, "Circle")
 		case "access denied":
-			utils.ForbiddenResponse(c, "Only circle admins can delete the circle")
+			utils.ForbiddenResponse(c, "Only the circle owner can delete the circle")
 		default:
 			utils.InternalServerErrorResponse(c, "Failed to delete circle")
 		}
@@ -241,6 +275,8 @@ func (cc *CircleController) CreateInvitation(c *gin.Context) {
 			utils.BadRequestResponse(c, "User already has a pending invitation")
 		case "user already member":
 			utils.BadRequestResponse(c, "User is already a member of this circle")
+		case "circle is archived":
+			utils.ConflictResponse(c, "This circle was merged into another circle and is read-only")
 		default:
 			utils.InternalServerErrorResponse(c, "Failed to create invitation")
 		}
@@ -410,6 +446,8 @@ func (cc *CircleController) JoinByInviteCode(c *gin.Context) {
 			utils.BadRequestResponse(c, "You are already a member of this circle")
 		case "circle full":
 			utils.BadRequestResponse(c, "Circle has reached maximum capacity")
+		case "circle is archived":
+			utils.ConflictResponse(c, "This circle was merged into another circle and is read-only")
 		default:
 			utils.InternalServerErrorResponse(c, "Failed to join circle")
 		}
@@ -419,6 +457,43 @@ func (cc *CircleController) JoinByInviteCode(c *gin.Context) {
 	utils.SuccessResponse(c, "Successfully joined circle", circle)
 }
 
+// JoinByInviteLink joins a circle using the token of an invite link
+func (cc *CircleController) JoinByInviteLink(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.JoinByInviteLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request body")
+		return
+	}
+
+	circle, err := cc.circleService.AcceptInviteLink(c.Request.Context(), userID, req.Token)
+	if err != nil {
+		logrus.Errorf("Join by invite link failed: %v", err)
+		switch err.Error() {
+		case "invalid invite link":
+			utils.BadRequestResponse(c, "Invalid or expired invite link")
+		case "invitation not found":
+			utils.NotFoundResponse(c, "Invitation")
+		case "access denied":
+			utils.ForbiddenResponse(c, "This invitation is not for you")
+		case "invitation expired":
+			utils.BadRequestResponse(c, "Invitation has expired")
+		case "invitation not pending":
+			utils.BadRequestResponse(c, "Invitation is no longer pending")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to accept invitation")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Successfully joined circle", circle)
+}
+
 // JoinByInvitation joins a circle using invitation ID
 func (cc *CircleController) JoinByInvitation(c *gin.Context) {
 	userID := c.GetString("userID")
@@ -639,6 +714,83 @@ func (cc *CircleController) RemoveCircleMember(c *gin.Context) {
 	utils.SuccessResponse(c, "Member removed successfully", nil)
 }
 
+// BulkAddMembers adds or invites many users to a circle at once
+func (cc *CircleController) BulkAddMembers(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	circleID := c.Param("circleId")
+	if circleID == "" {
+		utils.BadRequestResponse(c, "Circle ID is required")
+		return
+	}
+
+	var req models.BulkAddMembersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request body")
+		return
+	}
+
+	response, err := cc.circleService.BulkAddMembers(c.Request.Context(), userID, circleID, req)
+	if err != nil {
+		logrus.Errorf("Bulk add members failed: %v", err)
+		cc.handleBulkMembersError(c, err, "Failed to add members")
+		return
+	}
+
+	utils.SuccessResponse(c, "Members processed", response)
+}
+
+// BulkRemoveMembers removes many members from a circle at once
+func (cc *CircleController) BulkRemoveMembers(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	circleID := c.Param("circleId")
+	if circleID == "" {
+		utils.BadRequestResponse(c, "Circle ID is required")
+		return
+	}
+
+	var req models.BulkRemoveMembersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request body")
+		return
+	}
+
+	response, err := cc.circleService.BulkRemoveMembers(c.Request.Context(), userID, circleID, req)
+	if err != nil {
+		logrus.Errorf("Bulk remove members failed: %v", err)
+		cc.handleBulkMembersError(c, err, "Failed to remove members")
+		return
+	}
+
+	utils.SuccessResponse(c, "Members processed", response)
+}
+
+func (cc *CircleController) handleBulkMembersError(c *gin.Context, err error, fallback string) {
+	switch err.Error() {
+	case "validation failed":
+		utils.BadRequestResponse(c, "Invalid request: "+utils.ValidationFailureReason(err))
+	case "invalid circle ID":
+		utils.BadRequestResponse(c, "Invalid circle ID")
+	case "circle not found":
+		utils.NotFoundResponse(c, "Circle")
+	case "access denied", "member not found":
+		utils.ForbiddenResponse(c, "Only circle admins can manage members")
+	case "circle is archived":
+		utils.ConflictResponse(c, "This circle was merged into another circle and is read-only")
+	default:
+		utils.InternalServerErrorResponse(c, fallback)
+	}
+}
+
 // PromoteMember promotes a member to admin
 func (cc *CircleController) PromoteMember(c *gin.Context) {
 	userID := c.GetString("userID")
@@ -704,6 +856,8 @@ func (cc *CircleController) DemoteMember(c *gin.Context) {
 			utils.BadRequestResponse(c, "Member is not an admin")
 		case "cannot demote self":
 			utils.BadRequestResponse(c, "Cannot demote yourself")
+		case "cannot demote owner":
+			utils.BadRequestResponse(c, "Cannot demote the circle owner. Transfer ownership first")
 		default:
 			utils.InternalServerErrorResponse(c, "Failed to demote member")
 		}
@@ -713,6 +867,138 @@ func (cc *CircleController) DemoteMember(c *gin.Context) {
 	utils.SuccessResponse(c, "Member demoted successfully", nil)
 }
 
+// MergeCircle starts moving another circle into this one. It answers with
+// the merge job to poll for progress and the report.
+func (cc *CircleController) MergeCircle(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	targetCircleID := c.Param("circleId")
+	if targetCircleID == "" {
+		utils.BadRequestResponse(c, "Circle ID is required")
+		return
+	}
+
+	var req models.MergeCircleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data")
+		return
+	}
+
+	job, err := cc.circleService.StartMerge(c.Request.Context(), userID, targetCircleID, req)
+	if err != nil {
+		logrus.Errorf("Merge circle failed: %v", err)
+		switch err.Error() {
+		case "validation failed":
+			if reason := utils.ValidationFailureReason(err); reason != "" {
+				utils.BadRequestResponse(c, "Invalid merge request: "+reason)
+				return
+			}
+			utils.BadRequestResponse(c, "Source circle ID is required")
+		case "confirmation required":
+			utils.BadRequestResponse(c, "Circle merge must be confirmed")
+		case "invalid circle ID":
+			utils.BadRequestResponse(c, "Invalid circle ID")
+		case "circle not found":
+			utils.NotFoundResponse(c, "Circle")
+		case "access denied":
+			utils.ForbiddenResponse(c, "Only admins of both circles can merge them")
+		case "circle is archived":
+			utils.ConflictResponse(c, "One of the circles was already merged into another circle")
+		case "circle merge already running":
+			utils.ConflictResponse(c, "One of the circles is already being merged")
+		case "circle merge not available":
+			utils.ServiceUnavailableResponse(c, "Circle merge")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to start circle merge")
+		}
+		return
+	}
+
+	utils.AcceptedResponse(c, "Circle merge started", job)
+}
+
+// GetMergeJob reports a circle merge's progress and result
+func (cc *CircleController) GetMergeJob(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	job, err := cc.circleService.GetMergeJob(c.Request.Context(), userID, c.Param("circleId"), c.Param("jobId"))
+	if err != nil {
+		switch err.Error() {
+		case "invalid job ID":
+			utils.BadRequestResponse(c, "Invalid merge job ID")
+		case "merge job not found":
+			utils.NotFoundResponse(c, "Merge job")
+		case "access denied":
+			utils.ForbiddenResponse(c, "Only circle admins can view merges")
+		default:
+			logrus.Errorf("Get merge job failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to get merge job")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Merge job retrieved successfully", job)
+}
+
+// TransferOwnership transfers circle ownership to another admin member
+func (cc *CircleController) TransferOwnership(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	circleID := c.Param("circleId")
+	if circleID == "" {
+		utils.BadRequestResponse(c, "Circle ID is required")
+		return
+	}
+
+	var req models.TransferOwnershipRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data")
+		return
+	}
+	req.IPAddress = c.ClientIP()
+	req.UserAgent = c.Request.UserAgent()
+
+	circle, err := cc.circleService.TransferOwnership(c.Request.Context(), userID, circleID, req)
+	if err != nil {
+		logrus.Errorf("Transfer ownership failed: %v", err)
+		switch err.Error() {
+		case "validation failed":
+			utils.BadRequestResponse(c, "New owner ID is required")
+		case "confirmation required":
+			utils.BadRequestResponse(c, "Ownership transfer must be confirmed")
+		case "circle not found":
+			utils.NotFoundResponse(c, "Circle")
+		case "member not found":
+			utils.NotFoundResponse(c, "Member")
+		case "access denied":
+			utils.ForbiddenResponse(c, "Only the circle owner can transfer ownership")
+		case "already owner":
+			utils.BadRequestResponse(c, "You already own this circle")
+		case "not admin":
+			utils.BadRequestResponse(c, "Ownership can only be transferred to an admin")
+		case "ownership transfer conflict":
+			utils.ConflictResponse(c, "Circle roles changed during the transfer. Please try again")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to transfer ownership")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Circle ownership transferred successfully", circle)
+}
+
 // UpdateMemberPermissions updates member permissions
 func (cc *CircleController) UpdateMemberPermissions(c *gin.Context) {
 	userID := c.GetString("userID")
@@ -744,6 +1030,10 @@ func (cc *CircleController) UpdateMemberPermissions(c *gin.Context) {
 			utils.NotFoundResponse(c, "Member")
 		case "access denied":
 			utils.ForbiddenResponse(c, "You don't have permission to update member permissions")
+		case "cannot change own permissions":
+			utils.BadRequestResponse(c, "Cannot change your own permissions")
+		case "cannot change admin permissions":
+			utils.BadRequestResponse(c, "Cannot change circle admin permissions")
 		default:
 			utils.InternalServerErrorResponse(c, "Failed to update member permissions")
 		}
@@ -989,6 +1279,10 @@ func (cc *CircleController) UpdateCircleSettings(c *gin.Context) {
 			utils.NotFoundResponse(c, "Circle")
 		case "access denied":
 			utils.ForbiddenResponse(c, "Only admins can update circle settings")
+		case "message encryption not configured":
+			utils.BadRequestResponse(c, "Message encryption is not available on this server")
+		case "validation failed":
+			utils.BadRequestResponse(c, utils.ValidationFailureReason(err))
 		default:
 			utils.InternalServerErrorResponse(c, "Failed to update circle settings")
 		}
@@ -1160,12 +1454,8 @@ func (cc *CircleController) UpdateNotificationSettings(c *gin.Context) {
 	utils.SuccessResponse(c, "Notification settings updated successfully", settings)
 }
 
-// ========================
-// Activity and Monitoring
-// ========================
-
-// GetCircleActivity gets circle activity
-func (cc *CircleController) GetCircleActivity(c *gin.Context) {
+// GetNearbyAlertSettings gets the user's nearby alert opt-in for the circle
+func (cc *CircleController) GetNearbyAlertSettings(c *gin.Context) {
 	userID := c.GetString("userID")
 	if userID == "" {
 		utils.UnauthorizedResponse(c, "User not authenticated")
@@ -1178,30 +1468,27 @@ func (cc *CircleController) GetCircleActivity(c *gin.Context) {
 		return
 	}
 
-	// Parse query parameters for pagination and filtering
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
-	activityType := c.Query("type")
-
-	activity, err := cc.circleService.GetCircleActivity(c.Request.Context(), userID, circleID, page, pageSize, activityType)
+	settings, err := cc.circleService.GetNearbyAlertSettings(c.Request.Context(), userID, circleID)
 	if err != nil {
-		logrus.Errorf("Get circle activity failed: %v", err)
+		logrus.Errorf("Get nearby alert settings failed: %v", err)
 		switch err.Error() {
+		case "invalid circle ID":
+			utils.BadRequestResponse(c, "Invalid circle ID")
 		case "circle not found":
 			utils.NotFoundResponse(c, "Circle")
 		case "access denied":
-			utils.ForbiddenResponse(c, "You don't have access to this circle's activity")
+			utils.ForbiddenResponse(c, "You are not a member of this circle")
 		default:
-			utils.InternalServerErrorResponse(c, "Failed to get circle activity")
+			utils.InternalServerErrorResponse(c, "Failed to get nearby alert settings")
 		}
 		return
 	}
 
-	utils.SuccessResponse(c, "Circle activity retrieved successfully", activity)
+	utils.SuccessResponse(c, "Nearby alert settings retrieved successfully", settings)
 }
 
-// GetActivityFeed gets activity feed
-func (cc *CircleController) GetActivityFeed(c *gin.Context) {
+// UpdateNearbyAlertSettings opts the user in or out of nearby alerts
+func (cc *CircleController) UpdateNearbyAlertSettings(c *gin.Context) {
 	userID := c.GetString("userID")
 	if userID == "" {
 		utils.UnauthorizedResponse(c, "User not authenticated")
@@ -1214,21 +1501,39 @@ func (cc *CircleController) GetActivityFeed(c *gin.Context) {
 		return
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+	var req models.UpdateNearbyAlertsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request body")
+		return
+	}
 
-	feed, err := cc.circleService.GetActivityFeed(c.Request.Context(), userID, circleID, page, pageSize)
+	settings, err := cc.circleService.UpdateNearbyAlertSettings(c.Request.Context(), userID, circleID, req)
 	if err != nil {
-		logrus.Errorf("Get activity feed failed: %v", err)
-		utils.InternalServerErrorResponse(c, "Failed to get activity feed")
+		logrus.Errorf("Update nearby alert settings failed: %v", err)
+		switch err.Error() {
+		case "invalid circle ID":
+			utils.BadRequestResponse(c, "Invalid circle ID")
+		case "validation failed":
+			utils.BadRequestResponse(c, utils.ValidationFailureReason(err))
+		case "circle not found", "circle or member not found":
+			utils.NotFoundResponse(c, "Circle")
+		case "access denied":
+			utils.ForbiddenResponse(c, "You are not a member of this circle")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to update nearby alert settings")
+		}
 		return
 	}
 
-	utils.SuccessResponse(c, "Activity feed retrieved successfully", feed)
+	utils.SuccessResponse(c, "Nearby alert settings updated successfully", settings)
 }
 
-// GetMemberLocations gets current member locations
-func (cc *CircleController) GetMemberLocations(c *gin.Context) {
+// ========================
+// Activity and Monitoring
+// ========================
+
+// GetCircleActivity gets circle activity
+func (cc *CircleController) GetCircleActivity(c *gin.Context) {
 	userID := c.GetString("userID")
 	if userID == "" {
 		utils.UnauthorizedResponse(c, "User not authenticated")
@@ -1241,25 +1546,30 @@ func (cc *CircleController) GetMemberLocations(c *gin.Context) {
 		return
 	}
 
-	locations, err := cc.circleService.GetMemberLocations(c.Request.Context(), userID, circleID)
+	// Parse query parameters for pagination and filtering
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+	activityType := c.Query("type")
+
+	activity, err := cc.circleService.GetCircleActivity(c.Request.Context(), userID, circleID, page, pageSize, activityType)
 	if err != nil {
-		logrus.Errorf("Get member locations failed: %v", err)
+		logrus.Errorf("Get circle activity failed: %v", err)
 		switch err.Error() {
 		case "circle not found":
 			utils.NotFoundResponse(c, "Circle")
 		case "access denied":
-			utils.ForbiddenResponse(c, "You don't have permission to view member locations")
+			utils.ForbiddenResponse(c, "You don't have access to this circle's activity")
 		default:
-			utils.InternalServerErrorResponse(c, "Failed to get member locations")
+			utils.InternalServerErrorResponse(c, "Failed to get circle activity")
 		}
 		return
 	}
 
-	utils.SuccessResponse(c, "Member locations retrieved successfully", locations)
+	utils.SuccessResponse(c, "Circle activity retrieved successfully", activity)
 }
 
-// GetActivityTimeline gets activity timeline
-func (cc *CircleController) GetActivityTimeline(c *gin.Context) {
+// GetActivityFeed gets activity feed
+func (cc *CircleController) GetActivityFeed(c *gin.Context) {
 	userID := c.GetString("userID")
 	if userID == "" {
 		utils.UnauthorizedResponse(c, "User not authenticated")
@@ -1272,21 +1582,29 @@ func (cc *CircleController) GetActivityTimeline(c *gin.Context) {
 		return
 	}
 
-	startDate := c.Query("startDate")
-	endDate := c.Query("endDate")
+	pagination := utils.ParsePagination(c)
 
-	timeline, err := cc.circleService.GetActivityTimeline(c.Request.Context(), userID, circleID, startDate, endDate)
+	feed, err := cc.circleService.GetActivityFeed(c.Request.Context(), userID, circleID, pagination.Page, pagination.PageSize)
 	if err != nil {
-		logrus.Errorf("Get activity timeline failed: %v", err)
-		utils.InternalServerErrorResponse(c, "Failed to get activity timeline")
+		switch err.Error() {
+		case "invalid circle ID":
+			utils.BadRequestResponse(c, "Invalid circle ID")
+		case "circle not found":
+			utils.NotFoundResponse(c, "Circle")
+		case "access denied":
+			utils.ForbiddenResponse(c, "Access denied")
+		default:
+			logrus.Errorf("Get activity feed failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to get activity feed")
+		}
 		return
 	}
 
-	utils.SuccessResponse(c, "Activity timeline retrieved successfully", timeline)
+	utils.SuccessResponse(c, "Activity feed retrieved successfully", feed)
 }
 
-// GetCircleEvents gets circle events
-func (cc *CircleController) GetCircleEvents(c *gin.Context) {
+// ShareMoment posts a geotagged photo to the circle's activity feed
+func (cc *CircleController) ShareMoment(c *gin.Context) {
 	userID := c.GetString("userID")
 	if userID == "" {
 		utils.UnauthorizedResponse(c, "User not authenticated")
@@ -1299,11 +1617,154 @@ func (cc *CircleController) GetCircleEvents(c *gin.Context) {
 		return
 	}
 
-	eventType := c.Query("type")
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
-
-	events, err := cc.circleService.GetCircleEvents(c.Request.Context(), userID, circleID, eventType, page, pageSize)
+	var req models.CreateMomentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request body")
+		return
+	}
+
+	moment, err := cc.circleService.ShareMoment(c.Request.Context(), userID, circleID, req)
+	if err != nil {
+		logrus.Errorf("Share moment failed: %v", err)
+		switch err.Error() {
+		case "validation failed":
+			utils.BadRequestResponse(c, "Invalid moment: "+utils.ValidationFailureReason(err))
+		case "invalid circle ID":
+			utils.BadRequestResponse(c, "Invalid circle ID")
+		case "circle not found":
+			utils.NotFoundResponse(c, "Circle")
+		case "access denied":
+			utils.ForbiddenResponse(c, "Access denied")
+		case "location sharing disabled":
+			utils.ForbiddenResponse(c, "This circle has location sharing turned off")
+		case "location sharing paused":
+			utils.ForbiddenResponse(c, "Share your location with this circle to share moments")
+		case "circle is archived":
+			utils.ConflictResponse(c, "This circle was merged into another circle and is read-only")
+		case "moments not available":
+			utils.ErrorResponse(c, http.StatusNotImplemented, "Moments are not available", nil)
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to share moment")
+		}
+		return
+	}
+
+	utils.CreatedResponse(c, "Moment shared successfully", moment)
+}
+
+// DeleteMoment takes a moment down
+func (cc *CircleController) DeleteMoment(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	circleID := c.Param("circleId")
+	momentID := c.Param("momentId")
+	if circleID == "" || momentID == "" {
+		utils.BadRequestResponse(c, "Circle ID and Moment ID are required")
+		return
+	}
+
+	err := cc.circleService.DeleteMoment(c.Request.Context(), userID, circleID, momentID)
+	if err != nil {
+		logrus.Errorf("Delete moment failed: %v", err)
+		switch err.Error() {
+		case "invalid circle ID":
+			utils.BadRequestResponse(c, "Invalid circle ID")
+		case "circle not found":
+			utils.NotFoundResponse(c, "Circle")
+		case "invalid moment ID", "moment not found":
+			utils.NotFoundResponse(c, "Moment")
+		case "access denied":
+			utils.ForbiddenResponse(c, "Only the author or a circle admin can delete this moment")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to delete moment")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Moment deleted successfully", nil)
+}
+
+// GetMemberLocations gets current member locations
+func (cc *CircleController) GetMemberLocations(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	circleID := c.Param("circleId")
+	if circleID == "" {
+		utils.BadRequestResponse(c, "Circle ID is required")
+		return
+	}
+
+	locations, err := cc.circleService.GetMemberLocations(c.Request.Context(), userID, circleID)
+	if err != nil {
+		logrus.Errorf("Get member locations failed: %v", err)
+		switch err.Error() {
+		case "circle not found":
+			utils.NotFoundResponse(c, "Circle")
+		case "access denied":
+			utils.ForbiddenResponse(c, "You don't have permission to view member locations")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to get member locations")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Member locations retrieved successfully", locations)
+}
+
+// GetActivityTimeline gets activity timeline
+func (cc *CircleController) GetActivityTimeline(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	circleID := c.Param("circleId")
+	if circleID == "" {
+		utils.BadRequestResponse(c, "Circle ID is required")
+		return
+	}
+
+	startDate := c.Query("startDate")
+	endDate := c.Query("endDate")
+
+	timeline, err := cc.circleService.GetActivityTimeline(c.Request.Context(), userID, circleID, startDate, endDate)
+	if err != nil {
+		logrus.Errorf("Get activity timeline failed: %v", err)
+		utils.InternalServerErrorResponse(c, "Failed to get activity timeline")
+		return
+	}
+
+	utils.SuccessResponse(c, "Activity timeline retrieved successfully", timeline)
+}
+
+// GetCircleEvents gets circle events
+func (cc *CircleController) GetCircleEvents(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	circleID := c.Param("circleId")
+	if circleID == "" {
+		utils.BadRequestResponse(c, "Circle ID is required")
+		return
+	}
+
+	eventType := c.Query("type")
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+
+	events, err := cc.circleService.GetCircleEvents(c.Request.Context(), userID, circleID, eventType, page, pageSize)
 	if err != nil {
 		logrus.Errorf("Get circle events failed: %v", err)
 		utils.InternalServerErrorResponse(c, "Failed to get circle events")
@@ -1730,7 +2191,7 @@ func (cc *CircleController) CreateAnnouncement(c *gin.Context) {
 		return
 	}
 
-	var req map[string]interface{}
+	var req models.CreateAnnouncementRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		utils.BadRequestResponse(c, "Invalid request body")
 		return
@@ -1740,10 +2201,16 @@ func (cc *CircleController) CreateAnnouncement(c *gin.Context) {
 	if err != nil {
 		logrus.Errorf("Create announcement failed: %v", err)
 		switch err.Error() {
+		case "validation failed":
+			utils.BadRequestResponse(c, "Invalid announcement: "+utils.ValidationFailureReason(err))
 		case "circle not found":
 			utils.NotFoundResponse(c, "Circle")
-		case "access denied":
+		case "access denied", "member not found":
 			utils.ForbiddenResponse(c, "You don't have permission to create announcements")
+		case "circle is archived":
+			utils.ConflictResponse(c, "This circle was merged into another circle and is read-only")
+		case "announcement limit reached":
+			utils.TooManyRequestsResponse(c, "A circle can send 3 announcements a day")
 		default:
 			utils.InternalServerErrorResponse(c, "Failed to create announcement")
 		}
@@ -1753,6 +2220,45 @@ func (cc *CircleController) CreateAnnouncement(c *gin.Context) {
 	utils.CreatedResponse(c, "Announcement created successfully", announcement)
 }
 
+// GetAnnouncementStats gets how many members an announcement reached and
+// read it
+func (cc *CircleController) GetAnnouncementStats(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	circleID := c.Param("circleId")
+	announcementID := c.Param("announcementId")
+	if circleID == "" || announcementID == "" {
+		utils.BadRequestResponse(c, "Circle ID and Announcement ID are required")
+		return
+	}
+
+	stats, err := cc.circleService.GetAnnouncementStats(c.Request.Context(), userID, circleID, announcementID)
+	if err != nil {
+		logrus.Errorf("Get announcement stats failed: %v", err)
+		switch err.Error() {
+		case "invalid announcement ID":
+			utils.BadRequestResponse(c, "Invalid announcement ID")
+		case "circle not found":
+			utils.NotFoundResponse(c, "Circle")
+		case "announcement not found":
+			utils.NotFoundResponse(c, "Announcement")
+		case "access denied", "member not found":
+			utils.ForbiddenResponse(c, "Only circle admins can see announcement stats")
+		case "announcement stats not available":
+			utils.ServiceUnavailableResponse(c, "Announcement stats")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to get announcement stats")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Announcement stats retrieved successfully", stats)
+}
+
 // UpdateAnnouncement updates an announcement
 func (cc *CircleController) UpdateAnnouncement(c *gin.Context) {
 	userID := c.GetString("userID")
@@ -1863,6 +2369,405 @@ func (cc *CircleController) BroadcastMessage(c *gin.Context) {
 	utils.SuccessResponse(c, "Message broadcasted successfully", nil)
 }
 
+// ========================
+// Albums
+// ========================
+
+// GetAlbums gets the circle's accepted albums
+func (cc *CircleController) GetAlbums(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	circleID := c.Param("circleId")
+	if circleID == "" {
+		utils.BadRequestResponse(c, "Circle ID is required")
+		return
+	}
+
+	albums, err := cc.circleService.GetAlbums(c.Request.Context(), userID, circleID)
+	if err != nil {
+		logrus.Errorf("Get albums failed: %v", err)
+		switch err.Error() {
+		case "invalid circle ID":
+			utils.BadRequestResponse(c, "Invalid circle ID")
+		case "access denied":
+			utils.ForbiddenResponse(c, "You don't have access to this circle's albums")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to get albums")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Albums retrieved successfully", albums)
+}
+
+// GetAlbumSuggestions gets the albums suggested from photos shared during
+// visits to the circle's places
+func (cc *CircleController) GetAlbumSuggestions(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	circleID := c.Param("circleId")
+	if circleID == "" {
+		utils.BadRequestResponse(c, "Circle ID is required")
+		return
+	}
+
+	suggestions, err := cc.circleService.GetAlbumSuggestions(c.Request.Context(), userID, circleID)
+	if err != nil {
+		logrus.Errorf("Get album suggestions failed: %v", err)
+		switch err.Error() {
+		case "invalid circle ID":
+			utils.BadRequestResponse(c, "Invalid circle ID")
+		case "access denied":
+			utils.ForbiddenResponse(c, "You don't have access to this circle's albums")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to get album suggestions")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Album suggestions retrieved successfully", suggestions)
+}
+
+// GetAlbum gets an album with a page of its photos
+func (cc *CircleController) GetAlbum(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	circleID := c.Param("circleId")
+	albumID := c.Param("albumId")
+	if circleID == "" || albumID == "" {
+		utils.BadRequestResponse(c, "Circle ID and Album ID are required")
+		return
+	}
+
+	pagination := utils.ParsePagination(c)
+
+	album, err := cc.circleService.GetAlbum(c.Request.Context(), userID, circleID, albumID, pagination.Page, pagination.PageSize)
+	if err != nil {
+		logrus.Errorf("Get album failed: %v", err)
+		switch err.Error() {
+		case "invalid album ID":
+			utils.BadRequestResponse(c, "Invalid album ID")
+		case "album not found":
+			utils.NotFoundResponse(c, "Album")
+		case "access denied":
+			utils.ForbiddenResponse(c, "You don't have access to this circle's albums")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to get album")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Album retrieved successfully", album)
+}
+
+// AcceptAlbum keeps a suggested album
+func (cc *CircleController) AcceptAlbum(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	circleID := c.Param("circleId")
+	albumID := c.Param("albumId")
+	if circleID == "" || albumID == "" {
+		utils.BadRequestResponse(c, "Circle ID and Album ID are required")
+		return
+	}
+
+	var req models.AcceptAlbumRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.BadRequestResponse(c, "Invalid request body")
+			return
+		}
+	}
+
+	album, err := cc.circleService.AcceptAlbum(c.Request.Context(), userID, circleID, albumID, req)
+	if err != nil {
+		logrus.Errorf("Accept album failed: %v", err)
+		switch err.Error() {
+		case "validation failed":
+			utils.BadRequestResponse(c, "Invalid album: "+utils.ValidationFailureReason(err))
+		case "invalid album ID":
+			utils.BadRequestResponse(c, "Invalid album ID")
+		case "album not found":
+			utils.NotFoundResponse(c, "Album")
+		case "access denied", "member not found":
+			utils.ForbiddenResponse(c, "Only circle admins and members with photos in the album can accept it")
+		case "album already reviewed":
+			utils.ConflictResponse(c, "This album was already accepted or dismissed")
+		case "circle is archived":
+			utils.ConflictResponse(c, "This circle was merged into another circle and is read-only")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to accept album")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Album accepted successfully", album)
+}
+
+// DismissAlbum drops a suggested album
+func (cc *CircleController) DismissAlbum(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	circleID := c.Param("circleId")
+	albumID := c.Param("albumId")
+	if circleID == "" || albumID == "" {
+		utils.BadRequestResponse(c, "Circle ID and Album ID are required")
+		return
+	}
+
+	err := cc.circleService.DismissAlbum(c.Request.Context(), userID, circleID, albumID)
+	if err != nil {
+		logrus.Errorf("Dismiss album failed: %v", err)
+		switch err.Error() {
+		case "invalid album ID":
+			utils.BadRequestResponse(c, "Invalid album ID")
+		case "album not found":
+			utils.NotFoundResponse(c, "Album")
+		case "access denied", "member not found":
+			utils.ForbiddenResponse(c, "Only circle admins and members with photos in the album can dismiss it")
+		case "album already reviewed":
+			utils.ConflictResponse(c, "This album was already accepted or dismissed")
+		case "circle is archived":
+			utils.ConflictResponse(c, "This circle was merged into another circle and is read-only")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to dismiss album")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Album dismissed successfully", nil)
+}
+
+// ========================
+// Custom Roles
+// ========================
+
+// GetCircleRoles lists the circle's custom roles and the permissions they can grant
+func (cc *CircleController) GetCircleRoles(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	circleID := c.Param("circleId")
+	if circleID == "" {
+		utils.BadRequestResponse(c, "Circle ID is required")
+		return
+	}
+
+	roles, err := cc.circleService.GetCircleRoles(c.Request.Context(), userID, circleID)
+	if err != nil {
+		logrus.Errorf("Get circle roles failed: %v", err)
+		switch err.Error() {
+		case "circle not found":
+			utils.NotFoundResponse(c, "Circle")
+		case "access denied":
+			utils.ForbiddenResponse(c, "Access denied to this circle")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to get circle roles")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Circle roles retrieved successfully", roles)
+}
+
+// CreateCircleRole defines a custom role
+func (cc *CircleController) CreateCircleRole(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	circleID := c.Param("circleId")
+	if circleID == "" {
+		utils.BadRequestResponse(c, "Circle ID is required")
+		return
+	}
+
+	var req models.CreateCircleRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request body")
+		return
+	}
+
+	role, err := cc.circleService.CreateCircleRole(c.Request.Context(), userID, circleID, req)
+	if err != nil {
+		logrus.Errorf("Create circle role failed: %v", err)
+		switch err.Error() {
+		case "validation failed":
+			utils.BadRequestResponse(c, "Invalid role: "+utils.ValidationFailureReason(err))
+		case "circle not found":
+			utils.NotFoundResponse(c, "Circle")
+		case "access denied":
+			utils.ForbiddenResponse(c, "Only the circle owner can manage roles")
+		case "too many roles":
+			utils.BadRequestResponse(c, "This circle has the most custom roles it can have")
+		case "role name taken":
+			utils.ConflictResponse(c, "The circle already has a role with this name")
+		case "circle is archived":
+			utils.ConflictResponse(c, "This circle was merged into another circle and is read-only")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to create circle role")
+		}
+		return
+	}
+
+	utils.CreatedResponse(c, "Circle role created successfully", role)
+}
+
+// UpdateCircleRole changes a custom role
+func (cc *CircleController) UpdateCircleRole(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	circleID := c.Param("circleId")
+	roleID := c.Param("roleId")
+	if circleID == "" || roleID == "" {
+		utils.BadRequestResponse(c, "Circle ID and Role ID are required")
+		return
+	}
+
+	var req models.UpdateCircleRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request body")
+		return
+	}
+
+	role, err := cc.circleService.UpdateCircleRole(c.Request.Context(), userID, circleID, roleID, req)
+	if err != nil {
+		logrus.Errorf("Update circle role failed: %v", err)
+		switch err.Error() {
+		case "validation failed":
+			utils.BadRequestResponse(c, "Invalid role: "+utils.ValidationFailureReason(err))
+		case "circle not found":
+			utils.NotFoundResponse(c, "Circle")
+		case "invalid role ID", "role not found":
+			utils.NotFoundResponse(c, "Role")
+		case "access denied":
+			utils.ForbiddenResponse(c, "Only the circle owner can manage roles")
+		case "role name taken":
+			utils.ConflictResponse(c, "The circle already has a role with this name")
+		case "circle is archived":
+			utils.ConflictResponse(c, "This circle was merged into another circle and is read-only")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to update circle role")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Circle role updated successfully", role)
+}
+
+// DeleteCircleRole deletes a custom role and takes it away from its members
+func (cc *CircleController) DeleteCircleRole(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	circleID := c.Param("circleId")
+	roleID := c.Param("roleId")
+	if circleID == "" || roleID == "" {
+		utils.BadRequestResponse(c, "Circle ID and Role ID are required")
+		return
+	}
+
+	err := cc.circleService.DeleteCircleRole(c.Request.Context(), userID, circleID, roleID)
+	if err != nil {
+		logrus.Errorf("Delete circle role failed: %v", err)
+		switch err.Error() {
+		case "circle not found":
+			utils.NotFoundResponse(c, "Circle")
+		case "invalid role ID", "role not found":
+			utils.NotFoundResponse(c, "Role")
+		case "access denied":
+			utils.ForbiddenResponse(c, "Only the circle owner can manage roles")
+		case "circle is archived":
+			utils.ConflictResponse(c, "This circle was merged into another circle and is read-only")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to delete circle role")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Circle role deleted successfully", nil)
+}
+
+// AssignCircleRole gives a member a custom role, or takes theirs away
+func (cc *CircleController) AssignCircleRole(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	circleID := c.Param("circleId")
+	memberID := c.Param("userId")
+	if circleID == "" || memberID == "" {
+		utils.BadRequestResponse(c, "Circle ID and User ID are required")
+		return
+	}
+
+	var req models.AssignCircleRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request body")
+		return
+	}
+
+	member, err := cc.circleService.AssignCircleRole(c.Request.Context(), userID, circleID, memberID, req)
+	if err != nil {
+		logrus.Errorf("Assign circle role failed: %v", err)
+		switch err.Error() {
+		case "validation failed":
+			utils.BadRequestResponse(c, "Invalid role: "+utils.ValidationFailureReason(err))
+		case "circle not found":
+			utils.NotFoundResponse(c, "Circle")
+		case "member not found":
+			utils.NotFoundResponse(c, "Member")
+		case "invalid role ID", "role not found":
+			utils.NotFoundResponse(c, "Role")
+		case "access denied":
+			utils.ForbiddenResponse(c, "Only the circle owner can assign roles")
+		case "member is admin":
+			utils.BadRequestResponse(c, "Admins already have every permission")
+		case "circle is archived":
+			utils.ConflictResponse(c, "This circle was merged into another circle and is read-only")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to assign circle role")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Circle role assigned successfully", member)
+}
+
 // ========================
 // Backup and Export
 // ========================
@@ -1895,6 +2800,8 @@ func (cc *CircleController) ExportCircleData(c *gin.Context) {
 			utils.NotFoundResponse(c, "Circle")
 		case "access denied":
 			utils.ForbiddenResponse(c, "You don't have permission to export circle data")
+		case "export permission required":
+			utils.ExportPermissionRequiredResponse(c, circleID)
 		default:
 			utils.InternalServerErrorResponse(c, "Failed to initiate export")
 		}
@@ -2001,8 +2908,8 @@ func (cc *CircleController) LeaveCircle(c *gin.Context) {
 			utils.NotFoundResponse(c, "Circle")
 		case "access denied":
 			utils.ForbiddenResponse(c, "You are not a member of this circle")
-		case "cannot leave as admin":
-			utils.BadRequestResponse(c, "Admins cannot leave the circle. Transfer ownership first")
+		case "cannot leave as owner":
+			utils.BadRequestResponse(c, "Circle owners cannot leave the circle. Transfer ownership first")
 		default:
 			utils.InternalServerErrorResponse(c, "Failed to leave circle")
 		}
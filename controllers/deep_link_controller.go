@@ -0,0 +1,51 @@
+package controllers
+
+import (
+	"ftrack/models"
+	"ftrack/services"
+	"ftrack/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+type DeepLinkController struct {
+	deepLinkService *services.DeepLinkService
+}
+
+func NewDeepLinkController(deepLinkService *services.DeepLinkService) *DeepLinkController {
+	return &DeepLinkController{
+		deepLinkService: deepLinkService,
+	}
+}
+
+// ResolveDeepLink tells the client what a link points at and whether the
+// user can open it. Missing and forbidden targets still succeed, with the
+// reason, so clients can explain it.
+func (dlc *DeepLinkController) ResolveDeepLink(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.ResolveDeepLinkRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid query parameters")
+		return
+	}
+
+	target, err := dlc.deepLinkService.ResolveLink(c.Request.Context(), userID, req)
+	if err != nil {
+		switch err.Error() {
+		case "validation failed":
+			utils.BadRequestResponse(c, "Invalid link: "+utils.ValidationFailureReason(err))
+		default:
+			logrus.Errorf("Resolve deep link failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to resolve link")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Link resolved", target)
+}
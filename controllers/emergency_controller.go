@@ -49,14 +49,19 @@ func (ec *EmergencyController) CreateEmergencyAlert(c *gin.Context) {
 
 	var req models.CreateEmergencyAlertRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request body")
+		utils.BindErrorResponse(c, "Invalid request body", err)
 		return
 	}
 
 	alert, err := ec.emergencyService.CreateEmergencyAlert(c.Request.Context(), userID, req)
 	if err != nil {
 		logrus.Errorf("Create emergency alert failed: %v", err)
-		utils.InternalServerErrorResponse(c, "Failed to create emergency alert")
+		switch err.Error() {
+		case "validation failed":
+			utils.ValidationFailedResponse(c, "Invalid emergency alert", err)
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to create emergency alert")
+		}
 		return
 	}
 
@@ -98,14 +103,19 @@ func (ec *EmergencyController) UpdateEmergencyAlert(c *gin.Context) {
 
 	var req models.UpdateEmergencyAlertRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request body")
+		utils.BindErrorResponse(c, "Invalid request body", err)
 		return
 	}
 
 	alert, err := ec.emergencyService.UpdateEmergencyAlert(c.Request.Context(), userID, alertID, req)
 	if err != nil {
 		logrus.Errorf("Update emergency alert failed: %v", err)
-		utils.InternalServerErrorResponse(c, "Failed to update emergency alert")
+		switch err.Error() {
+		case "validation failed":
+			utils.ValidationFailedResponse(c, "Invalid emergency alert", err)
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to update emergency alert")
+		}
 		return
 	}
 
@@ -454,30 +464,6 @@ func (ec *EmergencyController) GetEmergencyContacts(c *gin.Context) {
 	utils.SuccessResponse(c, "Emergency contacts retrieved successfully", contacts)
 }
 
-// AddEmergencyContact adds a new emergency contact
-func (ec *EmergencyController) AddEmergencyContact(c *gin.Context) {
-	userID := c.GetString("userID")
-	if userID == "" {
-		utils.UnauthorizedResponse(c, "User not authenticated")
-		return
-	}
-
-	var req models.AddEmergencyContactRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request body")
-		return
-	}
-
-	contact, err := ec.emergencyService.AddEmergencyContact(c.Request.Context(), userID, req)
-	if err != nil {
-		logrus.Errorf("Add emergency contact failed: %v", err)
-		utils.InternalServerErrorResponse(c, "Failed to add emergency contact")
-		return
-	}
-
-	utils.CreatedResponse(c, "Emergency contact added successfully", contact)
-}
-
 // GetEmergencyContact gets a specific emergency contact
 func (ec *EmergencyController) GetEmergencyContact(c *gin.Context) {
 	userID := c.GetString("userID")
@@ -499,60 +485,6 @@ func (ec *EmergencyController) GetEmergencyContact(c *gin.Context) {
 	utils.SuccessResponse(c, "Emergency contact retrieved successfully", contact)
 }
 
-// UpdateEmergencyContact updates an emergency contact
-func (ec *EmergencyController) UpdateEmergencyContact(c *gin.Context) {
-	userID := c.GetString("userID")
-	contactID := c.Param("contactId")
-
-	var req models.UpdateEmergencyContactRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request body")
-		return
-	}
-
-	contact, err := ec.emergencyService.UpdateEmergencyContact(c.Request.Context(), userID, contactID, req)
-	if err != nil {
-		logrus.Errorf("Update emergency contact failed: %v", err)
-		utils.InternalServerErrorResponse(c, "Failed to update emergency contact")
-		return
-	}
-
-	utils.SuccessResponse(c, "Emergency contact updated successfully", contact)
-}
-
-// DeleteEmergencyContact deletes an emergency contact
-func (ec *EmergencyController) DeleteEmergencyContact(c *gin.Context) {
-	userID := c.GetString("userID")
-	contactID := c.Param("contactId")
-
-	err := ec.emergencyService.DeleteEmergencyContact(c.Request.Context(), userID, contactID)
-	if err != nil {
-		logrus.Errorf("Delete emergency contact failed: %v", err)
-		utils.InternalServerErrorResponse(c, "Failed to delete emergency contact")
-		return
-	}
-
-	utils.SuccessResponse(c, "Emergency contact deleted successfully", nil)
-}
-
-// VerifyEmergencyContact verifies an emergency contact
-func (ec *EmergencyController) VerifyEmergencyContact(c *gin.Context) {
-	userID := c.GetString("userID")
-	contactID := c.Param("contactId")
-
-	var req models.VerifyContactRequest
-	c.ShouldBindJSON(&req)
-
-	result, err := ec.emergencyService.VerifyEmergencyContact(c.Request.Context(), userID, contactID, req)
-	if err != nil {
-		logrus.Errorf("Verify emergency contact failed: %v", err)
-		utils.InternalServerErrorResponse(c, "Failed to verify emergency contact")
-		return
-	}
-
-	utils.SuccessResponse(c, "Emergency contact verification sent successfully", result)
-}
-
 // NotifyEmergencyContact notifies an emergency contact
 func (ec *EmergencyController) NotifyEmergencyContact(c *gin.Context) {
 	userID := c.GetString("userID")
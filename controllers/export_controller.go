@@ -0,0 +1,255 @@
+package controllers
+
+import (
+	"ftrack/models"
+	"ftrack/services"
+	"ftrack/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+type ExportController struct {
+	exportService *services.ExportService
+}
+
+func NewExportController(exportService *services.ExportService) *ExportController {
+	return &ExportController{
+		exportService: exportService,
+	}
+}
+
+// GetExportStatus gets the status of a message or place export
+func (ec *ExportController) GetExportStatus(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	exportID := c.Param("exportId")
+	if exportID == "" {
+		utils.BadRequestResponse(c, "Export ID is required")
+		return
+	}
+
+	status, err := ec.exportService.GetExportStatus(c.Request.Context(), userID, exportID)
+	if err != nil {
+		logrus.Errorf("Get export status failed: %v", err)
+		switch err.Error() {
+		case "invalid export ID":
+			utils.BadRequestResponse(c, "Invalid export ID")
+		case "export not found":
+			utils.NotFoundResponse(c, "Export")
+		case "access denied":
+			utils.ForbiddenResponse(c, "You can only view your own exports")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to get export status")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Export status retrieved successfully", status)
+}
+
+// CancelExport cancels a pending or processing export
+func (ec *ExportController) CancelExport(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	exportID := c.Param("exportId")
+	if exportID == "" {
+		utils.BadRequestResponse(c, "Export ID is required")
+		return
+	}
+
+	status, err := ec.exportService.CancelExport(c.Request.Context(), userID, exportID)
+	if err != nil {
+		logrus.Errorf("Cancel export failed: %v", err)
+		switch err.Error() {
+		case "invalid export ID":
+			utils.BadRequestResponse(c, "Invalid export ID")
+		case "export not found":
+			utils.NotFoundResponse(c, "Export")
+		case "access denied":
+			utils.ForbiddenResponse(c, "You can only cancel your own exports")
+		case "export cannot be cancelled":
+			utils.ConflictResponse(c, "Only pending or processing exports can be cancelled")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to cancel export")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Export cancelled successfully", status)
+}
+
+// GetExportPolicy returns who may export a circle's shared data
+func (ec *ExportController) GetExportPolicy(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	policy, err := ec.exportService.GetExportPolicy(c.Request.Context(), userID, c.Param("circleId"))
+	if err != nil {
+		switch err.Error() {
+		case "invalid circle ID":
+			utils.BadRequestResponse(c, "Invalid circle ID")
+		case "circle not found":
+			utils.NotFoundResponse(c, "Circle")
+		case "access denied":
+			utils.ForbiddenResponse(c, "You are not a member of this circle")
+		default:
+			logrus.Errorf("Get export policy failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to get export policy")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Export policy retrieved", policy)
+}
+
+// UpdateExportPolicy sets who may export a circle's shared data
+func (ec *ExportController) UpdateExportPolicy(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.ExportPolicy
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid export policy")
+		return
+	}
+
+	policy, err := ec.exportService.UpdateExportPolicy(c.Request.Context(), userID, c.Param("circleId"), req)
+	if err != nil {
+		switch err.Error() {
+		case "validation failed":
+			utils.BadRequestResponse(c, "Invalid export policy: allow must be owner, admins or members")
+		case "invalid circle ID":
+			utils.BadRequestResponse(c, "Invalid circle ID")
+		case "circle not found":
+			utils.NotFoundResponse(c, "Circle")
+		case "access denied":
+			utils.ForbiddenResponse(c, "Only circle admins can change the export policy, and only the owner an owner-only one")
+		default:
+			logrus.Errorf("Update export policy failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to update export policy")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Export policy updated", policy)
+}
+
+// RequestExportPermission asks a circle's admins to let the user export it
+func (ec *ExportController) RequestExportPermission(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.CreateExportRequestRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.BadRequestResponse(c, "Invalid request body")
+			return
+		}
+	}
+
+	request, err := ec.exportService.RequestExportPermission(c.Request.Context(), userID, c.Param("circleId"), req)
+	if err != nil {
+		switch err.Error() {
+		case "validation failed":
+			utils.BadRequestResponse(c, "The reason can be at most 500 characters")
+		case "invalid circle ID":
+			utils.BadRequestResponse(c, "Invalid circle ID")
+		case "circle not found":
+			utils.NotFoundResponse(c, "Circle")
+		case "access denied":
+			utils.ForbiddenResponse(c, "You are not a member of this circle")
+		case "export already allowed":
+			utils.ConflictResponse(c, "You can already export this circle")
+		case "export request already pending":
+			utils.ConflictResponse(c, "You already asked to export this circle")
+		default:
+			logrus.Errorf("Request export permission failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to request export permission")
+		}
+		return
+	}
+
+	utils.CreatedResponse(c, "Export permission requested", request)
+}
+
+// GetExportRequests lists a circle's export requests waiting for review
+func (ec *ExportController) GetExportRequests(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	requests, err := ec.exportService.GetExportRequests(c.Request.Context(), userID, c.Param("circleId"))
+	if err != nil {
+		switch err.Error() {
+		case "invalid circle ID":
+			utils.BadRequestResponse(c, "Invalid circle ID")
+		case "circle not found":
+			utils.NotFoundResponse(c, "Circle")
+		case "access denied":
+			utils.ForbiddenResponse(c, "Only those who approve exports can see export requests")
+		default:
+			logrus.Errorf("Get export requests failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to get export requests")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Export requests retrieved", requests)
+}
+
+// ReviewExportRequest approves or denies a request to export a circle
+func (ec *ExportController) ReviewExportRequest(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.ReviewExportRequestRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request body")
+		return
+	}
+
+	request, err := ec.exportService.ReviewExportRequest(c.Request.Context(), userID, c.Param("circleId"), c.Param("requestId"), req)
+	if err != nil {
+		switch err.Error() {
+		case "invalid circle ID", "invalid request ID":
+			utils.BadRequestResponse(c, "Invalid circle or request ID")
+		case "circle not found":
+			utils.NotFoundResponse(c, "Circle")
+		case "export request not found":
+			utils.NotFoundResponse(c, "Export request")
+		case "access denied":
+			utils.ForbiddenResponse(c, "Only those who approve exports can review export requests")
+		case "export request already reviewed":
+			utils.ConflictResponse(c, "This export request was already reviewed")
+		default:
+			logrus.Errorf("Review export request failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to review export request")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Export request reviewed", request)
+}
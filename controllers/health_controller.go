@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"net/http"
+	"runtime"
+	"time"
+
+	"ftrack/database"
+	"ftrack/models"
+	"ftrack/utils"
+	"ftrack/workers"
+
+	"github.com/gin-gonic/gin"
+)
+
+const apiVersion = "1.0.0"
+
+type HealthController struct {
+	startTime time.Time
+}
+
+func NewHealthController() *HealthController {
+	return &HealthController{
+		startTime: time.Now(),
+	}
+}
+
+// HealthCheck reports whether the API and its database are up
+func (hc *HealthController) HealthCheck(c *gin.Context) {
+	health := utils.HealthCheckResponse(hc.serviceStatuses(), apiVersion, hc.uptime())
+
+	statusCode := http.StatusOK
+	if health.Status != "healthy" {
+		statusCode = http.StatusServiceUnavailable
+	}
+
+	c.JSON(statusCode, health)
+}
+
+// Readiness reports whether the instance should receive traffic. A worker
+// that stopped sending heartbeats marks the instance degraded, and an open
+// database circuit unhealthy, so the load balancer drains it. Unavailable
+// providers are listed but don't drain it, as every instance shares them.
+func (hc *HealthController) Readiness(c *gin.Context) {
+	health := utils.HealthCheckResponse(hc.serviceStatuses(), apiVersion, hc.uptime())
+
+	circuit := database.Breaker.Stats()
+	degraded := workers.Registry.DegradedWorkers()
+	if circuit.State != database.CircuitClosed {
+		health.Status = "unhealthy"
+	} else if health.Status == "healthy" && len(degraded) > 0 {
+		health.Status = models.WorkerStatusDegraded
+	}
+
+	statusCode := http.StatusOK
+	if health.Status != "healthy" {
+		statusCode = http.StatusServiceUnavailable
+	}
+
+	c.JSON(statusCode, gin.H{
+		"status":          health.Status,
+		"timestamp":       health.Timestamp,
+		"services":        health.Services,
+		"databaseCircuit": circuit,
+		"degradedWorkers": degraded,
+		"providers":       utils.Providers.Unavailable(),
+	})
+}
+
+// DetailedHealthCheck reports the database, worker and provider details
+func (hc *HealthController) DetailedHealthCheck(c *gin.Context) {
+	dbHealth := database.HealthCheck()
+	health := utils.HealthCheckResponse(hc.serviceStatuses(), apiVersion, hc.uptime())
+
+	workerStatuses := workers.Registry.Statuses()
+	for _, worker := range workerStatuses {
+		if worker.Status != models.WorkerStatusHealthy && health.Status == "healthy" {
+			health.Status = models.WorkerStatusDegraded
+		}
+	}
+	if len(utils.Providers.Unavailable()) > 0 && health.Status == "healthy" {
+		health.Status = models.WorkerStatusDegraded
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    health.Status,
+		"timestamp": health.Timestamp,
+		"version":   health.Version,
+		"uptime":    health.Uptime,
+		"database":  dbHealth,
+		"circuit":   database.Breaker.Stats(),
+		"workers":   workerStatuses,
+		"providers": utils.Providers.Stats(),
+		"runtime":   runtimeStats(),
+	})
+}
+
+// APIInfo describes the API
+func (hc *HealthController) APIInfo(c *gin.Context) {
+	utils.SuccessResponse(c, "FTrack API", gin.H{
+		"name":    "FTrack API",
+		"version": apiVersion,
+		"docs":    "/docs/index.html",
+		"health":  "/health",
+	})
+}
+
+// Version returns the API version
+func (hc *HealthController) Version(c *gin.Context) {
+	utils.SuccessResponse(c, "Version retrieved successfully", gin.H{
+		"version":   apiVersion,
+		"goVersion": runtime.Version(),
+	})
+}
+
+// SwaggerDocs serves the API documentation
+func (hc *HealthController) SwaggerDocs(c *gin.Context) {
+	utils.NotFoundResponse(c, "Documentation")
+}
+
+// Metrics returns process metrics
+func (hc *HealthController) Metrics(c *gin.Context) {
+	utils.SuccessResponse(c, "Metrics retrieved successfully", gin.H{
+		"uptime":          hc.uptime(),
+		"runtime":         runtimeStats(),
+		"workers":         workers.Registry.Statuses(),
+		"databaseCircuit": database.Breaker.Stats(),
+		"databaseQueries": database.Queries.Stats(),
+		"providers":       utils.Providers.Stats(),
+	})
+}
+
+// SystemStats returns system statistics
+func (hc *HealthController) SystemStats(c *gin.Context) {
+	utils.SuccessResponse(c, "System stats retrieved successfully", gin.H{
+		"uptime":   hc.uptime(),
+		"database": database.HealthCheck(),
+		"runtime":  runtimeStats(),
+	})
+}
+
+// GetWorkers returns the background workers' heartbeats, throughput and
+// backlog
+func (hc *HealthController) GetWorkers(c *gin.Context) {
+	utils.SuccessResponse(c, "Worker status retrieved successfully", workers.Registry.Statuses())
+}
+
+func (hc *HealthController) serviceStatuses() map[string]string {
+	dbStatus, _ := database.HealthCheck()["status"].(string)
+	return map[string]string{
+		"database": dbStatus,
+	}
+}
+
+func (hc *HealthController) uptime() string {
+	return time.Since(hc.startTime).Round(time.Second).String()
+}
+
+func runtimeStats() gin.H {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	return gin.H{
+		"goroutines": runtime.NumGoroutine(),
+		"heapAlloc":  mem.HeapAlloc,
+		"sys":        mem.Sys,
+		"numGC":      mem.NumGC,
+	}
+}
@@ -0,0 +1,237 @@
+package controllers
+
+import (
+	"ftrack/models"
+	"ftrack/services"
+	"ftrack/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+type ImpersonationController struct {
+	impersonationService *services.ImpersonationService
+}
+
+func NewImpersonationController(impersonationService *services.ImpersonationService) *ImpersonationController {
+	return &ImpersonationController{
+		impersonationService: impersonationService,
+	}
+}
+
+// RequestImpersonation asks for consent to view the app as a user
+func (ic *ImpersonationController) RequestImpersonation(c *gin.Context) {
+	adminID := c.GetString("userID")
+	if adminID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.CreateImpersonationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request body")
+		return
+	}
+
+	session, err := ic.impersonationService.RequestImpersonation(c.Request.Context(), adminID, req)
+	if err != nil {
+		logrus.Errorf("Request impersonation failed: %v", err)
+		respondImpersonationError(c, err, "Failed to request impersonation")
+		return
+	}
+
+	utils.CreatedResponse(c, "Impersonation requested", session)
+}
+
+// GetImpersonation gets an impersonation session
+func (ic *ImpersonationController) GetImpersonation(c *gin.Context) {
+	sessionID := c.Param("sessionId")
+	if sessionID == "" {
+		utils.BadRequestResponse(c, "Session ID is required")
+		return
+	}
+
+	session, err := ic.impersonationService.GetSession(c.Request.Context(), sessionID)
+	if err != nil {
+		logrus.Errorf("Get impersonation failed: %v", err)
+		respondImpersonationError(c, err, "Failed to get impersonation session")
+		return
+	}
+
+	utils.SuccessResponse(c, "Impersonation session retrieved successfully", session)
+}
+
+// ApproveImpersonation approves a session on documented consent, as a
+// second admin
+func (ic *ImpersonationController) ApproveImpersonation(c *gin.Context) {
+	approverID := c.GetString("userID")
+	if approverID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	sessionID := c.Param("sessionId")
+	if sessionID == "" {
+		utils.BadRequestResponse(c, "Session ID is required")
+		return
+	}
+
+	var req models.ApproveImpersonationRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.BadRequestResponse(c, "Invalid request body")
+			return
+		}
+	}
+
+	session, err := ic.impersonationService.ApproveImpersonation(c.Request.Context(), approverID, c.GetString("userRole"), sessionID, req)
+	if err != nil {
+		logrus.Errorf("Approve impersonation failed: %v", err)
+		respondImpersonationError(c, err, "Failed to approve impersonation")
+		return
+	}
+
+	utils.SuccessResponse(c, "Impersonation approved", session)
+}
+
+// StartImpersonation issues the token of an approved session
+func (ic *ImpersonationController) StartImpersonation(c *gin.Context) {
+	adminID := c.GetString("userID")
+	if adminID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	sessionID := c.Param("sessionId")
+	if sessionID == "" {
+		utils.BadRequestResponse(c, "Session ID is required")
+		return
+	}
+
+	token, err := ic.impersonationService.StartImpersonation(c.Request.Context(), adminID, sessionID)
+	if err != nil {
+		logrus.Errorf("Start impersonation failed: %v", err)
+		respondImpersonationError(c, err, "Failed to start impersonation")
+		return
+	}
+
+	utils.SuccessResponse(c, "Impersonation started", token)
+}
+
+// EndImpersonation ends a session before its token runs out
+func (ic *ImpersonationController) EndImpersonation(c *gin.Context) {
+	adminID := c.GetString("userID")
+	if adminID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	sessionID := c.Param("sessionId")
+	if sessionID == "" {
+		utils.BadRequestResponse(c, "Session ID is required")
+		return
+	}
+
+	session, err := ic.impersonationService.EndImpersonation(c.Request.Context(), adminID, sessionID)
+	if err != nil {
+		logrus.Errorf("End impersonation failed: %v", err)
+		respondImpersonationError(c, err, "Failed to end impersonation")
+		return
+	}
+
+	utils.SuccessResponse(c, "Impersonation ended", session)
+}
+
+// GetMyImpersonations lists the support sessions on the user's account
+func (ic *ImpersonationController) GetMyImpersonations(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	sessions, err := ic.impersonationService.GetUserSessions(c.Request.Context(), userID)
+	if err != nil {
+		logrus.Errorf("Get impersonations failed: %v", err)
+		utils.InternalServerErrorResponse(c, "Failed to get support sessions")
+		return
+	}
+
+	utils.SuccessResponse(c, "Support sessions retrieved successfully", sessions)
+}
+
+// ApproveMyImpersonation lets support view the user's account
+func (ic *ImpersonationController) ApproveMyImpersonation(c *gin.Context) {
+	ic.respondToImpersonation(c, true)
+}
+
+// DenyMyImpersonation turns down support's request to view the account
+func (ic *ImpersonationController) DenyMyImpersonation(c *gin.Context) {
+	ic.respondToImpersonation(c, false)
+}
+
+func (ic *ImpersonationController) respondToImpersonation(c *gin.Context, approve bool) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	sessionID := c.Param("sessionId")
+	if sessionID == "" {
+		utils.BadRequestResponse(c, "Session ID is required")
+		return
+	}
+
+	var req models.ApproveImpersonationRequest
+	if approve && c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.BadRequestResponse(c, "Invalid request body")
+			return
+		}
+	}
+
+	session, err := ic.impersonationService.RespondToImpersonation(c.Request.Context(), userID, sessionID, approve, req)
+	if err != nil {
+		logrus.Errorf("Respond to impersonation failed: %v", err)
+		respondImpersonationError(c, err, "Failed to answer the support request")
+		return
+	}
+
+	message := "Support request denied"
+	if approve {
+		message = "Support request approved"
+	}
+	utils.SuccessResponse(c, message, session)
+}
+
+func respondImpersonationError(c *gin.Context, err error, fallback string) {
+	switch err.Error() {
+	case "validation failed":
+		utils.BadRequestResponse(c, utils.ValidationFailureReason(err))
+	case "invalid impersonation session ID", "invalid user ID":
+		utils.BadRequestResponse(c, "Invalid ID")
+	case "impersonation session not found":
+		utils.NotFoundResponse(c, "Impersonation session")
+	case "user not found":
+		utils.NotFoundResponse(c, "User")
+	case "access denied":
+		utils.ForbiddenResponse(c, "You don't have permission to do this")
+	case "staff accounts can't be impersonated":
+		utils.ForbiddenResponse(c, "Staff accounts can't be impersonated")
+	case "impersonation needs the user's approval":
+		utils.ForbiddenResponse(c, "Only the user can approve this impersonation")
+	case "impersonation already requested":
+		utils.ConflictResponse(c, "An impersonation of this user is already open")
+	case "impersonation request already answered":
+		utils.ConflictResponse(c, "The impersonation request was already answered")
+	case "impersonation already ended":
+		utils.ConflictResponse(c, "The impersonation has already ended")
+	case "impersonation not approved":
+		utils.BadRequestResponse(c, "The impersonation hasn't been approved")
+	case "impersonation request expired":
+		utils.BadRequestResponse(c, "The impersonation request has expired")
+	default:
+		utils.InternalServerErrorResponse(c, fallback)
+	}
+}
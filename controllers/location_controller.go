@@ -12,12 +12,16 @@ import (
 )
 
 type LocationController struct {
-	locationService *services.LocationService
+	locationService  *services.LocationService
+	anomalyService   *services.AnomalyService
+	integrityService *services.LocationIntegrityService
 }
 
-func NewLocationController(locationService *services.LocationService) *LocationController {
+func NewLocationController(locationService *services.LocationService, anomalyService *services.AnomalyService, integrityService *services.LocationIntegrityService) *LocationController {
 	return &LocationController{
-		locationService: locationService,
+		locationService:  locationService,
+		anomalyService:   anomalyService,
+		integrityService: integrityService,
 	}
 }
 
@@ -33,11 +37,11 @@ func (lc *LocationController) UpdateLocation(c *gin.Context) {
 
 	var location models.Location
 	if err := c.ShouldBindJSON(&location); err != nil {
-		utils.BadRequestResponse(c, "Invalid location data")
+		utils.BindErrorResponse(c, "Invalid location data", err)
 		return
 	}
 
-	updatedLocation, err := lc.locationService.UpdateLocation(c.Request.Context(), userID, location)
+	updatedLocation, err := lc.locationService.UpdateLocationWithHint(c.Request.Context(), userID, location)
 	if err != nil {
 		logrus.Errorf("Update location failed: %v", err)
 		switch err.Error() {
@@ -46,7 +50,7 @@ func (lc *LocationController) UpdateLocation(c *gin.Context) {
 		case "location sharing disabled":
 			utils.ForbiddenResponse(c, "Location sharing is disabled for this user")
 		case "validation failed":
-			utils.BadRequestResponse(c, "Invalid location data")
+			utils.ValidationFailedResponse(c, "Invalid location data", err)
 		default:
 			utils.InternalServerErrorResponse(c, "Failed to update location")
 		}
@@ -152,6 +156,47 @@ func (lc *LocationController) GetLocationHistory(c *gin.Context) {
 	utils.SuccessResponse(c, "Location history retrieved successfully", history)
 }
 
+// GetViewportHistory gets a member's location history inside a map viewport
+func (lc *LocationController) GetViewportHistory(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.ViewportHistoryRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid query parameters")
+		return
+	}
+
+	history, err := lc.locationService.GetViewportHistory(c.Request.Context(), userID, req)
+	if err != nil {
+		logrus.Errorf("Get viewport history failed: %v", err)
+		switch err.Error() {
+		case "invalid user ID":
+			utils.BadRequestResponse(c, "Invalid user ID")
+		case "invalid bbox":
+			utils.BadRequestResponse(c, "bbox must be minLon,minLat,maxLon,maxLat")
+		case "invalid date range":
+			utils.BadRequestResponse(c, "Invalid time range, use RFC 3339 times at most 31 days apart")
+		case "invalid max points":
+			utils.BadRequestResponse(c, "maxPoints must be between 1 and 2000")
+		case "user not found":
+			utils.NotFoundResponse(c, "User")
+		case "access denied":
+			utils.ForbiddenResponse(c, "Access denied")
+		case "location sharing paused":
+			utils.ForbiddenResponse(c, "Member is not sharing their location")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to get location history")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Location history retrieved successfully", history)
+}
+
 // ClearLocationHistory clears user's location history
 func (lc *LocationController) ClearLocationHistory(c *gin.Context) {
 	userID := c.GetString("userID")
@@ -919,6 +964,124 @@ func (lc *LocationController) ReportDrivingEvent(c *gin.Context) {
 	utils.CreatedResponse(c, "Driving event reported successfully", event)
 }
 
+// GetWeeklySafetyReport gets the user's own trip safety for a week
+func (lc *LocationController) GetWeeklySafetyReport(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.WeeklySafetyReportRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid query parameters")
+		return
+	}
+
+	report, err := lc.locationService.GetWeeklySafetyReport(c.Request.Context(), userID, req)
+	if err != nil {
+		logrus.Errorf("Get weekly safety report failed: %v", err)
+		handleDrivingSafetyError(c, err, "Failed to get weekly safety report")
+		return
+	}
+
+	utils.SuccessResponse(c, "Weekly safety report retrieved successfully", report)
+}
+
+// GetMemberWeeklySafetyReport gets a circle member's trip safety for a
+// week, if they share it with the circle
+func (lc *LocationController) GetMemberWeeklySafetyReport(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.WeeklySafetyReportRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid query parameters")
+		return
+	}
+
+	report, err := lc.locationService.GetMemberWeeklySafetyReport(c.Request.Context(), userID, c.Param("circleId"), c.Param("userId"), req)
+	if err != nil {
+		logrus.Errorf("Get member weekly safety report failed: %v", err)
+		handleDrivingSafetyError(c, err, "Failed to get weekly safety report")
+		return
+	}
+
+	utils.SuccessResponse(c, "Weekly safety report retrieved successfully", report)
+}
+
+// GetDrivingSafetySharing gets whether the user shares trip safety with
+// the circle
+func (lc *LocationController) GetDrivingSafetySharing(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	sharing, err := lc.locationService.GetDrivingSafetySharing(c.Request.Context(), userID, c.Param("circleId"))
+	if err != nil {
+		logrus.Errorf("Get driving safety sharing failed: %v", err)
+		handleDrivingSafetyError(c, err, "Failed to get driving safety sharing")
+		return
+	}
+
+	utils.SuccessResponse(c, "Driving safety sharing retrieved successfully", sharing)
+}
+
+// UpdateDrivingSafetySharing turns sharing trip safety with the circle on
+// or off
+func (lc *LocationController) UpdateDrivingSafetySharing(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.UpdateDrivingSafetySharingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BindErrorResponse(c, "Invalid request body", err)
+		return
+	}
+
+	sharing, err := lc.locationService.UpdateDrivingSafetySharing(c.Request.Context(), userID, c.Param("circleId"), req)
+	if err != nil {
+		logrus.Errorf("Update driving safety sharing failed: %v", err)
+		handleDrivingSafetyError(c, err, "Failed to update driving safety sharing")
+		return
+	}
+
+	utils.SuccessResponse(c, "Driving safety sharing updated successfully", sharing)
+}
+
+func handleDrivingSafetyError(c *gin.Context, err error, fallback string) {
+	switch err.Error() {
+	case "validation failed":
+		utils.ValidationFailedResponse(c, "Invalid driving safety settings", err)
+	case "invalid circle ID":
+		utils.BadRequestResponse(c, "Invalid circle ID")
+	case "invalid user ID":
+		utils.BadRequestResponse(c, "Invalid user ID")
+	case "invalid week":
+		utils.BadRequestResponse(c, "weekOf must be a date like 2006-01-02")
+	case "notify member not in circle":
+		utils.BadRequestResponse(c, "Reports can only be sent to other active members of the circle")
+	case "circle not found", "circle or member not found":
+		utils.NotFoundResponse(c, "Circle")
+	case "member not found", "user not found":
+		utils.NotFoundResponse(c, "Member")
+	case "access denied":
+		utils.ForbiddenResponse(c, "Access denied")
+	case "driving safety not shared":
+		utils.ForbiddenResponse(c, "Member is not sharing their driving safety with this circle")
+	default:
+		utils.InternalServerErrorResponse(c, fallback)
+	}
+}
+
 // ==================== ANALYTICS ENDPOINTS ====================
 
 // GetLocationStats gets location statistics
@@ -961,6 +1124,78 @@ func (lc *LocationController) GetLocationHeatmap(c *gin.Context) {
 	utils.SuccessResponse(c, "Location heatmap retrieved successfully", heatmap)
 }
 
+// GetMemberVisitHeatmap gets a circle member's visit heatmap
+func (lc *LocationController) GetMemberVisitHeatmap(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.MemberVisitHeatmapRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid query parameters")
+		return
+	}
+
+	heatmap, err := lc.locationService.GetMemberVisitHeatmap(c.Request.Context(), userID, c.Param("circleId"), c.Param("userId"), req)
+	if err != nil {
+		logrus.Errorf("Get member visit heatmap failed: %v", err)
+		switch err.Error() {
+		case "invalid circle ID":
+			utils.BadRequestResponse(c, "Invalid circle ID")
+		case "invalid user ID":
+			utils.BadRequestResponse(c, "Invalid user ID")
+		case "invalid period":
+			utils.BadRequestResponse(c, "Period must be day, week, month or custom")
+		case "invalid date range":
+			utils.BadRequestResponse(c, "Invalid date range")
+		case "invalid zoom":
+			utils.BadRequestResponse(c, "Zoom must be between 1 and 18")
+		case "circle not found":
+			utils.NotFoundResponse(c, "Circle")
+		case "member not found", "user not found":
+			utils.NotFoundResponse(c, "Member")
+		case "access denied":
+			utils.ForbiddenResponse(c, "Access denied")
+		case "location sharing paused":
+			utils.ForbiddenResponse(c, "Member is not sharing their location")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to get visit heatmap")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Visit heatmap retrieved successfully", heatmap)
+}
+
+// GetCircleLocations gets the latest location of every circle member
+func (lc *LocationController) GetCircleLocations(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	snapshot, err := lc.locationService.GetCircleLocations(c.Request.Context(), userID, c.Param("circleId"))
+	if err != nil {
+		logrus.Errorf("Get circle locations failed: %v", err)
+		switch err.Error() {
+		case "invalid circle ID":
+			utils.BadRequestResponse(c, "Invalid circle ID")
+		case "circle not found":
+			utils.NotFoundResponse(c, "Circle")
+		case "access denied":
+			utils.ForbiddenResponse(c, "Access denied")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to get circle locations")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Circle locations retrieved successfully", snapshot)
+}
+
 // GetLocationPatterns gets location patterns
 func (lc *LocationController) GetLocationPatterns(c *gin.Context) {
 	userID := c.GetString("userID")
@@ -1527,3 +1762,170 @@ func (lc *LocationController) SetPowerMode(c *gin.Context) {
 
 	utils.SuccessResponse(c, "Power mode set successfully", result)
 }
+
+// ==================== ANOMALY ALERT ENDPOINTS ====================
+
+// GetAnomalyProfile gets a member's anomaly alert rules and watchers
+func (lc *LocationController) GetAnomalyProfile(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	profile, err := lc.anomalyService.GetProfile(c.Request.Context(), userID, c.Param("userId"))
+	if err != nil {
+		logrus.Errorf("Get anomaly profile failed: %v", err)
+		handleAnomalyError(c, err, "Failed to get anomaly profile")
+		return
+	}
+
+	utils.SuccessResponse(c, "Anomaly profile retrieved successfully", profile)
+}
+
+// UpdateAnomalyProfile replaces a member's anomaly alert rules and watchers
+func (lc *LocationController) UpdateAnomalyProfile(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.UpdateAnomalyProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data")
+		return
+	}
+
+	profile, err := lc.anomalyService.UpdateProfile(c.Request.Context(), userID, c.Param("userId"), req)
+	if err != nil {
+		logrus.Errorf("Update anomaly profile failed: %v", err)
+		handleAnomalyError(c, err, "Failed to update anomaly profile")
+		return
+	}
+
+	utils.SuccessResponse(c, "Anomaly profile updated successfully", profile)
+}
+
+// GetAnomalyAlerts lists the alerts about the user or sent to them
+func (lc *LocationController) GetAnomalyAlerts(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	pagination := utils.ParsePagination(c)
+
+	alerts, err := lc.anomalyService.GetAlerts(c.Request.Context(), userID, pagination.Page, pagination.PageSize)
+	if err != nil {
+		logrus.Errorf("Get anomaly alerts failed: %v", err)
+		handleAnomalyError(c, err, "Failed to get anomaly alerts")
+		return
+	}
+
+	utils.SuccessResponse(c, "Anomaly alerts retrieved successfully", alerts)
+}
+
+// GetAnomalyAlert gets an alert with the events that raised it
+func (lc *LocationController) GetAnomalyAlert(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	alert, err := lc.anomalyService.GetAlert(c.Request.Context(), userID, c.Param("alertId"))
+	if err != nil {
+		logrus.Errorf("Get anomaly alert failed: %v", err)
+		handleAnomalyError(c, err, "Failed to get anomaly alert")
+		return
+	}
+
+	utils.SuccessResponse(c, "Anomaly alert retrieved successfully", alert)
+}
+
+// SubmitAnomalyFeedback marks an alert as confirmed or a false positive
+func (lc *LocationController) SubmitAnomalyFeedback(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.AnomalyFeedbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request data")
+		return
+	}
+
+	alert, err := lc.anomalyService.SubmitFeedback(c.Request.Context(), userID, c.Param("alertId"), req)
+	if err != nil {
+		logrus.Errorf("Submit anomaly feedback failed: %v", err)
+		handleAnomalyError(c, err, "Failed to submit feedback")
+		return
+	}
+
+	utils.SuccessResponse(c, "Feedback submitted successfully", alert)
+}
+
+func handleAnomalyError(c *gin.Context, err error, fallback string) {
+	switch err.Error() {
+	case "invalid user ID":
+		utils.BadRequestResponse(c, "Invalid user ID")
+	case "invalid alert ID":
+		utils.BadRequestResponse(c, "Invalid alert ID")
+	case "invalid place ID":
+		utils.BadRequestResponse(c, "Invalid place ID")
+	case "invalid timezone":
+		utils.BadRequestResponse(c, "Invalid timezone")
+	case "validation failed":
+		reason := utils.ValidationFailureReason(err)
+		if reason == "" {
+			reason = "Invalid anomaly profile"
+		}
+		utils.BadRequestResponse(c, reason)
+	case "anomaly profile not found":
+		utils.NotFoundResponse(c, "Anomaly profile")
+	case "anomaly alert not found":
+		utils.NotFoundResponse(c, "Anomaly alert")
+	case "place not found":
+		utils.NotFoundResponse(c, "Place")
+	case "access denied":
+		utils.ForbiddenResponse(c, "Access denied")
+	case "feedback already given":
+		utils.ConflictResponse(c, "Feedback was already given for this alert")
+	default:
+		utils.InternalServerErrorResponse(c, fallback)
+	}
+}
+
+// GetCircleIntegrity lists how far the circle members' locations look real,
+// for circle admins with integrity alerts on
+func (lc *LocationController) GetCircleIntegrity(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	integrity, err := lc.integrityService.GetCircleIntegrity(c.Request.Context(), userID, c.Param("circleId"))
+	if err != nil {
+		logrus.Errorf("Get circle integrity failed: %v", err)
+		switch err.Error() {
+		case "invalid circle ID":
+			utils.BadRequestResponse(c, "Invalid circle ID")
+		case "circle not found":
+			utils.NotFoundResponse(c, "Circle")
+		case "access denied":
+			utils.ForbiddenResponse(c, "Access denied")
+		case "integrity alerts disabled":
+			utils.ForbiddenResponse(c, "Integrity alerts are not enabled for this circle")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to get location integrity")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Location integrity retrieved successfully", integrity)
+}
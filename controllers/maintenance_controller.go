@@ -0,0 +1,208 @@
+package controllers
+
+import (
+	"ftrack/models"
+	"ftrack/services"
+	"ftrack/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+type MaintenanceController struct {
+	maintenanceService *services.MaintenanceService
+	searchService      *services.SearchService
+}
+
+func NewMaintenanceController(maintenanceService *services.MaintenanceService, searchService *services.SearchService) *MaintenanceController {
+	return &MaintenanceController{
+		maintenanceService: maintenanceService,
+		searchService:      searchService,
+	}
+}
+
+// TriggerReconcile starts a counter reconciliation run in the background
+func (mc *MaintenanceController) TriggerReconcile(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	// An empty body reconciles every counter class
+	var req models.ReconcileRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.BadRequestResponse(c, "Invalid request body")
+			return
+		}
+	}
+
+	run, err := mc.maintenanceService.StartReconcile(c.Request.Context(), "admin", userID, req)
+	if err != nil {
+		logrus.Errorf("Trigger reconcile failed: %v", err)
+		switch err.Error() {
+		case "validation failed":
+			utils.BadRequestResponse(c, "Invalid reconcile request")
+		case "reconcile already running":
+			utils.ConflictResponse(c, "A reconcile run is already in progress")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to start reconcile")
+		}
+		return
+	}
+
+	utils.AcceptedResponse(c, "Reconcile started", run)
+}
+
+// GetReconcileRun gets the report of a reconcile run
+func (mc *MaintenanceController) GetReconcileRun(c *gin.Context) {
+	runID := c.Param("runId")
+	if runID == "" {
+		utils.BadRequestResponse(c, "Run ID is required")
+		return
+	}
+
+	run, err := mc.maintenanceService.GetReconcileRun(c.Request.Context(), runID)
+	if err != nil {
+		logrus.Errorf("Get reconcile run failed: %v", err)
+		switch err.Error() {
+		case "invalid run ID":
+			utils.BadRequestResponse(c, "Invalid run ID")
+		case "run not found":
+			utils.NotFoundResponse(c, "Reconcile run")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to get reconcile run")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Reconcile run retrieved successfully", run)
+}
+
+// GetDriftStats gets counter drift totals per counter class
+func (mc *MaintenanceController) GetDriftStats(c *gin.Context) {
+	utils.SuccessResponse(c, "Counter drift retrieved successfully", mc.maintenanceService.GetDriftStats())
+}
+
+// TriggerSearchIndexRebuild rebuilds the message search index in the
+// background. Search keeps working on a slower scan meanwhile.
+func (mc *MaintenanceController) TriggerSearchIndexRebuild(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	build, err := mc.searchService.StartSearchIndexRebuild(c.Request.Context(), userID)
+	if err != nil {
+		logrus.Errorf("Trigger search index rebuild failed: %v", err)
+		switch err.Error() {
+		case "search index rebuild already running":
+			utils.ConflictResponse(c, "A search index rebuild is already in progress")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to start search index rebuild")
+		}
+		return
+	}
+
+	utils.AcceptedResponse(c, "Search index rebuild started", build)
+}
+
+// GetSearchIndexBuild gets the progress of a search index rebuild
+func (mc *MaintenanceController) GetSearchIndexBuild(c *gin.Context) {
+	buildID := c.Param("buildId")
+	if buildID == "" {
+		utils.BadRequestResponse(c, "Build ID is required")
+		return
+	}
+
+	build, err := mc.searchService.GetSearchIndexBuild(c.Request.Context(), buildID)
+	if err != nil {
+		logrus.Errorf("Get search index build failed: %v", err)
+		switch err.Error() {
+		case "invalid build ID":
+			utils.BadRequestResponse(c, "Invalid build ID")
+		case "build not found":
+			utils.NotFoundResponse(c, "Search index build")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to get search index build")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Search index build retrieved successfully", build)
+}
+
+// GetSearchIndexStatus reports whether the message search index is current
+func (mc *MaintenanceController) GetSearchIndexStatus(c *gin.Context) {
+	status, err := mc.searchService.GetSearchIndexStatus(c.Request.Context())
+	if err != nil {
+		logrus.Errorf("Get search index status failed: %v", err)
+		utils.InternalServerErrorResponse(c, "Failed to get search index status")
+		return
+	}
+
+	utils.SuccessResponse(c, "Search index status retrieved successfully", status)
+}
+
+// TriggerMessageEncryption encrypts stored message content for circles that
+// turned encryption on, and re-encrypts content after a key rotation
+func (mc *MaintenanceController) TriggerMessageEncryption(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.MessageEncryptionRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.BadRequestResponse(c, "Invalid request body")
+			return
+		}
+	}
+
+	run, err := mc.maintenanceService.StartMessageEncryption(c.Request.Context(), userID, req)
+	if err != nil {
+		logrus.Errorf("Trigger message encryption failed: %v", err)
+		switch err.Error() {
+		case "validation failed":
+			utils.BadRequestResponse(c, "Invalid message encryption request")
+		case "message encryption not configured":
+			utils.BadRequestResponse(c, "Message encryption is not configured on this server")
+		case "message encryption already running":
+			utils.ConflictResponse(c, "A message encryption run is already in progress")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to start message encryption")
+		}
+		return
+	}
+
+	utils.AcceptedResponse(c, "Message encryption started", run)
+}
+
+// GetMessageEncryptionRun gets the progress of a message encryption run
+func (mc *MaintenanceController) GetMessageEncryptionRun(c *gin.Context) {
+	runID := c.Param("runId")
+	if runID == "" {
+		utils.BadRequestResponse(c, "Run ID is required")
+		return
+	}
+
+	run, err := mc.maintenanceService.GetMessageEncryptionRun(c.Request.Context(), runID)
+	if err != nil {
+		logrus.Errorf("Get message encryption run failed: %v", err)
+		switch err.Error() {
+		case "invalid run ID":
+			utils.BadRequestResponse(c, "Invalid run ID")
+		case "run not found":
+			utils.NotFoundResponse(c, "Message encryption run")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to get message encryption run")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Message encryption run retrieved successfully", run)
+}
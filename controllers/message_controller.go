@@ -1,10 +1,13 @@
 package controllers
 
 import (
+	"fmt"
 	"ftrack/models"
 	"ftrack/services"
 	"ftrack/utils"
+	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
@@ -36,15 +39,14 @@ func (mc *MessageController) GetMessages(c *gin.Context) {
 		return
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "50"))
+	pagination := utils.ParseHistoryPagination(c)
 	before := c.Query("before")
 	after := c.Query("after")
 
 	req := models.GetMessagesRequest{
 		CircleID: circleID,
-		Page:     page,
-		PageSize: pageSize,
+		Page:     pagination.Page,
+		PageSize: pagination.PageSize,
 		Before:   before,
 		After:    after,
 	}
@@ -76,7 +78,7 @@ func (mc *MessageController) SendMessage(c *gin.Context) {
 
 	var req models.SendMessageRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request body")
+		utils.BindErrorResponse(c, "Invalid request body", err)
 		return
 	}
 
@@ -85,19 +87,25 @@ func (mc *MessageController) SendMessage(c *gin.Context) {
 		logrus.Errorf("Send message failed: %v", err)
 		switch err.Error() {
 		case "validation failed":
-			utils.BadRequestResponse(c, "Invalid message data")
+			utils.ValidationFailedResponse(c, "Invalid message data", err)
 		case "access denied":
 			utils.ForbiddenResponse(c, "You don't have permission to send messages to this circle")
 		case "circle not found":
 			utils.NotFoundResponse(c, "Circle")
 		case "message too long":
 			utils.BadRequestResponse(c, "Message content is too long")
+		case "circle is archived":
+			utils.ConflictResponse(c, "This circle was merged into another circle and is read-only")
 		default:
 			utils.InternalServerErrorResponse(c, "Failed to send message")
 		}
 		return
 	}
 
+	if message.IsDuplicate {
+		utils.SuccessResponse(c, "Message already sent", message)
+		return
+	}
 	utils.CreatedResponse(c, "Message sent successfully", message)
 }
 
@@ -148,7 +156,7 @@ func (mc *MessageController) UpdateMessage(c *gin.Context) {
 
 	var req models.EditMessageRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request body")
+		utils.BindErrorResponse(c, "Invalid request body", err)
 		return
 	}
 
@@ -161,9 +169,13 @@ func (mc *MessageController) UpdateMessage(c *gin.Context) {
 		case "access denied":
 			utils.ForbiddenResponse(c, "You can only edit your own messages")
 		case "validation failed":
-			utils.BadRequestResponse(c, "Invalid message content")
+			utils.ValidationFailedResponse(c, "Invalid message content", err)
 		case "edit time expired":
 			utils.BadRequestResponse(c, "Message can no longer be edited")
+		case "editing disabled":
+			utils.ForbiddenResponse(c, "This circle doesn't allow editing messages")
+		case "editing restricted to admins":
+			utils.ForbiddenResponse(c, "Only circle admins can edit messages in this circle")
 		default:
 			utils.InternalServerErrorResponse(c, "Failed to update message")
 		}
@@ -195,6 +207,10 @@ func (mc *MessageController) DeleteMessage(c *gin.Context) {
 			utils.NotFoundResponse(c, "Message")
 		case "access denied":
 			utils.ForbiddenResponse(c, "You can only delete your own messages")
+		case "deleting disabled":
+			utils.ForbiddenResponse(c, "This circle doesn't allow deleting messages")
+		case "deleting restricted to admins":
+			utils.ForbiddenResponse(c, "Only circle admins can delete messages in this circle")
 		default:
 			utils.InternalServerErrorResponse(c, "Failed to delete message")
 		}
@@ -204,6 +220,68 @@ func (mc *MessageController) DeleteMessage(c *gin.Context) {
 	utils.SuccessResponse(c, "Message deleted successfully", nil)
 }
 
+// GetMessagePolicy returns who may edit and delete messages in a circle
+func (mc *MessageController) GetMessagePolicy(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	policy, err := mc.messageService.GetMessagePolicy(c.Request.Context(), userID, c.Param("circleId"))
+	if err != nil {
+		switch err.Error() {
+		case "invalid circle ID":
+			utils.BadRequestResponse(c, "Invalid circle ID")
+		case "circle not found":
+			utils.NotFoundResponse(c, "Circle")
+		case "access denied":
+			utils.ForbiddenResponse(c, "You are not a member of this circle")
+		default:
+			logrus.Errorf("Get message policy failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to get message policy")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Message policy retrieved", policy)
+}
+
+// UpdateMessagePolicy replaces who may edit and delete messages in a circle
+func (mc *MessageController) UpdateMessagePolicy(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.MessagePolicy
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid message policy")
+		return
+	}
+
+	policy, err := mc.messageService.UpdateMessagePolicy(c.Request.Context(), userID, c.Param("circleId"), req)
+	if err != nil {
+		switch err.Error() {
+		case "validation failed":
+			utils.BadRequestResponse(c, "Invalid message policy: edit and delete must be senders, admins or none, and the edit window 0 to 10080 minutes")
+		case "invalid circle ID":
+			utils.BadRequestResponse(c, "Invalid circle ID")
+		case "circle not found":
+			utils.NotFoundResponse(c, "Circle")
+		case "access denied":
+			utils.ForbiddenResponse(c, "Only circle admins can change the message policy")
+		default:
+			logrus.Errorf("Update message policy failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to update message policy")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Message policy updated", policy)
+}
+
 // Message threading and replies
 
 // GetReplies gets replies to a message
@@ -220,10 +298,9 @@ func (mc *MessageController) GetReplies(c *gin.Context) {
 		return
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+	pagination := utils.ParsePagination(c)
 
-	replies, err := mc.messageService.GetReplies(c.Request.Context(), userID, messageID, page, pageSize)
+	replies, err := mc.messageService.GetReplies(c.Request.Context(), userID, messageID, pagination.Page, pagination.PageSize)
 	if err != nil {
 		logrus.Errorf("Get replies failed: %v", err)
 		switch err.Error() {
@@ -481,6 +558,41 @@ func (mc *MessageController) RemoveReaction(c *gin.Context) {
 	utils.SuccessResponse(c, "Reaction removed successfully", nil)
 }
 
+// ToggleReaction adds the reaction if the user hasn't reacted with the emoji
+// yet and removes it otherwise
+func (mc *MessageController) ToggleReaction(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	messageID := c.Param("messageId")
+	emoji := c.Param("emoji")
+	if messageID == "" || emoji == "" {
+		utils.BadRequestResponse(c, "Message ID and emoji are required")
+		return
+	}
+
+	result, err := mc.messageService.ToggleReaction(c.Request.Context(), userID, messageID, emoji)
+	if err != nil {
+		logrus.Errorf("Toggle reaction failed: %v", err)
+		switch err.Error() {
+		case "message not found":
+			utils.NotFoundResponse(c, "Message")
+		case "invalid message ID":
+			utils.BadRequestResponse(c, "Invalid message ID")
+		case "access denied":
+			utils.ForbiddenResponse(c, "You don't have access to this message")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to toggle reaction")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Reaction toggled successfully", result)
+}
+
 // GetReactionUsers gets users who reacted with a specific emoji
 func (mc *MessageController) GetReactionUsers(c *gin.Context) {
 	userID := c.GetString("userID")
@@ -560,6 +672,92 @@ func (mc *MessageController) UploadMedia(c *gin.Context) {
 	utils.CreatedResponse(c, "Media uploaded successfully", media)
 }
 
+// CreateUploadIntent returns a pre-signed URL to upload media directly to
+// storage
+func (mc *MessageController) CreateUploadIntent(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.MediaUploadIntentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BindErrorResponse(c, "Invalid request body", err)
+		return
+	}
+
+	intent, err := mc.messageService.CreateMediaUploadIntent(c.Request.Context(), userID, req)
+	if err != nil {
+		logrus.Errorf("Create upload intent failed: %v", err)
+		switch err.Error() {
+		case "validation failed":
+			utils.ValidationFailedResponse(c, "Invalid upload data", err)
+		case "direct uploads not available":
+			utils.ErrorResponse(c, http.StatusNotImplemented, "Direct uploads are not available, upload through the API instead", nil)
+		case "invalid file type":
+			utils.BadRequestResponse(c, "Invalid file type")
+		case "file too large":
+			utils.BadRequestResponse(c, "File size exceeds limit")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to create upload")
+		}
+		return
+	}
+
+	utils.CreatedResponse(c, "Upload created successfully", intent)
+}
+
+// CompleteUpload turns a direct upload into media once the file is in
+// storage
+func (mc *MessageController) CompleteUpload(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.MediaUploadCompleteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BindErrorResponse(c, "Invalid request body", err)
+		return
+	}
+
+	media, err := mc.messageService.CompleteMediaUpload(c.Request.Context(), userID, req)
+	if err != nil {
+		logrus.Errorf("Complete upload failed: %v", err)
+		if utils.IsProviderUnavailable(err) {
+			utils.ProviderUnavailableResponse(c, err)
+			return
+		}
+		switch err.Error() {
+		case "validation failed":
+			utils.ValidationFailedResponse(c, "Invalid upload data", err)
+		case "direct uploads not available":
+			utils.ErrorResponse(c, http.StatusNotImplemented, "Direct uploads are not available, upload through the API instead", nil)
+		case "invalid upload ID", "upload not found":
+			utils.NotFoundResponse(c, "Upload")
+		case "upload not received":
+			utils.BadRequestResponse(c, "The file has not been uploaded yet")
+		case "upload expired":
+			utils.ErrorResponse(c, http.StatusGone, "The upload has expired, start a new one", nil)
+		case "upload already completed":
+			utils.ConflictResponse(c, "The upload has already been completed")
+		case "upload in progress":
+			utils.ConflictResponse(c, "The upload is already being completed")
+		case "upload rejected", "upload does not match":
+			utils.BadRequestResponse(c, "The uploaded file does not match what was declared")
+		case "file too large":
+			utils.BadRequestResponse(c, "File size exceeds limit")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to complete upload")
+		}
+		return
+	}
+
+	utils.CreatedResponse(c, "Media uploaded successfully", media)
+}
+
 // GetMedia gets media by ID
 func (mc *MessageController) GetMedia(c *gin.Context) {
 	userID := c.GetString("userID")
@@ -591,6 +789,63 @@ func (mc *MessageController) GetMedia(c *gin.Context) {
 	utils.SuccessResponse(c, "Media retrieved successfully", media)
 }
 
+// StreamMedia serves a media file with range support, so video can seek.
+// Signed stream URLs can be cached by the client until they expire.
+func (mc *MessageController) StreamMedia(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	mediaID := c.Param("mediaId")
+	if mediaID == "" {
+		utils.BadRequestResponse(c, "Media ID is required")
+		return
+	}
+
+	cacheControl := "private, no-cache"
+	if expires := c.Query("expires"); expires != "" {
+		err := utils.VerifySignedURL(services.MediaStreamPath(mediaID), expires, c.Query("signature"))
+		if err != nil {
+			switch err.Error() {
+			case "link expired":
+				utils.ErrorResponse(c, http.StatusGone, "Media link has expired", nil)
+			default:
+				utils.ForbiddenResponse(c, "Invalid media link")
+			}
+			return
+		}
+
+		expiresAt, _ := strconv.ParseInt(expires, 10, 64)
+		cacheControl = fmt.Sprintf("private, max-age=%d", expiresAt-time.Now().Unix())
+	}
+
+	stream, err := mc.messageService.GetMediaStream(c.Request.Context(), userID, mediaID)
+	if err != nil {
+		switch err.Error() {
+		case "media not found":
+			utils.NotFoundResponse(c, "Media")
+		case "access denied":
+			utils.ForbiddenResponse(c, "You don't have access to this media")
+		default:
+			logrus.Errorf("Stream media failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to stream media")
+		}
+		return
+	}
+	defer stream.File.Close()
+
+	c.Header("Cache-Control", cacheControl)
+	c.Header("Accept-Ranges", "bytes")
+	if stream.ContentType != "" {
+		c.Header("Content-Type", stream.ContentType)
+	}
+
+	// ServeContent answers range requests with 206 and the requested bytes
+	http.ServeContent(c.Writer, c.Request, stream.Name, stream.ModTime, stream.File)
+}
+
 // DeleteMedia deletes media
 func (mc *MessageController) DeleteMedia(c *gin.Context) {
 	userID := c.GetString("userID")
@@ -704,31 +959,35 @@ func (mc *MessageController) SearchMessages(c *gin.Context) {
 		return
 	}
 
-	query := c.Query("q")
-	if query == "" {
-		utils.BadRequestResponse(c, "Search query is required")
-		return
-	}
-
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
-	messageType := c.Query("type")
-	dateFrom := c.Query("dateFrom")
-	dateTo := c.Query("dateTo")
+	pagination := utils.ParsePagination(c)
 
+	// Text query is optional when searching by sender, type or date
 	req := models.SearchMessagesRequest{
-		Query:       query,
-		Page:        page,
-		PageSize:    pageSize,
-		MessageType: messageType,
-		DateFrom:    dateFrom,
-		DateTo:      dateTo,
+		Query:       c.Query("q"),
+		Page:        pagination.Page,
+		PageSize:    pagination.PageSize,
+		SenderID:    c.Query("senderId"),
+		MessageType: c.Query("type"),
+		MediaType:   c.Query("mediaType"),
+		DateFrom:    c.Query("dateFrom"),
+		DateTo:      c.Query("dateTo"),
 	}
 
 	results, err := mc.messageService.SearchMessages(c.Request.Context(), userID, req)
 	if err != nil {
 		logrus.Errorf("Search messages failed: %v", err)
-		utils.InternalServerErrorResponse(c, "Failed to search messages")
+		switch err.Error() {
+		case "search query or filter is required":
+			utils.BadRequestResponse(c, "Search query or filter is required")
+		case "invalid sender ID":
+			utils.BadRequestResponse(c, "Invalid sender ID")
+		case "invalid media type":
+			utils.BadRequestResponse(c, "Media type must be image, video, audio or document")
+		case "invalid date range":
+			utils.BadRequestResponse(c, "Dates must be YYYY-MM-DD with dateFrom not after dateTo")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to search messages")
+		}
 		return
 	}
 
@@ -755,14 +1014,13 @@ func (mc *MessageController) SearchInCircle(c *gin.Context) {
 		return
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+	pagination := utils.ParsePagination(c)
 
 	req := models.SearchInCircleRequest{
 		CircleID: circleID,
 		Query:    query,
-		Page:     page,
-		PageSize: pageSize,
+		Page:     pagination.Page,
+		PageSize: pagination.PageSize,
 	}
 
 	results, err := mc.messageService.SearchInCircle(c.Request.Context(), userID, req)
@@ -791,14 +1049,13 @@ func (mc *MessageController) SearchMedia(c *gin.Context) {
 	}
 
 	mediaType := c.Query("type")
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+	pagination := utils.ParsePagination(c)
 	circleID := c.Query("circleId")
 
 	req := models.SearchMediaRequest{
 		MediaType: mediaType,
-		Page:      page,
-		PageSize:  pageSize,
+		Page:      pagination.Page,
+		PageSize:  pagination.PageSize,
 		CircleID:  circleID,
 	}
 
@@ -820,13 +1077,12 @@ func (mc *MessageController) SearchMentions(c *gin.Context) {
 		return
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+	pagination := utils.ParsePagination(c)
 	circleID := c.Query("circleId")
 
 	req := models.SearchMentionsRequest{
-		Page:     page,
-		PageSize: pageSize,
+		Page:     pagination.Page,
+		PageSize: pagination.PageSize,
 		CircleID: circleID,
 	}
 
@@ -848,14 +1104,13 @@ func (mc *MessageController) SearchLinks(c *gin.Context) {
 		return
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+	pagination := utils.ParsePagination(c)
 	circleID := c.Query("circleId")
 	domain := c.Query("domain")
 
 	req := models.SearchLinksRequest{
-		Page:     page,
-		PageSize: pageSize,
+		Page:     pagination.Page,
+		PageSize: pagination.PageSize,
 		CircleID: circleID,
 		Domain:   domain,
 	}
@@ -878,14 +1133,13 @@ func (mc *MessageController) SearchFiles(c *gin.Context) {
 		return
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+	pagination := utils.ParsePagination(c)
 	fileType := c.Query("fileType")
 	circleID := c.Query("circleId")
 
 	req := models.SearchFilesRequest{
-		Page:     page,
-		PageSize: pageSize,
+		Page:     pagination.Page,
+		PageSize: pagination.PageSize,
 		FileType: fileType,
 		CircleID: circleID,
 	}
@@ -990,6 +1244,37 @@ func (mc *MessageController) BulkMarkAsRead(c *gin.Context) {
 	})
 }
 
+// MarkAsDelivered records that a message's push reached the user's device
+func (mc *MessageController) MarkAsDelivered(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	messageID := c.Param("messageId")
+	if messageID == "" {
+		utils.BadRequestResponse(c, "Message ID is required")
+		return
+	}
+
+	err := mc.messageService.MarkAsDelivered(c.Request.Context(), userID, messageID)
+	if err != nil {
+		logrus.Errorf("Mark as delivered failed: %v", err)
+		switch err.Error() {
+		case "message not found":
+			utils.NotFoundResponse(c, "Message")
+		case "access denied":
+			utils.ForbiddenResponse(c, "You don't have access to this message")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to mark message as delivered")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Message marked as delivered", nil)
+}
+
 // GetDeliveryStatus gets delivery status of a message
 func (mc *MessageController) GetDeliveryStatus(c *gin.Context) {
 	userID := c.GetString("userID")
@@ -1209,13 +1494,12 @@ func (mc *MessageController) GetScheduledMessages(c *gin.Context) {
 		return
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+	pagination := utils.ParsePagination(c)
 	status := c.Query("status") // pending, sent, cancelled
 
 	req := models.GetScheduledMessagesRequest{
-		Page:     page,
-		PageSize: pageSize,
+		Page:     pagination.Page,
+		PageSize: pagination.PageSize,
 		Status:   status,
 	}
 
@@ -1291,6 +1575,10 @@ func (mc *MessageController) UpdateScheduledMessage(c *gin.Context) {
 		case "cannot update sent message":
 			utils.BadRequestResponse(c, "Cannot update already sent message")
 		case "validation failed":
+			if reason := utils.ValidationFailureReason(err); reason != "" {
+				utils.BadRequestResponse(c, "Invalid message data: "+reason)
+				return
+			}
 			utils.BadRequestResponse(c, "Invalid message data or schedule time")
 		default:
 			utils.InternalServerErrorResponse(c, "Failed to update scheduled message")
@@ -1344,13 +1632,12 @@ func (mc *MessageController) GetMessageTemplates(c *gin.Context) {
 		return
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+	pagination := utils.ParsePagination(c)
 	category := c.Query("category")
 
 	req := models.GetTemplatesRequest{
-		Page:     page,
-		PageSize: pageSize,
+		Page:     pagination.Page,
+		PageSize: pagination.PageSize,
 		Category: category,
 	}
 
@@ -1567,6 +1854,8 @@ func (mc *MessageController) ExportCircleMessages(c *gin.Context) {
 			utils.NotFoundResponse(c, "Circle")
 		case "access denied":
 			utils.ForbiddenResponse(c, "You don't have access to this circle")
+		case "export permission required":
+			utils.ExportPermissionRequiredResponse(c, circleID)
 		case "validation failed":
 			utils.BadRequestResponse(c, "Invalid export parameters")
 		default:
@@ -1578,6 +1867,53 @@ func (mc *MessageController) ExportCircleMessages(c *gin.Context) {
 	utils.AcceptedResponse(c, "Message export started successfully", exportJob)
 }
 
+// ExportSearchResults exports the circle messages matching a search
+func (mc *MessageController) ExportSearchResults(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	circleID := c.Param("circleId")
+	if circleID == "" {
+		utils.BadRequestResponse(c, "Circle ID is required")
+		return
+	}
+
+	var req models.ExportSearchResultsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request body")
+		return
+	}
+
+	exportJob, err := mc.messageService.ExportSearchResults(c.Request.Context(), userID, circleID, req)
+	if err != nil {
+		logrus.Errorf("Export search results failed: %v", err)
+		switch err.Error() {
+		case "access denied":
+			utils.ForbiddenResponse(c, "You don't have access to this circle")
+		case "export permission required":
+			utils.ExportPermissionRequiredResponse(c, circleID)
+		case "validation failed":
+			utils.BadRequestResponse(c, "Invalid export parameters")
+		case "search query or filter is required":
+			utils.BadRequestResponse(c, "Search query or filter is required")
+		case "invalid sender ID":
+			utils.BadRequestResponse(c, "Invalid sender ID")
+		case "invalid media type":
+			utils.BadRequestResponse(c, "Media type must be image, video, audio or document")
+		case "invalid date range":
+			utils.BadRequestResponse(c, "Dates must be YYYY-MM-DD with dateFrom not after dateTo")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to start search results export")
+		}
+		return
+	}
+
+	utils.AcceptedResponse(c, "Search results export started successfully", exportJob)
+}
+
 // GetExportStatus gets the status of a message export
 func (mc *MessageController) GetExportStatus(c *gin.Context) {
 	userID := c.GetString("userID")
@@ -1623,12 +1959,21 @@ func (mc *MessageController) DownloadMessageExport(c *gin.Context) {
 		return
 	}
 
-	exportData, err := mc.messageService.DownloadMessageExport(c.Request.Context(), userID, exportID)
+	// Multi-file exports are downloaded one part at a time
+	part, err := strconv.Atoi(c.DefaultQuery("part", "1"))
+	if err != nil || part < 1 {
+		utils.BadRequestResponse(c, "Invalid part number")
+		return
+	}
+
+	exportData, err := mc.messageService.DownloadMessageExport(c.Request.Context(), userID, exportID, part)
 	if err != nil {
 		logrus.Errorf("Download message export failed: %v", err)
 		switch err.Error() {
 		case "export not found":
 			utils.NotFoundResponse(c, "Export")
+		case "export part not found":
+			utils.NotFoundResponse(c, "Export part")
 		case "export not ready":
 			utils.BadRequestResponse(c, "Export is not ready for download")
 		case "access denied":
@@ -1645,6 +1990,43 @@ func (mc *MessageController) DownloadMessageExport(c *gin.Context) {
 	c.Data(200, exportData.ContentType, exportData.Data)
 }
 
+// DownloadMessageExportManifest downloads the manifest listing an export's
+// files, record count and schema version
+func (mc *MessageController) DownloadMessageExportManifest(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	manifest, err := mc.messageService.DownloadMessageExportManifest(c.Request.Context(), userID, c.Param("exportId"))
+	if err != nil {
+		handleExportManifestError(c, err)
+		return
+	}
+
+	c.Header("Content-Disposition", "attachment; filename="+manifest.Filename)
+	c.Data(200, manifest.ContentType, manifest.Data)
+}
+
+// handleExportManifestError maps the errors of the export manifest
+// downloads, shared by message and place exports
+func handleExportManifestError(c *gin.Context, err error) {
+	switch err.Error() {
+	case "export not found":
+		utils.NotFoundResponse(c, "Export")
+	case "export manifest not found":
+		utils.NotFoundResponse(c, "Export manifest")
+	case "export not ready":
+		utils.BadRequestResponse(c, "Export is not ready for download")
+	case "access denied":
+		utils.ForbiddenResponse(c, "You can only download your own exports")
+	default:
+		logrus.Errorf("Download export manifest failed: %v", err)
+		utils.InternalServerErrorResponse(c, "Failed to download export manifest")
+	}
+}
+
 // ImportMessages imports messages from a file
 func (mc *MessageController) ImportMessages(c *gin.Context) {
 	userID := c.GetString("userID")
@@ -1678,6 +2060,10 @@ func (mc *MessageController) ImportMessages(c *gin.Context) {
 		switch err.Error() {
 		case "invalid file format":
 			utils.BadRequestResponse(c, "Invalid file format")
+		case "file too large":
+			utils.BadRequestResponse(c, "Import file is too large")
+		case "validation failed":
+			utils.BadRequestResponse(c, "Incompatible export: "+utils.ValidationFailureReason(err))
 		case "circle not found":
 			utils.NotFoundResponse(c, "Circle")
 		case "access denied":
@@ -1818,14 +2204,13 @@ func (mc *MessageController) GetMessageReports(c *gin.Context) {
 		return
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+	pagination := utils.ParsePagination(c)
 	status := c.Query("status")     // pending, reviewed, resolved
 	severity := c.Query("severity") // low, medium, high
 
 	req := models.GetReportsRequest{
-		Page:     page,
-		PageSize: pageSize,
+		Page:     pagination.Page,
+		PageSize: pagination.PageSize,
 		Status:   status,
 		Severity: severity,
 	}
@@ -2046,6 +2431,43 @@ func (mc *MessageController) GetCircleMessageStats(c *gin.Context) {
 	utils.SuccessResponse(c, "Circle message statistics retrieved successfully", stats)
 }
 
+// GetCircleReactionStats gets a circle's reaction highlights
+func (mc *MessageController) GetCircleReactionStats(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	circleID := c.Param("circleId")
+	if circleID == "" {
+		utils.BadRequestResponse(c, "Circle ID is required")
+		return
+	}
+
+	period := c.DefaultQuery("period", "30d")
+
+	stats, err := mc.messageService.GetCircleReactionStats(c.Request.Context(), userID, circleID, period)
+	if err != nil {
+		logrus.Errorf("Get circle reaction stats failed: %v", err)
+		switch err.Error() {
+		case "invalid circle ID":
+			utils.BadRequestResponse(c, "Invalid circle ID")
+		case "circle not found":
+			utils.NotFoundResponse(c, "Circle")
+		case "access denied":
+			utils.ForbiddenResponse(c, "You don't have access to this circle")
+		case "invalid period":
+			utils.BadRequestResponse(c, "Period must be 1d, 7d, 30d or 90d")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to get reaction statistics")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Reaction statistics retrieved successfully", stats)
+}
+
 // GetMessageActivity gets message activity data
 func (mc *MessageController) GetMessageActivity(c *gin.Context) {
 	userID := c.GetString("userID")
@@ -2161,14 +2583,13 @@ func (mc *MessageController) GetAutomationRules(c *gin.Context) {
 		return
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+	pagination := utils.ParsePagination(c)
 	ruleType := c.Query("type") // auto_reply, keyword_trigger, schedule
 	status := c.Query("status") // active, inactive
 
 	req := models.GetAutomationRulesRequest{
-		Page:     page,
-		PageSize: pageSize,
+		Page:     pagination.Page,
+		PageSize: pagination.PageSize,
 		RuleType: ruleType,
 		Status:   status,
 	}
@@ -2335,13 +2756,12 @@ func (mc *MessageController) GetDrafts(c *gin.Context) {
 		return
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+	pagination := utils.ParsePagination(c)
 	circleID := c.Query("circleId")
 
 	req := models.GetDraftsRequest{
-		Page:     page,
-		PageSize: pageSize,
+		Page:     pagination.Page,
+		PageSize: pagination.PageSize,
 		CircleID: circleID,
 	}
 
@@ -2448,6 +2868,10 @@ func (mc *MessageController) UpdateDraft(c *gin.Context) {
 		case "access denied":
 			utils.ForbiddenResponse(c, "You can only update your own drafts")
 		case "validation failed":
+			if reason := utils.ValidationFailureReason(err); reason != "" {
+				utils.BadRequestResponse(c, "Invalid draft data: "+reason)
+				return
+			}
 			utils.BadRequestResponse(c, "Invalid draft data")
 		default:
 			utils.InternalServerErrorResponse(c, "Failed to update draft")
@@ -5,6 +5,8 @@ import (
 	"ftrack/services"
 	"ftrack/utils"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
@@ -44,17 +46,36 @@ func (nc *NotificationController) GetNotifications(c *gin.Context) {
 		return
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+	pagination := utils.ParsePagination(c)
 	notificationType := c.Query("type")
 	status := c.Query("status")
 
 	req := models.GetNotificationsRequest{
 		UserID:   userID,
-		Page:     page,
-		PageSize: pageSize,
+		Page:     pagination.Page,
+		PageSize: pagination.PageSize,
 		Type:     notificationType,
 		Status:   status,
+		CircleID: c.Query("circleId"),
+		Priority: c.Query("priority"),
+		GroupBy:  c.Query("groupBy"),
+	}
+
+	// Grouped, the page goes over sections instead of notifications
+	if req.GroupBy != "" {
+		grouped, err := nc.notificationService.GetGroupedNotifications(c.Request.Context(), req)
+		if err != nil {
+			if err.Error() == "validation failed" {
+				utils.BadRequestResponse(c, utils.ValidationFailureReason(err))
+				return
+			}
+			logrus.Errorf("Get grouped notifications failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to get notifications")
+			return
+		}
+
+		utils.SuccessResponse(c, "Notifications retrieved successfully", grouped)
+		return
 	}
 
 	notifications, err := nc.notificationService.GetNotifications(c.Request.Context(), req)
@@ -67,6 +88,76 @@ func (nc *NotificationController) GetNotifications(c *gin.Context) {
 	utils.SuccessResponse(c, "Notifications retrieved successfully", notifications)
 }
 
+// SearchNotifications searches the user's notifications by title and message
+func (nc *NotificationController) SearchNotifications(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	pagination := utils.ParsePagination(c)
+
+	from, err := parseSearchDate(c.Query("from"), false)
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid from date, expected RFC 3339 or YYYY-MM-DD")
+		return
+	}
+	to, err := parseSearchDate(c.Query("to"), true)
+	if err != nil {
+		utils.BadRequestResponse(c, "Invalid to date, expected RFC 3339 or YYYY-MM-DD")
+		return
+	}
+
+	req := models.SearchNotificationsRequest{
+		UserID:          userID,
+		Query:           c.Query("q"),
+		Type:            c.Query("type"),
+		Status:          c.Query("status"),
+		From:            from,
+		To:              to,
+		ExcludeArchived: c.Query("excludeArchived") == "true",
+		ExcludeSnoozed:  c.Query("excludeSnoozed") == "true",
+		Page:            pagination.Page,
+		PageSize:        pagination.PageSize,
+	}
+
+	results, err := nc.notificationService.SearchNotifications(c.Request.Context(), req)
+	if err != nil {
+		switch err.Error() {
+		case "validation failed":
+			utils.BadRequestResponse(c, "Invalid search: "+utils.ValidationFailureReason(err))
+		default:
+			logrus.Errorf("Search notifications failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to search notifications")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Notifications searched successfully", results)
+}
+
+// parseSearchDate parses an RFC 3339 time or a date. A date as the end of a
+// range includes the whole day.
+func parseSearchDate(value string, endOfDay bool) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, err
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return &t, nil
+}
+
 // GetNotification gets a specific notification by ID
 // @Summary Get notification
 // @Description Get a specific notification by ID
@@ -255,6 +346,34 @@ func (nc *NotificationController) BulkMarkAsRead(c *gin.Context) {
 	utils.SuccessResponse(c, "Notifications marked as read", result)
 }
 
+// MarkGroupRead marks every notification of an inbox group as read
+func (nc *NotificationController) MarkGroupRead(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.MarkGroupReadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request body")
+		return
+	}
+
+	result, err := nc.notificationService.MarkGroupRead(c.Request.Context(), userID, req)
+	if err != nil {
+		if err.Error() == "validation failed" {
+			utils.BadRequestResponse(c, utils.ValidationFailureReason(err))
+			return
+		}
+		logrus.Errorf("Mark notification group as read failed: %v", err)
+		utils.InternalServerErrorResponse(c, "Failed to mark notifications as read")
+		return
+	}
+
+	utils.SuccessResponse(c, "Notifications marked as read", result)
+}
+
 // BulkMarkAsUnread marks multiple notifications as unread
 func (nc *NotificationController) BulkMarkAsUnread(c *gin.Context) {
 	userID := c.GetString("userID")
@@ -327,6 +446,70 @@ func (nc *NotificationController) BulkArchiveNotifications(c *gin.Context) {
 	utils.SuccessResponse(c, "Notifications archived successfully", result)
 }
 
+// BulkDeleteByFilter deletes the notifications matching a filter
+func (nc *NotificationController) BulkDeleteByFilter(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.BulkNotificationFilterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request body")
+		return
+	}
+
+	result, err := nc.notificationService.DeleteNotificationsByFilter(c.Request.Context(), userID, req)
+	if err != nil {
+		if err.Error() == "validation failed" {
+			utils.BadRequestResponse(c, utils.ValidationFailureReason(err))
+			return
+		}
+		logrus.Errorf("Bulk delete notifications by filter failed: %v", err)
+		utils.InternalServerErrorResponse(c, "Failed to delete notifications")
+		return
+	}
+
+	if result.DryRun {
+		utils.SuccessResponse(c, "Notifications counted", result)
+		return
+	}
+	utils.SuccessResponse(c, "Notifications deleted successfully", result)
+}
+
+// BulkArchiveByFilter archives the notifications matching a filter
+func (nc *NotificationController) BulkArchiveByFilter(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.BulkNotificationFilterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request body")
+		return
+	}
+
+	result, err := nc.notificationService.ArchiveNotificationsByFilter(c.Request.Context(), userID, req)
+	if err != nil {
+		if err.Error() == "validation failed" {
+			utils.BadRequestResponse(c, utils.ValidationFailureReason(err))
+			return
+		}
+		logrus.Errorf("Bulk archive notifications by filter failed: %v", err)
+		utils.InternalServerErrorResponse(c, "Failed to archive notifications")
+		return
+	}
+
+	if result.DryRun {
+		utils.SuccessResponse(c, "Notifications counted", result)
+		return
+	}
+	utils.SuccessResponse(c, "Notifications archived successfully", result)
+}
+
 // ========================
 // Filtering Operations
 // ========================
@@ -339,10 +522,9 @@ func (nc *NotificationController) GetUnreadNotifications(c *gin.Context) {
 		return
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+	pagination := utils.ParsePagination(c)
 
-	notifications, err := nc.notificationService.GetUnreadNotifications(c.Request.Context(), userID, page, pageSize)
+	notifications, err := nc.notificationService.GetUnreadNotifications(c.Request.Context(), userID, pagination.Page, pagination.PageSize)
 	if err != nil {
 		logrus.Errorf("Get unread notifications failed: %v", err)
 		utils.InternalServerErrorResponse(c, "Failed to get unread notifications")
@@ -360,10 +542,9 @@ func (nc *NotificationController) GetReadNotifications(c *gin.Context) {
 		return
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+	pagination := utils.ParsePagination(c)
 
-	notifications, err := nc.notificationService.GetReadNotifications(c.Request.Context(), userID, page, pageSize)
+	notifications, err := nc.notificationService.GetReadNotifications(c.Request.Context(), userID, pagination.Page, pagination.PageSize)
 	if err != nil {
 		logrus.Errorf("Get read notifications failed: %v", err)
 		utils.InternalServerErrorResponse(c, "Failed to get read notifications")
@@ -387,10 +568,9 @@ func (nc *NotificationController) GetNotificationsByType(c *gin.Context) {
 		return
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+	pagination := utils.ParsePagination(c)
 
-	notifications, err := nc.notificationService.GetNotificationsByType(c.Request.Context(), userID, notificationType, page, pageSize)
+	notifications, err := nc.notificationService.GetNotificationsByType(c.Request.Context(), userID, notificationType, pagination.Page, pagination.PageSize)
 	if err != nil {
 		logrus.Errorf("Get notifications by type failed: %v", err)
 		utils.InternalServerErrorResponse(c, "Failed to get notifications by type")
@@ -414,10 +594,9 @@ func (nc *NotificationController) GetNotificationsByPriority(c *gin.Context) {
 		return
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+	pagination := utils.ParsePagination(c)
 
-	notifications, err := nc.notificationService.GetNotificationsByPriority(c.Request.Context(), userID, priority, page, pageSize)
+	notifications, err := nc.notificationService.GetNotificationsByPriority(c.Request.Context(), userID, priority, pagination.Page, pagination.PageSize)
 	if err != nil {
 		logrus.Errorf("Get notifications by priority failed: %v", err)
 		utils.InternalServerErrorResponse(c, "Failed to get notifications by priority")
@@ -441,10 +620,9 @@ func (nc *NotificationController) GetCircleNotifications(c *gin.Context) {
 		return
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+	pagination := utils.ParsePagination(c)
 
-	notifications, err := nc.notificationService.GetCircleNotifications(c.Request.Context(), userID, circleID, page, pageSize)
+	notifications, err := nc.notificationService.GetCircleNotifications(c.Request.Context(), userID, circleID, pagination.Page, pagination.PageSize)
 	if err != nil {
 		logrus.Errorf("Get circle notifications failed: %v", err)
 		switch err.Error() {
@@ -469,10 +647,9 @@ func (nc *NotificationController) GetArchivedNotifications(c *gin.Context) {
 		return
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+	pagination := utils.ParsePagination(c)
 
-	notifications, err := nc.notificationService.GetArchivedNotifications(c.Request.Context(), userID, page, pageSize)
+	notifications, err := nc.notificationService.GetArchivedNotifications(c.Request.Context(), userID, pagination.Page, pagination.PageSize)
 	if err != nil {
 		logrus.Errorf("Get archived notifications failed: %v", err)
 		utils.InternalServerErrorResponse(c, "Failed to get archived notifications")
@@ -523,7 +700,7 @@ func (nc *NotificationController) UpdatePushSettings(c *gin.Context) {
 		logrus.Errorf("Update push settings failed: %v", err)
 		switch err.Error() {
 		case "validation failed":
-			utils.BadRequestResponse(c, "Invalid settings data")
+			utils.BadRequestResponse(c, utils.ValidationFailureReason(err))
 		default:
 			utils.InternalServerErrorResponse(c, "Failed to update push settings")
 		}
@@ -717,7 +894,7 @@ func (nc *NotificationController) UpdateNotificationPreferences(c *gin.Context)
 		logrus.Errorf("Update notification preferences failed: %v", err)
 		switch err.Error() {
 		case "validation failed":
-			utils.BadRequestResponse(c, "Invalid preferences data")
+			utils.BadRequestResponse(c, utils.ValidationFailureReason(err))
 		default:
 			utils.InternalServerErrorResponse(c, "Failed to update notification preferences")
 		}
@@ -766,7 +943,7 @@ func (nc *NotificationController) UpdateTypePreferences(c *gin.Context) {
 		case "invalid type":
 			utils.BadRequestResponse(c, "Invalid notification type")
 		case "validation failed":
-			utils.BadRequestResponse(c, "Invalid preferences data")
+			utils.BadRequestResponse(c, utils.ValidationFailureReason(err))
 		default:
 			utils.InternalServerErrorResponse(c, "Failed to update type preferences")
 		}
@@ -1448,7 +1625,7 @@ func (nc *NotificationController) CreateNotificationRule(c *gin.Context) {
 		logrus.Errorf("Create notification rule failed: %v", err)
 		switch err.Error() {
 		case "validation failed":
-			utils.BadRequestResponse(c, "Invalid rule data")
+			utils.BadRequestResponse(c, "Invalid rule data: "+utils.ValidationFailureReason(err))
 		case "rule limit reached":
 			utils.BadRequestResponse(c, "Rule limit reached")
 		default:
@@ -1478,6 +1655,8 @@ func (nc *NotificationController) GetNotificationRule(c *gin.Context) {
 	if err != nil {
 		logrus.Errorf("Get notification rule failed: %v", err)
 		switch err.Error() {
+		case "invalid rule ID":
+			utils.BadRequestResponse(c, "Invalid rule ID")
 		case "rule not found":
 			utils.NotFoundResponse(c, "Rule")
 		case "access denied":
@@ -1515,12 +1694,14 @@ func (nc *NotificationController) UpdateNotificationRule(c *gin.Context) {
 	if err != nil {
 		logrus.Errorf("Update notification rule failed: %v", err)
 		switch err.Error() {
+		case "invalid rule ID":
+			utils.BadRequestResponse(c, "Invalid rule ID")
 		case "rule not found":
 			utils.NotFoundResponse(c, "Rule")
 		case "access denied":
 			utils.ForbiddenResponse(c, "You don't have access to this rule")
 		case "validation failed":
-			utils.BadRequestResponse(c, "Invalid rule data")
+			utils.BadRequestResponse(c, "Invalid rule data: "+utils.ValidationFailureReason(err))
 		default:
 			utils.InternalServerErrorResponse(c, "Failed to update notification rule")
 		}
@@ -1548,6 +1729,8 @@ func (nc *NotificationController) DeleteNotificationRule(c *gin.Context) {
 	if err != nil {
 		logrus.Errorf("Delete notification rule failed: %v", err)
 		switch err.Error() {
+		case "invalid rule ID":
+			utils.BadRequestResponse(c, "Invalid rule ID")
 		case "rule not found":
 			utils.NotFoundResponse(c, "Rule")
 		case "access denied":
@@ -1579,6 +1762,8 @@ func (nc *NotificationController) TestNotificationRule(c *gin.Context) {
 	if err != nil {
 		logrus.Errorf("Test notification rule failed: %v", err)
 		switch err.Error() {
+		case "invalid rule ID":
+			utils.BadRequestResponse(c, "Invalid rule ID")
 		case "rule not found":
 			utils.NotFoundResponse(c, "Rule")
 		case "access denied":
@@ -1592,6 +1777,76 @@ func (nc *NotificationController) TestNotificationRule(c *gin.Context) {
 	utils.SuccessResponse(c, "Rule test completed", result)
 }
 
+// ResolveDeliveryChannels shows which channels a notification would be
+// delivered on under the user's routing rules, without sending it
+func (nc *NotificationController) ResolveDeliveryChannels(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.ResolveChannelsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request body")
+		return
+	}
+	if req.Type == "" {
+		utils.BadRequestResponse(c, "Notification type is required")
+		return
+	}
+
+	resolution, err := nc.notificationService.ResolveDeliveryChannels(c.Request.Context(), userID, req)
+	if err != nil {
+		logrus.Errorf("Resolve delivery channels failed: %v", err)
+		utils.InternalServerErrorResponse(c, "Failed to resolve delivery channels")
+		return
+	}
+
+	utils.SuccessResponse(c, "Delivery channels resolved successfully", resolution)
+}
+
+// ExplainNotification shows how a notification would reach the user right
+// now, or at a given time, and which of their settings decided it
+func (nc *NotificationController) ExplainNotification(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	req := models.ExplainNotificationRequest{
+		Type:     c.Query("type"),
+		CircleID: c.Query("circleId"),
+		Priority: c.Query("priority"),
+	}
+	if channels := c.Query("channels"); channels != "" {
+		req.DeliveryChannels = strings.Split(channels, ",")
+	}
+	if at := c.Query("at"); at != "" {
+		t, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			utils.BadRequestResponse(c, "Invalid at, expected RFC 3339")
+			return
+		}
+		req.At = t
+	}
+
+	decision, err := nc.notificationService.ExplainNotificationDelivery(c.Request.Context(), userID, req)
+	if err != nil {
+		switch err.Error() {
+		case "validation failed":
+			utils.BadRequestResponse(c, utils.ValidationFailureReason(err))
+		default:
+			logrus.Errorf("Explain notification failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to explain notification delivery")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Notification delivery explained successfully", decision)
+}
+
 // ========================
 // Do Not Disturb
 // ========================
@@ -1693,7 +1948,7 @@ func (nc *NotificationController) UpdateQuietHours(c *gin.Context) {
 		logrus.Errorf("Update quiet hours failed: %v", err)
 		switch err.Error() {
 		case "validation failed":
-			utils.BadRequestResponse(c, "Invalid quiet hours data")
+			utils.BadRequestResponse(c, utils.ValidationFailureReason(err))
 		default:
 			utils.InternalServerErrorResponse(c, "Failed to update quiet hours")
 		}
@@ -1992,6 +2247,25 @@ func (nc *NotificationController) GetDeliveryStats(c *gin.Context) {
 	utils.SuccessResponse(c, "Delivery stats retrieved successfully", stats)
 }
 
+// GetSendTimeProfile gets when the user usually reads notifications, which
+// non-urgent notifications are held for
+func (nc *NotificationController) GetSendTimeProfile(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	profile, err := nc.notificationService.GetSendTimeProfile(c.Request.Context(), userID)
+	if err != nil {
+		logrus.Errorf("Get send-time profile failed: %v", err)
+		utils.InternalServerErrorResponse(c, "Failed to get send-time profile")
+		return
+	}
+
+	utils.SuccessResponse(c, "Send-time profile retrieved successfully", profile)
+}
+
 // GetEngagementStats gets notification engagement statistics
 func (nc *NotificationController) GetEngagementStats(c *gin.Context) {
 	userID := c.GetString("userID")
@@ -2067,16 +2341,15 @@ func (nc *NotificationController) GetNotificationHistory(c *gin.Context) {
 		return
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "50"))
+	pagination := utils.ParseHistoryPagination(c)
 	startDate := c.Query("startDate")
 	endDate := c.Query("endDate")
 	notificationType := c.Query("type")
 
 	req := models.GetHistoryRequest{
 		UserID:    userID,
-		Page:      page,
-		PageSize:  pageSize,
+		Page:      pagination.Page,
+		PageSize:  pagination.PageSize,
 		StartDate: startDate,
 		EndDate:   endDate,
 		Type:      notificationType,
@@ -2522,3 +2795,242 @@ func (nc *NotificationController) UnpinNotification(c *gin.Context) {
 
 	utils.SuccessResponse(c, "Notification unpinned successfully", nil)
 }
+
+// ========================
+// Broadcasts
+// ========================
+
+// CreateBroadcast schedules a notification to all users, a circle or a list
+// of users. It returns the broadcast to track its delivery with.
+func (nc *NotificationController) CreateBroadcast(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.CreateBroadcastRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request body")
+		return
+	}
+
+	broadcast, err := nc.notificationService.CreateBroadcast(c.Request.Context(), userID, req)
+	if err != nil {
+		switch err.Error() {
+		case "validation failed":
+			utils.BadRequestResponse(c, "Invalid broadcast: "+utils.ValidationFailureReason(err))
+		default:
+			logrus.Errorf("Create broadcast failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to create broadcast")
+		}
+		return
+	}
+
+	utils.AcceptedResponse(c, "Broadcast scheduled successfully", broadcast)
+}
+
+// GetBroadcast gets a broadcast with its delivery stats
+func (nc *NotificationController) GetBroadcast(c *gin.Context) {
+	broadcastID := c.Param("broadcastId")
+	if broadcastID == "" {
+		utils.BadRequestResponse(c, "Broadcast ID is required")
+		return
+	}
+
+	broadcast, err := nc.notificationService.GetBroadcast(c.Request.Context(), broadcastID)
+	if err != nil {
+		switch err.Error() {
+		case "invalid broadcast ID":
+			utils.BadRequestResponse(c, "Invalid broadcast ID")
+		case "broadcast not found":
+			utils.NotFoundResponse(c, "Broadcast")
+		default:
+			logrus.Errorf("Get broadcast failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to get broadcast")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Broadcast retrieved successfully", broadcast)
+}
+
+// CancelBroadcast stops a broadcast that hasn't finished
+func (nc *NotificationController) CancelBroadcast(c *gin.Context) {
+	broadcastID := c.Param("broadcastId")
+	if broadcastID == "" {
+		utils.BadRequestResponse(c, "Broadcast ID is required")
+		return
+	}
+
+	broadcast, err := nc.notificationService.CancelBroadcast(c.Request.Context(), broadcastID)
+	if err != nil {
+		switch err.Error() {
+		case "invalid broadcast ID":
+			utils.BadRequestResponse(c, "Invalid broadcast ID")
+		case "broadcast not found":
+			utils.NotFoundResponse(c, "Broadcast")
+		case "broadcast already finished":
+			utils.ConflictResponse(c, "Broadcast has already finished")
+		default:
+			logrus.Errorf("Cancel broadcast failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to cancel broadcast")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Broadcast cancelled successfully", broadcast)
+}
+
+// GetBroadcastDeliveries lists what happened to each targeted user
+func (nc *NotificationController) GetBroadcastDeliveries(c *gin.Context) {
+	broadcastID := c.Param("broadcastId")
+	if broadcastID == "" {
+		utils.BadRequestResponse(c, "Broadcast ID is required")
+		return
+	}
+
+	var req models.GetBroadcastDeliveriesRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid query parameters")
+		return
+	}
+	req.BroadcastID = broadcastID
+
+	deliveries, err := nc.notificationService.GetBroadcastDeliveries(c.Request.Context(), req)
+	if err != nil {
+		switch err.Error() {
+		case "validation failed":
+			utils.BadRequestResponse(c, "Invalid request: "+utils.ValidationFailureReason(err))
+		case "invalid broadcast ID":
+			utils.BadRequestResponse(c, "Invalid broadcast ID")
+		case "broadcast not found":
+			utils.NotFoundResponse(c, "Broadcast")
+		default:
+			logrus.Errorf("Get broadcast deliveries failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to get broadcast deliveries")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Broadcast deliveries retrieved successfully", deliveries)
+}
+
+// CreateNotificationExperiment schedules an experiment on the text of a
+// system notification template
+func (nc *NotificationController) CreateNotificationExperiment(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.CreateNotificationExperimentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request body")
+		return
+	}
+
+	experiment, err := nc.notificationService.CreateExperiment(c.Request.Context(), userID, req)
+	if err != nil {
+		switch err.Error() {
+		case "validation failed":
+			utils.BadRequestResponse(c, "Invalid experiment: "+utils.ValidationFailureReason(err))
+		case "experiment overlaps another":
+			utils.ConflictResponse(c, "Another experiment on this template overlaps these dates")
+		default:
+			logrus.Errorf("Create notification experiment failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to create experiment")
+		}
+		return
+	}
+
+	utils.CreatedResponse(c, "Experiment scheduled successfully", experiment)
+}
+
+func (nc *NotificationController) GetNotificationExperiments(c *gin.Context) {
+	experiments, err := nc.notificationService.GetExperiments(c.Request.Context())
+	if err != nil {
+		logrus.Errorf("Get notification experiments failed: %v", err)
+		utils.InternalServerErrorResponse(c, "Failed to get experiments")
+		return
+	}
+
+	utils.SuccessResponse(c, "Experiments retrieved successfully", experiments)
+}
+
+func (nc *NotificationController) GetNotificationExperiment(c *gin.Context) {
+	experiment, err := nc.notificationService.GetExperiment(c.Request.Context(), c.Param("experimentId"))
+	if err != nil {
+		switch err.Error() {
+		case "invalid experiment ID":
+			utils.BadRequestResponse(c, "Invalid experiment ID")
+		case "experiment not found":
+			utils.NotFoundResponse(c, "Experiment")
+		default:
+			logrus.Errorf("Get notification experiment failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to get experiment")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Experiment retrieved successfully", experiment)
+}
+
+// GetNotificationExperimentResults reports delivered, opened and acted on
+// notifications of each variant
+func (nc *NotificationController) GetNotificationExperimentResults(c *gin.Context) {
+	results, err := nc.notificationService.GetExperimentResults(c.Request.Context(), c.Param("experimentId"))
+	if err != nil {
+		switch err.Error() {
+		case "invalid experiment ID":
+			utils.BadRequestResponse(c, "Invalid experiment ID")
+		case "experiment not found":
+			utils.NotFoundResponse(c, "Experiment")
+		default:
+			logrus.Errorf("Get notification experiment results failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to get experiment results")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Experiment results retrieved successfully", results)
+}
+
+// EndNotificationExperiment ends an experiment and promotes its winning
+// variant to the template's base text
+func (nc *NotificationController) EndNotificationExperiment(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.EndNotificationExperimentRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.BadRequestResponse(c, "Invalid request body")
+			return
+		}
+	}
+
+	results, err := nc.notificationService.EndExperiment(c.Request.Context(), userID, c.Param("experimentId"), req)
+	if err != nil {
+		switch err.Error() {
+		case "validation failed":
+			utils.BadRequestResponse(c, "Invalid winner: "+utils.ValidationFailureReason(err))
+		case "invalid experiment ID":
+			utils.BadRequestResponse(c, "Invalid experiment ID")
+		case "experiment not found":
+			utils.NotFoundResponse(c, "Experiment")
+		case "experiment already ended":
+			utils.ConflictResponse(c, "Experiment has already ended")
+		default:
+			logrus.Errorf("End notification experiment failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to end experiment")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Experiment ended successfully", results)
+}
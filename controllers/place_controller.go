@@ -1,9 +1,12 @@
 package controllers
 
 import (
+	"context"
+	"errors"
 	"ftrack/models"
 	"ftrack/services"
 	"ftrack/utils"
+	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
@@ -11,12 +14,16 @@ import (
 )
 
 type PlaceController struct {
-	placeService *services.PlaceService
+	placeService             *services.PlaceService
+	departureReminderService *services.DepartureReminderService
+	locationReminderService  *services.LocationReminderService
 }
 
-func NewPlaceController(placeService *services.PlaceService) *PlaceController {
+func NewPlaceController(placeService *services.PlaceService, departureReminderService *services.DepartureReminderService, locationReminderService *services.LocationReminderService) *PlaceController {
 	return &PlaceController{
-		placeService: placeService,
+		placeService:             placeService,
+		departureReminderService: departureReminderService,
+		locationReminderService:  locationReminderService,
 	}
 }
 
@@ -31,20 +38,176 @@ func (pc *PlaceController) CreatePlace(c *gin.Context) {
 
 	var req models.CreatePlaceRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid place data")
+		utils.BindErrorResponse(c, "Invalid place data", err)
 		return
 	}
+	if c.Query("force") == "true" {
+		req.Force = true
+	}
 
 	place, err := pc.placeService.CreatePlace(c.Request.Context(), userID, req)
 	if err != nil {
+		if duplicatePlaceResponse(c, err) {
+			return
+		}
 		logrus.Errorf("Create place failed: %v", err)
-		utils.InternalServerErrorResponse(c, "Failed to create place")
+		switch err.Error() {
+		case "invalid circle ID":
+			utils.BadRequestResponse(c, "Invalid circle ID")
+		case "access denied":
+			utils.ForbiddenResponse(c, "You are not a member of this circle")
+		case "circle is archived":
+			utils.ConflictResponse(c, "This circle was merged into another circle and is read-only")
+		case "validation failed":
+			utils.ValidationFailedResponse(c, "Invalid place data", err)
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to create place")
+		}
 		return
 	}
 
 	utils.CreatedResponse(c, "Place created successfully", place)
 }
 
+// duplicatePlaceResponse answers with the similar places when a new place
+// looks like a duplicate. Clients can retry with force=true.
+func duplicatePlaceResponse(c *gin.Context, err error) bool {
+	var duplicateErr *services.DuplicatePlaceError
+	if !errors.As(err, &duplicateErr) {
+		return false
+	}
+
+	utils.ErrorResponse(c, http.StatusConflict, "Similar places already exist, retry with force=true to create it anyway", gin.H{
+		"candidates": duplicateErr.Candidates,
+	})
+	return true
+}
+
+// GetPlaceDuplicates reports groups of circle places that look alike
+func (pc *PlaceController) GetPlaceDuplicates(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	report, err := pc.placeService.GetPlaceDuplicates(c.Request.Context(), userID, c.Param("circleId"))
+	if err != nil {
+		logrus.Errorf("Get place duplicates failed: %v", err)
+		switch err.Error() {
+		case "invalid circle ID":
+			utils.BadRequestResponse(c, "Invalid circle ID")
+		case "access denied":
+			utils.ForbiddenResponse(c, "You are not a member of this circle")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to get duplicate places")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Duplicate places retrieved successfully", report)
+}
+
+// GetPlaceClusters returns a circle's places in a map viewport, clustered
+// where they are dense
+func (pc *PlaceController) GetPlaceClusters(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.PlaceClustersRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid viewport parameters")
+		return
+	}
+
+	response, err := pc.placeService.GetPlaceClusters(c.Request.Context(), userID, c.Param("circleId"), req)
+	if err != nil {
+		switch err.Error() {
+		case "validation failed":
+			utils.BadRequestResponse(c, "Invalid viewport: "+utils.ValidationFailureReason(err))
+		case "invalid circle ID":
+			utils.BadRequestResponse(c, "Invalid circle ID")
+		case "access denied":
+			utils.ForbiddenResponse(c, "You are not a member of this circle")
+		default:
+			logrus.Errorf("Get place clusters failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to get place clusters")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Place clusters retrieved successfully", response)
+}
+
+// GetPlaceClusterMembers returns the places of a cluster
+func (pc *PlaceController) GetPlaceClusterMembers(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	members, err := pc.placeService.GetPlaceClusterMembers(c.Request.Context(), userID, c.Param("clusterId"))
+	if err != nil {
+		switch err.Error() {
+		case "invalid cluster ID", "invalid circle ID":
+			utils.BadRequestResponse(c, "Invalid cluster ID")
+		case "access denied":
+			utils.ForbiddenResponse(c, "You are not a member of this circle")
+		default:
+			logrus.Errorf("Get place cluster members failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to get cluster places")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Cluster places retrieved successfully", members)
+}
+
+// MergePlaces merges duplicate circle places into a canonical place
+func (pc *PlaceController) MergePlaces(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.MergePlacesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid merge data")
+		return
+	}
+
+	result, err := pc.placeService.MergePlaces(c.Request.Context(), userID, c.Param("circleId"), req)
+	if err != nil {
+		logrus.Errorf("Merge places failed: %v", err)
+		switch err.Error() {
+		case "invalid circle ID":
+			utils.BadRequestResponse(c, "Invalid circle ID")
+		case "invalid place ID":
+			utils.BadRequestResponse(c, "Invalid place ID")
+		case "validation failed":
+			if reason := utils.ValidationFailureReason(err); reason != "" {
+				utils.BadRequestResponse(c, "Invalid merge data: "+reason)
+				return
+			}
+			utils.BadRequestResponse(c, "Invalid merge data")
+		case "place not found":
+			utils.NotFoundResponse(c, "Place")
+		case "access denied":
+			utils.ForbiddenResponse(c, "Only circle admins can merge other members' places")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to merge places")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Places merged successfully", result)
+}
+
 func (pc *PlaceController) GetPlace(c *gin.Context) {
 	userID := c.GetString("userID")
 	placeID := c.Param("placeId")
@@ -87,6 +250,80 @@ func (pc *PlaceController) GetPlaces(c *gin.Context) {
 	utils.SuccessResponse(c, "Places retrieved successfully", places)
 }
 
+// ==================== TAG OPERATIONS ====================
+
+// GetPlaceTags lists the tags of the user's places with their usage counts
+func (pc *PlaceController) GetPlaceTags(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.GetPlaceTagsRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid query parameters")
+		return
+	}
+
+	tags, err := pc.placeService.GetPlaceTags(c.Request.Context(), userID, req)
+	if err != nil {
+		logrus.Errorf("Get place tags failed: %v", err)
+		utils.HandleServiceError(c, err)
+		return
+	}
+
+	utils.SuccessResponse(c, "Place tags retrieved successfully", tags)
+}
+
+// RenamePlaceTag renames a tag on all of the user's places
+func (pc *PlaceController) RenamePlaceTag(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.RenamePlaceTagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request body")
+		return
+	}
+
+	result, err := pc.placeService.RenamePlaceTag(c.Request.Context(), userID, c.Param("tag"), req)
+	if err != nil {
+		logrus.Errorf("Rename place tag failed: %v", err)
+		utils.HandleServiceError(c, err)
+		return
+	}
+
+	utils.SuccessResponse(c, "Place tag renamed successfully", result)
+}
+
+// BulkUpdatePlaces applies the same changes to many of the user's places
+func (pc *PlaceController) BulkUpdatePlaces(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.BulkUpdatePlacesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request body")
+		return
+	}
+
+	result, err := pc.placeService.BulkUpdatePlaces(c.Request.Context(), userID, req)
+	if err != nil {
+		logrus.Errorf("Bulk update places failed: %v", err)
+		utils.HandleServiceError(c, err)
+		return
+	}
+
+	utils.SuccessResponse(c, "Bulk update completed", result)
+}
+
 // ==================== CATEGORY OPERATIONS ====================
 
 func (pc *PlaceController) GetPlaceCategories(c *gin.Context) {
@@ -144,13 +381,7 @@ func (pc *PlaceController) SearchPlaces(c *gin.Context) {
 		return
 	}
 
-	// Set defaults
-	if req.Page < 1 {
-		req.Page = 1
-	}
-	if req.PageSize < 1 {
-		req.PageSize = 20
-	}
+	req.Page, req.PageSize = models.NormalizePage(req.Page, req.PageSize)
 
 	result, err := pc.placeService.SearchPlaces(c.Request.Context(), req)
 	if err != nil {
@@ -162,6 +393,36 @@ func (pc *PlaceController) SearchPlaces(c *gin.Context) {
 	utils.SuccessResponse(c, "Places search completed", result)
 }
 
+func (pc *PlaceController) TypeaheadPlaces(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.PlaceTypeaheadRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid search parameters")
+		return
+	}
+
+	results, err := pc.placeService.TypeaheadPlaces(c.Request.Context(), userID, req)
+	if err != nil {
+		switch err.Error() {
+		case "validation failed":
+			utils.BadRequestResponse(c, "Search query is required")
+		case "invalid user ID":
+			utils.BadRequestResponse(c, "Invalid user ID")
+		default:
+			logrus.Errorf("Typeahead places failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to search places")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Places search completed", results)
+}
+
 func (pc *PlaceController) SearchNearbyPlaces(c *gin.Context) {
 	latStr := c.Query("latitude")
 	lonStr := c.Query("longitude")
@@ -220,10 +481,9 @@ func (pc *PlaceController) GetPlaceVisits(c *gin.Context) {
 		return
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+	pagination := utils.ParsePagination(c)
 
-	visits, total, err := pc.placeService.GetPlaceVisits(c.Request.Context(), userID, placeID, page, pageSize)
+	visits, total, err := pc.placeService.GetPlaceVisits(c.Request.Context(), userID, placeID, pagination.Page, pagination.PageSize)
 	if err != nil {
 		logrus.Errorf("Get place visits failed: %v", err)
 		utils.HandleServiceError(c, err)
@@ -233,10 +493,10 @@ func (pc *PlaceController) GetPlaceVisits(c *gin.Context) {
 	response := map[string]interface{}{
 		"visits": visits,
 		"meta": models.PaginationMeta{
-			Page:       page,
-			PageSize:   pageSize,
+			Page:       pagination.Page,
+			PageSize:   pagination.PageSize,
 			Total:      total,
-			TotalPages: int((total + int64(pageSize) - 1) / int64(pageSize)),
+			TotalPages: int((total + int64(pagination.PageSize) - 1) / int64(pagination.PageSize)),
 		},
 	}
 
@@ -277,198 +537,483 @@ func (pc *PlaceController) RecordPlaceVisit(c *gin.Context) {
 	utils.CreatedResponse(c, "Visit recorded successfully", visit)
 }
 
-// ==================== REVIEW OPERATIONS ====================
+// ==================== DEPARTURE REMINDER OPERATIONS ====================
 
-func (pc *PlaceController) GetPlaceReviews(c *gin.Context) {
-	placeID := c.Param("placeId")
-	if placeID == "" {
-		utils.BadRequestResponse(c, "Place ID is required")
+func (pc *PlaceController) GetDepartureReminders(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
 		return
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
-
-	reviews, total, err := pc.placeService.GetPlaceReviews(c.Request.Context(), placeID, page, pageSize)
+	reminders, err := pc.departureReminderService.GetReminders(c.Request.Context(), userID)
 	if err != nil {
-		logrus.Errorf("Get place reviews failed: %v", err)
-		utils.InternalServerErrorResponse(c, "Failed to get reviews")
+		logrus.Errorf("Get departure reminders failed: %v", err)
+		utils.InternalServerErrorResponse(c, "Failed to get departure reminders")
 		return
 	}
 
-	response := map[string]interface{}{
-		"reviews": reviews,
-		"meta": models.PaginationMeta{
-			Page:       page,
-			PageSize:   pageSize,
-			Total:      total,
-			TotalPages: int((total + int64(pageSize) - 1) / int64(pageSize)),
-		},
-	}
-
-	utils.SuccessResponse(c, "Reviews retrieved successfully", response)
+	utils.SuccessResponse(c, "Departure reminders retrieved successfully", reminders)
 }
 
-func (pc *PlaceController) CreatePlaceReview(c *gin.Context) {
+func (pc *PlaceController) CreateDepartureReminder(c *gin.Context) {
 	userID := c.GetString("userID")
-	placeID := c.Param("placeId")
-
 	if userID == "" {
 		utils.UnauthorizedResponse(c, "User not authenticated")
 		return
 	}
 
-	if placeID == "" {
-		utils.BadRequestResponse(c, "Place ID is required")
+	var req models.CreateDepartureReminderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid reminder data")
 		return
 	}
 
-	var req struct {
-		Rating   int    `json:"rating" validate:"required,min=1,max=5"`
-		Title    string `json:"title"`
-		Comment  string `json:"comment"`
-		IsPublic bool   `json:"isPublic"`
+	reminder, err := pc.departureReminderService.CreateReminder(c.Request.Context(), userID, req)
+	if err != nil {
+		switch err.Error() {
+		case "validation failed":
+			utils.BadRequestResponse(c, "Invalid reminder data: "+utils.ValidationFailureReason(err))
+		case "invalid timezone":
+			utils.BadRequestResponse(c, "Invalid timezone")
+		case "invalid place ID":
+			utils.BadRequestResponse(c, "Invalid place ID")
+		case "place not found":
+			utils.NotFoundResponse(c, "Place")
+		case "access denied":
+			utils.ForbiddenResponse(c, "Access denied")
+		case "departure reminder already exists":
+			utils.ConflictResponse(c, "A departure reminder for this place already exists")
+		default:
+			logrus.Errorf("Create departure reminder failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to create departure reminder")
+		}
+		return
 	}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid review data")
+	utils.CreatedResponse(c, "Departure reminder created successfully", reminder)
+}
+
+func (pc *PlaceController) GetDepartureReminder(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
 		return
 	}
 
-	review, err := pc.placeService.CreateReview(c.Request.Context(), userID, placeID, req.Rating, req.Title, req.Comment, req.IsPublic)
+	reminder, err := pc.departureReminderService.GetReminder(c.Request.Context(), userID, c.Param("reminderId"))
 	if err != nil {
-		logrus.Errorf("Create review failed: %v", err)
-		utils.InternalServerErrorResponse(c, "Failed to create review")
+		switch err.Error() {
+		case "invalid departure reminder ID":
+			utils.BadRequestResponse(c, "Invalid reminder ID")
+		case "departure reminder not found":
+			utils.NotFoundResponse(c, "Departure reminder")
+		default:
+			logrus.Errorf("Get departure reminder failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to get departure reminder")
+		}
 		return
 	}
 
-	utils.CreatedResponse(c, "Review created successfully", review)
+	utils.SuccessResponse(c, "Departure reminder retrieved successfully", reminder)
 }
 
-// ==================== CHECKIN OPERATIONS ====================
-
-func (pc *PlaceController) GetPlaceCheckins(c *gin.Context) {
-	placeID := c.Param("placeId")
-	if placeID == "" {
-		utils.BadRequestResponse(c, "Place ID is required")
+func (pc *PlaceController) UpdateDepartureReminder(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
 		return
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
-
-	checkins, total, err := pc.placeService.GetPlaceCheckins(c.Request.Context(), placeID, page, pageSize)
-	if err != nil {
-		logrus.Errorf("Get place checkins failed: %v", err)
-		utils.InternalServerErrorResponse(c, "Failed to get checkins")
+	var req models.UpdateDepartureReminderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid reminder data")
 		return
 	}
 
-	response := map[string]interface{}{
-		"checkins": checkins,
-		"meta": models.PaginationMeta{
-			Page:       page,
-			PageSize:   pageSize,
-			Total:      total,
-			TotalPages: int((total + int64(pageSize) - 1) / int64(pageSize)),
-		},
+	reminder, err := pc.departureReminderService.UpdateReminder(c.Request.Context(), userID, c.Param("reminderId"), req)
+	if err != nil {
+		switch err.Error() {
+		case "validation failed":
+			utils.BadRequestResponse(c, "Invalid reminder data: "+utils.ValidationFailureReason(err))
+		case "invalid timezone":
+			utils.BadRequestResponse(c, "Invalid timezone")
+		case "invalid departure reminder ID":
+			utils.BadRequestResponse(c, "Invalid reminder ID")
+		case "departure reminder not found":
+			utils.NotFoundResponse(c, "Departure reminder")
+		default:
+			logrus.Errorf("Update departure reminder failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to update departure reminder")
+		}
+		return
 	}
 
-	utils.SuccessResponse(c, "Checkins retrieved successfully", response)
+	utils.SuccessResponse(c, "Departure reminder updated successfully", reminder)
 }
 
-func (pc *PlaceController) CheckInToPlace(c *gin.Context) {
+func (pc *PlaceController) DeleteDepartureReminder(c *gin.Context) {
 	userID := c.GetString("userID")
-	placeID := c.Param("placeId")
-
 	if userID == "" {
 		utils.UnauthorizedResponse(c, "User not authenticated")
 		return
 	}
 
-	if placeID == "" {
-		utils.BadRequestResponse(c, "Place ID is required")
+	err := pc.departureReminderService.DeleteReminder(c.Request.Context(), userID, c.Param("reminderId"))
+	if err != nil {
+		switch err.Error() {
+		case "invalid departure reminder ID":
+			utils.BadRequestResponse(c, "Invalid reminder ID")
+		case "departure reminder not found":
+			utils.NotFoundResponse(c, "Departure reminder")
+		default:
+			logrus.Errorf("Delete departure reminder failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to delete departure reminder")
+		}
 		return
 	}
 
-	var req struct {
-		Message  string          `json:"message"`
-		IsPublic bool            `json:"isPublic"`
-		Location models.Location `json:"location"`
-	}
+	utils.SuccessResponse(c, "Departure reminder deleted successfully", nil)
+}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid checkin data")
+func (pc *PlaceController) RecomputeDepartureReminder(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
 		return
 	}
 
-	checkin, err := pc.placeService.CheckIn(c.Request.Context(), userID, placeID, req.Message, req.IsPublic, req.Location)
+	reminder, err := pc.departureReminderService.RecomputeReminder(c.Request.Context(), userID, c.Param("reminderId"))
 	if err != nil {
-		logrus.Errorf("Checkin failed: %v", err)
-		utils.InternalServerErrorResponse(c, "Failed to check in")
+		switch err.Error() {
+		case "invalid departure reminder ID":
+			utils.BadRequestResponse(c, "Invalid reminder ID")
+		case "departure reminder not found":
+			utils.NotFoundResponse(c, "Departure reminder")
+		default:
+			logrus.Errorf("Recompute departure reminder failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to recompute departure reminder")
+		}
 		return
 	}
 
-	utils.CreatedResponse(c, "Checked in successfully", checkin)
+	utils.SuccessResponse(c, "Departure reminder recomputed successfully", reminder)
 }
 
-// ==================== AUTOMATION OPERATIONS ====================
+// ==================== LOCATION REMINDER OPERATIONS ====================
 
-func (pc *PlaceController) GetAutomationRules(c *gin.Context) {
+func (pc *PlaceController) GetLocationReminders(c *gin.Context) {
 	userID := c.GetString("userID")
-	placeID := c.Param("placeId")
-
 	if userID == "" {
 		utils.UnauthorizedResponse(c, "User not authenticated")
 		return
 	}
 
-	// placeId is optional - if not provided, get all user's automation rules
-	rules, err := pc.placeService.GetAutomationRules(c.Request.Context(), userID, placeID)
+	reminders, err := pc.locationReminderService.GetReminders(c.Request.Context(), userID)
 	if err != nil {
-		logrus.Errorf("Get automation rules failed: %v", err)
-		utils.HandleServiceError(c, err)
+		logrus.Errorf("Get location reminders failed: %v", err)
+		utils.InternalServerErrorResponse(c, "Failed to get reminders")
 		return
 	}
 
-	utils.SuccessResponse(c, "Automation rules retrieved successfully", rules)
+	utils.SuccessResponse(c, "Reminders retrieved successfully", reminders)
 }
 
-func (pc *PlaceController) CreateAutomationRule(c *gin.Context) {
+func (pc *PlaceController) CreateLocationReminder(c *gin.Context) {
 	userID := c.GetString("userID")
-	placeID := c.Param("placeId")
-
 	if userID == "" {
 		utils.UnauthorizedResponse(c, "User not authenticated")
 		return
 	}
 
-	var req struct {
-		Name       string                 `json:"name" validate:"required"`
-		Type       string                 `json:"type" validate:"required"`
-		Conditions []models.RuleCondition `json:"conditions"`
-		Actions    []models.RuleAction    `json:"actions" validate:"required,min=1"`
-	}
-
+	var req models.CreateLocationReminderRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid automation rule data")
+		utils.BadRequestResponse(c, "Invalid reminder data")
 		return
 	}
 
-	rule, err := pc.placeService.CreateAutomationRule(c.Request.Context(), userID, placeID, req.Name, req.Type, req.Conditions, req.Actions)
+	reminder, err := pc.locationReminderService.CreateReminder(c.Request.Context(), userID, req)
 	if err != nil {
-		logrus.Errorf("Create automation rule failed: %v", err)
-		utils.HandleServiceError(c, err)
+		switch err.Error() {
+		case "validation failed":
+			utils.BadRequestResponse(c, "Invalid reminder data: "+utils.ValidationFailureReason(err))
+		case "invalid place ID":
+			utils.BadRequestResponse(c, "Invalid place ID")
+		case "place not found":
+			utils.NotFoundResponse(c, "Place")
+		case "access denied":
+			utils.ForbiddenResponse(c, "Access denied")
+		case "too many reminders":
+			utils.ConflictResponse(c, "You have too many active reminders")
+		default:
+			logrus.Errorf("Create location reminder failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to create reminder")
+		}
 		return
 	}
 
-	utils.CreatedResponse(c, "Automation rule created successfully", rule)
+	utils.CreatedResponse(c, "Reminder created successfully", reminder)
 }
 
+func (pc *PlaceController) DeleteLocationReminder(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
 
-func (pc *PlaceController) UpdatePlaceCategory(c *gin.Context) {
-	utils.SuccessResponse(c, "Category update feature coming soon", nil)
-}
+	err := pc.locationReminderService.DeleteReminder(c.Request.Context(), userID, c.Param("reminderId"))
+	if err != nil {
+		switch err.Error() {
+		case "invalid reminder ID":
+			utils.BadRequestResponse(c, "Invalid reminder ID")
+		case "reminder not found":
+			utils.NotFoundResponse(c, "Reminder")
+		default:
+			logrus.Errorf("Delete location reminder failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to delete reminder")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Reminder deleted successfully", nil)
+}
+
+// ==================== REVIEW OPERATIONS ====================
+
+func (pc *PlaceController) GetPlaceReviews(c *gin.Context) {
+	placeID := c.Param("placeId")
+	if placeID == "" {
+		utils.BadRequestResponse(c, "Place ID is required")
+		return
+	}
+
+	pagination := utils.ParsePagination(c)
+
+	reviews, total, err := pc.placeService.GetPlaceReviews(c.Request.Context(), placeID, pagination.Page, pagination.PageSize)
+	if err != nil {
+		logrus.Errorf("Get place reviews failed: %v", err)
+		utils.InternalServerErrorResponse(c, "Failed to get reviews")
+		return
+	}
+
+	response := map[string]interface{}{
+		"reviews": reviews,
+		"meta": models.PaginationMeta{
+			Page:       pagination.Page,
+			PageSize:   pagination.PageSize,
+			Total:      total,
+			TotalPages: int((total + int64(pagination.PageSize) - 1) / int64(pagination.PageSize)),
+		},
+	}
+
+	utils.SuccessResponse(c, "Reviews retrieved successfully", response)
+}
+
+func (pc *PlaceController) CreatePlaceReview(c *gin.Context) {
+	userID := c.GetString("userID")
+	placeID := c.Param("placeId")
+
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	if placeID == "" {
+		utils.BadRequestResponse(c, "Place ID is required")
+		return
+	}
+
+	var req struct {
+		Rating   int    `json:"rating" validate:"required,min=1,max=5"`
+		Title    string `json:"title"`
+		Comment  string `json:"comment"`
+		IsPublic bool   `json:"isPublic"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid review data")
+		return
+	}
+
+	review, err := pc.placeService.CreateReview(c.Request.Context(), userID, placeID, req.Rating, req.Title, req.Comment, req.IsPublic)
+	if err != nil {
+		switch err.Error() {
+		case "invalid place ID":
+			utils.BadRequestResponse(c, "Invalid place ID")
+		case "rating must be between 1 and 5":
+			utils.BadRequestResponse(c, "Rating must be between 1 and 5")
+		case "review contains inappropriate language":
+			utils.BadRequestResponse(c, "Review contains inappropriate language")
+		case "place not found":
+			utils.NotFoundResponse(c, "Place")
+		case "access denied":
+			utils.ForbiddenResponse(c, "Access denied")
+		case "must visit before reviewing":
+			utils.ForbiddenResponse(c, "You must visit or check in to this place before reviewing it")
+		case "already reviewed":
+			utils.ConflictResponse(c, "You have already reviewed this place")
+		default:
+			logrus.Errorf("Create review failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to create review")
+		}
+		return
+	}
+
+	utils.CreatedResponse(c, "Review created successfully", review)
+}
+
+// ==================== CHECKIN OPERATIONS ====================
+
+func (pc *PlaceController) GetPlaceCheckins(c *gin.Context) {
+	userID := c.GetString("userID")
+	placeID := c.Param("placeId")
+
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	if placeID == "" {
+		utils.BadRequestResponse(c, "Place ID is required")
+		return
+	}
+
+	pagination := utils.ParsePagination(c)
+
+	checkins, total, err := pc.placeService.GetPlaceCheckins(c.Request.Context(), userID, placeID, pagination.Page, pagination.PageSize)
+	if err != nil {
+		logrus.Errorf("Get place checkins failed: %v", err)
+		handleCheckinError(c, err, "Failed to get checkins")
+		return
+	}
+
+	response := map[string]interface{}{
+		"checkins": checkins,
+		"meta": models.PaginationMeta{
+			Page:       pagination.Page,
+			PageSize:   pagination.PageSize,
+			Total:      total,
+			TotalPages: int((total + int64(pagination.PageSize) - 1) / int64(pagination.PageSize)),
+		},
+	}
+
+	utils.SuccessResponse(c, "Checkins retrieved successfully", response)
+}
+
+func (pc *PlaceController) CheckInToPlace(c *gin.Context) {
+	userID := c.GetString("userID")
+	placeID := c.Param("placeId")
+
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	if placeID == "" {
+		utils.BadRequestResponse(c, "Place ID is required")
+		return
+	}
+
+	var req models.CheckInToPlaceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid checkin data")
+		return
+	}
+
+	checkin, err := pc.placeService.CheckIn(c.Request.Context(), userID, placeID, req)
+	if err != nil {
+		logrus.Errorf("Checkin failed: %v", err)
+		handleCheckinError(c, err, "Failed to check in")
+		return
+	}
+
+	utils.CreatedResponse(c, "Checked in successfully", checkin)
+}
+
+func handleCheckinError(c *gin.Context, err error, fallback string) {
+	switch err.Error() {
+	case "invalid place ID":
+		utils.BadRequestResponse(c, "Invalid place ID")
+	case "invalid checkin ID":
+		utils.BadRequestResponse(c, "Invalid checkin ID")
+	case "validation failed":
+		reason := utils.ValidationFailureReason(err)
+		if reason == "" {
+			reason = "Invalid checkin data"
+		}
+		utils.BadRequestResponse(c, reason)
+	case "place not found":
+		utils.NotFoundResponse(c, "Place")
+	case "checkin not found":
+		utils.NotFoundResponse(c, "Checkin")
+	case "access denied":
+		utils.ForbiddenResponse(c, "Access denied")
+	default:
+		utils.InternalServerErrorResponse(c, fallback)
+	}
+}
+
+// ==================== AUTOMATION OPERATIONS ====================
+
+func (pc *PlaceController) GetAutomationRules(c *gin.Context) {
+	userID := c.GetString("userID")
+	placeID := c.Param("placeId")
+
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	// placeId is optional - if not provided, get all user's automation rules
+	rules, err := pc.placeService.GetAutomationRules(c.Request.Context(), userID, placeID)
+	if err != nil {
+		logrus.Errorf("Get automation rules failed: %v", err)
+		utils.HandleServiceError(c, err)
+		return
+	}
+
+	utils.SuccessResponse(c, "Automation rules retrieved successfully", rules)
+}
+
+func (pc *PlaceController) CreateAutomationRule(c *gin.Context) {
+	userID := c.GetString("userID")
+	placeID := c.Param("placeId")
+
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req struct {
+		Name       string                 `json:"name" validate:"required"`
+		Type       string                 `json:"type" validate:"required"`
+		Conditions []models.RuleCondition `json:"conditions"`
+		Actions    []models.RuleAction    `json:"actions" validate:"required,min=1"`
+
+		Priority    int  `json:"priority"`
+		StopOnMatch bool `json:"stopOnMatch"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid automation rule data")
+		return
+	}
+
+	rule, err := pc.placeService.CreateAutomationRule(c.Request.Context(), userID, placeID, req.Name, req.Type, req.Conditions, req.Actions, req.Priority, req.StopOnMatch)
+	if err != nil {
+		logrus.Errorf("Create automation rule failed: %v", err)
+		utils.HandleServiceError(c, err)
+		return
+	}
+
+	utils.CreatedResponse(c, "Automation rule created successfully", rule)
+}
+
+
+func (pc *PlaceController) UpdatePlaceCategory(c *gin.Context) {
+	utils.SuccessResponse(c, "Category update feature coming soon", nil)
+}
 
 func (pc *PlaceController) DeletePlaceCategory(c *gin.Context) {
 	utils.SuccessResponse(c, "Category deletion feature coming soon", nil)
@@ -478,36 +1023,459 @@ func (pc *PlaceController) GetPlacesByCategory(c *gin.Context) {
 	utils.SuccessResponse(c, "Places by category feature coming soon", nil)
 }
 
+// GetPopularPlaces ranks the places the user can see by visits and
+// check-ins, recent ones weighing more
 func (pc *PlaceController) GetPopularPlaces(c *gin.Context) {
-	utils.SuccessResponse(c, "Popular places feature coming soon", nil)
+	pc.getPlaceTrends(c, "popular", "Popular places retrieved", pc.placeService.GetPopularPlaces)
 }
 
-func (pc *PlaceController) GetRecentPlaces(c *gin.Context) {
-	utils.SuccessResponse(c, "Recent places feature coming soon", nil)
-}
+func (pc *PlaceController) GetRecentPlaces(c *gin.Context) {
+	utils.SuccessResponse(c, "Recent places feature coming soon", nil)
+}
+
+// GetFavoritePlaces lists the places the member has favorited, most
+// recently favorited first
+func (pc *PlaceController) GetFavoritePlaces(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.GetFavoritePlacesRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid query parameters")
+		return
+	}
+
+	places, err := pc.placeService.GetFavoritePlaces(c.Request.Context(), userID, req)
+	if err != nil {
+		logrus.Errorf("Get favorite places failed: %v", err)
+		handlePlaceFavoriteError(c, err, "Failed to get favorite places")
+		return
+	}
+
+	utils.SuccessResponse(c, "Favorite places retrieved", places)
+}
+
+func (pc *PlaceController) AdvancedPlaceSearch(c *gin.Context) {
+	utils.SuccessResponse(c, "Advanced search feature coming soon", nil)
+}
+
+// GetGeofenceSettings returns the settings the place's geofence events use,
+// and which of them are inherited from the circle
+func (pc *PlaceController) GetGeofenceSettings(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	settings, err := pc.placeService.GetPlaceGeofenceSettings(c.Request.Context(), userID, c.Param("placeId"))
+	if err != nil {
+		logrus.Errorf("Get geofence settings failed: %v", err)
+		utils.HandleServiceError(c, err)
+		return
+	}
+
+	utils.SuccessResponse(c, "Geofence settings retrieved", settings)
+}
+
+// UpdateGeofenceSettings overrides settings on the place, or makes them
+// follow the circle's defaults again
+func (pc *PlaceController) UpdateGeofenceSettings(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.UpdatePlaceGeofenceSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid geofence settings")
+		return
+	}
+
+	settings, err := pc.placeService.UpdatePlaceGeofenceSettings(c.Request.Context(), userID, c.Param("placeId"), req)
+	if err != nil {
+		logrus.Errorf("Update geofence settings failed: %v", err)
+		utils.HandleServiceError(c, err)
+		return
+	}
+
+	utils.SuccessResponse(c, "Geofence settings updated", settings)
+}
+
+// GetPlaceVersions lists the changes made to a place, newest first
+func (pc *PlaceController) GetPlaceVersions(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	pagination := utils.ParsePagination(c)
+
+	versions, err := pc.placeService.GetPlaceVersions(c.Request.Context(), userID, c.Param("placeId"), pagination.Page, pagination.PageSize)
+	if err != nil {
+		logrus.Errorf("Get place versions failed: %v", err)
+		handlePlaceVersionError(c, err, "Failed to get place versions")
+		return
+	}
+
+	utils.SuccessResponse(c, "Place versions retrieved successfully", versions)
+}
+
+// RestorePlaceVersion puts a place's geometry and settings back as they
+// were at a version
+func (pc *PlaceController) RestorePlaceVersion(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	place, err := pc.placeService.RestorePlaceVersion(c.Request.Context(), userID, c.Param("placeId"), c.Param("versionId"))
+	if err != nil {
+		logrus.Errorf("Restore place version failed: %v", err)
+		handlePlaceVersionError(c, err, "Failed to restore place version")
+		return
+	}
+
+	utils.SuccessResponse(c, "Place version restored", place)
+}
+
+// SetPlaceAlias sets the member's private alias for a place, or makes it
+// their home
+func (pc *PlaceController) SetPlaceAlias(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.PlaceAliasRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request body")
+		return
+	}
+
+	alias, err := pc.placeService.SetPlaceAlias(c.Request.Context(), userID, c.Param("placeId"), req)
+	if err != nil {
+		logrus.Errorf("Set place alias failed: %v", err)
+		handlePlaceAliasError(c, err, "Failed to set place alias")
+		return
+	}
+	if alias == nil {
+		utils.SuccessResponse(c, "Place alias removed", nil)
+		return
+	}
+
+	utils.SuccessResponse(c, "Place alias updated", alias)
+}
+
+// DeletePlaceAlias removes the member's alias and home mark from a place
+func (pc *PlaceController) DeletePlaceAlias(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	if err := pc.placeService.DeletePlaceAlias(c.Request.Context(), userID, c.Param("placeId")); err != nil {
+		logrus.Errorf("Delete place alias failed: %v", err)
+		handlePlaceAliasError(c, err, "Failed to remove place alias")
+		return
+	}
+
+	utils.SuccessResponse(c, "Place alias removed", nil)
+}
+
+// FavoritePlace adds a place to the member's favorites. Favorites are the
+// member's own, even for places shared with their circles.
+func (pc *PlaceController) FavoritePlace(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	place, err := pc.placeService.FavoritePlace(c.Request.Context(), userID, c.Param("placeId"))
+	if err != nil {
+		logrus.Errorf("Favorite place failed: %v", err)
+		handlePlaceFavoriteError(c, err, "Failed to favorite place")
+		return
+	}
+
+	utils.SuccessResponse(c, "Place added to favorites", place)
+}
+
+// UnfavoritePlace removes a place from the member's favorites
+func (pc *PlaceController) UnfavoritePlace(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	if err := pc.placeService.UnfavoritePlace(c.Request.Context(), userID, c.Param("placeId")); err != nil {
+		logrus.Errorf("Unfavorite place failed: %v", err)
+		handlePlaceFavoriteError(c, err, "Failed to remove place from favorites")
+		return
+	}
+
+	utils.SuccessResponse(c, "Place removed from favorites", nil)
+}
+
+func handlePlaceFavoriteError(c *gin.Context, err error, fallback string) {
+	switch err.Error() {
+	case "invalid place ID", "invalid user ID":
+		utils.BadRequestResponse(c, "Invalid ID")
+	case "place not found":
+		utils.NotFoundResponse(c, "Place")
+	case "access denied":
+		utils.ForbiddenResponse(c, "Access denied")
+	default:
+		utils.InternalServerErrorResponse(c, fallback)
+	}
+}
+
+// GetPlaceSuggestions lists places suggested from where the user often
+// stays without a place covering it
+func (pc *PlaceController) GetPlaceSuggestions(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	suggestions, err := pc.placeService.GetPlaceSuggestions(c.Request.Context(), userID)
+	if err != nil {
+		logrus.Errorf("Get place suggestions failed: %v", err)
+		handlePlaceSuggestionError(c, err, "Failed to get place suggestions")
+		return
+	}
+
+	utils.SuccessResponse(c, "Place suggestions retrieved successfully", suggestions)
+}
+
+// AcceptPlaceSuggestion creates a place from a suggestion
+func (pc *PlaceController) AcceptPlaceSuggestion(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.AcceptPlaceSuggestionRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.BindErrorResponse(c, "Invalid place data", err)
+			return
+		}
+	}
+	if c.Query("force") == "true" {
+		req.Force = true
+	}
+
+	result, err := pc.placeService.AcceptPlaceSuggestion(c.Request.Context(), userID, c.Param("suggestionId"), req)
+	if err != nil {
+		if duplicatePlaceResponse(c, err) {
+			return
+		}
+		logrus.Errorf("Accept place suggestion failed: %v", err)
+		handlePlaceSuggestionError(c, err, "Failed to accept place suggestion")
+		return
+	}
+
+	utils.CreatedResponse(c, "Place created from suggestion", result)
+}
+
+// DismissPlaceSuggestion hides a suggestion for good
+func (pc *PlaceController) DismissPlaceSuggestion(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	if err := pc.placeService.DismissPlaceSuggestion(c.Request.Context(), userID, c.Param("suggestionId")); err != nil {
+		logrus.Errorf("Dismiss place suggestion failed: %v", err)
+		handlePlaceSuggestionError(c, err, "Failed to dismiss place suggestion")
+		return
+	}
+
+	utils.SuccessResponse(c, "Place suggestion dismissed", nil)
+}
+
+func handlePlaceSuggestionError(c *gin.Context, err error, fallback string) {
+	switch err.Error() {
+	case "invalid suggestion ID", "invalid user ID", "invalid circle ID":
+		utils.BadRequestResponse(c, "Invalid ID")
+	case "validation failed":
+		utils.ValidationFailedResponse(c, "Invalid place data", err)
+	case "place suggestion not found":
+		utils.NotFoundResponse(c, "Place suggestion")
+	case "access denied":
+		utils.ForbiddenResponse(c, "You are not a member of this circle")
+	case "circle is archived":
+		utils.ConflictResponse(c, "This circle was merged into another circle and is read-only")
+	default:
+		utils.InternalServerErrorResponse(c, fallback)
+	}
+}
+
+func handlePlaceAliasError(c *gin.Context, err error, fallback string) {
+	switch err.Error() {
+	case "invalid place ID", "invalid user ID":
+		utils.BadRequestResponse(c, "Invalid ID")
+	case "validation failed":
+		reason := utils.ValidationFailureReason(err)
+		if reason == "" {
+			reason = "Invalid alias"
+		}
+		utils.BadRequestResponse(c, reason)
+	case "place not found":
+		utils.NotFoundResponse(c, "Place")
+	case "place alias not found":
+		utils.NotFoundResponse(c, "Place alias")
+	case "access denied":
+		utils.ForbiddenResponse(c, "Access denied")
+	default:
+		utils.InternalServerErrorResponse(c, fallback)
+	}
+}
+
+func handlePlaceVersionError(c *gin.Context, err error, fallback string) {
+	switch err.Error() {
+	case "invalid place ID":
+		utils.BadRequestResponse(c, "Invalid place ID")
+	case "invalid version ID":
+		utils.BadRequestResponse(c, "Invalid version ID")
+	case "validation failed":
+		reason := utils.ValidationFailureReason(err)
+		if reason == "" {
+			reason = "The version's settings are no longer valid"
+		}
+		utils.BadRequestResponse(c, reason)
+	case "place not found":
+		utils.NotFoundResponse(c, "Place")
+	case "place version not found":
+		utils.NotFoundResponse(c, "Place version")
+	case "access denied":
+		utils.ForbiddenResponse(c, "Access denied")
+	default:
+		utils.InternalServerErrorResponse(c, fallback)
+	}
+}
+
+// GetCircleGeofenceDefaults returns the geofence settings the circle's
+// places inherit
+func (pc *PlaceController) GetCircleGeofenceDefaults(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	defaults, err := pc.placeService.GetCircleGeofenceDefaults(c.Request.Context(), userID, c.Param("circleId"))
+	if err != nil {
+		switch err.Error() {
+		case "invalid circle ID":
+			utils.BadRequestResponse(c, "Invalid circle ID")
+		case "circle not found":
+			utils.NotFoundResponse(c, "Circle")
+		case "access denied":
+			utils.ForbiddenResponse(c, "You are not a member of this circle")
+		default:
+			logrus.Errorf("Get circle geofence defaults failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to get circle geofence defaults")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Circle geofence defaults retrieved", defaults)
+}
+
+// UpdateCircleGeofenceDefaults replaces the geofence settings the circle's
+// places inherit
+func (pc *PlaceController) UpdateCircleGeofenceDefaults(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.GeofenceDefaults
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid geofence defaults")
+		return
+	}
+
+	defaults, err := pc.placeService.UpdateCircleGeofenceDefaults(c.Request.Context(), userID, c.Param("circleId"), req)
+	if err != nil {
+		switch err.Error() {
+		case "validation failed":
+			utils.BadRequestResponse(c, geofenceSettingsError(err))
+		case "invalid circle ID":
+			utils.BadRequestResponse(c, "Invalid circle ID")
+		case "circle not found":
+			utils.NotFoundResponse(c, "Circle")
+		case "access denied":
+			utils.ForbiddenResponse(c, "Only circle admins can change geofence defaults")
+		default:
+			logrus.Errorf("Update circle geofence defaults failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to update circle geofence defaults")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Circle geofence defaults updated", defaults)
+}
+
+// ResetPlacesToCircleDefaults makes the circle's places follow its geofence
+// defaults again
+func (pc *PlaceController) ResetPlacesToCircleDefaults(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.ResetPlaceSettingsRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.BadRequestResponse(c, "Invalid reset request")
+			return
+		}
+	}
 
-func (pc *PlaceController) GetFavoritePlaces(c *gin.Context) {
-	utils.SuccessResponse(c, "Favorite places feature coming soon", nil)
-}
+	result, err := pc.placeService.ResetPlacesToCircleDefaults(c.Request.Context(), userID, c.Param("circleId"), req)
+	if err != nil {
+		switch err.Error() {
+		case "validation failed":
+			utils.BadRequestResponse(c, geofenceSettingsError(err))
+		case "invalid circle ID":
+			utils.BadRequestResponse(c, "Invalid circle ID")
+		case "invalid place ID":
+			utils.BadRequestResponse(c, "Invalid place ID")
+		case "access denied":
+			utils.ForbiddenResponse(c, "Only circle admins can reset places to circle defaults")
+		default:
+			logrus.Errorf("Reset places to circle defaults failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to reset places to circle defaults")
+		}
+		return
+	}
 
-func (pc *PlaceController) AdvancedPlaceSearch(c *gin.Context) {
-	utils.SuccessResponse(c, "Advanced search feature coming soon", nil)
+	utils.SuccessResponse(c, "Places reset to circle defaults", result)
 }
 
-func (pc *PlaceController) GetGeofenceSettings(c *gin.Context) {
-	placeID := c.Param("placeId")
-	// Return basic geofence settings
-	settings := map[string]interface{}{
-		"placeId":     placeID,
-		"isEnabled":   true,
-		"shape":       "circle",
-		"sensitivity": "medium",
+func geofenceSettingsError(err error) string {
+	if reason := utils.ValidationFailureReason(err); reason != "" {
+		return "Invalid geofence settings: " + reason
 	}
-	utils.SuccessResponse(c, "Geofence settings retrieved", settings)
-}
-
-func (pc *PlaceController) UpdateGeofenceSettings(c *gin.Context) {
-	utils.SuccessResponse(c, "Geofence settings updated", nil)
+	return "Invalid geofence settings"
 }
 
 func (pc *PlaceController) TestGeofence(c *gin.Context) {
@@ -542,20 +1510,82 @@ func (pc *PlaceController) GetGeofenceActivity(c *gin.Context) {
 
 // Continue with all the remaining stub methods...
 func (pc *PlaceController) GetPlaceNotifications(c *gin.Context) {
-	notifications := map[string]interface{}{
-		"onArrival":   true,
-		"onDeparture": false,
-		"onLongStay":  true,
+	userID := c.GetString("userID")
+	placeID := c.Param("placeId")
+
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	notifications, err := pc.placeService.GetPlaceNotifications(c.Request.Context(), userID, placeID)
+	if err != nil {
+		logrus.Errorf("Get place notifications failed: %v", err)
+		utils.HandleServiceError(c, err)
+		return
 	}
+
 	utils.SuccessResponse(c, "Place notifications retrieved", notifications)
 }
 
 func (pc *PlaceController) UpdatePlaceNotifications(c *gin.Context) {
-	utils.SuccessResponse(c, "Place notifications updated", nil)
+	userID := c.GetString("userID")
+	placeID := c.Param("placeId")
+
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.PlaceNotifications
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid notification settings")
+		return
+	}
+
+	notifications, err := pc.placeService.UpdatePlaceNotifications(c.Request.Context(), userID, placeID, req)
+	if err != nil {
+		if err.Error() == "validation failed" {
+			utils.BadRequestResponse(c, "Invalid notification settings: "+utils.ValidationFailureReason(err))
+			return
+		}
+		logrus.Errorf("Update place notifications failed: %v", err)
+		utils.HandleServiceError(c, err)
+		return
+	}
+
+	utils.SuccessResponse(c, "Place notifications updated", notifications)
 }
 
 func (pc *PlaceController) TestPlaceNotification(c *gin.Context) {
-	utils.SuccessResponse(c, "Test notification sent", nil)
+	userID := c.GetString("userID")
+	placeID := c.Param("placeId")
+
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.TestPlaceNotificationRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.BadRequestResponse(c, "Invalid test notification data")
+			return
+		}
+	}
+
+	preview, err := pc.placeService.TestPlaceNotification(c.Request.Context(), userID, placeID, req)
+	if err != nil {
+		if err.Error() == "validation failed" {
+			utils.BadRequestResponse(c, "Invalid notification template: "+utils.ValidationFailureReason(err))
+			return
+		}
+		logrus.Errorf("Test place notification failed: %v", err)
+		utils.HandleServiceError(c, err)
+		return
+	}
+
+	utils.SuccessResponse(c, "Notification preview rendered", preview)
 }
 
 func (pc *PlaceController) GetNotificationHistory(c *gin.Context) {
@@ -631,41 +1661,245 @@ func (pc *PlaceController) GetVisitStats(c *gin.Context) {
 	utils.SuccessResponse(c, "Visit statistics retrieved", stats)
 }
 
+// GetPlaceHours returns a place's regular hours and overrides
 func (pc *PlaceController) GetPlaceHours(c *gin.Context) {
-	hours := map[string]interface{}{
-		"isAlwaysOpen": false,
-		"schedule": map[string]interface{}{
-			"monday": map[string]interface{}{
-				"isOpen":    true,
-				"startTime": "09:00",
-				"endTime":   "17:00",
-			},
-		},
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	hours, err := pc.placeService.GetPlaceHours(c.Request.Context(), userID, c.Param("placeId"))
+	if err != nil {
+		logrus.Errorf("Get place hours failed: %v", err)
+		handlePlaceHoursError(c, err, "Failed to get place hours")
+		return
 	}
+
 	utils.SuccessResponse(c, "Place hours retrieved", hours)
 }
 
+// UpdatePlaceHours changes a place's regular hours and timezone
 func (pc *PlaceController) UpdatePlaceHours(c *gin.Context) {
-	utils.SuccessResponse(c, "Place hours updated", nil)
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.UpdatePlaceHoursRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request body")
+		return
+	}
+
+	hours, err := pc.placeService.UpdatePlaceHours(c.Request.Context(), userID, c.Param("placeId"), req)
+	if err != nil {
+		logrus.Errorf("Update place hours failed: %v", err)
+		handlePlaceHoursError(c, err, "Failed to update place hours")
+		return
+	}
+
+	utils.SuccessResponse(c, "Place hours updated", hours)
 }
 
+// GetCurrentStatus returns whether a place is open now and when that
+// next changes
 func (pc *PlaceController) GetCurrentStatus(c *gin.Context) {
-	status := map[string]interface{}{
-		"isOpen":     true,
-		"nextChange": "17:00",
-		"status":     "open",
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	status, err := pc.placeService.GetCurrentStatus(c.Request.Context(), userID, c.Param("placeId"))
+	if err != nil {
+		logrus.Errorf("Get place status failed: %v", err)
+		handlePlaceHoursError(c, err, "Failed to get current status")
+		return
 	}
+
 	utils.SuccessResponse(c, "Current status retrieved", status)
 }
 
+// CreateHoursOverride changes a place's hours on one date
 func (pc *PlaceController) CreateHoursOverride(c *gin.Context) {
-	utils.SuccessResponse(c, "Hours override created", nil)
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.CreateHoursOverrideRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request body")
+		return
+	}
+
+	override, err := pc.placeService.CreateHoursOverride(c.Request.Context(), userID, c.Param("placeId"), req)
+	if err != nil {
+		logrus.Errorf("Create hours override failed: %v", err)
+		handlePlaceHoursError(c, err, "Failed to create hours override")
+		return
+	}
+
+	utils.CreatedResponse(c, "Hours override created", override)
+}
+
+// CreateRecurringHoursOverride changes a place's hours on recurring days,
+// such as holidays
+func (pc *PlaceController) CreateRecurringHoursOverride(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.CreateRecurringHoursOverrideRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request body")
+		return
+	}
+
+	override, err := pc.placeService.CreateRecurringHoursOverride(c.Request.Context(), userID, c.Param("placeId"), req)
+	if err != nil {
+		logrus.Errorf("Create recurring hours override failed: %v", err)
+		handlePlaceHoursError(c, err, "Failed to create hours override")
+		return
+	}
+
+	utils.CreatedResponse(c, "Hours override created", override)
 }
 
+// DeleteHoursOverride removes a dated or recurring override
 func (pc *PlaceController) DeleteHoursOverride(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	err := pc.placeService.DeleteHoursOverride(c.Request.Context(), userID, c.Param("placeId"), c.Param("overrideId"))
+	if err != nil {
+		logrus.Errorf("Delete hours override failed: %v", err)
+		handlePlaceHoursError(c, err, "Failed to delete hours override")
+		return
+	}
+
 	utils.SuccessResponse(c, "Hours override deleted", nil)
 }
 
+// GetHolidaySets lists the user's holiday sets
+func (pc *PlaceController) GetHolidaySets(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	sets, err := pc.placeService.GetHolidaySets(c.Request.Context(), userID)
+	if err != nil {
+		logrus.Errorf("Get holiday sets failed: %v", err)
+		handlePlaceHoursError(c, err, "Failed to get holiday sets")
+		return
+	}
+
+	utils.SuccessResponse(c, "Holiday sets retrieved successfully", sets)
+}
+
+// CreateHolidaySet creates a named list of holidays places can follow
+func (pc *PlaceController) CreateHolidaySet(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.HolidaySetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request body")
+		return
+	}
+
+	set, err := pc.placeService.CreateHolidaySet(c.Request.Context(), userID, req)
+	if err != nil {
+		logrus.Errorf("Create holiday set failed: %v", err)
+		handlePlaceHoursError(c, err, "Failed to create holiday set")
+		return
+	}
+
+	utils.CreatedResponse(c, "Holiday set created", set)
+}
+
+// UpdateHolidaySet replaces a holiday set's name and holidays
+func (pc *PlaceController) UpdateHolidaySet(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.HolidaySetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid request body")
+		return
+	}
+
+	set, err := pc.placeService.UpdateHolidaySet(c.Request.Context(), userID, c.Param("setId"), req)
+	if err != nil {
+		logrus.Errorf("Update holiday set failed: %v", err)
+		handlePlaceHoursError(c, err, "Failed to update holiday set")
+		return
+	}
+
+	utils.SuccessResponse(c, "Holiday set updated", set)
+}
+
+// DeleteHolidaySet deletes a holiday set no place follows
+func (pc *PlaceController) DeleteHolidaySet(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	err := pc.placeService.DeleteHolidaySet(c.Request.Context(), userID, c.Param("setId"))
+	if err != nil {
+		logrus.Errorf("Delete holiday set failed: %v", err)
+		handlePlaceHoursError(c, err, "Failed to delete holiday set")
+		return
+	}
+
+	utils.SuccessResponse(c, "Holiday set deleted", nil)
+}
+
+func handlePlaceHoursError(c *gin.Context, err error, fallback string) {
+	switch err.Error() {
+	case "invalid place ID", "invalid override ID", "invalid holiday set ID", "invalid user ID":
+		utils.BadRequestResponse(c, "Invalid ID")
+	case "validation failed":
+		reason := utils.ValidationFailureReason(err)
+		if reason == "" {
+			reason = "Invalid hours"
+		}
+		utils.BadRequestResponse(c, reason)
+	case "place not found":
+		utils.NotFoundResponse(c, "Place")
+	case "hours override not found":
+		utils.NotFoundResponse(c, "Hours override")
+	case "holiday set not found":
+		utils.NotFoundResponse(c, "Holiday set")
+	case "hours override already exists":
+		utils.ConflictResponse(c, "The place already has an override on that date")
+	case "holiday set in use":
+		utils.ConflictResponse(c, "Places still follow this holiday set")
+	case "access denied":
+		utils.ForbiddenResponse(c, "Access denied")
+	default:
+		utils.InternalServerErrorResponse(c, fallback)
+	}
+}
+
 func (pc *PlaceController) UpdateAutomationRule(c *gin.Context) {
 	utils.SuccessResponse(c, "Automation rule updated", nil)
 }
@@ -692,6 +1926,12 @@ func (pc *PlaceController) GetAvailableTriggers(c *gin.Context) {
 			"description":   "Triggered when user leaves a place",
 			"requiresPlace": true,
 		},
+		{
+			"type":          models.AutomationRulePlaceDND,
+			"name":          "Do Not Disturb at Place",
+			"description":   "Turns on do not disturb while user is at a place and off when they leave",
+			"requiresPlace": true,
+		},
 		{
 			"type":          "schedule",
 			"name":          "Time-based Schedule",
@@ -746,6 +1986,12 @@ func (pc *PlaceController) GetAvailableActions(c *gin.Context) {
 			"description":  "Send message to circle chat",
 			"configFields": []string{"message", "circleId"},
 		},
+		{
+			"type":         models.RuleActionDoNotDisturb,
+			"name":         "Do Not Disturb",
+			"description":  "Hold back notifications, all or only some types or circles",
+			"configFields": []string{"types", "circleIds"},
+		},
 	}
 	utils.SuccessResponse(c, "Available actions retrieved", actions)
 }
@@ -780,19 +2026,120 @@ func (pc *PlaceController) UpdatePlaceReview(c *gin.Context) {
 	utils.SuccessResponse(c, "Review updated successfully", nil)
 }
 
-func (pc *PlaceController) DeletePlaceReview(c *gin.Context) {
-	utils.SuccessResponse(c, "Review deleted successfully", nil)
-}
+func (pc *PlaceController) DeletePlaceReview(c *gin.Context) {
+	utils.SuccessResponse(c, "Review deleted successfully", nil)
+}
+
+// GetReviewStats summarizes a place's reviews, leaving out moderated ones
+func (pc *PlaceController) GetReviewStats(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	stats, err := pc.placeService.GetReviewStats(c.Request.Context(), userID, c.Param("placeId"))
+	if err != nil {
+		logrus.Errorf("Get review stats failed: %v", err)
+		utils.HandleServiceError(c, err)
+		return
+	}
+
+	utils.SuccessResponse(c, "Review statistics retrieved", stats)
+}
+
+// ReportPlaceReview reports a review to the moderators
+func (pc *PlaceController) ReportPlaceReview(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.ReportPlaceReviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid report data")
+		return
+	}
+
+	report, err := pc.placeService.ReportReview(c.Request.Context(), userID, c.Param("placeId"), c.Param("reviewId"), req)
+	if err != nil {
+		logrus.Errorf("Report place review failed: %v", err)
+		handleReviewReportError(c, err, "Failed to report review")
+		return
+	}
+
+	utils.CreatedResponse(c, "Review reported successfully", report)
+}
+
+// GetReviewReports lists place review reports (admin only)
+func (pc *PlaceController) GetReviewReports(c *gin.Context) {
+	pagination := utils.ParsePagination(c)
+
+	result, err := pc.placeService.GetReviewReports(c.Request.Context(), c.Query("status"), pagination.Page, pagination.PageSize)
+	if err != nil {
+		logrus.Errorf("Get review reports failed: %v", err)
+		handleReviewReportError(c, err, "Failed to get review reports")
+		return
+	}
+
+	utils.SuccessResponse(c, "Review reports retrieved successfully", result)
+}
+
+// HandleReviewReport hides or restores a reported review, or dismisses the
+// report (admin only)
+func (pc *PlaceController) HandleReviewReport(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.HandleReviewReportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid moderation data")
+		return
+	}
+
+	report, err := pc.placeService.HandleReviewReport(c.Request.Context(), userID, c.Param("reportId"), req)
+	if err != nil {
+		logrus.Errorf("Handle review report failed: %v", err)
+		handleReviewReportError(c, err, "Failed to handle review report")
+		return
+	}
 
-func (pc *PlaceController) GetReviewStats(c *gin.Context) {
-	stats := map[string]interface{}{
-		"averageRating": 4.2,
-		"totalReviews":  15,
-		"ratingDistribution": map[string]int{
-			"5": 8, "4": 4, "3": 2, "2": 1, "1": 0,
-		},
+	utils.SuccessResponse(c, "Review report handled", report)
+}
+
+func handleReviewReportError(c *gin.Context, err error, fallback string) {
+	switch err.Error() {
+	case "invalid place ID":
+		utils.BadRequestResponse(c, "Invalid place ID")
+	case "invalid review ID":
+		utils.BadRequestResponse(c, "Invalid review ID")
+	case "invalid report ID":
+		utils.BadRequestResponse(c, "Invalid report ID")
+	case "validation failed":
+		utils.BadRequestResponse(c, "Invalid report data")
+	case "invalid report status":
+		utils.BadRequestResponse(c, "Invalid report status")
+	case "cannot report own review":
+		utils.BadRequestResponse(c, "You can't report your own review")
+	case "place not found":
+		utils.NotFoundResponse(c, "Place")
+	case "review not found":
+		utils.NotFoundResponse(c, "Review")
+	case "report not found":
+		utils.NotFoundResponse(c, "Report")
+	case "access denied":
+		utils.ForbiddenResponse(c, "Access denied")
+	case "already reported":
+		utils.ConflictResponse(c, "You have already reported this review")
+	case "already reviewed":
+		utils.ConflictResponse(c, "The author has another visible review of this place")
+	default:
+		utils.InternalServerErrorResponse(c, fallback)
 	}
-	utils.SuccessResponse(c, "Review statistics retrieved", stats)
 }
 
 func (pc *PlaceController) MarkReviewHelpful(c *gin.Context) {
@@ -808,8 +2155,28 @@ func (pc *PlaceController) GetCheckin(c *gin.Context) {
 	utils.SuccessResponse(c, "Checkin retrieved", checkin)
 }
 
+// UpdateCheckin changes who sees a check-in
 func (pc *PlaceController) UpdateCheckin(c *gin.Context) {
-	utils.SuccessResponse(c, "Checkin updated successfully", nil)
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.UpdateCheckinRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid checkin data")
+		return
+	}
+
+	checkin, err := pc.placeService.UpdateCheckinVisibility(c.Request.Context(), userID, c.Param("placeId"), c.Param("checkinId"), req)
+	if err != nil {
+		logrus.Errorf("Update checkin failed: %v", err)
+		handleCheckinError(c, err, "Failed to update checkin")
+		return
+	}
+
+	utils.SuccessResponse(c, "Checkin updated successfully", checkin)
 }
 
 func (pc *PlaceController) DeleteCheckin(c *gin.Context) {
@@ -817,10 +2184,19 @@ func (pc *PlaceController) DeleteCheckin(c *gin.Context) {
 }
 
 func (pc *PlaceController) GetCheckinLeaderboard(c *gin.Context) {
-	leaderboard := []map[string]interface{}{
-		{"userId": "user1", "checkins": 25, "rank": 1},
-		{"userId": "user2", "checkins": 18, "rank": 2},
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	leaderboard, err := pc.placeService.GetCheckinLeaderboard(c.Request.Context(), userID, c.Param("placeId"))
+	if err != nil {
+		logrus.Errorf("Get checkin leaderboard failed: %v", err)
+		handleCheckinError(c, err, "Failed to get checkin leaderboard")
+		return
 	}
+
 	utils.SuccessResponse(c, "Checkin leaderboard retrieved", leaderboard)
 }
 
@@ -839,11 +2215,40 @@ func (pc *PlaceController) GetNearbyRecommendations(c *gin.Context) {
 	utils.SuccessResponse(c, "Nearby recommendations retrieved", recommendations)
 }
 
+// GetTrendingPlaces ranks the places the user can see by how busy they got
+// lately
 func (pc *PlaceController) GetTrendingPlaces(c *gin.Context) {
-	trending := []map[string]interface{}{
-		{"placeId": "place3", "trendScore": 95, "recentVisits": 42},
+	pc.getPlaceTrends(c, "trending", "Trending places retrieved", pc.placeService.GetTrendingPlaces)
+}
+
+func (pc *PlaceController) getPlaceTrends(c *gin.Context, kind, message string, rank func(context.Context, string, models.GetPlaceTrendsRequest) (*models.PlaceTrendsResponse, error)) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.GetPlaceTrendsRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid query parameters")
+		return
+	}
+
+	trends, err := rank(c.Request.Context(), userID, req)
+	if err != nil {
+		switch err.Error() {
+		case "validation failed":
+			utils.BadRequestResponse(c, "Days must be 1-365 and limit 1-50")
+		case "invalid user ID":
+			utils.BadRequestResponse(c, "Invalid user ID")
+		default:
+			logrus.Errorf("Get %s places failed: %v", kind, err)
+			utils.InternalServerErrorResponse(c, "Failed to get "+kind+" places")
+		}
+		return
 	}
-	utils.SuccessResponse(c, "Trending places retrieved", trending)
+
+	utils.SuccessResponse(c, message, trends)
 }
 
 func (pc *PlaceController) GetSimilarPlaces(c *gin.Context) {
@@ -894,25 +2299,156 @@ func (pc *PlaceController) RemovePlaceFromCollection(c *gin.Context) {
 }
 
 func (pc *PlaceController) ImportPlaces(c *gin.Context) {
-	result := map[string]interface{}{
-		"imported": 10,
-		"failed":   2,
-		"total":    12,
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		utils.BadRequestResponse(c, "File is required")
+		return
+	}
+	defer file.Close()
+
+	result, err := pc.placeService.ImportPlaces(c.Request.Context(), userID, file, header.Header.Get("Content-Type"), header.Filename)
+	if err != nil {
+		switch err.Error() {
+		case "invalid file format":
+			utils.BadRequestResponse(c, "Import file must be a JSON or CSV place export")
+		case "file too large":
+			utils.BadRequestResponse(c, "Import file is too large")
+		case "validation failed":
+			utils.BadRequestResponse(c, "Incompatible export: "+utils.ValidationFailureReason(err))
+		default:
+			logrus.Errorf("Import places failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to import places")
+		}
+		return
 	}
+
 	utils.SuccessResponse(c, "Places imported", result)
 }
 
 func (pc *PlaceController) ExportPlaces(c *gin.Context) {
-	export := map[string]interface{}{
-		"exportId":            "export123",
-		"status":              "processing",
-		"estimatedCompletion": "2025-01-01T12:05:00Z",
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.ExportPlacesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		// Allow empty body for default export
+		req = models.ExportPlacesRequest{
+			Format: "json",
+		}
+	}
+
+	export, err := pc.placeService.ExportPlaces(c.Request.Context(), userID, req)
+	if err != nil {
+		logrus.Errorf("Export places failed: %v", err)
+		switch err.Error() {
+		case "invalid export format":
+			utils.BadRequestResponse(c, "Export format must be json or csv")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to start export")
+		}
+		return
+	}
+
+	utils.AcceptedResponse(c, "Place export started successfully", export)
+}
+
+// ExportPlaceAnalytics starts a CSV or PDF report of a place's visits,
+// check-ins and ratings over a date range
+func (pc *PlaceController) ExportPlaceAnalytics(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.ExportPlaceAnalyticsRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.BadRequestResponse(c, "Invalid request body")
+			return
+		}
+	}
+
+	export, err := pc.placeService.ExportPlaceAnalytics(c.Request.Context(), userID, c.Param("placeId"), req)
+	if err != nil {
+		logrus.Errorf("Export place analytics failed: %v", err)
+		switch err.Error() {
+		case "invalid place ID":
+			utils.BadRequestResponse(c, "Invalid place ID")
+		case "validation failed":
+			utils.BadRequestResponse(c, utils.ValidationFailureReason(err))
+		case "place not found":
+			utils.NotFoundResponse(c, "Place")
+		case "access denied":
+			utils.ForbiddenResponse(c, "Only the place's owner and admins can export its analytics")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to start export")
+		}
+		return
 	}
-	utils.SuccessResponse(c, "Export started", export)
+
+	utils.AcceptedResponse(c, "Place analytics export started successfully", export)
 }
 
 func (pc *PlaceController) DownloadPlaceExport(c *gin.Context) {
-	utils.SuccessResponse(c, "Export download ready", nil)
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	exportID := c.Param("exportId")
+	if exportID == "" {
+		utils.BadRequestResponse(c, "Export ID is required")
+		return
+	}
+
+	exportData, err := pc.placeService.DownloadPlaceExport(c.Request.Context(), userID, exportID)
+	if err != nil {
+		logrus.Errorf("Download place export failed: %v", err)
+		switch err.Error() {
+		case "export not found":
+			utils.NotFoundResponse(c, "Export")
+		case "export not ready":
+			utils.BadRequestResponse(c, "Export is not ready for download")
+		case "access denied":
+			utils.ForbiddenResponse(c, "You can only download your own exports")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to download export")
+		}
+		return
+	}
+
+	// Set headers for file download
+	c.Header("Content-Disposition", "attachment; filename="+exportData.Filename)
+	c.Header("Content-Type", exportData.ContentType)
+	c.Data(200, exportData.ContentType, exportData.Data)
+}
+
+func (pc *PlaceController) DownloadPlaceExportManifest(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	manifest, err := pc.placeService.DownloadPlaceExportManifest(c.Request.Context(), userID, c.Param("exportId"))
+	if err != nil {
+		handleExportManifestError(c, err)
+		return
+	}
+
+	c.Header("Content-Disposition", "attachment; filename="+manifest.Filename)
+	c.Data(200, manifest.ContentType, manifest.Data)
 }
 
 func (pc *PlaceController) GetImportTemplates(c *gin.Context) {
@@ -932,35 +2468,243 @@ func (pc *PlaceController) BulkCreatePlaces(c *gin.Context) {
 }
 
 func (pc *PlaceController) GetPlaceTemplates(c *gin.Context) {
-	templates := []map[string]interface{}{
-		{"id": "template1", "name": "Coffee Shop", "category": "food"},
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	templates, err := pc.placeService.GetUserTemplates(c.Request.Context(), userID)
+	if err != nil {
+		logrus.Errorf("Get place templates failed: %v", err)
+		handlePlaceTemplateError(c, err, "Failed to get place templates")
+		return
 	}
+
 	utils.SuccessResponse(c, "Place templates retrieved", templates)
 }
 
 func (pc *PlaceController) CreatePlaceTemplate(c *gin.Context) {
-	utils.SuccessResponse(c, "Place template created", nil)
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.CreatePlaceTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid template data")
+		return
+	}
+
+	template, err := pc.placeService.CreateTemplate(c.Request.Context(), userID, req)
+	if err != nil {
+		logrus.Errorf("Create place template failed: %v", err)
+		handlePlaceTemplateError(c, err, "Failed to create place template")
+		return
+	}
+
+	utils.CreatedResponse(c, "Place template created", template)
 }
 
 func (pc *PlaceController) GetPlaceTemplate(c *gin.Context) {
-	template := map[string]interface{}{
-		"id":       c.Param("templateId"),
-		"name":     "Template Name",
-		"settings": map[string]interface{}{},
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	template, err := pc.placeService.GetTemplate(c.Request.Context(), userID, c.Param("templateId"))
+	if err != nil {
+		logrus.Errorf("Get place template failed: %v", err)
+		handlePlaceTemplateError(c, err, "Failed to get place template")
+		return
 	}
+
 	utils.SuccessResponse(c, "Place template retrieved", template)
 }
 
 func (pc *PlaceController) UpdatePlaceTemplate(c *gin.Context) {
-	utils.SuccessResponse(c, "Place template updated", nil)
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.UpdatePlaceTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid template data")
+		return
+	}
+
+	template, err := pc.placeService.UpdateTemplate(c.Request.Context(), userID, c.Param("templateId"), req)
+	if err != nil {
+		logrus.Errorf("Update place template failed: %v", err)
+		handlePlaceTemplateError(c, err, "Failed to update place template")
+		return
+	}
+
+	utils.SuccessResponse(c, "Place template updated", template)
 }
 
 func (pc *PlaceController) DeletePlaceTemplate(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	err := pc.placeService.DeleteTemplate(c.Request.Context(), userID, c.Param("templateId"))
+	if err != nil {
+		logrus.Errorf("Delete place template failed: %v", err)
+		handlePlaceTemplateError(c, err, "Failed to delete place template")
+		return
+	}
+
 	utils.SuccessResponse(c, "Place template deleted", nil)
 }
 
 func (pc *PlaceController) UsePlaceTemplate(c *gin.Context) {
-	utils.SuccessResponse(c, "Template applied successfully", nil)
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.UsePlaceTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid place data")
+		return
+	}
+
+	place, err := pc.placeService.UsePlaceTemplate(c.Request.Context(), userID, c.Param("templateId"), req)
+	if err != nil {
+		if duplicatePlaceResponse(c, err) {
+			return
+		}
+		logrus.Errorf("Use place template failed: %v", err)
+		handlePlaceTemplateError(c, err, "Failed to apply template")
+		return
+	}
+
+	utils.CreatedResponse(c, "Template applied successfully", place)
+}
+
+// PublishPlaceTemplate submits a template to the shared gallery
+func (pc *PlaceController) PublishPlaceTemplate(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	template, err := pc.placeService.PublishTemplate(c.Request.Context(), userID, c.Param("templateId"))
+	if err != nil {
+		logrus.Errorf("Publish place template failed: %v", err)
+		handlePlaceTemplateError(c, err, "Failed to publish place template")
+		return
+	}
+
+	utils.AcceptedResponse(c, "Place template submitted for review", template)
+}
+
+// UnpublishPlaceTemplate removes a template from the shared gallery
+func (pc *PlaceController) UnpublishPlaceTemplate(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	err := pc.placeService.UnpublishTemplate(c.Request.Context(), userID, c.Param("templateId"))
+	if err != nil {
+		logrus.Errorf("Unpublish place template failed: %v", err)
+		handlePlaceTemplateError(c, err, "Failed to unpublish place template")
+		return
+	}
+
+	utils.SuccessResponse(c, "Place template unpublished", nil)
+}
+
+// GetTemplateGallery lists approved shared templates
+func (pc *PlaceController) GetTemplateGallery(c *gin.Context) {
+	var req models.GetTemplateGalleryRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid query parameters")
+		return
+	}
+
+	result, err := pc.placeService.GetTemplateGallery(c.Request.Context(), req)
+	if err != nil {
+		logrus.Errorf("Get template gallery failed: %v", err)
+		utils.InternalServerErrorResponse(c, "Failed to get template gallery")
+		return
+	}
+
+	utils.SuccessResponse(c, "Template gallery retrieved", result)
+}
+
+// GetTemplatesForModeration lists gallery submissions (admin only)
+func (pc *PlaceController) GetTemplatesForModeration(c *gin.Context) {
+	pagination := utils.ParsePagination(c)
+
+	result, err := pc.placeService.GetTemplatesForModeration(c.Request.Context(), c.Query("status"), pagination.Page, pagination.PageSize)
+	if err != nil {
+		logrus.Errorf("Get templates for moderation failed: %v", err)
+		handlePlaceTemplateError(c, err, "Failed to get templates")
+		return
+	}
+
+	utils.SuccessResponse(c, "Templates retrieved successfully", result)
+}
+
+// ModeratePlaceTemplate approves or rejects a gallery submission (admin only)
+func (pc *PlaceController) ModeratePlaceTemplate(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.ModeratePlaceTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid moderation data")
+		return
+	}
+
+	template, err := pc.placeService.ModerateTemplate(c.Request.Context(), userID, c.Param("templateId"), req)
+	if err != nil {
+		logrus.Errorf("Moderate place template failed: %v", err)
+		handlePlaceTemplateError(c, err, "Failed to moderate place template")
+		return
+	}
+
+	utils.SuccessResponse(c, "Place template moderated", template)
+}
+
+func handlePlaceTemplateError(c *gin.Context, err error, fallback string) {
+	switch err.Error() {
+	case "invalid template ID":
+		utils.BadRequestResponse(c, "Invalid template ID")
+	case "template not found":
+		utils.NotFoundResponse(c, "Place template")
+	case "access denied":
+		utils.ForbiddenResponse(c, "Access denied")
+	case "validation failed":
+		utils.BadRequestResponse(c, "Invalid template data")
+	case "invalid moderation status":
+		utils.BadRequestResponse(c, "Invalid moderation status")
+	case "template already published":
+		utils.ConflictResponse(c, "Template is already published")
+	case "template not published":
+		utils.BadRequestResponse(c, "Template is not published")
+	case "invalid coordinates":
+		utils.BadRequestResponse(c, "Invalid coordinates")
+	case "radius must be between 10 and 5000 meters":
+		utils.BadRequestResponse(c, "Radius must be between 10 and 5000 meters")
+	default:
+		utils.InternalServerErrorResponse(c, fallback)
+	}
 }
 
 func (pc *PlaceController) GetPlaceStats(c *gin.Context) {
@@ -1023,7 +2767,7 @@ func (pc *PlaceController) UpdatePlace(c *gin.Context) {
 
 	var req models.UpdatePlaceRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid update data")
+		utils.BindErrorResponse(c, "Invalid update data", err)
 		return
 	}
 
@@ -0,0 +1,212 @@
+package controllers
+
+import (
+	"fmt"
+
+	"ftrack/models"
+	"ftrack/services"
+	"ftrack/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+type PlaceListController struct {
+	placeListService *services.PlaceListService
+}
+
+func NewPlaceListController(placeListService *services.PlaceListService) *PlaceListController {
+	return &PlaceListController{
+		placeListService: placeListService,
+	}
+}
+
+func (plc *PlaceListController) GetPlaceLists(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.GetPlaceListsRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid query parameters")
+		return
+	}
+
+	lists, err := plc.placeListService.GetLists(c.Request.Context(), userID, c.Param("circleId"), req)
+	if err != nil {
+		placeListErrorResponse(c, err, "Failed to get lists")
+		return
+	}
+
+	utils.SuccessResponse(c, "Lists retrieved", lists)
+}
+
+func (plc *PlaceListController) CreatePlaceList(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.CreatePlaceListRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BindErrorResponse(c, "Invalid request body", err)
+		return
+	}
+
+	list, err := plc.placeListService.CreateList(c.Request.Context(), userID, c.Param("circleId"), req)
+	if err != nil {
+		placeListErrorResponse(c, err, "Failed to create list")
+		return
+	}
+
+	utils.CreatedResponse(c, "List created", list)
+}
+
+func (plc *PlaceListController) GetPlaceList(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	list, err := plc.placeListService.GetList(c.Request.Context(), userID, c.Param("circleId"), c.Param("listId"))
+	if err != nil {
+		placeListErrorResponse(c, err, "Failed to get list")
+		return
+	}
+
+	utils.SuccessResponse(c, "List retrieved", list)
+}
+
+func (plc *PlaceListController) UpdatePlaceList(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.UpdatePlaceListRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BindErrorResponse(c, "Invalid request body", err)
+		return
+	}
+
+	list, err := plc.placeListService.UpdateList(c.Request.Context(), userID, c.Param("circleId"), c.Param("listId"), req)
+	if err != nil {
+		placeListErrorResponse(c, err, "Failed to update list")
+		return
+	}
+
+	utils.SuccessResponse(c, "List updated", list)
+}
+
+func (plc *PlaceListController) DeletePlaceList(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	if err := plc.placeListService.DeleteList(c.Request.Context(), userID, c.Param("circleId"), c.Param("listId")); err != nil {
+		placeListErrorResponse(c, err, "Failed to delete list")
+		return
+	}
+
+	utils.SuccessResponse(c, "List deleted", nil)
+}
+
+func (plc *PlaceListController) AddPlaceListItem(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.PlaceListItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BindErrorResponse(c, "Invalid request body", err)
+		return
+	}
+
+	item, err := plc.placeListService.AddItem(c.Request.Context(), userID, c.Param("circleId"), c.Param("listId"), req)
+	if err != nil {
+		placeListErrorResponse(c, err, "Failed to add item")
+		return
+	}
+
+	utils.CreatedResponse(c, "Item added", item)
+}
+
+// UpdatePlaceListItem edits an item or checks it off
+func (plc *PlaceListController) UpdatePlaceListItem(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.UpdatePlaceListItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BindErrorResponse(c, "Invalid request body", err)
+		return
+	}
+
+	item, err := plc.placeListService.UpdateItem(c.Request.Context(), userID, c.Param("circleId"), c.Param("listId"), c.Param("itemId"), req)
+	if err != nil {
+		placeListErrorResponse(c, err, "Failed to update item")
+		return
+	}
+
+	utils.SuccessResponse(c, "Item updated", item)
+}
+
+func (plc *PlaceListController) RemovePlaceListItem(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	if err := plc.placeListService.RemoveItem(c.Request.Context(), userID, c.Param("circleId"), c.Param("listId"), c.Param("itemId")); err != nil {
+		placeListErrorResponse(c, err, "Failed to remove item")
+		return
+	}
+
+	utils.SuccessResponse(c, "Item removed", nil)
+}
+
+// placeListErrorResponse answers the errors the list endpoints share
+func placeListErrorResponse(c *gin.Context, err error, failure string) {
+	switch err.Error() {
+	case "validation failed":
+		utils.ValidationFailedResponse(c, "Invalid list", err)
+	case "invalid circle ID":
+		utils.BadRequestResponse(c, "Invalid circle ID")
+	case "invalid list ID":
+		utils.BadRequestResponse(c, "Invalid list ID")
+	case "invalid item ID":
+		utils.BadRequestResponse(c, "Invalid item ID")
+	case "invalid place ID":
+		utils.BadRequestResponse(c, "Invalid place ID")
+	case "access denied":
+		utils.ForbiddenResponse(c, "You can't make this change to the list")
+	case "circle is archived":
+		utils.ConflictResponse(c, "This circle was merged into another circle and is read-only")
+	case "list not found":
+		utils.NotFoundResponse(c, "List")
+	case "item not found":
+		utils.NotFoundResponse(c, "Item")
+	case "place not found":
+		utils.NotFoundResponse(c, "Place")
+	case "list limit reached":
+		utils.ConflictResponse(c, fmt.Sprintf("A circle can have at most %d lists that aren't archived", models.MaxCirclePlaceLists))
+	case "list is full":
+		utils.ConflictResponse(c, fmt.Sprintf("A list can have at most %d items", models.MaxPlaceListItems))
+	default:
+		logrus.Errorf("%s: %v", failure, err)
+		utils.InternalServerErrorResponse(c, failure)
+	}
+}
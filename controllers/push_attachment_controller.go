@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"ftrack/models"
+	"ftrack/services"
+	"ftrack/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+type PushAttachmentController struct {
+	attachmentService *services.PushAttachmentService
+}
+
+func NewPushAttachmentController(attachmentService *services.PushAttachmentService) *PushAttachmentController {
+	return &PushAttachmentController{
+		attachmentService: attachmentService,
+	}
+}
+
+// GetAttachment serves the image of a push notification. Devices fetch it
+// without a session, so the signed URL is the only authorization.
+func (pac *PushAttachmentController) GetAttachment(c *gin.Context) {
+	attachment := models.NotificationAttachment{
+		Kind:       c.Param("kind"),
+		ResourceID: c.Param("id"),
+	}
+
+	err := utils.VerifySignedURL(services.PushAttachmentPath(attachment), c.Query("expires"), c.Query("signature"))
+	if err != nil {
+		switch err.Error() {
+		case "link expired":
+			utils.ErrorResponse(c, http.StatusGone, "Attachment link has expired", nil)
+		case "invalid signature":
+			utils.ForbiddenResponse(c, "Invalid attachment link")
+		default:
+			logrus.Errorf("Verify attachment link failed: %v", err)
+			utils.NotFoundResponse(c, "Attachment")
+		}
+		return
+	}
+
+	image, err := pac.attachmentService.GetAttachment(c.Request.Context(), attachment.Kind, attachment.ResourceID)
+	if err != nil {
+		if err.Error() != "attachment not found" {
+			logrus.Errorf("Get push attachment failed: %v", err)
+		}
+		utils.NotFoundResponse(c, "Attachment")
+		return
+	}
+
+	c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", int(services.PushAttachmentURLTTL.Seconds())))
+	if image.RedirectURL != "" {
+		c.Redirect(http.StatusFound, image.RedirectURL)
+		return
+	}
+
+	c.Data(http.StatusOK, image.ContentType, image.Data)
+}
@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/xml"
+	"net/http"
+
+	"ftrack/models"
+	"ftrack/services"
+	"ftrack/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+type SMSCommandController struct {
+	smsCommandService *services.SMSCommandService
+}
+
+func NewSMSCommandController(smsCommandService *services.SMSCommandService) *SMSCommandController {
+	return &SMSCommandController{
+		smsCommandService: smsCommandService,
+	}
+}
+
+// HandleInboundSMS is Twilio's webhook for texts to our number. The
+// request signature is the only authorization; the reply goes back as
+// TwiML.
+func (scc *SMSCommandController) HandleInboundSMS(c *gin.Context) {
+	if err := c.Request.ParseForm(); err != nil {
+		utils.BadRequestResponse(c, "Invalid request body")
+		return
+	}
+
+	if !scc.smsCommandService.VerifyWebhook(c.Request.PostForm, c.GetHeader("X-Twilio-Signature")) {
+		utils.ForbiddenResponse(c, "Invalid webhook signature")
+		return
+	}
+
+	form := c.Request.PostForm
+	reply := scc.smsCommandService.HandleInbound(c.Request.Context(), models.InboundSMS{
+		MessageSID: form.Get("MessageSid"),
+		From:       form.Get("From"),
+		To:         form.Get("To"),
+		Body:       form.Get("Body"),
+	})
+
+	c.Data(http.StatusOK, "application/xml; charset=utf-8", twimlMessage(reply))
+}
+
+// GetSMSCommandStatus returns whether the user's number answers commands
+func (scc *SMSCommandController) GetSMSCommandStatus(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	status, err := scc.smsCommandService.GetStatus(c.Request.Context(), userID)
+	if err != nil {
+		logrus.Errorf("Get SMS command status failed: %v", err)
+		utils.InternalServerErrorResponse(c, "Failed to get SMS command status")
+		return
+	}
+
+	utils.SuccessResponse(c, "SMS command status retrieved successfully", status)
+}
+
+// EnableSMSCommands starts the opt-in: the user texts the returned code
+// from their verified number to turn commands on
+func (scc *SMSCommandController) EnableSMSCommands(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	optIn, err := scc.smsCommandService.StartOptIn(c.Request.Context(), userID)
+	if err != nil {
+		switch err.Error() {
+		case "phone number not verified":
+			utils.BadRequestResponse(c, "Verify your phone number before turning on SMS commands")
+		case "SMS commands not available":
+			utils.ErrorResponse(c, http.StatusServiceUnavailable, "SMS commands are not available", nil)
+		default:
+			logrus.Errorf("Enable SMS commands failed: %v", err)
+			utils.InternalServerErrorResponse(c, "Failed to enable SMS commands")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Text the code from your phone to finish turning on SMS commands", optIn)
+}
+
+// DisableSMSCommands turns SMS commands off
+func (scc *SMSCommandController) DisableSMSCommands(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	if err := scc.smsCommandService.DisableCommands(c.Request.Context(), userID); err != nil {
+		logrus.Errorf("Disable SMS commands failed: %v", err)
+		utils.InternalServerErrorResponse(c, "Failed to disable SMS commands")
+		return
+	}
+
+	utils.SuccessResponse(c, "SMS commands turned off", nil)
+}
+
+// twimlMessage is a TwiML response replying with the message, or sending
+// nothing when it is empty
+func twimlMessage(message string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.WriteString("<Response>")
+	if message != "" {
+		buf.WriteString("<Message>")
+		xml.EscapeText(&buf, []byte(message))
+		buf.WriteString("</Message>")
+	}
+	buf.WriteString("</Response>")
+	return buf.Bytes()
+}
@@ -5,6 +5,7 @@ import (
 	"ftrack/models"
 	"ftrack/services"
 	"ftrack/utils"
+	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
@@ -12,12 +13,14 @@ import (
 )
 
 type UserController struct {
-	userService *services.UserService
+	userService         *services.UserService
+	deactivationService *services.AccountDeactivationService
 }
 
-func NewUserController(userService *services.UserService) *UserController {
+func NewUserController(userService *services.UserService, deactivationService *services.AccountDeactivationService) *UserController {
 	return &UserController{
-		userService: userService,
+		userService:         userService,
+		deactivationService: deactivationService,
 	}
 }
 
@@ -77,7 +80,7 @@ func (uc *UserController) UpdateCurrentUser(c *gin.Context) {
 
 	var req models.UpdateUserRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request body")
+		utils.BindErrorResponse(c, "Invalid request body", err)
 		return
 	}
 
@@ -86,7 +89,7 @@ func (uc *UserController) UpdateCurrentUser(c *gin.Context) {
 		logrus.Errorf("Update current user failed: %v", err)
 		switch err.Error() {
 		case "validation failed":
-			utils.BadRequestResponse(c, "Invalid user data")
+			utils.ValidationFailedResponse(c, "Invalid user data", err)
 		case "no fields to update":
 			utils.BadRequestResponse(c, "No fields provided for update")
 		case "user not found":
@@ -131,6 +134,57 @@ func (uc *UserController) DeleteCurrentUser(c *gin.Context) {
 	utils.SuccessResponse(c, "User account deleted successfully", nil)
 }
 
+// DeactivateCurrentUser deactivates the authenticated user's account
+// @Summary Deactivate current user
+// @Description Deactivate authenticated user's account until they log in again and confirm reactivation. Circles where the user is the only admin need a new owner first.
+// @Tags Users
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body models.DeactivateAccountRequest false "Deactivation reason"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Failure 401 {object} models.APIResponse
+// @Failure 409 {object} models.APIResponse
+// @Router /users/me/deactivate [post]
+func (uc *UserController) DeactivateCurrentUser(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		utils.UnauthorizedResponse(c, "User not authenticated")
+		return
+	}
+
+	var req models.DeactivateAccountRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.BadRequestResponse(c, "Invalid request body")
+			return
+		}
+	}
+
+	err := uc.deactivationService.DeactivateAccount(c.Request.Context(), userID, req)
+	if err != nil {
+		logrus.Errorf("Deactivate current user failed: %v", err)
+		if transferErr, ok := err.(services.OwnershipTransferRequiredError); ok {
+			utils.ErrorResponse(c, http.StatusConflict, "Transfer ownership of your circles before deactivating", transferErr)
+			return
+		}
+		switch err.Error() {
+		case "validation failed":
+			utils.BadRequestResponse(c, "Invalid deactivation data")
+		case "account already deactivated":
+			utils.ConflictResponse(c, "Account is already deactivated")
+		case "user not found":
+			utils.NotFoundResponse(c, "User")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to deactivate account")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, "Account deactivated successfully", nil)
+}
+
 // GetProfile gets user's profile
 // @Summary Get user profile
 // @Description Get authenticated user's profile information
@@ -183,7 +237,7 @@ func (uc *UserController) UpdateProfile(c *gin.Context) {
 
 	var req models.UpdateUserRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request body")
+		utils.BindErrorResponse(c, "Invalid request body", err)
 		return
 	}
 
@@ -192,7 +246,7 @@ func (uc *UserController) UpdateProfile(c *gin.Context) {
 		logrus.Errorf("Update profile failed: %v", err)
 		switch err.Error() {
 		case "validation failed":
-			utils.BadRequestResponse(c, "Invalid profile data")
+			utils.ValidationFailedResponse(c, "Invalid profile data", err)
 		case "no fields to update":
 			utils.BadRequestResponse(c, "No fields provided for update")
 		case "user not found":
@@ -693,10 +747,11 @@ func (uc *UserController) GetEmergencyContacts(c *gin.Context) {
 // @Security BearerAuth
 // @Accept json
 // @Produce json
-// @Param request body models.EmergencyContact true "Emergency contact data"
+// @Param request body models.AddEmergencyContactRequest true "Emergency contact data"
 // @Success 201 {object} models.APIResponse{data=models.EmergencyContact}
 // @Failure 400 {object} models.APIResponse
 // @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
 // @Router /users/me/emergency-contacts [post]
 func (uc *UserController) AddEmergencyContact(c *gin.Context) {
 	userID := c.GetString("userID")
@@ -705,13 +760,15 @@ func (uc *UserController) AddEmergencyContact(c *gin.Context) {
 		return
 	}
 
-	var contact models.EmergencyContact
-	if err := c.ShouldBindJSON(&contact); err != nil {
+	var req models.AddEmergencyContactRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		utils.BadRequestResponse(c, "Invalid emergency contact data")
 		return
 	}
+	req.IPAddress = c.ClientIP()
+	req.UserAgent = c.Request.UserAgent()
 
-	addedContact, err := uc.userService.AddEmergencyContact(c.Request.Context(), userID, contact)
+	addedContact, err := uc.userService.AddEmergencyContact(c.Request.Context(), userID, req)
 	if err != nil {
 		logrus.Errorf("Add emergency contact failed: %v", err)
 		switch err.Error() {
@@ -736,10 +793,11 @@ func (uc *UserController) AddEmergencyContact(c *gin.Context) {
 // @Accept json
 // @Produce json
 // @Param contactId path string true "Contact ID"
-// @Param request body models.EmergencyContact true "Updated emergency contact data"
+// @Param request body models.UpdateEmergencyContactRequest true "Updated emergency contact data"
 // @Success 200 {object} models.APIResponse{data=models.EmergencyContact}
 // @Failure 400 {object} models.APIResponse
 // @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
 // @Failure 404 {object} models.APIResponse
 // @Router /users/me/emergency-contacts/{contactId} [put]
 func (uc *UserController) UpdateEmergencyContact(c *gin.Context) {
@@ -755,13 +813,15 @@ func (uc *UserController) UpdateEmergencyContact(c *gin.Context) {
 		return
 	}
 
-	var contact models.EmergencyContact
-	if err := c.ShouldBindJSON(&contact); err != nil {
+	var req models.UpdateEmergencyContactRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		utils.BadRequestResponse(c, "Invalid emergency contact data")
 		return
 	}
+	req.IPAddress = c.ClientIP()
+	req.UserAgent = c.Request.UserAgent()
 
-	updatedContact, err := uc.userService.UpdateEmergencyContact(c.Request.Context(), userID, contactID, contact)
+	updatedContact, err := uc.userService.UpdateEmergencyContact(c.Request.Context(), userID, contactID, req)
 	if err != nil {
 		logrus.Errorf("Update emergency contact failed: %v", err)
 		switch err.Error() {
@@ -787,6 +847,7 @@ func (uc *UserController) UpdateEmergencyContact(c *gin.Context) {
 // @Param contactId path string true "Contact ID"
 // @Success 200 {object} models.APIResponse
 // @Failure 401 {object} models.APIResponse
+// @Failure 403 {object} models.APIResponse
 // @Failure 404 {object} models.APIResponse
 // @Router /users/me/emergency-contacts/{contactId} [delete]
 func (uc *UserController) DeleteEmergencyContact(c *gin.Context) {
@@ -802,7 +863,7 @@ func (uc *UserController) DeleteEmergencyContact(c *gin.Context) {
 		return
 	}
 
-	err := uc.userService.DeleteEmergencyContact(c.Request.Context(), userID, contactID)
+	err := uc.userService.DeleteEmergencyContact(c.Request.Context(), userID, contactID, c.ClientIP(), c.Request.UserAgent())
 	if err != nil {
 		logrus.Errorf("Delete emergency contact failed: %v", err)
 		switch err.Error() {
@@ -819,7 +880,7 @@ func (uc *UserController) DeleteEmergencyContact(c *gin.Context) {
 
 // VerifyEmergencyContact verifies an emergency contact
 // @Summary Verify emergency contact
-// @Description Send verification request to emergency contact
+// @Description Re-send the confirmation link to an emergency contact that has not answered yet
 // @Tags Users
 // @Security BearerAuth
 // @Produce json
@@ -849,6 +910,8 @@ func (uc *UserController) VerifyEmergencyContact(c *gin.Context) {
 			utils.NotFoundResponse(c, "Emergency contact")
 		case "already verified":
 			utils.BadRequestResponse(c, "Contact is already verified")
+		case "contact declined":
+			utils.BadRequestResponse(c, "Contact has declined to be an emergency contact")
 		default:
 			utils.InternalServerErrorResponse(c, "Failed to verify emergency contact")
 		}
@@ -858,6 +921,43 @@ func (uc *UserController) VerifyEmergencyContact(c *gin.Context) {
 	utils.SuccessResponse(c, "Verification request sent successfully", nil)
 }
 
+// RespondToEmergencyContactRequest records a contact's answer to a verification link
+// @Summary Respond to emergency contact request
+// @Description Confirm or decline being someone's emergency contact using the link they received
+// @Tags Users
+// @Produce json
+// @Param token query string true "Verification token"
+// @Param action query string true "confirm or decline"
+// @Success 200 {object} models.APIResponse
+// @Failure 400 {object} models.APIResponse
+// @Router /emergency-contacts/respond [get]
+func (uc *UserController) RespondToEmergencyContactRequest(c *gin.Context) {
+	var req models.EmergencyContactResponseRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.BadRequestResponse(c, "Invalid verification link")
+		return
+	}
+
+	err := uc.userService.RespondToEmergencyContactRequest(c.Request.Context(), req)
+	if err != nil {
+		logrus.Errorf("Respond to emergency contact request failed: %v", err)
+		switch err.Error() {
+		case "validation failed", "invalid or expired token":
+			utils.BadRequestResponse(c, "Invalid or expired verification link")
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to process response")
+		}
+		return
+	}
+
+	if req.Action == "decline" {
+		utils.SuccessResponse(c, "You will not be contacted as an emergency contact", nil)
+		return
+	}
+
+	utils.SuccessResponse(c, "Thank you for confirming as an emergency contact", nil)
+}
+
 // =============================================
 // DEVICE MANAGEMENT
 // =============================================
@@ -1489,6 +1589,7 @@ func (uc *UserController) GetBlockedUsers(c *gin.Context) {
 // @Failure 400 {object} models.APIResponse
 // @Failure 401 {object} models.APIResponse
 // @Failure 404 {object} models.APIResponse
+// @Router /users/{userId}/block [post]
 // @Router /users/me/moderation/block/{userId} [post]
 func (uc *UserController) BlockUser(c *gin.Context) {
 	userID := c.GetString("userID")
@@ -1503,10 +1604,13 @@ func (uc *UserController) BlockUser(c *gin.Context) {
 		return
 	}
 
+	// Reason is optional
 	var req models.BlockUserRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.BadRequestResponse(c, "Invalid request data")
-		return
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.BadRequestResponse(c, "Invalid request data")
+			return
+		}
 	}
 
 	blockedUser, err := uc.userService.BlockUser(c.Request.Context(), userID, targetUserID, req)
@@ -1518,7 +1622,9 @@ func (uc *UserController) BlockUser(c *gin.Context) {
 		case "cannot block yourself":
 			utils.BadRequestResponse(c, "Cannot block yourself")
 		case "already blocked":
-			utils.BadRequestResponse(c, "User is already blocked")
+			utils.ConflictResponse(c, "User is already blocked")
+		case "validation failed":
+			utils.BadRequestResponse(c, "Block reason is too long")
 		default:
 			utils.InternalServerErrorResponse(c, "Failed to block user")
 		}
@@ -1538,6 +1644,7 @@ func (uc *UserController) BlockUser(c *gin.Context) {
 // @Success 200 {object} models.APIResponse
 // @Failure 401 {object} models.APIResponse
 // @Failure 404 {object} models.APIResponse
+// @Router /users/{userId}/block [delete]
 // @Router /users/me/moderation/block/{userId} [delete]
 func (uc *UserController) UnblockUser(c *gin.Context) {
 	userID := c.GetString("userID")
@@ -1,11 +1,12 @@
 package controllers
 
 import (
+	"net/http"
+
 	"ftrack/models"
 	"ftrack/services"
 	"ftrack/utils"
 	"ftrack/websocket"
-	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
@@ -18,14 +19,9 @@ type WebSocketController struct {
 }
 
 func NewWebSocketController(hub *websocket.Hub, authService *services.AuthService) *WebSocketController {
-	upgrader := websocket.Upgrader{
-		ReadBufferSize:  1024,
-		WriteBufferSize: 1024,
-		CheckOrigin: func(r *http.Request) bool {
-			// In production, implement proper origin checking
-			return true
-		},
-	}
+	// Compression is negotiated per connection, clients that don't offer
+	// permessage-deflate get uncompressed frames
+	upgrader := websocket.NewUpgrader(1024, 1024)
 
 	return &WebSocketController{
 		hub:         hub,
@@ -38,43 +34,44 @@ func NewWebSocketController(hub *websocket.Hub, authService *services.AuthServic
 // @Summary WebSocket endpoint
 // @Description Establish WebSocket connection for real-time communication
 // @Tags WebSocket
-// @Param token query string true "Authentication token"
+// @Param ticket query string false "Ticket from /auth/ws-ticket"
+// @Param token query string false "Authentication token"
 // @Success 101 "Switching Protocols"
 // @Failure 400 {object} models.APIResponse
 // @Failure 401 {object} models.APIResponse
 // @Router /ws [get]
 func (wsc *WebSocketController) HandleWebSocket(c *gin.Context) {
-	// Get token from query parameter
-	token := c.Query("token")
-	if token == "" {
+	// Prefer a ticket, so the access token doesn't end up in URLs
+	var user *models.User
+	var err error
+	if ticket := c.Query("ticket"); ticket != "" {
+		user, err = wsc.authService.ValidateWSTicket(c.Request.Context(), ticket)
+	} else if token := c.Query("token"); token != "" {
+		user, err = wsc.authService.ValidateToken(c.Request.Context(), token)
+	} else {
 		utils.UnauthorizedResponse(c, "Authentication token is required")
 		return
 	}
-
-	// Validate token
-	claims, err := wsc.authService.ValidateToken(token)
 	if err != nil {
 		logrus.Errorf("WebSocket authentication failed: %v", err)
 		utils.UnauthorizedResponse(c, "Invalid authentication token")
 		return
 	}
 
-	// Get user information
-	user, err := wsc.authService.GetUserByID(c.Request.Context(), claims.UserID)
-	if err != nil {
-		logrus.Errorf("Failed to get user for WebSocket: %v", err)
-		utils.UnauthorizedResponse(c, "User not found")
-		return
-	}
-
 	// Check if user is active
 	if !user.IsActive {
 		utils.UnauthorizedResponse(c, "Account is deactivated")
 		return
 	}
 
+	// Clients retry elsewhere while the server restarts
+	if wsc.hub.IsShuttingDown() {
+		utils.ErrorResponse(c, http.StatusServiceUnavailable, "Server is restarting, reconnect shortly", nil)
+		return
+	}
+
 	// Upgrade HTTP connection to WebSocket
-	conn, err := wsc.upgrader.Upgrade(c.Writer, c.Request, nil)
+	conn, err := websocket.Upgrade(&wsc.upgrader, c.Writer, c.Request)
 	if err != nil {
 		logrus.Errorf("Failed to upgrade WebSocket connection: %v", err)
 		utils.BadRequestResponse(c, "Failed to establish WebSocket connection")
@@ -0,0 +1,283 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"ftrack/utils"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// Circuit breaker states
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half_open"
+)
+
+// CircuitBreakerConfig controls when the database circuit opens and how
+// long it stays open
+type CircuitBreakerConfig struct {
+	// Consecutive connection failures that open the circuit
+	FailureThreshold int `json:"failureThreshold"`
+
+	// Time the circuit stays open before a probe checks the database
+	Cooldown time.Duration `json:"cooldown"`
+
+	// Longest a probe may take
+	ProbeTimeout time.Duration `json:"probeTimeout"`
+}
+
+// CircuitBreakerStats are the breaker's state and counters since start
+type CircuitBreakerStats struct {
+	State               string           `json:"state"`
+	Since               time.Time        `json:"since"`
+	ConsecutiveFailures int              `json:"consecutiveFailures"`
+	Transitions         map[string]int64 `json:"transitions"` // by state entered
+	Rejected            int64            `json:"rejected"`
+	LastFailure         string           `json:"lastFailure,omitempty"`
+}
+
+// CircuitBreaker stops requests and workers from hitting MongoDB during an
+// outage. It watches the driver's connection pool and topology, so every
+// repository call counts without wrapping each one. Once open, a ping probes
+// the primary after the cooldown and closes the circuit when it answers.
+type CircuitBreaker struct {
+	config CircuitBreakerConfig
+	probe  func(ctx context.Context) error
+
+	mutex               sync.Mutex
+	state               string
+	since               time.Time
+	consecutiveFailures int
+	transitions         map[string]int64
+	rejected            int64
+	lastFailure         string
+}
+
+// Breaker guards the database for the API and the workers
+var Breaker = NewCircuitBreaker(CircuitBreakerConfig{
+	FailureThreshold: 5,
+	Cooldown:         10 * time.Second,
+	ProbeTimeout:     2 * time.Second,
+})
+
+func NewCircuitBreaker(config CircuitBreakerConfig) *CircuitBreaker {
+	return &CircuitBreaker{
+		config:      config,
+		state:       CircuitClosed,
+		since:       time.Now(),
+		transitions: make(map[string]int64),
+	}
+}
+
+// ConfigureCircuitBreaker sets when the database circuit opens and how long
+// it stays open. Call it before Connect.
+func ConfigureCircuitBreaker(failureThreshold int, cooldown time.Duration) {
+	if failureThreshold < 1 || cooldown <= 0 {
+		logrus.Errorf("Ignoring invalid database circuit breaker settings %d/%s", failureThreshold, cooldown)
+		return
+	}
+
+	Breaker.mutex.Lock()
+	defer Breaker.mutex.Unlock()
+	Breaker.config.FailureThreshold = failureThreshold
+	Breaker.config.Cooldown = cooldown
+}
+
+func (cb *CircuitBreaker) setProbe(probe func(ctx context.Context) error) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	cb.probe = probe
+}
+
+// Allow returns a "database unavailable" error while the circuit is open.
+// The first call after the cooldown starts the probe.
+func (cb *CircuitBreaker) Allow() error {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	switch cb.state {
+	case CircuitClosed:
+		return nil
+	case CircuitOpen:
+		if time.Since(cb.since) >= cb.config.Cooldown && cb.probe != nil {
+			cb.setState(CircuitHalfOpen)
+			go cb.runProbe()
+		}
+	}
+
+	cb.rejected++
+	return utils.NewDatabaseUnavailableError(cb.retryAfter())
+}
+
+// State returns the circuit's state
+func (cb *CircuitBreaker) State() string {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	return cb.state
+}
+
+// RetryAfter estimates when the database may be back
+func (cb *CircuitBreaker) RetryAfter() time.Duration {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	return cb.retryAfter()
+}
+
+func (cb *CircuitBreaker) retryAfter() time.Duration {
+	if cb.state != CircuitOpen {
+		return time.Second
+	}
+	remaining := cb.config.Cooldown - time.Since(cb.since)
+	if remaining < time.Second {
+		return time.Second
+	}
+	return remaining
+}
+
+// Stats returns the breaker's state and counters
+func (cb *CircuitBreaker) Stats() CircuitBreakerStats {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	transitions := make(map[string]int64, len(cb.transitions))
+	for state, count := range cb.transitions {
+		transitions[state] = count
+	}
+
+	return CircuitBreakerStats{
+		State:               cb.state,
+		Since:               cb.since,
+		ConsecutiveFailures: cb.consecutiveFailures,
+		Transitions:         transitions,
+		Rejected:            cb.rejected,
+		LastFailure:         cb.lastFailure,
+	}
+}
+
+// RecordSuccess resets the failure count of a closed circuit
+func (cb *CircuitBreaker) RecordSuccess() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if cb.state == CircuitClosed {
+		cb.consecutiveFailures = 0
+	}
+}
+
+// RecordFailure counts a connection failure, opening the circuit at the
+// threshold
+func (cb *CircuitBreaker) RecordFailure(reason string) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.lastFailure = reason
+	if cb.state != CircuitClosed {
+		return
+	}
+
+	cb.consecutiveFailures++
+	if cb.consecutiveFailures >= cb.config.FailureThreshold {
+		cb.setState(CircuitOpen)
+	}
+}
+
+// Trip opens the circuit right away, e.g. when no server accepts writes
+func (cb *CircuitBreaker) Trip(reason string) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.lastFailure = reason
+	if cb.state == CircuitClosed {
+		cb.setState(CircuitOpen)
+	}
+}
+
+func (cb *CircuitBreaker) runProbe() {
+	ctx, cancel := context.WithTimeout(context.Background(), cb.config.ProbeTimeout)
+	defer cancel()
+
+	err := cb.probe(ctx)
+
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if cb.state != CircuitHalfOpen {
+		return
+	}
+	if err != nil {
+		cb.lastFailure = err.Error()
+		cb.setState(CircuitOpen)
+		return
+	}
+	cb.setState(CircuitClosed)
+}
+
+// setState logs the transition once, instead of every failed call logging
+// its own error. Callers hold the mutex.
+func (cb *CircuitBreaker) setState(state string) {
+	previous := cb.state
+	cb.state = state
+	cb.since = time.Now()
+	cb.transitions[state]++
+	if state == CircuitClosed {
+		cb.consecutiveFailures = 0
+	}
+
+	entry := logrus.WithFields(logrus.Fields{
+		"from":        previous,
+		"to":          state,
+		"lastFailure": cb.lastFailure,
+	})
+	if state == CircuitClosed {
+		entry.Info("Database circuit breaker closed")
+	} else {
+		entry.Warn("Database circuit breaker state changed")
+	}
+}
+
+// monitor feeds the breaker from the driver: commands that succeed, pools
+// cleared or failing to connect, and topologies left without a primary
+func (cb *CircuitBreaker) monitor() (*event.CommandMonitor, *event.PoolMonitor, *event.ServerMonitor) {
+	commands := &event.CommandMonitor{
+		Succeeded: func(ctx context.Context, evt *event.CommandSucceededEvent) {
+			cb.RecordSuccess()
+		},
+	}
+
+	pool := &event.PoolMonitor{
+		Event: func(evt *event.PoolEvent) {
+			switch evt.Type {
+			case event.PoolCleared:
+				cb.RecordFailure("connection pool cleared for " + evt.Address)
+			case event.GetFailed:
+				if evt.Reason == event.ReasonConnectionErrored || evt.Reason == event.ReasonTimedOut {
+					cb.RecordFailure("connection checkout failed for " + evt.Address + ": " + evt.Reason)
+				}
+			}
+		},
+	}
+
+	servers := &event.ServerMonitor{
+		TopologyDescriptionChanged: func(evt *event.TopologyDescriptionChangedEvent) {
+			if evt.PreviousDescription.HasWritableServer() && !evt.NewDescription.HasWritableServer() {
+				cb.Trip("no writable server")
+			}
+		},
+	}
+
+	return commands, pool, servers
+}
+
+// pingPrimary probes the database for the breaker
+func pingPrimary(ctx context.Context) error {
+	if client == nil {
+		return utils.NewDatabaseUnavailableError(time.Second)
+	}
+	return client.Ping(ctx, readpref.Primary())
+}
@@ -31,6 +31,14 @@ func Connect(databaseURL string) (*mongo.Database, error) {
 	clientOptions.SetRetryWrites(true)
 	clientOptions.SetRetryReads(true)
 
+	// Fail fast during an outage; the circuit breaker takes over from there
+	clientOptions.SetServerSelectionTimeout(5 * time.Second)
+	commandMonitor, poolMonitor, serverMonitor := Breaker.monitor()
+	clientOptions.SetMonitor(commandMonitor)
+	clientOptions.SetPoolMonitor(poolMonitor)
+	clientOptions.SetServerMonitor(serverMonitor)
+	Breaker.setProbe(pingPrimary)
+
 	// Set read preference to primary preferred for better consistency
 	clientOptions.SetReadPreference(readpref.PrimaryPreferred())
 
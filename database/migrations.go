@@ -7,6 +7,7 @@ import (
 
 	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -77,6 +78,251 @@ var migrations = []Migration{
 		Description: "Create sessions collection with indexes",
 		Up:          createSessionsCollection,
 	},
+	{
+		Version:     11,
+		Description: "Create place templates collection with indexes",
+		Up:          createPlaceTemplatesCollection,
+	},
+	{
+		Version:     12,
+		Description: "Add place access indexes",
+		Up:          createPlaceAccessIndexes,
+	},
+	{
+		Version:     13,
+		Description: "Create departure reminders collection with indexes",
+		Up:          createDepartureRemindersCollection,
+	},
+	{
+		Version:     14,
+		Description: "Add deactivated account indexes",
+		Up:          createDeactivatedAccountIndexes,
+	},
+	{
+		Version:     15,
+		Description: "Create latest locations collection from location history",
+		Up:          createLatestLocationsCollection,
+	},
+	{
+		Version:     16,
+		Description: "Create message reactions collection from message reactions",
+		Up:          createMessageReactionsCollection,
+	},
+	{
+		Version:     17,
+		Description: "Add signing key indexes",
+		Up:          createSigningKeyIndexes,
+	},
+	{
+		Version:     18,
+		Description: "Add search index build indexes",
+		Up:          createSearchIndexBuildIndexes,
+	},
+	{
+		Version:     19,
+		Description: "Add notification rule indexes",
+		Up:          createNotificationRuleIndexes,
+	},
+	{
+		Version:     20,
+		Description: "Add place viewport index",
+		Up:          createPlaceViewportIndex,
+	},
+	{
+		Version:     21,
+		Description: "Add notification text index",
+		Up:          createNotificationTextIndex,
+	},
+	{
+		Version:     22,
+		Description: "Add notification broadcast indexes",
+		Up:          createNotificationBroadcastIndexes,
+	},
+	{
+		Version:     23,
+		Description: "Add place review moderation indexes",
+		Up:          createPlaceReviewModerationIndexes,
+	},
+	{
+		Version:     24,
+		Description: "Add circle merge job indexes",
+		Up:          createCircleMergeIndexes,
+	},
+	{
+		Version:     25,
+		Description: "Add ongoing place visit index",
+		Up:          createOngoingVisitIndex,
+	},
+	{
+		Version:     26,
+		Description: "Add check-in visibility",
+		Up:          createCheckinVisibility,
+	},
+	{
+		Version:     27,
+		Description: "Add anomaly alert indexes",
+		Up:          createAnomalyIndexes,
+	},
+	{
+		Version:     28,
+		Description: "Add location reminder indexes",
+		Up:          createLocationReminderIndexes,
+	},
+	{
+		Version:     29,
+		Description: "Add outbox indexes",
+		Up:          createOutboxIndexes,
+	},
+	{
+		Version:     30,
+		Description: "Add message content key indexes",
+		Up:          createContentKeyIndexes,
+	},
+	{
+		Version:     31,
+		Description: "Add place activity indexes",
+		Up:          createPlaceActivityIndexes,
+	},
+	{
+		Version:     32,
+		Description: "Add impersonation session indexes",
+		Up:          createImpersonationIndexes,
+	},
+	{
+		Version:     33,
+		Description: "Add GeoJSON points to location history",
+		Up:          addLocationHistoryPoints,
+	},
+	{
+		Version:     34,
+		Description: "Add place version indexes",
+		Up:          createPlaceVersionIndexes,
+	},
+	{
+		Version:     35,
+		Description: "Add deferred notification index",
+		Up:          createDeferredNotificationIndex,
+	},
+	{
+		Version:     36,
+		Description: "Add holiday set indexes",
+		Up:          createHolidaySetIndexes,
+	},
+	{
+		Version:     37,
+		Description: "Add place alias indexes",
+		Up:          createPlaceAliasIndexes,
+	},
+	{
+		Version:     38,
+		Description: "Add circle announcement indexes",
+		Up:          createCircleAnnouncementIndexes,
+	},
+	{
+		Version:     39,
+		Description: "Add location integrity indexes",
+		Up:          createLocationIntegrityIndexes,
+	},
+	{
+		Version:     40,
+		Description: "Add notification delivery decision indexes",
+		Up:          createDeliveryDecisionIndexes,
+	},
+	{
+		Version:     41,
+		Description: "Add circle album indexes",
+		Up:          createCircleAlbumIndexes,
+	},
+	{
+		Version:     42,
+		Description: "Add calendar feed indexes",
+		Up:          createCalendarFeedIndexes,
+	},
+	{
+		Version:     43,
+		Description: "Add message delivery indexes",
+		Up:          createMessageDeliveryIndexes,
+	},
+	{
+		Version:     44,
+		Description: "Add place tag index",
+		Up:          createPlaceTagIndex,
+	},
+	{
+		Version:     45,
+		Description: "Order messages by composed time and index client IDs",
+		Up:          addMessageComposedAt,
+	},
+	{
+		Version:     46,
+		Description: "Add export permission request indexes",
+		Up:          createExportPermissionRequestIndexes,
+	},
+	{
+		Version:     47,
+		Description: "Index circles with offline alerts",
+		Up:          createOfflineAlertIndex,
+	},
+	{
+		Version:     48,
+		Description: "Add place list indexes",
+		Up:          createPlaceListIndexes,
+	},
+	{
+		Version:     49,
+		Description: "Add notification experiment indexes",
+		Up:          createNotificationExperimentIndexes,
+	},
+	{
+		Version:     50,
+		Description: "Add geofence event indexes",
+		Up:          createGeofenceEventIndexes,
+	},
+	{
+		Version:     51,
+		Description: "Move place favorites to per-user records",
+		Up:          createPlaceFavorites,
+	},
+	{
+		Version:     52,
+		Description: "Add trip safety indexes",
+		Up:          createTripSafetyIndexes,
+	},
+	{
+		Version:     53,
+		Description: "Add place suggestion indexes",
+		Up:          createPlaceSuggestionIndexes,
+	},
+	{
+		Version:     54,
+		Description: "Add media upload intent indexes",
+		Up:          createMediaUploadIntentIndexes,
+	},
+	{
+		Version:     55,
+		Description: "Convert single-window quiet hours into windows",
+		Up:          migrateQuietHoursWindows,
+	},
+	{
+		Version:     56,
+		Description: "Create circle roles indexes",
+		Up:          createCircleRoleIndexes,
+	},
+	{
+		Version:     57,
+		Description: "Create circles overview indexes",
+		Up:          createCircleOverviewIndexes,
+	},
+	{
+		Version:     58,
+		Description: "Create circle moments indexes",
+		Up:          createCircleMomentIndexes,
+	},
+	{
+		Version:     59,
+		Description: "Assign emergency contact slots",
+		Up:          createEmergencyContactSlots,
+	},
 }
 
 // RunMigrations executes all pending migrations
@@ -427,3 +673,1152 @@ func createSessionsCollection(db *mongo.Database) error {
 	_, err := col.Indexes().CreateMany(ctx, indexes)
 	return err
 }
+
+func createPlaceTemplatesCollection(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	col := db.Collection("place_templates")
+
+	indexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: -1}},
+		},
+		{
+			// Gallery listing, ranked by usage
+			Keys: bson.D{{Key: "isPublic", Value: 1}, {Key: "moderationStatus", Value: 1}, {Key: "category", Value: 1}, {Key: "usageCount", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "moderationStatus", Value: 1}, {Key: "publishedAt", Value: 1}},
+		},
+	}
+
+	_, err := col.Indexes().CreateMany(ctx, indexes)
+	return err
+}
+
+func createPlaceAccessIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	col := db.Collection("places")
+
+	indexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "userId", Value: 1}, {Key: "isActive", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "sharing.sharedWith.userId", Value: 1}},
+		},
+	}
+
+	_, err := col.Indexes().CreateMany(ctx, indexes)
+	return err
+}
+
+func createDepartureRemindersCollection(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	col := db.Collection("departure_reminders")
+
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "userId", Value: 1}, {Key: "placeId", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "enabled", Value: 1}, {Key: "nextReminderAt", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "enabled", Value: 1}, {Key: "pattern.computedAt", Value: 1}},
+		},
+	}
+
+	if _, err := col.Indexes().CreateMany(ctx, indexes); err != nil {
+		return err
+	}
+
+	// Departure patterns read a user's visits in arrival order
+	_, err := db.Collection("place_visits").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}, {Key: "arrivalTime", Value: 1}},
+	})
+	return err
+}
+
+func createDeactivatedAccountIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "deactivation.deactivatedAt", Value: 1}},
+			Options: options.Index().SetSparse(true),
+		},
+	}
+
+	_, err := db.Collection("users").Indexes().CreateMany(ctx, indexes)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Collection("scheduled_messages").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}, {Key: "status", Value: 1}},
+	})
+	return err
+}
+
+func createLatestLocationsCollection(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	col := db.Collection("latest_locations")
+
+	// Retention cleanup removes latest locations with their history
+	_, err := col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "location.createdAt", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Backfill from history. This scans every location once, so it gets
+	// more time than index creation.
+	backfillCtx, backfillCancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer backfillCancel()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$sort", Value: bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: -1}}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$userId"},
+			{Key: "location", Value: bson.D{{Key: "$first", Value: "$$ROOT"}}},
+			{Key: "recordedAt", Value: bson.D{{Key: "$first", Value: "$createdAt"}}},
+		}}},
+		{{Key: "$merge", Value: bson.D{
+			{Key: "into", Value: "latest_locations"},
+			{Key: "whenMatched", Value: "keepExisting"},
+			{Key: "whenNotMatched", Value: "insert"},
+		}}},
+	}
+
+	cursor, err := db.Collection("locations").Aggregate(backfillCtx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return err
+	}
+	return cursor.Close(backfillCtx)
+}
+
+func createMessageReactionsCollection(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	col := db.Collection("message_reactions")
+
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "messageId", Value: 1}, {Key: "userId", Value: 1}, {Key: "emoji", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "circleId", Value: 1}, {Key: "createdAt", Value: -1}},
+		},
+	}
+
+	if _, err := col.Indexes().CreateMany(ctx, indexes); err != nil {
+		return err
+	}
+
+	// Backfill from the reactions embedded in messages
+	backfillCtx, backfillCancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer backfillCancel()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"isDeleted":   bson.M{"$ne": true},
+			"reactions.0": bson.M{"$exists": true},
+		}}},
+		{{Key: "$unwind", Value: "$reactions"}},
+		{{Key: "$project", Value: bson.M{
+			"_id":       0,
+			"messageId": "$_id",
+			"circleId":  "$circleId",
+			"userId":    "$reactions.userId",
+			"emoji":     "$reactions.emoji",
+			"createdAt": "$reactions.addedAt",
+		}}},
+		{{Key: "$merge", Value: bson.D{
+			{Key: "into", Value: "message_reactions"},
+			{Key: "on", Value: bson.A{"messageId", "userId", "emoji"}},
+			{Key: "whenMatched", Value: "keepExisting"},
+			{Key: "whenNotMatched", Value: "insert"},
+		}}},
+	}
+
+	cursor, err := db.Collection("messages").Aggregate(backfillCtx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return err
+	}
+	return cursor.Close(backfillCtx)
+}
+
+func createSigningKeyIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "kid", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "expiresAt", Value: 1}},
+		},
+	}
+
+	_, err := db.Collection("signing_keys").Indexes().CreateMany(ctx, indexes)
+	return err
+}
+
+func createSearchIndexBuildIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	indexes := []mongo.IndexModel{
+		// Only one rebuild may run at a time
+		{
+			Keys: bson.D{{Key: "status", Value: 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"status": "running"}),
+		},
+		{
+			Keys: bson.D{{Key: "completedAt", Value: -1}},
+		},
+	}
+
+	_, err := db.Collection("search_index_builds").Indexes().CreateMany(ctx, indexes)
+	return err
+}
+
+func createNotificationRuleIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	indexes := []mongo.IndexModel{
+		// Routing looks up a user's active rules, highest priority first
+		{
+			Keys: bson.D{
+				{Key: "user_id", Value: 1},
+				{Key: "is_active", Value: 1},
+				{Key: "priority", Value: -1},
+			},
+		},
+	}
+
+	_, err := db.Collection("notification_rules").Indexes().CreateMany(ctx, indexes)
+	return err
+}
+
+func createPlaceViewportIndex(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Map clustering loads a circle's places inside a viewport
+	_, err := db.Collection("places").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "circleId", Value: 1},
+			{Key: "latitude", Value: 1},
+			{Key: "longitude", Value: 1},
+		},
+	})
+	return err
+}
+
+func createNotificationTextIndex(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Inbox search matches words of the title and message
+	_, err := db.Collection("notifications").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "title", Value: "text"},
+			{Key: "message", Value: "text"},
+		},
+		Options: options.Index().SetName("notification_text_search"),
+	})
+	return err
+}
+
+func createNotificationBroadcastIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The worker looks for due and stalled broadcasts
+	_, err := db.Collection("notification_broadcasts").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "status", Value: 1},
+			{Key: "scheduled_at", Value: 1},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// A user's outcome is recorded once, so resumed broadcasts skip them
+	_, err = db.Collection("notification_broadcast_deliveries").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "broadcast_id", Value: 1},
+				{Key: "user_id", Value: 1},
+			},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{
+				{Key: "broadcast_id", Value: 1},
+				{Key: "status", Value: 1},
+				{Key: "user_id", Value: 1},
+			},
+		},
+	})
+	return err
+}
+
+func createPlaceReviewModerationIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	reviews := db.Collection("place_reviews")
+
+	// Reviews from before moderation are visible
+	_, err := reviews.UpdateMany(ctx,
+		bson.M{"moderationStatus": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"moderationStatus": "visible"}},
+	)
+	if err != nil {
+		return err
+	}
+
+	// Users get one review per place. Earlier duplicates are hidden rather
+	// than deleted, keeping the latest one visible.
+	cursor, err := reviews.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"moderationStatus": "visible"}}},
+		{{Key: "$sort", Value: bson.D{{Key: "createdAt", Value: -1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"placeId": "$placeId", "userId": "$userId"},
+			"ids":   bson.M{"$push": "$_id"},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	}, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return err
+	}
+
+	var duplicates []struct {
+		IDs []primitive.ObjectID `bson:"ids"`
+	}
+	if err := cursor.All(ctx, &duplicates); err != nil {
+		return err
+	}
+
+	for _, group := range duplicates {
+		_, err := reviews.UpdateMany(ctx,
+			bson.M{"_id": bson.M{"$in": group.IDs[1:]}},
+			bson.M{"$set": bson.M{
+				"moderationStatus": "hidden",
+				"moderationNote":   "duplicate review",
+			}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = reviews.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "placeId", Value: 1}, {Key: "userId", Value: 1}},
+		Options: options.Index().
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"moderationStatus": "visible"}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// A user reports a review once; admins work through reports by state
+	_, err = db.Collection("place_review_reports").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "reviewId", Value: 1}, {Key: "reportedBy", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "createdAt", Value: 1}},
+		},
+	})
+	return err
+}
+
+func createCircleMergeIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	indexes := []mongo.IndexModel{
+		// A circle can only be merged away once at a time
+		{
+			Keys: bson.D{{Key: "sourceCircleId", Value: 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"status": "running"}),
+		},
+		{
+			Keys: bson.D{{Key: "targetCircleId", Value: 1}, {Key: "status", Value: 1}},
+		},
+		// Finding merges to resume
+		{
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "heartbeatAt", Value: 1}},
+		},
+	}
+
+	if _, err := db.Collection("circle_merge_jobs").Indexes().CreateMany(ctx, indexes); err != nil {
+		return err
+	}
+
+	// Archived circles are deleted once their archive period is over
+	_, err := db.Collection("circles").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "deleteAfter", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	})
+	return err
+}
+
+func createOngoingVisitIndex(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Live visit updates page through the open visits
+	_, err := db.Collection("place_visits").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "isOngoing", Value: 1}, {Key: "_id", Value: 1}},
+		Options: options.Index().SetPartialFilterExpression(bson.M{"isOngoing": true}),
+	})
+	return err
+}
+
+func createCheckinVisibility(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	checkins := db.Collection("place_checkins")
+
+	// Public check-ins from before visibility levels go to the circle, the
+	// others stay with their author
+	_, err := checkins.UpdateMany(ctx,
+		bson.M{"visibility": bson.M{"$exists": false}, "isPublic": true},
+		bson.M{"$set": bson.M{"visibility": "circle"}},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = checkins.UpdateMany(ctx,
+		bson.M{"visibility": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"visibility": "private"}},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = checkins.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "placeId", Value: 1}, {Key: "createdAt", Value: -1}},
+		},
+		// Activity feeds list check-ins by circle members
+		{
+			Keys: bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: -1}},
+		},
+	})
+	return err
+}
+
+func createAnomalyIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := db.Collection("anomaly_profiles").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "userId", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		// The worker pages through enabled profiles in id order
+		{
+			Keys: bson.D{{Key: "enabled", Value: 1}, {Key: "_id", Value: 1}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Collection("anomaly_alerts").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "userId", Value: 1}, {Key: "triggeredAt", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "watchers", Value: 1}, {Key: "triggeredAt", Value: -1}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Home departures are looked up per user and place
+	_, err = db.Collection("place_visits").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}, {Key: "placeId", Value: 1}, {Key: "departureTime", Value: 1}},
+	})
+	return err
+}
+
+func createLocationReminderIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := db.Collection("location_reminders").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		// Arrivals look up the user's active reminders
+		{
+			Keys: bson.D{{Key: "userId", Value: 1}, {Key: "active", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: -1}},
+		},
+	})
+	return err
+}
+
+func createOutboxIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := db.Collection("outbox_events").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		// A side effect is stored once however often its write is retried
+		{
+			Keys:    bson.D{{Key: "idempotencyKey", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		// The dispatcher claims the longest-due pending event
+		{
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "availableAt", Value: 1}},
+		},
+		{
+			Keys:    bson.D{{Key: "dispatchedAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(7 * 24 * 3600), // 7 days
+		},
+	})
+	return err
+}
+
+func createContentKeyIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// The encryption run finds content under keys other than the current
+	// one; plain content has no key ID and stays out of the index
+	for _, collection := range []string{"messages", "message_drafts"} {
+		_, err := db.Collection(collection).Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "contentKeyId", Value: 1}},
+			Options: options.Index().SetSparse(true),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createPlaceActivityIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Trending and popular places weigh each place's recent visits and
+	// check-ins
+	if _, err := db.Collection("place_visits").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "placeId", Value: 1}, {Key: "arrivalTime", Value: -1}},
+	}); err != nil {
+		return err
+	}
+
+	_, err := db.Collection("place_checkins").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "placeId", Value: 1}, {Key: "createdAt", Value: -1}},
+	})
+	return err
+}
+
+func createImpersonationIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := db.Collection("impersonation_sessions").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		// The cleanup task closes unfinished sessions that ran out
+		{
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "expiresAt", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "userId", Value: 1}, {Key: "requestedAt", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "adminId", Value: 1}, {Key: "userId", Value: 1}, {Key: "status", Value: 1}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Session summaries count the requests audited under the session
+	_, err = db.Collection("audit_logs").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "details.impersonationId", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	})
+	return err
+}
+
+// addLocationHistoryPoints copies the coordinates of stored locations into
+// GeoJSON points, so viewport queries can use the geo index
+func addLocationHistoryPoints(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	col := db.Collection("locations")
+
+	_, err := col.UpdateMany(ctx,
+		bson.M{"point": bson.M{"$exists": false}},
+		mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{"point": bson.M{
+				"type":        "Point",
+				"coordinates": bson.A{"$longitude", "$latitude"},
+			}}}},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}, {Key: "point", Value: "2dsphere"}, {Key: "createdAt", Value: 1}},
+	})
+	return err
+}
+
+func createPlaceVersionIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Version numbers are unique per place, so concurrent changes can't
+	// both take the next one
+	_, err := db.Collection("place_versions").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "placeId", Value: 1}, {Key: "version", Value: -1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// createDeferredNotificationIndex lets the notification worker find held
+// notifications that are due. Only deferred notifications are indexed.
+func createDeferredNotificationIndex(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := db.Collection("notifications").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "deferred_until", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	})
+	return err
+}
+
+func createHolidaySetIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := db.Collection("place_holiday_sets").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}, {Key: "name", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Finds the places following a set before it is deleted
+	_, err = db.Collection("places").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "hours.recurringOverrides.holidaySetId", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	})
+	return err
+}
+
+func createPlaceAliasIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// One label per member and place
+	_, err := db.Collection("place_aliases").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "userId", Value: 1}, {Key: "placeId", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "placeId", Value: 1}},
+		},
+	})
+	return err
+}
+
+func createCircleAnnouncementIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Lists a circle's announcements and counts today's
+	_, err := db.Collection("circle_announcements").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "circleId", Value: 1}, {Key: "createdAt", Value: -1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Finds an announcement's notifications for its stats
+	_, err = db.Collection("notifications").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "metadata.announcement_id", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	})
+	return err
+}
+
+func createLocationIntegrityIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// One score per user
+	_, err := db.Collection("location_integrity").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "userId", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+func createDeliveryDecisionIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Decisions only explain recent notifications
+	_, err := db.Collection("notification_decisions").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(30 * 24 * 3600), // 30 days
+	})
+	return err
+}
+
+func createCircleAlbumIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Lists a circle's albums and finds the album of a visit
+	_, err := db.Collection("circle_albums").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "circleId", Value: 1}, {Key: "status", Value: 1}, {Key: "startTime", Value: -1}}},
+		{Keys: bson.D{{Key: "circleId", Value: 1}, {Key: "placeId", Value: 1}, {Key: "startTime", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// A photo is in an album once; photos removed from chat are found by
+	// message and media
+	_, err = db.Collection("circle_album_photos").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "albumId", Value: 1}, {Key: "messageId", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "albumId", Value: 1}, {Key: "takenAt", Value: 1}}},
+		{Keys: bson.D{{Key: "messageId", Value: 1}}},
+		{Keys: bson.D{{Key: "mediaId", Value: 1}}},
+	})
+	return err
+}
+
+func createCalendarFeedIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Feeds are fetched by token, and listed per user
+	_, err := db.Collection("calendar_feeds").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "tokenHash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: -1}}},
+	})
+	return err
+}
+
+func createMessageDeliveryIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Summaries are read by message ID; compaction looks for old ones
+	// that still have their detail
+	_, err := db.Collection("message_deliveries").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "compactedAt", Value: 1}, {Key: "createdAt", Value: 1}},
+	})
+	return err
+}
+
+func createPlaceTagIndex(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Tag filters and the tag vocabulary look at one user's places
+	_, err := db.Collection("places").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}, {Key: "tags", Value: 1}},
+	})
+	return err
+}
+
+// addMessageComposedAt gives existing messages the time they were stored as
+// their composed time, and indexes the IDs clients give messages they
+// compose offline
+func addMessageComposedAt(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	col := db.Collection("messages")
+
+	_, err := col.UpdateMany(ctx,
+		bson.M{"composedAt": bson.M{"$exists": false}},
+		mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{"composedAt": "$createdAt"}}},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "circleId", Value: 1}, {Key: "composedAt", Value: -1}, {Key: "createdAt", Value: -1}},
+		},
+		{
+			// A resent message is found by its client ID, unique per sender
+			Keys: bson.D{{Key: "senderId", Value: 1}, {Key: "clientId", Value: 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"clientId": bson.M{"$exists": true}}),
+		},
+	})
+	return err
+}
+
+func createExportPermissionRequestIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := db.Collection("export_permission_requests").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			// One pending request per member and circle
+			Keys: bson.D{{Key: "circleId", Value: 1}, {Key: "userId", Value: 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"status": "pending"}),
+		},
+		{
+			// Admins list pending requests; exports look up approvals
+			Keys: bson.D{{Key: "circleId", Value: 1}, {Key: "status", Value: 1}, {Key: "createdAt", Value: 1}},
+		},
+	})
+	return err
+}
+
+func createOfflineAlertIndex(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// The offline member check pages through the circles that opted in
+	_, err := db.Collection("circles").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "settings.offlineAlerts", Value: 1}, {Key: "_id", Value: 1}},
+		Options: options.Index().
+			SetPartialFilterExpression(bson.M{"settings.offlineAlerts": true}),
+	})
+	return err
+}
+
+func createPlaceListIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := db.Collection("place_lists").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "circleId", Value: 1}, {Key: "status", Value: 1}, {Key: "updatedAt", Value: -1}}},
+		// Arrival nudges look up the active lists of the place
+		{Keys: bson.D{{Key: "placeId", Value: 1}, {Key: "status", Value: 1}}},
+		// The cleanup worker archives completed lists
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "completedAt", Value: 1}}},
+	})
+	return err
+}
+
+func createNotificationExperimentIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := db.Collection("notification_experiments").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "template", Value: 1}, {Key: "status", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "ends_at", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Results count each variant's notifications
+	_, err = db.Collection("notifications").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "experiment_id", Value: 1}, {Key: "experiment_variant", Value: 1}},
+		Options: options.Index().
+			SetPartialFilterExpression(bson.M{"experiment_id": bson.M{"$exists": true}}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// A user's opt-out is counted once per experiment
+	_, err = db.Collection("notification_experiment_opt_outs").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "experiment_id", Value: 1}, {Key: "user_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+func createGeofenceEventIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := db.Collection("geofence_events").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "timestamp", Value: -1}}},
+		// Circle event feeds
+		{Keys: bson.D{{Key: "circleId", Value: 1}, {Key: "timestamp", Value: -1}}},
+	})
+	return err
+}
+
+func createPlaceFavorites(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// One favorite per member and place, listed newest first
+	_, err := db.Collection("place_favorites").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "userId", Value: 1}, {Key: "placeId", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "placeId", Value: 1}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// The old flag was on the place itself, set by its owner
+	backfillCtx, backfillCancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer backfillCancel()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"isFavorite": true}}},
+		{{Key: "$project", Value: bson.D{
+			{Key: "_id", Value: 0},
+			{Key: "userId", Value: "$userId"},
+			{Key: "placeId", Value: "$_id"},
+			{Key: "createdAt", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$updatedAt", "$$NOW"}}}},
+		}}},
+		{{Key: "$merge", Value: bson.D{
+			{Key: "into", Value: "place_favorites"},
+			{Key: "on", Value: bson.A{"userId", "placeId"}},
+			{Key: "whenMatched", Value: "keepExisting"},
+			{Key: "whenNotMatched", Value: "insert"},
+		}}},
+	}
+
+	cursor, err := db.Collection("places").Aggregate(backfillCtx, pipeline)
+	if err != nil {
+		return err
+	}
+	if err := cursor.Close(backfillCtx); err != nil {
+		return err
+	}
+
+	_, err = db.Collection("places").UpdateMany(backfillCtx,
+		bson.M{"isFavorite": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"isFavorite": ""}},
+	)
+	return err
+}
+
+func createTripSafetyIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := db.Collection("trips").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		// The trip safety events are recorded against
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "isActive", Value: 1}, {Key: "startTime", Value: -1}}},
+		// Weekly safety reports
+		{
+			Keys:    bson.D{{Key: "userId", Value: 1}, {Key: "endTime", Value: 1}},
+			Options: options.Index().SetPartialFilterExpression(bson.M{"safety": bson.M{"$exists": true}}),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// A trip's safety events, summarized when it ends
+	_, err = db.Collection("driving_events").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "tripId", Value: 1}, {Key: "timestamp", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	})
+	return err
+}
+
+func createPlaceSuggestionIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := db.Collection("place_suggestions").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		// A user's suggestions, open ones most visited first
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "status", Value: 1}, {Key: "visitDays", Value: -1}}},
+		// Open suggestions whose visits left location history
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "lastVisit", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Users who reported a location recently, analyzed for suggestions
+	_, err = db.Collection("latest_locations").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "recordedAt", Value: 1}},
+	})
+	return err
+}
+
+func createMediaUploadIntentIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := db.Collection("media_upload_intents").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		// Abandoned uploads, garbage collected once expired
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}},
+		// A user's uploads
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: -1}}},
+	})
+	return err
+}
+
+func migrateQuietHoursWindows(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	filter := bson.M{
+		"quiet_hours.start_time": bson.M{"$nin": bson.A{nil, ""}},
+		"quiet_hours.windows":    bson.M{"$exists": false},
+	}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"quiet_hours.windows": bson.A{bson.M{
+			"days":       bson.M{"$ifNull": bson.A{"$quiet_hours.days", bson.A{}}},
+			"start_time": "$quiet_hours.start_time",
+			"end_time":   "$quiet_hours.end_time",
+		}}}}},
+		{{Key: "$unset", Value: bson.A{"quiet_hours.start_time", "quiet_hours.end_time", "quiet_hours.days"}}},
+	}
+
+	// Quiet hours are kept with push settings, and copied into do not
+	// disturb status
+	for _, collection := range []string{"push_settings", "dnd_settings"} {
+		if _, err := db.Collection(collection).UpdateMany(ctx, filter, update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createCircleRoleIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := db.Collection("circle_roles").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		// A circle's roles, listed by name
+		{Keys: bson.D{{Key: "circleId", Value: 1}, {Key: "name", Value: 1}}},
+	})
+	return err
+}
+
+func createCircleOverviewIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Active emergencies and pending join requests, counted per circle
+	if _, err := db.Collection("emergencies").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "circleId", Value: 1}, {Key: "status", Value: 1}},
+	}); err != nil {
+		return err
+	}
+	_, err := db.Collection("circle_join_requests").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "circleId", Value: 1}, {Key: "status", Value: 1}},
+	})
+	return err
+}
+
+func createCircleMomentIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := db.Collection("circle_moments").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		// A circle's activity feed, newest first
+		{Keys: bson.D{{Key: "circleId", Value: 1}, {Key: "createdAt", Value: -1}}},
+	})
+	return err
+}
+
+// createEmergencyContactSlots numbers each user's emergency contacts in
+// priority order, and makes the numbers unique per user so the contact
+// limit holds under concurrent adds
+func createEmergencyContactSlots(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	contacts := db.Collection("emergency_contacts")
+	cursor, err := contacts.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"slot": bson.M{"$exists": false}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "priority", Value: 1}, {Key: "createdAt", Value: 1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": "$userId",
+			"ids": bson.M{"$push": "$_id"},
+		}}},
+	}, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return err
+	}
+
+	var users []struct {
+		IDs []primitive.ObjectID `bson:"ids"`
+	}
+	if err := cursor.All(ctx, &users); err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		for slot, id := range user.IDs {
+			if _, err := contacts.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"slot": slot}}); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = contacts.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}, {Key: "slot", Value: 1}},
+		Options: options.Index().
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"slot": bson.M{"$exists": true}}),
+	})
+	return err
+}
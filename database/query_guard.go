@@ -0,0 +1,278 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"ftrack/utils"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// QueryConfig bounds how long repository queries may run, and when they
+// are logged as slow
+type QueryConfig struct {
+	// Longest a find, count or distinct may run
+	ReadTimeout time.Duration `json:"readTimeout"`
+
+	// Longest an aggregation may run
+	AggregateTimeout time.Duration `json:"aggregateTimeout"`
+
+	// Queries taking at least this long are logged
+	SlowThreshold time.Duration `json:"slowThreshold"`
+}
+
+// CollectionQueryStats counts the queries of a collection that timed out or
+// were slow
+type CollectionQueryStats struct {
+	Timeouts int64 `json:"timeouts"`
+	Slow     int64 `json:"slow"`
+}
+
+// QueryStats are the query limits and counters since start
+type QueryStats struct {
+	Config      QueryConfig                     `json:"config"`
+	Collections map[string]CollectionQueryStats `json:"collections"`
+}
+
+// QueryGuard holds the query limits and counts what hits them
+type QueryGuard struct {
+	mutex       sync.Mutex
+	config      QueryConfig
+	collections map[string]*CollectionQueryStats
+}
+
+// Queries guards the repositories' queries
+var Queries = NewQueryGuard(QueryConfig{
+	ReadTimeout:      5 * time.Second,
+	AggregateTimeout: 10 * time.Second,
+	SlowThreshold:    500 * time.Millisecond,
+})
+
+// The client gives the server this long past a query's limit to stop it
+// itself, so the connection stays usable
+const queryDeadlineGrace = time.Second
+
+func NewQueryGuard(config QueryConfig) *QueryGuard {
+	return &QueryGuard{
+		config:      config,
+		collections: make(map[string]*CollectionQueryStats),
+	}
+}
+
+// ConfigureQueryLimits sets the query timeouts and the slow query threshold
+func ConfigureQueryLimits(readTimeout, aggregateTimeout, slowThreshold time.Duration) {
+	if readTimeout <= 0 || aggregateTimeout <= 0 || slowThreshold <= 0 {
+		logrus.Errorf("Ignoring invalid database query limits %s/%s/%s", readTimeout, aggregateTimeout, slowThreshold)
+		return
+	}
+
+	Queries.mutex.Lock()
+	defer Queries.mutex.Unlock()
+	Queries.config = QueryConfig{
+		ReadTimeout:      readTimeout,
+		AggregateTimeout: aggregateTimeout,
+		SlowThreshold:    slowThreshold,
+	}
+}
+
+// Config returns the query limits
+func (qg *QueryGuard) Config() QueryConfig {
+	qg.mutex.Lock()
+	defer qg.mutex.Unlock()
+	return qg.config
+}
+
+// Stats returns the query limits and the counters by collection
+func (qg *QueryGuard) Stats() QueryStats {
+	qg.mutex.Lock()
+	defer qg.mutex.Unlock()
+
+	collections := make(map[string]CollectionQueryStats, len(qg.collections))
+	for name, stats := range qg.collections {
+		collections[name] = *stats
+	}
+
+	return QueryStats{
+		Config:      qg.config,
+		Collections: collections,
+	}
+}
+
+func (qg *QueryGuard) count(collection string, timedOut, slow bool) {
+	qg.mutex.Lock()
+	defer qg.mutex.Unlock()
+
+	stats, ok := qg.collections[collection]
+	if !ok {
+		stats = &CollectionQueryStats{}
+		qg.collections[collection] = stats
+	}
+	if timedOut {
+		stats.Timeouts++
+	}
+	if slow {
+		stats.Slow++
+	}
+}
+
+// Collection is a MongoDB collection whose reads and aggregations run under
+// the query limits. The server stops a query at its limit, abandoned
+// requests cancel theirs, and slow ones are logged. Writes pass through.
+type Collection struct {
+	*mongo.Collection
+}
+
+// NewCollection returns the named collection of the database, guarded
+func NewCollection(db *mongo.Database, name string) *Collection {
+	return &Collection{Collection: db.Collection(name)}
+}
+
+func (c *Collection) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	limit := Queries.Config().ReadTimeout
+	queryCtx, cancel := context.WithTimeout(ctx, limit+queryDeadlineGrace)
+	defer cancel()
+
+	started := time.Now()
+	cursor, err := c.Collection.Find(queryCtx, filter, append([]*options.FindOptions{options.Find().SetMaxTime(limit)}, opts...)...)
+	return cursor, c.observe(ctx, "find", filter, started, err)
+}
+
+// FindOne reads the document before returning, so it isn't read after the
+// query's deadline has gone
+func (c *Collection) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	limit := Queries.Config().ReadTimeout
+	queryCtx, cancel := context.WithTimeout(ctx, limit+queryDeadlineGrace)
+	defer cancel()
+
+	started := time.Now()
+	result := c.Collection.FindOne(queryCtx, filter, append([]*options.FindOneOptions{options.FindOne().SetMaxTime(limit)}, opts...)...)
+
+	err := result.Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = nil
+	}
+	if err := c.observe(ctx, "findOne", filter, started, err); utils.IsQueryTimeout(err) {
+		return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+	}
+	return result
+}
+
+func (c *Collection) CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	limit := Queries.Config().ReadTimeout
+	queryCtx, cancel := context.WithTimeout(ctx, limit+queryDeadlineGrace)
+	defer cancel()
+
+	started := time.Now()
+	count, err := c.Collection.CountDocuments(queryCtx, filter, append([]*options.CountOptions{options.Count().SetMaxTime(limit)}, opts...)...)
+	return count, c.observe(ctx, "count", filter, started, err)
+}
+
+func (c *Collection) Distinct(ctx context.Context, fieldName string, filter interface{}, opts ...*options.DistinctOptions) ([]interface{}, error) {
+	limit := Queries.Config().ReadTimeout
+	queryCtx, cancel := context.WithTimeout(ctx, limit+queryDeadlineGrace)
+	defer cancel()
+
+	started := time.Now()
+	values, err := c.Collection.Distinct(queryCtx, fieldName, filter, append([]*options.DistinctOptions{options.Distinct().SetMaxTime(limit)}, opts...)...)
+	return values, c.observe(ctx, "distinct", filter, started, err)
+}
+
+func (c *Collection) Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	limit := Queries.Config().AggregateTimeout
+	queryCtx, cancel := context.WithTimeout(ctx, limit+queryDeadlineGrace)
+	defer cancel()
+
+	started := time.Now()
+	cursor, err := c.Collection.Aggregate(queryCtx, pipeline, append([]*options.AggregateOptions{options.Aggregate().SetMaxTime(limit)}, opts...)...)
+	return cursor, c.observe(ctx, "aggregate", pipeline, started, err)
+}
+
+// observe logs a slow query and counts it, and turns a query that ran out
+// of time into a "query timeout" error. Queries whose caller gave up are
+// neither; their error is returned as it is.
+func (c *Collection) observe(ctx context.Context, operation string, filter interface{}, started time.Time, err error) error {
+	elapsed := time.Since(started)
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return err
+	}
+
+	timedOut := err != nil && mongo.IsTimeout(err)
+	slow := elapsed >= Queries.Config().SlowThreshold
+	if !timedOut && !slow {
+		return err
+	}
+
+	collection := c.Name()
+	Queries.count(collection, timedOut, slow)
+
+	entry := logrus.WithFields(logrus.Fields{
+		"collection": collection,
+		"operation":  operation,
+		"filter":     QueryShape(filter),
+		"durationMs": elapsed.Milliseconds(),
+	})
+	if !timedOut {
+		entry.Warn("Slow database query")
+		return err
+	}
+
+	entry.Warn("Database query timed out")
+	utils.MarkQueryTimeout(ctx)
+	return utils.NewQueryTimeoutError(collection)
+}
+
+// QueryShape describes a filter or pipeline with its values left out, so
+// it can be logged
+func QueryShape(filter interface{}) string {
+	if filter == nil {
+		return "{}"
+	}
+
+	data, err := bson.Marshal(bson.D{{Key: "q", Value: filter}})
+	if err != nil {
+		return "?"
+	}
+	return rawShape(bson.Raw(data).Lookup("q"))
+}
+
+func rawShape(value bson.RawValue) string {
+	switch value.Type {
+	case bsontype.EmbeddedDocument:
+		elements, err := value.Document().Elements()
+		if err != nil {
+			return "?"
+		}
+		parts := make([]string, 0, len(elements))
+		for _, element := range elements {
+			parts = append(parts, element.Key()+": "+rawShape(element.Value()))
+		}
+		return "{" + strings.Join(parts, ", ") + "}"
+
+	case bsontype.Array:
+		values, err := value.Array().Values()
+		if err != nil {
+			return "?"
+		}
+		// Lists of values are left out whole, lists of clauses or
+		// pipeline stages are described
+		if len(values) == 0 || values[0].Type != bsontype.EmbeddedDocument {
+			return "[?]"
+		}
+		parts := make([]string, 0, len(values))
+		for _, element := range values {
+			parts = append(parts, rawShape(element))
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+
+	default:
+		return "?"
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"ftrack/config"
 	"ftrack/database"
 	"ftrack/routes"
+	"ftrack/services"
+	"ftrack/utils"
 	"ftrack/websocket"
 	"ftrack/workers"
 	"log"
@@ -36,6 +38,44 @@ func main() {
 	// Initialize logger
 	setupLogger(cfg)
 
+	// Encryption for stored TOTP secrets
+	mfaKey := cfg.MFAEncryptionKey
+	if mfaKey == "" {
+		logrus.Warn("MFA_ENCRYPTION_KEY not set, encrypting TOTP secrets with the JWT secret")
+		mfaKey = cfg.JWTSecret
+	}
+	utils.ConfigureMFAEncryption(mfaKey)
+
+	// Signed links, e.g. push notification images
+	urlSigningKey := cfg.URLSigningKey
+	if urlSigningKey == "" {
+		logrus.Warn("URL_SIGNING_KEY not set, signing URLs with the JWT secret")
+		urlSigningKey = cfg.JWTSecret
+	}
+	utils.ConfigureURLSigning(urlSigningKey, cfg.BaseURL)
+
+	// Encryption at rest for the messages of circles that ask for it
+	if cfg.MessageEncryptionKeyID != "" {
+		contentKeys, err := utils.NewStaticContentKeys(cfg.MessageEncryptionKeyID, cfg.MessageEncryptionKeys)
+		if err != nil {
+			logrus.Fatal("Invalid message encryption keys: ", err)
+		}
+		utils.ConfigureContentEncryption(contentKeys)
+	}
+
+	database.ConfigureCircuitBreaker(cfg.DBCircuitFailureThreshold, time.Duration(cfg.DBCircuitCooldown)*time.Second)
+	utils.Providers.Configure(
+		time.Duration(cfg.ProviderTimeout)*time.Second,
+		cfg.ProviderMaxRetries,
+		cfg.ProviderCircuitFailureThreshold,
+		time.Duration(cfg.ProviderCircuitCooldown)*time.Second,
+	)
+	database.ConfigureQueryLimits(
+		time.Duration(cfg.DBReadTimeout)*time.Millisecond,
+		time.Duration(cfg.DBAggregateTimeout)*time.Millisecond,
+		time.Duration(cfg.DBSlowQueryThreshold)*time.Millisecond,
+	)
+
 	// Initialize database
 	db, err := database.Connect(cfg.DatabaseURL)
 	if err != nil {
@@ -47,7 +87,47 @@ func main() {
 	redis := config.InitRedis(cfg)
 	defer redis.Close()
 
+	utils.ConfigureProfanityFilter(cfg.ProfanityWords)
+
+	utils.ConfigurePasswordPolicy(utils.PasswordPolicy{
+		MinLength:        cfg.PasswordMinLength,
+		MaxLength:        utils.DefaultPasswordPolicy.MaxLength,
+		MinScore:         cfg.PasswordMinScore,
+		BreachedListPath: cfg.PasswordBreachedList,
+	})
+
+	utils.ConfigurePagination(cfg.DefaultPageSize, cfg.DefaultHistoryPageSize, cfg.MaxPageSize)
+
+	utils.ConfigureLocationStaleness(
+		time.Duration(cfg.LocationStaleAfter)*time.Minute,
+		time.Duration(cfg.LocationOfflineAfter)*time.Minute,
+	)
+
+	utils.ConfigureMessageRules(utils.MessageRules{
+		MaxContentLength: cfg.MaxMessageLength,
+	})
+
+	services.ConfigurePlaceSuggestions(services.PlaceSuggestionConfig{
+		Enabled:       cfg.PlaceSuggestionsEnabled,
+		StayRadius:    float64(cfg.PlaceSuggestionStayRadius),
+		MinStay:       time.Duration(cfg.PlaceSuggestionMinStay) * time.Minute,
+		ClusterRadius: float64(cfg.PlaceSuggestionClusterRadius),
+		MinVisits:     cfg.PlaceSuggestionMinVisits,
+		Lookback:      time.Duration(cfg.PlaceSuggestionLookback) * 24 * time.Hour,
+		Retention:     time.Duration(cfg.LocationRetention) * 24 * time.Hour,
+	})
+
 	// Initialize WebSocket hub
+	websocket.ConfigureCompression(websocket.CompressionConfig{
+		Enabled:   cfg.WSCompressionEnabled,
+		Level:     cfg.WSCompressionLevel,
+		Threshold: cfg.WSCompressionThreshold,
+	})
+	websocket.ConfigureFanout(websocket.FanoutConfig{
+		Workers:   cfg.WSFanoutWorkers,
+		QueueSize: cfg.WSFanoutQueueSize,
+		BatchSize: cfg.WSFanoutBatchSize,
+	})
 	hub := websocket.NewHub()
 	go hub.Run()
 
@@ -55,9 +135,24 @@ func main() {
 	workers.StartLocationWorker(db, redis, hub)
 	workers.StartNotificationWorker(db, redis)
 	workers.StartGeofenceWorker(db, redis, hub)
+	workers.StartCleanupWorker(db, redis, cfg.InitMediaObjectStore())
+	workers.StartMaintenanceWorker(db, redis)
+	workers.StartDepartureReminderWorker(db, redis, hub)
+	workers.StartVisitUpdateWorker(db, redis, hub)
+	workers.StartAnomalyWorker(db, redis, hub)
+	workers.StartOfflineMemberWorker(db, redis, hub)
+	workers.StartDrivingSafetyWorker(db, redis, hub)
+	workers.StartLocationPartitionWorker(db, redis)
+	workers.StartOutboxWorker(db, redis, hub)
+	workers.StartCircleMergeWorker(db, redis)
+	workers.StartAlbumWorker(db, redis)
+	workers.StartPlaceSuggestionWorker(db, redis)
+	workers.StartAccountDeactivationWorker(db, redis, cfg.InitEmailService(),
+		time.Duration(cfg.DeactivatedAccountRetention)*24*time.Hour,
+		time.Duration(cfg.DeactivationWarningDays)*24*time.Hour)
 
 	// Setup routes
-	router := routes.SetupRoutes(db, redis, hub)
+	router := routes.SetupRoutes(cfg, db, redis, hub)
 
 	// Create HTTP server
 	server := &http.Server{
@@ -91,10 +186,24 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
+	// WebSocket connections are hijacked and outlive server.Shutdown, so
+	// close them first with a frame telling clients to reconnect
+	if err := hub.Shutdown(ctx); err != nil {
+		logrus.Warn("WebSocket hub shutdown incomplete: ", err)
+	}
+
 	if err := server.Shutdown(ctx); err != nil {
 		logrus.Fatal("Server forced to shutdown: ", err)
 	}
 
+	// Let the broadcasts and notifications of the last requests finish
+	if pending := services.Background.Pending(); pending > 0 {
+		logrus.Infof("Waiting for %d background tasks", pending)
+	}
+	if err := services.Background.Shutdown(ctx); err != nil {
+		logrus.Warn("Background tasks cut off at shutdown: ", err)
+	}
+
 	logrus.Info("✅ Server shutdown complete")
 }
 
@@ -15,8 +15,16 @@ import (
 )
 
 type AuthMiddleware struct {
-	jwtService *utils.JWTService
-	userRepo   *repositories.UserRepository
+	jwtService    *utils.JWTService
+	userRepo      *repositories.UserRepository
+	impersonation ImpersonationGuard
+	secondFactor  SecondFactorVerifier
+}
+
+// SecondFactorVerifier checks a 2FA code presented in place of signing in
+// again, limiting failed attempts as at login
+type SecondFactorVerifier interface {
+	VerifySecondFactor(ctx context.Context, user *models.User, code string) error
 }
 
 func NewAuthMiddleware(jwtService *utils.JWTService, userRepo *repositories.UserRepository) *AuthMiddleware {
@@ -26,6 +34,11 @@ func NewAuthMiddleware(jwtService *utils.JWTService, userRepo *repositories.User
 	}
 }
 
+// Routes an account that has to change its password can still use
+var passwordChangeRoutes = map[string]bool{
+	"/api/v1/auth/change-password": true,
+}
+
 // RequireAuth validates JWT token and sets user context
 func (am *AuthMiddleware) RequireAuth() gin.HandlerFunc {
 	return gin.HandlerFunc(func(c *gin.Context) {
@@ -41,7 +54,7 @@ func (am *AuthMiddleware) RequireAuth() gin.HandlerFunc {
 		}
 
 		// Validate token
-		claims, err := am.jwtService.ValidateToken(token)
+		claims, err := am.jwtService.ValidateToken(token, utils.AudienceAccess)
 		if err != nil {
 			logrus.Warnf("Invalid token: %v", err)
 			c.JSON(http.StatusUnauthorized, models.ErrorResponse{
@@ -110,12 +123,39 @@ func (am *AuthMiddleware) RequireAuth() gin.HandlerFunc {
 			return
 		}
 
+		// An account that has to change its password can do nothing else
+		if user.PasswordChangeRequired && !passwordChangeRoutes[c.FullPath()] {
+			c.JSON(http.StatusForbidden, models.ErrorResponse{
+				Error:   "FORBIDDEN",
+				Message: "Change your password to continue",
+				Code:    "AUTH_PASSWORD_CHANGE_REQUIRED",
+			})
+			c.Abort()
+			return
+		}
+
+		session, allowed := am.authorizeImpersonation(c, claims)
+		if !allowed {
+			return
+		}
+
 		// Set user context
 		c.Set("user", user)
 		c.Set("userID", user.ID.Hex())
 		c.Set("userEmail", user.Email)
 		c.Set("userRole", claims.Role)
 
+		if session != nil {
+			// No authTime: an impersonating admin never passes for a
+			// recently signed in user, and the user isn't seen
+			setImpersonationContext(c, session)
+			c.Next()
+			am.recordImpersonatedRequest(c, session, c.Writer.Status())
+			return
+		}
+
+		c.Set("authTime", tokenAuthTime(claims))
+
 		// Update user last seen
 		go am.updateUserLastSeen(user.ID.Hex())
 
@@ -181,7 +221,7 @@ func (am *AuthMiddleware) OptionalAuth() gin.HandlerFunc {
 		}
 
 		// Validate token
-		claims, err := am.jwtService.ValidateToken(token)
+		claims, err := am.jwtService.ValidateToken(token, utils.AudienceAccess)
 		if err != nil {
 			// Log but don't abort for optional auth
 			logrus.Debugf("Optional auth - invalid token: %v", err)
@@ -196,6 +236,14 @@ func (am *AuthMiddleware) OptionalAuth() gin.HandlerFunc {
 			return
 		}
 
+		// Impersonation is only honored where auth is required, so that
+		// every request made with it is checked and audited
+		if claims.ImpersonationID != "" {
+			logrus.Debug("Optional auth - impersonation token ignored")
+			c.Next()
+			return
+		}
+
 		// Get user from database
 		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 		defer cancel()
@@ -263,6 +311,62 @@ func (am *AuthMiddleware) RequireVerification() gin.HandlerFunc {
 	})
 }
 
+// ConfigureSecondFactor lets users with 2FA enabled present a code instead
+// of a recent login. Without a verifier only a recent login passes.
+func (am *AuthMiddleware) ConfigureSecondFactor(verifier SecondFactorVerifier) {
+	am.secondFactor = verifier
+}
+
+// RequireRecentAuth requires the session to have been authenticated within maxAge.
+// Users with 2FA enabled may instead present a valid code in the X-2FA-Code header.
+// Must run after RequireAuth.
+func (am *AuthMiddleware) RequireRecentAuth(maxAge time.Duration) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		user, exists := GetCurrentUser(c)
+		if !exists {
+			c.JSON(http.StatusUnauthorized, models.ErrorResponse{
+				Error:   "UNAUTHORIZED",
+				Message: "User not authenticated",
+				Code:    "AUTH_USER_NOT_AUTHENTICATED",
+			})
+			c.Abort()
+			return
+		}
+
+		var codeErr error
+		if code := c.GetHeader("X-2FA-Code"); code != "" && user.TwoFactorEnabled && am.secondFactor != nil {
+			if codeErr = am.secondFactor.VerifySecondFactor(c.Request.Context(), user, code); codeErr == nil {
+				c.Next()
+				return
+			}
+		}
+
+		if authTime, ok := c.Get("authTime"); ok {
+			if t, ok := authTime.(time.Time); ok && time.Since(t) <= maxAge {
+				c.Next()
+				return
+			}
+		}
+
+		if codeErr != nil && codeErr.Error() == "too many 2fa attempts" {
+			c.JSON(http.StatusTooManyRequests, models.ErrorResponse{
+				Error:   "TOO_MANY_REQUESTS",
+				Message: "Too many two-factor authentication attempts, sign in again",
+				Code:    "AUTH_2FA_TOO_MANY_ATTEMPTS",
+			})
+			c.Abort()
+			return
+		}
+
+		c.JSON(http.StatusForbidden, models.ErrorResponse{
+			Error:   "FORBIDDEN",
+			Message: "Recent authentication required",
+			Code:    "AUTH_REAUTHENTICATION_REQUIRED",
+		})
+		c.Abort()
+	})
+}
+
 // WebSocketAuth validates token for WebSocket connections
 func (am *AuthMiddleware) WebSocketAuth(token string) (*models.User, error) {
 	if token == "" {
@@ -270,7 +374,7 @@ func (am *AuthMiddleware) WebSocketAuth(token string) (*models.User, error) {
 	}
 
 	// Validate token
-	claims, err := am.jwtService.ValidateToken(token)
+	claims, err := am.jwtService.ValidateToken(token, utils.AudienceAccess)
 	if err != nil {
 		return nil, utils.NewValidationError("Invalid authentication token")
 	}
@@ -285,6 +389,11 @@ func (am *AuthMiddleware) WebSocketAuth(token string) (*models.User, error) {
 		return nil, utils.NewValidationError("Invalid token type")
 	}
 
+	// WebSocket traffic can't be audited per request
+	if claims.ImpersonationID != "" {
+		return nil, utils.NewValidationError("Impersonation tokens can't open WebSockets")
+	}
+
 	// Get user from database
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -302,6 +411,10 @@ func (am *AuthMiddleware) WebSocketAuth(token string) (*models.User, error) {
 		return nil, utils.NewValidationError("User account is deactivated")
 	}
 
+	if user.PasswordChangeRequired {
+		return nil, utils.NewValidationError("Password change required")
+	}
+
 	// Update user last seen
 	go am.updateUserLastSeen(user.ID.Hex())
 
@@ -333,6 +446,17 @@ func (am *AuthMiddleware) extractToken(c *gin.Context) string {
 	return ""
 }
 
+// tokenAuthTime returns when the user last authenticated interactively
+func tokenAuthTime(claims *utils.Claims) time.Time {
+	if claims.AuthTime > 0 {
+		return time.Unix(claims.AuthTime, 0)
+	}
+	if claims.IssuedAt != nil {
+		return claims.IssuedAt.Time
+	}
+	return time.Time{}
+}
+
 // updateUserLastSeen updates user's last seen timestamp
 func (am *AuthMiddleware) updateUserLastSeen(userID string) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"ftrack/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeSecondFactor accepts one code and, like the auth service, refuses
+// every code once too many were wrong
+type fakeSecondFactor struct {
+	code        string
+	maxFailures int
+	failures    int
+	calls       int
+}
+
+func (f *fakeSecondFactor) VerifySecondFactor(ctx context.Context, user *models.User, code string) error {
+	f.calls++
+	if f.failures >= f.maxFailures {
+		return errors.New("too many 2fa attempts")
+	}
+	if code != f.code {
+		f.failures++
+		return errors.New("invalid 2fa code")
+	}
+	return nil
+}
+
+func recentAuthRequest(t *testing.T, am *AuthMiddleware, user *models.User, authTime time.Time, code string) int {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/", func(c *gin.Context) {
+		c.Set("user", user)
+		if !authTime.IsZero() {
+			c.Set("authTime", authTime)
+		}
+	}, am.RequireRecentAuth(15*time.Minute), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	if code != "" {
+		req.Header.Set("X-2FA-Code", code)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestRequireRecentAuth(t *testing.T) {
+	user := &models.User{TwoFactorEnabled: true}
+	stale := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name     string
+		authTime time.Time
+		code     string
+		want     int
+	}{
+		{"recent login", time.Now(), "", http.StatusNoContent},
+		{"stale login", stale, "", http.StatusForbidden},
+		{"stale login with code", stale, "123456", http.StatusNoContent},
+		{"stale login with wrong code", stale, "000000", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			am := &AuthMiddleware{}
+			am.ConfigureSecondFactor(&fakeSecondFactor{code: "123456", maxFailures: 5})
+
+			if got := recentAuthRequest(t, am, user, tt.authTime, tt.code); got != tt.want {
+				t.Errorf("status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireRecentAuthLimitsCodeGuesses(t *testing.T) {
+	user := &models.User{TwoFactorEnabled: true}
+	stale := time.Now().Add(-time.Hour)
+	verifier := &fakeSecondFactor{code: "123456", maxFailures: 3}
+
+	am := &AuthMiddleware{}
+	am.ConfigureSecondFactor(verifier)
+
+	for i := 0; i < 3; i++ {
+		if got := recentAuthRequest(t, am, user, stale, "000000"); got != http.StatusForbidden {
+			t.Fatalf("guess %d: status = %d, want %d", i+1, got, http.StatusForbidden)
+		}
+	}
+
+	// Even the right code is refused once the limit is reached
+	if got := recentAuthRequest(t, am, user, stale, "123456"); got != http.StatusTooManyRequests {
+		t.Errorf("status after too many guesses = %d, want %d", got, http.StatusTooManyRequests)
+	}
+	if verifier.calls != 4 {
+		t.Errorf("verifier called %d times, want every code checked through it", verifier.calls)
+	}
+}
+
+func TestRequireRecentAuthWithoutVerifier(t *testing.T) {
+	user := &models.User{TwoFactorEnabled: true}
+
+	// Codes can't be checked, so only a recent login passes
+	if got := recentAuthRequest(t, &AuthMiddleware{}, user, time.Now().Add(-time.Hour), "123456"); got != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", got, http.StatusForbidden)
+	}
+}
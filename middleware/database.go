@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"strings"
+
+	"ftrack/database"
+	"ftrack/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DatabaseCircuitBreaker answers 503 with a Retry-After while the database
+// circuit is open, instead of letting requests fail against MongoDB. Health
+// checks still run so they can report the outage.
+func DatabaseCircuitBreaker() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if strings.HasPrefix(c.Request.URL.Path, "/health") {
+			c.Next()
+			return
+		}
+
+		if err := database.Breaker.Allow(); err != nil {
+			utils.HandleServiceError(c, err)
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// QueryTimeouts tracks whether the request's database queries run out of
+// time, so a failure they cause is answered with a 504 rather than a 500
+func QueryTimeouts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(utils.TrackQueryTimeouts(c.Request.Context()))
+		c.Next()
+	}
+}
@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"ftrack/models"
+	"ftrack/services"
+	"ftrack/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// ImpersonationGuard checks impersonation tokens against their sessions and
+// records what is done with them
+type ImpersonationGuard interface {
+	CheckImpersonation(ctx context.Context, sessionID, adminID, userID string) (*models.ImpersonationSession, error)
+	RecordImpersonatedRequest(ctx context.Context, session *models.ImpersonationSession, request models.ImpersonatedRequest)
+}
+
+// Routes an impersonating admin can never use, writes approved or not:
+// signing in and out, second factors, account removal, and answering
+// impersonation requests
+var impersonationBlockedRoutes = []string{
+	"/api/v1/auth/",
+	"/api/v1/users/me/mfa",
+	"/api/v1/users/me/deactivate",
+	"/api/v1/users/me/impersonations",
+}
+
+// ConfigureImpersonation accepts impersonation tokens, checking each one
+// with the guard. Without a guard they are refused.
+func (am *AuthMiddleware) ConfigureImpersonation(guard ImpersonationGuard) {
+	am.impersonation = guard
+}
+
+// authorizeImpersonation checks a request made with an impersonation token:
+// the session must still be live, and the request allowed under it. It
+// writes the response and returns false if not. Tokens that aren't for
+// impersonation pass with no session.
+func (am *AuthMiddleware) authorizeImpersonation(c *gin.Context, claims *utils.Claims) (*models.ImpersonationSession, bool) {
+	if claims.ImpersonationID == "" {
+		return nil, true
+	}
+
+	if am.impersonation == nil {
+		abortImpersonation(c, http.StatusUnauthorized, "UNAUTHORIZED", "Impersonation is not enabled", "AUTH_IMPERSONATION_DISABLED")
+		return nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	session, err := am.impersonation.CheckImpersonation(ctx, claims.ImpersonationID, claims.ImpersonatorID, claims.UserID)
+	if err != nil {
+		logrus.Warnf("Impersonation token of session %s refused: %v", claims.ImpersonationID, err)
+		abortImpersonation(c, http.StatusUnauthorized, "UNAUTHORIZED", "Impersonation session has ended", "AUTH_IMPERSONATION_ENDED")
+		return nil, false
+	}
+
+	if reason, code := impersonationRefusal(c, session); reason != "" {
+		am.recordImpersonatedRequest(c, session, http.StatusForbidden)
+		abortImpersonation(c, http.StatusForbidden, "FORBIDDEN", reason, code)
+		return nil, false
+	}
+
+	return session, true
+}
+
+// impersonationRefusal returns why the request isn't allowed under the
+// session, if it isn't
+func impersonationRefusal(c *gin.Context, session *models.ImpersonationSession) (string, string) {
+	route := c.FullPath()
+	for _, blocked := range impersonationBlockedRoutes {
+		if strings.HasPrefix(route, blocked) {
+			return "Not available while impersonating", "AUTH_IMPERSONATION_BLOCKED"
+		}
+	}
+	if c.Request.Method == http.MethodDelete && route == "/api/v1/users/me" {
+		return "Not available while impersonating", "AUTH_IMPERSONATION_BLOCKED"
+	}
+
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return "", ""
+	}
+	if !session.AllowWrites {
+		return "Impersonation is read-only", "AUTH_IMPERSONATION_READ_ONLY"
+	}
+	return "", ""
+}
+
+// setImpersonationContext marks the request as made by the admin on the
+// user's behalf
+func setImpersonationContext(c *gin.Context, session *models.ImpersonationSession) {
+	c.Set("impersonated", true)
+	c.Set("impersonationID", session.ID.Hex())
+	c.Set("impersonatorID", session.AdminID.Hex())
+	c.Set("impersonationWritable", session.AllowWrites)
+	c.Header("X-Impersonation-Session", session.ID.Hex())
+}
+
+// recordImpersonatedRequest audits the request once it has been handled
+func (am *AuthMiddleware) recordImpersonatedRequest(c *gin.Context, session *models.ImpersonationSession, status int) {
+	request := models.ImpersonatedRequest{
+		Method:    c.Request.Method,
+		Route:     c.FullPath(),
+		Path:      c.Request.URL.Path,
+		Status:    status,
+		IPAddress: c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+
+	services.Background.Go(c.Request.Context(), func(ctx context.Context) {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		am.impersonation.RecordImpersonatedRequest(ctx, session, request)
+	})
+}
+
+func abortImpersonation(c *gin.Context, status int, errorName, message, code string) {
+	c.JSON(status, models.ErrorResponse{
+		Error:   errorName,
+		Message: message,
+		Code:    code,
+	})
+	c.Abort()
+}
+
+// GetImpersonatorID returns the admin acting as the current user, if the
+// request was made under impersonation
+func GetImpersonatorID(c *gin.Context) (string, bool) {
+	impersonatorID := c.GetString("impersonatorID")
+	return impersonatorID, impersonatorID != ""
+}
@@ -312,6 +312,8 @@ func APIRateLimit(redis *redis.Client) gin.HandlerFunc {
 			"/health",
 			"/metrics",
 			"/docs",
+			// Typeahead fires on every keystroke and has its own limiter
+			"/api/v1/places/typeahead",
 		},
 	}
 
@@ -347,6 +349,35 @@ func MessageRateLimit(redis *redis.Client) gin.HandlerFunc {
 	return limiter.Middleware()
 }
 
+// MediaStreamRateLimit creates rate limiter for media streaming. Players
+// send a request per seek, so it allows more than uploads.
+func MediaStreamRateLimit(redis *redis.Client) gin.HandlerFunc {
+	config := RateLimitConfig{
+		Redis:        redis,
+		Requests:     120,
+		Window:       time.Minute,
+		KeyPrefix:    "media_stream_rate_limit",
+		ErrorMessage: "Media rate limit exceeded. Please try again later.",
+	}
+
+	limiter := NewRateLimiter(config, StrategyUser)
+	return limiter.Middleware()
+}
+
+// TypeaheadRateLimit creates lenient rate limiter for search-as-you-type
+func TypeaheadRateLimit(redis *redis.Client) gin.HandlerFunc {
+	config := RateLimitConfig{
+		Redis:        redis,
+		Requests:     300,
+		Window:       time.Minute,
+		KeyPrefix:    "typeahead_rate_limit",
+		ErrorMessage: "Search rate limit exceeded. Please slow down.",
+	}
+
+	limiter := NewRateLimiter(config, StrategyUser)
+	return limiter.Middleware()
+}
+
 // EmergencyRateLimit creates rate limiter for emergency alerts
 func EmergencyRateLimit(redis *redis.Client) gin.HandlerFunc {
 	config := RateLimitConfig{
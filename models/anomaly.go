@@ -0,0 +1,138 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ==================== MOVEMENT ANOMALY ALERTS ====================
+
+// Anomaly rule types
+const (
+	AnomalyRuleNoMovement       = "no_movement"       // hasn't moved for a while during active hours
+	AnomalyRuleUnusualDeparture = "unusual_departure" // left home during unusual hours
+	AnomalyRuleLeftSafeZone     = "left_safe_zone"    // left the safe places for somewhere else
+)
+
+// Anomaly alert feedback
+const (
+	AnomalyFeedbackFalsePositive = "false_positive"
+	AnomalyFeedbackConfirmed     = "confirmed"
+)
+
+const NotificationTypeAnomaly = "anomaly_alert"
+
+// AnomalyProfile holds a member's movement anomaly rules and the watchers
+// alerted when one trips. Members configure their own profile; admins of a
+// circle where the member is supervised can configure it for them.
+type AnomalyProfile struct {
+	ID       primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
+	UserID   primitive.ObjectID   `json:"userId" bson:"userId"`
+	Enabled  bool                 `json:"enabled" bson:"enabled"`
+	Timezone string               `json:"timezone" bson:"timezone"`
+	Watchers []primitive.ObjectID `json:"watchers" bson:"watchers"`
+	Rules    []AnomalyRule        `json:"rules" bson:"rules"`
+
+	LastEvaluatedAt *time.Time         `json:"lastEvaluatedAt,omitempty" bson:"lastEvaluatedAt,omitempty"`
+	UpdatedBy       primitive.ObjectID `json:"updatedBy" bson:"updatedBy"`
+	CreatedAt       time.Time          `json:"createdAt" bson:"createdAt"`
+	UpdatedAt       time.Time          `json:"updatedAt" bson:"updatedAt"`
+}
+
+// AnomalyRule is one rule of a profile. Only the settings of its type are
+// used. False-positive feedback widens the thresholds a little each time.
+type AnomalyRule struct {
+	ID              primitive.ObjectID `json:"id" bson:"id"`
+	Type            string             `json:"type" bson:"type"`
+	Enabled         bool               `json:"enabled" bson:"enabled"`
+	CooldownMinutes int                `json:"cooldownMinutes" bson:"cooldownMinutes"`
+
+	// no_movement: no more than MovementMeters of movement for WindowMinutes,
+	// checked during ActiveHours
+	WindowMinutes  int                `json:"windowMinutes,omitempty" bson:"windowMinutes,omitempty"`
+	MovementMeters float64            `json:"movementMeters,omitempty" bson:"movementMeters,omitempty"`
+	ActiveHours    *AnomalyTimeWindow `json:"activeHours,omitempty" bson:"activeHours,omitempty"`
+
+	// unusual_departure: leaving HomePlaceID during UnusualHours. Rules
+	// without one follow the home the member has set for themselves.
+	HomePlaceID  primitive.ObjectID `json:"homePlaceId,omitempty" bson:"homePlaceId,omitempty"`
+	UnusualHours *AnomalyTimeWindow `json:"unusualHours,omitempty" bson:"unusualHours,omitempty"`
+
+	// left_safe_zone: moving from inside one of SafePlaceIDs to outside all
+	// of them, by more than BufferMeters past their radius
+	SafePlaceIDs []primitive.ObjectID `json:"safePlaceIds,omitempty" bson:"safePlaceIds,omitempty"`
+	BufferMeters float64              `json:"bufferMeters,omitempty" bson:"bufferMeters,omitempty"`
+
+	FalsePositives  int        `json:"falsePositives" bson:"falsePositives"`
+	LastTriggeredAt *time.Time `json:"lastTriggeredAt,omitempty" bson:"lastTriggeredAt,omitempty"`
+}
+
+// AnomalyTimeWindow is a daily window in the profile's timezone. Windows
+// ending before they start wrap past midnight.
+type AnomalyTimeWindow struct {
+	Start string `json:"start" bson:"start" validate:"required"` // HH:MM
+	End   string `json:"end" bson:"end" validate:"required"`     // HH:MM
+}
+
+// AnomalyAlert is a tripped rule with the events that tripped it
+type AnomalyAlert struct {
+	ID        primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
+	ProfileID primitive.ObjectID   `json:"profileId" bson:"profileId"`
+	UserID    primitive.ObjectID   `json:"userId" bson:"userId"`
+	RuleID    primitive.ObjectID   `json:"ruleId" bson:"ruleId"`
+	RuleType  string               `json:"ruleType" bson:"ruleType"`
+	Title     string               `json:"title" bson:"title"`
+	Message   string               `json:"message" bson:"message"`
+	Evidence  []AnomalyEvidence    `json:"evidence" bson:"evidence"`
+	Watchers  []primitive.ObjectID `json:"watchers" bson:"watchers"`
+
+	Feedback   string              `json:"feedback,omitempty" bson:"feedback,omitempty"`
+	FeedbackBy *primitive.ObjectID `json:"feedbackBy,omitempty" bson:"feedbackBy,omitempty"`
+	FeedbackAt *time.Time          `json:"feedbackAt,omitempty" bson:"feedbackAt,omitempty"`
+
+	TriggeredAt time.Time `json:"triggeredAt" bson:"triggeredAt"`
+}
+
+// AnomalyEvidence is a location or visit that tripped a rule
+type AnomalyEvidence struct {
+	Kind      string             `json:"kind" bson:"kind"` // location, visit
+	ID        primitive.ObjectID `json:"id" bson:"id"`
+	PlaceID   primitive.ObjectID `json:"placeId,omitempty" bson:"placeId,omitempty"`
+	Latitude  float64            `json:"latitude,omitempty" bson:"latitude,omitempty"`
+	Longitude float64            `json:"longitude,omitempty" bson:"longitude,omitempty"`
+	Timestamp time.Time          `json:"timestamp" bson:"timestamp"`
+	Note      string             `json:"note" bson:"note"`
+}
+
+// UpdateAnomalyProfileRequest replaces a member's profile. Rules sent with
+// their id keep their feedback and cooldown state.
+type UpdateAnomalyProfileRequest struct {
+	Enabled  bool                 `json:"enabled"`
+	Timezone string               `json:"timezone,omitempty"`
+	Watchers []string             `json:"watchers" validate:"max=10"`
+	Rules    []AnomalyRuleRequest `json:"rules" validate:"max=10,dive"`
+}
+
+type AnomalyRuleRequest struct {
+	ID              string             `json:"id,omitempty"`
+	Type            string             `json:"type" validate:"required,oneof=no_movement unusual_departure left_safe_zone"`
+	Enabled         *bool              `json:"enabled,omitempty"`
+	CooldownMinutes int                `json:"cooldownMinutes,omitempty" validate:"omitempty,min=5,max=1440"`
+	WindowMinutes   int                `json:"windowMinutes,omitempty" validate:"omitempty,min=30,max=2880"`
+	MovementMeters  float64            `json:"movementMeters,omitempty" validate:"omitempty,min=10,max=5000"`
+	ActiveHours     *AnomalyTimeWindow `json:"activeHours,omitempty"`
+	HomePlaceID     string             `json:"homePlaceId,omitempty"`
+	UnusualHours    *AnomalyTimeWindow `json:"unusualHours,omitempty"`
+	SafePlaceIDs    []string           `json:"safePlaceIds,omitempty" validate:"max=20"`
+	BufferMeters    float64            `json:"bufferMeters,omitempty" validate:"omitempty,min=0,max=2000"`
+}
+
+type AnomalyFeedbackRequest struct {
+	Feedback string `json:"feedback" validate:"required,oneof=false_positive confirmed"`
+}
+
+type AnomalyAlertsResponse struct {
+	Alerts []AnomalyAlert `json:"alerts"`
+	Meta   PaginationMeta `json:"meta"`
+}
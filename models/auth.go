@@ -17,12 +17,13 @@ type LoginRequest struct {
 	IPAddress     string `json:"ipAddress,omitempty"`
 	UserAgent     string `json:"userAgent,omitempty"`
 	RememberMe    bool   `json:"rememberMe,omitempty"`
+	Reactivate    bool   `json:"reactivate,omitempty"` // Confirms reactivating a deactivated account
 }
 
 type RegisterRequest struct {
 	Email            string           `json:"email" validate:"required,email"`
 	Phone            string           `json:"phone" validate:"required"`
-	Password         string           `json:"password" validate:"required,min=6"`
+	Password         string           `json:"password" validate:"required"` // checked against the password policy
 	FirstName        string           `json:"firstName" validate:"required"`
 	LastName         string           `json:"lastName" validate:"required"`
 	EmergencyContact EmergencyContact `json:"emergencyContact"`
@@ -74,6 +75,25 @@ type AuthResponse struct {
 	ExpiresIn            int64  `json:"expiresIn"`
 	RequiresVerification bool   `json:"requiresVerification,omitempty"`
 	Requires2FA          bool   `json:"requires2FA,omitempty"`
+
+	// Set instead of tokens when the login needs a second factor
+	MFAChallenge *MFAChallenge `json:"mfaChallenge,omitempty"`
+}
+
+// MFAChallenge is the second login step for users with 2FA enabled
+type MFAChallenge struct {
+	MFAToken  string   `json:"mfaToken"`
+	ExpiresIn int64    `json:"expiresIn"` // seconds
+	Methods   []string `json:"methods"`   // totp, backup_code
+}
+
+type MFALoginRequest struct {
+	MFAToken string `json:"mfaToken" validate:"required"`
+	Code     string `json:"code" validate:"required"`
+}
+
+type VerifyMFARequest struct {
+	Code string `json:"code" validate:"required"`
 }
 
 type TokenValidationResponse struct {
@@ -261,6 +281,22 @@ type LoginAttempt struct {
 	CreatedAt  time.Time          `json:"createdAt" bson:"createdAt"`
 }
 
+// Lockouts, unlocks and forced password changes are sent to the user as
+// account security notifications
+const NotificationTypeAccountSecurity = "account_security"
+
+// PasswordRotationRequest makes a user change their password before they
+// can do anything else, or lets them off
+type PasswordRotationRequest struct {
+	Required bool   `json:"required"`
+	Reason   string `json:"reason" validate:"max=500"`
+}
+
+// UnlockAccountRequest ends a user's login lockout
+type UnlockAccountRequest struct {
+	Reason string `json:"reason" validate:"max=500"`
+}
+
 // ============== API KEY MANAGEMENT ==============
 
 type APIKey struct {
@@ -277,6 +313,26 @@ type APIKey struct {
 	UpdatedAt   time.Time          `json:"updatedAt" bson:"updatedAt"`
 }
 
+// ============== TOKEN SIGNING KEYS ==============
+
+// SigningKey is a stored key of the JWT key ring. The secret is encrypted.
+// Retired keys verify tokens until they expire.
+type SigningKey struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	KeyID     string             `json:"kid" bson:"kid"`
+	Secret    string             `json:"-" bson:"secret"`
+	Active    bool               `json:"active" bson:"active"`
+	CreatedBy string             `json:"createdBy,omitempty" bson:"createdBy,omitempty"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+	RetiredAt *time.Time         `json:"retiredAt,omitempty" bson:"retiredAt,omitempty"`
+	ExpiresAt *time.Time         `json:"expiresAt,omitempty" bson:"expiresAt,omitempty"`
+}
+
+type WSTicketResponse struct {
+	Ticket    string    `json:"ticket"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
 // ============== MESSAGE TYPES FOR VARIOUS FEATURES ==============
 
 type MessageType struct {
@@ -308,6 +364,10 @@ const (
 	EventSuspiciousActivity = "suspicious_activity"
 	EventAccountLocked      = "account_locked"
 	EventAccountUnlocked    = "account_unlocked"
+	EventIPLockedOut        = "ip_locked_out"
+
+	EventPasswordRotationRequired = "password_rotation_required"
+	EventPasswordRotationCleared  = "password_rotation_cleared"
 
 	// Security severity levels
 	SeverityInfo    = "info"
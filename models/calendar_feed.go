@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// Feeds a user can have at once
+	MaxCalendarFeeds = 10
+
+	// Days ahead a feed covers, with recurring items expanded
+	CalendarFeedHorizonDays = 90
+
+	// How long calendar apps may cache a feed
+	CalendarFeedCacheMaxAge = 15 * time.Minute
+)
+
+// CalendarFeed is a private iCal link to the scheduled items of the
+// selected circles. Only a hash of its token is stored, so the link is
+// shown once, when the feed is created.
+type CalendarFeed struct {
+	ID            primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
+	UserID        primitive.ObjectID   `json:"userId" bson:"userId"`
+	Name          string               `json:"name" bson:"name"`
+	CircleIDs     []primitive.ObjectID `json:"circleIds" bson:"circleIds"`
+	TokenHash     string               `json:"-" bson:"tokenHash"`
+	LastFetchedAt *time.Time           `json:"lastFetchedAt,omitempty" bson:"lastFetchedAt,omitempty"`
+	CreatedAt     time.Time            `json:"createdAt" bson:"createdAt"`
+}
+
+type CreateCalendarFeedRequest struct {
+	Name      string   `json:"name" validate:"max=100"`
+	CircleIDs []string `json:"circleIds" validate:"required,min=1,max=20"`
+}
+
+// CreatedCalendarFeed carries the feed's link, which isn't shown again
+type CreatedCalendarFeed struct {
+	CalendarFeed
+	URL string `json:"url"`
+}
@@ -16,22 +16,67 @@ type Circle struct {
 	// Settings
 	Settings CircleSettings `json:"settings" bson:"settings"`
 
+	// Geofence settings the circle's places inherit
+	GeofenceDefaults *GeofenceDefaults `json:"geofenceDefaults,omitempty" bson:"geofenceDefaults,omitempty"`
+
+	// Who may edit and delete messages, when not the defaults
+	MessagePolicy *MessagePolicy `json:"messagePolicy,omitempty" bson:"messagePolicy,omitempty"`
+
+	// Who may export the circle's shared data, when not the default
+	ExportPolicy *ExportPolicy `json:"exportPolicy,omitempty" bson:"exportPolicy,omitempty"`
+
 	// Statistics
 	Stats CircleStats `json:"stats" bson:"stats"`
 
+	// Set for admins when members have blocked each other. Who blocked whom
+	// is never exposed.
+	HasMemberBlocks bool `json:"hasMemberBlocks,omitempty" bson:"-"`
+
+	// Set when the circle was merged into another one. Archived circles are
+	// read-only and deleted after DeleteAfter.
+	ArchivedAt  *time.Time          `json:"archivedAt,omitempty" bson:"archivedAt,omitempty"`
+	MergedInto  *primitive.ObjectID `json:"mergedInto,omitempty" bson:"mergedInto,omitempty"`
+	DeleteAfter *time.Time          `json:"deleteAfter,omitempty" bson:"deleteAfter,omitempty"`
+
 	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"`
 }
 
+// IsOwner reports whether the user owns the circle. The owner is always
+// also an admin member.
+func (c *Circle) IsOwner(userID string) bool {
+	return c.AdminID.Hex() == userID
+}
+
+// IsArchived reports whether the circle was merged away and is read-only
+func (c *Circle) IsArchived() bool {
+	return c.ArchivedAt != nil
+}
+
 type CircleMember struct {
 	UserID       primitive.ObjectID `json:"userId" bson:"userId"`
 	Role         string             `json:"role" bson:"role"`     // admin, member
-	Status       string             `json:"status" bson:"status"` // active, pending, invited
+	Status       string             `json:"status" bson:"status"` // active, pending, invited, deactivated
 	JoinedAt     time.Time          `json:"joinedAt" bson:"joinedAt"`
 	InvitedAt    time.Time          `json:"invitedAt,omitempty" bson:"invitedAt,omitempty"`
 	InvitedBy    primitive.ObjectID `json:"invitedBy,omitempty" bson:"invitedBy,omitempty"`
 	Permissions  MemberPermissions  `json:"permissions" bson:"permissions"`
 	LastActivity time.Time          `json:"lastActivity" bson:"lastActivity"`
+
+	// A custom role the owner assigned, granting the member its
+	// permissions
+	CustomRoleID *primitive.ObjectID `json:"customRoleId,omitempty" bson:"customRoleId,omitempty"`
+
+	// Each member opts in for themselves, per circle
+	NearbyAlerts NearbyAlertSettings `json:"nearbyAlerts" bson:"nearbyAlerts"`
+
+	// Whether the member shares their trip safety summaries, their call
+	// alone
+	DrivingSafety DrivingSafetySharing `json:"drivingSafety" bson:"drivingSafety"`
+
+	// When the circle was last told the member went offline, cleared once
+	// they're back
+	OfflineAlertedAt *time.Time `json:"-" bson:"offlineAlertedAt,omitempty"`
 }
 
 type MemberPermissions struct {
@@ -41,6 +86,10 @@ type MemberPermissions struct {
 	CanManagePlaces  bool `json:"canManagePlaces" bson:"canManagePlaces"`
 	CanReceiveAlerts bool `json:"canReceiveAlerts" bson:"canReceiveAlerts"`
 	CanSendEmergency bool `json:"canSendEmergency" bson:"canSendEmergency"`
+
+	// Child or supervised accounts. Circle admins manage their anomaly
+	// alert rules.
+	Supervised bool `json:"supervised" bson:"supervised"`
 }
 
 type CircleSettings struct {
@@ -52,6 +101,39 @@ type CircleSettings struct {
 	EmergencyAlerts    bool `json:"emergencyAlerts" bson:"emergencyAlerts"`
 	AutoCheckIn        bool `json:"autoCheckIn" bson:"autoCheckIn"`
 	PlaceNotifications bool `json:"placeNotifications" bson:"placeNotifications"`
+
+	// Message and draft content is stored encrypted. It is left out of
+	// content search, which can't read it.
+	EncryptMessages bool `json:"encryptMessages" bson:"encryptMessages"`
+
+	// Admins see members' location integrity scores and are alerted when
+	// one drops
+	IntegrityAlerts bool `json:"integrityAlerts" bson:"integrityAlerts"`
+
+	// A member's latest location shows as stale, then as offline, after
+	// this long without an update. Zero uses the server's defaults.
+	StaleAfterMinutes   int `json:"staleAfterMinutes,omitempty" bson:"staleAfterMinutes,omitempty"`
+	OfflineAfterMinutes int `json:"offlineAfterMinutes,omitempty" bson:"offlineAfterMinutes,omitempty"`
+
+	// Members are told when another member goes offline, e.g. their phone
+	// died
+	OfflineAlerts bool `json:"offlineAlerts" bson:"offlineAlerts"`
+}
+
+// LocationStaleness returns how long a member's location stays fresh in
+// the circle, and how long until they show as offline
+func (s CircleSettings) LocationStaleness() (staleAfter, offlineAfter time.Duration) {
+	staleAfter, offlineAfter = DefaultLocationStaleAfter, DefaultLocationOfflineAfter
+	if s.StaleAfterMinutes > 0 {
+		staleAfter = time.Duration(s.StaleAfterMinutes) * time.Minute
+	}
+	if s.OfflineAfterMinutes > 0 {
+		offlineAfter = time.Duration(s.OfflineAfterMinutes) * time.Minute
+	}
+	if offlineAfter < staleAfter {
+		offlineAfter = staleAfter
+	}
+	return staleAfter, offlineAfter
 }
 
 type CircleStats struct {
@@ -71,6 +153,10 @@ type JoinCircleRequest struct {
 	InviteCode string `json:"inviteCode" validate:"required"`
 }
 
+type JoinByInviteLinkRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
 type InviteMemberRequest struct {
 	Email       string            `json:"email,omitempty" validate:"omitempty,email"`
 	Phone       string            `json:"phone,omitempty" validate:"omitempty,min=10"`
@@ -84,6 +170,29 @@ type UpdateCircleRequest struct {
 	Settings *CircleSettings `json:"settings,omitempty"`
 }
 
+// Bounds and default of the distance nearby alerts fire at, in meters
+const (
+	MinNearbyAlertDistance     = 100
+	MaxNearbyAlertDistance     = 5000
+	DefaultNearbyAlertDistance = 500
+)
+
+const NotificationTypeNearbyMember = "nearby_member"
+
+// NearbyAlertSettings is a member's opt-in to hearing when another member
+// of the circle is close by, away from the circle's places. Alerts only
+// fire between two members who both opted in.
+type NearbyAlertSettings struct {
+	Enabled   bool       `json:"enabled" bson:"enabled"`
+	Distance  int        `json:"distance" bson:"distance"` // meters
+	UpdatedAt *time.Time `json:"updatedAt,omitempty" bson:"updatedAt,omitempty"`
+}
+
+type UpdateNearbyAlertsRequest struct {
+	Enabled  bool `json:"enabled"`
+	Distance int  `json:"distance,omitempty" validate:"omitempty,min=100,max=5000"`
+}
+
 type UpdateMemberPermissionsRequest struct {
 	UserID      string            `json:"userId" validate:"required"`
 	Permissions MemberPermissions `json:"permissions"`
@@ -93,6 +202,13 @@ type UpdateMemberRoleRequest struct {
 	Role string `json:"role" validate:"required,oneof=admin member"`
 }
 
+type TransferOwnershipRequest struct {
+	NewOwnerID string `json:"newOwnerId" validate:"required"`
+	Confirm    bool   `json:"confirm"`
+	IPAddress  string `json:"-"`
+	UserAgent  string `json:"-"`
+}
+
 // Invitation model
 type CircleInvitation struct {
 	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
@@ -106,6 +222,9 @@ type CircleInvitation struct {
 	ExpiresAt time.Time          `json:"expiresAt" bson:"expiresAt"`
 	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
 	UpdatedAt time.Time          `json:"updatedAt" bson:"updatedAt"`
+
+	// Signed invite link token, only returned to the inviter on creation
+	Token string `json:"token,omitempty" bson:"-"`
 }
 
 // Join Request model
@@ -120,17 +239,45 @@ type JoinRequest struct {
 }
 
 // Announcement model
+// CircleAnnouncement is sent by an admin to the members as a notification
 type CircleAnnouncement struct {
-	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	CircleID  primitive.ObjectID `json:"circleId" bson:"circleId"`
-	AuthorID  primitive.ObjectID `json:"authorId" bson:"authorId"`
-	Title     string             `json:"title" bson:"title"`
-	Message   string             `json:"message" bson:"message"`
-	Type      string             `json:"type" bson:"type"` // info, warning, urgent
-	Priority  int                `json:"priority" bson:"priority"`
-	IsRead    map[string]bool    `json:"isRead" bson:"isRead"` // userID -> read status
-	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
-	UpdatedAt time.Time          `json:"updatedAt" bson:"updatedAt"`
+	ID         primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
+	CircleID   primitive.ObjectID  `json:"circleId" bson:"circleId"`
+	AuthorID   primitive.ObjectID  `json:"authorId" bson:"authorId"`
+	Title      string              `json:"title" bson:"title"`
+	Message    string              `json:"body" bson:"message"`
+	Priority   string              `json:"priority" bson:"priority"` // normal, high
+	PlaceID    *primitive.ObjectID `json:"placeId,omitempty" bson:"placeId,omitempty"`
+	EventID    string              `json:"eventId,omitempty" bson:"eventId,omitempty"`
+	Recipients int                 `json:"recipients" bson:"recipients"` // members it was sent to
+	Muted      int                 `json:"muted" bson:"muted"`           // members skipped for muting announcements
+	CreatedAt  time.Time           `json:"createdAt" bson:"createdAt"`
+	UpdatedAt  time.Time           `json:"updatedAt" bson:"updatedAt"`
+}
+
+// Announcements a circle can send in a day
+const MaxCircleAnnouncementsPerDay = 3
+
+const NotificationTypeCircleAnnouncement = "circle_announcement"
+
+type CreateAnnouncementRequest struct {
+	Title    string `json:"title" validate:"required,max=100"`
+	Body     string `json:"body" validate:"required,max=1000"`
+	Priority string `json:"priority,omitempty" validate:"omitempty,oneof=normal high"` // normal by default
+	PlaceID  string `json:"placeId,omitempty"`
+	EventID  string `json:"eventId,omitempty" validate:"max=100"`
+}
+
+// AnnouncementStats is how far an announcement got: the members it was
+// sent to, and how many of its notifications were delivered and read
+type AnnouncementStats struct {
+	AnnouncementID string  `json:"announcementId"`
+	Recipients     int     `json:"recipients"`
+	Muted          int     `json:"muted"`
+	Notified       int64   `json:"notified"`
+	Delivered      int64   `json:"delivered"`
+	Read           int64   `json:"read"`
+	ReadRate       float64 `json:"readRate"` // read out of notified
 }
 
 // Activity model
@@ -159,3 +306,97 @@ type ExportJob struct {
 	UpdatedAt time.Time          `json:"updatedAt" bson:"updatedAt"`
 	ExpiresAt time.Time          `json:"expiresAt" bson:"expiresAt"`
 }
+
+// Circle merge job statuses
+const (
+	CircleMergeStatusRunning   = "running"
+	CircleMergeStatusCompleted = "completed"
+	CircleMergeStatusFailed    = "failed"
+)
+
+// Circle merge phases, in the order they run
+const (
+	CircleMergePhaseMembers         = "members"
+	CircleMergePhasePlaces          = "places"
+	CircleMergePhaseMessages        = "messages"
+	CircleMergePhaseAutomationRules = "automation_rules"
+	CircleMergePhaseCollections     = "collections"
+	CircleMergePhaseArchive         = "archive"
+	CircleMergePhaseDone            = "done"
+)
+
+var CircleMergePhases = []string{
+	CircleMergePhaseMembers,
+	CircleMergePhasePlaces,
+	CircleMergePhaseMessages,
+	CircleMergePhaseAutomationRules,
+	CircleMergePhaseCollections,
+	CircleMergePhaseArchive,
+}
+
+// Archived circles are deleted this long after a merge
+const CircleMergeArchiveRetention = 30 * 24 * time.Hour
+
+type MergeCircleRequest struct {
+	SourceCircleID string `json:"sourceCircleId" validate:"required"`
+	Confirm        bool   `json:"confirm"`
+}
+
+// CircleMergeJob moves a circle's content into another circle. Each phase
+// can run again without duplicating anything, so a job whose instance died
+// is resumed from its phase.
+type CircleMergeJob struct {
+	ID             primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	SourceCircleID primitive.ObjectID `json:"sourceCircleId" bson:"sourceCircleId"`
+	TargetCircleID primitive.ObjectID `json:"targetCircleId" bson:"targetCircleId"`
+	RequestedBy    primitive.ObjectID `json:"requestedBy" bson:"requestedBy"`
+	Status         string             `json:"status" bson:"status"`
+	Phase          string             `json:"phase" bson:"phase"`
+	Progress       int                `json:"progress" bson:"progress"` // 0-100
+
+	// Fixed at start, so a resumed job detects duplicates the same way
+	DuplicateDistance float64 `json:"duplicateDistance" bson:"duplicateDistance"`
+
+	// Source places folded into target places, for repointing collections
+	PlaceMappings    []CircleMergePlaceMapping `json:"-" bson:"placeMappings"`
+	DividerMessageID *primitive.ObjectID       `json:"dividerMessageId,omitempty" bson:"dividerMessageId,omitempty"`
+
+	Report      CircleMergeReport `json:"report" bson:"report"`
+	Attempts    int               `json:"attempts" bson:"attempts"`
+	ErrorMsg    string            `json:"errorMsg,omitempty" bson:"errorMsg,omitempty"`
+	StartedAt   time.Time         `json:"startedAt" bson:"startedAt"`
+	HeartbeatAt time.Time         `json:"heartbeatAt" bson:"heartbeatAt"`
+	CompletedAt *time.Time        `json:"completedAt,omitempty" bson:"completedAt,omitempty"`
+}
+
+type CircleMergePlaceMapping struct {
+	DuplicateID primitive.ObjectID `bson:"duplicateId"`
+	CanonicalID primitive.ObjectID `bson:"canonicalId"`
+}
+
+// CircleMergeReport counts what a merge moved, what it skipped as already
+// in the target, and what it couldn't decide
+type CircleMergeReport struct {
+	Members         CircleMergeCounts     `json:"members" bson:"members"`
+	Places          CircleMergeCounts     `json:"places" bson:"places"`
+	Messages        CircleMergeCounts     `json:"messages" bson:"messages"`
+	AutomationRules CircleMergeCounts     `json:"automationRules" bson:"automationRules"`
+	Collections     CircleMergeCounts     `json:"collections" bson:"collections"`
+	Conflicts       []CircleMergeConflict `json:"conflicts" bson:"conflicts"`
+}
+
+type CircleMergeCounts struct {
+	Migrated          int64 `json:"migrated" bson:"migrated"`
+	DuplicatesSkipped int64 `json:"duplicatesSkipped" bson:"duplicatesSkipped"`
+	Promoted          int64 `json:"promoted,omitempty" bson:"promoted,omitempty"` // members made admin in the target
+}
+
+// CircleMergeConflict is an item the merge moved or left behind that needs
+// an admin to look at it
+type CircleMergeConflict struct {
+	Type      string `json:"type" bson:"type"` // member, place
+	ItemID    string `json:"itemId" bson:"itemId"`
+	Name      string `json:"name,omitempty" bson:"name,omitempty"`
+	RelatedID string `json:"relatedId,omitempty" bson:"relatedId,omitempty"` // the target item it clashes with
+	Reason    string `json:"reason" bson:"reason"`
+}
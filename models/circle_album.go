@@ -0,0 +1,91 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	AlbumStatusSuggested = "suggested"
+	AlbumStatusAccepted  = "accepted"
+	AlbumStatusDismissed = "dismissed"
+)
+
+// Photos shared in chat form a suggested album when enough of them, from
+// enough members, were taken during visits to the same circle place
+const (
+	AlbumMinPhotos       = 4
+	AlbumMinContributors = 2
+	AlbumClusterGap      = 3 * time.Hour // a longer pause between photos starts a new album
+)
+
+// CircleAlbum groups photos shared in a circle's chat during a visit to one
+// of its places. It only references the messages' media, it holds no copies.
+type CircleAlbum struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	CircleID  primitive.ObjectID `json:"circleId" bson:"circleId"`
+	PlaceID   primitive.ObjectID `json:"placeId" bson:"placeId"`
+	PlaceName string             `json:"placeName" bson:"placeName"`
+	Title     string             `json:"title" bson:"title"`
+	Status    string             `json:"status" bson:"status"`
+
+	// When the first and the last photo were taken
+	StartTime time.Time `json:"startTime" bson:"startTime"`
+	EndTime   time.Time `json:"endTime" bson:"endTime"`
+
+	CoverMediaID primitive.ObjectID `json:"coverMediaId,omitempty" bson:"coverMediaId,omitempty"`
+	CoverURL     string             `json:"coverUrl,omitempty" bson:"coverUrl,omitempty"`
+	PhotoCount   int                `json:"photoCount" bson:"photoCount"`
+	Contributors []AlbumContributor `json:"contributors" bson:"contributors"`
+
+	AcceptedBy  *primitive.ObjectID `json:"acceptedBy,omitempty" bson:"acceptedBy,omitempty"`
+	AcceptedAt  *time.Time          `json:"acceptedAt,omitempty" bson:"acceptedAt,omitempty"`
+	DismissedBy *primitive.ObjectID `json:"-" bson:"dismissedBy,omitempty"`
+	CreatedAt   time.Time           `json:"createdAt" bson:"createdAt"`
+	UpdatedAt   time.Time           `json:"updatedAt" bson:"updatedAt"`
+}
+
+// AlbumContributor is a member whose photos are in an album
+type AlbumContributor struct {
+	UserID     primitive.ObjectID `json:"userId" bson:"userId"`
+	PhotoCount int                `json:"photoCount" bson:"photoCount"`
+}
+
+// HasContributor reports whether the user has photos in the album
+func (a *CircleAlbum) HasContributor(userID primitive.ObjectID) bool {
+	for _, contributor := range a.Contributors {
+		if contributor.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// AlbumPhoto references a photo shared in the circle's chat from an album
+type AlbumPhoto struct {
+	ID           primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	AlbumID      primitive.ObjectID `json:"albumId" bson:"albumId"`
+	CircleID     primitive.ObjectID `json:"-" bson:"circleId"`
+	MessageID    primitive.ObjectID `json:"messageId" bson:"messageId"`
+	MediaID      primitive.ObjectID `json:"mediaId" bson:"mediaId"`
+	UserID       primitive.ObjectID `json:"userId" bson:"userId"`
+	URL          string             `json:"url" bson:"url"`
+	ThumbnailURL string             `json:"thumbnailUrl,omitempty" bson:"thumbnailUrl,omitempty"`
+	TakenAt      time.Time          `json:"takenAt" bson:"takenAt"`
+	AddedAt      time.Time          `json:"addedAt" bson:"addedAt"`
+}
+
+type AcceptAlbumRequest struct {
+	Title        string `json:"title" validate:"omitempty,max=100"`
+	CoverMediaID string `json:"coverMediaId"`
+}
+
+// AlbumDetailResponse is an album with a page of its photos
+type AlbumDetailResponse struct {
+	Album    *CircleAlbum `json:"album"`
+	Photos   []AlbumPhoto `json:"photos"`
+	Page     int          `json:"page"`
+	PageSize int          `json:"pageSize"`
+	HasNext  bool         `json:"hasNext"`
+}
@@ -0,0 +1,42 @@
+package models
+
+// Outcomes of a bulk membership entry
+const (
+	BulkMemberAdded   = "added"
+	BulkMemberInvited = "invited"
+	BulkMemberRemoved = "removed"
+	BulkMemberFailed  = "failed"
+)
+
+// BulkMemberEntry is a user to add to a circle, by ID or by email. Emails
+// without an account get a pending invitation.
+type BulkMemberEntry struct {
+	UserID string `json:"userId,omitempty"`
+	Email  string `json:"email,omitempty" validate:"omitempty,email"`
+	Role   string `json:"role,omitempty" validate:"omitempty,oneof=admin member"` // member by default
+}
+
+type BulkAddMembersRequest struct {
+	Members []BulkMemberEntry `json:"members" validate:"required,min=1,max=100,dive"`
+	Message string            `json:"message,omitempty" validate:"max=500"` // sent with invitations
+}
+
+type BulkRemoveMembersRequest struct {
+	UserIDs []string `json:"userIds" validate:"required,min=1,max=100"`
+}
+
+// BulkMemberResult is the outcome of one entry, in the order of the request
+type BulkMemberResult struct {
+	UserID       string `json:"userId,omitempty"`
+	Email        string `json:"email,omitempty"`
+	Role         string `json:"role,omitempty"`
+	Status       string `json:"status"` // added, invited, removed, failed
+	Error        string `json:"error,omitempty"`
+	InvitationID string `json:"invitationId,omitempty"`
+}
+
+type BulkMembershipResponse struct {
+	Results   []BulkMemberResult `json:"results"`
+	Succeeded int                `json:"succeeded"`
+	Failed    int                `json:"failed"`
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CircleMoment is a photo a member shares with a circle of what they are
+// seeing, pinned where they took it. Moments show in the circle's activity
+// feed; unlike messages they are anchored to a location and a place.
+type CircleMoment struct {
+	ID       primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	CircleID primitive.ObjectID `json:"circleId" bson:"circleId"`
+	UserID   primitive.ObjectID `json:"userId" bson:"userId"`
+	Caption  string             `json:"caption,omitempty" bson:"caption,omitempty"`
+	Media    CheckinMedia       `json:"media" bson:"media"`
+
+	// Where the moment was shared, exactly. Others see it at the author's
+	// sharing precision.
+	Latitude  float64 `json:"latitude" bson:"latitude"`
+	Longitude float64 `json:"longitude" bson:"longitude"`
+	Accuracy  float64 `json:"accuracy" bson:"accuracy"` // meters
+
+	// The circle place whose geofence the moment was shared in, if any
+	PlaceID   *primitive.ObjectID `json:"placeId,omitempty" bson:"placeId,omitempty"`
+	PlaceName string              `json:"placeName,omitempty" bson:"placeName,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+}
+
+type CreateMomentRequest struct {
+	MediaID   string  `json:"mediaId" validate:"required"` // an image uploaded through the media endpoint
+	Caption   string  `json:"caption,omitempty" validate:"max=280"`
+	Latitude  float64 `json:"latitude" validate:"required,gte=-90,lte=90"`
+	Longitude float64 `json:"longitude" validate:"required,gte=-180,lte=180"`
+	Accuracy  float64 `json:"accuracy,omitempty" validate:"gte=0"`
+
+	// A circle place the moment is at. Without one, the place whose
+	// geofence the location is in is matched.
+	PlaceID string `json:"placeId,omitempty"`
+}
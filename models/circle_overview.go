@@ -0,0 +1,72 @@
+package models
+
+import "time"
+
+// Parts of a circle overview a client can ask for with the fields
+// parameter. Without it every part is included.
+const (
+	CircleOverviewMembers     = "members"     // member count and avatars
+	CircleOverviewLastMessage = "lastMessage" // latest message preview
+	CircleOverviewUnread      = "unread"      // the caller's unread count
+	CircleOverviewRole        = "role"        // the caller's role
+	CircleOverviewState       = "state"       // whether the caller muted or paused the circle
+	CircleOverviewAlerts      = "alerts"      // active SOS and pending join requests
+)
+
+// CircleOverviewFields is every part of a circle overview
+var CircleOverviewFields = []string{
+	CircleOverviewMembers,
+	CircleOverviewLastMessage,
+	CircleOverviewUnread,
+	CircleOverviewRole,
+	CircleOverviewState,
+	CircleOverviewAlerts,
+}
+
+// Members shown with their avatar in a circle overview
+const CircleOverviewMaxAvatars = 6
+
+type CirclesOverviewRequest struct {
+	Fields string `form:"fields"` // comma-separated parts, all by default
+}
+
+// CircleOverview sums a circle up for the circles list, so clients don't
+// fetch its members, messages and alerts one by one. Parts that weren't
+// asked for are left out.
+type CircleOverview struct {
+	CircleID string `json:"circleId"`
+	Name     string `json:"name"`
+
+	MemberCount *int       `json:"memberCount,omitempty"`
+	Members     []UserInfo `json:"members,omitempty"` // up to CircleOverviewMaxAvatars
+
+	LastMessage *MessagePreview `json:"lastMessage,omitempty"`
+	UnreadCount *int64          `json:"unreadCount,omitempty"`
+
+	Role         string `json:"role,omitempty"` // admin, member
+	CustomRoleID string `json:"customRoleId,omitempty"`
+
+	// Muted while the caller's do not disturb covers the circle; paused
+	// while they don't share their location with it
+	Muted  *bool `json:"muted,omitempty"`
+	Paused *bool `json:"paused,omitempty"`
+
+	ActiveSOS *int64 `json:"activeSos,omitempty"`
+	// Only for members who can handle join requests
+	PendingJoinRequests *int64 `json:"pendingJoinRequests,omitempty"`
+}
+
+// MessagePreview is a short form of a message for lists
+type MessagePreview struct {
+	ID       string    `json:"id"`
+	SenderID string    `json:"senderId"`
+	Sender   string    `json:"sender,omitempty"` // first name
+	Type     string    `json:"type"`
+	Text     string    `json:"text,omitempty"` // truncated
+	SentAt   time.Time `json:"sentAt"`
+}
+
+type CirclesOverviewResponse struct {
+	Circles   []CircleOverview `json:"circles"`
+	Generated time.Time        `json:"generated"`
+}
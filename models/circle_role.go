@@ -0,0 +1,96 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Permissions a custom circle role can grant. Admins have all of them;
+// members without a custom role have none.
+const (
+	CirclePermissionInviteMembers       = "members.invite"       // invite people and handle join requests
+	CirclePermissionManageMembers       = "members.manage"       // remove members and change their permissions
+	CirclePermissionManageSettings      = "circle.settings"      // rename the circle and change its settings
+	CirclePermissionManagePlaces        = "places.manage"        // add, edit and delete the circle's places
+	CirclePermissionManageAnnouncements = "announcements.manage" // send and manage announcements
+	CirclePermissionDeleteMessages      = "messages.delete"      // delete other members' messages
+)
+
+// CirclePermissionInfo describes a permission of the catalog to clients
+type CirclePermissionInfo struct {
+	Key         string `json:"key"`
+	Description string `json:"description"`
+}
+
+// CirclePermissionCatalog is every permission custom roles can grant
+var CirclePermissionCatalog = []CirclePermissionInfo{
+	{Key: CirclePermissionInviteMembers, Description: "Invite people and approve or decline join requests"},
+	{Key: CirclePermissionManageMembers, Description: "Remove members and change their permissions"},
+	{Key: CirclePermissionManageSettings, Description: "Rename the circle and change its settings"},
+	{Key: CirclePermissionManagePlaces, Description: "Add, edit and delete the circle's places"},
+	{Key: CirclePermissionManageAnnouncements, Description: "Send and manage announcements"},
+	{Key: CirclePermissionDeleteMessages, Description: "Delete other members' messages"},
+}
+
+// IsCirclePermission reports whether the permission is in the catalog
+func IsCirclePermission(permission string) bool {
+	for _, info := range CirclePermissionCatalog {
+		if info.Key == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Most custom roles a circle can define
+const MaxCircleRoles = 20
+
+// CircleRole is a named set of permissions the circle's owner defined and
+// assigns to members. It only adds to what members can do; admins already
+// have every permission.
+type CircleRole struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	CircleID    primitive.ObjectID `json:"circleId" bson:"circleId"`
+	Name        string             `json:"name" bson:"name"`
+	Description string             `json:"description,omitempty" bson:"description,omitempty"`
+	Permissions []string           `json:"permissions" bson:"permissions"`
+	CreatedBy   primitive.ObjectID `json:"createdBy" bson:"createdBy"`
+	CreatedAt   time.Time          `json:"createdAt" bson:"createdAt"`
+	UpdatedAt   time.Time          `json:"updatedAt" bson:"updatedAt"`
+}
+
+// Grants reports whether the role grants the permission
+func (r *CircleRole) Grants(permission string) bool {
+	for _, granted := range r.Permissions {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+type CreateCircleRoleRequest struct {
+	Name        string   `json:"name" validate:"required,min=2,max=30"`
+	Description string   `json:"description,omitempty" validate:"max=200"`
+	Permissions []string `json:"permissions" validate:"required,min=1"`
+}
+
+type UpdateCircleRoleRequest struct {
+	Name        *string   `json:"name,omitempty" validate:"omitempty,min=2,max=30"`
+	Description *string   `json:"description,omitempty" validate:"omitempty,max=200"`
+	Permissions *[]string `json:"permissions,omitempty" validate:"omitempty,min=1"`
+}
+
+// AssignCircleRoleRequest assigns a custom role to a member. An empty role
+// ID takes their custom role away.
+type AssignCircleRoleRequest struct {
+	RoleID string `json:"roleId" validate:"omitempty,len=24,hexadecimal"`
+}
+
+// CircleRolesResponse lists the circle's custom roles with the permissions
+// they can grant
+type CircleRolesResponse struct {
+	Roles       []CircleRole           `json:"roles"`
+	Permissions []CirclePermissionInfo `json:"permissions"`
+}
@@ -0,0 +1,35 @@
+package models
+
+// App URL scheme of deep links; https links on the API's host resolve too
+const DeepLinkScheme = "ftrack"
+
+// Entities a deep link can point at
+const (
+	DeepLinkCircle  = "circle"
+	DeepLinkPlace   = "place"
+	DeepLinkMessage = "message"
+)
+
+// Why a deep link's target can't be opened
+const (
+	DeepLinkNotFound     = "not_found"
+	DeepLinkAccessDenied = "access_denied"
+)
+
+type ResolveDeepLinkRequest struct {
+	URL string `form:"url" validate:"required,max=2048"`
+}
+
+// DeepLinkTarget is what a deep link points at, for clients to pick the
+// screen to open. IDs beyond the linked one are only filled in when the user
+// has access.
+type DeepLinkTarget struct {
+	Type      string `json:"type"` // circle, place, message
+	CircleID  string `json:"circleId,omitempty"`
+	PlaceID   string `json:"placeId,omitempty"`
+	MessageID string `json:"messageId,omitempty"`
+	Path      string `json:"path"` // canonical, e.g. /circles/{id}/messages/{id}
+
+	Accessible bool   `json:"accessible"`
+	Reason     string `json:"reason,omitempty"` // not_found, access_denied
+}
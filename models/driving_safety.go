@@ -0,0 +1,102 @@
+package models
+
+import "time"
+
+// Safety events the driver's phone reports during a drive. They're stored
+// as driving events of the trip.
+const (
+	SafetyEventPhoneUse          = "phone_use"   // screen on while moving
+	SafetyEventRapidAcceleration = "rapid_accel" // hard on the accelerator
+	SafetyEventSpeeding          = "speeding"    // above the road's speed limit
+)
+
+const NotificationTypeDrivingSafetyReport = "driving_safety_report"
+
+// Most safety events accepted with one location update
+const MaxSafetyEventsPerUpdate = 50
+
+// TripSafetyEventReport is a safety event the client detected, posted with
+// a location update during a trip. The client knows the road's speed limit,
+// so speeding is only counted when it sends one below the speed.
+type TripSafetyEventReport struct {
+	Type         string    `json:"type" validate:"required,oneof=phone_use rapid_accel speeding"`
+	OccurredAt   time.Time `json:"occurredAt"`
+	Duration     int       `json:"duration,omitempty" validate:"min=0,max=86400"` // seconds the screen was on
+	Acceleration float64   `json:"acceleration,omitempty" validate:"min=0"`       // m/s²
+	Speed        float64   `json:"speed,omitempty" validate:"min=0"`              // m/s
+	SpeedLimit   float64   `json:"speedLimit,omitempty" validate:"min=0"`         // m/s
+}
+
+// TripSafetySummary adds up a trip's safety events when it ends. Score
+// starts at 100 and loses points for each event, more for longer phone use
+// and for speeding further over the limit.
+type TripSafetySummary struct {
+	PhoneUseEvents     int       `json:"phoneUseEvents" bson:"phoneUseEvents"`
+	PhoneUseSeconds    int       `json:"phoneUseSeconds" bson:"phoneUseSeconds"`
+	RapidAccelerations int       `json:"rapidAccelerations" bson:"rapidAccelerations"`
+	SpeedingEvents     int       `json:"speedingEvents" bson:"speedingEvents"`
+	TopSpeed           float64   `json:"topSpeed" bson:"topSpeed"` // m/s
+	Score              int       `json:"score" bson:"score"`       // 0-100
+	ComputedAt         time.Time `json:"computedAt" bson:"computedAt"`
+}
+
+// DrivingSafetySharing is a member's choice to share their trip safety
+// summaries with a circle. It's off unless the member turns it on, and
+// only trips that ended after SharedSince are shown, so turning it off
+// hides the earlier ones for good. The member always sees their own.
+type DrivingSafetySharing struct {
+	Enabled     bool       `json:"enabled" bson:"enabled"`
+	SharedSince *time.Time `json:"sharedSince,omitempty" bson:"sharedSince,omitempty"`
+
+	// Members told when a weekly report is ready
+	NotifyMemberIDs []string `json:"notifyMemberIds,omitempty" bson:"notifyMemberIds,omitempty"`
+
+	// Start of the last week reported to them
+	LastReportedWeek *time.Time `json:"-" bson:"lastReportedWeek,omitempty"`
+
+	UpdatedAt *time.Time `json:"updatedAt,omitempty" bson:"updatedAt,omitempty"`
+}
+
+type UpdateDrivingSafetySharingRequest struct {
+	Enabled         bool     `json:"enabled"`
+	NotifyMemberIDs []string `json:"notifyMemberIds,omitempty" validate:"omitempty,max=20,dive,len=24,hexadecimal"`
+}
+
+type WeeklySafetyReportRequest struct {
+	WeekOf string `form:"weekOf"` // YYYY-MM-DD, any day of the week; the last full week by default
+}
+
+// WeeklySafetyReport is a driver's trips from Monday to Monday in their
+// timezone
+type WeeklySafetyReport struct {
+	UserID    string    `json:"userId"`
+	CircleID  string    `json:"circleId,omitempty"`
+	WeekStart time.Time `json:"weekStart"`
+	WeekEnd   time.Time `json:"weekEnd"`
+
+	Trips         int     `json:"trips"`
+	TotalDistance float64 `json:"totalDistance"` // meters
+	TotalTime     int64   `json:"totalTime"`     // seconds
+
+	// Mean of the trip scores weighted by their driving time, unset
+	// without trips
+	Score *int `json:"score,omitempty"`
+
+	PhoneUseEvents     int     `json:"phoneUseEvents"`
+	PhoneUseSeconds    int     `json:"phoneUseSeconds"`
+	RapidAccelerations int     `json:"rapidAccelerations"`
+	SpeedingEvents     int     `json:"speedingEvents"`
+	TopSpeed           float64 `json:"topSpeed"` // m/s
+
+	TripSummaries []TripSafetyEntry `json:"tripSummaries"`
+	Generated     time.Time         `json:"generated"`
+}
+
+type TripSafetyEntry struct {
+	TripID    string            `json:"tripId"`
+	StartTime time.Time         `json:"startTime"`
+	EndTime   time.Time         `json:"endTime"`
+	Distance  float64           `json:"distance"` // meters
+	Duration  int64             `json:"duration"` // seconds
+	Safety    TripSafetySummary `json:"safety"`
+}
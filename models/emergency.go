@@ -84,12 +84,25 @@ type EmergencyContact struct {
 	Phone        string             `json:"phone" bson:"phone"`
 	Email        string             `json:"email,omitempty" bson:"email,omitempty"`
 	Relationship string             `json:"relationship" bson:"relationship"`
+	Priority     int                `json:"priority" bson:"priority"` // 1 = contacted first
 	NotifiedAt   time.Time          `json:"notifiedAt" bson:"notifiedAt"`
 	NotifyMethod string             `json:"notifyMethod" bson:"notifyMethod"` // sms, call, push, email
 	Acknowledged bool               `json:"acknowledged" bson:"acknowledged"`
 	AckedAt      time.Time          `json:"ackedAt,omitempty" bson:"ackedAt,omitempty"`
 	Response     string             `json:"response,omitempty" bson:"response,omitempty"`
 	UpdatedAt    time.Time          `json:"updatedAt" bson:"updatedAt"`
+
+	// Verification
+	VerificationStatus string    `json:"verificationStatus" bson:"verificationStatus"` // pending, verified, declined
+	VerificationToken  string    `json:"-" bson:"verificationToken,omitempty"`
+	VerificationSentAt time.Time `json:"verificationSentAt,omitempty" bson:"verificationSentAt,omitempty"`
+	VerifiedAt         time.Time `json:"verifiedAt,omitempty" bson:"verifiedAt,omitempty"`
+	DeclinedAt         time.Time `json:"declinedAt,omitempty" bson:"declinedAt,omitempty"`
+}
+
+// IsVerified reports whether the contact confirmed and may be used in escalations
+func (ec EmergencyContact) IsVerified() bool {
+	return ec.VerificationStatus == ContactVerificationVerified
 }
 
 type EmergencyMedia struct {
@@ -157,6 +170,15 @@ const (
 	EmergencyStatusDismissed  = "dismissed"
 )
 
+// Emergency Contact Verification Constants
+const (
+	ContactVerificationPending  = "pending"
+	ContactVerificationVerified = "verified"
+	ContactVerificationDeclined = "declined"
+
+	MaxEmergencyContacts = 5
+)
+
 // =================== REQUEST/RESPONSE MODELS ===================
 
 // Basic Emergency Requests
@@ -264,11 +286,13 @@ type CalibrateCrashRequest struct {
 // Emergency Contacts
 type AddEmergencyContactRequest struct {
 	Name          string   `json:"name" validate:"required"`
-	Phone         string   `json:"phone" validate:"required"`
+	Phone         string   `json:"phone,omitempty" validate:"required_without=Email"`
 	Email         string   `json:"email,omitempty" validate:"omitempty,email"`
 	Relationship  string   `json:"relationship" validate:"required"`
-	Priority      int      `json:"priority"`
+	Priority      int      `json:"priority" validate:"omitempty,min=1,max=5"`
 	NotifyMethods []string `json:"notifyMethods,omitempty"`
+	IPAddress     string   `json:"-"`
+	UserAgent     string   `json:"-"`
 }
 
 type UpdateEmergencyContactRequest struct {
@@ -276,8 +300,15 @@ type UpdateEmergencyContactRequest struct {
 	Phone         string   `json:"phone,omitempty"`
 	Email         string   `json:"email,omitempty" validate:"omitempty,email"`
 	Relationship  string   `json:"relationship,omitempty"`
-	Priority      int      `json:"priority,omitempty"`
+	Priority      int      `json:"priority,omitempty" validate:"omitempty,min=1,max=5"`
 	NotifyMethods []string `json:"notifyMethods,omitempty"`
+	IPAddress     string   `json:"-"`
+	UserAgent     string   `json:"-"`
+}
+
+type EmergencyContactResponseRequest struct {
+	Token  string `form:"token" json:"token" validate:"required"`
+	Action string `form:"action" json:"action" validate:"required,oneof=confirm decline"`
 }
 
 type VerifyContactRequest struct {
@@ -0,0 +1,91 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// AppVersion is the server release stamped into exports
+const AppVersion = "1.0.0"
+
+// Export file schema versions. Version 1 files were written before exports
+// were stamped: bare JSON arrays and CSVs without a stamp line. Bump the
+// latest version whenever exported records change shape, and register a
+// migration from the previous one in services/export_schema.go.
+const (
+	ExportSchemaVersion1      = 1
+	ExportSchemaVersion2      = 2
+	ExportSchemaVersionLatest = ExportSchemaVersion2
+)
+
+// ExportStampPrefix starts the stamp line of CSV and text exports
+const ExportStampPrefix = "# ftrack-export"
+
+// ExportHeader identifies an export file. JSON exports are an object with
+// these fields and the exported records under "records".
+type ExportHeader struct {
+	SchemaVersion int       `json:"schemaVersion"`
+	AppVersion    string    `json:"appVersion"`
+	Type          string    `json:"type"`
+	ExportedAt    time.Time `json:"exportedAt"`
+
+	// Set on exports run as jobs, so a leaked file can be traced
+	Watermark *ExportWatermark `json:"watermark,omitempty"`
+}
+
+// ExportWatermark names the export a file came from and who asked for it
+type ExportWatermark struct {
+	ExportID    string    `json:"exportId"`
+	RequestedBy string    `json:"requestedBy"` // user ID
+	RequestedAt time.Time `json:"requestedAt"`
+}
+
+// NewExportHeader stamps an export of the type with the current versions
+func NewExportHeader(exportType string) ExportHeader {
+	return ExportHeader{
+		SchemaVersion: ExportSchemaVersionLatest,
+		AppVersion:    AppVersion,
+		Type:          exportType,
+		ExportedAt:    time.Now(),
+	}
+}
+
+// Stamp is the header as the first line of a CSV or text export
+func (h ExportHeader) Stamp() string {
+	stamp := fmt.Sprintf("%s schemaVersion=%d appVersion=%s type=%s exportedAt=%s",
+		ExportStampPrefix, h.SchemaVersion, h.AppVersion, h.Type, h.ExportedAt.UTC().Format(time.RFC3339))
+	if w := h.Watermark; w != nil {
+		stamp += fmt.Sprintf(" exportId=%s requestedBy=%s requestedAt=%s",
+			w.ExportID, w.RequestedBy, w.RequestedAt.UTC().Format(time.RFC3339))
+	}
+	return stamp
+}
+
+// ExportManifest lists what a finished export contains. It is written next
+// to the export's files and can be downloaded on its own.
+type ExportManifest struct {
+	ExportHeader
+	ExportID    string               `json:"exportId"`
+	Format      string               `json:"format"`
+	RecordCount int                  `json:"recordCount"`
+	Files       []ExportManifestFile `json:"files"`
+
+	// The search the export was made from, if it holds search results
+	SearchCriteria *MessageSearchCriteria `json:"searchCriteria,omitempty"`
+}
+
+type ExportManifestFile struct {
+	Name string `json:"name"`
+	Part int    `json:"part"`
+	Size int64  `json:"size"`
+}
+
+// ImportResult reports how an import went, record by record
+type ImportResult struct {
+	SchemaVersion int      `json:"schemaVersion"` // of the imported file
+	Migrated      bool     `json:"migrated"`      // the file was upgraded from an older schema
+	Total         int      `json:"total"`
+	Imported      int      `json:"imported"`
+	Failed        int      `json:"failed"`
+	Errors        []string `json:"errors,omitempty"`
+}
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Who an export policy lets export the circle's shared data
+const (
+	ExportPolicyOwner   = "owner"   // the circle's owner only
+	ExportPolicyAdmins  = "admins"  // circle admins
+	ExportPolicyMembers = "members" // any member
+)
+
+// Circles without a policy keep letting any member export, as before
+// policies existed
+const DefaultExportPolicy = ExportPolicyMembers
+
+// ExportPolicy controls who may export circle data that includes other
+// members' content. Others ask for permission with an
+// ExportPermissionRequest.
+type ExportPolicy struct {
+	Allow string `json:"allow" bson:"allow" validate:"required,oneof=owner admins members"`
+
+	UpdatedBy primitive.ObjectID `json:"updatedBy,omitempty" bson:"updatedBy,omitempty"`
+	UpdatedAt time.Time          `json:"updatedAt,omitempty" bson:"updatedAt,omitempty"`
+}
+
+// Export permission request statuses
+const (
+	ExportRequestPending  = "pending"
+	ExportRequestApproved = "approved"
+	ExportRequestDenied   = "denied"
+	ExportRequestUsed     = "used"
+)
+
+// An approved request lets the member start one export within this long
+const ExportGrantDuration = 7 * 24 * time.Hour
+
+// ExportPermissionRequest is a member asking to export a circle the policy
+// doesn't let them export. Once approved it is used up by their next
+// export of the circle.
+type ExportPermissionRequest struct {
+	ID       primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	CircleID primitive.ObjectID `json:"circleId" bson:"circleId"`
+	UserID   primitive.ObjectID `json:"userId" bson:"userId"`
+	Reason   string             `json:"reason,omitempty" bson:"reason,omitempty"`
+	Status   string             `json:"status" bson:"status"`
+
+	ReviewedBy *primitive.ObjectID `json:"reviewedBy,omitempty" bson:"reviewedBy,omitempty"`
+	ReviewedAt *time.Time          `json:"reviewedAt,omitempty" bson:"reviewedAt,omitempty"`
+	ExpiresAt  *time.Time          `json:"expiresAt,omitempty" bson:"expiresAt,omitempty"` // of an approval
+
+	// The export that used the approval
+	ExportID *primitive.ObjectID `json:"exportId,omitempty" bson:"exportId,omitempty"`
+	UsedAt   *time.Time          `json:"usedAt,omitempty" bson:"usedAt,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+type CreateExportRequestRequest struct {
+	Reason string `json:"reason,omitempty" validate:"max=500"`
+}
+
+type ReviewExportRequestRequest struct {
+	Approve bool `json:"approve"`
+}
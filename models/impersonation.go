@@ -0,0 +1,122 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Impersonation session statuses
+const (
+	ImpersonationStatusPending  = "pending"  // waiting for consent
+	ImpersonationStatusApproved = "approved" // the admin may start it
+	ImpersonationStatusActive   = "active"   // a token was issued
+	ImpersonationStatusDenied   = "denied"
+	ImpersonationStatusEnded    = "ended"
+	ImpersonationStatusExpired  = "expired" // never approved or started in time
+)
+
+// How consent to an impersonation was given
+const (
+	// The user approved the request in the app
+	ImpersonationConsentUser = "user"
+	// Support obtained consent outside the app, and a second admin
+	// approved on the strength of it
+	ImpersonationConsentDocumented = "documented"
+)
+
+const (
+	// How long an impersonation token is valid once issued
+	ImpersonationSessionTTL = 30 * time.Minute
+	// How long a request waits for consent, and then to be started
+	ImpersonationRequestTTL = 30 * time.Minute
+)
+
+const (
+	NotificationTypeImpersonationRequest = "impersonation_request"
+	NotificationTypeImpersonationSummary = "impersonation_summary"
+)
+
+// Audit log events of impersonation, recorded on the impersonated user
+const (
+	AuditEventImpersonationRequested = "impersonation_requested"
+	AuditEventImpersonationApproved  = "impersonation_approved"
+	AuditEventImpersonationDenied    = "impersonation_denied"
+	AuditEventImpersonationStarted   = "impersonation_started"
+	AuditEventImpersonationEnded     = "impersonation_ended"
+	AuditEventImpersonatedRequest    = "impersonated_request"
+)
+
+// ImpersonationSession lets a support admin see the app as a user does.
+// Requests made with its token are read-only unless writes were approved,
+// and each one is recorded in the user's audit log.
+type ImpersonationSession struct {
+	ID      primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	AdminID primitive.ObjectID `json:"adminId" bson:"adminId"`
+	UserID  primitive.ObjectID `json:"userId" bson:"userId"`
+	Reason  string             `json:"reason" bson:"reason"`
+
+	// Writes were asked for, and whether they were approved along with the
+	// session
+	WritesRequested bool `json:"writesRequested" bson:"writesRequested"`
+	AllowWrites     bool `json:"allowWrites" bson:"allowWrites"`
+
+	ConsentMethod    string              `json:"consentMethod" bson:"consentMethod"`
+	ConsentReference string              `json:"consentReference,omitempty" bson:"consentReference,omitempty"` // where documented consent is kept
+	ApprovedBy       *primitive.ObjectID `json:"approvedBy,omitempty" bson:"approvedBy,omitempty"`             // the user, or the second admin
+
+	Status        string     `json:"status" bson:"status"`
+	Requests      int64      `json:"requests" bson:"requests"` // made with the token
+	RequestedAt   time.Time  `json:"requestedAt" bson:"requestedAt"`
+	ApprovedAt    *time.Time `json:"approvedAt,omitempty" bson:"approvedAt,omitempty"`
+	StartedAt     *time.Time `json:"startedAt,omitempty" bson:"startedAt,omitempty"`
+	ExpiresAt     time.Time  `json:"expiresAt" bson:"expiresAt"` // of the request, then of the token
+	EndedAt       *time.Time `json:"endedAt,omitempty" bson:"endedAt,omitempty"`
+	SummarySentAt *time.Time `json:"summarySentAt,omitempty" bson:"summarySentAt,omitempty"`
+}
+
+// IsLive reports whether the session's token may be used
+func (s *ImpersonationSession) IsLive(now time.Time) bool {
+	return s.Status == ImpersonationStatusActive && now.Before(s.ExpiresAt)
+}
+
+type CreateImpersonationRequest struct {
+	UserID           string `json:"userId" validate:"required"`
+	Reason           string `json:"reason" validate:"required,min=10,max=500"`
+	AllowWrites      bool   `json:"allowWrites"`
+	ConsentMethod    string `json:"consentMethod" validate:"required,oneof=user documented"`
+	ConsentReference string `json:"consentReference,omitempty" validate:"omitempty,max=200"`
+}
+
+// ApproveImpersonationRequest approves a session. Writes are only allowed
+// if they were requested and are approved here too.
+type ApproveImpersonationRequest struct {
+	AllowWrites bool `json:"allowWrites"`
+}
+
+// ImpersonationToken is handed to the admin when a session starts
+type ImpersonationToken struct {
+	Session     *ImpersonationSession `json:"session"`
+	AccessToken string                `json:"accessToken"`
+	TokenType   string                `json:"tokenType"`
+	ExpiresIn   int64                 `json:"expiresIn"`
+	ExpiresAt   time.Time             `json:"expiresAt"`
+}
+
+// ImpersonationAccess counts the requests made to one route during a
+// session
+type ImpersonationAccess struct {
+	Method string `json:"method" bson:"method"`
+	Route  string `json:"route" bson:"route"`
+	Count  int64  `json:"count" bson:"count"`
+}
+
+// ImpersonatedRequest is an API request made with an impersonation token
+type ImpersonatedRequest struct {
+	Method    string
+	Route     string // the route pattern, e.g. /api/v1/places/:placeId
+	Path      string
+	Status    int
+	IPAddress string
+	UserAgent string
+}
@@ -17,6 +17,9 @@ type Location struct {
 	Accuracy  float64 `json:"accuracy" bson:"accuracy"` // GPS accuracy in meters
 	Altitude  float64 `json:"altitude" bson:"altitude"` // Altitude in meters
 
+	// GeoJSON copy of the coordinates, for the geo index
+	Point *GeoPoint `json:"-" bson:"point,omitempty"`
+
 	// Movement Data
 	Speed        float64 `json:"speed" bson:"speed"`               // Speed in m/s
 	Bearing      float64 `json:"bearing" bson:"bearing"`           // Direction in degrees (0-360)
@@ -47,6 +50,9 @@ type Location struct {
 	// Weather Information (optional)
 	Weather WeatherInfo `json:"weather,omitempty" bson:"weather,omitempty"`
 
+	// Safety events detected since the last update, during a trip
+	SafetyEvents []TripSafetyEventReport `json:"safetyEvents,omitempty" bson:"-"`
+
 	// Privacy & Sharing
 	Visibility string   `json:"visibility" bson:"visibility"` // public, circles, private
 	SharedWith []string `json:"sharedWith" bson:"sharedWith"` // Circle IDs
@@ -63,12 +69,43 @@ type Location struct {
 	IsFiltered   bool    `json:"isFiltered" bson:"isFiltered"` // Passed quality filters
 	FilterReason string  `json:"filterReason,omitempty" bson:"filterReason,omitempty"`
 
+	// Reported by the device, for the spoofing heuristics
+	IsMock  bool             `json:"isMock" bson:"isMock"` // from a mock location provider
+	Sensors *LocationSensors `json:"sensors,omitempty" bson:"sensors,omitempty"`
+
 	// Metadata
 	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"`
 	ExpiresAt time.Time `json:"expiresAt,omitempty" bson:"expiresAt,omitempty"` // Auto-cleanup
 }
 
+// RecordedAt is when the location was taken: the device time, unless it is
+// missing or ahead of the server
+func (l *Location) RecordedAt() time.Time {
+	if l.DeviceTime.IsZero() || l.DeviceTime.After(l.ServerTime) {
+		return l.ServerTime
+	}
+	return l.DeviceTime
+}
+
+// GeoPoint is a GeoJSON point
+type GeoPoint struct {
+	Type        string    `json:"type" bson:"type"`
+	Coordinates []float64 `json:"coordinates" bson:"coordinates"` // longitude, latitude
+}
+
+func NewGeoPoint(latitude, longitude float64) *GeoPoint {
+	return &GeoPoint{Type: "Point", Coordinates: []float64{longitude, latitude}}
+}
+
+// LatestLocation is the most recent location of a user, kept up to date on
+// every update so current locations are read without scanning history
+type LatestLocation struct {
+	UserID     primitive.ObjectID `json:"userId" bson:"_id"`
+	Location   Location           `json:"location" bson:"location"`
+	RecordedAt time.Time          `json:"recordedAt" bson:"recordedAt"`
+}
+
 type WeatherInfo struct {
 	Temperature   float64 `json:"temperature" bson:"temperature"`     // Celsius
 	Humidity      int     `json:"humidity" bson:"humidity"`           // Percentage
@@ -159,6 +196,20 @@ type LocationSharing struct {
 
 	// Schedule-based sharing
 	Schedule LocationSchedule `json:"schedule" bson:"schedule"`
+
+	// Other members only see this many hours of history, 0 for all of it.
+	// The member always sees their own.
+	HistoryWindowHours int `json:"historyWindowHours,omitempty" bson:"historyWindowHours,omitempty" validate:"min=0,max=720"`
+}
+
+// HistoryVisibleSince is where the history other members may see starts,
+// or nil when they may see all of it
+func (s LocationSharing) HistoryVisibleSince(now time.Time) *time.Time {
+	if s.HistoryWindowHours <= 0 {
+		return nil
+	}
+	since := now.Add(-time.Duration(s.HistoryWindowHours) * time.Hour)
+	return &since
 }
 
 type LocationSchedule struct {
@@ -191,6 +242,9 @@ type LocationUpdateRequest struct {
 	Source       string  `json:"source"`
 	DeviceTime   string  `json:"deviceTime"` // RFC3339 format
 	Timezone     string  `json:"timezone"`
+
+	IsMock  bool             `json:"isMock"` // from a mock location provider
+	Sensors *LocationSensors `json:"sensors,omitempty"`
 }
 
 type LocationHistoryRequest struct {
@@ -394,6 +448,56 @@ type NearbyUser struct {
 	IsOnline       bool      `json:"isOnline"`
 }
 
+// CircleLocationSnapshot is the latest shared location of every member of a
+// circle, for loading the map before live updates arrive
+type CircleLocationSnapshot struct {
+	CircleID     string                 `json:"circleId"`
+	Members      []MemberLocationStatus `json:"members"`
+	StaleAfter   int                    `json:"staleAfter"`   // seconds
+	OfflineAfter int                    `json:"offlineAfter"` // seconds
+	Generated    time.Time              `json:"generated"`
+}
+
+// MemberLocationStatus is a member's latest location as the requester may
+// see it. Location and battery are left out while sharing is paused.
+type MemberLocationStatus struct {
+	UserID         string          `json:"userId"`
+	FirstName      string          `json:"firstName"`
+	LastName       string          `json:"lastName"`
+	ProfilePicture string          `json:"profilePicture"`
+	Status         string          `json:"status"` // sharing, paused, unavailable
+	Location       *SharedLocation `json:"location,omitempty"`
+	BatteryLevel   *int            `json:"batteryLevel,omitempty"`
+	IsCharging     *bool           `json:"isCharging,omitempty"`
+	LastUpdated    *time.Time      `json:"lastUpdated,omitempty"`
+	IsStale        bool            `json:"isStale"`
+	Staleness      string          `json:"staleness,omitempty"` // fresh, stale, offline; while sharing
+	CurrentVisit   *MemberVisit    `json:"currentVisit,omitempty"`
+}
+
+// MemberVisit is the place a member is at right now, for members who share
+// their places
+type MemberVisit struct {
+	VisitID     string    `json:"visitId"`
+	PlaceID     string    `json:"placeId"`
+	PlaceName   string    `json:"placeName"`
+	ArrivalTime time.Time `json:"arrivalTime"`
+	Duration    int64     `json:"duration"` // seconds so far
+}
+
+// SharedLocation is a location fuzzed to the member's sharing precision
+type SharedLocation struct {
+	Latitude     float64 `json:"latitude"`
+	Longitude    float64 `json:"longitude"`
+	Accuracy     float64 `json:"accuracy"` // meters
+	Precision    string  `json:"precision"`
+	Address      string  `json:"address,omitempty"`
+	PlaceName    string  `json:"placeName,omitempty"`
+	IsDriving    bool    `json:"isDriving"`
+	Speed        float64 `json:"speed,omitempty"`
+	MovementType string  `json:"movementType,omitempty"`
+}
+
 // ==================== TRIP MANAGEMENT ====================
 
 type Trip struct {
@@ -408,7 +512,9 @@ type Trip struct {
 	EndTime        *time.Time         `json:"endTime,omitempty" bson:"endTime,omitempty"`
 	IsActive       bool               `json:"isActive" bson:"isActive"`
 	Stats          *TripStats         `json:"stats,omitempty" bson:"stats,omitempty"`
+	Safety         *TripSafetySummary `json:"safety,omitempty" bson:"safety,omitempty"`
 	Route          []Location         `json:"route,omitempty" bson:"route,omitempty"`
+	Tags           []string           `json:"tags,omitempty" bson:"tags,omitempty"` // from_home, to_home
 	CreatedAt      time.Time          `json:"createdAt" bson:"createdAt"`
 	UpdatedAt      time.Time          `json:"updatedAt" bson:"updatedAt"`
 }
@@ -442,6 +548,9 @@ type TripUpdate struct {
 	EndTime        *time.Time `json:"endTime,omitempty"`
 	IsActive       *bool      `json:"isActive,omitempty"`
 	Stats          *TripStats `json:"stats,omitempty"`
+	Tags           []string   `json:"tags,omitempty"`
+
+	Safety *TripSafetySummary `json:"-"` // set when the trip ends
 }
 
 type StartTripRequest struct {
@@ -507,6 +616,7 @@ type DrivingEvent struct {
 	ID        primitive.ObjectID     `json:"id" bson:"_id,omitempty"`
 	UserID    string                 `json:"userId" bson:"userId"`
 	SessionID string                 `json:"sessionId,omitempty" bson:"sessionId,omitempty"`
+	TripID    string                 `json:"tripId,omitempty" bson:"tripId,omitempty"`
 	EventType string                 `json:"eventType" bson:"eventType"` // hard_brake, rapid_accel, sharp_turn, speeding, phone_use
 	Severity  string                 `json:"severity" bson:"severity"`   // low, medium, high
 	Location  Location               `json:"location" bson:"location"`
@@ -629,6 +739,41 @@ type HeatmapPoint struct {
 	Count     int     `json:"count"`
 }
 
+// MemberVisitHeatmapRequest selects the period and zoom of a member heatmap
+type MemberVisitHeatmapRequest struct {
+	Period    string `form:"period"`    // day, week, month, custom
+	StartDate string `form:"startDate"` // YYYY-MM-DD, custom period only
+	EndDate   string `form:"endDate"`   // YYYY-MM-DD, custom period only
+	Zoom      int    `form:"zoom"`      // map zoom level, 1-18
+}
+
+// MemberVisitHeatmap is a circle member's locations and place visits
+// clustered into grid cells sized for the map zoom
+type MemberVisitHeatmap struct {
+	CircleID       string        `json:"circleId"`
+	UserID         string        `json:"userId"`
+	Period         string        `json:"period"`
+	StartDate      time.Time     `json:"startDate"`
+	EndDate        time.Time     `json:"endDate"`
+	Zoom           int           `json:"zoom"`
+	CellSize       float64       `json:"cellSize"`  // degrees
+	Precision      string        `json:"precision"` // member's sharing precision
+	Cells          []HeatmapCell `json:"cells"`
+	Bounds         GeoBounds     `json:"bounds"`
+	TotalLocations int           `json:"totalLocations"`
+	TotalVisits    int           `json:"totalVisits"`
+	Generated      time.Time     `json:"generated"`
+}
+
+type HeatmapCell struct {
+	Latitude      float64 `json:"latitude"` // cell centre
+	Longitude     float64 `json:"longitude"`
+	Weight        float64 `json:"weight"` // 0.0 to 1.0
+	LocationCount int     `json:"locationCount"`
+	VisitCount    int     `json:"visitCount"`
+	VisitDuration int64   `json:"visitDuration"` // seconds
+}
+
 type GeoBounds struct {
 	Northeast Coordinate `json:"northeast"`
 	Southwest Coordinate `json:"southwest"`
@@ -639,6 +784,61 @@ type Coordinate struct {
 	Longitude float64 `json:"longitude"`
 }
 
+const (
+	DefaultHistoryPoints = 500
+	MaxHistoryPoints     = 2000
+	// Longest time window of a viewport history query
+	MaxHistoryWindow = 31 * 24 * time.Hour
+)
+
+// ViewportHistoryRequest selects a member's points inside a map viewport
+type ViewportHistoryRequest struct {
+	UserID    string `form:"userId"`
+	BBox      string `form:"bbox"`      // minLon,minLat,maxLon,maxLat
+	From      string `form:"from"`      // RFC 3339, default a day before to
+	To        string `form:"to"`        // RFC 3339, default now
+	MaxPoints int    `form:"maxPoints"` // default DefaultHistoryPoints
+}
+
+// BoundingBox is a map viewport in degrees. West is greater than east when
+// the box crosses the antimeridian.
+type BoundingBox struct {
+	West  float64 `json:"west"`
+	South float64 `json:"south"`
+	East  float64 `json:"east"`
+	North float64 `json:"north"`
+}
+
+// Contains reports whether the point lies in the box, edges included
+func (b BoundingBox) Contains(latitude, longitude float64) bool {
+	if latitude < b.South || latitude > b.North {
+		return false
+	}
+	if b.West <= b.East {
+		return longitude >= b.West && longitude <= b.East
+	}
+	return longitude >= b.West || longitude <= b.East
+}
+
+// ViewportHistory is a member's location history inside a viewport,
+// downsampled to at most MaxPoints evenly spread over the time window
+type ViewportHistory struct {
+	UserID      string         `json:"userId"`
+	BBox        BoundingBox    `json:"bbox"`
+	From        time.Time      `json:"from"`
+	To          time.Time      `json:"to"`
+	Precision   string         `json:"precision"` // member's sharing precision
+	Points      []HistoryPoint `json:"points"`
+	TotalPoints int64          `json:"totalPoints"` // before downsampling
+	MaxPoints   int            `json:"maxPoints"`
+	Downsampled bool           `json:"downsampled"`
+}
+
+type HistoryPoint struct {
+	SharedLocation
+	RecordedAt time.Time `json:"recordedAt"`
+}
+
 type LocationPatterns struct {
 	UserID         string                `json:"userId"`
 	HomeLocation   *Location             `json:"homeLocation,omitempty"`
@@ -743,10 +943,32 @@ type GeofenceEvent struct {
 	UserID    string             `json:"userId" bson:"userId"`
 	PlaceID   string             `json:"placeId" bson:"placeId"`
 	PlaceName string             `json:"placeName" bson:"placeName"`
-	EventType string             `json:"eventType" bson:"eventType"` // enter, exit
+	CircleID  string             `json:"circleId,omitempty" bson:"circleId,omitempty"` // the place's circle
+	EventType string             `json:"eventType" bson:"eventType"`                   // enter, exit
 	Location  Location           `json:"location" bson:"location"`
 	Timestamp time.Time          `json:"timestamp" bson:"timestamp"`
 	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+
+	Notification *GeofenceEventNotification `json:"notification,omitempty" bson:"notification,omitempty"`
+}
+
+// GeofenceEventNotification records who was told of a geofence event. When
+// the member crosses overlapping places of several circles, each recipient
+// gets one notification, naming all the circles, and the other events list
+// them as collapsed into it.
+type GeofenceEventNotification struct {
+	Notified  []string                  `json:"notified" bson:"notified"`
+	CircleIDs []string                  `json:"circleIds" bson:"circleIds"` // circles the notifications named
+	Collapsed []GeofenceCollapsedNotice `json:"collapsed,omitempty" bson:"collapsed,omitempty"`
+	SentAt    time.Time                 `json:"sentAt" bson:"sentAt"`
+}
+
+// GeofenceCollapsedNotice is a recipient who wasn't notified of the event
+// because they were of the overlapping event in another circle
+type GeofenceCollapsedNotice struct {
+	UserID     string `json:"userId" bson:"userId"`
+	IntoEvent  string `json:"intoEvent" bson:"intoEvent"`
+	IntoCircle string `json:"intoCircle,omitempty" bson:"intoCircle,omitempty"`
 }
 
 type GeofenceTestRequest struct {
@@ -977,9 +1199,31 @@ type PowerModeResult struct {
 // ==================== RESPONSE MODELS ====================
 
 type BulkUpdateResult struct {
-	Successful int      `json:"successful"`
-	Failed     int      `json:"failed"`
-	Errors     []string `json:"errors,omitempty"`
+	Successful int           `json:"successful"`
+	Failed     int           `json:"failed"`
+	Errors     []string      `json:"errors,omitempty"`
+	Tracking   *TrackingHint `json:"tracking,omitempty"`
+}
+
+// TrackingHint tells the client how often to report its location
+type TrackingHint struct {
+	Interval  int       `json:"interval"` // seconds
+	Reason    string    `json:"reason"`   // live_view, active_trip, at_place, default
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+const (
+	TrackingReasonLiveView   = "live_view"
+	TrackingReasonActiveTrip = "active_trip"
+	TrackingReasonAtPlace    = "at_place"
+	TrackingReasonDefault    = "default"
+)
+
+// LocationUpdateResponse is the saved location with the interval the client
+// should report at from now on
+type LocationUpdateResponse struct {
+	*Location
+	Tracking *TrackingHint `json:"tracking,omitempty"`
 }
 
 type LocationHistoryResponse struct {
@@ -1044,6 +1288,16 @@ const (
 	PrecisionApproximate = "approximate"
 	PrecisionCity        = "city"
 
+	// Member location statuses
+	MemberLocationSharing     = "sharing"
+	MemberLocationPaused      = "paused"
+	MemberLocationUnavailable = "unavailable"
+
+	// How current a sharing member's latest location is
+	LocationFresh   = "fresh"
+	LocationStale   = "stale"
+	LocationOffline = "offline"
+
 	// Trip types
 	TripTypeCommute   = "commute"
 	TripTypeLeisure   = "leisure"
@@ -1072,3 +1326,28 @@ const (
 	SeverityHigh     = "high"
 	SeverityCritical = "critical"
 )
+
+// Server defaults for when a member's location shows as stale and as
+// offline, set by utils.ConfigureLocationStaleness
+var (
+	DefaultLocationStaleAfter   = 15 * time.Minute
+	DefaultLocationOfflineAfter = 2 * time.Hour
+)
+
+// Longest a circle can wait before showing a member as offline
+const MaxLocationOfflineAfterMinutes = 7 * 24 * 60
+
+const NotificationTypeMemberOffline = "member_offline"
+
+// LocationStalenessAt says whether a location last updated at updatedAt is
+// fresh, stale or offline at now
+func LocationStalenessAt(updatedAt, now time.Time, staleAfter, offlineAfter time.Duration) string {
+	switch age := now.Sub(updatedAt); {
+	case age > offlineAfter:
+		return LocationOffline
+	case age > staleAfter:
+		return LocationStale
+	default:
+		return LocationFresh
+	}
+}
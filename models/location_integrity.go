@@ -0,0 +1,70 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Heuristics that flag a location update as possibly spoofed
+const (
+	IntegrityFlagMockProvider    = "mock_provider"    // the device reported a mock location provider
+	IntegrityFlagPerfectAccuracy = "perfect_accuracy" // implausibly precise fixes for a long stretch
+	IntegrityFlagTeleport        = "teleport"         // a jump faster than any travel
+	IntegrityFlagNoNoise         = "no_noise"         // the same coordinates fix after fix
+	IntegrityFlagSensorMismatch  = "sensor_mismatch"  // motion sensors report stillness while the position moves
+)
+
+const NotificationTypeLocationIntegrity = "location_integrity"
+
+// LocationSensors is what the device's motion sensors reported with a
+// location update
+type LocationSensors struct {
+	Activity string `json:"activity,omitempty" bson:"activity,omitempty"` // stationary, walking, running, cycling, automotive
+	Steps    *int   `json:"steps,omitempty" bson:"steps,omitempty"`       // steps since the previous update
+}
+
+// LocationIntegrity is how far a user's reported locations are trusted to
+// be real. Flags lower the score and clean updates slowly restore it. It
+// only informs circle admins; nothing is blocked on it.
+type LocationIntegrity struct {
+	ID     primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	UserID primitive.ObjectID `json:"userId" bson:"userId"`
+
+	Score       int                     `json:"score" bson:"score"` // 0-100, 100 is fully trusted
+	FlagCounts  map[string]int          `json:"flagCounts" bson:"flagCounts"`
+	RecentFlags []LocationIntegrityFlag `json:"recentFlags" bson:"recentFlags"` // newest first
+
+	// Stretches the heuristics look at, from update to update
+	PreciseSince   *time.Time `json:"-" bson:"preciseSince,omitempty"`
+	PreciseFlagged bool       `json:"-" bson:"preciseFlagged"`
+	IdenticalFixes int        `json:"-" bson:"identicalFixes"`
+
+	LastFlaggedAt *time.Time `json:"lastFlaggedAt,omitempty" bson:"lastFlaggedAt,omitempty"`
+	CreatedAt     time.Time  `json:"createdAt" bson:"createdAt"`
+	UpdatedAt     time.Time  `json:"updatedAt" bson:"updatedAt"`
+}
+
+// LocationIntegrityFlag is one update a heuristic flagged
+type LocationIntegrityFlag struct {
+	Type       string             `json:"type" bson:"type"`
+	LocationID primitive.ObjectID `json:"locationId" bson:"locationId"`
+	Detail     string             `json:"detail,omitempty" bson:"detail,omitempty"`
+	FlaggedAt  time.Time          `json:"flaggedAt" bson:"flaggedAt"`
+}
+
+// MemberIntegrity is a circle member's location integrity, as the circle's
+// admins see it
+type MemberIntegrity struct {
+	UserID        string                  `json:"userId"`
+	Name          string                  `json:"name"`
+	Score         int                     `json:"score"`
+	FlagCounts    map[string]int          `json:"flagCounts"`
+	RecentFlags   []LocationIntegrityFlag `json:"recentFlags"`
+	LastFlaggedAt *time.Time              `json:"lastFlaggedAt,omitempty"`
+}
+
+type CircleIntegrityResponse struct {
+	CircleID string            `json:"circleId"`
+	Members  []MemberIntegrity `json:"members"`
+}
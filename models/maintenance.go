@@ -0,0 +1,157 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Denormalized counter classes checked by the reconciliation job
+const (
+	CounterClassReplyCount        = "message_reply_count"
+	CounterClassReactions         = "message_reactions"
+	CounterClassPlaceVisits       = "place_visit_count"
+	CounterClassPlaceCheckins     = "place_checkin_count"
+	CounterClassPlaceReviews      = "place_review_count"
+	CounterClassNotificationBadge = "notification_badge"
+	CounterClassTemplateUsage     = "template_usage_count"
+)
+
+// AllCounterClasses lists every counter class in the order they are reconciled
+var AllCounterClasses = []string{
+	CounterClassReplyCount,
+	CounterClassReactions,
+	CounterClassPlaceVisits,
+	CounterClassPlaceCheckins,
+	CounterClassPlaceReviews,
+	CounterClassNotificationBadge,
+	CounterClassTemplateUsage,
+}
+
+// Reconcile run statuses
+const (
+	ReconcileStatusRunning   = "running"
+	ReconcileStatusCompleted = "completed"
+	ReconcileStatusFailed    = "failed"
+)
+
+// Maximum number of discrepancies kept on a run report
+const MaxReportedDiscrepancies = 500
+
+type ReconcileRequest struct {
+	CounterClasses []string `json:"counterClasses,omitempty" validate:"omitempty,dive,oneof=message_reply_count message_reactions place_visit_count place_checkin_count place_review_count notification_badge template_usage_count"`
+	DryRun         bool     `json:"dryRun"`
+	BatchSize      int      `json:"batchSize,omitempty" validate:"omitempty,min=10,max=1000"`
+}
+
+// ReconcileRun records one pass of the counter reconciliation job
+type ReconcileRun struct {
+	ID            primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
+	Trigger       string               `json:"trigger" bson:"trigger"` // schedule, admin
+	TriggeredBy   string               `json:"triggeredBy,omitempty" bson:"triggeredBy,omitempty"`
+	DryRun        bool                 `json:"dryRun" bson:"dryRun"`
+	Status        string               `json:"status" bson:"status"` // running, completed, failed
+	Classes       []CounterClassReport `json:"classes" bson:"classes"`
+	Discrepancies []CounterDiscrepancy `json:"discrepancies" bson:"discrepancies"`
+	Truncated     bool                 `json:"truncated" bson:"truncated"` // more discrepancies than were kept
+	ErrorMsg      string               `json:"errorMsg,omitempty" bson:"errorMsg,omitempty"`
+	StartedAt     time.Time            `json:"startedAt" bson:"startedAt"`
+	CompletedAt   *time.Time           `json:"completedAt,omitempty" bson:"completedAt,omitempty"`
+}
+
+type CounterClassReport struct {
+	CounterClass  string `json:"counterClass" bson:"counterClass"`
+	Scanned       int64  `json:"scanned" bson:"scanned"`
+	Discrepancies int64  `json:"discrepancies" bson:"discrepancies"`
+	Repaired      int64  `json:"repaired" bson:"repaired"`
+	Skipped       int64  `json:"skipped" bson:"skipped"` // changed mid-scan or within tolerance
+	TotalDrift    int64  `json:"totalDrift" bson:"totalDrift"`
+	MaxDrift      int64  `json:"maxDrift" bson:"maxDrift"`
+}
+
+type CounterDiscrepancy struct {
+	CounterClass string `json:"counterClass" bson:"counterClass"`
+	EntityID     string `json:"entityId" bson:"entityId"`
+	Stored       int64  `json:"stored" bson:"stored"`
+	Computed     int64  `json:"computed" bson:"computed"`
+	Repaired     bool   `json:"repaired" bson:"repaired"`
+}
+
+// CounterDriftStats accumulates drift per counter class across runs
+type CounterDriftStats struct {
+	Runs          int64     `json:"runs"`
+	Discrepancies int64     `json:"discrepancies"`
+	Repaired      int64     `json:"repaired"`
+	TotalDrift    int64     `json:"totalDrift"`
+	MaxDrift      int64     `json:"maxDrift"`
+	LastDrift     int64     `json:"lastDrift"`
+	LastRunAt     time.Time `json:"lastRunAt"`
+}
+
+// Phases of a search index rebuild
+const (
+	SearchIndexPhaseDropping = "dropping"
+	SearchIndexPhaseBuilding = "building"
+)
+
+// SearchIndexBuild records a rebuild of the message text index. Its status
+// is one of the reconcile run statuses.
+type SearchIndexBuild struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	TriggeredBy string             `json:"triggeredBy,omitempty" bson:"triggeredBy,omitempty"`
+	IndexName   string             `json:"indexName" bson:"indexName"`
+	Status      string             `json:"status" bson:"status"`
+	Phase       string             `json:"phase" bson:"phase"`
+	Done        int64              `json:"done" bson:"done"`   // documents indexed so far, when the server reports it
+	Total       int64              `json:"total" bson:"total"` // documents to index
+	ErrorMsg    string             `json:"errorMsg,omitempty" bson:"errorMsg,omitempty"`
+	StartedAt   time.Time          `json:"startedAt" bson:"startedAt"`
+	HeartbeatAt time.Time          `json:"heartbeatAt" bson:"heartbeatAt"`
+	CompletedAt *time.Time         `json:"completedAt,omitempty" bson:"completedAt,omitempty"`
+}
+
+// SearchIndexStatus reports whether the message text index exists, indexes
+// the fields search expects, and when it was last rebuilt
+type SearchIndexStatus struct {
+	IndexName         string            `json:"indexName"`
+	Exists            bool              `json:"exists"`
+	IndexedFields     []string          `json:"indexedFields"`
+	ExpectedFields    []string          `json:"expectedFields"`
+	DefinitionCurrent bool              `json:"definitionCurrent"`
+	Rebuilding        bool              `json:"rebuilding"`
+	FallbackActive    bool              `json:"fallbackActive"` // searches scan content with a regex
+	CurrentBuild      *SearchIndexBuild `json:"currentBuild,omitempty"`
+	LastBuild         *SearchIndexBuild `json:"lastBuild,omitempty"`
+	LastBuiltAt       *time.Time        `json:"lastBuiltAt,omitempty"`
+	CheckedAt         time.Time         `json:"checkedAt"`
+}
+
+// Phases of a message encryption run
+const (
+	MessageEncryptionPhaseMessages = "messages"
+	MessageEncryptionPhaseDrafts   = "drafts"
+)
+
+// MessageEncryptionRun records a pass writing message and draft content as
+// circles' encryption settings and the current key ask: encrypting plain
+// content of encrypted circles and re-encrypting content under older keys.
+// Its status is one of the reconcile run statuses.
+type MessageEncryptionRun struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	TriggeredBy string             `json:"triggeredBy,omitempty" bson:"triggeredBy,omitempty"`
+	KeyID       string             `json:"keyId" bson:"keyId"`
+	Status      string             `json:"status" bson:"status"`
+	Phase       string             `json:"phase" bson:"phase"`
+	Circles     int                `json:"circles" bson:"circles"` // circles with encryption on
+	Total       int64              `json:"total" bson:"total"`     // documents to write, counted at start
+	Done        int64              `json:"done" bson:"done"`
+	Skipped     int64              `json:"skipped" bson:"skipped"` // changed mid-run
+	ErrorMsg    string             `json:"errorMsg,omitempty" bson:"errorMsg,omitempty"`
+	StartedAt   time.Time          `json:"startedAt" bson:"startedAt"`
+	HeartbeatAt time.Time          `json:"heartbeatAt" bson:"heartbeatAt"`
+	CompletedAt *time.Time         `json:"completedAt,omitempty" bson:"completedAt,omitempty"`
+}
+
+type MessageEncryptionRequest struct {
+	BatchSize int `json:"batchSize,omitempty" validate:"omitempty,min=10,max=1000"`
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	MediaUploadStatusPending    = "pending"    // waiting for the client's upload
+	MediaUploadStatusProcessing = "processing" // being verified and processed
+	MediaUploadStatusCompleted  = "completed"
+	MediaUploadStatusRejected   = "rejected" // the object didn't match what was declared
+)
+
+// MediaUploadIntent is a direct upload to object storage the client was
+// given a pre-signed URL for. Its object only becomes media once the
+// upload is completed; until then it can be garbage collected.
+type MediaUploadIntent struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID      string             `json:"userId" bson:"userId"`
+	ObjectKey   string             `json:"-" bson:"objectKey"`
+	Filename    string             `json:"filename" bson:"filename"`
+	ContentType string             `json:"contentType" bson:"contentType"`
+	MediaType   string             `json:"mediaType" bson:"mediaType"`
+	Size        int64              `json:"size" bson:"size"` // bytes, as declared
+	Status      string             `json:"status" bson:"status"`
+
+	MediaID     *primitive.ObjectID `json:"mediaId,omitempty" bson:"mediaId,omitempty"`
+	ExpiresAt   time.Time           `json:"expiresAt" bson:"expiresAt"`
+	CompletedAt *time.Time          `json:"completedAt,omitempty" bson:"completedAt,omitempty"`
+	CreatedAt   time.Time           `json:"createdAt" bson:"createdAt"`
+	UpdatedAt   time.Time           `json:"updatedAt" bson:"updatedAt"`
+}
+
+type MediaUploadIntentRequest struct {
+	Filename    string `json:"filename" validate:"required,max=255"`
+	ContentType string `json:"contentType" validate:"required,max=100"`
+	Size        int64  `json:"size" validate:"required,min=1"`
+	MediaType   string `json:"type,omitempty" validate:"omitempty,oneof=image video audio document"`
+}
+
+// MediaUploadIntentResponse tells the client where and how to upload. The
+// headers must be sent with the PUT as they are.
+type MediaUploadIntentResponse struct {
+	UploadID    string            `json:"uploadId"`
+	UploadURL   string            `json:"uploadUrl"`
+	Method      string            `json:"method"`
+	Headers     map[string]string `json:"headers"`
+	ContentType string            `json:"contentType"`
+	MaxSize     int64             `json:"maxSize"`
+	ExpiresAt   time.Time         `json:"expiresAt"`
+}
+
+type MediaUploadCompleteRequest struct {
+	UploadID string `json:"uploadId" validate:"required,len=24,hexadecimal"`
+}
@@ -19,14 +19,27 @@ type Message struct {
 	Media    MessageMedia    `json:"media,omitempty" bson:"media,omitempty"`
 	Location MessageLocation `json:"location,omitempty" bson:"location,omitempty"`
 
+	// Set while Content holds ciphertext: the key it was encrypted with.
+	// The repository decrypts content on read.
+	ContentKeyID string `json:"-" bson:"contentKeyId,omitempty"`
+
 	// Message State
 	Status    string              `json:"status" bson:"status"` // sent, delivered, read
 	ReadBy    []MessageReadStatus `json:"readBy" bson:"readBy"`
 	Reactions []MessageReaction   `json:"reactions" bson:"reactions"`
 
 	// References
-	ReplyTo  primitive.ObjectID `json:"replyTo,omitempty" bson:"replyTo,omitempty"`
-	ThreadID primitive.ObjectID `json:"threadId,omitempty" bson:"threadId,omitempty"`
+	ReplyTo    primitive.ObjectID `json:"replyTo,omitempty" bson:"replyTo,omitempty"`
+	ThreadID   primitive.ObjectID `json:"threadId,omitempty" bson:"threadId,omitempty"`
+	TemplateID primitive.ObjectID `json:"templateId,omitempty" bson:"templateId,omitempty"`
+	ReplyCount int                `json:"replyCount" bson:"replyCount"`
+
+	// Set by clients that compose messages offline and send them later:
+	// their own ID for the message, unique per sender, and when it was
+	// written. Messages are ordered by ComposedAt, then by CreatedAt, the
+	// time the server got them.
+	ClientID   string    `json:"clientId,omitempty" bson:"clientId,omitempty"`
+	ComposedAt time.Time `json:"composedAt" bson:"composedAt"`
 
 	// Metadata
 	IsEdited  bool      `json:"isEdited" bson:"isEdited"`
@@ -34,10 +47,31 @@ type Message struct {
 	IsDeleted bool      `json:"isDeleted" bson:"isDeleted"`
 	DeletedAt time.Time `json:"deletedAt,omitempty" bson:"deletedAt,omitempty"`
 
+	// Set at read time when the sender is blocked by the viewer; content,
+	// media and location are cleared
+	IsCollapsed bool `json:"isCollapsed,omitempty" bson:"-"`
+
+	// Set when a send repeats a client ID the sender already used; the
+	// message is the one stored the first time
+	IsDuplicate bool `json:"isDuplicate,omitempty" bson:"-"`
+
 	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"`
 }
 
+// Composed-at times older than this are taken as the time the server got
+// the message, so a device with a wrong clock can't bury it in history
+const MaxMessageComposedAge = 7 * 24 * time.Hour
+
+// Collapse replaces the message with a placeholder for a viewer who has
+// blocked its sender
+func (m *Message) Collapse() {
+	m.Content = ""
+	m.Media = MessageMedia{}
+	m.Location = MessageLocation{}
+	m.IsCollapsed = true
+}
+
 type MessageLocation struct {
 	Latitude  float64 `json:"latitude" bson:"latitude"`
 	Longitude float64 `json:"longitude" bson:"longitude"`
@@ -45,6 +79,12 @@ type MessageLocation struct {
 	PlaceName string  `json:"placeName,omitempty" bson:"placeName,omitempty"`
 }
 
+// IsEmpty reports whether no location was given. 0,0 is in the ocean, so it
+// is never a real shared location.
+func (l *MessageLocation) IsEmpty() bool {
+	return l.Latitude == 0 && l.Longitude == 0 && l.Address == "" && l.PlaceName == ""
+}
+
 type MessageReadStatus struct {
 	UserID primitive.ObjectID `json:"userId" bson:"userId"`
 	ReadAt time.Time          `json:"readAt" bson:"readAt"`
@@ -92,6 +132,14 @@ type SendMessageRequest struct {
 	Media    *MessageMedia    `json:"media,omitempty"`
 	Location *MessageLocation `json:"location,omitempty"`
 	ReplyTo  string           `json:"replyTo,omitempty"`
+
+	// Offline composition: resending with the same client ID returns the
+	// stored message instead of posting it again
+	ClientID   string     `json:"clientId,omitempty" validate:"omitempty,max=64"`
+	ComposedAt *time.Time `json:"composedAt,omitempty"`
+
+	// Set when the message is sent from a template
+	TemplateID string `json:"-"`
 }
 
 type EditMessageRequest struct {
@@ -133,6 +181,7 @@ type MessageDraft struct {
 	Location  *MessageLocation   `json:"location,omitempty" bson:"location,omitempty"`
 	ReplyTo   primitive.ObjectID `json:"replyTo,omitempty" bson:"replyTo,omitempty"`
 	AutoSave  bool               `json:"autoSave" bson:"autoSave"`
+	ContentKeyID string          `json:"-" bson:"contentKeyId,omitempty"` // see Message.ContentKeyID
 	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
 	UpdatedAt time.Time          `json:"updatedAt" bson:"updatedAt"`
 }
@@ -147,7 +196,7 @@ type ScheduledMessage struct {
 	Media       *MessageMedia       `json:"media,omitempty" bson:"media,omitempty"`
 	Location    *MessageLocation    `json:"location,omitempty" bson:"location,omitempty"`
 	ScheduledAt time.Time           `json:"scheduledAt" bson:"scheduledAt"`
-	Status      string              `json:"status" bson:"status"` // pending, sent, cancelled, failed
+	Status      string              `json:"status" bson:"status"` // pending, paused, sent, cancelled, failed
 	SentAt      *time.Time          `json:"sentAt,omitempty" bson:"sentAt,omitempty"`
 	MessageID   *primitive.ObjectID `json:"messageId,omitempty" bson:"messageId,omitempty"`
 	ErrorMsg    string              `json:"errorMsg,omitempty" bson:"errorMsg,omitempty"`
@@ -179,8 +228,16 @@ type AutomationRule struct {
 	Name          string              `json:"name" bson:"name"`
 	Type          string              `json:"type" bson:"type"` // auto_reply, keyword_trigger, schedule
 	IsActive      bool                `json:"isActive" bson:"isActive"`
+	PausedByOwner bool                `json:"-" bson:"pausedByOwner,omitempty"` // Paused while the owner is deactivated
 	Conditions    []RuleCondition     `json:"conditions" bson:"conditions"`
 	Actions       []RuleAction        `json:"actions" bson:"actions"`
+
+	// Matching rules run highest priority first, older rules first on a
+	// tie. A match on a StopOnMatch rule skips the owner's remaining rules
+	// for the event.
+	Priority    int  `json:"priority" bson:"priority"`
+	StopOnMatch bool `json:"stopOnMatch" bson:"stopOnMatch"`
+
 	TriggerCount  int                 `json:"triggerCount" bson:"triggerCount"`
 	LastTriggered *time.Time          `json:"lastTriggered,omitempty" bson:"lastTriggered,omitempty"`
 	CreatedAt     time.Time           `json:"createdAt" bson:"createdAt"`
@@ -192,10 +249,18 @@ type AutomationRule struct {
 type MessageExport struct {
 	ID           primitive.ObjectID `json:"id" bson:"_id,omitempty"`
 	UserID       primitive.ObjectID `json:"userId" bson:"userId"`
-	CircleID     primitive.ObjectID `json:"circleId" bson:"circleId"`
-	Format       string             `json:"format" bson:"format"`     // json, csv, txt
-	Status       string             `json:"status" bson:"status"`     // processing, completed, failed
+	CircleID     primitive.ObjectID `json:"circleId,omitempty" bson:"circleId,omitempty"`
+	PlaceID      primitive.ObjectID `json:"placeId,omitempty" bson:"placeId,omitempty"` // place analytics
+	Type         string             `json:"type" bson:"type"`         // messages, places, place_analytics
+	Format       string             `json:"format" bson:"format"`     // json, csv, txt, pdf
+	SchemaVersion int               `json:"schemaVersion,omitempty" bson:"schemaVersion,omitempty"` // of the files written
+	AppVersion    string            `json:"appVersion,omitempty" bson:"appVersion,omitempty"`
+	ManifestPath  string            `json:"-" bson:"manifestPath,omitempty"`
+	Status       string             `json:"status" bson:"status"`     // pending, processing, completed, failed, cancelled
 	Progress     int                `json:"progress" bson:"progress"` // 0-100
+	FilePath     string             `json:"-" bson:"filePath,omitempty"`
+	FilePaths    []string           `json:"-" bson:"filePaths,omitempty"` // all parts of a multi-file export
+	PartCount    int                `json:"partCount,omitempty" bson:"partCount,omitempty"`
 	FileURL      string             `json:"fileUrl,omitempty" bson:"fileUrl,omitempty"`
 	FileSize     int64              `json:"fileSize,omitempty" bson:"fileSize,omitempty"`
 	MessageCount int                `json:"messageCount" bson:"messageCount"`
@@ -203,8 +268,41 @@ type MessageExport struct {
 	IncludeMedia bool               `json:"includeMedia" bson:"includeMedia"`
 	ErrorMsg     string             `json:"errorMsg,omitempty" bson:"errorMsg,omitempty"`
 	ExpiresAt    time.Time          `json:"expiresAt" bson:"expiresAt"`
+	CompletedAt  *time.Time         `json:"completedAt,omitempty" bson:"completedAt,omitempty"`
+	CancelledAt  *time.Time         `json:"cancelledAt,omitempty" bson:"cancelledAt,omitempty"`
 	CreatedAt    time.Time          `json:"createdAt" bson:"createdAt"`
 	UpdatedAt    time.Time          `json:"updatedAt" bson:"updatedAt"`
+
+	// Set on exports of search results
+	SearchCriteria *MessageSearchCriteria `json:"searchCriteria,omitempty" bson:"searchCriteria,omitempty"`
+}
+
+// Export job types and statuses
+const (
+	ExportTypeMessages = "messages"
+	ExportTypePlaces   = "places"
+
+	ExportStatusPending    = "pending"
+	ExportStatusProcessing = "processing"
+	ExportStatusCompleted  = "completed"
+	ExportStatusFailed     = "failed"
+	ExportStatusCancelled  = "cancelled"
+)
+
+// IsActive reports whether the export can still be cancelled
+func (e *MessageExport) IsActive() bool {
+	return e.Status == ExportStatusPending || e.Status == ExportStatusProcessing
+}
+
+// Files returns the paths of every file the export wrote
+func (e *MessageExport) Files() []string {
+	if len(e.FilePaths) > 0 {
+		return e.FilePaths
+	}
+	if e.FilePath != "" {
+		return []string{e.FilePath}
+	}
+	return nil
 }
 
 type ExportDateRange struct {
@@ -246,6 +344,21 @@ type MediaMetadata struct {
 	EXIF       map[string]string `json:"exif,omitempty" bson:"exif,omitempty"`
 }
 
+// MediaBlob is stored upload content shared by every media record with the
+// same SHA-256. The file is removed when the last reference goes.
+type MediaBlob struct {
+	Hash         string           `json:"hash" bson:"_id"`
+	Filename     string           `json:"filename" bson:"filename"`
+	Size         int64            `json:"size" bson:"size"`
+	MimeType     string           `json:"mimeType" bson:"mimeType"`
+	ThumbnailURL string           `json:"thumbnailUrl,omitempty" bson:"thumbnailUrl,omitempty"`
+	Duration     int              `json:"duration,omitempty" bson:"duration,omitempty"`
+	Dimensions   *MediaDimensions `json:"dimensions,omitempty" bson:"dimensions,omitempty"`
+	RefCount     int64            `json:"refCount" bson:"refCount"`
+	CreatedAt    time.Time        `json:"createdAt" bson:"createdAt"`
+	UpdatedAt    time.Time        `json:"updatedAt" bson:"updatedAt"`
+}
+
 // Request Models
 
 // Basic Message Requests
@@ -278,12 +391,23 @@ type CompressMediaRequest struct {
 
 // Search Requests
 type SearchMessagesRequest struct {
-	Query       string `json:"query" validate:"required,min=1"`
+	Query       string `json:"query,omitempty"`
 	Page        int    `json:"page" validate:"min=1"`
 	PageSize    int    `json:"pageSize" validate:"min=1,max=100"`
+	SenderID    string `json:"senderId,omitempty"`
 	MessageType string `json:"messageType,omitempty"`
-	DateFrom    string `json:"dateFrom,omitempty"`
-	DateTo      string `json:"dateTo,omitempty"`
+	MediaType   string `json:"mediaType,omitempty" validate:"omitempty,oneof=image video audio document"`
+	DateFrom    string `json:"dateFrom,omitempty"` // YYYY-MM-DD
+	DateTo      string `json:"dateTo,omitempty"`   // YYYY-MM-DD, inclusive
+
+	// Senders on either side of a block with the searcher
+	ExcludeSenderIDs []string `json:"-"`
+}
+
+// HasFilters reports whether the search is scoped by anything besides text
+func (req *SearchMessagesRequest) HasFilters() bool {
+	return req.SenderID != "" || req.MessageType != "" || req.MediaType != "" ||
+		req.DateFrom != "" || req.DateTo != ""
 }
 
 type SearchInCircleRequest struct {
@@ -291,6 +415,8 @@ type SearchInCircleRequest struct {
 	Query    string `json:"query" validate:"required,min=1"`
 	Page     int    `json:"page" validate:"min=1"`
 	PageSize int    `json:"pageSize" validate:"min=1,max=100"`
+
+	ExcludeSenderIDs []string `json:"-"`
 }
 
 type SearchMediaRequest struct {
@@ -304,6 +430,9 @@ type SearchMentionsRequest struct {
 	Page     int    `json:"page" validate:"min=1"`
 	PageSize int    `json:"pageSize" validate:"min=1,max=100"`
 	CircleID string `json:"circleId,omitempty"`
+
+	// Mentions across a block are dropped
+	ExcludeSenderIDs []string `json:"-"`
 }
 
 type SearchLinksRequest struct {
@@ -347,10 +476,19 @@ type GetScheduledMessagesRequest struct {
 }
 
 type UpdateScheduledMessageRequest struct {
-	Content     string           `json:"content,omitempty"`
-	Media       *MessageMedia    `json:"media,omitempty"`
-	Location    *MessageLocation `json:"location,omitempty"`
-	ScheduledAt *time.Time       `json:"scheduledAt,omitempty"`
+	Content string `json:"content,omitempty"`
+	AttachmentUpdate
+	ScheduledAt *time.Time `json:"scheduledAt,omitempty"`
+}
+
+// AttachmentUpdate is the media and location part of a partial update. A
+// missing value leaves the stored one unchanged and the clear flags remove
+// it. Empty values are ignored, so they can't wipe it by accident.
+type AttachmentUpdate struct {
+	Media         *MessageMedia    `json:"media,omitempty"`
+	Location      *MessageLocation `json:"location,omitempty"`
+	ClearMedia    bool             `json:"clearMedia,omitempty"`
+	ClearLocation bool             `json:"clearLocation,omitempty"`
 }
 
 // Template Requests
@@ -386,9 +524,41 @@ type UseTemplateRequest struct {
 
 // Export/Import Requests
 type ExportMessagesRequest struct {
-	Format       string          `json:"format" validate:"required,oneof=json csv txt"`
+	Format       string          `json:"format" validate:"required,oneof=json csv txt pdf"`
 	DateRange    ExportDateRange `json:"dateRange,omitempty"`
 	IncludeMedia bool            `json:"includeMedia"`
+
+	// Set when exporting search results instead of the whole circle
+	Search *SearchMessagesRequest `json:"-"`
+}
+
+// ExportSearchResultsRequest exports the circle messages matching a search
+type ExportSearchResultsRequest struct {
+	Format       string `json:"format" validate:"required,oneof=json csv txt pdf"`
+	IncludeMedia bool   `json:"includeMedia"`
+	MessageSearchCriteria
+}
+
+// MessageSearchCriteria is the search an export was made from, kept with
+// the export and written to its manifest
+type MessageSearchCriteria struct {
+	Query       string `json:"query,omitempty" bson:"query,omitempty"`
+	SenderID    string `json:"senderId,omitempty" bson:"senderId,omitempty"`
+	MessageType string `json:"messageType,omitempty" bson:"messageType,omitempty"`
+	MediaType   string `json:"mediaType,omitempty" bson:"mediaType,omitempty"`
+	DateFrom    string `json:"dateFrom,omitempty" bson:"dateFrom,omitempty"` // YYYY-MM-DD
+	DateTo      string `json:"dateTo,omitempty" bson:"dateTo,omitempty"`     // YYYY-MM-DD, inclusive
+}
+
+func (c MessageSearchCriteria) SearchRequest() SearchMessagesRequest {
+	return SearchMessagesRequest{
+		Query:       c.Query,
+		SenderID:    c.SenderID,
+		MessageType: c.MessageType,
+		MediaType:   c.MediaType,
+		DateFrom:    c.DateFrom,
+		DateTo:      c.DateTo,
+	}
 }
 
 type ImportMessagesRequest struct {
@@ -456,6 +626,12 @@ type GetPopularMessagesRequest struct {
 	CircleID string `json:"circleId,omitempty"`
 }
 
+// Automation rule priorities
+const (
+	MinAutomationRulePriority = 0
+	MaxAutomationRulePriority = 1000
+)
+
 // Automation Requests
 type GetAutomationRulesRequest struct {
 	Page     int    `json:"page" validate:"min=1"`
@@ -471,6 +647,9 @@ type CreateAutomationRuleRequest struct {
 	Conditions []RuleCondition `json:"conditions" validate:"required,min=1"`
 	Actions    []RuleAction    `json:"actions" validate:"required,min=1"`
 	IsActive   bool            `json:"isActive"`
+
+	Priority    int  `json:"priority" validate:"min=0,max=1000"`
+	StopOnMatch bool `json:"stopOnMatch"`
 }
 
 type UpdateAutomationRuleRequest struct {
@@ -478,6 +657,9 @@ type UpdateAutomationRuleRequest struct {
 	Conditions []RuleCondition `json:"conditions,omitempty"`
 	Actions    []RuleAction    `json:"actions,omitempty"`
 	IsActive   *bool           `json:"isActive,omitempty"`
+
+	Priority    *int  `json:"priority,omitempty" validate:"omitempty,min=0,max=1000"`
+	StopOnMatch *bool `json:"stopOnMatch,omitempty"`
 }
 
 type TestAutomationRuleRequest struct {
@@ -503,9 +685,8 @@ type SaveDraftRequest struct {
 }
 
 type UpdateDraftRequest struct {
-	Content  string           `json:"content,omitempty"`
-	Media    *MessageMedia    `json:"media,omitempty"`
-	Location *MessageLocation `json:"location,omitempty"`
+	Content string `json:"content,omitempty"`
+	AttachmentUpdate
 }
 
 // Response Models
@@ -534,6 +715,14 @@ type ReactionsResponse struct {
 	Total     int                        `json:"total"`
 }
 
+// ReactionToggleResponse is the user's reaction state after a toggle
+type ReactionToggleResponse struct {
+	MessageID string `json:"messageId"`
+	Emoji     string `json:"emoji"`
+	Reacted   bool   `json:"reacted"`
+	Count     int    `json:"count"`
+}
+
 type ReactionSummary struct {
 	Emoji string   `json:"emoji"`
 	Count int      `json:"count"`
@@ -554,6 +743,38 @@ type UserInfo struct {
 	Avatar    string `json:"avatar,omitempty"`
 }
 
+// ReactionStats are a circle's reaction highlights over a period
+type ReactionStats struct {
+	CircleID       string              `json:"circleId"`
+	Period         string              `json:"period"`
+	StartDate      time.Time           `json:"startDate"`
+	TotalReactions int64               `json:"totalReactions"`
+	Emojis         []EmojiCount        `json:"emojis"`
+	TopReactors    []TopReactor        `json:"topReactors"`
+	TopMessages    []TopReactedMessage `json:"topMessages"`
+	Generated      time.Time           `json:"generated"`
+}
+
+type EmojiCount struct {
+	Emoji string `json:"emoji"`
+	Count int64  `json:"count"`
+}
+
+type TopReactor struct {
+	User  UserInfo `json:"user"`
+	Count int64    `json:"count"`
+}
+
+// TopReactedMessage is a most-reacted message with a short preview of it
+type TopReactedMessage struct {
+	MessageID     string    `json:"messageId"`
+	Sender        UserInfo  `json:"sender"`
+	Type          string    `json:"type"`
+	Preview       string    `json:"preview"`
+	ReactionCount int64     `json:"reactionCount"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
 type MediaThumbnail struct {
 	MediaID      string `json:"mediaId"`
 	ThumbnailURL string `json:"thumbnailUrl"`
@@ -621,18 +842,23 @@ type FileInfo struct {
 	CreatedAt time.Time `json:"createdAt"`
 }
 
+// DeliveryStatusResponse counts the recipients a message reached and read.
+// Details are left out once the message is old enough to be compacted.
 type DeliveryStatusResponse struct {
 	MessageID string                 `json:"messageId"`
-	Status    string                 `json:"status"`
+	Status    string                 `json:"status"` // sent, delivered, read: how far it got with every recipient
 	Delivered int                    `json:"delivered"`
+	Read      int                    `json:"read"`
 	Total     int                    `json:"total"`
+	Compacted bool                   `json:"compacted,omitempty"`
 	Details   []DeliveryStatusDetail `json:"details"`
 }
 
 type DeliveryStatusDetail struct {
-	UserID      string    `json:"userId"`
-	Status      string    `json:"status"`
-	DeliveredAt time.Time `json:"deliveredAt,omitempty"`
+	UserID      string     `json:"userId"`
+	Status      string     `json:"status"` // sent, delivered, read
+	DeliveredAt *time.Time `json:"deliveredAt,omitempty"`
+	ReadAt      *time.Time `json:"readAt,omitempty"`
 }
 
 type ReadReceiptsResponse struct {
@@ -665,15 +891,21 @@ type ForwardInfo struct {
 }
 
 type ExportStatusResponse struct {
-	ExportID     string    `json:"exportId"`
-	Status       string    `json:"status"`
-	Progress     int       `json:"progress"`
-	FileURL      string    `json:"fileUrl,omitempty"`
-	FileSize     int64     `json:"fileSize,omitempty"`
-	MessageCount int       `json:"messageCount"`
-	CreatedAt    time.Time `json:"createdAt"`
-	CompletedAt  time.Time `json:"completedAt,omitempty"`
-	ExpiresAt    time.Time `json:"expiresAt"`
+	ExportID     string     `json:"exportId"`
+	Type         string     `json:"type,omitempty"`
+	Status       string     `json:"status"`
+	Progress     int        `json:"progress"`
+	FileURL      string     `json:"fileUrl,omitempty"`
+	FileSize     int64      `json:"fileSize,omitempty"`
+	PartCount    int        `json:"partCount,omitempty"`
+	MessageCount int        `json:"messageCount"`
+	SchemaVersion int       `json:"schemaVersion,omitempty"`
+	AppVersion    string    `json:"appVersion,omitempty"`
+	Error        string     `json:"error,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	CompletedAt  *time.Time `json:"completedAt,omitempty"`
+	CancelledAt  *time.Time `json:"cancelledAt,omitempty"`
+	ExpiresAt    time.Time  `json:"expiresAt"`
 }
 
 type ExportDownload struct {
@@ -760,7 +992,8 @@ type WSReactionData struct {
 	CircleID  string    `json:"circleId"`
 	UserID    string    `json:"userId"`
 	Emoji     string    `json:"emoji"`
-	Action    string    `json:"action"` // add, remove
+	Action    string    `json:"action"`          // add, remove
+	Count     *int      `json:"count,omitempty"` // emoji count after the change, sent by toggles
 	Timestamp time.Time `json:"timestamp"`
 }
 
@@ -800,6 +1033,7 @@ type MessageMedia struct {
 	Filename         string             `json:"filename" bson:"filename"`
 	MimeType         string             `json:"mimeType" bson:"mimeType"`
 	ThumbnailURL     string             `json:"thumbnailUrl,omitempty" bson:"thumbnailUrl,omitempty"`
+	StreamURL        string             `json:"streamUrl,omitempty" bson:"-"`
 	Duration         int                `json:"duration,omitempty" bson:"duration,omitempty"`
 	Dimensions       *MediaDimensions   `json:"dimensions,omitempty" bson:"dimensions,omitempty"`
 	UploadedBy       string             `json:"uploadedBy" bson:"uploadedBy"`
@@ -807,12 +1041,18 @@ type MessageMedia struct {
 	Compressed       bool               `json:"compressed" bson:"compressed"`
 	OriginalSize     int64              `json:"originalSize,omitempty" bson:"originalSize,omitempty"`
 	CompressionRatio float64            `json:"compressionRatio,omitempty" bson:"compressionRatio,omitempty"`
+	ContentHash      string             `json:"contentHash,omitempty" bson:"contentHash,omitempty"` // SHA-256 of deduplicated uploads
 	IsDeleted        bool               `json:"isDeleted,omitempty" bson:"isDeleted,omitempty"`
 	DeletedAt        *time.Time         `json:"deletedAt,omitempty" bson:"deletedAt,omitempty"`
 	CreatedAt        time.Time          `json:"createdAt" bson:"createdAt"`
 	UpdatedAt        time.Time          `json:"updatedAt" bson:"updatedAt"`
 }
 
+// IsEmpty reports whether no media was given
+func (m *MessageMedia) IsEmpty() bool {
+	return m.ID.IsZero() && m.URL == ""
+}
+
 // Storage Stats
 type StorageStats struct {
 	TotalSize int64 `json:"totalSize"`
@@ -924,6 +1164,9 @@ type ImportJob struct {
 	CircleID  string    `json:"circleId"`
 	Status    string    `json:"status"`
 	Progress  int       `json:"progress"`
+	SchemaVersion int   `json:"schemaVersion"` // of the imported file
+	Migrated  bool      `json:"migrated"`      // the file was upgraded from an older schema
+	Records   int       `json:"records"`
 	CreatedAt time.Time `json:"createdAt"`
 }
 
@@ -1205,64 +1448,55 @@ type PopularMessagesResponse struct {
 
 // Validation helpers
 func (req *SearchMessagesRequest) Validate() error {
-	if req.Query == "" {
-		return errors.New("search query is required")
+	if req.Query == "" && !req.HasFilters() {
+		return errors.New("search query or filter is required")
 	}
-	if req.Page < 1 {
-		req.Page = 1
+	if req.MediaType != "" && req.MediaType != "image" && req.MediaType != "video" &&
+		req.MediaType != "audio" && req.MediaType != "document" {
+		return errors.New("invalid media type")
 	}
-	if req.PageSize < 1 || req.PageSize > 100 {
-		req.PageSize = 20
+
+	var dateFrom, dateTo time.Time
+	var err error
+	if req.DateFrom != "" {
+		if dateFrom, err = time.Parse("2006-01-02", req.DateFrom); err != nil {
+			return errors.New("invalid date range")
+		}
 	}
+	if req.DateTo != "" {
+		if dateTo, err = time.Parse("2006-01-02", req.DateTo); err != nil {
+			return errors.New("invalid date range")
+		}
+	}
+	if req.DateFrom != "" && req.DateTo != "" && dateTo.Before(dateFrom) {
+		return errors.New("invalid date range")
+	}
+
+	req.Page, req.PageSize = NormalizePage(req.Page, req.PageSize)
 	return nil
 }
 
 func (req *GetTemplatesRequest) Validate() error {
-	if req.Page < 1 {
-		req.Page = 1
-	}
-	if req.PageSize < 1 || req.PageSize > 100 {
-		req.PageSize = 20
-	}
+	req.Page, req.PageSize = NormalizePage(req.Page, req.PageSize)
 	return nil
 }
 
 func (req *GetDraftsRequest) Validate() error {
-	if req.Page < 1 {
-		req.Page = 1
-	}
-	if req.PageSize < 1 || req.PageSize > 100 {
-		req.PageSize = 20
-	}
+	req.Page, req.PageSize = NormalizePage(req.Page, req.PageSize)
 	return nil
 }
 
 func (req *GetScheduledMessagesRequest) Validate() error {
-	if req.Page < 1 {
-		req.Page = 1
-	}
-	if req.PageSize < 1 || req.PageSize > 100 {
-		req.PageSize = 20
-	}
+	req.Page, req.PageSize = NormalizePage(req.Page, req.PageSize)
 	return nil
 }
 
 func (req *GetReportsRequest) Validate() error {
-	if req.Page < 1 {
-		req.Page = 1
-	}
-	if req.PageSize < 1 || req.PageSize > 100 {
-		req.PageSize = 20
-	}
+	req.Page, req.PageSize = NormalizePage(req.Page, req.PageSize)
 	return nil
 }
 
 func (req *GetAutomationRulesRequest) Validate() error {
-	if req.Page < 1 {
-		req.Page = 1
-	}
-	if req.PageSize < 1 || req.PageSize > 100 {
-		req.PageSize = 20
-	}
+	req.Page, req.PageSize = NormalizePage(req.Page, req.PageSize)
 	return nil
 }
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Per-member delivery detail is kept this long, then compacted into counts
+const MessageDeliveryDetailDays = 30
+
+// MessageDelivery summarizes who a message reached, in one document per
+// message. Members holds when each member first got it, over their socket
+// or a push; once compacted, only the counts are left.
+type MessageDelivery struct {
+	MessageID primitive.ObjectID        `json:"messageId" bson:"_id"`
+	CircleID  primitive.ObjectID        `json:"circleId" bson:"circleId"`
+	Members   map[string]MemberDelivery `json:"members,omitempty" bson:"members,omitempty"` // by user ID
+
+	// Set when the detail is compacted
+	DeliveredCount int        `json:"deliveredCount,omitempty" bson:"deliveredCount,omitempty"`
+	ReadCount      int        `json:"readCount,omitempty" bson:"readCount,omitempty"`
+	RecipientCount int        `json:"recipientCount,omitempty" bson:"recipientCount,omitempty"`
+	CompactedAt    *time.Time `json:"compactedAt,omitempty" bson:"compactedAt,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+type MemberDelivery struct {
+	DeliveredAt time.Time `json:"deliveredAt" bson:"deliveredAt"`
+}
+
+// PendingMessageDelivery is a message's deliveries waiting to be written
+// to its summary
+type PendingMessageDelivery struct {
+	MessageID primitive.ObjectID
+	CircleID  primitive.ObjectID
+	Members   map[string]time.Time // delivered at, by user ID
+}
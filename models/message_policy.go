@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Who a message policy lets edit or delete messages
+const (
+	MessagePolicySenders = "senders" // senders, and for deletes circle admins too
+	MessagePolicyAdmins  = "admins"  // circle admins only
+	MessagePolicyNone    = "none"    // nobody
+)
+
+// Minutes after sending that a message can be edited, unless the circle's
+// policy says otherwise
+const DefaultMessageEditWindow = 15
+
+// MessagePolicy controls who may edit and delete messages in a circle.
+// Unset values use the defaults: senders edit their own messages for
+// DefaultMessageEditWindow minutes, and senders and circle admins delete.
+type MessagePolicy struct {
+	Edit       string `json:"edit,omitempty" bson:"edit,omitempty" validate:"omitempty,oneof=senders admins none"`
+	EditWindow *int   `json:"editWindow,omitempty" bson:"editWindow,omitempty" validate:"omitempty,min=0,max=10080"` // minutes, 0 for no limit
+	Delete     string `json:"delete,omitempty" bson:"delete,omitempty" validate:"omitempty,oneof=senders admins none"`
+
+	UpdatedBy primitive.ObjectID `json:"updatedBy,omitempty" bson:"updatedBy,omitempty"`
+	UpdatedAt time.Time          `json:"updatedAt,omitempty" bson:"updatedAt,omitempty"`
+}
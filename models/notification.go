@@ -12,29 +12,63 @@ import (
 // ========================
 
 type Notification struct {
-	ID               primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
-	UserID           string                 `bson:"user_id" json:"user_id"`
-	Title            string                 `bson:"title" json:"title"`
-	Message          string                 `bson:"message" json:"message"`
-	Type             string                 `bson:"type" json:"type"`
-	Priority         string                 `bson:"priority" json:"priority"`
-	Category         string                 `bson:"category" json:"category"`
-	Status           string                 `bson:"status" json:"status"` // read, unread, archived
-	CircleID         string                 `bson:"circle_id,omitempty" json:"circle_id,omitempty"`
-	Data             interface{}            `bson:"data,omitempty" json:"data,omitempty"`
-	ActionButtons    []ActionButton         `bson:"action_buttons,omitempty" json:"action_buttons,omitempty"`
-	ImageURL         string                 `bson:"image_url,omitempty" json:"image_url,omitempty"`
-	DeepLink         string                 `bson:"deep_link,omitempty" json:"deep_link,omitempty"`
-	ExpiresAt        *time.Time             `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
-	ScheduledAt      *time.Time             `bson:"scheduled_at,omitempty" json:"scheduled_at,omitempty"`
-	SnoozedUntil     *time.Time             `bson:"snoozed_until,omitempty" json:"snoozed_until,omitempty"`
-	IsPinned         bool                   `bson:"is_pinned" json:"is_pinned"`
-	IsArchived       bool                   `bson:"is_archived" json:"is_archived"`
-	ReadAt           *time.Time             `bson:"read_at,omitempty" json:"read_at,omitempty"`
-	CreatedAt        time.Time              `bson:"created_at" json:"created_at"`
-	UpdatedAt        time.Time              `bson:"updated_at" json:"updated_at"`
-	DeliveryChannels []string               `bson:"delivery_channels" json:"delivery_channels"`
-	Metadata         map[string]interface{} `bson:"metadata,omitempty" json:"metadata,omitempty"`
+	ID               primitive.ObjectID      `bson:"_id,omitempty" json:"id"`
+	UserID           string                  `bson:"user_id" json:"user_id"`
+	Title            string                  `bson:"title" json:"title"`
+	Message          string                  `bson:"message" json:"message"`
+	Type             string                  `bson:"type" json:"type"`
+	Priority         string                  `bson:"priority" json:"priority"`
+	Category         string                  `bson:"category" json:"category"`
+	Status           string                  `bson:"status" json:"status"` // read, unread, archived
+	CircleID         string                  `bson:"circle_id,omitempty" json:"circle_id,omitempty"`
+	Data             interface{}             `bson:"data,omitempty" json:"data,omitempty"`
+	ActionButtons    []ActionButton          `bson:"action_buttons,omitempty" json:"action_buttons,omitempty"`
+	ImageURL         string                  `bson:"image_url,omitempty" json:"image_url,omitempty"`
+	Attachment       *NotificationAttachment `bson:"attachment,omitempty" json:"attachment,omitempty"`
+	DeepLink         string                  `bson:"deep_link,omitempty" json:"deep_link,omitempty"`
+	CollapseKey      string                  `bson:"collapse_key,omitempty" json:"collapse_key,omitempty"` // replaces the unread notification with the same key
+	ExpiresAt        *time.Time              `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	ScheduledAt      *time.Time              `bson:"scheduled_at,omitempty" json:"scheduled_at,omitempty"`
+	SnoozedUntil     *time.Time              `bson:"snoozed_until,omitempty" json:"snoozed_until,omitempty"`
+	DeferredUntil    *time.Time              `bson:"deferred_until,omitempty" json:"deferred_until,omitempty"` // held for the user's usual reading time, or until its batch goes out
+	Batched          bool                    `bson:"batched,omitempty" json:"batched,omitempty"`               // sent with the others of its type and deferred_until
+	Immediate        bool                    `bson:"immediate,omitempty" json:"immediate,omitempty"`           // never batched
+	DeliveredAt      *time.Time              `bson:"delivered_at,omitempty" json:"delivered_at,omitempty"`
+	DecisionID       string                  `bson:"decision_id,omitempty" json:"decision_id,omitempty"` // the delivery decision that governed it
+	IsPinned         bool                    `bson:"is_pinned" json:"is_pinned"`
+	IsArchived       bool                    `bson:"is_archived" json:"is_archived"`
+	ReadAt           *time.Time              `bson:"read_at,omitempty" json:"read_at,omitempty"`
+	ActedAt          *time.Time              `bson:"acted_at,omitempty" json:"acted_at,omitempty"` // first action the user took on it
+	CreatedAt        time.Time               `bson:"created_at" json:"created_at"`
+	UpdatedAt        time.Time               `bson:"updated_at" json:"updated_at"`
+	DeliveryChannels []string                `bson:"delivery_channels" json:"delivery_channels"`
+	Metadata         map[string]interface{}  `bson:"metadata,omitempty" json:"metadata,omitempty"`
+
+	// The experiment variant the text came from, if any
+	ExperimentID      string `bson:"experiment_id,omitempty" json:"-"`
+	ExperimentVariant string `bson:"experiment_variant,omitempty" json:"-"`
+}
+
+// NotificationAttachment is an image shown with a push notification. Push
+// payloads only carry a short-lived signed URL to it.
+type NotificationAttachment struct {
+	Kind       string `bson:"kind" json:"kind"` // avatar, place_map, media_thumbnail
+	ResourceID string `bson:"resource_id" json:"resource_id"`
+}
+
+const (
+	AttachmentAvatar         = "avatar"
+	AttachmentPlaceMap       = "place_map"
+	AttachmentMediaThumbnail = "media_thumbnail"
+)
+
+// MessageAttachment returns the media thumbnail of a message, or the
+// sender's avatar when the message has none
+func MessageAttachment(message *Message) *NotificationAttachment {
+	if message.Media.ThumbnailURL != "" && !message.Media.ID.IsZero() {
+		return &NotificationAttachment{Kind: AttachmentMediaThumbnail, ResourceID: message.Media.ID.Hex()}
+	}
+	return &NotificationAttachment{Kind: AttachmentAvatar, ResourceID: message.SenderID.Hex()}
 }
 
 type ActionButton struct {
@@ -55,6 +89,38 @@ type PaginatedNotifications struct {
 	HasPrev       bool           `json:"has_prev"`
 }
 
+// NotificationSearchResult is a notification matching a search, with the
+// parts of it that matched
+type NotificationSearchResult struct {
+	Notification Notification            `json:"notification"`
+	Highlights   []NotificationHighlight `json:"highlights"`
+}
+
+// NotificationHighlight marks the matches in a field of a notification.
+// Offsets count characters of the field.
+type NotificationHighlight struct {
+	Field   string           `json:"field"` // title, message
+	Matches []HighlightRange `json:"matches"`
+}
+
+type HighlightRange struct {
+	Start  int `json:"start"`
+	Length int `json:"length"`
+}
+
+// NotificationSearchResults is a page of search results, paginated like
+// the inbox
+type NotificationSearchResults struct {
+	Results    []NotificationSearchResult `json:"results"`
+	Query      string                     `json:"query"`
+	Page       int                        `json:"page"`
+	PageSize   int                        `json:"page_size"`
+	Total      int64                      `json:"total"`
+	TotalPages int                        `json:"total_pages"`
+	HasNext    bool                       `json:"has_next"`
+	HasPrev    bool                       `json:"has_prev"`
+}
+
 // ========================
 // Request Models
 // ========================
@@ -65,23 +131,104 @@ type GetNotificationsRequest struct {
 	PageSize int    `json:"page_size"`
 	Type     string `json:"type"`
 	Status   string `json:"status"`
+	CircleID string `json:"circle_id"`
+	Priority string `json:"priority"`
+	GroupBy  string `json:"group_by"` // circle, type; pages then go over groups
+}
+
+// How the inbox can be grouped
+const (
+	NotificationGroupByCircle = "circle"
+	NotificationGroupByType   = "type"
+
+	// Most recent notifications shown in each group
+	NotificationGroupPreviewSize = 5
+)
+
+// NotificationGroup is one section of a grouped inbox, with its most
+// recent notifications
+type NotificationGroup struct {
+	Key           string         `bson:"_id" json:"key"`           // circle ID, empty for notifications outside circles, or type
+	Label         string         `bson:"-" json:"label,omitempty"` // circle name
+	Total         int64          `bson:"total" json:"total"`
+	Unread        int64          `bson:"unread" json:"unread"`
+	LatestAt      time.Time      `bson:"latest_at" json:"latest_at"`
+	Notifications []Notification `bson:"notifications" json:"notifications"`
+}
+
+// GroupedNotifications is a page of inbox groups, most recently active
+// first, with the totals of the whole filtered inbox
+type GroupedNotifications struct {
+	GroupBy     string              `json:"group_by"`
+	Groups      []NotificationGroup `json:"groups"`
+	Total       int64               `json:"total"`
+	Unread      int64               `json:"unread"`
+	Page        int                 `json:"page"`
+	PageSize    int                 `json:"page_size"`
+	TotalGroups int64               `json:"total_groups"`
+	TotalPages  int                 `json:"total_pages"`
+	HasNext     bool                `json:"has_next"`
+	HasPrev     bool                `json:"has_prev"`
+}
+
+// MarkGroupReadRequest marks one inbox group read, within the inbox's
+// priority filter if it had one
+type MarkGroupReadRequest struct {
+	GroupBy  string `json:"group_by" validate:"required,oneof=circle type"`
+	Key      string `json:"key"`
+	Priority string `json:"priority"`
+}
+
+type MarkGroupReadResult struct {
+	MarkedRead int64 `json:"marked_read"`
+	Unread     int64 `json:"unread"` // left in the whole inbox
+}
+
+// SearchNotificationsRequest searches a user's notifications. Archived and
+// snoozed notifications are included unless excluded.
+type SearchNotificationsRequest struct {
+	UserID          string     `json:"user_id"`
+	Query           string     `json:"query"`
+	Type            string     `json:"type"`
+	Status          string     `json:"status"` // read, unread, archived
+	From            *time.Time `json:"from"`
+	To              *time.Time `json:"to"`
+	ExcludeArchived bool       `json:"exclude_archived"`
+	ExcludeSnoozed  bool       `json:"exclude_snoozed"`
+	Page            int        `json:"page"`
+	PageSize        int        `json:"page_size"`
 }
 
 type SendNotificationRequest struct {
-	Recipients       []string               `json:"recipients" validate:"required"`
-	Title            string                 `json:"title" validate:"required"`
-	Message          string                 `json:"message" validate:"required"`
-	Type             string                 `json:"type" validate:"required"`
-	Priority         string                 `json:"priority"`
-	Category         string                 `json:"category"`
-	Data             interface{}            `json:"data,omitempty"`
-	ActionButtons    []ActionButton         `json:"action_buttons,omitempty"`
-	ImageURL         string                 `json:"image_url,omitempty"`
-	DeepLink         string                 `json:"deep_link,omitempty"`
-	ScheduledAt      *time.Time             `json:"scheduled_at,omitempty"`
-	ExpiresAt        *time.Time             `json:"expires_at,omitempty"`
-	DeliveryChannels []string               `json:"delivery_channels"`
-	Metadata         map[string]interface{} `json:"metadata,omitempty"`
+	Recipients       []string                `json:"recipients" validate:"required"`
+	Title            string                  `json:"title" validate:"required"`
+	Message          string                  `json:"message" validate:"required"`
+	Type             string                  `json:"type" validate:"required"`
+	Priority         string                  `json:"priority"`
+	Category         string                  `json:"category"`
+	CircleID         string                  `json:"circle_id,omitempty"` // recipients must still be members
+	Data             interface{}             `json:"data,omitempty"`
+	ActionButtons    []ActionButton          `json:"action_buttons,omitempty"`
+	ImageURL         string                  `json:"image_url,omitempty"`
+	Attachment       *NotificationAttachment `json:"attachment,omitempty"`
+	DeepLink         string                  `json:"deep_link,omitempty"`
+	CollapseKey      string                  `json:"collapse_key,omitempty"`
+	ScheduledAt      *time.Time              `json:"scheduled_at,omitempty"`
+	ExpiresAt        *time.Time              `json:"expires_at,omitempty"`
+	DeliveryChannels []string                `json:"delivery_channels"`
+	Metadata         map[string]interface{}  `json:"metadata,omitempty"`
+
+	// SubjectUserID is the user the notification is about, if any.
+	// Recipients on either side of a block with them are skipped.
+	SubjectUserID string `json:"subject_user_id,omitempty"`
+
+	// Immediate sends the notification right away, skipping its type's
+	// batching window
+	Immediate bool `json:"immediate,omitempty"`
+
+	// The experiment variant the text came from, if any
+	ExperimentID      string `json:"-"`
+	ExperimentVariant string `json:"-"`
 }
 
 type BulkNotificationRequest struct {
@@ -94,6 +241,22 @@ type BulkOperationResult struct {
 	FailedIDs    []string `json:"failed_ids,omitempty"`
 }
 
+// BulkNotificationFilterRequest selects the user's notifications a bulk
+// operation applies to, without listing their IDs. The criteria combine,
+// and at least one is required.
+type BulkNotificationFilterRequest struct {
+	Types         []string `json:"types"`
+	Status        string   `json:"status"` // read, unread, archived
+	CircleID      string   `json:"circle_id"`
+	OlderThanDays int      `json:"older_than_days"`
+	DryRun        bool     `json:"dry_run"` // only count what would be affected
+}
+
+type BulkNotificationFilterResult struct {
+	Affected int64 `json:"affected"` // would be, for a dry run
+	DryRun   bool  `json:"dry_run"`
+}
+
 // ========================
 // Push Notification Models
 // ========================
@@ -115,14 +278,181 @@ type TypePreference struct {
 	Enabled   bool   `bson:"enabled" json:"enabled"`
 	Sound     string `bson:"sound" json:"sound"`
 	Vibration bool   `bson:"vibration" json:"vibration"`
+
+	// Whether notifications of the type may be held for the user's usual
+	// reading time. Unset means yes, for types that are eligible.
+	OptimizeSendTime *bool `bson:"optimize_send_time,omitempty" json:"optimize_send_time,omitempty"`
+
+	// Seconds notifications of the type are held to go out together,
+	// overriding the type's default. 0 sends each right away.
+	BatchWindowSeconds *int `bson:"batch_window_seconds,omitempty" json:"batch_window_seconds,omitempty"`
+}
+
+// Notification types whose delivery may be held for the user's usual
+// reading time. Urgent notifications never are, whatever their type.
+var SendTimeOptimizedTypes = map[string]bool{
+	NotificationTypeDigest:         true,
+	NotificationTypeRecommendation: true,
+	NotificationTypeWeeklySummary:  true,
+}
+
+const (
+	NotificationTypeDigest         = "digest"
+	NotificationTypeRecommendation = "recommendation"
+	NotificationTypeWeeklySummary  = "weekly_summary"
+)
+
+// Sent for new chat messages. A sender's messages to a circle in quick
+// succession are coalesced into one notification.
+const NotificationTypeMessage = "message"
+
+// Default batching windows: how long a notification of the type waits for
+// others of its type to the same user, to go out with them. Types not listed
+// are sent right away, and so are urgent and high priority notifications.
+var DefaultNotificationBatchWindows = map[string]time.Duration{
+	NotificationTypeMessage:     30 * time.Second,
+	NotificationTypePlaceReview: time.Hour,
+}
+
+// Longest batching window, configured or chosen by the user
+const MaxNotificationBatchWindow = 24 * time.Hour
+
+// NotificationBatchPolicy is how notifications of a type are batched for
+// the user
+type NotificationBatchPolicy struct {
+	WindowSeconds int    `json:"window_seconds"` // 0 sends each right away
+	Source        string `json:"source"`         // default, user
 }
 
+// Where a batching window comes from
+const (
+	BatchPolicySourceDefault = "default" // the server's, built-in or configured
+	BatchPolicySourceUser    = "user"
+)
+
+// Send-time optimization bounds
+const (
+	SendTimeMaxDelay   = 12 * time.Hour
+	SendTimeLookback   = 30 * 24 * time.Hour // reads the windows are learned from
+	SendTimeMinSamples = 10                  // reads needed before deferring
+)
+
+// SendTimeProfile is when a user usually reads their notifications, by
+// hour of the day in their timezone
+type SendTimeProfile struct {
+	UserID         string      `json:"user_id"`
+	Timezone       string      `json:"timezone"`
+	Samples        int64       `json:"samples"`
+	HourlyReads    []int64     `json:"hourly_reads"` // 24 entries, from midnight
+	PreferredHours []int       `json:"preferred_hours"`
+	Windows        []TimeRange `json:"windows"`
+	Active         bool        `json:"active"` // enough reads to defer on
+	ComputedAt     time.Time   `json:"computed_at"`
+}
+
+// QuietHours silences notifications during windows of the week, in the
+// user's timezone
 type QuietHours struct {
-	Enabled   bool   `bson:"enabled" json:"enabled"`
+	Enabled  bool               `bson:"enabled" json:"enabled"`
+	Timezone string             `bson:"timezone" json:"timezone"`
+	Windows  []QuietHoursWindow `bson:"windows" json:"windows"`
+
+	// The single window quiet hours had before they had windows. Still
+	// accepted, and turned into a window by Normalize.
+	StartTime string `bson:"start_time,omitempty" json:"start_time,omitempty"` // HH:MM format
+	EndTime   string `bson:"end_time,omitempty" json:"end_time,omitempty"`     // HH:MM format
+	Days      []int  `bson:"days,omitempty" json:"days,omitempty"`             // 0=Sunday, 1=Monday, etc.
+}
+
+// QuietHoursWindow is quiet from its start to its end time on the days
+// given, or every day without any. A window that ends at or before its
+// start runs past midnight and belongs to the day it starts on.
+type QuietHoursWindow struct {
+	Name      string `bson:"name,omitempty" json:"name,omitempty"`
+	Days      []int  `bson:"days" json:"days"`             // 0=Sunday, 1=Monday, etc.
 	StartTime string `bson:"start_time" json:"start_time"` // HH:MM format
 	EndTime   string `bson:"end_time" json:"end_time"`     // HH:MM format
-	Timezone  string `bson:"timezone" json:"timezone"`
-	Days      []int  `bson:"days" json:"days"` // 0=Sunday, 1=Monday, etc.
+}
+
+// Most windows quiet hours can have
+const MaxQuietHoursWindows = 20
+
+// Normalize turns the single window of older settings into a window
+func (q *QuietHours) Normalize() {
+	if len(q.Windows) == 0 && (q.StartTime != "" || q.EndTime != "") {
+		q.Windows = []QuietHoursWindow{{Days: q.Days, StartTime: q.StartTime, EndTime: q.EndTime}}
+	}
+	q.StartTime, q.EndTime, q.Days = "", "", nil
+}
+
+// ActiveAt reports whether the time falls in quiet hours
+func (q QuietHours) ActiveAt(t time.Time) bool {
+	return q.WindowAt(t) != nil
+}
+
+// WindowAt returns the window the time falls in, or nil outside quiet
+// hours. The morning part of a window past midnight is checked against the
+// day before, the day it started on.
+func (q QuietHours) WindowAt(t time.Time) *QuietHoursWindow {
+	if !q.Enabled {
+		return nil
+	}
+
+	if q.Timezone != "" {
+		if location, err := time.LoadLocation(q.Timezone); err == nil {
+			t = t.In(location)
+		}
+	}
+
+	q.Normalize()
+
+	current := t.Hour()*60 + t.Minute()
+	today := int(t.Weekday())
+	yesterday := (today + 6) % 7
+	for i := range q.Windows {
+		window := &q.Windows[i]
+		start, end, ok := window.Minutes()
+		if !ok {
+			continue
+		}
+
+		if start <= end {
+			if current >= start && current < end && window.OnDay(today) {
+				return window
+			}
+			continue
+		}
+		if (current >= start && window.OnDay(today)) || (current < end && window.OnDay(yesterday)) {
+			return window
+		}
+	}
+	return nil
+}
+
+// Minutes returns the window's start and end as minutes from midnight
+func (w QuietHoursWindow) Minutes() (int, int, bool) {
+	start, err := time.Parse("15:04", w.StartTime)
+	if err != nil {
+		return 0, 0, false
+	}
+	end, err := time.Parse("15:04", w.EndTime)
+	if err != nil {
+		return 0, 0, false
+	}
+	return start.Hour()*60 + start.Minute(), end.Hour()*60 + end.Minute(), true
+}
+
+// OnDay reports whether the window starts on the weekday
+func (w QuietHoursWindow) OnDay(day int) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
 }
 
 type UpdatePushSettingsRequest struct {
@@ -191,6 +521,10 @@ type NotificationPreferences struct {
 	Timezone        string                    `bson:"timezone" json:"timezone"`
 	CreatedAt       time.Time                 `bson:"created_at" json:"created_at"`
 	UpdatedAt       time.Time                 `bson:"updated_at" json:"updated_at"`
+
+	// The effective batching of each notification type, with the user's
+	// overrides applied. Worked out on read, never stored.
+	BatchingPolicy map[string]NotificationBatchPolicy `bson:"-" json:"batching_policy,omitempty"`
 }
 
 type NotificationSchedule struct {
@@ -232,6 +566,12 @@ type UpdateTypePreferencesRequest struct {
 	Enabled   *bool  `json:"enabled,omitempty"`
 	Sound     string `json:"sound,omitempty"`
 	Vibration *bool  `json:"vibration,omitempty"`
+
+	OptimizeSendTime *bool `json:"optimize_send_time,omitempty"`
+
+	// Seconds to batch the type's notifications for; -1 goes back to the
+	// type's default
+	BatchWindowSeconds *int `json:"batch_window_seconds,omitempty"`
 }
 
 type UpdateNotificationScheduleRequest struct {
@@ -311,8 +651,14 @@ type SMSSettings struct {
 	TypeSettings map[string]bool `bson:"type_settings" json:"type_settings"`
 	DailyLimit   int             `bson:"daily_limit" json:"daily_limit"`
 	MonthlyLimit int             `bson:"monthly_limit" json:"monthly_limit"`
-	CreatedAt    time.Time       `bson:"created_at" json:"created_at"`
-	UpdatedAt    time.Time       `bson:"updated_at" json:"updated_at"`
+
+	// SMS commands answer texts from the verified number. They return
+	// nothing until the user has opted in.
+	CommandsEnabled   bool       `bson:"commands_enabled" json:"commands_enabled"`
+	CommandsOptedInAt *time.Time `bson:"commands_opted_in_at,omitempty" json:"commands_opted_in_at,omitempty"`
+
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
 }
 
 type UpdateSMSSettingsRequest struct {
@@ -446,34 +792,172 @@ type UpdateChannelRequest struct {
 // ========================
 
 type NotificationRule struct {
-	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	UserID      string             `bson:"user_id" json:"user_id"`
-	Name        string             `bson:"name" json:"name"`
-	Description string             `bson:"description" json:"description"`
-	Conditions  []RuleCondition    `bson:"conditions" json:"conditions"`
-	Actions     []RuleAction       `bson:"actions" json:"actions"`
-	Priority    int                `bson:"priority" json:"priority"`
-	IsActive    bool               `bson:"is_active" json:"is_active"`
-	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
-	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
-}
+	ID          primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	UserID      string               `bson:"user_id" json:"user_id"`
+	Name        string               `bson:"name" json:"name"`
+	Description string               `bson:"description" json:"description"`
+	Conditions  []RuleCondition      `bson:"conditions" json:"conditions"`
+	Actions     []RuleAction         `bson:"actions" json:"actions"`
+	Routing     *NotificationRouting `bson:"routing,omitempty" json:"routing,omitempty"`
+	Priority    int                  `bson:"priority" json:"priority"`
+	IsActive    bool                 `bson:"is_active" json:"is_active"`
+	CreatedAt   time.Time            `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time            `bson:"updated_at" json:"updated_at"`
+}
+
+// NotificationRouting sends notifications of the given types and priorities
+// to the listed channels instead of the ones they were sent with. Empty types
+// or priorities match any. Channels are built-in channels or the IDs of the
+// user's own notification channels.
+type NotificationRouting struct {
+	Types      []string `bson:"types,omitempty" json:"types,omitempty"`
+	Priorities []string `bson:"priorities,omitempty" json:"priorities,omitempty"`
+	Channels   []string `bson:"channels" json:"channels"`
+}
+
+// Built-in delivery channels
+const (
+	DeliveryChannelPush  = "push"
+	DeliveryChannelEmail = "email"
+	DeliveryChannelSMS   = "sms"
+	DeliveryChannelInApp = "in-app"
+)
 
 type CreateRuleRequest struct {
-	Name        string          `json:"name" validate:"required"`
-	Description string          `json:"description"`
-	Conditions  []RuleCondition `json:"conditions" validate:"required"`
-	Actions     []RuleAction    `json:"actions" validate:"required"`
-	Priority    int             `json:"priority"`
+	Name        string               `json:"name" validate:"required"`
+	Description string               `json:"description"`
+	Conditions  []RuleCondition      `json:"conditions" validate:"required_without=Routing"`
+	Actions     []RuleAction         `json:"actions" validate:"required_without=Routing"`
+	Routing     *NotificationRouting `json:"routing,omitempty"`
+	Priority    int                  `json:"priority"`
 }
 
 type UpdateRuleRequest struct {
-	Name        string          `json:"name,omitempty"`
-	Description string          `json:"description,omitempty"`
-	Conditions  []RuleCondition `json:"conditions,omitempty"`
-	Actions     []RuleAction    `json:"actions,omitempty"`
-	Priority    *int            `json:"priority,omitempty"`
-	IsActive    *bool           `json:"is_active,omitempty"`
-}
+	Name        string               `json:"name,omitempty"`
+	Description string               `json:"description,omitempty"`
+	Conditions  []RuleCondition      `json:"conditions,omitempty"`
+	Actions     []RuleAction         `json:"actions,omitempty"`
+	Routing     *NotificationRouting `json:"routing,omitempty"`
+	Priority    *int                 `json:"priority,omitempty"`
+	IsActive    *bool                `json:"is_active,omitempty"`
+}
+
+// ResolveChannelsRequest describes a notification to resolve the delivery
+// channels of, without sending it
+type ResolveChannelsRequest struct {
+	Type             string   `json:"type" validate:"required"`
+	Priority         string   `json:"priority"`
+	DeliveryChannels []string `json:"delivery_channels"`
+}
+
+// ChannelResolution is the channels a notification is delivered on
+type ChannelResolution struct {
+	Type     string           `json:"type"`
+	Priority string           `json:"priority"`
+	Channels []string         `json:"channels"`
+	RuleID   string           `json:"rule_id,omitempty"` // the routing rule that matched, if any
+	RuleName string           `json:"rule_name,omitempty"`
+	Skipped  []SkippedChannel `json:"skipped"`
+}
+
+// SkippedChannel is a channel a notification would have used but can't,
+// e.g. because the phone number is no longer verified
+type SkippedChannel struct {
+	Channel string `json:"channel"`
+	Reason  string `json:"reason"`
+}
+
+// ExplainNotificationRequest describes a notification to explain the
+// delivery of, as if it were sent at At
+type ExplainNotificationRequest struct {
+	Type             string    `json:"type"`
+	CircleID         string    `json:"circle_id,omitempty"`
+	Priority         string    `json:"priority,omitempty"`
+	DeliveryChannels []string  `json:"delivery_channels,omitempty"` // the type's channels when empty
+	At               time.Time `json:"at"`                          // now when zero
+}
+
+// DeliveryDecision is how a notification for a user was, or would be,
+// delivered. The trace lists each layer of their settings in the order it
+// was consulted.
+type DeliveryDecision struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID         string             `bson:"user_id" json:"user_id"`
+	NotificationID string             `bson:"notification_id,omitempty" json:"notification_id,omitempty"`
+	Type           string             `bson:"type" json:"type"`
+	Priority       string             `bson:"priority" json:"priority"`
+	CircleID       string             `bson:"circle_id,omitempty" json:"circle_id,omitempty"`
+	At             time.Time          `bson:"at" json:"at"`
+	DryRun         bool               `bson:"-" json:"dry_run"`
+
+	Outcome       string         `bson:"outcome" json:"outcome"` // delivered, inbox_only, deferred, batched, suppressed
+	Channels      []string       `bson:"channels" json:"channels"`
+	DeferredUntil *time.Time     `bson:"deferred_until,omitempty" json:"deferred_until,omitempty"`
+	SuppressedBy  string         `bson:"suppressed_by,omitempty" json:"suppressed_by,omitempty"` // the layer
+	Trace         []DecisionStep `bson:"trace" json:"trace"`
+
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
+
+// AddStep records a layer that was consulted
+func (d *DeliveryDecision) AddStep(layer string, value interface{}, effect, detail string) {
+	d.Trace = append(d.Trace, DecisionStep{Layer: layer, Value: value, Effect: effect, Detail: detail})
+}
+
+// Suppress ends the decision at a layer that stops the notification
+func (d *DeliveryDecision) Suppress(layer string, value interface{}, detail string) *DeliveryDecision {
+	d.AddStep(layer, value, DecisionEffectSuppressed, detail)
+	d.Outcome = DeliveryOutcomeSuppressed
+	d.SuppressedBy = layer
+	d.Channels = []string{}
+	return d
+}
+
+// DecisionStep is one layer of settings consulted for a delivery decision
+type DecisionStep struct {
+	Layer  string      `bson:"layer" json:"layer"`
+	Value  interface{} `bson:"value,omitempty" json:"value,omitempty"`
+	Effect string      `bson:"effect" json:"effect"`
+	Detail string      `bson:"detail,omitempty" json:"detail,omitempty"`
+}
+
+// Delivery decision outcomes
+const (
+	DeliveryOutcomeDelivered  = "delivered"
+	DeliveryOutcomeInboxOnly  = "inbox_only" // saved, but sent on no channel
+	DeliveryOutcomeDeferred   = "deferred"
+	DeliveryOutcomeBatched    = "batched" // held to go out with others of its type
+	DeliveryOutcomeSuppressed = "suppressed"
+)
+
+// Delivery decision layers, in the order they are consulted
+const (
+	DecisionLayerRecipient    = "recipient"
+	DecisionLayerGlobal       = "global"
+	DecisionLayerType         = "type"
+	DecisionLayerCircle       = "circle"
+	DecisionLayerDoNotDisturb = "do_not_disturb"
+	DecisionLayerRouting      = "routing"
+	DecisionLayerChannels     = "channels"
+	DecisionLayerSendTime     = "send_time"
+	DecisionLayerQuietHours   = "quiet_hours"
+	DecisionLayerBatching     = "batching"
+)
+
+// What a layer did to the delivery
+const (
+	DecisionEffectNone       = "none"
+	DecisionEffectSuppressed = "suppressed"
+	DecisionEffectBypassed   = "bypassed" // it would have suppressed, but the priority overrides it
+	DecisionEffectRerouted   = "rerouted"
+	DecisionEffectFiltered   = "filtered"
+	DecisionEffectInAppOnly  = "in_app_only"
+	DecisionEffectDeferred   = "deferred"
+	DecisionEffectBatched    = "batched"
+)
+
+// How long delivery decisions are kept for explaining past notifications
+const DeliveryDecisionRetention = 30 * 24 * time.Hour
 
 type RuleTestResult struct {
 	RuleID   string      `json:"rule_id"`
@@ -488,6 +972,25 @@ type RuleTestResult struct {
 // Do Not Disturb Models
 // ========================
 
+// What turned do not disturb on or off last
+const (
+	DNDSourceManual = "manual"
+	DNDSourcePlace  = "place"
+)
+
+// Automation rules of the place_dnd type turn on do not disturb while the
+// user is at the rule's place, or any place with its tag, and turn it off
+// when they leave. Their do_not_disturb action's config may narrow it to
+// notification "types" and "circleIds".
+const (
+	AutomationRulePlaceDND = "place_dnd"
+	RuleActionDoNotDisturb = "do_not_disturb"
+)
+
+// Place-driven do not disturb turns itself off after this long, in case
+// the user's exit is never detected
+const MaxPlaceDNDDuration = 4 * time.Hour
+
 type DoNotDisturbStatus struct {
 	UserID     string     `bson:"user_id" json:"user_id"`
 	Enabled    bool       `bson:"enabled" json:"enabled"`
@@ -498,6 +1001,21 @@ type DoNotDisturbStatus struct {
 	QuietHours QuietHours `bson:"quiet_hours" json:"quiet_hours"`
 	Exceptions []string   `bson:"exceptions" json:"exceptions"` // notification types that bypass DND
 	UpdatedAt  time.Time  `bson:"updated_at" json:"updated_at"`
+
+	// Source is manual or place. Place-driven DND names the place and rule
+	// and may only cover some notification types or circles; empty scopes
+	// cover all of them.
+	Source    string   `bson:"source,omitempty" json:"source,omitempty"`
+	PlaceID   string   `bson:"place_id,omitempty" json:"place_id,omitempty"`
+	PlaceName string   `bson:"place_name,omitempty" json:"place_name,omitempty"`
+	RuleID    string   `bson:"rule_id,omitempty" json:"rule_id,omitempty"`
+	Types     []string `bson:"types,omitempty" json:"types,omitempty"`
+	CircleIDs []string `bson:"circle_ids,omitempty" json:"circle_ids,omitempty"`
+}
+
+// IsActive reports whether do not disturb is on at the time
+func (s *DoNotDisturbStatus) IsActive(at time.Time) bool {
+	return s.Enabled && (s.ExpiresAt == nil || s.ExpiresAt.After(at))
 }
 
 type EnableDNDRequest struct {
@@ -505,12 +1023,15 @@ type EnableDNDRequest struct {
 	Reason   string `json:"reason"`
 }
 
+// UpdateQuietHoursRequest replaces the windows when they're given. The
+// single start and end time of older clients replace them with one window.
 type UpdateQuietHoursRequest struct {
-	Enabled   *bool  `json:"enabled,omitempty"`
-	StartTime string `json:"start_time,omitempty"`
-	EndTime   string `json:"end_time,omitempty"`
-	Timezone  string `json:"timezone,omitempty"`
-	Days      []int  `json:"days,omitempty"`
+	Enabled   *bool              `json:"enabled,omitempty"`
+	Windows   []QuietHoursWindow `json:"windows,omitempty"`
+	StartTime string             `json:"start_time,omitempty"`
+	EndTime   string             `json:"end_time,omitempty"`
+	Timezone  string             `json:"timezone,omitempty"`
+	Days      []int              `json:"days,omitempty"`
 }
 
 type UpdateDNDExceptionsRequest struct {
@@ -560,6 +1081,11 @@ type NotificationStats struct {
 	ByType    map[string]int64 `json:"by_type"`
 	ByChannel map[string]int64 `json:"by_channel"`
 	Trends    []StatsTrend     `json:"trends"`
+
+	// Held by send-time optimization, and held by the user. Counted apart,
+	// a deferral is not something the user chose.
+	Deferred int64 `json:"deferred"`
+	Snoozed  int64 `json:"snoozed"`
 }
 
 type DeliveryStats struct {
@@ -635,6 +1161,7 @@ type DeliveryHistory struct {
 	NotificationID string            `json:"notification_id"`
 	Attempts       []DeliveryAttempt `json:"attempts"`
 	Summary        DeliverySummary   `json:"summary"`
+	Decision       *DeliveryDecision `json:"decision,omitempty"` // why it went out the way it did
 }
 
 type DeliveryAttempt struct {
@@ -743,3 +1270,117 @@ type SnoozeResult struct {
 	SnoozedUntil   time.Time `json:"snoozed_until"`
 	Reason         string    `json:"reason"`
 }
+
+// ========================
+// Broadcast Models
+// ========================
+
+const (
+	BroadcastTargetAll    = "all"
+	BroadcastTargetCircle = "circle"
+	BroadcastTargetUsers  = "users"
+
+	BroadcastStatusScheduled = "scheduled"
+	BroadcastStatusRunning   = "running"
+	BroadcastStatusCompleted = "completed"
+	BroadcastStatusFailed    = "failed"
+	BroadcastStatusCancelled = "cancelled"
+
+	BroadcastDeliveryDelivered = "delivered"
+	BroadcastDeliverySkipped   = "skipped"
+	BroadcastDeliveryFailed    = "failed"
+)
+
+// NotificationBroadcast is a notification an admin sends to many users at
+// once. The worker delivers it in batches of users, ordered by ID, and
+// records how far it got in Cursor so another instance can resume it.
+type NotificationBroadcast struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	CreatedBy   string             `bson:"created_by" json:"created_by"`
+	Target      BroadcastTarget    `bson:"target" json:"target"`
+	Template    BroadcastTemplate  `bson:"template" json:"notification"`
+	Status      string             `bson:"status" json:"status"`
+	ScheduledAt time.Time          `bson:"scheduled_at" json:"scheduled_at"`
+	StartedAt   *time.Time         `bson:"started_at,omitempty" json:"started_at,omitempty"`
+	CompletedAt *time.Time         `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+	HeartbeatAt *time.Time         `bson:"heartbeat_at,omitempty" json:"-"`
+	Recipients  []string           `bson:"recipients,omitempty" json:"-"` // circle members when the broadcast started
+	Cursor      string             `bson:"cursor,omitempty" json:"-"`     // last user delivered to
+	Stats       BroadcastStats     `bson:"stats" json:"stats"`
+	ErrorMsg    string             `bson:"error_msg,omitempty" json:"error_msg,omitempty"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+type BroadcastTarget struct {
+	Type     string   `bson:"type" json:"type" validate:"required,oneof=all circle users"`
+	CircleID string   `bson:"circle_id,omitempty" json:"circle_id,omitempty"`
+	UserIDs  []string `bson:"user_ids,omitempty" json:"user_ids,omitempty"`
+}
+
+// BroadcastTemplate is the notification every recipient gets. Title and
+// message can use {firstName}, {lastName} and {fullName}.
+type BroadcastTemplate struct {
+	Title            string                 `bson:"title" json:"title" validate:"required,max=200"`
+	Message          string                 `bson:"message" json:"message" validate:"required,max=2000"`
+	Type             string                 `bson:"type" json:"type" validate:"required"`
+	Priority         string                 `bson:"priority" json:"priority"`
+	Category         string                 `bson:"category,omitempty" json:"category,omitempty"`
+	DeepLink         string                 `bson:"deep_link,omitempty" json:"deep_link,omitempty"`
+	ImageURL         string                 `bson:"image_url,omitempty" json:"image_url,omitempty"`
+	Data             map[string]interface{} `bson:"data,omitempty" json:"data,omitempty"`
+	DeliveryChannels []string               `bson:"delivery_channels" json:"delivery_channels"`
+}
+
+// BroadcastStats counts what happened to each targeted user. Skipped users
+// are counted by reason, e.g. "notifications_disabled" or "rate_limited".
+type BroadcastStats struct {
+	Targeted        int64                            `bson:"targeted" json:"targeted"`
+	Processed       int64                            `bson:"processed" json:"processed"`
+	Delivered       int64                            `bson:"delivered" json:"delivered"`
+	Skipped         int64                            `bson:"skipped" json:"skipped"`
+	Failed          int64                            `bson:"failed" json:"failed"`
+	SkippedByReason map[string]int64                 `bson:"skipped_by_reason,omitempty" json:"skipped_by_reason"`
+	Channels        map[string]BroadcastChannelStats `bson:"channels,omitempty" json:"channels"`
+}
+
+type BroadcastChannelStats struct {
+	Sent   int64 `bson:"sent" json:"sent"`
+	Failed int64 `bson:"failed" json:"failed"`
+}
+
+// BroadcastDelivery is the outcome of a broadcast for one user
+type BroadcastDelivery struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	BroadcastID    primitive.ObjectID `bson:"broadcast_id" json:"broadcast_id"`
+	UserID         string             `bson:"user_id" json:"user_id"`
+	Status         string             `bson:"status" json:"status"`
+	Reason         string             `bson:"reason,omitempty" json:"reason,omitempty"`
+	NotificationID string             `bson:"notification_id,omitempty" json:"notification_id,omitempty"`
+	Channels       []string           `bson:"channels,omitempty" json:"channels,omitempty"`
+	FailedChannels []string           `bson:"failed_channels,omitempty" json:"failed_channels,omitempty"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+}
+
+type CreateBroadcastRequest struct {
+	Target       BroadcastTarget   `json:"target" validate:"required"`
+	Notification BroadcastTemplate `json:"notification" validate:"required"`
+	ScheduledAt  *time.Time        `json:"scheduled_at,omitempty"`
+}
+
+type GetBroadcastDeliveriesRequest struct {
+	BroadcastID string `json:"-"`
+	Status      string `form:"status"`
+	Page        int    `form:"page"`
+	PageSize    int    `form:"page_size"`
+}
+
+type BroadcastDeliveries struct {
+	Deliveries []BroadcastDelivery `json:"deliveries"`
+	Page       int                 `json:"page"`
+	PageSize   int                 `json:"page_size"`
+	Total      int64               `json:"total"`
+	TotalPages int                 `json:"total_pages"`
+	HasNext    bool                `json:"has_next"`
+	HasPrev    bool                `json:"has_prev"`
+}
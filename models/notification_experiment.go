@@ -0,0 +1,186 @@
+package models
+
+import (
+	"hash/fnv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// System notification templates. Operators can replace their text and run
+// experiments on it; a place's own templates still win over them. They
+// use PlaceNotificationVariables.
+const (
+	SystemTemplatePlaceArrival   = "place_arrival"
+	SystemTemplatePlaceDeparture = "place_departure"
+	SystemTemplateHomeArrival    = "home_arrival"
+	SystemTemplateHomeDeparture  = "home_departure"
+)
+
+// DefaultSystemTemplates is the built in text of each system template
+var DefaultSystemTemplates = map[string]string{
+	SystemTemplatePlaceArrival:   DefaultArrivalTemplate,
+	SystemTemplatePlaceDeparture: DefaultDepartureTemplate,
+	SystemTemplateHomeArrival:    DefaultHomeArrivalTemplate,
+	SystemTemplateHomeDeparture:  DefaultHomeDepartureTemplate,
+}
+
+// Place arrivals and departures are sent, and turned off, as geofence
+// notifications
+const NotificationTypeGeofence = "geofence"
+
+// SystemTemplateKey returns the system template of a place arrival or
+// departure, at the member's home or elsewhere
+func SystemTemplateKey(eventType string, home bool) string {
+	switch {
+	case eventType == "departure" && home:
+		return SystemTemplateHomeDeparture
+	case eventType == "departure":
+		return SystemTemplatePlaceDeparture
+	case home:
+		return SystemTemplateHomeArrival
+	}
+	return SystemTemplatePlaceArrival
+}
+
+const (
+	ExperimentStatusScheduled = "scheduled"
+	ExperimentStatusRunning   = "running"
+	ExperimentStatusEnded     = "ended"
+
+	MaxExperimentVariants = 3
+
+	// A variant is stopped once more than this share of the users who got
+	// it turned the notifications off, unless the experiment sets its own
+	DefaultExperimentGuardrailRate = 0.05
+	// ...and only after it reached this many, so a few early opt-outs
+	// don't stop it
+	ExperimentGuardrailMinDelivered = 100
+)
+
+// NotificationExperiment tests content variants of a system template. Each
+// user is assigned to a variant by a hash of their ID and the experiment's,
+// in proportion to the variant weights, and keeps it for the whole
+// experiment. Users of a stopped variant get the base template.
+type NotificationExperiment struct {
+	ID            primitive.ObjectID    `bson:"_id,omitempty" json:"id"`
+	Name          string                `bson:"name" json:"name"`
+	Template      string                `bson:"template" json:"template"` // system template key
+	Variants      []NotificationVariant `bson:"variants" json:"variants"`
+	GuardrailRate float64               `bson:"guardrail_rate" json:"guardrail_rate"`
+	Status        string                `bson:"status" json:"status"`
+	StartsAt      time.Time             `bson:"starts_at" json:"starts_at"`
+	EndsAt        time.Time             `bson:"ends_at" json:"ends_at"`
+	EndedAt       *time.Time            `bson:"ended_at,omitempty" json:"ended_at,omitempty"`
+	WinnerVariant string                `bson:"winner_variant,omitempty" json:"winner_variant,omitempty"` // promoted to the base template
+	CreatedBy     string                `bson:"created_by" json:"created_by"`
+	CreatedAt     time.Time             `bson:"created_at" json:"created_at"`
+	UpdatedAt     time.Time             `bson:"updated_at" json:"updated_at"`
+}
+
+type NotificationVariant struct {
+	ID         string     `bson:"id" json:"id"` // a, b, c
+	Template   string     `bson:"template" json:"template"`
+	Weight     int        `bson:"weight" json:"weight"`
+	StoppedAt  *time.Time `bson:"stopped_at,omitempty" json:"stopped_at,omitempty"`
+	StopReason string     `bson:"stop_reason,omitempty" json:"stop_reason,omitempty"`
+}
+
+// VariantFor returns the variant the user is assigned to
+func (e *NotificationExperiment) VariantFor(userID string) *NotificationVariant {
+	total := 0
+	for _, variant := range e.Variants {
+		total += variant.Weight
+	}
+	if total <= 0 {
+		return nil
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(userID + ":" + e.ID.Hex()))
+	bucket := int(hash.Sum32() % uint32(total))
+
+	for i := range e.Variants {
+		if bucket < e.Variants[i].Weight {
+			return &e.Variants[i]
+		}
+		bucket -= e.Variants[i].Weight
+	}
+	return nil
+}
+
+// Variant returns the experiment's variant with the ID, or nil
+func (e *NotificationExperiment) Variant(variantID string) *NotificationVariant {
+	for i := range e.Variants {
+		if e.Variants[i].ID == variantID {
+			return &e.Variants[i]
+		}
+	}
+	return nil
+}
+
+// NotificationExperimentOptOut records that a user who got a variant
+// turned its notifications off. A user is counted once per experiment.
+type NotificationExperimentOptOut struct {
+	ExperimentID primitive.ObjectID `bson:"experiment_id"`
+	UserID       string             `bson:"user_id"`
+	Variant      string             `bson:"variant"`
+	CreatedAt    time.Time          `bson:"created_at"`
+}
+
+type CreateNotificationExperimentRequest struct {
+	Name          string                       `json:"name" validate:"required,max=100"`
+	Template      string                       `json:"template" validate:"required"`
+	Variants      []NotificationVariantRequest `json:"variants" validate:"required,min=1,max=3,dive"`
+	StartsAt      *time.Time                   `json:"starts_at,omitempty"` // default now
+	EndsAt        time.Time                    `json:"ends_at" validate:"required"`
+	GuardrailRate *float64                     `json:"guardrail_rate,omitempty" validate:"omitempty,gt=0,lte=1"`
+}
+
+type NotificationVariantRequest struct {
+	Template string `json:"template" validate:"required,max=200"`
+	Weight   int    `json:"weight" validate:"min=1,max=100"`
+}
+
+type EndNotificationExperimentRequest struct {
+	// Promoted instead of the best performing variant
+	WinnerVariant string `json:"winner_variant,omitempty"`
+}
+
+// NotificationExperimentResults is the engagement with each variant of an
+// experiment. Opened counts notifications the user read, and ActionTaken
+// those they acted on.
+type NotificationExperimentResults struct {
+	ExperimentID  string                       `json:"experiment_id"`
+	Template      string                       `json:"template"`
+	Status        string                       `json:"status"`
+	StartsAt      time.Time                    `json:"starts_at"`
+	EndsAt        time.Time                    `json:"ends_at"`
+	Leader        string                       `json:"leader,omitempty"` // the variant that would be promoted now
+	WinnerVariant string                       `json:"winner_variant,omitempty"`
+	Variants      []NotificationVariantResults `json:"variants"`
+	Generated     time.Time                    `json:"generated"`
+}
+
+type NotificationVariantResults struct {
+	VariantID   string     `json:"variant_id"`
+	Template    string     `json:"template"`
+	Weight      int        `json:"weight"`
+	StoppedAt   *time.Time `json:"stopped_at,omitempty"`
+	StopReason  string     `json:"stop_reason,omitempty"`
+	Delivered   int64      `json:"delivered"`
+	Opened      int64      `json:"opened"`
+	ActionTaken int64      `json:"action_taken"`
+	Disabled    int64      `json:"disabled"`
+	OpenRate    float64    `json:"open_rate"`
+	ActionRate  float64    `json:"action_rate"`
+	DisableRate float64    `json:"disable_rate"`
+}
+
+// ExperimentVariantCounts is the engagement with a variant's notifications
+type ExperimentVariantCounts struct {
+	Variant     string `bson:"_id"`
+	Delivered   int64  `bson:"delivered"`
+	Opened      int64  `bson:"opened"`
+	ActionTaken int64  `bson:"action_taken"`
+}
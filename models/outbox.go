@@ -0,0 +1,58 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Outbox event kinds
+const (
+	OutboxKindCircleBroadcast = "circle_broadcast" // a WSMessage for a circle's room
+	OutboxKindPlaceEvent      = "place_event"      // an arrival or departure for the member's circles
+	OutboxKindNotification    = "notification"     // a SendNotificationRequest
+)
+
+// Outbox event statuses
+const (
+	OutboxStatusPending    = "pending"
+	OutboxStatusDispatched = "dispatched"
+	OutboxStatusFailed     = "failed"
+)
+
+// OutboxEvent is a side effect of a write, stored right after the write and
+// delivered by the outbox dispatcher until it succeeds. Delivery is at least
+// once: the idempotency key goes out with the event so receivers can drop
+// repeats.
+type OutboxEvent struct {
+	ID             primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Kind           string             `json:"kind" bson:"kind"`
+	IdempotencyKey string             `json:"idempotencyKey" bson:"idempotencyKey"`
+	Payload        []byte             `json:"payload" bson:"payload"` // JSON, shaped by Kind
+	Status         string             `json:"status" bson:"status"`
+	Attempts       int                `json:"attempts" bson:"attempts"`
+	LastError      string             `json:"lastError,omitempty" bson:"lastError,omitempty"`
+
+	// When the event may next be claimed. Claiming pushes it out by the
+	// lease, so an instance that dies mid-delivery hands the event back.
+	AvailableAt  time.Time  `json:"availableAt" bson:"availableAt"`
+	CreatedAt    time.Time  `json:"createdAt" bson:"createdAt"`
+	DispatchedAt *time.Time `json:"dispatchedAt,omitempty" bson:"dispatchedAt,omitempty"`
+}
+
+// OutboxCircleBroadcast is the payload of a circle_broadcast event
+type OutboxCircleBroadcast struct {
+	CircleID string    `json:"circleId"`
+	Message  WSMessage `json:"message"`
+
+	// Limit who in the circle gets the message
+	ExcludeUserIDs []string `json:"excludeUserIds,omitempty"`
+	IncludeUserIDs []string `json:"includeUserIds,omitempty"`
+}
+
+// OutboxPlaceEvent is the payload of a place_event event
+type OutboxPlaceEvent struct {
+	UserID     string       `json:"userId"`
+	CircleIDs  []string     `json:"circleIds"`
+	PlaceEvent WSPlaceEvent `json:"placeEvent"`
+}
@@ -24,7 +24,6 @@ type Place struct {
 	IsPublic      bool               `json:"isPublic" bson:"isPublic"`
 	IsShared      bool               `json:"isShared" bson:"isShared"`
 	IsActive      bool               `json:"isActive" bson:"isActive"`
-	IsFavorite    bool               `json:"isFavorite" bson:"isFavorite"`
 	Tags          []string           `json:"tags" bson:"tags"`
 	Priority      int                `json:"priority" bson:"priority"`
 	Notifications PlaceNotifications `json:"notifications" bson:"notifications"`
@@ -35,6 +34,28 @@ type Place struct {
 	Sharing       PlaceSharing       `json:"sharing" bson:"sharing"`
 	CreatedAt     time.Time          `json:"createdAt" bson:"createdAt"`
 	UpdatedAt     time.Time          `json:"updatedAt" bson:"updatedAt"`
+
+	// Set when the place was created from a template. The template data is
+	// copied, so the place survives the template being unpublished.
+	TemplateID       primitive.ObjectID `json:"templateId,omitempty" bson:"templateId,omitempty"`
+	TemplateAuthorID primitive.ObjectID `json:"templateAuthorId,omitempty" bson:"templateAuthorId,omitempty"`
+
+	// Settings that follow the circle's geofence defaults instead of the
+	// values stored on the place. Places from before circle defaults have
+	// none, so all their settings are their own.
+	InheritedSettings []string `json:"-" bson:"inheritedSettings,omitempty"`
+
+	// Set on create and update when the geofence looks problematic, e.g.
+	// because it overlaps many other places
+	Warnings []string `json:"warnings,omitempty" bson:"-"`
+
+	// The requesting member's private alias for the place, and whether it
+	// is their home
+	Alias  string `json:"alias,omitempty" bson:"-"`
+	IsHome bool   `json:"isHome,omitempty" bson:"-"`
+
+	// Whether the requesting member has the place among their favorites
+	IsFavorite bool `json:"isFavorite" bson:"-"`
 }
 
 type PlaceNotifications struct {
@@ -43,6 +64,65 @@ type PlaceNotifications struct {
 	OnLongStay       bool `json:"onLongStay" bson:"onLongStay"`
 	OnFirstTime      bool `json:"onFirstTime" bson:"onFirstTime"`
 	LongStayDuration int  `json:"longStayDuration" bson:"longStayDuration"` // minutes
+
+	// Members notified besides the circle, and the minutes before the same
+	// member triggers another notification at the place
+	NotifyMembers []string `json:"notifyMembers,omitempty" bson:"notifyMembers,omitempty" validate:"max=50"`
+	Cooldown      int      `json:"cooldown" bson:"cooldown" validate:"min=0,max=1440"`
+
+	// Tells the place's owner when another member's visit there is detected
+	NotifyOwner bool `json:"notifyOwner" bson:"notifyOwner"`
+
+	// Custom notification text using PlaceNotificationVariables. Empty
+	// templates fall back to the default text.
+	ArrivalTemplate   string `json:"arrivalTemplate,omitempty" bson:"arrivalTemplate,omitempty" validate:"max=200"`
+	DepartureTemplate string `json:"departureTemplate,omitempty" bson:"departureTemplate,omitempty" validate:"max=200"`
+}
+
+const (
+	DefaultArrivalTemplate   = "{member} arrived at {place}"
+	DefaultDepartureTemplate = "{member} left {place}"
+)
+
+// PlaceNotificationVariables are the variables place notification templates
+// may use
+var PlaceNotificationVariables = []string{"member", "place", "address", "time"}
+
+// TemplateFor returns the template for an arrival or departure event
+func (n PlaceNotifications) TemplateFor(eventType string) string {
+	if eventType == "departure" {
+		if n.DepartureTemplate != "" {
+			return n.DepartureTemplate
+		}
+		return DefaultDepartureTemplate
+	}
+
+	if n.ArrivalTemplate != "" {
+		return n.ArrivalTemplate
+	}
+	return DefaultArrivalTemplate
+}
+
+// HasTemplateFor reports whether the place has its own template for an
+// arrival or departure event
+func (n PlaceNotifications) HasTemplateFor(eventType string) bool {
+	if eventType == "departure" {
+		return n.DepartureTemplate != ""
+	}
+	return n.ArrivalTemplate != ""
+}
+
+type TestPlaceNotificationRequest struct {
+	EventType string `json:"eventType" binding:"omitempty,oneof=arrival departure"`
+	// Previews an unsaved template instead of the place's own
+	Template string `json:"template,omitempty" validate:"max=200"`
+}
+
+type PlaceNotificationPreview struct {
+	EventType string `json:"eventType"`
+	Template  string `json:"template"`
+	Title     string `json:"title"`
+	Body      string `json:"body"`
 }
 
 type PlaceHours struct {
@@ -50,6 +130,10 @@ type PlaceHours struct {
 	Schedule     map[string]DaySchedule `json:"schedule" bson:"schedule"` // day -> schedule
 	Timezone     string                 `json:"timezone" bson:"timezone"`
 	Overrides    []HoursOverride        `json:"overrides" bson:"overrides"`
+
+	// Overrides that come back, like holidays; the dated ones above take
+	// precedence
+	RecurringOverrides []RecurringHoursOverride `json:"recurringOverrides" bson:"recurringOverrides"`
 }
 
 type HoursOverride struct {
@@ -71,6 +155,89 @@ type GeofenceSettings struct {
 	CustomRadius int    `json:"customRadius,omitempty" bson:"customRadius,omitempty"`
 }
 
+// Geofence settings a place can inherit from its circle
+const (
+	PlaceSettingOnArrival        = "onArrival"
+	PlaceSettingOnDeparture      = "onDeparture"
+	PlaceSettingOnLongStay       = "onLongStay"
+	PlaceSettingLongStayDuration = "longStayDuration"
+	PlaceSettingNotifyMembers    = "notifyMembers"
+	PlaceSettingCooldown         = "cooldown"
+	PlaceSettingDwellTime        = "dwellTime"
+	PlaceSettingExitDelay        = "exitDelay"
+)
+
+var PlaceSettingKeys = []string{
+	PlaceSettingOnArrival,
+	PlaceSettingOnDeparture,
+	PlaceSettingOnLongStay,
+	PlaceSettingLongStayDuration,
+	PlaceSettingNotifyMembers,
+	PlaceSettingCooldown,
+	PlaceSettingDwellTime,
+	PlaceSettingExitDelay,
+}
+
+// Where a place's setting comes from
+const (
+	SettingSourcePlace  = "place"
+	SettingSourceCircle = "circle"
+	SettingSourceSystem = "system"
+)
+
+// GeofenceDefaults are the settings a circle's places use unless they set
+// their own. Unset values fall back to the system defaults.
+type GeofenceDefaults struct {
+	OnArrival        *bool     `json:"onArrival,omitempty" bson:"onArrival,omitempty"`
+	OnDeparture      *bool     `json:"onDeparture,omitempty" bson:"onDeparture,omitempty"`
+	OnLongStay       *bool     `json:"onLongStay,omitempty" bson:"onLongStay,omitempty"`
+	LongStayDuration *int      `json:"longStayDuration,omitempty" bson:"longStayDuration,omitempty" validate:"omitempty,min=5,max=1440"`
+	NotifyMembers    *[]string `json:"notifyMembers,omitempty" bson:"notifyMembers,omitempty" validate:"omitempty,max=50"`
+	Cooldown         *int      `json:"cooldown,omitempty" bson:"cooldown,omitempty" validate:"omitempty,min=0,max=1440"`
+	DwellTime        *int      `json:"dwellTime,omitempty" bson:"dwellTime,omitempty" validate:"omitempty,min=0,max=3600"`
+	ExitDelay        *int      `json:"exitDelay,omitempty" bson:"exitDelay,omitempty" validate:"omitempty,min=0,max=3600"`
+
+	UpdatedBy primitive.ObjectID `json:"updatedBy,omitempty" bson:"updatedBy,omitempty"`
+	UpdatedAt time.Time          `json:"updatedAt,omitempty" bson:"updatedAt,omitempty"`
+}
+
+// PlaceGeofenceSettings are the settings a place's geofence events use, and
+// for each one whether the place sets it or inherits it
+type PlaceGeofenceSettings struct {
+	PlaceID       string             `json:"placeId"`
+	CircleID      string             `json:"circleId,omitempty"`
+	Notifications PlaceNotifications `json:"notifications"`
+	Geofence      GeofenceSettings   `json:"geofence"`
+	Sources       map[string]string  `json:"sources"` // setting -> place, circle or system
+}
+
+// UpdatePlaceGeofenceSettingsRequest overrides the given settings on the
+// place, and makes the settings in Inherit follow the circle again
+type UpdatePlaceGeofenceSettingsRequest struct {
+	OnArrival        *bool     `json:"onArrival,omitempty"`
+	OnDeparture      *bool     `json:"onDeparture,omitempty"`
+	OnLongStay       *bool     `json:"onLongStay,omitempty"`
+	LongStayDuration *int      `json:"longStayDuration,omitempty" validate:"omitempty,min=5,max=1440"`
+	NotifyMembers    *[]string `json:"notifyMembers,omitempty" validate:"omitempty,max=50"`
+	Cooldown         *int      `json:"cooldown,omitempty" validate:"omitempty,min=0,max=1440"`
+	DwellTime        *int      `json:"dwellTime,omitempty" validate:"omitempty,min=0,max=3600"`
+	ExitDelay        *int      `json:"exitDelay,omitempty" validate:"omitempty,min=0,max=3600"`
+	Inherit          []string  `json:"inherit,omitempty"`
+}
+
+// ResetPlaceSettingsRequest makes places of a circle follow its defaults
+// again. No places means all of them, no settings means every setting.
+type ResetPlaceSettingsRequest struct {
+	PlaceIDs []string `json:"placeIds,omitempty" validate:"max=500"`
+	Settings []string `json:"settings,omitempty"`
+}
+
+type ResetPlaceSettingsResult struct {
+	CircleID string   `json:"circleId"`
+	Settings []string `json:"settings"`
+	Updated  int64    `json:"updated"`
+}
+
 type PlaceMetadata struct {
 	Phone      string            `json:"phone,omitempty" bson:"phone,omitempty"`
 	Website    string            `json:"website,omitempty" bson:"website,omitempty"`
@@ -165,6 +332,69 @@ type PlaceReview struct {
 	HelpfulCount int                `json:"helpfulCount" bson:"helpfulCount"`
 	CreatedAt    time.Time          `json:"createdAt" bson:"createdAt"`
 	UpdatedAt    time.Time          `json:"updatedAt" bson:"updatedAt"`
+
+	// Moderation. Hidden reviews are left out of listings and stats.
+	ModerationStatus string              `json:"moderationStatus,omitempty" bson:"moderationStatus,omitempty"`
+	ModerationNote   string              `json:"moderationNote,omitempty" bson:"moderationNote,omitempty"`
+	ReportCount      int                 `json:"reportCount,omitempty" bson:"reportCount,omitempty"`
+	ModeratedBy      *primitive.ObjectID `json:"moderatedBy,omitempty" bson:"moderatedBy,omitempty"`
+	ModeratedAt      *time.Time          `json:"moderatedAt,omitempty" bson:"moderatedAt,omitempty"`
+}
+
+// Place review moderation states
+const (
+	ReviewModerationVisible = "visible"
+	ReviewModerationHidden  = "hidden"
+)
+
+// Place review report states and the actions admins take on them
+const (
+	ReviewReportPending   = "pending"
+	ReviewReportResolved  = "resolved"
+	ReviewReportDismissed = "dismissed"
+
+	ReviewReportActionHide    = "hide"
+	ReviewReportActionRestore = "restore"
+	ReviewReportActionDismiss = "dismiss"
+)
+
+type PlaceReviewReport struct {
+	ID          primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
+	ReviewID    primitive.ObjectID  `json:"reviewId" bson:"reviewId"`
+	PlaceID     primitive.ObjectID  `json:"placeId" bson:"placeId"`
+	ReportedBy  primitive.ObjectID  `json:"reportedBy" bson:"reportedBy"`
+	Reason      string              `json:"reason" bson:"reason"`
+	Description string              `json:"description,omitempty" bson:"description,omitempty"`
+	Status      string              `json:"status" bson:"status"` // pending, resolved, dismissed
+	Action      string              `json:"action,omitempty" bson:"action,omitempty"`
+	Resolution  string              `json:"resolution,omitempty" bson:"resolution,omitempty"`
+	ReviewedBy  *primitive.ObjectID `json:"reviewedBy,omitempty" bson:"reviewedBy,omitempty"`
+	ReviewedAt  *time.Time          `json:"reviewedAt,omitempty" bson:"reviewedAt,omitempty"`
+	CreatedAt   time.Time           `json:"createdAt" bson:"createdAt"`
+	UpdatedAt   time.Time           `json:"updatedAt" bson:"updatedAt"`
+}
+
+type ReportPlaceReviewRequest struct {
+	Reason      string `json:"reason" validate:"required,oneof=spam harassment inappropriate false_information other"`
+	Description string `json:"description,omitempty" validate:"max=500"`
+}
+
+type HandleReviewReportRequest struct {
+	Action     string `json:"action" validate:"required,oneof=hide restore dismiss"`
+	Resolution string `json:"resolution,omitempty" validate:"max=500"`
+}
+
+type PlaceReviewReportsResponse struct {
+	Reports []PlaceReviewReport `json:"reports"`
+	Meta    PaginationMeta      `json:"meta"`
+}
+
+// PlaceReviewStats summarizes a place's visible reviews
+type PlaceReviewStats struct {
+	PlaceID            string           `json:"placeId"`
+	TotalReviews       int64            `json:"totalReviews"`
+	AverageRating      float64          `json:"averageRating"`
+	RatingDistribution map[string]int64 `json:"ratingDistribution"` // "1" to "5"
 }
 
 // ==================== PLACE CHECKINS ====================
@@ -175,12 +405,83 @@ type PlaceCheckin struct {
 	UserID     primitive.ObjectID `json:"userId" bson:"userId"`
 	Message    string             `json:"message,omitempty" bson:"message,omitempty"`
 	Photos     []string           `json:"photos,omitempty" bson:"photos,omitempty"`
-	IsPublic   bool               `json:"isPublic" bson:"isPublic"`
+	IsPublic   bool               `json:"isPublic" bson:"isPublic"` // kept in sync with Visibility for older clients
 	Location   Location           `json:"location" bson:"location"`
 	Companions []string           `json:"companions,omitempty" bson:"companions,omitempty"`
 	Mood       string             `json:"mood,omitempty" bson:"mood,omitempty"`
 	CreatedAt  time.Time          `json:"createdAt" bson:"createdAt"`
 	UpdatedAt  time.Time          `json:"updatedAt" bson:"updatedAt"`
+
+	// Who sees the check-in in listings, leaderboards and feeds. The author
+	// always does, and it counts toward their own stats either way.
+	Visibility string               `json:"visibility" bson:"visibility"`
+	SharedWith []primitive.ObjectID `json:"sharedWith,omitempty" bson:"sharedWith,omitempty"` // selected members
+
+	// A short status, and a photo the author uploaded with its caption
+	Status  string        `json:"status,omitempty" bson:"status,omitempty"`
+	Caption string        `json:"caption,omitempty" bson:"caption,omitempty"`
+	Media   *CheckinMedia `json:"media,omitempty" bson:"media,omitempty"`
+}
+
+// CheckinMedia is the uploaded photo attached to a check-in
+type CheckinMedia struct {
+	ID           primitive.ObjectID `json:"id" bson:"id"`
+	URL          string             `json:"url" bson:"url"`
+	ThumbnailURL string             `json:"thumbnailUrl,omitempty" bson:"thumbnailUrl,omitempty"`
+}
+
+// Check-in visibility levels
+const (
+	CheckinVisibilityCircle   = "circle"   // members of the author's circles
+	CheckinVisibilitySelected = "selected" // the members in SharedWith
+	CheckinVisibilityPrivate  = "private"  // the author only
+
+	NotificationTypeCheckin = "place_checkin"
+
+	NotificationTypePlaceOwnerVisit = "place_owner_visit"
+
+	NotificationTypePlaceReview = "place_review"
+)
+
+type CheckInToPlaceRequest struct {
+	Message    string   `json:"message"`
+	IsPublic   *bool    `json:"isPublic,omitempty"`   // deprecated, use visibility
+	Visibility string   `json:"visibility,omitempty"` // circle (default), selected, private
+	SharedWith []string `json:"sharedWith,omitempty"` // user ids, for selected
+	Location   Location `json:"location"`
+
+	Status  string `json:"status,omitempty" validate:"max=60"` // e.g. "grabbing coffee"
+	Mood    string `json:"mood,omitempty" validate:"max=30"`
+	Caption string `json:"caption,omitempty" validate:"max=280"`
+	MediaID string `json:"mediaId,omitempty"` // an image uploaded through the media endpoint
+}
+
+type UpdateCheckinRequest struct {
+	Visibility string   `json:"visibility" validate:"required"`
+	SharedWith []string `json:"sharedWith,omitempty"`
+}
+
+// CheckinResponse is a check-in with who can see it
+type CheckinResponse struct {
+	*PlaceCheckin
+	Audience CheckinAudience `json:"audience"`
+}
+
+// CheckinAudience is who sees a check-in besides its author
+type CheckinAudience struct {
+	Visibility  string   `json:"visibility"`
+	ViewerCount int      `json:"viewerCount"`
+	Viewers     []string `json:"viewers"` // user ids
+}
+
+type CheckinLeaderboardEntry struct {
+	UserID   string `json:"userId" bson:"_id"`
+	Checkins int64  `json:"checkins" bson:"checkins"`
+	Rank     int    `json:"rank" bson:"-"`
+
+	// From the user's latest check-in the viewer may see
+	Status string `json:"status,omitempty" bson:"status,omitempty"`
+	Mood   string `json:"mood,omitempty" bson:"mood,omitempty"`
 }
 
 // ==================== PLACE COLLECTIONS ====================
@@ -228,21 +529,275 @@ type PlaceTemplate struct {
 	IsPublic    bool               `json:"isPublic" bson:"isPublic"`
 	Template    PlaceTemplateData  `json:"template" bson:"template"`
 	UsageCount  int                `json:"usageCount" bson:"usageCount"`
+	LastUsedAt  *time.Time         `json:"lastUsedAt,omitempty" bson:"lastUsedAt,omitempty"`
 	Rating      float64            `json:"rating" bson:"rating"`
 	Tags        []string           `json:"tags,omitempty" bson:"tags,omitempty"`
 	CreatedAt   time.Time          `json:"createdAt" bson:"createdAt"`
 	UpdatedAt   time.Time          `json:"updatedAt" bson:"updatedAt"`
+
+	// Gallery publishing. The gallery serves a sanitized copy of the
+	// template data, the author's own copy is left untouched.
+	ModerationStatus string             `json:"moderationStatus,omitempty" bson:"moderationStatus,omitempty"`
+	ModerationNote   string             `json:"moderationNote,omitempty" bson:"moderationNote,omitempty"`
+	ReviewedBy       primitive.ObjectID `json:"reviewedBy,omitempty" bson:"reviewedBy,omitempty"`
+	ReviewedAt       *time.Time         `json:"reviewedAt,omitempty" bson:"reviewedAt,omitempty"`
+	PublishedAt      *time.Time         `json:"publishedAt,omitempty" bson:"publishedAt,omitempty"`
+	GalleryTemplate  *PlaceTemplateData `json:"galleryTemplate,omitempty" bson:"galleryTemplate,omitempty"`
 }
 
 type PlaceTemplateData struct {
-	Category        string             `json:"category"`
-	Color           string             `json:"color"`
-	Icon            string             `json:"icon"`
-	Radius          int                `json:"radius"`
-	Notifications   PlaceNotifications `json:"notifications"`
-	Hours           PlaceHours         `json:"hours,omitempty"`
-	Geofence        GeofenceSettings   `json:"geofence"`
-	AutomationRules []AutomationRule   `json:"automationRules,omitempty"`
+	Category        string             `json:"category" bson:"category"`
+	Color           string             `json:"color" bson:"color"`
+	Icon            string             `json:"icon" bson:"icon"`
+	Radius          int                `json:"radius" bson:"radius"`
+	Notifications   PlaceNotifications `json:"notifications" bson:"notifications"`
+	Hours           PlaceHours         `json:"hours,omitempty" bson:"hours,omitempty"`
+	Geofence        GeofenceSettings   `json:"geofence" bson:"geofence"`
+	AutomationRules []AutomationRule   `json:"automationRules,omitempty" bson:"automationRules,omitempty"`
+}
+
+// Place template moderation states
+const (
+	TemplateModerationPending  = "pending"
+	TemplateModerationApproved = "approved"
+	TemplateModerationRejected = "rejected"
+)
+
+// IsInGallery reports whether the template is listed in the shared gallery
+func (t *PlaceTemplate) IsInGallery() bool {
+	return t.IsPublic && t.ModerationStatus == TemplateModerationApproved && t.GalleryTemplate != nil
+}
+
+// PublicView returns the template as gallery users see it, with the
+// sanitized data in place of the author's own
+func (t PlaceTemplate) PublicView() PlaceTemplate {
+	if t.GalleryTemplate != nil {
+		t.Template = *t.GalleryTemplate
+	}
+	t.GalleryTemplate = nil
+	t.ModerationNote = ""
+	t.ReviewedBy = primitive.NilObjectID
+	return t
+}
+
+type CreatePlaceTemplateRequest struct {
+	Name        string            `json:"name" validate:"required,min=1,max=100"`
+	Description string            `json:"description,omitempty" validate:"max=500"`
+	Category    string            `json:"category" validate:"required"`
+	Tags        []string          `json:"tags,omitempty"`
+	Template    PlaceTemplateData `json:"template"`
+}
+
+type UpdatePlaceTemplateRequest struct {
+	Name        *string            `json:"name,omitempty" validate:"omitempty,min=1,max=100"`
+	Description *string            `json:"description,omitempty" validate:"omitempty,max=500"`
+	Category    *string            `json:"category,omitempty"`
+	Tags        []string           `json:"tags,omitempty"`
+	Template    *PlaceTemplateData `json:"template,omitempty"`
+}
+
+type UsePlaceTemplateRequest struct {
+	Name      string  `json:"name" validate:"required,min=1,max=100"`
+	Address   string  `json:"address,omitempty" validate:"max=200"`
+	Latitude  float64 `json:"latitude" validate:"required,gte=-90,lte=90"`
+	Longitude float64 `json:"longitude" validate:"required,gte=-180,lte=180"`
+	Radius    int     `json:"radius,omitempty" validate:"omitempty,min=1"` // bounds depend on the plan
+	Force     bool    `json:"force,omitempty"`
+}
+
+type ModeratePlaceTemplateRequest struct {
+	Status string `json:"status" validate:"required,oneof=approved rejected"`
+	Note   string `json:"note,omitempty" validate:"max=500"`
+}
+
+type GetTemplateGalleryRequest struct {
+	Category string `form:"category"`
+	Tag      string `form:"tag"`
+	SortBy   string `form:"sortBy"` // popular, recent
+	Page     int    `form:"page"`
+	PageSize int    `form:"pageSize"`
+}
+
+type PlaceTemplatesResponse struct {
+	Templates []PlaceTemplate `json:"templates"`
+	Meta      PaginationMeta  `json:"meta"`
+}
+
+// ==================== DEPARTURE REMINDERS ====================
+
+// DepartureReminder reminds a user to leave for a place they travel to
+// regularly, ahead of the time they usually set off. Patterns come from the
+// user's own visits only.
+type DepartureReminder struct {
+	ID             primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID         primitive.ObjectID `json:"userId" bson:"userId"`
+	PlaceID        primitive.ObjectID `json:"placeId" bson:"placeId"` // destination
+	PlaceName      string             `json:"placeName" bson:"placeName"`
+	Enabled        bool               `json:"enabled" bson:"enabled"`
+	LeadMinutes    int                `json:"leadMinutes" bson:"leadMinutes"`
+	Timezone       string             `json:"timezone" bson:"timezone"`
+	Pattern        DeparturePattern   `json:"pattern" bson:"pattern"`
+	NextReminderAt *time.Time         `json:"nextReminderAt,omitempty" bson:"nextReminderAt,omitempty"`
+	LastSentAt     *time.Time         `json:"lastSentAt,omitempty" bson:"lastSentAt,omitempty"`
+	CreatedAt      time.Time          `json:"createdAt" bson:"createdAt"`
+	UpdatedAt      time.Time          `json:"updatedAt" bson:"updatedAt"`
+}
+
+// DeparturePattern holds the typical departure per weekday. Weekdays
+// without enough trips are left out and get no reminder.
+type DeparturePattern struct {
+	OriginPlaceID primitive.ObjectID `json:"originPlaceId,omitempty" bson:"originPlaceId,omitempty"`
+	Days          []DepartureDay     `json:"days" bson:"days"`
+	TripCount     int                `json:"tripCount" bson:"tripCount"`
+	ComputedAt    *time.Time         `json:"computedAt,omitempty" bson:"computedAt,omitempty"`
+}
+
+type DepartureDay struct {
+	Weekday       int    `json:"weekday" bson:"weekday"`             // 0=Sunday
+	DepartureTime string `json:"departureTime" bson:"departureTime"` // HH:MM local
+	TravelMinutes int    `json:"travelMinutes" bson:"travelMinutes"`
+	Trips         int    `json:"trips" bson:"trips"`
+}
+
+const (
+	DefaultDepartureLeadMinutes = 10
+	NotificationTypeDeparture   = "departure_reminder"
+)
+
+type CreateDepartureReminderRequest struct {
+	PlaceID     string `json:"placeId" validate:"required"`
+	LeadMinutes int    `json:"leadMinutes,omitempty" validate:"omitempty,min=1,max=120"`
+	Timezone    string `json:"timezone,omitempty"`
+}
+
+type UpdateDepartureReminderRequest struct {
+	Enabled     *bool   `json:"enabled,omitempty"`
+	LeadMinutes *int    `json:"leadMinutes,omitempty" validate:"omitempty,min=1,max=120"`
+	Timezone    *string `json:"timezone,omitempty"`
+}
+
+// ==================== LOCATION REMINDERS ====================
+
+// LocationReminder notifies its user when they arrive at a place, or at any
+// of their places of a category. One-time reminders are cleared once they
+// fire; recurring ones fire on every arrival outside their cooldown.
+type LocationReminder struct {
+	ID              primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
+	UserID          primitive.ObjectID  `json:"userId" bson:"userId"`
+	PlaceID         *primitive.ObjectID `json:"placeId,omitempty" bson:"placeId,omitempty"`
+	Category        string              `json:"category,omitempty" bson:"category,omitempty"`
+	Message         string              `json:"message" bson:"message"`
+	Recurring       bool                `json:"recurring" bson:"recurring"`
+	Important       bool                `json:"important" bson:"important"` // delivered during quiet hours
+	CooldownMinutes int                 `json:"cooldownMinutes,omitempty" bson:"cooldownMinutes,omitempty"`
+	Active          bool                `json:"active" bson:"active"`
+	TriggerCount    int                 `json:"triggerCount" bson:"triggerCount"`
+	LastTriggeredAt *time.Time          `json:"lastTriggeredAt,omitempty" bson:"lastTriggeredAt,omitempty"`
+	ClearedAt       *time.Time          `json:"clearedAt,omitempty" bson:"clearedAt,omitempty"`
+	CreatedAt       time.Time           `json:"createdAt" bson:"createdAt"`
+	UpdatedAt       time.Time           `json:"updatedAt" bson:"updatedAt"`
+}
+
+const (
+	DefaultLocationReminderCooldown  = 60 // minutes
+	NotificationTypeLocationReminder = "location_reminder"
+)
+
+type CreateLocationReminderRequest struct {
+	PlaceID         string `json:"placeId,omitempty"`
+	Category        string `json:"category,omitempty"`
+	Message         string `json:"message" validate:"required,max=200"`
+	Recurring       bool   `json:"recurring"`
+	Important       bool   `json:"important"`
+	CooldownMinutes int    `json:"cooldownMinutes,omitempty" validate:"omitempty,min=5,max=10080"`
+}
+
+// ==================== DUPLICATE PLACES ====================
+
+// DuplicatePlaceCandidate is an existing place that looks like another one,
+// by distance or by name
+type DuplicatePlaceCandidate struct {
+	PlaceID   string  `json:"placeId"`
+	Name      string  `json:"name"`
+	Address   string  `json:"address,omitempty"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Distance  float64 `json:"distance"` // meters
+	NameMatch bool    `json:"nameMatch"`
+}
+
+// PlaceDuplicateGroup is a set of circle places that are probably the same
+// place. The suggested canonical place is the most visited one.
+type PlaceDuplicateGroup struct {
+	Places               []Place `json:"places"`
+	SuggestedCanonicalID string  `json:"suggestedCanonicalId"`
+	MaxDistance          float64 `json:"maxDistance"` // meters
+	NameMatch            bool    `json:"nameMatch"`
+}
+
+type PlaceDuplicatesReport struct {
+	CircleID  string                `json:"circleId"`
+	Distance  float64               `json:"distance"` // meters
+	Groups    []PlaceDuplicateGroup `json:"groups"`
+	Generated time.Time             `json:"generated"`
+}
+
+type MergePlacesRequest struct {
+	CanonicalPlaceID  string   `json:"canonicalPlaceId" validate:"required"`
+	DuplicatePlaceIDs []string `json:"duplicatePlaceIds" validate:"required,min=1,max=20"`
+}
+
+type MergePlacesResult struct {
+	CanonicalPlaceID string   `json:"canonicalPlaceId"`
+	MergedPlaceIDs   []string `json:"mergedPlaceIds"`
+	VisitsMoved      int64    `json:"visitsMoved"`
+	CheckinsMoved    int64    `json:"checkinsMoved"`
+	RulesMoved       int64    `json:"rulesMoved"`
+}
+
+// PlaceClustersRequest is a map viewport. The bbox is
+// "minLon,minLat,maxLon,maxLat".
+type PlaceClustersRequest struct {
+	BBox string `form:"bbox" validate:"required"`
+	Zoom int    `form:"zoom" validate:"min=0,max=22"`
+}
+
+// PlaceMarker is the part of a place a map needs to draw it
+type PlaceMarker struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	Name      string             `json:"name" bson:"name"`
+	Category  string             `json:"category" bson:"category"`
+	Icon      string             `json:"icon" bson:"icon"`
+	Color     string             `json:"color" bson:"color"`
+	Latitude  float64            `json:"latitude" bson:"latitude"`
+	Longitude float64            `json:"longitude" bson:"longitude"`
+}
+
+// PlaceCluster stands for the places in one cell of the map grid. Its ID
+// names the cell, so it stays the same while the viewport moves.
+type PlaceCluster struct {
+	ID        string    `json:"id"`
+	Latitude  float64   `json:"latitude"` // centroid of the places
+	Longitude float64   `json:"longitude"`
+	Count     int       `json:"count"`
+	Category  string    `json:"category"` // the most common category
+	Bounds    GeoBounds `json:"bounds"`
+}
+
+// PlaceClustersResponse is what a viewport shows: clusters where places are
+// dense, and single places elsewhere or when zoomed in closely
+type PlaceClustersResponse struct {
+	CircleID    string         `json:"circleId"`
+	Zoom        int            `json:"zoom"`
+	Clusters    []PlaceCluster `json:"clusters"`
+	Places      []PlaceMarker  `json:"places"`
+	GeneratedAt time.Time      `json:"generatedAt"`
+}
+
+type PlaceClusterMembers struct {
+	ClusterID string        `json:"clusterId"`
+	CircleID  string        `json:"circleId"`
+	Places    []PlaceMarker `json:"places"`
 }
 
 // ==================== REQUEST/RESPONSE MODELS ====================
@@ -253,7 +808,7 @@ type CreatePlaceRequest struct {
 	Address       string             `json:"address,omitempty" validate:"max=200"`
 	Latitude      float64            `json:"latitude" validate:"required,gte=-90,lte=90"`
 	Longitude     float64            `json:"longitude" validate:"required,gte=-180,lte=180"`
-	Radius        int                `json:"radius" validate:"required,min=10,max=5000"`
+	Radius        int                `json:"radius" validate:"required,min=1"` // bounds depend on the plan
 	Category      string             `json:"category" validate:"required"`
 	Color         string             `json:"color,omitempty"`
 	Icon          string             `json:"icon,omitempty"`
@@ -265,6 +820,16 @@ type CreatePlaceRequest struct {
 	Hours         PlaceHours         `json:"hours,omitempty"`
 	Geofence      GeofenceSettings   `json:"geofence"`
 	Metadata      PlaceMetadata      `json:"metadata,omitempty"`
+
+	// Creates the place for a circle the user is a member of
+	CircleID string `json:"circleId,omitempty"`
+
+	// Creates the place even when similar places already exist
+	Force bool `json:"force,omitempty"`
+
+	// Set by UsePlaceTemplate for attribution, never bound from the body
+	TemplateID       primitive.ObjectID `json:"-"`
+	TemplateAuthorID primitive.ObjectID `json:"-"`
 }
 
 type SearchPlacesRequest struct {
@@ -273,11 +838,61 @@ type SearchPlacesRequest struct {
 	Latitude  float64 `form:"latitude"`
 	Longitude float64 `form:"longitude"`
 	Radius    float64 `form:"radius"`
-	Tags      string  `form:"tags"`
+	Tags      string  `form:"tags"`     // comma-separated
+	TagMatch  string  `form:"tagMatch"` // any (default) or all
 	Page      int     `form:"page"`
 	PageSize  int     `form:"pageSize"`
 }
 
+type PlaceTypeaheadRequest struct {
+	Query     string   `form:"q" validate:"required,max=100"`
+	Latitude  *float64 `form:"lat" validate:"omitempty,min=-90,max=90"`
+	Longitude *float64 `form:"lon" validate:"omitempty,min=-180,max=180"`
+}
+
+// PlaceTypeaheadResult is a lightweight place suggestion. Distance is in
+// meters and only set when the request carried coordinates.
+type PlaceTypeaheadResult struct {
+	ID       primitive.ObjectID `json:"id"`
+	Name     string             `json:"name"`
+	Category string             `json:"category"`
+	Icon     string             `json:"icon"`
+	Distance *float64           `json:"distance,omitempty"`
+}
+
+// GetPlaceTrendsRequest asks for trending or popular places over the last
+// Days days
+type GetPlaceTrendsRequest struct {
+	Days  int `form:"days" validate:"omitempty,min=1,max=365"`
+	Limit int `form:"limit" validate:"omitempty,min=1,max=50"`
+}
+
+// PlaceTrend ranks a place by its visits and check-ins, each weighted by
+// how recent it is: an event loses half its weight every half-life. The
+// period is split in two halves, and RecentDelta is how many more events
+// the recent half had than the one before it.
+type PlaceTrend struct {
+	PlaceID        primitive.ObjectID `json:"placeId"`
+	Name           string             `json:"name"`
+	Category       string             `json:"category"`
+	Icon           string             `json:"icon"`
+	Latitude       float64            `json:"latitude"`
+	Longitude      float64            `json:"longitude"`
+	Score          float64            `json:"score"`
+	Visits         int                `json:"visits"`
+	Checkins       int                `json:"checkins"`
+	RecentEvents   int                `json:"recentEvents"`
+	PreviousEvents int                `json:"previousEvents"`
+	RecentDelta    int                `json:"recentDelta"`
+}
+
+type PlaceTrendsResponse struct {
+	Places        []PlaceTrend `json:"places"`
+	PeriodDays    int          `json:"periodDays"`
+	HalfLifeHours float64      `json:"halfLifeHours"`
+	GeneratedAt   time.Time    `json:"generatedAt"`
+}
+
 type PlaceSearchResponse struct {
 	Places      []PlaceResponse `json:"places"`
 	Meta        PaginationMeta  `json:"meta"`
@@ -289,7 +904,8 @@ type GetPlacesRequest struct {
 	IsShared   *bool   `form:"isShared"`
 	IsActive   *bool   `form:"isActive"`
 	IsFavorite *bool   `form:"isFavorite"`
-	Tags       string  `form:"tags"` // comma-separated
+	Tags       string  `form:"tags"`     // comma-separated
+	TagMatch   string  `form:"tagMatch"` // any (default) or all
 	Latitude   float64 `form:"latitude"`
 	Longitude  float64 `form:"longitude"`
 	Radius     float64 `form:"radius"` // meters
@@ -299,13 +915,17 @@ type GetPlacesRequest struct {
 	SortOrder  string  `form:"sortOrder"`
 }
 
+type ExportPlacesRequest struct {
+	Format string `json:"format" validate:"required,oneof=json csv"`
+}
+
 type UpdatePlaceRequest struct {
 	Name          *string             `json:"name,omitempty" validate:"omitempty,min=1,max=100"`
 	Description   *string             `json:"description,omitempty" validate:"omitempty,max=500"`
 	Address       *string             `json:"address,omitempty" validate:"omitempty,max=200"`
 	Latitude      *float64            `json:"latitude,omitempty" validate:"omitempty,gte=-90,lte=90"`
 	Longitude     *float64            `json:"longitude,omitempty" validate:"omitempty,gte=-180,lte=180"`
-	Radius        *int                `json:"radius,omitempty" validate:"omitempty,min=10,max=5000"`
+	Radius        *int                `json:"radius,omitempty" validate:"omitempty,min=1"` // bounds depend on the plan
 	Category      *string             `json:"category,omitempty"`
 	Color         *string             `json:"color,omitempty"`
 	Icon          *string             `json:"icon,omitempty"`
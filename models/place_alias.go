@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Sent to members whose alias or home was on a place that got deleted
+const NotificationTypePlaceDeleted = "place_deleted"
+
+// Notification text for a member arriving at or leaving their own home,
+// used when the place has no templates of its own
+const (
+	DefaultHomeArrivalTemplate   = "{member} arrived home"
+	DefaultHomeDepartureTemplate = "{member} left home"
+)
+
+// Tags put on trips that start or end at the member's home
+const (
+	TripTagFromHome = "from_home"
+	TripTagToHome   = "to_home"
+)
+
+// PlaceAlias is a member's private label for a place: their own name for
+// it, and whether it is their home. Each member has at most one home.
+type PlaceAlias struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `json:"userId" bson:"userId"`
+	PlaceID   primitive.ObjectID `json:"placeId" bson:"placeId"`
+	Alias     string             `json:"alias,omitempty" bson:"alias,omitempty"`
+	IsHome    bool               `json:"isHome" bson:"isHome"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+	UpdatedAt time.Time          `json:"updatedAt" bson:"updatedAt"`
+}
+
+// PlaceAliasRequest sets the member's alias for a place, or makes it their
+// home. Fields left out are kept; an empty alias removes it.
+type PlaceAliasRequest struct {
+	Alias  *string `json:"alias,omitempty" validate:"omitempty,max=100"`
+	IsHome *bool   `json:"isHome,omitempty"`
+}
@@ -0,0 +1,91 @@
+package models
+
+import "time"
+
+// Export job type of place analytics reports
+const ExportTypePlaceAnalytics = "place_analytics"
+
+const (
+	// Visitor figures of a period or peak time with fewer visitors than
+	// this are left out of reports, so they can't single anyone out
+	MinPlaceAnalyticsVisitors = 3
+
+	// Longest date range a report covers, and the longest reported day by
+	// day; longer ones are reported week by week
+	MaxPlaceAnalyticsDays      = 366
+	MaxDailyPlaceAnalyticsDays = 62
+
+	DefaultPlaceAnalyticsDays = 30
+)
+
+// Reporting periods of place analytics
+const (
+	PlaceAnalyticsPeriodDay  = "day"
+	PlaceAnalyticsPeriodWeek = "week"
+)
+
+type ExportPlaceAnalyticsRequest struct {
+	Format string     `json:"format" validate:"omitempty,oneof=csv pdf"` // csv (default), pdf
+	From   *time.Time `json:"from,omitempty"`                            // defaults to 30 days before To
+	To     *time.Time `json:"to,omitempty"`                              // defaults to now
+}
+
+// PlaceVisitBucket adds up the visits of a period, an hour of the day or a
+// day of the week
+type PlaceVisitBucket struct {
+	Start    time.Time `bson:"start"`
+	Hour     int       `bson:"hour"`    // 0-23
+	Weekday  int       `bson:"weekday"` // 1 Monday to 7 Sunday
+	Visits   int       `bson:"visits"`
+	Visitors int       `bson:"visitors"`
+	Duration int64     `bson:"duration"` // seconds, all visits together
+}
+
+// PlaceVisitAnalytics is the visits to a place over a date range, in the
+// place's timezone
+type PlaceVisitAnalytics struct {
+	Totals   PlaceVisitBucket   `bson:"totals"`
+	Periods  []PlaceVisitBucket `bson:"periods"`
+	Hours    []PlaceVisitBucket `bson:"hours"`
+	Weekdays []PlaceVisitBucket `bson:"weekdays"`
+}
+
+// PlaceActivityBucket counts the check-ins or reviews of a period
+type PlaceActivityBucket struct {
+	Start     time.Time `bson:"_id"`
+	Count     int       `bson:"count"`
+	RatingSum int       `bson:"ratingSum"` // reviews only
+}
+
+// PlaceAnalyticsReport is what a place analytics export holds. Visitor
+// figures under MinPlaceAnalyticsVisitors are nil.
+type PlaceAnalyticsReport struct {
+	PlaceID   string    `json:"placeId"`
+	PlaceName string    `json:"placeName"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Timezone  string    `json:"timezone"`
+	Period    string    `json:"period"` // day, week
+
+	Totals   PlaceAnalyticsPeriod   `json:"totals"`
+	Periods  []PlaceAnalyticsPeriod `json:"periods"`
+	Hours    []PlaceAnalyticsPeak   `json:"hours"`    // 0-23
+	Weekdays []PlaceAnalyticsPeak   `json:"weekdays"` // Monday first
+}
+
+type PlaceAnalyticsPeriod struct {
+	Start           time.Time `json:"start"`
+	Visits          *int      `json:"visits"`
+	UniqueVisitors  *int      `json:"uniqueVisitors"`
+	AverageDuration *int64    `json:"averageDuration"` // seconds
+	Checkins        int       `json:"checkins"`
+	Reviews         int       `json:"reviews"`
+	AverageRating   *float64  `json:"averageRating"`
+}
+
+// PlaceAnalyticsPeak is the visits in an hour of the day or on a day of
+// the week, across the whole range
+type PlaceAnalyticsPeak struct {
+	Label  string `json:"label"`
+	Visits *int   `json:"visits"`
+}
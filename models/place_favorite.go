@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PlaceFavorite marks a place as one of a member's favorites. Favorites
+// are private to the member, also on places shared with their circles.
+type PlaceFavorite struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `json:"userId" bson:"userId"`
+	PlaceID   primitive.ObjectID `json:"placeId" bson:"placeId"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+type GetFavoritePlacesRequest struct {
+	Page     int `form:"page"`
+	PageSize int `form:"pageSize"`
+}
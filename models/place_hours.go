@@ -0,0 +1,172 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// How specific an hours override is. When several fall on the same day the
+// most specific one applies, so a dated override beats "every December 24",
+// which beats "every public holiday", which beats "every last Friday".
+const (
+	HoursOverrideMonthly = 1 // a day of every month
+	HoursOverrideHoliday = 2 // the days of a holiday set
+	HoursOverrideYearly  = 3 // a day of one month, every year
+	HoursOverrideDated   = 4 // a single date
+)
+
+// Where a day's hours come from
+const (
+	HoursSourceRegular   = "regular"
+	HoursSourceOverride  = "override"
+	HoursSourceRecurring = "recurring_override"
+	HoursSourceHoliday   = "holiday"
+)
+
+// Recurring overrides a place can have
+const MaxRecurringHoursOverrides = 50
+
+// Recurring overrides are checked for clashes over this many years, after
+// which weekdays fall on the same dates again
+const HoursOverrideCheckYears = 28
+
+// Days ahead searched for a place's next opening or closing
+const HoursTransitionHorizonDays = 14
+
+var Weekdays = []string{"sunday", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday"}
+
+// HoursRecurrence picks the day a recurring override falls on: a day of the
+// month, or the nth weekday of it. Without a month it falls on that day of
+// every month.
+type HoursRecurrence struct {
+	Month   int    `json:"month,omitempty" bson:"month,omitempty" validate:"min=0,max=12"`
+	Day     int    `json:"day,omitempty" bson:"day,omitempty" validate:"min=0,max=31"`
+	Weekday string `json:"weekday,omitempty" bson:"weekday,omitempty" validate:"omitempty,oneof=monday tuesday wednesday thursday friday saturday sunday"`
+	Week    int    `json:"week,omitempty" bson:"week,omitempty" validate:"min=-1,max=5"` // -1 for the last
+}
+
+// Matches reports whether the recurrence falls on the date
+func (r HoursRecurrence) Matches(date time.Time) bool {
+	if r.Month != 0 && time.Month(r.Month) != date.Month() {
+		return false
+	}
+	if r.Day != 0 {
+		return date.Day() == r.Day
+	}
+	if Weekdays[date.Weekday()] != r.Weekday {
+		return false
+	}
+	if r.Week == -1 {
+		return date.AddDate(0, 0, 7).Month() != date.Month()
+	}
+	return (date.Day()-1)/7+1 == r.Week
+}
+
+// Specificity is how specific an override on the recurrence is
+func (r HoursRecurrence) Specificity() int {
+	if r.Month == 0 {
+		return HoursOverrideMonthly
+	}
+	return HoursOverrideYearly
+}
+
+// RecurringHoursOverride changes a place's hours on the days of a
+// recurrence, or on the holidays of a holiday set
+type RecurringHoursOverride struct {
+	ID           primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
+	Name         string              `json:"name" bson:"name"`
+	Recurrence   *HoursRecurrence    `json:"recurrence,omitempty" bson:"recurrence,omitempty"`
+	HolidaySetID *primitive.ObjectID `json:"holidaySetId,omitempty" bson:"holidaySetId,omitempty"`
+	IsOpen       bool                `json:"isOpen" bson:"isOpen"`
+	StartTime    string              `json:"startTime,omitempty" bson:"startTime,omitempty"`
+	EndTime      string              `json:"endTime,omitempty" bson:"endTime,omitempty"`
+	Note         string              `json:"note,omitempty" bson:"note,omitempty"`
+	CreatedAt    time.Time           `json:"createdAt" bson:"createdAt"`
+}
+
+// HolidaySet is a named list of holidays that places' hours can follow,
+// such as a country's public holidays
+type HolidaySet struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `json:"userId" bson:"userId"`
+	Name      string             `json:"name" bson:"name"`
+	Holidays  []Holiday          `json:"holidays" bson:"holidays"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+	UpdatedAt time.Time          `json:"updatedAt" bson:"updatedAt"`
+}
+
+// Holiday falls on a date, or on the same day every year. Holidays that
+// move, like Easter, are listed by date.
+type Holiday struct {
+	Name       string           `json:"name" bson:"name" validate:"required,max=100"`
+	Date       string           `json:"date,omitempty" bson:"date,omitempty" validate:"omitempty,datetime=2006-01-02"`
+	Recurrence *HoursRecurrence `json:"recurrence,omitempty" bson:"recurrence,omitempty"`
+}
+
+// Matches reports whether the holiday falls on the date
+func (h Holiday) Matches(date time.Time) bool {
+	if h.Date != "" {
+		return h.Date == date.Format("2006-01-02")
+	}
+	return h.Recurrence != nil && h.Recurrence.Matches(date)
+}
+
+// PlaceDayHours is how a place is open on one day, after overrides
+type PlaceDayHours struct {
+	Date         string              `json:"date"` // YYYY-MM-DD
+	IsOpen       bool                `json:"isOpen"`
+	StartTime    string              `json:"startTime,omitempty"`
+	EndTime      string              `json:"endTime,omitempty"`
+	Source       string              `json:"source"`
+	OverrideID   *primitive.ObjectID `json:"overrideId,omitempty"`
+	OverrideName string              `json:"overrideName,omitempty"`
+	Holiday      string              `json:"holiday,omitempty"`
+	Note         string              `json:"note,omitempty"`
+}
+
+// HoursTransition is when a place next opens or closes
+type HoursTransition struct {
+	At     time.Time `json:"at"`
+	IsOpen bool      `json:"isOpen"` // after the transition
+	Source string    `json:"source"`
+}
+
+type PlaceHoursStatus struct {
+	IsOpen         bool             `json:"isOpen"`
+	Status         string           `json:"status"` // open, closed
+	Timezone       string           `json:"timezone"`
+	Today          PlaceDayHours    `json:"today"`
+	NextTransition *HoursTransition `json:"nextTransition,omitempty"` // none within the horizon
+}
+
+type UpdatePlaceHoursRequest struct {
+	IsAlwaysOpen *bool                  `json:"isAlwaysOpen,omitempty"`
+	Schedule     map[string]DaySchedule `json:"schedule,omitempty"` // day -> schedule
+	Timezone     *string                `json:"timezone,omitempty"`
+}
+
+type CreateHoursOverrideRequest struct {
+	Date      string `json:"date" validate:"required,datetime=2006-01-02"`
+	IsOpen    bool   `json:"isOpen"`
+	StartTime string `json:"startTime,omitempty" validate:"omitempty,datetime=15:04"`
+	EndTime   string `json:"endTime,omitempty" validate:"omitempty,datetime=15:04"`
+	Note      string `json:"note,omitempty" validate:"max=200"`
+}
+
+// CreateRecurringHoursOverrideRequest needs either a recurrence or a
+// holiday set
+type CreateRecurringHoursOverrideRequest struct {
+	Name         string           `json:"name" validate:"required,max=100"`
+	Recurrence   *HoursRecurrence `json:"recurrence,omitempty"`
+	HolidaySetID string           `json:"holidaySetId,omitempty"`
+	IsOpen       bool             `json:"isOpen"`
+	StartTime    string           `json:"startTime,omitempty" validate:"omitempty,datetime=15:04"`
+	EndTime      string           `json:"endTime,omitempty" validate:"omitempty,datetime=15:04"`
+	Note         string           `json:"note,omitempty" validate:"max=200"`
+}
+
+type HolidaySetRequest struct {
+	Name     string    `json:"name" validate:"required,max=100"`
+	Holidays []Holiday `json:"holidays" validate:"max=100,dive"`
+}
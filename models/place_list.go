@@ -0,0 +1,111 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// List statuses. A list is completed once every item on it is checked, and
+// archived a week after that.
+const (
+	PlaceListStatusActive    = "active"
+	PlaceListStatusCompleted = "completed"
+	PlaceListStatusArchived  = "archived"
+)
+
+// Who may add, change and remove a list's items. Its creator and the
+// circle's admins always can, and any member can check items off.
+const (
+	PlaceListEditMembers = "members"
+	PlaceListEditAdmins  = "admins"
+)
+
+const (
+	PlaceListArchiveAfter = 7 * 24 * time.Hour
+	MaxPlaceListItems     = 200
+	MaxCirclePlaceLists   = 100 // not counting archived ones
+
+	NotificationTypePlaceListNudge = "place_list_nudge"
+)
+
+// PlaceList is a shared shopping or errand list of a circle, optionally
+// linked to one of its places. Members arriving at the place are nudged
+// about the items still to get.
+type PlaceList struct {
+	ID         primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
+	CircleID   primitive.ObjectID  `json:"circleId" bson:"circleId"`
+	PlaceID    *primitive.ObjectID `json:"placeId,omitempty" bson:"placeId,omitempty"`
+	PlaceName  string              `json:"placeName,omitempty" bson:"placeName,omitempty"`
+	Name       string              `json:"name" bson:"name"`
+	EditableBy string              `json:"editableBy" bson:"editableBy"` // members, admins
+	Status     string              `json:"status" bson:"status"`
+	Items      []PlaceListItem     `json:"items" bson:"items"`
+
+	CreatedBy   primitive.ObjectID `json:"createdBy" bson:"createdBy"`
+	CompletedAt *time.Time         `json:"completedAt,omitempty" bson:"completedAt,omitempty"`
+	ArchivedAt  *time.Time         `json:"archivedAt,omitempty" bson:"archivedAt,omitempty"`
+	CreatedAt   time.Time          `json:"createdAt" bson:"createdAt"`
+	UpdatedAt   time.Time          `json:"updatedAt" bson:"updatedAt"`
+}
+
+// UncheckedCount is how many items are still to get
+func (l *PlaceList) UncheckedCount() int {
+	count := 0
+	for _, item := range l.Items {
+		if !item.Checked {
+			count++
+		}
+	}
+	return count
+}
+
+// Item returns the list's item with the ID, or nil
+func (l *PlaceList) Item(itemID primitive.ObjectID) *PlaceListItem {
+	for i := range l.Items {
+		if l.Items[i].ID == itemID {
+			return &l.Items[i]
+		}
+	}
+	return nil
+}
+
+type PlaceListItem struct {
+	ID        primitive.ObjectID  `json:"id" bson:"_id"`
+	Text      string              `json:"text" bson:"text"`
+	Quantity  string              `json:"quantity,omitempty" bson:"quantity,omitempty"` // e.g. "2", "1 lb"
+	Checked   bool                `json:"checked" bson:"checked"`
+	CheckedBy *primitive.ObjectID `json:"checkedBy,omitempty" bson:"checkedBy,omitempty"`
+	CheckedAt *time.Time          `json:"checkedAt,omitempty" bson:"checkedAt,omitempty"`
+	AddedBy   primitive.ObjectID  `json:"addedBy" bson:"addedBy"`
+	AddedAt   time.Time           `json:"addedAt" bson:"addedAt"`
+}
+
+type CreatePlaceListRequest struct {
+	Name       string                 `json:"name" validate:"required,max=100"`
+	PlaceID    string                 `json:"placeId,omitempty"`
+	EditableBy string                 `json:"editableBy,omitempty" validate:"omitempty,oneof=members admins"`
+	Items      []PlaceListItemRequest `json:"items,omitempty" validate:"max=200,dive"`
+}
+
+type UpdatePlaceListRequest struct {
+	Name       *string `json:"name,omitempty" validate:"omitempty,min=1,max=100"`
+	PlaceID    *string `json:"placeId,omitempty"` // empty unlinks the place
+	EditableBy *string `json:"editableBy,omitempty" validate:"omitempty,oneof=members admins"`
+}
+
+type PlaceListItemRequest struct {
+	Text     string `json:"text" validate:"required,max=200"`
+	Quantity string `json:"quantity,omitempty" validate:"max=50"`
+}
+
+type UpdatePlaceListItemRequest struct {
+	Text     *string `json:"text,omitempty" validate:"omitempty,min=1,max=200"`
+	Quantity *string `json:"quantity,omitempty" validate:"omitempty,max=50"`
+	Checked  *bool   `json:"checked,omitempty"`
+}
+
+type GetPlaceListsRequest struct {
+	IncludeArchived bool   `form:"includeArchived"`
+	PlaceID         string `form:"placeId"`
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	PlaceSuggestionStatusSuggested = "suggested"
+	PlaceSuggestionStatusAccepted  = "accepted"
+	PlaceSuggestionStatusDismissed = "dismissed"
+)
+
+// PlaceSuggestion is somewhere a user keeps staying that none of their
+// places covers. It's private to the user and only keeps the centre of
+// their stays, not the locations it was found from.
+type PlaceSuggestion struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `json:"userId" bson:"userId"`
+	Latitude  float64            `json:"latitude" bson:"latitude"`
+	Longitude float64            `json:"longitude" bson:"longitude"`
+	Radius    int                `json:"radius" bson:"radius"` // meters, covering the stays
+
+	// From the addresses the user's device reverse geocoded during the stays
+	ProposedName string `json:"proposedName" bson:"proposedName"`
+	Address      string `json:"address,omitempty" bson:"address,omitempty"`
+
+	// Stays in the analyzed history, on how many different days
+	VisitCount    int       `json:"visitCount" bson:"visitCount"`
+	VisitDays     int       `json:"visitDays" bson:"visitDays"`
+	VisitsPerWeek float64   `json:"visitsPerWeek" bson:"visitsPerWeek"`
+	TotalDuration int64     `json:"totalDuration" bson:"totalDuration"` // seconds
+	FirstVisit    time.Time `json:"firstVisit" bson:"firstVisit"`
+	LastVisit     time.Time `json:"lastVisit" bson:"lastVisit"`
+
+	Status    string              `json:"status" bson:"status"`
+	PlaceID   *primitive.ObjectID `json:"placeId,omitempty" bson:"placeId,omitempty"` // once accepted
+	CreatedAt time.Time           `json:"createdAt" bson:"createdAt"`
+	UpdatedAt time.Time           `json:"updatedAt" bson:"updatedAt"`
+}
+
+// AcceptPlaceSuggestionRequest creates a place from a suggestion. Unset
+// fields take the suggestion's name and radius.
+type AcceptPlaceSuggestionRequest struct {
+	Name     string `json:"name,omitempty" validate:"omitempty,min=1,max=100"`
+	Category string `json:"category,omitempty" validate:"omitempty,max=50"`
+	Radius   int    `json:"radius,omitempty" validate:"omitempty,min=1"`
+	CircleID string `json:"circleId,omitempty" validate:"omitempty,len=24,hexadecimal"`
+	Force    bool   `json:"force,omitempty"` // even when similar places exist
+}
+
+type AcceptPlaceSuggestionResponse struct {
+	Suggestion PlaceSuggestion `json:"suggestion"`
+	Place      *Place          `json:"place"`
+}
@@ -0,0 +1,92 @@
+package models
+
+import (
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	MaxPlaceTags      = 10
+	MaxPlaceTagLength = 30 // characters
+
+	// Most places a bulk update changes at once
+	MaxBulkPlaceUpdates = 500
+)
+
+// Automation rule condition that matches places carrying a tag, so one rule
+// covers e.g. every place tagged "school"
+const RuleConditionPlaceTag = "place_tag"
+
+// NormalizePlaceTag lowercases the tag and trims its spaces
+func NormalizePlaceTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// SplitPlaceTags parses a comma-separated tag filter, normalized and
+// without empty entries
+func SplitPlaceTags(raw string) []string {
+	var tags []string
+	for _, tag := range strings.Split(raw, ",") {
+		if tag = NormalizePlaceTag(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// PlaceTagUsage is a tag of the user's places and how many carry it
+type PlaceTagUsage struct {
+	Tag   string `json:"tag" bson:"_id"`
+	Count int    `json:"count" bson:"count"`
+}
+
+type GetPlaceTagsRequest struct {
+	Prefix string `form:"prefix" validate:"max=30"`
+	Limit  int    `form:"limit" validate:"omitempty,min=1,max=100"`
+}
+
+type RenamePlaceTagRequest struct {
+	Name string `json:"name" validate:"required"`
+}
+
+// RenamePlaceTagResult counts the places and automation rules that had the
+// tag renamed
+type RenamePlaceTagResult struct {
+	Tag    string `json:"tag"`
+	Places int64  `json:"places"`
+	Rules  int64  `json:"rules"`
+}
+
+// BulkUpdatePlacesRequest changes the user's places picked by ID, by tags,
+// by category, or by several of them at once. Tags match places carrying
+// any of them, or all of them with TagMatch "all".
+type BulkUpdatePlacesRequest struct {
+	PlaceIDs []string `json:"placeIds,omitempty" validate:"max=500"`
+	Tags     []string `json:"tags,omitempty"`
+	TagMatch string   `json:"tagMatch,omitempty" validate:"omitempty,oneof=any all"`
+	Category string   `json:"category,omitempty"`
+
+	Update BulkPlaceUpdate `json:"update"`
+}
+
+type BulkPlaceUpdate struct {
+	Category   *string  `json:"category,omitempty"`
+	Color      *string  `json:"color,omitempty"`
+	Icon       *string  `json:"icon,omitempty"`
+	IsActive   *bool    `json:"isActive,omitempty"`
+	IsFavorite *bool    `json:"isFavorite,omitempty"`
+	AddTags    []string `json:"addTags,omitempty"`
+	RemoveTags []string `json:"removeTags,omitempty"`
+}
+
+type BulkUpdatePlacesResult struct {
+	Matched int                  `json:"matched"`
+	Updated int                  `json:"updated"`
+	Failed  []BulkPlaceUpdateErr `json:"failed,omitempty"`
+}
+
+type BulkPlaceUpdateErr struct {
+	PlaceID primitive.ObjectID `json:"placeId"`
+	Reason  string             `json:"reason"`
+}
@@ -0,0 +1,96 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Versions kept per place. Older ones are pruned.
+const MaxPlaceVersions = 50
+
+// What produced a place version
+const (
+	// The place as it was before its first recorded change
+	PlaceVersionBaseline      = "baseline"
+	PlaceVersionUpdate        = "update"
+	PlaceVersionNotifications = "notifications"
+	PlaceVersionSettings      = "geofence_settings"
+	PlaceVersionHours         = "hours"
+	PlaceVersionRestore       = "restore"
+)
+
+// PlaceVersion records one change to a place: the fields that changed and
+// the place's versioned fields after it
+type PlaceVersion struct {
+	ID           primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
+	PlaceID      primitive.ObjectID  `json:"placeId" bson:"placeId"`
+	Version      int                 `json:"version" bson:"version"`
+	Action       string              `json:"action" bson:"action"`
+	ActorID      *primitive.ObjectID `json:"actorId,omitempty" bson:"actorId,omitempty"` // none on baselines
+	RestoredFrom *primitive.ObjectID `json:"restoredFrom,omitempty" bson:"restoredFrom,omitempty"`
+	Changes      []PlaceFieldChange  `json:"changes" bson:"changes"`
+	Snapshot     PlaceSnapshot       `json:"snapshot" bson:"snapshot"`
+	CreatedAt    time.Time           `json:"createdAt" bson:"createdAt"`
+}
+
+// PlaceFieldChange is one field of a version's diff. Nested settings are
+// named by path, e.g. notifications.onArrival.
+type PlaceFieldChange struct {
+	Field    string      `json:"field" bson:"field"`
+	OldValue interface{} `json:"oldValue" bson:"oldValue"`
+	NewValue interface{} `json:"newValue" bson:"newValue"`
+}
+
+// PlaceSnapshot holds the versioned fields of a place
+type PlaceSnapshot struct {
+	Name        string   `json:"name" bson:"name"`
+	Description string   `json:"description" bson:"description"`
+	Address     string   `json:"address" bson:"address"`
+	Category    string   `json:"category" bson:"category"`
+	Color       string   `json:"color" bson:"color"`
+	Icon        string   `json:"icon" bson:"icon"`
+	Tags        []string `json:"tags" bson:"tags"`
+	Priority    int      `json:"priority" bson:"priority"`
+	IsPublic    bool     `json:"isPublic" bson:"isPublic"`
+	IsShared    bool     `json:"isShared" bson:"isShared"`
+	IsActive    bool     `json:"isActive" bson:"isActive"`
+
+	// Geometry and settings, which a restore brings back
+	Latitude          float64            `json:"latitude" bson:"latitude"`
+	Longitude         float64            `json:"longitude" bson:"longitude"`
+	Radius            int                `json:"radius" bson:"radius"`
+	Geofence          GeofenceSettings   `json:"geofence" bson:"geofence"`
+	Notifications     PlaceNotifications `json:"notifications" bson:"notifications"`
+	Hours             PlaceHours         `json:"hours" bson:"hours"`
+	InheritedSettings []string           `json:"inheritedSettings" bson:"inheritedSettings"`
+}
+
+func NewPlaceSnapshot(place *Place) PlaceSnapshot {
+	return PlaceSnapshot{
+		Name:        place.Name,
+		Description: place.Description,
+		Address:     place.Address,
+		Category:    place.Category,
+		Color:       place.Color,
+		Icon:        place.Icon,
+		Tags:        place.Tags,
+		Priority:    place.Priority,
+		IsPublic:    place.IsPublic,
+		IsShared:    place.IsShared,
+		IsActive:    place.IsActive,
+
+		Latitude:          place.Latitude,
+		Longitude:         place.Longitude,
+		Radius:            place.Radius,
+		Geofence:          place.Geofence,
+		Notifications:     place.Notifications,
+		Hours:             place.Hours,
+		InheritedSettings: place.InheritedSettings,
+	}
+}
+
+type PlaceVersionsResponse struct {
+	Versions []PlaceVersion `json:"versions"` // newest first
+	Meta     PaginationMeta `json:"meta"`
+}
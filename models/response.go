@@ -4,12 +4,13 @@ import "time"
 
 // Standard API Response wrapper
 type APIResponse struct {
-	Success   bool        `json:"success"`
-	Message   string      `json:"message"`
-	Data      interface{} `json:"data,omitempty"`
-	Error     *APIError   `json:"error,omitempty"`
-	Meta      *MetaData   `json:"meta,omitempty"`
-	Timestamp time.Time   `json:"timestamp"`
+	Success   bool         `json:"success"`
+	Message   string       `json:"message"`
+	Data      interface{}  `json:"data,omitempty"`
+	Error     *APIError    `json:"error,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"` // fields that failed validation
+	Meta      *MetaData    `json:"meta,omitempty"`
+	Timestamp time.Time    `json:"timestamp"`
 }
 
 type APIError struct {
@@ -19,6 +20,15 @@ type APIError struct {
 	Field   string      `json:"field,omitempty"`
 }
 
+// FieldError is a request field that failed validation, named by its JSON
+// path, and the rule it broke
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule,omitempty"`
+	Code    string `json:"code,omitempty"` // machine readable reason, e.g. PASSWORD_BREACHED
+	Message string `json:"message"`
+}
+
 type MetaData struct {
 	Page       int   `json:"page,omitempty"`
 	PageSize   int   `json:"pageSize,omitempty"`
@@ -26,6 +36,35 @@ type MetaData struct {
 	TotalPages int   `json:"totalPages,omitempty"`
 }
 
+// Page sizes of list requests that don't ask for one, and the largest any
+// may ask for. History lists like chat default to longer pages. Set from
+// config at startup with utils.ConfigurePagination.
+var (
+	DefaultPageSize        = 20
+	DefaultHistoryPageSize = 50
+	MaxPageSize            = 100
+)
+
+// NormalizePage defaults a missing page or page size and caps the page size
+func NormalizePage(page, pageSize int) (int, int) {
+	return NormalizePageWithDefault(page, pageSize, DefaultPageSize)
+}
+
+// NormalizePageWithDefault is NormalizePage for lists with their own
+// default page size
+func NormalizePageWithDefault(page, pageSize, defaultPageSize int) (int, int) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > MaxPageSize {
+		pageSize = MaxPageSize
+	}
+	return page, pageSize
+}
+
 // Pagination request
 type PaginationRequest struct {
 	Page      int    `json:"page" form:"page" validate:"min=1"`
@@ -77,4 +116,9 @@ const (
 	ErrCodeRateLimit      = "RATE_LIMIT_EXCEEDED"
 	ErrCodeInternal       = "INTERNAL_ERROR"
 	ErrCodeExternal       = "EXTERNAL_SERVICE_ERROR"
+	ErrCodeQueryTimeout   = "QUERY_TIMEOUT"
+
+	// The circle's export policy doesn't let the user export; they can ask
+	// an admin for permission
+	ErrCodeExportPermission = "EXPORT_PERMISSION_REQUIRED"
 )
@@ -0,0 +1,30 @@
+package models
+
+import "testing"
+
+func TestNormalizePageWithDefault(t *testing.T) {
+	tests := []struct {
+		name                   string
+		page, pageSize         int
+		wantPage, wantPageSize int
+	}{
+		{"missing", 0, 0, 1, 30},
+		{"negative", -2, -5, 1, 30},
+		{"given", 3, 10, 3, 10},
+		{"at the cap", 1, MaxPageSize, 1, MaxPageSize},
+		{"over the cap", 1, MaxPageSize + 1, 1, MaxPageSize},
+		{"far over the cap", 2, 100000, 2, MaxPageSize},
+		{"smallest", 1, 1, 1, 1},
+	}
+	for _, tt := range tests {
+		page, pageSize := NormalizePageWithDefault(tt.page, tt.pageSize, 30)
+		if page != tt.wantPage || pageSize != tt.wantPageSize {
+			t.Errorf("%s: NormalizePageWithDefault(%d, %d, 30) = %d, %d; want %d, %d",
+				tt.name, tt.page, tt.pageSize, page, pageSize, tt.wantPage, tt.wantPageSize)
+		}
+	}
+
+	if page, pageSize := NormalizePage(0, 0); page != 1 || pageSize != DefaultPageSize {
+		t.Errorf("NormalizePage(0, 0) = %d, %d; want 1, %d", page, pageSize, DefaultPageSize)
+	}
+}
@@ -0,0 +1,54 @@
+package models
+
+import "time"
+
+// SMS commands, the first word of an inbound text
+const (
+	SMSCommandWhere   = "WHERE"
+	SMSCommandCheckin = "CHECKIN"
+	SMSCommandSOS     = "SOS"
+	SMSCommandHelp    = "HELP"
+	SMSCommandStart   = "START"
+	SMSCommandStop    = "STOP"
+)
+
+// InboundSMS is a text received on the Twilio number
+type InboundSMS struct {
+	MessageSID string `form:"MessageSid"`
+	From       string `form:"From"`
+	To         string `form:"To"`
+	Body       string `form:"Body"`
+}
+
+// SMSCommand is a parsed inbound text
+type SMSCommand struct {
+	Name     string `json:"name"`
+	Argument string `json:"argument,omitempty"`
+}
+
+// SMSCommandOptIn is handed to the app when the user turns SMS commands on.
+// Commands answer once the code is texted from the verified number.
+type SMSCommandOptIn struct {
+	PhoneNumber  string    `json:"phoneNumber"`
+	SendTo       string    `json:"sendTo"` // the number to text
+	Code         string    `json:"code"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+	Instructions string    `json:"instructions"`
+}
+
+type SMSCommandStatus struct {
+	PhoneNumber   string     `json:"phoneNumber,omitempty"`
+	PhoneVerified bool       `json:"phoneVerified"`
+	Enabled       bool       `json:"enabled"`
+	OptedInAt     *time.Time `json:"optedInAt,omitempty"`
+	PendingOptIn  bool       `json:"pendingOptIn"`
+}
+
+// Whereabouts is a member's location as coarse as an SMS reply gets: the
+// place they're at, or else the area
+type Whereabouts struct {
+	FirstName  string    `json:"firstName"`
+	PlaceName  string    `json:"placeName,omitempty"`
+	Area       string    `json:"area,omitempty"`
+	RecordedAt time.Time `json:"recordedAt"`
+}
@@ -28,6 +28,9 @@ type User struct {
 	IsVerified bool      `json:"isVerified" bson:"isVerified"`
 	LastSeen   time.Time `json:"lastSeen" bson:"lastSeen"`
 
+	// Subscription plan, empty for the default plan. Some limits depend on it.
+	Plan string `json:"plan,omitempty" bson:"plan,omitempty"`
+
 	// Authentication & Security
 	VerificationToken string    `json:"-" bson:"verificationToken,omitempty"`
 	ResetToken        string    `json:"-" bson:"resetToken,omitempty"`
@@ -35,8 +38,12 @@ type User struct {
 
 	// Two-Factor Authentication
 	TwoFactorEnabled bool     `json:"twoFactorEnabled" bson:"twoFactorEnabled"`
-	TwoFactorSecret  string   `json:"-" bson:"twoFactorSecret,omitempty"`
-	BackupCodes      []string `json:"-" bson:"backupCodes,omitempty"`
+	TwoFactorSecret  string   `json:"-" bson:"twoFactorSecret,omitempty"` // Encrypted
+	BackupCodes      []string `json:"-" bson:"backupCodes,omitempty"`     // Hashed, each usable once
+
+	// Secret issued by enrollment, active once a code from it is verified
+	PendingTwoFactorSecret    string    `json:"-" bson:"pendingTwoFactorSecret,omitempty"`
+	PendingTwoFactorExpiresAt time.Time `json:"-" bson:"pendingTwoFactorExpiresAt,omitempty"`
 
 	// OAuth Authentication
 	AuthProvider   string `json:"authProvider,omitempty" bson:"authProvider,omitempty"`
@@ -46,6 +53,10 @@ type User struct {
 	LoginAttempts int       `json:"-" bson:"loginAttempts,omitempty"`
 	LockedUntil   time.Time `json:"-" bson:"lockedUntil,omitempty"`
 
+	// Set by an admin on a compromised account. Until the password is
+	// changed, changing it is all the account can do.
+	PasswordChangeRequired bool `json:"passwordChangeRequired,omitempty" bson:"passwordChangeRequired,omitempty"`
+
 	// Contact Information
 	EmergencyContact EmergencyContact `json:"emergencyContact" bson:"emergencyContact"`
 
@@ -61,6 +72,9 @@ type User struct {
 	Permissions   []string   `json:"permissions,omitempty" bson:"permissions,omitempty"` // specific permissions
 	IsAdmin       bool       `json:"isAdmin" bson:"isAdmin"`                             // quick admin check
 	DeactivatedAt *time.Time `json:"deactivatedAt,omitempty" bson:"deactivatedAt,omitempty"`
+
+	// Set only while the user has deactivated their own account
+	Deactivation *AccountDeactivation `json:"deactivation,omitempty" bson:"deactivation,omitempty"`
 }
 
 type UserPreferences struct {
@@ -154,6 +168,8 @@ type UserDataExport struct {
 	UserID      primitive.ObjectID `json:"userId" bson:"userId"`
 	Status      string             `json:"status" bson:"status"` // pending, processing, completed, failed
 	DataTypes   []string           `json:"dataTypes" bson:"dataTypes"`
+	SchemaVersion int              `json:"schemaVersion" bson:"schemaVersion"`
+	AppVersion    string           `json:"appVersion" bson:"appVersion"`
 	FileURL     string             `json:"fileUrl,omitempty" bson:"fileUrl,omitempty"`
 	FileSize    int64              `json:"fileSize,omitempty" bson:"fileSize,omitempty"`
 	ExpiresAt   time.Time          `json:"expiresAt" bson:"expiresAt"`
@@ -161,13 +177,37 @@ type UserDataExport struct {
 	CreatedAt   time.Time          `json:"createdAt" bson:"createdAt"`
 }
 
+// Account Deactivation
+type AccountDeactivation struct {
+	Reason        string    `json:"reason,omitempty" bson:"reason,omitempty"`
+	DeactivatedAt time.Time `json:"deactivatedAt" bson:"deactivatedAt"`
+
+	// Location sharing is switched off while deactivated and restored on reactivation
+	LocationSharingEnabled bool `json:"-" bson:"locationSharingEnabled"`
+
+	// Retention: a warning email is sent before the account is queued for deletion
+	WarningSentAt    *time.Time `json:"-" bson:"warningSentAt,omitempty"`
+	PurgeRequestedAt *time.Time `json:"-" bson:"purgeRequestedAt,omitempty"`
+}
+
+type DeactivateAccountRequest struct {
+	Reason string `json:"reason,omitempty" validate:"max=500"`
+}
+
+// SoleAdminCircle is a circle that needs a new owner before its only admin
+// can deactivate
+type SoleAdminCircle struct {
+	ID   primitive.ObjectID `json:"id"`
+	Name string             `json:"name"`
+}
+
 // Privacy and Security
 type DataPurgeRequest struct {
 	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
 	UserID      primitive.ObjectID `json:"userId" bson:"userId"`
 	Reason      string             `json:"reason" bson:"reason"`
 	DataTypes   []string           `json:"dataTypes" bson:"dataTypes"`
-	Status      string             `json:"status" bson:"status"` // pending, processing, completed
+	Status      string             `json:"status" bson:"status"` // pending, processing, completed, cancelled
 	ScheduledAt time.Time          `json:"scheduledAt" bson:"scheduledAt"`
 	CompletedAt time.Time          `json:"completedAt,omitempty" bson:"completedAt,omitempty"`
 	CreatedAt   time.Time          `json:"createdAt" bson:"createdAt"`
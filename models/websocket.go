@@ -5,14 +5,27 @@ import (
 	"time"
 )
 
+// WebSocket protocol versions. Clients ask for one when connecting and get
+// payloads without the fields of later versions; see docs/WEBSOCKET.md.
+const (
+	WSVersion1      = 1
+	WSVersion2      = 2
+	WSVersionLatest = WSVersion2
+)
+
 // WebSocket Message Types
 type WSMessage struct {
 	Type      string      `json:"type"`
+	Version   int         `json:"version,omitempty"` // set when sending, from version 2
 	Data      interface{} `json:"data"`
 	UserID    string      `json:"userId,omitempty"`
 	CircleID  string      `json:"circleId,omitempty"`
 	Timestamp time.Time   `json:"timestamp"`
 	RequestID string      `json:"requestId,omitempty"`
+
+	// EventID is set on events delivered through the outbox, which may
+	// arrive more than once; clients drop ids they've already seen
+	EventID string `json:"eventId,omitempty"` // from version 2
 }
 
 type WSLocationUpdate struct {
@@ -28,8 +41,54 @@ type WSPlaceEvent struct {
 	EventType string    `json:"eventType"` // arrival, departure
 	Location  Location  `json:"location,omitempty"`
 	Timestamp time.Time `json:"timestamp"`
+
+	// The visit the event opened or closed
+	VisitID     string     `json:"visitId,omitempty"`
+	ArrivalTime *time.Time `json:"arrivalTime,omitempty"`
+	Duration    int64      `json:"duration,omitempty"` // seconds, on departure
+}
+
+// WSVisitUpdate keeps "here for 25 min" displays live. It is sent when a
+// visit opens or closes and periodically while it is open; clients can count
+// up from ArrivalTime in between.
+type WSVisitUpdate struct {
+	VisitID       string     `json:"visitId"`
+	UserID        string     `json:"userId"`
+	PlaceID       string     `json:"placeId"`
+	PlaceName     string     `json:"placeName"`
+	ArrivalTime   time.Time  `json:"arrivalTime"`
+	DepartureTime *time.Time `json:"departureTime,omitempty"`
+	Duration      int64      `json:"duration"` // seconds
+	IsOngoing     bool       `json:"isOngoing"`
+	Timestamp     time.Time  `json:"timestamp"`
+}
+
+// WSPlaceListUpdate tells a circle a shared list changed, so members
+// shopping together see items checked off as it happens. Item events carry
+// the item, the others the whole list; a deleted list carries neither.
+type WSPlaceListUpdate struct {
+	ListID    string         `json:"listId"`
+	CircleID  string         `json:"circleId"`
+	UserID    string         `json:"userId"` // who made the change
+	Action    string         `json:"action"`
+	Status    string         `json:"status,omitempty"` // of the list after the change
+	Item      *PlaceListItem `json:"item,omitempty"`
+	List      *PlaceList     `json:"list,omitempty"`
+	Timestamp time.Time      `json:"timestamp"`
 }
 
+// Place list update actions
+const (
+	PlaceListActionCreated       = "list_created"
+	PlaceListActionUpdated       = "list_updated"
+	PlaceListActionDeleted       = "list_deleted"
+	PlaceListActionItemAdded     = "item_added"
+	PlaceListActionItemUpdated   = "item_updated"
+	PlaceListActionItemChecked   = "item_checked"
+	PlaceListActionItemUnchecked = "item_unchecked"
+	PlaceListActionItemRemoved   = "item_removed"
+)
+
 type WSEmergencyAlert struct {
 	UserID      string            `json:"userId"`
 	EmergencyID string            `json:"emergencyId"`
@@ -55,6 +114,10 @@ type WSUserStatus struct {
 	LastSeen     time.Time `json:"lastSeen,omitempty"`
 	BatteryLevel int       `json:"batteryLevel,omitempty"`
 	Timestamp    time.Time `json:"timestamp"`
+
+	// Sent when the member's location goes offline or comes back
+	LocationStatus string     `json:"locationStatus,omitempty"` // fresh, stale, offline
+	LastLocationAt *time.Time `json:"lastLocationAt,omitempty"`
 }
 
 type WSNotification struct {
@@ -75,7 +138,11 @@ type WSMessageData struct {
 	Type      string        `json:"type"`
 	Content   string        `json:"content,omitempty"`
 	Media     *MessageMedia `json:"media,omitempty"`
+	ClientID  string        `json:"clientId,omitempty"`
 	Timestamp time.Time     `json:"timestamp"`
+
+	// Set on the placeholder sent to members who blocked the sender
+	IsCollapsed bool `json:"isCollapsed,omitempty"`
 }
 
 type WSDrivingEvent struct {
@@ -152,6 +219,9 @@ type WSMessageRequest struct {
 	Content  string        `json:"content,omitempty"`
 	Media    *MessageMedia `json:"media,omitempty"`
 	ReplyTo  string        `json:"replyTo,omitempty"`
+
+	ClientID   string     `json:"clientId,omitempty"`
+	ComposedAt *time.Time `json:"composedAt,omitempty"`
 }
 
 type WSEmergencyRequest struct {
@@ -178,6 +248,9 @@ const (
 	WSTypeAuth             = "auth"
 	WSTypeError            = "error"
 	WSTypeSuccess          = "success"
+	WSTypeTrackingHint     = "tracking_hint"
+	WSTypeVisitUpdate      = "visit_update"
+	WSTypePlaceListUpdate  = "place_list_update"
 
 	// WebSocket request types
 	WSRequestLocationUpdate = "location_update_request"
@@ -249,10 +322,21 @@ type WSHubStats struct {
 	MessagesPerSecond float64                `json:"messagesPerSecond"`
 	ConnectionsByType map[string]int         `json:"connectionsByType"`
 	RoomStats         map[string]WSRoomStats `json:"roomStats"`
+	Fanout            WSFanoutStats          `json:"fanout"`
 	Uptime            time.Duration          `json:"uptime"`
 	LastUpdate        time.Time              `json:"lastUpdate"`
 }
 
+// WSFanoutStats is the load of the workers delivering room broadcasts
+type WSFanoutStats struct {
+	Workers           int   `json:"workers"`
+	BusyWorkers       int   `json:"busyWorkers"`
+	QueueDepth        int   `json:"queueDepth"`
+	QueueCapacity     int   `json:"queueCapacity"`
+	RejectedBatches   int64 `json:"rejectedBatches"`   // dropped because the queue was full
+	SlowClientsClosed int64 `json:"slowClientsClosed"` // closed for not reading their messages
+}
+
 type WSRoomStats struct {
 	CircleID       string    `json:"circleId"`
 	ActiveUsers    int       `json:"activeUsers"`
@@ -268,11 +352,13 @@ type WSAuthRequest struct {
 }
 
 type WSAuthResponse struct {
-	Success   bool      `json:"success"`
-	UserID    string    `json:"userId,omitempty"`
-	CircleIDs []string  `json:"circleIds,omitempty"`
-	Error     string    `json:"error,omitempty"`
-	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+	Success           bool      `json:"success"`
+	UserID            string    `json:"userId,omitempty"`
+	CircleIDs         []string  `json:"circleIds,omitempty"`
+	Error             string    `json:"error,omitempty"`
+	ExpiresAt         time.Time `json:"expiresAt,omitempty"`
+	Version           int       `json:"version,omitempty"`
+	SupportedVersions []int     `json:"supportedVersions,omitempty"`
 }
 
 // WebSocket Heartbeat
@@ -0,0 +1,265 @@
+// models/websocket_binary.go
+package models
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// WebSocket payload encodings. Clients opt into binary when connecting; it
+// only changes high-frequency events, everything else stays JSON.
+const (
+	WSEncodingJSON   = "json"
+	WSEncodingBinary = "binary"
+)
+
+// Binary frame kinds, the first byte of every binary frame
+const (
+	WSBinaryLocationUpdate  byte = 1
+	WSBinaryTypingIndicator byte = 2
+)
+
+// Binary frame layout, all integers big-endian:
+//
+//	header          kind(1) version(1) envelopeTime(8, unix ms) circleId(12, zero if none)
+//	location_update userId(12) latitude(8) longitude(8) accuracy(4) speed(4)
+//	                bearing(4) batteryLevel(1) flags(1) timestamp(8)
+//	typing_indicator circleId(12) userId(12) flags(1) timestamp(8)
+//
+// Floats are IEEE 754, 64-bit for coordinates and 32-bit otherwise.
+const (
+	wsBinaryHeaderSize   = 1 + 1 + 8 + 12
+	wsBinaryLocationSize = 12 + 8 + 8 + 4 + 4 + 4 + 1 + 1 + 8
+	wsBinaryTypingSize   = 12 + 12 + 1 + 8
+)
+
+// Flag bits
+const (
+	wsBinaryFlagDriving  byte = 1 << 0
+	wsBinaryFlagMoving   byte = 1 << 1
+	wsBinaryFlagCharging byte = 1 << 2
+	wsBinaryFlagTyping   byte = 1 << 0
+)
+
+var ErrWSBinaryFrame = errors.New("invalid binary frame")
+
+// EncodeWSBinary encodes a message in the compact binary layout. It returns
+// false when the message has no binary layout, or carries ids that aren't
+// ObjectIDs, and should be sent as JSON instead.
+func EncodeWSBinary(message WSMessage) ([]byte, bool) {
+	var kind byte
+	var payload []byte
+	var ok bool
+
+	switch data := message.Data.(type) {
+	case WSLocationUpdate:
+		kind = WSBinaryLocationUpdate
+		payload, ok = encodeWSBinaryLocation(data)
+	case *WSLocationUpdate:
+		if data == nil {
+			return nil, false
+		}
+		kind = WSBinaryLocationUpdate
+		payload, ok = encodeWSBinaryLocation(*data)
+	case WSTypingIndicator:
+		kind = WSBinaryTypingIndicator
+		payload, ok = encodeWSBinaryTyping(data)
+	case *WSTypingIndicator:
+		if data == nil {
+			return nil, false
+		}
+		kind = WSBinaryTypingIndicator
+		payload, ok = encodeWSBinaryTyping(*data)
+	default:
+		return nil, false
+	}
+	if !ok {
+		return nil, false
+	}
+
+	circleID, ok := wsBinaryID(message.CircleID)
+	if !ok || message.Version > math.MaxUint8 {
+		return nil, false
+	}
+
+	frame := make([]byte, 0, wsBinaryHeaderSize+len(payload))
+	frame = append(frame, kind, byte(message.Version))
+	frame = binary.BigEndian.AppendUint64(frame, uint64(wsBinaryTime(message.Timestamp)))
+	frame = append(frame, circleID[:]...)
+	frame = append(frame, payload...)
+	return frame, true
+}
+
+// DecodeWSBinary decodes a frame written by EncodeWSBinary. Ids that were
+// empty come back empty, timestamps come back in UTC at millisecond precision.
+func DecodeWSBinary(frame []byte) (WSMessage, error) {
+	if len(frame) < wsBinaryHeaderSize {
+		return WSMessage{}, ErrWSBinaryFrame
+	}
+
+	message := WSMessage{
+		Version:   int(frame[1]),
+		Timestamp: wsBinaryTimeFrom(frame[2:10]),
+		CircleID:  wsBinaryIDString(frame[10:22]),
+	}
+	payload := frame[wsBinaryHeaderSize:]
+
+	switch frame[0] {
+	case WSBinaryLocationUpdate:
+		if len(payload) != wsBinaryLocationSize {
+			return WSMessage{}, ErrWSBinaryFrame
+		}
+		message.Type = WSTypeLocationUpdate
+		message.Data = decodeWSBinaryLocation(payload)
+	case WSBinaryTypingIndicator:
+		if len(payload) != wsBinaryTypingSize {
+			return WSMessage{}, ErrWSBinaryFrame
+		}
+		message.Type = WSTypeTypingIndicator
+		message.Data = decodeWSBinaryTyping(payload)
+	default:
+		return WSMessage{}, ErrWSBinaryFrame
+	}
+
+	return message, nil
+}
+
+// encodeWSBinaryLocation keeps the fields map pins need; clients that want
+// the address or place details read them from the REST API
+func encodeWSBinaryLocation(update WSLocationUpdate) ([]byte, bool) {
+	userID, ok := wsBinaryID(update.UserID)
+	if !ok {
+		return nil, false
+	}
+
+	var flags byte
+	if update.Location.IsDriving {
+		flags |= wsBinaryFlagDriving
+	}
+	if update.Location.IsMoving {
+		flags |= wsBinaryFlagMoving
+	}
+	if update.Location.IsCharging {
+		flags |= wsBinaryFlagCharging
+	}
+
+	payload := make([]byte, 0, wsBinaryLocationSize)
+	payload = append(payload, userID[:]...)
+	payload = binary.BigEndian.AppendUint64(payload, math.Float64bits(update.Location.Latitude))
+	payload = binary.BigEndian.AppendUint64(payload, math.Float64bits(update.Location.Longitude))
+	payload = binary.BigEndian.AppendUint32(payload, math.Float32bits(float32(update.Location.Accuracy)))
+	payload = binary.BigEndian.AppendUint32(payload, math.Float32bits(float32(update.Location.Speed)))
+	payload = binary.BigEndian.AppendUint32(payload, math.Float32bits(float32(update.Location.Bearing)))
+	payload = append(payload, wsBinaryBattery(update.Location.BatteryLevel), flags)
+	payload = binary.BigEndian.AppendUint64(payload, uint64(wsBinaryTime(update.Timestamp)))
+	return payload, true
+}
+
+func decodeWSBinaryLocation(payload []byte) WSLocationUpdate {
+	userID := wsBinaryIDString(payload[0:12])
+	flags := payload[41]
+
+	location := Location{
+		Latitude:     math.Float64frombits(binary.BigEndian.Uint64(payload[12:20])),
+		Longitude:    math.Float64frombits(binary.BigEndian.Uint64(payload[20:28])),
+		Accuracy:     float64(math.Float32frombits(binary.BigEndian.Uint32(payload[28:32]))),
+		Speed:        float64(math.Float32frombits(binary.BigEndian.Uint32(payload[32:36]))),
+		Bearing:      float64(math.Float32frombits(binary.BigEndian.Uint32(payload[36:40]))),
+		BatteryLevel: int(payload[40]),
+		IsDriving:    flags&wsBinaryFlagDriving != 0,
+		IsMoving:     flags&wsBinaryFlagMoving != 0,
+		IsCharging:   flags&wsBinaryFlagCharging != 0,
+	}
+	if id, err := primitive.ObjectIDFromHex(userID); err == nil {
+		location.UserID = id
+	}
+
+	return WSLocationUpdate{
+		UserID:    userID,
+		Location:  location,
+		Timestamp: wsBinaryTimeFrom(payload[42:50]),
+	}
+}
+
+func encodeWSBinaryTyping(indicator WSTypingIndicator) ([]byte, bool) {
+	circleID, ok := wsBinaryID(indicator.CircleID)
+	if !ok {
+		return nil, false
+	}
+	userID, ok := wsBinaryID(indicator.UserID)
+	if !ok {
+		return nil, false
+	}
+
+	var flags byte
+	if indicator.IsTyping {
+		flags |= wsBinaryFlagTyping
+	}
+
+	payload := make([]byte, 0, wsBinaryTypingSize)
+	payload = append(payload, circleID[:]...)
+	payload = append(payload, userID[:]...)
+	payload = append(payload, flags)
+	payload = binary.BigEndian.AppendUint64(payload, uint64(wsBinaryTime(indicator.Timestamp)))
+	return payload, true
+}
+
+func decodeWSBinaryTyping(payload []byte) WSTypingIndicator {
+	return WSTypingIndicator{
+		CircleID:  wsBinaryIDString(payload[0:12]),
+		UserID:    wsBinaryIDString(payload[12:24]),
+		IsTyping:  payload[24]&wsBinaryFlagTyping != 0,
+		Timestamp: wsBinaryTimeFrom(payload[25:33]),
+	}
+}
+
+// wsBinaryID converts a hex id to its 12 bytes, the empty id to zeros
+func wsBinaryID(id string) (primitive.ObjectID, bool) {
+	if id == "" {
+		return primitive.NilObjectID, true
+	}
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil || objectID.IsZero() {
+		return primitive.NilObjectID, false
+	}
+	return objectID, true
+}
+
+func wsBinaryIDString(b []byte) string {
+	var objectID primitive.ObjectID
+	copy(objectID[:], b)
+	if objectID.IsZero() {
+		return ""
+	}
+	return objectID.Hex()
+}
+
+// wsBinaryTime encodes the zero time as 0 so it round-trips
+func wsBinaryTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+func wsBinaryTimeFrom(b []byte) time.Time {
+	ms := int64(binary.BigEndian.Uint64(b))
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms).UTC()
+}
+
+func wsBinaryBattery(level int) byte {
+	if level < 0 {
+		return 0
+	}
+	if level > 100 {
+		return 100
+	}
+	return byte(level)
+}
@@ -0,0 +1,219 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Fixed ids and times at millisecond precision in UTC, which both encodings
+// carry exactly
+var (
+	testWSUserID   = primitive.NewObjectIDFromTimestamp(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	testWSCircleID = primitive.NewObjectIDFromTimestamp(time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC))
+	testWSTime     = time.Date(2026, 10, 17, 9, 30, 15, 123000000, time.UTC)
+)
+
+// testWSEvents has a payload of every event type. The location update only
+// sets the fields the binary layout carries, with float32-exact values.
+func testWSEvents() map[string]interface{} {
+	userID, circleID := testWSUserID.Hex(), testWSCircleID.Hex()
+	count := 3
+	departed := testWSTime.Add(time.Hour)
+
+	return map[string]interface{}{
+		WSTypeLocationUpdate: WSLocationUpdate{
+			UserID: userID,
+			Location: Location{
+				UserID:       testWSUserID,
+				Latitude:     40.712812345678,
+				Longitude:    -74.006012345678,
+				Accuracy:     12.5,
+				Speed:        3.25,
+				Bearing:      270,
+				BatteryLevel: 84,
+				IsMoving:     true,
+				IsCharging:   true,
+			},
+			Timestamp: testWSTime,
+		},
+		WSTypeTypingIndicator: WSTypingIndicator{CircleID: circleID, UserID: userID, IsTyping: true, Timestamp: testWSTime},
+		WSTypePlaceEvent: WSPlaceEvent{
+			UserID: userID, PlaceID: "place-1", PlaceName: "Home", EventType: "departure",
+			Location: Location{Latitude: 40.7, Longitude: -74}, Timestamp: testWSTime,
+			VisitID: "visit-1", ArrivalTime: &testWSTime, Duration: 3600,
+		},
+		WSTypeVisitUpdate: WSVisitUpdate{
+			VisitID: "visit-1", UserID: userID, PlaceID: "place-1", PlaceName: "Home",
+			ArrivalTime: testWSTime, DepartureTime: &departed, Duration: 3600, Timestamp: testWSTime,
+		},
+		WSTypePlaceListUpdate: WSPlaceListUpdate{
+			ListID: "list-1", CircleID: circleID, UserID: userID, Action: PlaceListActionDeleted, Timestamp: testWSTime,
+		},
+		WSTypeEmergencyAlert: WSEmergencyAlert{
+			UserID: userID, EmergencyID: "emergency-1", Type: "sos", Title: "SOS", Message: "Help",
+			Priority: "critical", Timestamp: testWSTime,
+		},
+		WSTypeCircleUpdate: WSCircleUpdate{CircleID: circleID, Type: "member_joined", UserID: userID, Data: map[string]interface{}{"role": "member"}, Timestamp: testWSTime},
+		WSTypeUserStatus: WSUserStatus{
+			UserID: userID, IsOnline: true, LastSeen: testWSTime, BatteryLevel: 50, Timestamp: testWSTime,
+			LocationStatus: "stale", LastLocationAt: &testWSTime,
+		},
+		WSTypeMessage: WSMessageData{
+			MessageID: "message-1", CircleID: circleID, SenderID: userID, Type: "photo", Content: "look",
+			Media: &MessageMedia{URL: "/media/1", Type: "image"}, ClientID: "client-1", Timestamp: testWSTime,
+		},
+		WSTypeNotification: WSNotification{
+			NotificationID: "notification-1", UserID: userID, Type: "place", Title: "Arrived", Body: "At home",
+			Data: map[string]interface{}{"placeId": "place-1"}, Priority: "normal", Timestamp: testWSTime,
+		},
+		WSTypeDrivingEvent: WSDrivingEvent{
+			UserID: userID, EventID: "event-1", Type: "speeding", Severity: "high", Description: "Fast",
+			Location: Location{Latitude: 40.7, Longitude: -74}, Speed: 40, SpeedLimit: 30, Timestamp: testWSTime,
+		},
+		WSTypeConnectionStatus: WSConnectionStatus{UserID: userID, ConnectionID: "conn-1", Status: "connected", Timestamp: testWSTime},
+		WSTypePing:             WSHeartbeat{Type: "ping", Timestamp: testWSTime},
+		WSTypePong:             WSHeartbeat{Type: "pong", Timestamp: testWSTime},
+		WSTypeAuth: WSAuthResponse{
+			Success: true, UserID: userID, CircleIDs: []string{circleID}, ExpiresAt: testWSTime,
+			Version: WSVersion2, SupportedVersions: []int{WSVersion1, WSVersion2},
+		},
+		WSTypeError:            WSError{Code: "rate_limited", Message: "Slow down", Timestamp: testWSTime},
+		WSTypeSuccess:          WSResponse{Type: "join_circle_request", Data: "ok", Success: true, RequestID: "req-1", Timestamp: testWSTime},
+		WSTypeTrackingHint:     TrackingHint{Interval: 5, Reason: TrackingReasonLiveView, UpdatedAt: testWSTime},
+		WSTypeMessageEdit:      WSMessageEditData{MessageID: "message-1", CircleID: circleID, SenderID: userID, NewContent: "edited", Timestamp: testWSTime},
+		WSTypeMessageDelete:    WSMessageDeleteData{MessageID: "message-1", CircleID: circleID, SenderID: userID, Timestamp: testWSTime},
+		WSTypeReaction:         WSReactionData{MessageID: "message-1", CircleID: circleID, UserID: userID, Emoji: "👍", Action: "add", Count: &count, Timestamp: testWSTime},
+		WSTypeReadReceipt:      WSReadReceiptData{MessageID: "message-1", CircleID: circleID, UserID: userID, Timestamp: testWSTime},
+		WSTypeBulkReadReceipt:  WSBulkReadReceiptData{MessageIDs: []string{"message-1", "message-2"}, CircleID: circleID, UserID: userID, Timestamp: testWSTime},
+		WSTypeTypingStart:      WSTypingData{CircleID: circleID, UserID: userID, IsTyping: true, Timestamp: testWSTime},
+		WSTypeTypingStop:       WSTypingData{CircleID: circleID, UserID: userID, Timestamp: testWSTime},
+		WSTypeScheduledMessage: WSMessageData{MessageID: "message-2", CircleID: circleID, SenderID: userID, Type: "text", Content: "later", Timestamp: testWSTime},
+		WSTypeMessageForward:   WSMessageData{MessageID: "message-3", CircleID: circleID, SenderID: userID, Type: "text", Content: "fwd", Timestamp: testWSTime},
+	}
+}
+
+func testWSMessage(eventType string, data interface{}) WSMessage {
+	return WSMessage{
+		Type:      eventType,
+		Version:   WSVersion2,
+		Data:      data,
+		CircleID:  testWSCircleID.Hex(),
+		Timestamp: testWSTime,
+	}
+}
+
+// decodeWSJSON decodes a JSON frame with the payload as the type of like
+func decodeWSJSON(t *testing.T, frame []byte, like interface{}) WSMessage {
+	t.Helper()
+	payload := reflect.New(reflect.TypeOf(like))
+	decoded := WSMessage{Data: payload.Interface()}
+	if err := json.Unmarshal(frame, &decoded); err != nil {
+		t.Fatalf("decoding %s: %v", frame, err)
+	}
+	decoded.Data = payload.Elem().Interface()
+	return decoded
+}
+
+func TestWSMessageJSONRoundTrip(t *testing.T) {
+	for eventType, data := range testWSEvents() {
+		message := testWSMessage(eventType, data)
+		frame, err := json.Marshal(message)
+		if err != nil {
+			t.Errorf("%s: encoding: %v", eventType, err)
+			continue
+		}
+		if decoded := decodeWSJSON(t, frame, data); !reflect.DeepEqual(decoded, message) {
+			t.Errorf("%s: JSON round trip = %+v, want %+v", eventType, decoded, message)
+		}
+	}
+}
+
+func TestWSMessageBinaryRoundTrip(t *testing.T) {
+	binaryTypes := map[string]bool{WSTypeLocationUpdate: true, WSTypeTypingIndicator: true}
+
+	for eventType, data := range testWSEvents() {
+		message := testWSMessage(eventType, data)
+		frame, ok := EncodeWSBinary(message)
+		if !binaryTypes[eventType] {
+			if ok {
+				t.Errorf("%s: encoded as binary, want it left to JSON", eventType)
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("%s: has no binary encoding", eventType)
+			continue
+		}
+
+		decoded, err := DecodeWSBinary(frame)
+		if err != nil {
+			t.Errorf("%s: decoding: %v", eventType, err)
+			continue
+		}
+		if !reflect.DeepEqual(decoded, message) {
+			t.Errorf("%s: binary round trip = %+v, want %+v", eventType, decoded, message)
+		}
+
+		// Clients see the same event whichever encoding they chose
+		jsonFrame, _ := json.Marshal(message)
+		if fromJSON := decodeWSJSON(t, jsonFrame, data); !reflect.DeepEqual(decoded, fromJSON) {
+			t.Errorf("%s: binary and JSON decode differently:\n%+v\n%+v", eventType, decoded, fromJSON)
+		}
+	}
+}
+
+func TestWSBinaryFallsBackToJSON(t *testing.T) {
+	typing := WSTypingIndicator{CircleID: testWSCircleID.Hex(), UserID: testWSUserID.Hex(), IsTyping: true}
+
+	tests := []struct {
+		name    string
+		message WSMessage
+	}{
+		{"user id that isn't an ObjectID", testWSMessage(WSTypeTypingIndicator, WSTypingIndicator{CircleID: testWSCircleID.Hex(), UserID: "user-1"})},
+		{"envelope circle that isn't an ObjectID", WSMessage{Type: WSTypeTypingIndicator, Data: typing, CircleID: "circle-1"}},
+		{"version past a byte", WSMessage{Type: WSTypeTypingIndicator, Data: typing, Version: 256}},
+		{"nil location pointer", WSMessage{Type: WSTypeLocationUpdate, Data: (*WSLocationUpdate)(nil)}},
+		{"map payload", WSMessage{Type: WSTypeLocationUpdate, Data: map[string]interface{}{"userId": testWSUserID.Hex()}}},
+	}
+	for _, tt := range tests {
+		if _, ok := EncodeWSBinary(tt.message); ok {
+			t.Errorf("%s: encoded as binary, want JSON", tt.name)
+		}
+	}
+
+	// Pointers encode like values, and empty ids and times round-trip empty
+	frame, ok := EncodeWSBinary(WSMessage{Type: WSTypeTypingIndicator, Data: &WSTypingIndicator{}})
+	if !ok {
+		t.Fatal("empty typing indicator has no binary encoding")
+	}
+	decoded, err := DecodeWSBinary(frame)
+	if err != nil {
+		t.Fatalf("decoding: %v", err)
+	}
+	if want := (WSMessage{Type: WSTypeTypingIndicator, Data: WSTypingIndicator{}}); !reflect.DeepEqual(decoded, want) {
+		t.Errorf("empty typing indicator = %+v, want %+v", decoded, want)
+	}
+}
+
+func TestDecodeWSBinaryRejectsBadFrames(t *testing.T) {
+	frame, _ := EncodeWSBinary(testWSMessage(WSTypeTypingIndicator, testWSEvents()[WSTypeTypingIndicator]))
+
+	unknownKind := append([]byte{}, frame...)
+	unknownKind[0] = 99
+
+	for name, bad := range map[string][]byte{
+		"empty":          nil,
+		"header only":    frame[:wsBinaryHeaderSize],
+		"truncated":      frame[:len(frame)-1],
+		"trailing bytes": append(append([]byte{}, frame...), 0),
+		"unknown kind":   unknownKind,
+	} {
+		if _, err := DecodeWSBinary(bad); err != ErrWSBinaryFrame {
+			t.Errorf("%s: error = %v, want ErrWSBinaryFrame", name, err)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Worker health states
+const (
+	WorkerStatusHealthy  = "healthy"
+	WorkerStatusDegraded = "degraded"
+)
+
+// WorkerStatus reports a background worker's health. Processed and failed
+// cover the last full heartbeat interval.
+type WorkerStatus struct {
+	Name              string    `json:"name"`
+	Status            string    `json:"status"`
+	LastHeartbeat     time.Time `json:"lastHeartbeat"`
+	HeartbeatInterval string    `json:"heartbeatInterval"`
+	Processed         int64     `json:"processed"`
+	Failed            int64     `json:"failed"`
+	Backlog           int       `json:"backlog"`
+	InFlight          int       `json:"inFlight"`
+	StuckRequeued     int64     `json:"stuckRequeued"`
+}
@@ -0,0 +1,266 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"ftrack/database"
+	"ftrack/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type AlbumRepository struct {
+	collection      *database.Collection
+	photoCollection *database.Collection
+}
+
+func NewAlbumRepository(db *mongo.Database) *AlbumRepository {
+	return &AlbumRepository{
+		collection:      database.NewCollection(db, "circle_albums"),
+		photoCollection: database.NewCollection(db, "circle_album_photos"),
+	}
+}
+
+func (ar *AlbumRepository) Create(ctx context.Context, album *models.CircleAlbum) error {
+	album.ID = primitive.NewObjectID()
+	album.CreatedAt = time.Now()
+	album.UpdatedAt = album.CreatedAt
+
+	_, err := ar.collection.InsertOne(ctx, album)
+	return err
+}
+
+func (ar *AlbumRepository) GetByID(ctx context.Context, albumID string) (*models.CircleAlbum, error) {
+	objectID, err := primitive.ObjectIDFromHex(albumID)
+	if err != nil {
+		return nil, errors.New("invalid album ID")
+	}
+
+	var album models.CircleAlbum
+	err = ar.collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&album)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("album not found")
+		}
+		return nil, err
+	}
+
+	return &album, nil
+}
+
+// GetByCircle returns the circle's albums in a status, newest first
+func (ar *AlbumRepository) GetByCircle(ctx context.Context, circleID primitive.ObjectID, status string) ([]models.CircleAlbum, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "startTime", Value: -1}})
+	cursor, err := ar.collection.Find(ctx, bson.M{"circleId": circleID, "status": status}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var albums []models.CircleAlbum
+	if err := cursor.All(ctx, &albums); err != nil {
+		return nil, err
+	}
+
+	return albums, nil
+}
+
+// FindOverlapping returns the circle's album at the place whose photos were
+// taken within a cluster gap of the given range, in any status, or nil
+func (ar *AlbumRepository) FindOverlapping(ctx context.Context, circleID, placeID primitive.ObjectID, start, end time.Time) (*models.CircleAlbum, error) {
+	var album models.CircleAlbum
+	err := ar.collection.FindOne(ctx, bson.M{
+		"circleId":  circleID,
+		"placeId":   placeID,
+		"startTime": bson.M{"$lte": end.Add(models.AlbumClusterGap)},
+		"endTime":   bson.M{"$gte": start.Add(-models.AlbumClusterGap)},
+	}).Decode(&album)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &album, nil
+}
+
+func (ar *AlbumRepository) Update(ctx context.Context, albumID primitive.ObjectID, set bson.M) error {
+	set["updatedAt"] = time.Now()
+
+	result, err := ar.collection.UpdateOne(ctx, bson.M{"_id": albumID}, bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("album not found")
+	}
+
+	return nil
+}
+
+// AddPhotos references the photos from their album, skipping the ones it
+// already has
+func (ar *AlbumRepository) AddPhotos(ctx context.Context, photos []models.AlbumPhoto) error {
+	now := time.Now()
+	for _, photo := range photos {
+		photo.AddedAt = now
+		_, err := ar.photoCollection.UpdateOne(ctx,
+			bson.M{"albumId": photo.AlbumID, "messageId": photo.MessageID},
+			bson.M{"$setOnInsert": photo},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetPhotos returns a page of the album's photos in the order they were taken
+func (ar *AlbumRepository) GetPhotos(ctx context.Context, albumID primitive.ObjectID, page, pageSize int) ([]models.AlbumPhoto, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "takenAt", Value: 1}, {Key: "_id", Value: 1}}).
+		SetSkip(int64((page - 1) * pageSize)).
+		SetLimit(int64(pageSize))
+
+	cursor, err := ar.photoCollection.Find(ctx, bson.M{"albumId": albumID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var photos []models.AlbumPhoto
+	if err := cursor.All(ctx, &photos); err != nil {
+		return nil, err
+	}
+
+	return photos, nil
+}
+
+// GetPhoto returns the album's reference to the media
+func (ar *AlbumRepository) GetPhoto(ctx context.Context, albumID, mediaID primitive.ObjectID) (*models.AlbumPhoto, error) {
+	var photo models.AlbumPhoto
+	err := ar.photoCollection.FindOne(ctx, bson.M{"albumId": albumID, "mediaId": mediaID}).Decode(&photo)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("photo not found")
+		}
+		return nil, err
+	}
+
+	return &photo, nil
+}
+
+// RemovePhotos drops the photos matching the filter from every album and
+// returns the albums they were in
+func (ar *AlbumRepository) RemovePhotos(ctx context.Context, filter bson.M) ([]primitive.ObjectID, error) {
+	values, err := ar.photoCollection.Distinct(ctx, "albumId", filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	if _, err := ar.photoCollection.DeleteMany(ctx, filter); err != nil {
+		return nil, err
+	}
+
+	albumIDs := make([]primitive.ObjectID, 0, len(values))
+	for _, value := range values {
+		if albumID, ok := value.(primitive.ObjectID); ok {
+			albumIDs = append(albumIDs, albumID)
+		}
+	}
+
+	return albumIDs, nil
+}
+
+// Refresh recounts the album's photos and contributors from the photos it
+// still has, and picks a new cover if the old one is gone. A suggestion left
+// with too few photos is deleted.
+func (ar *AlbumRepository) Refresh(ctx context.Context, albumID primitive.ObjectID) error {
+	var album models.CircleAlbum
+	if err := ar.collection.FindOne(ctx, bson.M{"_id": albumID}).Decode(&album); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		return err
+	}
+
+	cursor, err := ar.photoCollection.Find(ctx, bson.M{"albumId": albumID},
+		options.Find().SetSort(bson.D{{Key: "takenAt", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var photos []models.AlbumPhoto
+	if err := cursor.All(ctx, &photos); err != nil {
+		return err
+	}
+
+	if album.Status == models.AlbumStatusSuggested && len(photos) < models.AlbumMinPhotos {
+		if _, err := ar.photoCollection.DeleteMany(ctx, bson.M{"albumId": albumID}); err != nil {
+			return err
+		}
+		_, err := ar.collection.DeleteOne(ctx, bson.M{"_id": albumID})
+		return err
+	}
+
+	counts := make(map[primitive.ObjectID]int)
+	contributors := []models.AlbumContributor{}
+	coverFound := false
+	for _, photo := range photos {
+		if counts[photo.UserID] == 0 {
+			contributors = append(contributors, models.AlbumContributor{UserID: photo.UserID})
+		}
+		counts[photo.UserID]++
+		if photo.MediaID == album.CoverMediaID {
+			coverFound = true
+		}
+	}
+	for i := range contributors {
+		contributors[i].PhotoCount = counts[contributors[i].UserID]
+	}
+
+	set := bson.M{
+		"photoCount":   len(photos),
+		"contributors": contributors,
+	}
+	unset := bson.M{}
+	if len(photos) > 0 {
+		set["startTime"] = photos[0].TakenAt
+		set["endTime"] = photos[len(photos)-1].TakenAt
+		if !coverFound {
+			set["coverMediaId"] = photos[0].MediaID
+			set["coverUrl"] = coverURL(photos[0])
+		}
+	} else {
+		unset["coverMediaId"] = ""
+		unset["coverUrl"] = ""
+	}
+	set["updatedAt"] = time.Now()
+
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	_, err = ar.collection.UpdateOne(ctx, bson.M{"_id": albumID}, update)
+	return err
+}
+
+// coverURL prefers the photo's thumbnail for the album cover
+func coverURL(photo models.AlbumPhoto) string {
+	if photo.ThumbnailURL != "" {
+		return photo.ThumbnailURL
+	}
+	return photo.URL
+}
@@ -0,0 +1,206 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"ftrack/database"
+	"ftrack/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type AnomalyRepository struct {
+	profileCollection *database.Collection
+	alertCollection   *database.Collection
+}
+
+func NewAnomalyRepository(db *mongo.Database) *AnomalyRepository {
+	return &AnomalyRepository{
+		profileCollection: database.NewCollection(db, "anomaly_profiles"),
+		alertCollection:   database.NewCollection(db, "anomaly_alerts"),
+	}
+}
+
+func (ar *AnomalyRepository) GetProfile(ctx context.Context, userID string) (*models.AnomalyProfile, error) {
+	objectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	var profile models.AnomalyProfile
+	err = ar.profileCollection.FindOne(ctx, bson.M{"userId": objectID}).Decode(&profile)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("anomaly profile not found")
+		}
+		return nil, err
+	}
+
+	return &profile, nil
+}
+
+// SaveProfile creates or replaces the member's profile. The evaluation
+// cursor is left alone so saving doesn't re-scan old locations.
+func (ar *AnomalyRepository) SaveProfile(ctx context.Context, profile *models.AnomalyProfile) error {
+	now := time.Now()
+	profile.UpdatedAt = now
+
+	opts := options.FindOneAndUpdate().
+		SetUpsert(true).
+		SetReturnDocument(options.After)
+
+	err := ar.profileCollection.FindOneAndUpdate(ctx,
+		bson.M{"userId": profile.UserID},
+		bson.M{
+			"$set": bson.M{
+				"enabled":   profile.Enabled,
+				"timezone":  profile.Timezone,
+				"watchers":  profile.Watchers,
+				"rules":     profile.Rules,
+				"updatedBy": profile.UpdatedBy,
+				"updatedAt": now,
+			},
+			"$setOnInsert": bson.M{"createdAt": now},
+		},
+		opts,
+	).Decode(profile)
+	return err
+}
+
+// GetEnabledProfiles pages through enabled profiles in id order
+func (ar *AnomalyRepository) GetEnabledProfiles(ctx context.Context, afterID primitive.ObjectID, limit int) ([]models.AnomalyProfile, error) {
+	filter := bson.M{"enabled": true}
+	if !afterID.IsZero() {
+		filter["_id"] = bson.M{"$gt": afterID}
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetLimit(int64(limit))
+
+	cursor, err := ar.profileCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var profiles []models.AnomalyProfile
+	err = cursor.All(ctx, &profiles)
+	return profiles, err
+}
+
+func (ar *AnomalyRepository) SetEvaluated(ctx context.Context, profileID primitive.ObjectID, at time.Time) error {
+	_, err := ar.profileCollection.UpdateOne(ctx,
+		bson.M{"_id": profileID},
+		bson.M{"$set": bson.M{"lastEvaluatedAt": at}},
+	)
+	return err
+}
+
+// SetRuleTriggered starts a rule's cooldown. Rules are matched by id, so a
+// profile saved meanwhile keeps its own rules.
+func (ar *AnomalyRepository) SetRuleTriggered(ctx context.Context, profileID, ruleID primitive.ObjectID, at time.Time) error {
+	_, err := ar.profileCollection.UpdateOne(ctx,
+		bson.M{"_id": profileID, "rules.id": ruleID},
+		bson.M{"$set": bson.M{"rules.$.lastTriggeredAt": at}},
+	)
+	return err
+}
+
+// UpdateRule stores a rule's widened thresholds. It is a no-op when the
+// rule was removed from the profile meanwhile.
+func (ar *AnomalyRepository) UpdateRule(ctx context.Context, profileID primitive.ObjectID, rule models.AnomalyRule) error {
+	_, err := ar.profileCollection.UpdateOne(ctx,
+		bson.M{"_id": profileID, "rules.id": rule.ID},
+		bson.M{"$set": bson.M{
+			"rules.$":   rule,
+			"updatedAt": time.Now(),
+		}},
+	)
+	return err
+}
+
+func (ar *AnomalyRepository) CreateAlert(ctx context.Context, alert *models.AnomalyAlert) error {
+	alert.ID = primitive.NewObjectID()
+
+	_, err := ar.alertCollection.InsertOne(ctx, alert)
+	return err
+}
+
+func (ar *AnomalyRepository) GetAlert(ctx context.Context, alertID string) (*models.AnomalyAlert, error) {
+	objectID, err := primitive.ObjectIDFromHex(alertID)
+	if err != nil {
+		return nil, errors.New("invalid alert ID")
+	}
+
+	var alert models.AnomalyAlert
+	err = ar.alertCollection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&alert)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("anomaly alert not found")
+		}
+		return nil, err
+	}
+
+	return &alert, nil
+}
+
+// GetAlertsForUser returns the alerts about the user or sent to them,
+// newest first
+func (ar *AnomalyRepository) GetAlertsForUser(ctx context.Context, userID string, page, pageSize int) ([]models.AnomalyAlert, int64, error) {
+	objectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, 0, errors.New("invalid user ID")
+	}
+
+	filter := bson.M{"$or": []bson.M{
+		{"userId": objectID},
+		{"watchers": objectID},
+	}}
+
+	total, err := ar.alertCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "triggeredAt", Value: -1}}).
+		SetSkip(int64((page - 1) * pageSize)).
+		SetLimit(int64(pageSize))
+
+	cursor, err := ar.alertCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	alerts := []models.AnomalyAlert{}
+	err = cursor.All(ctx, &alerts)
+	return alerts, total, err
+}
+
+// SetAlertFeedback records feedback once; an alert that already has some
+// returns an error
+func (ar *AnomalyRepository) SetAlertFeedback(ctx context.Context, alertID, userID primitive.ObjectID, feedback string) error {
+	now := time.Now()
+	result, err := ar.alertCollection.UpdateOne(ctx,
+		bson.M{"_id": alertID, "feedback": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{
+			"feedback":   feedback,
+			"feedbackBy": userID,
+			"feedbackAt": now,
+		}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("feedback already given")
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package repositories
 
 import (
 	"context"
+	"ftrack/database"
 	"ftrack/models"
 	"time"
 
@@ -12,12 +13,12 @@ import (
 )
 
 type AuditLogRepository struct {
-	collection *mongo.Collection
+	collection *database.Collection
 }
 
 func NewAuditLogRepository(db *mongo.Database) *AuditLogRepository {
 	return &AuditLogRepository{
-		collection: db.Collection("audit_logs"),
+		collection: database.NewCollection(db, "audit_logs"),
 	}
 }
 
@@ -109,6 +110,39 @@ func (alr *AuditLogRepository) GetSecurityEvents(ctx context.Context, userID str
 	return entries, err
 }
 
+// GetImpersonationAccess counts the requests made during an impersonation
+// session by route, leaving out the ones that were refused
+func (alr *AuditLogRepository) GetImpersonationAccess(ctx context.Context, sessionID string) ([]models.ImpersonationAccess, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"eventType":               models.AuditEventImpersonatedRequest,
+			"details.impersonationId": sessionID,
+			"details.status":          bson.M{"$lt": 400},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"method": "$details.method", "route": "$details.route"},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":    0,
+			"method": "$_id.method",
+			"route":  "$_id.route",
+			"count":  1,
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "route", Value: 1}}}},
+	}
+
+	cursor, err := alr.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	access := []models.ImpersonationAccess{}
+	err = cursor.All(ctx, &access)
+	return access, err
+}
+
 func (alr *AuditLogRepository) CleanupOldLogs(ctx context.Context, olderThan time.Duration) error {
 	cutoff := time.Now().Add(-olderThan)
 	filter := bson.M{"createdAt": bson.M{"$lt": cutoff}}
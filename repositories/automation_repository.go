@@ -3,6 +3,7 @@ package repositories
 import (
 	"context"
 	"errors"
+	"ftrack/database"
 	"ftrack/models"
 	"time"
 
@@ -13,12 +14,12 @@ import (
 )
 
 type AutomationRepository struct {
-	collection *mongo.Collection
+	collection *database.Collection
 }
 
 func NewAutomationRepository(db *mongo.Database) *AutomationRepository {
 	return &AutomationRepository{
-		collection: db.Collection("automation_rules"),
+		collection: database.NewCollection(db, "automation_rules"),
 	}
 }
 
@@ -113,6 +114,14 @@ func (ar *AutomationRepository) Delete(ctx context.Context, id string) error {
 	return nil
 }
 
+// automationPriorityOrder is the order rules are evaluated in: highest
+// priority first, then older rules first
+var automationPriorityOrder = bson.D{
+	{Key: "priority", Value: -1},
+	{Key: "createdAt", Value: 1},
+	{Key: "_id", Value: 1},
+}
+
 func (ar *AutomationRepository) GetUserRules(ctx context.Context, userID string, req models.GetAutomationRulesRequest) ([]models.AutomationRule, int64, error) {
 	userObjectID, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
@@ -145,7 +154,7 @@ func (ar *AutomationRepository) GetUserRules(ctx context.Context, userID string,
 	// Get rules
 	skip := (req.Page - 1) * req.PageSize
 	opts := options.Find().
-		SetSort(bson.D{{Key: "createdAt", Value: -1}}).
+		SetSort(automationPriorityOrder).
 		SetSkip(int64(skip)).
 		SetLimit(int64(req.PageSize))
 
@@ -189,7 +198,7 @@ func (ar *AutomationRepository) GetActiveRulesForCircle(ctx context.Context, cir
 		"isDeleted": bson.M{"$ne": true},
 	}
 
-	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}) // Older rules first
+	opts := options.Find().SetSort(automationPriorityOrder)
 
 	cursor, err := ar.collection.Find(ctx, filter, opts)
 	if err != nil {
@@ -356,6 +365,66 @@ func (ar *AutomationRepository) BulkToggleStatus(ctx context.Context, ruleIDs []
 	return err
 }
 
+// PauseUserRules deactivates the user's active rules and marks them so they
+// can be resumed later without touching rules the user turned off
+func (ar *AutomationRepository) PauseUserRules(ctx context.Context, userID string) (int64, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return 0, errors.New("invalid user ID")
+	}
+
+	result, err := ar.collection.UpdateMany(
+		ctx,
+		bson.M{"userId": userObjectID, "isActive": true},
+		bson.M{"$set": bson.M{
+			"isActive":      false,
+			"pausedByOwner": true,
+			"updatedAt":     time.Now(),
+		}},
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+}
+
+// ResumeUserRules reactivates the rules paused by PauseUserRules
+func (ar *AutomationRepository) ResumeUserRules(ctx context.Context, userID string) (int64, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return 0, errors.New("invalid user ID")
+	}
+
+	result, err := ar.collection.UpdateMany(
+		ctx,
+		bson.M{"userId": userObjectID, "pausedByOwner": true},
+		bson.M{
+			"$set":   bson.M{"isActive": true, "updatedAt": time.Now()},
+			"$unset": bson.M{"pausedByOwner": ""},
+		},
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+}
+
+// MoveCircleRules moves a circle's rules to another circle
+func (ar *AutomationRepository) MoveCircleRules(ctx context.Context, fromCircleID, toCircleID primitive.ObjectID) (int64, error) {
+	result, err := ar.collection.UpdateMany(
+		ctx,
+		bson.M{"circleId": fromCircleID},
+		bson.M{"$set": bson.M{"circleId": toCircleID, "updatedAt": time.Now()}},
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+}
+
 func (ar *AutomationRepository) CleanupInactiveRules(ctx context.Context, inactiveDays int) error {
 	cutoffDate := time.Now().AddDate(0, 0, -inactiveDays)
 
@@ -0,0 +1,201 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"ftrack/database"
+	"ftrack/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type BlockRepository struct {
+	collection *database.Collection
+}
+
+func NewBlockRepository(db *mongo.Database) *BlockRepository {
+	return &BlockRepository{
+		collection: database.NewCollection(db, "user_blocks"),
+	}
+}
+
+func (br *BlockRepository) CreateIndexes(ctx context.Context) error {
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "userId", Value: 1}, {Key: "blockedUserId", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "blockedUserId", Value: 1}},
+		},
+	}
+
+	_, err := br.collection.Indexes().CreateMany(ctx, indexes)
+	if err != nil {
+		return fmt.Errorf("failed to create block indexes: %w", err)
+	}
+	return nil
+}
+
+func (br *BlockRepository) Create(ctx context.Context, block *models.BlockedUser) error {
+	block.ID = primitive.NewObjectID()
+	block.CreatedAt = time.Now()
+
+	_, err := br.collection.InsertOne(ctx, block)
+	if mongo.IsDuplicateKeyError(err) {
+		return errors.New("already blocked")
+	}
+	return err
+}
+
+func (br *BlockRepository) Delete(ctx context.Context, userID, blockedUserID string) error {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return errors.New("invalid user ID")
+	}
+	blockedObjectID, err := primitive.ObjectIDFromHex(blockedUserID)
+	if err != nil {
+		return errors.New("invalid user ID")
+	}
+
+	result, err := br.collection.DeleteOne(ctx, bson.M{
+		"userId":        userObjectID,
+		"blockedUserId": blockedObjectID,
+	})
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return errors.New("not blocked")
+	}
+
+	return nil
+}
+
+// GetBlockedUsers returns the blocks created by the user
+func (br *BlockRepository) GetBlockedUsers(ctx context.Context, userID string) ([]models.BlockedUser, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := br.collection.Find(ctx, bson.M{"userId": userObjectID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var blocks []models.BlockedUser
+	err = cursor.All(ctx, &blocks)
+	return blocks, err
+}
+
+// GetBlockedUserIDs returns the IDs of users the user has blocked
+func (br *BlockRepository) GetBlockedUserIDs(ctx context.Context, userID string) (map[string]bool, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	return br.findRelatedIDs(ctx, bson.M{"userId": userObjectID}, userObjectID)
+}
+
+// GetBlockerIDs returns the IDs of users who have blocked the user
+func (br *BlockRepository) GetBlockerIDs(ctx context.Context, userID string) (map[string]bool, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	return br.findRelatedIDs(ctx, bson.M{"blockedUserId": userObjectID}, userObjectID)
+}
+
+// GetRelatedUserIDs returns the IDs of users on the other side of any
+// block involving the user, whichever of them created it
+func (br *BlockRepository) GetRelatedUserIDs(ctx context.Context, userID string) (map[string]bool, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	return br.findRelatedIDs(ctx, bson.M{
+		"$or": []bson.M{
+			{"userId": userObjectID},
+			{"blockedUserId": userObjectID},
+		},
+	}, userObjectID)
+}
+
+// IsBlockedEitherWay reports whether either user has blocked the other
+func (br *BlockRepository) IsBlockedEitherWay(ctx context.Context, userID, otherUserID string) (bool, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return false, errors.New("invalid user ID")
+	}
+	otherObjectID, err := primitive.ObjectIDFromHex(otherUserID)
+	if err != nil {
+		return false, errors.New("invalid user ID")
+	}
+
+	count, err := br.collection.CountDocuments(ctx, bson.M{
+		"$or": []bson.M{
+			{"userId": userObjectID, "blockedUserId": otherObjectID},
+			{"userId": otherObjectID, "blockedUserId": userObjectID},
+		},
+	}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// HasBlocksAmong reports whether any of the users has blocked another
+func (br *BlockRepository) HasBlocksAmong(ctx context.Context, userIDs []primitive.ObjectID) (bool, error) {
+	if len(userIDs) < 2 {
+		return false, nil
+	}
+
+	count, err := br.collection.CountDocuments(ctx, bson.M{
+		"userId":        bson.M{"$in": userIDs},
+		"blockedUserId": bson.M{"$in": userIDs},
+	}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func (br *BlockRepository) findRelatedIDs(ctx context.Context, filter bson.M, userObjectID primitive.ObjectID) (map[string]bool, error) {
+	opts := options.Find().SetProjection(bson.M{"userId": 1, "blockedUserId": 1})
+	cursor, err := br.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	ids := make(map[string]bool)
+	for cursor.Next(ctx) {
+		var block models.BlockedUser
+		if err := cursor.Decode(&block); err != nil {
+			return nil, err
+		}
+
+		if block.UserID == userObjectID {
+			ids[block.BlockedUserID.Hex()] = true
+		} else {
+			ids[block.UserID.Hex()] = true
+		}
+	}
+
+	return ids, cursor.Err()
+}
@@ -0,0 +1,94 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"ftrack/database"
+	"ftrack/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type CalendarFeedRepository struct {
+	collection *database.Collection
+}
+
+func NewCalendarFeedRepository(db *mongo.Database) *CalendarFeedRepository {
+	return &CalendarFeedRepository{
+		collection: database.NewCollection(db, "calendar_feeds"),
+	}
+}
+
+func (cr *CalendarFeedRepository) Create(ctx context.Context, feed *models.CalendarFeed) error {
+	feed.ID = primitive.NewObjectID()
+	feed.CreatedAt = time.Now()
+
+	_, err := cr.collection.InsertOne(ctx, feed)
+	return err
+}
+
+func (cr *CalendarFeedRepository) GetByTokenHash(ctx context.Context, tokenHash string) (*models.CalendarFeed, error) {
+	var feed models.CalendarFeed
+	err := cr.collection.FindOne(ctx, bson.M{"tokenHash": tokenHash}).Decode(&feed)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("calendar feed not found")
+		}
+		return nil, err
+	}
+	return &feed, nil
+}
+
+// GetUserFeeds returns the user's feeds, newest first
+func (cr *CalendarFeedRepository) GetUserFeeds(ctx context.Context, userID string) ([]models.CalendarFeed, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := cr.collection.Find(ctx, bson.M{"userId": userObjectID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	feeds := []models.CalendarFeed{}
+	err = cursor.All(ctx, &feeds)
+	return feeds, err
+}
+
+func (cr *CalendarFeedRepository) CountUserFeeds(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	return cr.collection.CountDocuments(ctx, bson.M{"userId": userID})
+}
+
+// Delete revokes one of the user's feeds; its link stops working at once
+func (cr *CalendarFeedRepository) Delete(ctx context.Context, userID, feedID string) error {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return errors.New("invalid user ID")
+	}
+	feedObjectID, err := primitive.ObjectIDFromHex(feedID)
+	if err != nil {
+		return errors.New("invalid feed ID")
+	}
+
+	result, err := cr.collection.DeleteOne(ctx, bson.M{"_id": feedObjectID, "userId": userObjectID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errors.New("calendar feed not found")
+	}
+	return nil
+}
+
+func (cr *CalendarFeedRepository) MarkFetched(ctx context.Context, feedID primitive.ObjectID) error {
+	_, err := cr.collection.UpdateOne(ctx, bson.M{"_id": feedID}, bson.M{"$set": bson.M{"lastFetchedAt": time.Now()}})
+	return err
+}
@@ -3,6 +3,8 @@ package repositories
 import (
 	"context"
 	"errors"
+	"fmt"
+	"ftrack/database"
 	"ftrack/models"
 	"time"
 
@@ -13,13 +15,13 @@ import (
 )
 
 type CircleRepository struct {
-	collection *mongo.Collection
+	collection *database.Collection
 	database   *mongo.Database
 }
 
 func NewCircleRepository(db *mongo.Database) *CircleRepository {
 	return &CircleRepository{
-		collection: db.Collection("circles"),
+		collection: database.NewCollection(db, "circles"),
 		database:   db,
 	}
 }
@@ -28,24 +30,36 @@ func NewCircleRepository(db *mongo.Database) *CircleRepository {
 // Collection Getters
 // ========================
 
-func (cr *CircleRepository) GetInvitationCollection() *mongo.Collection {
-	return cr.database.Collection("circle_invitations")
+func (cr *CircleRepository) GetInvitationCollection() *database.Collection {
+	return database.NewCollection(cr.database, "circle_invitations")
 }
 
-func (cr *CircleRepository) GetJoinRequestCollection() *mongo.Collection {
-	return cr.database.Collection("circle_join_requests")
+func (cr *CircleRepository) GetJoinRequestCollection() *database.Collection {
+	return database.NewCollection(cr.database, "circle_join_requests")
 }
 
-func (cr *CircleRepository) GetAnnouncementCollection() *mongo.Collection {
-	return cr.database.Collection("circle_announcements")
+func (cr *CircleRepository) GetRoleCollection() *database.Collection {
+	return database.NewCollection(cr.database, "circle_roles")
 }
 
-func (cr *CircleRepository) GetActivityCollection() *mongo.Collection {
-	return cr.database.Collection("circle_activities")
+func (cr *CircleRepository) GetMomentCollection() *database.Collection {
+	return database.NewCollection(cr.database, "circle_moments")
 }
 
-func (cr *CircleRepository) GetExportJobCollection() *mongo.Collection {
-	return cr.database.Collection("circle_export_jobs")
+func (cr *CircleRepository) GetAnnouncementCollection() *database.Collection {
+	return database.NewCollection(cr.database, "circle_announcements")
+}
+
+func (cr *CircleRepository) GetActivityCollection() *database.Collection {
+	return database.NewCollection(cr.database, "circle_activities")
+}
+
+func (cr *CircleRepository) GetExportJobCollection() *database.Collection {
+	return database.NewCollection(cr.database, "circle_export_jobs")
+}
+
+func (cr *CircleRepository) GetMergeJobCollection() *database.Collection {
+	return database.NewCollection(cr.database, "circle_merge_jobs")
 }
 
 // ========================
@@ -225,6 +239,82 @@ func (cr *CircleRepository) RemoveMember(ctx context.Context, circleID, userID s
 	return nil
 }
 
+// AddMembers adds the members to the circle in one write, only if they all
+// fit within maxMembers and none of them is a member yet. It returns
+// "circle full" or "user already member" when they don't.
+func (cr *CircleRepository) AddMembers(ctx context.Context, circleID string, members []models.CircleMember, maxMembers int) error {
+	objectID, err := primitive.ObjectIDFromHex(circleID)
+	if err != nil {
+		return errors.New("invalid circle ID")
+	}
+	if len(members) == 0 {
+		return nil
+	}
+	if len(members) > maxMembers {
+		return errors.New("circle full")
+	}
+
+	now := time.Now()
+	userIDs := make([]primitive.ObjectID, len(members))
+	for i := range members {
+		members[i].JoinedAt = now
+		members[i].LastActivity = now
+		userIDs[i] = members[i].UserID
+	}
+
+	// The circle has room when the member that would go past the limit
+	// isn't there
+	filter := bson.M{
+		"_id": objectID,
+		fmt.Sprintf("members.%d", maxMembers-len(members)): bson.M{"$exists": false},
+		"members.userId": bson.M{"$nin": userIDs},
+	}
+	result, err := cr.collection.UpdateOne(ctx, filter, bson.M{
+		"$push": bson.M{"members": bson.M{"$each": members}},
+		"$set":  bson.M{"updatedAt": now},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	circle, err := cr.GetByID(ctx, circleID)
+	if err != nil {
+		return err
+	}
+	if len(circle.Members)+len(members) > maxMembers {
+		return errors.New("circle full")
+	}
+	return errors.New("user already member")
+}
+
+// RemoveMembers removes the members from the circle in one write
+func (cr *CircleRepository) RemoveMembers(ctx context.Context, circleID string, userIDs []primitive.ObjectID) error {
+	objectID, err := primitive.ObjectIDFromHex(circleID)
+	if err != nil {
+		return errors.New("invalid circle ID")
+	}
+
+	result, err := cr.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": objectID},
+		bson.M{
+			"$pull": bson.M{"members": bson.M{"userId": bson.M{"$in": userIDs}}},
+			"$set":  bson.M{"updatedAt": time.Now()},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("circle not found")
+	}
+
+	return nil
+}
+
 func (cr *CircleRepository) UpdateMemberPermissions(ctx context.Context, circleID, userID string, permissions models.MemberPermissions) error {
 	circleObjectID, err := primitive.ObjectIDFromHex(circleID)
 	if err != nil {
@@ -261,6 +351,153 @@ func (cr *CircleRepository) UpdateMemberPermissions(ctx context.Context, circleI
 	return nil
 }
 
+// UpdateMemberNearbyAlerts sets the member's own nearby alert opt-in
+func (cr *CircleRepository) UpdateMemberNearbyAlerts(ctx context.Context, circleID, userID string, settings models.NearbyAlertSettings) error {
+	circleObjectID, err := primitive.ObjectIDFromHex(circleID)
+	if err != nil {
+		return errors.New("invalid circle ID")
+	}
+
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return errors.New("invalid user ID")
+	}
+
+	result, err := cr.collection.UpdateOne(
+		ctx,
+		bson.M{
+			"_id":            circleObjectID,
+			"members.userId": userObjectID,
+		},
+		bson.M{
+			"$set": bson.M{
+				"members.$.nearbyAlerts": settings,
+				"updatedAt":              time.Now(),
+			},
+		},
+	)
+
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return errors.New("circle or member not found")
+	}
+
+	return nil
+}
+
+// UpdateMemberDrivingSafety sets the member's own driving safety sharing
+func (cr *CircleRepository) UpdateMemberDrivingSafety(ctx context.Context, circleID, userID string, sharing models.DrivingSafetySharing) error {
+	circleObjectID, err := primitive.ObjectIDFromHex(circleID)
+	if err != nil {
+		return errors.New("invalid circle ID")
+	}
+
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return errors.New("invalid user ID")
+	}
+
+	result, err := cr.collection.UpdateOne(
+		ctx,
+		bson.M{
+			"_id":            circleObjectID,
+			"members.userId": userObjectID,
+		},
+		bson.M{
+			"$set": bson.M{
+				"members.$.drivingSafety": sharing,
+				"updatedAt":               time.Now(),
+			},
+		},
+	)
+
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return errors.New("circle or member not found")
+	}
+
+	return nil
+}
+
+// GetCirclesWithDrivingSafetyReports returns up to limit circles where a
+// member shares weekly safety reports with someone, in ID order after
+// afterID
+func (cr *CircleRepository) GetCirclesWithDrivingSafetyReports(ctx context.Context, afterID primitive.ObjectID, limit int) ([]models.Circle, error) {
+	filter := bson.M{
+		"members": bson.M{"$elemMatch": bson.M{
+			"status":                        "active",
+			"drivingSafety.enabled":         true,
+			"drivingSafety.notifyMemberIds": bson.M{"$exists": true, "$ne": bson.A{}},
+		}},
+		"archivedAt": bson.M{"$exists": false},
+	}
+	if !afterID.IsZero() {
+		filter["_id"] = bson.M{"$gt": afterID}
+	}
+
+	opts := options.Find().SetSort(bson.M{"_id": 1}).SetLimit(int64(limit))
+	cursor, err := cr.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var circles []models.Circle
+	err = cursor.All(ctx, &circles)
+	return circles, err
+}
+
+// SetMemberSafetyReported records the start of the last week the member's
+// safety report went out for. It only matches while sharing is still on.
+func (cr *CircleRepository) SetMemberSafetyReported(ctx context.Context, circleID, userID primitive.ObjectID, week time.Time) error {
+	_, err := cr.collection.UpdateOne(ctx,
+		bson.M{"_id": circleID, "members": bson.M{"$elemMatch": bson.M{"userId": userID, "drivingSafety.enabled": true}}},
+		bson.M{"$set": bson.M{"members.$.drivingSafety.lastReportedWeek": week}},
+	)
+	return err
+}
+
+// GetCirclesWithOfflineAlerts returns up to limit circles that alert their
+// members when one goes offline, in ID order after afterID
+func (cr *CircleRepository) GetCirclesWithOfflineAlerts(ctx context.Context, afterID primitive.ObjectID, limit int) ([]models.Circle, error) {
+	filter := bson.M{
+		"settings.offlineAlerts": true,
+		"archivedAt":             bson.M{"$exists": false},
+	}
+	if !afterID.IsZero() {
+		filter["_id"] = bson.M{"$gt": afterID}
+	}
+
+	opts := options.Find().SetSort(bson.M{"_id": 1}).SetLimit(int64(limit))
+	cursor, err := cr.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var circles []models.Circle
+	err = cursor.All(ctx, &circles)
+	return circles, err
+}
+
+// SetMemberOfflineAlerted records when the circle was told the member went
+// offline, or clears it when at is nil
+func (cr *CircleRepository) SetMemberOfflineAlerted(ctx context.Context, circleID, userID primitive.ObjectID, at *time.Time) error {
+	update := bson.M{"$unset": bson.M{"members.$.offlineAlertedAt": ""}}
+	if at != nil {
+		update = bson.M{"$set": bson.M{"members.$.offlineAlertedAt": *at}}
+	}
+
+	_, err := cr.collection.UpdateOne(ctx, bson.M{"_id": circleID, "members.userId": userID}, update)
+	return err
+}
+
 func (cr *CircleRepository) UpdateMemberRole(ctx context.Context, circleID, userID, role string) error {
 	circleObjectID, err := primitive.ObjectIDFromHex(circleID)
 	if err != nil {
@@ -297,6 +534,71 @@ func (cr *CircleRepository) UpdateMemberRole(ctx context.Context, circleID, user
 	return nil
 }
 
+// TransferOwnership hands the circle to another admin member in a single
+// update. The filter only matches while the current owner still owns the
+// circle and the new owner is still an admin, so concurrent role changes
+// cannot leave the circle in a half-transferred state.
+func (cr *CircleRepository) TransferOwnership(ctx context.Context, circleID, currentOwnerID, newOwnerID string) error {
+	circleObjectID, err := primitive.ObjectIDFromHex(circleID)
+	if err != nil {
+		return errors.New("invalid circle ID")
+	}
+
+	currentOwnerObjectID, err := primitive.ObjectIDFromHex(currentOwnerID)
+	if err != nil {
+		return errors.New("invalid user ID")
+	}
+
+	newOwnerObjectID, err := primitive.ObjectIDFromHex(newOwnerID)
+	if err != nil {
+		return errors.New("invalid user ID")
+	}
+
+	filter := bson.M{
+		"_id":     circleObjectID,
+		"adminId": currentOwnerObjectID,
+		"members": bson.M{"$elemMatch": bson.M{
+			"userId": newOwnerObjectID,
+			"role":   "admin",
+		}},
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"adminId":                  newOwnerObjectID,
+			"members.$[previous].role": "admin",
+			"members.$[next].role":     "admin",
+			"members.$[next].permissions": models.MemberPermissions{
+				CanSeeLocation:   true,
+				CanSeeDriving:    true,
+				CanSendMessages:  true,
+				CanManagePlaces:  true,
+				CanReceiveAlerts: true,
+				CanSendEmergency: true,
+			},
+			"updatedAt": time.Now(),
+		},
+	}
+
+	opts := options.Update().SetArrayFilters(options.ArrayFilters{
+		Filters: []interface{}{
+			bson.M{"previous.userId": currentOwnerObjectID},
+			bson.M{"next.userId": newOwnerObjectID},
+		},
+	})
+
+	result, err := cr.collection.UpdateOne(ctx, filter, update, opts)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return errors.New("ownership transfer conflict")
+	}
+
+	return nil
+}
+
 func (cr *CircleRepository) UpdateLastActivity(ctx context.Context, circleID, userID string) error {
 	circleObjectID, err := primitive.ObjectIDFromHex(circleID)
 	if err != nil {
@@ -326,6 +628,40 @@ func (cr *CircleRepository) UpdateLastActivity(ctx context.Context, circleID, us
 	return err
 }
 
+// SetMemberStatusEverywhere moves the user's membership from one status to
+// another in every circle they belong to
+func (cr *CircleRepository) SetMemberStatusEverywhere(ctx context.Context, userID, fromStatus, toStatus string) (int64, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return 0, errors.New("invalid user ID")
+	}
+
+	opts := options.Update().SetArrayFilters(options.ArrayFilters{
+		Filters: []interface{}{
+			bson.M{"member.userId": userObjectID, "member.status": fromStatus},
+		},
+	})
+
+	result, err := cr.collection.UpdateMany(
+		ctx,
+		bson.M{
+			"members": bson.M{"$elemMatch": bson.M{"userId": userObjectID, "status": fromStatus}},
+		},
+		bson.M{
+			"$set": bson.M{
+				"members.$[member].status": toStatus,
+				"updatedAt":                time.Now(),
+			},
+		},
+		opts,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+}
+
 func (cr *CircleRepository) IsMember(ctx context.Context, circleID, userID string) (bool, error) {
 	circleObjectID, err := primitive.ObjectIDFromHex(circleID)
 	if err != nil {
@@ -345,6 +681,22 @@ func (cr *CircleRepository) IsMember(ctx context.Context, circleID, userID strin
 	return count > 0, err
 }
 
+// IsArchived reports whether the circle was merged into another one and is
+// read-only
+func (cr *CircleRepository) IsArchived(ctx context.Context, circleID string) (bool, error) {
+	objectID, err := primitive.ObjectIDFromHex(circleID)
+	if err != nil {
+		return false, errors.New("invalid circle ID")
+	}
+
+	count, err := cr.collection.CountDocuments(ctx, bson.M{
+		"_id":        objectID,
+		"archivedAt": bson.M{"$exists": true},
+	})
+
+	return count > 0, err
+}
+
 func (cr *CircleRepository) GetMemberRole(ctx context.Context, circleID, userID string) (string, error) {
 	circleObjectID, err := primitive.ObjectIDFromHex(circleID)
 	if err != nil {
@@ -385,6 +737,258 @@ func (cr *CircleRepository) GetMemberRole(ctx context.Context, circleID, userID
 	return "", errors.New("member not found")
 }
 
+// HasPermission reports whether the member's role grants the permission.
+// Admins have every permission, other members those of the custom role
+// assigned to them.
+func (cr *CircleRepository) HasPermission(ctx context.Context, circleID, userID, permission string) (bool, error) {
+	circleObjectID, err := primitive.ObjectIDFromHex(circleID)
+	if err != nil {
+		return false, errors.New("invalid circle ID")
+	}
+
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return false, errors.New("invalid user ID")
+	}
+
+	var circle models.Circle
+	err = cr.collection.FindOne(ctx,
+		bson.M{"_id": circleObjectID, "members.userId": userObjectID},
+		options.FindOne().SetProjection(bson.M{"members.$": 1}),
+	).Decode(&circle)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return false, errors.New("member not found")
+		}
+		return false, err
+	}
+	if len(circle.Members) == 0 {
+		return false, errors.New("member not found")
+	}
+
+	member := circle.Members[0]
+	if member.Role == "admin" {
+		return true, nil
+	}
+	if member.CustomRoleID == nil {
+		return false, nil
+	}
+
+	var role models.CircleRole
+	err = cr.GetRoleCollection().FindOne(ctx, bson.M{"_id": *member.CustomRoleID, "circleId": circleObjectID}).Decode(&role)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return false, nil
+		}
+		return false, err
+	}
+	return role.Grants(permission), nil
+}
+
+// SetMemberCustomRole assigns the custom role to the member, or takes
+// theirs away when it is nil
+func (cr *CircleRepository) SetMemberCustomRole(ctx context.Context, circleID, userID string, roleID *primitive.ObjectID) error {
+	circleObjectID, err := primitive.ObjectIDFromHex(circleID)
+	if err != nil {
+		return errors.New("invalid circle ID")
+	}
+
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return errors.New("invalid user ID")
+	}
+
+	update := bson.M{"$set": bson.M{"members.$.customRoleId": roleID, "updatedAt": time.Now()}}
+	if roleID == nil {
+		update = bson.M{
+			"$unset": bson.M{"members.$.customRoleId": ""},
+			"$set":   bson.M{"updatedAt": time.Now()},
+		}
+	}
+
+	result, err := cr.collection.UpdateOne(ctx,
+		bson.M{"_id": circleObjectID, "members.userId": userObjectID},
+		update,
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("member not found")
+	}
+	return nil
+}
+
+// ========================
+// Custom Role Management
+// ========================
+
+func (cr *CircleRepository) CreateRole(ctx context.Context, role *models.CircleRole) error {
+	role.ID = primitive.NewObjectID()
+	role.CreatedAt = time.Now()
+	role.UpdatedAt = role.CreatedAt
+
+	_, err := cr.GetRoleCollection().InsertOne(ctx, role)
+	return err
+}
+
+func (cr *CircleRepository) GetRole(ctx context.Context, circleID, roleID string) (*models.CircleRole, error) {
+	circleObjectID, err := primitive.ObjectIDFromHex(circleID)
+	if err != nil {
+		return nil, errors.New("invalid circle ID")
+	}
+
+	roleObjectID, err := primitive.ObjectIDFromHex(roleID)
+	if err != nil {
+		return nil, errors.New("invalid role ID")
+	}
+
+	var role models.CircleRole
+	err = cr.GetRoleCollection().FindOne(ctx, bson.M{"_id": roleObjectID, "circleId": circleObjectID}).Decode(&role)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("role not found")
+		}
+		return nil, err
+	}
+	return &role, nil
+}
+
+// GetRoles returns the circle's custom roles by name
+func (cr *CircleRepository) GetRoles(ctx context.Context, circleID string) ([]models.CircleRole, error) {
+	circleObjectID, err := primitive.ObjectIDFromHex(circleID)
+	if err != nil {
+		return nil, errors.New("invalid circle ID")
+	}
+
+	cursor, err := cr.GetRoleCollection().Find(ctx,
+		bson.M{"circleId": circleObjectID},
+		options.Find().SetSort(bson.M{"name": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	roles := []models.CircleRole{}
+	err = cursor.All(ctx, &roles)
+	return roles, err
+}
+
+func (cr *CircleRepository) UpdateRole(ctx context.Context, role *models.CircleRole) error {
+	role.UpdatedAt = time.Now()
+
+	result, err := cr.GetRoleCollection().UpdateOne(ctx,
+		bson.M{"_id": role.ID, "circleId": role.CircleID},
+		bson.M{"$set": bson.M{
+			"name":        role.Name,
+			"description": role.Description,
+			"permissions": role.Permissions,
+			"updatedAt":   role.UpdatedAt,
+		}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("role not found")
+	}
+	return nil
+}
+
+// DeleteRole deletes the custom role and takes it away from the members it
+// was assigned to
+func (cr *CircleRepository) DeleteRole(ctx context.Context, circleID, roleID primitive.ObjectID) error {
+	result, err := cr.GetRoleCollection().DeleteOne(ctx, bson.M{"_id": roleID, "circleId": circleID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errors.New("role not found")
+	}
+
+	opts := options.Update().SetArrayFilters(options.ArrayFilters{
+		Filters: []interface{}{bson.M{"member.customRoleId": roleID}},
+	})
+	_, err = cr.collection.UpdateOne(ctx,
+		bson.M{"_id": circleID, "members.customRoleId": roleID},
+		bson.M{
+			"$unset": bson.M{"members.$[member].customRoleId": ""},
+			"$set":   bson.M{"updatedAt": time.Now()},
+		},
+		opts,
+	)
+	return err
+}
+
+// ========================
+// Moments
+// ========================
+
+func (cr *CircleRepository) CreateMoment(ctx context.Context, moment *models.CircleMoment) error {
+	moment.ID = primitive.NewObjectID()
+	moment.CreatedAt = time.Now()
+
+	_, err := cr.GetMomentCollection().InsertOne(ctx, moment)
+	return err
+}
+
+func (cr *CircleRepository) GetMoment(ctx context.Context, circleID, momentID string) (*models.CircleMoment, error) {
+	circleObjectID, err := primitive.ObjectIDFromHex(circleID)
+	if err != nil {
+		return nil, errors.New("invalid circle ID")
+	}
+
+	momentObjectID, err := primitive.ObjectIDFromHex(momentID)
+	if err != nil {
+		return nil, errors.New("invalid moment ID")
+	}
+
+	var moment models.CircleMoment
+	err = cr.GetMomentCollection().FindOne(ctx, bson.M{"_id": momentObjectID, "circleId": circleObjectID}).Decode(&moment)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("moment not found")
+		}
+		return nil, err
+	}
+	return &moment, nil
+}
+
+func (cr *CircleRepository) DeleteMoment(ctx context.Context, momentID primitive.ObjectID) error {
+	_, err := cr.GetMomentCollection().DeleteOne(ctx, bson.M{"_id": momentID})
+	return err
+}
+
+// GetMomentFeed returns the circle's newest moments shared by the given
+// members, and how many they shared in all
+func (cr *CircleRepository) GetMomentFeed(ctx context.Context, circleID primitive.ObjectID, authorIDs []primitive.ObjectID, limit int) ([]models.CircleMoment, int64, error) {
+	filter := bson.M{
+		"circleId": circleID,
+		"userId":   bson.M{"$in": authorIDs},
+	}
+
+	total, err := cr.GetMomentCollection().CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}}).
+		SetLimit(int64(limit))
+	cursor, err := cr.GetMomentCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	moments := []models.CircleMoment{}
+	if err := cursor.All(ctx, &moments); err != nil {
+		return nil, 0, err
+	}
+	return moments, total, nil
+}
+
 // ========================
 // Invitation Management
 // ========================
@@ -650,6 +1254,44 @@ func (cr *CircleRepository) GetUserJoinRequests(ctx context.Context, userID stri
 	return joinRequests, err
 }
 
+// CountPendingJoinRequests counts the pending join requests of each of the
+// circles. Circles with none are left out.
+func (cr *CircleRepository) CountPendingJoinRequests(ctx context.Context, circleIDs []primitive.ObjectID) (map[primitive.ObjectID]int64, error) {
+	return countByCircle(ctx, cr.GetJoinRequestCollection(), bson.M{
+		"circleId": bson.M{"$in": circleIDs},
+		"status":   "pending",
+	})
+}
+
+// countByCircle counts the documents matching the filter in each circle, in
+// one aggregation
+func countByCircle(ctx context.Context, collection *database.Collection, match bson.M) (map[primitive.ObjectID]int64, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{"_id": "$circleId", "count": bson.M{"$sum": 1}}}},
+	}
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		CircleID primitive.ObjectID `bson:"_id"`
+		Count    int64              `bson:"count"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[primitive.ObjectID]int64, len(results))
+	for _, result := range results {
+		counts[result.CircleID] = result.Count
+	}
+	return counts, nil
+}
+
 // ========================
 // Announcement Management
 // ========================
@@ -666,7 +1308,7 @@ func (cr *CircleRepository) CreateAnnouncement(ctx context.Context, announcement
 }
 
 func (cr *CircleRepository) GetCircleAnnouncements(ctx context.Context, circleID string, page, pageSize int) ([]models.CircleAnnouncement, error) {
-	_, err := primitive.ObjectIDFromHex(circleID)
+	circleObjectID, err := primitive.ObjectIDFromHex(circleID)
 	if err != nil {
 		return nil, errors.New("invalid circle ID")
 	}
@@ -676,6 +1318,7 @@ func (cr *CircleRepository) GetCircleAnnouncements(ctx context.Context, circleID
 	skip := (page - 1) * pageSize
 	cursor, err := announcementCollection.Find(
 		ctx,
+		bson.M{"circleId": circleObjectID},
 		&options.FindOptions{
 			Sort:  bson.M{"createdAt": -1},
 			Skip:  &[]int64{int64(skip)}[0],
@@ -692,6 +1335,15 @@ func (cr *CircleRepository) GetCircleAnnouncements(ctx context.Context, circleID
 	return announcements, err
 }
 
+// CountCircleAnnouncementsSince counts the announcements the circle sent
+// since the time
+func (cr *CircleRepository) CountCircleAnnouncementsSince(ctx context.Context, circleID primitive.ObjectID, since time.Time) (int64, error) {
+	return cr.GetAnnouncementCollection().CountDocuments(ctx, bson.M{
+		"circleId":  circleID,
+		"createdAt": bson.M{"$gte": since},
+	})
+}
+
 func (cr *CircleRepository) GetAnnouncementByID(ctx context.Context, announcementID string) (*models.CircleAnnouncement, error) {
 	objectID, err := primitive.ObjectIDFromHex(announcementID)
 	if err != nil {
@@ -918,6 +1570,158 @@ func (cr *CircleRepository) GetUserExportJobs(ctx context.Context, userID string
 	return exportJobs, err
 }
 
+// ========================
+// Merge Job Management
+// ========================
+
+func (cr *CircleRepository) CreateMergeJob(ctx context.Context, job *models.CircleMergeJob) error {
+	job.ID = primitive.NewObjectID()
+
+	// One unfinished merge per source circle, enforced by a unique index
+	_, err := cr.GetMergeJobCollection().InsertOne(ctx, job)
+	if mongo.IsDuplicateKeyError(err) {
+		return errors.New("circle merge already running")
+	}
+	return err
+}
+
+func (cr *CircleRepository) GetMergeJobByID(ctx context.Context, jobID string) (*models.CircleMergeJob, error) {
+	objectID, err := primitive.ObjectIDFromHex(jobID)
+	if err != nil {
+		return nil, errors.New("invalid job ID")
+	}
+
+	var job models.CircleMergeJob
+	err = cr.GetMergeJobCollection().FindOne(ctx, bson.M{"_id": objectID}).Decode(&job)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("merge job not found")
+		}
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// GetUnfinishedMergeJobs returns the running or failed merges the circles
+// take part in, as source or target
+func (cr *CircleRepository) GetUnfinishedMergeJobs(ctx context.Context, circleIDs []primitive.ObjectID) ([]models.CircleMergeJob, error) {
+	filter := bson.M{
+		"status": bson.M{"$in": []string{models.CircleMergeStatusRunning, models.CircleMergeStatusFailed}},
+		"$or": []bson.M{
+			{"sourceCircleId": bson.M{"$in": circleIDs}},
+			{"targetCircleId": bson.M{"$in": circleIDs}},
+		},
+	}
+
+	cursor, err := cr.GetMergeJobCollection().Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var jobs []models.CircleMergeJob
+	err = cursor.All(ctx, &jobs)
+	return jobs, err
+}
+
+// UpdateMergeJob applies an update document, so callers can combine $set
+// with $inc and $push on the report
+func (cr *CircleRepository) UpdateMergeJob(ctx context.Context, jobID primitive.ObjectID, update bson.M) error {
+	result, err := cr.GetMergeJobCollection().UpdateOne(ctx, bson.M{"_id": jobID}, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return errors.New("merge job not found")
+	}
+
+	return nil
+}
+
+// ClaimMergeJob marks a merge as running on this instance. Failed merges
+// and running ones whose heartbeat is older than staleBefore can be claimed;
+// nil means another instance has it.
+func (cr *CircleRepository) ClaimMergeJob(ctx context.Context, jobID primitive.ObjectID, staleBefore time.Time) (*models.CircleMergeJob, error) {
+	filter := bson.M{
+		"_id": jobID,
+		"$or": []bson.M{
+			{"status": models.CircleMergeStatusFailed},
+			{"status": models.CircleMergeStatusRunning, "heartbeatAt": bson.M{"$lt": staleBefore}},
+		},
+	}
+
+	return cr.claimMergeJob(ctx, filter)
+}
+
+// ClaimStaleMergeJob claims a running merge whose instance stopped sending
+// heartbeats. It returns nil when there is none.
+func (cr *CircleRepository) ClaimStaleMergeJob(ctx context.Context, staleBefore time.Time) (*models.CircleMergeJob, error) {
+	filter := bson.M{
+		"status":      models.CircleMergeStatusRunning,
+		"heartbeatAt": bson.M{"$lt": staleBefore},
+	}
+
+	return cr.claimMergeJob(ctx, filter)
+}
+
+func (cr *CircleRepository) claimMergeJob(ctx context.Context, filter bson.M) (*models.CircleMergeJob, error) {
+	update := bson.M{
+		"$set": bson.M{
+			"status":      models.CircleMergeStatusRunning,
+			"heartbeatAt": time.Now(),
+		},
+		"$unset": bson.M{"errorMsg": ""},
+		"$inc":   bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var job models.CircleMergeJob
+	err := cr.GetMergeJobCollection().FindOneAndUpdate(ctx, filter, update, opts).Decode(&job)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// ArchiveCircle makes a circle read-only as merged into another one. Its
+// deletion is scheduled separately once the merge completes.
+func (cr *CircleRepository) ArchiveCircle(ctx context.Context, circleID, mergedInto primitive.ObjectID) error {
+	now := time.Now()
+	_, err := cr.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": circleID, "archivedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{
+			"archivedAt": now,
+			"mergedInto": mergedInto,
+			"updatedAt":  now,
+		}},
+	)
+	return err
+}
+
+// GetExpiredArchivedCircles returns archived circles due for deletion
+func (cr *CircleRepository) GetExpiredArchivedCircles(ctx context.Context, before time.Time, limit int) ([]models.Circle, error) {
+	opts := options.Find().SetLimit(int64(limit))
+	cursor, err := cr.collection.Find(ctx, bson.M{
+		"archivedAt":  bson.M{"$exists": true},
+		"deleteAfter": bson.M{"$lte": before},
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var circles []models.Circle
+	err = cursor.All(ctx, &circles)
+	return circles, err
+}
+
 // ========================
 // Search and Discovery
 // ========================
@@ -0,0 +1,133 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"ftrack/database"
+	"ftrack/models"
+	"ftrack/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// contentEncryption stores the content of messages and drafts encrypted
+// for circles with EncryptMessages set. Content is decrypted on read
+// whatever the circle's current setting, so turning it off later leaves
+// old content readable.
+type contentEncryption struct {
+	circles *database.Collection
+}
+
+func newContentEncryption(db *mongo.Database) contentEncryption {
+	return contentEncryption{circles: database.NewCollection(db, "circles")}
+}
+
+// circleEncrypted reports whether the circle stores content encrypted
+func (ce contentEncryption) circleEncrypted(ctx context.Context, circleID primitive.ObjectID) (bool, error) {
+	var circle struct {
+		Settings struct {
+			EncryptMessages bool `bson:"encryptMessages"`
+		} `bson:"settings"`
+	}
+
+	opts := options.FindOne().SetProjection(bson.M{"settings.encryptMessages": 1})
+	err := ce.circles.FindOne(ctx, bson.M{"_id": circleID}, opts).Decode(&circle)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return circle.Settings.EncryptMessages, nil
+}
+
+// seal returns content as it is stored in the circle: ciphertext and the
+// key ID when the circle is encrypted, else the content and no key ID
+func (ce contentEncryption) seal(ctx context.Context, circleID primitive.ObjectID, content string) (string, string, error) {
+	if content == "" {
+		return "", "", nil
+	}
+
+	encrypted, err := ce.circleEncrypted(ctx, circleID)
+	if err != nil || !encrypted {
+		return content, "", err
+	}
+
+	return utils.EncryptContent(content)
+}
+
+// sealUpdate encrypts the content in a $set for the circle. It returns the
+// $unset that drops a key ID left from earlier content, if any.
+func (ce contentEncryption) sealUpdate(ctx context.Context, circleID primitive.ObjectID, set bson.M) (bson.M, error) {
+	content, exists := set["content"].(string)
+	if !exists {
+		return nil, nil
+	}
+
+	stored, keyID, err := ce.seal(ctx, circleID, content)
+	if err != nil {
+		return nil, err
+	}
+
+	set["content"] = stored
+	if keyID != "" {
+		set["contentKeyId"] = keyID
+		return nil, nil
+	}
+	return bson.M{"contentKeyId": ""}, nil
+}
+
+// DecryptMessages replaces encrypted message content with its plain text.
+// Code reading messages outside the repository must call it too.
+func DecryptMessages(messages []models.Message) error {
+	for i := range messages {
+		if err := decryptMessage(&messages[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decryptMessage(message *models.Message) error {
+	if message.ContentKeyID == "" {
+		return nil
+	}
+
+	content, err := utils.DecryptContent(message.Content, message.ContentKeyID)
+	if err != nil {
+		return fmt.Errorf("decrypt message %s: %w", message.ID.Hex(), err)
+	}
+
+	message.Content = content
+	message.ContentKeyID = ""
+	return nil
+}
+
+func decryptDrafts(drafts []models.MessageDraft) error {
+	for i := range drafts {
+		if err := decryptDraft(&drafts[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decryptDraft(draft *models.MessageDraft) error {
+	if draft.ContentKeyID == "" {
+		return nil
+	}
+
+	content, err := utils.DecryptContent(draft.Content, draft.ContentKeyID)
+	if err != nil {
+		return fmt.Errorf("decrypt draft %s: %w", draft.ID.Hex(), err)
+	}
+
+	draft.Content = content
+	draft.ContentKeyID = ""
+	return nil
+}
@@ -0,0 +1,174 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"ftrack/database"
+	"ftrack/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type DepartureReminderRepository struct {
+	collection *database.Collection
+}
+
+func NewDepartureReminderRepository(db *mongo.Database) *DepartureReminderRepository {
+	return &DepartureReminderRepository{
+		collection: database.NewCollection(db, "departure_reminders"),
+	}
+}
+
+func (dr *DepartureReminderRepository) Create(ctx context.Context, reminder *models.DepartureReminder) error {
+	reminder.ID = primitive.NewObjectID()
+	reminder.CreatedAt = time.Now()
+	reminder.UpdatedAt = time.Now()
+
+	_, err := dr.collection.InsertOne(ctx, reminder)
+	if mongo.IsDuplicateKeyError(err) {
+		return errors.New("departure reminder already exists")
+	}
+	return err
+}
+
+// GetByID returns one of the user's reminders. Reminders are private, so
+// every lookup is scoped to the owner.
+func (dr *DepartureReminderRepository) GetByID(ctx context.Context, userID, reminderID string) (*models.DepartureReminder, error) {
+	filter, err := reminderFilter(userID, reminderID)
+	if err != nil {
+		return nil, err
+	}
+
+	var reminder models.DepartureReminder
+	err = dr.collection.FindOne(ctx, filter).Decode(&reminder)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("departure reminder not found")
+		}
+		return nil, err
+	}
+
+	return &reminder, nil
+}
+
+func (dr *DepartureReminderRepository) GetUserReminders(ctx context.Context, userID string) ([]models.DepartureReminder, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	cursor, err := dr.collection.Find(ctx, bson.M{"userId": userObjectID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	reminders := []models.DepartureReminder{}
+	err = cursor.All(ctx, &reminders)
+	return reminders, err
+}
+
+func (dr *DepartureReminderRepository) Update(ctx context.Context, userID, reminderID string, update bson.M) error {
+	filter, err := reminderFilter(userID, reminderID)
+	if err != nil {
+		return err
+	}
+
+	update["updatedAt"] = time.Now()
+
+	result, err := dr.collection.UpdateOne(ctx, filter, bson.M{"$set": update})
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return errors.New("departure reminder not found")
+	}
+
+	return nil
+}
+
+func (dr *DepartureReminderRepository) Delete(ctx context.Context, userID, reminderID string) error {
+	filter, err := reminderFilter(userID, reminderID)
+	if err != nil {
+		return err
+	}
+
+	result, err := dr.collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return errors.New("departure reminder not found")
+	}
+
+	return nil
+}
+
+// GetDue returns enabled reminders whose next reminder time has passed
+func (dr *DepartureReminderRepository) GetDue(ctx context.Context, now time.Time, limit int) ([]models.DepartureReminder, error) {
+	filter := bson.M{
+		"enabled":        true,
+		"nextReminderAt": bson.M{"$lte": now},
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "nextReminderAt", Value: 1}}).
+		SetLimit(int64(limit))
+
+	cursor, err := dr.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var reminders []models.DepartureReminder
+	err = cursor.All(ctx, &reminders)
+	return reminders, err
+}
+
+// GetStalePatterns returns enabled reminders whose pattern was computed
+// before the cutoff, oldest first
+func (dr *DepartureReminderRepository) GetStalePatterns(ctx context.Context, computedBefore time.Time, limit int) ([]models.DepartureReminder, error) {
+	filter := bson.M{
+		"enabled": true,
+		"$or": []bson.M{
+			{"pattern.computedAt": bson.M{"$lt": computedBefore}},
+			{"pattern.computedAt": bson.M{"$exists": false}},
+		},
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "pattern.computedAt", Value: 1}}).
+		SetLimit(int64(limit))
+
+	cursor, err := dr.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var reminders []models.DepartureReminder
+	err = cursor.All(ctx, &reminders)
+	return reminders, err
+}
+
+func reminderFilter(userID, reminderID string) (bson.M, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+	reminderObjectID, err := primitive.ObjectIDFromHex(reminderID)
+	if err != nil {
+		return nil, errors.New("invalid departure reminder ID")
+	}
+
+	return bson.M{"_id": reminderObjectID, "userId": userObjectID}, nil
+}
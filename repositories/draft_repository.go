@@ -3,6 +3,7 @@ package repositories
 import (
 	"context"
 	"errors"
+	"ftrack/database"
 	"ftrack/models"
 	"time"
 
@@ -13,12 +14,14 @@ import (
 )
 
 type DraftRepository struct {
-	collection *mongo.Collection
+	collection *database.Collection
+	encryption contentEncryption
 }
 
 func NewDraftRepository(db *mongo.Database) *DraftRepository {
 	return &DraftRepository{
-		collection: db.Collection("message_drafts"),
+		collection: database.NewCollection(db, "message_drafts"),
+		encryption: newContentEncryption(db),
 	}
 }
 
@@ -27,7 +30,16 @@ func (dr *DraftRepository) Create(ctx context.Context, draft *models.MessageDraf
 	draft.CreatedAt = time.Now()
 	draft.UpdatedAt = time.Now()
 
-	_, err := dr.collection.InsertOne(ctx, draft)
+	// Store a copy, so the caller keeps the plain content
+	stored := *draft
+	content, keyID, err := dr.encryption.seal(ctx, draft.CircleID, draft.Content)
+	if err != nil {
+		return err
+	}
+	stored.Content = content
+	stored.ContentKeyID = keyID
+
+	_, err = dr.collection.InsertOne(ctx, &stored)
 	return err
 }
 
@@ -50,6 +62,10 @@ func (dr *DraftRepository) GetByID(ctx context.Context, id string) (*models.Mess
 		return nil, err
 	}
 
+	if err := decryptDraft(&draft); err != nil {
+		return nil, err
+	}
+
 	return &draft, nil
 }
 
@@ -61,13 +77,36 @@ func (dr *DraftRepository) Update(ctx context.Context, id string, update bson.M)
 
 	update["updatedAt"] = time.Now()
 
+	change := bson.M{"$set": update}
+	if _, exists := update["content"]; exists {
+		var draft struct {
+			CircleID primitive.ObjectID `bson:"circleId"`
+		}
+		opts := options.FindOne().SetProjection(bson.M{"circleId": 1})
+		err := dr.collection.FindOne(ctx, bson.M{"_id": objectID}, opts).Decode(&draft)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return errors.New("draft not found")
+			}
+			return err
+		}
+
+		unset, err := dr.encryption.sealUpdate(ctx, draft.CircleID, update)
+		if err != nil {
+			return err
+		}
+		if unset != nil {
+			change["$unset"] = unset
+		}
+	}
+
 	result, err := dr.collection.UpdateOne(
 		ctx,
 		bson.M{
 			"_id":       objectID,
 			"isDeleted": bson.M{"$ne": true},
 		},
-		bson.M{"$set": update},
+		change,
 	)
 
 	if err != nil {
@@ -138,8 +177,10 @@ func (dr *DraftRepository) GetUserDrafts(ctx context.Context, userID string, req
 	defer cursor.Close(ctx)
 
 	var drafts []models.MessageDraft
-	err = cursor.All(ctx, &drafts)
-	return drafts, total, err
+	if err := cursor.All(ctx, &drafts); err != nil {
+		return nil, 0, err
+	}
+	return drafts, total, decryptDrafts(drafts)
 }
 
 func (dr *DraftRepository) GetByCircle(ctx context.Context, userID, circleID string) ([]models.MessageDraft, error) {
@@ -168,8 +209,10 @@ func (dr *DraftRepository) GetByCircle(ctx context.Context, userID, circleID str
 	defer cursor.Close(ctx)
 
 	var drafts []models.MessageDraft
-	err = cursor.All(ctx, &drafts)
-	return drafts, err
+	if err := cursor.All(ctx, &drafts); err != nil {
+		return nil, err
+	}
+	return drafts, decryptDrafts(drafts)
 }
 
 func (dr *DraftRepository) DeleteOldDrafts(ctx context.Context, olderThan time.Time) error {
@@ -218,6 +261,8 @@ func (dr *DraftRepository) GetRecentDrafts(ctx context.Context, userID string, l
 	defer cursor.Close(ctx)
 
 	var drafts []models.MessageDraft
-	err = cursor.All(ctx, &drafts)
-	return drafts, err
+	if err := cursor.All(ctx, &drafts); err != nil {
+		return nil, err
+	}
+	return drafts, decryptDrafts(drafts)
 }
\ No newline at end of file
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"ftrack/database"
 	"ftrack/models"
 	"time"
 
@@ -16,33 +17,33 @@ import (
 
 type EmergencyRepository struct {
 	database            *mongo.Database
-	emergencyCollection *mongo.Collection
-	settingsCollection  *mongo.Collection
-	contactsCollection  *mongo.Collection
-	locationsCollection *mongo.Collection
-	responsesCollection *mongo.Collection
-	checkinsCollection  *mongo.Collection
-	drillsCollection    *mongo.Collection
-	medicalCollection   *mongo.Collection
-	broadcastCollection *mongo.Collection
-	exportsCollection   *mongo.Collection
-	eventsCollection    *mongo.Collection
-}
-
-func NewEmergencyRepository(database *mongo.Database) *EmergencyRepository {
+	emergencyCollection *database.Collection
+	settingsCollection  *database.Collection
+	contactsCollection  *database.Collection
+	locationsCollection *database.Collection
+	responsesCollection *database.Collection
+	checkinsCollection  *database.Collection
+	drillsCollection    *database.Collection
+	medicalCollection   *database.Collection
+	broadcastCollection *database.Collection
+	exportsCollection   *database.Collection
+	eventsCollection    *database.Collection
+}
+
+func NewEmergencyRepository(db *mongo.Database) *EmergencyRepository {
 	return &EmergencyRepository{
-		database:            database,
-		emergencyCollection: database.Collection("emergencies"),
-		settingsCollection:  database.Collection("emergency_settings"),
-		contactsCollection:  database.Collection("emergency_contacts"),
-		locationsCollection: database.Collection("emergency_locations"),
-		responsesCollection: database.Collection("emergency_responses"),
-		checkinsCollection:  database.Collection("emergency_checkins"),
-		drillsCollection:    database.Collection("emergency_drills"),
-		medicalCollection:   database.Collection("emergency_medical"),
-		broadcastCollection: database.Collection("emergency_broadcasts"),
-		exportsCollection:   database.Collection("emergency_exports"),
-		eventsCollection:    database.Collection("emergency_events"),
+		database:            db,
+		emergencyCollection: database.NewCollection(db, "emergencies"),
+		settingsCollection:  database.NewCollection(db, "emergency_settings"),
+		contactsCollection:  database.NewCollection(db, "emergency_contacts"),
+		locationsCollection: database.NewCollection(db, "emergency_locations"),
+		responsesCollection: database.NewCollection(db, "emergency_responses"),
+		checkinsCollection:  database.NewCollection(db, "emergency_checkins"),
+		drillsCollection:    database.NewCollection(db, "emergency_drills"),
+		medicalCollection:   database.NewCollection(db, "emergency_medical"),
+		broadcastCollection: database.NewCollection(db, "emergency_broadcasts"),
+		exportsCollection:   database.NewCollection(db, "emergency_exports"),
+		eventsCollection:    database.NewCollection(db, "emergency_events"),
 	}
 }
 
@@ -268,6 +269,15 @@ func (er *EmergencyRepository) GetCircleEmergencies(ctx context.Context, circleI
 	return emergencies, nil
 }
 
+// CountActiveByCircles counts the active emergencies of each of the
+// circles. Circles with none are left out.
+func (er *EmergencyRepository) CountActiveByCircles(ctx context.Context, circleIDs []primitive.ObjectID) (map[primitive.ObjectID]int64, error) {
+	return countByCircle(ctx, er.emergencyCollection, bson.M{
+		"circleId": bson.M{"$in": circleIDs},
+		"status":   models.EmergencyStatusActive,
+	})
+}
+
 // =================== EMERGENCY CONTACT OPERATIONS ===================
 
 func (er *EmergencyRepository) GetUserEmergencyContacts(ctx context.Context, userID string) ([]models.EmergencyContact, error) {
@@ -276,7 +286,8 @@ func (er *EmergencyRepository) GetUserEmergencyContacts(ctx context.Context, use
 		return nil, errors.New("invalid user ID")
 	}
 
-	cursor, err := er.contactsCollection.Find(ctx, bson.M{"userId": userObjectID})
+	opts := options.Find().SetSort(bson.D{{Key: "priority", Value: 1}, {Key: "createdAt", Value: 1}})
+	cursor, err := er.contactsCollection.Find(ctx, bson.M{"userId": userObjectID}, opts)
 	if err != nil {
 		logrus.Errorf("Failed to get emergency contacts: %v", err)
 		return nil, err
@@ -292,6 +303,9 @@ func (er *EmergencyRepository) GetUserEmergencyContacts(ctx context.Context, use
 	return contacts, nil
 }
 
+// AddEmergencyContact stores a new contact of the user. Each contact takes
+// one of the user's MaxEmergencyContacts slots, unique per user, so
+// concurrent adds can't go over the limit.
 func (er *EmergencyRepository) AddEmergencyContact(ctx context.Context, userID string, contact *models.EmergencyContact) error {
 	userObjectID, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
@@ -299,24 +313,80 @@ func (er *EmergencyRepository) AddEmergencyContact(ctx context.Context, userID s
 	}
 
 	contactDoc := bson.M{
-		"_id":          primitive.NewObjectID(),
-		"userId":       userObjectID,
-		"contactId":    contact.ContactID,
-		"name":         contact.Name,
-		"phone":        contact.Phone,
-		"email":        contact.Email,
-		"relationship": contact.Relationship,
-		"createdAt":    time.Now(),
-		"updatedAt":    time.Now(),
+		"_id":                primitive.NewObjectID(),
+		"userId":             userObjectID,
+		"contactId":          contact.ContactID,
+		"name":               contact.Name,
+		"phone":              contact.Phone,
+		"email":              contact.Email,
+		"relationship":       contact.Relationship,
+		"priority":           contact.Priority,
+		"verificationStatus": contact.VerificationStatus,
+		"verificationToken":  contact.VerificationToken,
+		"verificationSentAt": contact.VerificationSentAt,
+		"createdAt":          time.Now(),
+		"updatedAt":          time.Now(),
+	}
+
+	// A concurrent add may take the slot found free; try the next one
+	for attempt := 0; attempt < models.MaxEmergencyContacts; attempt++ {
+		slot, err := er.freeContactSlot(ctx, userObjectID)
+		if err != nil {
+			return err
+		}
+		if slot < 0 {
+			return errors.New("contact limit exceeded")
+		}
+
+		contactDoc["slot"] = slot
+		_, err = er.contactsCollection.InsertOne(ctx, contactDoc)
+		if mongo.IsDuplicateKeyError(err) {
+			continue
+		}
+		if err != nil {
+			logrus.Errorf("Failed to add emergency contact: %v", err)
+			return err
+		}
+		return nil
 	}
 
-	_, err = er.contactsCollection.InsertOne(ctx, contactDoc)
+	return errors.New("contact limit exceeded")
+}
+
+// freeContactSlot returns the lowest slot the user has no contact in, or
+// -1 once they have MaxEmergencyContacts contacts
+func (er *EmergencyRepository) freeContactSlot(ctx context.Context, userID primitive.ObjectID) (int, error) {
+	cursor, err := er.contactsCollection.Find(ctx,
+		bson.M{"userId": userID},
+		options.Find().SetProjection(bson.M{"slot": 1}),
+	)
 	if err != nil {
-		logrus.Errorf("Failed to add emergency contact: %v", err)
-		return err
+		return 0, err
 	}
+	defer cursor.Close(ctx)
 
-	return nil
+	var contacts []struct {
+		Slot *int `bson:"slot"`
+	}
+	if err := cursor.All(ctx, &contacts); err != nil {
+		return 0, err
+	}
+	if len(contacts) >= models.MaxEmergencyContacts {
+		return -1, nil
+	}
+
+	taken := make(map[int]bool, len(contacts))
+	for _, contact := range contacts {
+		if contact.Slot != nil {
+			taken[*contact.Slot] = true
+		}
+	}
+	for slot := 0; slot < models.MaxEmergencyContacts; slot++ {
+		if !taken[slot] {
+			return slot, nil
+		}
+	}
+	return -1, nil
 }
 
 func (er *EmergencyRepository) UpdateEmergencyContact(ctx context.Context, userID, contactID string, contact *models.EmergencyContact) error {
@@ -331,11 +401,17 @@ func (er *EmergencyRepository) UpdateEmergencyContact(ctx context.Context, userI
 	}
 
 	updateFields := bson.M{
-		"name":         contact.Name,
-		"phone":        contact.Phone,
-		"email":        contact.Email,
-		"relationship": contact.Relationship,
-		"updatedAt":    time.Now(),
+		"name":               contact.Name,
+		"phone":              contact.Phone,
+		"email":              contact.Email,
+		"relationship":       contact.Relationship,
+		"priority":           contact.Priority,
+		"notifiedAt":         contact.NotifiedAt,
+		"notifyMethod":       contact.NotifyMethod,
+		"verificationStatus": contact.VerificationStatus,
+		"verificationToken":  contact.VerificationToken,
+		"verificationSentAt": contact.VerificationSentAt,
+		"updatedAt":          time.Now(),
 	}
 
 	result, err := er.contactsCollection.UpdateOne(
@@ -384,6 +460,93 @@ func (er *EmergencyRepository) DeleteEmergencyContact(ctx context.Context, userI
 	return nil
 }
 
+func (er *EmergencyRepository) CountUserEmergencyContacts(ctx context.Context, userID string) (int64, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return 0, errors.New("invalid user ID")
+	}
+
+	return er.contactsCollection.CountDocuments(ctx, bson.M{"userId": userObjectID})
+}
+
+func (er *EmergencyRepository) GetVerifiedEmergencyContacts(ctx context.Context, userID string) ([]models.EmergencyContact, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	filter := bson.M{
+		"userId":             userObjectID,
+		"verificationStatus": models.ContactVerificationVerified,
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "priority", Value: 1}, {Key: "createdAt", Value: 1}})
+	cursor, err := er.contactsCollection.Find(ctx, filter, opts)
+	if err != nil {
+		logrus.Errorf("Failed to get verified emergency contacts: %v", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var contacts []models.EmergencyContact
+	if err = cursor.All(ctx, &contacts); err != nil {
+		logrus.Errorf("Failed to decode verified emergency contacts: %v", err)
+		return nil, err
+	}
+
+	return contacts, nil
+}
+
+func (er *EmergencyRepository) GetEmergencyContactByVerificationToken(ctx context.Context, token string) (*models.EmergencyContact, primitive.ObjectID, error) {
+	var doc struct {
+		UserID                  primitive.ObjectID `bson:"userId"`
+		models.EmergencyContact `bson:",inline"`
+	}
+
+	err := er.contactsCollection.FindOne(ctx, bson.M{"verificationToken": token}).Decode(&doc)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, primitive.NilObjectID, errors.New("contact not found")
+		}
+		logrus.Errorf("Failed to get emergency contact by token: %v", err)
+		return nil, primitive.NilObjectID, err
+	}
+
+	return &doc.EmergencyContact, doc.UserID, nil
+}
+
+// SetEmergencyContactVerificationStatus records the contact's answer and invalidates the token
+func (er *EmergencyRepository) SetEmergencyContactVerificationStatus(ctx context.Context, token, status string) error {
+	now := time.Now()
+	updateFields := bson.M{
+		"verificationStatus": status,
+		"updatedAt":          now,
+	}
+
+	switch status {
+	case models.ContactVerificationVerified:
+		updateFields["verifiedAt"] = now
+	case models.ContactVerificationDeclined:
+		updateFields["declinedAt"] = now
+	}
+
+	result, err := er.contactsCollection.UpdateOne(
+		ctx,
+		bson.M{"verificationToken": token},
+		bson.M{"$set": updateFields, "$unset": bson.M{"verificationToken": ""}},
+	)
+	if err != nil {
+		logrus.Errorf("Failed to update emergency contact verification: %v", err)
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return errors.New("contact not found")
+	}
+
+	return nil
+}
+
 func (er *EmergencyRepository) GetContactNotificationHistory(ctx context.Context, userID, contactID string) ([]models.EmergencyEvent, error) {
 	filter := bson.M{
 		"data.userId":    userID,
@@ -472,7 +635,7 @@ func (er *EmergencyRepository) GetNotificationSettings(ctx context.Context, user
 	}
 
 	var result map[string]interface{}
-	err = er.database.Collection("notification_settings").FindOne(ctx, bson.M{"userId": userObjectID}).Decode(&result)
+	err = database.NewCollection(er.database, "notification_settings").FindOne(ctx, bson.M{"userId": userObjectID}).Decode(&result)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			// Return default notification settings
@@ -528,7 +691,7 @@ func (er *EmergencyRepository) GetAutomationSettings(ctx context.Context, userID
 	}
 
 	var result map[string]interface{}
-	err = er.database.Collection("automation_settings").FindOne(ctx, bson.M{"userId": userObjectID}).Decode(&result)
+	err = database.NewCollection(er.database, "automation_settings").FindOne(ctx, bson.M{"userId": userObjectID}).Decode(&result)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			// Return default automation settings
@@ -816,7 +979,7 @@ func (er *EmergencyRepository) GetCheckInSettings(ctx context.Context, userID st
 	}
 
 	var settings map[string]interface{}
-	err = er.database.Collection("checkin_settings").FindOne(ctx, bson.M{"userId": userObjectID}).Decode(&settings)
+	err = database.NewCollection(er.database, "checkin_settings").FindOne(ctx, bson.M{"userId": userObjectID}).Decode(&settings)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			// Return default settings
@@ -886,7 +1049,7 @@ func (er *EmergencyRepository) GetCheckInRequests(ctx context.Context, userID st
 	}
 
 	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}})
-	cursor, err := er.database.Collection("checkin_requests").Find(ctx, filter, opts)
+	cursor, err := database.NewCollection(er.database, "checkin_requests").Find(ctx, filter, opts)
 	if err != nil {
 		logrus.Errorf("Failed to get check-in requests: %v", err)
 		return nil, err
@@ -1598,6 +1761,10 @@ func (er *EmergencyRepository) CreateIndexes(ctx context.Context) error {
 		{
 			Keys: bson.D{{Key: "userId", Value: 1}},
 		},
+		{
+			Keys:    bson.D{{Key: "verificationToken", Value: 1}},
+			Options: options.Index().SetSparse(true),
+		},
 		{
 			Keys:    bson.D{{Key: "userId", Value: 1}, {Key: "contactId", Value: 1}},
 			Options: options.Index().SetUnique(true),
@@ -3,6 +3,7 @@ package repositories
 import (
 	"context"
 	"errors"
+	"ftrack/database"
 	"ftrack/models"
 	"time"
 
@@ -14,15 +15,21 @@ import (
 
 type ExportRepository struct {
 	db                      *mongo.Database
-	dataExportsCollection   *mongo.Collection
-	purgeRequestsCollection *mongo.Collection
+	dataExportsCollection   *database.Collection
+	exportJobsCollection    *database.Collection
+	purgeRequestsCollection *database.Collection
+
+	permissionRequestsCollection *database.Collection
 }
 
 func NewExportRepository(db *mongo.Database) *ExportRepository {
 	return &ExportRepository{
 		db:                      db,
-		dataExportsCollection:   db.Collection("data_exports"),
-		purgeRequestsCollection: db.Collection("purge_requests"),
+		dataExportsCollection:   database.NewCollection(db, "data_exports"),
+		exportJobsCollection:    database.NewCollection(db, "export_jobs"),
+		purgeRequestsCollection: database.NewCollection(db, "purge_requests"),
+
+		permissionRequestsCollection: database.NewCollection(db, "export_permission_requests"),
 	}
 }
 
@@ -127,6 +134,80 @@ func (er *ExportRepository) UpdateExportStatus(ctx context.Context, exportID, st
 	return nil
 }
 
+// Export Jobs
+func (er *ExportRepository) Create(ctx context.Context, export *models.MessageExport) error {
+	export.ID = primitive.NewObjectID()
+	export.CreatedAt = time.Now()
+	export.UpdatedAt = time.Now()
+
+	_, err := er.exportJobsCollection.InsertOne(ctx, export)
+	return err
+}
+
+func (er *ExportRepository) GetByID(ctx context.Context, exportID string) (*models.MessageExport, error) {
+	objectID, err := primitive.ObjectIDFromHex(exportID)
+	if err != nil {
+		return nil, errors.New("invalid export ID")
+	}
+
+	var export models.MessageExport
+	err = er.exportJobsCollection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&export)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("export not found")
+		}
+		return nil, err
+	}
+
+	return &export, nil
+}
+
+// UpdateActiveExport applies the update only while the export is still
+// pending or processing. It reports whether the export was updated, so
+// callers can tell when a job has already been cancelled or finished.
+func (er *ExportRepository) UpdateActiveExport(ctx context.Context, exportID string, update bson.M) (bool, error) {
+	objectID, err := primitive.ObjectIDFromHex(exportID)
+	if err != nil {
+		return false, errors.New("invalid export ID")
+	}
+
+	update["updatedAt"] = time.Now()
+
+	filter := bson.M{
+		"_id":    objectID,
+		"status": bson.M{"$in": []string{models.ExportStatusPending, models.ExportStatusProcessing}},
+	}
+
+	result, err := er.exportJobsCollection.UpdateOne(ctx, filter, bson.M{"$set": update})
+	if err != nil {
+		return false, err
+	}
+
+	return result.MatchedCount > 0, nil
+}
+
+// GetStuckExports returns pending or processing exports that have not
+// reported progress since the given time
+func (er *ExportRepository) GetStuckExports(ctx context.Context, before time.Time) ([]models.MessageExport, error) {
+	filter := bson.M{
+		"status":    bson.M{"$in": []string{models.ExportStatusPending, models.ExportStatusProcessing}},
+		"updatedAt": bson.M{"$lt": before},
+	}
+
+	cursor, err := er.exportJobsCollection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var exports []models.MessageExport
+	if err = cursor.All(ctx, &exports); err != nil {
+		return nil, err
+	}
+
+	return exports, nil
+}
+
 // Data Purge Requests
 func (er *ExportRepository) CreatePurgeRequest(ctx context.Context, request *models.DataPurgeRequest) error {
 	request.ID = primitive.NewObjectID()
@@ -154,3 +235,145 @@ func (er *ExportRepository) GetPurgeRequest(ctx context.Context, userID string)
 
 	return &request, nil
 }
+
+// CancelPendingPurgeRequests cancels purge requests for the user that have
+// not started yet
+func (er *ExportRepository) CancelPendingPurgeRequests(ctx context.Context, userID string) (int64, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return 0, errors.New("invalid user ID")
+	}
+
+	result, err := er.purgeRequestsCollection.UpdateMany(
+		ctx,
+		bson.M{"userId": userObjectID, "status": "pending"},
+		bson.M{"$set": bson.M{"status": "cancelled"}},
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+}
+
+// Export permission requests
+
+// CreatePermissionRequest stores a member's request to export a circle. A
+// member has at most one pending request per circle.
+func (er *ExportRepository) CreatePermissionRequest(ctx context.Context, request *models.ExportPermissionRequest) error {
+	request.ID = primitive.NewObjectID()
+	request.Status = models.ExportRequestPending
+	request.CreatedAt = time.Now()
+	request.UpdatedAt = request.CreatedAt
+
+	_, err := er.permissionRequestsCollection.InsertOne(ctx, request)
+	if mongo.IsDuplicateKeyError(err) {
+		return errors.New("export request already pending")
+	}
+	return err
+}
+
+func (er *ExportRepository) GetPermissionRequest(ctx context.Context, requestID string) (*models.ExportPermissionRequest, error) {
+	objectID, err := primitive.ObjectIDFromHex(requestID)
+	if err != nil {
+		return nil, errors.New("invalid request ID")
+	}
+
+	var request models.ExportPermissionRequest
+	err = er.permissionRequestsCollection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&request)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("export request not found")
+		}
+		return nil, err
+	}
+
+	return &request, nil
+}
+
+// GetPendingPermissionRequests returns the circle's requests waiting for
+// review, oldest first
+func (er *ExportRepository) GetPendingPermissionRequests(ctx context.Context, circleID primitive.ObjectID) ([]models.ExportPermissionRequest, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	cursor, err := er.permissionRequestsCollection.Find(ctx, bson.M{
+		"circleId": circleID,
+		"status":   models.ExportRequestPending,
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	requests := []models.ExportPermissionRequest{}
+	if err := cursor.All(ctx, &requests); err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+// ReviewPermissionRequest approves or denies a pending request. It reports
+// false if the request was no longer pending.
+func (er *ExportRepository) ReviewPermissionRequest(ctx context.Context, requestID, reviewerID primitive.ObjectID, approve bool) (bool, error) {
+	now := time.Now()
+	set := bson.M{
+		"status":     models.ExportRequestDenied,
+		"reviewedBy": reviewerID,
+		"reviewedAt": now,
+		"updatedAt":  now,
+	}
+	if approve {
+		set["status"] = models.ExportRequestApproved
+		set["expiresAt"] = now.Add(models.ExportGrantDuration)
+	}
+
+	result, err := er.permissionRequestsCollection.UpdateOne(ctx,
+		bson.M{"_id": requestID, "status": models.ExportRequestPending},
+		bson.M{"$set": set},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// GetPermissionGrant returns the member's approved request for the circle
+// that is still unused, or nil if there is none
+func (er *ExportRepository) GetPermissionGrant(ctx context.Context, circleID, userID primitive.ObjectID) (*models.ExportPermissionRequest, error) {
+	var request models.ExportPermissionRequest
+	err := er.permissionRequestsCollection.FindOne(ctx, bson.M{
+		"circleId":  circleID,
+		"userId":    userID,
+		"status":    models.ExportRequestApproved,
+		"expiresAt": bson.M{"$gt": time.Now()},
+	}).Decode(&request)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &request, nil
+}
+
+// UsePermissionGrant marks an approved request as used by the export. It
+// reports false if it was already used or has expired.
+func (er *ExportRepository) UsePermissionGrant(ctx context.Context, requestID, exportID primitive.ObjectID) (bool, error) {
+	now := time.Now()
+	result, err := er.permissionRequestsCollection.UpdateOne(ctx,
+		bson.M{
+			"_id":       requestID,
+			"status":    models.ExportRequestApproved,
+			"expiresAt": bson.M{"$gt": now},
+		},
+		bson.M{"$set": bson.M{
+			"status":    models.ExportRequestUsed,
+			"exportId":  exportID,
+			"usedAt":    now,
+			"updatedAt": now,
+		}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"time"
 
+	"ftrack/database"
 	"ftrack/models"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -15,15 +16,15 @@ import (
 
 type FriendRepository struct {
 	db                       *mongo.Database
-	friendRequestsCollection *mongo.Collection
-	friendshipsCollection    *mongo.Collection
+	friendRequestsCollection *database.Collection
+	friendshipsCollection    *database.Collection
 }
 
 func NewFriendRepository(db *mongo.Database) *FriendRepository {
 	return &FriendRepository{
 		db:                       db,
-		friendRequestsCollection: db.Collection("friend_requests"),
-		friendshipsCollection:    db.Collection("friendships"),
+		friendRequestsCollection: database.NewCollection(db, "friend_requests"),
+		friendshipsCollection:    database.NewCollection(db, "friendships"),
 	}
 }
 
@@ -0,0 +1,147 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"ftrack/database"
+	"ftrack/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type ImpersonationRepository struct {
+	collection *database.Collection
+}
+
+func NewImpersonationRepository(db *mongo.Database) *ImpersonationRepository {
+	return &ImpersonationRepository{
+		collection: database.NewCollection(db, "impersonation_sessions"),
+	}
+}
+
+func (ir *ImpersonationRepository) Create(ctx context.Context, session *models.ImpersonationSession) error {
+	session.ID = primitive.NewObjectID()
+	session.RequestedAt = time.Now()
+
+	_, err := ir.collection.InsertOne(ctx, session)
+	return err
+}
+
+func (ir *ImpersonationRepository) GetByID(ctx context.Context, sessionID string) (*models.ImpersonationSession, error) {
+	objectID, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		return nil, errors.New("invalid impersonation session ID")
+	}
+
+	var session models.ImpersonationSession
+	err = ir.collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&session)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("impersonation session not found")
+		}
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// HasOpenSession reports whether the admin already has a session for the
+// user that hasn't finished
+func (ir *ImpersonationRepository) HasOpenSession(ctx context.Context, adminID, userID primitive.ObjectID) (bool, error) {
+	count, err := ir.collection.CountDocuments(ctx, bson.M{
+		"adminId": adminID,
+		"userId":  userID,
+		"status": bson.M{"$in": []string{
+			models.ImpersonationStatusPending,
+			models.ImpersonationStatusApproved,
+			models.ImpersonationStatusActive,
+		}},
+		"expiresAt": bson.M{"$gt": time.Now()},
+	}, options.Count().SetLimit(1))
+	return count > 0, err
+}
+
+// Transition moves the session from one status to another, applying the
+// given fields. It reports false if the session wasn't in that status, so
+// two approvals or two starts can't both win.
+func (ir *ImpersonationRepository) Transition(ctx context.Context, sessionID primitive.ObjectID, from, to string, set bson.M) (bool, error) {
+	if set == nil {
+		set = bson.M{}
+	}
+	set["status"] = to
+
+	result, err := ir.collection.UpdateOne(ctx,
+		bson.M{"_id": sessionID, "status": from},
+		bson.M{"$set": set},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+func (ir *ImpersonationRepository) IncrementRequests(ctx context.Context, sessionID primitive.ObjectID) error {
+	_, err := ir.collection.UpdateOne(ctx,
+		bson.M{"_id": sessionID},
+		bson.M{"$inc": bson.M{"requests": 1}},
+	)
+	return err
+}
+
+// ClaimSummary marks the session's summary as sent. It reports false if it
+// already was.
+func (ir *ImpersonationRepository) ClaimSummary(ctx context.Context, sessionID primitive.ObjectID) (bool, error) {
+	result, err := ir.collection.UpdateOne(ctx,
+		bson.M{"_id": sessionID, "summarySentAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"summarySentAt": time.Now()}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// GetUserSessions returns the latest sessions on the user's account
+func (ir *ImpersonationRepository) GetUserSessions(ctx context.Context, userID string, limit int) ([]models.ImpersonationSession, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	opts := options.Find().SetSort(bson.M{"requestedAt": -1}).SetLimit(int64(limit))
+	cursor, err := ir.collection.Find(ctx, bson.M{"userId": userObjectID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	sessions := []models.ImpersonationSession{}
+	err = cursor.All(ctx, &sessions)
+	return sessions, err
+}
+
+// GetExpired returns unfinished sessions whose request or token ran out
+func (ir *ImpersonationRepository) GetExpired(ctx context.Context, now time.Time, limit int) ([]models.ImpersonationSession, error) {
+	opts := options.Find().SetSort(bson.M{"expiresAt": 1}).SetLimit(int64(limit))
+	cursor, err := ir.collection.Find(ctx, bson.M{
+		"status": bson.M{"$in": []string{
+			models.ImpersonationStatusPending,
+			models.ImpersonationStatusApproved,
+			models.ImpersonationStatusActive,
+		}},
+		"expiresAt": bson.M{"$lte": now},
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var sessions []models.ImpersonationSession
+	err = cursor.All(ctx, &sessions)
+	return sessions, err
+}
@@ -0,0 +1,83 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"ftrack/database"
+	"ftrack/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type LocationIntegrityRepository struct {
+	collection *database.Collection
+}
+
+func NewLocationIntegrityRepository(db *mongo.Database) *LocationIntegrityRepository {
+	return &LocationIntegrityRepository{
+		collection: database.NewCollection(db, "location_integrity"),
+	}
+}
+
+func (lir *LocationIntegrityRepository) GetByUser(ctx context.Context, userID primitive.ObjectID) (*models.LocationIntegrity, error) {
+	var integrity models.LocationIntegrity
+	err := lir.collection.FindOne(ctx, bson.M{"userId": userID}).Decode(&integrity)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("location integrity not found")
+		}
+		return nil, err
+	}
+
+	return &integrity, nil
+}
+
+// GetByUsers returns the integrity of those of the users who have one
+func (lir *LocationIntegrityRepository) GetByUsers(ctx context.Context, userIDs []primitive.ObjectID) ([]models.LocationIntegrity, error) {
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+
+	cursor, err := lir.collection.Find(ctx, bson.M{"userId": bson.M{"$in": userIDs}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var integrities []models.LocationIntegrity
+	if err := cursor.All(ctx, &integrities); err != nil {
+		return nil, err
+	}
+
+	return integrities, nil
+}
+
+// Save creates or replaces the user's integrity
+func (lir *LocationIntegrityRepository) Save(ctx context.Context, integrity *models.LocationIntegrity) error {
+	now := time.Now()
+	integrity.UpdatedAt = now
+
+	_, err := lir.collection.UpdateOne(ctx,
+		bson.M{"userId": integrity.UserID},
+		bson.M{
+			"$set": bson.M{
+				"score":          integrity.Score,
+				"flagCounts":     integrity.FlagCounts,
+				"recentFlags":    integrity.RecentFlags,
+				"preciseSince":   integrity.PreciseSince,
+				"preciseFlagged": integrity.PreciseFlagged,
+				"identicalFixes": integrity.IdenticalFixes,
+				"lastFlaggedAt":  integrity.LastFlaggedAt,
+				"updatedAt":      now,
+			},
+			"$setOnInsert": bson.M{"createdAt": now},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
@@ -0,0 +1,446 @@
+package repositories
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"ftrack/database"
+	"ftrack/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// Location history used to live in one collection. It's read alongside
+	// the partitions until the backfill has moved all of it over.
+	legacyLocationCollection = "locations"
+
+	// Partitions hold the locations stored in one calendar month, UTC, e.g.
+	// locations_2024_05
+	locationPartitionPrefix = "locations_"
+	locationPartitionLayout = "2006_01"
+
+	locationPartitionStateCollection = "location_partition_state"
+	locationBackfillStateID          = "backfill"
+
+	// How long the list of partitions is trusted once the backfill is done
+	locationPartitionCacheTTL = time.Minute
+)
+
+var locationPartitionName = regexp.MustCompile(`^locations_\d{4}_\d{2}$`)
+
+// LocationPartitionName is the collection holding locations stored at t
+func LocationPartitionName(t time.Time) string {
+	return locationPartitionPrefix + t.UTC().Format(locationPartitionLayout)
+}
+
+func locationPartitionMonth(name string) (time.Time, error) {
+	return time.Parse(locationPartitionLayout, name[len(locationPartitionPrefix):])
+}
+
+// locationPartitions routes location history across its monthly
+// collections. Locations go to the month the server stored them in, so
+// writes always land in the current partition, reads union the months
+// their time range touches, and retention drops whole months instead of
+// deleting point by point.
+type locationPartitions struct {
+	db     *mongo.Database
+	legacy *database.Collection
+	state  *database.Collection
+
+	mutex        sync.RWMutex
+	names        []string // oldest first
+	backfilled   bool
+	refreshedAt  time.Time
+	indexedNames map[string]bool
+}
+
+func newLocationPartitions(db *mongo.Database) *locationPartitions {
+	return &locationPartitions{
+		db:           db,
+		legacy:       database.NewCollection(db, legacyLocationCollection),
+		state:        database.NewCollection(db, locationPartitionStateCollection),
+		indexedNames: make(map[string]bool),
+	}
+}
+
+// refresh reloads the partitions and the backfill state. While the backfill
+// runs it creates past partitions at any time, so they are listed anew on
+// every read until it's done.
+func (lp *locationPartitions) refresh(ctx context.Context) error {
+	lp.mutex.RLock()
+	fresh := lp.backfilled && time.Since(lp.refreshedAt) < locationPartitionCacheTTL
+	lp.mutex.RUnlock()
+	if fresh {
+		return nil
+	}
+
+	names, err := lp.db.ListCollectionNames(ctx, bson.M{"name": bson.M{"$regex": locationPartitionName.String()}})
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	backfilled := lp.isBackfilled()
+	if !backfilled {
+		var state struct {
+			CompletedAt *time.Time `bson:"completedAt"`
+		}
+		err := lp.state.FindOne(ctx, bson.M{"_id": locationBackfillStateID}).Decode(&state)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return err
+		}
+		backfilled = state.CompletedAt != nil
+	}
+
+	lp.mutex.Lock()
+	lp.names = names
+	lp.backfilled = backfilled
+	lp.refreshedAt = time.Now()
+	lp.mutex.Unlock()
+	return nil
+}
+
+func (lp *locationPartitions) isBackfilled() bool {
+	lp.mutex.RLock()
+	defer lp.mutex.RUnlock()
+	return lp.backfilled
+}
+
+// collections returns the collections that can hold locations stored from
+// from to to, newest partition first and the legacy collection last while
+// it's still read. A zero from or to leaves that end open.
+func (lp *locationPartitions) collections(ctx context.Context, from, to time.Time) ([]*database.Collection, error) {
+	if err := lp.refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	lp.mutex.RLock()
+	oldest := ""
+	if len(lp.names) > 0 {
+		oldest = lp.names[0]
+	}
+	backfilled := lp.backfilled
+	lp.mutex.RUnlock()
+
+	now := time.Now().UTC()
+	last := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if !to.IsZero() && to.Before(now) {
+		to = to.UTC()
+		last = time.Date(to.Year(), to.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	first := last
+	if oldest != "" {
+		if month, err := locationPartitionMonth(oldest); err == nil && month.Before(first) {
+			first = month
+		}
+	}
+	if !from.IsZero() {
+		from = from.UTC()
+		if month := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC); month.After(first) {
+			first = month
+		}
+	}
+
+	var collections []*database.Collection
+	for month := last; !month.Before(first); month = month.AddDate(0, -1, 0) {
+		collections = append(collections, database.NewCollection(lp.db, LocationPartitionName(month)))
+	}
+	if len(collections) == 0 {
+		// The range ends before the oldest partition
+		collections = append(collections, database.NewCollection(lp.db, LocationPartitionName(last)))
+	}
+	if !backfilled {
+		collections = append(collections, lp.legacy)
+	}
+	return collections, nil
+}
+
+// forWrite returns the partition of locations stored at t, creating its
+// indexes the first time this process writes to it
+func (lp *locationPartitions) forWrite(ctx context.Context, t time.Time) (*database.Collection, error) {
+	name := LocationPartitionName(t)
+
+	lp.mutex.RLock()
+	indexed := lp.indexedNames[name]
+	lp.mutex.RUnlock()
+
+	collection := database.NewCollection(lp.db, name)
+	if indexed {
+		return collection, nil
+	}
+
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "point", Value: "2dsphere"}, {Key: "createdAt", Value: 1}}},
+		{Keys: bson.D{{Key: "createdAt", Value: 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	lp.mutex.Lock()
+	lp.indexedNames[name] = true
+	if i := sort.SearchStrings(lp.names, name); i == len(lp.names) || lp.names[i] != name {
+		lp.names = append(lp.names[:i], append([]string{name}, lp.names[i:]...)...)
+	}
+	lp.mutex.Unlock()
+	return collection, nil
+}
+
+// aggregate runs the stages over the locations matching the filter across
+// every collection the time range touches
+func (lp *locationPartitions) aggregate(ctx context.Context, from, to time.Time, match bson.M, stages ...bson.M) (*mongo.Cursor, error) {
+	collections, err := lp.collections(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	pipeline := []bson.M{{"$match": match}}
+	for _, collection := range collections[1:] {
+		pipeline = append(pipeline, bson.M{"$unionWith": bson.M{
+			"coll":     collection.Name(),
+			"pipeline": []bson.M{{"$match": match}},
+		}})
+	}
+	pipeline = append(pipeline, stages...)
+
+	return collections[0].Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+}
+
+// count counts the locations matching the filter across the time range
+func (lp *locationPartitions) count(ctx context.Context, from, to time.Time, match bson.M, stages ...bson.M) (int64, error) {
+	counting := append(append([]bson.M{}, stages...), bson.M{"$count": "total"})
+	cursor, err := lp.aggregate(ctx, from, to, match, counting...)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var result struct {
+		Total int64 `bson:"total"`
+	}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&result); err != nil {
+			return 0, err
+		}
+	}
+	return result.Total, cursor.Err()
+}
+
+// findOne returns the newest, or oldest, location matching the filter in
+// the time range, or nil. Partitions are tried one at a time from the
+// relevant end, so it usually reads a single month.
+func (lp *locationPartitions) findOne(ctx context.Context, from, to time.Time, match bson.M, newest bool) (*models.Location, error) {
+	collections, err := lp.collections(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	direction := 1
+	if newest {
+		direction = -1
+	} else {
+		// Oldest partition first, legacy still last
+		partitions := collections
+		if !lp.isBackfilled() {
+			partitions = collections[:len(collections)-1]
+		}
+		for i, j := 0, len(partitions)-1; i < j; i, j = i+1, j-1 {
+			partitions[i], partitions[j] = partitions[j], partitions[i]
+		}
+	}
+	opts := options.FindOne().SetSort(bson.D{{Key: "createdAt", Value: direction}})
+
+	var best *models.Location
+	foundInPartition := false
+	for _, collection := range collections {
+		isLegacy := collection.Name() == legacyLocationCollection
+		if foundInPartition && !isLegacy {
+			continue
+		}
+
+		var location models.Location
+		err := collection.FindOne(ctx, match, opts).Decode(&location)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if best == nil || (newest && location.CreatedAt.After(best.CreatedAt)) || (!newest && location.CreatedAt.Before(best.CreatedAt)) {
+			best = &location
+		}
+		if !isLegacy {
+			foundInPartition = true
+		}
+	}
+	return best, nil
+}
+
+// deleteMany deletes the locations matching the filter across the time
+// range
+func (lp *locationPartitions) deleteMany(ctx context.Context, from, to time.Time, match bson.M) (int64, error) {
+	collections, err := lp.collections(ctx, from, to)
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	for _, collection := range collections {
+		result, err := collection.DeleteMany(ctx, match)
+		if err != nil {
+			return deleted, err
+		}
+		deleted += result.DeletedCount
+	}
+	return deleted, nil
+}
+
+// dropBefore removes the locations stored before the cutoff. Months wholly
+// before it are dropped; the month it falls in is trimmed.
+func (lp *locationPartitions) dropBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	if err := lp.refresh(ctx); err != nil {
+		return 0, err
+	}
+
+	lp.mutex.RLock()
+	names := append([]string(nil), lp.names...)
+	backfilled := lp.backfilled
+	lp.mutex.RUnlock()
+
+	var deleted int64
+	var dropped []string
+	for _, name := range names {
+		month, err := locationPartitionMonth(name)
+		if err != nil {
+			continue
+		}
+		collection := database.NewCollection(lp.db, name)
+
+		if !month.AddDate(0, 1, 0).After(cutoff) {
+			count, err := collection.EstimatedDocumentCount(ctx)
+			if err != nil {
+				return deleted, err
+			}
+			if err := collection.Drop(ctx); err != nil {
+				return deleted, err
+			}
+			deleted += count
+			dropped = append(dropped, name)
+			continue
+		}
+
+		if month.Before(cutoff) {
+			result, err := collection.DeleteMany(ctx, bson.M{"createdAt": bson.M{"$lt": cutoff}})
+			if err != nil {
+				return deleted, err
+			}
+			deleted += result.DeletedCount
+		}
+	}
+
+	if !backfilled {
+		result, err := lp.legacy.DeleteMany(ctx, bson.M{"createdAt": bson.M{"$lt": cutoff}})
+		if err != nil {
+			return deleted, err
+		}
+		deleted += result.DeletedCount
+	}
+
+	if len(dropped) > 0 {
+		lp.mutex.Lock()
+		for _, name := range dropped {
+			delete(lp.indexedNames, name)
+		}
+		lp.refreshedAt = time.Time{}
+		lp.mutex.Unlock()
+	}
+	return deleted, nil
+}
+
+// backfill moves up to batchSize locations from the legacy collection into
+// their partitions, and records the backfill as done once none are left.
+// Each batch is copied before it's deleted, so a read in between may see it
+// twice, never not at all.
+func (lp *locationPartitions) backfill(ctx context.Context, batchSize int) (int, bool, error) {
+	if err := lp.refresh(ctx); err != nil {
+		return 0, false, err
+	}
+	if lp.isBackfilled() {
+		return 0, true, nil
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(batchSize))
+	cursor, err := lp.legacy.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return 0, false, err
+	}
+	var documents []bson.M
+	if err := cursor.All(ctx, &documents); err != nil {
+		return 0, false, err
+	}
+
+	batches := make(map[string][]interface{})
+	stored := make(map[string]time.Time)
+	ids := make([]interface{}, 0, len(documents))
+	for _, document := range documents {
+		at := time.Now()
+		if createdAt, ok := document["createdAt"].(primitive.DateTime); ok {
+			at = createdAt.Time()
+		} else if id, ok := document["_id"].(primitive.ObjectID); ok {
+			at = id.Timestamp()
+		}
+
+		name := LocationPartitionName(at)
+		batches[name] = append(batches[name], document)
+		stored[name] = at
+		ids = append(ids, document["_id"])
+	}
+
+	for name, batch := range batches {
+		collection, err := lp.forWrite(ctx, stored[name])
+		if err != nil {
+			return 0, false, err
+		}
+		// Copies left by an interrupted batch are already there
+		_, err = collection.InsertMany(ctx, batch, options.InsertMany().SetOrdered(false))
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return 0, false, err
+		}
+	}
+
+	if len(ids) > 0 {
+		if _, err := lp.legacy.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+			return 0, false, err
+		}
+	}
+
+	if len(documents) == batchSize {
+		return len(documents), false, nil
+	}
+
+	now := time.Now()
+	_, err = lp.state.UpdateOne(ctx,
+		bson.M{"_id": locationBackfillStateID},
+		bson.M{"$set": bson.M{"completedAt": now}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return len(documents), false, err
+	}
+
+	lp.mutex.Lock()
+	lp.backfilled = true
+	lp.refreshedAt = time.Time{}
+	lp.mutex.Unlock()
+	return len(documents), true, nil
+}
@@ -0,0 +1,140 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"ftrack/database"
+	"ftrack/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type LocationReminderRepository struct {
+	collection *database.Collection
+}
+
+func NewLocationReminderRepository(db *mongo.Database) *LocationReminderRepository {
+	return &LocationReminderRepository{
+		collection: database.NewCollection(db, "location_reminders"),
+	}
+}
+
+func (lr *LocationReminderRepository) Create(ctx context.Context, reminder *models.LocationReminder) error {
+	reminder.ID = primitive.NewObjectID()
+	reminder.CreatedAt = time.Now()
+	reminder.UpdatedAt = time.Now()
+
+	_, err := lr.collection.InsertOne(ctx, reminder)
+	return err
+}
+
+// GetUserReminders returns the user's reminders, active ones first
+func (lr *LocationReminderRepository) GetUserReminders(ctx context.Context, userID string) ([]models.LocationReminder, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "active", Value: -1}, {Key: "createdAt", Value: -1}})
+	cursor, err := lr.collection.Find(ctx, bson.M{"userId": userObjectID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	reminders := []models.LocationReminder{}
+	err = cursor.All(ctx, &reminders)
+	return reminders, err
+}
+
+func (lr *LocationReminderRepository) CountActive(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	return lr.collection.CountDocuments(ctx, bson.M{"userId": userID, "active": true})
+}
+
+// GetActiveForPlace returns the user's active reminders for the place or
+// its category
+func (lr *LocationReminderRepository) GetActiveForPlace(ctx context.Context, userID string, place models.Place) ([]models.LocationReminder, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	targets := []bson.M{{"placeId": place.ID}}
+	if place.Category != "" {
+		targets = append(targets, bson.M{"category": place.Category})
+	}
+
+	cursor, err := lr.collection.Find(ctx, bson.M{
+		"userId": userObjectID,
+		"active": true,
+		"$or":    targets,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var reminders []models.LocationReminder
+	err = cursor.All(ctx, &reminders)
+	return reminders, err
+}
+
+// ClaimTrigger records that a reminder fires now. One-time reminders are
+// cleared, recurring ones only fire again after their cooldown. It returns
+// false when the reminder was cleared or fired meanwhile, so concurrent
+// arrivals notify once.
+func (lr *LocationReminderRepository) ClaimTrigger(ctx context.Context, reminder models.LocationReminder, now time.Time) (bool, error) {
+	filter := bson.M{"_id": reminder.ID, "active": true}
+	set := bson.M{
+		"lastTriggeredAt": now,
+		"updatedAt":       now,
+	}
+
+	if reminder.Recurring {
+		cooldown := time.Duration(reminder.CooldownMinutes) * time.Minute
+		filter["$or"] = []bson.M{
+			{"lastTriggeredAt": bson.M{"$exists": false}},
+			{"lastTriggeredAt": bson.M{"$lte": now.Add(-cooldown)}},
+		}
+	} else {
+		set["active"] = false
+		set["clearedAt"] = now
+	}
+
+	result, err := lr.collection.UpdateOne(ctx, filter, bson.M{
+		"$set": set,
+		"$inc": bson.M{"triggerCount": 1},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount == 1, nil
+}
+
+func (lr *LocationReminderRepository) Delete(ctx context.Context, userID, reminderID string) error {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return errors.New("invalid user ID")
+	}
+	reminderObjectID, err := primitive.ObjectIDFromHex(reminderID)
+	if err != nil {
+		return errors.New("invalid reminder ID")
+	}
+
+	result, err := lr.collection.DeleteOne(ctx, bson.M{"_id": reminderObjectID, "userId": userObjectID})
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return errors.New("reminder not found")
+	}
+
+	return nil
+}
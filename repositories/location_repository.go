@@ -3,6 +3,7 @@ package repositories
 import (
 	"context"
 	"errors"
+	"ftrack/database"
 	"ftrack/models"
 	"math"
 	"time"
@@ -14,45 +15,47 @@ import (
 )
 
 type LocationRepository struct {
-	// Core collections
-	collection *mongo.Collection
+	// Core collections; location history is partitioned by month
+	history          *locationPartitions
+	latestCollection *database.Collection
 
 	// Feature-specific collections
-	settingsCollection       *mongo.Collection
-	sharingCollection        *mongo.Collection
-	tempShareCollection      *mongo.Collection
-	proximityAlertCollection *mongo.Collection
-	tripCollection           *mongo.Collection
-	tripShareCollection      *mongo.Collection
-	drivingSessionCollection *mongo.Collection
-	drivingEventCollection   *mongo.Collection
-	drivingReportCollection  *mongo.Collection
-	geofenceEventCollection  *mongo.Collection
-	exportCollection         *mongo.Collection
-	emergencyShareCollection *mongo.Collection
-	pingCollection           *mongo.Collection
-	calibrationCollection    *mongo.Collection
-	batteryOptCollection     *mongo.Collection
+	settingsCollection       *database.Collection
+	sharingCollection        *database.Collection
+	tempShareCollection      *database.Collection
+	proximityAlertCollection *database.Collection
+	tripCollection           *database.Collection
+	tripShareCollection      *database.Collection
+	drivingSessionCollection *database.Collection
+	drivingEventCollection   *database.Collection
+	drivingReportCollection  *database.Collection
+	geofenceEventCollection  *database.Collection
+	exportCollection         *database.Collection
+	emergencyShareCollection *database.Collection
+	pingCollection           *database.Collection
+	calibrationCollection    *database.Collection
+	batteryOptCollection     *database.Collection
 }
 
 func NewLocationRepository(db *mongo.Database) *LocationRepository {
 	return &LocationRepository{
-		collection:               db.Collection("locations"),
-		settingsCollection:       db.Collection("location_settings"),
-		sharingCollection:        db.Collection("sharing_permissions"),
-		tempShareCollection:      db.Collection("temporary_shares"),
-		proximityAlertCollection: db.Collection("proximity_alerts"),
-		tripCollection:           db.Collection("trips"),
-		tripShareCollection:      db.Collection("trip_shares"),
-		drivingSessionCollection: db.Collection("driving_sessions"),
-		drivingEventCollection:   db.Collection("driving_events"),
-		drivingReportCollection:  db.Collection("driving_reports"),
-		geofenceEventCollection:  db.Collection("geofence_events"),
-		exportCollection:         db.Collection("location_exports"),
-		emergencyShareCollection: db.Collection("emergency_location_shares"),
-		pingCollection:           db.Collection("location_pings"),
-		calibrationCollection:    db.Collection("location_calibrations"),
-		batteryOptCollection:     db.Collection("battery_optimizations"),
+		history:                  newLocationPartitions(db),
+		latestCollection:         database.NewCollection(db, "latest_locations"),
+		settingsCollection:       database.NewCollection(db, "location_settings"),
+		sharingCollection:        database.NewCollection(db, "sharing_permissions"),
+		tempShareCollection:      database.NewCollection(db, "temporary_shares"),
+		proximityAlertCollection: database.NewCollection(db, "proximity_alerts"),
+		tripCollection:           database.NewCollection(db, "trips"),
+		tripShareCollection:      database.NewCollection(db, "trip_shares"),
+		drivingSessionCollection: database.NewCollection(db, "driving_sessions"),
+		drivingEventCollection:   database.NewCollection(db, "driving_events"),
+		drivingReportCollection:  database.NewCollection(db, "driving_reports"),
+		geofenceEventCollection:  database.NewCollection(db, "geofence_events"),
+		exportCollection:         database.NewCollection(db, "location_exports"),
+		emergencyShareCollection: database.NewCollection(db, "emergency_location_shares"),
+		pingCollection:           database.NewCollection(db, "location_pings"),
+		calibrationCollection:    database.NewCollection(db, "location_calibrations"),
+		batteryOptCollection:     database.NewCollection(db, "battery_optimizations"),
 	}
 }
 
@@ -62,41 +65,139 @@ func (lr *LocationRepository) Create(ctx context.Context, location *models.Locat
 	location.ID = primitive.NewObjectID()
 	location.CreatedAt = time.Now()
 	location.ServerTime = time.Now()
+	location.Point = models.NewGeoPoint(location.Latitude, location.Longitude)
 
-	_, err := lr.collection.InsertOne(ctx, location)
+	collection, err := lr.history.forWrite(ctx, location.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if _, err := collection.InsertOne(ctx, location); err != nil {
+		return err
+	}
+
+	return lr.updateLatestLocation(ctx, location)
+}
+
+// updateLatestLocation keeps the user's latest location current. Locations
+// uploaded late, like offline batches, don't replace a newer one.
+func (lr *LocationRepository) updateLatestLocation(ctx context.Context, location *models.Location) error {
+	latest := models.LatestLocation{
+		UserID:     location.UserID,
+		Location:   *location,
+		RecordedAt: location.RecordedAt(),
+	}
+
+	filter := bson.M{
+		"_id":        latest.UserID,
+		"recordedAt": bson.M{"$lte": latest.RecordedAt},
+	}
+	_, err := lr.latestCollection.ReplaceOne(ctx, filter, latest, options.Replace().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// A newer location is already stored
+		return nil
+	}
 	return err
 }
 
+// GetLatestLocations returns the latest location of each of the users that
+// has reported one, keyed by user ID
+func (lr *LocationRepository) GetLatestLocations(ctx context.Context, userIDs []string) (map[string]models.Location, error) {
+	objectIDs := make([]primitive.ObjectID, 0, len(userIDs))
+	for _, userID := range userIDs {
+		objectID, err := primitive.ObjectIDFromHex(userID)
+		if err != nil {
+			return nil, errors.New("invalid user ID")
+		}
+		objectIDs = append(objectIDs, objectID)
+	}
+
+	cursor, err := lr.latestCollection.Find(ctx, bson.M{"_id": bson.M{"$in": objectIDs}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var latest []models.LatestLocation
+	if err := cursor.All(ctx, &latest); err != nil {
+		return nil, err
+	}
+
+	locations := make(map[string]models.Location, len(latest))
+	for _, entry := range latest {
+		locations[entry.UserID.Hex()] = entry.Location
+	}
+	return locations, nil
+}
+
+// GetUsersLocatedSince returns up to limit users who reported a location
+// since the given time, in ID order after afterID
+func (lr *LocationRepository) GetUsersLocatedSince(ctx context.Context, since time.Time, afterID primitive.ObjectID, limit int) ([]primitive.ObjectID, error) {
+	filter := bson.M{"recordedAt": bson.M{"$gte": since}}
+	if !afterID.IsZero() {
+		filter["_id"] = bson.M{"$gt": afterID}
+	}
+
+	opts := options.Find().
+		SetSort(bson.M{"_id": 1}).
+		SetLimit(int64(limit)).
+		SetProjection(bson.M{"_id": 1})
+	cursor, err := lr.latestCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var latest []models.LatestLocation
+	if err := cursor.All(ctx, &latest); err != nil {
+		return nil, err
+	}
+
+	userIDs := make([]primitive.ObjectID, len(latest))
+	for i, entry := range latest {
+		userIDs[i] = entry.UserID
+	}
+	return userIDs, nil
+}
+
 func (lr *LocationRepository) GetCurrentLocation(ctx context.Context, userID string) (*models.Location, error) {
 	objectID, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
 		return nil, errors.New("invalid user ID")
 	}
 
-	opts := options.FindOne().SetSort(bson.D{{"createdAt", -1}})
-	var location models.Location
-	err = lr.collection.FindOne(ctx, bson.M{"userId": objectID}, opts).Decode(&location)
+	location, err := lr.history.findOne(ctx, time.Time{}, time.Time{}, bson.M{"userId": objectID}, true)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return nil, errors.New("location not found")
-		}
 		return nil, err
 	}
+	if location == nil {
+		return nil, errors.New("location not found")
+	}
 
-	return &location, nil
+	return location, nil
 }
 
+// DeleteOldLocations removes the history stored before olderThan, dropping
+// the months that are wholly older
 func (lr *LocationRepository) DeleteOldLocations(ctx context.Context, olderThan time.Time) (int64, error) {
-	filter := bson.M{
-		"createdAt": bson.M{"$lt": olderThan},
+	deleted, err := lr.history.dropBefore(ctx, olderThan)
+	if err != nil {
+		return deleted, err
 	}
 
-	result, err := lr.collection.DeleteMany(ctx, filter)
+	// Latest locations are retained no longer than the history they came from
+	_, err = lr.latestCollection.DeleteMany(ctx, bson.M{"location.createdAt": bson.M{"$lt": olderThan}})
 	if err != nil {
-		return 0, err
+		return deleted, err
 	}
 
-	return result.DeletedCount, nil
+	return deleted, nil
+}
+
+// BackfillLocationPartitions moves up to batchSize locations from the
+// original single collection into the monthly ones, and reports whether
+// none are left
+func (lr *LocationRepository) BackfillLocationPartitions(ctx context.Context, batchSize int) (int, bool, error) {
+	return lr.history.backfill(ctx, batchSize)
 }
 func (lr *LocationRepository) GetLocationHistory(ctx context.Context, userID string, startTime, endTime *time.Time, page, pageSize int) ([]models.Location, int64, error) {
 	objectID, err := primitive.ObjectIDFromHex(userID)
@@ -107,31 +208,141 @@ func (lr *LocationRepository) GetLocationHistory(ctx context.Context, userID str
 	filter := bson.M{"userId": objectID}
 
 	// Add time range filter if provided
+	var from, to time.Time
 	if startTime != nil || endTime != nil {
 		timeFilter := bson.M{}
 		if startTime != nil {
 			timeFilter["$gte"] = *startTime
+			from = *startTime
 		}
 		if endTime != nil {
 			timeFilter["$lte"] = *endTime
+			to = *endTime
 		}
 		filter["createdAt"] = timeFilter
 	}
 
 	// Count total documents
-	total, err := lr.collection.CountDocuments(ctx, filter)
+	total, err := lr.history.count(ctx, from, to, filter)
 	if err != nil {
 		return nil, 0, err
 	}
 
 	// Calculate pagination
 	skip := (page - 1) * pageSize
-	opts := options.Find().
-		SetSort(bson.D{{"createdAt", -1}}).
-		SetSkip(int64(skip)).
-		SetLimit(int64(pageSize))
+	cursor, err := lr.history.aggregate(ctx, from, to, filter,
+		bson.M{"$sort": bson.M{"createdAt": -1}},
+		bson.M{"$skip": skip},
+		bson.M{"$limit": pageSize},
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var locations []models.Location
+	err = cursor.All(ctx, &locations)
+	return locations, total, err
+}
+
+// GetLocationsBetween returns up to limit of the user's locations recorded
+// after from and up to to, oldest first
+func (lr *LocationRepository) GetLocationsBetween(ctx context.Context, userID string, from, to time.Time, limit int) ([]models.Location, error) {
+	objectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
 
-	cursor, err := lr.collection.Find(ctx, filter, opts)
+	cursor, err := lr.history.aggregate(ctx, from, to, bson.M{
+		"userId":    objectID,
+		"createdAt": bson.M{"$gt": from, "$lte": to},
+	},
+		bson.M{"$sort": bson.M{"createdAt": 1}},
+		bson.M{"$limit": limit},
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var locations []models.Location
+	err = cursor.All(ctx, &locations)
+	return locations, err
+}
+
+// GetViewportHistory returns the user's locations inside the box recorded
+// from from up to to, oldest first, with their total before downsampling.
+// When snap is set, points are matched on coordinates rounded to a grid of
+// that many degrees, so the box can't reveal more than the grid does. Past
+// maxPoints, the window is split into maxPoints equal slices and the first
+// point of each is kept.
+func (lr *LocationRepository) GetViewportHistory(ctx context.Context, userID string, box models.BoundingBox, from, to time.Time, snap float64, maxPoints int) ([]models.Location, int64, error) {
+	objectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, 0, errors.New("invalid user ID")
+	}
+
+	// Rounded points can lie up to half a cell outside the box they are
+	// matched in
+	searchBox := box
+	if snap > 0 {
+		searchBox = models.BoundingBox{
+			West:  math.Max(box.West-snap, -180),
+			South: math.Max(box.South-snap, -90),
+			East:  math.Min(box.East+snap, 180),
+			North: math.Min(box.North+snap, 90),
+		}
+	}
+
+	match := bson.M{
+		"userId":    objectID,
+		"createdAt": bson.M{"$gte": from, "$lte": to},
+	}
+	if searchBox.West <= searchBox.East {
+		match["point"] = geoWithinBox(searchBox.West, searchBox.South, searchBox.East, searchBox.North)
+	} else {
+		match["$or"] = []bson.M{
+			{"point": geoWithinBox(searchBox.West, searchBox.South, 180, searchBox.North)},
+			{"point": geoWithinBox(-180, searchBox.South, searchBox.East, searchBox.North)},
+		}
+	}
+
+	var pipeline []bson.M
+	if snap > 0 {
+		pipeline = append(pipeline,
+			bson.M{"$addFields": bson.M{
+				"snappedLat": snapToGrid("$latitude", snap),
+				"snappedLon": snapToGrid("$longitude", snap),
+			}},
+			bson.M{"$match": snappedInBox(box)},
+		)
+	}
+
+	total, err := lr.history.count(ctx, from, to, match, pipeline...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pipeline = append(pipeline, bson.M{"$sort": bson.M{"createdAt": 1}})
+	if total > int64(maxPoints) {
+		slice := float64(to.Sub(from).Milliseconds()) / float64(maxPoints)
+		pipeline = append(pipeline,
+			bson.M{"$group": bson.M{
+				"_id": bson.M{"$min": []interface{}{
+					bson.M{"$floor": bson.M{"$divide": []interface{}{
+						bson.M{"$subtract": []interface{}{"$createdAt", from}},
+						slice,
+					}}},
+					maxPoints - 1,
+				}},
+				"location": bson.M{"$first": "$$ROOT"},
+			}},
+			bson.M{"$replaceRoot": bson.M{"newRoot": "$location"}},
+			bson.M{"$sort": bson.M{"createdAt": 1}},
+		)
+	}
+
+	cursor, err := lr.history.aggregate(ctx, from, to, match, pipeline...)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -142,13 +353,68 @@ func (lr *LocationRepository) GetLocationHistory(ctx context.Context, userID str
 	return locations, total, err
 }
 
+// geoWithinBox matches points in a flat longitude/latitude box, which the
+// 2dsphere index serves
+func geoWithinBox(west, south, east, north float64) bson.M {
+	return bson.M{"$geoWithin": bson.M{
+		"$box": [][]float64{{west, south}, {east, north}},
+	}}
+}
+
+func snapToGrid(field string, cellSize float64) bson.M {
+	return bson.M{"$multiply": []interface{}{
+		bson.M{"$round": []interface{}{bson.M{"$divide": []interface{}{field, cellSize}}, 0}},
+		cellSize,
+	}}
+}
+
+func snappedInBox(box models.BoundingBox) bson.M {
+	filter := bson.M{"snappedLat": bson.M{"$gte": box.South, "$lte": box.North}}
+	if box.West <= box.East {
+		filter["snappedLon"] = bson.M{"$gte": box.West, "$lte": box.East}
+	} else {
+		filter["$or"] = []bson.M{
+			{"snappedLon": bson.M{"$gte": box.West}},
+			{"snappedLon": bson.M{"$lte": box.East}},
+		}
+	}
+	return filter
+}
+
+// GetLocationAt returns the user's last location recorded at or before the
+// given time
+func (lr *LocationRepository) GetLocationAt(ctx context.Context, userID string, at time.Time) (*models.Location, error) {
+	objectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	location, err := lr.history.findOne(ctx, time.Time{}, at, bson.M{
+		"userId":    objectID,
+		"createdAt": bson.M{"$lte": at},
+	}, true)
+	if err != nil {
+		return nil, err
+	}
+	if location == nil {
+		return nil, errors.New("location not found")
+	}
+
+	return location, nil
+}
+
 func (lr *LocationRepository) ClearLocationHistory(ctx context.Context, userID string) error {
 	objectID, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
 		return errors.New("invalid user ID")
 	}
 
-	_, err = lr.collection.DeleteMany(ctx, bson.M{"userId": objectID})
+	_, err = lr.history.deleteMany(ctx, time.Time{}, time.Time{}, bson.M{"userId": objectID})
+	if err != nil {
+		return err
+	}
+
+	_, err = lr.latestCollection.DeleteOne(ctx, bson.M{"_id": objectID})
 	return err
 }
 
@@ -224,6 +490,20 @@ func (lr *LocationRepository) GetTemporaryShares(ctx context.Context, userID str
 	return shares, err
 }
 
+// StopTemporaryShares ends all of the user's active temporary shares
+func (lr *LocationRepository) StopTemporaryShares(ctx context.Context, userID string) (int64, error) {
+	result, err := lr.tempShareCollection.UpdateMany(
+		ctx,
+		bson.M{"userId": userID, "isActive": true},
+		bson.M{"$set": bson.M{"isActive": false}},
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+}
+
 func (lr *LocationRepository) GetTemporaryShare(ctx context.Context, shareID string) (*models.TemporaryShare, error) {
 	objectID, err := primitive.ObjectIDFromHex(shareID)
 	if err != nil {
@@ -269,13 +549,12 @@ func (lr *LocationRepository) GetNearbyUsers(ctx context.Context, lat, lon, radi
 	}
 
 	// Aggregation pipeline to get latest locations for users in specified circles
+	since := time.Now().Add(-time.Hour) // Only recent locations
+	match := bson.M{
+		"circleId":  bson.M{"$in": circleObjectIDs},
+		"createdAt": bson.M{"$gte": since},
+	}
 	pipeline := []bson.M{
-		{
-			"$match": bson.M{
-				"circleId":  bson.M{"$in": circleObjectIDs},
-				"createdAt": bson.M{"$gte": time.Now().Add(-time.Hour)}, // Only recent locations
-			},
-		},
 		{
 			"$sort": bson.M{"userId": 1, "createdAt": -1},
 		},
@@ -317,7 +596,7 @@ func (lr *LocationRepository) GetNearbyUsers(ctx context.Context, lat, lon, radi
 		},
 	}
 
-	cursor, err := lr.collection.Aggregate(ctx, pipeline)
+	cursor, err := lr.history.aggregate(ctx, since, time.Time{}, match, pipeline...)
 	if err != nil {
 		return nil, err
 	}
@@ -500,6 +779,27 @@ func (lr *LocationRepository) GetTrip(ctx context.Context, tripID string) (*mode
 	return &trip, nil
 }
 
+// HasActiveTrip reports whether the user has a trip or driving session in
+// progress
+func (lr *LocationRepository) HasActiveTrip(ctx context.Context, userID string) (bool, error) {
+	filter := bson.M{"userId": userID, "isActive": true}
+	opts := options.Count().SetLimit(1)
+
+	trips, err := lr.tripCollection.CountDocuments(ctx, filter, opts)
+	if err != nil {
+		return false, err
+	}
+	if trips > 0 {
+		return true, nil
+	}
+
+	sessions, err := lr.drivingSessionCollection.CountDocuments(ctx, filter, opts)
+	if err != nil {
+		return false, err
+	}
+	return sessions > 0, nil
+}
+
 func (lr *LocationRepository) UpdateTrip(ctx context.Context, tripID string, update models.TripUpdate) error {
 	objectID, err := primitive.ObjectIDFromHex(tripID)
 	if err != nil {
@@ -532,6 +832,12 @@ func (lr *LocationRepository) UpdateTrip(ctx context.Context, tripID string, upd
 	if update.Stats != nil {
 		updateDoc["stats"] = *update.Stats
 	}
+	if update.Tags != nil {
+		updateDoc["tags"] = update.Tags
+	}
+	if update.Safety != nil {
+		updateDoc["safety"] = *update.Safety
+	}
 
 	result, err := lr.tripCollection.UpdateOne(
 		ctx,
@@ -560,7 +866,48 @@ func (lr *LocationRepository) DeleteTrip(ctx context.Context, tripID string) err
 	if result.DeletedCount == 0 {
 		return errors.New("trip not found")
 	}
-	return nil
+
+	// Safety events only make sense with their trip
+	_, err = lr.drivingEventCollection.DeleteMany(ctx, bson.M{"tripId": tripID})
+	return err
+}
+
+// GetActiveTrip returns the user's trip in progress
+func (lr *LocationRepository) GetActiveTrip(ctx context.Context, userID string) (*models.Trip, error) {
+	opts := options.FindOne().SetSort(bson.D{{Key: "startTime", Value: -1}})
+
+	var trip models.Trip
+	err := lr.tripCollection.FindOne(ctx, bson.M{"userId": userID, "isActive": true}, opts).Decode(&trip)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("trip not found")
+		}
+		return nil, err
+	}
+	return &trip, nil
+}
+
+// GetSafetyTrips returns the user's trips with a safety summary that ended
+// in [from, to), oldest first
+func (lr *LocationRepository) GetSafetyTrips(ctx context.Context, userID string, from, to time.Time) ([]models.Trip, error) {
+	filter := bson.M{
+		"userId":  userID,
+		"endTime": bson.M{"$gte": from, "$lt": to},
+		"safety":  bson.M{"$exists": true},
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "endTime", Value: 1}}).
+		SetProjection(bson.M{"route": 0})
+
+	cursor, err := lr.tripCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var trips []models.Trip
+	err = cursor.All(ctx, &trips)
+	return trips, err
 }
 
 func (lr *LocationRepository) GetTripRoute(ctx context.Context, tripID string) (*models.TripRoute, error) {
@@ -608,12 +955,13 @@ func (lr *LocationRepository) GetTripLocations(ctx context.Context, tripID strin
 		},
 	}
 
+	var to time.Time
 	if trip.EndTime != nil {
 		filter["createdAt"].(bson.M)["$lte"] = *trip.EndTime
+		to = *trip.EndTime
 	}
 
-	opts := options.Find().SetSort(bson.D{{"createdAt", 1}})
-	cursor, err := lr.collection.Find(ctx, filter, opts)
+	cursor, err := lr.history.aggregate(ctx, trip.StartTime, to, filter, bson.M{"$sort": bson.M{"createdAt": 1}})
 	if err != nil {
 		return nil, err
 	}
@@ -831,6 +1179,38 @@ func (lr *LocationRepository) CreateDrivingEvent(ctx context.Context, event *mod
 	return err
 }
 
+// CreateDrivingEvents stores the safety events reported with one update
+func (lr *LocationRepository) CreateDrivingEvents(ctx context.Context, events []models.DrivingEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	docs := make([]interface{}, len(events))
+	for i := range events {
+		events[i].ID = primitive.NewObjectID()
+		events[i].CreatedAt = time.Now()
+		docs[i] = events[i]
+	}
+
+	_, err := lr.drivingEventCollection.InsertMany(ctx, docs)
+	return err
+}
+
+// GetTripDrivingEvents returns the events recorded during the trip
+func (lr *LocationRepository) GetTripDrivingEvents(ctx context.Context, tripID string) ([]models.DrivingEvent, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}})
+
+	cursor, err := lr.drivingEventCollection.Find(ctx, bson.M{"tripId": tripID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var events []models.DrivingEvent
+	err = cursor.All(ctx, &events)
+	return events, err
+}
+
 // ==================== ANALYTICS METHODS ====================
 
 func (lr *LocationRepository) GetLocationStats(ctx context.Context, userID, period string) (*models.LocationStats, error) {
@@ -948,6 +1328,57 @@ func (lr *LocationRepository) GetLocationHeatmap(ctx context.Context, userID, pe
 	return heatmap, nil
 }
 
+// HeatmapGridCount is the number of locations in one grid cell. The cell
+// spans [index*cellSize, (index+1)*cellSize) on each axis.
+type HeatmapGridCount struct {
+	LatIndex int64 `bson:"latIndex"`
+	LonIndex int64 `bson:"lonIndex"`
+	Count    int   `bson:"count"`
+}
+
+// GetLocationGridCounts buckets a user's locations in the time range into a
+// grid of cellSize degrees
+func (lr *LocationRepository) GetLocationGridCounts(ctx context.Context, userID string, startDate, endDate time.Time, cellSize float64) ([]HeatmapGridCount, error) {
+	objectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	match := bson.M{
+		"userId":    objectID,
+		"createdAt": bson.M{"$gte": startDate, "$lt": endDate},
+	}
+	pipeline := []bson.M{
+		{
+			"$group": bson.M{
+				"_id": bson.M{
+					"lat": bson.M{"$toLong": bson.M{"$floor": bson.M{"$divide": []interface{}{"$latitude", cellSize}}}},
+					"lon": bson.M{"$toLong": bson.M{"$floor": bson.M{"$divide": []interface{}{"$longitude", cellSize}}}},
+				},
+				"count": bson.M{"$sum": 1},
+			},
+		},
+		{
+			"$project": bson.M{
+				"_id":      0,
+				"latIndex": "$_id.lat",
+				"lonIndex": "$_id.lon",
+				"count":    1,
+			},
+		},
+	}
+
+	cursor, err := lr.history.aggregate(ctx, startDate, endDate, match, pipeline...)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var counts []HeatmapGridCount
+	err = cursor.All(ctx, &counts)
+	return counts, err
+}
+
 func (lr *LocationRepository) GetLocationPatterns(ctx context.Context, userID string) (*models.LocationPatterns, error) {
 	// This would analyze location data to identify patterns
 	// For now, return a placeholder
@@ -1036,6 +1467,23 @@ func (lr *LocationRepository) GetGeofenceEvents(ctx context.Context, userID stri
 	return events, total, err
 }
 
+func (lr *LocationRepository) CreateGeofenceEvent(ctx context.Context, event *models.GeofenceEvent) error {
+	event.ID = primitive.NewObjectID()
+	event.CreatedAt = time.Now()
+
+	_, err := lr.geofenceEventCollection.InsertOne(ctx, event)
+	return err
+}
+
+// SetGeofenceEventNotification records who was notified of the event
+func (lr *LocationRepository) SetGeofenceEventNotification(ctx context.Context, eventID primitive.ObjectID, notification models.GeofenceEventNotification) error {
+	_, err := lr.geofenceEventCollection.UpdateOne(ctx,
+		bson.M{"_id": eventID},
+		bson.M{"$set": bson.M{"notification": notification}},
+	)
+	return err
+}
+
 func (lr *LocationRepository) GetGeofenceEvent(ctx context.Context, eventID string) (*models.GeofenceEvent, error) {
 	objectID, err := primitive.ObjectIDFromHex(eventID)
 	if err != nil {
@@ -1107,13 +1555,16 @@ func (lr *LocationRepository) PurgeLocationData(ctx context.Context, userID stri
 	filter := bson.M{"userId": userID}
 
 	// Add date range if specified
+	var from, to time.Time
 	if request.StartDate != nil || request.EndDate != nil {
 		dateFilter := bson.M{}
 		if request.StartDate != nil {
 			dateFilter["$gte"] = *request.StartDate
+			from = *request.StartDate
 		}
 		if request.EndDate != nil {
 			dateFilter["$lte"] = *request.EndDate
+			to = *request.EndDate
 		}
 		filter["createdAt"] = dateFilter
 	}
@@ -1122,10 +1573,11 @@ func (lr *LocationRepository) PurgeLocationData(ctx context.Context, userID stri
 	for _, dataType := range request.DataTypes {
 		switch dataType {
 		case "locations":
-			deleteResult, err := lr.collection.DeleteMany(ctx, filter)
+			deleted, err := lr.history.deleteMany(ctx, from, to, filter)
 			if err == nil {
-				result.LocationsDeleted = int(deleteResult.DeletedCount)
+				result.LocationsDeleted = int(deleted)
 			}
+			lr.purgeLatestLocation(ctx, userID, request)
 		case "trips":
 			deleteResult, err := lr.tripCollection.DeleteMany(ctx, bson.M{"userId": userID})
 			if err == nil {
@@ -1142,6 +1594,29 @@ func (lr *LocationRepository) PurgeLocationData(ctx context.Context, userID stri
 	return result, nil
 }
 
+// purgeLatestLocation removes the user's latest location when it falls in
+// the purged range
+func (lr *LocationRepository) purgeLatestLocation(ctx context.Context, userID string, request models.LocationPurgeRequest) {
+	objectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return
+	}
+
+	filter := bson.M{"_id": objectID}
+	if request.StartDate != nil || request.EndDate != nil {
+		dateFilter := bson.M{}
+		if request.StartDate != nil {
+			dateFilter["$gte"] = *request.StartDate
+		}
+		if request.EndDate != nil {
+			dateFilter["$lte"] = *request.EndDate
+		}
+		filter["location.createdAt"] = dateFilter
+	}
+
+	lr.latestCollection.DeleteOne(ctx, filter)
+}
+
 func (lr *LocationRepository) GetDataUsage(ctx context.Context, userID string) (*models.DataUsage, error) {
 	objectID, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
@@ -1149,7 +1624,7 @@ func (lr *LocationRepository) GetDataUsage(ctx context.Context, userID string) (
 	}
 
 	// Count documents in different collections
-	locationCount, _ := lr.collection.CountDocuments(ctx, bson.M{"userId": objectID})
+	locationCount, _ := lr.history.count(ctx, time.Time{}, time.Time{}, bson.M{"userId": objectID})
 	tripCount, _ := lr.tripCollection.CountDocuments(ctx, bson.M{"userId": userID})
 	eventCount, _ := lr.geofenceEventCollection.CountDocuments(ctx, bson.M{"userId": userID})
 
@@ -0,0 +1,387 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"ftrack/database"
+	"ftrack/models"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CounterSpec describes a denormalized counter stored on one collection and
+// derived by counting referencing documents in another
+type CounterSpec struct {
+	Collection       string
+	Field            string // dotted path, e.g. "stats.visitCount"
+	SourceCollection string
+	SourceField      string // field on source documents referencing the counter owner
+	SourceFilter     bson.M // extra conditions a source document must meet to count
+
+	// IncreaseOnly marks counters whose older sources may not be traceable,
+	// so a computed value below the stored one is reported but never applied
+	IncreaseOnly bool
+}
+
+// CounterSnapshot is the stored value of a counter at scan time
+type CounterSnapshot struct {
+	ID        primitive.ObjectID
+	Stored    int64
+	UpdatedAt time.Time
+}
+
+// StoredContent is message or draft content as stored: ciphertext when
+// ContentKeyID is set
+type StoredContent struct {
+	ID           primitive.ObjectID `bson:"_id"`
+	Content      string             `bson:"content"`
+	ContentKeyID string             `bson:"contentKeyId,omitempty"`
+}
+
+type MaintenanceRepository struct {
+	db                       *mongo.Database
+	runsCollection           *database.Collection
+	encryptionRunsCollection *database.Collection
+}
+
+func NewMaintenanceRepository(db *mongo.Database) *MaintenanceRepository {
+	return &MaintenanceRepository{
+		db:                       db,
+		runsCollection:           database.NewCollection(db, "maintenance_runs"),
+		encryptionRunsCollection: database.NewCollection(db, "message_encryption_runs"),
+	}
+}
+
+// ========================
+// Reconcile Runs
+// ========================
+
+func (mr *MaintenanceRepository) CreateRun(ctx context.Context, run *models.ReconcileRun) error {
+	run.ID = primitive.NewObjectID()
+	run.StartedAt = time.Now()
+
+	_, err := mr.runsCollection.InsertOne(ctx, run)
+	return err
+}
+
+func (mr *MaintenanceRepository) UpdateRun(ctx context.Context, run *models.ReconcileRun) error {
+	_, err := mr.runsCollection.ReplaceOne(ctx, bson.M{"_id": run.ID}, run)
+	return err
+}
+
+func (mr *MaintenanceRepository) GetRun(ctx context.Context, runID string) (*models.ReconcileRun, error) {
+	objectID, err := primitive.ObjectIDFromHex(runID)
+	if err != nil {
+		return nil, errors.New("invalid run ID")
+	}
+
+	var run models.ReconcileRun
+	err = mr.runsCollection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&run)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("run not found")
+		}
+		return nil, err
+	}
+
+	return &run, nil
+}
+
+// ========================
+// Counter Scans
+// ========================
+
+// ScanCounters returns the next batch of stored counter values ordered by ID
+func (mr *MaintenanceRepository) ScanCounters(ctx context.Context, spec CounterSpec, afterID primitive.ObjectID, limit int) ([]CounterSnapshot, error) {
+	filter := bson.M{}
+	if !afterID.IsZero() {
+		filter["_id"] = bson.M{"$gt": afterID}
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetLimit(int64(limit)).
+		SetProjection(bson.M{spec.Field: 1, "updatedAt": 1})
+
+	cursor, err := mr.db.Collection(spec.Collection).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var snapshots []CounterSnapshot
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+
+		id, _ := doc["_id"].(primitive.ObjectID)
+		snapshot := CounterSnapshot{
+			ID:     id,
+			Stored: lookupCounter(doc, spec.Field),
+		}
+		if updatedAt, ok := doc["updatedAt"].(primitive.DateTime); ok {
+			snapshot.UpdatedAt = updatedAt.Time()
+		}
+
+		snapshots = append(snapshots, snapshot)
+	}
+
+	return snapshots, cursor.Err()
+}
+
+// CountSources computes counter values for the given owners from the source collection
+func (mr *MaintenanceRepository) CountSources(ctx context.Context, spec CounterSpec, ids []primitive.ObjectID) (map[primitive.ObjectID]int64, error) {
+	match := bson.M{spec.SourceField: bson.M{"$in": ids}}
+	for key, value := range spec.SourceFilter {
+		match[key] = value
+	}
+
+	pipeline := []bson.M{
+		{"$match": match},
+		{"$group": bson.M{
+			"_id":   "$" + spec.SourceField,
+			"count": bson.M{"$sum": 1},
+		}},
+	}
+
+	cursor, err := mr.db.Collection(spec.SourceCollection).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	counts := make(map[primitive.ObjectID]int64)
+	for cursor.Next(ctx) {
+		var result struct {
+			ID    primitive.ObjectID `bson:"_id"`
+			Count int64              `bson:"count"`
+		}
+		if err := cursor.Decode(&result); err != nil {
+			return nil, err
+		}
+		counts[result.ID] = result.Count
+	}
+
+	return counts, cursor.Err()
+}
+
+// RepairCounter sets the counter to the computed value only if it still
+// holds the value seen during the scan. It reports whether it was updated.
+func (mr *MaintenanceRepository) RepairCounter(ctx context.Context, spec CounterSpec, id primitive.ObjectID, stored, computed int64) (bool, error) {
+	filter := bson.M{"_id": id, spec.Field: stored}
+	if stored == 0 {
+		filter = bson.M{
+			"_id": id,
+			"$or": []bson.M{
+				{spec.Field: 0},
+				{spec.Field: bson.M{"$exists": false}},
+			},
+		}
+	}
+
+	result, err := mr.db.Collection(spec.Collection).UpdateOne(ctx, filter, bson.M{
+		"$set": bson.M{spec.Field: computed},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount > 0, nil
+}
+
+// ========================
+// Message Reactions
+// ========================
+
+// ScanMessageReactions returns the next batch of messages that have reactions
+func (mr *MaintenanceRepository) ScanMessageReactions(ctx context.Context, afterID primitive.ObjectID, limit int) ([]models.Message, error) {
+	filter := bson.M{"reactions.0": bson.M{"$exists": true}}
+	if !afterID.IsZero() {
+		filter["_id"] = bson.M{"$gt": afterID}
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetLimit(int64(limit)).
+		SetProjection(bson.M{"reactions": 1, "updatedAt": 1})
+
+	cursor, err := mr.db.Collection("messages").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var messages []models.Message
+	err = cursor.All(ctx, &messages)
+	return messages, err
+}
+
+// ReplaceMessageReactions swaps in a repaired reaction list if the message
+// still has the number of reactions seen during the scan
+func (mr *MaintenanceRepository) ReplaceMessageReactions(ctx context.Context, messageID primitive.ObjectID, storedCount int, reactions []models.MessageReaction) (bool, error) {
+	result, err := mr.db.Collection("messages").UpdateOne(
+		ctx,
+		bson.M{
+			"_id":       messageID,
+			"reactions": bson.M{"$size": storedCount},
+		},
+		bson.M{"$set": bson.M{"reactions": reactions}},
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount > 0, nil
+}
+
+// ========================
+// Message Encryption
+// ========================
+
+func (mr *MaintenanceRepository) CreateEncryptionRun(ctx context.Context, run *models.MessageEncryptionRun) error {
+	run.ID = primitive.NewObjectID()
+	run.StartedAt = time.Now()
+	run.HeartbeatAt = run.StartedAt
+
+	_, err := mr.encryptionRunsCollection.InsertOne(ctx, run)
+	return err
+}
+
+func (mr *MaintenanceRepository) UpdateEncryptionRun(ctx context.Context, run *models.MessageEncryptionRun) error {
+	run.HeartbeatAt = time.Now()
+	_, err := mr.encryptionRunsCollection.ReplaceOne(ctx, bson.M{"_id": run.ID}, run)
+	return err
+}
+
+func (mr *MaintenanceRepository) GetEncryptionRun(ctx context.Context, runID string) (*models.MessageEncryptionRun, error) {
+	objectID, err := primitive.ObjectIDFromHex(runID)
+	if err != nil {
+		return nil, errors.New("invalid run ID")
+	}
+
+	var run models.MessageEncryptionRun
+	err = mr.encryptionRunsCollection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&run)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("run not found")
+		}
+		return nil, err
+	}
+
+	return &run, nil
+}
+
+// GetEncryptedCircleIDs returns the circles that store content encrypted
+func (mr *MaintenanceRepository) GetEncryptedCircleIDs(ctx context.Context) ([]primitive.ObjectID, error) {
+	ids, err := mr.db.Collection("circles").Distinct(ctx, "_id", bson.M{"settings.encryptMessages": true})
+	if err != nil {
+		return nil, err
+	}
+
+	circleIDs := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		if objectID, ok := id.(primitive.ObjectID); ok {
+			circleIDs = append(circleIDs, objectID)
+		}
+	}
+	return circleIDs, nil
+}
+
+// contentToEncryptFilter matches content not stored the way it should be:
+// plain content in the encrypted circles, and content under a key other
+// than the current one in any circle
+func contentToEncryptFilter(circleIDs []primitive.ObjectID, currentKeyID string) bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{
+			"circleId":     bson.M{"$in": circleIDs},
+			"content":      bson.M{"$nin": bson.A{nil, ""}},
+			"contentKeyId": bson.M{"$exists": false},
+		},
+		bson.M{"contentKeyId": bson.M{"$exists": true, "$ne": currentKeyID}},
+	}}
+}
+
+// CountContentToEncrypt counts the documents of a collection, messages or
+// message_drafts, whose content the encryption run has to write
+func (mr *MaintenanceRepository) CountContentToEncrypt(ctx context.Context, collection string, circleIDs []primitive.ObjectID, currentKeyID string) (int64, error) {
+	return mr.db.Collection(collection).CountDocuments(ctx, contentToEncryptFilter(circleIDs, currentKeyID))
+}
+
+// ScanContentToEncrypt returns the next batch of documents whose content
+// the encryption run has to write
+func (mr *MaintenanceRepository) ScanContentToEncrypt(ctx context.Context, collection string, circleIDs []primitive.ObjectID, currentKeyID string, afterID primitive.ObjectID, limit int) ([]StoredContent, error) {
+	filter := contentToEncryptFilter(circleIDs, currentKeyID)
+	if !afterID.IsZero() {
+		filter["_id"] = bson.M{"$gt": afterID}
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetLimit(int64(limit)).
+		SetProjection(bson.M{"content": 1, "contentKeyId": 1})
+
+	cursor, err := mr.db.Collection(collection).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var batch []StoredContent
+	err = cursor.All(ctx, &batch)
+	return batch, err
+}
+
+// ReplaceStoredContent swaps in newly encrypted content if the document
+// still holds the content seen during the scan. An edit in the meantime
+// wrote the content for the circle's setting anyway.
+func (mr *MaintenanceRepository) ReplaceStoredContent(ctx context.Context, collection string, scanned StoredContent, content, keyID string) (bool, error) {
+	filter := bson.M{"_id": scanned.ID, "content": scanned.Content}
+	if scanned.ContentKeyID == "" {
+		filter["contentKeyId"] = bson.M{"$exists": false}
+	} else {
+		filter["contentKeyId"] = scanned.ContentKeyID
+	}
+
+	result, err := mr.db.Collection(collection).UpdateOne(ctx, filter, bson.M{
+		"$set": bson.M{"content": content, "contentKeyId": keyID},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount > 0, nil
+}
+
+// lookupCounter reads a numeric value at a dotted path, treating missing
+// fields as zero
+func lookupCounter(doc bson.M, path string) int64 {
+	var value interface{} = doc
+	for _, key := range strings.Split(path, ".") {
+		switch nested := value.(type) {
+		case bson.M:
+			value = nested[key]
+		case bson.D:
+			value = nested.Map()[key]
+		default:
+			return 0
+		}
+	}
+
+	switch v := value.(type) {
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	default:
+		return 0
+	}
+}
@@ -3,6 +3,7 @@ package repositories
 import (
 	"context"
 	"errors"
+	"ftrack/database"
 	"ftrack/models"
 	"time"
 
@@ -13,12 +14,16 @@ import (
 )
 
 type MediaRepository struct {
-	collection *mongo.Collection
+	collection       *database.Collection
+	blobCollection   *database.Collection
+	uploadCollection *database.Collection
 }
 
 func NewMediaRepository(db *mongo.Database) *MediaRepository {
 	return &MediaRepository{
-		collection: db.Collection("message_media"),
+		collection:       database.NewCollection(db, "message_media"),
+		blobCollection:   database.NewCollection(db, "media_blobs"),
+		uploadCollection: database.NewCollection(db, "media_upload_intents"),
 	}
 }
 
@@ -214,3 +219,180 @@ func (mr *MediaRepository) Search(ctx context.Context, req models.SearchMediaReq
 	err = cursor.All(ctx, &media)
 	return media, total, err
 }
+
+// ReferenceBlob adds a reference to stored content with the given hash
+func (mr *MediaRepository) ReferenceBlob(ctx context.Context, hash string) (*models.MediaBlob, error) {
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var blob models.MediaBlob
+	err := mr.blobCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": hash},
+		bson.M{
+			"$inc": bson.M{"refCount": 1},
+			"$set": bson.M{"updatedAt": time.Now()},
+		},
+		opts,
+	).Decode(&blob)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("media blob not found")
+		}
+		return nil, err
+	}
+
+	return &blob, nil
+}
+
+// CreateBlob records newly stored content with one reference. When the same
+// content was stored concurrently, it references that blob instead and
+// returns it.
+func (mr *MediaRepository) CreateBlob(ctx context.Context, blob *models.MediaBlob) (*models.MediaBlob, error) {
+	now := time.Now()
+	opts := options.FindOneAndUpdate().
+		SetUpsert(true).
+		SetReturnDocument(options.After)
+
+	var stored models.MediaBlob
+	err := mr.blobCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": blob.Hash},
+		bson.M{
+			"$inc": bson.M{"refCount": 1},
+			"$set": bson.M{"updatedAt": now},
+			"$setOnInsert": bson.M{
+				"filename":     blob.Filename,
+				"size":         blob.Size,
+				"mimeType":     blob.MimeType,
+				"thumbnailUrl": blob.ThumbnailURL,
+				"duration":     blob.Duration,
+				"dimensions":   blob.Dimensions,
+				"createdAt":    now,
+			},
+		},
+		opts,
+	).Decode(&stored)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stored, nil
+}
+
+// ReleaseBlob drops a reference. It returns true when that was the last
+// one and the blob record is gone, so the content can be removed.
+func (mr *MediaRepository) ReleaseBlob(ctx context.Context, hash string) (bool, error) {
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var blob models.MediaBlob
+	err := mr.blobCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": hash, "refCount": bson.M{"$gt": 0}},
+		bson.M{
+			"$inc": bson.M{"refCount": -1},
+			"$set": bson.M{"updatedAt": time.Now()},
+		},
+		opts,
+	).Decode(&blob)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return false, errors.New("media blob not found")
+		}
+		return false, err
+	}
+
+	if blob.RefCount > 0 {
+		return false, nil
+	}
+
+	// A reference taken meanwhile keeps the blob
+	result, err := mr.blobCollection.DeleteOne(ctx, bson.M{"_id": hash, "refCount": 0})
+	if err != nil {
+		return false, err
+	}
+
+	return result.DeletedCount == 1, nil
+}
+
+func (mr *MediaRepository) CreateUploadIntent(ctx context.Context, intent *models.MediaUploadIntent) error {
+	intent.ID = primitive.NewObjectID()
+	intent.CreatedAt = time.Now()
+	intent.UpdatedAt = intent.CreatedAt
+
+	_, err := mr.uploadCollection.InsertOne(ctx, intent)
+	return err
+}
+
+func (mr *MediaRepository) GetUploadIntent(ctx context.Context, id string) (*models.MediaUploadIntent, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, errors.New("invalid upload ID")
+	}
+
+	var intent models.MediaUploadIntent
+	err = mr.uploadCollection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&intent)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("upload not found")
+		}
+		return nil, err
+	}
+	return &intent, nil
+}
+
+// ClaimUploadIntent moves a pending upload that hasn't expired to
+// processing, so it is only completed once
+func (mr *MediaRepository) ClaimUploadIntent(ctx context.Context, id primitive.ObjectID, now time.Time) error {
+	result, err := mr.uploadCollection.UpdateOne(ctx,
+		bson.M{
+			"_id":       id,
+			"status":    models.MediaUploadStatusPending,
+			"expiresAt": bson.M{"$gt": now},
+		},
+		bson.M{"$set": bson.M{
+			"status":    models.MediaUploadStatusProcessing,
+			"updatedAt": now,
+		}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("upload not found")
+	}
+	return nil
+}
+
+// SetUploadIntentStatus moves an upload out of processing, e.g. back to
+// pending when the client can retry
+func (mr *MediaRepository) SetUploadIntentStatus(ctx context.Context, id primitive.ObjectID, status string, mediaID *primitive.ObjectID) error {
+	now := time.Now()
+	set := bson.M{"status": status, "updatedAt": now}
+	if mediaID != nil {
+		set["mediaId"] = *mediaID
+		set["completedAt"] = now
+	}
+
+	_, err := mr.uploadCollection.UpdateOne(ctx,
+		bson.M{"_id": id, "status": models.MediaUploadStatusProcessing},
+		bson.M{"$set": set},
+	)
+	return err
+}
+
+// GetExpiredUploadIntents returns up to limit uploads, in any status, that
+// expired before the given time
+func (mr *MediaRepository) GetExpiredUploadIntents(ctx context.Context, before time.Time, limit int) ([]models.MediaUploadIntent, error) {
+	opts := options.Find().SetSort(bson.M{"expiresAt": 1}).SetLimit(int64(limit))
+	cursor, err := mr.uploadCollection.Find(ctx, bson.M{"expiresAt": bson.M{"$lt": before}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var intents []models.MediaUploadIntent
+	err = cursor.All(ctx, &intents)
+	return intents, err
+}
+
+func (mr *MediaRepository) DeleteUploadIntent(ctx context.Context, id primitive.ObjectID) error {
+	_, err := mr.uploadCollection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
@@ -0,0 +1,160 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"ftrack/database"
+	"ftrack/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type MessageDeliveryRepository struct {
+	collection *database.Collection
+}
+
+func NewMessageDeliveryRepository(db *mongo.Database) *MessageDeliveryRepository {
+	return &MessageDeliveryRepository{
+		collection: database.NewCollection(db, "message_deliveries"),
+	}
+}
+
+// RecordDeliveries adds the deliveries to their messages' summaries, one
+// write per message. A member's first delivery is the one kept.
+func (mdr *MessageDeliveryRepository) RecordDeliveries(ctx context.Context, pending []models.PendingMessageDelivery) error {
+	if len(pending) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	writes := make([]mongo.WriteModel, 0, len(pending))
+	for _, delivery := range pending {
+		delivered := bson.M{}
+		for userID, deliveredAt := range delivery.Members {
+			delivered["members."+userID+".deliveredAt"] = deliveredAt
+		}
+
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": delivery.MessageID}).
+			SetUpdate(bson.M{
+				"$min":         delivered,
+				"$set":         bson.M{"updatedAt": now},
+				"$setOnInsert": bson.M{"circleId": delivery.CircleID, "createdAt": now},
+			}).
+			SetUpsert(true))
+	}
+
+	_, err := mdr.collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+func (mdr *MessageDeliveryRepository) GetByMessageID(ctx context.Context, messageID string) (*models.MessageDelivery, error) {
+	messageObjectID, err := primitive.ObjectIDFromHex(messageID)
+	if err != nil {
+		return nil, errors.New("invalid message ID")
+	}
+
+	var delivery models.MessageDelivery
+	err = mdr.collection.FindOne(ctx, bson.M{"_id": messageObjectID}).Decode(&delivery)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("message delivery not found")
+		}
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// CompactBefore replaces the per-member detail of up to limit summaries
+// created before the cutoff with counts. Recipients are the circle's
+// active members other than the sender, as the circle is now. It returns
+// how many summaries were compacted.
+func (mdr *MessageDeliveryRepository) CompactBefore(ctx context.Context, before time.Time, limit int) (int, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"createdAt":   bson.M{"$lt": before},
+			"compactedAt": bson.M{"$exists": false},
+		}}},
+		{{Key: "$limit", Value: limit}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         "messages",
+			"localField":   "_id",
+			"foreignField": "_id",
+			"as":           "message",
+		}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         "circles",
+			"localField":   "circleId",
+			"foreignField": "_id",
+			"as":           "circle",
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"members":       1,
+			"senderId":      bson.M{"$first": "$message.senderId"},
+			"readBy":        bson.M{"$first": "$message.readBy"},
+			"circleMembers": bson.M{"$first": "$circle.members"},
+		}}},
+	}
+
+	cursor, err := mdr.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var summaries []struct {
+		ID            primitive.ObjectID               `bson:"_id"`
+		Members       map[string]models.MemberDelivery `bson:"members"`
+		SenderID      primitive.ObjectID               `bson:"senderId"`
+		ReadBy        []models.MessageReadStatus       `bson:"readBy"`
+		CircleMembers []models.CircleMember            `bson:"circleMembers"`
+	}
+	if err := cursor.All(ctx, &summaries); err != nil {
+		return 0, err
+	}
+	if len(summaries) == 0 {
+		return 0, nil
+	}
+
+	now := time.Now()
+	writes := make([]mongo.WriteModel, 0, len(summaries))
+	for _, summary := range summaries {
+		recipients := make(map[primitive.ObjectID]bool)
+		for _, member := range summary.CircleMembers {
+			if member.UserID != summary.SenderID && member.Status == "active" {
+				recipients[member.UserID] = true
+			}
+		}
+
+		read := 0
+		for _, readStatus := range summary.ReadBy {
+			if recipients[readStatus.UserID] {
+				read++
+			}
+		}
+
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": summary.ID}).
+			SetUpdate(bson.M{
+				"$set": bson.M{
+					"deliveredCount": len(summary.Members),
+					"readCount":      read,
+					"recipientCount": len(recipients),
+					"compactedAt":    now,
+					"updatedAt":      now,
+				},
+				"$unset": bson.M{"members": ""},
+			}))
+	}
+
+	result, err := mdr.collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return 0, err
+	}
+	return int(result.ModifiedCount), nil
+}
@@ -3,6 +3,7 @@ package repositories
 import (
 	"context"
 	"errors"
+	"ftrack/database"
 	"ftrack/models"
 	"time"
 
@@ -13,16 +14,20 @@ import (
 )
 
 type MessageRepository struct {
-	collection        *mongo.Collection
-	forwardCollection *mongo.Collection
-	db                *mongo.Database
+	collection         *database.Collection
+	forwardCollection  *database.Collection
+	reactionCollection *database.Collection
+	db                 *mongo.Database
+	encryption         contentEncryption
 }
 
 func NewMessageRepository(db *mongo.Database) *MessageRepository {
 	return &MessageRepository{
-		collection:        db.Collection("messages"),
-		forwardCollection: db.Collection("message_forwards"),
-		db:                db,
+		collection:         database.NewCollection(db, "messages"),
+		forwardCollection:  database.NewCollection(db, "message_forwards"),
+		reactionCollection: database.NewCollection(db, "message_reactions"),
+		db:                 db,
+		encryption:         newContentEncryption(db),
 	}
 }
 
@@ -37,11 +42,103 @@ func (mr *MessageRepository) Create(ctx context.Context, message *models.Message
 	if message.Status == "" {
 		message.Status = "sent"
 	}
+	if message.ComposedAt.IsZero() {
+		message.ComposedAt = message.CreatedAt
+	}
+
+	// Stored as empty arrays rather than null, which $push, $addToSet and
+	// $pull refuse
+	if message.ReadBy == nil {
+		message.ReadBy = []models.MessageReadStatus{}
+	}
+	if message.Reactions == nil {
+		message.Reactions = []models.MessageReaction{}
+	}
+
+	// Store a copy, so the caller keeps the plain content to broadcast
+	stored := *message
+	content, keyID, err := mr.encryption.seal(ctx, message.CircleID, message.Content)
+	if err != nil {
+		return err
+	}
+	stored.Content = content
+	stored.ContentKeyID = keyID
 
-	_, err := mr.collection.InsertOne(ctx, message)
+	_, err = mr.collection.InsertOne(ctx, &stored)
+	if mongo.IsDuplicateKeyError(err) && message.ClientID != "" {
+		return errors.New("client ID already used")
+	}
 	return err
 }
 
+// GetByClientID returns the message the sender stored under their client ID
+func (mr *MessageRepository) GetByClientID(ctx context.Context, senderID primitive.ObjectID, clientID string) (*models.Message, error) {
+	var message models.Message
+	err := mr.collection.FindOne(ctx, bson.M{
+		"senderId": senderID,
+		"clientId": clientID,
+	}).Decode(&message)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("message not found")
+		}
+		return nil, err
+	}
+
+	if err := decryptMessage(&message); err != nil {
+		return nil, err
+	}
+
+	return &message, nil
+}
+
+// MoveCircleMessages moves up to limit of a circle's messages, and their
+// reaction records, to another circle. It returns how many moved, so
+// callers repeat it until none are left. Content keeps the encryption it
+// was stored with until the next message encryption run.
+func (mr *MessageRepository) MoveCircleMessages(ctx context.Context, fromCircleID, toCircleID primitive.ObjectID, limit int) (int64, error) {
+	opts := options.Find().SetProjection(bson.M{"_id": 1}).SetLimit(int64(limit))
+	cursor, err := mr.collection.Find(ctx, bson.M{"circleId": fromCircleID}, opts)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var batch []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &batch); err != nil {
+		return 0, err
+	}
+	if len(batch) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]primitive.ObjectID, len(batch))
+	for i, message := range batch {
+		ids[i] = message.ID
+	}
+
+	// Reactions first, so a retry after a failure still finds the messages
+	if _, err := mr.reactionCollection.UpdateMany(ctx,
+		bson.M{"messageId": bson.M{"$in": ids}},
+		bson.M{"$set": bson.M{"circleId": toCircleID}},
+	); err != nil {
+		return 0, err
+	}
+
+	result, err := mr.collection.UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$in": ids}, "circleId": fromCircleID},
+		bson.M{"$set": bson.M{"circleId": toCircleID}},
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+}
+
 func (mr *MessageRepository) GetByID(ctx context.Context, id string) (*models.Message, error) {
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -61,9 +158,32 @@ func (mr *MessageRepository) GetByID(ctx context.Context, id string) (*models.Me
 		return nil, err
 	}
 
+	if err := decryptMessage(&message); err != nil {
+		return nil, err
+	}
+
 	return &message, nil
 }
 
+// GetByIDs returns the messages that exist and aren't deleted, in no
+// particular order
+func (mr *MessageRepository) GetByIDs(ctx context.Context, ids []primitive.ObjectID) ([]models.Message, error) {
+	cursor, err := mr.collection.Find(ctx, bson.M{
+		"_id":       bson.M{"$in": ids},
+		"isDeleted": bson.M{"$ne": true},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var messages []models.Message
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+	return messages, DecryptMessages(messages)
+}
+
 func (mr *MessageRepository) Update(ctx context.Context, id string, update bson.M) error {
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -72,13 +192,36 @@ func (mr *MessageRepository) Update(ctx context.Context, id string, update bson.
 
 	update["updatedAt"] = time.Now()
 
+	change := bson.M{"$set": update}
+	if _, exists := update["content"]; exists {
+		var message struct {
+			CircleID primitive.ObjectID `bson:"circleId"`
+		}
+		opts := options.FindOne().SetProjection(bson.M{"circleId": 1})
+		err := mr.collection.FindOne(ctx, bson.M{"_id": objectID}, opts).Decode(&message)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return errors.New("message not found")
+			}
+			return err
+		}
+
+		unset, err := mr.encryption.sealUpdate(ctx, message.CircleID, update)
+		if err != nil {
+			return err
+		}
+		if unset != nil {
+			change["$unset"] = unset
+		}
+	}
+
 	result, err := mr.collection.UpdateOne(
 		ctx,
 		bson.M{
 			"_id":       objectID,
 			"isDeleted": bson.M{"$ne": true},
 		},
-		bson.M{"$set": update},
+		change,
 	)
 
 	if err != nil {
@@ -122,7 +265,9 @@ func (mr *MessageRepository) SoftDelete(ctx context.Context, id string) error {
 		return errors.New("message not found")
 	}
 
-	return nil
+	// Reactions to deleted messages no longer count towards stats
+	_, err = mr.reactionCollection.DeleteMany(ctx, bson.M{"messageId": objectID})
+	return err
 }
 
 func (mr *MessageRepository) Hide(ctx context.Context, id string) error {
@@ -166,7 +311,7 @@ func (mr *MessageRepository) GetCircleMessages(ctx context.Context, circleID str
 
 	skip := (page - 1) * pageSize
 	opts := options.Find().
-		SetSort(bson.D{{Key: "createdAt", Value: -1}}).
+		SetSort(messageOrder(-1)).
 		SetSkip(int64(skip)).
 		SetLimit(int64(pageSize))
 
@@ -183,8 +328,10 @@ func (mr *MessageRepository) GetCircleMessages(ctx context.Context, circleID str
 	defer cursor.Close(ctx)
 
 	var messages []models.Message
-	err = cursor.All(ctx, &messages)
-	return messages, err
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+	return messages, DecryptMessages(messages)
 }
 
 func (mr *MessageRepository) GetCircleMessagesPaginated(ctx context.Context, req models.GetMessagesRequest) ([]models.Message, int64, error) {
@@ -200,20 +347,23 @@ func (mr *MessageRepository) GetCircleMessagesPaginated(ctx context.Context, req
 	}
 
 	// Add cursor-based pagination if before/after specified
+	var cursors bson.A
 	if req.Before != "" {
-		beforeObjectID, err := primitive.ObjectIDFromHex(req.Before)
-		if err == nil {
-			filter["_id"] = bson.M{"$lt": beforeObjectID}
+		if before, err := mr.messageCursorFilter(ctx, req.Before, "$lt"); err == nil {
+			cursors = append(cursors, bson.M{"$or": before})
 		}
 	}
 
 	if req.After != "" {
-		afterObjectID, err := primitive.ObjectIDFromHex(req.After)
-		if err == nil {
-			filter["_id"] = bson.M{"$gt": afterObjectID}
+		if after, err := mr.messageCursorFilter(ctx, req.After, "$gt"); err == nil {
+			cursors = append(cursors, bson.M{"$or": after})
 		}
 	}
 
+	if len(cursors) > 0 {
+		filter["$and"] = cursors
+	}
+
 	// Get total count
 	total, err := mr.collection.CountDocuments(ctx, filter)
 	if err != nil {
@@ -223,7 +373,7 @@ func (mr *MessageRepository) GetCircleMessagesPaginated(ctx context.Context, req
 	// Get messages
 	skip := (req.Page - 1) * req.PageSize
 	opts := options.Find().
-		SetSort(bson.D{{"createdAt", -1}}).
+		SetSort(messageOrder(-1)).
 		SetSkip(int64(skip)).
 		SetLimit(int64(req.PageSize))
 
@@ -234,8 +384,47 @@ func (mr *MessageRepository) GetCircleMessagesPaginated(ctx context.Context, req
 	defer cursor.Close(ctx)
 
 	var messages []models.Message
-	err = cursor.All(ctx, &messages)
-	return messages, total, err
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, 0, err
+	}
+	return messages, total, DecryptMessages(messages)
+}
+
+// messageOrder sorts messages by when they were composed, then by when the
+// server got them
+func messageOrder(direction int) bson.D {
+	return bson.D{
+		{Key: "composedAt", Value: direction},
+		{Key: "createdAt", Value: direction},
+		{Key: "_id", Value: direction},
+	}
+}
+
+// messageCursorFilter matches the messages ordered before ($lt) or after
+// ($gt) the given one
+func (mr *MessageRepository) messageCursorFilter(ctx context.Context, messageID, op string) (bson.A, error) {
+	objectID, err := primitive.ObjectIDFromHex(messageID)
+	if err != nil {
+		return nil, errors.New("invalid message ID")
+	}
+
+	var anchor struct {
+		ComposedAt time.Time `bson:"composedAt"`
+		CreatedAt  time.Time `bson:"createdAt"`
+	}
+	opts := options.FindOne().SetProjection(bson.M{"composedAt": 1, "createdAt": 1})
+	if err := mr.collection.FindOne(ctx, bson.M{"_id": objectID}, opts).Decode(&anchor); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("message not found")
+		}
+		return nil, err
+	}
+
+	return bson.A{
+		bson.M{"composedAt": bson.M{op: anchor.ComposedAt}},
+		bson.M{"composedAt": anchor.ComposedAt, "createdAt": bson.M{op: anchor.CreatedAt}},
+		bson.M{"composedAt": anchor.ComposedAt, "createdAt": anchor.CreatedAt, "_id": bson.M{op: objectID}},
+	}, nil
 }
 
 func (mr *MessageRepository) GetMessagesSince(ctx context.Context, circleID string, since time.Time) ([]models.Message, error) {
@@ -259,8 +448,58 @@ func (mr *MessageRepository) GetMessagesSince(ctx context.Context, circleID stri
 	defer cursor.Close(ctx)
 
 	var messages []models.Message
-	err = cursor.All(ctx, &messages)
-	return messages, err
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+	return messages, DecryptMessages(messages)
+}
+
+// GetCircleMessagesInRange returns messages in chronological order, optionally
+// limited to a date range, along with the total matching count
+func (mr *MessageRepository) GetCircleMessagesInRange(ctx context.Context, circleID string, dateRange models.ExportDateRange, skip, limit int) ([]models.Message, int64, error) {
+	objectID, err := primitive.ObjectIDFromHex(circleID)
+	if err != nil {
+		return nil, 0, errors.New("invalid circle ID")
+	}
+
+	filter := bson.M{
+		"circleId":  objectID,
+		"isDeleted": bson.M{"$ne": true},
+		"isHidden":  bson.M{"$ne": true},
+	}
+
+	createdAt := bson.M{}
+	if dateRange.From != nil {
+		createdAt["$gte"] = *dateRange.From
+	}
+	if dateRange.To != nil {
+		createdAt["$lte"] = *dateRange.To
+	}
+	if len(createdAt) > 0 {
+		filter["createdAt"] = createdAt
+	}
+
+	total, err := mr.collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(messageOrder(1)).
+		SetSkip(int64(skip)).
+		SetLimit(int64(limit))
+
+	cursor, err := mr.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var messages []models.Message
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, 0, err
+	}
+	return messages, total, DecryptMessages(messages)
 }
 
 // =============================================================================
@@ -299,8 +538,10 @@ func (mr *MessageRepository) GetReplies(ctx context.Context, messageID string, p
 	defer cursor.Close(ctx)
 
 	var replies []models.Message
-	err = cursor.All(ctx, &replies)
-	return replies, total, err
+	if err := cursor.All(ctx, &replies); err != nil {
+		return nil, 0, err
+	}
+	return replies, total, DecryptMessages(replies)
 }
 
 func (mr *MessageRepository) IncrementReplyCount(ctx context.Context, messageID string) error {
@@ -411,6 +652,193 @@ func (mr *MessageRepository) RemoveReaction(ctx context.Context, messageID, user
 	return nil
 }
 
+// ToggleReaction adds the user's reaction if absent and removes it if
+// present, in a single update so concurrent toggles can't race. It returns
+// whether the user now has the reaction and the emoji's new count.
+func (mr *MessageRepository) ToggleReaction(ctx context.Context, messageID, userID, emoji string) (bool, int, error) {
+	messageObjectID, err := primitive.ObjectIDFromHex(messageID)
+	if err != nil {
+		return false, 0, errors.New("invalid message ID")
+	}
+
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return false, 0, errors.New("invalid user ID")
+	}
+
+	now := time.Now()
+	reactions := bson.M{"$ifNull": bson.A{"$reactions", bson.A{}}}
+	isUserReaction := bson.M{"$and": bson.A{
+		bson.M{"$eq": bson.A{"$$r.userId", userObjectID}},
+		bson.M{"$eq": bson.A{"$$r.emoji", bson.M{"$literal": emoji}}},
+	}}
+
+	pipeline := []bson.M{
+		{"$set": bson.M{
+			"reactions": bson.M{"$cond": bson.A{
+				bson.M{"$anyElementTrue": bson.A{bson.M{"$map": bson.M{
+					"input": reactions,
+					"as":    "r",
+					"in":    isUserReaction,
+				}}}},
+				bson.M{"$filter": bson.M{
+					"input": reactions,
+					"as":    "r",
+					"cond":  bson.M{"$not": bson.A{isUserReaction}},
+				}},
+				bson.M{"$concatArrays": bson.A{reactions, bson.A{bson.M{
+					"userId":  userObjectID,
+					"emoji":   bson.M{"$literal": emoji},
+					"addedAt": now,
+				}}}},
+			}},
+			"updatedAt": now,
+		}},
+	}
+
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(bson.M{"reactions": 1})
+
+	var updated struct {
+		Reactions []models.MessageReaction `bson:"reactions"`
+	}
+	err = mr.collection.FindOneAndUpdate(ctx, bson.M{"_id": messageObjectID}, pipeline, opts).Decode(&updated)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return false, 0, errors.New("message not found")
+		}
+		return false, 0, err
+	}
+
+	reacted := false
+	count := 0
+	for _, reaction := range updated.Reactions {
+		if reaction.Emoji != emoji {
+			continue
+		}
+		count++
+		if reaction.UserID == userObjectID {
+			reacted = true
+		}
+	}
+
+	return reacted, count, nil
+}
+
+// RecordReaction adds a reaction to the reactions side collection, which
+// reaction stats read instead of the messages' embedded arrays
+func (mr *MessageRepository) RecordReaction(ctx context.Context, circleID, messageID primitive.ObjectID, userID, emoji string) error {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return errors.New("invalid user ID")
+	}
+
+	filter := bson.M{"messageId": messageID, "userId": userObjectID, "emoji": emoji}
+	_, err = mr.reactionCollection.UpdateOne(ctx, filter, bson.M{
+		"$setOnInsert": bson.M{
+			"circleId":  circleID,
+			"createdAt": time.Now(),
+		},
+	}, options.Update().SetUpsert(true))
+	return err
+}
+
+// DeleteReactionRecord removes a reaction from the reactions side collection
+func (mr *MessageRepository) DeleteReactionRecord(ctx context.Context, messageID primitive.ObjectID, userID, emoji string) error {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return errors.New("invalid user ID")
+	}
+
+	_, err = mr.reactionCollection.DeleteOne(ctx, bson.M{"messageId": messageID, "userId": userObjectID, "emoji": emoji})
+	return err
+}
+
+// GetReactionStats counts a circle's reactions since the given time by
+// emoji, reactor and message. Only the reactions side collection is read.
+func (mr *MessageRepository) GetReactionStats(ctx context.Context, circleID string, since time.Time, limit int) (*ReactionCounts, error) {
+	circleObjectID, err := primitive.ObjectIDFromHex(circleID)
+	if err != nil {
+		return nil, errors.New("invalid circle ID")
+	}
+
+	match := bson.M{"circleId": circleObjectID}
+	if !since.IsZero() {
+		match["createdAt"] = bson.M{"$gte": since}
+	}
+
+	topBy := func(field string, limit int) bson.A {
+		return bson.A{
+			bson.M{"$group": bson.M{"_id": "$" + field, "count": bson.M{"$sum": 1}}},
+			bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+			bson.M{"$limit": limit},
+		}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$facet", Value: bson.M{
+			"total":    bson.A{bson.M{"$count": "count"}},
+			"emojis":   topBy("emoji", limit),
+			"reactors": topBy("userId", limit),
+			"messages": topBy("messageId", limit),
+		}}},
+	}
+
+	cursor, err := mr.reactionCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		Total []struct {
+			Count int64 `bson:"count"`
+		} `bson:"total"`
+		Emojis []struct {
+			Emoji string `bson:"_id"`
+			Count int64  `bson:"count"`
+		} `bson:"emojis"`
+		Reactors []ReactionCount `bson:"reactors"`
+		Messages []ReactionCount `bson:"messages"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	counts := &ReactionCounts{}
+	if len(results) == 0 {
+		return counts, nil
+	}
+
+	result := results[0]
+	if len(result.Total) > 0 {
+		counts.Total = result.Total[0].Count
+	}
+	for _, emoji := range result.Emojis {
+		counts.Emojis = append(counts.Emojis, models.EmojiCount{Emoji: emoji.Emoji, Count: emoji.Count})
+	}
+	counts.Reactors = result.Reactors
+	counts.Messages = result.Messages
+
+	return counts, nil
+}
+
+// ReactionCounts is the raw result of GetReactionStats, most used first
+type ReactionCounts struct {
+	Total    int64
+	Emojis   []models.EmojiCount
+	Reactors []ReactionCount
+	Messages []ReactionCount
+}
+
+// ReactionCount is the number of reactions by a user or to a message
+type ReactionCount struct {
+	ID    primitive.ObjectID `bson:"_id"`
+	Count int64              `bson:"count"`
+}
+
 func (mr *MessageRepository) GetReactionUsers(ctx context.Context, messageID, emoji string) ([]models.UserInfo, error) {
 	messageObjectID, err := primitive.ObjectIDFromHex(messageID)
 	if err != nil {
@@ -583,35 +1011,40 @@ func (mr *MessageRepository) GetUnreadCount(ctx context.Context, circleID, userI
 	return count, err
 }
 
-func (mr *MessageRepository) GetDeliveryStatus(ctx context.Context, messageID string) (*models.DeliveryStatusResponse, error) {
-	messageObjectID, err := primitive.ObjectIDFromHex(messageID)
+// GetUnreadCounts counts the user's unread messages in each of the circles,
+// like GetUnreadCount. Circles with none are left out.
+func (mr *MessageRepository) GetUnreadCounts(ctx context.Context, circleIDs []primitive.ObjectID, userID string) (map[primitive.ObjectID]int64, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
-		return nil, errors.New("invalid message ID")
+		return nil, errors.New("invalid user ID")
+	}
+
+	return countByCircle(ctx, mr.collection, bson.M{
+		"circleId":      bson.M{"$in": circleIDs},
+		"senderId":      bson.M{"$ne": userObjectID},
+		"readBy.userId": bson.M{"$ne": userObjectID},
+		"isDeleted":     bson.M{"$ne": true},
+		"isHidden":      bson.M{"$ne": true},
+	})
+}
+
+// GetLatestMessages returns the latest message of each of the circles,
+// leaving out deleted and hidden messages and those of the excluded
+// senders. Circles without one are left out.
+func (mr *MessageRepository) GetLatestMessages(ctx context.Context, circleIDs []primitive.ObjectID, excludeSenderIDs []primitive.ObjectID) (map[primitive.ObjectID]models.Message, error) {
+	match := bson.M{
+		"circleId":  bson.M{"$in": circleIDs},
+		"isDeleted": bson.M{"$ne": true},
+		"isHidden":  bson.M{"$ne": true},
+	}
+	if len(excludeSenderIDs) > 0 {
+		match["senderId"] = bson.M{"$nin": excludeSenderIDs}
 	}
 
-	// Use aggregation to get delivery status with user details
 	pipeline := mongo.Pipeline{
-		{{Key: "$match", Value: bson.M{"_id": messageObjectID}}},
-		{{Key: "$lookup", Value: bson.M{
-			"from":         "circles",
-			"localField":   "circleId",
-			"foreignField": "_id",
-			"as":           "circle",
-		}}},
-		{{Key: "$unwind", Value: "$circle"}},
-		{{Key: "$lookup", Value: bson.M{
-			"from":         "users",
-			"localField":   "circle.members",
-			"foreignField": "_id",
-			"as":           "members",
-		}}},
-		{{Key: "$project", Value: bson.M{
-			"_id":      1,
-			"status":   1,
-			"readBy":   1,
-			"members":  1,
-			"senderId": 1,
-		}}},
+		{{Key: "$match", Value: match}},
+		{{Key: "$sort", Value: append(bson.D{{Key: "circleId", Value: 1}}, messageOrder(-1)...)}},
+		{{Key: "$group", Value: bson.M{"_id": "$circleId", "message": bson.M{"$first": "$$ROOT"}}}},
 	}
 
 	cursor, err := mr.collection.Aggregate(ctx, pipeline)
@@ -620,61 +1053,26 @@ func (mr *MessageRepository) GetDeliveryStatus(ctx context.Context, messageID st
 	}
 	defer cursor.Close(ctx)
 
-	var result struct {
-		ID       primitive.ObjectID         `bson:"_id"`
-		Status   string                     `bson:"status"`
-		ReadBy   []models.MessageReadStatus `bson:"readBy"`
-		Members  []models.User              `bson:"members"`
-		SenderID primitive.ObjectID         `bson:"senderId"`
+	var results []struct {
+		Message models.Message `bson:"message"`
 	}
-
-	if cursor.Next(ctx) {
-		err = cursor.Decode(&result)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		return nil, errors.New("message not found")
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
 	}
 
-	// Calculate delivery statistics
-	totalRecipients := 0
-	deliveredCount := 0
-	details := make([]models.DeliveryStatusDetail, 0)
-
-	readByMap := make(map[string]time.Time)
-	for _, readStatus := range result.ReadBy {
-		readByMap[readStatus.UserID.Hex()] = readStatus.ReadAt
+	messages := make([]models.Message, len(results))
+	for i, result := range results {
+		messages[i] = result.Message
 	}
-
-	for _, member := range result.Members {
-		// Skip sender
-		if member.ID == result.SenderID {
-			continue
-		}
-
-		totalRecipients++
-		detail := models.DeliveryStatusDetail{
-			UserID: member.ID.Hex(),
-			Status: "sent",
-		}
-
-		if readTime, isRead := readByMap[member.ID.Hex()]; isRead {
-			detail.Status = "read"
-			detail.DeliveredAt = readTime
-			deliveredCount++
-		}
-
-		details = append(details, detail)
+	if err := DecryptMessages(messages); err != nil {
+		return nil, err
 	}
 
-	return &models.DeliveryStatusResponse{
-		MessageID: messageID,
-		Status:    result.Status,
-		Delivered: deliveredCount,
-		Total:     totalRecipients,
-		Details:   details,
-	}, nil
+	latest := make(map[primitive.ObjectID]models.Message, len(messages))
+	for _, message := range messages {
+		latest[message.CircleID] = message
+	}
+	return latest, nil
 }
 
 func (mr *MessageRepository) GetReadReceipts(ctx context.Context, messageID string) (*models.ReadReceiptsResponse, error) {
@@ -791,9 +1189,14 @@ func (mr *MessageRepository) CheckMediaAccess(ctx context.Context, mediaID strin
 		circleObjectIDs[i] = objectID
 	}
 
+	mediaObjectID, err := primitive.ObjectIDFromHex(mediaID)
+	if err != nil {
+		return false, errors.New("invalid media ID")
+	}
+
 	filter := bson.M{
 		"circleId":  bson.M{"$in": circleObjectIDs},
-		"media.id":  mediaID,
+		"media._id": mediaObjectID,
 		"isDeleted": bson.M{"$ne": true},
 		"isHidden":  bson.M{"$ne": true},
 	}
@@ -1148,6 +1551,9 @@ func (mr *MessageRepository) GetPopularMessages(ctx context.Context, circleIDs [
 
 	popularMessages := make([]models.PopularMessageInfo, len(results))
 	for i, result := range results {
+		if err := decryptMessage(&result.Message); err != nil {
+			return nil, err
+		}
 		popularMessages[i] = models.PopularMessageInfo{
 			Message:       result.Message,
 			Score:         result.Score,
@@ -1176,7 +1582,7 @@ func (mr *MessageRepository) getCircleMemberCount(ctx context.Context, circleID,
 	}
 
 	// Get circle from circles collection
-	circleCollection := mr.db.Collection("circles")
+	circleCollection := database.NewCollection(mr.db, "circles")
 	var circle struct {
 		Members []primitive.ObjectID `bson:"members"`
 	}
@@ -1209,11 +1615,13 @@ func (mr *MessageRepository) SearchMessages(ctx context.Context, query string, c
 		circleObjectIDs[i] = objectID
 	}
 
+	// Encrypted content can't be matched, so it is left out of search
 	filter := bson.M{
-		"circleId":  bson.M{"$in": circleObjectIDs},
-		"content":   bson.M{"$regex": query, "$options": "i"},
-		"isDeleted": bson.M{"$ne": true},
-		"isHidden":  bson.M{"$ne": true},
+		"circleId":     bson.M{"$in": circleObjectIDs},
+		"content":      bson.M{"$regex": query, "$options": "i"},
+		"contentKeyId": bson.M{"$exists": false},
+		"isDeleted":    bson.M{"$ne": true},
+		"isHidden":     bson.M{"$ne": true},
 	}
 
 	opts := options.Find().
@@ -1227,8 +1635,10 @@ func (mr *MessageRepository) SearchMessages(ctx context.Context, query string, c
 	defer cursor.Close(ctx)
 
 	var messages []models.Message
-	err = cursor.All(ctx, &messages)
-	return messages, err
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+	return messages, DecryptMessages(messages)
 }
 
 func (mr *MessageRepository) GetMessagesByType(ctx context.Context, circleIDs []string, messageType string, limit int) ([]models.Message, error) {
@@ -1259,8 +1669,10 @@ func (mr *MessageRepository) GetMessagesByType(ctx context.Context, circleIDs []
 	defer cursor.Close(ctx)
 
 	var messages []models.Message
-	err = cursor.All(ctx, &messages)
-	return messages, err
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+	return messages, DecryptMessages(messages)
 }
 
 func (mr *MessageRepository) GetMessagesWithMedia(ctx context.Context, circleIDs []string, mediaType string, limit int) ([]models.Message, error) {
@@ -1295,8 +1707,73 @@ func (mr *MessageRepository) GetMessagesWithMedia(ctx context.Context, circleIDs
 	defer cursor.Close(ctx)
 
 	var messages []models.Message
-	err = cursor.All(ctx, &messages)
-	return messages, err
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+	return messages, DecryptMessages(messages)
+}
+
+// GetCirclesWithMediaSince returns the circles where media of the type was
+// shared after the given time
+func (mr *MessageRepository) GetCirclesWithMediaSince(ctx context.Context, mediaType string, since time.Time) ([]primitive.ObjectID, error) {
+	values, err := mr.collection.Distinct(ctx, "circleId", bson.M{
+		"media.type": mediaType,
+		"createdAt":  bson.M{"$gte": since},
+		"isDeleted":  bson.M{"$ne": true},
+		"isHidden":   bson.M{"$ne": true},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	circleIDs := make([]primitive.ObjectID, 0, len(values))
+	for _, value := range values {
+		if circleID, ok := value.(primitive.ObjectID); ok {
+			circleIDs = append(circleIDs, circleID)
+		}
+	}
+	return circleIDs, nil
+}
+
+// GetCircleMediaSince returns the circle's messages with media of the type
+// shared after the given time, oldest first
+func (mr *MessageRepository) GetCircleMediaSince(ctx context.Context, circleID primitive.ObjectID, mediaType string, since time.Time) ([]models.Message, error) {
+	filter := bson.M{
+		"circleId":        circleID,
+		"media.type":      mediaType,
+		"media.url":       bson.M{"$exists": true, "$ne": ""},
+		"media.isDeleted": bson.M{"$ne": true},
+		"createdAt":       bson.M{"$gte": since},
+		"isDeleted":       bson.M{"$ne": true},
+		"isHidden":        bson.M{"$ne": true},
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	cursor, err := mr.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var messages []models.Message
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+	return messages, DecryptMessages(messages)
+}
+
+// MarkMediaDeleted flags the deleted media on the messages it was shared in
+func (mr *MessageRepository) MarkMediaDeleted(ctx context.Context, mediaID primitive.ObjectID) error {
+	now := time.Now()
+	_, err := mr.collection.UpdateMany(ctx,
+		bson.M{"media._id": mediaID},
+		bson.M{"$set": bson.M{
+			"media.isDeleted": true,
+			"media.deletedAt": now,
+			"updatedAt":       now,
+		}},
+	)
+	return err
 }
 
 // Batch operations
@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"ftrack/database"
 	"ftrack/models"
 	"time"
 
@@ -16,37 +17,47 @@ import (
 
 type NotificationRepository struct {
 	db                       *mongo.Database
-	notificationCollection   *mongo.Collection
-	pushSettingsCollection   *mongo.Collection
-	pushDeviceCollection     *mongo.Collection
-	preferencesCollection    *mongo.Collection
-	emailSettingsCollection  *mongo.Collection
-	emailTemplatesCollection *mongo.Collection
-	smsSettingsCollection    *mongo.Collection
-	inAppSettingsCollection  *mongo.Collection
-	channelsCollection       *mongo.Collection
-	rulesCollection          *mongo.Collection
-	dndCollection            *mongo.Collection
-	templatesCollection      *mongo.Collection
-	subscriptionsCollection  *mongo.Collection
+	notificationCollection   *database.Collection
+	pushSettingsCollection   *database.Collection
+	pushDeviceCollection     *database.Collection
+	preferencesCollection    *database.Collection
+	emailSettingsCollection  *database.Collection
+	emailTemplatesCollection *database.Collection
+	smsSettingsCollection    *database.Collection
+	inAppSettingsCollection  *database.Collection
+	channelsCollection       *database.Collection
+	rulesCollection          *database.Collection
+	dndCollection            *database.Collection
+	templatesCollection      *database.Collection
+	subscriptionsCollection  *database.Collection
+	broadcastsCollection     *database.Collection
+	broadcastDeliveries      *database.Collection
+	decisionsCollection      *database.Collection
+	experimentsCollection    *database.Collection
+	experimentOptOuts        *database.Collection
 }
 
 func NewNotificationRepository(db *mongo.Database) *NotificationRepository {
 	return &NotificationRepository{
 		db:                       db,
-		notificationCollection:   db.Collection("notifications"),
-		pushSettingsCollection:   db.Collection("push_settings"),
-		pushDeviceCollection:     db.Collection("push_devices"),
-		preferencesCollection:    db.Collection("notification_preferences"),
-		emailSettingsCollection:  db.Collection("email_settings"),
-		emailTemplatesCollection: db.Collection("email_templates"),
-		smsSettingsCollection:    db.Collection("sms_settings"),
-		inAppSettingsCollection:  db.Collection("in_app_settings"),
-		channelsCollection:       db.Collection("notification_channels"),
-		rulesCollection:          db.Collection("notification_rules"),
-		dndCollection:            db.Collection("dnd_settings"),
-		templatesCollection:      db.Collection("notification_templates"),
-		subscriptionsCollection:  db.Collection("notification_subscriptions"),
+		notificationCollection:   database.NewCollection(db, "notifications"),
+		pushSettingsCollection:   database.NewCollection(db, "push_settings"),
+		pushDeviceCollection:     database.NewCollection(db, "push_devices"),
+		preferencesCollection:    database.NewCollection(db, "notification_preferences"),
+		emailSettingsCollection:  database.NewCollection(db, "email_settings"),
+		emailTemplatesCollection: database.NewCollection(db, "email_templates"),
+		smsSettingsCollection:    database.NewCollection(db, "sms_settings"),
+		inAppSettingsCollection:  database.NewCollection(db, "in_app_settings"),
+		channelsCollection:       database.NewCollection(db, "notification_channels"),
+		rulesCollection:          database.NewCollection(db, "notification_rules"),
+		dndCollection:            database.NewCollection(db, "dnd_settings"),
+		templatesCollection:      database.NewCollection(db, "notification_templates"),
+		subscriptionsCollection:  database.NewCollection(db, "notification_subscriptions"),
+		broadcastsCollection:     database.NewCollection(db, "notification_broadcasts"),
+		broadcastDeliveries:      database.NewCollection(db, "notification_broadcast_deliveries"),
+		decisionsCollection:      database.NewCollection(db, "notification_decisions"),
+		experimentsCollection:    database.NewCollection(db, "notification_experiments"),
+		experimentOptOuts:        database.NewCollection(db, "notification_experiment_opt_outs"),
 	}
 }
 
@@ -112,19 +123,165 @@ func (nr *NotificationRepository) Delete(ctx context.Context, id string) error {
 	return nil
 }
 
+// bulkFilter matches the user's notifications a filter-based bulk
+// operation selects
+func bulkFilter(userID string, req models.BulkNotificationFilterRequest) bson.M {
+	filter := bson.M{"user_id": userID}
+
+	if len(req.Types) > 0 {
+		filter["type"] = bson.M{"$in": req.Types}
+	}
+	switch req.Status {
+	case "read", "unread":
+		filter["status"] = req.Status
+	case "archived":
+		filter["is_archived"] = true
+	}
+	if req.CircleID != "" {
+		filter["circle_id"] = req.CircleID
+	}
+	if req.OlderThanDays > 0 {
+		filter["created_at"] = bson.M{"$lt": time.Now().AddDate(0, 0, -req.OlderThanDays)}
+	}
+	return filter
+}
+
+// DeleteByFilter deletes the user's notifications the filter selects, or
+// only counts them for a dry run
+func (nr *NotificationRepository) DeleteByFilter(ctx context.Context, userID string, req models.BulkNotificationFilterRequest) (int64, error) {
+	filter := bulkFilter(userID, req)
+
+	if req.DryRun {
+		count, err := nr.notificationCollection.CountDocuments(ctx, filter)
+		if err != nil {
+			return 0, fmt.Errorf("failed to count notifications: %w", err)
+		}
+		return count, nil
+	}
+
+	result, err := nr.notificationCollection.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete notifications: %w", err)
+	}
+	return result.DeletedCount, nil
+}
+
+// ArchiveByFilter archives the user's notifications the filter selects
+// that aren't archived yet, or only counts them for a dry run
+func (nr *NotificationRepository) ArchiveByFilter(ctx context.Context, userID string, req models.BulkNotificationFilterRequest) (int64, error) {
+	filter := bulkFilter(userID, req)
+	if req.Status == "archived" {
+		return 0, nil
+	}
+	filter["is_archived"] = bson.M{"$ne": true}
+
+	if req.DryRun {
+		count, err := nr.notificationCollection.CountDocuments(ctx, filter)
+		if err != nil {
+			return 0, fmt.Errorf("failed to count notifications: %w", err)
+		}
+		return count, nil
+	}
+
+	result, err := nr.notificationCollection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{
+		"is_archived": true,
+		"updated_at":  time.Now(),
+	}})
+	if err != nil {
+		return 0, fmt.Errorf("failed to archive notifications: %w", err)
+	}
+	return result.ModifiedCount, nil
+}
+
+// DeleteUnreadCollapsed removes the user's unread notifications with the
+// collapse key, so a notification replacing them doesn't stack on them
+func (nr *NotificationRepository) DeleteUnreadCollapsed(ctx context.Context, userID, collapseKey string) error {
+	_, err := nr.notificationCollection.DeleteMany(ctx, bson.M{
+		"user_id":      userID,
+		"collapse_key": collapseKey,
+		"status":       "unread",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete collapsed notifications: %w", err)
+	}
+
+	return nil
+}
+
+// GetAnnouncementStats counts the notifications of a circle announcement,
+// and how many of them were delivered and read
+func (nr *NotificationRepository) GetAnnouncementStats(ctx context.Context, announcementID string) (*models.AnnouncementStats, error) {
+	pipeline := []bson.M{
+		{"$match": bson.M{"metadata.announcement_id": announcementID}},
+		{"$group": bson.M{
+			"_id":       nil,
+			"notified":  bson.M{"$sum": 1},
+			"delivered": bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$gt": bson.A{"$delivered_at", nil}}, 1, 0}}},
+			"read":      bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$gt": bson.A{"$read_at", nil}}, 1, 0}}},
+		}},
+	}
+
+	cursor, err := nr.notificationCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate announcement stats: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	stats := &models.AnnouncementStats{AnnouncementID: announcementID}
+	if cursor.Next(ctx) {
+		var result struct {
+			Notified  int64 `bson:"notified"`
+			Delivered int64 `bson:"delivered"`
+			Read      int64 `bson:"read"`
+		}
+		if err := cursor.Decode(&result); err != nil {
+			return nil, fmt.Errorf("failed to decode announcement stats: %w", err)
+		}
+		stats.Notified = result.Notified
+		stats.Delivered = result.Delivered
+		stats.Read = result.Read
+	}
+
+	return stats, nil
+}
+
 // ========================
 // User Notification Queries
 // ========================
 
-func (nr *NotificationRepository) GetUserNotifications(ctx context.Context, userID string, page, pageSize int, notificationType, status string) ([]models.Notification, int64, error) {
-	filter := bson.M{"user_id": userID, "is_archived": bson.M{"$ne": true}}
+// inboxFilter matches the user's notifications in the inbox, narrowed by
+// the request's filters
+func inboxFilter(req models.GetNotificationsRequest) bson.M {
+	filter := bson.M{"user_id": req.UserID, "is_archived": bson.M{"$ne": true}}
 
-	if notificationType != "" {
-		filter["type"] = notificationType
+	if req.Type != "" {
+		filter["type"] = req.Type
 	}
-	if status != "" {
-		filter["status"] = status
+	if req.Status != "" {
+		filter["status"] = req.Status
+	}
+	if req.CircleID != "" {
+		filter["circle_id"] = req.CircleID
+	}
+	if req.Priority != "" {
+		filter["priority"] = req.Priority
 	}
+	return filter
+}
+
+// inboxGroupKey is the field an inbox is grouped by. Notifications outside
+// circles group under an empty key.
+func inboxGroupKey(groupBy string) (string, bson.M) {
+	field := "circle_id"
+	if groupBy == models.NotificationGroupByType {
+		field = "type"
+	}
+	return field, bson.M{"$ifNull": bson.A{"$" + field, ""}}
+}
+
+func (nr *NotificationRepository) GetUserNotifications(ctx context.Context, req models.GetNotificationsRequest) ([]models.Notification, int64, error) {
+	filter := inboxFilter(req)
+	page, pageSize := req.Page, req.PageSize
 
 	// Count total documents
 	total, err := nr.notificationCollection.CountDocuments(ctx, filter)
@@ -155,6 +312,106 @@ func (nr *NotificationRepository) GetUserNotifications(ctx context.Context, user
 	return notifications, total, nil
 }
 
+// GetGroupedNotifications returns a page of the inbox's groups, most
+// recently active first, and the totals of the filtered inbox, in one
+// aggregation. Only the groups on the page look up their notifications.
+func (nr *NotificationRepository) GetGroupedNotifications(ctx context.Context, req models.GetNotificationsRequest) (*models.GroupedNotifications, error) {
+	filter := inboxFilter(req)
+	_, key := inboxGroupKey(req.GroupBy)
+	isUnread := bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$status", "unread"}}, 1, 0}}
+
+	pipeline := []bson.M{
+		{"$match": filter},
+		{"$facet": bson.M{
+			"groups": []bson.M{
+				{"$group": bson.M{
+					"_id":       key,
+					"total":     bson.M{"$sum": 1},
+					"unread":    bson.M{"$sum": isUnread},
+					"latest_at": bson.M{"$max": "$created_at"},
+				}},
+				{"$sort": bson.D{{Key: "latest_at", Value: -1}, {Key: "_id", Value: 1}}},
+				{"$skip": int64((req.Page - 1) * req.PageSize)},
+				{"$limit": int64(req.PageSize)},
+				{"$lookup": bson.M{
+					"from": "notifications",
+					"let":  bson.M{"key": "$_id"},
+					"pipeline": []bson.M{
+						{"$match": filter},
+						{"$match": bson.M{"$expr": bson.M{"$eq": bson.A{key, "$$key"}}}},
+						{"$sort": bson.M{"created_at": -1}},
+						{"$limit": models.NotificationGroupPreviewSize},
+					},
+					"as": "notifications",
+				}},
+			},
+			"totals": []bson.M{
+				{"$group": bson.M{
+					"_id":    nil,
+					"total":  bson.M{"$sum": 1},
+					"unread": bson.M{"$sum": isUnread},
+					"keys":   bson.M{"$addToSet": key},
+				}},
+				{"$project": bson.M{"total": 1, "unread": 1, "groups": bson.M{"$size": "$keys"}}},
+			},
+		}},
+	}
+
+	cursor, err := nr.notificationCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to group notifications: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		Groups []models.NotificationGroup `bson:"groups"`
+		Totals []struct {
+			Total  int64 `bson:"total"`
+			Unread int64 `bson:"unread"`
+			Groups int64 `bson:"groups"`
+		} `bson:"totals"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, fmt.Errorf("failed to decode notification groups: %w", err)
+	}
+
+	grouped := &models.GroupedNotifications{
+		GroupBy: req.GroupBy,
+		Groups:  []models.NotificationGroup{},
+	}
+	if len(results) > 0 {
+		grouped.Groups = append(grouped.Groups, results[0].Groups...)
+		if len(results[0].Totals) > 0 {
+			grouped.Total = results[0].Totals[0].Total
+			grouped.Unread = results[0].Totals[0].Unread
+			grouped.TotalGroups = results[0].Totals[0].Groups
+		}
+	}
+	return grouped, nil
+}
+
+// MarkGroupRead marks the unread notifications of one inbox group read
+func (nr *NotificationRepository) MarkGroupRead(ctx context.Context, userID string, req models.MarkGroupReadRequest) (int64, error) {
+	filter := inboxFilter(models.GetNotificationsRequest{UserID: userID, Status: "unread", Priority: req.Priority})
+	field, _ := inboxGroupKey(req.GroupBy)
+	if req.Key == "" {
+		filter["$or"] = bson.A{bson.M{field: nil}, bson.M{field: ""}}
+	} else {
+		filter[field] = req.Key
+	}
+
+	now := time.Now()
+	result, err := nr.notificationCollection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{
+		"status":     "read",
+		"read_at":    now,
+		"updated_at": now,
+	}})
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark notification group read: %w", err)
+	}
+	return result.ModifiedCount, nil
+}
+
 func (nr *NotificationRepository) GetNotificationsByPriority(ctx context.Context, userID, priority string, page, pageSize int) ([]models.Notification, int64, error) {
 	filter := bson.M{
 		"user_id":     userID,
@@ -457,6 +714,41 @@ func (nr *NotificationRepository) UpdateSMSSettings(ctx context.Context, setting
 
 	return nil
 }
+
+// GetVerifiedSMSSettingsByPhone returns the settings of the user who
+// verified the phone number
+func (nr *NotificationRepository) GetVerifiedSMSSettingsByPhone(ctx context.Context, phoneNumber string) (*models.SMSSettings, error) {
+	var settings models.SMSSettings
+	err := nr.smsSettingsCollection.FindOne(ctx, bson.M{"phone_number": phoneNumber, "is_verified": true}).Decode(&settings)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("not found")
+		}
+		return nil, fmt.Errorf("failed to get SMS settings: %w", err)
+	}
+
+	return &settings, nil
+}
+
+// SetSMSCommandsEnabled opts the user in or out of SMS commands
+func (nr *NotificationRepository) SetSMSCommandsEnabled(ctx context.Context, userID string, enabled bool) error {
+	now := time.Now()
+	set := bson.M{"commands_enabled": enabled, "updated_at": now}
+	update := bson.M{"$set": set}
+	if enabled {
+		set["commands_opted_in_at"] = now
+	} else {
+		update["$unset"] = bson.M{"commands_opted_in_at": ""}
+	}
+
+	_, err := nr.smsSettingsCollection.UpdateOne(ctx, bson.M{"user_id": userID}, update)
+	if err != nil {
+		return fmt.Errorf("failed to update SMS settings: %w", err)
+	}
+
+	return nil
+}
+
 func (nr *NotificationRepository) GetDeviceByToken(ctx context.Context, deviceToken string) (*models.PushDevice, error) {
 	var device models.PushDevice
 	err := nr.pushDeviceCollection.FindOne(ctx, bson.M{"device_token": deviceToken}).Decode(&device)
@@ -690,156 +982,866 @@ func (nr *NotificationRepository) UpdateEmailTemplate(ctx context.Context, templ
 }
 
 // ========================
-// Index Creation
+// Do Not Disturb
 // ========================
 
-func (nr *NotificationRepository) CreateIndexes(ctx context.Context) error {
-	indexes := []mongo.IndexModel{
-		// Notification indexes
-		{
-			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
-		},
-		{
-			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "status", Value: 1}},
-		},
-		{
-			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "type", Value: 1}},
-		},
-		{
-			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "circle_id", Value: 1}},
-		},
-		{
-			Keys:    bson.D{{Key: "expires_at", Value: 1}},
-			Options: options.Index().SetExpireAfterSeconds(0),
-		},
+func (nr *NotificationRepository) GetDNDStatus(ctx context.Context, userID string) (*models.DoNotDisturbStatus, error) {
+	var status models.DoNotDisturbStatus
+	err := nr.dndCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&status)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("not found")
+		}
+		return nil, fmt.Errorf("failed to get do not disturb status: %w", err)
 	}
 
-	_, err := nr.notificationCollection.Indexes().CreateMany(ctx, indexes)
+	return &status, nil
+}
+
+func (nr *NotificationRepository) UpsertDNDStatus(ctx context.Context, status *models.DoNotDisturbStatus) error {
+	status.UpdatedAt = time.Now()
+
+	filter := bson.M{"user_id": status.UserID}
+	_, err := nr.dndCollection.ReplaceOne(ctx, filter, status, options.Replace().SetUpsert(true))
 	if err != nil {
-		return fmt.Errorf("failed to create notification indexes: %w", err)
+		return fmt.Errorf("failed to save do not disturb status: %w", err)
 	}
 
-	// Push settings indexes
-	pushSettingsIndexes := []mongo.IndexModel{
-		{
-			Keys:    bson.D{{Key: "user_id", Value: 1}},
-			Options: options.Index().SetUnique(true),
-		},
+	return nil
+}
+
+// ========================
+// Notification Rules
+// ========================
+
+func (nr *NotificationRepository) CreateRule(ctx context.Context, rule *models.NotificationRule) error {
+	rule.ID = primitive.NewObjectID()
+	rule.CreatedAt = time.Now()
+	rule.UpdatedAt = time.Now()
+
+	_, err := nr.rulesCollection.InsertOne(ctx, rule)
+	if err != nil {
+		return fmt.Errorf("failed to create notification rule: %w", err)
 	}
 
-	_, err = nr.pushSettingsCollection.Indexes().CreateMany(ctx, pushSettingsIndexes)
+	return nil
+}
+
+func (nr *NotificationRepository) GetRule(ctx context.Context, ruleID string) (*models.NotificationRule, error) {
+	objectID, err := primitive.ObjectIDFromHex(ruleID)
 	if err != nil {
-		return fmt.Errorf("failed to create push settings indexes: %w", err)
+		return nil, errors.New("invalid rule ID")
 	}
 
-	// Push device indexes
-	pushDeviceIndexes := []mongo.IndexModel{
-		{
-			Keys: bson.D{{Key: "user_id", Value: 1}},
-		},
-		{
-			Keys:    bson.D{{Key: "device_token", Value: 1}},
-			Options: options.Index().SetUnique(true),
-		},
+	var rule models.NotificationRule
+	err = nr.rulesCollection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&rule)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("rule not found")
+		}
+		return nil, fmt.Errorf("failed to get rule: %w", err)
 	}
 
-	_, err = nr.pushDeviceCollection.Indexes().CreateMany(ctx, pushDeviceIndexes)
+	return &rule, nil
+}
+
+// GetUserRules returns the user's rules, highest priority first
+func (nr *NotificationRepository) GetUserRules(ctx context.Context, userID string) ([]models.NotificationRule, error) {
+	return nr.findRules(ctx, bson.M{"user_id": userID})
+}
+
+// GetActiveRoutingRules returns the user's active rules that route
+// notifications, highest priority first
+func (nr *NotificationRepository) GetActiveRoutingRules(ctx context.Context, userID string) ([]models.NotificationRule, error) {
+	return nr.findRules(ctx, bson.M{
+		"user_id":   userID,
+		"is_active": true,
+		"routing":   bson.M{"$exists": true},
+	})
+}
+
+func (nr *NotificationRepository) findRules(ctx context.Context, filter bson.M) ([]models.NotificationRule, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "priority", Value: -1}, {Key: "created_at", Value: 1}})
+
+	cursor, err := nr.rulesCollection.Find(ctx, filter, opts)
 	if err != nil {
-		return fmt.Errorf("failed to create push device indexes: %w", err)
+		return nil, fmt.Errorf("failed to find notification rules: %w", err)
 	}
+	defer cursor.Close(ctx)
 
-	// Preferences indexes
-	preferencesIndexes := []mongo.IndexModel{
-		{
-			Keys:    bson.D{{Key: "user_id", Value: 1}},
-			Options: options.Index().SetUnique(true),
-		},
+	rules := []models.NotificationRule{}
+	if err = cursor.All(ctx, &rules); err != nil {
+		return nil, fmt.Errorf("failed to decode notification rules: %w", err)
 	}
 
-	_, err = nr.preferencesCollection.Indexes().CreateMany(ctx, preferencesIndexes)
+	return rules, nil
+}
+
+func (nr *NotificationRepository) CountUserRules(ctx context.Context, userID string) (int64, error) {
+	return nr.rulesCollection.CountDocuments(ctx, bson.M{"user_id": userID})
+}
+
+func (nr *NotificationRepository) UpdateRule(ctx context.Context, rule *models.NotificationRule) error {
+	rule.UpdatedAt = time.Now()
+
+	filter := bson.M{"_id": rule.ID}
+	update := bson.M{"$set": rule}
+	if rule.Routing == nil {
+		update["$unset"] = bson.M{"routing": ""}
+	}
+
+	_, err := nr.rulesCollection.UpdateOne(ctx, filter, update)
 	if err != nil {
-		return fmt.Errorf("failed to create preferences indexes: %w", err)
+		return fmt.Errorf("failed to update notification rule: %w", err)
 	}
 
-	// Email settings indexes
-	emailSettingsIndexes := []mongo.IndexModel{
-		{
-			Keys:    bson.D{{Key: "user_id", Value: 1}},
-			Options: options.Index().SetUnique(true),
-		},
-		{
-			Keys: bson.D{{Key: "email_address", Value: 1}},
-		},
+	return nil
+}
+
+func (nr *NotificationRepository) DeleteRule(ctx context.Context, ruleID string) error {
+	objectID, err := primitive.ObjectIDFromHex(ruleID)
+	if err != nil {
+		return errors.New("invalid rule ID")
 	}
 
-	_, err = nr.emailSettingsCollection.Indexes().CreateMany(ctx, emailSettingsIndexes)
+	_, err = nr.rulesCollection.DeleteOne(ctx, bson.M{"_id": objectID})
 	if err != nil {
-		return fmt.Errorf("failed to create email settings indexes: %w", err)
+		return fmt.Errorf("failed to delete notification rule: %w", err)
 	}
 
 	return nil
 }
 
 // ========================
-// Cleanup Methods
+// Notification Broadcasts
 // ========================
 
-func (nr *NotificationRepository) CleanupExpiredNotifications(ctx context.Context) error {
-	filter := bson.M{
-		"expires_at": bson.M{"$lt": time.Now()},
-	}
+func (nr *NotificationRepository) CreateBroadcast(ctx context.Context, broadcast *models.NotificationBroadcast) error {
+	broadcast.ID = primitive.NewObjectID()
+	broadcast.CreatedAt = time.Now()
+	broadcast.UpdatedAt = time.Now()
 
-	_, err := nr.notificationCollection.DeleteMany(ctx, filter)
+	_, err := nr.broadcastsCollection.InsertOne(ctx, broadcast)
 	if err != nil {
-		return fmt.Errorf("failed to cleanup expired notifications: %w", err)
+		return fmt.Errorf("failed to create broadcast: %w", err)
 	}
 
 	return nil
 }
 
-func (nr *NotificationRepository) CleanupOldNotifications(ctx context.Context, userID string, olderThan time.Time) (int64, error) {
-	filter := bson.M{
-		"created_at": bson.M{"$lt": olderThan},
+func (nr *NotificationRepository) GetBroadcast(ctx context.Context, broadcastID string) (*models.NotificationBroadcast, error) {
+	objectID, err := primitive.ObjectIDFromHex(broadcastID)
+	if err != nil {
+		return nil, errors.New("invalid broadcast ID")
 	}
 
-	if userID != "" {
-		filter["user_id"] = userID
+	var broadcast models.NotificationBroadcast
+	err = nr.broadcastsCollection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&broadcast)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("broadcast not found")
+		}
+		return nil, fmt.Errorf("failed to get broadcast: %w", err)
 	}
 
-	result, err := nr.notificationCollection.DeleteMany(ctx, filter)
+	return &broadcast, nil
+}
+
+// ClaimDueBroadcast marks a broadcast that is due, or whose instance stopped
+// sending it before staleBefore, as running and returns it. It returns nil
+// when there is none.
+func (nr *NotificationRepository) ClaimDueBroadcast(ctx context.Context, staleBefore time.Time) (*models.NotificationBroadcast, error) {
+	now := time.Now()
+	filter := bson.M{
+		"$or": []bson.M{
+			{"status": models.BroadcastStatusScheduled, "scheduled_at": bson.M{"$lte": now}},
+			{"status": models.BroadcastStatusRunning, "heartbeat_at": bson.M{"$lt": staleBefore}},
+		},
+	}
+	update := bson.M{"$set": bson.M{
+		"status":       models.BroadcastStatusRunning,
+		"heartbeat_at": now,
+		"updated_at":   now,
+	}}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.M{"scheduled_at": 1}).
+		SetReturnDocument(options.After)
+
+	var broadcast models.NotificationBroadcast
+	err := nr.broadcastsCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&broadcast)
 	if err != nil {
-		return 0, fmt.Errorf("failed to cleanup old notifications: %w", err)
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to claim broadcast: %w", err)
 	}
 
-	return result.DeletedCount, nil
+	return &broadcast, nil
 }
 
-// ========================
-// Analytics Methods
-// ========================
+// UpdateRunningBroadcast sets fields of a broadcast that is still running.
+// It returns false if the broadcast was cancelled meanwhile.
+func (nr *NotificationRepository) UpdateRunningBroadcast(ctx context.Context, broadcastID primitive.ObjectID, fields bson.M) (bool, error) {
+	fields["heartbeat_at"] = time.Now()
+	fields["updated_at"] = time.Now()
 
-func (nr *NotificationRepository) GetNotificationStatsByDateRange(ctx context.Context, userID string, startDate, endDate time.Time) (*models.NotificationStats, error) {
-	filter := bson.M{
-		"created_at": bson.M{
-			"$gte": startDate,
-			"$lte": endDate,
-		},
+	filter := bson.M{"_id": broadcastID, "status": models.BroadcastStatusRunning}
+	result, err := nr.broadcastsCollection.UpdateOne(ctx, filter, bson.M{"$set": fields})
+	if err != nil {
+		return false, fmt.Errorf("failed to update broadcast: %w", err)
 	}
 
-	if userID != "" {
-		filter["user_id"] = userID
+	return result.MatchedCount > 0, nil
+}
+
+// CancelBroadcast stops a broadcast that hasn't finished. It returns false
+// if the broadcast had already finished.
+func (nr *NotificationRepository) CancelBroadcast(ctx context.Context, broadcastID string) (bool, error) {
+	objectID, err := primitive.ObjectIDFromHex(broadcastID)
+	if err != nil {
+		return false, errors.New("invalid broadcast ID")
 	}
 
-	// Count total notifications
-	total, err := nr.notificationCollection.CountDocuments(ctx, filter)
+	now := time.Now()
+	filter := bson.M{
+		"_id":    objectID,
+		"status": bson.M{"$in": []string{models.BroadcastStatusScheduled, models.BroadcastStatusRunning}},
+	}
+	update := bson.M{"$set": bson.M{
+		"status":       models.BroadcastStatusCancelled,
+		"completed_at": now,
+		"updated_at":   now,
+	}}
+
+	result, err := nr.broadcastsCollection.UpdateOne(ctx, filter, update)
 	if err != nil {
-		return nil, fmt.Errorf("failed to count total notifications: %w", err)
+		return false, fmt.Errorf("failed to cancel broadcast: %w", err)
 	}
 
-	// Count by status
-	sentFilter := filter
-	sentFilter["status"] = bson.M{"$in": []string{"read", "unread"}}
-	sent, _ := nr.notificationCollection.CountDocuments(ctx, sentFilter)
+	return result.MatchedCount > 0, nil
+}
+
+// RecordBroadcastDelivery stores the outcome for a user, adds it to the
+// broadcast's stats and keeps the broadcast marked alive. Outcomes are recorded once per user, so a resumed
+// broadcast doesn't count users twice.
+func (nr *NotificationRepository) RecordBroadcastDelivery(ctx context.Context, delivery *models.BroadcastDelivery, stats bson.M) error {
+	delivery.ID = primitive.NewObjectID()
+	delivery.CreatedAt = time.Now()
+
+	if _, err := nr.broadcastDeliveries.InsertOne(ctx, delivery); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to record broadcast delivery: %w", err)
+	}
+
+	update := bson.M{
+		"$inc": stats,
+		"$set": bson.M{"heartbeat_at": time.Now()},
+	}
+	_, err := nr.broadcastsCollection.UpdateOne(ctx, bson.M{"_id": delivery.BroadcastID}, update)
+	if err != nil {
+		return fmt.Errorf("failed to update broadcast stats: %w", err)
+	}
+
+	return nil
+}
+
+// GetBroadcastRecipientsDone returns which of the users already have an
+// outcome for the broadcast
+func (nr *NotificationRepository) GetBroadcastRecipientsDone(ctx context.Context, broadcastID primitive.ObjectID, userIDs []string) (map[string]bool, error) {
+	filter := bson.M{
+		"broadcast_id": broadcastID,
+		"user_id":      bson.M{"$in": userIDs},
+	}
+	opts := options.Find().SetProjection(bson.M{"user_id": 1})
+
+	cursor, err := nr.broadcastDeliveries.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find broadcast deliveries: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var deliveries []models.BroadcastDelivery
+	if err = cursor.All(ctx, &deliveries); err != nil {
+		return nil, fmt.Errorf("failed to decode broadcast deliveries: %w", err)
+	}
+
+	done := make(map[string]bool, len(deliveries))
+	for _, delivery := range deliveries {
+		done[delivery.UserID] = true
+	}
+	return done, nil
+}
+
+func (nr *NotificationRepository) GetBroadcastDeliveries(ctx context.Context, broadcastID primitive.ObjectID, status string, page, pageSize int) ([]models.BroadcastDelivery, int64, error) {
+	filter := bson.M{"broadcast_id": broadcastID}
+	if status != "" {
+		filter["status"] = status
+	}
+
+	total, err := nr.broadcastDeliveries.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count broadcast deliveries: %w", err)
+	}
+
+	skip := (page - 1) * pageSize
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "user_id", Value: 1}}).
+		SetSkip(int64(skip)).
+		SetLimit(int64(pageSize))
+
+	cursor, err := nr.broadcastDeliveries.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to find broadcast deliveries: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	deliveries := []models.BroadcastDelivery{}
+	if err = cursor.All(ctx, &deliveries); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode broadcast deliveries: %w", err)
+	}
+
+	return deliveries, total, nil
+}
+
+// ========================
+// Notification Experiments
+// ========================
+
+func (nr *NotificationRepository) CreateExperiment(ctx context.Context, experiment *models.NotificationExperiment) error {
+	experiment.ID = primitive.NewObjectID()
+	experiment.CreatedAt = time.Now()
+	experiment.UpdatedAt = time.Now()
+
+	_, err := nr.experimentsCollection.InsertOne(ctx, experiment)
+	if err != nil {
+		return fmt.Errorf("failed to create experiment: %w", err)
+	}
+
+	return nil
+}
+
+func (nr *NotificationRepository) GetExperiment(ctx context.Context, experimentID string) (*models.NotificationExperiment, error) {
+	objectID, err := primitive.ObjectIDFromHex(experimentID)
+	if err != nil {
+		return nil, errors.New("invalid experiment ID")
+	}
+
+	var experiment models.NotificationExperiment
+	err = nr.experimentsCollection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&experiment)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("experiment not found")
+		}
+		return nil, fmt.Errorf("failed to get experiment: %w", err)
+	}
+
+	return &experiment, nil
+}
+
+// GetExperiments returns the experiments, newest first
+func (nr *NotificationRepository) GetExperiments(ctx context.Context) ([]models.NotificationExperiment, error) {
+	opts := options.Find().SetSort(bson.M{"created_at": -1})
+	cursor, err := nr.experimentsCollection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find experiments: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	experiments := []models.NotificationExperiment{}
+	if err = cursor.All(ctx, &experiments); err != nil {
+		return nil, fmt.Errorf("failed to decode experiments: %w", err)
+	}
+	return experiments, nil
+}
+
+// HasOverlappingExperiment reports whether an experiment on the template
+// that hasn't ended overlaps the period
+func (nr *NotificationRepository) HasOverlappingExperiment(ctx context.Context, template string, startsAt, endsAt time.Time) (bool, error) {
+	count, err := nr.experimentsCollection.CountDocuments(ctx, bson.M{
+		"template":  template,
+		"status":    bson.M{"$ne": models.ExperimentStatusEnded},
+		"starts_at": bson.M{"$lt": endsAt},
+		"ends_at":   bson.M{"$gt": startsAt},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to count experiments: %w", err)
+	}
+	return count > 0, nil
+}
+
+// GetRunningExperiment returns the experiment running on the template, or
+// nil when there is none
+func (nr *NotificationRepository) GetRunningExperiment(ctx context.Context, template string) (*models.NotificationExperiment, error) {
+	var experiment models.NotificationExperiment
+	err := nr.experimentsCollection.FindOne(ctx, bson.M{
+		"template": template,
+		"status":   models.ExperimentStatusRunning,
+	}).Decode(&experiment)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get running experiment: %w", err)
+	}
+
+	return &experiment, nil
+}
+
+// GetRunningExperiments returns the experiments running on any of the
+// templates
+func (nr *NotificationRepository) GetRunningExperiments(ctx context.Context, templates []string) ([]models.NotificationExperiment, error) {
+	cursor, err := nr.experimentsCollection.Find(ctx, bson.M{
+		"template": bson.M{"$in": templates},
+		"status":   models.ExperimentStatusRunning,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find running experiments: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	experiments := []models.NotificationExperiment{}
+	if err = cursor.All(ctx, &experiments); err != nil {
+		return nil, fmt.Errorf("failed to decode experiments: %w", err)
+	}
+	return experiments, nil
+}
+
+// StartDueExperiments starts the scheduled experiments whose start has
+// come, and returns how many it started
+func (nr *NotificationRepository) StartDueExperiments(ctx context.Context, now time.Time) (int64, error) {
+	result, err := nr.experimentsCollection.UpdateMany(ctx,
+		bson.M{"status": models.ExperimentStatusScheduled, "starts_at": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"status": models.ExperimentStatusRunning, "updated_at": now}},
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to start experiments: %w", err)
+	}
+	return result.ModifiedCount, nil
+}
+
+// GetExpiredExperiments returns the experiments that haven't ended but are
+// past their end
+func (nr *NotificationRepository) GetExpiredExperiments(ctx context.Context, now time.Time) ([]models.NotificationExperiment, error) {
+	cursor, err := nr.experimentsCollection.Find(ctx, bson.M{
+		"status":  bson.M{"$ne": models.ExperimentStatusEnded},
+		"ends_at": bson.M{"$lte": now},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find expired experiments: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	experiments := []models.NotificationExperiment{}
+	if err = cursor.All(ctx, &experiments); err != nil {
+		return nil, fmt.Errorf("failed to decode experiments: %w", err)
+	}
+	return experiments, nil
+}
+
+// EndExperiment marks an experiment ended with its winner. It returns
+// false if the experiment had already ended.
+func (nr *NotificationRepository) EndExperiment(ctx context.Context, experimentID primitive.ObjectID, winner string) (bool, error) {
+	now := time.Now()
+	set := bson.M{
+		"status":     models.ExperimentStatusEnded,
+		"ended_at":   now,
+		"updated_at": now,
+	}
+	if winner != "" {
+		set["winner_variant"] = winner
+	}
+
+	result, err := nr.experimentsCollection.UpdateOne(ctx,
+		bson.M{"_id": experimentID, "status": bson.M{"$ne": models.ExperimentStatusEnded}},
+		bson.M{"$set": set},
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to end experiment: %w", err)
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// StopExperimentVariant stops a variant that is still running. It returns
+// false if it was already stopped.
+func (nr *NotificationRepository) StopExperimentVariant(ctx context.Context, experimentID primitive.ObjectID, variantID, reason string) (bool, error) {
+	now := time.Now()
+	filter := bson.M{
+		"_id":    experimentID,
+		"status": models.ExperimentStatusRunning,
+		"variants": bson.M{"$elemMatch": bson.M{
+			"id":         variantID,
+			"stopped_at": bson.M{"$exists": false},
+		}},
+	}
+	update := bson.M{"$set": bson.M{
+		"variants.$.stopped_at":  now,
+		"variants.$.stop_reason": reason,
+		"updated_at":             now,
+	}}
+
+	result, err := nr.experimentsCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, fmt.Errorf("failed to stop experiment variant: %w", err)
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// GetExperimentVariantOf returns the variant of the experiment the user got
+// notifications from, or "" when they got none
+func (nr *NotificationRepository) GetExperimentVariantOf(ctx context.Context, userID string, experimentID primitive.ObjectID) (string, error) {
+	var notification models.Notification
+	opts := options.FindOne().SetProjection(bson.M{"experiment_variant": 1})
+	err := nr.notificationCollection.FindOne(ctx, bson.M{
+		"user_id":       userID,
+		"experiment_id": experimentID.Hex(),
+		"delivered_at":  bson.M{"$exists": true},
+	}, opts).Decode(&notification)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to find experiment notification: %w", err)
+	}
+
+	return notification.ExperimentVariant, nil
+}
+
+// RecordExperimentOptOut counts a user turning off an experiment's
+// notifications. It returns false if they were already counted.
+func (nr *NotificationRepository) RecordExperimentOptOut(ctx context.Context, optOut *models.NotificationExperimentOptOut) (bool, error) {
+	optOut.CreatedAt = time.Now()
+
+	if _, err := nr.experimentOptOuts.InsertOne(ctx, optOut); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to record experiment opt-out: %w", err)
+	}
+	return true, nil
+}
+
+// GetExperimentCounts returns the delivered, opened and acted on
+// notifications of each variant of the experiment
+func (nr *NotificationRepository) GetExperimentCounts(ctx context.Context, experimentID primitive.ObjectID) (map[string]models.ExperimentVariantCounts, error) {
+	pipeline := []bson.M{
+		{"$match": bson.M{
+			"experiment_id": experimentID.Hex(),
+			"delivered_at":  bson.M{"$exists": true},
+		}},
+		{"$group": bson.M{
+			"_id":          "$experiment_variant",
+			"delivered":    bson.M{"$sum": 1},
+			"opened":       bson.M{"$sum": bson.M{"$cond": []interface{}{bson.M{"$gt": []interface{}{"$read_at", nil}}, 1, 0}}},
+			"action_taken": bson.M{"$sum": bson.M{"$cond": []interface{}{bson.M{"$gt": []interface{}{"$acted_at", nil}}, 1, 0}}},
+		}},
+	}
+
+	cursor, err := nr.notificationCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count experiment notifications: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var results []models.ExperimentVariantCounts
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, fmt.Errorf("failed to decode experiment counts: %w", err)
+	}
+
+	counts := make(map[string]models.ExperimentVariantCounts, len(results))
+	for _, result := range results {
+		counts[result.Variant] = result
+	}
+	return counts, nil
+}
+
+// GetExperimentOptOutCounts returns how many users of each variant of the
+// experiment turned its notifications off
+func (nr *NotificationRepository) GetExperimentOptOutCounts(ctx context.Context, experimentID primitive.ObjectID) (map[string]int64, error) {
+	pipeline := []bson.M{
+		{"$match": bson.M{"experiment_id": experimentID}},
+		{"$group": bson.M{"_id": "$variant", "count": bson.M{"$sum": 1}}},
+	}
+
+	cursor, err := nr.experimentOptOuts.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count experiment opt-outs: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		Variant string `bson:"_id"`
+		Count   int64  `bson:"count"`
+	}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, fmt.Errorf("failed to decode experiment opt-outs: %w", err)
+	}
+
+	counts := make(map[string]int64, len(results))
+	for _, result := range results {
+		counts[result.Variant] = result.Count
+	}
+	return counts, nil
+}
+
+// MarkActedOn records the first action the user took on a notification
+func (nr *NotificationRepository) MarkActedOn(ctx context.Context, notificationID primitive.ObjectID) error {
+	now := time.Now()
+	_, err := nr.notificationCollection.UpdateOne(ctx,
+		bson.M{"_id": notificationID, "acted_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"acted_at": now, "updated_at": now}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to mark notification acted on: %w", err)
+	}
+	return nil
+}
+
+// GetSystemTemplate returns the operators' text for a system template, or
+// nil when it has the built in one
+func (nr *NotificationRepository) GetSystemTemplate(ctx context.Context, key string) (*models.NotificationTemplate, error) {
+	var template models.NotificationTemplate
+	err := nr.templatesCollection.FindOne(ctx, bson.M{"is_system": true, "type": key}).Decode(&template)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get system template: %w", err)
+	}
+
+	return &template, nil
+}
+
+// SetSystemTemplate replaces the text of a system template
+func (nr *NotificationRepository) SetSystemTemplate(ctx context.Context, key, content, updatedBy string) error {
+	now := time.Now()
+	_, err := nr.templatesCollection.UpdateOne(ctx,
+		bson.M{"is_system": true, "type": key},
+		bson.M{
+			"$set": bson.M{
+				"content":    content,
+				"user_id":    updatedBy,
+				"updated_at": now,
+			},
+			"$setOnInsert": bson.M{
+				"name":       key,
+				"category":   "system",
+				"variables":  models.PlaceNotificationVariables,
+				"is_default": true,
+				"created_at": now,
+			},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set system template: %w", err)
+	}
+	return nil
+}
+
+// ========================
+// Notification Channels
+// ========================
+
+// CreateDeliveryDecision stores the decision that governed a notification's
+// delivery. The caller sets its ID, which the notification links to.
+func (nr *NotificationRepository) CreateDeliveryDecision(ctx context.Context, decision *models.DeliveryDecision) error {
+	decision.CreatedAt = time.Now()
+
+	if _, err := nr.decisionsCollection.InsertOne(ctx, decision); err != nil {
+		return fmt.Errorf("failed to create delivery decision: %w", err)
+	}
+	return nil
+}
+
+func (nr *NotificationRepository) GetDeliveryDecision(ctx context.Context, decisionID string) (*models.DeliveryDecision, error) {
+	objectID, err := primitive.ObjectIDFromHex(decisionID)
+	if err != nil {
+		return nil, errors.New("decision not found")
+	}
+
+	var decision models.DeliveryDecision
+	err = nr.decisionsCollection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&decision)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("decision not found")
+		}
+		return nil, fmt.Errorf("failed to get delivery decision: %w", err)
+	}
+
+	return &decision, nil
+}
+
+func (nr *NotificationRepository) GetChannel(ctx context.Context, channelID string) (*models.NotificationChannel, error) {
+	objectID, err := primitive.ObjectIDFromHex(channelID)
+	if err != nil {
+		return nil, errors.New("invalid channel ID")
+	}
+
+	var channel models.NotificationChannel
+	err = nr.channelsCollection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&channel)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("channel not found")
+		}
+		return nil, fmt.Errorf("failed to get channel: %w", err)
+	}
+
+	return &channel, nil
+}
+
+// ========================
+// Index Creation
+// ========================
+
+func (nr *NotificationRepository) CreateIndexes(ctx context.Context) error {
+	indexes := []mongo.IndexModel{
+		// Notification indexes
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "status", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "type", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "circle_id", Value: 1}},
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+
+	_, err := nr.notificationCollection.Indexes().CreateMany(ctx, indexes)
+	if err != nil {
+		return fmt.Errorf("failed to create notification indexes: %w", err)
+	}
+
+	// Push settings indexes
+	pushSettingsIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	}
+
+	_, err = nr.pushSettingsCollection.Indexes().CreateMany(ctx, pushSettingsIndexes)
+	if err != nil {
+		return fmt.Errorf("failed to create push settings indexes: %w", err)
+	}
+
+	// Push device indexes
+	pushDeviceIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}},
+		},
+		{
+			Keys:    bson.D{{Key: "device_token", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	}
+
+	_, err = nr.pushDeviceCollection.Indexes().CreateMany(ctx, pushDeviceIndexes)
+	if err != nil {
+		return fmt.Errorf("failed to create push device indexes: %w", err)
+	}
+
+	// Preferences indexes
+	preferencesIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	}
+
+	_, err = nr.preferencesCollection.Indexes().CreateMany(ctx, preferencesIndexes)
+	if err != nil {
+		return fmt.Errorf("failed to create preferences indexes: %w", err)
+	}
+
+	// Email settings indexes
+	emailSettingsIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "email_address", Value: 1}},
+		},
+	}
+
+	_, err = nr.emailSettingsCollection.Indexes().CreateMany(ctx, emailSettingsIndexes)
+	if err != nil {
+		return fmt.Errorf("failed to create email settings indexes: %w", err)
+	}
+
+	return nil
+}
+
+// ========================
+// Cleanup Methods
+// ========================
+
+func (nr *NotificationRepository) CleanupExpiredNotifications(ctx context.Context) error {
+	filter := bson.M{
+		"expires_at": bson.M{"$lt": time.Now()},
+	}
+
+	_, err := nr.notificationCollection.DeleteMany(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("failed to cleanup expired notifications: %w", err)
+	}
+
+	return nil
+}
+
+func (nr *NotificationRepository) CleanupOldNotifications(ctx context.Context, userID string, olderThan time.Time) (int64, error) {
+	filter := bson.M{
+		"created_at": bson.M{"$lt": olderThan},
+	}
+
+	if userID != "" {
+		filter["user_id"] = userID
+	}
+
+	result, err := nr.notificationCollection.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, fmt.Errorf("failed to cleanup old notifications: %w", err)
+	}
+
+	return result.DeletedCount, nil
+}
+
+// ========================
+// Analytics Methods
+// ========================
+
+func (nr *NotificationRepository) GetNotificationStatsByDateRange(ctx context.Context, userID string, startDate, endDate time.Time) (*models.NotificationStats, error) {
+	filter := bson.M{
+		"created_at": bson.M{
+			"$gte": startDate,
+			"$lte": endDate,
+		},
+	}
+
+	if userID != "" {
+		filter["user_id"] = userID
+	}
+
+	// Count total notifications
+	total, err := nr.notificationCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count total notifications: %w", err)
+	}
+
+	// Count by status
+	sentFilter := filter
+	sentFilter["status"] = bson.M{"$in": []string{"read", "unread"}}
+	sent, _ := nr.notificationCollection.CountDocuments(ctx, sentFilter)
 
 	deliveredFilter := filter
 	deliveredFilter["status"] = bson.M{"$in": []string{"read", "unread"}}
@@ -859,9 +1861,149 @@ func (nr *NotificationRepository) GetNotificationStatsByDateRange(ctx context.Co
 		ByChannel: make(map[string]int64),
 	}
 
+	// Deferrals and snoozes, counted apart
+	countHeld := func(field string) int64 {
+		heldFilter := bson.M{
+			"created_at": bson.M{"$gte": startDate, "$lte": endDate},
+			field:        bson.M{"$exists": true},
+		}
+		if userID != "" {
+			heldFilter["user_id"] = userID
+		}
+		count, _ := nr.notificationCollection.CountDocuments(ctx, heldFilter)
+		return count
+	}
+	stats.Deferred = countHeld("deferred_until")
+	stats.Snoozed = countHeld("snoozed_until")
+
 	return stats, nil
 }
 
+// GetHourlyReadCounts counts the user's notification reads since the time
+// by hour of the day in the location, from midnight
+func (nr *NotificationRepository) GetHourlyReadCounts(ctx context.Context, userID string, since time.Time, location *time.Location) ([]int64, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"user_id": userID,
+			"read_at": bson.M{"$gte": since},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"$hour": bson.M{"date": "$read_at", "timezone": location.String()}},
+			"count": bson.M{"$sum": 1},
+		}}},
+	}
+
+	cursor, err := nr.notificationCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count notification reads: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		Hour  int   `bson:"_id"`
+		Count int64 `bson:"count"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, fmt.Errorf("failed to decode notification reads: %w", err)
+	}
+
+	counts := make([]int64, 24)
+	for _, result := range results {
+		if result.Hour >= 0 && result.Hour < 24 {
+			counts[result.Hour] = result.Count
+		}
+	}
+	return counts, nil
+}
+
+// ClaimDueDeferredNotification marks a deferred notification whose time has
+// come as delivered and returns it, so only one instance sends it. It
+// returns nil when there is none.
+func (nr *NotificationRepository) ClaimDueDeferredNotification(ctx context.Context) (*models.Notification, error) {
+	now := time.Now()
+	filter := bson.M{
+		"deferred_until": bson.M{"$lte": now},
+		"delivered_at":   bson.M{"$exists": false},
+	}
+	update := bson.M{"$set": bson.M{
+		"delivered_at": now,
+		"updated_at":   now,
+	}}
+	// Oldest first, so a batch starts with its first notification
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "deferred_until", Value: 1}, {Key: "created_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var notification models.Notification
+	err := nr.notificationCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&notification)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to claim deferred notification: %w", err)
+	}
+
+	return &notification, nil
+}
+
+// GetOpenBatchUntil returns when the user's open batch of notifications of
+// the type goes out, or nil when there is none to join
+func (nr *NotificationRepository) GetOpenBatchUntil(ctx context.Context, userID, notificationType string, at time.Time) (*time.Time, error) {
+	filter := bson.M{
+		"user_id":        userID,
+		"type":           notificationType,
+		"batched":        true,
+		"deferred_until": bson.M{"$gt": at},
+		"delivered_at":   bson.M{"$exists": false},
+	}
+	opts := options.FindOne().
+		SetSort(bson.M{"deferred_until": 1}).
+		SetProjection(bson.M{"deferred_until": 1})
+
+	var notification models.Notification
+	err := nr.notificationCollection.FindOne(ctx, filter, opts).Decode(&notification)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get open notification batch: %w", err)
+	}
+
+	return notification.DeferredUntil, nil
+}
+
+// ClaimBatchedNotification marks the oldest undelivered notification in the
+// same batch as first as delivered and returns it. It returns nil once the
+// whole batch is claimed.
+func (nr *NotificationRepository) ClaimBatchedNotification(ctx context.Context, first *models.Notification) (*models.Notification, error) {
+	now := time.Now()
+	filter := bson.M{
+		"user_id":        first.UserID,
+		"type":           first.Type,
+		"batched":        true,
+		"deferred_until": first.DeferredUntil,
+		"delivered_at":   bson.M{"$exists": false},
+	}
+	update := bson.M{"$set": bson.M{
+		"delivered_at": now,
+		"updated_at":   now,
+	}}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.M{"created_at": 1}).
+		SetReturnDocument(options.After)
+
+	var notification models.Notification
+	err := nr.notificationCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&notification)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to claim batched notification: %w", err)
+	}
+
+	return &notification, nil
+}
+
 // ========================
 // Search and Advanced Queries
 // ========================
@@ -900,6 +2042,70 @@ func (nr *NotificationRepository) SearchNotifications(ctx context.Context, userI
 	return notifications, total, nil
 }
 
+// SearchNotificationsText searches the user's notifications with the text
+// index on title and message, newest first
+func (nr *NotificationRepository) SearchNotificationsText(ctx context.Context, req models.SearchNotificationsRequest) ([]models.Notification, int64, error) {
+	filter := bson.M{
+		"user_id": req.UserID,
+		"$text":   bson.M{"$search": req.Query},
+	}
+
+	if req.Type != "" {
+		filter["type"] = req.Type
+	}
+	switch req.Status {
+	case "read", "unread":
+		filter["status"] = req.Status
+	case "archived":
+		filter["is_archived"] = true
+	}
+	if req.ExcludeArchived {
+		filter["is_archived"] = bson.M{"$ne": true}
+	}
+	if req.ExcludeSnoozed {
+		filter["$or"] = []bson.M{
+			{"snoozed_until": bson.M{"$exists": false}},
+			{"snoozed_until": nil},
+			{"snoozed_until": bson.M{"$lte": time.Now()}},
+		}
+	}
+
+	createdAt := bson.M{}
+	if req.From != nil {
+		createdAt["$gte"] = *req.From
+	}
+	if req.To != nil {
+		createdAt["$lte"] = *req.To
+	}
+	if len(createdAt) > 0 {
+		filter["created_at"] = createdAt
+	}
+
+	total, err := nr.notificationCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count search results: %w", err)
+	}
+
+	skip := (req.Page - 1) * req.PageSize
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(int64(skip)).
+		SetLimit(int64(req.PageSize))
+
+	cursor, err := nr.notificationCollection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to search notifications: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	notifications := []models.Notification{}
+	if err = cursor.All(ctx, &notifications); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode search results: %w", err)
+	}
+
+	return notifications, total, nil
+}
+
 func (nr *NotificationRepository) GetNotificationsByDateRange(ctx context.Context, userID string, startDate, endDate time.Time, page, pageSize int) ([]models.Notification, int64, error) {
 	filter := bson.M{
 		"user_id": userID,
@@ -0,0 +1,112 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"ftrack/database"
+	"ftrack/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type OutboxRepository struct {
+	collection *database.Collection
+}
+
+func NewOutboxRepository(db *mongo.Database) *OutboxRepository {
+	return &OutboxRepository{
+		collection: database.NewCollection(db, "outbox_events"),
+	}
+}
+
+// Enqueue stores a pending event. It returns false when an event with the
+// same idempotency key is already stored, which is left as it is.
+func (or *OutboxRepository) Enqueue(ctx context.Context, event *models.OutboxEvent) (bool, error) {
+	now := time.Now()
+	event.ID = primitive.NewObjectID()
+	event.Status = models.OutboxStatusPending
+	event.AvailableAt = now
+	event.CreatedAt = now
+
+	_, err := or.collection.InsertOne(ctx, event)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Claim leases a pending event for delivery. It returns nil when the event
+// was delivered or is leased by someone else.
+func (or *OutboxRepository) Claim(ctx context.Context, eventID primitive.ObjectID, lease time.Duration) (*models.OutboxEvent, error) {
+	return or.claim(ctx, bson.M{"_id": eventID}, lease)
+}
+
+// ClaimNext leases the pending event that has been available the longest.
+// It returns nil when nothing is due.
+func (or *OutboxRepository) ClaimNext(ctx context.Context, lease time.Duration) (*models.OutboxEvent, error) {
+	return or.claim(ctx, bson.M{}, lease)
+}
+
+func (or *OutboxRepository) claim(ctx context.Context, filter bson.M, lease time.Duration) (*models.OutboxEvent, error) {
+	now := time.Now()
+	filter["status"] = models.OutboxStatusPending
+	filter["availableAt"] = bson.M{"$lte": now}
+
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "availableAt", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var event models.OutboxEvent
+	err := or.collection.FindOneAndUpdate(ctx, filter, bson.M{
+		"$set": bson.M{"availableAt": now.Add(lease)},
+		"$inc": bson.M{"attempts": 1},
+	}, opts).Decode(&event)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &event, nil
+}
+
+func (or *OutboxRepository) MarkDispatched(ctx context.Context, eventID primitive.ObjectID) error {
+	_, err := or.collection.UpdateOne(ctx,
+		bson.M{"_id": eventID},
+		bson.M{
+			"$set":   bson.M{"status": models.OutboxStatusDispatched, "dispatchedAt": time.Now()},
+			"$unset": bson.M{"lastError": ""},
+		},
+	)
+	return err
+}
+
+// MarkRetry hands a failed attempt back for another try at retryAt
+func (or *OutboxRepository) MarkRetry(ctx context.Context, eventID primitive.ObjectID, retryAt time.Time, reason string) error {
+	_, err := or.collection.UpdateOne(ctx,
+		bson.M{"_id": eventID},
+		bson.M{"$set": bson.M{"availableAt": retryAt, "lastError": reason}},
+	)
+	return err
+}
+
+// MarkFailed gives up on an event
+func (or *OutboxRepository) MarkFailed(ctx context.Context, eventID primitive.ObjectID, reason string) error {
+	_, err := or.collection.UpdateOne(ctx,
+		bson.M{"_id": eventID},
+		bson.M{"$set": bson.M{"status": models.OutboxStatusFailed, "lastError": reason}},
+	)
+	return err
+}
+
+func (or *OutboxRepository) CountPending(ctx context.Context) (int64, error) {
+	return or.collection.CountDocuments(ctx, bson.M{"status": models.OutboxStatusPending})
+}
@@ -0,0 +1,227 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"ftrack/database"
+	"ftrack/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type PlaceListRepository struct {
+	collection *database.Collection
+}
+
+func NewPlaceListRepository(db *mongo.Database) *PlaceListRepository {
+	return &PlaceListRepository{
+		collection: database.NewCollection(db, "place_lists"),
+	}
+}
+
+func (pr *PlaceListRepository) Create(ctx context.Context, list *models.PlaceList) error {
+	list.ID = primitive.NewObjectID()
+	list.CreatedAt = time.Now()
+	list.UpdatedAt = list.CreatedAt
+
+	_, err := pr.collection.InsertOne(ctx, list)
+	return err
+}
+
+func (pr *PlaceListRepository) GetByID(ctx context.Context, listID string) (*models.PlaceList, error) {
+	objectID, err := primitive.ObjectIDFromHex(listID)
+	if err != nil {
+		return nil, errors.New("invalid list ID")
+	}
+
+	var list models.PlaceList
+	err = pr.collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&list)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("list not found")
+		}
+		return nil, err
+	}
+	return &list, nil
+}
+
+// GetCircleLists returns the circle's lists, most recently changed first.
+// Archived lists are left out unless asked for.
+func (pr *PlaceListRepository) GetCircleLists(ctx context.Context, circleID primitive.ObjectID, placeID *primitive.ObjectID, includeArchived bool) ([]models.PlaceList, error) {
+	filter := bson.M{"circleId": circleID}
+	if placeID != nil {
+		filter["placeId"] = *placeID
+	}
+	if !includeArchived {
+		filter["status"] = bson.M{"$ne": models.PlaceListStatusArchived}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "updatedAt", Value: -1}})
+	cursor, err := pr.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	lists := []models.PlaceList{}
+	err = cursor.All(ctx, &lists)
+	return lists, err
+}
+
+func (pr *PlaceListRepository) CountUnarchived(ctx context.Context, circleID primitive.ObjectID) (int64, error) {
+	return pr.collection.CountDocuments(ctx, bson.M{
+		"circleId": circleID,
+		"status":   bson.M{"$ne": models.PlaceListStatusArchived},
+	})
+}
+
+// Update sets fields of the list and returns it as it is after
+func (pr *PlaceListRepository) Update(ctx context.Context, listID primitive.ObjectID, set, unset bson.M) (*models.PlaceList, error) {
+	if set == nil {
+		set = bson.M{}
+	}
+	set["updatedAt"] = time.Now()
+
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	return pr.findOneAndUpdate(ctx, bson.M{"_id": listID}, update)
+}
+
+func (pr *PlaceListRepository) Delete(ctx context.Context, listID primitive.ObjectID) error {
+	result, err := pr.collection.DeleteOne(ctx, bson.M{"_id": listID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errors.New("list not found")
+	}
+	return nil
+}
+
+// AddItems appends items to the list, up to models.MaxPlaceListItems, and
+// returns the list as it is after. New items reopen a completed or
+// archived list.
+func (pr *PlaceListRepository) AddItems(ctx context.Context, listID primitive.ObjectID, items []models.PlaceListItem) (*models.PlaceList, error) {
+	if len(items) > models.MaxPlaceListItems {
+		return nil, errors.New("list is full")
+	}
+
+	// The list has room if the item that would go over the limit isn't there
+	filter := bson.M{
+		"_id": listID,
+		fmt.Sprintf("items.%d", models.MaxPlaceListItems-len(items)): bson.M{"$exists": false},
+	}
+
+	update := bson.M{
+		"$push":  bson.M{"items": bson.M{"$each": items}},
+		"$set":   bson.M{"status": models.PlaceListStatusActive, "updatedAt": time.Now()},
+		"$unset": bson.M{"completedAt": "", "archivedAt": ""},
+	}
+
+	list, err := pr.findOneAndUpdate(ctx, filter, update)
+	if err != nil && err.Error() == "list not found" {
+		// Tell a full list from a missing one
+		if _, getErr := pr.GetByID(ctx, listID.Hex()); getErr == nil {
+			return nil, errors.New("list is full")
+		}
+	}
+	return list, err
+}
+
+// UpdateItem sets fields of an item and returns the list as it is after
+func (pr *PlaceListRepository) UpdateItem(ctx context.Context, listID, itemID primitive.ObjectID, set, unset bson.M) (*models.PlaceList, error) {
+	itemSet := bson.M{"updatedAt": time.Now()}
+	for field, value := range set {
+		itemSet["items.$."+field] = value
+	}
+	update := bson.M{"$set": itemSet}
+	if len(unset) > 0 {
+		itemUnset := bson.M{}
+		for field := range unset {
+			itemUnset["items.$."+field] = ""
+		}
+		update["$unset"] = itemUnset
+	}
+
+	list, err := pr.findOneAndUpdate(ctx, bson.M{"_id": listID, "items._id": itemID}, update)
+	if err != nil && err.Error() == "list not found" {
+		return nil, errors.New("item not found")
+	}
+	return list, err
+}
+
+// RemoveItem takes an item off the list and returns the list as it is after
+func (pr *PlaceListRepository) RemoveItem(ctx context.Context, listID, itemID primitive.ObjectID) (*models.PlaceList, error) {
+	update := bson.M{
+		"$pull": bson.M{"items": bson.M{"_id": itemID}},
+		"$set":  bson.M{"updatedAt": time.Now()},
+	}
+
+	list, err := pr.findOneAndUpdate(ctx, bson.M{"_id": listID, "items._id": itemID}, update)
+	if err != nil && err.Error() == "list not found" {
+		return nil, errors.New("item not found")
+	}
+	return list, err
+}
+
+// GetPendingForPlace returns the active lists of the circles linked to the
+// place that still have items to get
+func (pr *PlaceListRepository) GetPendingForPlace(ctx context.Context, placeID primitive.ObjectID, circleIDs []primitive.ObjectID) ([]models.PlaceList, error) {
+	cursor, err := pr.collection.Find(ctx, bson.M{
+		"placeId":       placeID,
+		"circleId":      bson.M{"$in": circleIDs},
+		"status":        models.PlaceListStatusActive,
+		"items.checked": false,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	lists := []models.PlaceList{}
+	err = cursor.All(ctx, &lists)
+	return lists, err
+}
+
+// ArchiveCompletedBefore archives the lists completed before the cutoff and
+// returns how many it archived
+func (pr *PlaceListRepository) ArchiveCompletedBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	now := time.Now()
+	result, err := pr.collection.UpdateMany(ctx,
+		bson.M{
+			"status":      models.PlaceListStatusCompleted,
+			"completedAt": bson.M{"$lt": cutoff},
+		},
+		bson.M{"$set": bson.M{
+			"status":     models.PlaceListStatusArchived,
+			"archivedAt": now,
+			"updatedAt":  now,
+		}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+func (pr *PlaceListRepository) findOneAndUpdate(ctx context.Context, filter, update bson.M) (*models.PlaceList, error) {
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var list models.PlaceList
+	err := pr.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&list)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("list not found")
+		}
+		return nil, err
+	}
+	return &list, nil
+}
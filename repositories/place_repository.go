@@ -3,9 +3,11 @@ package repositories
 import (
 	"context"
 	"errors"
+	"ftrack/database"
 	"ftrack/models"
 	"math"
-	"strings"
+	"regexp"
+	"strconv"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -15,26 +17,38 @@ import (
 )
 
 type PlaceRepository struct {
-	collection           *mongo.Collection
-	categoryCollection   *mongo.Collection
-	visitCollection      *mongo.Collection
-	reviewCollection     *mongo.Collection
-	checkinCollection    *mongo.Collection
-	collectionCollection *mongo.Collection
-	automationCollection *mongo.Collection
-	templateCollection   *mongo.Collection
+	collection           *database.Collection
+	categoryCollection   *database.Collection
+	visitCollection      *database.Collection
+	reviewCollection     *database.Collection
+	reviewReportCol      *database.Collection
+	checkinCollection    *database.Collection
+	collectionCollection *database.Collection
+	automationCollection *database.Collection
+	templateCollection   *database.Collection
+	versionCollection    *database.Collection
+	holidaySetCollection *database.Collection
+	aliasCollection      *database.Collection
+	favoriteCollection   *database.Collection
+	suggestionCollection *database.Collection
 }
 
 func NewPlaceRepository(db *mongo.Database) *PlaceRepository {
 	return &PlaceRepository{
-		collection:           db.Collection("places"),
-		categoryCollection:   db.Collection("place_categories"),
-		visitCollection:      db.Collection("place_visits"),
-		reviewCollection:     db.Collection("place_reviews"),
-		checkinCollection:    db.Collection("place_checkins"),
-		collectionCollection: db.Collection("place_collections"),
-		automationCollection: db.Collection("automation_rules"),
-		templateCollection:   db.Collection("place_templates"),
+		collection:           database.NewCollection(db, "places"),
+		categoryCollection:   database.NewCollection(db, "place_categories"),
+		visitCollection:      database.NewCollection(db, "place_visits"),
+		reviewCollection:     database.NewCollection(db, "place_reviews"),
+		reviewReportCol:      database.NewCollection(db, "place_review_reports"),
+		checkinCollection:    database.NewCollection(db, "place_checkins"),
+		collectionCollection: database.NewCollection(db, "place_collections"),
+		automationCollection: database.NewCollection(db, "automation_rules"),
+		templateCollection:   database.NewCollection(db, "place_templates"),
+		versionCollection:    database.NewCollection(db, "place_versions"),
+		holidaySetCollection: database.NewCollection(db, "place_holiday_sets"),
+		aliasCollection:      database.NewCollection(db, "place_aliases"),
+		favoriteCollection:   database.NewCollection(db, "place_favorites"),
+		suggestionCollection: database.NewCollection(db, "place_suggestions"),
 	}
 }
 
@@ -85,6 +99,23 @@ func (pr *PlaceRepository) GetByID(ctx context.Context, placeID string) (*models
 	return &place, nil
 }
 
+// GetByIDs returns the places with the given ids, skipping missing ones
+func (pr *PlaceRepository) GetByIDs(ctx context.Context, placeIDs []primitive.ObjectID) ([]models.Place, error) {
+	if len(placeIDs) == 0 {
+		return []models.Place{}, nil
+	}
+
+	cursor, err := pr.collection.Find(ctx, bson.M{"_id": bson.M{"$in": placeIDs}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var places []models.Place
+	err = cursor.All(ctx, &places)
+	return places, err
+}
+
 func (pr *PlaceRepository) Update(ctx context.Context, placeID string, updates map[string]interface{}) error {
 	objectID, err := primitive.ObjectIDFromHex(placeID)
 	if err != nil {
@@ -117,9 +148,304 @@ func (pr *PlaceRepository) Delete(ctx context.Context, placeID string) error {
 		return errors.New("place not found")
 	}
 
+	// The history goes with the place
+	_, err = pr.versionCollection.DeleteMany(ctx, bson.M{"placeId": objectID})
+	return err
+}
+
+// GetAccessiblePlaceNames returns the active places a user can see: their
+// own, those shared with their circles and those shared with them directly.
+// Only the fields needed for suggestions and duplicate checks are loaded.
+func (pr *PlaceRepository) GetAccessiblePlaceNames(ctx context.Context, userID string, circleIDs []primitive.ObjectID) ([]models.Place, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	access := []bson.M{
+		{"userId": userObjectID},
+		{"sharing.sharedWith.userId": userObjectID},
+	}
+	if len(circleIDs) > 0 {
+		access = append(access, bson.M{
+			"circleId": bson.M{"$in": circleIDs},
+			"isShared": true,
+		})
+	}
+
+	filter := bson.M{
+		"isActive": true,
+		"$or":      access,
+	}
+
+	opts := options.Find().SetProjection(bson.M{
+		"userId":    1,
+		"circleId":  1,
+		"name":      1,
+		"address":   1,
+		"category":  1,
+		"icon":      1,
+		"latitude":  1,
+		"longitude": 1,
+		"radius":    1,
+	})
+
+	cursor, err := pr.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var places []models.Place
+	err = cursor.All(ctx, &places)
+	return places, err
+}
+
+// GetCirclePlaces returns the active places that belong to a circle
+func (pr *PlaceRepository) GetCirclePlaces(ctx context.Context, circleID string) ([]models.Place, error) {
+	circleObjectID, err := primitive.ObjectIDFromHex(circleID)
+	if err != nil {
+		return nil, errors.New("invalid circle ID")
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	cursor, err := pr.collection.Find(ctx, bson.M{"circleId": circleObjectID, "isActive": true}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var places []models.Place
+	err = cursor.All(ctx, &places)
+	return places, err
+}
+
+// InheritCircleDefaults makes the circle's places inherit the settings from
+// the circle's geofence defaults. No place IDs means every place of the
+// circle. It returns the number of places changed.
+func (pr *PlaceRepository) InheritCircleDefaults(ctx context.Context, circleID primitive.ObjectID, placeIDs []primitive.ObjectID, settings []string) (int64, error) {
+	filter := bson.M{"circleId": circleID}
+	if len(placeIDs) > 0 {
+		filter["_id"] = bson.M{"$in": placeIDs}
+	}
+
+	update := bson.M{
+		"$addToSet": bson.M{"inheritedSettings": bson.M{"$each": settings}},
+		"$set":      bson.M{"updatedAt": time.Now()},
+	}
+
+	result, err := pr.collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// GetCirclePlaceMarkers returns the markers of the circle's active places
+// inside the bounds. Bounds whose southwest longitude is east of the
+// northeast one cross the antimeridian.
+func (pr *PlaceRepository) GetCirclePlaceMarkers(ctx context.Context, circleID string, bounds models.GeoBounds) ([]models.PlaceMarker, error) {
+	circleObjectID, err := primitive.ObjectIDFromHex(circleID)
+	if err != nil {
+		return nil, errors.New("invalid circle ID")
+	}
+
+	filter := bson.M{
+		"circleId": circleObjectID,
+		"isActive": true,
+		"latitude": bson.M{"$gte": bounds.Southwest.Latitude, "$lte": bounds.Northeast.Latitude},
+	}
+	if bounds.Southwest.Longitude <= bounds.Northeast.Longitude {
+		filter["longitude"] = bson.M{"$gte": bounds.Southwest.Longitude, "$lte": bounds.Northeast.Longitude}
+	} else {
+		filter["$or"] = []bson.M{
+			{"longitude": bson.M{"$gte": bounds.Southwest.Longitude}},
+			{"longitude": bson.M{"$lte": bounds.Northeast.Longitude}},
+		}
+	}
+
+	opts := options.Find().SetProjection(bson.M{
+		"name":      1,
+		"category":  1,
+		"icon":      1,
+		"color":     1,
+		"latitude":  1,
+		"longitude": 1,
+	})
+
+	cursor, err := pr.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	markers := []models.PlaceMarker{}
+	err = cursor.All(ctx, &markers)
+	return markers, err
+}
+
+// MergePlaces moves the visits, check-ins and automation rules of the
+// duplicates to the canonical place, then deletes the duplicates. Moving
+// comes first, so a failed merge can be retried without losing anything.
+func (pr *PlaceRepository) MergePlaces(ctx context.Context, canonicalID primitive.ObjectID, duplicateIDs []primitive.ObjectID) (*models.MergePlacesResult, error) {
+	result := &models.MergePlacesResult{CanonicalPlaceID: canonicalID.Hex()}
+	inDuplicates := bson.M{"$in": duplicateIDs}
+
+	visits, err := pr.visitCollection.UpdateMany(ctx,
+		bson.M{"placeId": inDuplicates},
+		bson.M{"$set": bson.M{"placeId": canonicalID}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	result.VisitsMoved = visits.ModifiedCount
+
+	checkins, err := pr.checkinCollection.UpdateMany(ctx,
+		bson.M{"placeId": inDuplicates},
+		bson.M{"$set": bson.M{"placeId": canonicalID}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	result.CheckinsMoved = checkins.ModifiedCount
+
+	for _, field := range []string{"conditions", "actions"} {
+		opts := options.Update().SetArrayFilters(options.ArrayFilters{
+			Filters: []interface{}{bson.M{"item.placeId": inDuplicates}},
+		})
+		rules, err := pr.automationCollection.UpdateMany(ctx,
+			bson.M{field + ".placeId": inDuplicates},
+			bson.M{
+				"$set": bson.M{
+					field + ".$[item].placeId": canonicalID,
+					"updatedAt":                time.Now(),
+				},
+			},
+			opts,
+		)
+		if err != nil {
+			return nil, err
+		}
+		result.RulesMoved += rules.ModifiedCount
+	}
+
+	if err := pr.moveMergedPlaceAliases(ctx, canonicalID, inDuplicates); err != nil {
+		return nil, err
+	}
+	if err := pr.moveMergedPlaceFavorites(ctx, canonicalID, inDuplicates); err != nil {
+		return nil, err
+	}
+
+	if _, err := pr.collection.DeleteMany(ctx, bson.M{"_id": inDuplicates}); err != nil {
+		return nil, err
+	}
+	for _, id := range duplicateIDs {
+		result.MergedPlaceIDs = append(result.MergedPlaceIDs, id.Hex())
+	}
+
+	pr.updatePlaceStatsAfterVisit(ctx, canonicalID.Hex())
+	pr.updatePlaceCheckinStats(ctx, canonicalID.Hex())
+
+	return result, nil
+}
+
+// moveMergedPlaceAliases moves members' labels of the duplicates to the
+// canonical place. A member who already labelled the canonical place keeps
+// that label.
+func (pr *PlaceRepository) moveMergedPlaceAliases(ctx context.Context, canonicalID primitive.ObjectID, inDuplicates bson.M) error {
+	cursor, err := pr.aliasCollection.Find(ctx, bson.M{"placeId": inDuplicates})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var labels []models.PlaceAlias
+	if err := cursor.All(ctx, &labels); err != nil {
+		return err
+	}
+
+	for _, label := range labels {
+		_, err := pr.aliasCollection.UpdateOne(ctx,
+			bson.M{"_id": label.ID},
+			bson.M{"$set": bson.M{"placeId": canonicalID, "updatedAt": time.Now()}},
+		)
+		if mongo.IsDuplicateKeyError(err) {
+			_, err = pr.aliasCollection.DeleteOne(ctx, bson.M{"_id": label.ID})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveMergedPlaceFavorites makes members who favorited a duplicate favor
+// the canonical place instead
+func (pr *PlaceRepository) moveMergedPlaceFavorites(ctx context.Context, canonicalID primitive.ObjectID, inDuplicates bson.M) error {
+	cursor, err := pr.favoriteCollection.Find(ctx, bson.M{"placeId": inDuplicates})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var favorites []models.PlaceFavorite
+	if err := cursor.All(ctx, &favorites); err != nil {
+		return err
+	}
+
+	for _, favorite := range favorites {
+		_, err := pr.favoriteCollection.UpdateOne(ctx,
+			bson.M{"_id": favorite.ID},
+			bson.M{"$set": bson.M{"placeId": canonicalID}},
+		)
+		if mongo.IsDuplicateKeyError(err) {
+			_, err = pr.favoriteCollection.DeleteOne(ctx, bson.M{"_id": favorite.ID})
+		}
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// MoveCirclePlaces moves the places of a circle that are still in it to
+// another circle
+func (pr *PlaceRepository) MoveCirclePlaces(ctx context.Context, fromCircleID, toCircleID primitive.ObjectID) (int64, error) {
+	result, err := pr.collection.UpdateMany(ctx,
+		bson.M{"circleId": fromCircleID},
+		bson.M{"$set": bson.M{"circleId": toCircleID, "updatedAt": time.Now()}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// ReplaceCollectionPlace points the collections holding a merged-away place
+// at the place it was merged into. It returns the number of collections
+// changed.
+func (pr *PlaceRepository) ReplaceCollectionPlace(ctx context.Context, duplicateID, canonicalID primitive.ObjectID) (int64, error) {
+	now := time.Now()
+	filter := bson.M{"placeIds": duplicateID}
+
+	// Add before pulling, so a retry still finds the collections
+	result, err := pr.collectionCollection.UpdateMany(ctx, filter, bson.M{
+		"$addToSet": bson.M{"placeIds": canonicalID},
+		"$set":      bson.M{"updatedAt": now},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := pr.collectionCollection.UpdateMany(ctx, filter, bson.M{
+		"$pull": bson.M{"placeIds": duplicateID},
+	}); err != nil {
+		return 0, err
+	}
+
+	return result.MatchedCount, nil
+}
+
 func (pr *PlaceRepository) SearchPlaces(ctx context.Context, req models.SearchPlacesRequest) ([]models.Place, int64, error) {
 	filter := bson.M{}
 
@@ -129,7 +455,7 @@ func (pr *PlaceRepository) SearchPlaces(ctx context.Context, req models.SearchPl
 			{"name": bson.M{"$regex": req.Query, "$options": "i"}},
 			{"description": bson.M{"$regex": req.Query, "$options": "i"}},
 			{"address": bson.M{"$regex": req.Query, "$options": "i"}},
-			{"tags": bson.M{"$in": []string{req.Query}}},
+			{"tags": models.NormalizePlaceTag(req.Query)},
 		}
 	}
 
@@ -151,9 +477,8 @@ func (pr *PlaceRepository) SearchPlaces(ctx context.Context, req models.SearchPl
 	}
 
 	// Tags filter
-	if req.Tags != "" {
-		tags := strings.Split(req.Tags, ",")
-		filter["tags"] = bson.M{"$in": tags}
+	if tags := models.SplitPlaceTags(req.Tags); len(tags) > 0 {
+		filter["tags"] = placeTagFilter(tags, req.TagMatch == "all")
 	}
 
 	// Only show public or accessible places
@@ -167,14 +492,7 @@ func (pr *PlaceRepository) SearchPlaces(ctx context.Context, req models.SearchPl
 		return nil, 0, err
 	}
 
-	page := req.Page
-	if page < 1 {
-		page = 1
-	}
-	pageSize := req.PageSize
-	if pageSize < 1 {
-		pageSize = 20
-	}
+	page, pageSize := models.NormalizePage(req.Page, req.PageSize)
 	skip := (page - 1) * pageSize
 
 	opts := options.Find().
@@ -264,7 +582,15 @@ func (pr *PlaceRepository) GetUserPlaces(ctx context.Context, userID string, req
 		filter["isActive"] = *req.IsActive
 	}
 	if req.IsFavorite != nil {
-		filter["isFavorite"] = *req.IsFavorite
+		favoriteIDs, err := pr.favoritePlaceIDs(ctx, userObjectID)
+		if err != nil {
+			return nil, 0, err
+		}
+		if *req.IsFavorite {
+			filter["_id"] = bson.M{"$in": favoriteIDs}
+		} else {
+			filter["_id"] = bson.M{"$nin": favoriteIDs}
+		}
 	}
 
 	// Geographic filter
@@ -278,12 +604,8 @@ func (pr *PlaceRepository) GetUserPlaces(ctx context.Context, userID string, req
 	}
 
 	// Tags filter
-	if req.Tags != "" {
-		tags := strings.Split(req.Tags, ",")
-		for i, tag := range tags {
-			tags[i] = strings.TrimSpace(tag)
-		}
-		filter["tags"] = bson.M{"$in": tags}
+	if tags := models.SplitPlaceTags(req.Tags); len(tags) > 0 {
+		filter["tags"] = placeTagFilter(tags, req.TagMatch == "all")
 	}
 
 	// Count total
@@ -293,14 +615,7 @@ func (pr *PlaceRepository) GetUserPlaces(ctx context.Context, userID string, req
 	}
 
 	// Setup pagination
-	page := req.Page
-	if page < 1 {
-		page = 1
-	}
-	pageSize := req.PageSize
-	if pageSize < 1 {
-		pageSize = 20
-	}
+	page, pageSize := models.NormalizePage(req.Page, req.PageSize)
 	skip := (page - 1) * pageSize
 
 	// Setup sorting
@@ -466,162 +781,1807 @@ func (pr *PlaceRepository) GetPlaceVisits(ctx context.Context, placeID string, p
 	return visits, total, err
 }
 
-func (pr *PlaceRepository) UpdateVisit(ctx context.Context, visitID string, updates map[string]interface{}) error {
-	objectID, err := primitive.ObjectIDFromHex(visitID)
+// PlaceVisitTotal sums a user's visits to one place
+type PlaceVisitTotal struct {
+	PlaceID   primitive.ObjectID `bson:"_id"`
+	Latitude  float64            `bson:"latitude"`
+	Longitude float64            `bson:"longitude"`
+	Visits    int                `bson:"visits"`
+	Duration  int64              `bson:"duration"` // seconds
+}
+
+// GetVisitTotalsByPlace sums the user's visits that started in the time
+// range per place, with the place coordinates
+func (pr *PlaceRepository) GetVisitTotalsByPlace(ctx context.Context, userID string, startDate, endDate time.Time) ([]PlaceVisitTotal, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
-		return errors.New("invalid visit ID")
+		return nil, errors.New("invalid user ID")
 	}
 
-	updates["updatedAt"] = time.Now()
+	pipeline := []bson.M{
+		{
+			"$match": bson.M{
+				"userId":      userObjectID,
+				"arrivalTime": bson.M{"$gte": startDate, "$lt": endDate},
+			},
+		},
+		{
+			"$group": bson.M{
+				"_id":      "$placeId",
+				"visits":   bson.M{"$sum": 1},
+				"duration": bson.M{"$sum": "$duration"},
+			},
+		},
+		{
+			"$lookup": bson.M{
+				"from":         "places",
+				"localField":   "_id",
+				"foreignField": "_id",
+				"as":           "place",
+			},
+		},
+		{"$unwind": "$place"},
+		{
+			"$project": bson.M{
+				"latitude":  "$place.latitude",
+				"longitude": "$place.longitude",
+				"visits":    1,
+				"duration":  1,
+			},
+		},
+	}
 
-	_, err = pr.visitCollection.UpdateOne(
-		ctx,
-		bson.M{"_id": objectID},
-		bson.M{"$set": updates},
-	)
+	cursor, err := pr.visitCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
 
-	return err
+	var totals []PlaceVisitTotal
+	err = cursor.All(ctx, &totals)
+	return totals, err
 }
 
-// ==================== REVIEW OPERATIONS ====================
+// PlaceActivity sums one user's visits and check-ins at a place, each
+// weighted by how recent it is
+type PlaceActivity struct {
+	PlaceID   primitive.ObjectID
+	UserID    primitive.ObjectID
+	Score     float64 // sum of the weights
+	MaxWeight float64 // weight of the latest event
+	Visits    int
+	Checkins  int
+	Recent    int // events since recentSince
+	Previous  int // events in the window of the same length before it
+}
 
-func (pr *PlaceRepository) CreateReview(ctx context.Context, review *models.PlaceReview) error {
-	review.ID = primitive.NewObjectID()
-	review.CreatedAt = time.Now()
-	review.UpdatedAt = time.Now()
+// GetPlaceActivity weighs the visits and the check-ins the viewer may see
+// at the places since the given time. An event's weight halves every
+// halfLife, starting from 1 at now.
+func (pr *PlaceRepository) GetPlaceActivity(ctx context.Context, placeIDs []primitive.ObjectID, viewerID primitive.ObjectID, mateIDs []primitive.ObjectID, since, recentSince, now time.Time, halfLife time.Duration) ([]PlaceActivity, error) {
+	visits, err := pr.aggregatePlaceActivity(ctx, pr.visitCollection, "arrivalTime", bson.M{}, placeIDs, since, recentSince, now, halfLife)
+	if err != nil {
+		return nil, err
+	}
 
-	_, err := pr.reviewCollection.InsertOne(ctx, review)
+	checkins, err := pr.aggregatePlaceActivity(ctx, pr.checkinCollection, "createdAt", checkinVisibilityFilter(viewerID, mateIDs), placeIDs, since, recentSince, now, halfLife)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Update place rating statistics
-	go pr.updatePlaceRatingStats(ctx, review.PlaceID.Hex())
+	type activityKey struct{ placeID, userID primitive.ObjectID }
+	merged := make(map[activityKey]*PlaceActivity)
+	for _, source := range []struct {
+		rows     []placeActivityRow
+		checkins bool
+	}{{visits, false}, {checkins, true}} {
+		for _, row := range source.rows {
+			key := activityKey{row.ID.PlaceID, row.ID.UserID}
+			entry, exists := merged[key]
+			if !exists {
+				entry = &PlaceActivity{PlaceID: row.ID.PlaceID, UserID: row.ID.UserID}
+				merged[key] = entry
+			}
+
+			entry.Score += row.Score
+			entry.MaxWeight = math.Max(entry.MaxWeight, row.MaxWeight)
+			entry.Recent += row.Recent
+			entry.Previous += row.Previous
+			if source.checkins {
+				entry.Checkins += row.Events
+			} else {
+				entry.Visits += row.Events
+			}
+		}
+	}
 
-	return nil
+	activity := make([]PlaceActivity, 0, len(merged))
+	for _, entry := range merged {
+		activity = append(activity, *entry)
+	}
+	return activity, nil
 }
 
-func (pr *PlaceRepository) GetPlaceReviews(ctx context.Context, placeID string, page, pageSize int) ([]models.PlaceReview, int64, error) {
-	placeObjectID, err := primitive.ObjectIDFromHex(placeID)
+type placeActivityRow struct {
+	ID struct {
+		PlaceID primitive.ObjectID `bson:"placeId"`
+		UserID  primitive.ObjectID `bson:"userId"`
+	} `bson:"_id"`
+	Score     float64 `bson:"score"`
+	MaxWeight float64 `bson:"maxWeight"`
+	Events    int     `bson:"events"`
+	Recent    int     `bson:"recent"`
+	Previous  int     `bson:"previous"`
+}
+
+func (pr *PlaceRepository) aggregatePlaceActivity(ctx context.Context, collection *database.Collection, timeField string, match bson.M, placeIDs []primitive.ObjectID, since, recentSince, now time.Time, halfLife time.Duration) ([]placeActivityRow, error) {
+	field := "$" + timeField
+	previousSince := recentSince.Add(-now.Sub(recentSince))
+
+	match["placeId"] = bson.M{"$in": placeIDs}
+	match[timeField] = bson.M{"$gte": since, "$lte": now}
+
+	// weight = e^(-ln2 * age / halfLife), with the age in milliseconds
+	decay := -math.Ln2 / float64(halfLife.Milliseconds())
+
+	cursor, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$addFields", Value: bson.M{
+			"weight": bson.M{"$exp": bson.M{"$multiply": bson.A{decay, bson.M{"$subtract": bson.A{now, field}}}}},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":       bson.M{"placeId": "$placeId", "userId": "$userId"},
+			"score":     bson.M{"$sum": "$weight"},
+			"maxWeight": bson.M{"$max": "$weight"},
+			"events":    bson.M{"$sum": 1},
+			"recent": bson.M{"$sum": bson.M{"$cond": bson.A{
+				bson.M{"$gte": bson.A{field, recentSince}}, 1, 0,
+			}}},
+			"previous": bson.M{"$sum": bson.M{"$cond": bson.A{
+				bson.M{"$and": bson.A{
+					bson.M{"$gte": bson.A{field, previousSince}},
+					bson.M{"$lt": bson.A{field, recentSince}},
+				}}, 1, 0,
+			}}},
+		}}},
+	})
 	if err != nil {
-		return nil, 0, errors.New("invalid place ID")
+		return nil, err
 	}
+	defer cursor.Close(ctx)
 
-	filter := bson.M{"placeId": placeObjectID, "isPublic": true}
+	var rows []placeActivityRow
+	err = cursor.All(ctx, &rows)
+	return rows, err
+}
 
-	total, err := pr.reviewCollection.CountDocuments(ctx, filter)
+// GetUserVisitsSince returns the user's visits that started after the given
+// time, in arrival order
+func (pr *PlaceRepository) GetUserVisitsSince(ctx context.Context, userID string, since time.Time) ([]models.PlaceVisit, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
-		return nil, 0, err
+		return nil, errors.New("invalid user ID")
 	}
 
-	skip := (page - 1) * pageSize
-	opts := options.Find().
-		SetSort(bson.D{{Key: "helpfulCount", Value: -1}, {Key: "createdAt", Value: -1}}).
-		SetSkip(int64(skip)).
-		SetLimit(int64(pageSize))
+	filter := bson.M{
+		"userId":      userObjectID,
+		"arrivalTime": bson.M{"$gte": since},
+	}
 
-	cursor, err := pr.reviewCollection.Find(ctx, filter, opts)
+	opts := options.Find().SetSort(bson.D{{Key: "arrivalTime", Value: 1}})
+	cursor, err := pr.visitCollection.Find(ctx, filter, opts)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 	defer cursor.Close(ctx)
 
-	var reviews []models.PlaceReview
-	err = cursor.All(ctx, &reviews)
-	return reviews, total, err
+	var visits []models.PlaceVisit
+	err = cursor.All(ctx, &visits)
+	return visits, err
 }
 
-// ==================== CHECKIN OPERATIONS ====================
-
-func (pr *PlaceRepository) CreateCheckin(ctx context.Context, checkin *models.PlaceCheckin) error {
-	checkin.ID = primitive.NewObjectID()
-	checkin.CreatedAt = time.Now()
-	checkin.UpdatedAt = time.Now()
+// GetOngoingUserVisits returns the open visits of the users that started
+// after the given time
+func (pr *PlaceRepository) GetOngoingUserVisits(ctx context.Context, userIDs []string, since time.Time) ([]models.PlaceVisit, error) {
+	objectIDs := make([]primitive.ObjectID, 0, len(userIDs))
+	for _, userID := range userIDs {
+		if objectID, err := primitive.ObjectIDFromHex(userID); err == nil {
+			objectIDs = append(objectIDs, objectID)
+		}
+	}
+	if len(objectIDs) == 0 {
+		return []models.PlaceVisit{}, nil
+	}
 
-	_, err := pr.checkinCollection.InsertOne(ctx, checkin)
+	cursor, err := pr.visitCollection.Find(ctx, bson.M{
+		"userId":      bson.M{"$in": objectIDs},
+		"isOngoing":   true,
+		"arrivalTime": bson.M{"$gte": since},
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer cursor.Close(ctx)
 
-	// Update place checkin statistics
-	go pr.updatePlaceCheckinStats(ctx, checkin.PlaceID.Hex())
-
-	return nil
+	var visits []models.PlaceVisit
+	err = cursor.All(ctx, &visits)
+	return visits, err
 }
 
-func (pr *PlaceRepository) GetPlaceCheckins(ctx context.Context, placeID string, page, pageSize int) ([]models.PlaceCheckin, int64, error) {
-	placeObjectID, err := primitive.ObjectIDFromHex(placeID)
-	if err != nil {
-		return nil, 0, errors.New("invalid place ID")
+// GetOngoingVisits pages through all open visits that started after the
+// given time, in id order
+func (pr *PlaceRepository) GetOngoingVisits(ctx context.Context, since time.Time, afterID primitive.ObjectID, limit int) ([]models.PlaceVisit, error) {
+	filter := bson.M{
+		"isOngoing":   true,
+		"arrivalTime": bson.M{"$gte": since},
+	}
+	if !afterID.IsZero() {
+		filter["_id"] = bson.M{"$gt": afterID}
 	}
 
-	filter := bson.M{"placeId": placeObjectID, "isPublic": true}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetLimit(int64(limit))
 
-	total, err := pr.checkinCollection.CountDocuments(ctx, filter)
+	cursor, err := pr.visitCollection.Find(ctx, filter, opts)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var visits []models.PlaceVisit
+	err = cursor.All(ctx, &visits)
+	return visits, err
+}
+
+// GetDeparturesBetween returns the user's visits to a place that ended in
+// the given range, oldest first
+func (pr *PlaceRepository) GetDeparturesBetween(ctx context.Context, userID, placeID primitive.ObjectID, from, to time.Time) ([]models.PlaceVisit, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "departureTime", Value: 1}})
+
+	cursor, err := pr.visitCollection.Find(ctx, bson.M{
+		"userId":        userID,
+		"placeId":       placeID,
+		"departureTime": bson.M{"$gt": from, "$lte": to},
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var visits []models.PlaceVisit
+	err = cursor.All(ctx, &visits)
+	return visits, err
+}
+
+func (pr *PlaceRepository) UpdateVisit(ctx context.Context, visitID string, updates map[string]interface{}) error {
+	objectID, err := primitive.ObjectIDFromHex(visitID)
+	if err != nil {
+		return errors.New("invalid visit ID")
+	}
+
+	updates["updatedAt"] = time.Now()
+
+	_, err = pr.visitCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": objectID},
+		bson.M{"$set": updates},
+	)
+
+	return err
+}
+
+// ==================== REVIEW OPERATIONS ====================
+
+func (pr *PlaceRepository) CreateReview(ctx context.Context, review *models.PlaceReview) error {
+	review.ID = primitive.NewObjectID()
+	review.CreatedAt = time.Now()
+	review.UpdatedAt = time.Now()
+	if review.ModerationStatus == "" {
+		review.ModerationStatus = models.ReviewModerationVisible
+	}
+
+	_, err := pr.reviewCollection.InsertOne(ctx, review)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return errors.New("already reviewed")
+		}
+		return err
+	}
+
+	// Update place rating statistics
+	go pr.updatePlaceRatingStats(ctx, review.PlaceID.Hex())
+
+	return nil
+}
+
+func (pr *PlaceRepository) GetPlaceReviews(ctx context.Context, placeID string, page, pageSize int) ([]models.PlaceReview, int64, error) {
+	placeObjectID, err := primitive.ObjectIDFromHex(placeID)
+	if err != nil {
+		return nil, 0, errors.New("invalid place ID")
+	}
+
+	filter := bson.M{
+		"placeId":          placeObjectID,
+		"isPublic":         true,
+		"moderationStatus": bson.M{"$ne": models.ReviewModerationHidden},
+	}
+
+	total, err := pr.reviewCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	skip := (page - 1) * pageSize
+	opts := options.Find().
+		SetSort(bson.D{{Key: "helpfulCount", Value: -1}, {Key: "createdAt", Value: -1}}).
+		SetSkip(int64(skip)).
+		SetLimit(int64(pageSize))
+
+	cursor, err := pr.reviewCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var reviews []models.PlaceReview
+	err = cursor.All(ctx, &reviews)
+	return reviews, total, err
+}
+
+// HasReviewed reports whether the user reviewed the place, whatever the
+// review's moderation state
+func (pr *PlaceRepository) HasReviewed(ctx context.Context, userID, placeID primitive.ObjectID) (bool, error) {
+	count, err := pr.reviewCollection.CountDocuments(ctx, bson.M{
+		"placeId": placeID,
+		"userId":  userID,
+	}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// HasVisited reports whether the user has a recorded visit or check-in at
+// the place
+func (pr *PlaceRepository) HasVisited(ctx context.Context, userID, placeID primitive.ObjectID) (bool, error) {
+	filter := bson.M{"placeId": placeID, "userId": userID}
+
+	visits, err := pr.visitCollection.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	if visits > 0 {
+		return true, nil
+	}
+
+	checkins, err := pr.checkinCollection.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return checkins > 0, nil
+}
+
+func (pr *PlaceRepository) GetReviewByID(ctx context.Context, reviewID string) (*models.PlaceReview, error) {
+	objectID, err := primitive.ObjectIDFromHex(reviewID)
+	if err != nil {
+		return nil, errors.New("invalid review ID")
+	}
+
+	var review models.PlaceReview
+	err = pr.reviewCollection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&review)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("review not found")
+		}
+		return nil, err
+	}
+
+	return &review, nil
+}
+
+// SetReviewModeration hides or restores a review and refreshes the place's
+// rating
+func (pr *PlaceRepository) SetReviewModeration(ctx context.Context, review *models.PlaceReview, status, note string, moderatedBy primitive.ObjectID) error {
+	now := time.Now()
+	_, err := pr.reviewCollection.UpdateOne(ctx, bson.M{"_id": review.ID}, bson.M{
+		"$set": bson.M{
+			"moderationStatus": status,
+			"moderationNote":   note,
+			"moderatedBy":      moderatedBy,
+			"moderatedAt":      now,
+			"updatedAt":        now,
+		},
+	})
+	if err != nil {
+		// Restoring a hidden duplicate of a visible review
+		if mongo.IsDuplicateKeyError(err) {
+			return errors.New("already reviewed")
+		}
+		return err
+	}
+
+	pr.updatePlaceRatingStats(ctx, review.PlaceID.Hex())
+	return nil
+}
+
+// GetReviewStats summarizes the place's visible reviews
+func (pr *PlaceRepository) GetReviewStats(ctx context.Context, placeID string) (*models.PlaceReviewStats, error) {
+	placeObjectID, err := primitive.ObjectIDFromHex(placeID)
+	if err != nil {
+		return nil, errors.New("invalid place ID")
+	}
+
+	pipeline := []bson.M{
+		{"$match": bson.M{
+			"placeId":          placeObjectID,
+			"isPublic":         true,
+			"moderationStatus": bson.M{"$ne": models.ReviewModerationHidden},
+		}},
+		{"$group": bson.M{
+			"_id":   "$rating",
+			"count": bson.M{"$sum": 1},
+		}},
+	}
+
+	cursor, err := pr.reviewCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var ratings []struct {
+		Rating int   `bson:"_id"`
+		Count  int64 `bson:"count"`
+	}
+	if err := cursor.All(ctx, &ratings); err != nil {
+		return nil, err
+	}
+
+	stats := &models.PlaceReviewStats{
+		PlaceID:            placeID,
+		RatingDistribution: map[string]int64{"1": 0, "2": 0, "3": 0, "4": 0, "5": 0},
+	}
+	var ratingSum int64
+	for _, rating := range ratings {
+		stats.RatingDistribution[strconv.Itoa(rating.Rating)] += rating.Count
+		stats.TotalReviews += rating.Count
+		ratingSum += int64(rating.Rating) * rating.Count
+	}
+	if stats.TotalReviews > 0 {
+		stats.AverageRating = math.Round(float64(ratingSum)/float64(stats.TotalReviews)*10) / 10
+	}
+
+	return stats, nil
+}
+
+// ==================== REVIEW REPORT OPERATIONS ====================
+
+// CreateReviewReport files a report and counts it on the review
+func (pr *PlaceRepository) CreateReviewReport(ctx context.Context, report *models.PlaceReviewReport) error {
+	report.ID = primitive.NewObjectID()
+	report.CreatedAt = time.Now()
+	report.UpdatedAt = time.Now()
+	if report.Status == "" {
+		report.Status = models.ReviewReportPending
+	}
+
+	_, err := pr.reviewReportCol.InsertOne(ctx, report)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return errors.New("already reported")
+		}
+		return err
+	}
+
+	_, err = pr.reviewCollection.UpdateOne(ctx, bson.M{"_id": report.ReviewID}, bson.M{
+		"$inc": bson.M{"reportCount": 1},
+	})
+	return err
+}
+
+func (pr *PlaceRepository) GetReviewReportByID(ctx context.Context, reportID string) (*models.PlaceReviewReport, error) {
+	objectID, err := primitive.ObjectIDFromHex(reportID)
+	if err != nil {
+		return nil, errors.New("invalid report ID")
+	}
+
+	var report models.PlaceReviewReport
+	err = pr.reviewReportCol.FindOne(ctx, bson.M{"_id": objectID}).Decode(&report)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("report not found")
+		}
+		return nil, err
+	}
+
+	return &report, nil
+}
+
+// GetReviewReports lists review reports in a state, oldest first
+func (pr *PlaceRepository) GetReviewReports(ctx context.Context, status string, page, pageSize int) ([]models.PlaceReviewReport, int64, error) {
+	filter := bson.M{"status": status}
+
+	total, err := pr.reviewReportCol.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	skip := (page - 1) * pageSize
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: 1}}).
+		SetSkip(int64(skip)).
+		SetLimit(int64(pageSize))
+
+	cursor, err := pr.reviewReportCol.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	reports := []models.PlaceReviewReport{}
+	err = cursor.All(ctx, &reports)
+	return reports, total, err
+}
+
+// ResolvePendingReviewReports closes every pending report of a review with
+// the admin's decision
+func (pr *PlaceRepository) ResolvePendingReviewReports(ctx context.Context, reviewID primitive.ObjectID, status, action, resolution string, reviewedBy primitive.ObjectID) error {
+	now := time.Now()
+	_, err := pr.reviewReportCol.UpdateMany(ctx, bson.M{
+		"reviewId": reviewID,
+		"status":   models.ReviewReportPending,
+	}, bson.M{
+		"$set": bson.M{
+			"status":     status,
+			"action":     action,
+			"resolution": resolution,
+			"reviewedBy": reviewedBy,
+			"reviewedAt": now,
+			"updatedAt":  now,
+		},
+	})
+	return err
+}
+
+// ==================== CHECKIN OPERATIONS ====================
+
+func (pr *PlaceRepository) CreateCheckin(ctx context.Context, checkin *models.PlaceCheckin) error {
+	checkin.ID = primitive.NewObjectID()
+	checkin.CreatedAt = time.Now()
+	checkin.UpdatedAt = time.Now()
+
+	_, err := pr.checkinCollection.InsertOne(ctx, checkin)
+	if err != nil {
+		return err
+	}
+
+	// Update place checkin statistics
+	go pr.updatePlaceCheckinStats(ctx, checkin.PlaceID.Hex())
+
+	return nil
+}
+
+// checkinVisibilityFilter matches the check-ins the viewer may see: their
+// own, circle check-ins by their circle mates, and those shared with them
+func checkinVisibilityFilter(viewerID primitive.ObjectID, mateIDs []primitive.ObjectID) bson.M {
+	return bson.M{"$or": []bson.M{
+		{"userId": viewerID},
+		{"visibility": models.CheckinVisibilityCircle, "userId": bson.M{"$in": mateIDs}},
+		{"visibility": models.CheckinVisibilitySelected, "sharedWith": viewerID},
+	}}
+}
+
+// GetPlaceCheckins returns the place's check-ins the viewer may see, newest
+// first
+func (pr *PlaceRepository) GetPlaceCheckins(ctx context.Context, placeID string, viewerID primitive.ObjectID, mateIDs []primitive.ObjectID, page, pageSize int) ([]models.PlaceCheckin, int64, error) {
+	placeObjectID, err := primitive.ObjectIDFromHex(placeID)
+	if err != nil {
+		return nil, 0, errors.New("invalid place ID")
+	}
+
+	filter := checkinVisibilityFilter(viewerID, mateIDs)
+	filter["placeId"] = placeObjectID
+
+	total, err := pr.checkinCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	skip := (page - 1) * pageSize
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}}).
+		SetSkip(int64(skip)).
+		SetLimit(int64(pageSize))
+
+	cursor, err := pr.checkinCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var checkins []models.PlaceCheckin
+	err = cursor.All(ctx, &checkins)
+	return checkins, total, err
+}
+
+// GetCheckinFeed returns the check-ins of the authors that the viewer may
+// see, newest first
+func (pr *PlaceRepository) GetCheckinFeed(ctx context.Context, authorIDs []primitive.ObjectID, viewerID primitive.ObjectID, mateIDs []primitive.ObjectID, page, pageSize int) ([]models.PlaceCheckin, int64, error) {
+	filter := checkinVisibilityFilter(viewerID, mateIDs)
+	filter["userId"] = bson.M{"$in": authorIDs}
+
+	total, err := pr.checkinCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}}).
+		SetSkip(int64((page - 1) * pageSize)).
+		SetLimit(int64(pageSize))
+
+	cursor, err := pr.checkinCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var checkins []models.PlaceCheckin
+	err = cursor.All(ctx, &checkins)
+	return checkins, total, err
+}
+
+// GetCheckinLeaderboard ranks users by their check-ins at the place that the
+// viewer may see
+func (pr *PlaceRepository) GetCheckinLeaderboard(ctx context.Context, placeID string, viewerID primitive.ObjectID, mateIDs []primitive.ObjectID, limit int) ([]models.CheckinLeaderboardEntry, error) {
+	placeObjectID, err := primitive.ObjectIDFromHex(placeID)
+	if err != nil {
+		return nil, errors.New("invalid place ID")
+	}
+
+	match := checkinVisibilityFilter(viewerID, mateIDs)
+	match["placeId"] = placeObjectID
+
+	cursor, err := pr.checkinCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		// Newest first, so each user's group starts with their latest status
+		{{Key: "$sort", Value: bson.M{"createdAt": -1}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":      "$userId",
+			"checkins": bson.M{"$sum": 1},
+			"status":   bson.M{"$first": "$status"},
+			"mood":     bson.M{"$first": "$mood"},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "checkins", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: limit}},
+		{{Key: "$project", Value: bson.M{"_id": bson.M{"$toString": "$_id"}, "checkins": 1, "status": 1, "mood": 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	entries := []models.CheckinLeaderboardEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+
+	for i := range entries {
+		entries[i].Rank = i + 1
+	}
+	return entries, nil
+}
+
+func (pr *PlaceRepository) GetCheckinByID(ctx context.Context, checkinID string) (*models.PlaceCheckin, error) {
+	objectID, err := primitive.ObjectIDFromHex(checkinID)
+	if err != nil {
+		return nil, errors.New("invalid checkin ID")
+	}
+
+	var checkin models.PlaceCheckin
+	err = pr.checkinCollection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&checkin)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("checkin not found")
+		}
+		return nil, err
+	}
+
+	return &checkin, nil
+}
+
+// UpdateCheckinVisibility changes who sees a check-in. Listings filter when
+// read, so the change applies to earlier feeds right away.
+func (pr *PlaceRepository) UpdateCheckinVisibility(ctx context.Context, checkin *models.PlaceCheckin) error {
+	checkin.UpdatedAt = time.Now()
+
+	_, err := pr.checkinCollection.UpdateOne(ctx,
+		bson.M{"_id": checkin.ID},
+		bson.M{"$set": bson.M{
+			"visibility": checkin.Visibility,
+			"sharedWith": checkin.SharedWith,
+			"isPublic":   checkin.IsPublic,
+			"updatedAt":  checkin.UpdatedAt,
+		}},
+	)
+	return err
+}
+
+// ==================== AUTOMATION OPERATIONS ====================
+
+func (pr *PlaceRepository) CreateAutomationRule(ctx context.Context, rule *models.AutomationRule) error {
+	rule.ID = primitive.NewObjectID()
+	rule.CreatedAt = time.Now()
+	rule.UpdatedAt = time.Now()
+
+	_, err := pr.automationCollection.InsertOne(ctx, rule)
+	return err
+}
+
+// GetAutomationRules returns the user's rules, or those about the place
+// when placeID is set: rules naming it, place arrival and departure rules,
+// and rules matching any of its tags
+func (pr *PlaceRepository) GetAutomationRules(ctx context.Context, userID, placeID string, placeTags []string) ([]models.AutomationRule, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	filter := bson.M{"userId": userObjectID}
+
+	// If placeID is provided, filter by place-related conditions
+	if placeID != "" {
+		placeObjectID, err := primitive.ObjectIDFromHex(placeID)
+		if err != nil {
+			return nil, errors.New("invalid place ID")
+		}
+
+		// Filter rules that have conditions or actions related to this place
+		placeRules := []bson.M{
+			{"conditions.placeId": placeObjectID},
+			{"actions.placeId": placeObjectID},
+			{"type": bson.M{"$in": []string{"place_arrival", "place_departure"}}},
+		}
+		if len(placeTags) > 0 {
+			placeRules = append(placeRules, bson.M{"conditions": bson.M{"$elemMatch": bson.M{
+				"type":  models.RuleConditionPlaceTag,
+				"value": bson.M{"$in": placeTags},
+			}}})
+		}
+		filter["$or"] = placeRules
+	}
+
+	cursor, err := pr.automationCollection.Find(ctx, filter, options.Find().SetSort(automationPriorityOrder))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rules []models.AutomationRule
+	err = cursor.All(ctx, &rules)
+	return rules, err
+}
+
+// GetActivePlaceRules returns the user's active rules of a place trigger
+// type, in the order they run
+func (pr *PlaceRepository) GetActivePlaceRules(ctx context.Context, userID, ruleType string) ([]models.AutomationRule, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	cursor, err := pr.automationCollection.Find(ctx, bson.M{
+		"userId":   userObjectID,
+		"type":     ruleType,
+		"isActive": true,
+	}, options.Find().SetSort(automationPriorityOrder))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rules []models.AutomationRule
+	err = cursor.All(ctx, &rules)
+	return rules, err
+}
+
+func (pr *PlaceRepository) RecordAutomationTrigger(ctx context.Context, ruleID primitive.ObjectID) error {
+	now := time.Now()
+	_, err := pr.automationCollection.UpdateOne(ctx,
+		bson.M{"_id": ruleID},
+		bson.M{
+			"$inc": bson.M{"triggerCount": 1},
+			"$set": bson.M{"lastTriggered": now, "updatedAt": now},
+		},
+	)
+	return err
+}
+
+// ==================== TAG OPERATIONS ====================
+
+// placeTagFilter matches places carrying any of the tags, or all of them
+func placeTagFilter(tags []string, matchAll bool) bson.M {
+	if matchAll {
+		return bson.M{"$all": tags}
+	}
+	return bson.M{"$in": tags}
+}
+
+// GetPlaceTags counts the places of the user carrying each tag, most used
+// first, optionally only tags starting with the prefix
+func (pr *PlaceRepository) GetPlaceTags(ctx context.Context, userID, prefix string, limit int) ([]models.PlaceTagUsage, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"userId": userObjectID}}},
+		{{Key: "$unwind", Value: "$tags"}},
+	}
+	if prefix != "" {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{
+			"tags": bson.M{"$regex": "^" + regexp.QuoteMeta(prefix)},
+		}}})
+	}
+	pipeline = append(pipeline,
+		bson.D{{Key: "$group", Value: bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+		bson.D{{Key: "$limit", Value: limit}},
+	)
+
+	cursor, err := pr.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	tags := []models.PlaceTagUsage{}
+	err = cursor.All(ctx, &tags)
+	return tags, err
+}
+
+// RenamePlaceTag renames the tag on all of the user's places. Places that
+// already carry the new name just lose the old one. It returns how many
+// places changed.
+func (pr *PlaceRepository) RenamePlaceTag(ctx context.Context, userID, from, to string) (int64, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return 0, errors.New("invalid user ID")
+	}
+
+	now := time.Now()
+	merged, err := pr.collection.UpdateMany(ctx,
+		bson.M{"userId": userObjectID, "tags": bson.M{"$all": []string{from, to}}},
+		bson.M{"$pull": bson.M{"tags": from}, "$set": bson.M{"updatedAt": now}},
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	renamed, err := pr.collection.UpdateMany(ctx,
+		bson.M{"userId": userObjectID, "tags": from},
+		bson.M{"$set": bson.M{"tags.$": to, "updatedAt": now}},
+	)
+	if err != nil {
+		return merged.ModifiedCount, err
+	}
+
+	return merged.ModifiedCount + renamed.ModifiedCount, nil
+}
+
+// RenameRuleTag points the user's automation rule conditions on the tag at
+// its new name
+func (pr *PlaceRepository) RenameRuleTag(ctx context.Context, userID, from, to string) (int64, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return 0, errors.New("invalid user ID")
+	}
+
+	tagCondition := bson.M{"c.type": models.RuleConditionPlaceTag, "c.value": from}
+	result, err := pr.automationCollection.UpdateMany(ctx,
+		bson.M{"userId": userObjectID, "conditions": bson.M{"$elemMatch": bson.M{
+			"type":  models.RuleConditionPlaceTag,
+			"value": from,
+		}}},
+		bson.M{"$set": bson.M{"conditions.$[c].value": to, "updatedAt": time.Now()}},
+		options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{tagCondition}}),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// GetPlacesForBulkUpdate returns the user's places a bulk update picks, up
+// to limit
+func (pr *PlaceRepository) GetPlacesForBulkUpdate(ctx context.Context, userID string, req models.BulkUpdatePlacesRequest, limit int) ([]models.Place, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	filter := bson.M{"userId": userObjectID}
+	if len(req.PlaceIDs) > 0 {
+		placeIDs := make([]primitive.ObjectID, 0, len(req.PlaceIDs))
+		for _, placeID := range req.PlaceIDs {
+			objectID, err := primitive.ObjectIDFromHex(placeID)
+			if err != nil {
+				return nil, errors.New("invalid place ID")
+			}
+			placeIDs = append(placeIDs, objectID)
+		}
+		filter["_id"] = bson.M{"$in": placeIDs}
+	}
+	if len(req.Tags) > 0 {
+		filter["tags"] = placeTagFilter(req.Tags, req.TagMatch == "all")
+	}
+	if req.Category != "" {
+		filter["category"] = req.Category
+	}
+
+	cursor, err := pr.collection.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetLimit(int64(limit)))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var places []models.Place
+	err = cursor.All(ctx, &places)
+	return places, err
+}
+
+// ==================== TEMPLATE OPERATIONS ====================
+
+func (pr *PlaceRepository) CreateTemplate(ctx context.Context, template *models.PlaceTemplate) error {
+	template.ID = primitive.NewObjectID()
+	template.CreatedAt = time.Now()
+	template.UpdatedAt = time.Now()
+
+	_, err := pr.templateCollection.InsertOne(ctx, template)
+	return err
+}
+
+func (pr *PlaceRepository) GetTemplateByID(ctx context.Context, templateID string) (*models.PlaceTemplate, error) {
+	objectID, err := primitive.ObjectIDFromHex(templateID)
+	if err != nil {
+		return nil, errors.New("invalid template ID")
+	}
+
+	var template models.PlaceTemplate
+	err = pr.templateCollection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&template)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("template not found")
+		}
+		return nil, err
+	}
+
+	return &template, nil
+}
+
+func (pr *PlaceRepository) GetUserTemplates(ctx context.Context, userID string) ([]models.PlaceTemplate, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := pr.templateCollection.Find(ctx, bson.M{"userId": userObjectID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var templates []models.PlaceTemplate
+	err = cursor.All(ctx, &templates)
+	return templates, err
+}
+
+func (pr *PlaceRepository) UpdateTemplate(ctx context.Context, templateID string, updates map[string]interface{}) error {
+	objectID, err := primitive.ObjectIDFromHex(templateID)
+	if err != nil {
+		return errors.New("invalid template ID")
+	}
+
+	updates["updatedAt"] = time.Now()
+
+	result, err := pr.templateCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": objectID},
+		bson.M{"$set": updates},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return errors.New("template not found")
+	}
+
+	return nil
+}
+
+// UnpublishTemplate takes the template out of the gallery. Places created
+// from it hold their own copy of the data and are not touched.
+func (pr *PlaceRepository) UnpublishTemplate(ctx context.Context, templateID string) error {
+	objectID, err := primitive.ObjectIDFromHex(templateID)
+	if err != nil {
+		return errors.New("invalid template ID")
+	}
+
+	result, err := pr.templateCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": objectID},
+		bson.M{
+			"$set": bson.M{"isPublic": false, "updatedAt": time.Now()},
+			"$unset": bson.M{
+				"moderationStatus": "",
+				"moderationNote":   "",
+				"reviewedBy":       "",
+				"reviewedAt":       "",
+				"publishedAt":      "",
+				"galleryTemplate":  "",
+			},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return errors.New("template not found")
+	}
+
+	return nil
+}
+
+func (pr *PlaceRepository) DeleteTemplate(ctx context.Context, templateID string) error {
+	objectID, err := primitive.ObjectIDFromHex(templateID)
+	if err != nil {
+		return errors.New("invalid template ID")
+	}
+
+	result, err := pr.templateCollection.DeleteOne(ctx, bson.M{"_id": objectID})
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return errors.New("template not found")
+	}
+
+	return nil
+}
+
+// GetGalleryTemplates lists approved public templates, most used first
+// unless sorted by recent
+func (pr *PlaceRepository) GetGalleryTemplates(ctx context.Context, req models.GetTemplateGalleryRequest) ([]models.PlaceTemplate, int64, error) {
+	filter := bson.M{
+		"isPublic":         true,
+		"moderationStatus": models.TemplateModerationApproved,
+	}
+	if req.Category != "" {
+		filter["category"] = req.Category
+	}
+	if req.Tag != "" {
+		filter["tags"] = req.Tag
+	}
+
+	total, err := pr.templateCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sort := bson.D{{Key: "usageCount", Value: -1}, {Key: "publishedAt", Value: -1}, {Key: "_id", Value: -1}}
+	if req.SortBy == "recent" {
+		sort = bson.D{{Key: "publishedAt", Value: -1}, {Key: "_id", Value: -1}}
+	}
+
+	skip := (req.Page - 1) * req.PageSize
+	opts := options.Find().
+		SetSort(sort).
+		SetSkip(int64(skip)).
+		SetLimit(int64(req.PageSize))
+
+	cursor, err := pr.templateCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var templates []models.PlaceTemplate
+	err = cursor.All(ctx, &templates)
+	return templates, total, err
+}
+
+// GetTemplatesForModeration lists published templates in a moderation
+// state, oldest submission first
+func (pr *PlaceRepository) GetTemplatesForModeration(ctx context.Context, status string, page, pageSize int) ([]models.PlaceTemplate, int64, error) {
+	filter := bson.M{"isPublic": true, "moderationStatus": status}
+
+	total, err := pr.templateCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	skip := (page - 1) * pageSize
+	opts := options.Find().
+		SetSort(bson.D{{Key: "publishedAt", Value: 1}}).
+		SetSkip(int64(skip)).
+		SetLimit(int64(pageSize))
+
+	cursor, err := pr.templateCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var templates []models.PlaceTemplate
+	err = cursor.All(ctx, &templates)
+	return templates, total, err
+}
+
+// IncrementTemplateUsage records that a place was created from the template
+func (pr *PlaceRepository) IncrementTemplateUsage(ctx context.Context, templateID primitive.ObjectID) error {
+	_, err := pr.templateCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": templateID},
+		bson.M{
+			"$inc": bson.M{"usageCount": 1},
+			"$set": bson.M{"lastUsedAt": time.Now()},
+		},
+	)
+	return err
+}
+
+// ==================== VERSION OPERATIONS ====================
+
+// CreatePlaceVersion stores the version as the place's next one
+func (pr *PlaceRepository) CreatePlaceVersion(ctx context.Context, version *models.PlaceVersion) error {
+	if version.CreatedAt.IsZero() {
+		version.CreatedAt = time.Now()
+	}
+
+	// Two changes at once can pick the same number, and the unique index
+	// on it turns the second away
+	for attempt := 0; ; attempt++ {
+		latest, err := pr.latestPlaceVersion(ctx, version.PlaceID)
+		if err != nil {
+			return err
+		}
+
+		version.ID = primitive.NewObjectID()
+		version.Version = latest + 1
+		_, err = pr.versionCollection.InsertOne(ctx, version)
+		if err == nil || !mongo.IsDuplicateKeyError(err) || attempt == 2 {
+			return err
+		}
+	}
+}
+
+func (pr *PlaceRepository) latestPlaceVersion(ctx context.Context, placeID primitive.ObjectID) (int, error) {
+	var latest models.PlaceVersion
+	opts := options.FindOne().SetSort(bson.M{"version": -1}).SetProjection(bson.M{"version": 1})
+	err := pr.versionCollection.FindOne(ctx, bson.M{"placeId": placeID}, opts).Decode(&latest)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	return latest.Version, err
+}
+
+func (pr *PlaceRepository) HasPlaceVersions(ctx context.Context, placeID primitive.ObjectID) (bool, error) {
+	count, err := pr.versionCollection.CountDocuments(ctx, bson.M{"placeId": placeID}, options.Count().SetLimit(1))
+	return count > 0, err
+}
+
+// GetPlaceVersions returns a page of the place's versions, newest first
+func (pr *PlaceRepository) GetPlaceVersions(ctx context.Context, placeID string, page, pageSize int) ([]models.PlaceVersion, int64, error) {
+	placeObjectID, err := primitive.ObjectIDFromHex(placeID)
+	if err != nil {
+		return nil, 0, errors.New("invalid place ID")
+	}
+
+	filter := bson.M{"placeId": placeObjectID}
+	total, err := pr.versionCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.M{"version": -1}).
+		SetSkip(int64((page - 1) * pageSize)).
+		SetLimit(int64(pageSize))
+
+	cursor, err := pr.versionCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	versions := []models.PlaceVersion{}
+	err = cursor.All(ctx, &versions)
+	return versions, total, err
+}
+
+func (pr *PlaceRepository) GetPlaceVersion(ctx context.Context, placeID, versionID string) (*models.PlaceVersion, error) {
+	placeObjectID, err := primitive.ObjectIDFromHex(placeID)
+	if err != nil {
+		return nil, errors.New("invalid place ID")
+	}
+	versionObjectID, err := primitive.ObjectIDFromHex(versionID)
+	if err != nil {
+		return nil, errors.New("invalid version ID")
+	}
+
+	var version models.PlaceVersion
+	err = pr.versionCollection.FindOne(ctx, bson.M{"_id": versionObjectID, "placeId": placeObjectID}).Decode(&version)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("place version not found")
+		}
+		return nil, err
+	}
+
+	return &version, nil
+}
+
+// PrunePlaceVersions deletes all but the place's newest keep versions
+func (pr *PlaceRepository) PrunePlaceVersions(ctx context.Context, placeID primitive.ObjectID, keep int) (int64, error) {
+	var oldestKept models.PlaceVersion
+	opts := options.FindOne().
+		SetSort(bson.M{"version": -1}).
+		SetSkip(int64(keep - 1)).
+		SetProjection(bson.M{"version": 1})
+	err := pr.versionCollection.FindOne(ctx, bson.M{"placeId": placeID}, opts).Decode(&oldestKept)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := pr.versionCollection.DeleteMany(ctx, bson.M{
+		"placeId": placeID,
+		"version": bson.M{"$lt": oldestKept.Version},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// ==================== HOLIDAY SET OPERATIONS ====================
+
+func (pr *PlaceRepository) CreateHolidaySet(ctx context.Context, set *models.HolidaySet) error {
+	set.ID = primitive.NewObjectID()
+	set.CreatedAt = time.Now()
+	set.UpdatedAt = time.Now()
+
+	_, err := pr.holidaySetCollection.InsertOne(ctx, set)
+	return err
+}
+
+func (pr *PlaceRepository) GetHolidaySet(ctx context.Context, setID string) (*models.HolidaySet, error) {
+	objectID, err := primitive.ObjectIDFromHex(setID)
+	if err != nil {
+		return nil, errors.New("invalid holiday set ID")
+	}
+
+	var set models.HolidaySet
+	err = pr.holidaySetCollection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&set)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("holiday set not found")
+		}
+		return nil, err
+	}
+
+	return &set, nil
+}
+
+// GetHolidaySetsByIDs returns the holiday sets with the given ids, skipping
+// missing ones
+func (pr *PlaceRepository) GetHolidaySetsByIDs(ctx context.Context, setIDs []primitive.ObjectID) ([]models.HolidaySet, error) {
+	if len(setIDs) == 0 {
+		return []models.HolidaySet{}, nil
+	}
+
+	cursor, err := pr.holidaySetCollection.Find(ctx, bson.M{"_id": bson.M{"$in": setIDs}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	sets := []models.HolidaySet{}
+	err = cursor.All(ctx, &sets)
+	return sets, err
+}
+
+func (pr *PlaceRepository) GetUserHolidaySets(ctx context.Context, userID primitive.ObjectID) ([]models.HolidaySet, error) {
+	opts := options.Find().SetSort(bson.M{"name": 1})
+	cursor, err := pr.holidaySetCollection.Find(ctx, bson.M{"userId": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	sets := []models.HolidaySet{}
+	err = cursor.All(ctx, &sets)
+	return sets, err
+}
+
+func (pr *PlaceRepository) UpdateHolidaySet(ctx context.Context, setID primitive.ObjectID, name string, holidays []models.Holiday) error {
+	result, err := pr.holidaySetCollection.UpdateOne(ctx, bson.M{"_id": setID}, bson.M{
+		"$set": bson.M{
+			"name":      name,
+			"holidays":  holidays,
+			"updatedAt": time.Now(),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("holiday set not found")
+	}
+	return nil
+}
+
+func (pr *PlaceRepository) DeleteHolidaySet(ctx context.Context, setID primitive.ObjectID) error {
+	result, err := pr.holidaySetCollection.DeleteOne(ctx, bson.M{"_id": setID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errors.New("holiday set not found")
+	}
+	return nil
+}
+
+// CountPlacesUsingHolidaySet counts the places whose hours follow the set
+func (pr *PlaceRepository) CountPlacesUsingHolidaySet(ctx context.Context, setID primitive.ObjectID) (int64, error) {
+	return pr.collection.CountDocuments(ctx, bson.M{"hours.recurringOverrides.holidaySetId": setID})
+}
+
+// ==================== PLACE ALIAS OPERATIONS ====================
+
+// SetPlaceAlias sets the member's alias for the place and whether it is
+// their home; nil leaves a field as it is. Making a place the member's
+// home unmarks their previous one. A label left with neither is removed,
+// and nil is returned.
+func (pr *PlaceRepository) SetPlaceAlias(ctx context.Context, userID, placeID primitive.ObjectID, alias *string, isHome *bool) (*models.PlaceAlias, error) {
+	if isHome != nil && *isHome {
+		_, err := pr.aliasCollection.UpdateMany(ctx, bson.M{
+			"userId":  userID,
+			"placeId": bson.M{"$ne": placeID},
+			"isHome":  true,
+		}, bson.M{"$set": bson.M{"isHome": false, "updatedAt": time.Now()}})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	set := bson.M{"updatedAt": time.Now()}
+	if alias != nil {
+		set["alias"] = *alias
+	}
+	if isHome != nil {
+		set["isHome"] = *isHome
+	}
+
+	filter := bson.M{"userId": userID, "placeId": placeID}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var label models.PlaceAlias
+	err := pr.aliasCollection.FindOneAndUpdate(ctx, filter, bson.M{
+		"$set":         set,
+		"$setOnInsert": bson.M{"createdAt": time.Now()},
+	}, opts).Decode(&label)
+	if err != nil {
+		return nil, err
+	}
+
+	if label.Alias == "" && !label.IsHome {
+		_, err := pr.aliasCollection.DeleteOne(ctx, bson.M{"_id": label.ID})
+		return nil, err
+	}
+	return &label, nil
+}
+
+func (pr *PlaceRepository) DeletePlaceAlias(ctx context.Context, userID, placeID primitive.ObjectID) error {
+	result, err := pr.aliasCollection.DeleteOne(ctx, bson.M{"userId": userID, "placeId": placeID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errors.New("place alias not found")
+	}
+	return nil
+}
+
+// GetUserPlaceAliases returns the member's labels for the places, by place
+func (pr *PlaceRepository) GetUserPlaceAliases(ctx context.Context, userID primitive.ObjectID, placeIDs []primitive.ObjectID) (map[primitive.ObjectID]models.PlaceAlias, error) {
+	return pr.findPlaceAliases(ctx, bson.M{"userId": userID, "placeId": bson.M{"$in": placeIDs}}, func(label models.PlaceAlias) primitive.ObjectID {
+		return label.PlaceID
+	})
+}
+
+// GetPlaceAliasesOf returns the members' labels for the place, by member
+func (pr *PlaceRepository) GetPlaceAliasesOf(ctx context.Context, placeID primitive.ObjectID, userIDs []primitive.ObjectID) (map[primitive.ObjectID]models.PlaceAlias, error) {
+	return pr.findPlaceAliases(ctx, bson.M{"placeId": placeID, "userId": bson.M{"$in": userIDs}}, func(label models.PlaceAlias) primitive.ObjectID {
+		return label.UserID
+	})
+}
+
+func (pr *PlaceRepository) findPlaceAliases(ctx context.Context, filter bson.M, key func(models.PlaceAlias) primitive.ObjectID) (map[primitive.ObjectID]models.PlaceAlias, error) {
+	cursor, err := pr.aliasCollection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var labels []models.PlaceAlias
+	if err := cursor.All(ctx, &labels); err != nil {
+		return nil, err
+	}
+
+	aliases := make(map[primitive.ObjectID]models.PlaceAlias, len(labels))
+	for _, label := range labels {
+		aliases[key(label)] = label
+	}
+	return aliases, nil
+}
+
+// GetHomePlaceID returns the member's home place, or a zero ID if they
+// haven't set one
+func (pr *PlaceRepository) GetHomePlaceID(ctx context.Context, userID primitive.ObjectID) (primitive.ObjectID, error) {
+	var label models.PlaceAlias
+	err := pr.aliasCollection.FindOne(ctx, bson.M{"userId": userID, "isHome": true}).Decode(&label)
+	if err == mongo.ErrNoDocuments {
+		return primitive.NilObjectID, nil
+	}
+	return label.PlaceID, err
+}
+
+// DeletePlaceAliases removes every member's label for the place and
+// returns the removed labels
+func (pr *PlaceRepository) DeletePlaceAliases(ctx context.Context, placeID primitive.ObjectID) ([]models.PlaceAlias, error) {
+	cursor, err := pr.aliasCollection.Find(ctx, bson.M{"placeId": placeID})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	labels := []models.PlaceAlias{}
+	if err := cursor.All(ctx, &labels); err != nil {
+		return nil, err
+	}
+	if len(labels) == 0 {
+		return labels, nil
+	}
+
+	_, err = pr.aliasCollection.DeleteMany(ctx, bson.M{"placeId": placeID})
+	return labels, err
+}
+
+// ==================== PLACE FAVORITE OPERATIONS ====================
+
+// SetPlaceFavorite adds the place to the member's favorites or removes it.
+// Setting it to what it already is changes nothing.
+func (pr *PlaceRepository) SetPlaceFavorite(ctx context.Context, userID, placeID primitive.ObjectID, favorite bool) error {
+	filter := bson.M{"userId": userID, "placeId": placeID}
+	if !favorite {
+		_, err := pr.favoriteCollection.DeleteOne(ctx, filter)
+		return err
+	}
+
+	_, err := pr.favoriteCollection.UpdateOne(ctx, filter, bson.M{
+		"$setOnInsert": bson.M{"createdAt": time.Now()},
+	}, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// Favorited by a concurrent request
+		return nil
+	}
+	return err
+}
+
+// GetUserFavoritePlaceIDs returns which of the places are among the
+// member's favorites
+func (pr *PlaceRepository) GetUserFavoritePlaceIDs(ctx context.Context, userID primitive.ObjectID, placeIDs []primitive.ObjectID) (map[primitive.ObjectID]bool, error) {
+	cursor, err := pr.favoriteCollection.Find(ctx, bson.M{"userId": userID, "placeId": bson.M{"$in": placeIDs}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var favorites []models.PlaceFavorite
+	if err := cursor.All(ctx, &favorites); err != nil {
+		return nil, err
+	}
+
+	favorited := make(map[primitive.ObjectID]bool, len(favorites))
+	for _, favorite := range favorites {
+		favorited[favorite.PlaceID] = true
+	}
+	return favorited, nil
+}
+
+// GetFavoritePlaces returns a page of the member's favorites, most
+// recently favorited first
+func (pr *PlaceRepository) GetFavoritePlaces(ctx context.Context, userID primitive.ObjectID, page, pageSize int) ([]models.PlaceFavorite, int64, error) {
+	filter := bson.M{"userId": userID}
+
+	total, err := pr.favoriteCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	skip := (page - 1) * pageSize
 	opts := options.Find().
-		SetSort(bson.D{{Key: "createdAt", Value: -1}}).
-		SetSkip(int64(skip)).
+		SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64((page - 1) * pageSize)).
 		SetLimit(int64(pageSize))
 
-	cursor, err := pr.checkinCollection.Find(ctx, filter, opts)
+	cursor, err := pr.favoriteCollection.Find(ctx, filter, opts)
 	if err != nil {
 		return nil, 0, err
 	}
 	defer cursor.Close(ctx)
 
-	var checkins []models.PlaceCheckin
-	err = cursor.All(ctx, &checkins)
-	return checkins, total, err
+	favorites := []models.PlaceFavorite{}
+	err = cursor.All(ctx, &favorites)
+	return favorites, total, err
 }
 
-// ==================== AUTOMATION OPERATIONS ====================
+func (pr *PlaceRepository) favoritePlaceIDs(ctx context.Context, userID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	values, err := pr.favoriteCollection.Distinct(ctx, "placeId", bson.M{"userId": userID})
+	if err != nil {
+		return nil, err
+	}
 
-func (pr *PlaceRepository) CreateAutomationRule(ctx context.Context, rule *models.AutomationRule) error {
-	rule.ID = primitive.NewObjectID()
-	rule.CreatedAt = time.Now()
-	rule.UpdatedAt = time.Now()
+	placeIDs := make([]primitive.ObjectID, 0, len(values))
+	for _, value := range values {
+		if placeID, ok := value.(primitive.ObjectID); ok {
+			placeIDs = append(placeIDs, placeID)
+		}
+	}
+	return placeIDs, nil
+}
 
-	_, err := pr.automationCollection.InsertOne(ctx, rule)
+// DeletePlaceFavorites removes the place from every member's favorites
+func (pr *PlaceRepository) DeletePlaceFavorites(ctx context.Context, placeID primitive.ObjectID) error {
+	_, err := pr.favoriteCollection.DeleteMany(ctx, bson.M{"placeId": placeID})
 	return err
 }
 
-func (pr *PlaceRepository) GetAutomationRules(ctx context.Context, userID, placeID string) ([]models.AutomationRule, error) {
-	userObjectID, err := primitive.ObjectIDFromHex(userID)
+// ==================== PLACE SUGGESTION OPERATIONS ====================
+
+func (pr *PlaceRepository) CreatePlaceSuggestion(ctx context.Context, suggestion *models.PlaceSuggestion) error {
+	suggestion.ID = primitive.NewObjectID()
+	suggestion.CreatedAt = time.Now()
+	suggestion.UpdatedAt = suggestion.CreatedAt
+
+	_, err := pr.suggestionCollection.InsertOne(ctx, suggestion)
+	return err
+}
+
+func (pr *PlaceRepository) GetPlaceSuggestion(ctx context.Context, suggestionID string) (*models.PlaceSuggestion, error) {
+	objectID, err := primitive.ObjectIDFromHex(suggestionID)
 	if err != nil {
-		return nil, errors.New("invalid user ID")
+		return nil, errors.New("invalid suggestion ID")
 	}
 
-	filter := bson.M{"userId": userObjectID}
-
-	// If placeID is provided, filter by place-related conditions
-	if placeID != "" {
-		placeObjectID, err := primitive.ObjectIDFromHex(placeID)
-		if err != nil {
-			return nil, errors.New("invalid place ID")
+	var suggestion models.PlaceSuggestion
+	err = pr.suggestionCollection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&suggestion)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("place suggestion not found")
 		}
+		return nil, err
+	}
+	return &suggestion, nil
+}
 
-		// Filter rules that have conditions or actions related to this place
-		filter["$or"] = []bson.M{
-			{"conditions.placeId": placeObjectID},
-			{"actions.placeId": placeObjectID},
-			{"type": bson.M{"$in": []string{"place_arrival", "place_departure"}}},
+// GetUserPlaceSuggestions returns the user's suggestions in any status, so
+// the analyzer can tell which stays were already suggested
+func (pr *PlaceRepository) GetUserPlaceSuggestions(ctx context.Context, userID primitive.ObjectID) ([]models.PlaceSuggestion, error) {
+	cursor, err := pr.suggestionCollection.Find(ctx, bson.M{"userId": userID})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var suggestions []models.PlaceSuggestion
+	err = cursor.All(ctx, &suggestions)
+	return suggestions, err
+}
+
+// GetPendingPlaceSuggestions returns the user's open suggestions last
+// visited since the given time, most visited first
+func (pr *PlaceRepository) GetPendingPlaceSuggestions(ctx context.Context, userID primitive.ObjectID, since time.Time) ([]models.PlaceSuggestion, error) {
+	filter := bson.M{
+		"userId":    userID,
+		"status":    models.PlaceSuggestionStatusSuggested,
+		"lastVisit": bson.M{"$gte": since},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "visitDays", Value: -1}, {Key: "visitCount", Value: -1}, {Key: "_id", Value: 1}})
+
+	cursor, err := pr.suggestionCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	suggestions := []models.PlaceSuggestion{}
+	err = cursor.All(ctx, &suggestions)
+	return suggestions, err
+}
+
+// UpdatePlaceSuggestion sets fields of a suggestion that is still open.
+// Accepted and dismissed ones are left alone.
+func (pr *PlaceRepository) UpdatePlaceSuggestion(ctx context.Context, suggestionID primitive.ObjectID, set bson.M) error {
+	set["updatedAt"] = time.Now()
+	result, err := pr.suggestionCollection.UpdateOne(ctx,
+		bson.M{"_id": suggestionID, "status": models.PlaceSuggestionStatusSuggested},
+		bson.M{"$set": set},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("place suggestion not found")
+	}
+	return nil
+}
+
+// DeletePendingPlaceSuggestions removes the user's open suggestions, e.g.
+// when their location history is cleared. Dismissed ones stay so they
+// aren't suggested again.
+func (pr *PlaceRepository) DeletePendingPlaceSuggestions(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := pr.suggestionCollection.DeleteMany(ctx, bson.M{
+		"userId": userID,
+		"status": models.PlaceSuggestionStatusSuggested,
+	})
+	return err
+}
+
+// DeleteExpiredPlaceSuggestions removes the open suggestions last visited
+// before the given time, when their stays have left location history
+func (pr *PlaceRepository) DeleteExpiredPlaceSuggestions(ctx context.Context, before time.Time) (int64, error) {
+	result, err := pr.suggestionCollection.DeleteMany(ctx, bson.M{
+		"status":    models.PlaceSuggestionStatusSuggested,
+		"lastVisit": bson.M{"$lt": before},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// ==================== ANALYTICS OPERATIONS ====================
+
+// analyticsPeriodStart is the start of the day or week, in the timezone,
+// that the date falls in. Weeks start on Monday.
+func analyticsPeriodStart(field, period, timezone string) bson.M {
+	return bson.M{"$dateTrunc": bson.M{
+		"date":        field,
+		"unit":        period,
+		"timezone":    timezone,
+		"startOfWeek": "monday",
+	}}
+}
+
+// visitBucketGroup adds up the visits grouped by the key, counting each
+// visitor once. The key is kept in the named field, if any.
+func visitBucketGroup(key interface{}, field string) []bson.M {
+	project := bson.M{
+		"_id":      0,
+		"visits":   1,
+		"visitors": bson.M{"$size": "$visitors"},
+		"duration": 1,
+	}
+	stages := []bson.M{
+		{"$group": bson.M{
+			"_id":      key,
+			"visits":   bson.M{"$sum": 1},
+			"visitors": bson.M{"$addToSet": "$userId"},
+			"duration": bson.M{"$sum": "$duration"},
+		}},
+		{"$project": project},
+	}
+	if field != "" {
+		project[field] = "$_id"
+		stages = append(stages, bson.M{"$sort": bson.M{field: 1}})
+	}
+	return stages
+}
+
+// GetPlaceVisitAnalytics adds up the place's visits that started in the
+// range, by day or week, by hour of the day and by day of the week, in the
+// timezone. Only counts leave the database, never who visited.
+func (pr *PlaceRepository) GetPlaceVisitAnalytics(ctx context.Context, placeID primitive.ObjectID, from, to time.Time, period, timezone string) (*models.PlaceVisitAnalytics, error) {
+	cursor, err := pr.visitCollection.Aggregate(ctx, []bson.M{
+		{"$match": bson.M{
+			"placeId":     placeID,
+			"arrivalTime": bson.M{"$gte": from, "$lt": to},
+		}},
+		{"$facet": bson.M{
+			"totals":   visitBucketGroup(nil, ""),
+			"periods":  visitBucketGroup(analyticsPeriodStart("$arrivalTime", period, timezone), "start"),
+			"hours":    visitBucketGroup(bson.M{"$hour": bson.M{"date": "$arrivalTime", "timezone": timezone}}, "hour"),
+			"weekdays": visitBucketGroup(bson.M{"$isoDayOfWeek": bson.M{"date": "$arrivalTime", "timezone": timezone}}, "weekday"),
+		}},
+		{"$project": bson.M{
+			"totals":   bson.M{"$ifNull": []interface{}{bson.M{"$first": "$totals"}, bson.M{}}},
+			"periods":  1,
+			"hours":    1,
+			"weekdays": 1,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var analytics models.PlaceVisitAnalytics
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&analytics); err != nil {
+			return nil, err
 		}
 	}
+	return &analytics, cursor.Err()
+}
+
+// GetPlaceCheckinTrend counts the place's check-ins in the range that the
+// viewer may see, by day or week in the timezone
+func (pr *PlaceRepository) GetPlaceCheckinTrend(ctx context.Context, placeID primitive.ObjectID, from, to time.Time, period, timezone string, viewerID primitive.ObjectID, mateIDs []primitive.ObjectID) ([]models.PlaceActivityBucket, error) {
+	match := checkinVisibilityFilter(viewerID, mateIDs)
+	match["placeId"] = placeID
+	match["createdAt"] = bson.M{"$gte": from, "$lt": to}
+
+	return pr.activityTrend(ctx, pr.checkinCollection, match, period, timezone, nil)
+}
+
+// GetPlaceReviewTrend counts the place's public reviews written in the
+// range and adds up their ratings, by day or week in the timezone
+func (pr *PlaceRepository) GetPlaceReviewTrend(ctx context.Context, placeID primitive.ObjectID, from, to time.Time, period, timezone string) ([]models.PlaceActivityBucket, error) {
+	match := bson.M{
+		"placeId":          placeID,
+		"isPublic":         true,
+		"moderationStatus": bson.M{"$ne": models.ReviewModerationHidden},
+		"createdAt":        bson.M{"$gte": from, "$lt": to},
+	}
+
+	return pr.activityTrend(ctx, pr.reviewCollection, match, period, timezone, "$rating")
+}
+
+func (pr *PlaceRepository) activityTrend(ctx context.Context, collection *database.Collection, match bson.M, period, timezone string, rating interface{}) ([]models.PlaceActivityBucket, error) {
+	group := bson.M{
+		"_id":   analyticsPeriodStart("$createdAt", period, timezone),
+		"count": bson.M{"$sum": 1},
+	}
+	if rating != nil {
+		group["ratingSum"] = bson.M{"$sum": rating}
+	}
 
-	cursor, err := pr.automationCollection.Find(ctx, filter)
+	cursor, err := collection.Aggregate(ctx, []bson.M{
+		{"$match": match},
+		{"$group": group},
+		{"$sort": bson.M{"_id": 1}},
+	})
 	if err != nil {
 		return nil, err
 	}
 	defer cursor.Close(ctx)
 
-	var rules []models.AutomationRule
-	err = cursor.All(ctx, &rules)
-	return rules, err
+	buckets := []models.PlaceActivityBucket{}
+	if err := cursor.All(ctx, &buckets); err != nil {
+		return nil, err
+	}
+	return buckets, nil
 }
 
 // ==================== HELPER METHODS ====================
@@ -670,7 +2630,10 @@ func (pr *PlaceRepository) updatePlaceRatingStats(ctx context.Context, placeID s
 	placeObjectID, _ := primitive.ObjectIDFromHex(placeID)
 
 	pipeline := []bson.M{
-		{"$match": bson.M{"placeId": placeObjectID}},
+		{"$match": bson.M{
+			"placeId":          placeObjectID,
+			"moderationStatus": bson.M{"$ne": models.ReviewModerationHidden},
+		}},
 		{"$group": bson.M{
 			"_id":       nil,
 			"avgRating": bson.M{"$avg": "$rating"},
@@ -3,6 +3,7 @@ package repositories
 import (
 	"context"
 	"errors"
+	"ftrack/database"
 	"ftrack/models"
 	"time"
 
@@ -13,12 +14,12 @@ import (
 )
 
 type ReportRepository struct {
-	collection *mongo.Collection
+	collection *database.Collection
 }
 
 func NewReportRepository(db *mongo.Database) *ReportRepository {
 	return &ReportRepository{
-		collection: db.Collection("message_reports"),
+		collection: database.NewCollection(db, "message_reports"),
 	}
 }
 
@@ -3,6 +3,7 @@ package repositories
 import (
 	"context"
 	"errors"
+	"ftrack/database"
 	"ftrack/models"
 	"time"
 
@@ -13,12 +14,12 @@ import (
 )
 
 type ScheduleRepository struct {
-	collection *mongo.Collection
+	collection *database.Collection
 }
 
 func NewScheduleRepository(db *mongo.Database) *ScheduleRepository {
 	return &ScheduleRepository{
-		collection: db.Collection("scheduled_messages"),
+		collection: database.NewCollection(db, "scheduled_messages"),
 	}
 }
 
@@ -322,3 +323,65 @@ func (sr *ScheduleRepository) CountPendingMessages(ctx context.Context, userID s
 
 	return sr.collection.CountDocuments(ctx, filter)
 }
+
+// PauseUserMessages holds all of the user's pending messages so the
+// scheduler skips them
+func (sr *ScheduleRepository) PauseUserMessages(ctx context.Context, userID string) (int64, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return 0, errors.New("invalid user ID")
+	}
+
+	result, err := sr.collection.UpdateMany(
+		ctx,
+		bson.M{
+			"userId":    userObjectID,
+			"status":    "pending",
+			"isDeleted": bson.M{"$ne": true},
+		},
+		bson.M{"$set": bson.M{"status": "paused", "updatedAt": time.Now()}},
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+}
+
+// ResumeUserMessages puts the user's paused messages back in the queue.
+// Messages whose time passed while paused are cancelled instead of being
+// sent late.
+func (sr *ScheduleRepository) ResumeUserMessages(ctx context.Context, userID string) (int64, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return 0, errors.New("invalid user ID")
+	}
+
+	now := time.Now()
+	filter := bson.M{
+		"userId":    userObjectID,
+		"status":    "paused",
+		"isDeleted": bson.M{"$ne": true},
+	}
+
+	filter["scheduledAt"] = bson.M{"$lte": now}
+	_, err = sr.collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{
+		"status":    "cancelled",
+		"errorMsg":  "scheduled time passed while the account was deactivated",
+		"updatedAt": now,
+	}})
+	if err != nil {
+		return 0, err
+	}
+
+	filter["scheduledAt"] = bson.M{"$gt": now}
+	result, err := sr.collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{
+		"status":    "pending",
+		"updatedAt": now,
+	}})
+	if err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+}
@@ -0,0 +1,72 @@
+package repositories
+
+import (
+	"context"
+	"ftrack/database"
+	"ftrack/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type SigningKeyRepository struct {
+	collection *database.Collection
+}
+
+func NewSigningKeyRepository(db *mongo.Database) *SigningKeyRepository {
+	return &SigningKeyRepository{
+		collection: database.NewCollection(db, "signing_keys"),
+	}
+}
+
+// GetUsable returns the keys that still verify tokens, oldest first
+func (skr *SigningKeyRepository) GetUsable(ctx context.Context) ([]models.SigningKey, error) {
+	filter := bson.M{
+		"$or": []bson.M{
+			{"expiresAt": bson.M{"$exists": false}},
+			{"expiresAt": bson.M{"$gt": time.Now()}},
+		},
+	}
+
+	cursor, err := skr.collection.Find(ctx, filter, options.Find().SetSort(bson.M{"createdAt": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	keys := []models.SigningKey{}
+	err = cursor.All(ctx, &keys)
+	return keys, err
+}
+
+// Rotate stores a new active key and retires the previous ones. Retired
+// keys keep verifying tokens until retiredKeysExpireAt.
+func (skr *SigningKeyRepository) Rotate(ctx context.Context, key *models.SigningKey, retiredKeysExpireAt time.Time) error {
+	now := time.Now()
+	key.ID = primitive.NewObjectID()
+	key.Active = true
+	key.CreatedAt = now
+
+	if _, err := skr.collection.InsertOne(ctx, key); err != nil {
+		return err
+	}
+
+	filter := bson.M{
+		"_id":    bson.M{"$ne": key.ID},
+		"active": true,
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"active":    false,
+			"retiredAt": now,
+			"expiresAt": retiredKeysExpireAt,
+		},
+	}
+
+	_, err := skr.collection.UpdateMany(ctx, filter, update)
+	return err
+}
@@ -3,6 +3,7 @@ package repositories
 import (
 	"context"
 	"errors"
+	"ftrack/database"
 	"ftrack/models"
 	"time"
 
@@ -13,12 +14,12 @@ import (
 )
 
 type TemplateRepository struct {
-	collection *mongo.Collection
+	collection *database.Collection
 }
 
 func NewTemplateRepository(db *mongo.Database) *TemplateRepository {
 	return &TemplateRepository{
-		collection: db.Collection("message_templates"),
+		collection: database.NewCollection(db, "message_templates"),
 	}
 }
 
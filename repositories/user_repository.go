@@ -8,6 +8,7 @@ import (
 	"errors"
 	"time"
 
+	"ftrack/database"
 	"ftrack/models"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -18,13 +19,13 @@ import (
 
 type UserRepository struct {
 	db         *mongo.Database
-	collection *mongo.Collection
+	collection *database.Collection
 }
 
 func NewUserRepository(db *mongo.Database) *UserRepository {
 	return &UserRepository{
 		db:         db,
-		collection: db.Collection("users"),
+		collection: database.NewCollection(db, "users"),
 	}
 }
 
@@ -477,6 +478,64 @@ func (ur *UserRepository) UpdatePassword(ctx context.Context, userID, hashedPass
 	return nil
 }
 
+// ActivateTwoFactor turns on 2FA with the user's pending secret. It fails
+// if the pending secret changed since it was read.
+func (ur *UserRepository) ActivateTwoFactor(ctx context.Context, userID, pendingSecret string, hashedBackupCodes []string) error {
+	objectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return errors.New("invalid user ID")
+	}
+
+	result, err := ur.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": objectID, "pendingTwoFactorSecret": pendingSecret},
+		bson.M{
+			"$set": bson.M{
+				"twoFactorEnabled": true,
+				"twoFactorSecret":  pendingSecret,
+				"backupCodes":      hashedBackupCodes,
+				"updatedAt":        time.Now(),
+			},
+			"$unset": bson.M{
+				"pendingTwoFactorSecret":    "",
+				"pendingTwoFactorExpiresAt": "",
+			},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return errors.New("no pending enrollment")
+	}
+
+	return nil
+}
+
+// ConsumeBackupCode removes a used backup code. It reports false if the code
+// was already used, so each code works only once even under concurrent logins.
+func (ur *UserRepository) ConsumeBackupCode(ctx context.Context, userID, hashedCode string) (bool, error) {
+	objectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return false, errors.New("invalid user ID")
+	}
+
+	result, err := ur.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": objectID, "backupCodes": hashedCode},
+		bson.M{
+			"$pull": bson.M{"backupCodes": hashedCode},
+			"$set":  bson.M{"updatedAt": time.Now()},
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount == 1, nil
+}
+
 func (ur *UserRepository) Delete(ctx context.Context, userID string) error {
 	objectID, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
@@ -634,6 +693,51 @@ func (ur *UserRepository) GetUsersByIDs(ctx context.Context, userIDs []string) (
 	return users, nil
 }
 
+// GetActiveUserIDsAfter returns the IDs of active, not deactivated users
+// after afterID in ID order, for walking through every user in batches
+func (ur *UserRepository) GetActiveUserIDsAfter(ctx context.Context, afterID string, limit int) ([]string, error) {
+	filter := bson.M{
+		"isActive":     true,
+		"deactivation": bson.M{"$exists": false},
+	}
+	if afterID != "" {
+		objectID, err := primitive.ObjectIDFromHex(afterID)
+		if err != nil {
+			return nil, errors.New("invalid user ID")
+		}
+		filter["_id"] = bson.M{"$gt": objectID}
+	}
+
+	opts := options.Find().
+		SetSort(bson.M{"_id": 1}).
+		SetLimit(int64(limit)).
+		SetProjection(bson.M{"_id": 1})
+
+	cursor, err := ur.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	userIDs := make([]string, 0, len(users))
+	for _, user := range users {
+		userIDs = append(userIDs, user.ID.Hex())
+	}
+	return userIDs, nil
+}
+
+func (ur *UserRepository) CountActiveUsers(ctx context.Context) (int64, error) {
+	return ur.collection.CountDocuments(ctx, bson.M{
+		"isActive":     true,
+		"deactivation": bson.M{"$exists": false},
+	})
+}
+
 // =============================================
 // DEVICE AND STATUS OPERATIONS
 // =============================================
@@ -915,6 +1019,16 @@ func (ur *UserRepository) GetUsersByRole(ctx context.Context, role string) ([]mo
 	return users, nil
 }
 
+// IsAdmin reports whether the user is an app admin, by flag or by role
+func (ur *UserRepository) IsAdmin(ctx context.Context, userID string) (bool, error) {
+	user, err := ur.GetByID(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+
+	return user.IsAdmin || user.Role == "admin" || user.Role == "superadmin", nil
+}
+
 func (ur *UserRepository) UpdateUserRole(ctx context.Context, userID, role string) error {
 	objectID, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
@@ -983,6 +1097,122 @@ func (ur *UserRepository) DeleteUnverifiedUsers(ctx context.Context, createdBefo
 	return result.DeletedCount, nil
 }
 
+// =============================================
+// ACCOUNT DEACTIVATION
+// =============================================
+
+// GetDeactivatedUserIDs returns which of the given users have deactivated
+// their account
+func (ur *UserRepository) GetDeactivatedUserIDs(ctx context.Context, userIDs []string) (map[string]bool, error) {
+	objectIDs := make([]primitive.ObjectID, 0, len(userIDs))
+	for _, id := range userIDs {
+		if objectID, err := primitive.ObjectIDFromHex(id); err == nil {
+			objectIDs = append(objectIDs, objectID)
+		}
+	}
+
+	deactivated := make(map[string]bool)
+	if len(objectIDs) == 0 {
+		return deactivated, nil
+	}
+
+	filter := bson.M{
+		"_id":          bson.M{"$in": objectIDs},
+		"deactivation": bson.M{"$exists": true},
+	}
+	opts := options.Find().SetProjection(bson.M{"_id": 1})
+
+	cursor, err := ur.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	for _, user := range users {
+		deactivated[user.ID.Hex()] = true
+	}
+	return deactivated, nil
+}
+
+// ClearDeactivation reactivates a self-deactivated account and restores its
+// location sharing setting
+func (ur *UserRepository) ClearDeactivation(ctx context.Context, userID string, locationSharing bool) error {
+	objectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return errors.New("invalid user ID")
+	}
+
+	result, err := ur.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": objectID, "deactivation": bson.M{"$exists": true}},
+		bson.M{
+			"$set": bson.M{
+				"isActive":                true,
+				"locationSharing.enabled": locationSharing,
+				"updatedAt":               time.Now(),
+			},
+			"$unset": bson.M{
+				"deactivation":  "",
+				"deactivatedAt": "",
+			},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return errors.New("user not found")
+	}
+
+	return nil
+}
+
+// GetDeactivationsDueWarning returns accounts deactivated before the cutoff
+// that have not been warned about deletion yet
+func (ur *UserRepository) GetDeactivationsDueWarning(ctx context.Context, deactivatedBefore time.Time, limit int) ([]models.User, error) {
+	filter := bson.M{
+		"deactivation.deactivatedAt": bson.M{"$lte": deactivatedBefore},
+		"deactivation.warningSentAt": bson.M{"$exists": false},
+	}
+
+	return ur.findDeactivations(ctx, filter, limit)
+}
+
+// GetDeactivationsDuePurge returns accounts deactivated before the cutoff
+// whose deletion warning went out before warnedBefore and that have not been
+// queued for deletion yet
+func (ur *UserRepository) GetDeactivationsDuePurge(ctx context.Context, deactivatedBefore, warnedBefore time.Time, limit int) ([]models.User, error) {
+	filter := bson.M{
+		"deactivation.deactivatedAt":    bson.M{"$lte": deactivatedBefore},
+		"deactivation.warningSentAt":    bson.M{"$lte": warnedBefore},
+		"deactivation.purgeRequestedAt": bson.M{"$exists": false},
+	}
+
+	return ur.findDeactivations(ctx, filter, limit)
+}
+
+func (ur *UserRepository) findDeactivations(ctx context.Context, filter bson.M, limit int) ([]models.User, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "deactivation.deactivatedAt", Value: 1}}).
+		SetLimit(int64(limit))
+
+	cursor, err := ur.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var users []models.User
+	err = cursor.All(ctx, &users)
+	return users, err
+}
+
 // =============================================
 // INDEXES
 // =============================================
@@ -4,6 +4,7 @@ package repositories
 import (
 	"context"
 	"errors"
+	"ftrack/database"
 	"ftrack/models"
 	"time"
 
@@ -14,12 +15,12 @@ import (
 )
 
 type UserSessionRepository struct {
-	collection *mongo.Collection
+	collection *database.Collection
 }
 
 func NewUserSessionRepository(db *mongo.Database) *UserSessionRepository {
 	return &UserSessionRepository{
-		collection: db.Collection("user_sessions"),
+		collection: database.NewCollection(db, "user_sessions"),
 	}
 }
 
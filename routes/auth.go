@@ -17,6 +17,7 @@ func SetupAuthRoutes(router *gin.RouterGroup, authController *controllers.AuthCo
 	// Public authentication endpoints
 	auth.POST("/register", authController.Register)
 	auth.POST("/login", authController.Login)
+	auth.POST("/login/mfa", authController.CompleteMFALogin)
 	auth.POST("/forgot-password", authController.ForgotPassword)
 	auth.POST("/reset-password", authController.ResetPassword)
 	auth.POST("/verify-email", authController.VerifyEmail)
@@ -43,6 +44,7 @@ func SetupAuthRoutes(router *gin.RouterGroup, authController *controllers.AuthCo
 	{
 		// Current user token operations
 		protected.POST("/validate", authController.ValidateToken)
+		protected.POST("/ws-ticket", authController.IssueWSTicket)
 		protected.POST("/logout-all", authController.LogoutAllDevices)
 		protected.GET("/sessions", authController.GetActiveSessions)
 		protected.DELETE("/sessions/:sessionId", authController.RevokeSession)
@@ -65,6 +67,18 @@ func SetupAuthRoutes(router *gin.RouterGroup, authController *controllers.AuthCo
 	}
 }
 
+// SetupMFARoutes configures multi-factor enrollment for the current user
+func SetupMFARoutes(router *gin.RouterGroup, authController *controllers.AuthController, authMiddleware *middleware.AuthMiddleware) {
+	mfa := router.Group("/users/me/mfa")
+	{
+		mfa.POST("/enroll", authController.EnrollMFA)
+		mfa.POST("/verify", authController.VerifyMFA)
+	}
+
+	// Turning MFA off needs a recent login or a current code
+	router.DELETE("/users/me/mfa", authMiddleware.RequireRecentAuth(15*time.Minute), authController.DisableMFA)
+}
+
 // In routes/auth.go
 func SetupAuthMiddleware(router *gin.RouterGroup, redis *redis.Client) {
 	// Rate limiting for authentication endpoints
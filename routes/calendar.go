@@ -0,0 +1,18 @@
+// routes/calendar.go
+package routes
+
+import (
+	"ftrack/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetupCalendarFeedRoutes configures managing the user's calendar feeds.
+// The feeds themselves are served at /calendar/:token.ics without a session.
+func SetupCalendarFeedRoutes(router *gin.RouterGroup, calendarFeedController *controllers.CalendarFeedController) {
+	feeds := router.Group("/users/me/calendar-feeds")
+
+	feeds.GET("", calendarFeedController.GetCalendarFeeds)
+	feeds.POST("", calendarFeedController.CreateCalendarFeed)
+	feeds.DELETE("/:feedId", calendarFeedController.RevokeCalendarFeed)
+}
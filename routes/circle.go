@@ -12,12 +12,18 @@ import (
 func SetupCircleRoutes(router *gin.RouterGroup, circleController *controllers.CircleController, redis *redis.Client) {
 	circles := router.Group("/circles")
 
+	// Every circle of the user summed up for the circles list, in one call
+	router.GET("/me/circles/overview", circleController.GetCirclesOverview)
+
 	// Circle CRUD operations
 	circles.GET("/", circleController.GetUserCircles)
 	circles.POST("/", circleController.CreateCircle)
 	circles.GET("/:circleId", circleController.GetCircle)
 	circles.PUT("/:circleId", circleController.UpdateCircle)
 	circles.DELETE("/:circleId", circleController.DeleteCircle)
+	circles.POST("/:circleId/transfer-ownership", circleController.TransferOwnership)
+	circles.POST("/:circleId/merge", circleController.MergeCircle)
+	circles.GET("/:circleId/merge/:jobId", circleController.GetMergeJob)
 
 	// Circle invitation and joining
 	invitations := circles.Group("/:circleId/invitations")
@@ -35,6 +41,7 @@ func SetupCircleRoutes(router *gin.RouterGroup, circleController *controllers.Ci
 	{
 		join.POST("/by-code", circleController.JoinByInviteCode)
 		join.POST("/by-invitation/:invitationId", circleController.JoinByInvitation)
+		join.POST("/by-link", circleController.JoinByInviteLink)
 		join.POST("/request/:circleId", circleController.RequestToJoin)
 	}
 
@@ -42,12 +49,15 @@ func SetupCircleRoutes(router *gin.RouterGroup, circleController *controllers.Ci
 	members := circles.Group("/:circleId/members")
 	{
 		members.GET("/", circleController.GetCircleMembers)
+		members.POST("/bulk", circleController.BulkAddMembers)
+		members.POST("/bulk/remove", circleController.BulkRemoveMembers)
 		members.GET("/:userId", circleController.GetCircleMember)
 		members.PUT("/:userId", circleController.UpdateCircleMember)
 		members.DELETE("/:userId", circleController.RemoveCircleMember)
 		members.POST("/:userId/promote", circleController.PromoteMember)
 		members.POST("/:userId/demote", circleController.DemoteMember)
 		members.PUT("/:userId/permissions", circleController.UpdateMemberPermissions)
+		members.PUT("/:userId/custom-role", circleController.AssignCircleRole)
 		members.GET("/:userId/activity", circleController.GetMemberActivity)
 	}
 
@@ -71,6 +81,8 @@ func SetupCircleRoutes(router *gin.RouterGroup, circleController *controllers.Ci
 		settings.PUT("/permissions", circleController.UpdatePermissionSettings)
 		settings.GET("/notifications", circleController.GetNotificationSettings)
 		settings.PUT("/notifications", circleController.UpdateNotificationSettings)
+		settings.GET("/nearby-alerts", circleController.GetNearbyAlertSettings)
+		settings.PUT("/nearby-alerts", circleController.UpdateNearbyAlertSettings)
 	}
 
 	// Circle activity and monitoring
@@ -105,6 +117,40 @@ func SetupCircleRoutes(router *gin.RouterGroup, circleController *controllers.Ci
 		places.GET("/:placeId/activity", circleController.GetPlaceActivity)
 	}
 
+	// Announcements sent to the members as notifications
+	announcements := circles.Group("/:circleId/announcements")
+	{
+		announcements.GET("/", circleController.GetAnnouncements)
+		announcements.POST("/", circleController.CreateAnnouncement)
+		announcements.GET("/:announcementId/stats", circleController.GetAnnouncementStats)
+	}
+
+	// Geotagged photos shown in the activity feed
+	moments := circles.Group("/:circleId/moments")
+	{
+		moments.POST("/", circleController.ShareMoment)
+		moments.DELETE("/:momentId", circleController.DeleteMoment)
+	}
+
+	// Custom roles the owner defines and assigns to members
+	roles := circles.Group("/:circleId/roles")
+	{
+		roles.GET("/", circleController.GetCircleRoles)
+		roles.POST("/", circleController.CreateCircleRole)
+		roles.PUT("/:roleId", circleController.UpdateCircleRole)
+		roles.DELETE("/:roleId", circleController.DeleteCircleRole)
+	}
+
+	// Albums of photos shared during visits to the circle's places
+	albums := circles.Group("/:circleId/albums")
+	{
+		albums.GET("/", circleController.GetAlbums)
+		albums.GET("/suggestions", circleController.GetAlbumSuggestions)
+		albums.GET("/:albumId", circleController.GetAlbum)
+		albums.POST("/:albumId/accept", circleController.AcceptAlbum)
+		albums.POST("/:albumId/dismiss", circleController.DismissAlbum)
+	}
+
 	// Circle communication
 	communication := circles.Group("/:circleId/communication")
 	{
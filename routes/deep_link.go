@@ -0,0 +1,16 @@
+// routes/deep_link.go
+package routes
+
+import (
+	"ftrack/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetupDeepLinkRoutes configures resolving the links notifications and
+// shares carry into the screen to open
+func SetupDeepLinkRoutes(router *gin.RouterGroup, deepLinkController *controllers.DeepLinkController) {
+	links := router.Group("/links")
+
+	links.GET("/resolve", deepLinkController.ResolveDeepLink)
+}
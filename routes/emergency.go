@@ -49,15 +49,11 @@ func SetupEmergencyRoutes(router *gin.RouterGroup, emergencyController *controll
 		crash.POST("/calibrate", emergencyController.CalibrateCrashDetection)
 	}
 
-	// Emergency contacts management
+	// Emergency contacts (managed under /users/me/emergency-contacts)
 	contacts := emergency.Group("/contacts")
 	{
 		contacts.GET("/", emergencyController.GetEmergencyContacts)
-		contacts.POST("/", emergencyController.AddEmergencyContact)
 		contacts.GET("/:contactId", emergencyController.GetEmergencyContact)
-		contacts.PUT("/:contactId", emergencyController.UpdateEmergencyContact)
-		contacts.DELETE("/:contactId", emergencyController.DeleteEmergencyContact)
-		contacts.POST("/:contactId/verify", emergencyController.VerifyEmergencyContact)
 		contacts.POST("/:contactId/notify", emergencyController.NotifyEmergencyContact)
 		contacts.GET("/:contactId/history", emergencyController.GetContactHistory)
 	}
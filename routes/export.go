@@ -0,0 +1,23 @@
+// routes/export.go
+package routes
+
+import (
+	"ftrack/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetupExportRoutes configures routes for managing background export jobs
+func SetupExportRoutes(router *gin.RouterGroup, exportController *controllers.ExportController) {
+	exports := router.Group("/exports")
+
+	exports.GET("/:exportId", exportController.GetExportStatus)
+	exports.DELETE("/:exportId", exportController.CancelExport)
+
+	// Who may export a circle, and members asking to
+	router.GET("/circles/:circleId/export-policy", exportController.GetExportPolicy)
+	router.PUT("/circles/:circleId/export-policy", exportController.UpdateExportPolicy)
+	router.POST("/circles/:circleId/export-requests", exportController.RequestExportPermission)
+	router.GET("/circles/:circleId/export-requests", exportController.GetExportRequests)
+	router.PUT("/circles/:circleId/export-requests/:requestId", exportController.ReviewExportRequest)
+}
@@ -0,0 +1,19 @@
+// routes/impersonation.go
+package routes
+
+import (
+	"ftrack/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetupImpersonationRoutes configures the user side of support
+// impersonation: seeing and answering requests to view the account. Admins
+// request and start sessions under /admin/impersonations.
+func SetupImpersonationRoutes(router *gin.RouterGroup, impersonationController *controllers.ImpersonationController) {
+	impersonations := router.Group("/users/me/impersonations")
+
+	impersonations.GET("", impersonationController.GetMyImpersonations)
+	impersonations.POST("/:sessionId/approve", impersonationController.ApproveMyImpersonation)
+	impersonations.POST("/:sessionId/deny", impersonationController.DenyMyImpersonation)
+}
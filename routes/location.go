@@ -74,6 +74,7 @@ func SetupLocationRoutes(router *gin.RouterGroup, locationController *controller
 		driving.GET("/score", locationController.GetDrivingScore)
 		driving.GET("/events", locationController.GetDrivingEvents)
 		driving.POST("/events", locationController.ReportDrivingEvent)
+		driving.GET("/safety/weekly", locationController.GetWeeklySafetyReport)
 	}
 
 	// Location analytics and statistics
@@ -87,6 +88,20 @@ func SetupLocationRoutes(router *gin.RouterGroup, locationController *controller
 		analytics.GET("/summary/:period", locationController.GetLocationSummary)
 	}
 
+	// Per-member heatmap, served under the circle's member routes
+	router.GET("/circles/:circleId/members/:userId/visit-heatmap", locationController.GetMemberVisitHeatmap)
+
+	// Trip safety a member chose to share with the circle, and their choice
+	router.GET("/circles/:circleId/members/:userId/driving-safety/weekly", locationController.GetMemberWeeklySafetyReport)
+	router.GET("/circles/:circleId/driving-safety/sharing", locationController.GetDrivingSafetySharing)
+	router.PUT("/circles/:circleId/driving-safety/sharing", locationController.UpdateDrivingSafetySharing)
+
+	// Latest location of every member, for loading the circle map
+	router.GET("/circles/:circleId/locations", locationController.GetCircleLocations)
+
+	// A member's history inside a map viewport
+	router.GET("/location-history", locationController.GetViewportHistory)
+
 	// Geofencing and place detection
 	geofencing := location.Group("/geofencing")
 	{
@@ -96,6 +111,22 @@ func SetupLocationRoutes(router *gin.RouterGroup, locationController *controller
 		geofencing.GET("/status", locationController.GetGeofenceStatus)
 	}
 
+	// Movement anomaly alerts
+	anomaly := location.Group("/anomaly")
+	{
+		anomaly.GET("/profiles/:userId", locationController.GetAnomalyProfile)
+		anomaly.PUT("/profiles/:userId", locationController.UpdateAnomalyProfile)
+		anomaly.GET("/alerts", locationController.GetAnomalyAlerts)
+		anomaly.GET("/alerts/:alertId", locationController.GetAnomalyAlert)
+		anomaly.POST("/alerts/:alertId/feedback", locationController.SubmitAnomalyFeedback)
+	}
+
+	// Spoofing heuristics, for circle admins
+	integrity := location.Group("/integrity")
+	{
+		integrity.GET("/circles/:circleId", locationController.GetCircleIntegrity)
+	}
+
 	// Location data management
 	data := location.Group("/data")
 	{
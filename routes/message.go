@@ -20,6 +20,10 @@ func SetupMessageRoutes(router *gin.RouterGroup, messageController *controllers.
 	messages.PUT("/:messageId", messageController.UpdateMessage)
 	messages.DELETE("/:messageId", messageController.DeleteMessage)
 
+	// Who may edit and delete messages in a circle
+	router.GET("/circles/:circleId/message-policy", messageController.GetMessagePolicy)
+	router.PUT("/circles/:circleId/message-policy", messageController.UpdateMessagePolicy)
+
 	// Message threading and replies
 	threading := messages.Group("/:messageId/replies")
 	{
@@ -36,6 +40,7 @@ func SetupMessageRoutes(router *gin.RouterGroup, messageController *controllers.
 		reactions.GET("/", messageController.GetReactions)
 		reactions.POST("/:emoji", messageController.AddReaction)
 		reactions.DELETE("/:emoji", messageController.RemoveReaction)
+		reactions.PUT("/:emoji/toggle", messageController.ToggleReaction)
 		reactions.GET("/users/:emoji", messageController.GetReactionUsers)
 	}
 
@@ -44,12 +49,17 @@ func SetupMessageRoutes(router *gin.RouterGroup, messageController *controllers.
 	media.Use(middleware.UploadRateLimit(redis))
 	{
 		media.POST("/upload", messageController.UploadMedia)
+		media.POST("/upload-intent", messageController.CreateUploadIntent)
+		media.POST("/upload-complete", messageController.CompleteUpload)
 		media.GET("/:mediaId", messageController.GetMedia)
 		media.DELETE("/:mediaId", messageController.DeleteMedia)
 		media.GET("/:mediaId/thumbnail", messageController.GetMediaThumbnail)
 		media.POST("/:mediaId/compress", messageController.CompressMedia)
 	}
 
+	// Media streaming is limited separately, since seeking sends many requests
+	messages.GET("/media/:mediaId/stream", middleware.MediaStreamRateLimit(redis), messageController.StreamMedia)
+
 	// Message search and filtering
 	search := messages.Group("/search")
 	{
@@ -66,6 +76,7 @@ func SetupMessageRoutes(router *gin.RouterGroup, messageController *controllers.
 	{
 		status.PUT("/:messageId/read", messageController.MarkAsRead)
 		status.PUT("/:messageId/unread", messageController.MarkAsUnread)
+		status.PUT("/:messageId/delivered", messageController.MarkAsDelivered)
 		status.PUT("/bulk/read", messageController.BulkMarkAsRead)
 		status.GET("/:messageId/delivery", messageController.GetDeliveryStatus)
 		status.GET("/:messageId/read-receipts", messageController.GetReadReceipts)
@@ -104,8 +115,10 @@ func SetupMessageRoutes(router *gin.RouterGroup, messageController *controllers.
 	backup := messages.Group("/backup")
 	{
 		backup.POST("/export/:circleId", messageController.ExportCircleMessages)
+		backup.POST("/export/:circleId/search", messageController.ExportSearchResults)
 		backup.GET("/export/status", messageController.GetExportStatus)
 		backup.GET("/export/:exportId/download", messageController.DownloadMessageExport)
+		backup.GET("/export/:exportId/manifest", messageController.DownloadMessageExportManifest)
 		backup.POST("/import", messageController.ImportMessages)
 	}
 
@@ -137,6 +150,9 @@ func SetupMessageRoutes(router *gin.RouterGroup, messageController *controllers.
 		analytics.GET("/popular", messageController.GetPopularMessages)
 	}
 
+	// Reaction highlights, served under the circle routes
+	router.GET("/circles/:circleId/reactions/stats", messageController.GetCircleReactionStats)
+
 	// Message automation and bots
 	automation := messages.Group("/automation")
 	{
@@ -14,6 +14,8 @@ func SetupNotificationRoutes(router *gin.RouterGroup, notificationController *co
 
 	// Basic notification operations
 	notifications.GET("/", notificationController.GetNotifications)
+	notifications.GET("/search", notificationController.SearchNotifications)
+	notifications.GET("/explain", notificationController.ExplainNotification)
 	notifications.GET("/:notificationId", notificationController.GetNotification)
 	notifications.PUT("/:notificationId/read", notificationController.MarkAsRead)
 	notifications.PUT("/:notificationId/unread", notificationController.MarkAsUnread)
@@ -26,8 +28,13 @@ func SetupNotificationRoutes(router *gin.RouterGroup, notificationController *co
 		bulk.PUT("/unread", notificationController.BulkMarkAsUnread)
 		bulk.DELETE("/", notificationController.BulkDeleteNotifications)
 		bulk.POST("/archive", notificationController.BulkArchiveNotifications)
+		bulk.POST("/delete-by-filter", notificationController.BulkDeleteByFilter)
+		bulk.POST("/archive-by-filter", notificationController.BulkArchiveByFilter)
 	}
 
+	// Sections of the inbox grouped by circle or type
+	notifications.PUT("/groups/read", notificationController.MarkGroupRead)
+
 	// Notification filtering and organization
 	filter := notifications.Group("/filter")
 	{
@@ -109,6 +116,7 @@ func SetupNotificationRoutes(router *gin.RouterGroup, notificationController *co
 	{
 		rules.GET("/", notificationController.GetNotificationRules)
 		rules.POST("/", notificationController.CreateNotificationRule)
+		rules.POST("/resolve", notificationController.ResolveDeliveryChannels)
 		rules.GET("/:ruleId", notificationController.GetNotificationRule)
 		rules.PUT("/:ruleId", notificationController.UpdateNotificationRule)
 		rules.DELETE("/:ruleId", notificationController.DeleteNotificationRule)
@@ -144,6 +152,7 @@ func SetupNotificationRoutes(router *gin.RouterGroup, notificationController *co
 		analytics.GET("/stats", notificationController.GetNotificationStats)
 		analytics.GET("/delivery", notificationController.GetDeliveryStats)
 		analytics.GET("/engagement", notificationController.GetEngagementStats)
+		analytics.GET("/send-time", notificationController.GetSendTimeProfile)
 		analytics.GET("/trends", notificationController.GetNotificationTrends)
 		analytics.GET("/performance", notificationController.GetNotificationPerformance)
 	}
@@ -20,6 +20,36 @@ func SetupPlaceRoutes(router *gin.RouterGroup, placeController *controllers.Plac
 	places.PUT("/:placeId", placeController.UpdatePlace)
 	places.DELETE("/:placeId", placeController.DeletePlace)
 
+	// Duplicate places of a circle, served under the circle's place routes
+	router.GET("/circles/:circleId/places/duplicates", placeController.GetPlaceDuplicates)
+	router.POST("/circles/:circleId/places/duplicates/merge", placeController.MergePlaces)
+
+	// Map clustering for place-dense circles
+	router.GET("/circles/:circleId/places/clusters", placeController.GetPlaceClusters)
+	places.GET("/clusters/:clusterId/members", placeController.GetPlaceClusterMembers)
+
+	// Change history of a place's geometry and settings
+	places.GET("/:placeId/versions", placeController.GetPlaceVersions)
+	places.POST("/:placeId/versions/:versionId/restore", placeController.RestorePlaceVersion)
+
+	// A member's private alias for a place, and their home
+	places.PUT("/:placeId/alias", placeController.SetPlaceAlias)
+	places.DELETE("/:placeId/alias", placeController.DeletePlaceAlias)
+
+	// A member's private favorite flag for a place
+	places.PUT("/:placeId/favorite", placeController.FavoritePlace)
+	places.DELETE("/:placeId/favorite", placeController.UnfavoritePlace)
+
+	// Places suggested from where the member often stays, private to them
+	places.GET("/suggestions", placeController.GetPlaceSuggestions)
+	places.POST("/suggestions/:suggestionId/accept", placeController.AcceptPlaceSuggestion)
+	places.POST("/suggestions/:suggestionId/dismiss", placeController.DismissPlaceSuggestion)
+
+	// Geofence settings a circle's places inherit
+	router.GET("/circles/:circleId/defaults/geofence", placeController.GetCircleGeofenceDefaults)
+	router.PUT("/circles/:circleId/defaults/geofence", placeController.UpdateCircleGeofenceDefaults)
+	router.POST("/circles/:circleId/defaults/geofence/reset", placeController.ResetPlacesToCircleDefaults)
+
 	// Place categories and organization
 	categories := places.Group("/categories")
 	{
@@ -30,6 +60,13 @@ func SetupPlaceRoutes(router *gin.RouterGroup, placeController *controllers.Plac
 		categories.GET("/:categoryId/places", placeController.GetPlacesByCategory)
 	}
 
+	// Free-form tags: the user's vocabulary, and renaming one everywhere
+	places.GET("/tags", placeController.GetPlaceTags)
+	places.PUT("/tags/:tag", placeController.RenamePlaceTag)
+
+	// Search-as-you-type, rate limited separately from the API budget
+	places.GET("/typeahead", middleware.TypeaheadRateLimit(redis), placeController.TypeaheadPlaces)
+
 	// Place search and discovery
 	search := places.Group("/search")
 	{
@@ -82,6 +119,9 @@ func SetupPlaceRoutes(router *gin.RouterGroup, placeController *controllers.Plac
 		visits.GET("/stats", placeController.GetVisitStats)
 	}
 
+	// Analytics reports of a single place, downloaded like other place exports
+	places.POST("/:placeId/analytics/export", placeController.ExportPlaceAnalytics)
+
 	// Place hours and availability
 	hours := places.Group("/:placeId/hours")
 	{
@@ -90,6 +130,16 @@ func SetupPlaceRoutes(router *gin.RouterGroup, placeController *controllers.Plac
 		hours.GET("/current", placeController.GetCurrentStatus)
 		hours.POST("/override", placeController.CreateHoursOverride)
 		hours.DELETE("/override/:overrideId", placeController.DeleteHoursOverride)
+		hours.POST("/recurring", placeController.CreateRecurringHoursOverride)
+	}
+
+	// Named holiday lists that places' recurring overrides can follow
+	holidaySets := places.Group("/holiday-sets")
+	{
+		holidaySets.GET("/", placeController.GetHolidaySets)
+		holidaySets.POST("/", placeController.CreateHolidaySet)
+		holidaySets.PUT("/:setId", placeController.UpdateHolidaySet)
+		holidaySets.DELETE("/:setId", placeController.DeleteHolidaySet)
 	}
 
 	// Place automation and rules
@@ -124,6 +174,7 @@ func SetupPlaceRoutes(router *gin.RouterGroup, placeController *controllers.Plac
 		reviews.DELETE("/:reviewId", placeController.DeletePlaceReview)
 		reviews.GET("/stats", placeController.GetReviewStats)
 		reviews.POST("/:reviewId/helpful", placeController.MarkReviewHelpful)
+		reviews.POST("/:reviewId/report", placeController.ReportPlaceReview)
 	}
 
 	// Place check-ins and social features
@@ -137,6 +188,25 @@ func SetupPlaceRoutes(router *gin.RouterGroup, placeController *controllers.Plac
 		checkins.GET("/leaderboard", placeController.GetCheckinLeaderboard)
 	}
 
+	// Predictive "time to leave" reminders, private to each user
+	departureReminders := places.Group("/reminders/departure")
+	{
+		departureReminders.GET("/", placeController.GetDepartureReminders)
+		departureReminders.POST("/", placeController.CreateDepartureReminder)
+		departureReminders.GET("/:reminderId", placeController.GetDepartureReminder)
+		departureReminders.PUT("/:reminderId", placeController.UpdateDepartureReminder)
+		departureReminders.DELETE("/:reminderId", placeController.DeleteDepartureReminder)
+		departureReminders.POST("/:reminderId/recompute", placeController.RecomputeDepartureReminder)
+	}
+
+	// "Remind me when I get there" reminders, private to each user
+	reminders := router.Group("/reminders")
+	{
+		reminders.GET("/", placeController.GetLocationReminders)
+		reminders.POST("/", placeController.CreateLocationReminder)
+		reminders.DELETE("/:reminderId", placeController.DeleteLocationReminder)
+	}
+
 	// Place recommendations and suggestions
 	recommendations := places.Group("/recommendations")
 	{
@@ -165,8 +235,10 @@ func SetupPlaceRoutes(router *gin.RouterGroup, placeController *controllers.Plac
 		data.POST("/import", placeController.ImportPlaces)
 		data.POST("/export", placeController.ExportPlaces)
 		data.GET("/export/:exportId/download", placeController.DownloadPlaceExport)
+		data.GET("/export/:exportId/manifest", placeController.DownloadPlaceExportManifest)
 		data.GET("/templates", placeController.GetImportTemplates)
 		data.POST("/bulk-create", placeController.BulkCreatePlaces)
+		data.POST("/bulk-update", placeController.BulkUpdatePlaces)
 	}
 
 	// Place templates and presets
@@ -178,6 +250,9 @@ func SetupPlaceRoutes(router *gin.RouterGroup, placeController *controllers.Plac
 		templates.PUT("/:templateId", placeController.UpdatePlaceTemplate)
 		templates.DELETE("/:templateId", placeController.DeletePlaceTemplate)
 		templates.POST("/:templateId/use", placeController.UsePlaceTemplate)
+		templates.POST("/:templateId/publish", placeController.PublishPlaceTemplate)
+		templates.DELETE("/:templateId/publish", placeController.UnpublishPlaceTemplate)
+		templates.GET("/gallery", placeController.GetTemplateGallery)
 	}
 
 	// Place statistics and analytics
@@ -0,0 +1,25 @@
+// routes/place_list.go
+package routes
+
+import (
+	"ftrack/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetupPlaceListRoutes configures the shared shopping and errand lists of
+// circles
+func SetupPlaceListRoutes(router *gin.RouterGroup, placeListController *controllers.PlaceListController) {
+	lists := router.Group("/circles/:circleId/lists")
+
+	lists.GET("", placeListController.GetPlaceLists)
+	lists.POST("", placeListController.CreatePlaceList)
+	lists.GET("/:listId", placeListController.GetPlaceList)
+	lists.PUT("/:listId", placeListController.UpdatePlaceList)
+	lists.DELETE("/:listId", placeListController.DeletePlaceList)
+
+	// Items
+	lists.POST("/:listId/items", placeListController.AddPlaceListItem)
+	lists.PUT("/:listId/items/:itemId", placeListController.UpdatePlaceListItem)
+	lists.DELETE("/:listId/items/:itemId", placeListController.RemovePlaceListItem)
+}
@@ -2,11 +2,14 @@
 package routes
 
 import (
+	"ftrack/config"
 	"ftrack/controllers"
 	"ftrack/middleware"
 	"ftrack/repositories"
 	"ftrack/services"
+	"ftrack/utils"
 	"ftrack/websocket"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
@@ -14,18 +17,22 @@ import (
 )
 
 // SetupRoutes initializes all application routes
-func SetupRoutes(db *mongo.Database, redis *redis.Client, hub *websocket.Hub) *gin.Engine {
+func SetupRoutes(cfg *config.Config, db *mongo.Database, redis *redis.Client, hub *websocket.Hub) *gin.Engine {
 	router := gin.New()
 
 	// Initialize repositories
 	repos := initializeRepositories(db)
 
 	// Initialize services
-	services := initializeServices(repos, redis, hub)
+	services := initializeServices(cfg, db, repos, redis, hub)
 
 	// Initialize controllers
 	controllers := initializeControllers(services, hub)
 
+	// Impersonation tokens are checked against their sessions
+	authMiddleware.ConfigureImpersonation(services.Impersonation)
+	authMiddleware.ConfigureSecondFactor(services.Auth)
+
 	// Global middleware
 	setupGlobalMiddleware(router, redis)
 
@@ -47,6 +54,26 @@ type Repositories struct {
 	Location     *repositories.LocationRepository
 	Notification *repositories.NotificationRepository
 	Place        *repositories.PlaceRepository
+	AuditLog     *repositories.AuditLogRepository
+	Export       *repositories.ExportRepository
+	Maintenance  *repositories.MaintenanceRepository
+	Block        *repositories.BlockRepository
+
+	DepartureReminder *repositories.DepartureReminderRepository
+	Session           *repositories.UserSessionRepository
+	Schedule          *repositories.ScheduleRepository
+	Automation        *repositories.AutomationRepository
+	Media             *repositories.MediaRepository
+	SigningKey        *repositories.SigningKeyRepository
+	Anomaly           *repositories.AnomalyRepository
+	LocationReminder  *repositories.LocationReminderRepository
+	Outbox            *repositories.OutboxRepository
+	Impersonation     *repositories.ImpersonationRepository
+	LocationIntegrity *repositories.LocationIntegrityRepository
+	Album             *repositories.AlbumRepository
+	CalendarFeed      *repositories.CalendarFeedRepository
+	MessageDelivery   *repositories.MessageDeliveryRepository
+	PlaceList         *repositories.PlaceListRepository
 }
 
 func initializeRepositories(db *mongo.Database) *Repositories {
@@ -58,6 +85,26 @@ func initializeRepositories(db *mongo.Database) *Repositories {
 		Location:     repositories.NewLocationRepository(db),
 		Notification: repositories.NewNotificationRepository(db),
 		Place:        repositories.NewPlaceRepository(db),
+		AuditLog:     repositories.NewAuditLogRepository(db),
+		Export:       repositories.NewExportRepository(db),
+		Maintenance:  repositories.NewMaintenanceRepository(db),
+		Block:        repositories.NewBlockRepository(db),
+
+		DepartureReminder: repositories.NewDepartureReminderRepository(db),
+		Session:           repositories.NewUserSessionRepository(db),
+		Schedule:          repositories.NewScheduleRepository(db),
+		Automation:        repositories.NewAutomationRepository(db),
+		Media:             repositories.NewMediaRepository(db),
+		SigningKey:        repositories.NewSigningKeyRepository(db),
+		Anomaly:           repositories.NewAnomalyRepository(db),
+		LocationReminder:  repositories.NewLocationReminderRepository(db),
+		Outbox:            repositories.NewOutboxRepository(db),
+		Impersonation:     repositories.NewImpersonationRepository(db),
+		LocationIntegrity: repositories.NewLocationIntegrityRepository(db),
+		Album:             repositories.NewAlbumRepository(db),
+		CalendarFeed:      repositories.NewCalendarFeedRepository(db),
+		MessageDelivery:   repositories.NewMessageDeliveryRepository(db),
+		PlaceList:         repositories.NewPlaceListRepository(db),
 	}
 }
 
@@ -71,21 +118,119 @@ type Services struct {
 	Location     *services.LocationService
 	Notification *services.NotificationService
 	Place        *services.PlaceService
+	Export       *services.ExportService
+	Maintenance  *services.MaintenanceService
+	Search       *services.SearchService
+
+	DepartureReminder   *services.DepartureReminderService
+	AccountDeactivation *services.AccountDeactivationService
+	PushAttachment      *services.PushAttachmentService
+	TrackingHint        *services.TrackingHintService
+	Anomaly             *services.AnomalyService
+	LocationReminder    *services.LocationReminderService
+	Outbox              *services.OutboxService
+	SMSCommand          *services.SMSCommandService
+	Impersonation       *services.ImpersonationService
+	LocationIntegrity   *services.LocationIntegrityService
+	CalendarFeed        *services.CalendarFeedService
+	DeepLink            *services.DeepLinkService
+	PlaceList           *services.PlaceListService
 }
 
-func initializeServices(repos *Repositories, redis *redis.Client, hub *websocket.Hub) *Services {
+func initializeServices(cfg *config.Config, db *mongo.Database, repos *Repositories, redis *redis.Client, hub *websocket.Hub) *Services {
 	authService := services.NewAuthService(repos.User, redis)
 	notificationService := services.NewNotificationService(repos.Notification, redis)
+	notificationService.ConfigureBatchWindows(cfg.NotificationBatchWindows)
+	emailService := cfg.InitEmailService()
+	smsService := services.NewSMSService(cfg.TwilioAccountSID, cfg.TwilioAuthToken, cfg.TwilioPhoneNumber, repos.Notification)
+	exportService := services.NewExportService(repos.Export, services.DefaultExportDir)
+	exportService.ConfigurePDF(services.PDFExportConfig{
+		PagesPerFile:     cfg.ExportPDFPagesPerFile,
+		FontPath:         cfg.ExportPDFFontPath,
+		FallbackFontPath: cfg.ExportPDFFallbackFont,
+	})
+	exportService.ConfigureCirclePermissions(repos.Circle)
+	placeService := services.NewPlaceService(repos.Place, repos.Circle, exportService)
+	placeService.ConfigureDuplicateDetection(float64(cfg.PlaceDuplicateDistance))
+	placeService.ConfigurePlaceTrends(
+		time.Duration(cfg.PlaceTrendingHalfLifeHours)*time.Hour,
+		time.Duration(cfg.PlacePopularHalfLifeHours)*time.Hour,
+	)
+	placeService.ConfigureRadiusBounds(services.RadiusBounds{Min: cfg.PlaceRadiusMin, Max: cfg.PlaceRadiusMax}, cfg.PlaceRadiusPlanBounds, repos.User)
+	deactivationService := services.NewAccountDeactivationService(repos.User, repos.Session, repos.Circle, repos.Location, repos.Schedule, repos.Automation, repos.Export, emailService)
+	authService.ConfigureReactivation(deactivationService)
+	jwtService := utils.NewJWTService(cfg.JWTSecret)
+	jwtService.AddVerificationSecrets(cfg.JWTPreviousSecrets)
+	jwtService.AcceptLegacyTokens(cfg.JWTLegacyTokensUntil)
+	authService.ConfigureSigningKeys(jwtService, repos.SigningKey)
+	authService.ConfigureLoginSecurity(services.LoginLockoutPolicy{
+		AccountThreshold: cfg.LoginLockoutAccountThreshold,
+		IPThreshold:      cfg.LoginLockoutIPThreshold,
+		FailureWindow:    time.Duration(cfg.LoginFailureWindow) * time.Minute,
+		BaseDelay:        time.Duration(cfg.LoginLockoutBaseDelay) * time.Second,
+		MaxDelay:         time.Duration(cfg.LoginLockoutMaxDelay) * time.Minute,
+	}, repos.AuditLog, notificationService)
+	mediaService := services.NewMediaService(cfg.MediaUploadPath, cfg.BaseURL)
+	mediaService.ConfigureDeduplication(repos.Media, cfg.MediaDedupEnabled)
+	trackingHintService := services.NewTrackingHintService(redis, repos.Location, repos.Place, hub, nil)
+	circleService := services.NewCircleService(repos.Circle, repos.User, repos.AuditLog, repos.Block, notificationService)
+	circleService.ConfigureTrackingHints(trackingHintService)
+	placeService.ConfigureCheckinNotifications(notificationService)
+	placeService.ConfigureCheckinMedia(repos.Media)
+	placeService.ConfigureSuggestionHistory(repos.Location)
+	circleService.ConfigureMerge(placeService, repos.Message, repos.Automation)
+	circleService.ConfigureAlbums(repos.Album, repos.Message, repos.Place)
+	circleService.ConfigureExports(exportService)
+	circleService.ConfigureInviteLinks(jwtService)
+	circleService.ConfigureOverview(repos.Message, repos.Emergency, redis)
+	circleService.ConfigureMoments(repos.Media, repos.Place)
+	locationService := services.NewLocationService(repos.Location, repos.Place, repos.Circle, hub)
+	locationService.ConfigureTrackingHints(trackingHintService)
+	locationReminderService := services.NewLocationReminderService(repos.LocationReminder, repos.Notification, placeService, notificationService)
+	locationService.ConfigureLocationReminders(locationReminderService)
+	outboxService := services.NewOutboxService(repos.Outbox, hub, notificationService)
+	circleService.ConfigureOutbox(outboxService)
+	locationService.ConfigureOutbox(outboxService)
+	locationIntegrityService := services.NewLocationIntegrityService(repos.LocationIntegrity, repos.Circle, repos.User, notificationService)
+	locationService.ConfigureLocationIntegrity(locationIntegrityService)
+	locationService.ConfigurePlaceOwnerVisits(placeService)
+	placeListService := services.NewPlaceListService(repos.PlaceList, repos.Circle, placeService, notificationService, hub, redis)
+	locationService.ConfigurePlaceLists(placeListService)
+	messageService := services.NewMessageService(repos.Message, repos.Circle, repos.User, hub)
+	messageService.ConfigureOutbox(outboxService)
+	messageService.ConfigureAlbums(repos.Album)
+	messageService.ConfigureMessageNotifications(notificationService, time.Duration(cfg.MessageNotificationWindow)*time.Second)
+	messageService.ConfigureDeliveryTracking(repos.MessageDelivery)
+	messageService.ConfigureDirectUploads(services.NewMediaUploadService(repos.Media, cfg.InitMediaObjectStore(), mediaService, time.Duration(cfg.MediaUploadIntentTTL)*time.Minute))
+	emergencyService := services.NewEmergencyService(repos.Emergency, repos.Circle, repos.User, notificationService, hub)
+	smsCommandService := services.NewSMSCommandService(smsService, repos.Notification, repos.User, repos.Circle, repos.Location, repos.AuditLog, locationService, placeService, emergencyService, redis, cfg.BaseURL+"/api/v1/sms/inbound")
 
 	return &Services{
 		Auth:         authService,
-		User:         services.NewUserService(repos.User),
-		Circle:       services.NewCircleService(repos.Circle, repos.User),
-		Message:      services.NewMessageService(repos.Message, repos.Circle, repos.User, hub),
-		Emergency:    services.NewEmergencyService(repos.Emergency, repos.Circle, repos.User, notificationService, hub),
-		Location:     services.NewLocationService(repos.Location, repos.Place, repos.Circle, hub),
+		User:         services.NewUserService(repos.User, repos.Emergency, repos.AuditLog, repos.Block, emailService, smsService, cfg.BaseURL),
+		Circle:       circleService,
+		Message:      messageService,
+		Emergency:    emergencyService,
+		Location:     locationService,
 		Notification: notificationService,
-		Place:        services.NewPlaceService(repos.Place, repos.Circle),
+		Place:        placeService,
+		Export:       exportService,
+		Maintenance:  services.NewMaintenanceService(repos.Maintenance, repos.Notification, redis),
+		Search:       services.NewSearchService(db),
+
+		DepartureReminder:   services.NewDepartureReminderService(repos.DepartureReminder, repos.Place, repos.Notification, placeService, notificationService),
+		AccountDeactivation: deactivationService,
+		PushAttachment:      services.NewPushAttachmentService(repos.User, repos.Place, repos.Media, mediaService, redis, cfg.StaticMapsURL),
+		TrackingHint:        trackingHintService,
+		Anomaly:             services.NewAnomalyService(repos.Anomaly, repos.Location, repos.Place, repos.Circle, repos.User, notificationService),
+		LocationReminder:    locationReminderService,
+		Outbox:              outboxService,
+		SMSCommand:          smsCommandService,
+		Impersonation:       services.NewImpersonationService(repos.Impersonation, repos.User, repos.AuditLog, notificationService, jwtService),
+		LocationIntegrity:   locationIntegrityService,
+		CalendarFeed:        services.NewCalendarFeedService(repos.CalendarFeed, repos.Circle, repos.Place, repos.Schedule, placeService, cfg.BaseURL),
+		DeepLink:            services.NewDeepLinkService(repos.Circle, repos.Message, placeService, cfg.BaseURL),
+		PlaceList:           placeListService,
 	}
 }
 
@@ -99,22 +244,40 @@ type Controllers struct {
 	Location     *controllers.LocationController
 	Notification *controllers.NotificationController
 	Place        *controllers.PlaceController
+	Export       *controllers.ExportController
+	Maintenance  *controllers.MaintenanceController
 	WebSocket    *controllers.WebSocketController
 	Health       *controllers.HealthController
+
+	PushAttachment *controllers.PushAttachmentController
+	SMSCommand     *controllers.SMSCommandController
+	Impersonation  *controllers.ImpersonationController
+	CalendarFeed   *controllers.CalendarFeedController
+	DeepLink       *controllers.DeepLinkController
+	PlaceList      *controllers.PlaceListController
 }
 
 func initializeControllers(services *Services, hub *websocket.Hub) *Controllers {
 	return &Controllers{
 		Auth:         controllers.NewAuthController(services.Auth),
-		User:         controllers.NewUserController(services.User),
+		User:         controllers.NewUserController(services.User, services.AccountDeactivation),
 		Circle:       controllers.NewCircleController(services.Circle),
 		Message:      controllers.NewMessageController(services.Message),
 		Emergency:    controllers.NewEmergencyController(services.Emergency),
-		Location:     controllers.NewLocationController(services.Location),
+		Location:     controllers.NewLocationController(services.Location, services.Anomaly, services.LocationIntegrity),
 		Notification: controllers.NewNotificationController(services.Notification),
-		Place:        controllers.NewPlaceController(services.Place),
+		Place:        controllers.NewPlaceController(services.Place, services.DepartureReminder, services.LocationReminder),
+		Export:       controllers.NewExportController(services.Export),
+		Maintenance:  controllers.NewMaintenanceController(services.Maintenance, services.Search),
 		WebSocket:    controllers.NewWebSocketController(hub, services.Auth),
 		Health:       controllers.NewHealthController(),
+
+		PushAttachment: controllers.NewPushAttachmentController(services.PushAttachment),
+		SMSCommand:     controllers.NewSMSCommandController(services.SMSCommand),
+		Impersonation:  controllers.NewImpersonationController(services.Impersonation),
+		CalendarFeed:   controllers.NewCalendarFeedController(services.CalendarFeed),
+		DeepLink:       controllers.NewDeepLinkController(services.DeepLink),
+		PlaceList:      controllers.NewPlaceListController(services.PlaceList),
 	}
 }
 
@@ -130,6 +293,10 @@ func setupGlobalMiddleware(router *gin.Engine, redis *redis.Client) {
 	router.Use(middleware.SecurityHSeaders())
 	router.Use(middleware.GlobalRateLimit(redis))
 
+	// Fail fast while MongoDB is down
+	router.Use(middleware.DatabaseCircuitBreaker())
+	router.Use(middleware.QueryTimeouts())
+
 	// Monitoring middleware
 	router.Use(middleware.Metrics())
 }
@@ -139,6 +306,7 @@ func setupPublicRoutes(router *gin.Engine, controllers *Controllers) {
 	// Health check
 	router.GET("/health", controllers.Health.HealthCheck)
 	router.GET("/health/detailed", controllers.Health.DetailedHealthCheck)
+	router.GET("/health/ready", controllers.Health.Readiness)
 
 	// API info
 	router.GET("/", controllers.Health.APIInfo)
@@ -152,6 +320,18 @@ func setupPublicRoutes(router *gin.Engine, controllers *Controllers) {
 	{
 		// Authentication routes
 		SetupAuthRoutes(public, controllers.Auth)
+
+		// Emergency contact confirmation links
+		public.GET("/emergency-contacts/respond", controllers.User.RespondToEmergencyContactRequest)
+
+		// Push notification images, authorized by a signed URL
+		public.GET("/push/attachments/:kind/:id", controllers.PushAttachment.GetAttachment)
+
+		// Texts to the Twilio number, authorized by the request signature
+		public.POST("/sms/inbound", controllers.SMSCommand.HandleInboundSMS)
+
+		// Calendar feeds, authorized by the token in the link
+		public.GET("/calendar/:feed", controllers.CalendarFeed.GetCalendarFeed)
 	}
 }
 
@@ -162,13 +342,20 @@ func setupAuthenticatedRoutes(router *gin.Engine, controllers *Controllers, redi
 	api.Use(middleware.APIRateLimit(redis))
 
 	// Setup all authenticated route groups
-	SetupUserRoutes(api, controllers.User, redis)
+	SetupUserRoutes(api, controllers.User, authMiddleware, redis)
+	SetupMFARoutes(api, controllers.Auth, authMiddleware)
 	SetupCircleRoutes(api, controllers.Circle, redis)
 	SetupMessageRoutes(api, controllers.Message, redis)
 	SetupEmergencyRoutes(api, controllers.Emergency, redis)
 	SetupLocationRoutes(api, controllers.Location, redis)
 	SetupNotificationRoutes(api, controllers.Notification, redis)
 	SetupPlaceRoutes(api, controllers.Place, redis)
+	SetupExportRoutes(api, controllers.Export)
+	SetupSMSCommandRoutes(api, controllers.SMSCommand)
+	SetupImpersonationRoutes(api, controllers.Impersonation)
+	SetupCalendarFeedRoutes(api, controllers.CalendarFeed)
+	SetupDeepLinkRoutes(api, controllers.DeepLink)
+	SetupPlaceListRoutes(api, controllers.PlaceList)
 }
 
 // Admin routes (requires admin privileges)
@@ -183,6 +370,8 @@ func setupAdminRoutes(router *gin.Engine, controllers *Controllers, redis *redis
 	admin.GET("/users/:id", controllers.User.GetUserByID)
 	admin.PUT("/users/:id/status", controllers.User.UpdateUserStatus)
 	admin.DELETE("/users/:id", controllers.User.DeleteUser)
+	admin.POST("/users/:id/unlock", controllers.Auth.UnlockUserAccount)
+	admin.PUT("/users/:id/password-rotation", controllers.Auth.SetPasswordRotation)
 
 	admin.GET("/circles", controllers.Circle.GetAllCircles)
 	admin.GET("/circles/:id", controllers.Circle.GetCircleByID)
@@ -193,6 +382,45 @@ func setupAdminRoutes(router *gin.Engine, controllers *Controllers, redis *redis
 
 	admin.GET("/metrics", controllers.Health.Metrics)
 	admin.GET("/stats", controllers.Health.SystemStats)
+	admin.GET("/workers", controllers.Health.GetWorkers)
+
+	admin.POST("/maintenance/reconcile", controllers.Maintenance.TriggerReconcile)
+	admin.GET("/maintenance/reconcile/:runId", controllers.Maintenance.GetReconcileRun)
+	admin.GET("/maintenance/drift", controllers.Maintenance.GetDriftStats)
+	admin.GET("/maintenance/search-index", controllers.Maintenance.GetSearchIndexStatus)
+	admin.POST("/maintenance/search-index/rebuild", controllers.Maintenance.TriggerSearchIndexRebuild)
+	admin.GET("/maintenance/search-index/builds/:buildId", controllers.Maintenance.GetSearchIndexBuild)
+	admin.POST("/maintenance/message-encryption", controllers.Maintenance.TriggerMessageEncryption)
+	admin.GET("/maintenance/message-encryption/:runId", controllers.Maintenance.GetMessageEncryptionRun)
+
+	admin.GET("/auth/signing-keys", controllers.Auth.GetSigningKeys)
+	admin.POST("/auth/signing-keys/rotate", controllers.Auth.RotateSigningKey)
+
+	admin.POST("/notifications/broadcast", controllers.Notification.CreateBroadcast)
+	admin.GET("/notifications/broadcast/:broadcastId", controllers.Notification.GetBroadcast)
+	admin.GET("/notifications/broadcast/:broadcastId/deliveries", controllers.Notification.GetBroadcastDeliveries)
+	admin.POST("/notifications/broadcast/:broadcastId/cancel", controllers.Notification.CancelBroadcast)
+
+	// Content experiments on system notification templates
+	admin.POST("/notifications/experiments", controllers.Notification.CreateNotificationExperiment)
+	admin.GET("/notifications/experiments", controllers.Notification.GetNotificationExperiments)
+	admin.GET("/notifications/experiments/:experimentId", controllers.Notification.GetNotificationExperiment)
+	admin.GET("/notifications/experiments/:experimentId/results", controllers.Notification.GetNotificationExperimentResults)
+	admin.POST("/notifications/experiments/:experimentId/end", controllers.Notification.EndNotificationExperiment)
+
+	admin.GET("/place-templates", controllers.Place.GetTemplatesForModeration)
+	admin.PUT("/place-templates/:templateId/moderation", controllers.Place.ModeratePlaceTemplate)
+
+	admin.GET("/place-reviews/reports", controllers.Place.GetReviewReports)
+	admin.PUT("/place-reviews/reports/:reportId", controllers.Place.HandleReviewReport)
+
+	// Viewing the app as a user, with their consent or a second admin's
+	// approval
+	admin.POST("/impersonations", authMiddleware.RequireRecentAuth(15*time.Minute), controllers.Impersonation.RequestImpersonation)
+	admin.GET("/impersonations/:sessionId", controllers.Impersonation.GetImpersonation)
+	admin.POST("/impersonations/:sessionId/approve", authMiddleware.RequireRecentAuth(15*time.Minute), controllers.Impersonation.ApproveImpersonation)
+	admin.POST("/impersonations/:sessionId/start", authMiddleware.RequireRecentAuth(15*time.Minute), controllers.Impersonation.StartImpersonation)
+	admin.POST("/impersonations/:sessionId/end", controllers.Impersonation.EndImpersonation)
 }
 
 // WebSocket routes
@@ -0,0 +1,18 @@
+// routes/sms.go
+package routes
+
+import (
+	"ftrack/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetupSMSCommandRoutes configures the app side of SMS commands: opting a
+// verified number in and out. Texts arrive on the public webhook.
+func SetupSMSCommandRoutes(router *gin.RouterGroup, smsCommandController *controllers.SMSCommandController) {
+	commands := router.Group("/sms/commands")
+
+	commands.GET("", smsCommandController.GetSMSCommandStatus)
+	commands.POST("/opt-in", smsCommandController.EnableSMSCommands)
+	commands.DELETE("/opt-in", smsCommandController.DisableSMSCommands)
+}
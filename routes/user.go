@@ -4,19 +4,21 @@ package routes
 import (
 	"ftrack/controllers"
 	"ftrack/middleware"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
 )
 
 // SetupUserRoutes configures user-related routes
-func SetupUserRoutes(router *gin.RouterGroup, userController *controllers.UserController, redis *redis.Client) {
+func SetupUserRoutes(router *gin.RouterGroup, userController *controllers.UserController, authMiddleware *middleware.AuthMiddleware, redis *redis.Client) {
 	users := router.Group("/users")
 
 	// Current user endpoints
 	users.GET("/me", userController.GetCurrentUser)
 	users.PUT("/me", userController.UpdateCurrentUser)
 	users.DELETE("/me", userController.DeleteCurrentUser)
+	users.POST("/me/deactivate", authMiddleware.RequireRecentAuth(15*time.Minute), userController.DeactivateCurrentUser)
 	users.GET("/me/profile", userController.GetProfile)
 	users.PUT("/me/profile", userController.UpdateProfile)
 
@@ -44,13 +46,14 @@ func SetupUserRoutes(router *gin.RouterGroup, userController *controllers.UserCo
 		settings.PUT("/driving", userController.UpdateDrivingSettings)
 	}
 
-	// Emergency contacts
+	// Emergency contacts (changes require a recently authenticated session)
 	emergency := users.Group("/me/emergency-contacts")
+	recentAuth := authMiddleware.RequireRecentAuth(15 * time.Minute)
 	{
 		emergency.GET("/", userController.GetEmergencyContacts)
-		emergency.POST("/", userController.AddEmergencyContact)
-		emergency.PUT("/:contactId", userController.UpdateEmergencyContact)
-		emergency.DELETE("/:contactId", userController.DeleteEmergencyContact)
+		emergency.POST("/", recentAuth, userController.AddEmergencyContact)
+		emergency.PUT("/:contactId", recentAuth, userController.UpdateEmergencyContact)
+		emergency.DELETE("/:contactId", recentAuth, userController.DeleteEmergencyContact)
 		emergency.POST("/:contactId/verify", userController.VerifyEmergencyContact)
 	}
 
@@ -70,6 +73,8 @@ func SetupUserRoutes(router *gin.RouterGroup, userController *controllers.UserCo
 		social.GET("/search", userController.SearchUsers)
 		social.GET("/:userId", userController.GetUserByID)
 		social.GET("/:userId/profile", userController.GetPublicProfile)
+		social.POST("/:userId/block", userController.BlockUser)
+		social.DELETE("/:userId/block", userController.UnblockUser)
 
 		// Friend requests (if implemented)
 		social.POST("/:userId/friend-request", userController.SendFriendRequest)
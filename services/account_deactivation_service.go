@@ -0,0 +1,284 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"ftrack/models"
+	"ftrack/repositories"
+	"ftrack/utils"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Deactivated accounts are kept for DefaultDeactivationRetention, with a
+// warning email DefaultDeactivationWarningPeriod before they are queued for
+// deletion
+const (
+	DefaultDeactivationRetention     = 365 * 24 * time.Hour
+	DefaultDeactivationWarningPeriod = 30 * 24 * time.Hour
+)
+
+// OwnershipTransferRequiredError reads "ownership transfer required" and
+// lists the circles that would be left without an admin
+type OwnershipTransferRequiredError struct {
+	Circles []models.SoleAdminCircle `json:"circles"`
+}
+
+func (e OwnershipTransferRequiredError) Error() string {
+	return "ownership transfer required"
+}
+
+type AccountDeactivationService struct {
+	userRepo       *repositories.UserRepository
+	sessionRepo    *repositories.UserSessionRepository
+	circleRepo     *repositories.CircleRepository
+	locationRepo   *repositories.LocationRepository
+	scheduleRepo   *repositories.ScheduleRepository
+	automationRepo *repositories.AutomationRepository
+	exportRepo     *repositories.ExportRepository
+	emailService   EmailService
+	validator      *utils.ValidationService
+}
+
+func NewAccountDeactivationService(
+	userRepo *repositories.UserRepository,
+	sessionRepo *repositories.UserSessionRepository,
+	circleRepo *repositories.CircleRepository,
+	locationRepo *repositories.LocationRepository,
+	scheduleRepo *repositories.ScheduleRepository,
+	automationRepo *repositories.AutomationRepository,
+	exportRepo *repositories.ExportRepository,
+	emailService EmailService,
+) *AccountDeactivationService {
+	return &AccountDeactivationService{
+		userRepo:       userRepo,
+		sessionRepo:    sessionRepo,
+		circleRepo:     circleRepo,
+		locationRepo:   locationRepo,
+		scheduleRepo:   scheduleRepo,
+		automationRepo: automationRepo,
+		exportRepo:     exportRepo,
+		emailService:   emailService,
+		validator:      utils.NewValidationService(),
+	}
+}
+
+// DeactivateAccount hides the user from their circles and stops all activity
+// on the account until they log in again and confirm reactivation
+func (ds *AccountDeactivationService) DeactivateAccount(ctx context.Context, userID string, req models.DeactivateAccountRequest) error {
+	if validationErrors := ds.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	user, err := ds.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.Deactivation != nil {
+		return errors.New("account already deactivated")
+	}
+
+	soleAdminCircles, err := ds.getSoleAdminCircles(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if len(soleAdminCircles) > 0 {
+		return OwnershipTransferRequiredError{Circles: soleAdminCircles}
+	}
+
+	now := time.Now()
+	err = ds.userRepo.Update(ctx, userID, bson.M{
+		"isActive":      false,
+		"isOnline":      false,
+		"deactivatedAt": now,
+		"deactivation": models.AccountDeactivation{
+			Reason:                 req.Reason,
+			DeactivatedAt:          now,
+			LocationSharingEnabled: user.LocationSharing.Enabled,
+		},
+		"locationSharing.enabled": false,
+	})
+	if err != nil {
+		return err
+	}
+
+	// The account is already locked out at this point, so the remaining
+	// steps are best effort and undone again on reactivation
+	if err := ds.sessionRepo.InvalidateAllUserSessions(ctx, user.ID); err != nil {
+		logrus.Warnf("Failed to revoke sessions for deactivated user %s: %v", userID, err)
+	}
+
+	if _, err := ds.circleRepo.SetMemberStatusEverywhere(ctx, userID, "active", "deactivated"); err != nil {
+		logrus.Warnf("Failed to mark user %s as deactivated in circles: %v", userID, err)
+	}
+
+	if _, err := ds.locationRepo.StopTemporaryShares(ctx, userID); err != nil {
+		logrus.Warnf("Failed to stop temporary shares for user %s: %v", userID, err)
+	}
+
+	if _, err := ds.scheduleRepo.PauseUserMessages(ctx, userID); err != nil {
+		logrus.Warnf("Failed to pause scheduled messages for user %s: %v", userID, err)
+	}
+
+	if _, err := ds.automationRepo.PauseUserRules(ctx, userID); err != nil {
+		logrus.Warnf("Failed to pause automation rules for user %s: %v", userID, err)
+	}
+
+	logrus.Infof("User %s deactivated their account", userID)
+	return nil
+}
+
+// ReactivateAccount restores a deactivated account to where it was before
+// deactivation. The user is updated in place.
+func (ds *AccountDeactivationService) ReactivateAccount(ctx context.Context, user *models.User) error {
+	if user.Deactivation == nil {
+		return nil
+	}
+
+	userID := user.ID.Hex()
+	locationSharing := user.Deactivation.LocationSharingEnabled
+
+	if err := ds.userRepo.ClearDeactivation(ctx, userID, locationSharing); err != nil {
+		return err
+	}
+
+	if _, err := ds.circleRepo.SetMemberStatusEverywhere(ctx, userID, "deactivated", "active"); err != nil {
+		logrus.Warnf("Failed to restore circle memberships for user %s: %v", userID, err)
+	}
+
+	if _, err := ds.scheduleRepo.ResumeUserMessages(ctx, userID); err != nil {
+		logrus.Warnf("Failed to resume scheduled messages for user %s: %v", userID, err)
+	}
+
+	if _, err := ds.automationRepo.ResumeUserRules(ctx, userID); err != nil {
+		logrus.Warnf("Failed to resume automation rules for user %s: %v", userID, err)
+	}
+
+	if user.Deactivation.PurgeRequestedAt != nil {
+		if _, err := ds.exportRepo.CancelPendingPurgeRequests(ctx, userID); err != nil {
+			logrus.Warnf("Failed to cancel purge request for user %s: %v", userID, err)
+		}
+	}
+
+	user.IsActive = true
+	user.LocationSharing.Enabled = locationSharing
+	user.Deactivation = nil
+	user.DeactivatedAt = nil
+
+	logrus.Infof("User %s reactivated their account", userID)
+	return nil
+}
+
+// ProcessStaleDeactivations warns users whose account has been deactivated
+// for almost the retention period, and queues accounts past it for deletion
+// once the warning period has run out
+func (ds *AccountDeactivationService) ProcessStaleDeactivations(ctx context.Context, retention, warningPeriod time.Duration, limit int) (warned int, queued int, err error) {
+	now := time.Now()
+
+	dueWarning, err := ds.userRepo.GetDeactivationsDueWarning(ctx, now.Add(warningPeriod-retention), limit)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for i := range dueWarning {
+		user := &dueWarning[i]
+		deletionDate := user.Deactivation.DeactivatedAt.Add(retention)
+		if earliest := now.Add(warningPeriod); deletionDate.Before(earliest) {
+			deletionDate = earliest
+		}
+
+		if err := ds.sendDeletionWarning(user, deletionDate); err != nil {
+			logrus.Errorf("Failed to send deletion warning to user %s: %v", user.ID.Hex(), err)
+			continue
+		}
+
+		if err := ds.userRepo.Update(ctx, user.ID.Hex(), bson.M{"deactivation.warningSentAt": now}); err != nil {
+			logrus.Errorf("Failed to record deletion warning for user %s: %v", user.ID.Hex(), err)
+			continue
+		}
+		warned++
+	}
+
+	duePurge, err := ds.userRepo.GetDeactivationsDuePurge(ctx, now.Add(-retention), now.Add(-warningPeriod), limit)
+	if err != nil {
+		return warned, 0, err
+	}
+
+	for _, user := range duePurge {
+		err := ds.exportRepo.CreatePurgeRequest(ctx, &models.DataPurgeRequest{
+			UserID:      user.ID,
+			Reason:      fmt.Sprintf("account deactivated for more than %d days", int(retention.Hours()/24)),
+			DataTypes:   []string{"all"},
+			Status:      "pending",
+			ScheduledAt: now,
+		})
+		if err != nil {
+			logrus.Errorf("Failed to queue deletion of user %s: %v", user.ID.Hex(), err)
+			continue
+		}
+
+		if err := ds.userRepo.Update(ctx, user.ID.Hex(), bson.M{"deactivation.purgeRequestedAt": now}); err != nil {
+			logrus.Errorf("Failed to record deletion request for user %s: %v", user.ID.Hex(), err)
+			continue
+		}
+		queued++
+	}
+
+	return warned, queued, nil
+}
+
+// getSoleAdminCircles returns the circles where the user is the only active
+// admin and other active members would be left behind
+func (ds *AccountDeactivationService) getSoleAdminCircles(ctx context.Context, userID string) ([]models.SoleAdminCircle, error) {
+	circles, err := ds.circleRepo.GetUserCircles(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	soleAdminCircles := []models.SoleAdminCircle{}
+	for _, circle := range circles {
+		isAdmin, otherAdmins, otherMembers := false, 0, 0
+		for _, member := range circle.Members {
+			if member.UserID.Hex() == userID {
+				isAdmin = member.Role == "admin"
+				continue
+			}
+			if member.Status != "active" {
+				continue
+			}
+			otherMembers++
+			if member.Role == "admin" {
+				otherAdmins++
+			}
+		}
+
+		if isAdmin && otherAdmins == 0 && otherMembers > 0 {
+			soleAdminCircles = append(soleAdminCircles, models.SoleAdminCircle{
+				ID:   circle.ID,
+				Name: circle.Name,
+			})
+		}
+	}
+
+	return soleAdminCircles, nil
+}
+
+func (ds *AccountDeactivationService) sendDeletionWarning(user *models.User, deletionDate time.Time) error {
+	if ds.emailService == nil || user.Email == "" {
+		return nil
+	}
+
+	return ds.emailService.SendEmail(EmailData{
+		To:       user.Email,
+		Subject:  "Your deactivated account will be deleted - FTrack",
+		Template: "account_deletion_warning",
+		Data: map[string]interface{}{
+			"Name":         user.FirstName,
+			"DeletionDate": deletionDate.Format("January 2, 2006"),
+		},
+	})
+}
@@ -0,0 +1,793 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"ftrack/models"
+	"ftrack/repositories"
+	"ftrack/utils"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultAnomalyCooldownMinutes = 60
+
+	// no_movement defaults: four quiet daytime hours
+	defaultAnomalyWindowMinutes  = 240
+	defaultAnomalyMovementMeters = 100
+
+	defaultAnomalyBufferMeters = 100
+
+	// Profiles that haven't been evaluated for longer than this, e.g. while
+	// the worker was down, only look this far back
+	anomalyMaxLookback = 24 * time.Hour
+
+	// Locations read per rule and evaluation
+	anomalyMaxLocations = 1000
+
+	// Feedback never narrows an unusual hours window below this
+	anomalyMinWindowMinutes = 60
+)
+
+var (
+	defaultAnomalyActiveHours  = models.AnomalyTimeWindow{Start: "08:00", End: "20:00"}
+	defaultAnomalyUnusualHours = models.AnomalyTimeWindow{Start: "23:00", End: "05:00"}
+)
+
+// AnomalyService raises alerts when a member's movement breaks the rules of
+// their anomaly profile, and sends them to the profile's watchers
+type AnomalyService struct {
+	anomalyRepo         *repositories.AnomalyRepository
+	locationRepo        *repositories.LocationRepository
+	placeRepo           *repositories.PlaceRepository
+	circleRepo          *repositories.CircleRepository
+	userRepo            *repositories.UserRepository
+	notificationService *NotificationService
+	validator           *utils.ValidationService
+}
+
+func NewAnomalyService(
+	anomalyRepo *repositories.AnomalyRepository,
+	locationRepo *repositories.LocationRepository,
+	placeRepo *repositories.PlaceRepository,
+	circleRepo *repositories.CircleRepository,
+	userRepo *repositories.UserRepository,
+	notificationService *NotificationService,
+) *AnomalyService {
+	return &AnomalyService{
+		anomalyRepo:         anomalyRepo,
+		locationRepo:        locationRepo,
+		placeRepo:           placeRepo,
+		circleRepo:          circleRepo,
+		userRepo:            userRepo,
+		notificationService: notificationService,
+		validator:           utils.NewValidationService(),
+	}
+}
+
+// anomalyAccess is what one user may do with a member's anomaly profile
+type anomalyAccess struct {
+	canManage bool
+	mates     map[string]bool
+}
+
+// memberAccess works out whether the actor manages the member's profile.
+// Members manage their own unless they are supervised in any circle; then
+// the admins of those circles do.
+func (as *AnomalyService) memberAccess(ctx context.Context, actorID, memberID string) (*anomalyAccess, error) {
+	circles, err := as.circleRepo.GetUserCircles(ctx, memberID)
+	if err != nil {
+		return nil, err
+	}
+
+	access := &anomalyAccess{mates: make(map[string]bool)}
+	supervised := false
+	for i := range circles {
+		member := findCircleMember(&circles[i], memberID)
+		if member == nil || member.Status != "active" {
+			continue
+		}
+
+		for _, other := range circles[i].Members {
+			if other.Status == "active" && other.UserID.Hex() != memberID {
+				access.mates[other.UserID.Hex()] = true
+			}
+		}
+
+		if !member.Permissions.Supervised {
+			continue
+		}
+		supervised = true
+
+		actor := findCircleMember(&circles[i], actorID)
+		if actor != nil && actor.Status == "active" && actor.Role == "admin" && actorID != memberID {
+			access.canManage = true
+		}
+	}
+
+	if actorID == memberID && !supervised {
+		access.canManage = true
+	}
+
+	return access, nil
+}
+
+func (as *AnomalyService) GetProfile(ctx context.Context, actorID, memberID string) (*models.AnomalyProfile, error) {
+	access, err := as.memberAccess(ctx, actorID, memberID)
+	if err != nil {
+		return nil, err
+	}
+	if !access.canManage {
+		return nil, errors.New("access denied")
+	}
+
+	return as.anomalyRepo.GetProfile(ctx, memberID)
+}
+
+// UpdateProfile replaces the member's rules and watchers. Watchers must
+// share an active circle with the member.
+func (as *AnomalyService) UpdateProfile(ctx context.Context, actorID, memberID string, req models.UpdateAnomalyProfileRequest) (*models.AnomalyProfile, error) {
+	if validationErrors := as.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	memberObjectID, err := primitive.ObjectIDFromHex(memberID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	access, err := as.memberAccess(ctx, actorID, memberID)
+	if err != nil {
+		return nil, err
+	}
+	if !access.canManage {
+		return nil, errors.New("access denied")
+	}
+
+	timezone := req.Timezone
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(timezone); err != nil {
+		return nil, errors.New("invalid timezone")
+	}
+
+	seen := make(map[string]bool)
+	watchers := []primitive.ObjectID{}
+	for _, watcherID := range req.Watchers {
+		if !access.mates[watcherID] {
+			return nil, utils.NewValidationFailedError("watchers must share a circle with the member")
+		}
+		if seen[watcherID] {
+			continue
+		}
+		seen[watcherID] = true
+		watchers = append(watchers, utils.ObjectIDFromHex(watcherID))
+	}
+	if req.Enabled && len(watchers) == 0 {
+		return nil, utils.NewValidationFailedError("an enabled profile needs at least one watcher")
+	}
+
+	// Rules that are kept keep their cooldown and feedback state
+	existingRules := make(map[string]models.AnomalyRule)
+	if existing, err := as.anomalyRepo.GetProfile(ctx, memberID); err == nil {
+		for _, rule := range existing.Rules {
+			existingRules[rule.ID.Hex()] = rule
+		}
+	} else if err.Error() != "anomaly profile not found" {
+		return nil, err
+	}
+
+	rules := make([]models.AnomalyRule, 0, len(req.Rules))
+	for _, ruleReq := range req.Rules {
+		rule, err := as.buildRule(ctx, memberObjectID, ruleReq, existingRules)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	profile := &models.AnomalyProfile{
+		UserID:    memberObjectID,
+		Enabled:   req.Enabled,
+		Timezone:  timezone,
+		Watchers:  watchers,
+		Rules:     rules,
+		UpdatedBy: utils.ObjectIDFromHex(actorID),
+	}
+
+	if err := as.anomalyRepo.SaveProfile(ctx, profile); err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("Anomaly profile of user %s updated by %s", memberID, actorID)
+	return profile, nil
+}
+
+func (as *AnomalyService) buildRule(ctx context.Context, memberID primitive.ObjectID, req models.AnomalyRuleRequest, existingRules map[string]models.AnomalyRule) (models.AnomalyRule, error) {
+	rule := models.AnomalyRule{ID: primitive.NewObjectID()}
+	if existing, ok := existingRules[req.ID]; ok && existing.Type == req.Type {
+		rule = existing
+	}
+
+	rule.Type = req.Type
+	rule.Enabled = req.Enabled == nil || *req.Enabled
+	rule.CooldownMinutes = req.CooldownMinutes
+	if rule.CooldownMinutes == 0 {
+		rule.CooldownMinutes = defaultAnomalyCooldownMinutes
+	}
+
+	switch req.Type {
+	case models.AnomalyRuleNoMovement:
+		rule.WindowMinutes = req.WindowMinutes
+		if rule.WindowMinutes == 0 {
+			rule.WindowMinutes = defaultAnomalyWindowMinutes
+		}
+		rule.MovementMeters = req.MovementMeters
+		if rule.MovementMeters == 0 {
+			rule.MovementMeters = defaultAnomalyMovementMeters
+		}
+		rule.ActiveHours = req.ActiveHours
+		if rule.ActiveHours == nil {
+			activeHours := defaultAnomalyActiveHours
+			rule.ActiveHours = &activeHours
+		}
+		if err := validateAnomalyWindow(*rule.ActiveHours); err != nil {
+			return rule, err
+		}
+
+	case models.AnomalyRuleUnusualDeparture:
+		// Without a homePlaceId the rule follows the home the member has
+		// chosen for themselves
+		rule.HomePlaceID = primitive.NilObjectID
+		if req.HomePlaceID != "" {
+			place, err := as.placeRepo.GetByID(ctx, req.HomePlaceID)
+			if err != nil {
+				return rule, err
+			}
+			rule.HomePlaceID = place.ID
+		} else {
+			homeID, err := as.placeRepo.GetHomePlaceID(ctx, memberID)
+			if err != nil {
+				return rule, err
+			}
+			if homeID.IsZero() {
+				return rule, utils.NewValidationFailedError("unusual_departure rules need a homePlaceId, or a home set by the member")
+			}
+		}
+		rule.UnusualHours = req.UnusualHours
+		if rule.UnusualHours == nil {
+			unusualHours := defaultAnomalyUnusualHours
+			rule.UnusualHours = &unusualHours
+		}
+		if err := validateAnomalyWindow(*rule.UnusualHours); err != nil {
+			return rule, err
+		}
+
+	case models.AnomalyRuleLeftSafeZone:
+		if len(req.SafePlaceIDs) == 0 {
+			return rule, utils.NewValidationFailedError("left_safe_zone rules need at least one safe place")
+		}
+		placeIDs := make([]primitive.ObjectID, 0, len(req.SafePlaceIDs))
+		for _, placeID := range req.SafePlaceIDs {
+			objectID, err := primitive.ObjectIDFromHex(placeID)
+			if err != nil {
+				return rule, errors.New("invalid place ID")
+			}
+			placeIDs = append(placeIDs, objectID)
+		}
+		places, err := as.placeRepo.GetByIDs(ctx, placeIDs)
+		if err != nil {
+			return rule, err
+		}
+		if len(places) != len(placeIDs) {
+			return rule, errors.New("place not found")
+		}
+		rule.SafePlaceIDs = placeIDs
+		rule.BufferMeters = req.BufferMeters
+		if rule.BufferMeters == 0 {
+			rule.BufferMeters = defaultAnomalyBufferMeters
+		}
+	}
+
+	return rule, nil
+}
+
+// GetAlerts returns the alerts about the user or sent to them
+func (as *AnomalyService) GetAlerts(ctx context.Context, userID string, page, pageSize int) (*models.AnomalyAlertsResponse, error) {
+	alerts, total, err := as.anomalyRepo.GetAlertsForUser(ctx, userID, page, pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.AnomalyAlertsResponse{
+		Alerts: alerts,
+		Meta: models.PaginationMeta{
+			Page:       page,
+			PageSize:   pageSize,
+			Total:      total,
+			TotalPages: int((total + int64(pageSize) - 1) / int64(pageSize)),
+		},
+	}, nil
+}
+
+// GetAlert returns an alert with its evidence to the member, its watchers
+// and whoever manages the member's profile
+func (as *AnomalyService) GetAlert(ctx context.Context, userID, alertID string) (*models.AnomalyAlert, error) {
+	alert, err := as.anomalyRepo.GetAlert(ctx, alertID)
+	if err != nil {
+		return nil, err
+	}
+
+	if alert.UserID.Hex() == userID || anomalyWatcher(alert, userID) {
+		return alert, nil
+	}
+
+	access, err := as.memberAccess(ctx, userID, alert.UserID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	if !access.canManage {
+		return nil, errors.New("access denied")
+	}
+
+	return alert, nil
+}
+
+// SubmitFeedback records whether an alert was real. False positives widen
+// the thresholds of the rule that raised it a little, so the same
+// situation is less likely to trip it again.
+func (as *AnomalyService) SubmitFeedback(ctx context.Context, userID, alertID string, req models.AnomalyFeedbackRequest) (*models.AnomalyAlert, error) {
+	if validationErrors := as.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	alert, err := as.anomalyRepo.GetAlert(ctx, alertID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !anomalyWatcher(alert, userID) {
+		access, err := as.memberAccess(ctx, userID, alert.UserID.Hex())
+		if err != nil {
+			return nil, err
+		}
+		if !access.canManage {
+			return nil, errors.New("access denied")
+		}
+	}
+
+	if err := as.anomalyRepo.SetAlertFeedback(ctx, alert.ID, userObjectID, req.Feedback); err != nil {
+		return nil, err
+	}
+
+	if req.Feedback == models.AnomalyFeedbackFalsePositive {
+		as.widenRule(ctx, alert)
+	}
+
+	return as.anomalyRepo.GetAlert(ctx, alertID)
+}
+
+func (as *AnomalyService) widenRule(ctx context.Context, alert *models.AnomalyAlert) {
+	profile, err := as.anomalyRepo.GetProfile(ctx, alert.UserID.Hex())
+	if err != nil {
+		logrus.Warnf("Failed to get anomaly profile for feedback on %s: %v", alert.ID.Hex(), err)
+		return
+	}
+
+	for _, rule := range profile.Rules {
+		if rule.ID != alert.RuleID {
+			continue
+		}
+
+		widenAnomalyRule(&rule)
+		if err := as.anomalyRepo.UpdateRule(ctx, profile.ID, rule); err != nil {
+			logrus.Errorf("Failed to widen anomaly rule %s: %v", rule.ID.Hex(), err)
+		}
+		return
+	}
+}
+
+// EvaluateProfiles checks every enabled profile against the locations and
+// visits recorded since its last evaluation, and returns how many alerts
+// were raised
+func (as *AnomalyService) EvaluateProfiles(ctx context.Context, batchSize int) (int, error) {
+	now := time.Now()
+
+	raised := 0
+	afterID := primitive.NilObjectID
+	for {
+		profiles, err := as.anomalyRepo.GetEnabledProfiles(ctx, afterID, batchSize)
+		if err != nil {
+			return raised, err
+		}
+		if len(profiles) == 0 {
+			return raised, nil
+		}
+		afterID = profiles[len(profiles)-1].ID
+
+		for i := range profiles {
+			raised += as.evaluateProfile(ctx, &profiles[i], now)
+
+			if err := as.anomalyRepo.SetEvaluated(ctx, profiles[i].ID, now); err != nil {
+				logrus.Errorf("Failed to mark anomaly profile %s evaluated: %v", profiles[i].ID.Hex(), err)
+			}
+		}
+
+		if len(profiles) < batchSize {
+			return raised, nil
+		}
+	}
+}
+
+func (as *AnomalyService) evaluateProfile(ctx context.Context, profile *models.AnomalyProfile, now time.Time) int {
+	location, err := time.LoadLocation(profile.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+
+	since := now.Add(-anomalyMaxLookback)
+	if profile.LastEvaluatedAt != nil && profile.LastEvaluatedAt.After(since) {
+		since = *profile.LastEvaluatedAt
+	}
+
+	userID := profile.UserID.Hex()
+	raised := 0
+	for _, rule := range profile.Rules {
+		if !rule.Enabled {
+			continue
+		}
+		if rule.LastTriggeredAt != nil && now.Before(rule.LastTriggeredAt.Add(time.Duration(rule.CooldownMinutes)*time.Minute)) {
+			continue
+		}
+
+		var evidence []models.AnomalyEvidence
+		switch rule.Type {
+		case models.AnomalyRuleNoMovement:
+			evidence, err = as.checkNoMovement(ctx, userID, rule, location, now)
+		case models.AnomalyRuleUnusualDeparture:
+			evidence, err = as.checkUnusualDeparture(ctx, userID, rule, location, since, now)
+		case models.AnomalyRuleLeftSafeZone:
+			evidence, err = as.checkLeftSafeZone(ctx, userID, rule, since, now)
+		}
+		if err != nil {
+			logrus.Errorf("Failed to evaluate anomaly rule %s of user %s: %v", rule.ID.Hex(), userID, err)
+			continue
+		}
+		if len(evidence) == 0 {
+			continue
+		}
+
+		if err := as.raiseAlert(ctx, profile, rule, evidence, location, now); err != nil {
+			logrus.Errorf("Failed to raise anomaly alert for user %s: %v", userID, err)
+			continue
+		}
+		raised++
+	}
+
+	return raised
+}
+
+// checkNoMovement trips when every location of the window stays within the
+// rule's distance of where the member was when it started
+func (as *AnomalyService) checkNoMovement(ctx context.Context, userID string, rule models.AnomalyRule, location *time.Location, now time.Time) ([]models.AnomalyEvidence, error) {
+	if rule.ActiveHours != nil && !inAnomalyWindow(*rule.ActiveHours, now.In(location)) {
+		return nil, nil
+	}
+
+	windowStart := now.Add(-time.Duration(rule.WindowMinutes) * time.Minute)
+	anchor, err := as.locationRepo.GetLocationAt(ctx, userID, windowStart)
+	if err != nil {
+		if err.Error() == "location not found" {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	locations, err := as.locationRepo.GetLocationsBetween(ctx, userID, windowStart, now, anomalyMaxLocations)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, loc := range locations {
+		if utils.CalculateDistance(anchor.Latitude, anchor.Longitude, loc.Latitude, loc.Longitude) > rule.MovementMeters {
+			return nil, nil
+		}
+	}
+
+	evidence := []models.AnomalyEvidence{
+		locationEvidence(*anchor, "Position when the window started"),
+	}
+	if len(locations) > 0 {
+		last := locations[len(locations)-1]
+		evidence = append(evidence, locationEvidence(last, fmt.Sprintf("Latest position, within %.0fm", rule.MovementMeters)))
+	} else {
+		evidence[0].Note = "Last position reported, nothing since"
+	}
+
+	return evidence, nil
+}
+
+// checkUnusualDeparture trips on a departure from the home place during the
+// rule's unusual hours
+func (as *AnomalyService) checkUnusualDeparture(ctx context.Context, userID string, rule models.AnomalyRule, location *time.Location, since, now time.Time) ([]models.AnomalyEvidence, error) {
+	homeID := rule.HomePlaceID
+	if homeID.IsZero() {
+		var err error
+		homeID, err = as.placeRepo.GetHomePlaceID(ctx, utils.ObjectIDFromHex(userID))
+		if err != nil || homeID.IsZero() {
+			return nil, err
+		}
+	}
+
+	visits, err := as.placeRepo.GetDeparturesBetween(ctx, utils.ObjectIDFromHex(userID), homeID, since, now)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, visit := range visits {
+		departure := visit.DepartureTime.In(location)
+		if !inAnomalyWindow(*rule.UnusualHours, departure) {
+			continue
+		}
+
+		evidence := []models.AnomalyEvidence{{
+			Kind:      "visit",
+			ID:        visit.ID,
+			PlaceID:   visit.PlaceID,
+			Timestamp: *visit.DepartureTime,
+			Note:      fmt.Sprintf("Left home at %s", departure.Format("15:04")),
+		}}
+
+		after, err := as.locationRepo.GetLocationsBetween(ctx, userID, *visit.DepartureTime, now, 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(after) > 0 {
+			evidence = append(evidence, locationEvidence(after[0], "First position after leaving"))
+		}
+
+		return evidence, nil
+	}
+
+	return nil, nil
+}
+
+// checkLeftSafeZone trips when a location inside one of the safe places is
+// followed by one outside all of them, buffer included
+func (as *AnomalyService) checkLeftSafeZone(ctx context.Context, userID string, rule models.AnomalyRule, since, now time.Time) ([]models.AnomalyEvidence, error) {
+	places, err := as.placeRepo.GetByIDs(ctx, rule.SafePlaceIDs)
+	if err != nil || len(places) == 0 {
+		return nil, err
+	}
+
+	safePlace := func(loc models.Location) *models.Place {
+		for i := range places {
+			distance := utils.CalculateDistance(places[i].Latitude, places[i].Longitude, loc.Latitude, loc.Longitude)
+			if distance <= float64(places[i].Radius)+rule.BufferMeters {
+				return &places[i]
+			}
+		}
+		return nil
+	}
+
+	var previous *models.Location
+	if loc, err := as.locationRepo.GetLocationAt(ctx, userID, since); err == nil {
+		previous = loc
+	} else if err.Error() != "location not found" {
+		return nil, err
+	}
+
+	locations, err := as.locationRepo.GetLocationsBetween(ctx, userID, since, now, anomalyMaxLocations)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range locations {
+		current := &locations[i]
+		if previous != nil {
+			if place := safePlace(*previous); place != nil && safePlace(*current) == nil {
+				return []models.AnomalyEvidence{
+					locationEvidence(*previous, fmt.Sprintf("Last position at %s", place.Name)),
+					locationEvidence(*current, "First position outside every safe place"),
+				}, nil
+			}
+		}
+		previous = current
+	}
+
+	return nil, nil
+}
+
+func (as *AnomalyService) raiseAlert(ctx context.Context, profile *models.AnomalyProfile, rule models.AnomalyRule, evidence []models.AnomalyEvidence, location *time.Location, now time.Time) error {
+	userID := profile.UserID.Hex()
+
+	memberName := "A circle member"
+	if user, err := as.userRepo.GetByID(ctx, userID); err == nil {
+		memberName = user.FirstName
+	}
+
+	// Watchers who have since left the member's circles are skipped
+	access, err := as.memberAccess(ctx, "", userID)
+	if err != nil {
+		return err
+	}
+	watchers := []primitive.ObjectID{}
+	recipients := []string{}
+	for _, watcherID := range profile.Watchers {
+		if access.mates[watcherID.Hex()] {
+			watchers = append(watchers, watcherID)
+			recipients = append(recipients, watcherID.Hex())
+		}
+	}
+
+	title, message := anomalyAlertText(memberName, rule, evidence, location)
+	alert := &models.AnomalyAlert{
+		ProfileID:   profile.ID,
+		UserID:      profile.UserID,
+		RuleID:      rule.ID,
+		RuleType:    rule.Type,
+		Title:       title,
+		Message:     message,
+		Evidence:    evidence,
+		Watchers:    watchers,
+		TriggeredAt: now,
+	}
+
+	if err := as.anomalyRepo.CreateAlert(ctx, alert); err != nil {
+		return err
+	}
+	if err := as.anomalyRepo.SetRuleTriggered(ctx, profile.ID, rule.ID, now); err != nil {
+		logrus.Errorf("Failed to start cooldown of anomaly rule %s: %v", rule.ID.Hex(), err)
+	}
+
+	if as.notificationService == nil || len(recipients) == 0 {
+		return nil
+	}
+
+	err = as.notificationService.SendNotification(ctx, models.SendNotificationRequest{
+		Recipients: recipients,
+		Title:      title,
+		Message:    message,
+		Type:       models.NotificationTypeAnomaly,
+		Priority:   "high",
+		Category:   "safety",
+		Data: map[string]interface{}{
+			"alertId":  alert.ID.Hex(),
+			"userId":   userID,
+			"ruleType": rule.Type,
+		},
+		DeliveryChannels: []string{"push"},
+		SubjectUserID:    userID,
+	})
+	if err != nil {
+		logrus.Errorf("Failed to send anomaly alert notification: %v", err)
+	}
+
+	return nil
+}
+
+func anomalyAlertText(memberName string, rule models.AnomalyRule, evidence []models.AnomalyEvidence, location *time.Location) (string, string) {
+	switch rule.Type {
+	case models.AnomalyRuleNoMovement:
+		window := time.Duration(rule.WindowMinutes) * time.Minute
+		return fmt.Sprintf("%s hasn't moved", memberName),
+			fmt.Sprintf("%s hasn't moved more than %.0fm in %s", memberName, rule.MovementMeters, window.String())
+	case models.AnomalyRuleUnusualDeparture:
+		return fmt.Sprintf("%s left home", memberName),
+			fmt.Sprintf("%s left home at %s", memberName, evidence[0].Timestamp.In(location).Format("15:04"))
+	default:
+		return fmt.Sprintf("%s left their safe places", memberName),
+			fmt.Sprintf("%s is outside all of their safe places", memberName)
+	}
+}
+
+func locationEvidence(loc models.Location, note string) models.AnomalyEvidence {
+	return models.AnomalyEvidence{
+		Kind:      "location",
+		ID:        loc.ID,
+		PlaceID:   loc.PlaceID,
+		Latitude:  loc.Latitude,
+		Longitude: loc.Longitude,
+		Timestamp: loc.CreatedAt,
+		Note:      note,
+	}
+}
+
+func anomalyWatcher(alert *models.AnomalyAlert, userID string) bool {
+	for _, watcherID := range alert.Watchers {
+		if watcherID.Hex() == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// widenAnomalyRule loosens a rule after a false positive: longer windows,
+// more allowed movement, narrower unusual hours and wider safe places
+func widenAnomalyRule(rule *models.AnomalyRule) {
+	rule.FalsePositives++
+
+	switch rule.Type {
+	case models.AnomalyRuleNoMovement:
+		rule.WindowMinutes = min(rule.WindowMinutes+max(15, rule.WindowMinutes/10), 2880)
+		rule.MovementMeters = min(rule.MovementMeters*1.1, 5000)
+
+	case models.AnomalyRuleUnusualDeparture:
+		if rule.UnusualHours == nil {
+			return
+		}
+		start, startErr := parseAnomalyClock(rule.UnusualHours.Start)
+		end, endErr := parseAnomalyClock(rule.UnusualHours.End)
+		if startErr != nil || endErr != nil {
+			return
+		}
+		if (end-start+1440)%1440-30 < anomalyMinWindowMinutes {
+			return
+		}
+		rule.UnusualHours = &models.AnomalyTimeWindow{
+			Start: formatAnomalyClock(start + 15),
+			End:   formatAnomalyClock(end - 15),
+		}
+
+	case models.AnomalyRuleLeftSafeZone:
+		rule.BufferMeters = min(rule.BufferMeters+max(25, rule.BufferMeters/10), 2000)
+	}
+}
+
+func validateAnomalyWindow(window models.AnomalyTimeWindow) error {
+	start, err := parseAnomalyClock(window.Start)
+	if err != nil {
+		return utils.NewValidationFailedError("time windows must use HH:MM")
+	}
+	end, err := parseAnomalyClock(window.End)
+	if err != nil {
+		return utils.NewValidationFailedError("time windows must use HH:MM")
+	}
+	if start == end {
+		return utils.NewValidationFailedError("time windows must not start and end at the same time")
+	}
+	return nil
+}
+
+// inAnomalyWindow reports whether t, already in the profile's timezone,
+// falls in the window
+func inAnomalyWindow(window models.AnomalyTimeWindow, t time.Time) bool {
+	start, err := parseAnomalyClock(window.Start)
+	if err != nil {
+		return false
+	}
+	end, err := parseAnomalyClock(window.End)
+	if err != nil {
+		return false
+	}
+
+	current := t.Hour()*60 + t.Minute()
+	if start <= end {
+		return current >= start && current < end
+	}
+	return current >= start || current < end
+}
+
+func parseAnomalyClock(value string) (int, error) {
+	clock, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, err
+	}
+	return clock.Hour()*60 + clock.Minute(), nil
+}
+
+func formatAnomalyClock(minutes int) string {
+	minutes = (minutes%1440 + 1440) % 1440
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
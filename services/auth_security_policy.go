@@ -0,0 +1,358 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"ftrack/models"
+	"ftrack/repositories"
+	"ftrack/utils"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// LoginLockoutPolicy locks an account, or an IP address, out of logging in
+// after repeated failed logins. Each lockout within a day lasts twice as
+// long as the one before, up to MaxDelay.
+type LoginLockoutPolicy struct {
+	AccountThreshold int           // failed logins of an account before it is locked
+	IPThreshold      int           // failed logins from an IP address before it is locked out
+	FailureWindow    time.Duration // failures older than this are forgotten
+	BaseDelay        time.Duration // length of the first lockout
+	MaxDelay         time.Duration
+}
+
+var DefaultLoginLockoutPolicy = LoginLockoutPolicy{
+	AccountThreshold: 5,
+	IPThreshold:      20,
+	FailureWindow:    15 * time.Minute,
+	BaseDelay:        30 * time.Second,
+	MaxDelay:         time.Hour,
+}
+
+// Lockouts are remembered this long to work out how long the next one lasts
+const loginLockoutMemory = 24 * time.Hour
+
+const (
+	lockoutScopeAccount = "account"
+	lockoutScopeIP      = "ip"
+)
+
+// ConfigureLoginSecurity locks accounts and IP addresses out after repeated
+// failed logins, and records lockouts, unlocks and forced password changes
+// in the audit log and tells the user about them
+func (as *AuthService) ConfigureLoginSecurity(policy LoginLockoutPolicy, auditRepo *repositories.AuditLogRepository, notificationService *NotificationService) {
+	if policy.AccountThreshold <= 0 {
+		policy.AccountThreshold = DefaultLoginLockoutPolicy.AccountThreshold
+	}
+	if policy.IPThreshold <= 0 {
+		policy.IPThreshold = DefaultLoginLockoutPolicy.IPThreshold
+	}
+	if policy.FailureWindow <= 0 {
+		policy.FailureWindow = DefaultLoginLockoutPolicy.FailureWindow
+	}
+	if policy.BaseDelay <= 0 {
+		policy.BaseDelay = DefaultLoginLockoutPolicy.BaseDelay
+	}
+	if policy.MaxDelay < policy.BaseDelay {
+		policy.MaxDelay = policy.BaseDelay
+	}
+
+	as.lockoutPolicy = policy
+	as.auditRepo = auditRepo
+	as.notificationService = notificationService
+}
+
+// checkLoginLockout returns a "too many login attempts" error while the
+// account or IP address is locked out
+func (as *AuthService) checkLoginLockout(ctx context.Context, scope, id string) error {
+	if as.redis == nil || id == "" {
+		return nil
+	}
+
+	ttl, err := as.redis.PTTL(ctx, loginLockedKey(scope, id)).Result()
+	if err == nil && ttl > 0 {
+		return utils.NewLoginLockedError(ttl)
+	}
+	return nil
+}
+
+// checkAccountLockout checks the account's lockout. A lockout that ran out
+// since the last login is recorded as ended.
+func (as *AuthService) checkAccountLockout(ctx context.Context, user *models.User, ipAddress string) error {
+	if err := as.checkLoginLockout(ctx, lockoutScopeAccount, user.ID.Hex()); err != nil {
+		return err
+	}
+
+	if user.LockedUntil.IsZero() {
+		return nil
+	}
+	if wait := time.Until(user.LockedUntil); wait > 0 {
+		return utils.NewLoginLockedError(wait)
+	}
+
+	if err := as.userRepo.UnlockAccount(ctx, user.ID.Hex()); err != nil {
+		logrus.Warnf("Failed to clear lockout of user %s: %v", user.ID.Hex(), err)
+		return nil
+	}
+	user.LockedUntil = time.Time{}
+
+	as.auditSecurityEvent(ctx, user.ID.Hex(), models.EventAccountUnlocked, "Login lockout ended", ipAddress, models.SeverityInfo, map[string]interface{}{
+		"reason": "expired",
+	})
+	as.sendSecurityNotification(ctx, user.ID.Hex(), "Your account is unlocked",
+		"The lock on logging in to your account after several failed attempts has ended.",
+		map[string]interface{}{"event": models.EventAccountUnlocked})
+	return nil
+}
+
+// recordFailedLogin counts a failed login against the IP address and, if
+// the email belongs to an account, against the account, and locks either
+// out once it reached its threshold. user is nil for unknown emails.
+func (as *AuthService) recordFailedLogin(ctx context.Context, user *models.User, req models.LoginRequest) {
+	if as.redis == nil {
+		return
+	}
+	policy := as.lockoutPolicy
+
+	if req.IPAddress != "" && as.countLoginFailure(ctx, lockoutScopeIP, req.IPAddress, policy.IPThreshold) {
+		delay := as.lockOut(ctx, lockoutScopeIP, req.IPAddress)
+		logrus.Warnf("IP address %s locked out of logging in for %s", req.IPAddress, delay)
+
+		// The account tried last is told, as it is likely the one targeted
+		if user != nil {
+			as.auditSecurityEvent(ctx, user.ID.Hex(), models.EventIPLockedOut, "IP address locked out after repeated failed logins", req.IPAddress, models.SeverityWarning, map[string]interface{}{
+				"lockedFor": delay.String(),
+			})
+		}
+	}
+
+	if user == nil {
+		return
+	}
+
+	userID := user.ID.Hex()
+	if !as.countLoginFailure(ctx, lockoutScopeAccount, userID, policy.AccountThreshold) {
+		return
+	}
+
+	delay := as.lockOut(ctx, lockoutScopeAccount, userID)
+	if err := as.userRepo.LockAccount(ctx, userID, delay); err != nil {
+		logrus.Warnf("Failed to record lockout of user %s: %v", userID, err)
+	}
+
+	as.auditSecurityEvent(ctx, userID, models.EventAccountLocked, "Account locked after repeated failed logins", req.IPAddress, models.SeverityWarning, map[string]interface{}{
+		"lockedFor":   delay.String(),
+		"lockedUntil": time.Now().Add(delay),
+	})
+	as.sendSecurityNotification(ctx, userID, "Your account was locked",
+		fmt.Sprintf("Logging in to your account failed several times, so it is locked for %s. If this wasn't you, reset your password.", delay.Round(time.Second)),
+		map[string]interface{}{
+			"event":       models.EventAccountLocked,
+			"lockedUntil": time.Now().Add(delay),
+		})
+}
+
+// countLoginFailure counts a failed login and reports whether the
+// threshold was reached
+func (as *AuthService) countLoginFailure(ctx context.Context, scope, id string, threshold int) bool {
+	key := loginFailuresKey(scope, id)
+	pipe := as.redis.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, as.lockoutPolicy.FailureWindow)
+	if _, err := pipe.Exec(ctx); err != nil {
+		logrus.Warn("Failed to record failed login: ", err)
+		return false
+	}
+	return incr.Val() >= int64(threshold)
+}
+
+// lockOut starts a lockout twice as long as the previous one of the day
+// and returns its length
+func (as *AuthService) lockOut(ctx context.Context, scope, id string) time.Duration {
+	policy := as.lockoutPolicy
+
+	lockoutsKey := loginLockoutsKey(scope, id)
+	pipe := as.redis.TxPipeline()
+	incr := pipe.Incr(ctx, lockoutsKey)
+	pipe.Expire(ctx, lockoutsKey, loginLockoutMemory)
+	if _, err := pipe.Exec(ctx); err != nil {
+		logrus.Warn("Failed to count lockouts: ", err)
+	}
+
+	delay := policy.BaseDelay
+	for i := int64(1); i < incr.Val() && delay < policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+
+	pipe = as.redis.TxPipeline()
+	pipe.Set(ctx, loginLockedKey(scope, id), time.Now().Add(delay).Unix(), delay)
+	pipe.Del(ctx, loginFailuresKey(scope, id))
+	if _, err := pipe.Exec(ctx); err != nil {
+		logrus.Warn("Failed to lock out login: ", err)
+	}
+	return delay
+}
+
+// clearFailedLogins forgets the account's failed logins after it logged
+// in. The IP address's stay, so one working account can't hide guesses at
+// others.
+func (as *AuthService) clearFailedLogins(ctx context.Context, userID string) {
+	if as.redis != nil {
+		as.redis.Del(ctx, loginFailuresKey(lockoutScopeAccount, userID))
+	}
+}
+
+// AdminUnlockAccount ends a user's login lockout and forgets their failed
+// logins and earlier lockouts
+func (as *AuthService) AdminUnlockAccount(ctx context.Context, adminID, userID string, req models.UnlockAccountRequest) error {
+	if validationErrors := as.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	if err := as.userRepo.UnlockAccount(ctx, userID); err != nil {
+		return err
+	}
+	as.forgetLoginLockouts(ctx, userID)
+
+	as.auditSecurityEvent(ctx, userID, models.EventAccountUnlocked, "Account unlocked by an admin", "", models.SeverityInfo, map[string]interface{}{
+		"reason":  req.Reason,
+		"adminId": adminID,
+	})
+	as.sendSecurityNotification(ctx, userID, "Your account is unlocked",
+		"Our support team unlocked your account. You can log in again.",
+		map[string]interface{}{"event": models.EventAccountUnlocked})
+	return nil
+}
+
+// unlockAfterPasswordReset ends the account's lockout, as whoever reset
+// the password has access to the account's email
+func (as *AuthService) unlockAfterPasswordReset(ctx context.Context, user *models.User) {
+	userID := user.ID.Hex()
+	locked := !user.LockedUntil.IsZero() || as.checkLoginLockout(ctx, lockoutScopeAccount, userID) != nil
+	as.forgetLoginLockouts(ctx, userID)
+	if !locked {
+		return
+	}
+
+	if err := as.userRepo.UnlockAccount(ctx, userID); err != nil {
+		logrus.Warnf("Failed to clear lockout of user %s: %v", userID, err)
+	}
+
+	as.auditSecurityEvent(ctx, userID, models.EventAccountUnlocked, "Account unlocked by a password reset", "", models.SeverityInfo, map[string]interface{}{
+		"reason": "password_reset",
+	})
+	as.sendSecurityNotification(ctx, userID, "Your account is unlocked",
+		"Your password was reset, so the lock on logging in to your account has ended.",
+		map[string]interface{}{"event": models.EventAccountUnlocked})
+}
+
+// forgetLoginLockouts ends the account's lockout and forgets its failed
+// logins and earlier lockouts
+func (as *AuthService) forgetLoginLockouts(ctx context.Context, userID string) {
+	if as.redis == nil {
+		return
+	}
+	as.redis.Del(ctx,
+		loginLockedKey(lockoutScopeAccount, userID),
+		loginFailuresKey(lockoutScopeAccount, userID),
+		loginLockoutsKey(lockoutScopeAccount, userID),
+	)
+}
+
+// SetPasswordRotation makes a user change their password before they can
+// do anything else, e.g. after their account was compromised, or lets them
+// off. Requiring it logs them out everywhere.
+func (as *AuthService) SetPasswordRotation(ctx context.Context, adminID, userID string, req models.PasswordRotationRequest) error {
+	if validationErrors := as.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	user, err := as.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := as.userRepo.Update(ctx, userID, bson.M{
+		"passwordChangeRequired": req.Required,
+		"updatedAt":              time.Now(),
+	}); err != nil {
+		return err
+	}
+
+	details := map[string]interface{}{
+		"reason":  req.Reason,
+		"adminId": adminID,
+	}
+
+	if !req.Required {
+		as.auditSecurityEvent(ctx, userID, models.EventPasswordRotationCleared, "Password change no longer required", "", models.SeverityInfo, details)
+		return nil
+	}
+
+	if err := as.sessionRepo.InvalidateAllUserSessions(ctx, user.ID); err != nil {
+		logrus.Warnf("Failed to end sessions of user %s: %v", userID, err)
+	}
+
+	as.auditSecurityEvent(ctx, userID, models.EventPasswordRotationRequired, "Password change required by an admin", "", models.SeverityWarning, details)
+	as.sendSecurityNotification(ctx, userID, "Change your password",
+		"Your account may have been accessed by someone else. Log in and change your password to keep using the app.",
+		map[string]interface{}{"event": models.EventPasswordRotationRequired})
+	return nil
+}
+
+// passwordRotated records that a user who had to change their password did
+func (as *AuthService) passwordRotated(ctx context.Context, user *models.User) {
+	if user.PasswordChangeRequired {
+		as.auditSecurityEvent(ctx, user.ID.Hex(), models.EventPasswordRotationCleared, "Required password change completed", "", models.SeverityInfo, nil)
+	}
+}
+
+func (as *AuthService) auditSecurityEvent(ctx context.Context, userID, eventType, description, ipAddress, severity string, details map[string]interface{}) {
+	if as.auditRepo == nil {
+		logrus.Infof("Security event for user %s: %s - %v", userID, eventType, details)
+		return
+	}
+
+	if err := as.auditRepo.LogSecurityEvent(ctx, userID, eventType, description, ipAddress, "", "", severity, details); err != nil {
+		logrus.Warnf("Failed to write audit log for user %s: %v", userID, err)
+	}
+}
+
+func (as *AuthService) sendSecurityNotification(ctx context.Context, userID, title, message string, data map[string]interface{}) {
+	if as.notificationService == nil {
+		return
+	}
+
+	Background.Go(ctx, func(ctx context.Context) {
+		err := as.notificationService.SendNotification(ctx, models.SendNotificationRequest{
+			Recipients:       []string{userID},
+			Title:            title,
+			Message:          message,
+			Type:             models.NotificationTypeAccountSecurity,
+			Priority:         "high",
+			Category:         "security",
+			Data:             data,
+			DeliveryChannels: []string{"push", "email"},
+		})
+		if err != nil {
+			logrus.Errorf("Failed to send security notification to user %s: %v", userID, err)
+		}
+	})
+}
+
+func loginFailuresKey(scope, id string) string {
+	return fmt.Sprintf("login:failures:%s:%s", scope, id)
+}
+
+func loginLockedKey(scope, id string) string {
+	return fmt.Sprintf("login:locked:%s:%s", scope, id)
+}
+
+func loginLockoutsKey(scope, id string) string {
+	return fmt.Sprintf("login:lockouts:%s:%s", scope, id)
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"ftrack/interfaces"
@@ -13,6 +14,7 @@ import (
 	"ftrack/utils"
 	"math/big"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -32,6 +34,13 @@ type AuthService struct {
 	validator       *utils.ValidationService
 	redis           *redis.Client
 	config          *models.AuthConfig
+
+	deactivationService *AccountDeactivationService
+	signingKeyRepo      *repositories.SigningKeyRepository
+
+	lockoutPolicy       LoginLockoutPolicy
+	auditRepo           *repositories.AuditLogRepository
+	notificationService *NotificationService
 }
 
 func NewAuthService(
@@ -53,15 +62,22 @@ func NewAuthService(
 		validator:       utils.NewValidationService(),
 		redis:           redis,
 		config:          config,
+		lockoutPolicy:   DefaultLoginLockoutPolicy,
 	}
 }
 
+// ConfigureReactivation lets users who deactivated their account reactivate
+// it by logging in
+func (as *AuthService) ConfigureReactivation(deactivationService *AccountDeactivationService) {
+	as.deactivationService = deactivationService
+}
+
 // ============== BASIC AUTH METHODS ==============
 
 func (as *AuthService) Register(ctx context.Context, req models.RegisterRequest) (*models.AuthResponse, error) {
 	// Validate request
 	if validationErrors := as.validator.ValidateStruct(req); len(validationErrors) > 0 {
-		return nil, errors.New("validation failed")
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
 	}
 
 	// Check if user already exists
@@ -75,6 +91,10 @@ func (as *AuthService) Register(ctx context.Context, req models.RegisterRequest)
 		return nil, errors.New("phone already exists")
 	}
 
+	if err := as.passwordService.ValidatePasswordStrength(req.Password, req.Email, req.Phone, req.FirstName, req.LastName); err != nil {
+		return nil, err
+	}
+
 	// Hash password
 	hashedPassword, err := as.passwordService.HashPassword(req.Password)
 	if err != nil {
@@ -124,7 +144,9 @@ func (as *AuthService) Register(ctx context.Context, req models.RegisterRequest)
 	}
 
 	// Send verification email using existing email service
-	go as.sendVerificationEmail(user.Email, user.FirstName, verificationToken)
+	Background.Go(ctx, func(context.Context) {
+		as.sendVerificationEmail(user.Email, user.FirstName, verificationToken)
+	})
 
 	// Generate JWT tokens
 	tokenPair, err := as.jwtService.GenerateTokenPair(user.ID.Hex(), user.Email, "user")
@@ -161,17 +183,29 @@ func (as *AuthService) Register(ctx context.Context, req models.RegisterRequest)
 func (as *AuthService) Login(ctx context.Context, req models.LoginRequest) (*models.AuthResponse, error) {
 	// Validate request
 	if validationErrors := as.validator.ValidateStruct(req); len(validationErrors) > 0 {
-		return nil, errors.New("validation failed")
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	// An IP address guessing at many accounts is locked out of all of them
+	if err := as.checkLoginLockout(ctx, lockoutScopeIP, req.IPAddress); err != nil {
+		return nil, err
 	}
 
 	// Get user by email
 	user, err := as.userRepo.GetByEmail(ctx, req.Email)
 	if err != nil {
+		as.recordFailedLogin(ctx, nil, req)
 		return nil, errors.New("invalid email or password")
 	}
 
-	// Check if user is active
-	if !user.IsActive {
+	if err := as.checkAccountLockout(ctx, user, req.IPAddress); err != nil {
+		return nil, err
+	}
+
+	// Check if user is active. Users who deactivated their account
+	// themselves can reactivate it once their credentials are verified.
+	canReactivate := user.Deactivation != nil && as.deactivationService != nil
+	if !user.IsActive && !canReactivate {
 		return nil, errors.New("account is deactivated")
 	}
 
@@ -183,32 +217,88 @@ func (as *AuthService) Login(ctx context.Context, req models.LoginRequest) (*mod
 			"email": req.Email,
 			"ip":    req.IPAddress,
 		})
+		as.recordFailedLogin(ctx, user, req)
 		return nil, errors.New("invalid email or password")
 	}
+	as.clearFailedLogins(ctx, user.ID.Hex())
 
 	// Check if email is verified (optional based on config)
 	if as.config.RequireEmailVerification && !user.IsVerified {
 		return nil, errors.New("email not verified")
 	}
 
-	// Check if 2FA is enabled
-	if user.TwoFactorEnabled && req.TwoFactorCode == "" {
-		return nil, errors.New("2fa required")
+	// Deactivated accounts are only let in when the user confirms reactivation
+	if !user.IsActive && !req.Reactivate {
+		return nil, errors.New("account reactivation required")
+	}
+
+	if user.TwoFactorEnabled {
+		// Without a code the login continues with a second step
+		if req.TwoFactorCode == "" {
+			return as.startMFAChallenge(ctx, user, req)
+		}
+
+		if err := as.verifyMFACode(ctx, user, req.TwoFactorCode, true); err != nil {
+			return nil, err
+		}
+	}
+
+	return as.completeLogin(ctx, user, req)
+}
+
+// CompleteMFALogin finishes a login that was answered with an MFA challenge
+func (as *AuthService) CompleteMFALogin(ctx context.Context, req models.MFALoginRequest) (*models.AuthResponse, error) {
+	if validationErrors := as.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	key := mfaChallengeKey(req.MFAToken)
+	data, err := as.redis.Get(ctx, key).Result()
+	if err != nil {
+		return nil, errors.New("invalid or expired mfa token")
+	}
+
+	var loginReq models.LoginRequest
+	if err := json.Unmarshal([]byte(data), &loginReq); err != nil {
+		return nil, errors.New("invalid or expired mfa token")
+	}
+
+	user, err := as.userRepo.GetByEmail(ctx, loginReq.Email)
+	if err != nil || !user.TwoFactorEnabled {
+		return nil, errors.New("invalid or expired mfa token")
+	}
+	if !user.IsActive && (user.Deactivation == nil || as.deactivationService == nil) {
+		return nil, errors.New("account is deactivated")
+	}
+
+	if err := as.verifyMFACode(ctx, user, req.Code, true); err != nil {
+		return nil, err
+	}
+
+	// A challenge can only be answered once
+	if deleted, err := as.redis.Del(ctx, key).Result(); err != nil || deleted == 0 {
+		return nil, errors.New("invalid or expired mfa token")
 	}
 
-	// Verify 2FA if provided
-	if user.TwoFactorEnabled && req.TwoFactorCode != "" {
-		valid, err := as.verify2FACode(user.TwoFactorSecret, req.TwoFactorCode)
-		if err != nil || !valid {
-			as.logSecurityEvent(ctx, user.ID.Hex(), "failed_2fa", map[string]interface{}{
-				"code": req.TwoFactorCode,
-			})
-			return nil, errors.New("invalid 2fa code")
+	return as.completeLogin(ctx, user, loginReq)
+}
+
+// completeLogin issues tokens once all login factors have been checked
+func (as *AuthService) completeLogin(ctx context.Context, user *models.User, req models.LoginRequest) (*models.AuthResponse, error) {
+	// Reactivate only when the user confirmed it
+	if !user.IsActive {
+		if err := as.deactivationService.ReactivateAccount(ctx, user); err != nil {
+			logrus.Error("Failed to reactivate account: ", err)
+			return nil, errors.New("failed to reactivate account")
 		}
+
+		as.logSecurityEvent(ctx, user.ID.Hex(), "account_reactivated", map[string]interface{}{
+			"ip": req.IPAddress,
+		})
 	}
 
 	// Update last seen
-	err = as.userRepo.UpdateLastSeen(ctx, user.ID.Hex())
+	err := as.userRepo.UpdateLastSeen(ctx, user.ID.Hex())
 	if err != nil {
 		logrus.Warn("Failed to update last seen: ", err)
 	}
@@ -282,7 +372,9 @@ func (as *AuthService) VerifyEmail(ctx context.Context, token string) error {
 	}
 
 	// Send welcome email
-	go as.sendWelcomeEmail(user.Email, user.FirstName)
+	Background.Go(ctx, func(context.Context) {
+		as.sendWelcomeEmail(user.Email, user.FirstName)
+	})
 
 	// Log email verification
 	as.logSecurityEvent(ctx, user.ID.Hex(), "email_verified", nil)
@@ -320,7 +412,9 @@ func (as *AuthService) ResendVerification(ctx context.Context, email string) err
 	}
 
 	// Send verification email
-	go as.sendVerificationEmail(user.Email, user.FirstName, verificationToken)
+	Background.Go(ctx, func(context.Context) {
+		as.sendVerificationEmail(user.Email, user.FirstName, verificationToken)
+	})
 
 	return nil
 }
@@ -353,7 +447,9 @@ func (as *AuthService) ForgotPassword(ctx context.Context, email string) error {
 	}
 
 	// Send reset email using existing email service
-	go as.sendPasswordResetEmail(user.Email, user.FirstName, resetToken)
+	Background.Go(ctx, func(context.Context) {
+		as.sendPasswordResetEmail(user.Email, user.FirstName, resetToken)
+	})
 
 	// Log password reset request
 	as.logSecurityEvent(ctx, user.ID.Hex(), "password_reset_requested", nil)
@@ -373,8 +469,8 @@ func (as *AuthService) ResetPassword(ctx context.Context, token, newPassword str
 	}
 
 	// Validate new password
-	if len(newPassword) < 6 {
-		return errors.New("validation failed")
+	if err := as.passwordService.ValidatePasswordStrength(newPassword, user.Email, user.Phone, user.FirstName, user.LastName); err != nil {
+		return err
 	}
 
 	// Hash new password
@@ -385,10 +481,11 @@ func (as *AuthService) ResetPassword(ctx context.Context, token, newPassword str
 
 	// Update user password and clear reset token
 	updateFields := bson.M{
-		"password":       hashedPassword,
-		"resetToken":     "",
-		"tokenExpiresAt": time.Time{},
-		"updatedAt":      time.Now(),
+		"password":               hashedPassword,
+		"resetToken":             "",
+		"tokenExpiresAt":         time.Time{},
+		"passwordChangeRequired": false,
+		"updatedAt":              time.Now(),
 	}
 
 	err = as.userRepo.Update(ctx, user.ID.Hex(), updateFields)
@@ -400,10 +497,14 @@ func (as *AuthService) ResetPassword(ctx context.Context, token, newPassword str
 	as.sessionRepo.InvalidateAllUserSessions(ctx, user.ID)
 
 	// Send password changed notification
-	go as.sendPasswordChangedEmail(user.Email, user.FirstName)
+	Background.Go(ctx, func(context.Context) {
+		as.sendPasswordChangedEmail(user.Email, user.FirstName)
+	})
 
 	// Log password reset
 	as.logSecurityEvent(ctx, user.ID.Hex(), "password_reset_completed", nil)
+	as.passwordRotated(ctx, user)
+	as.unlockAfterPasswordReset(ctx, user)
 
 	return nil
 }
@@ -421,8 +522,8 @@ func (as *AuthService) ChangePassword(ctx context.Context, userID, currentPasswo
 	}
 
 	// Validate new password
-	if len(newPassword) < 6 {
-		return errors.New("validation failed")
+	if err := as.passwordService.ValidatePasswordStrength(newPassword, user.Email, user.Phone, user.FirstName, user.LastName); err != nil {
+		return err
 	}
 
 	// Check if new password is different
@@ -437,10 +538,11 @@ func (as *AuthService) ChangePassword(ctx context.Context, userID, currentPasswo
 		return err
 	}
 
-	// Update password
+	// Update password. A required change is done.
 	updateFields := bson.M{
-		"password":  hashedPassword,
-		"updatedAt": time.Now(),
+		"password":               hashedPassword,
+		"passwordChangeRequired": false,
+		"updatedAt":              time.Now(),
 	}
 
 	err = as.userRepo.Update(ctx, userID, updateFields)
@@ -449,16 +551,27 @@ func (as *AuthService) ChangePassword(ctx context.Context, userID, currentPasswo
 	}
 
 	// Send password changed notification
-	go as.sendPasswordChangedEmail(user.Email, user.FirstName)
+	Background.Go(ctx, func(context.Context) {
+		as.sendPasswordChangedEmail(user.Email, user.FirstName)
+	})
 
 	// Log password change
 	as.logSecurityEvent(ctx, userID, "password_changed", nil)
+	as.passwordRotated(ctx, user)
 
 	return nil
 }
 
 // ============== 2FA METHODS ==============
 
+const (
+	mfaChallengeTTL      = 5 * time.Minute
+	mfaEnrollmentTTL     = 15 * time.Minute
+	mfaMaxFailedAttempts = 5
+	mfaFailedAttemptsTTL = 15 * time.Minute
+	mfaBackupCodeCount   = 8
+)
+
 func (as *AuthService) Disable2FA(ctx context.Context, userID, password, code string) error {
 	user, err := as.userRepo.GetByID(ctx, userID)
 	if err != nil {
@@ -476,11 +589,33 @@ func (as *AuthService) Disable2FA(ctx context.Context, userID, password, code st
 	}
 
 	// Verify 2FA code
-	valid, err := as.verify2FACode(user.TwoFactorSecret, code)
-	if err != nil || !valid {
-		return errors.New("invalid code")
+	if err := as.verifyMFACode(ctx, user, code, true); err != nil {
+		if err.Error() == "invalid 2fa code" {
+			return errors.New("invalid code")
+		}
+		return err
 	}
 
+	return as.disableMFA(ctx, user)
+}
+
+// DisableMFA turns off 2FA for a user who recently re-authenticated
+func (as *AuthService) DisableMFA(ctx context.Context, userID string) error {
+	user, err := as.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if !user.TwoFactorEnabled {
+		return errors.New("not enabled")
+	}
+
+	return as.disableMFA(ctx, user)
+}
+
+func (as *AuthService) disableMFA(ctx context.Context, user *models.User) error {
+	userID := user.ID.Hex()
+
 	// Disable 2FA
 	updateFields := bson.M{
 		"twoFactorEnabled": false,
@@ -489,13 +624,15 @@ func (as *AuthService) Disable2FA(ctx context.Context, userID, password, code st
 		"updatedAt":        time.Now(),
 	}
 
-	err = as.userRepo.Update(ctx, userID, updateFields)
+	err := as.userRepo.Update(ctx, userID, updateFields)
 	if err != nil {
 		return err
 	}
 
 	// Send 2FA disabled email notification
-	go as.send2FADisabledEmail(user.Email, user.FirstName)
+	Background.Go(ctx, func(context.Context) {
+		as.send2FADisabledEmail(user.Email, user.FirstName)
+	})
 
 	// Log 2FA disabled
 	as.logSecurityEvent(ctx, userID, "2fa_disabled", nil)
@@ -559,7 +696,7 @@ func (as *AuthService) RefreshToken(ctx context.Context, refreshToken string) (*
 	}
 
 	// Get user info from token
-	claims, err := as.jwtService.ValidateToken(tokenPair.AccessToken)
+	claims, err := as.jwtService.ValidateToken(tokenPair.AccessToken, utils.AudienceAccess)
 	if err != nil {
 		return nil, errors.New("invalid token")
 	}
@@ -699,6 +836,20 @@ func (as *AuthService) Setup2FA(ctx context.Context, userID string) (*models.Two
 		return nil, err
 	}
 
+	// The secret only becomes active once a code from it is verified
+	encryptedSecret, err := utils.EncryptMFASecret(secret.Secret())
+	if err != nil {
+		return nil, err
+	}
+
+	err = as.userRepo.Update(ctx, userID, bson.M{
+		"pendingTwoFactorSecret":    encryptedSecret,
+		"pendingTwoFactorExpiresAt": time.Now().Add(mfaEnrollmentTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	// Generate QR code URL
 	qrCodeURL := secret.URL()
 
@@ -709,6 +860,9 @@ func (as *AuthService) Setup2FA(ctx context.Context, userID string) (*models.Two
 	}, nil
 }
 
+// Verify2FA enables 2FA once the user proves their app generates codes for
+// the secret issued by Setup2FA. A secret sent by older clients has to match
+// the issued one.
 func (as *AuthService) Verify2FA(ctx context.Context, userID, code, secret string) ([]string, error) {
 	user, err := as.userRepo.GetByID(ctx, userID)
 	if err != nil {
@@ -719,29 +873,32 @@ func (as *AuthService) Verify2FA(ctx context.Context, userID, code, secret strin
 		return nil, errors.New("already enabled")
 	}
 
+	if user.PendingTwoFactorSecret == "" || time.Now().After(user.PendingTwoFactorExpiresAt) {
+		return nil, errors.New("no pending enrollment")
+	}
+
+	if secret != "" {
+		pendingSecret, err := utils.DecryptMFASecret(user.PendingTwoFactorSecret)
+		if err != nil || pendingSecret != secret {
+			return nil, errors.New("invalid secret")
+		}
+	}
+
 	// Verify the code
-	valid, err := as.verify2FACode(secret, code)
-	if err != nil || !valid {
+	if err := as.checkMFAAttempts(ctx, userID); err != nil {
+		return nil, err
+	}
+	if !utils.ValidateTOTPCode(user.PendingTwoFactorSecret, code) {
+		as.recordFailedMFAAttempt(ctx, userID)
 		return nil, errors.New("invalid code")
 	}
+	as.clearFailedMFAAttempts(ctx, userID)
 
 	// Generate backup codes
-	backupCodes := as.generateBackupCodes(8)
-	hashedBackupCodes := make([]string, len(backupCodes))
-	for i, code := range backupCodes {
-		hash, _ := as.passwordService.HashPassword(code)
-		hashedBackupCodes[i] = hash
-	}
+	backupCodes, hashedBackupCodes := as.newBackupCodes()
 
 	// Enable 2FA for user
-	updateFields := bson.M{
-		"twoFactorEnabled": true,
-		"twoFactorSecret":  secret,
-		"backupCodes":      hashedBackupCodes,
-		"updatedAt":        time.Now(),
-	}
-
-	err = as.userRepo.Update(ctx, userID, updateFields)
+	err = as.userRepo.ActivateTwoFactor(ctx, userID, user.PendingTwoFactorSecret, hashedBackupCodes)
 	if err != nil {
 		return nil, err
 	}
@@ -793,19 +950,13 @@ func (as *AuthService) RegenerateBackupCodes(ctx context.Context, userID, passwo
 		return nil, errors.New("invalid password")
 	}
 
-	// Verify 2FA code
-	valid, err := as.verify2FACode(user.TwoFactorSecret, code)
-	if err != nil || !valid {
-		return nil, errors.New("invalid 2fa code")
+	// Verify 2FA code. A backup code can't be traded for a fresh set.
+	if err := as.verifyMFACode(ctx, user, code, false); err != nil {
+		return nil, err
 	}
 
 	// Generate new backup codes
-	backupCodes := as.generateBackupCodes(8)
-	hashedBackupCodes := make([]string, len(backupCodes))
-	for i, code := range backupCodes {
-		hash, _ := as.passwordService.HashPassword(code)
-		hashedBackupCodes[i] = hash
-	}
+	backupCodes, hashedBackupCodes := as.newBackupCodes()
 
 	// Update backup codes
 	updateFields := bson.M{
@@ -989,7 +1140,7 @@ func (as *AuthService) GetUserByID(ctx context.Context, userID string) (*models.
 }
 
 func (as *AuthService) ValidateToken(ctx context.Context, token string) (*models.User, error) {
-	claims, err := as.jwtService.ValidateToken(token)
+	claims, err := as.jwtService.ValidateToken(token, utils.AudienceAccess)
 	if err != nil {
 		return nil, err
 	}
@@ -1050,8 +1201,138 @@ func (as *AuthService) hashToken(token string) string {
 	return hex.EncodeToString(hash[:])
 }
 
-func (as *AuthService) verify2FACode(secret, code string) (bool, error) {
-	return totp.Validate(code, secret), nil
+// VerifySecondFactor checks a TOTP code presented in place of a recent
+// login. Failures count towards the same limit as login codes.
+func (as *AuthService) VerifySecondFactor(ctx context.Context, user *models.User, code string) error {
+	return as.verifyMFACode(ctx, user, code, false)
+}
+
+// verifyMFACode checks a TOTP code, or when allowed a backup code, which is
+// then used up. Failed attempts are limited per user.
+func (as *AuthService) verifyMFACode(ctx context.Context, user *models.User, code string, allowBackupCode bool) error {
+	userID := user.ID.Hex()
+
+	if err := as.checkMFAAttempts(ctx, userID); err != nil {
+		return err
+	}
+
+	if utils.ValidateTOTPCode(user.TwoFactorSecret, code) {
+		as.clearFailedMFAAttempts(ctx, userID)
+		return nil
+	}
+
+	if allowBackupCode {
+		normalized := strings.TrimSpace(code)
+		for _, hashedCode := range user.BackupCodes {
+			if valid, err := as.passwordService.ComparePassword(normalized, hashedCode); err != nil || !valid {
+				continue
+			}
+
+			consumed, err := as.userRepo.ConsumeBackupCode(ctx, userID, hashedCode)
+			if err != nil {
+				return err
+			}
+			if consumed {
+				as.clearFailedMFAAttempts(ctx, userID)
+				as.logSecurityEvent(ctx, userID, "backup_code_used", map[string]interface{}{
+					"remaining": len(user.BackupCodes) - 1,
+				})
+				return nil
+			}
+			break
+		}
+	}
+
+	as.recordFailedMFAAttempt(ctx, userID)
+	as.logSecurityEvent(ctx, userID, "failed_2fa", nil)
+	return errors.New("invalid 2fa code")
+}
+
+func (as *AuthService) checkMFAAttempts(ctx context.Context, userID string) error {
+	if as.redis == nil {
+		return nil
+	}
+
+	attempts, err := as.redis.Get(ctx, mfaAttemptsKey(userID)).Int()
+	if err == nil && attempts >= mfaMaxFailedAttempts {
+		return errors.New("too many 2fa attempts")
+	}
+	return nil
+}
+
+func (as *AuthService) recordFailedMFAAttempt(ctx context.Context, userID string) {
+	if as.redis == nil {
+		return
+	}
+
+	key := mfaAttemptsKey(userID)
+	pipe := as.redis.TxPipeline()
+	pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, mfaFailedAttemptsTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		logrus.Warn("Failed to record 2fa attempt: ", err)
+	}
+}
+
+func (as *AuthService) clearFailedMFAAttempts(ctx context.Context, userID string) {
+	if as.redis != nil {
+		as.redis.Del(ctx, mfaAttemptsKey(userID))
+	}
+}
+
+// startMFAChallenge parks a password-verified login until the second factor
+// is provided
+func (as *AuthService) startMFAChallenge(ctx context.Context, user *models.User, req models.LoginRequest) (*models.AuthResponse, error) {
+	token, err := as.generateSecureToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	// Never keep the password around
+	req.Password = ""
+	req.TwoFactorCode = ""
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := as.redis.Set(ctx, mfaChallengeKey(token), data, mfaChallengeTTL).Err(); err != nil {
+		logrus.Error("Failed to store mfa challenge: ", err)
+		return nil, errors.New("failed to start 2fa challenge")
+	}
+
+	methods := []string{"totp"}
+	if len(user.BackupCodes) > 0 {
+		methods = append(methods, "backup_code")
+	}
+
+	return &models.AuthResponse{
+		Requires2FA: true,
+		MFAChallenge: &models.MFAChallenge{
+			MFAToken:  token,
+			ExpiresIn: int64(mfaChallengeTTL.Seconds()),
+			Methods:   methods,
+		},
+	}, nil
+}
+
+func (as *AuthService) newBackupCodes() ([]string, []string) {
+	backupCodes := as.generateBackupCodes(mfaBackupCodeCount)
+	hashedBackupCodes := make([]string, len(backupCodes))
+	for i, code := range backupCodes {
+		hash, _ := as.passwordService.HashPassword(code)
+		hashedBackupCodes[i] = hash
+	}
+	return backupCodes, hashedBackupCodes
+}
+
+func mfaChallengeKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return fmt.Sprintf("mfa:challenge:%s", hex.EncodeToString(sum[:]))
+}
+
+func mfaAttemptsKey(userID string) string {
+	return fmt.Sprintf("mfa:attempts:%s", userID)
 }
 
 func (as *AuthService) generateBackupCodes(count int) []string {
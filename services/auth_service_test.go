@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"ftrack/models"
+	"ftrack/repositories"
+	"ftrack/testharness"
+	"ftrack/utils"
+
+	"github.com/pquerna/otp/totp"
+)
+
+const testPassword = "Correct-horse-9"
+
+func newTestAuthService(t *testing.T, env *testharness.Env) *AuthService {
+	t.Helper()
+	utils.ConfigureMFAEncryption("test mfa key")
+	return NewAuthService(env.Repos.User, repositories.NewUserSessionRepository(env.DB), utils.NewJWTService("secret"), nil, nil, testharness.Redis(t), &models.AuthConfig{})
+}
+
+// withPassword stores the user with a hash of testPassword
+func withPassword(t *testing.T) func(*models.User) {
+	hash, err := utils.NewPasswordService().HashPassword(testPassword)
+	if err != nil {
+		t.Fatalf("hashing password: %v", err)
+	}
+	return func(user *models.User) {
+		user.Password = hash
+	}
+}
+
+func TestAuthServiceMFAFlow(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	as := newTestAuthService(t, env)
+	ctx := context.Background()
+
+	user := env.Factory.User(withPassword(t))
+	userID := user.ID.Hex()
+	login := models.LoginRequest{Email: user.Email, Password: testPassword}
+
+	// Enroll
+	setup, err := as.Setup2FA(ctx, userID)
+	if err != nil {
+		t.Fatalf("Setup2FA: %v", err)
+	}
+	if setup.Secret == "" || !strings.HasPrefix(setup.QRCodeURL, "otpauth://totp/") {
+		t.Fatalf("setup = %+v, want a secret and provisioning URI", setup)
+	}
+	stored, _ := env.Repos.User.GetByID(ctx, userID)
+	if stored.TwoFactorEnabled || stored.PendingTwoFactorSecret == "" || strings.Contains(stored.PendingTwoFactorSecret, setup.Secret) {
+		t.Errorf("pending secret stored as %q, enabled %v; want encrypted and not enabled", stored.PendingTwoFactorSecret, stored.TwoFactorEnabled)
+	}
+
+	// Until verified, logins need no second step
+	if response, err := as.Login(ctx, login); err != nil || response.Requires2FA || response.AccessToken == "" {
+		t.Fatalf("login before verifying = %+v, %v; want tokens", response, err)
+	}
+
+	// Verify
+	if _, err := as.Verify2FA(ctx, userID, "abcdef", ""); err == nil || err.Error() != "invalid code" {
+		t.Errorf("Verify2FA with a wrong code error = %v, want invalid code", err)
+	}
+	backupCodes, err := as.Verify2FA(ctx, userID, totpCode(t, setup.Secret), "")
+	if err != nil {
+		t.Fatalf("Verify2FA: %v", err)
+	}
+	if len(backupCodes) != mfaBackupCodeCount {
+		t.Errorf("%d backup codes issued, want %d", len(backupCodes), mfaBackupCodeCount)
+	}
+	if _, err := as.Setup2FA(ctx, userID); err == nil || err.Error() != "already enabled" {
+		t.Errorf("Setup2FA once enabled error = %v, want already enabled", err)
+	}
+
+	// Login now stops at a challenge
+	challenge, err := as.Login(ctx, login)
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if !challenge.Requires2FA || challenge.MFAChallenge == nil || challenge.AccessToken != "" {
+		t.Fatalf("login with MFA = %+v, want a challenge and no tokens", challenge)
+	}
+	token := challenge.MFAChallenge.MFAToken
+
+	if _, err := as.CompleteMFALogin(ctx, models.MFALoginRequest{MFAToken: token, Code: "abcdef"}); err == nil || err.Error() != "invalid 2fa code" {
+		t.Errorf("CompleteMFALogin with a wrong code error = %v, want invalid 2fa code", err)
+	}
+	response, err := as.CompleteMFALogin(ctx, models.MFALoginRequest{MFAToken: token, Code: totpCode(t, setup.Secret)})
+	if err != nil {
+		t.Fatalf("CompleteMFALogin: %v", err)
+	}
+	if response.AccessToken == "" || response.RefreshToken == "" {
+		t.Errorf("completed login = %+v, want tokens", response)
+	}
+	if _, err := as.CompleteMFALogin(ctx, models.MFALoginRequest{MFAToken: token, Code: totpCode(t, setup.Secret)}); err == nil || err.Error() != "invalid or expired mfa token" {
+		t.Errorf("reusing the challenge error = %v, want invalid or expired mfa token", err)
+	}
+
+	// A backup code works once, in a single step
+	withBackup := login
+	withBackup.TwoFactorCode = backupCodes[0]
+	if response, err := as.Login(ctx, withBackup); err != nil || response.AccessToken == "" {
+		t.Fatalf("login with a backup code = %+v, %v; want tokens", response, err)
+	}
+	if _, err := as.Login(ctx, withBackup); err == nil || err.Error() != "invalid 2fa code" {
+		t.Errorf("reusing a backup code error = %v, want invalid 2fa code", err)
+	}
+}
+
+func TestAuthServiceMFAAttemptLimit(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	as := newTestAuthService(t, env)
+	ctx := context.Background()
+
+	user := env.Factory.User(withPassword(t))
+	setup, err := as.Setup2FA(ctx, user.ID.Hex())
+	if err != nil {
+		t.Fatalf("Setup2FA: %v", err)
+	}
+	if _, err := as.Verify2FA(ctx, user.ID.Hex(), totpCode(t, setup.Secret), ""); err != nil {
+		t.Fatalf("Verify2FA: %v", err)
+	}
+
+	login := models.LoginRequest{Email: user.Email, Password: testPassword, TwoFactorCode: "abcdef"}
+	for i := 0; i < mfaMaxFailedAttempts; i++ {
+		if _, err := as.Login(ctx, login); err == nil || err.Error() != "invalid 2fa code" {
+			t.Fatalf("attempt %d error = %v, want invalid 2fa code", i+1, err)
+		}
+	}
+
+	// Once over the limit even the right code is refused
+	login.TwoFactorCode = totpCode(t, setup.Secret)
+	if _, err := as.Login(ctx, login); err == nil || err.Error() != "too many 2fa attempts" {
+		t.Errorf("login past the limit error = %v, want too many 2fa attempts", err)
+	}
+}
+
+func totpCode(t *testing.T, secret string) string {
+	t.Helper()
+	code, err := totp.GenerateCode(secret, time.Now())
+	if err != nil {
+		t.Fatalf("generating code: %v", err)
+	}
+	return code
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"ftrack/models"
+	"ftrack/repositories"
+	"ftrack/utils"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Other instances pick up rotated keys this often
+const signingKeyRefreshInterval = 5 * time.Minute
+
+// ConfigureSigningKeys signs tokens with the key ring, including keys
+// rotated into the keys collection
+func (as *AuthService) ConfigureSigningKeys(jwtService *utils.JWTService, signingKeyRepo *repositories.SigningKeyRepository) {
+	as.jwtService = jwtService
+	as.signingKeyRepo = signingKeyRepo
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := as.LoadSigningKeys(ctx); err != nil {
+		logrus.Errorf("Failed to load signing keys: %v", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(signingKeyRefreshInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			if err := as.LoadSigningKeys(ctx); err != nil {
+				logrus.Warnf("Failed to refresh signing keys: %v", err)
+			}
+			cancel()
+		}
+	}()
+}
+
+// LoadSigningKeys loads the stored keys into the key ring
+func (as *AuthService) LoadSigningKeys(ctx context.Context) error {
+	if as.signingKeyRepo == nil {
+		return nil
+	}
+
+	stored, err := as.signingKeyRepo.GetUsable(ctx)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]utils.SigningKey, 0, len(stored))
+	for _, key := range stored {
+		secret, err := decryptSigningKey(key.Secret)
+		if err != nil {
+			logrus.Errorf("Failed to decrypt signing key %s: %v", key.KeyID, err)
+			continue
+		}
+
+		ringKey := utils.SigningKey{
+			ID:        key.KeyID,
+			Secret:    secret,
+			CreatedAt: key.CreatedAt,
+		}
+		if key.ExpiresAt != nil {
+			ringKey.ExpiresAt = *key.ExpiresAt
+		}
+		keys = append(keys, ringKey)
+	}
+
+	as.jwtService.SetKeys(keys)
+	return nil
+}
+
+// RotateSigningKey makes a new key sign tokens. Previous keys keep verifying
+// until tokens signed with them have expired, so nobody is logged out.
+func (as *AuthService) RotateSigningKey(ctx context.Context, adminID string) (*models.SigningKey, error) {
+	if as.signingKeyRepo == nil {
+		return nil, errors.New("signing key storage not configured")
+	}
+
+	key, err := utils.NewSigningKey()
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted, err := utils.EncryptMFASecret(base64.StdEncoding.EncodeToString(key.Secret))
+	if err != nil {
+		return nil, err
+	}
+
+	stored := &models.SigningKey{
+		KeyID:     key.ID,
+		Secret:    encrypted,
+		CreatedBy: adminID,
+	}
+	if err := as.signingKeyRepo.Rotate(ctx, stored, time.Now().Add(as.jwtService.RefreshTokenTTL())); err != nil {
+		return nil, err
+	}
+
+	if err := as.LoadSigningKeys(ctx); err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("Signing key rotated to %s by admin %s", key.ID, adminID)
+	return stored, nil
+}
+
+// GetSigningKeys returns the stored keys that still verify tokens
+func (as *AuthService) GetSigningKeys(ctx context.Context) ([]models.SigningKey, error) {
+	if as.signingKeyRepo == nil {
+		return []models.SigningKey{}, nil
+	}
+	return as.signingKeyRepo.GetUsable(ctx)
+}
+
+// IssueWSTicket issues a single-use ticket for opening a WebSocket
+func (as *AuthService) IssueWSTicket(ctx context.Context, userID string) (*models.WSTicketResponse, error) {
+	ticket, err := as.jwtService.GenerateWSTicket(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.WSTicketResponse{
+		Ticket:    ticket,
+		ExpiresAt: time.Now().Add(as.jwtService.WSTicketTTL()),
+	}, nil
+}
+
+// ValidateWSTicket returns the user of a WebSocket ticket. Tickets can only
+// be used once.
+func (as *AuthService) ValidateWSTicket(ctx context.Context, ticket string) (*models.User, error) {
+	claims, err := as.jwtService.ValidateToken(ticket, utils.AudienceWSTicket)
+	if err != nil {
+		return nil, errors.New("invalid ticket")
+	}
+
+	if as.redis != nil {
+		firstUse, err := as.redis.SetNX(ctx, "ws:ticket:"+claims.ID, 1, as.jwtService.WSTicketTTL()).Result()
+		if err != nil {
+			return nil, err
+		}
+		if !firstUse {
+			return nil, errors.New("invalid ticket")
+		}
+	}
+
+	return as.userRepo.GetByID(ctx, claims.UserID)
+}
+
+// Stored key secrets are encrypted like TOTP secrets
+func decryptSigningKey(stored string) ([]byte, error) {
+	encoded, err := utils.DecryptMFASecret(stored)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(encoded)
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"ftrack/models"
+	"ftrack/testharness"
+)
+
+// notifyRule is a rule sending its owner note whenever a message contains
+// keyword
+func notifyRule(circle *models.Circle, name, keyword, note string, priority int, stopOnMatch bool) models.CreateAutomationRuleRequest {
+	return models.CreateAutomationRuleRequest{
+		Name:        name,
+		Type:        "keyword_trigger",
+		CircleID:    circle.ID.Hex(),
+		Conditions:  []models.RuleCondition{{Type: "keyword", Operator: "contains", Value: keyword}},
+		Actions:     []models.RuleAction{{Type: "notify", Config: map[string]interface{}{"message": note}}},
+		IsActive:    true,
+		Priority:    priority,
+		StopOnMatch: stopOnMatch,
+	}
+}
+
+// ruleNotes returns the notes the user's rules sent since the last call,
+// in order
+func ruleNotes(env *testharness.Env, user *models.User, seen *int) string {
+	var notes []string
+	messages := env.Hub.UserMessages(user.ID.Hex())
+	for _, message := range messages[*seen:] {
+		notes = append(notes, message.Notification.(map[string]interface{})["message"].(string))
+	}
+	*seen = len(messages)
+	return strings.Join(notes, ",")
+}
+
+func TestAutomationRulePriorityOrder(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ms := newTestMessageService(env)
+	ctx := context.Background()
+
+	alice, bob := env.Factory.User(), env.Factory.User()
+	circle := env.Factory.Circle(alice, []*models.User{bob})
+
+	// Created out of order; mid and tie share a priority
+	for _, req := range []models.CreateAutomationRuleRequest{
+		notifyRule(circle, "low", "hello", "low", 1, false),
+		notifyRule(circle, "mid", "hello", "mid", 50, false),
+		notifyRule(circle, "high", "hello", "high", 100, false),
+		notifyRule(circle, "tie", "hello", "tie", 50, false),
+		notifyRule(circle, "unmatched", "goodbye", "unmatched", 200, true),
+	} {
+		if _, err := ms.CreateAutomationRule(ctx, alice.ID.Hex(), req); err != nil {
+			t.Fatalf("CreateAutomationRule %s: %v", req.Name, err)
+		}
+	}
+
+	rules, err := ms.GetAutomationRules(ctx, alice.ID.Hex(), models.GetAutomationRulesRequest{})
+	if err != nil {
+		t.Fatalf("GetAutomationRules: %v", err)
+	}
+	var names []string
+	for _, rule := range rules.Rules {
+		names = append(names, rule.Name)
+	}
+	if got := strings.Join(names, ","); got != "unmatched,high,mid,tie,low" {
+		t.Errorf("rules listed as %s, want highest priority first, older first on a tie", got)
+	}
+
+	// Matching rules run in the same order. A stop-on-match rule that
+	// doesn't match stops nothing.
+	seen := 0
+	ms.ProcessAutomationRules(ctx, env.Factory.Message(circle, bob, "hello there"))
+	if got := ruleNotes(env, alice, &seen); got != "high,mid,tie,low" {
+		t.Errorf("rules ran as %s, want high,mid,tie,low", got)
+	}
+
+	// Runs are the same every time
+	for i := 0; i < 3; i++ {
+		ms.ProcessAutomationRules(ctx, env.Factory.Message(circle, bob, "hello again"))
+		if got := ruleNotes(env, alice, &seen); got != "high,mid,tie,low" {
+			t.Fatalf("run %d ran rules as %s, want the same order", i+2, got)
+		}
+	}
+}
+
+func TestAutomationRuleStopOnMatch(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ms := newTestMessageService(env)
+	ctx := context.Background()
+
+	alice, bob, carol := env.Factory.User(), env.Factory.User(), env.Factory.User()
+	circle := env.Factory.Circle(alice, []*models.User{bob, carol})
+
+	create := func(user *models.User, req models.CreateAutomationRuleRequest) *models.AutomationRule {
+		t.Helper()
+		rule, err := ms.CreateAutomationRule(ctx, user.ID.Hex(), req)
+		if err != nil {
+			t.Fatalf("CreateAutomationRule %s: %v", req.Name, err)
+		}
+		return rule
+	}
+	create(alice, notifyRule(circle, "notify", "urgent", "notify", 100, false))
+	mute := create(alice, notifyRule(circle, "mute", "urgent", "mute", 50, false))
+	create(alice, notifyRule(circle, "digest", "urgent", "digest", 10, false))
+	create(carol, notifyRule(circle, "carol first", "urgent", "carol first", 1000, true))
+	create(carol, notifyRule(circle, "carol later", "urgent", "carol later", 0, false))
+
+	stop := true
+	if _, err := ms.UpdateAutomationRule(ctx, alice.ID.Hex(), mute.ID.Hex(), models.UpdateAutomationRuleRequest{StopOnMatch: &stop}); err != nil {
+		t.Fatalf("UpdateAutomationRule: %v", err)
+	}
+
+	// The stopping rule runs, the owner's lower ones don't; another
+	// member's stopping rule doesn't reach them
+	aliceSeen, carolSeen := 0, 0
+	ms.ProcessAutomationRules(ctx, env.Factory.Message(circle, bob, "urgent: call me"))
+	if got := ruleNotes(env, alice, &aliceSeen); got != "notify,mute" {
+		t.Errorf("alice's rules ran as %s, want notify,mute", got)
+	}
+	if got := ruleNotes(env, carol, &carolSeen); got != "carol first" {
+		t.Errorf("carol's rules ran as %s, want only carol first", got)
+	}
+
+	// Raising the stopping rule above the others leaves it alone
+	top := models.MaxAutomationRulePriority
+	if _, err := ms.UpdateAutomationRule(ctx, alice.ID.Hex(), mute.ID.Hex(), models.UpdateAutomationRuleRequest{Priority: &top}); err != nil {
+		t.Fatalf("UpdateAutomationRule: %v", err)
+	}
+	ms.ProcessAutomationRules(ctx, env.Factory.Message(circle, bob, "urgent again"))
+	if got := ruleNotes(env, alice, &aliceSeen); got != "mute" {
+		t.Errorf("alice's rules ran as %s, want only mute", got)
+	}
+
+	// Priorities stay in range
+	if _, err := ms.CreateAutomationRule(ctx, alice.ID.Hex(), notifyRule(circle, "over", "x", "x", models.MaxAutomationRulePriority+1, false)); err == nil || err.Error() != "validation failed" {
+		t.Errorf("creating a rule over the top priority error = %v, want validation failed", err)
+	}
+	over := models.MaxAutomationRulePriority + 1
+	if _, err := ms.UpdateAutomationRule(ctx, alice.ID.Hex(), mute.ID.Hex(), models.UpdateAutomationRuleRequest{Priority: &over}); err == nil || err.Error() != "validation failed" {
+		t.Errorf("raising a rule over the top priority error = %v, want validation failed", err)
+	}
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// BackgroundTasks runs the side effects services start besides answering a
+// request, like broadcasts and notifications, so shutdown can wait for
+// them instead of cutting them off mid-write. Long jobs that resume from
+// their own records, like merges and exports, don't run here.
+type BackgroundTasks struct {
+	mutex   sync.Mutex
+	running sync.WaitGroup
+	pending atomic.Int64
+	closed  bool
+
+	// Cancelled when shutdown stops waiting
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Background runs the services' background tasks
+var Background = NewBackgroundTasks()
+
+func NewBackgroundTasks() *BackgroundTasks {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &BackgroundTasks{
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Go runs the task in the background. Its context keeps the values of ctx
+// but outlives it, and is only cancelled when shutdown gives up waiting.
+// Once shutdown has started, the task runs before Go returns instead.
+func (bt *BackgroundTasks) Go(ctx context.Context, task func(ctx context.Context)) {
+	bt.mutex.Lock()
+	if bt.closed {
+		bt.mutex.Unlock()
+		bt.run(ctx, task)
+		return
+	}
+	bt.running.Add(1)
+	bt.pending.Add(1)
+	bt.mutex.Unlock()
+
+	go func() {
+		defer bt.running.Done()
+		defer bt.pending.Add(-1)
+		bt.run(ctx, task)
+	}()
+}
+
+func (bt *BackgroundTasks) run(ctx context.Context, task func(ctx context.Context)) {
+	taskCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+	stop := context.AfterFunc(bt.ctx, cancel)
+	defer stop()
+
+	task(taskCtx)
+}
+
+// Pending counts the tasks still running
+func (bt *BackgroundTasks) Pending() int64 {
+	return bt.pending.Load()
+}
+
+// Shutdown waits for the running tasks to finish. Tasks started from then
+// on run in their caller. If ctx ends first, the running tasks' contexts
+// are cancelled and ctx's error is returned.
+func (bt *BackgroundTasks) Shutdown(ctx context.Context) error {
+	bt.mutex.Lock()
+	bt.closed = true
+	bt.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		bt.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		bt.cancel()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackgroundShutdownWaitsForPendingTasks(t *testing.T) {
+	bt := NewBackgroundTasks()
+
+	release := make(chan struct{})
+	var finished atomic.Int32
+	for i := 0; i < 3; i++ {
+		bt.Go(context.Background(), func(ctx context.Context) {
+			<-release
+			finished.Add(1)
+		})
+	}
+	if pending := bt.Pending(); pending != 3 {
+		t.Fatalf("Pending() = %d, want 3", pending)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- bt.Shutdown(context.Background())
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown returned before the tasks finished: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if got := finished.Load(); got != 3 {
+		t.Errorf("%d tasks finished before Shutdown returned, want 3", got)
+	}
+	if pending := bt.Pending(); pending != 0 {
+		t.Errorf("Pending() after shutdown = %d, want 0", pending)
+	}
+}
+
+func TestBackgroundTaskOutlivesRequest(t *testing.T) {
+	bt := NewBackgroundTasks()
+
+	type key struct{}
+	requestCtx, cancel := context.WithCancel(context.WithValue(context.Background(), key{}, "request"))
+
+	started := make(chan struct{})
+	result := make(chan error, 1)
+	bt.Go(requestCtx, func(ctx context.Context) {
+		close(started)
+		time.Sleep(20 * time.Millisecond)
+		if ctx.Value(key{}) != "request" {
+			result <- errors.New("task lost the request's values")
+			return
+		}
+		result <- ctx.Err()
+	})
+
+	// The request ends while its side effects still run
+	<-started
+	cancel()
+
+	if err := bt.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if err := <-result; err != nil {
+		t.Errorf("task context: %v, want it live after the request ended", err)
+	}
+}
+
+func TestBackgroundShutdownTimeoutCancelsTasks(t *testing.T) {
+	bt := NewBackgroundTasks()
+
+	cancelled := make(chan struct{})
+	bt.Go(context.Background(), func(ctx context.Context) {
+		<-ctx.Done()
+		close(cancelled)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := bt.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("task context not cancelled when shutdown gave up waiting")
+	}
+}
+
+func TestBackgroundRunsInlineAfterShutdown(t *testing.T) {
+	bt := NewBackgroundTasks()
+	if err := bt.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	ran := false
+	bt.Go(context.Background(), func(ctx context.Context) {
+		ran = true
+	})
+	if !ran {
+		t.Error("task started after shutdown didn't run before Go returned")
+	}
+}
+
+func TestWaitCountdownStopsAtShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	started := time.Now()
+	if err := waitCountdown(ctx, time.Minute); !errors.Is(err, context.Canceled) {
+		t.Fatalf("waitCountdown error = %v, want %v", err, context.Canceled)
+	}
+	if time.Since(started) > time.Second {
+		t.Error("waitCountdown kept waiting after its context ended")
+	}
+
+	if err := waitCountdown(context.Background(), time.Millisecond); err != nil {
+		t.Errorf("waitCountdown: %v", err)
+	}
+}
@@ -0,0 +1,284 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"ftrack/models"
+	"ftrack/repositories"
+	"ftrack/utils"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CalendarFeedService serves private iCal feeds of what's scheduled in a
+// user's circles: the messages they scheduled there, and the days the
+// circles' places keep special hours. Feeds are rendered when fetched, so
+// they always reflect the circles the user is still in.
+type CalendarFeedService struct {
+	feedRepo     *repositories.CalendarFeedRepository
+	circleRepo   *repositories.CircleRepository
+	placeRepo    *repositories.PlaceRepository
+	scheduleRepo *repositories.ScheduleRepository
+	placeService *PlaceService
+	baseURL      string
+	validator    *utils.ValidationService
+}
+
+func NewCalendarFeedService(
+	feedRepo *repositories.CalendarFeedRepository,
+	circleRepo *repositories.CircleRepository,
+	placeRepo *repositories.PlaceRepository,
+	scheduleRepo *repositories.ScheduleRepository,
+	placeService *PlaceService,
+	baseURL string,
+) *CalendarFeedService {
+	return &CalendarFeedService{
+		feedRepo:     feedRepo,
+		circleRepo:   circleRepo,
+		placeRepo:    placeRepo,
+		scheduleRepo: scheduleRepo,
+		placeService: placeService,
+		baseURL:      baseURL,
+		validator:    utils.NewValidationService(),
+	}
+}
+
+// CreateFeed creates a feed of the selected circles. Its link is returned
+// only here.
+func (cfs *CalendarFeedService) CreateFeed(ctx context.Context, userID string, req models.CreateCalendarFeedRequest) (*models.CreatedCalendarFeed, error) {
+	if validationErrors := cfs.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	count, err := cfs.feedRepo.CountUserFeeds(ctx, userObjectID)
+	if err != nil {
+		return nil, err
+	}
+	if count >= models.MaxCalendarFeeds {
+		return nil, errors.New("calendar feed limit reached")
+	}
+
+	var circleIDs []primitive.ObjectID
+	var circleNames []string
+	seen := make(map[string]bool)
+	for _, circleID := range req.CircleIDs {
+		if seen[circleID] {
+			continue
+		}
+		seen[circleID] = true
+
+		isMember, err := cfs.circleRepo.IsMember(ctx, circleID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if !isMember {
+			return nil, errors.New("access denied")
+		}
+
+		circle, err := cfs.circleRepo.GetByID(ctx, circleID)
+		if err != nil {
+			return nil, err
+		}
+		circleIDs = append(circleIDs, circle.ID)
+		circleNames = append(circleNames, circle.Name)
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = strings.Join(circleNames, ", ")
+	}
+
+	token, err := newCalendarFeedToken()
+	if err != nil {
+		return nil, err
+	}
+
+	feed := &models.CalendarFeed{
+		UserID:    userObjectID,
+		Name:      name,
+		CircleIDs: circleIDs,
+		TokenHash: calendarFeedTokenHash(token),
+	}
+	if err := cfs.feedRepo.Create(ctx, feed); err != nil {
+		return nil, err
+	}
+
+	return &models.CreatedCalendarFeed{
+		CalendarFeed: *feed,
+		URL:          fmt.Sprintf("%s/api/v1/calendar/%s.ics", strings.TrimRight(cfs.baseURL, "/"), token),
+	}, nil
+}
+
+func (cfs *CalendarFeedService) GetFeeds(ctx context.Context, userID string) ([]models.CalendarFeed, error) {
+	return cfs.feedRepo.GetUserFeeds(ctx, userID)
+}
+
+// RevokeFeed deletes the feed, so its link stops working
+func (cfs *CalendarFeedService) RevokeFeed(ctx context.Context, userID, feedID string) error {
+	return cfs.feedRepo.Delete(ctx, userID, feedID)
+}
+
+// RenderFeed renders the feed with the token. Circles the user has left
+// since the feed was created are left out. The calendar is stamped with the
+// start of the cache period, so it renders the same until something
+// changes and can be revalidated by its ETag.
+func (cfs *CalendarFeedService) RenderFeed(ctx context.Context, token string) ([]byte, error) {
+	feed, err := cfs.feedRepo.GetByTokenHash(ctx, calendarFeedTokenHash(token))
+	if err != nil {
+		return nil, err
+	}
+
+	userID := feed.UserID.Hex()
+	now := time.Now()
+	calendar := utils.NewICalendar(feed.Name)
+
+	for _, circleID := range feed.CircleIDs {
+		isMember, err := cfs.circleRepo.IsMember(ctx, circleID.Hex(), userID)
+		if err != nil {
+			return nil, err
+		}
+		if !isMember {
+			continue
+		}
+
+		circle, err := cfs.circleRepo.GetByID(ctx, circleID.Hex())
+		if err != nil {
+			if err.Error() == "circle not found" {
+				continue
+			}
+			return nil, err
+		}
+
+		if err := cfs.addScheduledMessages(ctx, calendar, userID, circle); err != nil {
+			return nil, err
+		}
+		if err := cfs.addPlaceHours(ctx, calendar, circle, now); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfs.feedRepo.MarkFetched(ctx, feed.ID); err != nil {
+		logrus.Warnf("Failed to record fetch of calendar feed %s: %v", feed.ID.Hex(), err)
+	}
+
+	return calendar.Render(now.Truncate(models.CalendarFeedCacheMaxAge)), nil
+}
+
+// addScheduledMessages adds the messages the user scheduled in the circle
+// that haven't been sent yet. Other members' scheduled messages stay
+// private to them.
+func (cfs *CalendarFeedService) addScheduledMessages(ctx context.Context, calendar *utils.ICalendar, userID string, circle *models.Circle) error {
+	scheduled, err := cfs.scheduleRepo.GetByCircle(ctx, circle.ID.Hex(), "pending")
+	if err != nil {
+		return err
+	}
+
+	for _, message := range scheduled {
+		if message.UserID.Hex() != userID {
+			continue
+		}
+
+		description := message.Content
+		if message.Type != "text" {
+			description = fmt.Sprintf("A %s message", message.Type)
+		}
+
+		calendar.AddEvent(utils.ICalEvent{
+			UID:          fmt.Sprintf("scheduled-message-%s@ftrack", message.ID.Hex()),
+			Summary:      fmt.Sprintf("Scheduled message to %s", circle.Name),
+			Description:  description,
+			Start:        message.ScheduledAt,
+			LastModified: message.UpdatedAt,
+		})
+	}
+	return nil
+}
+
+// addPlaceHours adds the days the circle's places don't keep their regular
+// hours, over the feed's horizon. Recurring overrides are expanded into
+// their days rather than written as rules, since holiday sets have no
+// iCalendar equivalent.
+func (cfs *CalendarFeedService) addPlaceHours(ctx context.Context, calendar *utils.ICalendar, circle *models.Circle, now time.Time) error {
+	places, err := cfs.placeRepo.GetCirclePlaces(ctx, circle.ID.Hex())
+	if err != nil {
+		return err
+	}
+
+	for i := range places {
+		place := &places[i]
+		days, err := cfs.placeService.UpcomingHoursExceptions(ctx, place, now, models.CalendarFeedHorizonDays)
+		if err != nil {
+			return err
+		}
+
+		location := placeHoursLocation(place.Hours)
+		for _, day := range days {
+			date, err := time.ParseInLocation("2006-01-02", day.Date, location)
+			if err != nil {
+				continue
+			}
+
+			event := utils.ICalEvent{
+				UID:          fmt.Sprintf("place-hours-%s-%s@ftrack", place.ID.Hex(), day.Date),
+				Description:  placeHoursDescription(day),
+				Location:     place.Address,
+				LastModified: place.UpdatedAt,
+			}
+
+			interval, isOpen := openInterval(day, date)
+			switch {
+			case !isOpen:
+				event.Summary = fmt.Sprintf("%s closed", place.Name)
+				event.Start, event.End, event.AllDay = date, date.AddDate(0, 0, 1), true
+			case day.StartTime == "":
+				event.Summary = fmt.Sprintf("%s open all day", place.Name)
+				event.Start, event.End, event.AllDay = date, date.AddDate(0, 0, 1), true
+			default:
+				event.Summary = fmt.Sprintf("%s special hours", place.Name)
+				event.Start, event.End, event.TimeZone = interval.start, interval.end, location
+			}
+			calendar.AddEvent(event)
+		}
+	}
+	return nil
+}
+
+// placeHoursDescription says why the day's hours differ
+func placeHoursDescription(day models.PlaceDayHours) string {
+	var parts []string
+	if day.Holiday != "" {
+		parts = append(parts, day.Holiday)
+	} else if day.OverrideName != "" {
+		parts = append(parts, day.OverrideName)
+	}
+	if day.Note != "" {
+		parts = append(parts, day.Note)
+	}
+	return strings.Join(parts, "\n")
+}
+
+func newCalendarFeedToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+func calendarFeedTokenHash(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
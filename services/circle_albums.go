@@ -0,0 +1,469 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"ftrack/models"
+	"ftrack/repositories"
+	"ftrack/utils"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ConfigureAlbums lets the circle suggest albums from photos shared in chat
+// during visits to its places
+func (cs *CircleService) ConfigureAlbums(albumRepo *repositories.AlbumRepository, messageRepo *repositories.MessageRepository, placeRepo *repositories.PlaceRepository) {
+	cs.albumRepo = albumRepo
+	cs.messageRepo = messageRepo
+	cs.placeRepo = placeRepo
+}
+
+// ConfigureAlbums takes photos out of circle albums when they are removed
+// from chat
+func (ms *MessageService) ConfigureAlbums(albumRepo *repositories.AlbumRepository) {
+	ms.albumRepo = albumRepo
+}
+
+// GenerateAlbumSuggestions looks at the photos shared since the given time
+// in every circle and suggests albums for them, or adds them to the album
+// of the visit they belong to. It returns how many albums were suggested.
+func (cs *CircleService) GenerateAlbumSuggestions(ctx context.Context, since time.Time) (int, error) {
+	if cs.albumRepo == nil {
+		return 0, nil
+	}
+
+	circleIDs, err := cs.messageRepo.GetCirclesWithMediaSince(ctx, "image", since)
+	if err != nil {
+		return 0, err
+	}
+
+	suggested := 0
+	for _, circleID := range circleIDs {
+		if ctx.Err() != nil {
+			return suggested, ctx.Err()
+		}
+
+		created, err := cs.generateCircleAlbums(ctx, circleID, since)
+		if err != nil {
+			logrus.Errorf("Failed to generate albums for circle %s: %v", circleID.Hex(), err)
+			continue
+		}
+		suggested += created
+	}
+
+	return suggested, nil
+}
+
+// generateCircleAlbums clusters the circle's photos by the place their
+// sender was visiting when sharing them, and splits each place's photos
+// where they pause for longer than the cluster gap
+func (cs *CircleService) generateCircleAlbums(ctx context.Context, circleID primitive.ObjectID, since time.Time) (int, error) {
+	messages, err := cs.messageRepo.GetCircleMediaSince(ctx, circleID, "image", since)
+	if err != nil {
+		return 0, err
+	}
+	if len(messages) == 0 {
+		return 0, nil
+	}
+
+	// Visits that started a day before the window still cover its photos
+	visits := make(map[primitive.ObjectID][]models.PlaceVisit)
+	for _, message := range messages {
+		if _, loaded := visits[message.SenderID]; loaded {
+			continue
+		}
+		userVisits, err := cs.placeRepo.GetUserVisitsSince(ctx, message.SenderID.Hex(), since.Add(-24*time.Hour))
+		if err != nil {
+			return 0, err
+		}
+		visits[message.SenderID] = userVisits
+	}
+
+	byPlace := make(map[primitive.ObjectID][]models.Message)
+	var placeOrder []primitive.ObjectID
+	for _, message := range messages {
+		placeID, ok := visitedPlaceAt(visits[message.SenderID], message.CreatedAt)
+		if !ok {
+			continue
+		}
+		if _, seen := byPlace[placeID]; !seen {
+			placeOrder = append(placeOrder, placeID)
+		}
+		byPlace[placeID] = append(byPlace[placeID], message)
+	}
+
+	suggested := 0
+	for _, placeID := range placeOrder {
+		// Only the circle's own places; a member's personal places stay private
+		place, err := cs.placeRepo.GetByID(ctx, placeID.Hex())
+		if err != nil || place.CircleID != circleID {
+			continue
+		}
+
+		for _, cluster := range clusterPhotos(byPlace[placeID]) {
+			created, err := cs.saveAlbumCluster(ctx, circleID, place, cluster)
+			if err != nil {
+				return suggested, err
+			}
+			if created {
+				suggested++
+			}
+		}
+	}
+
+	return suggested, nil
+}
+
+// saveAlbumCluster adds the photos to the album of the same visit, or
+// suggests a new album for them when there are enough. Photos of a
+// dismissed suggestion aren't suggested again.
+func (cs *CircleService) saveAlbumCluster(ctx context.Context, circleID primitive.ObjectID, place *models.Place, cluster []models.Message) (bool, error) {
+	start := cluster[0].CreatedAt
+	end := cluster[len(cluster)-1].CreatedAt
+
+	album, err := cs.albumRepo.FindOverlapping(ctx, circleID, place.ID, start, end)
+	if err != nil {
+		return false, err
+	}
+	if album != nil && album.Status == models.AlbumStatusDismissed {
+		return false, nil
+	}
+
+	created := false
+	if album == nil {
+		senders := make(map[primitive.ObjectID]bool)
+		for _, message := range cluster {
+			senders[message.SenderID] = true
+		}
+		if len(cluster) < models.AlbumMinPhotos || len(senders) < models.AlbumMinContributors {
+			return false, nil
+		}
+
+		album = &models.CircleAlbum{
+			CircleID:     circleID,
+			PlaceID:      place.ID,
+			PlaceName:    place.Name,
+			Title:        fmt.Sprintf("%s, %s", place.Name, start.Format("Jan 2")),
+			Status:       models.AlbumStatusSuggested,
+			StartTime:    start,
+			EndTime:      end,
+			Contributors: []models.AlbumContributor{},
+		}
+		if err := cs.albumRepo.Create(ctx, album); err != nil {
+			return false, err
+		}
+		created = true
+	}
+
+	photos := make([]models.AlbumPhoto, 0, len(cluster))
+	for _, message := range cluster {
+		photos = append(photos, models.AlbumPhoto{
+			AlbumID:      album.ID,
+			CircleID:     circleID,
+			MessageID:    message.ID,
+			MediaID:      message.Media.ID,
+			UserID:       message.SenderID,
+			URL:          message.Media.URL,
+			ThumbnailURL: message.Media.ThumbnailURL,
+			TakenAt:      message.CreatedAt,
+		})
+	}
+	if err := cs.albumRepo.AddPhotos(ctx, photos); err != nil {
+		return created, err
+	}
+
+	return created, cs.albumRepo.Refresh(ctx, album.ID)
+}
+
+// visitedPlaceAt returns the place of the visit that was under way at the
+// given time
+func visitedPlaceAt(visits []models.PlaceVisit, at time.Time) (primitive.ObjectID, bool) {
+	for _, visit := range visits {
+		if visit.ArrivalTime.After(at) {
+			continue
+		}
+		if visit.DepartureTime == nil {
+			if visit.IsOngoing {
+				return visit.PlaceID, true
+			}
+			continue
+		}
+		if !visit.DepartureTime.Before(at) {
+			return visit.PlaceID, true
+		}
+	}
+	return primitive.NilObjectID, false
+}
+
+// clusterPhotos splits messages in time order where they pause for longer
+// than the cluster gap
+func clusterPhotos(messages []models.Message) [][]models.Message {
+	var clusters [][]models.Message
+	var current []models.Message
+	for _, message := range messages {
+		if len(current) > 0 && message.CreatedAt.Sub(current[len(current)-1].CreatedAt) > models.AlbumClusterGap {
+			clusters = append(clusters, current)
+			current = nil
+		}
+		current = append(current, message)
+	}
+	if len(current) > 0 {
+		clusters = append(clusters, current)
+	}
+	return clusters
+}
+
+// GetAlbumSuggestions returns the albums suggested for the circle that
+// haven't been accepted or dismissed yet
+func (cs *CircleService) GetAlbumSuggestions(ctx context.Context, userID, circleID string) (interface{}, error) {
+	albums, err := cs.circleAlbums(ctx, userID, circleID, models.AlbumStatusSuggested)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"suggestions": albums,
+	}, nil
+}
+
+// GetAlbums returns the circle's accepted albums
+func (cs *CircleService) GetAlbums(ctx context.Context, userID, circleID string) (interface{}, error) {
+	albums, err := cs.circleAlbums(ctx, userID, circleID, models.AlbumStatusAccepted)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"albums": albums,
+	}, nil
+}
+
+func (cs *CircleService) circleAlbums(ctx context.Context, userID, circleID, status string) ([]models.CircleAlbum, error) {
+	if cs.albumRepo == nil {
+		return nil, errors.New("albums not available")
+	}
+
+	isMember, err := cs.circleRepo.IsMember(ctx, circleID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !isMember {
+		return nil, errors.New("access denied")
+	}
+
+	circleObjectID, err := primitive.ObjectIDFromHex(circleID)
+	if err != nil {
+		return nil, errors.New("invalid circle ID")
+	}
+
+	albums, err := cs.albumRepo.GetByCircle(ctx, circleObjectID, status)
+	if err != nil {
+		return nil, err
+	}
+	if albums == nil {
+		albums = []models.CircleAlbum{}
+	}
+
+	return albums, nil
+}
+
+// GetAlbum returns an album, suggested or accepted, with a page of its
+// photos
+func (cs *CircleService) GetAlbum(ctx context.Context, userID, circleID, albumID string, page, pageSize int) (*models.AlbumDetailResponse, error) {
+	album, err := cs.memberAlbum(ctx, userID, circleID, albumID)
+	if err != nil {
+		return nil, err
+	}
+
+	page, pageSize = models.NormalizePage(page, pageSize)
+	photos, err := cs.albumRepo.GetPhotos(ctx, album.ID, page, pageSize)
+	if err != nil {
+		return nil, err
+	}
+	if photos == nil {
+		photos = []models.AlbumPhoto{}
+	}
+
+	return &models.AlbumDetailResponse{
+		Album:    album,
+		Photos:   photos,
+		Page:     page,
+		PageSize: pageSize,
+		HasNext:  page*pageSize < album.PhotoCount,
+	}, nil
+}
+
+// AcceptAlbum keeps a suggested album, optionally renamed and with another
+// cover. A circle admin or anyone whose photos are in it can accept it.
+func (cs *CircleService) AcceptAlbum(ctx context.Context, userID, circleID, albumID string, req models.AcceptAlbumRequest) (*models.CircleAlbum, error) {
+	if validationErrors := cs.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	album, err := cs.reviewableAlbum(ctx, userID, circleID, albumID)
+	if err != nil {
+		return nil, err
+	}
+
+	userObjectID, _ := primitive.ObjectIDFromHex(userID)
+	now := time.Now()
+	set := bson.M{
+		"status":     models.AlbumStatusAccepted,
+		"acceptedBy": userObjectID,
+		"acceptedAt": now,
+	}
+	if req.Title != "" {
+		set["title"] = req.Title
+		album.Title = req.Title
+	}
+	if req.CoverMediaID != "" {
+		mediaID, err := primitive.ObjectIDFromHex(req.CoverMediaID)
+		if err != nil {
+			return nil, utils.NewValidationFailedError("invalid cover media ID")
+		}
+		cover, err := cs.albumRepo.GetPhoto(ctx, album.ID, mediaID)
+		if err != nil {
+			if err.Error() == "photo not found" {
+				return nil, utils.NewValidationFailedError("the cover must be one of the album's photos")
+			}
+			return nil, err
+		}
+		album.CoverMediaID = cover.MediaID
+		album.CoverURL = cover.ThumbnailURL
+		if album.CoverURL == "" {
+			album.CoverURL = cover.URL
+		}
+		set["coverMediaId"] = album.CoverMediaID
+		set["coverUrl"] = album.CoverURL
+	}
+
+	if err := cs.albumRepo.Update(ctx, album.ID, set); err != nil {
+		return nil, err
+	}
+	album.Status = models.AlbumStatusAccepted
+	album.AcceptedBy = &userObjectID
+	album.AcceptedAt = &now
+
+	activity := models.CircleActivity{
+		CircleID: album.CircleID,
+		UserID:   userObjectID,
+		Type:     "album",
+		Action:   "album_created",
+		Data: map[string]interface{}{
+			"albumId":    album.ID.Hex(),
+			"title":      album.Title,
+			"placeId":    album.PlaceID.Hex(),
+			"photoCount": album.PhotoCount,
+		},
+		CreatedAt: now,
+	}
+	if err := cs.circleRepo.CreateActivity(ctx, &activity); err != nil {
+		logrus.Warnf("Failed to record album activity for circle %s: %v", circleID, err)
+	}
+
+	return album, nil
+}
+
+// DismissAlbum drops a suggested album. Its photos aren't suggested again.
+func (cs *CircleService) DismissAlbum(ctx context.Context, userID, circleID, albumID string) error {
+	album, err := cs.reviewableAlbum(ctx, userID, circleID, albumID)
+	if err != nil {
+		return err
+	}
+
+	userObjectID, _ := primitive.ObjectIDFromHex(userID)
+	return cs.albumRepo.Update(ctx, album.ID, bson.M{
+		"status":      models.AlbumStatusDismissed,
+		"dismissedBy": userObjectID,
+	})
+}
+
+// memberAlbum returns the circle's album if the user is a member. Dismissed
+// suggestions are gone for the members.
+func (cs *CircleService) memberAlbum(ctx context.Context, userID, circleID, albumID string) (*models.CircleAlbum, error) {
+	if cs.albumRepo == nil {
+		return nil, errors.New("albums not available")
+	}
+
+	isMember, err := cs.circleRepo.IsMember(ctx, circleID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !isMember {
+		return nil, errors.New("access denied")
+	}
+
+	album, err := cs.albumRepo.GetByID(ctx, albumID)
+	if err != nil {
+		return nil, err
+	}
+	if album.CircleID.Hex() != circleID || album.Status == models.AlbumStatusDismissed {
+		return nil, errors.New("album not found")
+	}
+
+	return album, nil
+}
+
+// reviewableAlbum returns a suggested album the user may accept or dismiss:
+// circle admins and the members whose photos are in it
+func (cs *CircleService) reviewableAlbum(ctx context.Context, userID, circleID, albumID string) (*models.CircleAlbum, error) {
+	album, err := cs.memberAlbum(ctx, userID, circleID, albumID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cs.ensureNotArchived(ctx, circleID); err != nil {
+		return nil, err
+	}
+
+	userObjectID, _ := primitive.ObjectIDFromHex(userID)
+	if !album.HasContributor(userObjectID) {
+		role, err := cs.circleRepo.GetMemberRole(ctx, circleID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if role != "admin" {
+			return nil, errors.New("access denied")
+		}
+	}
+
+	if album.Status != models.AlbumStatusSuggested {
+		return nil, errors.New("album already reviewed")
+	}
+
+	return album, nil
+}
+
+// removeFromAlbums takes the photos matching the filter out of every album
+// they are in, so a photo removed from chat doesn't linger in albums
+func (ms *MessageService) removeFromAlbums(ctx context.Context, filter bson.M) {
+	if ms.albumRepo == nil {
+		return
+	}
+
+	albumIDs, err := ms.albumRepo.RemovePhotos(ctx, filter)
+	if err != nil {
+		logrus.Errorf("Failed to remove photos from albums: %v", err)
+		return
+	}
+
+	for _, albumID := range albumIDs {
+		if err := ms.albumRepo.Refresh(ctx, albumID); err != nil {
+			logrus.Errorf("Failed to refresh album %s: %v", albumID.Hex(), err)
+		}
+	}
+}
+
+// removeMessageFromAlbums takes a removed message's photo out of albums
+func (ms *MessageService) removeMessageFromAlbums(ctx context.Context, messageID string) {
+	objectID, err := primitive.ObjectIDFromHex(messageID)
+	if err != nil {
+		return
+	}
+	ms.removeFromAlbums(ctx, bson.M{"messageId": objectID})
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"ftrack/models"
+	"ftrack/utils"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (cs *CircleService) GetAnnouncements(ctx context.Context, userID, circleID string, page, pageSize int) (interface{}, error) {
+	// Check if user is member
+	isMember, err := cs.circleRepo.IsMember(ctx, circleID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isMember {
+		return nil, errors.New("access denied")
+	}
+
+	page, pageSize = models.NormalizePage(page, pageSize)
+	announcements, err := cs.circleRepo.GetCircleAnnouncements(ctx, circleID, page, pageSize)
+	if err != nil {
+		return nil, err
+	}
+	if announcements == nil {
+		announcements = []models.CircleAnnouncement{}
+	}
+
+	return map[string]interface{}{
+		"announcements": announcements,
+		"page":          page,
+		"pageSize":      pageSize,
+	}, nil
+}
+
+// CreateAnnouncement sends an admin's announcement to the circle's active
+// members as a notification, and records it in the activity feed. A circle
+// can send a few announcements a day.
+func (cs *CircleService) CreateAnnouncement(ctx context.Context, userID, circleID string, req models.CreateAnnouncementRequest) (*models.CircleAnnouncement, error) {
+	if err := requireCirclePermission(ctx, cs.circleRepo, circleID, userID, models.CirclePermissionManageAnnouncements); err != nil {
+		return nil, err
+	}
+
+	if validationErrors := cs.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	if err := cs.ensureNotArchived(ctx, circleID); err != nil {
+		return nil, err
+	}
+
+	circle, err := cs.circleRepo.GetByID(ctx, circleID)
+	if err != nil {
+		return nil, err
+	}
+
+	sent, err := cs.circleRepo.CountCircleAnnouncementsSince(ctx, circle.ID, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		return nil, err
+	}
+	if sent >= models.MaxCircleAnnouncementsPerDay {
+		return nil, errors.New("announcement limit reached")
+	}
+
+	authorID, _ := primitive.ObjectIDFromHex(userID)
+	announcement := &models.CircleAnnouncement{
+		CircleID: circle.ID,
+		AuthorID: authorID,
+		Title:    req.Title,
+		Message:  req.Body,
+		Priority: req.Priority,
+		EventID:  req.EventID,
+	}
+	if announcement.Priority == "" {
+		announcement.Priority = "normal"
+	}
+
+	if req.PlaceID != "" {
+		placeID, err := primitive.ObjectIDFromHex(req.PlaceID)
+		if err != nil {
+			return nil, utils.NewValidationFailedError("invalid place ID")
+		}
+		if cs.placeService != nil {
+			if _, err := cs.placeService.GetPlace(ctx, userID, req.PlaceID); err != nil {
+				return nil, utils.NewValidationFailedError("place not found")
+			}
+		}
+		announcement.PlaceID = &placeID
+	}
+
+	// Members who haven't joined yet or are deactivated aren't sent it
+	var recipients []string
+	for _, member := range circle.Members {
+		if member.UserID != authorID && member.Status == "active" {
+			recipients = append(recipients, member.UserID.Hex())
+		}
+	}
+	announcement.Recipients = len(recipients)
+
+	if err := cs.circleRepo.CreateAnnouncement(ctx, announcement); err != nil {
+		return nil, err
+	}
+
+	activity := models.CircleActivity{
+		CircleID: circle.ID,
+		UserID:   authorID,
+		Type:     "announcement",
+		Action:   "announcement_sent",
+		Data: map[string]interface{}{
+			"announcementId": announcement.ID.Hex(),
+			"title":          announcement.Title,
+			"priority":       announcement.Priority,
+		},
+		CreatedAt: announcement.CreatedAt,
+	}
+	if err := cs.circleRepo.CreateActivity(ctx, &activity); err != nil {
+		logrus.Warnf("Failed to record announcement activity for circle %s: %v", circleID, err)
+	}
+
+	if len(recipients) > 0 && cs.notificationService != nil {
+		Background.Go(ctx, func(ctx context.Context) {
+			cs.sendAnnouncement(ctx, circle, announcement, recipients)
+		})
+	}
+
+	return announcement, nil
+}
+
+// sendAnnouncement fans the announcement out, and records how many members
+// were skipped for muting it
+func (cs *CircleService) sendAnnouncement(ctx context.Context, circle *models.Circle, announcement *models.CircleAnnouncement, recipients []string) {
+	data := map[string]interface{}{
+		"circleId":       circle.ID.Hex(),
+		"announcementId": announcement.ID.Hex(),
+	}
+	if announcement.PlaceID != nil {
+		data["placeId"] = announcement.PlaceID.Hex()
+	}
+	if announcement.EventID != "" {
+		data["eventId"] = announcement.EventID
+	}
+
+	muted, err := cs.notificationService.SendAnnouncement(ctx, models.SendNotificationRequest{
+		Recipients:    recipients,
+		Title:         announcement.Title,
+		Message:       announcement.Message,
+		Type:          models.NotificationTypeCircleAnnouncement,
+		Priority:      announcement.Priority,
+		Category:      "circle",
+		Data:          data,
+		Metadata:      map[string]interface{}{"announcement_id": announcement.ID.Hex()},
+		SubjectUserID: announcement.AuthorID.Hex(),
+	})
+	if err != nil {
+		logrus.Errorf("Failed to send announcement %s of circle %s: %v", announcement.ID.Hex(), circle.ID.Hex(), err)
+	}
+
+	if muted > 0 {
+		if err := cs.circleRepo.UpdateAnnouncement(ctx, announcement.ID.Hex(), bson.M{"muted": muted}); err != nil {
+			logrus.Warnf("Failed to record muted members of announcement %s: %v", announcement.ID.Hex(), err)
+		}
+	}
+}
+
+// GetAnnouncementStats tells an admin how many members an announcement
+// reached and read it
+func (cs *CircleService) GetAnnouncementStats(ctx context.Context, userID, circleID, announcementID string) (*models.AnnouncementStats, error) {
+	if err := requireCirclePermission(ctx, cs.circleRepo, circleID, userID, models.CirclePermissionManageAnnouncements); err != nil {
+		return nil, err
+	}
+
+	announcement, err := cs.circleRepo.GetAnnouncementByID(ctx, announcementID)
+	if err != nil {
+		return nil, err
+	}
+	if announcement.CircleID.Hex() != circleID {
+		return nil, errors.New("announcement not found")
+	}
+
+	if cs.notificationService == nil {
+		return nil, errors.New("announcement stats not available")
+	}
+	stats, err := cs.notificationService.GetAnnouncementStats(ctx, announcementID)
+	if err != nil {
+		return nil, err
+	}
+
+	stats.Recipients = announcement.Recipients
+	stats.Muted = announcement.Muted
+	if stats.Notified > 0 {
+		stats.ReadRate = float64(stats.Read) / float64(stats.Notified)
+	}
+
+	return stats, nil
+}
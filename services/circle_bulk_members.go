@@ -0,0 +1,311 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"ftrack/models"
+	"ftrack/utils"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BulkAddMembers adds many users to a circle at once. Each entry is checked
+// on its own and gets its own result. Users are added together only if all
+// of them fit in the circle; emails without an account get a pending
+// invitation, which takes no place until it is accepted.
+func (cs *CircleService) BulkAddMembers(ctx context.Context, userID, circleID string, req models.BulkAddMembersRequest) (*models.BulkMembershipResponse, error) {
+	role, err := cs.circleRepo.GetMemberRole(ctx, circleID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if role != "admin" {
+		return nil, errors.New("access denied")
+	}
+
+	if validationErrors := cs.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	if err := cs.ensureNotArchived(ctx, circleID); err != nil {
+		return nil, err
+	}
+
+	circle, err := cs.circleRepo.GetByID(ctx, circleID)
+	if err != nil {
+		return nil, err
+	}
+
+	invitations, err := cs.circleRepo.GetCircleInvitations(ctx, circleID)
+	if err != nil {
+		return nil, err
+	}
+	invited := make(map[string]bool)
+	for _, invitation := range invitations {
+		if invitation.Status == "pending" && invitation.ExpiresAt.After(time.Now()) {
+			invited[strings.ToLower(invitation.Email)] = true
+		}
+	}
+
+	members := make(map[primitive.ObjectID]bool, len(circle.Members))
+	for _, member := range circle.Members {
+		members[member.UserID] = true
+	}
+
+	results := make([]models.BulkMemberResult, len(req.Members))
+	var adding []models.CircleMember
+	var addingAt, invitingAt []int
+	seen := make(map[string]bool)
+
+	for i, entry := range req.Members {
+		result := &results[i]
+		result.UserID = strings.TrimSpace(entry.UserID)
+		result.Email = strings.ToLower(strings.TrimSpace(entry.Email))
+		result.Role = entry.Role
+		if result.Role == "" {
+			result.Role = "member"
+		}
+
+		user, err := cs.bulkMemberUser(ctx, result)
+		if err != nil {
+			result.Status, result.Error = models.BulkMemberFailed, err.Error()
+			continue
+		}
+
+		key := result.Email
+		if user != nil {
+			key = user.ID.Hex()
+			result.UserID = key
+		}
+		switch {
+		case seen[key]:
+			result.Status, result.Error = models.BulkMemberFailed, "duplicate entry"
+		case user != nil && members[user.ID]:
+			result.Status, result.Error = models.BulkMemberFailed, "user already member"
+		case user != nil && user.DeactivatedAt != nil:
+			result.Status, result.Error = models.BulkMemberFailed, "user not found"
+		case user == nil && invited[result.Email]:
+			result.Status, result.Error = models.BulkMemberFailed, "user already invited"
+		case user == nil:
+			invitingAt = append(invitingAt, i)
+		default:
+			adding = append(adding, newCircleMember(user.ID, result.Role, userID))
+			addingAt = append(addingAt, i)
+		}
+		seen[key] = true
+	}
+
+	// The users are added in one write, which only happens if they all fit
+	addErr := cs.circleRepo.AddMembers(ctx, circleID, adding, circle.Settings.MaxMembers)
+	if addErr != nil && addErr.Error() != "circle full" && addErr.Error() != "user already member" {
+		return nil, addErr
+	}
+	for _, i := range addingAt {
+		if addErr != nil {
+			results[i].Status, results[i].Error = models.BulkMemberFailed, addErr.Error()
+		} else {
+			results[i].Status = models.BulkMemberAdded
+		}
+	}
+
+	if addErr == nil && len(adding) > 0 {
+		cs.circleRepo.Update(ctx, circleID, bson.M{
+			"stats.totalMembers":  len(circle.Members) + len(adding),
+			"stats.activeMembers": len(circle.Members) + len(adding),
+		})
+
+		now := time.Now()
+		addedIDs := make([]string, len(adding))
+		for i, member := range adding {
+			addedIDs[i] = member.UserID.Hex()
+			cs.publishMembershipChange(ctx, circleID, addedIDs[i], "member_joined", now, map[string]interface{}{
+				"addedBy": userID,
+			})
+		}
+		cs.notifyMembersAdded(ctx, circle, addedIDs, now)
+	}
+
+	inviterID, _ := primitive.ObjectIDFromHex(userID)
+	for _, i := range invitingAt {
+		invitation := &models.CircleInvitation{
+			CircleID:  circle.ID,
+			InviterID: inviterID,
+			Email:     results[i].Email,
+			Role:      results[i].Role,
+			Message:   req.Message,
+			Status:    "pending",
+			ExpiresAt: time.Now().AddDate(0, 0, 7),
+		}
+		if err := cs.circleRepo.CreateInvitation(ctx, invitation); err != nil {
+			logrus.Errorf("Failed to invite %s to circle %s: %v", results[i].Email, circleID, err)
+			results[i].Status, results[i].Error = models.BulkMemberFailed, "failed to create invitation"
+			continue
+		}
+		results[i].Status = models.BulkMemberInvited
+		results[i].InvitationID = invitation.ID.Hex()
+	}
+
+	return newBulkMembershipResponse(results), nil
+}
+
+// bulkMemberUser finds the user of an entry. It returns nil for an email
+// without an account.
+func (cs *CircleService) bulkMemberUser(ctx context.Context, entry *models.BulkMemberResult) (*models.User, error) {
+	switch {
+	case entry.UserID != "" && entry.Email != "":
+		return nil, errors.New("give a user ID or an email, not both")
+	case entry.UserID != "":
+		if _, err := primitive.ObjectIDFromHex(entry.UserID); err != nil {
+			return nil, errors.New("invalid user ID")
+		}
+		user, err := cs.userRepo.GetByID(ctx, entry.UserID)
+		if err != nil {
+			return nil, errors.New("user not found")
+		}
+		return user, nil
+	case entry.Email != "":
+		user, err := cs.userRepo.GetByEmail(ctx, entry.Email)
+		if err != nil {
+			if err.Error() == "user not found" {
+				return nil, nil
+			}
+			return nil, errors.New("failed to look up user")
+		}
+		return user, nil
+	default:
+		return nil, errors.New("user ID or email is required")
+	}
+}
+
+// BulkRemoveMembers removes many members from a circle at once. Admins and
+// the caller can't be removed this way; their entries fail and the others
+// are still removed.
+func (cs *CircleService) BulkRemoveMembers(ctx context.Context, userID, circleID string, req models.BulkRemoveMembersRequest) (*models.BulkMembershipResponse, error) {
+	role, err := cs.circleRepo.GetMemberRole(ctx, circleID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if role != "admin" {
+		return nil, errors.New("access denied")
+	}
+
+	if validationErrors := cs.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	circle, err := cs.circleRepo.GetByID(ctx, circleID)
+	if err != nil {
+		return nil, err
+	}
+	roles := make(map[string]string, len(circle.Members))
+	for _, member := range circle.Members {
+		roles[member.UserID.Hex()] = member.Role
+	}
+
+	results := make([]models.BulkMemberResult, len(req.UserIDs))
+	var removing []primitive.ObjectID
+	var removingAt []int
+	seen := make(map[string]bool)
+
+	for i, memberID := range req.UserIDs {
+		result := &results[i]
+		result.UserID = strings.TrimSpace(memberID)
+
+		memberObjectID, err := primitive.ObjectIDFromHex(result.UserID)
+		switch {
+		case err != nil:
+			result.Error = "invalid user ID"
+		case seen[result.UserID]:
+			result.Error = "duplicate entry"
+		case result.UserID == userID:
+			result.Error = "cannot remove yourself"
+		case roles[result.UserID] == "":
+			result.Error = "member not found"
+		case roles[result.UserID] == "admin":
+			result.Error = "cannot remove admin"
+		default:
+			removing = append(removing, memberObjectID)
+			removingAt = append(removingAt, i)
+		}
+		if result.Error != "" {
+			result.Status = models.BulkMemberFailed
+		}
+		seen[result.UserID] = true
+	}
+
+	if len(removing) > 0 {
+		if err := cs.circleRepo.RemoveMembers(ctx, circleID, removing); err != nil {
+			return nil, err
+		}
+
+		now := time.Now()
+		for _, i := range removingAt {
+			results[i].Status = models.BulkMemberRemoved
+			cs.publishMembershipChange(ctx, circleID, results[i].UserID, "member_left", now, map[string]interface{}{
+				"removedBy": userID,
+			})
+			cs.notifyMemberRemoved(ctx, circleID, results[i].UserID, now)
+		}
+	}
+
+	return newBulkMembershipResponse(results), nil
+}
+
+// notifyMembersAdded tells users an admin added them to the circle
+func (cs *CircleService) notifyMembersAdded(ctx context.Context, circle *models.Circle, memberIDs []string, at time.Time) {
+	if cs.outbox == nil {
+		return
+	}
+
+	key := fmt.Sprintf("circle:%s:members_added:%d", circle.ID.Hex(), at.UnixNano())
+	err := cs.outbox.EnqueueNotification(ctx, key, models.SendNotificationRequest{
+		Recipients:       memberIDs,
+		Title:            "Added to circle",
+		Message:          fmt.Sprintf("You were added to %s", circle.Name),
+		Type:             "circle_member_added",
+		Priority:         "normal",
+		Category:         "circle",
+		Data:             map[string]interface{}{"circleId": circle.ID.Hex()},
+		DeliveryChannels: []string{"push"},
+	})
+	if err != nil {
+		logrus.Errorf("Failed to enqueue added notification for circle %s: %v", circle.ID.Hex(), err)
+	}
+}
+
+// newCircleMember is an active member with the permissions of their role
+func newCircleMember(userID primitive.ObjectID, role, invitedBy string) models.CircleMember {
+	inviterID, _ := primitive.ObjectIDFromHex(invitedBy)
+	return models.CircleMember{
+		UserID:    userID,
+		Role:      role,
+		Status:    "active",
+		InvitedBy: inviterID,
+		Permissions: models.MemberPermissions{
+			CanSeeLocation:   true,
+			CanSeeDriving:    true,
+			CanSendMessages:  true,
+			CanManagePlaces:  role == "admin",
+			CanReceiveAlerts: true,
+			CanSendEmergency: true,
+		},
+		JoinedAt: time.Now(),
+	}
+}
+
+func newBulkMembershipResponse(results []models.BulkMemberResult) *models.BulkMembershipResponse {
+	response := &models.BulkMembershipResponse{Results: results}
+	for _, result := range results {
+		if result.Status == models.BulkMemberFailed {
+			response.Failed++
+		} else {
+			response.Succeeded++
+		}
+	}
+	return response
+}
@@ -0,0 +1,250 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"ftrack/models"
+	"ftrack/testharness"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func withMaxMembers(max int) func(*models.Circle) {
+	return func(circle *models.Circle) {
+		circle.Settings.MaxMembers = max
+	}
+}
+
+// expectBulkResults checks each entry's status, and its error when it failed
+func expectBulkResults(t *testing.T, name string, response *models.BulkMembershipResponse, want ...string) {
+	t.Helper()
+	if len(response.Results) != len(want) {
+		t.Fatalf("%s: %d results, want %d", name, len(response.Results), len(want))
+	}
+	failed := 0
+	for i, result := range response.Results {
+		got := result.Status
+		if result.Status == models.BulkMemberFailed {
+			got += ": " + result.Error
+			failed++
+		}
+		if got != want[i] {
+			t.Errorf("%s: entry %d %s, want %s", name, i, got, want[i])
+		}
+	}
+	if response.Failed != failed || response.Succeeded != len(want)-failed {
+		t.Errorf("%s: %d succeeded and %d failed, want %d and %d", name, response.Succeeded, response.Failed, len(want)-failed, failed)
+	}
+}
+
+func circleMemberRoles(t *testing.T, env *testharness.Env, circle *models.Circle) map[primitive.ObjectID]string {
+	t.Helper()
+	stored, err := env.Repos.Circle.GetByID(context.Background(), circle.ID.Hex())
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	roles := make(map[primitive.ObjectID]string, len(stored.Members))
+	for _, member := range stored.Members {
+		roles[member.UserID] = member.Role
+	}
+	return roles
+}
+
+func TestCircleBulkAddMembersPartialSuccess(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	cs := newTestCircleService(env)
+	cs.ConfigureOutbox(NewOutboxService(env.Repos.Outbox, env.Hub, env.Notifier))
+	ctx := context.Background()
+
+	admin, member := env.Factory.User(), env.Factory.User()
+	byID, byEmail := env.Factory.User(), env.Factory.User()
+	gone := env.Factory.User(func(user *models.User) {
+		deactivated := time.Now()
+		user.DeactivatedAt = &deactivated
+	})
+	circle := env.Factory.Circle(admin, []*models.User{member})
+
+	response, err := cs.BulkAddMembers(ctx, admin.ID.Hex(), circle.ID.Hex(), models.BulkAddMembersRequest{
+		Members: []models.BulkMemberEntry{
+			{UserID: byID.ID.Hex()},
+			{Email: strings.ToUpper(byEmail.Email), Role: "admin"},
+			{Email: "newcomer@example.com"},
+			{UserID: member.ID.Hex()},
+			{UserID: byID.ID.Hex()},
+			{UserID: "not-an-id"},
+			{UserID: primitive.NewObjectID().Hex()},
+			{UserID: gone.ID.Hex()},
+			{UserID: byID.ID.Hex(), Email: byID.Email},
+		},
+	})
+	if err != nil {
+		t.Fatalf("BulkAddMembers: %v", err)
+	}
+	expectBulkResults(t, "mixed batch", response,
+		models.BulkMemberAdded,
+		models.BulkMemberAdded,
+		models.BulkMemberInvited,
+		"failed: user already member",
+		"failed: duplicate entry",
+		"failed: invalid user ID",
+		"failed: user not found",
+		"failed: user not found",
+		"failed: give a user ID or an email, not both",
+	)
+	if response.Results[1].UserID != byEmail.ID.Hex() || response.Results[2].InvitationID == "" {
+		t.Errorf("results = %+v, want the emailed user's ID and the invitation", response.Results)
+	}
+
+	roles := circleMemberRoles(t, env, circle)
+	if len(roles) != 4 || roles[byID.ID] != "member" || roles[byEmail.ID] != "admin" {
+		t.Errorf("members after the batch = %v, want the two added with their roles", roles)
+	}
+
+	added := env.Notifier.WaitForSent(t, "circle_member_added", 1)[0]
+	sort.Strings(added.Recipients)
+	want := []string{byID.ID.Hex(), byEmail.ID.Hex()}
+	sort.Strings(want)
+	if strings.Join(added.Recipients, ",") != strings.Join(want, ",") {
+		t.Errorf("added notification to %v, want %v", added.Recipients, want)
+	}
+
+	// The pending invitation isn't sent twice
+	response, err = cs.BulkAddMembers(ctx, admin.ID.Hex(), circle.ID.Hex(), models.BulkAddMembersRequest{
+		Members: []models.BulkMemberEntry{{Email: "Newcomer@example.com"}},
+	})
+	if err != nil {
+		t.Fatalf("BulkAddMembers: %v", err)
+	}
+	expectBulkResults(t, "second invitation", response, "failed: user already invited")
+
+	if _, err := cs.BulkAddMembers(ctx, member.ID.Hex(), circle.ID.Hex(), models.BulkAddMembersRequest{
+		Members: []models.BulkMemberEntry{{Email: "someone@example.com"}},
+	}); err == nil || err.Error() != "access denied" {
+		t.Errorf("bulk add by a member error = %v, want access denied", err)
+	}
+}
+
+func TestCircleBulkAddMembersCapacity(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	cs := newTestCircleService(env)
+	ctx := context.Background()
+
+	admin, member := env.Factory.User(), env.Factory.User()
+	newcomers := []*models.User{env.Factory.User(), env.Factory.User(), env.Factory.User()}
+	circle := env.Factory.Circle(admin, []*models.User{member}, withMaxMembers(4))
+
+	entries := func(users ...*models.User) []models.BulkMemberEntry {
+		var entries []models.BulkMemberEntry
+		for _, user := range users {
+			entries = append(entries, models.BulkMemberEntry{UserID: user.ID.Hex()})
+		}
+		return entries
+	}
+
+	// Three users don't fit in the two places left, so none is added;
+	// invitations take no place
+	response, err := cs.BulkAddMembers(ctx, admin.ID.Hex(), circle.ID.Hex(), models.BulkAddMembersRequest{
+		Members: append(entries(newcomers...), models.BulkMemberEntry{Email: "invitee@example.com"}),
+	})
+	if err != nil {
+		t.Fatalf("BulkAddMembers: %v", err)
+	}
+	expectBulkResults(t, "batch over capacity", response,
+		"failed: circle full", "failed: circle full", "failed: circle full", models.BulkMemberInvited)
+	if roles := circleMemberRoles(t, env, circle); len(roles) != 2 {
+		t.Errorf("%d members after a batch over capacity, want 2", len(roles))
+	}
+
+	// Two do fit
+	response, err = cs.BulkAddMembers(ctx, admin.ID.Hex(), circle.ID.Hex(), models.BulkAddMembersRequest{Members: entries(newcomers[:2]...)})
+	if err != nil {
+		t.Fatalf("BulkAddMembers: %v", err)
+	}
+	expectBulkResults(t, "batch at capacity", response, models.BulkMemberAdded, models.BulkMemberAdded)
+	if roles := circleMemberRoles(t, env, circle); len(roles) != 4 {
+		t.Errorf("%d members after filling the circle, want 4", len(roles))
+	}
+}
+
+func TestCircleBulkAddMembersConcurrentCapacity(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	cs := newTestCircleService(env)
+	ctx := context.Background()
+
+	admin := env.Factory.User()
+	circle := env.Factory.Circle(admin, nil, withMaxMembers(3))
+	var batches [][]models.BulkMemberEntry
+	for i := 0; i < 4; i++ {
+		batches = append(batches, []models.BulkMemberEntry{{UserID: env.Factory.User().ID.Hex()}, {UserID: env.Factory.User().ID.Hex()}})
+	}
+
+	// Each batch fits on its own, but only one of them fits with the others
+	var wg sync.WaitGroup
+	responses := make([]*models.BulkMembershipResponse, len(batches))
+	for i, batch := range batches {
+		wg.Add(1)
+		go func(i int, batch []models.BulkMemberEntry) {
+			defer wg.Done()
+			response, err := cs.BulkAddMembers(ctx, admin.ID.Hex(), circle.ID.Hex(), models.BulkAddMembersRequest{Members: batch})
+			if err != nil {
+				t.Errorf("BulkAddMembers: %v", err)
+				return
+			}
+			responses[i] = response
+		}(i, batch)
+	}
+	wg.Wait()
+
+	added := 0
+	for _, response := range responses {
+		if response != nil && response.Failed == 0 {
+			added++
+		}
+	}
+	if roles := circleMemberRoles(t, env, circle); added != 1 || len(roles) != 3 {
+		t.Errorf("%d batches added, %d members; want one batch and a full circle", added, len(roles))
+	}
+}
+
+func TestCircleBulkRemoveMembers(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	cs := newTestCircleService(env)
+	ctx := context.Background()
+
+	admin, coAdmin, first, second, stranger := env.Factory.User(), env.Factory.User(), env.Factory.User(), env.Factory.User(), env.Factory.User()
+	circle := env.Factory.Circle(admin, []*models.User{coAdmin, first, second}, asAdmin(1, models.MemberPermissions{}))
+
+	response, err := cs.BulkRemoveMembers(ctx, admin.ID.Hex(), circle.ID.Hex(), models.BulkRemoveMembersRequest{
+		UserIDs: []string{first.ID.Hex(), coAdmin.ID.Hex(), admin.ID.Hex(), "not-an-id", first.ID.Hex(), stranger.ID.Hex(), second.ID.Hex()},
+	})
+	if err != nil {
+		t.Fatalf("BulkRemoveMembers: %v", err)
+	}
+	expectBulkResults(t, "removal", response,
+		models.BulkMemberRemoved,
+		"failed: cannot remove admin",
+		"failed: cannot remove yourself",
+		"failed: invalid user ID",
+		"failed: duplicate entry",
+		"failed: member not found",
+		models.BulkMemberRemoved,
+	)
+
+	roles := circleMemberRoles(t, env, circle)
+	if len(roles) != 2 || roles[admin.ID] != "admin" || roles[coAdmin.ID] != "admin" {
+		t.Errorf("members after removal = %v, want the two admins", roles)
+	}
+
+	if _, err := cs.BulkRemoveMembers(ctx, stranger.ID.Hex(), circle.ID.Hex(), models.BulkRemoveMembersRequest{UserIDs: []string{admin.ID.Hex()}}); err == nil {
+		t.Error("a stranger removed members")
+	}
+}
@@ -0,0 +1,551 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"ftrack/models"
+	"ftrack/repositories"
+	"ftrack/utils"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	circleMergeHeartbeatInterval = 10 * time.Second
+
+	// A running merge without a heartbeat for this long died with its
+	// instance and is resumed
+	CircleMergeStaleAfter = 1 * time.Minute
+
+	circleMergeMessageBatch = 500
+)
+
+// ConfigureMerge gives the service the places, messages and rules merging
+// circles moves. Without it merges are refused.
+func (cs *CircleService) ConfigureMerge(placeService *PlaceService, messageRepo *repositories.MessageRepository, automationRepo *repositories.AutomationRepository) {
+	cs.placeService = placeService
+	cs.messageRepo = messageRepo
+	cs.automationRepo = automationRepo
+}
+
+// StartMerge moves the source circle's members, places, messages, rules and
+// collections into the target circle in the background. The user must be
+// an admin of both. The source circle is read-only from the start and is
+// deleted 30 days after the merge. Starting a merge that failed resumes it.
+func (cs *CircleService) StartMerge(ctx context.Context, userID, targetCircleID string, req models.MergeCircleRequest) (*models.CircleMergeJob, error) {
+	if validationErrors := cs.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	if !req.Confirm {
+		return nil, errors.New("confirmation required")
+	}
+
+	if cs.placeService == nil {
+		return nil, errors.New("circle merge not available")
+	}
+
+	if req.SourceCircleID == targetCircleID {
+		return nil, utils.NewValidationFailedError("a circle can't be merged into itself")
+	}
+
+	target, err := cs.circleRepo.GetByID(ctx, targetCircleID)
+	if err != nil {
+		return nil, err
+	}
+	source, err := cs.circleRepo.GetByID(ctx, req.SourceCircleID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !cs.isCircleAdmin(target, userID) || !cs.isCircleAdmin(source, userID) {
+		return nil, errors.New("access denied")
+	}
+
+	if target.IsArchived() {
+		return nil, errors.New("circle is archived")
+	}
+
+	jobs, err := cs.circleRepo.GetUnfinishedMergeJobs(ctx, []primitive.ObjectID{source.ID, target.ID})
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range jobs {
+		if job.SourceCircleID == source.ID && job.TargetCircleID == target.ID && job.Status == models.CircleMergeStatusFailed {
+			return cs.resumeFailedMerge(ctx, job)
+		}
+	}
+	if len(jobs) > 0 {
+		return nil, errors.New("circle merge already running")
+	}
+	if source.IsArchived() {
+		return nil, errors.New("circle is archived")
+	}
+
+	userObjectID, _ := primitive.ObjectIDFromHex(userID)
+	now := time.Now()
+	job := &models.CircleMergeJob{
+		SourceCircleID:    source.ID,
+		TargetCircleID:    target.ID,
+		RequestedBy:       userObjectID,
+		Status:            models.CircleMergeStatusRunning,
+		Phase:             models.CircleMergePhases[0],
+		DuplicateDistance: cs.placeService.duplicateDistance,
+		PlaceMappings:     []models.CircleMergePlaceMapping{},
+		Report: models.CircleMergeReport{
+			Conflicts: []models.CircleMergeConflict{},
+		},
+		Attempts:    1,
+		StartedAt:   now,
+		HeartbeatAt: now,
+	}
+
+	if err := cs.circleRepo.CreateMergeJob(ctx, job); err != nil {
+		return nil, err
+	}
+
+	// Nothing may change in the source while it is being emptied
+	if err := cs.circleRepo.ArchiveCircle(ctx, source.ID, target.ID); err != nil {
+		cs.updateMergeJob(ctx, job.ID, bson.M{"$set": bson.M{
+			"status":   models.CircleMergeStatusFailed,
+			"errorMsg": err.Error(),
+		}})
+		return nil, err
+	}
+
+	logrus.Infof("Circle merge %s started: %s into %s by user %s", job.ID.Hex(), source.ID.Hex(), target.ID.Hex(), userID)
+
+	snapshot := *job
+	go cs.runMerge(job)
+
+	return &snapshot, nil
+}
+
+func (cs *CircleService) resumeFailedMerge(ctx context.Context, job models.CircleMergeJob) (*models.CircleMergeJob, error) {
+	claimed, err := cs.circleRepo.ClaimMergeJob(ctx, job.ID, time.Now().Add(-CircleMergeStaleAfter))
+	if err != nil {
+		return nil, err
+	}
+	if claimed == nil {
+		return nil, errors.New("circle merge already running")
+	}
+
+	logrus.Infof("Circle merge %s resumed at phase %s", claimed.ID.Hex(), claimed.Phase)
+
+	snapshot := *claimed
+	go cs.runMerge(claimed)
+
+	return &snapshot, nil
+}
+
+// GetMergeJob returns a merge's progress and report to an admin of its
+// target circle
+func (cs *CircleService) GetMergeJob(ctx context.Context, userID, targetCircleID, jobID string) (*models.CircleMergeJob, error) {
+	job, err := cs.circleRepo.GetMergeJobByID(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+
+	if job.TargetCircleID.Hex() != targetCircleID {
+		return nil, errors.New("merge job not found")
+	}
+
+	role, err := cs.circleRepo.GetMemberRole(ctx, targetCircleID, userID)
+	if err != nil || role != "admin" {
+		return nil, errors.New("access denied")
+	}
+
+	return job, nil
+}
+
+// ResumeStaleMerges picks up merges whose instance stopped mid-merge. It
+// returns the number resumed.
+func (cs *CircleService) ResumeStaleMerges(ctx context.Context) (int, error) {
+	if cs.placeService == nil {
+		return 0, nil
+	}
+
+	resumed := 0
+	for {
+		job, err := cs.circleRepo.ClaimStaleMergeJob(ctx, time.Now().Add(-CircleMergeStaleAfter))
+		if err != nil {
+			return resumed, err
+		}
+		if job == nil {
+			return resumed, nil
+		}
+
+		logrus.Infof("Circle merge %s resumed at phase %s after its instance stopped", job.ID.Hex(), job.Phase)
+		go cs.runMerge(job)
+		resumed++
+	}
+}
+
+// runMerge runs the job's phases from the one it stopped at
+func (cs *CircleService) runMerge(job *models.CircleMergeJob) {
+	ctx := context.Background()
+
+	stop := make(chan struct{})
+	go cs.mergeHeartbeat(job.ID, stop)
+	defer close(stop)
+
+	start := 0
+	for i, phase := range models.CircleMergePhases {
+		if phase == job.Phase {
+			start = i
+		}
+	}
+
+	for i := start; i < len(models.CircleMergePhases); i++ {
+		phase := models.CircleMergePhases[i]
+		cs.updateMergeJob(ctx, job.ID, bson.M{"$set": bson.M{
+			"phase":    phase,
+			"progress": i * 100 / len(models.CircleMergePhases),
+		}})
+
+		if err := cs.runMergePhase(ctx, job, phase); err != nil {
+			logrus.Errorf("Circle merge %s failed in phase %s: %v", job.ID.Hex(), phase, err)
+			cs.updateMergeJob(ctx, job.ID, bson.M{"$set": bson.M{
+				"status":      models.CircleMergeStatusFailed,
+				"errorMsg":    err.Error(),
+				"heartbeatAt": time.Now(),
+			}})
+			return
+		}
+	}
+
+	now := time.Now()
+	cs.updateMergeJob(ctx, job.ID, bson.M{"$set": bson.M{
+		"status":      models.CircleMergeStatusCompleted,
+		"phase":       models.CircleMergePhaseDone,
+		"progress":    100,
+		"heartbeatAt": now,
+		"completedAt": now,
+	}})
+
+	logrus.Infof("Circle merge %s completed", job.ID.Hex())
+}
+
+func (cs *CircleService) runMergePhase(ctx context.Context, job *models.CircleMergeJob, phase string) error {
+	switch phase {
+	case models.CircleMergePhaseMembers:
+		return cs.mergeMembers(ctx, job)
+	case models.CircleMergePhasePlaces:
+		return cs.mergePlaces(ctx, job)
+	case models.CircleMergePhaseMessages:
+		return cs.mergeMessages(ctx, job)
+	case models.CircleMergePhaseAutomationRules:
+		moved, err := cs.automationRepo.MoveCircleRules(ctx, job.SourceCircleID, job.TargetCircleID)
+		if err != nil {
+			return err
+		}
+		return cs.circleRepo.UpdateMergeJob(ctx, job.ID, bson.M{"$inc": bson.M{"report.automationRules.migrated": moved}})
+	case models.CircleMergePhaseCollections:
+		return cs.mergeCollections(ctx, job)
+	case models.CircleMergePhaseArchive:
+		return cs.circleRepo.Update(ctx, job.SourceCircleID.Hex(), bson.M{
+			"deleteAfter": time.Now().Add(models.CircleMergeArchiveRetention),
+		})
+	}
+	return fmt.Errorf("unknown merge phase %q", phase)
+}
+
+// mergeMembers adds the source's members to the target. Members of both
+// keep the higher of their roles; members over the target's size limit are
+// left out and reported.
+func (cs *CircleService) mergeMembers(ctx context.Context, job *models.CircleMergeJob) error {
+	source, err := cs.circleRepo.GetByID(ctx, job.SourceCircleID.Hex())
+	if err != nil {
+		return err
+	}
+	target, err := cs.circleRepo.GetByID(ctx, job.TargetCircleID.Hex())
+	if err != nil {
+		return err
+	}
+
+	existing := make(map[primitive.ObjectID]models.CircleMember, len(target.Members))
+	for _, member := range target.Members {
+		existing[member.UserID] = member
+	}
+
+	counts := models.CircleMergeCounts{}
+	conflicts := []models.CircleMergeConflict{}
+	memberCount := len(target.Members)
+	for _, member := range source.Members {
+		if current, ok := existing[member.UserID]; ok {
+			// Added by an earlier run of this merge
+			if current.InvitedBy == job.RequestedBy && !current.JoinedAt.Before(job.StartedAt) {
+				counts.Migrated++
+				continue
+			}
+
+			if member.Role == "admin" && current.Role != "admin" {
+				if err := cs.circleRepo.UpdateMemberRole(ctx, target.ID.Hex(), member.UserID.Hex(), "admin"); err != nil {
+					return err
+				}
+				counts.Promoted++
+			}
+			counts.DuplicatesSkipped++
+			continue
+		}
+
+		if target.Settings.MaxMembers > 0 && memberCount >= target.Settings.MaxMembers {
+			conflicts = append(conflicts, models.CircleMergeConflict{
+				Type:   "member",
+				ItemID: member.UserID.Hex(),
+				Reason: "target circle is full",
+			})
+			continue
+		}
+
+		member.InvitedBy = job.RequestedBy
+		if err := cs.circleRepo.AddMember(ctx, target.ID.Hex(), member); err != nil {
+			return err
+		}
+		memberCount++
+		counts.Migrated++
+	}
+
+	if err := cs.circleRepo.Update(ctx, target.ID.Hex(), bson.M{
+		"stats.totalMembers":  memberCount,
+		"stats.activeMembers": memberCount,
+	}); err != nil {
+		return err
+	}
+
+	// Counts are set rather than added to, since a rerun sees the same members
+	return cs.circleRepo.UpdateMergeJob(ctx, job.ID, bson.M{"$set": bson.M{
+		"report.members":   counts,
+		"report.conflicts": append(nonMemberConflicts(job), conflicts...),
+	}})
+}
+
+// nonMemberConflicts drops member conflicts from an earlier run of the
+// members phase
+func nonMemberConflicts(job *models.CircleMergeJob) []models.CircleMergeConflict {
+	kept := []models.CircleMergeConflict{}
+	for _, conflict := range job.Report.Conflicts {
+		if conflict.Type != "member" {
+			kept = append(kept, conflict)
+		}
+	}
+	return kept
+}
+
+// mergePlaces moves the source's places to the target. A place with the
+// same name as a target place within the duplicate distance is folded into
+// it; a place that only shares the name or only lies close by is moved and
+// reported for an admin to look at.
+func (cs *CircleService) mergePlaces(ctx context.Context, job *models.CircleMergeJob) error {
+	placeRepo := cs.placeService.placeRepo
+
+	targetPlaces, err := placeRepo.GetCirclePlaces(ctx, job.TargetCircleID.Hex())
+	if err != nil {
+		return err
+	}
+	sourcePlaces, err := placeRepo.GetCirclePlaces(ctx, job.SourceCircleID.Hex())
+	if err != nil {
+		return err
+	}
+
+	// Places already handled have left the source, so a rerun picks up
+	// where the last one stopped
+	for i := range sourcePlaces {
+		place := &sourcePlaces[i]
+		name := normalizePlaceName(place.Name)
+
+		var duplicate, near, sameName *models.Place
+		for j := range targetPlaces {
+			candidate := &targetPlaces[j]
+			distance := utils.CalculateDistance(place.Latitude, place.Longitude, candidate.Latitude, candidate.Longitude)
+			nameMatch := name != "" && normalizePlaceName(candidate.Name) == name
+
+			switch {
+			case nameMatch && distance <= job.DuplicateDistance:
+				duplicate = candidate
+			case nameMatch && sameName == nil:
+				sameName = candidate
+			case distance <= job.DuplicateDistance && near == nil:
+				near = candidate
+			}
+			if duplicate != nil {
+				break
+			}
+		}
+
+		update := bson.M{}
+		var mapping *models.CircleMergePlaceMapping
+		if duplicate != nil {
+			mapping = &models.CircleMergePlaceMapping{
+				DuplicateID: place.ID,
+				CanonicalID: duplicate.ID,
+			}
+
+			// Recorded before the duplicate is deleted, so collections can
+			// still be repointed if the merge stops in between
+			if err := cs.circleRepo.UpdateMergeJob(ctx, job.ID, bson.M{
+				"$push": bson.M{"placeMappings": *mapping},
+			}); err != nil {
+				return err
+			}
+			if _, err := placeRepo.MergePlaces(ctx, duplicate.ID, []primitive.ObjectID{place.ID}); err != nil {
+				return err
+			}
+			update["$inc"] = bson.M{"report.places.duplicatesSkipped": 1}
+			cs.placeService.invalidatePlaceTypeahead(ctx, duplicate)
+		} else {
+			if err := placeRepo.Update(ctx, place.ID.Hex(), map[string]interface{}{"circleId": job.TargetCircleID}); err != nil {
+				return err
+			}
+			update["$inc"] = bson.M{"report.places.migrated": 1}
+
+			if related, reason := placeMergeConflict(sameName, near); related != nil {
+				update["$push"] = bson.M{"report.conflicts": models.CircleMergeConflict{
+					Type:      "place",
+					ItemID:    place.ID.Hex(),
+					Name:      place.Name,
+					RelatedID: related.ID.Hex(),
+					Reason:    reason,
+				}}
+			}
+
+			moved := *place
+			moved.CircleID = job.TargetCircleID
+			targetPlaces = append(targetPlaces, moved)
+			cs.placeService.invalidatePlaceTypeahead(ctx, place)
+			cs.placeService.invalidatePlaceTypeahead(ctx, &moved)
+		}
+
+		if err := cs.circleRepo.UpdateMergeJob(ctx, job.ID, update); err != nil {
+			return err
+		}
+		if mapping != nil {
+			job.PlaceMappings = append(job.PlaceMappings, *mapping)
+		}
+	}
+
+	// Inactive places move as they are
+	moved, err := placeRepo.MoveCirclePlaces(ctx, job.SourceCircleID, job.TargetCircleID)
+	if err != nil {
+		return err
+	}
+	return cs.circleRepo.UpdateMergeJob(ctx, job.ID, bson.M{"$inc": bson.M{"report.places.migrated": moved}})
+}
+
+func placeMergeConflict(sameName, near *models.Place) (*models.Place, string) {
+	if sameName != nil {
+		return sameName, "target circle has a place with the same name elsewhere"
+	}
+	if near != nil {
+		return near, "target circle has a different place close by"
+	}
+	return nil, ""
+}
+
+// mergeMessages moves the source's messages in batches, then marks where
+// they start in the target's chat with a system message
+func (cs *CircleService) mergeMessages(ctx context.Context, job *models.CircleMergeJob) error {
+	for {
+		moved, err := cs.messageRepo.MoveCircleMessages(ctx, job.SourceCircleID, job.TargetCircleID, circleMergeMessageBatch)
+		if err != nil {
+			return err
+		}
+		if moved == 0 {
+			break
+		}
+
+		if err := cs.circleRepo.UpdateMergeJob(ctx, job.ID, bson.M{
+			"$inc": bson.M{"report.messages.migrated": moved},
+			"$set": bson.M{"heartbeatAt": time.Now()},
+		}); err != nil {
+			return err
+		}
+	}
+
+	if job.DividerMessageID != nil {
+		return nil
+	}
+
+	source, err := cs.circleRepo.GetByID(ctx, job.SourceCircleID.Hex())
+	if err != nil {
+		return err
+	}
+
+	divider := &models.Message{
+		CircleID:  job.TargetCircleID,
+		SenderID:  job.RequestedBy,
+		Type:      "system",
+		Content:   fmt.Sprintf("Messages from %s were merged into this circle", source.Name),
+		ReadBy:    []models.MessageReadStatus{},
+		Reactions: []models.MessageReaction{},
+	}
+	if err := cs.messageRepo.Create(ctx, divider); err != nil {
+		return err
+	}
+
+	job.DividerMessageID = &divider.ID
+	return cs.circleRepo.UpdateMergeJob(ctx, job.ID, bson.M{"$set": bson.M{"dividerMessageId": divider.ID}})
+}
+
+// mergeCollections points collections holding places that were folded into
+// target places at the target places. Collections belong to users, so the
+// places that simply moved stay in them as they are.
+func (cs *CircleService) mergeCollections(ctx context.Context, job *models.CircleMergeJob) error {
+	var changed int64
+	for _, mapping := range job.PlaceMappings {
+		count, err := cs.placeService.placeRepo.ReplaceCollectionPlace(ctx, mapping.DuplicateID, mapping.CanonicalID)
+		if err != nil {
+			return err
+		}
+		changed += count
+	}
+
+	return cs.circleRepo.UpdateMergeJob(ctx, job.ID, bson.M{"$set": bson.M{"report.collections.migrated": changed}})
+}
+
+// mergeHeartbeat keeps the job marked alive until stop is closed
+func (cs *CircleService) mergeHeartbeat(jobID primitive.ObjectID, stop <-chan struct{}) {
+	ticker := time.NewTicker(circleMergeHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			cs.updateMergeJob(ctx, jobID, bson.M{"$set": bson.M{"heartbeatAt": time.Now()}})
+			cancel()
+		}
+	}
+}
+
+func (cs *CircleService) updateMergeJob(ctx context.Context, jobID primitive.ObjectID, update bson.M) {
+	if err := cs.circleRepo.UpdateMergeJob(ctx, jobID, update); err != nil {
+		logrus.Warnf("Failed to update circle merge %s: %v", jobID.Hex(), err)
+	}
+}
+
+// DeleteExpiredArchivedCircles deletes circles whose post-merge archive
+// period is over. It returns the number deleted.
+func (cs *CircleService) DeleteExpiredArchivedCircles(ctx context.Context, batchSize int) (int, error) {
+	circles, err := cs.circleRepo.GetExpiredArchivedCircles(ctx, time.Now(), batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, circle := range circles {
+		if err := cs.circleRepo.Delete(ctx, circle.ID.Hex()); err != nil {
+			logrus.Warnf("Failed to delete archived circle %s: %v", circle.ID.Hex(), err)
+			continue
+		}
+		deleted++
+	}
+	return deleted, nil
+}
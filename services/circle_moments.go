@@ -0,0 +1,263 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+
+	"ftrack/models"
+	"ftrack/repositories"
+	"ftrack/utils"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// How far outside a place's geofence a moment may be when the author names
+// the place, for the location's inaccuracy. Auto-matching allows none.
+const momentPlaceSlackMeters = 100
+
+// ConfigureMoments lets members share moments: photos from the media
+// endpoint, matched to the circle's places. Without it sharing one fails.
+func (cs *CircleService) ConfigureMoments(mediaRepo *repositories.MediaRepository, placeRepo *repositories.PlaceRepository) {
+	cs.mediaRepo = mediaRepo
+	cs.placeRepo = placeRepo
+}
+
+// ShareMoment posts a geotagged photo to the circle's activity feed. It is
+// matched to the circle place whose geofence it was shared in, unless the
+// author names one. Authors who don't share their location with the circle
+// can't share moments to it.
+func (cs *CircleService) ShareMoment(ctx context.Context, userID, circleID string, req models.CreateMomentRequest) (*models.CircleMoment, error) {
+	if cs.mediaRepo == nil || cs.placeRepo == nil {
+		return nil, errors.New("moments not available")
+	}
+
+	if validationErrors := cs.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	circle, err := cs.circleRepo.GetByID(ctx, circleID)
+	if err != nil {
+		return nil, err
+	}
+	member := findCircleMember(circle, userID)
+	if member == nil || member.Status != "active" {
+		return nil, errors.New("access denied")
+	}
+	if circle.IsArchived() {
+		return nil, errors.New("circle is archived")
+	}
+	if !circle.Settings.LocationSharing {
+		return nil, errors.New("location sharing disabled")
+	}
+
+	user, err := cs.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !sharingIncludesCircle(user.LocationSharing, circleID) {
+		return nil, errors.New("location sharing paused")
+	}
+
+	media, err := cs.momentMedia(ctx, userID, req.MediaID)
+	if err != nil {
+		return nil, err
+	}
+
+	places, err := cs.placeRepo.GetCirclePlaces(ctx, circleID)
+	if err != nil {
+		return nil, err
+	}
+
+	var place *models.Place
+	if req.PlaceID != "" {
+		place = findPlace(places, req.PlaceID)
+		if place == nil {
+			return nil, utils.NewValidationFailedError("placeId is not a place of this circle")
+		}
+		if !momentAtPlace(place, req.Latitude, req.Longitude, math.Min(req.Accuracy, momentPlaceSlackMeters)) {
+			return nil, utils.NewValidationFailedError("the location is not at " + place.Name)
+		}
+	} else {
+		place = matchMomentPlace(places, req.Latitude, req.Longitude)
+	}
+
+	moment := &models.CircleMoment{
+		CircleID:  circle.ID,
+		UserID:    user.ID,
+		Caption:   strings.TrimSpace(req.Caption),
+		Media:     *media,
+		Latitude:  req.Latitude,
+		Longitude: req.Longitude,
+		Accuracy:  req.Accuracy,
+	}
+	if place != nil {
+		moment.PlaceID = &place.ID
+		moment.PlaceName = place.Name
+	}
+
+	if err := cs.circleRepo.CreateMoment(ctx, moment); err != nil {
+		return nil, err
+	}
+
+	return moment, nil
+}
+
+// DeleteMoment takes a moment down. Authors delete their own; admins
+// delete anyone's.
+func (cs *CircleService) DeleteMoment(ctx context.Context, userID, circleID, momentID string) error {
+	circle, err := cs.circleRepo.GetByID(ctx, circleID)
+	if err != nil {
+		return err
+	}
+	member := findCircleMember(circle, userID)
+	if member == nil {
+		return errors.New("access denied")
+	}
+
+	moment, err := cs.circleRepo.GetMoment(ctx, circleID, momentID)
+	if err != nil {
+		return err
+	}
+	if moment.UserID.Hex() != userID && member.Role != "admin" {
+		return errors.New("access denied")
+	}
+
+	return cs.circleRepo.DeleteMoment(ctx, moment.ID)
+}
+
+// getMomentFeed returns the circle's newest moments by the given members as
+// activity feed entries, pinned as the viewer may see them
+func (cs *CircleService) getMomentFeed(ctx context.Context, userID string, circle *models.Circle, authorIDs []primitive.ObjectID, limit int) ([]models.CircleActivity, int64, error) {
+	moments, total, err := cs.circleRepo.GetMomentFeed(ctx, circle.ID, authorIDs, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	authorIDStrings := make([]string, 0, len(moments))
+	for _, moment := range moments {
+		authorIDStrings = append(authorIDStrings, moment.UserID.Hex())
+	}
+	authors, err := cs.userRepo.GetUsersByIDs(ctx, authorIDStrings)
+	if err != nil {
+		return nil, 0, err
+	}
+	sharingByID := make(map[primitive.ObjectID]models.LocationSharing, len(authors))
+	for _, author := range authors {
+		sharingByID[author.ID] = author.LocationSharing
+	}
+
+	circleID := circle.ID.Hex()
+	activities := make([]models.CircleActivity, 0, len(moments))
+	for i := range moments {
+		moment := &moments[i]
+		sharing, ok := sharingByID[moment.UserID]
+		if !ok {
+			// Authors who deactivated are left out
+			continue
+		}
+
+		pin, showPlace := momentPin(moment, sharing, circleID, moment.UserID.Hex() == userID)
+
+		data := map[string]interface{}{
+			"momentId": moment.ID.Hex(),
+			"caption":  moment.Caption,
+			"media":    moment.Media,
+			"location": pin,
+		}
+		if showPlace && moment.PlaceID != nil {
+			data["placeId"] = moment.PlaceID.Hex()
+			data["placeName"] = moment.PlaceName
+		}
+
+		activities = append(activities, models.CircleActivity{
+			ID:        moment.ID,
+			CircleID:  moment.CircleID,
+			UserID:    moment.UserID,
+			Type:      "moment",
+			Action:    "share",
+			Data:      data,
+			CreatedAt: moment.CreatedAt,
+		})
+	}
+
+	return activities, total, nil
+}
+
+// momentPin returns where the viewer sees a moment on the map, and whether
+// they see its place. Others get the pin fuzzed to the author's current
+// sharing precision, and the place only if the author shares places. The
+// pin is left out while the author doesn't share their location with the
+// circle.
+func momentPin(moment *models.CircleMoment, sharing models.LocationSharing, circleID string, self bool) (*models.SharedLocation, bool) {
+	if self {
+		sharing.Precision = models.PrecisionExact
+		sharing.SharePlaces = true
+	} else if !sharingIncludesCircle(sharing, circleID) {
+		return nil, false
+	}
+
+	location := models.Location{
+		Latitude:  moment.Latitude,
+		Longitude: moment.Longitude,
+		Accuracy:  moment.Accuracy,
+		PlaceName: moment.PlaceName,
+	}
+	return sharedLocation(location, sharing), sharing.SharePlaces
+}
+
+// matchMomentPlace returns the place whose geofence the location is in,
+// the one with the nearest center when geofences overlap
+func matchMomentPlace(places []models.Place, latitude, longitude float64) *models.Place {
+	var match *models.Place
+	nearest := math.Inf(1)
+	for i := range places {
+		distance := utils.CalculateDistance(latitude, longitude, places[i].Latitude, places[i].Longitude)
+		if distance <= float64(places[i].Radius) && distance < nearest {
+			match = &places[i]
+			nearest = distance
+		}
+	}
+	return match
+}
+
+// momentAtPlace reports whether the location is within the place's
+// geofence, give or take the slack
+func momentAtPlace(place *models.Place, latitude, longitude, slack float64) bool {
+	distance := utils.CalculateDistance(latitude, longitude, place.Latitude, place.Longitude)
+	return distance <= float64(place.Radius)+slack
+}
+
+func findPlace(places []models.Place, placeID string) *models.Place {
+	for i := range places {
+		if places[i].ID.Hex() == placeID {
+			return &places[i]
+		}
+	}
+	return nil
+}
+
+// momentMedia resolves a moment's photo. Only images the user uploaded
+// themselves may be shared.
+func (cs *CircleService) momentMedia(ctx context.Context, userID, mediaID string) (*models.CheckinMedia, error) {
+	media, err := cs.mediaRepo.GetByID(ctx, mediaID)
+	if err != nil {
+		if err.Error() == "media not found" || err.Error() == "invalid media ID" {
+			return nil, utils.NewValidationFailedError("mediaId does not refer to an uploaded photo")
+		}
+		return nil, err
+	}
+	if media.UploadedBy != userID {
+		return nil, errors.New("access denied")
+	}
+	if media.Type != "image" && !strings.HasPrefix(media.MimeType, "image/") {
+		return nil, utils.NewValidationFailedError("moment media must be an image")
+	}
+
+	return &models.CheckinMedia{
+		ID:           media.ID,
+		URL:          media.URL,
+		ThumbnailURL: media.ThumbnailURL,
+	}, nil
+}
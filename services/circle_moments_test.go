@@ -0,0 +1,210 @@
+package services
+
+import (
+	"math"
+	"testing"
+
+	"ftrack/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Roughly one meter of latitude, in degrees
+const metersToDegrees = 1.0 / 111195
+
+func placeNorthOf(name string, latitude, longitude, meters float64, radius int) models.Place {
+	return models.Place{
+		ID:        primitive.NewObjectID(),
+		Name:      name,
+		Latitude:  latitude + meters*metersToDegrees,
+		Longitude: longitude,
+		Radius:    radius,
+	}
+}
+
+func TestMatchMomentPlace(t *testing.T) {
+	const latitude, longitude = 40.0, -74.0
+
+	tests := []struct {
+		name   string
+		places []models.Place
+		want   string
+	}{
+		{
+			name:   "no places",
+			places: nil,
+			want:   "",
+		},
+		{
+			name: "outside every geofence",
+			places: []models.Place{
+				placeNorthOf("far", latitude, longitude, 1000, 100),
+			},
+			want: "",
+		},
+		{
+			name: "inside one geofence",
+			places: []models.Place{
+				placeNorthOf("far", latitude, longitude, 1000, 100),
+				placeNorthOf("home", latitude, longitude, 50, 100),
+			},
+			want: "home",
+		},
+		{
+			name: "overlapping geofences, nearest center wins",
+			places: []models.Place{
+				placeNorthOf("neighborhood", latitude, longitude, 150, 500),
+				placeNorthOf("home", latitude, longitude, 40, 100),
+				placeNorthOf("street", latitude, longitude, 90, 200),
+			},
+			want: "home",
+		},
+		{
+			name: "nearer center whose geofence doesn't reach",
+			places: []models.Place{
+				placeNorthOf("mailbox", latitude, longitude, 30, 10),
+				placeNorthOf("park", latitude, longitude, 120, 300),
+			},
+			want: "park",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := matchMomentPlace(tt.places, latitude, longitude)
+			switch {
+			case tt.want == "" && got != nil:
+				t.Errorf("matched %q, want no place", got.Name)
+			case tt.want != "" && got == nil:
+				t.Errorf("matched no place, want %q", tt.want)
+			case tt.want != "" && got.Name != tt.want:
+				t.Errorf("matched %q, want %q", got.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestMomentAtPlace(t *testing.T) {
+	place := placeNorthOf("home", 40.0, -74.0, 0, 100)
+
+	if !momentAtPlace(&place, 40.0+150*metersToDegrees, -74.0, 60) {
+		t.Error("moment 150m from a 100m geofence rejected with 60m slack")
+	}
+	if momentAtPlace(&place, 40.0+150*metersToDegrees, -74.0, 20) {
+		t.Error("moment 150m from a 100m geofence accepted with 20m slack")
+	}
+}
+
+func TestMomentPin(t *testing.T) {
+	circleID := primitive.NewObjectID().Hex()
+	placeID := primitive.NewObjectID()
+	moment := &models.CircleMoment{
+		Latitude:  40.712776,
+		Longitude: -74.005974,
+		Accuracy:  5,
+		PlaceID:   &placeID,
+		PlaceName: "Home",
+	}
+
+	tests := []struct {
+		name      string
+		sharing   models.LocationSharing
+		self      bool
+		hidden    bool
+		precision string
+		showPlace bool
+	}{
+		{
+			name:      "author sees the exact pin",
+			sharing:   models.LocationSharing{Enabled: false, Precision: models.PrecisionCity},
+			self:      true,
+			precision: models.PrecisionExact,
+			showPlace: true,
+		},
+		{
+			name:      "exact sharing",
+			sharing:   models.LocationSharing{Enabled: true, Precision: models.PrecisionExact, SharePlaces: true},
+			precision: models.PrecisionExact,
+			showPlace: true,
+		},
+		{
+			name:      "approximate sharing",
+			sharing:   models.LocationSharing{Enabled: true, Precision: models.PrecisionApproximate},
+			precision: models.PrecisionApproximate,
+		},
+		{
+			name:      "city sharing",
+			sharing:   models.LocationSharing{Enabled: true, Precision: models.PrecisionCity, SharePlaces: true},
+			precision: models.PrecisionCity,
+			showPlace: true,
+		},
+		{
+			name:    "sharing off",
+			sharing: models.LocationSharing{Enabled: false},
+			hidden:  true,
+		},
+		{
+			name:    "stealth mode",
+			sharing: models.LocationSharing{Enabled: true, StealthMode: true},
+			hidden:  true,
+		},
+		{
+			name:    "sharing with other circles only",
+			sharing: models.LocationSharing{Enabled: true, ShareWith: []string{primitive.NewObjectID().Hex()}},
+			hidden:  true,
+		},
+		{
+			name:      "sharing with this circle",
+			sharing:   models.LocationSharing{Enabled: true, ShareWith: []string{circleID}},
+			precision: models.PrecisionExact,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pin, showPlace := momentPin(moment, tt.sharing, circleID, tt.self)
+
+			if tt.hidden {
+				if pin != nil || showPlace {
+					t.Errorf("pin = %+v, showPlace = %v, want the moment hidden", pin, showPlace)
+				}
+				return
+			}
+			if pin == nil {
+				t.Fatal("pin hidden, want it shown")
+			}
+			if showPlace != tt.showPlace {
+				t.Errorf("showPlace = %v, want %v", showPlace, tt.showPlace)
+			}
+			if pin.Precision != tt.precision {
+				t.Errorf("precision = %q, want %q", pin.Precision, tt.precision)
+			}
+
+			cellSize := heatmapPrecisionCellSize[tt.precision]
+			if cellSize == 0 {
+				if pin.Latitude != moment.Latitude || pin.Longitude != moment.Longitude {
+					t.Errorf("pin at %v,%v, want the exact location", pin.Latitude, pin.Longitude)
+				}
+				return
+			}
+
+			// Fuzzed pins sit on the precision's grid, within a cell of the
+			// moment, and claim no better accuracy than the grid allows
+			if pin.Latitude == moment.Latitude && pin.Longitude == moment.Longitude {
+				t.Error("pin at the exact location, want it fuzzed")
+			}
+			if math.Abs(pin.Latitude-moment.Latitude) > cellSize || math.Abs(pin.Longitude-moment.Longitude) > cellSize {
+				t.Errorf("pin at %v,%v more than a cell from the moment", pin.Latitude, pin.Longitude)
+			}
+			if onGrid := pin.Latitude / cellSize; math.Abs(onGrid-math.Round(onGrid)) > 1e-6 {
+				t.Errorf("pin latitude %v not on the %v grid", pin.Latitude, cellSize)
+			}
+			if pin.Accuracy < cellSize*111000*math.Sqrt2/2 {
+				t.Errorf("accuracy = %v, finer than the grid", pin.Accuracy)
+			}
+			if !tt.showPlace && pin.PlaceName != "" {
+				t.Errorf("place name %q shown while places aren't shared", pin.PlaceName)
+			}
+		})
+	}
+}
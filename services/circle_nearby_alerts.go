@@ -0,0 +1,65 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"ftrack/models"
+	"ftrack/utils"
+)
+
+// GetNearbyAlertSettings returns the user's nearby alert opt-in for the
+// circle
+func (cs *CircleService) GetNearbyAlertSettings(ctx context.Context, userID, circleID string) (*models.NearbyAlertSettings, error) {
+	member, err := cs.activeMember(ctx, userID, circleID)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := member.NearbyAlerts
+	settings.Distance = nearbyAlertDistance(settings)
+	return &settings, nil
+}
+
+// UpdateNearbyAlertSettings opts the user in or out of nearby alerts in the
+// circle. Members only ever set their own, admins included.
+func (cs *CircleService) UpdateNearbyAlertSettings(ctx context.Context, userID, circleID string, req models.UpdateNearbyAlertsRequest) (*models.NearbyAlertSettings, error) {
+	if validationErrors := cs.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	member, err := cs.activeMember(ctx, userID, circleID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	settings := models.NearbyAlertSettings{
+		Enabled:   req.Enabled,
+		Distance:  req.Distance,
+		UpdatedAt: &now,
+	}
+	if settings.Distance == 0 {
+		settings.Distance = nearbyAlertDistance(member.NearbyAlerts)
+	}
+
+	if err := cs.circleRepo.UpdateMemberNearbyAlerts(ctx, circleID, userID, settings); err != nil {
+		return nil, err
+	}
+
+	return &settings, nil
+}
+
+func (cs *CircleService) activeMember(ctx context.Context, userID, circleID string) (*models.CircleMember, error) {
+	circle, err := cs.circleRepo.GetByID(ctx, circleID)
+	if err != nil {
+		return nil, err
+	}
+
+	member := findCircleMember(circle, userID)
+	if member == nil || member.Status != "active" {
+		return nil, errors.New("access denied")
+	}
+	return member, nil
+}
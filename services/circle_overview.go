@@ -0,0 +1,457 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"ftrack/models"
+	"ftrack/repositories"
+	"ftrack/utils"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// How long the part of a circle's overview every member sees alike is
+// cached. Sending or deleting a message drops it sooner.
+const circleOverviewCacheTTL = 30 * time.Second
+
+// Longest message preview, in characters
+const messagePreviewLength = 100
+
+// ConfigureOverview lets the circles overview read messages and
+// emergencies, and cache each circle's summary in Redis
+func (cs *CircleService) ConfigureOverview(messageRepo *repositories.MessageRepository, emergencyRepo *repositories.EmergencyRepository, cache *redis.Client) {
+	cs.messageRepo = messageRepo
+	cs.emergencyRepo = emergencyRepo
+	cs.overviewCache = cache
+}
+
+// circleSummary is the part of a circle's overview that is the same for
+// every member, cached per circle. The last message ignores blocks; it is
+// replaced for callers who blocked its sender.
+type circleSummary struct {
+	MemberCount         int                    `json:"memberCount"`
+	Members             []models.UserInfo      `json:"members"`
+	LastMessage         *models.MessagePreview `json:"lastMessage,omitempty"`
+	ActiveSOS           int64                  `json:"activeSos"`
+	PendingJoinRequests int64                  `json:"pendingJoinRequests"`
+
+	// Set when the last message is encrypted at rest, so its text is kept
+	// out of the cache
+	encrypted bool
+}
+
+func circleOverviewCacheKey(circleID string) string {
+	return "circle:overview:" + circleID
+}
+
+// dropCircleOverview drops the circle's cached summary, so the next
+// overview shows a change right away
+func dropCircleOverview(ctx context.Context, cache *redis.Client, circleID string) {
+	if cache == nil {
+		return
+	}
+	if err := cache.Del(ctx, circleOverviewCacheKey(circleID)).Err(); err != nil {
+		logrus.Warnf("Failed to drop overview cache of circle %s: %v", circleID, err)
+	}
+}
+
+// GetCirclesOverview sums up each of the user's circles in one call: its
+// members, latest message, the user's unread count, role and state, and its
+// alerts. Fields limits the overview to the parts named.
+func (cs *CircleService) GetCirclesOverview(ctx context.Context, userID string, req models.CirclesOverviewRequest) (*models.CirclesOverviewResponse, error) {
+	fields, err := parseCircleOverviewFields(req.Fields)
+	if err != nil {
+		return nil, err
+	}
+
+	circles, err := cs.circleRepo.GetUserCircles(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &models.CirclesOverviewResponse{
+		Circles:   make([]models.CircleOverview, 0, len(circles)),
+		Generated: time.Now(),
+	}
+	if len(circles) == 0 {
+		return response, nil
+	}
+
+	circleIDs := make([]primitive.ObjectID, len(circles))
+	for i, circle := range circles {
+		circleIDs[i] = circle.ID
+	}
+
+	var summaries map[primitive.ObjectID]*circleSummary
+	if fields[models.CircleOverviewMembers] || fields[models.CircleOverviewLastMessage] || fields[models.CircleOverviewAlerts] {
+		summaries, err = cs.getCircleSummaries(ctx, circles)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if fields[models.CircleOverviewLastMessage] {
+		if err := cs.replaceBlockedLastMessages(ctx, userID, summaries); err != nil {
+			return nil, err
+		}
+	}
+
+	var unread map[primitive.ObjectID]int64
+	if fields[models.CircleOverviewUnread] {
+		unread, err = cs.messageRepo.GetUnreadCounts(ctx, circleIDs, userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var user *models.User
+	var dnd *models.DoNotDisturbStatus
+	if fields[models.CircleOverviewState] {
+		user, err = cs.userRepo.GetByID(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		dnd, err = cs.notificationService.GetDoNotDisturbStatus(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, circle := range circles {
+		circleID := circle.ID.Hex()
+		overview := models.CircleOverview{
+			CircleID: circleID,
+			Name:     circle.Name,
+		}
+
+		member := circleMember(&circle, userID)
+		summary := summaries[circle.ID]
+
+		if fields[models.CircleOverviewMembers] && summary != nil {
+			memberCount := summary.MemberCount
+			overview.MemberCount = &memberCount
+			overview.Members = summary.Members
+		}
+		if fields[models.CircleOverviewLastMessage] && summary != nil {
+			overview.LastMessage = summary.LastMessage
+		}
+		if fields[models.CircleOverviewUnread] {
+			count := unread[circle.ID]
+			overview.UnreadCount = &count
+		}
+		if fields[models.CircleOverviewRole] && member != nil {
+			overview.Role = member.Role
+			if member.CustomRoleID != nil {
+				overview.CustomRoleID = member.CustomRoleID.Hex()
+			}
+		}
+		if fields[models.CircleOverviewState] {
+			muted := dnd.IsActive(response.Generated) && matchesAny(dnd.CircleIDs, circleID)
+			paused := !sharingIncludesCircle(user.LocationSharing, circleID)
+			overview.Muted = &muted
+			overview.Paused = &paused
+		}
+		if fields[models.CircleOverviewAlerts] && summary != nil {
+			activeSOS := summary.ActiveSOS
+			overview.ActiveSOS = &activeSOS
+			if cs.canHandleJoinRequests(ctx, circleID, userID, member) {
+				pending := summary.PendingJoinRequests
+				overview.PendingJoinRequests = &pending
+			}
+		}
+
+		response.Circles = append(response.Circles, overview)
+	}
+
+	return response, nil
+}
+
+// getCircleSummaries returns the circles' summaries, from the cache where it
+// has them. The rest are put together in a few batched queries and cached.
+func (cs *CircleService) getCircleSummaries(ctx context.Context, circles []models.Circle) (map[primitive.ObjectID]*circleSummary, error) {
+	summaries := make(map[primitive.ObjectID]*circleSummary, len(circles))
+
+	missing := circles
+	if cs.overviewCache != nil {
+		keys := make([]string, len(circles))
+		for i, circle := range circles {
+			keys[i] = circleOverviewCacheKey(circle.ID.Hex())
+		}
+
+		cached, err := cs.overviewCache.MGet(ctx, keys...).Result()
+		if err != nil {
+			logrus.Warnf("Failed to read circle overview cache: %v", err)
+			cached = nil
+		}
+
+		missing = nil
+		for i, circle := range circles {
+			if i < len(cached) {
+				if value, ok := cached[i].(string); ok {
+					var summary circleSummary
+					if err := json.Unmarshal([]byte(value), &summary); err == nil {
+						summaries[circle.ID] = &summary
+						continue
+					}
+				}
+			}
+			missing = append(missing, circle)
+		}
+	}
+	if len(missing) == 0 {
+		return summaries, nil
+	}
+
+	built, err := cs.buildCircleSummaries(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+
+	var pipe redis.Pipeliner
+	if cs.overviewCache != nil {
+		pipe = cs.overviewCache.Pipeline()
+	}
+	for circleID, summary := range built {
+		summaries[circleID] = summary
+		if pipe == nil || summary.encrypted {
+			continue
+		}
+		if value, err := json.Marshal(summary); err == nil {
+			pipe.Set(ctx, circleOverviewCacheKey(circleID.Hex()), value, circleOverviewCacheTTL)
+		}
+	}
+	if pipe != nil {
+		if _, err := pipe.Exec(ctx); err != nil {
+			logrus.Warnf("Failed to cache circle overviews: %v", err)
+		}
+	}
+
+	return summaries, nil
+}
+
+// buildCircleSummaries reads the circles' summaries from the database, with
+// one query per kind of data for all of them
+func (cs *CircleService) buildCircleSummaries(ctx context.Context, circles []models.Circle) (map[primitive.ObjectID]*circleSummary, error) {
+	circleIDs := make([]primitive.ObjectID, len(circles))
+	for i, circle := range circles {
+		circleIDs[i] = circle.ID
+	}
+
+	latest, err := cs.messageRepo.GetLatestMessages(ctx, circleIDs, nil)
+	if err != nil {
+		return nil, err
+	}
+	activeSOS, err := cs.emergencyRepo.CountActiveByCircles(ctx, circleIDs)
+	if err != nil {
+		return nil, err
+	}
+	pendingJoinRequests, err := cs.circleRepo.CountPendingJoinRequests(ctx, circleIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	// The most recently active members are the ones shown
+	shown := make(map[primitive.ObjectID][]models.CircleMember, len(circles))
+	memberCounts := make(map[primitive.ObjectID]int, len(circles))
+	var userIDs []string
+	for _, circle := range circles {
+		var active []models.CircleMember
+		for _, member := range circle.Members {
+			if member.Status == "active" {
+				active = append(active, member)
+			}
+		}
+		sort.SliceStable(active, func(i, j int) bool {
+			return active[i].LastActivity.After(active[j].LastActivity)
+		})
+		memberCounts[circle.ID] = len(active)
+		if len(active) > models.CircleOverviewMaxAvatars {
+			active = active[:models.CircleOverviewMaxAvatars]
+		}
+		shown[circle.ID] = active
+		for _, member := range active {
+			userIDs = append(userIDs, member.UserID.Hex())
+		}
+	}
+	for _, message := range latest {
+		userIDs = append(userIDs, message.SenderID.Hex())
+	}
+
+	usersByID, err := cs.getUserInfos(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make(map[primitive.ObjectID]*circleSummary, len(circles))
+	for _, circle := range circles {
+		summary := &circleSummary{
+			MemberCount:         memberCounts[circle.ID],
+			Members:             []models.UserInfo{},
+			ActiveSOS:           activeSOS[circle.ID],
+			PendingJoinRequests: pendingJoinRequests[circle.ID],
+		}
+		for _, member := range shown[circle.ID] {
+			if user, ok := usersByID[member.UserID.Hex()]; ok {
+				summary.Members = append(summary.Members, user)
+			}
+		}
+		if message, ok := latest[circle.ID]; ok {
+			summary.LastMessage = messagePreview(&message, usersByID)
+			summary.encrypted = message.ContentKeyID != ""
+		}
+		summaries[circle.ID] = summary
+	}
+
+	return summaries, nil
+}
+
+// replaceBlockedLastMessages swaps last messages sent by users the caller
+// blocked for the latest message from someone else, like the chat collapses
+// their messages. The cached summaries are left alone.
+func (cs *CircleService) replaceBlockedLastMessages(ctx context.Context, userID string, summaries map[primitive.ObjectID]*circleSummary) error {
+	blockedIDs, err := cs.blockRepo.GetBlockedUserIDs(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if len(blockedIDs) == 0 {
+		return nil
+	}
+
+	var circleIDs []primitive.ObjectID
+	for circleID, summary := range summaries {
+		if summary.LastMessage != nil && blockedIDs[summary.LastMessage.SenderID] {
+			circleIDs = append(circleIDs, circleID)
+		}
+	}
+	if len(circleIDs) == 0 {
+		return nil
+	}
+
+	excluded := make([]primitive.ObjectID, 0, len(blockedIDs))
+	for id := range blockedIDs {
+		if objectID, err := primitive.ObjectIDFromHex(id); err == nil {
+			excluded = append(excluded, objectID)
+		}
+	}
+
+	latest, err := cs.messageRepo.GetLatestMessages(ctx, circleIDs, excluded)
+	if err != nil {
+		return err
+	}
+
+	senderIDs := make([]string, 0, len(latest))
+	for _, message := range latest {
+		senderIDs = append(senderIDs, message.SenderID.Hex())
+	}
+	usersByID, err := cs.getUserInfos(ctx, senderIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, circleID := range circleIDs {
+		summary := *summaries[circleID]
+		summary.LastMessage = nil
+		if message, ok := latest[circleID]; ok {
+			summary.LastMessage = messagePreview(&message, usersByID)
+		}
+		summaries[circleID] = &summary
+	}
+	return nil
+}
+
+// canHandleJoinRequests reports whether the member sees the circle's pending
+// join requests. Failures are logged and treated as no.
+func (cs *CircleService) canHandleJoinRequests(ctx context.Context, circleID, userID string, member *models.CircleMember) bool {
+	switch {
+	case member == nil:
+		return false
+	case member.Role == "admin":
+		return true
+	case member.CustomRoleID == nil:
+		return false
+	}
+
+	allowed, err := cs.circleRepo.HasPermission(ctx, circleID, userID, models.CirclePermissionInviteMembers)
+	if err != nil {
+		logrus.Warnf("Failed to check join request permission in circle %s: %v", circleID, err)
+		return false
+	}
+	return allowed
+}
+
+func (cs *CircleService) getUserInfos(ctx context.Context, userIDs []string) (map[string]models.UserInfo, error) {
+	users, err := cs.userRepo.GetUsersByIDs(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	usersByID := make(map[string]models.UserInfo, len(users))
+	for _, user := range users {
+		usersByID[user.ID.Hex()] = models.UserInfo{
+			ID:        user.ID.Hex(),
+			FirstName: user.FirstName,
+			LastName:  user.LastName,
+			Avatar:    user.ProfilePicture,
+		}
+	}
+	return usersByID, nil
+}
+
+func circleMember(circle *models.Circle, userID string) *models.CircleMember {
+	for i := range circle.Members {
+		if circle.Members[i].UserID.Hex() == userID {
+			return &circle.Members[i]
+		}
+	}
+	return nil
+}
+
+func messagePreview(message *models.Message, usersByID map[string]models.UserInfo) *models.MessagePreview {
+	preview := &models.MessagePreview{
+		ID:       message.ID.Hex(),
+		SenderID: message.SenderID.Hex(),
+		Sender:   usersByID[message.SenderID.Hex()].FirstName,
+		Type:     message.Type,
+		SentAt:   message.ComposedAt,
+	}
+	if preview.SentAt.IsZero() {
+		preview.SentAt = message.CreatedAt
+	}
+
+	text := []rune(strings.TrimSpace(message.Content))
+	if len(text) > messagePreviewLength {
+		text = append(text[:messagePreviewLength-1], '…')
+	}
+	preview.Text = string(text)
+
+	return preview
+}
+
+// parseCircleOverviewFields returns the parts of the overview asked for,
+// all of them when none are
+func parseCircleOverviewFields(value string) (map[string]bool, error) {
+	fields := make(map[string]bool, len(models.CircleOverviewFields))
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !matchesAny(models.CircleOverviewFields, field) {
+			return nil, utils.NewValidationFailedError("unknown field " + field + ", expected one of " + strings.Join(models.CircleOverviewFields, ", "))
+		}
+		fields[field] = true
+	}
+
+	if len(fields) == 0 {
+		for _, field := range models.CircleOverviewFields {
+			fields[field] = true
+		}
+	}
+	return fields, nil
+}
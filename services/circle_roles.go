@@ -0,0 +1,236 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"ftrack/models"
+	"ftrack/repositories"
+	"ftrack/utils"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// requireCirclePermission returns "access denied" unless the member's role
+// grants the permission, and "member not found" for non-members
+func requireCirclePermission(ctx context.Context, circleRepo *repositories.CircleRepository, circleID, userID, permission string) error {
+	allowed, err := circleRepo.HasPermission(ctx, circleID, userID, permission)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return errors.New("access denied")
+	}
+	return nil
+}
+
+// GetCircleRoles lists the circle's custom roles, and the permissions a role
+// can grant, to its members
+func (cs *CircleService) GetCircleRoles(ctx context.Context, userID, circleID string) (*models.CircleRolesResponse, error) {
+	isMember, err := cs.circleRepo.IsMember(ctx, circleID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !isMember {
+		return nil, errors.New("access denied")
+	}
+
+	roles, err := cs.circleRepo.GetRoles(ctx, circleID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.CircleRolesResponse{
+		Roles:       roles,
+		Permissions: models.CirclePermissionCatalog,
+	}, nil
+}
+
+// CreateCircleRole defines a custom role. Only the owner manages roles.
+func (cs *CircleService) CreateCircleRole(ctx context.Context, userID, circleID string, req models.CreateCircleRoleRequest) (*models.CircleRole, error) {
+	circle, err := cs.requireCircleOwner(ctx, userID, circleID)
+	if err != nil {
+		return nil, err
+	}
+
+	if validationErrors := cs.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	permissions, err := validateRolePermissions(req.Permissions)
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := cs.circleRepo.GetRoles(ctx, circleID)
+	if err != nil {
+		return nil, err
+	}
+	if len(roles) >= models.MaxCircleRoles {
+		return nil, errors.New("too many roles")
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if err := checkRoleName(roles, name, primitive.NilObjectID); err != nil {
+		return nil, err
+	}
+
+	role := &models.CircleRole{
+		CircleID:    circle.ID,
+		Name:        name,
+		Description: strings.TrimSpace(req.Description),
+		Permissions: permissions,
+		CreatedBy:   circle.AdminID,
+	}
+	if err := cs.circleRepo.CreateRole(ctx, role); err != nil {
+		return nil, err
+	}
+
+	return role, nil
+}
+
+// UpdateCircleRole changes a custom role. Members it is assigned to get the
+// new permissions right away.
+func (cs *CircleService) UpdateCircleRole(ctx context.Context, userID, circleID, roleID string, req models.UpdateCircleRoleRequest) (*models.CircleRole, error) {
+	if _, err := cs.requireCircleOwner(ctx, userID, circleID); err != nil {
+		return nil, err
+	}
+
+	if validationErrors := cs.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	role, err := cs.circleRepo.GetRole(ctx, circleID, roleID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name != nil {
+		roles, err := cs.circleRepo.GetRoles(ctx, circleID)
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimSpace(*req.Name)
+		if err := checkRoleName(roles, name, role.ID); err != nil {
+			return nil, err
+		}
+		role.Name = name
+	}
+	if req.Description != nil {
+		role.Description = strings.TrimSpace(*req.Description)
+	}
+	if req.Permissions != nil {
+		permissions, err := validateRolePermissions(*req.Permissions)
+		if err != nil {
+			return nil, err
+		}
+		role.Permissions = permissions
+	}
+
+	if err := cs.circleRepo.UpdateRole(ctx, role); err != nil {
+		return nil, err
+	}
+
+	return role, nil
+}
+
+// DeleteCircleRole deletes a custom role, taking it away from its members
+func (cs *CircleService) DeleteCircleRole(ctx context.Context, userID, circleID, roleID string) error {
+	circle, err := cs.requireCircleOwner(ctx, userID, circleID)
+	if err != nil {
+		return err
+	}
+
+	role, err := cs.circleRepo.GetRole(ctx, circleID, roleID)
+	if err != nil {
+		return err
+	}
+
+	return cs.circleRepo.DeleteRole(ctx, circle.ID, role.ID)
+}
+
+// AssignCircleRole gives a member a custom role, or takes theirs away.
+// Admins have every permission already, so they can't be given one.
+func (cs *CircleService) AssignCircleRole(ctx context.Context, userID, circleID, memberID string, req models.AssignCircleRoleRequest) (*models.CircleMember, error) {
+	if _, err := cs.requireCircleOwner(ctx, userID, circleID); err != nil {
+		return nil, err
+	}
+
+	if validationErrors := cs.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	memberRole, err := cs.circleRepo.GetMemberRole(ctx, circleID, memberID)
+	if err != nil {
+		return nil, err
+	}
+
+	var roleID *primitive.ObjectID
+	if req.RoleID != "" {
+		if memberRole == "admin" {
+			return nil, errors.New("member is admin")
+		}
+
+		role, err := cs.circleRepo.GetRole(ctx, circleID, req.RoleID)
+		if err != nil {
+			return nil, err
+		}
+		roleID = &role.ID
+	}
+
+	if err := cs.circleRepo.SetMemberCustomRole(ctx, circleID, memberID, roleID); err != nil {
+		return nil, err
+	}
+
+	return cs.GetMember(ctx, userID, circleID, memberID)
+}
+
+// requireCircleOwner returns the circle if the user owns it and it isn't
+// archived
+func (cs *CircleService) requireCircleOwner(ctx context.Context, userID, circleID string) (*models.Circle, error) {
+	circle, err := cs.circleRepo.GetByID(ctx, circleID)
+	if err != nil {
+		return nil, err
+	}
+	if !circle.IsOwner(userID) {
+		return nil, errors.New("access denied")
+	}
+	if circle.IsArchived() {
+		return nil, errors.New("circle is archived")
+	}
+	return circle, nil
+}
+
+// validateRolePermissions checks the permissions against the catalog and
+// drops repeats
+func validateRolePermissions(permissions []string) ([]string, error) {
+	seen := make(map[string]bool, len(permissions))
+	valid := make([]string, 0, len(permissions))
+	for _, permission := range permissions {
+		if !models.IsCirclePermission(permission) {
+			return nil, utils.NewValidationFailedError("unknown permission " + permission)
+		}
+		if seen[permission] {
+			continue
+		}
+		seen[permission] = true
+		valid = append(valid, permission)
+	}
+	return valid, nil
+}
+
+// checkRoleName rejects the built-in role names and names another of the
+// circle's roles has, ignoring case
+func checkRoleName(roles []models.CircleRole, name string, except primitive.ObjectID) error {
+	switch strings.ToLower(name) {
+	case "owner", "admin", "member":
+		return utils.NewValidationFailedError(name + " is a built-in role")
+	}
+	for _, role := range roles {
+		if role.ID != except && strings.EqualFold(role.Name, name) {
+			return errors.New("role name taken")
+		}
+	}
+	return nil
+}
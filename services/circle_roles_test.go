@@ -0,0 +1,214 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"ftrack/models"
+	"ftrack/testharness"
+	"ftrack/utils"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestValidateRolePermissions(t *testing.T) {
+	permissions, err := validateRolePermissions([]string{
+		models.CirclePermissionDeleteMessages, models.CirclePermissionManagePlaces, models.CirclePermissionDeleteMessages,
+	})
+	if err != nil || strings.Join(permissions, ",") != "messages.delete,places.manage" {
+		t.Errorf("permissions %v, %v; want the two without the repeat", permissions, err)
+	}
+	if _, err := validateRolePermissions([]string{models.CirclePermissionManageMembers, "circle.delete"}); utils.ValidationFailureReason(err) != "unknown permission circle.delete" {
+		t.Errorf("permission outside the catalog error = %v", err)
+	}
+
+	moderator := models.CircleRole{ID: primitive.NewObjectID(), Name: "Moderator"}
+	roles := []models.CircleRole{moderator}
+	tests := []struct {
+		name   string
+		except primitive.ObjectID
+		err    string
+	}{
+		{"Greeter", primitive.NilObjectID, ""},
+		{"moderator", primitive.NilObjectID, "role name taken"},
+		{"MODERATOR", moderator.ID, ""}, // renaming the role itself
+		{"Admin", primitive.NilObjectID, "validation failed"},
+		{"owner", primitive.NilObjectID, "validation failed"},
+	}
+	for _, tt := range tests {
+		got := ""
+		if err := checkRoleName(roles, tt.name, tt.except); err != nil {
+			got = err.Error()
+		}
+		if got != tt.err {
+			t.Errorf("role named %s error %q, want %q", tt.name, got, tt.err)
+		}
+	}
+}
+
+func TestCustomRoleDeletesMessages(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	cs := newTestCircleService(env)
+	ms := newTestMessageService(env)
+	ctx := context.Background()
+
+	owner, moderator, member := env.Factory.User(), env.Factory.User(), env.Factory.User()
+	circle := env.Factory.Circle(owner, []*models.User{moderator, member})
+	circleID, ownerID, moderatorID := circle.ID.Hex(), owner.ID.Hex(), moderator.ID.Hex()
+
+	deleteAs := func(user *models.User, sender *models.User) error {
+		t.Helper()
+		message := env.Factory.Message(circle, sender, "hello")
+		return ms.DeleteMessage(ctx, user.ID.Hex(), message.ID.Hex())
+	}
+
+	// Only the owner defines roles, from the catalog
+	req := models.CreateCircleRoleRequest{Name: "Moderator", Permissions: []string{models.CirclePermissionDeleteMessages}}
+	if _, err := cs.CreateCircleRole(ctx, moderatorID, circleID, req); err == nil || err.Error() != "access denied" {
+		t.Errorf("member creating a role error = %v, want access denied", err)
+	}
+	if _, err := cs.CreateCircleRole(ctx, ownerID, circleID, models.CreateCircleRoleRequest{Name: "Chief", Permissions: []string{"circle.delete"}}); utils.ValidationFailureReason(err) != "unknown permission circle.delete" {
+		t.Errorf("role with an unknown permission error = %v", err)
+	}
+	role, err := cs.CreateCircleRole(ctx, ownerID, circleID, req)
+	if err != nil {
+		t.Fatalf("CreateCircleRole: %v", err)
+	}
+	if _, err := cs.CreateCircleRole(ctx, ownerID, circleID, req); err == nil || err.Error() != "role name taken" {
+		t.Errorf("second role with the name error = %v, want role name taken", err)
+	}
+
+	// Without the role, a member can't delete someone else's message
+	if err := deleteAs(moderator, member); err == nil || err.Error() != "access denied" {
+		t.Fatalf("deleting before the role was assigned error = %v, want access denied", err)
+	}
+
+	assign := models.AssignCircleRoleRequest{RoleID: role.ID.Hex()}
+	if _, err := cs.AssignCircleRole(ctx, moderatorID, circleID, moderatorID, assign); err == nil || err.Error() != "access denied" {
+		t.Errorf("member assigning themselves a role error = %v, want access denied", err)
+	}
+	if _, err := cs.AssignCircleRole(ctx, ownerID, circleID, ownerID, assign); err == nil || err.Error() != "member is admin" {
+		t.Errorf("assigning a role to an admin error = %v, want member is admin", err)
+	}
+	assigned, err := cs.AssignCircleRole(ctx, ownerID, circleID, moderatorID, assign)
+	if err != nil {
+		t.Fatalf("AssignCircleRole: %v", err)
+	}
+	if assigned.CustomRoleID == nil || *assigned.CustomRoleID != role.ID {
+		t.Errorf("member's role %v, want %s", assigned.CustomRoleID, role.ID.Hex())
+	}
+
+	// The role grants deleting messages and nothing else
+	if err := deleteAs(moderator, member); err != nil {
+		t.Errorf("moderator deleting a message: %v", err)
+	}
+	if err := deleteAs(member, moderator); err == nil || err.Error() != "access denied" {
+		t.Errorf("member without the role deleting a message error = %v, want access denied", err)
+	}
+	name := "Renamed"
+	if _, err := cs.UpdateCircle(ctx, moderatorID, circleID, models.UpdateCircleRequest{Name: &name}); err == nil || err.Error() != "access denied" {
+		t.Errorf("moderator renaming the circle error = %v, want access denied", err)
+	}
+	for _, permission := range []string{models.CirclePermissionManageMembers, models.CirclePermissionInviteMembers} {
+		if allowed, err := env.Repos.Circle.HasPermission(ctx, circleID, moderatorID, permission); err != nil || allowed {
+			t.Errorf("moderator has %s: %v, %v", permission, allowed, err)
+		}
+	}
+
+	// Editing the role applies to its members right away
+	permissions := []string{models.CirclePermissionManageSettings}
+	if _, err := cs.UpdateCircleRole(ctx, ownerID, circleID, role.ID.Hex(), models.UpdateCircleRoleRequest{Permissions: &permissions}); err != nil {
+		t.Fatalf("UpdateCircleRole: %v", err)
+	}
+	if err := deleteAs(moderator, member); err == nil || err.Error() != "access denied" {
+		t.Errorf("deleting after the permission was taken away error = %v, want access denied", err)
+	}
+	if _, err := cs.UpdateCircle(ctx, moderatorID, circleID, models.UpdateCircleRequest{Name: &name}); err != nil {
+		t.Errorf("renaming the circle with the settings permission: %v", err)
+	}
+
+	// Deleting the role takes it away
+	if err := cs.DeleteCircleRole(ctx, ownerID, circleID, role.ID.Hex()); err != nil {
+		t.Fatalf("DeleteCircleRole: %v", err)
+	}
+	if allowed, err := env.Repos.Circle.HasPermission(ctx, circleID, moderatorID, models.CirclePermissionManageSettings); err != nil || allowed {
+		t.Errorf("permission after the role was deleted: %v, %v", allowed, err)
+	}
+	roles, err := cs.GetCircleRoles(ctx, moderatorID, circleID)
+	if err != nil {
+		t.Fatalf("GetCircleRoles: %v", err)
+	}
+	if len(roles.Roles) != 0 || len(roles.Permissions) != len(models.CirclePermissionCatalog) {
+		t.Errorf("%d roles and %d permissions listed, want none and the catalog", len(roles.Roles), len(roles.Permissions))
+	}
+}
+
+func TestCustomRoleCannotEscalateMemberPermissions(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	cs := newTestCircleService(env)
+	ctx := context.Background()
+
+	owner, coAdmin, manager, member := env.Factory.User(), env.Factory.User(), env.Factory.User(), env.Factory.User()
+	circle := env.Factory.Circle(owner, []*models.User{coAdmin, manager, member}, asAdmin(1, models.MemberPermissions{CanSeeLocation: true}))
+	circleID, ownerID, managerID := circle.ID.Hex(), owner.ID.Hex(), manager.ID.Hex()
+
+	role, err := cs.CreateCircleRole(ctx, ownerID, circleID, models.CreateCircleRoleRequest{
+		Name: "Organizer", Permissions: []string{models.CirclePermissionManageMembers},
+	})
+	if err != nil {
+		t.Fatalf("CreateCircleRole: %v", err)
+	}
+	if _, err := cs.AssignCircleRole(ctx, ownerID, circleID, managerID, models.AssignCircleRoleRequest{RoleID: role.ID.Hex()}); err != nil {
+		t.Fatalf("AssignCircleRole: %v", err)
+	}
+
+	// Managing members doesn't reach the manager's own permissions or the
+	// admins'
+	everything := models.MemberPermissions{CanSeeLocation: true, CanSeeDriving: true, CanSendMessages: true}
+	tests := []struct {
+		name   string
+		userID string
+		target *models.User
+		err    string
+	}{
+		{"manager on themselves", managerID, manager, "cannot change own permissions"},
+		{"manager on the owner", managerID, owner, "cannot change admin permissions"},
+		{"manager on an admin", managerID, coAdmin, "cannot change admin permissions"},
+		{"member on the manager", member.ID.Hex(), manager, "access denied"},
+		{"owner on themselves", ownerID, owner, "cannot change own permissions"},
+		{"manager on a member", managerID, member, ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if err := cs.UpdateMemberPermissions(ctx, tt.userID, circleID, tt.target.ID.Hex(), everything); err != nil {
+			got = err.Error()
+		}
+		if got != tt.err {
+			t.Errorf("%s: error %q, want %q", tt.name, got, tt.err)
+		}
+	}
+
+	stored, err := env.Repos.Circle.GetByID(ctx, circleID)
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	for _, m := range stored.Members {
+		switch m.UserID {
+		case manager.ID:
+			if m.Permissions.CanSeeDriving {
+				t.Error("manager gave themselves driving")
+			}
+		case coAdmin.ID:
+			if m.Permissions.CanSeeDriving {
+				t.Error("admin's permissions rewritten")
+			}
+		case member.ID:
+			if !m.Permissions.CanSeeDriving {
+				t.Error("member's permissions not updated")
+			}
+		}
+	}
+}
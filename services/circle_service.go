@@ -7,26 +7,83 @@ import (
 	"ftrack/models"
 	"ftrack/repositories"
 	"ftrack/utils"
+	"sort"
 	"time"
 
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type CircleService struct {
-	circleRepo *repositories.CircleRepository
-	userRepo   *repositories.UserRepository
-	validator  *utils.ValidationService
+	circleRepo          *repositories.CircleRepository
+	userRepo            *repositories.UserRepository
+	auditRepo           *repositories.AuditLogRepository
+	blockRepo           *repositories.BlockRepository
+	notificationService *NotificationService
+	validator           *utils.ValidationService
+	trackingHints       *TrackingHintService
+	outbox              *OutboxService
+
+	// Set by ConfigureMerge, the place service also feeds the activity feed
+	placeService   *PlaceService
+	messageRepo    *repositories.MessageRepository
+	automationRepo *repositories.AutomationRepository
+
+	// Set by ConfigureAlbums
+	albumRepo *repositories.AlbumRepository
+	placeRepo *repositories.PlaceRepository
+
+	// Set by ConfigureMoments
+	mediaRepo *repositories.MediaRepository
+
+	// Set by ConfigureExports
+	exportService *ExportService
+
+	// Set by ConfigureInviteLinks
+	jwtService *utils.JWTService
+
+	// Set by ConfigureOverview
+	emergencyRepo *repositories.EmergencyRepository
+	overviewCache *redis.Client
 }
 
-func NewCircleService(circleRepo *repositories.CircleRepository, userRepo *repositories.UserRepository) *CircleService {
+func NewCircleService(circleRepo *repositories.CircleRepository, userRepo *repositories.UserRepository, auditRepo *repositories.AuditLogRepository, blockRepo *repositories.BlockRepository, notificationService *NotificationService) *CircleService {
 	return &CircleService{
-		circleRepo: circleRepo,
-		userRepo:   userRepo,
-		validator:  utils.NewValidationService(),
+		circleRepo:          circleRepo,
+		userRepo:            userRepo,
+		auditRepo:           auditRepo,
+		blockRepo:           blockRepo,
+		notificationService: notificationService,
+		validator:           utils.NewValidationService(),
 	}
 }
 
+// ConfigureTrackingHints lets member location requests ask the members to
+// report live
+func (cs *CircleService) ConfigureTrackingHints(trackingHints *TrackingHintService) {
+	cs.trackingHints = trackingHints
+}
+
+// ConfigureOutbox tells circles about members joining and leaving, through
+// the outbox so a crash after the change doesn't lose the update
+func (cs *CircleService) ConfigureOutbox(outbox *OutboxService) {
+	cs.outbox = outbox
+}
+
+// ConfigureInviteLinks returns a signed link token with each invitation
+// created, which joins the circle without knowing the invitation ID
+func (cs *CircleService) ConfigureInviteLinks(jwtService *utils.JWTService) {
+	cs.jwtService = jwtService
+}
+
+// ConfigureExports lets the circle's export policy decide who exports its
+// data, rather than admins only
+func (cs *CircleService) ConfigureExports(exportService *ExportService) {
+	cs.exportService = exportService
+}
+
 // ========================
 // Basic CRUD Operations
 // ========================
@@ -34,7 +91,7 @@ func NewCircleService(circleRepo *repositories.CircleRepository, userRepo *repos
 func (cs *CircleService) CreateCircle(ctx context.Context, userID string, req models.CreateCircleRequest) (*models.Circle, error) {
 	// Validate request
 	if validationErrors := cs.validator.ValidateStruct(req); len(validationErrors) > 0 {
-		return nil, errors.New("validation failed")
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
 	}
 
 	userObjectID, err := primitive.ObjectIDFromHex(userID)
@@ -103,18 +160,55 @@ func (cs *CircleService) GetCircle(ctx context.Context, userID, circleID string)
 		return nil, errors.New("access denied")
 	}
 
-	return cs.circleRepo.GetByID(ctx, circleID)
+	circle, err := cs.circleRepo.GetByID(ctx, circleID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Blocks affect coordination, so admins are told they exist
+	if cs.isCircleAdmin(circle, userID) {
+		memberIDs := make([]primitive.ObjectID, len(circle.Members))
+		for i, member := range circle.Members {
+			memberIDs[i] = member.UserID
+		}
+
+		circle.HasMemberBlocks, err = cs.blockRepo.HasBlocksAmong(ctx, memberIDs)
+		if err != nil {
+			logrus.Warnf("Failed to check member blocks for circle %s: %v", circleID, err)
+		}
+	}
+
+	return circle, nil
 }
 
-func (cs *CircleService) UpdateCircle(ctx context.Context, userID, circleID string, req models.UpdateCircleRequest) (*models.Circle, error) {
-	// Check if user is admin
-	role, err := cs.circleRepo.GetMemberRole(ctx, circleID, userID)
+func (cs *CircleService) isCircleAdmin(circle *models.Circle, userID string) bool {
+	for _, member := range circle.Members {
+		if member.UserID.Hex() == userID {
+			return member.Role == "admin"
+		}
+	}
+	return false
+}
+
+// ensureNotArchived refuses changes to circles merged into another one
+func (cs *CircleService) ensureNotArchived(ctx context.Context, circleID string) error {
+	archived, err := cs.circleRepo.IsArchived(ctx, circleID)
 	if err != nil {
+		return err
+	}
+	if archived {
+		return errors.New("circle is archived")
+	}
+	return nil
+}
+
+func (cs *CircleService) UpdateCircle(ctx context.Context, userID, circleID string, req models.UpdateCircleRequest) (*models.Circle, error) {
+	if err := requireCirclePermission(ctx, cs.circleRepo, circleID, userID, models.CirclePermissionManageSettings); err != nil {
 		return nil, err
 	}
 
-	if role != "admin" {
-		return nil, errors.New("access denied")
+	if err := cs.ensureNotArchived(ctx, circleID); err != nil {
+		return nil, err
 	}
 
 	// Build update document
@@ -123,10 +217,13 @@ func (cs *CircleService) UpdateCircle(ctx context.Context, userID, circleID stri
 		update["name"] = *req.Name
 	}
 	if req.Settings != nil {
+		if err := checkMessageEncryption(*req.Settings); err != nil {
+			return nil, err
+		}
 		update["settings"] = *req.Settings
 	}
 
-	err = cs.circleRepo.Update(ctx, circleID, update)
+	err := cs.circleRepo.Update(ctx, circleID, update)
 	if err != nil {
 		return nil, err
 	}
@@ -135,34 +232,138 @@ func (cs *CircleService) UpdateCircle(ctx context.Context, userID, circleID stri
 }
 
 func (cs *CircleService) DeleteCircle(ctx context.Context, userID, circleID string) error {
-	// Check if user is admin
-	role, err := cs.circleRepo.GetMemberRole(ctx, circleID, userID)
+	circle, err := cs.circleRepo.GetByID(ctx, circleID)
 	if err != nil {
 		return err
 	}
 
-	if role != "admin" {
+	// Only the owner can delete the circle
+	if !circle.IsOwner(userID) {
 		return errors.New("access denied")
 	}
 
 	return cs.circleRepo.Delete(ctx, circleID)
 }
 
-// ========================
-// Invitation Management
-// ========================
+// TransferOwnership hands the circle to another admin member. The previous
+// owner stays in the circle as an admin.
+func (cs *CircleService) TransferOwnership(ctx context.Context, userID, circleID string, req models.TransferOwnershipRequest) (*models.Circle, error) {
+	// Validate request
+	if validationErrors := cs.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
 
-func (cs *CircleService) GetCircleInvitations(ctx context.Context, userID, circleID string) ([]models.CircleInvitation, error) {
-	// Check if user is admin or member with appropriate permissions
-	role, err := cs.circleRepo.GetMemberRole(ctx, circleID, userID)
+	if !req.Confirm {
+		return nil, errors.New("confirmation required")
+	}
+
+	circle, err := cs.circleRepo.GetByID(ctx, circleID)
 	if err != nil {
 		return nil, err
 	}
 
-	if role != "admin" {
+	if !circle.IsOwner(userID) {
 		return nil, errors.New("access denied")
 	}
 
+	if req.NewOwnerID == userID {
+		return nil, errors.New("already owner")
+	}
+
+	// The new owner must be an existing admin member
+	newOwnerRole, err := cs.circleRepo.GetMemberRole(ctx, circleID, req.NewOwnerID)
+	if err != nil {
+		return nil, errors.New("member not found")
+	}
+
+	if newOwnerRole != "admin" {
+		return nil, errors.New("not admin")
+	}
+
+	if err := cs.circleRepo.TransferOwnership(ctx, circleID, userID, req.NewOwnerID); err != nil {
+		return nil, err
+	}
+
+	details := map[string]interface{}{
+		"circleId":        circleID,
+		"previousOwnerId": userID,
+		"newOwnerId":      req.NewOwnerID,
+	}
+
+	if err := cs.auditRepo.LogSecurityEvent(ctx, userID, "circle_ownership_transferred", "Circle ownership transferred", req.IPAddress, req.UserAgent, "", "info", details); err != nil {
+		logrus.Warnf("Failed to write audit log for circle %s: %v", circleID, err)
+	}
+
+	userObjectID, _ := primitive.ObjectIDFromHex(userID)
+	activity := models.CircleActivity{
+		CircleID:  circle.ID,
+		UserID:    userObjectID,
+		Type:      "member",
+		Action:    "ownership_transferred",
+		Data:      details,
+		CreatedAt: time.Now(),
+	}
+	if err := cs.circleRepo.CreateActivity(ctx, &activity); err != nil {
+		logrus.Warnf("Failed to record ownership transfer activity for circle %s: %v", circleID, err)
+	}
+
+	Background.Go(ctx, func(ctx context.Context) {
+		cs.notifyOwnershipTransfer(ctx, circle, userID, req.NewOwnerID)
+	})
+
+	return cs.circleRepo.GetByID(ctx, circleID)
+}
+
+func (cs *CircleService) notifyOwnershipTransfer(ctx context.Context, circle *models.Circle, previousOwnerID, newOwnerID string) {
+	if cs.notificationService == nil {
+		return
+	}
+
+	data := map[string]interface{}{
+		"circleId":        circle.ID.Hex(),
+		"previousOwnerId": previousOwnerID,
+		"newOwnerId":      newOwnerID,
+	}
+
+	notifications := []models.SendNotificationRequest{
+		{
+			Recipients:       []string{newOwnerID},
+			Title:            "You're now the circle owner",
+			Message:          fmt.Sprintf("You are now the owner of %s", circle.Name),
+			Type:             "circle_ownership_transferred",
+			Priority:         "normal",
+			Category:         "circle",
+			Data:             data,
+			DeliveryChannels: []string{"push"},
+		},
+		{
+			Recipients:       []string{previousOwnerID},
+			Title:            "Circle ownership transferred",
+			Message:          fmt.Sprintf("You transferred ownership of %s and are now an admin", circle.Name),
+			Type:             "circle_ownership_transferred",
+			Priority:         "normal",
+			Category:         "circle",
+			Data:             data,
+			DeliveryChannels: []string{"push"},
+		},
+	}
+
+	for _, notification := range notifications {
+		if err := cs.notificationService.SendNotification(ctx, notification); err != nil {
+			logrus.Errorf("Failed to send ownership transfer notification: %v", err)
+		}
+	}
+}
+
+// ========================
+// Invitation Management
+// ========================
+
+func (cs *CircleService) GetCircleInvitations(ctx context.Context, userID, circleID string) ([]models.CircleInvitation, error) {
+	if err := requireCirclePermission(ctx, cs.circleRepo, circleID, userID, models.CirclePermissionInviteMembers); err != nil {
+		return nil, err
+	}
+
 	// Get all invitations for this circle
 	invitationCollection := cs.circleRepo.GetInvitationCollection()
 	circleObjectID, _ := primitive.ObjectIDFromHex(circleID)
@@ -179,24 +380,28 @@ func (cs *CircleService) GetCircleInvitations(ctx context.Context, userID, circl
 }
 
 func (cs *CircleService) CreateInvitation(ctx context.Context, userID, circleID string, req models.InviteMemberRequest) (*models.CircleInvitation, error) {
-	// Check if user has permission to invite
-	role, err := cs.circleRepo.GetMemberRole(ctx, circleID, userID)
-	if err != nil {
+	if err := requireCirclePermission(ctx, cs.circleRepo, circleID, userID, models.CirclePermissionInviteMembers); err != nil {
 		return nil, err
 	}
 
-	if role != "admin" {
-		return nil, errors.New("access denied")
-	}
-
 	// Validate request
 	if validationErrors := cs.validator.ValidateStruct(req); len(validationErrors) > 0 {
-		return nil, errors.New("validation failed")
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
 	}
 
-	// Check if circle exists
-	if err != nil {
-		return nil, errors.New("circle not found")
+	// Custom roles can invite members, but only admins can invite admins
+	if req.Role == "admin" {
+		role, err := cs.circleRepo.GetMemberRole(ctx, circleID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if role != "admin" {
+			return nil, errors.New("access denied")
+		}
+	}
+
+	if err := cs.ensureNotArchived(ctx, circleID); err != nil {
+		return nil, err
 	}
 
 	userObjectID, _ := primitive.ObjectIDFromHex(userID)
@@ -228,11 +433,18 @@ func (cs *CircleService) CreateInvitation(ctx context.Context, userID, circleID
 		ExpiresAt: time.Now().AddDate(0, 0, 7), // 7 days from now
 	}
 
-	err = cs.circleRepo.CreateInvitation(ctx, invitation)
+	err := cs.circleRepo.CreateInvitation(ctx, invitation)
 	if err != nil {
 		return nil, err
 	}
 
+	if cs.jwtService != nil {
+		invitation.Token, err = cs.jwtService.GenerateInviteToken(invitation.ID.Hex(), circleID, userID, invitation.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return invitation, nil
 }
 
@@ -343,6 +555,10 @@ func (cs *CircleService) JoinByInviteCode(ctx context.Context, userID, inviteCod
 		return nil, errors.New("invalid invite code")
 	}
 
+	if circle.IsArchived() {
+		return nil, errors.New("circle is archived")
+	}
+
 	// Check if user is already a member
 	isMember, err := cs.circleRepo.IsMember(ctx, circle.ID.Hex(), userID)
 	if err != nil {
@@ -379,6 +595,7 @@ func (cs *CircleService) JoinByInviteCode(ctx context.Context, userID, inviteCod
 	if err != nil {
 		return nil, err
 	}
+	cs.publishMembershipChange(ctx, circle.ID.Hex(), userID, "member_joined", newMember.JoinedAt, nil)
 
 	// Update stats
 	cs.circleRepo.Update(ctx, circle.ID.Hex(), bson.M{
@@ -389,6 +606,21 @@ func (cs *CircleService) JoinByInviteCode(ctx context.Context, userID, inviteCod
 	return cs.circleRepo.GetByID(ctx, circle.ID.Hex())
 }
 
+// AcceptInviteLink joins the circle of the invitation a link token stands
+// for, as AcceptInvitation does
+func (cs *CircleService) AcceptInviteLink(ctx context.Context, userID, token string) (*models.Circle, error) {
+	if cs.jwtService == nil {
+		return nil, errors.New("invalid invite link")
+	}
+
+	claims, err := cs.jwtService.ValidateToken(token, utils.AudienceInvite)
+	if err != nil || claims.InvitationID == "" {
+		return nil, errors.New("invalid invite link")
+	}
+
+	return cs.AcceptInvitation(ctx, userID, claims.InvitationID)
+}
+
 func (cs *CircleService) AcceptInvitation(ctx context.Context, userID, invitationID string) (*models.Circle, error) {
 	// Get invitation details
 	invitation, err := cs.circleRepo.GetInvitationByID(ctx, invitationID)
@@ -448,6 +680,7 @@ func (cs *CircleService) AcceptInvitation(ctx context.Context, userID, invitatio
 	if err != nil {
 		return nil, err
 	}
+	cs.publishMembershipChange(ctx, invitation.CircleID.Hex(), userID, "member_joined", newMember.JoinedAt, nil)
 
 	// Update invitation status
 	cs.circleRepo.UpdateInvitationStatus(ctx, invitationID, "accepted")
@@ -550,32 +783,20 @@ func (cs *CircleService) GetMember(ctx context.Context, userID, circleID, member
 }
 
 func (cs *CircleService) UpdateMember(ctx context.Context, userID, circleID, memberID string, req map[string]interface{}) (*models.CircleMember, error) {
-	// Check if user is admin
-	role, err := cs.circleRepo.GetMemberRole(ctx, circleID, userID)
-	if err != nil {
+	if err := requireCirclePermission(ctx, cs.circleRepo, circleID, userID, models.CirclePermissionManageMembers); err != nil {
 		return nil, err
 	}
 
-	if role != "admin" {
-		return nil, errors.New("access denied")
-	}
-
 	// Update member (implement specific update logic based on req fields)
 	// For now, return the member
 	return cs.GetMember(ctx, userID, circleID, memberID)
 }
 
 func (cs *CircleService) RemoveMember(ctx context.Context, userID, circleID, memberID string) error {
-	// Check if user is admin
-	role, err := cs.circleRepo.GetMemberRole(ctx, circleID, userID)
-	if err != nil {
+	if err := requireCirclePermission(ctx, cs.circleRepo, circleID, userID, models.CirclePermissionManageMembers); err != nil {
 		return err
 	}
 
-	if role != "admin" {
-		return errors.New("access denied")
-	}
-
 	// Check if member exists in circle
 	isMember, err := cs.circleRepo.IsMember(ctx, circleID, memberID)
 	if err != nil {
@@ -601,7 +822,70 @@ func (cs *CircleService) RemoveMember(ctx context.Context, userID, circleID, mem
 		return errors.New("cannot remove admin")
 	}
 
-	return cs.circleRepo.RemoveMember(ctx, circleID, memberID)
+	if err := cs.circleRepo.RemoveMember(ctx, circleID, memberID); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	cs.publishMembershipChange(ctx, circleID, memberID, "member_left", now, map[string]interface{}{
+		"removedBy": userID,
+	})
+	cs.notifyMemberRemoved(ctx, circleID, memberID, now)
+
+	return nil
+}
+
+// publishMembershipChange sends a circle_update about a member to the
+// circle. Changes are only published with the outbox configured.
+func (cs *CircleService) publishMembershipChange(ctx context.Context, circleID, memberID, changeType string, at time.Time, data map[string]interface{}) {
+	if cs.outbox == nil {
+		return
+	}
+
+	message := models.WSMessage{
+		Type: models.WSTypeCircleUpdate,
+		Data: models.WSCircleUpdate{
+			CircleID:  circleID,
+			Type:      changeType,
+			UserID:    memberID,
+			Data:      data,
+			Timestamp: at,
+		},
+		CircleID:  circleID,
+		Timestamp: at,
+	}
+
+	key := fmt.Sprintf("circle:%s:%s:%s:%d", circleID, changeType, memberID, at.UnixNano())
+	if err := cs.outbox.EnqueueCircleBroadcast(ctx, key, circleID, message); err != nil {
+		logrus.Errorf("Failed to enqueue %s for circle %s: %v", changeType, circleID, err)
+	}
+}
+
+// notifyMemberRemoved tells a member an admin removed them from the circle
+func (cs *CircleService) notifyMemberRemoved(ctx context.Context, circleID, memberID string, at time.Time) {
+	if cs.outbox == nil {
+		return
+	}
+
+	circleName := "a circle"
+	if circle, err := cs.circleRepo.GetByID(ctx, circleID); err == nil {
+		circleName = circle.Name
+	}
+
+	key := fmt.Sprintf("circle:%s:member_removed:%s:%d", circleID, memberID, at.UnixNano())
+	err := cs.outbox.EnqueueNotification(ctx, key, models.SendNotificationRequest{
+		Recipients:       []string{memberID},
+		Title:            "Removed from circle",
+		Message:          fmt.Sprintf("You were removed from %s", circleName),
+		Type:             "circle_member_removed",
+		Priority:         "normal",
+		Category:         "circle",
+		Data:             map[string]interface{}{"circleId": circleID},
+		DeliveryChannels: []string{"push"},
+	})
+	if err != nil {
+		logrus.Errorf("Failed to enqueue removal notification for %s: %v", memberID, err)
+	}
 }
 
 func (cs *CircleService) PromoteMember(ctx context.Context, userID, circleID, memberID string) error {
@@ -644,6 +928,16 @@ func (cs *CircleService) DemoteMember(ctx context.Context, userID, circleID, mem
 		return errors.New("cannot demote self")
 	}
 
+	// The owner must transfer ownership before stepping down
+	circle, err := cs.circleRepo.GetByID(ctx, circleID)
+	if err != nil {
+		return err
+	}
+
+	if circle.IsOwner(memberID) {
+		return errors.New("cannot demote owner")
+	}
+
 	// Check current member role
 	memberRole, err := cs.circleRepo.GetMemberRole(ctx, circleID, memberID)
 	if err != nil {
@@ -658,16 +952,10 @@ func (cs *CircleService) DemoteMember(ctx context.Context, userID, circleID, mem
 }
 
 func (cs *CircleService) UpdateMemberPermissions(ctx context.Context, userID, circleID, memberID string, permissions models.MemberPermissions) error {
-	// Check if user is admin
-	role, err := cs.circleRepo.GetMemberRole(ctx, circleID, userID)
-	if err != nil {
+	if err := requireCirclePermission(ctx, cs.circleRepo, circleID, userID, models.CirclePermissionManageMembers); err != nil {
 		return err
 	}
 
-	if role != "admin" {
-		return errors.New("access denied")
-	}
-
 	// Check if member exists
 	isMember, err := cs.circleRepo.IsMember(ctx, circleID, memberID)
 	if err != nil {
@@ -678,6 +966,26 @@ func (cs *CircleService) UpdateMemberPermissions(ctx context.Context, userID, ci
 		return errors.New("member not found")
 	}
 
+	// A custom role can manage members, so don't let it widen its own
+	// permissions or touch the admins'
+	if userID == memberID {
+		return errors.New("cannot change own permissions")
+	}
+
+	circle, err := cs.circleRepo.GetByID(ctx, circleID)
+	if err != nil {
+		return errors.New("circle not found")
+	}
+
+	memberRole, err := cs.circleRepo.GetMemberRole(ctx, circleID, memberID)
+	if err != nil {
+		return err
+	}
+
+	if memberRole == "admin" || circle.IsOwner(memberID) {
+		return errors.New("cannot change admin permissions")
+	}
+
 	return cs.circleRepo.UpdateMemberPermissions(ctx, circleID, memberID, permissions)
 }
 
@@ -706,46 +1014,28 @@ func (cs *CircleService) GetMemberActivity(ctx context.Context, userID, circleID
 // ========================
 
 func (cs *CircleService) GetJoinRequests(ctx context.Context, userID, circleID string) ([]models.JoinRequest, error) {
-	// Check if user is admin
-	role, err := cs.circleRepo.GetMemberRole(ctx, circleID, userID)
-	if err != nil {
+	if err := requireCirclePermission(ctx, cs.circleRepo, circleID, userID, models.CirclePermissionInviteMembers); err != nil {
 		return nil, err
 	}
 
-	if role != "admin" {
-		return nil, errors.New("access denied")
-	}
-
 	// TODO: Implement join requests retrieval from repository
 	return []models.JoinRequest{}, nil
 }
 
 func (cs *CircleService) ApproveJoinRequest(ctx context.Context, userID, circleID, requestID string) error {
-	// Check if user is admin
-	role, err := cs.circleRepo.GetMemberRole(ctx, circleID, userID)
-	if err != nil {
+	if err := requireCirclePermission(ctx, cs.circleRepo, circleID, userID, models.CirclePermissionInviteMembers); err != nil {
 		return err
 	}
 
-	if role != "admin" {
-		return errors.New("access denied")
-	}
-
 	// TODO: Implement join request approval logic
 	return nil
 }
 
 func (cs *CircleService) DeclineJoinRequest(ctx context.Context, userID, circleID, requestID string) error {
-	// Check if user is admin
-	role, err := cs.circleRepo.GetMemberRole(ctx, circleID, userID)
-	if err != nil {
+	if err := requireCirclePermission(ctx, cs.circleRepo, circleID, userID, models.CirclePermissionInviteMembers); err != nil {
 		return err
 	}
 
-	if role != "admin" {
-		return errors.New("access denied")
-	}
-
 	// TODO: Implement join request decline logic
 	return nil
 }
@@ -790,17 +1080,18 @@ func (cs *CircleService) GetCircleSettings(ctx context.Context, userID, circleID
 }
 
 func (cs *CircleService) UpdateCircleSettings(ctx context.Context, userID, circleID string, settings models.CircleSettings) (*models.CircleSettings, error) {
-	// Check if user is admin
-	role, err := cs.circleRepo.GetMemberRole(ctx, circleID, userID)
-	if err != nil {
+	if err := requireCirclePermission(ctx, cs.circleRepo, circleID, userID, models.CirclePermissionManageSettings); err != nil {
 		return nil, err
 	}
 
-	if role != "admin" {
-		return nil, errors.New("access denied")
+	if err := checkMessageEncryption(settings); err != nil {
+		return nil, err
+	}
+	if err := checkLocationStaleness(settings); err != nil {
+		return nil, err
 	}
 
-	err = cs.circleRepo.Update(ctx, circleID, bson.M{"settings": settings})
+	err := cs.circleRepo.Update(ctx, circleID, bson.M{"settings": settings})
 	if err != nil {
 		return nil, err
 	}
@@ -813,6 +1104,31 @@ func (cs *CircleService) UpdateCircleSettings(ctx context.Context, userID, circl
 	return &circle.Settings, nil
 }
 
+// checkMessageEncryption refuses to turn encryption on without a key to
+// encrypt with. Turning it on applies to new content; existing content is
+// encrypted by the message encryption run.
+func checkMessageEncryption(settings models.CircleSettings) error {
+	if settings.EncryptMessages && !utils.ContentEncryptionConfigured() {
+		return errors.New("message encryption not configured")
+	}
+	return nil
+}
+
+// checkLocationStaleness makes sure members show as offline no sooner than
+// stale, and within models.MaxLocationOfflineAfterMinutes
+func checkLocationStaleness(settings models.CircleSettings) error {
+	if settings.StaleAfterMinutes < 0 || settings.OfflineAfterMinutes < 0 {
+		return utils.NewValidationFailedError("staleness thresholds can't be negative")
+	}
+	if settings.OfflineAfterMinutes > models.MaxLocationOfflineAfterMinutes {
+		return utils.NewValidationFailedError(fmt.Sprintf("members can show as offline after at most %d minutes", models.MaxLocationOfflineAfterMinutes))
+	}
+	if settings.StaleAfterMinutes > 0 && settings.OfflineAfterMinutes > 0 && settings.OfflineAfterMinutes < settings.StaleAfterMinutes {
+		return utils.NewValidationFailedError("offlineAfterMinutes can't be less than staleAfterMinutes")
+	}
+	return nil
+}
+
 func (cs *CircleService) GetPrivacySettings(ctx context.Context, userID, circleID string) (map[string]interface{}, error) {
 	// Check if user is member
 	isMember, err := cs.circleRepo.IsMember(ctx, circleID, userID)
@@ -833,16 +1149,10 @@ func (cs *CircleService) GetPrivacySettings(ctx context.Context, userID, circleI
 }
 
 func (cs *CircleService) UpdatePrivacySettings(ctx context.Context, userID, circleID string, settings map[string]interface{}) (map[string]interface{}, error) {
-	// Check if user is admin
-	role, err := cs.circleRepo.GetMemberRole(ctx, circleID, userID)
-	if err != nil {
+	if err := requireCirclePermission(ctx, cs.circleRepo, circleID, userID, models.CirclePermissionManageSettings); err != nil {
 		return nil, err
 	}
 
-	if role != "admin" {
-		return nil, errors.New("access denied")
-	}
-
 	// TODO: Implement privacy settings update
 	return settings, nil
 }
@@ -867,16 +1177,10 @@ func (cs *CircleService) GetPermissionSettings(ctx context.Context, userID, circ
 }
 
 func (cs *CircleService) UpdatePermissionSettings(ctx context.Context, userID, circleID string, settings map[string]interface{}) (map[string]interface{}, error) {
-	// Check if user is admin
-	role, err := cs.circleRepo.GetMemberRole(ctx, circleID, userID)
-	if err != nil {
+	if err := requireCirclePermission(ctx, cs.circleRepo, circleID, userID, models.CirclePermissionManageSettings); err != nil {
 		return nil, err
 	}
 
-	if role != "admin" {
-		return nil, errors.New("access denied")
-	}
-
 	// TODO: Implement permission settings update
 	return settings, nil
 }
@@ -940,25 +1244,83 @@ func (cs *CircleService) GetCircleActivity(ctx context.Context, userID, circleID
 	}, nil
 }
 
+// GetActivityFeed lists the members' check-ins the user may see and the
+// moments they shared, newest first. Check-ins whose visibility changes drop
+// out of or into the feed.
 func (cs *CircleService) GetActivityFeed(ctx context.Context, userID, circleID string, page, pageSize int) (interface{}, error) {
-	// Check if user is member
-	isMember, err := cs.circleRepo.IsMember(ctx, circleID, userID)
+	circle, err := cs.circleRepo.GetByID(ctx, circleID)
 	if err != nil {
 		return nil, err
 	}
 
-	if !isMember {
+	if member := findCircleMember(circle, userID); member == nil || member.Status != "active" {
 		return nil, errors.New("access denied")
 	}
 
-	// TODO: Implement activity feed
+	authorIDs, err := cs.feedAuthorIDs(ctx, userID, circle)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each kind gives its newest entries up to the end of the page, and the
+	// page is cut from them merged
+	limit := page * pageSize
+	feed := []models.CircleActivity{}
+	var total int64
+	if cs.placeService != nil {
+		checkins, count, err := cs.placeService.GetCircleCheckinFeed(ctx, userID, circle, authorIDs, 1, limit)
+		if err != nil {
+			return nil, err
+		}
+		feed = append(feed, checkins...)
+		total += count
+	}
+
+	moments, count, err := cs.getMomentFeed(ctx, userID, circle, authorIDs, limit)
+	if err != nil {
+		return nil, err
+	}
+	feed = append(feed, moments...)
+	total += count
+
+	sort.SliceStable(feed, func(i, j int) bool {
+		return feed[i].CreatedAt.After(feed[j].CreatedAt)
+	})
+	start := (page - 1) * pageSize
+	if start > len(feed) {
+		start = len(feed)
+	}
+	end := start + pageSize
+	if end > len(feed) {
+		end = len(feed)
+	}
+	feed = feed[start:end]
+
 	return map[string]interface{}{
-		"feed":     []interface{}{},
-		"page":     page,
-		"pageSize": pageSize,
+		"feed":       feed,
+		"totalCount": total,
+		"page":       page,
+		"pageSize":   pageSize,
 	}, nil
 }
 
+// feedAuthorIDs returns the active members whose activity the viewer sees:
+// all but those on either side of a block with them, as their location is
+func (cs *CircleService) feedAuthorIDs(ctx context.Context, userID string, circle *models.Circle) ([]primitive.ObjectID, error) {
+	relatedIDs, err := cs.blockRepo.GetRelatedUserIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	authorIDs := []primitive.ObjectID{}
+	for _, member := range circle.Members {
+		if member.Status == "active" && !relatedIDs[member.UserID.Hex()] {
+			authorIDs = append(authorIDs, member.UserID)
+		}
+	}
+	return authorIDs, nil
+}
+
 func (cs *CircleService) GetMemberLocations(ctx context.Context, userID, circleID string) (interface{}, error) {
 	// Check if user is member
 	isMember, err := cs.circleRepo.IsMember(ctx, circleID, userID)
@@ -970,6 +1332,20 @@ func (cs *CircleService) GetMemberLocations(ctx context.Context, userID, circleI
 		return nil, errors.New("access denied")
 	}
 
+	// Members on someone's map should report live while it is open
+	if cs.trackingHints != nil {
+		circle, err := cs.circleRepo.GetByID(ctx, circleID)
+		if err == nil {
+			var memberIDs []string
+			for _, member := range circle.Members {
+				if member.Status == "active" {
+					memberIDs = append(memberIDs, member.UserID.Hex())
+				}
+			}
+			cs.trackingHints.RecordWatch(ctx, userID, memberIDs)
+		}
+	}
+
 	// TODO: Implement member locations retrieval
 	return map[string]interface{}{
 		"locations": []interface{}{},
@@ -1167,16 +1543,10 @@ func (cs *CircleService) GetCirclePlaces(ctx context.Context, userID, circleID s
 }
 
 func (cs *CircleService) CreateCirclePlace(ctx context.Context, userID, circleID string, req map[string]interface{}) (interface{}, error) {
-	// Check if user has permission
-	role, err := cs.circleRepo.GetMemberRole(ctx, circleID, userID)
-	if err != nil {
+	if err := requireCirclePermission(ctx, cs.circleRepo, circleID, userID, models.CirclePermissionManagePlaces); err != nil {
 		return nil, err
 	}
 
-	if role != "admin" {
-		return nil, errors.New("access denied")
-	}
-
 	// TODO: Implement place creation
 	return map[string]interface{}{
 		"id":        primitive.NewObjectID().Hex(),
@@ -1204,16 +1574,10 @@ func (cs *CircleService) GetCirclePlace(ctx context.Context, userID, circleID, p
 }
 
 func (cs *CircleService) UpdateCirclePlace(ctx context.Context, userID, circleID, placeID string, req map[string]interface{}) (interface{}, error) {
-	// Check if user has permission
-	role, err := cs.circleRepo.GetMemberRole(ctx, circleID, userID)
-	if err != nil {
+	if err := requireCirclePermission(ctx, cs.circleRepo, circleID, userID, models.CirclePermissionManagePlaces); err != nil {
 		return nil, err
 	}
 
-	if role != "admin" {
-		return nil, errors.New("access denied")
-	}
-
 	// TODO: Implement place update
 	return map[string]interface{}{
 		"id":        placeID,
@@ -1222,16 +1586,10 @@ func (cs *CircleService) UpdateCirclePlace(ctx context.Context, userID, circleID
 }
 
 func (cs *CircleService) DeleteCirclePlace(ctx context.Context, userID, circleID, placeID string) error {
-	// Check if user has permission
-	role, err := cs.circleRepo.GetMemberRole(ctx, circleID, userID)
-	if err != nil {
+	if err := requireCirclePermission(ctx, cs.circleRepo, circleID, userID, models.CirclePermissionManagePlaces); err != nil {
 		return err
 	}
 
-	if role != "admin" {
-		return errors.New("access denied")
-	}
-
 	// TODO: Implement place deletion
 	return nil
 }
@@ -1259,56 +1617,11 @@ func (cs *CircleService) GetPlaceActivity(ctx context.Context, userID, circleID,
 // Communication
 // ========================
 
-func (cs *CircleService) GetAnnouncements(ctx context.Context, userID, circleID string, page, pageSize int) (interface{}, error) {
-	// Check if user is member
-	isMember, err := cs.circleRepo.IsMember(ctx, circleID, userID)
-	if err != nil {
-		return nil, err
-	}
-
-	if !isMember {
-		return nil, errors.New("access denied")
-	}
-
-	// TODO: Implement announcements retrieval
-	return map[string]interface{}{
-		"announcements": []interface{}{},
-		"page":          page,
-		"pageSize":      pageSize,
-	}, nil
-}
-
-func (cs *CircleService) CreateAnnouncement(ctx context.Context, userID, circleID string, req map[string]interface{}) (interface{}, error) {
-	// Check if user has permission
-	role, err := cs.circleRepo.GetMemberRole(ctx, circleID, userID)
-	if err != nil {
-		return nil, err
-	}
-
-	if role != "admin" {
-		return nil, errors.New("access denied")
-	}
-
-	// TODO: Implement announcement creation
-	return map[string]interface{}{
-		"id":        primitive.NewObjectID().Hex(),
-		"title":     req["title"],
-		"message":   req["message"],
-		"createdAt": time.Now(),
-	}, nil
-}
-
 func (cs *CircleService) UpdateAnnouncement(ctx context.Context, userID, circleID, announcementID string, req map[string]interface{}) (interface{}, error) {
-	// Check if user has permission
-	role, err := cs.circleRepo.GetMemberRole(ctx, circleID, userID)
-	if err != nil {
+	if err := requireCirclePermission(ctx, cs.circleRepo, circleID, userID, models.CirclePermissionManageAnnouncements); err != nil {
 		return nil, err
 	}
 
-	if role != "admin" {
-		return nil, errors.New("access denied")
-	}
-
 	// TODO: Implement announcement update
 	return map[string]interface{}{
 		"id":        announcementID,
@@ -1317,31 +1630,19 @@ func (cs *CircleService) UpdateAnnouncement(ctx context.Context, userID, circleI
 }
 
 func (cs *CircleService) DeleteAnnouncement(ctx context.Context, userID, circleID, announcementID string) error {
-	// Check if user has permission
-	role, err := cs.circleRepo.GetMemberRole(ctx, circleID, userID)
-	if err != nil {
+	if err := requireCirclePermission(ctx, cs.circleRepo, circleID, userID, models.CirclePermissionManageAnnouncements); err != nil {
 		return err
 	}
 
-	if role != "admin" {
-		return errors.New("access denied")
-	}
-
 	// TODO: Implement announcement deletion
 	return nil
 }
 
 func (cs *CircleService) BroadcastMessage(ctx context.Context, userID, circleID, message, messageType string) error {
-	// Check if user has permission
-	role, err := cs.circleRepo.GetMemberRole(ctx, circleID, userID)
-	if err != nil {
+	if err := requireCirclePermission(ctx, cs.circleRepo, circleID, userID, models.CirclePermissionManageAnnouncements); err != nil {
 		return err
 	}
 
-	if role != "admin" {
-		return errors.New("access denied")
-	}
-
 	// TODO: Implement message broadcasting
 	return nil
 }
@@ -1352,13 +1653,19 @@ func (cs *CircleService) BroadcastMessage(ctx context.Context, userID, circleID,
 
 func (cs *CircleService) ExportCircleData(ctx context.Context, userID, circleID, format string, includes []string) (interface{}, error) {
 	// Check if user has permission
-	role, err := cs.circleRepo.GetMemberRole(ctx, circleID, userID)
-	if err != nil {
-		return nil, err
-	}
+	if cs.exportService != nil {
+		if err := cs.exportService.CheckCircleExportPermission(ctx, userID, circleID); err != nil {
+			return nil, err
+		}
+	} else {
+		role, err := cs.circleRepo.GetMemberRole(ctx, circleID, userID)
+		if err != nil {
+			return nil, err
+		}
 
-	if role != "admin" {
-		return nil, errors.New("access denied")
+		if role != "admin" {
+			return nil, errors.New("access denied")
+		}
 	}
 
 	// TODO: Implement data export
@@ -1419,25 +1726,22 @@ func (cs *CircleService) LeaveCircle(ctx context.Context, userID, circleID strin
 		return errors.New("access denied")
 	}
 
-	// Check if user is admin
-	role, err := cs.circleRepo.GetMemberRole(ctx, circleID, userID)
+	circle, err := cs.circleRepo.GetByID(ctx, circleID)
 	if err != nil {
-		return err
+		return errors.New("circle not found")
 	}
 
-	if role == "admin" {
-		// Check if there are other members
-		circle, err := cs.circleRepo.GetByID(ctx, circleID)
-		if err != nil {
-			return errors.New("circle not found")
-		}
+	// The owner has to hand the circle off while other members remain
+	if circle.IsOwner(userID) && len(circle.Members) > 1 {
+		return errors.New("cannot leave as owner")
+	}
 
-		if len(circle.Members) > 1 {
-			return errors.New("cannot leave as admin")
-		}
+	if err := cs.circleRepo.RemoveMember(ctx, circleID, userID); err != nil {
+		return err
 	}
+	cs.publishMembershipChange(ctx, circleID, userID, "member_left", time.Now(), nil)
 
-	return cs.circleRepo.RemoveMember(ctx, circleID, userID)
+	return nil
 }
 
 // ========================
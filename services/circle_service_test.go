@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"ftrack/models"
+	"ftrack/repositories"
+	"ftrack/testharness"
+)
+
+func newTestCircleService(env *testharness.Env) *CircleService {
+	return NewCircleService(env.Repos.Circle, env.Repos.User, repositories.NewAuditLogRepository(env.DB), env.Repos.Block, nil)
+}
+
+// asAdmin makes the circle's member at index i an admin with only the
+// given permissions
+func asAdmin(i int, permissions models.MemberPermissions) func(*models.Circle) {
+	return func(circle *models.Circle) {
+		circle.Members[i].Role = "admin"
+		circle.Members[i].Permissions = permissions
+	}
+}
+
+func TestCircleServiceTransferOwnership(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	cs := newTestCircleService(env)
+	ctx := context.Background()
+
+	alice, bob, carol, dave := env.Factory.User(), env.Factory.User(), env.Factory.User(), env.Factory.User()
+	circle := env.Factory.Circle(alice, []*models.User{bob, carol}, asAdmin(1, models.MemberPermissions{CanSeeLocation: true}))
+	circleID := circle.ID.Hex()
+	aliceID, bobID := alice.ID.Hex(), bob.ID.Hex()
+
+	errorCases := []struct {
+		name   string
+		userID string
+		req    models.TransferOwnershipRequest
+		want   string
+	}{
+		{"unconfirmed", aliceID, models.TransferOwnershipRequest{NewOwnerID: bobID}, "confirmation required"},
+		{"not the owner", bobID, models.TransferOwnershipRequest{NewOwnerID: aliceID, Confirm: true}, "access denied"},
+		{"to self", aliceID, models.TransferOwnershipRequest{NewOwnerID: aliceID, Confirm: true}, "already owner"},
+		{"to a plain member", aliceID, models.TransferOwnershipRequest{NewOwnerID: carol.ID.Hex(), Confirm: true}, "not admin"},
+		{"to a non-member", aliceID, models.TransferOwnershipRequest{NewOwnerID: dave.ID.Hex(), Confirm: true}, "member not found"},
+	}
+	for _, tc := range errorCases {
+		if _, err := cs.TransferOwnership(ctx, tc.userID, circleID, tc.req); err == nil || err.Error() != tc.want {
+			t.Errorf("%s: error = %v, want %s", tc.name, err, tc.want)
+		}
+	}
+
+	updated, err := cs.TransferOwnership(ctx, aliceID, circleID, models.TransferOwnershipRequest{NewOwnerID: bobID, Confirm: true})
+	if err != nil {
+		t.Fatalf("TransferOwnership: %v", err)
+	}
+	if !updated.IsOwner(bobID) || updated.IsOwner(aliceID) {
+		t.Errorf("owner after transfer = %s, want %s", updated.AdminID.Hex(), bobID)
+	}
+	for _, member := range updated.Members {
+		switch member.UserID {
+		case alice.ID:
+			if member.Role != "admin" {
+				t.Errorf("previous owner role = %s, want admin", member.Role)
+			}
+		case bob.ID:
+			if member.Role != "admin" {
+				t.Errorf("new owner role = %s, want admin", member.Role)
+			}
+			if p := member.Permissions; !p.CanSeeDriving || !p.CanSendMessages || !p.CanManagePlaces || !p.CanReceiveAlerts || !p.CanSendEmergency {
+				t.Errorf("new owner permissions = %+v, want all granted", p)
+			}
+		}
+	}
+
+	// Owner-only actions move with the role
+	if err := cs.DeleteCircle(ctx, aliceID, circleID); err == nil || err.Error() != "access denied" {
+		t.Errorf("DeleteCircle by the previous owner error = %v, want access denied", err)
+	}
+	if _, err := cs.TransferOwnership(ctx, aliceID, circleID, models.TransferOwnershipRequest{NewOwnerID: bobID, Confirm: true}); err == nil || err.Error() != "access denied" {
+		t.Errorf("transfer by the previous owner error = %v, want access denied", err)
+	}
+	if _, err := cs.TransferOwnership(ctx, bobID, circleID, models.TransferOwnershipRequest{NewOwnerID: aliceID, Confirm: true}); err != nil {
+		t.Errorf("transfer back by the new owner: %v", err)
+	}
+}
+
+func TestCircleServiceConcurrentTransfers(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	cs := newTestCircleService(env)
+	ctx := context.Background()
+
+	alice, bob, carol := env.Factory.User(), env.Factory.User(), env.Factory.User()
+	limited := models.MemberPermissions{CanSeeLocation: true, CanSendMessages: true}
+	circle := env.Factory.Circle(alice, []*models.User{bob, carol}, asAdmin(1, limited), asAdmin(2, limited))
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		succeeded []string
+	)
+	for _, next := range []*models.User{bob, carol} {
+		wg.Add(1)
+		go func(newOwnerID string) {
+			defer wg.Done()
+			_, err := cs.TransferOwnership(ctx, alice.ID.Hex(), circle.ID.Hex(), models.TransferOwnershipRequest{NewOwnerID: newOwnerID, Confirm: true})
+			if err == nil {
+				mu.Lock()
+				succeeded = append(succeeded, newOwnerID)
+				mu.Unlock()
+			}
+		}(next.ID.Hex())
+	}
+	wg.Wait()
+
+	if len(succeeded) != 1 {
+		t.Fatalf("%d concurrent transfers succeeded, want 1", len(succeeded))
+	}
+	stored, err := env.Repos.Circle.GetByID(ctx, circle.ID.Hex())
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	if !stored.IsOwner(succeeded[0]) {
+		t.Errorf("owner = %s, want %s", stored.AdminID.Hex(), succeeded[0])
+	}
+	for _, member := range stored.Members {
+		if member.Role != "admin" {
+			t.Errorf("member %s role = %s, want admin", member.UserID.Hex(), member.Role)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package services
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"ftrack/models"
+	"ftrack/repositories"
+	"ftrack/utils"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DeepLinkService resolves the links notifications and shares carry into
+// the entity they point at, checking the user may open it. Links take the
+// form ftrack://circles/{id}, ftrack://places/{id}, ftrack://messages/{id}
+// or ftrack://circles/{id}/messages/{id}, or the same paths on the API's
+// host.
+type DeepLinkService struct {
+	circleRepo   *repositories.CircleRepository
+	messageRepo  *repositories.MessageRepository
+	placeService *PlaceService
+	host         string
+	validator    *utils.ValidationService
+}
+
+func NewDeepLinkService(
+	circleRepo *repositories.CircleRepository,
+	messageRepo *repositories.MessageRepository,
+	placeService *PlaceService,
+	baseURL string,
+) *DeepLinkService {
+	host := ""
+	if base, err := url.Parse(baseURL); err == nil {
+		host = base.Host
+	}
+
+	return &DeepLinkService{
+		circleRepo:   circleRepo,
+		messageRepo:  messageRepo,
+		placeService: placeService,
+		host:         host,
+		validator:    utils.NewValidationService(),
+	}
+}
+
+// ResolveLink returns what the link points at and whether the user can open
+// it. Links that aren't ours or don't point at anything are a validation
+// error; missing and forbidden targets are reported in the result.
+func (dls *DeepLinkService) ResolveLink(ctx context.Context, userID string, req models.ResolveDeepLinkRequest) (*models.DeepLinkTarget, error) {
+	if validationErrors := dls.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	segments, err := dls.linkSegments(strings.TrimSpace(req.URL))
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case len(segments) == 2 && segments[0] == "circles":
+		return dls.resolveCircle(ctx, userID, segments[1])
+	case len(segments) == 2 && segments[0] == "places":
+		return dls.resolvePlace(ctx, userID, segments[1])
+	case len(segments) == 2 && segments[0] == "messages":
+		return dls.resolveMessage(ctx, userID, "", segments[1])
+	case len(segments) == 4 && segments[0] == "circles" && segments[2] == "messages":
+		return dls.resolveMessage(ctx, userID, segments[1], segments[3])
+	}
+	return nil, utils.NewValidationFailedError("the link doesn't point at a circle, place or message")
+}
+
+// linkSegments returns the path of an app link, or of a web link on our
+// host, split into its parts
+func (dls *DeepLinkService) linkSegments(raw string) ([]string, error) {
+	link, err := url.Parse(raw)
+	if err != nil {
+		return nil, utils.NewValidationFailedError("the link isn't a valid URL")
+	}
+
+	path := link.Path
+	switch strings.ToLower(link.Scheme) {
+	case models.DeepLinkScheme:
+		// The first part of ftrack://circles/{id} parses as the host
+		path = link.Host + "/" + link.Path
+	case "http", "https":
+		if dls.host == "" || !strings.EqualFold(link.Host, dls.host) {
+			return nil, utils.NewValidationFailedError("the link isn't to this app")
+		}
+		path = strings.TrimPrefix(path, "/api/v1")
+	case "":
+	default:
+		return nil, utils.NewValidationFailedError("the link isn't to this app")
+	}
+
+	var segments []string
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	if len(segments) < 2 || len(segments)%2 != 0 {
+		return nil, utils.NewValidationFailedError("the link doesn't point at a circle, place or message")
+	}
+	for i := 1; i < len(segments); i += 2 {
+		if _, err := primitive.ObjectIDFromHex(segments[i]); err != nil {
+			return nil, utils.NewValidationFailedError("the link has an invalid ID")
+		}
+	}
+	return segments, nil
+}
+
+func (dls *DeepLinkService) resolveCircle(ctx context.Context, userID, circleID string) (*models.DeepLinkTarget, error) {
+	target := &models.DeepLinkTarget{
+		Type:     models.DeepLinkCircle,
+		CircleID: circleID,
+		Path:     "/circles/" + circleID,
+	}
+
+	if _, err := dls.circleRepo.GetByID(ctx, circleID); err != nil {
+		if err.Error() == "circle not found" {
+			return deepLinkUnavailable(target, models.DeepLinkNotFound), nil
+		}
+		return nil, err
+	}
+
+	isMember, err := dls.circleRepo.IsMember(ctx, circleID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !isMember {
+		return deepLinkUnavailable(target, models.DeepLinkAccessDenied), nil
+	}
+
+	target.Accessible = true
+	return target, nil
+}
+
+func (dls *DeepLinkService) resolvePlace(ctx context.Context, userID, placeID string) (*models.DeepLinkTarget, error) {
+	target := &models.DeepLinkTarget{
+		Type:    models.DeepLinkPlace,
+		PlaceID: placeID,
+		Path:    "/places/" + placeID,
+	}
+
+	place, err := dls.placeService.GetPlace(ctx, userID, placeID)
+	if err != nil {
+		switch err.Error() {
+		case "place not found":
+			return deepLinkUnavailable(target, models.DeepLinkNotFound), nil
+		case "access denied":
+			return deepLinkUnavailable(target, models.DeepLinkAccessDenied), nil
+		}
+		return nil, err
+	}
+
+	if !place.CircleID.IsZero() {
+		target.CircleID = place.CircleID.Hex()
+	}
+	target.Accessible = true
+	return target, nil
+}
+
+// resolveMessage resolves a message link. A link naming a circle the
+// message isn't in doesn't point at anything.
+func (dls *DeepLinkService) resolveMessage(ctx context.Context, userID, circleID, messageID string) (*models.DeepLinkTarget, error) {
+	target := &models.DeepLinkTarget{
+		Type:      models.DeepLinkMessage,
+		MessageID: messageID,
+		Path:      "/messages/" + messageID,
+	}
+
+	message, err := dls.messageRepo.GetByID(ctx, messageID)
+	if err != nil {
+		if err.Error() == "message not found" {
+			return deepLinkUnavailable(target, models.DeepLinkNotFound), nil
+		}
+		return nil, err
+	}
+	if circleID != "" && message.CircleID.Hex() != circleID {
+		return deepLinkUnavailable(target, models.DeepLinkNotFound), nil
+	}
+
+	isMember, err := dls.circleRepo.IsMember(ctx, message.CircleID.Hex(), userID)
+	if err != nil {
+		return nil, err
+	}
+	if !isMember {
+		return deepLinkUnavailable(target, models.DeepLinkAccessDenied), nil
+	}
+
+	target.CircleID = message.CircleID.Hex()
+	target.Path = "/circles/" + target.CircleID + "/messages/" + messageID
+	target.Accessible = true
+	return target, nil
+}
+
+func deepLinkUnavailable(target *models.DeepLinkTarget, reason string) *models.DeepLinkTarget {
+	target.Accessible = false
+	target.Reason = reason
+	return target
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"ftrack/models"
+	"ftrack/testharness"
+	"ftrack/utils"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestDeepLinkSegments(t *testing.T) {
+	dls := NewDeepLinkService(nil, nil, nil, "https://api.ftrack.app/api/v1")
+	id, other := primitive.NewObjectID().Hex(), primitive.NewObjectID().Hex()
+
+	tests := []struct {
+		link string
+		want string
+		err  string
+	}{
+		{"ftrack://circles/" + id, "circles/" + id, ""},
+		{"FTRACK://places/" + id + "/", "places/" + id, ""},
+		{"ftrack://circles/" + id + "/messages/" + other, "circles/" + id + "/messages/" + other, ""},
+		{"https://api.ftrack.app/api/v1/messages/" + id, "messages/" + id, ""},
+		{"https://API.ftrack.app/places/" + id + "?ref=push", "places/" + id, ""},
+		{"/circles/" + id, "circles/" + id, ""},
+		{"https://evil.example.com/circles/" + id, "", "the link isn't to this app"},
+		{"mailto:someone@example.com", "", "the link isn't to this app"},
+		{"ftrack://circles", "", "the link doesn't point at a circle, place or message"},
+		{"ftrack://circles/" + id + "/messages", "", "the link doesn't point at a circle, place or message"},
+		{"ftrack://circles/not-an-id", "", "the link has an invalid ID"},
+		{"%zz", "", "the link isn't a valid URL"},
+	}
+	for _, tt := range tests {
+		segments, err := dls.linkSegments(tt.link)
+		if reason := utils.ValidationFailureReason(err); reason != tt.err {
+			t.Errorf("%s: error %v, want %q", tt.link, err, tt.err)
+			continue
+		}
+		if got := strings.Join(segments, "/"); got != tt.want {
+			t.Errorf("%s: path %s, want %s", tt.link, got, tt.want)
+		}
+	}
+
+	// Without a base URL only app links and paths resolve
+	if _, err := NewDeepLinkService(nil, nil, nil, "").linkSegments("https://api.ftrack.app/circles/" + id); utils.ValidationFailureReason(err) != "the link isn't to this app" {
+		t.Errorf("web link without a base URL error = %v", err)
+	}
+}
+
+func TestResolveDeepLink(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	dls := NewDeepLinkService(env.Repos.Circle, env.Repos.Message, newTestPlaceService(env), "https://api.ftrack.app")
+	ctx := context.Background()
+
+	member, outsider := env.Factory.User(), env.Factory.User()
+	circle := env.Factory.Circle(member, nil)
+	otherCircle := env.Factory.Circle(outsider, nil)
+	message := env.Factory.Message(circle, member, "see you there")
+	shared := env.Factory.Place(member, testharness.InCircle(circle), func(place *models.Place) { place.IsShared = true })
+	private := env.Factory.Place(outsider)
+	missing := primitive.NewObjectID().Hex()
+
+	circleID, messageID := circle.ID.Hex(), message.ID.Hex()
+	tests := []struct {
+		name string
+		link string
+		want models.DeepLinkTarget
+	}{
+		{"own circle", "ftrack://circles/" + circleID,
+			models.DeepLinkTarget{Type: "circle", CircleID: circleID, Path: "/circles/" + circleID, Accessible: true}},
+		{"another circle", "ftrack://circles/" + otherCircle.ID.Hex(),
+			models.DeepLinkTarget{Type: "circle", CircleID: otherCircle.ID.Hex(), Path: "/circles/" + otherCircle.ID.Hex(), Reason: "access_denied"}},
+		{"missing circle", "ftrack://circles/" + missing,
+			models.DeepLinkTarget{Type: "circle", CircleID: missing, Path: "/circles/" + missing, Reason: "not_found"}},
+		{"place shared in the circle", "https://api.ftrack.app/places/" + shared.ID.Hex(),
+			models.DeepLinkTarget{Type: "place", CircleID: circleID, PlaceID: shared.ID.Hex(), Path: "/places/" + shared.ID.Hex(), Accessible: true}},
+		{"someone's private place", "ftrack://places/" + private.ID.Hex(),
+			models.DeepLinkTarget{Type: "place", PlaceID: private.ID.Hex(), Path: "/places/" + private.ID.Hex(), Reason: "access_denied"}},
+		{"missing place", "/places/" + missing,
+			models.DeepLinkTarget{Type: "place", PlaceID: missing, Path: "/places/" + missing, Reason: "not_found"}},
+		{"message", "ftrack://messages/" + messageID,
+			models.DeepLinkTarget{Type: "message", CircleID: circleID, MessageID: messageID, Path: "/circles/" + circleID + "/messages/" + messageID, Accessible: true}},
+		{"message in its circle", "ftrack://circles/" + circleID + "/messages/" + messageID,
+			models.DeepLinkTarget{Type: "message", CircleID: circleID, MessageID: messageID, Path: "/circles/" + circleID + "/messages/" + messageID, Accessible: true}},
+		{"message under another circle", "ftrack://circles/" + otherCircle.ID.Hex() + "/messages/" + messageID,
+			models.DeepLinkTarget{Type: "message", MessageID: messageID, Path: "/messages/" + messageID, Reason: "not_found"}},
+		{"missing message", "ftrack://messages/" + missing,
+			models.DeepLinkTarget{Type: "message", MessageID: missing, Path: "/messages/" + missing, Reason: "not_found"}},
+	}
+	for _, tt := range tests {
+		target, err := dls.ResolveLink(ctx, member.ID.Hex(), models.ResolveDeepLinkRequest{URL: tt.link})
+		if err != nil {
+			t.Errorf("%s: ResolveLink: %v", tt.name, err)
+			continue
+		}
+		if *target != tt.want {
+			t.Errorf("%s: target %+v, want %+v", tt.name, *target, tt.want)
+		}
+	}
+
+	// A forbidden message doesn't give away its circle
+	target, err := dls.ResolveLink(ctx, outsider.ID.Hex(), models.ResolveDeepLinkRequest{URL: "ftrack://messages/" + messageID})
+	if err != nil {
+		t.Fatalf("ResolveLink: %v", err)
+	}
+	want := models.DeepLinkTarget{Type: "message", MessageID: messageID, Path: "/messages/" + messageID, Reason: "access_denied"}
+	if *target != want {
+		t.Errorf("outsider's message target %+v, want %+v", *target, want)
+	}
+
+	_, err = dls.ResolveLink(ctx, member.ID.Hex(), models.ResolveDeepLinkRequest{})
+	if fields := utils.ValidationFailureFields(err); len(fields) != 1 || fields[0].Field != "url" {
+		t.Errorf("empty link error = %v with fields %+v, want url named", err, fields)
+	}
+	if _, err := dls.ResolveLink(ctx, member.ID.Hex(), models.ResolveDeepLinkRequest{URL: "ftrack://users/" + missing}); utils.ValidationFailureReason(err) != "the link doesn't point at a circle, place or message" {
+		t.Errorf("link to a user error = %v", err)
+	}
+}
@@ -0,0 +1,460 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"ftrack/models"
+	"ftrack/repositories"
+	"ftrack/utils"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// Visits older than this don't count towards departure patterns
+	departureHistoryWindow = 8 * 7 * 24 * time.Hour
+
+	// A departure followed by an arrival at the destination within this
+	// time counts as a trip there
+	departureMaxTravel = 3 * time.Hour
+
+	// Weekdays with fewer trips than this get no reminder
+	departureMinTrips = 3
+
+	// Reminders that fell due longer ago than this, e.g. while the worker
+	// was down, are skipped rather than sent late
+	departureReminderGrace = 5 * time.Minute
+
+	// DeparturePatternMaxAge is how long a computed pattern is used before
+	// it is recomputed from newer visits
+	DeparturePatternMaxAge = 24 * time.Hour
+)
+
+// DepartureReminderService schedules "time to leave" reminders from the
+// user's own visit history. Patterns are never shared with circle members.
+type DepartureReminderService struct {
+	reminderRepo        *repositories.DepartureReminderRepository
+	placeRepo           *repositories.PlaceRepository
+	notificationRepo    *repositories.NotificationRepository
+	placeService        *PlaceService
+	notificationService *NotificationService
+	validator           *utils.ValidationService
+}
+
+func NewDepartureReminderService(
+	reminderRepo *repositories.DepartureReminderRepository,
+	placeRepo *repositories.PlaceRepository,
+	notificationRepo *repositories.NotificationRepository,
+	placeService *PlaceService,
+	notificationService *NotificationService,
+) *DepartureReminderService {
+	return &DepartureReminderService{
+		reminderRepo:        reminderRepo,
+		placeRepo:           placeRepo,
+		notificationRepo:    notificationRepo,
+		placeService:        placeService,
+		notificationService: notificationService,
+		validator:           utils.NewValidationService(),
+	}
+}
+
+// CreateReminder opts the user in to reminders for a destination and
+// computes its pattern right away
+func (ds *DepartureReminderService) CreateReminder(ctx context.Context, userID string, req models.CreateDepartureReminderRequest) (*models.DepartureReminder, error) {
+	if validationErrors := ds.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	timezone := req.Timezone
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(timezone); err != nil {
+		return nil, errors.New("invalid timezone")
+	}
+
+	place, err := ds.placeService.GetPlace(ctx, userID, req.PlaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	leadMinutes := req.LeadMinutes
+	if leadMinutes == 0 {
+		leadMinutes = models.DefaultDepartureLeadMinutes
+	}
+
+	reminder := &models.DepartureReminder{
+		UserID:      userObjectID,
+		PlaceID:     place.ID,
+		PlaceName:   place.Name,
+		Enabled:     true,
+		LeadMinutes: leadMinutes,
+		Timezone:    timezone,
+	}
+
+	now := time.Now()
+	reminder.Pattern, err = ds.computePattern(ctx, reminder, now)
+	if err != nil {
+		return nil, err
+	}
+	reminder.NextReminderAt = nextDepartureReminder(reminder, now)
+
+	if err := ds.reminderRepo.Create(ctx, reminder); err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("Departure reminder created for place %s by user %s", place.ID.Hex(), userID)
+	return reminder, nil
+}
+
+func (ds *DepartureReminderService) GetReminders(ctx context.Context, userID string) ([]models.DepartureReminder, error) {
+	return ds.reminderRepo.GetUserReminders(ctx, userID)
+}
+
+func (ds *DepartureReminderService) GetReminder(ctx context.Context, userID, reminderID string) (*models.DepartureReminder, error) {
+	return ds.reminderRepo.GetByID(ctx, userID, reminderID)
+}
+
+// UpdateReminder changes the lead time, timezone or opt-in and reschedules
+// the next reminder
+func (ds *DepartureReminderService) UpdateReminder(ctx context.Context, userID, reminderID string, req models.UpdateDepartureReminderRequest) (*models.DepartureReminder, error) {
+	if validationErrors := ds.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	reminder, err := ds.reminderRepo.GetByID(ctx, userID, reminderID)
+	if err != nil {
+		return nil, err
+	}
+
+	update := bson.M{}
+	if req.Enabled != nil {
+		reminder.Enabled = *req.Enabled
+		update["enabled"] = reminder.Enabled
+	}
+	if req.LeadMinutes != nil {
+		reminder.LeadMinutes = *req.LeadMinutes
+		update["leadMinutes"] = reminder.LeadMinutes
+	}
+	if req.Timezone != nil {
+		if _, err := time.LoadLocation(*req.Timezone); err != nil || *req.Timezone == "" {
+			return nil, errors.New("invalid timezone")
+		}
+		reminder.Timezone = *req.Timezone
+		update["timezone"] = reminder.Timezone
+
+		// Departure times are stored as local times
+		reminder.Pattern, err = ds.computePattern(ctx, reminder, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		update["pattern"] = reminder.Pattern
+	}
+
+	reminder.NextReminderAt = nil
+	if reminder.Enabled {
+		reminder.NextReminderAt = nextDepartureReminder(reminder, time.Now())
+	}
+	update["nextReminderAt"] = reminder.NextReminderAt
+
+	if err := ds.reminderRepo.Update(ctx, userID, reminderID, update); err != nil {
+		return nil, err
+	}
+
+	return reminder, nil
+}
+
+func (ds *DepartureReminderService) DeleteReminder(ctx context.Context, userID, reminderID string) error {
+	return ds.reminderRepo.Delete(ctx, userID, reminderID)
+}
+
+// RecomputeReminder rebuilds the reminder's pattern from the latest visits
+func (ds *DepartureReminderService) RecomputeReminder(ctx context.Context, userID, reminderID string) (*models.DepartureReminder, error) {
+	reminder, err := ds.reminderRepo.GetByID(ctx, userID, reminderID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ds.refreshReminder(ctx, reminder, time.Now()); err != nil {
+		return nil, err
+	}
+
+	return reminder, nil
+}
+
+// RecomputeStalePatterns refreshes patterns older than
+// DeparturePatternMaxAge and returns how many were refreshed
+func (ds *DepartureReminderService) RecomputeStalePatterns(ctx context.Context, limit int) (int, error) {
+	now := time.Now()
+
+	reminders, err := ds.reminderRepo.GetStalePatterns(ctx, now.Add(-DeparturePatternMaxAge), limit)
+	if err != nil {
+		return 0, err
+	}
+
+	refreshed := 0
+	for i := range reminders {
+		if err := ds.refreshReminder(ctx, &reminders[i], now); err != nil {
+			logrus.Warnf("Failed to recompute departure reminder %s: %v", reminders[i].ID.Hex(), err)
+			continue
+		}
+		refreshed++
+	}
+
+	return refreshed, nil
+}
+
+// ProcessDueReminders sends the reminders that fell due and schedules
+// their next occurrence. Reminders inside the user's quiet hours are
+// skipped, not delayed.
+func (ds *DepartureReminderService) ProcessDueReminders(ctx context.Context, limit int) (int, error) {
+	now := time.Now()
+
+	reminders, err := ds.reminderRepo.GetDue(ctx, now, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for i := range reminders {
+		reminder := &reminders[i]
+		userID := reminder.UserID.Hex()
+		update := bson.M{}
+
+		switch {
+		case now.Sub(*reminder.NextReminderAt) > departureReminderGrace:
+			logrus.Debugf("Skipping late departure reminder %s", reminder.ID.Hex())
+		case ds.inQuietHours(ctx, userID, now):
+			logrus.Debugf("Skipping departure reminder %s during quiet hours", reminder.ID.Hex())
+		default:
+			if err := ds.sendReminder(ctx, reminder); err != nil {
+				logrus.Errorf("Failed to send departure reminder %s: %v", reminder.ID.Hex(), err)
+			} else {
+				sent++
+				update["lastSentAt"] = now
+			}
+		}
+
+		update["nextReminderAt"] = nextDepartureReminder(reminder, now)
+		if err := ds.reminderRepo.Update(ctx, userID, reminder.ID.Hex(), update); err != nil {
+			logrus.Errorf("Failed to reschedule departure reminder %s: %v", reminder.ID.Hex(), err)
+		}
+	}
+
+	return sent, nil
+}
+
+// refreshReminder recomputes the pattern and next reminder and saves them.
+// Reminders for deleted places are removed and reminders for places the
+// user lost access to are disabled.
+func (ds *DepartureReminderService) refreshReminder(ctx context.Context, reminder *models.DepartureReminder, now time.Time) error {
+	userID := reminder.UserID.Hex()
+
+	place, err := ds.placeService.GetPlace(ctx, userID, reminder.PlaceID.Hex())
+	if err != nil {
+		switch err.Error() {
+		case "place not found":
+			return ds.reminderRepo.Delete(ctx, userID, reminder.ID.Hex())
+		case "access denied":
+			reminder.Enabled = false
+			reminder.NextReminderAt = nil
+			return ds.reminderRepo.Update(ctx, userID, reminder.ID.Hex(), bson.M{
+				"enabled":        false,
+				"nextReminderAt": nil,
+			})
+		}
+		return err
+	}
+	reminder.PlaceName = place.Name
+
+	reminder.Pattern, err = ds.computePattern(ctx, reminder, now)
+	if err != nil {
+		return err
+	}
+
+	reminder.NextReminderAt = nil
+	if reminder.Enabled {
+		reminder.NextReminderAt = nextDepartureReminder(reminder, now)
+	}
+
+	return ds.reminderRepo.Update(ctx, userID, reminder.ID.Hex(), bson.M{
+		"placeName":      reminder.PlaceName,
+		"pattern":        reminder.Pattern,
+		"nextReminderAt": reminder.NextReminderAt,
+	})
+}
+
+type departureTrip struct {
+	departure time.Time
+	travel    time.Duration
+}
+
+// computePattern finds the user's trips to the destination, a departure
+// from another place followed by an arrival at the destination, and takes
+// the median departure time for each weekday
+func (ds *DepartureReminderService) computePattern(ctx context.Context, reminder *models.DepartureReminder, now time.Time) (models.DeparturePattern, error) {
+	location, err := time.LoadLocation(reminder.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+
+	visits, err := ds.placeRepo.GetUserVisitsSince(ctx, reminder.UserID.Hex(), now.Add(-departureHistoryWindow))
+	if err != nil {
+		return models.DeparturePattern{}, err
+	}
+
+	tripsByWeekday := make(map[time.Weekday][]departureTrip)
+	origins := make(map[primitive.ObjectID]int)
+	tripCount := 0
+
+	for i := 1; i < len(visits); i++ {
+		previous, visit := visits[i-1], visits[i]
+		if visit.PlaceID != reminder.PlaceID || previous.PlaceID == reminder.PlaceID || previous.DepartureTime == nil {
+			continue
+		}
+
+		travel := visit.ArrivalTime.Sub(*previous.DepartureTime)
+		if travel < 0 || travel > departureMaxTravel {
+			continue
+		}
+
+		departure := previous.DepartureTime.In(location)
+		tripsByWeekday[departure.Weekday()] = append(tripsByWeekday[departure.Weekday()], departureTrip{
+			departure: departure,
+			travel:    travel,
+		})
+		origins[previous.PlaceID]++
+		tripCount++
+	}
+
+	computedAt := now
+	pattern := models.DeparturePattern{
+		Days:       []models.DepartureDay{},
+		TripCount:  tripCount,
+		ComputedAt: &computedAt,
+	}
+
+	for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+		trips := tripsByWeekday[weekday]
+		if len(trips) < departureMinTrips {
+			continue
+		}
+
+		departureMinutes := make([]int, len(trips))
+		travelMinutes := make([]int, len(trips))
+		for i, trip := range trips {
+			departureMinutes[i] = trip.departure.Hour()*60 + trip.departure.Minute()
+			travelMinutes[i] = int(trip.travel.Minutes())
+		}
+
+		departureMinute := medianInt(departureMinutes)
+		pattern.Days = append(pattern.Days, models.DepartureDay{
+			Weekday:       int(weekday),
+			DepartureTime: fmt.Sprintf("%02d:%02d", departureMinute/60, departureMinute%60),
+			TravelMinutes: medianInt(travelMinutes),
+			Trips:         len(trips),
+		})
+	}
+
+	mostTrips := 0
+	for originID, trips := range origins {
+		if trips > mostTrips {
+			pattern.OriginPlaceID = originID
+			mostTrips = trips
+		}
+	}
+
+	return pattern, nil
+}
+
+func (ds *DepartureReminderService) sendReminder(ctx context.Context, reminder *models.DepartureReminder) error {
+	location, err := time.LoadLocation(reminder.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+
+	departure := reminder.NextReminderAt.Add(time.Duration(reminder.LeadMinutes) * time.Minute).In(location)
+
+	return ds.notificationService.SendNotification(ctx, models.SendNotificationRequest{
+		Recipients: []string{reminder.UserID.Hex()},
+		Title:      fmt.Sprintf("Time to leave for %s", reminder.PlaceName),
+		Message:    fmt.Sprintf("You usually leave for %s around %s", reminder.PlaceName, departure.Format("15:04")),
+		Type:       models.NotificationTypeDeparture,
+		Priority:   "normal",
+		Category:   "place",
+		Data: map[string]interface{}{
+			"reminderId":    reminder.ID.Hex(),
+			"placeId":       reminder.PlaceID.Hex(),
+			"departureTime": departure.Format("15:04"),
+		},
+		DeliveryChannels: []string{"push", "in-app"},
+	})
+}
+
+func (ds *DepartureReminderService) inQuietHours(ctx context.Context, userID string, now time.Time) bool {
+	settings, err := ds.notificationRepo.GetPushSettings(ctx, userID)
+	if err != nil || settings == nil {
+		return false
+	}
+	return settings.QuietHours.ActiveAt(now)
+}
+
+// nextDepartureReminder returns the first reminder time after the given
+// time, or nil when the pattern has no usable weekday
+func nextDepartureReminder(reminder *models.DepartureReminder, after time.Time) *time.Time {
+	location, err := time.LoadLocation(reminder.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+
+	lead := time.Duration(reminder.LeadMinutes) * time.Minute
+	local := after.In(location)
+
+	var next *time.Time
+	// One extra day covers a reminder that already passed today
+	for offset := 0; offset <= 7; offset++ {
+		day := local.AddDate(0, 0, offset)
+		for _, pattern := range reminder.Pattern.Days {
+			if pattern.Weekday != int(day.Weekday()) {
+				continue
+			}
+
+			clock, err := time.Parse("15:04", pattern.DepartureTime)
+			if err != nil {
+				continue
+			}
+
+			at := time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), 0, 0, location).Add(-lead).UTC()
+			if at.After(after) && (next == nil || at.Before(*next)) {
+				next = &at
+			}
+		}
+		if next != nil {
+			break
+		}
+	}
+
+	return next
+}
+
+func medianInt(values []int) int {
+	sorted := append([]int(nil), values...)
+	sort.Ints(sorted)
+
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
@@ -0,0 +1,550 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"ftrack/models"
+	"ftrack/utils"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Points a trip's safety score loses, from 100, for each event. An event's
+// points are capped so one long phone call or stretch of speeding can't
+// zero a trip on its own.
+const (
+	safetyPhoneUsePoints    = 8.0 // per time the screen came on
+	safetyPhoneUsePerMinute = 2.0 // and per minute it stayed on
+	safetyPhoneUseMaxPoints = 20.0
+
+	safetyRapidAccelPoints = 4.0
+
+	safetySpeedingPoints    = 5.0 // per speeding event
+	safetySpeedingPerKmh    = 0.5 // and per km/h over the limit
+	safetySpeedingMaxPoints = 15.0
+)
+
+// ConfigureDrivingSafetyReports sends weekly safety reports to the members
+// drivers pick
+func (ls *LocationService) ConfigureDrivingSafetyReports(notificationService NotificationDispatcher) {
+	ls.notificationService = notificationService
+}
+
+// validateSafetyEvents checks the safety events posted with a location
+// update
+func (ls *LocationService) validateSafetyEvents(events []models.TripSafetyEventReport) error {
+	if len(events) > models.MaxSafetyEventsPerUpdate {
+		return utils.NewFieldValidationFailedError([]utils.ValidationError{{
+			Field:   "safetyEvents",
+			Tag:     "max",
+			Message: fmt.Sprintf("At most %d safety events can be sent with an update", models.MaxSafetyEventsPerUpdate),
+		}})
+	}
+
+	for _, event := range events {
+		if validationErrors := ls.validator.ValidateStruct(event); len(validationErrors) > 0 {
+			return utils.NewFieldValidationFailedError(validationErrors)
+		}
+	}
+	return nil
+}
+
+// recordSafetyEvents stores the safety events posted with a location update
+// against the user's trip in progress. The client only reports them during
+// trips, so without one they're dropped.
+func (ls *LocationService) recordSafetyEvents(ctx context.Context, userID string, location models.Location) {
+	trip, err := ls.locationRepo.GetActiveTrip(ctx, userID)
+	if err != nil {
+		if err.Error() != "trip not found" {
+			logrus.Warnf("Failed to get active trip of user %s: %v", userID, err)
+		}
+		return
+	}
+
+	reports := location.SafetyEvents
+	location.SafetyEvents = nil
+
+	events := make([]models.DrivingEvent, 0, len(reports))
+	for _, report := range reports {
+		// Speeding is against the limit the client knows for the road
+		if report.Type == models.SafetyEventSpeeding && (report.SpeedLimit <= 0 || report.Speed <= report.SpeedLimit) {
+			continue
+		}
+
+		occurredAt := report.OccurredAt
+		if occurredAt.IsZero() {
+			occurredAt = location.RecordedAt()
+		}
+
+		events = append(events, models.DrivingEvent{
+			UserID:    userID,
+			TripID:    trip.ID.Hex(),
+			EventType: report.Type,
+			Severity:  safetyEventSeverity(report),
+			Location:  location,
+			Details: map[string]interface{}{
+				"duration":     report.Duration,
+				"acceleration": report.Acceleration,
+				"speed":        report.Speed,
+				"speedLimit":   report.SpeedLimit,
+			},
+			Timestamp: occurredAt,
+		})
+	}
+
+	if err := ls.locationRepo.CreateDrivingEvents(ctx, events); err != nil {
+		logrus.Warnf("Failed to record safety events of trip %s: %v", trip.ID.Hex(), err)
+	}
+}
+
+func safetyEventSeverity(report models.TripSafetyEventReport) string {
+	switch report.Type {
+	case models.SafetyEventPhoneUse:
+		switch {
+		case report.Duration >= 60:
+			return "high"
+		case report.Duration >= 15:
+			return "medium"
+		}
+	case models.SafetyEventRapidAcceleration:
+		switch {
+		case report.Acceleration >= 4.5:
+			return "high"
+		case report.Acceleration >= 3.5:
+			return "medium"
+		}
+	case models.SafetyEventSpeeding:
+		switch over := (report.Speed - report.SpeedLimit) * 3.6; {
+		case over >= 25:
+			return "high"
+		case over >= 10:
+			return "medium"
+		}
+	}
+	return "low"
+}
+
+// summarizeTripSafety adds up the trip's safety events when it ends. Trips
+// that weren't by car and had no events get no summary, so walks don't
+// count towards the driver's reports.
+func (ls *LocationService) summarizeTripSafety(ctx context.Context, trip *models.Trip, stats *models.TripStats) *models.TripSafetySummary {
+	events, err := ls.locationRepo.GetTripDrivingEvents(ctx, trip.ID.Hex())
+	if err != nil {
+		logrus.Warnf("Failed to get safety events of trip %s: %v", trip.ID.Hex(), err)
+		return nil
+	}
+	if len(events) == 0 && trip.Transportation != "" && trip.Transportation != "car" {
+		return nil
+	}
+
+	summary := &models.TripSafetySummary{ComputedAt: time.Now()}
+	if stats != nil {
+		summary.TopSpeed = stats.MaxSpeed
+	}
+
+	points := 0.0
+	for _, event := range events {
+		points += tripSafetyPoints(event)
+
+		switch event.EventType {
+		case models.SafetyEventPhoneUse:
+			summary.PhoneUseEvents++
+			summary.PhoneUseSeconds += int(eventDetail(event, "duration"))
+		case models.SafetyEventRapidAcceleration:
+			summary.RapidAccelerations++
+		case models.SafetyEventSpeeding:
+			summary.SpeedingEvents++
+			summary.TopSpeed = math.Max(summary.TopSpeed, eventDetail(event, "speed"))
+		}
+	}
+
+	summary.Score = int(math.Max(0, math.Round(100-points)))
+	return summary
+}
+
+// tripSafetyPoints is what the event takes off the trip's score
+func tripSafetyPoints(event models.DrivingEvent) float64 {
+	switch event.EventType {
+	case models.SafetyEventPhoneUse:
+		minutes := eventDetail(event, "duration") / 60
+		return math.Min(safetyPhoneUsePoints+safetyPhoneUsePerMinute*minutes, safetyPhoneUseMaxPoints)
+	case models.SafetyEventRapidAcceleration:
+		return safetyRapidAccelPoints
+	case models.SafetyEventSpeeding:
+		overKmh := math.Max(0, eventDetail(event, "speed")-eventDetail(event, "speedLimit")) * 3.6
+		return math.Min(safetySpeedingPoints+safetySpeedingPerKmh*overKmh, safetySpeedingMaxPoints)
+	}
+	return 0
+}
+
+// eventDetail reads a number from the event's details, whichever type it
+// was decoded as
+func eventDetail(event models.DrivingEvent, key string) float64 {
+	switch value := event.Details[key].(type) {
+	case float64:
+		return value
+	case int32:
+		return float64(value)
+	case int64:
+		return float64(value)
+	case int:
+		return float64(value)
+	}
+	return 0
+}
+
+// GetDrivingSafetySharing returns whether the user shares their trip
+// safety with the circle
+func (ls *LocationService) GetDrivingSafetySharing(ctx context.Context, userID, circleID string) (*models.DrivingSafetySharing, error) {
+	_, member, err := ls.activeCircleMember(ctx, userID, circleID)
+	if err != nil {
+		return nil, err
+	}
+
+	sharing := member.DrivingSafety
+	return &sharing, nil
+}
+
+// UpdateDrivingSafetySharing turns sharing trip safety with the circle on
+// or off and picks who is told about weekly reports. Only the driver sets
+// it. Turning it off hides every earlier trip from the circle; the driver
+// keeps them.
+func (ls *LocationService) UpdateDrivingSafetySharing(ctx context.Context, userID, circleID string, req models.UpdateDrivingSafetySharingRequest) (*models.DrivingSafetySharing, error) {
+	if validationErrors := ls.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	circle, member, err := ls.activeCircleMember(ctx, userID, circleID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	sharing := models.DrivingSafetySharing{
+		Enabled:   req.Enabled,
+		UpdatedAt: &now,
+	}
+
+	if req.Enabled {
+		// Changing who is told doesn't restart sharing
+		sharing.SharedSince = &now
+		if previous := member.DrivingSafety; previous.Enabled && previous.SharedSince != nil {
+			sharing.SharedSince = previous.SharedSince
+			sharing.LastReportedWeek = previous.LastReportedWeek
+		}
+
+		seen := make(map[string]bool, len(req.NotifyMemberIDs))
+		for _, recipientID := range req.NotifyMemberIDs {
+			if seen[recipientID] {
+				continue
+			}
+			seen[recipientID] = true
+
+			recipient := findCircleMember(circle, recipientID)
+			if recipientID == userID || recipient == nil || recipient.Status != "active" {
+				return nil, errors.New("notify member not in circle")
+			}
+			sharing.NotifyMemberIDs = append(sharing.NotifyMemberIDs, recipientID)
+		}
+	}
+
+	if err := ls.circleRepo.UpdateMemberDrivingSafety(ctx, circleID, userID, sharing); err != nil {
+		return nil, err
+	}
+
+	return &sharing, nil
+}
+
+// GetWeeklySafetyReport returns the user's own weekly report, with every
+// trip whether it's shared or not
+func (ls *LocationService) GetWeeklySafetyReport(ctx context.Context, userID string, req models.WeeklySafetyReportRequest) (*models.WeeklySafetyReport, error) {
+	user, err := ls.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	weekStart, err := safetyReportWeek(user, req.WeekOf, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return ls.buildWeeklySafetyReport(ctx, userID, weekStart, nil)
+}
+
+// GetMemberWeeklySafetyReport returns a circle member's weekly report. Other
+// members need the driving permission, and only see trips from while the
+// driver shared them with the circle.
+func (ls *LocationService) GetMemberWeeklySafetyReport(ctx context.Context, requesterID, circleID, memberID string, req models.WeeklySafetyReportRequest) (*models.WeeklySafetyReport, error) {
+	circle, requester, err := ls.activeCircleMember(ctx, requesterID, circleID)
+	if err != nil {
+		return nil, err
+	}
+
+	member := findCircleMember(circle, memberID)
+	if member == nil || member.Status != "active" {
+		return nil, errors.New("member not found")
+	}
+
+	var since *time.Time
+	if requesterID != memberID {
+		if requester.Role != "admin" && !requester.Permissions.CanSeeDriving {
+			return nil, errors.New("access denied")
+		}
+
+		blocked, err := ls.blockRepo.IsBlockedEitherWay(ctx, requesterID, memberID)
+		if err != nil {
+			return nil, err
+		}
+		if blocked {
+			return nil, errors.New("access denied")
+		}
+
+		if !member.DrivingSafety.Enabled || member.DrivingSafety.SharedSince == nil {
+			return nil, errors.New("driving safety not shared")
+		}
+		since = member.DrivingSafety.SharedSince
+	}
+
+	user, err := ls.userRepo.GetByID(ctx, memberID)
+	if err != nil {
+		return nil, err
+	}
+
+	weekStart, err := safetyReportWeek(user, req.WeekOf, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := ls.buildWeeklySafetyReport(ctx, memberID, weekStart, since)
+	if err != nil {
+		return nil, err
+	}
+	report.CircleID = circleID
+	return report, nil
+}
+
+// SendWeeklySafetyReports tells the members each driver picked about the
+// driver's last full week, once per week, going through batchSize circles
+// at a time. Weeks without trips aren't announced. It returns how many
+// reports went out.
+func (ls *LocationService) SendWeeklySafetyReports(ctx context.Context, batchSize int) (int, error) {
+	sent := 0
+	var afterID primitive.ObjectID
+	for {
+		circles, err := ls.circleRepo.GetCirclesWithDrivingSafetyReports(ctx, afterID, batchSize)
+		if err != nil {
+			return sent, err
+		}
+
+		for i := range circles {
+			if err := ctx.Err(); err != nil {
+				return sent, err
+			}
+
+			count, err := ls.sendCircleSafetyReports(ctx, &circles[i], time.Now())
+			sent += count
+			if err != nil {
+				logrus.Warnf("Failed to send safety reports of circle %s: %v", circles[i].ID.Hex(), err)
+			}
+		}
+
+		if len(circles) < batchSize {
+			return sent, nil
+		}
+		afterID = circles[len(circles)-1].ID
+	}
+}
+
+func (ls *LocationService) sendCircleSafetyReports(ctx context.Context, circle *models.Circle, now time.Time) (int, error) {
+	sent := 0
+	for _, member := range circle.Members {
+		sharing := member.DrivingSafety
+		if member.Status != "active" || !sharing.Enabled || sharing.SharedSince == nil || len(sharing.NotifyMemberIDs) == 0 {
+			continue
+		}
+
+		userID := member.UserID.Hex()
+		user, err := ls.userRepo.GetByID(ctx, userID)
+		if err != nil {
+			return sent, err
+		}
+
+		weekStart, _ := safetyReportWeek(user, "", now)
+		if sharing.LastReportedWeek != nil && !sharing.LastReportedWeek.Before(weekStart) {
+			continue
+		}
+
+		report, err := ls.buildWeeklySafetyReport(ctx, userID, weekStart, sharing.SharedSince)
+		if err != nil {
+			return sent, err
+		}
+		if report.Trips > 0 && ls.sendSafetyReport(ctx, circle, user, sharing.NotifyMemberIDs, report) {
+			sent++
+		}
+
+		if err := ls.circleRepo.SetMemberSafetyReported(ctx, circle.ID, member.UserID, weekStart); err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// sendSafetyReport tells the recipients who are still active, unblocked
+// members that the driver's weekly report is ready
+func (ls *LocationService) sendSafetyReport(ctx context.Context, circle *models.Circle, user *models.User, recipientIDs []string, report *models.WeeklySafetyReport) bool {
+	if ls.notificationService == nil {
+		return false
+	}
+
+	userID := user.ID.Hex()
+	var recipients []string
+	for _, recipientID := range recipientIDs {
+		recipient := findCircleMember(circle, recipientID)
+		if recipient == nil || recipient.Status != "active" {
+			continue
+		}
+		if blocked, err := ls.blockRepo.IsBlockedEitherWay(ctx, recipientID, userID); err != nil || blocked {
+			continue
+		}
+		recipients = append(recipients, recipientID)
+	}
+	if len(recipients) == 0 {
+		return false
+	}
+
+	name := strings.TrimSpace(user.FirstName + " " + user.LastName)
+	err := ls.notificationService.SendNotification(ctx, models.SendNotificationRequest{
+		Recipients: recipients,
+		Title:      "Weekly driving report",
+		Message: fmt.Sprintf("%s drove %d trips last week with a safety score of %d: %d phone uses, %d speeding and %d rapid acceleration events.",
+			name, report.Trips, *report.Score, report.PhoneUseEvents, report.SpeedingEvents, report.RapidAccelerations),
+		Type:     models.NotificationTypeDrivingSafetyReport,
+		Priority: "normal",
+		Category: "circle",
+		Data: map[string]interface{}{
+			"circleId":  circle.ID.Hex(),
+			"userId":    userID,
+			"weekStart": report.WeekStart.Format("2006-01-02"),
+		},
+		SubjectUserID: userID,
+	})
+	if err != nil {
+		logrus.Errorf("Failed to send safety report of user %s in circle %s: %v", userID, circle.ID.Hex(), err)
+		return false
+	}
+	return true
+}
+
+// buildWeeklySafetyReport adds up the driver's trips that ended in the
+// week, and after since if set
+func (ls *LocationService) buildWeeklySafetyReport(ctx context.Context, userID string, weekStart time.Time, since *time.Time) (*models.WeeklySafetyReport, error) {
+	weekEnd := weekStart.AddDate(0, 0, 7)
+	report := &models.WeeklySafetyReport{
+		UserID:        userID,
+		WeekStart:     weekStart,
+		WeekEnd:       weekEnd,
+		TripSummaries: []models.TripSafetyEntry{},
+		Generated:     time.Now(),
+	}
+
+	from := weekStart
+	if since != nil && since.After(from) {
+		from = *since
+	}
+	if !from.Before(weekEnd) {
+		return report, nil
+	}
+
+	trips, err := ls.locationRepo.GetSafetyTrips(ctx, userID, from, weekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	var weightedScore, weights, scoreSum float64
+	for _, trip := range trips {
+		if trip.Safety == nil || trip.EndTime == nil {
+			continue
+		}
+		safety := *trip.Safety
+
+		entry := models.TripSafetyEntry{
+			TripID:    trip.ID.Hex(),
+			StartTime: trip.StartTime,
+			EndTime:   *trip.EndTime,
+			Safety:    safety,
+		}
+		if trip.Stats != nil {
+			entry.Distance = trip.Stats.TotalDistance
+			entry.Duration = trip.Stats.TotalTime
+		}
+		report.TripSummaries = append(report.TripSummaries, entry)
+
+		report.Trips++
+		report.TotalDistance += entry.Distance
+		report.TotalTime += entry.Duration
+		report.PhoneUseEvents += safety.PhoneUseEvents
+		report.PhoneUseSeconds += safety.PhoneUseSeconds
+		report.RapidAccelerations += safety.RapidAccelerations
+		report.SpeedingEvents += safety.SpeedingEvents
+		report.TopSpeed = math.Max(report.TopSpeed, safety.TopSpeed)
+
+		weightedScore += float64(safety.Score) * float64(entry.Duration)
+		weights += float64(entry.Duration)
+		scoreSum += float64(safety.Score)
+	}
+
+	if report.Trips > 0 {
+		// Trips without a recorded duration count equally
+		score := int(math.Round(scoreSum / float64(report.Trips)))
+		if weights > 0 {
+			score = int(math.Round(weightedScore / weights))
+		}
+		report.Score = &score
+	}
+
+	return report, nil
+}
+
+// safetyReportWeek is the start of the week, Monday midnight in the user's
+// timezone, holding the weekOf date, or of the last full week before now
+func safetyReportWeek(user *models.User, weekOf string, now time.Time) (time.Time, error) {
+	location := time.UTC
+	if user.Preferences.Timezone != "" {
+		if loaded, err := time.LoadLocation(user.Preferences.Timezone); err == nil {
+			location = loaded
+		}
+	}
+
+	day := now.In(location).AddDate(0, 0, -7)
+	if weekOf != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", weekOf, location)
+		if err != nil {
+			return time.Time{}, errors.New("invalid week")
+		}
+		day = parsed
+	}
+
+	daysSinceMonday := (int(day.Weekday()) + 6) % 7
+	return time.Date(day.Year(), day.Month(), day.Day()-daysSinceMonday, 0, 0, 0, 0, location), nil
+}
+
+// activeCircleMember returns the circle and the user's membership, which
+// must be active
+func (ls *LocationService) activeCircleMember(ctx context.Context, userID, circleID string) (*models.Circle, *models.CircleMember, error) {
+	circle, err := ls.circleRepo.GetByID(ctx, circleID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	member := findCircleMember(circle, userID)
+	if member == nil || member.Status != "active" {
+		return nil, nil, errors.New("access denied")
+	}
+	return circle, member, nil
+}
@@ -4,11 +4,16 @@ package services
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"ftrack/models"
 	"ftrack/repositories"
+	"ftrack/utils"
 	"html/template"
+	"net"
 	"net/smtp"
+	"net/textproto"
 
 	"github.com/sirupsen/logrus"
 )
@@ -71,10 +76,9 @@ func (es *SMTPEmailService) SendEmail(data EmailData) error {
 	message := es.buildMessage(data.To, data.Subject, htmlBody, textBody)
 
 	// Send email
-	auth := smtp.PlainAuth("", es.username, es.password, es.host)
-	addr := fmt.Sprintf("%s:%s", es.host, es.port)
-
-	err = smtp.SendMail(addr, auth, es.from, []string{data.To}, []byte(message))
+	err = utils.Providers.Do(context.Background(), utils.ProviderEmail, func(ctx context.Context) error {
+		return es.sendMail(ctx, data.To, []byte(message))
+	})
 	if err != nil {
 		logrus.Errorf("Failed to send email to %s: %v", data.To, err)
 		return err
@@ -84,6 +88,66 @@ func (es *SMTPEmailService) SendEmail(data EmailData) error {
 	return nil
 }
 
+// sendMail is smtp.SendMail bounded by the context, so a server that stops
+// answering doesn't hold the caller. Rejections (5xx replies) aren't retried.
+func (es *SMTPEmailService) sendMail(ctx context.Context, to string, message []byte) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(es.host, es.port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, es.host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: es.host}); err != nil {
+			return err
+		}
+	}
+	if ok, _ := client.Extension("AUTH"); ok && es.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", es.username, es.password, es.host)); err != nil {
+			return smtpError(err)
+		}
+	}
+
+	if err := client.Mail(es.from); err != nil {
+		return smtpError(err)
+	}
+	if err := client.Rcpt(to); err != nil {
+		return smtpError(err)
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return smtpError(err)
+	}
+	if _, err := writer.Write(message); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return smtpError(err)
+	}
+
+	return client.Quit()
+}
+
+// smtpError makes permanent failures permanent; 4xx replies are worth
+// retrying
+func smtpError(err error) error {
+	var replyErr *textproto.Error
+	if errors.As(err, &replyErr) && replyErr.Code >= 500 {
+		return utils.NewPermanentProviderError(err)
+	}
+	return err
+}
+
 // ============== NEW AUTH-SPECIFIC METHODS ==============
 
 // SendVerificationEmail sends email verification email
@@ -389,6 +453,29 @@ func (es *SMTPEmailService) buildHTMLTemplate(templateName string, data map[stri
     </ul>
     <p>Best regards,<br>FTrack Team</p>
 </body>
+</html>`,
+
+		// Emergency contact verification template
+		"emergency_contact_verification": `
+<body>
+    <h2>Hi {{.Name}},</h2>
+    <p>{{.OwnerName}} has added you as an emergency contact on FTrack.</p>
+    <p>Emergency contacts are alerted by SMS or email if {{.OwnerName}} triggers an SOS.</p>
+    <p><a href="{{.ConfirmURL}}">Yes, I agree to be an emergency contact</a></p>
+    <p><a href="{{.DeclineURL}}">No, do not contact me</a></p>
+    <p>Best regards,<br>FTrack Team</p>
+</body>
+</html>`,
+
+		// Deactivated account deletion warning template
+		"account_deletion_warning": `
+<body>
+    <h2>Hi {{.Name}},</h2>
+    <p>Your FTrack account has been deactivated for a long time and will be permanently deleted on {{.DeletionDate}}.</p>
+    <p>To keep your account, simply log in to FTrack and confirm that you want to reactivate it.</p>
+    <p>If you no longer need your account, you don't need to do anything.</p>
+    <p>Best regards,<br>FTrack Team</p>
+</body>
 </html>`,
 	}
 
@@ -490,6 +577,36 @@ For your security:
 
 © 2024 FTrack. All rights reserved.`, name)
 
+	case "emergency_contact_verification":
+		ownerName, _ := data["OwnerName"].(string)
+		confirmURL, _ := data["ConfirmURL"].(string)
+		declineURL, _ := data["DeclineURL"].(string)
+		return fmt.Sprintf(`Hi %s,
+
+%s has added you as an emergency contact on FTrack.
+
+Emergency contacts are alerted by SMS or email if %s triggers an SOS.
+
+To confirm, visit:
+%s
+
+To decline and never be contacted, visit:
+%s
+
+© 2024 FTrack. All rights reserved.`, name, ownerName, ownerName, confirmURL, declineURL)
+
+	case "account_deletion_warning":
+		deletionDate, _ := data["DeletionDate"].(string)
+		return fmt.Sprintf(`Hi %s,
+
+Your FTrack account has been deactivated for a long time and will be permanently deleted on %s.
+
+To keep your account, simply log in to FTrack and confirm that you want to reactivate it.
+
+If you no longer need your account, you don't need to do anything.
+
+© 2024 FTrack. All rights reserved.`, name, deletionDate)
+
 	// Keep your existing cases...
 	case "verification":
 		link, _ := data["Link"].(string)
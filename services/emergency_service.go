@@ -49,7 +49,7 @@ func (es *EmergencyService) GetEmergencyAlerts(ctx context.Context, userID strin
 
 func (es *EmergencyService) CreateEmergencyAlert(ctx context.Context, userID string, req models.CreateEmergencyAlertRequest) (*models.Emergency, error) {
 	if validationErrors := es.validator.ValidateStruct(req); len(validationErrors) > 0 {
-		return nil, errors.New("validation failed")
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
 	}
 
 	userObjectID, err := primitive.ObjectIDFromHex(userID)
@@ -89,7 +89,9 @@ func (es *EmergencyService) CreateEmergencyAlert(ctx context.Context, userID str
 	}
 
 	// Notify contacts and broadcast
-	go es.handleEmergencyNotifications(ctx, emergency)
+	Background.Go(ctx, func(ctx context.Context) {
+		es.handleEmergencyNotifications(ctx, emergency)
+	})
 
 	return emergency, nil
 }
@@ -107,10 +109,18 @@ func (es *EmergencyService) GetEmergencyAlert(ctx context.Context, userID, alert
 		return nil, errors.New("access denied")
 	}
 
+	if emergency.UserID.Hex() != userID {
+		maskEmergencyContacts(emergency.Contacts)
+	}
+
 	return emergency, nil
 }
 
 func (es *EmergencyService) UpdateEmergencyAlert(ctx context.Context, userID, alertID string, req models.UpdateEmergencyAlertRequest) (*models.Emergency, error) {
+	if validationErrors := es.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
 	emergency, err := es.emergencyRepo.GetByID(ctx, alertID)
 	if err != nil {
 		return nil, err
@@ -204,7 +214,7 @@ func (es *EmergencyService) ResolveEmergencyAlert(ctx context.Context, userID, a
 
 func (es *EmergencyService) TriggerSOS(ctx context.Context, userID string, req models.TriggerSOSRequest) (*models.Emergency, error) {
 	if validationErrors := es.validator.ValidateStruct(req); len(validationErrors) > 0 {
-		return nil, errors.New("validation failed")
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
 	}
 
 	userObjectID, err := primitive.ObjectIDFromHex(userID)
@@ -238,11 +248,15 @@ func (es *EmergencyService) TriggerSOS(ctx context.Context, userID string, req m
 
 	// Handle SOS specific logic
 	if req.AutoCall && req.CountdownSec > 0 {
-		go es.handleSOSCountdown(ctx, emergency.ID.Hex(), req.CountdownSec)
+		Background.Go(ctx, func(ctx context.Context) {
+			es.handleSOSCountdown(ctx, emergency.ID.Hex(), req.CountdownSec)
+		})
 	}
 
 	// Immediate notifications for SOS
-	go es.handleEmergencyNotifications(ctx, emergency)
+	Background.Go(ctx, func(ctx context.Context) {
+		es.handleEmergencyNotifications(ctx, emergency)
+	})
 
 	return emergency, nil
 }
@@ -329,10 +343,21 @@ func (es *EmergencyService) TestSOS(ctx context.Context, userID string) (map[str
 		"timestamp": time.Now(),
 	}
 
-	// Test emergency contacts
+	// Test emergency contacts - only verified contacts are used in escalations
 	contacts, err := es.GetEmergencyContacts(ctx, userID)
 	if err != nil || len(contacts) == 0 {
 		result["contacts"] = "no_contacts_configured"
+	} else {
+		verified := 0
+		for _, contact := range contacts {
+			if contact.IsVerified() {
+				verified++
+			}
+		}
+		if verified == 0 {
+			result["contacts"] = "no_verified_contacts"
+		}
+		result["verifiedContacts"] = verified
 	}
 
 	return result, nil
@@ -342,7 +367,7 @@ func (es *EmergencyService) TestSOS(ctx context.Context, userID string) (map[str
 
 func (es *EmergencyService) DetectCrash(ctx context.Context, userID string, req models.CrashDetectionRequest) (*models.Emergency, error) {
 	if validationErrors := es.validator.ValidateStruct(req); len(validationErrors) > 0 {
-		return nil, errors.New("validation failed")
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
 	}
 
 	userObjectID, err := primitive.ObjectIDFromHex(userID)
@@ -376,7 +401,9 @@ func (es *EmergencyService) DetectCrash(ctx context.Context, userID string, req
 	}
 
 	// Start confirmation countdown for crash detection
-	go es.handleCrashConfirmationCountdown(ctx, emergency.ID.Hex())
+	Background.Go(ctx, func(ctx context.Context) {
+		es.handleCrashConfirmationCountdown(ctx, emergency.ID.Hex())
+	})
 
 	return emergency, nil
 }
@@ -403,7 +430,9 @@ func (es *EmergencyService) ConfirmCrash(ctx context.Context, userID, detectionI
 		}
 
 		// Trigger emergency notifications
-		go es.handleEmergencyNotifications(ctx, emergency)
+		Background.Go(ctx, func(ctx context.Context) {
+			es.handleEmergencyNotifications(ctx, emergency)
+		})
 	} else {
 		updateFields["status"] = models.EmergencyStatusFalseAlarm
 		updateFields["dismissalReason"] = "User confirmed no crash occurred"
@@ -479,28 +508,6 @@ func (es *EmergencyService) GetEmergencyContacts(ctx context.Context, userID str
 	return es.emergencyRepo.GetUserEmergencyContacts(ctx, userID)
 }
 
-func (es *EmergencyService) AddEmergencyContact(ctx context.Context, userID string, req models.AddEmergencyContactRequest) (*models.EmergencyContact, error) {
-	if validationErrors := es.validator.ValidateStruct(req); len(validationErrors) > 0 {
-		return nil, errors.New("validation failed")
-	}
-
-	contact := &models.EmergencyContact{
-		ContactID:    primitive.NewObjectID(),
-		Name:         req.Name,
-		Phone:        req.Phone,
-		Email:        req.Email,
-		Relationship: req.Relationship,
-		UpdatedAt:    time.Now(),
-	}
-
-	err := es.emergencyRepo.AddEmergencyContact(ctx, userID, contact)
-	if err != nil {
-		return nil, err
-	}
-
-	return contact, nil
-}
-
 func (es *EmergencyService) GetEmergencyContact(ctx context.Context, userID, contactID string) (*models.EmergencyContact, error) {
 	contacts, err := es.GetEmergencyContacts(ctx, userID)
 	if err != nil {
@@ -516,77 +523,6 @@ func (es *EmergencyService) GetEmergencyContact(ctx context.Context, userID, con
 	return nil, errors.New("contact not found")
 }
 
-func (es *EmergencyService) UpdateEmergencyContact(ctx context.Context, userID, contactID string, req models.UpdateEmergencyContactRequest) (*models.EmergencyContact, error) {
-	contact, err := es.GetEmergencyContact(ctx, userID, contactID)
-	if err != nil {
-		return nil, err
-	}
-
-	if req.Name != "" {
-		contact.Name = req.Name
-	}
-	if req.Phone != "" {
-		contact.Phone = req.Phone
-	}
-	if req.Email != "" {
-		contact.Email = req.Email
-	}
-	if req.Relationship != "" {
-		contact.Relationship = req.Relationship
-	}
-
-	contact.UpdatedAt = time.Now()
-
-	err = es.emergencyRepo.UpdateEmergencyContact(ctx, userID, contactID, contact)
-	if err != nil {
-		return nil, err
-	}
-
-	return contact, nil
-}
-
-func (es *EmergencyService) DeleteEmergencyContact(ctx context.Context, userID, contactID string) error {
-	return es.emergencyRepo.DeleteEmergencyContact(ctx, userID, contactID)
-}
-
-func (es *EmergencyService) VerifyEmergencyContact(ctx context.Context, userID, contactID string, req models.VerifyContactRequest) (map[string]interface{}, error) {
-	contact, err := es.GetEmergencyContact(ctx, userID, contactID)
-	if err != nil {
-		return nil, err
-	}
-
-	result := map[string]interface{}{
-		"status":    "sent",
-		"method":    req.Method,
-		"contactId": contactID,
-		"timestamp": time.Now(),
-	}
-
-	// Send verification based on method
-	switch req.Method {
-	case "sms":
-		if contact.Phone == "" {
-			return nil, errors.New("phone number not available")
-		}
-		// Send SMS verification
-		result["message"] = "Verification SMS sent"
-	case "email":
-		if contact.Email == "" {
-			return nil, errors.New("email address not available")
-		}
-		// Send email verification
-		result["message"] = "Verification email sent"
-	case "call":
-		if contact.Phone == "" {
-			return nil, errors.New("phone number not available")
-		}
-		// Initiate verification call
-		result["message"] = "Verification call initiated"
-	}
-
-	return result, nil
-}
-
 func (es *EmergencyService) NotifyEmergencyContact(ctx context.Context, userID, contactID string, req models.NotifyContactRequest) error {
 	contact, err := es.GetEmergencyContact(ctx, userID, contactID)
 	if err != nil {
@@ -879,7 +815,9 @@ func (es *EmergencyService) RespondToEmergency(ctx context.Context, userID, aler
 	}
 
 	// Notify emergency creator
-	go es.notifyEmergencyCreator(ctx, emergency, response)
+	Background.Go(ctx, func(ctx context.Context) {
+		es.notifyEmergencyCreator(ctx, emergency, response)
+	})
 
 	return response, nil
 }
@@ -944,7 +882,9 @@ func (es *EmergencyService) RequestHelp(ctx context.Context, userID, alertID str
 	}
 
 	// Broadcast help request to nearby users
-	go es.broadcastHelpRequest(ctx, helpRequest)
+	Background.Go(ctx, func(ctx context.Context) {
+		es.broadcastHelpRequest(ctx, helpRequest)
+	})
 
 	return helpRequest, nil
 }
@@ -970,7 +910,9 @@ func (es *EmergencyService) OfferHelp(ctx context.Context, userID, alertID strin
 
 	// Notify emergency creator about help offer
 	emergency, _ := es.emergencyRepo.GetByID(ctx, alertID)
-	go es.notifyEmergencyCreator(ctx, emergency, helpOffer)
+	Background.Go(ctx, func(ctx context.Context) {
+		es.notifyEmergencyCreator(ctx, emergency, helpOffer)
+	})
 
 	return helpOffer, nil
 }
@@ -996,7 +938,9 @@ func (es *EmergencyService) CheckInSafe(ctx context.Context, userID string, req
 	}
 
 	// Notify concerned contacts
-	go es.notifyCheckInStatus(ctx, userID, "safe", req.Message)
+	Background.Go(ctx, func(ctx context.Context) {
+		es.notifyCheckInStatus(ctx, userID, "safe", req.Message)
+	})
 
 	return checkIn, nil
 }
@@ -1028,11 +972,15 @@ func (es *EmergencyService) CheckInNotSafe(ctx context.Context, userID string, r
 			Priority:    req.Severity,
 			Location:    req.Location,
 		}
-		go es.CreateEmergencyAlert(ctx, userID, emergencyReq)
+		Background.Go(ctx, func(ctx context.Context) {
+			es.CreateEmergencyAlert(ctx, userID, emergencyReq)
+		})
 	}
 
 	// Notify emergency contacts
-	go es.notifyCheckInStatus(ctx, userID, "not_safe", req.Description)
+	Background.Go(ctx, func(ctx context.Context) {
+		es.notifyCheckInStatus(ctx, userID, "not_safe", req.Description)
+	})
 
 	return checkIn, nil
 }
@@ -1104,7 +1052,9 @@ func (es *EmergencyService) RequestCheckIn(ctx context.Context, requesterID, tar
 	}
 
 	// Notify target user
-	go es.notifyCheckInRequest(ctx, targetUserID, request)
+	Background.Go(ctx, func(ctx context.Context) {
+		es.notifyCheckInRequest(ctx, targetUserID, request)
+	})
 
 	return request, nil
 }
@@ -1149,7 +1099,9 @@ func (es *EmergencyService) ExportEmergencyHistory(ctx context.Context, userID s
 	}
 
 	// Start background export process
-	go es.processEmergencyExport(ctx, userID, export, req)
+	Background.Go(ctx, func(ctx context.Context) {
+		es.processEmergencyExport(ctx, userID, export, req)
+	})
 
 	return export, nil
 }
@@ -1394,7 +1346,9 @@ func (es *EmergencyService) BroadcastEmergency(ctx context.Context, userID strin
 	}
 
 	// Send broadcast through specified channels
-	go es.sendBroadcastNotifications(ctx, broadcast)
+	Background.Go(ctx, func(ctx context.Context) {
+		es.sendBroadcastNotifications(ctx, broadcast)
+	})
 
 	return broadcast, nil
 }
@@ -1591,6 +1545,14 @@ func (es *EmergencyService) hasEmergencyAccess(ctx context.Context, userID strin
 	return false
 }
 
+// maskEmergencyContacts hides contact details from anyone but the owning user
+func maskEmergencyContacts(contacts []models.EmergencyContact) {
+	for i := range contacts {
+		contacts[i].Phone = utils.MaskPhoneNumber(contacts[i].Phone)
+		contacts[i].Email = utils.MaskEmail(contacts[i].Email)
+	}
+}
+
 func (es *EmergencyService) getEmergencyTitle(emergencyType string) string {
 	switch emergencyType {
 	case models.EmergencyTypeSOS:
@@ -1638,8 +1600,9 @@ func (es *EmergencyService) notifyEmergencyContacts(ctx context.Context, emergen
 		return
 	}
 
-	// Get emergency contacts
-	contacts, err := es.GetEmergencyContacts(ctx, emergency.UserID.Hex())
+	// Only contacts who confirmed the verification link are escalated to;
+	// pending and declined contacts are never messaged.
+	contacts, err := es.emergencyRepo.GetVerifiedEmergencyContacts(ctx, emergency.UserID.Hex())
 	if err != nil {
 		return
 	}
@@ -1667,6 +1630,8 @@ func (es *EmergencyService) notifyEmergencyContacts(ctx context.Context, emergen
 				}
 			}
 
+			// SubjectUserID is deliberately left unset: emergency alerts
+			// reach every circle member regardless of blocks
 			if len(userIDs) > 0 {
 				notifReq := models.SendNotificationRequest{
 					Recipients:       userIDs,     // Changed from UserIDs
@@ -1715,7 +1680,9 @@ func (es *EmergencyService) broadcastEmergencyAlert(userID string, emergency *mo
 }
 
 func (es *EmergencyService) handleSOSCountdown(ctx context.Context, emergencyID string, countdownSec int) {
-	time.Sleep(time.Duration(countdownSec) * time.Second)
+	if err := waitCountdown(ctx, time.Duration(countdownSec)*time.Second); err != nil {
+		return
+	}
 
 	// Check if SOS was cancelled
 	emergency, err := es.emergencyRepo.GetByID(ctx, emergencyID)
@@ -1728,7 +1695,9 @@ func (es *EmergencyService) handleSOSCountdown(ctx context.Context, emergencyID
 }
 
 func (es *EmergencyService) handleCrashConfirmationCountdown(ctx context.Context, emergencyID string) {
-	time.Sleep(30 * time.Second) // 30 second countdown
+	if err := waitCountdown(ctx, 30*time.Second); err != nil {
+		return
+	}
 
 	emergency, err := es.emergencyRepo.GetByID(ctx, emergencyID)
 	if err != nil || emergency.Status != models.EmergencyStatusActive {
@@ -1739,6 +1708,16 @@ func (es *EmergencyService) handleCrashConfirmationCountdown(ctx context.Context
 	es.handleEmergencyNotifications(ctx, emergency)
 }
 
+// waitCountdown waits out a countdown, or until shutdown gives up waiting
+func waitCountdown(ctx context.Context, countdown time.Duration) error {
+	select {
+	case <-time.After(countdown):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (es *EmergencyService) processEmergencyExport(ctx context.Context, userID string, export *models.EmergencyFileExport, req models.ExportHistoryRequest) {
 	// Background process to generate export file
 	// This would collect data, format it, and store the file
@@ -0,0 +1,274 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"ftrack/models"
+	"ftrack/repositories"
+	"ftrack/utils"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ConfigureCirclePermissions enforces circles' export policies on exports of
+// circle data, and posts every such export to the circle's activity feed
+func (es *ExportService) ConfigureCirclePermissions(circleRepo *repositories.CircleRepository) {
+	es.circleRepo = circleRepo
+}
+
+// effectiveExportPolicy is who the circle's policy lets export, with the
+// default filled in
+func effectiveExportPolicy(policy *models.ExportPolicy) models.ExportPolicy {
+	effective := models.ExportPolicy{}
+	if policy != nil {
+		effective = *policy
+	}
+	if effective.Allow == "" {
+		effective.Allow = models.DefaultExportPolicy
+	}
+	return effective
+}
+
+func activeCircleMember(circle *models.Circle, userID primitive.ObjectID) *models.CircleMember {
+	for i := range circle.Members {
+		if circle.Members[i].UserID == userID && circle.Members[i].Status == "active" {
+			return &circle.Members[i]
+		}
+	}
+	return nil
+}
+
+// mayExportCircle reports whether the circle's policy lets the member export
+func mayExportCircle(circle *models.Circle, member *models.CircleMember) bool {
+	isOwner := member.UserID == circle.AdminID
+	switch effectiveExportPolicy(circle.ExportPolicy).Allow {
+	case models.ExportPolicyOwner:
+		return isOwner
+	case models.ExportPolicyAdmins:
+		return isOwner || member.Role == "admin"
+	default:
+		return true
+	}
+}
+
+// mayReviewExportRequests reports whether the member approves export
+// requests: the owner always, admins unless only the owner may export
+func mayReviewExportRequests(circle *models.Circle, member *models.CircleMember) bool {
+	if member.UserID == circle.AdminID {
+		return true
+	}
+	return member.Role == "admin" && effectiveExportPolicy(circle.ExportPolicy).Allow != models.ExportPolicyOwner
+}
+
+// circleForMember returns the circle and the user's membership of it
+func (es *ExportService) circleForMember(ctx context.Context, userID, circleID string) (*models.Circle, *models.CircleMember, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, nil, errors.New("invalid user ID")
+	}
+
+	circle, err := es.circleRepo.GetByID(ctx, circleID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	member := activeCircleMember(circle, userObjectID)
+	if member == nil {
+		return nil, nil, errors.New("access denied")
+	}
+	return circle, member, nil
+}
+
+// authorizeCircleExport checks that the user may export the circle. Members
+// the policy leaves out need an approved request, which is returned so the
+// export can use it up.
+func (es *ExportService) authorizeCircleExport(ctx context.Context, userID string, circleID primitive.ObjectID) (*models.ExportPermissionRequest, error) {
+	if es.circleRepo == nil {
+		return nil, nil
+	}
+
+	circle, member, err := es.circleForMember(ctx, userID, circleID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	if mayExportCircle(circle, member) {
+		return nil, nil
+	}
+
+	grant, err := es.exportRepo.GetPermissionGrant(ctx, circle.ID, member.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if grant == nil {
+		return nil, errors.New("export permission required")
+	}
+	return grant, nil
+}
+
+// CheckCircleExportPermission returns "export permission required" if the
+// circle's policy doesn't let the user export it and no request of theirs
+// was approved
+func (es *ExportService) CheckCircleExportPermission(ctx context.Context, userID, circleID string) error {
+	circleObjectID, err := primitive.ObjectIDFromHex(circleID)
+	if err != nil {
+		return errors.New("invalid circle ID")
+	}
+	_, err = es.authorizeCircleExport(ctx, userID, circleObjectID)
+	return err
+}
+
+// startedCircleExport uses up the approval the export relied on, if any,
+// and posts the export to the circle's activity feed so a leaked file can
+// be traced to it
+func (es *ExportService) startedCircleExport(ctx context.Context, export *models.MessageExport, grant *models.ExportPermissionRequest) {
+	data := map[string]interface{}{
+		"exportId": export.ID.Hex(),
+		"type":     export.Type,
+		"format":   export.Format,
+	}
+
+	if grant != nil {
+		used, err := es.exportRepo.UsePermissionGrant(ctx, grant.ID, export.ID)
+		if err != nil || !used {
+			logrus.Warnf("Failed to use export approval %s for export %s: %v", grant.ID.Hex(), export.ID.Hex(), err)
+		}
+		data["requestId"] = grant.ID.Hex()
+	}
+
+	activity := models.CircleActivity{
+		CircleID:  export.CircleID,
+		UserID:    export.UserID,
+		Type:      "export",
+		Action:    "export_started",
+		Data:      data,
+		CreatedAt: export.CreatedAt,
+	}
+	if err := es.circleRepo.CreateActivity(ctx, &activity); err != nil {
+		logrus.Errorf("Failed to record export %s in the activity of circle %s: %v", export.ID.Hex(), export.CircleID.Hex(), err)
+	}
+}
+
+// GetExportPolicy returns who may export the circle, with the default
+// filled in
+func (es *ExportService) GetExportPolicy(ctx context.Context, userID, circleID string) (*models.ExportPolicy, error) {
+	circle, _, err := es.circleForMember(ctx, userID, circleID)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := effectiveExportPolicy(circle.ExportPolicy)
+	return &policy, nil
+}
+
+// UpdateExportPolicy replaces who may export the circle. Admins set it,
+// except that only the owner changes an owner-only policy.
+func (es *ExportService) UpdateExportPolicy(ctx context.Context, userID, circleID string, req models.ExportPolicy) (*models.ExportPolicy, error) {
+	circle, member, err := es.circleForMember(ctx, userID, circleID)
+	if err != nil {
+		return nil, err
+	}
+	if !mayReviewExportRequests(circle, member) {
+		return nil, errors.New("access denied")
+	}
+
+	if validationErrors := es.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	req.UpdatedBy = member.UserID
+	req.UpdatedAt = time.Now()
+
+	if err := es.circleRepo.Update(ctx, circleID, bson.M{"exportPolicy": req}); err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("Export policy of circle %s set to %s by %s", circleID, req.Allow, userID)
+	return &req, nil
+}
+
+// RequestExportPermission asks the circle's admins to let the user export
+// it once
+func (es *ExportService) RequestExportPermission(ctx context.Context, userID, circleID string, req models.CreateExportRequestRequest) (*models.ExportPermissionRequest, error) {
+	if validationErrors := es.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	circle, member, err := es.circleForMember(ctx, userID, circleID)
+	if err != nil {
+		return nil, err
+	}
+	if mayExportCircle(circle, member) {
+		return nil, errors.New("export already allowed")
+	}
+
+	request := &models.ExportPermissionRequest{
+		CircleID: circle.ID,
+		UserID:   member.UserID,
+		Reason:   req.Reason,
+	}
+	if err := es.exportRepo.CreatePermissionRequest(ctx, request); err != nil {
+		return nil, err
+	}
+
+	activity := models.CircleActivity{
+		CircleID:  circle.ID,
+		UserID:    member.UserID,
+		Type:      "export",
+		Action:    "export_requested",
+		Data:      map[string]interface{}{"requestId": request.ID.Hex()},
+		CreatedAt: request.CreatedAt,
+	}
+	if err := es.circleRepo.CreateActivity(ctx, &activity); err != nil {
+		logrus.Warnf("Failed to record export request activity for circle %s: %v", circleID, err)
+	}
+
+	return request, nil
+}
+
+// GetExportRequests returns the circle's requests waiting for review
+func (es *ExportService) GetExportRequests(ctx context.Context, userID, circleID string) ([]models.ExportPermissionRequest, error) {
+	circle, member, err := es.circleForMember(ctx, userID, circleID)
+	if err != nil {
+		return nil, err
+	}
+	if !mayReviewExportRequests(circle, member) {
+		return nil, errors.New("access denied")
+	}
+
+	return es.exportRepo.GetPendingPermissionRequests(ctx, circle.ID)
+}
+
+// ReviewExportRequest approves or denies a pending request. An approval
+// lets the member start one export within models.ExportGrantDuration.
+func (es *ExportService) ReviewExportRequest(ctx context.Context, userID, circleID, requestID string, req models.ReviewExportRequestRequest) (*models.ExportPermissionRequest, error) {
+	circle, member, err := es.circleForMember(ctx, userID, circleID)
+	if err != nil {
+		return nil, err
+	}
+	if !mayReviewExportRequests(circle, member) {
+		return nil, errors.New("access denied")
+	}
+
+	request, err := es.exportRepo.GetPermissionRequest(ctx, requestID)
+	if err != nil {
+		return nil, err
+	}
+	if request.CircleID != circle.ID {
+		return nil, errors.New("export request not found")
+	}
+
+	reviewed, err := es.exportRepo.ReviewPermissionRequest(ctx, request.ID, member.UserID, req.Approve)
+	if err != nil {
+		return nil, err
+	}
+	if !reviewed {
+		return nil, errors.New("export request already reviewed")
+	}
+
+	logrus.Infof("Export request %s in circle %s reviewed by %s, approved: %t", requestID, circleID, userID, req.Approve)
+	return es.exportRepo.GetPermissionRequest(ctx, requestID)
+}
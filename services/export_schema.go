@@ -0,0 +1,260 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"ftrack/models"
+	"ftrack/utils"
+)
+
+// exportMigration upgrades a decoded export by one schema version
+type exportMigration func(export *decodedExport) error
+
+// exportMigrations are keyed by the schema version they upgrade from. An
+// export older than the oldest registered migration can't be imported.
+var exportMigrations = map[int]exportMigration{
+	// Version 2 only added the stamp; the records are unchanged
+	models.ExportSchemaVersion1: func(export *decodedExport) error { return nil },
+}
+
+// decodedExport is an export file read back and upgraded to the latest
+// schema version
+type decodedExport struct {
+	Header        models.ExportHeader
+	SourceVersion int // the schema version the file was written at
+	Migrated      bool
+
+	Records json.RawMessage // JSON exports: the array of records
+	Rows    [][]string      // CSV exports: the column row, then the records
+}
+
+// RecordCount returns how many records the export holds
+func (de *decodedExport) RecordCount() (int, error) {
+	if de.Rows != nil {
+		return len(de.Rows) - 1, nil
+	}
+
+	var records []json.RawMessage
+	if err := json.Unmarshal(de.Records, &records); err != nil {
+		return 0, utils.NewValidationFailedError("export records are not a JSON array")
+	}
+	return len(records), nil
+}
+
+// importFormat returns the export format of an uploaded file from its
+// content type, falling back to its extension
+func importFormat(contentType, filename string) string {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	switch strings.TrimSpace(mediaType) {
+	case "application/json":
+		return "json"
+	case "text/csv":
+		return "csv"
+	case "text/plain":
+		return "txt"
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		return "json"
+	case ".csv":
+		return "csv"
+	case ".txt":
+		return "txt"
+	}
+	return ""
+}
+
+// writeJSONExportStart opens a JSON export: the header fields followed by
+// the records array, which writeJSONExportEnd closes
+func writeJSONExportStart(ctx context.Context, w io.Writer, exportType string) error {
+	header, err := json.Marshal(exportHeader(ctx, exportType))
+	if err != nil {
+		return err
+	}
+
+	// Leave the object open for the records
+	if _, err := w.Write(header[:len(header)-1]); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, `,"records":[`)
+	return err
+}
+
+func writeJSONExportEnd(w io.Writer) error {
+	_, err := io.WriteString(w, "]}")
+	return err
+}
+
+// writeExportStamp writes the stamp line that starts CSV and text exports
+func writeExportStamp(ctx context.Context, w io.Writer, exportType string) error {
+	_, err := io.WriteString(w, exportHeader(ctx, exportType).Stamp()+"\n")
+	return err
+}
+
+// decodeExport reads an export file of the format, checks that it holds
+// the expected type of records at a schema version this server can read,
+// and migrates it to the latest version. Incompatible files are rejected
+// with a validation error saying why.
+func decodeExport(data []byte, format, exportType string) (*decodedExport, error) {
+	var export *decodedExport
+	var err error
+
+	switch format {
+	case "json":
+		export, err = decodeJSONExport(data)
+	case "csv":
+		export, err = decodeCSVExport(data)
+	default:
+		return nil, utils.NewValidationFailedError(fmt.Sprintf("%s files can't be imported", format))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	header := &export.Header
+	if header.Type == "" {
+		header.Type = exportType
+	}
+	if header.Type != exportType {
+		return nil, utils.NewValidationFailedError(fmt.Sprintf("file is a %s export, not %s", header.Type, exportType))
+	}
+
+	switch {
+	case header.SchemaVersion < models.ExportSchemaVersion1:
+		return nil, utils.NewValidationFailedError("export has no valid schema version")
+	case header.SchemaVersion > models.ExportSchemaVersionLatest:
+		return nil, utils.NewValidationFailedError(fmt.Sprintf(
+			"export schema version %d (app %s) is newer than this server supports (%d); update the server to import it",
+			header.SchemaVersion, header.AppVersion, models.ExportSchemaVersionLatest))
+	}
+
+	export.SourceVersion = header.SchemaVersion
+	for header.SchemaVersion < models.ExportSchemaVersionLatest {
+		migrate, exists := exportMigrations[header.SchemaVersion]
+		if !exists {
+			return nil, utils.NewValidationFailedError(fmt.Sprintf("export schema version %d is no longer supported", header.SchemaVersion))
+		}
+		if err := migrate(export); err != nil {
+			return nil, utils.NewValidationFailedError(fmt.Sprintf("export can't be upgraded from schema version %d: %v", header.SchemaVersion, err))
+		}
+		header.SchemaVersion++
+		export.Migrated = true
+	}
+
+	return export, nil
+}
+
+// decodeJSONExport reads a stamped export object, or a bare array of
+// records written before exports were stamped
+func decodeJSONExport(data []byte) (*decodedExport, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, utils.NewValidationFailedError("file is empty")
+	}
+
+	if data[0] == '[' {
+		return &decodedExport{
+			Header:  models.ExportHeader{SchemaVersion: models.ExportSchemaVersion1},
+			Records: data,
+		}, nil
+	}
+
+	var file struct {
+		models.ExportHeader
+		Records json.RawMessage `json:"records"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, utils.NewValidationFailedError("file is not a valid JSON export")
+	}
+	if len(file.Records) == 0 {
+		file.Records = json.RawMessage("[]")
+	}
+
+	return &decodedExport{
+		Header:  file.ExportHeader,
+		Records: file.Records,
+	}, nil
+}
+
+// decodeCSVExport reads a CSV export, with its stamp line if it has one
+func decodeCSVExport(data []byte) (*decodedExport, error) {
+	export := &decodedExport{
+		Header: models.ExportHeader{SchemaVersion: models.ExportSchemaVersion1},
+	}
+
+	reader := bufio.NewReader(bytes.NewReader(data))
+	firstLine, err := reader.Peek(len(models.ExportStampPrefix))
+	if err == nil && string(firstLine) == models.ExportStampPrefix {
+		line, _ := reader.ReadString('\n')
+		header, err := parseExportStamp(line)
+		if err != nil {
+			return nil, err
+		}
+		export.Header = header
+	}
+
+	rows, err := csv.NewReader(reader).ReadAll()
+	if err != nil {
+		return nil, utils.NewValidationFailedError("file is not a valid CSV export")
+	}
+	if len(rows) == 0 {
+		return nil, utils.NewValidationFailedError("file is empty")
+	}
+	export.Rows = rows
+
+	return export, nil
+}
+
+// parseExportStamp reads the header back from a stamp line
+func parseExportStamp(line string) (models.ExportHeader, error) {
+	var header models.ExportHeader
+
+	fields := strings.Fields(strings.TrimPrefix(strings.TrimSpace(line), models.ExportStampPrefix))
+	for _, field := range fields {
+		key, value, found := strings.Cut(field, "=")
+		if !found {
+			continue
+		}
+
+		switch key {
+		case "schemaVersion":
+			version, err := strconv.Atoi(value)
+			if err != nil {
+				return header, utils.NewValidationFailedError("export has no valid schema version")
+			}
+			header.SchemaVersion = version
+		case "appVersion":
+			header.AppVersion = value
+		case "type":
+			header.Type = value
+		case "exportedAt":
+			header.ExportedAt, _ = time.Parse(time.RFC3339, value)
+		case "exportId":
+			watermark(&header).ExportID = value
+		case "requestedBy":
+			watermark(&header).RequestedBy = value
+		case "requestedAt":
+			watermark(&header).RequestedAt, _ = time.Parse(time.RFC3339, value)
+		}
+	}
+
+	return header, nil
+}
+
+func watermark(header *models.ExportHeader) *models.ExportWatermark {
+	if header.Watermark == nil {
+		header.Watermark = &models.ExportWatermark{}
+	}
+	return header.Watermark
+}
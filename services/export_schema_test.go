@@ -0,0 +1,171 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"ftrack/models"
+	"ftrack/testharness"
+	"ftrack/utils"
+)
+
+// Exports as releases before stamping wrote them
+const (
+	v1PlacesJSON = `[
+		{"name":"Home","latitude":40.7128,"longitude":-74.006,"radius":100,"category":"home"},
+		{"name":"Office","latitude":40.758,"longitude":-73.9855,"radius":150,"category":"work"}
+	]`
+	v1PlacesCSV = "id,name,address,latitude,longitude,radius,category\n" +
+		"1,Gym,,40.73,-73.99,80,gym\n" +
+		"2,Nowhere,,0,0,80,other\n"
+)
+
+func TestDecodeExportVersions(t *testing.T) {
+	var stampedJSON bytes.Buffer
+	writeJSONExportStart(context.Background(), &stampedJSON, models.ExportTypePlaces)
+	stampedJSON.WriteString(`{"name":"Home"}`)
+	writeJSONExportEnd(&stampedJSON)
+
+	var stampedCSV bytes.Buffer
+	writeExportStamp(context.Background(), &stampedCSV, models.ExportTypePlaces)
+	stampedCSV.WriteString("id,name\n1,Home\n")
+
+	tests := []struct {
+		name     string
+		data     string
+		format   string
+		source   int
+		migrated bool
+		records  int
+		reason   string // empty when the file decodes
+	}{
+		{"version 1 JSON", v1PlacesJSON, "json", 1, true, 2, ""},
+		{"version 1 CSV", v1PlacesCSV, "csv", 1, true, 2, ""},
+		{"stamped JSON", stampedJSON.String(), "json", 2, false, 1, ""},
+		{"stamped CSV", stampedCSV.String(), "csv", 2, false, 1, ""},
+		{"stamped JSON without records", `{"schemaVersion":2,"type":"places"}`, "json", 2, false, 0, ""},
+		{"from a newer release", `{"schemaVersion":3,"appVersion":"9.0.0","type":"places","records":[]}`, "json", 0, false, 0,
+			"export schema version 3 (app 9.0.0) is newer than this server supports (2); update the server to import it"},
+		{"newer CSV", "# ftrack-export schemaVersion=7 appVersion=9.0.0 type=places\nid,name\n", "csv", 0, false, 0,
+			"export schema version 7 (app 9.0.0) is newer than this server supports (2); update the server to import it"},
+		{"without a version", `{"type":"places","records":[]}`, "json", 0, false, 0, "export has no valid schema version"},
+		{"unreadable stamp", "# ftrack-export schemaVersion=two\nid,name\n", "csv", 0, false, 0, "export has no valid schema version"},
+		{"of another type", `{"schemaVersion":2,"type":"messages","records":[]}`, "json", 0, false, 0, "file is a messages export, not places"},
+		{"not JSON", `{"schemaVersion":`, "json", 0, false, 0, "file is not a valid JSON export"},
+		{"empty", "  \n", "json", 0, false, 0, "file is empty"},
+		{"text", "hello", "txt", 0, false, 0, "txt files can't be imported"},
+	}
+
+	for _, tt := range tests {
+		export, err := decodeExport([]byte(tt.data), tt.format, models.ExportTypePlaces)
+		if tt.reason != "" {
+			if reason := utils.ValidationFailureReason(err); reason != tt.reason {
+				t.Errorf("%s: error = %v (%q), want %q", tt.name, err, reason, tt.reason)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: decodeExport: %v", tt.name, err)
+			continue
+		}
+
+		if export.SourceVersion != tt.source || export.Migrated != tt.migrated || export.Header.SchemaVersion != models.ExportSchemaVersionLatest {
+			t.Errorf("%s: source %d, migrated %v, now %d; want %d, %v, latest", tt.name,
+				export.SourceVersion, export.Migrated, export.Header.SchemaVersion, tt.source, tt.migrated)
+		}
+		if count, err := export.RecordCount(); err != nil || count != tt.records {
+			t.Errorf("%s: RecordCount = %d, %v; want %d", tt.name, count, err, tt.records)
+		}
+	}
+}
+
+func TestExportStampRoundTrip(t *testing.T) {
+	header := models.NewExportHeader(models.ExportTypeMessages)
+	parsed, err := parseExportStamp(header.Stamp() + "\n")
+	if err != nil {
+		t.Fatalf("parseExportStamp: %v", err)
+	}
+	if parsed.SchemaVersion != header.SchemaVersion || parsed.AppVersion != header.AppVersion ||
+		parsed.Type != header.Type || !parsed.ExportedAt.Equal(header.ExportedAt.Truncate(1e9)) {
+		t.Errorf("stamp read back as %+v, want %+v", parsed, header)
+	}
+
+	var buf bytes.Buffer
+	writeJSONExportStart(context.Background(), &buf, models.ExportTypeMessages)
+	writeJSONExportEnd(&buf)
+	var file map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &file); err != nil {
+		t.Fatalf("empty JSON export %s: %v", buf.String(), err)
+	}
+	if file["schemaVersion"] != float64(models.ExportSchemaVersionLatest) || file["appVersion"] != models.AppVersion {
+		t.Errorf("JSON export header = %v, want the current versions", file)
+	}
+}
+
+func TestImportFormat(t *testing.T) {
+	tests := []struct {
+		contentType, filename, want string
+	}{
+		{"application/json", "export", "json"},
+		{"text/csv; charset=utf-8", "export", "csv"},
+		{"text/plain", "export.csv", "txt"},
+		{"application/octet-stream", "export.JSON", "json"},
+		{"", "export.csv", "csv"},
+		{"application/pdf", "export.pdf", ""},
+	}
+	for _, tt := range tests {
+		if got := importFormat(tt.contentType, tt.filename); got != tt.want {
+			t.Errorf("importFormat(%q, %q) = %q, want %q", tt.contentType, tt.filename, got, tt.want)
+		}
+	}
+}
+
+func TestPlaceServiceImportPriorVersion(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ps := NewPlaceService(env.Repos.Place, env.Repos.Circle, nil)
+	ctx := context.Background()
+	userID := env.Factory.User().ID.Hex()
+
+	result, err := ps.ImportPlaces(ctx, userID, strings.NewReader(v1PlacesJSON), "application/json", "places.json")
+	if err != nil {
+		t.Fatalf("ImportPlaces: %v", err)
+	}
+	if result.SchemaVersion != models.ExportSchemaVersion1 || !result.Migrated || result.Total != 2 || result.Imported != 2 || result.Failed != 0 {
+		t.Errorf("version 1 JSON import = %+v, want both places imported after migrating", result)
+	}
+	places, _, err := env.Repos.Place.GetUserPlaces(ctx, userID, models.GetPlacesRequest{})
+	if err != nil || len(places) != 2 {
+		t.Fatalf("places after import = %d, %v; want 2", len(places), err)
+	}
+
+	// Invalid records fail on their own
+	result, err = ps.ImportPlaces(ctx, userID, strings.NewReader(v1PlacesCSV), "text/csv", "places.csv")
+	if err != nil {
+		t.Fatalf("ImportPlaces: %v", err)
+	}
+	if result.Imported != 1 || result.Failed != 1 || len(result.Errors) != 1 || !strings.HasPrefix(result.Errors[0], "record 2 (Nowhere)") {
+		t.Errorf("version 1 CSV import = %+v, want Gym imported and Nowhere failed", result)
+	}
+
+	// Importing the same file again doesn't duplicate places
+	result, err = ps.ImportPlaces(ctx, userID, strings.NewReader(v1PlacesJSON), "application/json", "places.json")
+	if err != nil {
+		t.Fatalf("ImportPlaces: %v", err)
+	}
+	if result.Imported != 0 || result.Failed != 2 {
+		t.Errorf("second import = %+v, want both skipped", result)
+	}
+
+	// Files from a newer release are rejected before anything is created
+	newer := `{"schemaVersion":99,"appVersion":"9.0.0","type":"places","records":[{"name":"Future"}]}`
+	if _, err := ps.ImportPlaces(ctx, userID, strings.NewReader(newer), "application/json", "places.json"); err == nil || err.Error() != "validation failed" {
+		t.Errorf("newer import error = %v, want validation failed", err)
+	}
+	if _, err := ps.ImportPlaces(ctx, userID, strings.NewReader(v1PlacesJSON), "application/pdf", "places.pdf"); err == nil || err.Error() != "invalid file format" {
+		t.Errorf("PDF import error = %v, want invalid file format", err)
+	}
+}
@@ -0,0 +1,541 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ftrack/models"
+	"ftrack/repositories"
+	"ftrack/utils"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// DefaultExportDir is where export files are written until they expire
+const DefaultExportDir = "./uploads/exports"
+
+// PDFExportConfig controls how PDF exports are rendered
+type PDFExportConfig struct {
+	// Output is split into another file once a file reaches this many pages
+	PagesPerFile int
+
+	// UTF-8 TrueType fonts. Without FontPath the built-in Helvetica is used,
+	// which only covers Latin-1. FallbackFontPath is used for characters the
+	// main font has no glyph for, such as emoji.
+	FontPath         string
+	FallbackFontPath string
+}
+
+var DefaultPDFExportConfig = PDFExportConfig{
+	PagesPerFile: 500,
+}
+
+// ExportWriter streams the export content to w. Implementations should
+// check ctx between batches and report progress (0-100) as they go; it
+// returns the number of records written.
+type ExportWriter func(ctx context.Context, w io.Writer, progress func(percent int)) (int, error)
+
+// PartedExportWriter is an ExportWriter that may split its output across
+// several files. Each call to nextPart closes the previous file and
+// returns a writer for the next one.
+type PartedExportWriter func(ctx context.Context, nextPart func() (io.Writer, error), progress func(percent int)) (int, error)
+
+// ExportService runs background export jobs and tracks them so they can
+// be cancelled while pending or processing.
+type ExportService struct {
+	exportRepo *repositories.ExportRepository
+	exportDir  string
+	pdfConfig  PDFExportConfig
+	validator  *utils.ValidationService
+
+	// Set by ConfigureCirclePermissions
+	circleRepo *repositories.CircleRepository
+
+	jobs  map[string]context.CancelFunc
+	mutex sync.Mutex
+}
+
+func NewExportService(exportRepo *repositories.ExportRepository, exportDir string) *ExportService {
+	// Ensure export directory exists
+	os.MkdirAll(exportDir, 0755)
+
+	return &ExportService{
+		exportRepo: exportRepo,
+		exportDir:  exportDir,
+		pdfConfig:  DefaultPDFExportConfig,
+		validator:  utils.NewValidationService(),
+		jobs:       make(map[string]context.CancelFunc),
+	}
+}
+
+// ConfigurePDF sets the page limit and fonts used for PDF exports
+func (es *ExportService) ConfigurePDF(config PDFExportConfig) {
+	if config.PagesPerFile <= 0 {
+		config.PagesPerFile = DefaultPDFExportConfig.PagesPerFile
+	}
+	es.pdfConfig = config
+}
+
+// PDFConfig returns the PDF export settings
+func (es *ExportService) PDFConfig() PDFExportConfig {
+	return es.pdfConfig
+}
+
+// StartExport stores the export job and processes it in the background
+func (es *ExportService) StartExport(ctx context.Context, export *models.MessageExport, write ExportWriter) error {
+	return es.StartPartedExport(ctx, export, func(ctx context.Context, nextPart func() (io.Writer, error), progress func(int)) (int, error) {
+		w, err := nextPart()
+		if err != nil {
+			return 0, err
+		}
+		return write(ctx, w, progress)
+	})
+}
+
+// StartPartedExport stores the export job and processes it in the
+// background, allowing the writer to split the output into several files.
+// Exports of a circle's data must be allowed by the circle's export policy.
+func (es *ExportService) StartPartedExport(ctx context.Context, export *models.MessageExport, write PartedExportWriter) error {
+	var grant *models.ExportPermissionRequest
+	if !export.CircleID.IsZero() {
+		var err error
+		if grant, err = es.authorizeCircleExport(ctx, export.UserID.Hex(), export.CircleID); err != nil {
+			return err
+		}
+	}
+
+	export.Status = models.ExportStatusPending
+	export.Progress = 0
+	export.SchemaVersion = models.ExportSchemaVersionLatest
+	export.AppVersion = models.AppVersion
+
+	if err := es.exportRepo.Create(ctx, export); err != nil {
+		logrus.Errorf("Failed to create export job: %v", err)
+		return err
+	}
+
+	if !export.CircleID.IsZero() && es.circleRepo != nil {
+		es.startedCircleExport(ctx, export, grant)
+	}
+
+	exportID := export.ID.Hex()
+	jobCtx, cancel := context.WithCancel(withExportWatermark(context.Background(), exportWatermark(export)))
+
+	es.mutex.Lock()
+	es.jobs[exportID] = cancel
+	es.mutex.Unlock()
+
+	go es.runExport(jobCtx, exportID, export.Format, write)
+
+	return nil
+}
+
+func (es *ExportService) GetExportStatus(ctx context.Context, userID, exportID string) (*models.ExportStatusResponse, error) {
+	export, err := es.getUserExport(ctx, userID, exportID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.ExportStatusResponse{
+		ExportID:      exportID,
+		Type:          export.Type,
+		Status:        export.Status,
+		Progress:      export.Progress,
+		FileURL:       export.FileURL,
+		FileSize:      export.FileSize,
+		PartCount:     export.PartCount,
+		MessageCount:  export.MessageCount,
+		SchemaVersion: export.SchemaVersion,
+		AppVersion:    export.AppVersion,
+		Error:         export.ErrorMsg,
+		CreatedAt:     export.CreatedAt,
+		CompletedAt:   export.CompletedAt,
+		CancelledAt:   export.CancelledAt,
+		ExpiresAt:     export.ExpiresAt,
+	}, nil
+}
+
+// CancelExport marks a pending or processing export as cancelled and
+// stops its background job
+func (es *ExportService) CancelExport(ctx context.Context, userID, exportID string) (*models.ExportStatusResponse, error) {
+	export, err := es.getUserExport(ctx, userID, exportID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !export.IsActive() {
+		return nil, errors.New("export cannot be cancelled")
+	}
+
+	updated, err := es.exportRepo.UpdateActiveExport(ctx, exportID, bson.M{
+		"status":      models.ExportStatusCancelled,
+		"cancelledAt": time.Now(),
+	})
+	if err != nil {
+		logrus.Errorf("Failed to cancel export %s: %v", exportID, err)
+		return nil, err
+	}
+	if !updated {
+		// Finished or failed between the read and the update
+		return nil, errors.New("export cannot be cancelled")
+	}
+
+	// A job running in this process removes its own partial file once it
+	// sees the cancellation; otherwise clean up here
+	if !es.cancelJob(exportID) {
+		es.removeExportFiles(export.Files())
+	}
+
+	return es.GetExportStatus(ctx, userID, exportID)
+}
+
+// ReadExport returns a completed export and its file contents
+func (es *ExportService) ReadExport(ctx context.Context, userID, exportID string) (*models.MessageExport, []byte, error) {
+	return es.ReadExportPart(ctx, userID, exportID, 1)
+}
+
+// ReadExportPart returns a completed export and the contents of one of its
+// files, numbered from 1
+func (es *ExportService) ReadExportPart(ctx context.Context, userID, exportID string, part int) (*models.MessageExport, []byte, error) {
+	export, err := es.getUserExport(ctx, userID, exportID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if export.Status != models.ExportStatusCompleted {
+		return nil, nil, errors.New("export not ready")
+	}
+
+	files := export.Files()
+	if part < 1 || part > len(files) {
+		return nil, nil, errors.New("export part not found")
+	}
+
+	data, err := os.ReadFile(files[part-1])
+	if err != nil {
+		logrus.Errorf("Failed to read export file %s: %v", files[part-1], err)
+		return nil, nil, errors.New("export file not found")
+	}
+
+	return export, data, nil
+}
+
+// DownloadExportManifest returns the manifest file of a completed export
+func (es *ExportService) DownloadExportManifest(ctx context.Context, userID, exportID string) (*models.ExportDownload, error) {
+	export, err := es.getUserExport(ctx, userID, exportID)
+	if err != nil {
+		return nil, err
+	}
+
+	if export.Status != models.ExportStatusCompleted {
+		return nil, errors.New("export not ready")
+	}
+	if export.ManifestPath == "" {
+		// Finished before exports had manifests
+		return nil, errors.New("export manifest not found")
+	}
+
+	data, err := os.ReadFile(export.ManifestPath)
+	if err != nil {
+		logrus.Errorf("Failed to read export manifest %s: %v", export.ManifestPath, err)
+		return nil, errors.New("export manifest not found")
+	}
+
+	return &models.ExportDownload{
+		Filename:    fmt.Sprintf("%s_export_%s_manifest.json", export.Type, exportID),
+		ContentType: "application/json",
+		Data:        data,
+	}, nil
+}
+
+// FailStuckExports fails exports that have not reported progress within
+// the timeout and removes their partial files
+func (es *ExportService) FailStuckExports(ctx context.Context, timeout time.Duration) (int, error) {
+	exports, err := es.exportRepo.GetStuckExports(ctx, time.Now().Add(-timeout))
+	if err != nil {
+		return 0, err
+	}
+
+	failed := 0
+	for _, export := range exports {
+		exportID := export.ID.Hex()
+
+		updated, err := es.exportRepo.UpdateActiveExport(ctx, exportID, bson.M{
+			"status":   models.ExportStatusFailed,
+			"errorMsg": "export timed out",
+		})
+		if err != nil {
+			logrus.Errorf("Failed to fail stuck export %s: %v", exportID, err)
+			continue
+		}
+		if !updated {
+			continue
+		}
+
+		es.cancelJob(exportID)
+		es.removeExportFiles(export.Files())
+		failed++
+	}
+
+	return failed, nil
+}
+
+func (es *ExportService) runExport(ctx context.Context, exportID, format string, write PartedExportWriter) {
+	defer es.releaseJob(exportID)
+
+	filePath := es.partPath(exportID, format, 1)
+
+	updated, err := es.exportRepo.UpdateActiveExport(ctx, exportID, bson.M{
+		"status":   models.ExportStatusProcessing,
+		"filePath": filePath,
+	})
+	if err != nil || !updated {
+		// Cancelled before processing started
+		return
+	}
+
+	logrus.Infof("Starting export process for ID: %s", exportID)
+
+	var (
+		filePaths []string
+		file      *os.File
+	)
+	nextPart := func() (io.Writer, error) {
+		if file != nil {
+			if err := file.Close(); err != nil {
+				return nil, err
+			}
+			file = nil
+		}
+
+		path := es.partPath(exportID, format, len(filePaths)+1)
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		file = f
+		filePaths = append(filePaths, path)
+
+		// Record every part as it is created so a cancelled or stuck job
+		// can clean up all of them
+		if len(filePaths) > 1 {
+			es.exportRepo.UpdateActiveExport(ctx, exportID, bson.M{"filePaths": filePaths})
+		}
+		return f, nil
+	}
+
+	count, err := write(ctx, nextPart, func(percent int) {
+		// Progress updates double as a heartbeat for the stuck export janitor.
+		// If the job was cancelled or failed elsewhere, stop processing.
+		updated, err := es.exportRepo.UpdateActiveExport(ctx, exportID, bson.M{"progress": percent})
+		if err == nil && !updated {
+			es.cancelJob(exportID)
+		}
+	})
+	var closeErr error
+	if file != nil {
+		closeErr = file.Close()
+	}
+
+	if ctx.Err() != nil {
+		logrus.Infof("Export %s cancelled, removing partial files", exportID)
+		es.removeExportFiles(filePaths)
+		return
+	}
+
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil && len(filePaths) == 0 {
+		err = errors.New("export wrote no files")
+	}
+	if err != nil {
+		es.failExport(exportID, filePaths, err)
+		return
+	}
+
+	export, err := es.exportRepo.GetByID(context.Background(), exportID)
+	if err != nil {
+		es.failExport(exportID, filePaths, err)
+		return
+	}
+
+	watermark := exportWatermark(export)
+	manifest := models.ExportManifest{
+		ExportHeader: models.ExportHeader{
+			SchemaVersion: export.SchemaVersion,
+			AppVersion:    export.AppVersion,
+			Type:          export.Type,
+			ExportedAt:    time.Now(),
+			Watermark:     &watermark,
+		},
+		ExportID:    exportID,
+		Format:      format,
+		RecordCount: count,
+	}
+	manifest.SearchCriteria = export.SearchCriteria
+
+	var fileSize int64
+	for i, path := range filePaths {
+		file := models.ExportManifestFile{Name: filepath.Base(path), Part: i + 1}
+		if info, err := os.Stat(path); err == nil {
+			file.Size = info.Size()
+			fileSize += info.Size()
+		}
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	manifestPath := es.manifestPath(exportID)
+	if err := writeExportManifest(manifestPath, manifest); err != nil {
+		es.failExport(exportID, filePaths, err)
+		return
+	}
+
+	updated, err = es.exportRepo.UpdateActiveExport(context.Background(), exportID, bson.M{
+		"status":       models.ExportStatusCompleted,
+		"progress":     100,
+		"filePaths":    filePaths,
+		"partCount":    len(filePaths),
+		"fileSize":     fileSize,
+		"messageCount": count,
+		"manifestPath": manifestPath,
+		"completedAt":  time.Now(),
+	})
+	if err != nil {
+		logrus.Errorf("Failed to complete export %s: %v", exportID, err)
+		return
+	}
+	if !updated {
+		// Cancelled after the last batch was written
+		es.removeExportFiles(append(filePaths, manifestPath))
+		return
+	}
+
+	logrus.Infof("Export %s completed with %d records in %d files", exportID, count, len(filePaths))
+}
+
+type exportWatermarkKey struct{}
+
+// exportWatermark identifies the export and who asked for it
+func exportWatermark(export *models.MessageExport) models.ExportWatermark {
+	return models.ExportWatermark{
+		ExportID:    export.ID.Hex(),
+		RequestedBy: export.UserID.Hex(),
+		RequestedAt: export.CreatedAt,
+	}
+}
+
+// withExportWatermark returns a context that carries the watermark to the
+// export's writer
+func withExportWatermark(ctx context.Context, watermark models.ExportWatermark) context.Context {
+	return context.WithValue(ctx, exportWatermarkKey{}, watermark)
+}
+
+// watermarkFromContext returns the watermark of the export job running
+// with the context
+func watermarkFromContext(ctx context.Context) (models.ExportWatermark, bool) {
+	watermark, ok := ctx.Value(exportWatermarkKey{}).(models.ExportWatermark)
+	return watermark, ok
+}
+
+// exportHeader is the header of an export of the type, watermarked when
+// written by an export job
+func exportHeader(ctx context.Context, exportType string) models.ExportHeader {
+	header := models.NewExportHeader(exportType)
+	if watermark, ok := watermarkFromContext(ctx); ok {
+		header.Watermark = &watermark
+	}
+	return header
+}
+
+// partPath returns the path of an export file. The first part keeps the
+// plain name so single-file exports are unchanged.
+func (es *ExportService) partPath(exportID, format string, part int) string {
+	if part == 1 {
+		return filepath.Join(es.exportDir, fmt.Sprintf("%s.%s", exportID, format))
+	}
+	return filepath.Join(es.exportDir, fmt.Sprintf("%s_part%d.%s", exportID, part, format))
+}
+
+func (es *ExportService) manifestPath(exportID string) string {
+	return filepath.Join(es.exportDir, exportID+"_manifest.json")
+}
+
+func writeExportManifest(path string, manifest models.ExportManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func (es *ExportService) failExport(exportID string, filePaths []string, cause error) {
+	logrus.Errorf("Export %s failed: %v", exportID, cause)
+
+	es.removeExportFiles(filePaths)
+
+	_, err := es.exportRepo.UpdateActiveExport(context.Background(), exportID, bson.M{
+		"status":   models.ExportStatusFailed,
+		"errorMsg": "export processing failed",
+	})
+	if err != nil {
+		logrus.Errorf("Failed to mark export %s as failed: %v", exportID, err)
+	}
+}
+
+func (es *ExportService) getUserExport(ctx context.Context, userID, exportID string) (*models.MessageExport, error) {
+	export, err := es.exportRepo.GetByID(ctx, exportID)
+	if err != nil {
+		return nil, err
+	}
+
+	if export.UserID.Hex() != userID {
+		return nil, errors.New("access denied")
+	}
+
+	return export, nil
+}
+
+// cancelJob signals a job running in this process and reports whether one
+// was found
+func (es *ExportService) cancelJob(exportID string) bool {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+
+	cancel, exists := es.jobs[exportID]
+	if !exists {
+		return false
+	}
+
+	cancel()
+	return true
+}
+
+func (es *ExportService) releaseJob(exportID string) {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+
+	if cancel, exists := es.jobs[exportID]; exists {
+		cancel()
+		delete(es.jobs, exportID)
+	}
+}
+
+func (es *ExportService) removeExportFiles(filePaths []string) {
+	for _, filePath := range filePaths {
+		if filePath == "" {
+			continue
+		}
+
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			logrus.Errorf("Failed to remove export file %s: %v", filePath, err)
+		}
+	}
+}
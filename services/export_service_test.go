@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"ftrack/models"
+	"ftrack/testharness"
+)
+
+func TestExportServiceCancelMidProcessing(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	es := NewExportService(env.Repos.Export, t.TempDir())
+	ctx := context.Background()
+
+	owner, stranger := env.Factory.User(), env.Factory.User()
+
+	started := make(chan string, 1)
+	stopped := make(chan struct{})
+	export := &models.MessageExport{
+		UserID: owner.ID,
+		Type:   models.ExportTypeMessages,
+		Format: "json",
+	}
+	err := es.StartPartedExport(ctx, export, func(ctx context.Context, nextPart func() (io.Writer, error), progress func(int)) (int, error) {
+		defer close(stopped)
+
+		w, err := nextPart()
+		if err != nil {
+			return 0, err
+		}
+		io.WriteString(w, `{"messages":[`)
+		progress(10)
+		started <- es.partPath(export.ID.Hex(), "json", 1)
+
+		// Keep writing batches until the job is cancelled
+		<-ctx.Done()
+		return 1, ctx.Err()
+	})
+	if err != nil {
+		t.Fatalf("StartPartedExport: %v", err)
+	}
+
+	var partPath string
+	select {
+	case partPath = <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("export never started processing")
+	}
+	exportID := export.ID.Hex()
+
+	status, err := es.GetExportStatus(ctx, owner.ID.Hex(), exportID)
+	if err != nil {
+		t.Fatalf("GetExportStatus: %v", err)
+	}
+	if status.Status != models.ExportStatusProcessing || status.Progress != 10 {
+		t.Errorf("status before cancel = %s at %d%%, want processing at 10%%", status.Status, status.Progress)
+	}
+
+	if _, err := es.CancelExport(ctx, stranger.ID.Hex(), exportID); err == nil || err.Error() != "access denied" {
+		t.Errorf("CancelExport by a stranger error = %v, want access denied", err)
+	}
+
+	status, err = es.CancelExport(ctx, owner.ID.Hex(), exportID)
+	if err != nil {
+		t.Fatalf("CancelExport: %v", err)
+	}
+	if status.Status != models.ExportStatusCancelled || status.CancelledAt == nil {
+		t.Errorf("status after cancel = %s (cancelledAt %v), want cancelled", status.Status, status.CancelledAt)
+	}
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("export writer was not signalled to stop")
+	}
+
+	// The job removes its partial file once it sees the cancellation
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(partPath); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("partial file %s was not removed", partPath)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if _, err := es.CancelExport(ctx, owner.ID.Hex(), exportID); err == nil || err.Error() != "export cannot be cancelled" {
+		t.Errorf("second CancelExport error = %v, want export cannot be cancelled", err)
+	}
+	status, err = es.GetExportStatus(ctx, owner.ID.Hex(), exportID)
+	if err != nil {
+		t.Fatalf("GetExportStatus after cancel: %v", err)
+	}
+	if status.Status != models.ExportStatusCancelled {
+		t.Errorf("status after the job stopped = %s, want cancelled", status.Status)
+	}
+}
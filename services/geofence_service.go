@@ -16,6 +16,7 @@ type GeofenceService struct {
 	placeRepo    *repositories.PlaceRepository
 	locationRepo *repositories.LocationRepository
 	websocketHub WebSocketHub // Use interface instead of concrete type
+	circleRepo   *repositories.CircleRepository
 }
 
 func NewGeofenceService(
@@ -30,6 +31,12 @@ func NewGeofenceService(
 	}
 }
 
+// ConfigureCircleDefaults lets place events use the geofence defaults of
+// the place's circle for settings the place inherits
+func (gs *GeofenceService) ConfigureCircleDefaults(circleRepo *repositories.CircleRepository) {
+	gs.circleRepo = circleRepo
+}
+
 func (gs *GeofenceService) CheckGeofences(ctx context.Context, userID string, lat, lon float64) ([]models.WSPlaceEvent, error) {
 	// Get user places within a reasonable radius (e.g., 5km)
 	places, err := gs.placeRepo.GetPlacesInRadius(ctx, lat, lon, 5000)
@@ -110,6 +117,15 @@ func (gs *GeofenceService) handlePlaceEvent(ctx context.Context, userID string,
 		EventType: eventType,
 	}
 
+	if gs.circleRepo != nil && !place.CircleID.IsZero() {
+		circle, err := gs.circleRepo.GetByID(ctx, place.CircleID.Hex())
+		if err != nil {
+			logrus.Warnf("Failed to get geofence defaults of circle %s: %v", place.CircleID.Hex(), err)
+		} else {
+			place = ApplyPlaceSettings(place, circle.GeofenceDefaults)
+		}
+	}
+
 	// Check if notifications are enabled for this event type
 	shouldNotify := (eventType == "arrival" && place.Notifications.OnArrival) ||
 		(eventType == "departure" && place.Notifications.OnDeparture)
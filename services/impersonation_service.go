@@ -0,0 +1,545 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"ftrack/models"
+	"ftrack/repositories"
+	"ftrack/utils"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// Sessions closed per cleanup pass
+	impersonationCleanupBatch = 100
+	// Sessions listed to the user
+	impersonationHistoryLimit = 20
+)
+
+// ImpersonationService lets support admins see the app as a user does,
+// with the user's consent. Consent is given by the user in the app, or
+// documented by support and approved by a second, senior admin. The admin
+// then gets a token good for ImpersonationSessionTTL; what is done with it is
+// recorded in the user's audit log, and the user gets a summary afterwards.
+type ImpersonationService struct {
+	impersonationRepo   *repositories.ImpersonationRepository
+	userRepo            *repositories.UserRepository
+	auditLogRepo        *repositories.AuditLogRepository
+	notificationService *NotificationService
+	jwtService          *utils.JWTService
+	validator           *utils.ValidationService
+}
+
+func NewImpersonationService(
+	impersonationRepo *repositories.ImpersonationRepository,
+	userRepo *repositories.UserRepository,
+	auditLogRepo *repositories.AuditLogRepository,
+	notificationService *NotificationService,
+	jwtService *utils.JWTService,
+) *ImpersonationService {
+	return &ImpersonationService{
+		impersonationRepo:   impersonationRepo,
+		userRepo:            userRepo,
+		auditLogRepo:        auditLogRepo,
+		notificationService: notificationService,
+		jwtService:          jwtService,
+		validator:           utils.NewValidationService(),
+	}
+}
+
+// RequestImpersonation opens a session waiting for consent. With user
+// consent the user is asked in the app; with documented consent another
+// admin has to approve.
+func (is *ImpersonationService) RequestImpersonation(ctx context.Context, adminID string, req models.CreateImpersonationRequest) (*models.ImpersonationSession, error) {
+	if validationErrors := is.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+	if req.ConsentMethod == models.ImpersonationConsentDocumented && strings.TrimSpace(req.ConsentReference) == "" {
+		return nil, utils.NewValidationFailedError("consentReference is required for documented consent")
+	}
+	if req.UserID == adminID {
+		return nil, utils.NewValidationFailedError("you can't impersonate yourself")
+	}
+
+	adminObjectID, err := primitive.ObjectIDFromHex(adminID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	user, err := is.userRepo.GetByID(ctx, req.UserID)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+	if isStaffRole(user.Role) {
+		return nil, errors.New("staff accounts can't be impersonated")
+	}
+
+	open, err := is.impersonationRepo.HasOpenSession(ctx, adminObjectID, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if open {
+		return nil, errors.New("impersonation already requested")
+	}
+
+	session := &models.ImpersonationSession{
+		AdminID:          adminObjectID,
+		UserID:           user.ID,
+		Reason:           req.Reason,
+		WritesRequested:  req.AllowWrites,
+		ConsentMethod:    req.ConsentMethod,
+		ConsentReference: strings.TrimSpace(req.ConsentReference),
+		Status:           models.ImpersonationStatusPending,
+		ExpiresAt:        time.Now().Add(models.ImpersonationRequestTTL),
+	}
+	if err := is.impersonationRepo.Create(ctx, session); err != nil {
+		return nil, err
+	}
+
+	is.audit(ctx, session, models.AuditEventImpersonationRequested, "Support requested to view the account", "warning", map[string]interface{}{
+		"reason":          session.Reason,
+		"consentMethod":   session.ConsentMethod,
+		"writesRequested": session.WritesRequested,
+	})
+
+	if session.ConsentMethod == models.ImpersonationConsentUser {
+		is.askForConsent(ctx, session)
+	}
+
+	return session, nil
+}
+
+// RespondToImpersonation is the user approving or denying a request for
+// their account
+func (is *ImpersonationService) RespondToImpersonation(ctx context.Context, userID, sessionID string, approve bool, req models.ApproveImpersonationRequest) (*models.ImpersonationSession, error) {
+	session, err := is.impersonationRepo.GetByID(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	// Sessions on other accounts don't exist as far as the user is concerned
+	if session.UserID.Hex() != userID {
+		return nil, errors.New("impersonation session not found")
+	}
+	if session.ConsentMethod != models.ImpersonationConsentUser {
+		return nil, errors.New("access denied")
+	}
+
+	if !approve {
+		return is.deny(ctx, session)
+	}
+	return is.approve(ctx, session, session.UserID, req)
+}
+
+// ApproveImpersonation is a second admin approving a session on documented
+// consent. Only superadmins other than the requester can.
+func (is *ImpersonationService) ApproveImpersonation(ctx context.Context, approverID, approverRole, sessionID string, req models.ApproveImpersonationRequest) (*models.ImpersonationSession, error) {
+	session, err := is.impersonationRepo.GetByID(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if session.ConsentMethod != models.ImpersonationConsentDocumented {
+		return nil, errors.New("impersonation needs the user's approval")
+	}
+	if approverRole != "superadmin" || session.AdminID.Hex() == approverID {
+		return nil, errors.New("access denied")
+	}
+
+	approverObjectID, err := primitive.ObjectIDFromHex(approverID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+	return is.approve(ctx, session, approverObjectID, req)
+}
+
+func (is *ImpersonationService) approve(ctx context.Context, session *models.ImpersonationSession, approverID primitive.ObjectID, req models.ApproveImpersonationRequest) (*models.ImpersonationSession, error) {
+	if err := checkPending(session); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session.AllowWrites = session.WritesRequested && req.AllowWrites
+	session.ApprovedBy = &approverID
+	session.ApprovedAt = &now
+	session.ExpiresAt = now.Add(models.ImpersonationRequestTTL)
+
+	moved, err := is.impersonationRepo.Transition(ctx, session.ID, models.ImpersonationStatusPending, models.ImpersonationStatusApproved, bson.M{
+		"allowWrites": session.AllowWrites,
+		"approvedBy":  approverID,
+		"approvedAt":  now,
+		"expiresAt":   session.ExpiresAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !moved {
+		return nil, errors.New("impersonation request already answered")
+	}
+	session.Status = models.ImpersonationStatusApproved
+
+	is.audit(ctx, session, models.AuditEventImpersonationApproved, "Support access to the account approved", "warning", map[string]interface{}{
+		"approvedBy":  approverID.Hex(),
+		"allowWrites": session.AllowWrites,
+	})
+	return session, nil
+}
+
+func (is *ImpersonationService) deny(ctx context.Context, session *models.ImpersonationSession) (*models.ImpersonationSession, error) {
+	if err := checkPending(session); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	moved, err := is.impersonationRepo.Transition(ctx, session.ID, models.ImpersonationStatusPending, models.ImpersonationStatusDenied, bson.M{
+		"endedAt": now,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !moved {
+		return nil, errors.New("impersonation request already answered")
+	}
+	session.Status = models.ImpersonationStatusDenied
+	session.EndedAt = &now
+
+	is.audit(ctx, session, models.AuditEventImpersonationDenied, "Support access to the account denied", "info", nil)
+	return session, nil
+}
+
+func checkPending(session *models.ImpersonationSession) error {
+	if session.Status != models.ImpersonationStatusPending {
+		return errors.New("impersonation request already answered")
+	}
+	if time.Now().After(session.ExpiresAt) {
+		return errors.New("impersonation request expired")
+	}
+	return nil
+}
+
+// StartImpersonation issues the requesting admin a token for an approved
+// session. The token is only handed out once.
+func (is *ImpersonationService) StartImpersonation(ctx context.Context, adminID, sessionID string) (*models.ImpersonationToken, error) {
+	session, err := is.impersonationRepo.GetByID(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if session.AdminID.Hex() != adminID {
+		return nil, errors.New("access denied")
+	}
+	if session.Status != models.ImpersonationStatusApproved {
+		return nil, errors.New("impersonation not approved")
+	}
+	if time.Now().After(session.ExpiresAt) {
+		return nil, errors.New("impersonation request expired")
+	}
+
+	user, err := is.userRepo.GetByID(ctx, session.UserID.Hex())
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(models.ImpersonationSessionTTL)
+	moved, err := is.impersonationRepo.Transition(ctx, session.ID, models.ImpersonationStatusApproved, models.ImpersonationStatusActive, bson.M{
+		"startedAt": now,
+		"expiresAt": expiresAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !moved {
+		return nil, errors.New("impersonation not approved")
+	}
+	session.Status = models.ImpersonationStatusActive
+	session.StartedAt = &now
+	session.ExpiresAt = expiresAt
+
+	token, err := is.jwtService.GenerateImpersonationToken(user.ID.Hex(), user.Email, "user", adminID, session.ID.Hex(), models.ImpersonationSessionTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	is.audit(ctx, session, models.AuditEventImpersonationStarted, "Support started viewing the account", "warning", map[string]interface{}{
+		"allowWrites": session.AllowWrites,
+		"expiresAt":   expiresAt,
+	})
+
+	return &models.ImpersonationToken{
+		Session:     session,
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(models.ImpersonationSessionTTL.Seconds()),
+		ExpiresAt:   expiresAt,
+	}, nil
+}
+
+// EndImpersonation ends the admin's session before it runs out. Its token
+// stops working right away.
+func (is *ImpersonationService) EndImpersonation(ctx context.Context, adminID, sessionID string) (*models.ImpersonationSession, error) {
+	session, err := is.impersonationRepo.GetByID(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if session.AdminID.Hex() != adminID {
+		return nil, errors.New("access denied")
+	}
+
+	ended, err := is.close(ctx, session, models.ImpersonationStatusEnded)
+	if err != nil {
+		return nil, err
+	}
+	if !ended {
+		return nil, errors.New("impersonation already ended")
+	}
+	return session, nil
+}
+
+func (is *ImpersonationService) GetSession(ctx context.Context, sessionID string) (*models.ImpersonationSession, error) {
+	return is.impersonationRepo.GetByID(ctx, sessionID)
+}
+
+// GetUserSessions lists the latest sessions on the user's account, so they
+// can see who asked and what came of it
+func (is *ImpersonationService) GetUserSessions(ctx context.Context, userID string) ([]models.ImpersonationSession, error) {
+	return is.impersonationRepo.GetUserSessions(ctx, userID, impersonationHistoryLimit)
+}
+
+// CloseExpiredSessions ends sessions whose token ran out and expires
+// requests that were never answered or started. It returns how many it
+// closed.
+func (is *ImpersonationService) CloseExpiredSessions(ctx context.Context) (int, error) {
+	sessions, err := is.impersonationRepo.GetExpired(ctx, time.Now(), impersonationCleanupBatch)
+	if err != nil {
+		return 0, err
+	}
+
+	closed := 0
+	for i := range sessions {
+		status := models.ImpersonationStatusExpired
+		if sessions[i].Status == models.ImpersonationStatusActive {
+			status = models.ImpersonationStatusEnded
+		}
+
+		ok, err := is.close(ctx, &sessions[i], status)
+		if err != nil {
+			return closed, err
+		}
+		if ok {
+			closed++
+		}
+	}
+	return closed, nil
+}
+
+// close moves an unfinished session to the given final status. Sessions that
+// were started get their summary sent to the user.
+func (is *ImpersonationService) close(ctx context.Context, session *models.ImpersonationSession, status string) (bool, error) {
+	from := session.Status
+	switch from {
+	case models.ImpersonationStatusPending, models.ImpersonationStatusApproved, models.ImpersonationStatusActive:
+	default:
+		return false, nil
+	}
+
+	now := time.Now()
+	moved, err := is.impersonationRepo.Transition(ctx, session.ID, from, status, bson.M{"endedAt": now})
+	if err != nil || !moved {
+		return false, err
+	}
+	session.Status = status
+	session.EndedAt = &now
+
+	is.audit(ctx, session, models.AuditEventImpersonationEnded, "Support session on the account closed", "info", map[string]interface{}{
+		"status":   status,
+		"requests": session.Requests,
+	})
+
+	if session.StartedAt != nil {
+		is.sendSummary(ctx, session)
+	}
+	return true, nil
+}
+
+// CheckImpersonation returns the session an impersonation token was issued
+// for, if it is still live and matches the token
+func (is *ImpersonationService) CheckImpersonation(ctx context.Context, sessionID, adminID, userID string) (*models.ImpersonationSession, error) {
+	session, err := is.impersonationRepo.GetByID(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if session.AdminID.Hex() != adminID || session.UserID.Hex() != userID {
+		return nil, errors.New("impersonation token doesn't match its session")
+	}
+	if !session.IsLive(time.Now()) {
+		return nil, errors.New("impersonation session ended")
+	}
+	return session, nil
+}
+
+// RecordImpersonatedRequest adds a request made with the session's token to
+// the user's audit log, under both identities
+func (is *ImpersonationService) RecordImpersonatedRequest(ctx context.Context, session *models.ImpersonationSession, request models.ImpersonatedRequest) {
+	severity := "info"
+	if request.Status == 403 {
+		severity = "warning"
+	}
+
+	entry := &models.AuditLogEntry{
+		UserID:      session.UserID,
+		EventType:   models.AuditEventImpersonatedRequest,
+		Description: fmt.Sprintf("Support %s %s", request.Method, request.Route),
+		IPAddress:   request.IPAddress,
+		UserAgent:   request.UserAgent,
+		Severity:    severity,
+		Details: map[string]interface{}{
+			"impersonationId": session.ID.Hex(),
+			"impersonatorId":  session.AdminID.Hex(),
+			"method":          request.Method,
+			"route":           request.Route,
+			"path":            request.Path,
+			"status":          request.Status,
+		},
+	}
+	if err := is.auditLogRepo.Create(ctx, entry); err != nil {
+		logrus.Errorf("Failed to audit impersonated request of session %s: %v", session.ID.Hex(), err)
+	}
+
+	if err := is.impersonationRepo.IncrementRequests(ctx, session.ID); err != nil {
+		logrus.Warnf("Failed to count impersonated request of session %s: %v", session.ID.Hex(), err)
+	}
+}
+
+func (is *ImpersonationService) audit(ctx context.Context, session *models.ImpersonationSession, eventType, description, severity string, details map[string]interface{}) {
+	if details == nil {
+		details = map[string]interface{}{}
+	}
+	details["impersonationId"] = session.ID.Hex()
+	details["impersonatorId"] = session.AdminID.Hex()
+
+	entry := &models.AuditLogEntry{
+		UserID:      session.UserID,
+		EventType:   eventType,
+		Description: description,
+		Severity:    severity,
+		Details:     details,
+	}
+	if err := is.auditLogRepo.Create(ctx, entry); err != nil {
+		logrus.Errorf("Failed to audit impersonation session %s: %v", session.ID.Hex(), err)
+	}
+}
+
+// askForConsent asks the user in the app to approve a request
+func (is *ImpersonationService) askForConsent(ctx context.Context, session *models.ImpersonationSession) {
+	if is.notificationService == nil {
+		return
+	}
+
+	message := "Approve to let our support team see the app as you do for 30 minutes. They won't be able to change anything."
+	if session.WritesRequested {
+		message = "Approve to let our support team see the app as you do for 30 minutes. They also asked to be able to make changes for you."
+	}
+
+	err := is.notificationService.SendNotification(ctx, models.SendNotificationRequest{
+		Recipients: []string{session.UserID.Hex()},
+		Title:      "Support would like to view your account",
+		Message:    message,
+		Type:       models.NotificationTypeImpersonationRequest,
+		Priority:   "high",
+		Category:   "security",
+		Data: map[string]interface{}{
+			"impersonationId": session.ID.Hex(),
+			"reason":          session.Reason,
+			"writesRequested": session.WritesRequested,
+			"expiresAt":       session.ExpiresAt,
+		},
+		DeliveryChannels: []string{"push"},
+	})
+	if err != nil {
+		logrus.Errorf("Failed to ask for consent to impersonation session %s: %v", session.ID.Hex(), err)
+	}
+}
+
+// sendSummary tells the user what support looked at during the session.
+// It is sent once, however the session ended.
+func (is *ImpersonationService) sendSummary(ctx context.Context, session *models.ImpersonationSession) {
+	if is.notificationService == nil {
+		return
+	}
+
+	claimed, err := is.impersonationRepo.ClaimSummary(ctx, session.ID)
+	if err != nil || !claimed {
+		if err != nil {
+			logrus.Errorf("Failed to claim summary of impersonation session %s: %v", session.ID.Hex(), err)
+		}
+		return
+	}
+
+	access, err := is.auditLogRepo.GetImpersonationAccess(ctx, session.ID.Hex())
+	if err != nil {
+		logrus.Errorf("Failed to summarize impersonation session %s: %v", session.ID.Hex(), err)
+		access = []models.ImpersonationAccess{}
+	}
+
+	message := "Our support team's session on your account has ended. Nothing was accessed."
+	if areas := accessedAreas(access); len(areas) > 0 {
+		message = fmt.Sprintf("Our support team's session on your account has ended. They viewed: %s.", strings.Join(areas, ", "))
+	}
+
+	err = is.notificationService.SendNotification(ctx, models.SendNotificationRequest{
+		Recipients: []string{session.UserID.Hex()},
+		Title:      "Support session ended",
+		Message:    message,
+		Type:       models.NotificationTypeImpersonationSummary,
+		Priority:   "normal",
+		Category:   "security",
+		Data: map[string]interface{}{
+			"impersonationId": session.ID.Hex(),
+			"allowWrites":     session.AllowWrites,
+			"access":          access,
+		},
+		DeliveryChannels: []string{"push"},
+	})
+	if err != nil {
+		logrus.Errorf("Failed to send summary of impersonation session %s: %v", session.ID.Hex(), err)
+	}
+}
+
+// accessedAreas names the parts of the API that were accessed, e.g.
+// "places" for /api/v1/places/:placeId, in alphabetical order
+func accessedAreas(access []models.ImpersonationAccess) []string {
+	seen := make(map[string]bool)
+	areas := []string{}
+	for _, entry := range access {
+		segments := strings.Split(strings.TrimPrefix(entry.Route, "/api/v1/"), "/")
+		area := segments[0]
+		if area == "users" && len(segments) > 1 && segments[1] == "me" {
+			area = "profile"
+			if len(segments) > 2 {
+				area = segments[2]
+			}
+		}
+		area = strings.ReplaceAll(area, "-", " ")
+		if area == "" || seen[area] {
+			continue
+		}
+		seen[area] = true
+		areas = append(areas, area)
+	}
+	sort.Strings(areas)
+	return areas
+}
+
+func isStaffRole(role string) bool {
+	switch role {
+	case "moderator", "admin", "superadmin":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,227 @@
+package services
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"ftrack/models"
+	"ftrack/repositories"
+	"ftrack/testharness"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func newTestLocationService(env *testharness.Env) *LocationService {
+	return NewLocationService(env.Repos.Location, env.Repos.Circle, env.Repos.Place, env.Repos.User, env.Repos.Block, nil, nil)
+}
+
+// storeLocationAt stores a location as if the server got it at the time,
+// in that month's partition
+func storeLocationAt(t *testing.T, env *testharness.Env, user *models.User, latitude, longitude float64, at time.Time) {
+	t.Helper()
+	location := models.Location{
+		ID:         primitive.NewObjectID(),
+		UserID:     user.ID,
+		Latitude:   latitude,
+		Longitude:  longitude,
+		Accuracy:   5,
+		Point:      models.NewGeoPoint(latitude, longitude),
+		DeviceTime: at,
+		ServerTime: at,
+		CreatedAt:  at,
+	}
+	if _, err := env.DB.Collection(repositories.LocationPartitionName(at)).InsertOne(context.Background(), location); err != nil {
+		t.Fatalf("storing location: %v", err)
+	}
+}
+
+func TestParseBoundingBox(t *testing.T) {
+	tests := []struct {
+		value string
+		want  models.BoundingBox
+		err   bool
+	}{
+		{"-74.02,40.70,-73.99,40.72", models.BoundingBox{West: -74.02, South: 40.70, East: -73.99, North: 40.72}, false},
+		{" -74.02, 40.70 , -73.99,40.72", models.BoundingBox{West: -74.02, South: 40.70, East: -73.99, North: 40.72}, false},
+		{"179,-1,-179,1", models.BoundingBox{West: 179, South: -1, East: -179, North: 1}, false},
+		{"", models.BoundingBox{}, true},
+		{"1,2,3", models.BoundingBox{}, true},
+		{"a,2,3,4", models.BoundingBox{}, true},
+		{"NaN,2,3,4", models.BoundingBox{}, true},
+		{"-181,0,10,10", models.BoundingBox{}, true},
+		{"0,-91,10,10", models.BoundingBox{}, true},
+		{"0,10,10,10", models.BoundingBox{}, true},
+		{"0,20,10,10", models.BoundingBox{}, true},
+		{"5,0,5,10", models.BoundingBox{}, true},
+	}
+	for _, tt := range tests {
+		box, err := parseBoundingBox(tt.value)
+		if tt.err {
+			if err == nil || err.Error() != "invalid bbox" {
+				t.Errorf("parseBoundingBox(%q) error = %v, want invalid bbox", tt.value, err)
+			}
+			continue
+		}
+		if err != nil || box != tt.want {
+			t.Errorf("parseBoundingBox(%q) = %+v, %v; want %+v", tt.value, box, err, tt.want)
+		}
+	}
+}
+
+func TestBoundingBoxContains(t *testing.T) {
+	city := models.BoundingBox{West: -74.02, South: 40.70, East: -73.99, North: 40.72}
+	pacific := models.BoundingBox{West: 179, South: -1, East: -179, North: 1}
+
+	tests := []struct {
+		box       models.BoundingBox
+		lat, lon  float64
+		contained bool
+	}{
+		{city, 40.71, -74.00, true},
+		{city, 40.70, -74.02, true}, // edges count
+		{city, 40.73, -74.00, false},
+		{city, 40.71, -73.98, false},
+		{pacific, 0, 179.5, true},
+		{pacific, 0, -179.5, true},
+		{pacific, 0, 0, false},
+		{pacific, 2, 179.5, false},
+	}
+	for _, tt := range tests {
+		if got := tt.box.Contains(tt.lat, tt.lon); got != tt.contained {
+			t.Errorf("%+v contains (%v, %v) = %v, want %v", tt.box, tt.lat, tt.lon, got, tt.contained)
+		}
+	}
+}
+
+func TestParseViewportHistory(t *testing.T) {
+	const bbox = "-74.02,40.70,-73.99,40.72"
+
+	tests := []struct {
+		name      string
+		req       models.ViewportHistoryRequest
+		window    time.Duration
+		maxPoints int
+		err       string
+	}{
+		{"defaults", models.ViewportHistoryRequest{BBox: bbox}, 24 * time.Hour, models.DefaultHistoryPoints, ""},
+		{"explicit", models.ViewportHistoryRequest{BBox: bbox, From: "2024-05-01T00:00:00Z", To: "2024-05-03T00:00:00Z", MaxPoints: 50}, 48 * time.Hour, 50, ""},
+		{"from only", models.ViewportHistoryRequest{BBox: bbox, From: time.Now().Add(-2 * time.Hour).Format(time.RFC3339)}, 0, models.DefaultHistoryPoints, ""},
+		{"bad bbox", models.ViewportHistoryRequest{BBox: "1,2,3"}, 0, 0, "invalid bbox"},
+		{"bad date", models.ViewportHistoryRequest{BBox: bbox, From: "yesterday"}, 0, 0, "invalid date range"},
+		{"backwards", models.ViewportHistoryRequest{BBox: bbox, From: "2024-05-03T00:00:00Z", To: "2024-05-01T00:00:00Z"}, 0, 0, "invalid date range"},
+		{"too long", models.ViewportHistoryRequest{BBox: bbox, From: "2024-01-01T00:00:00Z", To: "2024-03-01T00:00:00Z"}, 0, 0, "invalid date range"},
+		{"too many points", models.ViewportHistoryRequest{BBox: bbox, MaxPoints: models.MaxHistoryPoints + 1}, 0, 0, "invalid max points"},
+		{"negative points", models.ViewportHistoryRequest{BBox: bbox, MaxPoints: -1}, 0, 0, "invalid max points"},
+	}
+	for _, tt := range tests {
+		_, from, to, maxPoints, err := parseViewportHistory(tt.req)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: error = %v, want %s", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: parseViewportHistory: %v", tt.name, err)
+			continue
+		}
+		if tt.window != 0 && to.Sub(from) != tt.window {
+			t.Errorf("%s: window = %v, want %v", tt.name, to.Sub(from), tt.window)
+		}
+		if maxPoints != tt.maxPoints {
+			t.Errorf("%s: maxPoints = %d, want %d", tt.name, maxPoints, tt.maxPoints)
+		}
+	}
+}
+
+func TestLocationServiceViewportHistory(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ls := newTestLocationService(env)
+	ctx := context.Background()
+
+	alice, bob, stranger := env.Factory.User(), env.Factory.User(), env.Factory.User()
+	erin := env.Factory.User(func(user *models.User) { user.LocationSharing.Precision = models.PrecisionApproximate })
+	env.Factory.Circle(alice, []*models.User{bob, erin})
+
+	// Ten points in the city, one an hour, a point elsewhere, one before the
+	// window and two either side of the antimeridian
+	now := time.Now().UTC().Truncate(time.Second)
+	for i := 0; i < 10; i++ {
+		storeLocationAt(t, env, alice, 40.71+float64(i)*0.001, -74.005, now.Add(-time.Duration(i)*time.Hour-30*time.Minute))
+	}
+	storeLocationAt(t, env, alice, 51.5074, -0.1278, now.Add(-time.Hour))
+	storeLocationAt(t, env, alice, 40.71, -74.005, now.Add(-11*time.Hour))
+	storeLocationAt(t, env, alice, 0, 179.5, now.Add(-2*time.Hour))
+	storeLocationAt(t, env, alice, 0, -179.5, now.Add(-3*time.Hour))
+	storeLocationAt(t, env, erin, 40.7128, -74.0060, now.Add(-time.Hour))
+
+	req := models.ViewportHistoryRequest{
+		UserID: alice.ID.Hex(),
+		BBox:   "-74.02,40.70,-73.99,40.72",
+		From:   now.Add(-10 * time.Hour).Format(time.RFC3339),
+		To:     now.Format(time.RFC3339),
+	}
+
+	history, err := ls.GetViewportHistory(ctx, alice.ID.Hex(), req)
+	if err != nil {
+		t.Fatalf("GetViewportHistory: %v", err)
+	}
+	if len(history.Points) != 10 || history.TotalPoints != 10 || history.Downsampled {
+		t.Fatalf("history = %d of %d points, downsampled %v; want the 10 in the box and window", len(history.Points), history.TotalPoints, history.Downsampled)
+	}
+	for i := 1; i < len(history.Points); i++ {
+		if !history.Points[i].RecordedAt.After(history.Points[i-1].RecordedAt) {
+			t.Fatalf("points not oldest first: %v then %v", history.Points[i-1].RecordedAt, history.Points[i].RecordedAt)
+		}
+	}
+
+	// Past maxPoints the window is cut into equal slices, each keeping its
+	// oldest point
+	req.MaxPoints = 5
+	history, err = ls.GetViewportHistory(ctx, alice.ID.Hex(), req)
+	if err != nil {
+		t.Fatalf("GetViewportHistory: %v", err)
+	}
+	if len(history.Points) != 5 || history.TotalPoints != 10 || !history.Downsampled || history.MaxPoints != 5 {
+		t.Fatalf("downsampled history = %d of %d points, downsampled %v; want 5 of 10", len(history.Points), history.TotalPoints, history.Downsampled)
+	}
+	for i, point := range history.Points {
+		if want := now.Add(-time.Duration(9-2*i)*time.Hour - 30*time.Minute); !point.RecordedAt.Equal(want) {
+			t.Errorf("point %d recorded at %v, want %v", i, point.RecordedAt, want)
+		}
+	}
+
+	// A box across the antimeridian matches both sides of it
+	req.BBox, req.MaxPoints = "179,-1,-179,1", 0
+	history, err = ls.GetViewportHistory(ctx, bob.ID.Hex(), req)
+	if err != nil {
+		t.Fatalf("GetViewportHistory: %v", err)
+	}
+	if len(history.Points) != 2 {
+		t.Errorf("antimeridian history = %+v, want the 2 points either side", history.Points)
+	}
+
+	// Other members see points as precise as the member shares them
+	erinReq := models.ViewportHistoryRequest{UserID: erin.ID.Hex(), BBox: "-74.02,40.70,-73.99,40.72", From: req.From, To: req.To}
+	history, err = ls.GetViewportHistory(ctx, bob.ID.Hex(), erinReq)
+	if err != nil {
+		t.Fatalf("GetViewportHistory: %v", err)
+	}
+	if history.Precision != models.PrecisionApproximate || len(history.Points) != 1 {
+		t.Fatalf("approximate history = %+v, want erin's point", history)
+	}
+	if point := history.Points[0]; math.Abs(point.Latitude-40.71) > 1e-9 || math.Abs(point.Longitude+74.01) > 1e-9 || point.Accuracy < 500 {
+		t.Errorf("approximate point = %+v, want it on the 0.01° grid", point.SharedLocation)
+	}
+	history, err = ls.GetViewportHistory(ctx, erin.ID.Hex(), erinReq)
+	if err != nil || history.Precision != models.PrecisionExact || len(history.Points) != 1 || history.Points[0].Latitude != 40.7128 {
+		t.Errorf("own history = %+v, %v; want the exact point", history, err)
+	}
+
+	if _, err := ls.GetViewportHistory(ctx, stranger.ID.Hex(), req); err == nil || err.Error() != "access denied" {
+		t.Errorf("stranger's request error = %v, want access denied", err)
+	}
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"ftrack/models"
+	"ftrack/testharness"
+)
+
+func TestHistoryVisibleSince(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	if since := (models.LocationSharing{}).HistoryVisibleSince(now); since != nil {
+		t.Errorf("no window shows history since %v, want all of it", since)
+	}
+	since := models.LocationSharing{HistoryWindowHours: 24}.HistoryVisibleSince(now)
+	if since == nil || !since.Equal(now.Add(-24*time.Hour)) {
+		t.Errorf("a day's window shows history since %v, want a day ago", since)
+	}
+}
+
+func TestLocationHistoryWindow(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ls := newTestLocationService(env)
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	window := func(user *models.User) { user.LocationSharing.HistoryWindowHours = 24 }
+	member, mate := env.Factory.User(window), env.Factory.User()
+	away := env.Factory.User(window)
+	circle := env.Factory.Circle(mate, []*models.User{member, away})
+
+	storeLocationAt(t, env, member, 40.71, -74.00, now.Add(-3*24*time.Hour))
+	storeLocationAt(t, env, member, 40.71, -74.00, now.Add(-30*time.Hour))
+	storeLocationAt(t, env, member, 40.71, -74.00, now.Add(-2*time.Hour))
+	storeLocationAt(t, env, away, 40.71, -74.00, now.Add(-30*time.Hour))
+
+	// Others see the last day, the member all of it, whatever the range
+	// asked for
+	from := now.Add(-7 * 24 * time.Hour)
+	for _, tt := range []struct {
+		name      string
+		requester *models.User
+		total     int64
+	}{
+		{"mate", mate, 1},
+		{"member", member, 3},
+	} {
+		history, err := ls.GetLocationHistory(ctx, tt.requester.ID.Hex(), member.ID.Hex(), &from, nil, 1, 10)
+		if err != nil {
+			t.Fatalf("%s: GetLocationHistory: %v", tt.name, err)
+		}
+		if history.Meta.Total != tt.total || len(history.Locations) != int(tt.total) {
+			t.Errorf("%s sees %d of %d locations, want %d", tt.name, len(history.Locations), history.Meta.Total, tt.total)
+		}
+	}
+
+	req := models.ViewportHistoryRequest{
+		UserID: member.ID.Hex(),
+		BBox:   "-74.02,40.70,-73.99,40.72",
+		From:   now.Add(-4 * 24 * time.Hour).Format(time.RFC3339),
+		To:     now.Format(time.RFC3339),
+	}
+	viewport, err := ls.GetViewportHistory(ctx, mate.ID.Hex(), req)
+	if err != nil {
+		t.Fatalf("GetViewportHistory: %v", err)
+	}
+	if viewport.TotalPoints != 1 || !viewport.Points[0].RecordedAt.Equal(now.Add(-2*time.Hour)) {
+		t.Errorf("mate's viewport has %d points, want only the one in the window", viewport.TotalPoints)
+	}
+
+	// A last location older than the window is hidden from others, like one
+	// never sent
+	snapshot, err := ls.GetCircleLocations(ctx, mate.ID.Hex(), circle.ID.Hex())
+	if err != nil {
+		t.Fatalf("GetCircleLocations: %v", err)
+	}
+	want := map[string]string{
+		mate.ID.Hex():   models.MemberLocationUnavailable,
+		member.ID.Hex(): models.MemberLocationSharing,
+		away.ID.Hex():   models.MemberLocationUnavailable,
+	}
+	for _, status := range snapshot.Members {
+		if status.Status != want[status.UserID] {
+			t.Errorf("member %s is %s in the snapshot, want %s", status.UserID, status.Status, want[status.UserID])
+		}
+	}
+
+	if _, err := ls.GetCurrentLocation(ctx, mate.ID.Hex(), away.ID.Hex()); err == nil || err.Error() != "location not found" {
+		t.Errorf("current location before the window error = %v, want location not found", err)
+	}
+	if _, err := ls.GetLastKnownLocation(ctx, mate.ID.Hex(), away.ID.Hex()); err == nil || err.Error() != "location not found" {
+		t.Errorf("last known location before the window error = %v, want location not found", err)
+	}
+	if _, err := ls.GetLastKnownLocation(ctx, away.ID.Hex(), away.ID.Hex()); err != nil {
+		t.Errorf("member's own last known location: %v", err)
+	}
+	if location, err := ls.GetCurrentLocation(ctx, mate.ID.Hex(), member.ID.Hex()); err != nil || !location.RecordedAt().Equal(now.Add(-2*time.Hour)) {
+		t.Errorf("current location inside the window = %v, %v", location, err)
+	}
+
+	// Whereabouts, as the SMS WHERE command replies, follow the window too
+	if _, err := ls.GetWhereabouts(ctx, mate.ID.Hex(), away.ID.Hex()); err == nil || err.Error() != "location not found" {
+		t.Errorf("whereabouts before the window error = %v, want location not found", err)
+	}
+	if _, err := ls.GetWhereabouts(ctx, away.ID.Hex(), away.ID.Hex()); err != nil {
+		t.Errorf("member's own whereabouts: %v", err)
+	}
+	if whereabouts, err := ls.GetWhereabouts(ctx, mate.ID.Hex(), member.ID.Hex()); err != nil || !whereabouts.RecordedAt.Equal(now.Add(-2*time.Hour)) {
+		t.Errorf("whereabouts inside the window = %v, %v", whereabouts, err)
+	}
+}
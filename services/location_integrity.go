@@ -0,0 +1,346 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"ftrack/models"
+	"ftrack/repositories"
+	"ftrack/utils"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// Fixes at least this precise are implausible for long, since real
+	// receivers drift
+	integrityPreciseAccuracyMeters = 1.0
+	integrityPreciseStretch        = 30 * time.Minute
+
+	// Real fixes jitter even at rest, so a run of identical coordinates
+	// comes from a replayed or pinned location
+	integrityIdenticalFixes = 10
+
+	// Faster than an airliner, over more than a city block of GPS error
+	integrityTeleportMeters = 1000
+	integrityTeleportSpeed  = 300.0 // m/s
+
+	// Movement the motion sensors should have noticed
+	integrityStillMovedMeters = 200
+	integrityStillMovedSpeed  = 2.0  // m/s
+	integrityOnFootMaxSpeed   = 15.0 // m/s
+
+	integrityMaxScore     = 100
+	integrityAlertScore   = 50
+	integrityRecentFlags  = 20
+	integrityCleanRestore = 1
+)
+
+// How far each flag lowers the score
+var integrityFlagWeights = map[string]int{
+	models.IntegrityFlagMockProvider:    25,
+	models.IntegrityFlagTeleport:        20,
+	models.IntegrityFlagSensorMismatch:  10,
+	models.IntegrityFlagPerfectAccuracy: 10,
+	models.IntegrityFlagNoNoise:         10,
+}
+
+// LocationIntegrityService scores how far users' reported locations look
+// real. The heuristics only flag updates; the score is shown to admins of
+// circles with integrity alerts on and never blocks an update.
+type LocationIntegrityService struct {
+	integrityRepo       *repositories.LocationIntegrityRepository
+	circleRepo          *repositories.CircleRepository
+	userRepo            *repositories.UserRepository
+	notificationService *NotificationService
+}
+
+func NewLocationIntegrityService(
+	integrityRepo *repositories.LocationIntegrityRepository,
+	circleRepo *repositories.CircleRepository,
+	userRepo *repositories.UserRepository,
+	notificationService *NotificationService,
+) *LocationIntegrityService {
+	return &LocationIntegrityService{
+		integrityRepo:       integrityRepo,
+		circleRepo:          circleRepo,
+		userRepo:            userRepo,
+		notificationService: notificationService,
+	}
+}
+
+// Evaluate runs the heuristics on a saved location, against the user's
+// previous one if there is one, and updates their score
+func (lis *LocationIntegrityService) Evaluate(ctx context.Context, location models.Location, prev *models.Location) error {
+	integrity, err := lis.integrityRepo.GetByUser(ctx, location.UserID)
+	if err != nil {
+		if err.Error() != "location integrity not found" {
+			return err
+		}
+		integrity = &models.LocationIntegrity{
+			UserID:     location.UserID,
+			Score:      integrityMaxScore,
+			FlagCounts: make(map[string]int),
+		}
+	}
+	before := *integrity
+
+	flags := integrityFlags(integrity, location, prev)
+	if len(flags) == 0 && integrity.Score == integrityMaxScore && integrityStateEqual(&before, integrity) {
+		return nil
+	}
+
+	if len(flags) == 0 {
+		integrity.Score = min(integrity.Score+integrityCleanRestore, integrityMaxScore)
+	}
+
+	now := time.Now()
+	if integrity.FlagCounts == nil {
+		integrity.FlagCounts = make(map[string]int)
+	}
+	for _, flag := range flags {
+		flag.LocationID = location.ID
+		flag.FlaggedAt = now
+		integrity.FlagCounts[flag.Type]++
+		integrity.Score = max(integrity.Score-integrityFlagWeights[flag.Type], 0)
+		integrity.RecentFlags = append([]models.LocationIntegrityFlag{flag}, integrity.RecentFlags...)
+		integrity.LastFlaggedAt = &now
+	}
+	if len(integrity.RecentFlags) > integrityRecentFlags {
+		integrity.RecentFlags = integrity.RecentFlags[:integrityRecentFlags]
+	}
+
+	if err := lis.integrityRepo.Save(ctx, integrity); err != nil {
+		return err
+	}
+
+	if before.Score >= integrityAlertScore && integrity.Score < integrityAlertScore {
+		lis.alertAdmins(ctx, integrity)
+	}
+
+	return nil
+}
+
+// integrityFlags runs the heuristics, and moves the stretches they look at
+// along
+func integrityFlags(integrity *models.LocationIntegrity, location models.Location, prev *models.Location) []models.LocationIntegrityFlag {
+	var flags []models.LocationIntegrityFlag
+	recordedAt := location.RecordedAt()
+
+	if location.IsMock {
+		flags = append(flags, models.LocationIntegrityFlag{Type: models.IntegrityFlagMockProvider})
+	}
+
+	// Clients that don't report accuracy send none
+	if location.Accuracy > 0 && location.Accuracy <= integrityPreciseAccuracyMeters {
+		if integrity.PreciseSince == nil {
+			integrity.PreciseSince = &recordedAt
+			integrity.PreciseFlagged = false
+		}
+		if !integrity.PreciseFlagged && recordedAt.Sub(*integrity.PreciseSince) >= integrityPreciseStretch {
+			integrity.PreciseFlagged = true
+			flags = append(flags, models.LocationIntegrityFlag{
+				Type:   models.IntegrityFlagPerfectAccuracy,
+				Detail: fmt.Sprintf("accuracy of %.1fm or better since %s", integrityPreciseAccuracyMeters, integrity.PreciseSince.Format(time.RFC3339)),
+			})
+		}
+	} else {
+		integrity.PreciseSince = nil
+		integrity.PreciseFlagged = false
+	}
+
+	// Uploads that arrive out of order, like offline batches, say nothing
+	// about movement
+	if prev == nil || !recordedAt.After(prev.RecordedAt()) {
+		return flags
+	}
+
+	if location.Latitude == prev.Latitude && location.Longitude == prev.Longitude {
+		integrity.IdenticalFixes++
+		if integrity.IdenticalFixes == integrityIdenticalFixes {
+			flags = append(flags, models.LocationIntegrityFlag{
+				Type:   models.IntegrityFlagNoNoise,
+				Detail: fmt.Sprintf("%d fixes with identical coordinates", integrityIdenticalFixes),
+			})
+		}
+		return flags
+	}
+	identical := integrity.IdenticalFixes
+	integrity.IdenticalFixes = 0
+
+	distance := utils.CalculateDistance(prev.Latitude, prev.Longitude, location.Latitude, location.Longitude)
+	speed := distance / recordedAt.Sub(prev.RecordedAt()).Seconds()
+
+	if distance >= integrityTeleportMeters && speed > integrityTeleportSpeed {
+		detail := fmt.Sprintf("moved %.0fm at %.0fm/s", distance, speed)
+		if identical > 0 {
+			detail += fmt.Sprintf(" after %d identical fixes", identical)
+		}
+		flags = append(flags, models.LocationIntegrityFlag{Type: models.IntegrityFlagTeleport, Detail: detail})
+	}
+
+	if location.Sensors != nil {
+		activity := strings.ToLower(location.Sensors.Activity)
+		switch {
+		case activity == "stationary" && distance >= integrityStillMovedMeters && speed > integrityStillMovedSpeed:
+			flags = append(flags, models.LocationIntegrityFlag{
+				Type:   models.IntegrityFlagSensorMismatch,
+				Detail: fmt.Sprintf("sensors report stationary while moving %.0fm", distance),
+			})
+		case (activity == "walking" || activity == "running") && speed > integrityOnFootMaxSpeed:
+			flags = append(flags, models.LocationIntegrityFlag{
+				Type:   models.IntegrityFlagSensorMismatch,
+				Detail: fmt.Sprintf("sensors report %s while moving at %.0fm/s", activity, speed),
+			})
+		}
+	}
+
+	return flags
+}
+
+func integrityStateEqual(a, b *models.LocationIntegrity) bool {
+	return a.IdenticalFixes == b.IdenticalFixes &&
+		a.PreciseFlagged == b.PreciseFlagged &&
+		(a.PreciseSince == nil) == (b.PreciseSince == nil)
+}
+
+// alertAdmins tells the admins of the user's circles with integrity alerts
+// on that their score dropped
+func (lis *LocationIntegrityService) alertAdmins(ctx context.Context, integrity *models.LocationIntegrity) {
+	if lis.notificationService == nil {
+		return
+	}
+
+	userID := integrity.UserID.Hex()
+	circles, err := lis.circleRepo.GetUserCircles(ctx, userID)
+	if err != nil {
+		logrus.Warnf("Failed to get circles of user %s for integrity alert: %v", userID, err)
+		return
+	}
+
+	name := "A member"
+	if user, err := lis.userRepo.GetByID(ctx, userID); err == nil {
+		name = strings.TrimSpace(user.FirstName + " " + user.LastName)
+	}
+
+	for i := range circles {
+		circle := &circles[i]
+		member := findCircleMember(circle, userID)
+		if !circle.Settings.IntegrityAlerts || member == nil || member.Status != "active" {
+			continue
+		}
+
+		var admins []string
+		for _, other := range circle.Members {
+			if other.Role == "admin" && other.Status == "active" && other.UserID != integrity.UserID {
+				admins = append(admins, other.UserID.Hex())
+			}
+		}
+		if len(admins) == 0 {
+			continue
+		}
+
+		err := lis.notificationService.SendNotification(ctx, models.SendNotificationRequest{
+			Recipients: admins,
+			Title:      "Location integrity",
+			Message:    fmt.Sprintf("%s's recent locations in %s look unreliable", name, circle.Name),
+			Type:       models.NotificationTypeLocationIntegrity,
+			Priority:   "normal",
+			Category:   "circle",
+			Data: map[string]interface{}{
+				"circleId": circle.ID.Hex(),
+				"userId":   userID,
+				"score":    integrity.Score,
+			},
+			SubjectUserID: userID,
+		})
+		if err != nil {
+			logrus.Errorf("Failed to send integrity alert for user %s in circle %s: %v", userID, circle.ID.Hex(), err)
+		}
+	}
+}
+
+// GetCircleIntegrity lists the integrity of a circle's active members, for
+// its admins once integrity alerts are on
+func (lis *LocationIntegrityService) GetCircleIntegrity(ctx context.Context, userID, circleID string) (*models.CircleIntegrityResponse, error) {
+	role, err := lis.circleRepo.GetMemberRole(ctx, circleID, userID)
+	if err != nil {
+		if err.Error() == "member not found" {
+			return nil, errors.New("access denied")
+		}
+		return nil, err
+	}
+	if role != "admin" {
+		return nil, errors.New("access denied")
+	}
+
+	circle, err := lis.circleRepo.GetByID(ctx, circleID)
+	if err != nil {
+		return nil, err
+	}
+	if !circle.Settings.IntegrityAlerts {
+		return nil, errors.New("integrity alerts disabled")
+	}
+
+	var memberIDs []string
+	var memberObjectIDs []primitive.ObjectID
+	for _, member := range circle.Members {
+		if member.Status == "active" {
+			memberIDs = append(memberIDs, member.UserID.Hex())
+			memberObjectIDs = append(memberObjectIDs, member.UserID)
+		}
+	}
+
+	users, err := lis.userRepo.GetUsersByIDs(ctx, memberIDs)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]string, len(users))
+	for _, user := range users {
+		names[user.ID.Hex()] = strings.TrimSpace(user.FirstName + " " + user.LastName)
+	}
+
+	integrities, err := lis.integrityRepo.GetByUsers(ctx, memberObjectIDs)
+	if err != nil {
+		return nil, err
+	}
+	scored := make(map[string]*models.LocationIntegrity, len(integrities))
+	for i := range integrities {
+		scored[integrities[i].UserID.Hex()] = &integrities[i]
+	}
+
+	// Members without flags yet are fully trusted
+	response := &models.CircleIntegrityResponse{CircleID: circleID, Members: []models.MemberIntegrity{}}
+	for _, memberID := range memberIDs {
+		member := models.MemberIntegrity{
+			UserID:      memberID,
+			Name:        names[memberID],
+			Score:       integrityMaxScore,
+			FlagCounts:  map[string]int{},
+			RecentFlags: []models.LocationIntegrityFlag{},
+		}
+		if integrity, ok := scored[memberID]; ok {
+			member.Score = integrity.Score
+			member.LastFlaggedAt = integrity.LastFlaggedAt
+			if integrity.FlagCounts != nil {
+				member.FlagCounts = integrity.FlagCounts
+			}
+			if integrity.RecentFlags != nil {
+				member.RecentFlags = integrity.RecentFlags
+			}
+		}
+		response.Members = append(response.Members, member)
+	}
+
+	// Least trusted first
+	sort.SliceStable(response.Members, func(i, j int) bool {
+		return response.Members[i].Score < response.Members[j].Score
+	})
+
+	return response, nil
+}
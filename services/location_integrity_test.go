@@ -0,0 +1,235 @@
+package services
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"ftrack/models"
+	"ftrack/repositories"
+	"ftrack/testharness"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var traceStart = time.Date(2026, 10, 17, 8, 0, 0, 0, time.UTC)
+
+// traceFix is a fix taken offset into a trace
+func traceFix(offset time.Duration, lat, lon, accuracy float64) models.Location {
+	at := traceStart.Add(offset)
+	return models.Location{
+		ID:         primitive.NewObjectID(),
+		Latitude:   lat,
+		Longitude:  lon,
+		Accuracy:   accuracy,
+		DeviceTime: at,
+		ServerTime: at,
+	}
+}
+
+// genuineDrive heads north at about 25m/s, with the accuracy and heading
+// wandering like a real receiver's
+func genuineDrive() []models.Location {
+	var trace []models.Location
+	for i := 0; i < 60; i++ {
+		fix := traceFix(time.Duration(i)*10*time.Second, 52.37+float64(i)*0.00225, 4.89+0.0003*math.Sin(float64(i)), 5+float64(i%7))
+		fix.Sensors = &models.LocationSensors{Activity: "automotive"}
+		trace = append(trace, fix)
+	}
+	return trace
+}
+
+// mockTeleport sits pinned at home with perfect accuracy for 40 minutes,
+// then jumps 50km in ten seconds
+func mockTeleport(isMock bool) []models.Location {
+	var trace []models.Location
+	for i := 0; i < 40; i++ {
+		trace = append(trace, traceFix(time.Duration(i)*time.Minute, 52.37, 4.89, 1))
+	}
+	trace = append(trace, traceFix(39*time.Minute+10*time.Second, 52.82, 4.89, 1))
+	for i := range trace {
+		trace[i].IsMock = isMock
+	}
+	return trace
+}
+
+// stationaryNoise is a phone on a desk for half an hour, its fixes
+// jittering a few metres
+func stationaryNoise() []models.Location {
+	var trace []models.Location
+	for i := 0; i < 60; i++ {
+		jitter := 0.00004 * math.Sin(float64(i)*1.7)
+		fix := traceFix(time.Duration(i)*30*time.Second, 52.37+jitter, 4.89-jitter/2, 8+float64(i%12))
+		fix.Sensors = &models.LocationSensors{Activity: "stationary"}
+		trace = append(trace, fix)
+	}
+	return trace
+}
+
+// walkingAtCarSpeed says walking while covering 200m every ten seconds
+func walkingAtCarSpeed() []models.Location {
+	var trace []models.Location
+	for i := 0; i < 6; i++ {
+		fix := traceFix(time.Duration(i)*10*time.Second, 52.37+float64(i)*0.0018, 4.89, 6)
+		fix.Sensors = &models.LocationSensors{Activity: "Walking"}
+		trace = append(trace, fix)
+	}
+	return trace
+}
+
+func TestIntegrityFlagsOnSyntheticTraces(t *testing.T) {
+	// An offline batch arrives newest first, so no step says anything
+	// about movement
+	offline := mockTeleport(false)[30:]
+	for i, j := 0, len(offline)-1; i < j; i, j = i+1, j-1 {
+		offline[i], offline[j] = offline[j], offline[i]
+	}
+
+	tests := []struct {
+		name  string
+		trace []models.Location
+		want  map[string]int
+	}{
+		{"genuine drive", genuineDrive(), map[string]int{}},
+		{"stationary noise", stationaryNoise(), map[string]int{}},
+		{"mock teleport", mockTeleport(true), map[string]int{
+			models.IntegrityFlagMockProvider:    41,
+			models.IntegrityFlagNoNoise:         1,
+			models.IntegrityFlagPerfectAccuracy: 1,
+			models.IntegrityFlagTeleport:        1,
+		}},
+		{"teleport without the mock provider", mockTeleport(false), map[string]int{
+			models.IntegrityFlagNoNoise:         1,
+			models.IntegrityFlagPerfectAccuracy: 1,
+			models.IntegrityFlagTeleport:        1,
+		}},
+		{"walking at car speed", walkingAtCarSpeed(), map[string]int{models.IntegrityFlagSensorMismatch: 5}},
+		{"offline batch", offline, map[string]int{}},
+	}
+
+	for _, tt := range tests {
+		integrity := &models.LocationIntegrity{Score: integrityMaxScore}
+		got := map[string]int{}
+		var last []models.LocationIntegrityFlag
+		for i, fix := range tt.trace {
+			var prev *models.Location
+			if i > 0 {
+				prev = &tt.trace[i-1]
+			}
+			last = integrityFlags(integrity, fix, prev)
+			for _, flag := range last {
+				got[flag.Type]++
+			}
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: flags %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for flag, count := range tt.want {
+			if got[flag] != count {
+				t.Errorf("%s: flags %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+		if tt.want[models.IntegrityFlagTeleport] > 0 {
+			teleport := last[len(last)-1]
+			if teleport.Type != models.IntegrityFlagTeleport || !strings.HasSuffix(teleport.Detail, "after 39 identical fixes") {
+				t.Errorf("%s: last flag %+v, want the teleport after the pinned stretch", tt.name, teleport)
+			}
+		}
+	}
+}
+
+func TestLocationIntegrityScores(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	integrityRepo := repositories.NewLocationIntegrityRepository(env.DB)
+	lis := NewLocationIntegrityService(integrityRepo, env.Repos.Circle, env.Repos.User, nil)
+	ctx := context.Background()
+
+	admin, driver, spoofer, hider, idle := env.Factory.User(), env.Factory.User(), env.Factory.User(), env.Factory.User(), env.Factory.User()
+	members := []*models.User{driver, spoofer, hider, idle}
+	circle := env.Factory.Circle(admin, members, func(circle *models.Circle) { circle.Settings.IntegrityAlerts = true })
+	quiet := env.Factory.Circle(admin, members)
+
+	drive := func(user *models.User, trace []models.Location) {
+		t.Helper()
+		for i := range trace {
+			trace[i].UserID = user.ID
+			var prev *models.Location
+			if i > 0 {
+				prev = &trace[i-1]
+			}
+			if err := lis.Evaluate(ctx, trace[i], prev); err != nil {
+				t.Fatalf("Evaluate: %v", err)
+			}
+		}
+	}
+	drive(driver, genuineDrive())
+	drive(idle, stationaryNoise())
+	teleport := mockTeleport(true)
+	drive(spoofer, teleport)
+	drive(hider, mockTeleport(false))
+
+	// Clean traces never lower the score, so nothing is kept for them
+	for _, user := range []*models.User{driver, idle} {
+		if _, err := integrityRepo.GetByUser(ctx, user.ID); err == nil || err.Error() != "location integrity not found" {
+			t.Errorf("integrity of a clean trace error = %v, want location integrity not found", err)
+		}
+	}
+
+	spoofed, err := integrityRepo.GetByUser(ctx, spoofer.ID)
+	if err != nil {
+		t.Fatalf("GetByUser: %v", err)
+	}
+	if spoofed.Score != 0 || spoofed.FlagCounts[models.IntegrityFlagMockProvider] != 41 {
+		t.Errorf("mock teleport scored %d with flags %v, want 0", spoofed.Score, spoofed.FlagCounts)
+	}
+	if len(spoofed.RecentFlags) != integrityRecentFlags || spoofed.RecentFlags[0].Type != models.IntegrityFlagTeleport ||
+		spoofed.RecentFlags[0].LocationID != teleport[len(teleport)-1].ID {
+		t.Errorf("recent flags start with %+v (%d kept), want the teleport", spoofed.RecentFlags[0], len(spoofed.RecentFlags))
+	}
+
+	// Clean fixes restore a point each: the identical fixes cost 10 and are
+	// made good before the perfect accuracy costs 10, which the 9 clean
+	// fixes after it don't make good, and the teleport costs 20
+	hidden, err := integrityRepo.GetByUser(ctx, hider.ID)
+	if err != nil {
+		t.Fatalf("GetByUser: %v", err)
+	}
+	if hidden.Score != 79 {
+		t.Errorf("teleport without the mock provider scored %d, want 79", hidden.Score)
+	}
+
+	// Admins see the least trusted first, with members not yet flagged
+	// fully trusted
+	response, err := lis.GetCircleIntegrity(ctx, admin.ID.Hex(), circle.ID.Hex())
+	if err != nil {
+		t.Fatalf("GetCircleIntegrity: %v", err)
+	}
+	var scores []int
+	for _, member := range response.Members {
+		scores = append(scores, member.Score)
+	}
+	if len(scores) != 5 || response.Members[0].UserID != spoofer.ID.Hex() || response.Members[1].UserID != hider.ID.Hex() ||
+		scores[0] != 0 || scores[1] != 79 || scores[2] != 100 || scores[4] != 100 {
+		t.Errorf("circle integrity scores %v, want the spoofer, then the hider, then the rest at 100", scores)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		userID string
+		circle *models.Circle
+		err    string
+	}{
+		{"for a member", spoofer.ID.Hex(), circle, "access denied"},
+		{"for a stranger", primitive.NewObjectID().Hex(), circle, "access denied"},
+		{"with alerts off", admin.ID.Hex(), quiet, "integrity alerts disabled"},
+	} {
+		if _, err := lis.GetCircleIntegrity(ctx, tt.userID, tt.circle.ID.Hex()); err == nil || err.Error() != tt.err {
+			t.Errorf("circle integrity %s error = %v, want %s", tt.name, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"ftrack/models"
+	"ftrack/repositories"
+	"ftrack/testharness"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestLocationPartitionName(t *testing.T) {
+	berlin := time.FixedZone("CEST", 2*60*60)
+	tests := []struct {
+		at   time.Time
+		want string
+	}{
+		{time.Date(2025, 9, 30, 23, 59, 59, 0, time.UTC), "locations_2025_09"},
+		{time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC), "locations_2025_10"},
+		{time.Date(2025, 10, 1, 1, 0, 0, 0, berlin), "locations_2025_09"},
+		{time.Date(2025, 12, 31, 23, 0, 0, 0, time.UTC), "locations_2025_12"},
+		{time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), "locations_2026_01"},
+	}
+	for _, tt := range tests {
+		if got := repositories.LocationPartitionName(tt.at); got != tt.want {
+			t.Errorf("location stored at %v goes to %s, want %s", tt.at, got, tt.want)
+		}
+	}
+}
+
+func TestLocationQueriesSpanPartitions(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	repo := env.Repos.Location
+	ctx := context.Background()
+
+	user, other := env.Factory.User(), env.Factory.User()
+	userID := user.ID.Hex()
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2025, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	// Either side of midnight on the 1st of October, plus one still in the
+	// single collection the partitions replace
+	august := at(time.August, 15, 12, 0)
+	beforeMidnight := at(time.September, 30, 23, 50)
+	legacy := at(time.September, 30, 23, 55)
+	afterMidnight := at(time.October, 1, 0, 10)
+	october := at(time.October, 15, 12, 0)
+	storeLocationAt(t, env, user, 40.70, -74.00, august)
+	storeLocationAt(t, env, user, 40.71, -74.00, beforeMidnight)
+	storeLocationAt(t, env, user, 40.72, -74.00, afterMidnight)
+	storeLocationAt(t, env, user, 40.73, -74.00, october)
+	storeLocationAt(t, env, other, 40.71, -74.00, afterMidnight)
+	if _, err := env.DB.Collection("locations").InsertOne(ctx, models.Location{
+		ID: primitive.NewObjectID(), UserID: user.ID, Latitude: 40.715, Longitude: -74.00,
+		Point: models.NewGeoPoint(40.715, -74.00), DeviceTime: legacy, ServerTime: legacy, CreatedAt: legacy,
+	}); err != nil {
+		t.Fatalf("storing legacy location: %v", err)
+	}
+
+	times := func(locations []models.Location) string {
+		var got []string
+		for _, location := range locations {
+			got = append(got, location.CreatedAt.UTC().Format("01-02 15:04"))
+		}
+		return strings.Join(got, ",")
+	}
+	from, to := at(time.September, 30, 23, 0), at(time.October, 1, 1, 0)
+
+	check := func(stage string) {
+		t.Helper()
+
+		history, total, err := repo.GetLocationHistory(ctx, userID, &from, &to, 1, 10)
+		if err != nil {
+			t.Fatalf("%s: GetLocationHistory: %v", stage, err)
+		}
+		if got := times(history); total != 3 || got != "10-01 00:10,09-30 23:55,09-30 23:50" {
+			t.Errorf("%s: history across midnight %s (%d), want 3 newest first", stage, got, total)
+		}
+
+		page, total, err := repo.GetLocationHistory(ctx, userID, nil, nil, 2, 2)
+		if err != nil {
+			t.Fatalf("%s: GetLocationHistory: %v", stage, err)
+		}
+		if got := times(page); total != 5 || got != "09-30 23:55,09-30 23:50" {
+			t.Errorf("%s: second page of all history %s (%d), want the middle two of 5", stage, got, total)
+		}
+
+		between, err := repo.GetLocationsBetween(ctx, userID, beforeMidnight, october, 10)
+		if err != nil {
+			t.Fatalf("%s: GetLocationsBetween: %v", stage, err)
+		}
+		if got := times(between); got != "09-30 23:55,10-01 00:10,10-15 12:00" {
+			t.Errorf("%s: locations between %s, want oldest first after the first", stage, got)
+		}
+
+		location, err := repo.GetLocationAt(ctx, userID, at(time.October, 1, 0, 5))
+		if err != nil {
+			t.Fatalf("%s: GetLocationAt: %v", stage, err)
+		}
+		if !location.CreatedAt.Equal(legacy) {
+			t.Errorf("%s: location at 00:05 recorded at %v, want the one from the previous month", stage, location.CreatedAt)
+		}
+
+		counts, err := repo.GetLocationGridCounts(ctx, userID, at(time.August, 1, 0, 0), at(time.November, 1, 0, 0), 1)
+		if err != nil {
+			t.Fatalf("%s: GetLocationGridCounts: %v", stage, err)
+		}
+		if len(counts) != 1 || counts[0].Count != 5 {
+			t.Errorf("%s: heatmap over three months %+v, want 5 locations in one cell", stage, counts)
+		}
+
+		trip := &models.Trip{UserID: userID, Name: "late drive", StartTime: beforeMidnight, EndTime: &afterMidnight}
+		if err := repo.CreateTrip(ctx, trip); err != nil {
+			t.Fatalf("%s: CreateTrip: %v", stage, err)
+		}
+		route, err := repo.GetTripRoute(ctx, trip.ID.Hex())
+		if err != nil {
+			t.Fatalf("%s: GetTripRoute: %v", stage, err)
+		}
+		if got := times(route.Points); got != "09-30 23:50,09-30 23:55,10-01 00:10" {
+			t.Errorf("%s: trip route %s, want the points either side of midnight", stage, got)
+		}
+	}
+	check("before the backfill")
+
+	// The backfill moves the old collection over in batches; reads see
+	// every location once throughout
+	for done := false; !done; {
+		var err error
+		if _, done, err = repo.BackfillLocationPartitions(ctx, 1); err != nil {
+			t.Fatalf("BackfillLocationPartitions: %v", err)
+		}
+	}
+	if left, err := env.DB.Collection("locations").CountDocuments(ctx, bson.M{}); err != nil || left != 0 {
+		t.Errorf("%d locations left in the old collection (%v)", left, err)
+	}
+	if moved, err := env.DB.Collection("locations_2025_09").CountDocuments(ctx, bson.M{"createdAt": legacy}); err != nil || moved != 1 {
+		t.Errorf("%d backfilled locations in September's partition (%v), want 1", moved, err)
+	}
+	check("after the backfill")
+
+	// Retention drops whole months and trims the one the cutoff falls in
+	deleted, err := repo.DeleteOldLocations(ctx, at(time.October, 1, 0, 5))
+	if err != nil {
+		t.Fatalf("DeleteOldLocations: %v", err)
+	}
+	if deleted != 3 {
+		t.Errorf("retention deleted %d locations, want 3", deleted)
+	}
+	names, err := env.DB.ListCollectionNames(ctx, bson.M{"name": bson.M{"$regex": "^locations_"}})
+	if err != nil {
+		t.Fatalf("ListCollectionNames: %v", err)
+	}
+	sort.Strings(names)
+	if got := strings.Join(names, ","); got != "locations_2025_10" {
+		t.Errorf("partitions after retention %s, want October only", got)
+	}
+	history, total, err := repo.GetLocationHistory(ctx, userID, nil, nil, 1, 10)
+	if err != nil {
+		t.Fatalf("GetLocationHistory: %v", err)
+	}
+	if got := times(history); total != 2 || got != "10-15 12:00,10-01 00:10" {
+		t.Errorf("history after retention %s (%d), want October's", got, total)
+	}
+}
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"ftrack/models"
+	"ftrack/repositories"
+	"ftrack/utils"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Users can have this many active location reminders
+const maxActiveLocationReminders = 50
+
+// LocationReminderService fires "remind me when I get there" notifications
+// when the user arrives at a place. Reminders are private to their user.
+type LocationReminderService struct {
+	reminderRepo        *repositories.LocationReminderRepository
+	notificationRepo    *repositories.NotificationRepository
+	placeService        *PlaceService
+	notificationService NotificationDispatcher
+	validator           *utils.ValidationService
+}
+
+func NewLocationReminderService(
+	reminderRepo *repositories.LocationReminderRepository,
+	notificationRepo *repositories.NotificationRepository,
+	placeService *PlaceService,
+	notificationService NotificationDispatcher,
+) *LocationReminderService {
+	return &LocationReminderService{
+		reminderRepo:        reminderRepo,
+		notificationRepo:    notificationRepo,
+		placeService:        placeService,
+		notificationService: notificationService,
+		validator:           utils.NewValidationService(),
+	}
+}
+
+// CreateReminder sets a reminder on a place the user can see, or on every
+// place of a category
+func (rs *LocationReminderService) CreateReminder(ctx context.Context, userID string, req models.CreateLocationReminderRequest) (*models.LocationReminder, error) {
+	if validationErrors := rs.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+	if (req.PlaceID == "") == (req.Category == "") {
+		return nil, utils.NewValidationFailedError("set either placeId or category")
+	}
+
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	reminder := &models.LocationReminder{
+		UserID:    userObjectID,
+		Message:   req.Message,
+		Recurring: req.Recurring,
+		Important: req.Important,
+		Active:    true,
+	}
+
+	if req.PlaceID != "" {
+		place, err := rs.placeService.GetPlace(ctx, userID, req.PlaceID)
+		if err != nil {
+			return nil, err
+		}
+		reminder.PlaceID = &place.ID
+	} else {
+		if !utils.ValidatePlaceCategory(req.Category) {
+			return nil, utils.NewValidationFailedError("unknown place category")
+		}
+		reminder.Category = req.Category
+	}
+
+	if req.Recurring {
+		reminder.CooldownMinutes = req.CooldownMinutes
+		if reminder.CooldownMinutes == 0 {
+			reminder.CooldownMinutes = models.DefaultLocationReminderCooldown
+		}
+	}
+
+	active, err := rs.reminderRepo.CountActive(ctx, userObjectID)
+	if err != nil {
+		return nil, err
+	}
+	if active >= maxActiveLocationReminders {
+		return nil, errors.New("too many reminders")
+	}
+
+	if err := rs.reminderRepo.Create(ctx, reminder); err != nil {
+		return nil, err
+	}
+
+	return reminder, nil
+}
+
+func (rs *LocationReminderService) GetReminders(ctx context.Context, userID string) ([]models.LocationReminder, error) {
+	return rs.reminderRepo.GetUserReminders(ctx, userID)
+}
+
+func (rs *LocationReminderService) DeleteReminder(ctx context.Context, userID, reminderID string) error {
+	return rs.reminderRepo.Delete(ctx, userID, reminderID)
+}
+
+// HandlePlaceEntry fires the user's reminders for a place they just entered.
+// Outside important reminders, nothing fires during quiet hours and the
+// reminder waits for the next arrival.
+func (rs *LocationReminderService) HandlePlaceEntry(ctx context.Context, userID string, place models.Place) {
+	reminders, err := rs.reminderRepo.GetActiveForPlace(ctx, userID, place)
+	if err != nil {
+		logrus.Errorf("Failed to get location reminders for %s: %v", userID, err)
+		return
+	}
+	if len(reminders) == 0 {
+		return
+	}
+
+	now := time.Now()
+	quiet := rs.inQuietHours(ctx, userID, now)
+
+	for _, reminder := range reminders {
+		if quiet && !reminder.Important {
+			continue
+		}
+
+		claimed, err := rs.reminderRepo.ClaimTrigger(ctx, reminder, now)
+		if err != nil {
+			logrus.Errorf("Failed to trigger location reminder %s: %v", reminder.ID.Hex(), err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		rs.sendReminder(ctx, userID, place, reminder)
+	}
+}
+
+func (rs *LocationReminderService) inQuietHours(ctx context.Context, userID string, now time.Time) bool {
+	settings, err := rs.notificationRepo.GetPushSettings(ctx, userID)
+	if err != nil || settings == nil {
+		return false
+	}
+	return settings.QuietHours.ActiveAt(now)
+}
+
+func (rs *LocationReminderService) sendReminder(ctx context.Context, userID string, place models.Place, reminder models.LocationReminder) {
+	if rs.notificationService == nil {
+		return
+	}
+
+	priority := "normal"
+	if reminder.Important {
+		priority = "high"
+	}
+
+	err := rs.notificationService.SendNotification(ctx, models.SendNotificationRequest{
+		Recipients: []string{userID},
+		Title:      fmt.Sprintf("Reminder at %s", place.Name),
+		Message:    reminder.Message,
+		Type:       models.NotificationTypeLocationReminder,
+		Priority:   priority,
+		Category:   "place",
+		Data: map[string]interface{}{
+			"reminderId": reminder.ID.Hex(),
+			"placeId":    place.ID.Hex(),
+			"recurring":  reminder.Recurring,
+		},
+		DeliveryChannels: []string{"push"},
+	})
+	if err != nil {
+		logrus.Errorf("Failed to send location reminder %s: %v", reminder.ID.Hex(), err)
+	}
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"ftrack/models"
+	"ftrack/repositories"
+	"ftrack/testharness"
+	"ftrack/utils"
+)
+
+func newTestLocationReminderService(env *testharness.Env) *LocationReminderService {
+	return NewLocationReminderService(
+		repositories.NewLocationReminderRepository(env.DB),
+		repositories.NewNotificationRepository(env.DB),
+		NewPlaceService(env.Repos.Place, env.Repos.Circle, nil),
+		env.Notifier,
+	)
+}
+
+func TestLocationReminderTriggerAndClear(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	rs := newTestLocationReminderService(env)
+	ctx := context.Background()
+
+	user, stranger := env.Factory.User(), env.Factory.User()
+	userID := user.ID.Hex()
+	grocery := env.Factory.Place(user, func(place *models.Place) {
+		place.Name = "Grocery"
+		place.Category = "shopping"
+	})
+	home := env.Factory.Place(user)
+
+	if _, err := rs.CreateReminder(ctx, userID, models.CreateLocationReminderRequest{PlaceID: grocery.ID.Hex(), Category: "shopping", Message: "milk"}); utils.ValidationFailureReason(err) != "set either placeId or category" {
+		t.Errorf("reminder on a place and a category error = %v, want set either placeId or category", err)
+	}
+	if _, err := rs.CreateReminder(ctx, stranger.ID.Hex(), models.CreateLocationReminderRequest{PlaceID: grocery.ID.Hex(), Message: "milk"}); err == nil {
+		t.Error("reminder on someone else's place was created")
+	}
+
+	once, err := rs.CreateReminder(ctx, userID, models.CreateLocationReminderRequest{PlaceID: grocery.ID.Hex(), Message: "buy milk"})
+	if err != nil {
+		t.Fatalf("CreateReminder: %v", err)
+	}
+	recurring, err := rs.CreateReminder(ctx, userID, models.CreateLocationReminderRequest{Category: "shopping", Message: "bring bags", Recurring: true})
+	if err != nil {
+		t.Fatalf("CreateReminder: %v", err)
+	}
+	if recurring.CooldownMinutes != models.DefaultLocationReminderCooldown {
+		t.Errorf("cooldown = %d, want the default %d", recurring.CooldownMinutes, models.DefaultLocationReminderCooldown)
+	}
+
+	// Arriving somewhere else fires nothing
+	rs.HandlePlaceEntry(ctx, userID, *home)
+	if sent := env.Notifier.Sent(models.NotificationTypeLocationReminder); len(sent) != 0 {
+		t.Fatalf("arriving home sent %d reminders, want none", len(sent))
+	}
+
+	// Arriving at the grocery fires both
+	rs.HandlePlaceEntry(ctx, userID, *grocery)
+	sent := env.Notifier.Sent(models.NotificationTypeLocationReminder)
+	if len(sent) != 2 {
+		t.Fatalf("arriving sent %d reminders, want 2", len(sent))
+	}
+	for _, req := range sent {
+		if len(req.Recipients) != 1 || req.Recipients[0] != userID || req.Title != "Reminder at Grocery" {
+			t.Errorf("reminder = %+v, want one to the user about the grocery", req)
+		}
+	}
+
+	// The one-time reminder is cleared; the recurring one waits out its cooldown
+	reminders, err := rs.GetReminders(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetReminders: %v", err)
+	}
+	for _, reminder := range reminders {
+		switch reminder.ID {
+		case once.ID:
+			if reminder.Active || reminder.ClearedAt == nil || reminder.TriggerCount != 1 {
+				t.Errorf("one-time reminder = %+v, want cleared after one trigger", reminder)
+			}
+		case recurring.ID:
+			if !reminder.Active || reminder.LastTriggeredAt == nil || reminder.TriggerCount != 1 {
+				t.Errorf("recurring reminder = %+v, want active after one trigger", reminder)
+			}
+		}
+	}
+
+	rs.HandlePlaceEntry(ctx, userID, *grocery)
+	if sent := env.Notifier.Sent(models.NotificationTypeLocationReminder); len(sent) != 2 {
+		t.Errorf("arriving again sent %d more reminders, want none", len(sent)-2)
+	}
+
+	if err := rs.DeleteReminder(ctx, stranger.ID.Hex(), recurring.ID.Hex()); err == nil || err.Error() != "reminder not found" {
+		t.Errorf("deleting someone else's reminder error = %v, want reminder not found", err)
+	}
+	if err := rs.DeleteReminder(ctx, userID, recurring.ID.Hex()); err != nil {
+		t.Errorf("DeleteReminder: %v", err)
+	}
+}
+
+func TestLocationReminderQuietHours(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	rs := newTestLocationReminderService(env)
+	ctx := context.Background()
+
+	user := env.Factory.User()
+	userID := user.ID.Hex()
+	place := env.Factory.Place(user)
+
+	now := time.Now().UTC()
+	err := repositories.NewNotificationRepository(env.DB).CreatePushSettings(ctx, &models.PushSettings{
+		UserID: userID,
+		QuietHours: models.QuietHours{
+			Enabled:  true,
+			Timezone: "UTC",
+			Windows: []models.QuietHoursWindow{{
+				StartTime: now.Add(-time.Hour).Format("15:04"),
+				EndTime:   now.Add(time.Hour).Format("15:04"),
+			}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreatePushSettings: %v", err)
+	}
+
+	quiet, err := rs.CreateReminder(ctx, userID, models.CreateLocationReminderRequest{PlaceID: place.ID.Hex(), Message: "water plants"})
+	if err != nil {
+		t.Fatalf("CreateReminder: %v", err)
+	}
+	if _, err := rs.CreateReminder(ctx, userID, models.CreateLocationReminderRequest{PlaceID: place.ID.Hex(), Message: "take pills", Important: true}); err != nil {
+		t.Fatalf("CreateReminder: %v", err)
+	}
+
+	rs.HandlePlaceEntry(ctx, userID, *place)
+	sent := env.Notifier.Sent(models.NotificationTypeLocationReminder)
+	if len(sent) != 1 || sent[0].Message != "take pills" || sent[0].Priority != "high" {
+		t.Fatalf("reminders in quiet hours = %+v, want only the important one", sent)
+	}
+
+	// The held back reminder waits for the next arrival
+	reminders, _ := rs.GetReminders(ctx, userID)
+	for _, reminder := range reminders {
+		if reminder.ID == quiet.ID && (!reminder.Active || reminder.TriggerCount != 0) {
+			t.Errorf("reminder held back by quiet hours = %+v, want active and not triggered", reminder)
+		}
+	}
+}
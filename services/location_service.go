@@ -3,13 +3,19 @@ package services
 import (
 	"context"
 	"errors"
+	"fmt"
 	"ftrack/models"
 	"ftrack/repositories"
 	"ftrack/utils"
 	"ftrack/websocket"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type LocationService struct {
@@ -17,9 +23,18 @@ type LocationService struct {
 	circleRepo      *repositories.CircleRepository
 	placeRepo       *repositories.PlaceRepository
 	userRepo        *repositories.UserRepository
+	blockRepo       *repositories.BlockRepository
 	geofenceService *GeofenceService
 	websocketHub    *websocket.Hub
 	validator       *utils.ValidationService
+	trackingHints   *TrackingHintService
+	reminders       *LocationReminderService
+	placeLists      *PlaceListService
+	outbox          *OutboxService
+	integrity       *LocationIntegrityService
+	placeService    *PlaceService // owner visit notifications, optional
+
+	notificationService NotificationDispatcher // offline alerts and safety reports, optional
 }
 
 func NewLocationService(
@@ -27,6 +42,7 @@ func NewLocationService(
 	circleRepo *repositories.CircleRepository,
 	placeRepo *repositories.PlaceRepository,
 	userRepo *repositories.UserRepository,
+	blockRepo *repositories.BlockRepository,
 	geofenceService *GeofenceService,
 	websocketHub *websocket.Hub,
 ) *LocationService {
@@ -35,19 +51,77 @@ func NewLocationService(
 		circleRepo:      circleRepo,
 		placeRepo:       placeRepo,
 		userRepo:        userRepo,
+		blockRepo:       blockRepo,
 		geofenceService: geofenceService,
 		websocketHub:    websocketHub,
 		validator:       utils.NewValidationService(),
 	}
 }
 
+// ConfigureTrackingHints enables reporting interval recommendations on
+// location updates
+func (ls *LocationService) ConfigureTrackingHints(trackingHints *TrackingHintService) {
+	ls.trackingHints = trackingHints
+}
+
+// ConfigureLocationReminders fires the user's place reminders when they
+// arrive somewhere
+func (ls *LocationService) ConfigureLocationReminders(reminders *LocationReminderService) {
+	ls.reminders = reminders
+}
+
+// ConfigurePlaceLists nudges members arriving at a place about the circle
+// lists linked to it
+func (ls *LocationService) ConfigurePlaceLists(placeLists *PlaceListService) {
+	ls.placeLists = placeLists
+}
+
+// ConfigureOutbox delivers arrivals and departures through the outbox
+// instead of a fire-and-forget broadcast
+func (ls *LocationService) ConfigureOutbox(outbox *OutboxService) {
+	ls.outbox = outbox
+}
+
+// ConfigureLocationIntegrity scores updates with the spoofing heuristics
+func (ls *LocationService) ConfigureLocationIntegrity(integrity *LocationIntegrityService) {
+	ls.integrity = integrity
+}
+
+// ConfigurePlaceOwnerVisits tells shared places' owners about the visits
+// detected there
+func (ls *LocationService) ConfigurePlaceOwnerVisits(placeService *PlaceService) {
+	ls.placeService = placeService
+}
+
 // ==================== TRACKING METHODS ====================
 
+// UpdateLocationWithHint saves a location and returns it with the interval
+// the client should report at from now on
+func (ls *LocationService) UpdateLocationWithHint(ctx context.Context, userID string, location models.Location) (*models.LocationUpdateResponse, error) {
+	saved, err := ls.UpdateLocation(ctx, userID, location)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &models.LocationUpdateResponse{Location: saved}
+	if ls.trackingHints != nil {
+		response.Tracking, err = ls.trackingHints.CurrentHint(ctx, userID)
+		if err != nil {
+			logrus.Warnf("Failed to get tracking hint for user %s: %v", userID, err)
+		}
+	}
+
+	return response, nil
+}
+
 func (ls *LocationService) UpdateLocation(ctx context.Context, userID string, location models.Location) (*models.Location, error) {
 	// Validate location
 	if !utils.IsValidCoordinate(location.Latitude, location.Longitude) {
 		return nil, errors.New("invalid coordinates")
 	}
+	if err := ls.validateSafetyEvents(location.SafetyEvents); err != nil {
+		return nil, err
+	}
 
 	// Get user's circles
 	circles, err := ls.circleRepo.GetUserCircles(ctx, userID)
@@ -61,6 +135,12 @@ func (ls *LocationService) UpdateLocation(ctx context.Context, userID string, lo
 		logrus.Debug("No previous location found for user: ", userID)
 	}
 
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+	location.UserID = userObjectID
+
 	// Reverse geocoding (get address from coordinates)
 	location.Address = ls.getAddressFromCoordinates(location.Latitude, location.Longitude)
 
@@ -70,13 +150,39 @@ func (ls *LocationService) UpdateLocation(ctx context.Context, userID string, lo
 		return nil, err
 	}
 
-	// Check geofences and handle place events
+	if len(location.SafetyEvents) > 0 {
+		ls.recordSafetyEvents(ctx, userID, location)
+	}
+
+	// Check geofences and handle place events. This outlives the request.
 	if prevLocation != nil {
-		go ls.handleGeofenceEvents(ctx, userID, *prevLocation, location, circles)
+		Background.Go(ctx, func(ctx context.Context) {
+			ls.handleGeofenceEvents(ctx, userID, *prevLocation, location, circles)
+		})
 	}
 
 	// Broadcast location update via WebSocket
-	go ls.broadcastLocationUpdate(userID, location, circles)
+	Background.Go(ctx, func(context.Context) {
+		ls.broadcastLocationUpdate(userID, location, circles)
+	})
+
+	// Flag updates that look spoofed. This only informs circle admins and
+	// never rejects the update.
+	if ls.integrity != nil {
+		Background.Go(ctx, func(ctx context.Context) {
+			if err := ls.integrity.Evaluate(ctx, location, prevLocation); err != nil {
+				logrus.Warnf("Failed to evaluate location integrity for user %s: %v", userID, err)
+			}
+		})
+	}
+
+	// Recommend how often to report next. Changes are pushed to the user's
+	// devices, so updates over WebSocket get them too.
+	if ls.trackingHints != nil {
+		if _, err := ls.trackingHints.Evaluate(ctx, userID, location); err != nil {
+			logrus.Warnf("Failed to evaluate tracking hint for user %s: %v", userID, err)
+		}
+	}
 
 	return &location, nil
 }
@@ -105,6 +211,14 @@ func (ls *LocationService) BulkUpdateLocation(ctx context.Context, userID string
 		Errors:     errors,
 	}
 
+	if ls.trackingHints != nil {
+		hint, err := ls.trackingHints.CurrentHint(ctx, userID)
+		if err != nil {
+			logrus.Warnf("Failed to get tracking hint for user %s: %v", userID, err)
+		}
+		result.Tracking = hint
+	}
+
 	return result, nil
 }
 
@@ -122,10 +236,114 @@ func (ls *LocationService) GetCurrentLocation(ctx context.Context, requesterID,
 	if err != nil {
 		return nil, errors.New("location not found")
 	}
+	if err := ls.checkHistoryWindow(ctx, requesterID, targetUserID, location); err != nil {
+		return nil, err
+	}
+
+	// Someone looking at the user's location wants it live
+	if ls.trackingHints != nil && requesterID != targetUserID {
+		ls.trackingHints.RecordWatch(ctx, requesterID, []string{targetUserID})
+	}
 
 	return location, nil
 }
 
+// GetWhereabouts returns where a member is as coarsely as possible: the
+// place they're at when they share places, or else their city. It follows
+// the same sharing settings as their location on the map.
+func (ls *LocationService) GetWhereabouts(ctx context.Context, requesterID, targetUserID string) (*models.Whereabouts, error) {
+	hasPermission, err := ls.hasLocationPermission(ctx, requesterID, targetUserID)
+	if err != nil {
+		return nil, err
+	}
+	if !hasPermission {
+		return nil, errors.New("access denied")
+	}
+
+	user, err := ls.userRepo.GetByID(ctx, targetUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	sharing := user.LocationSharing
+	if requesterID != targetUserID {
+		shared, err := ls.sharesWithRequester(ctx, requesterID, targetUserID, sharing)
+		if err != nil {
+			return nil, err
+		}
+		if !shared {
+			return nil, errors.New("location sharing paused")
+		}
+	} else {
+		sharing.SharePlaces = true
+	}
+
+	location, err := ls.locationRepo.GetCurrentLocation(ctx, targetUserID)
+	if err != nil {
+		return nil, errors.New("location not found")
+	}
+	if err := ls.checkHistoryWindow(ctx, requesterID, targetUserID, location); err != nil {
+		return nil, err
+	}
+
+	whereabouts := &models.Whereabouts{
+		FirstName:  user.FirstName,
+		Area:       location.City,
+		RecordedAt: location.RecordedAt(),
+	}
+	if sharing.SharePlaces && location.IsAtPlace {
+		whereabouts.PlaceName = location.PlaceName
+	}
+
+	return whereabouts, nil
+}
+
+// historySince is where the target's history the requester may see
+// starts, or nil when they may see all of it
+func (ls *LocationService) historySince(ctx context.Context, requesterID, targetUserID string) (*time.Time, error) {
+	if requesterID == targetUserID {
+		return nil, nil
+	}
+
+	user, err := ls.userRepo.GetByID(ctx, targetUserID)
+	if err != nil {
+		return nil, err
+	}
+	return user.LocationSharing.HistoryVisibleSince(time.Now()), nil
+}
+
+// checkHistoryWindow hides a location recorded before the history the
+// requester may see of the target
+func (ls *LocationService) checkHistoryWindow(ctx context.Context, requesterID, targetUserID string, location *models.Location) error {
+	since, err := ls.historySince(ctx, requesterID, targetUserID)
+	if err != nil {
+		return err
+	}
+	if since != nil && location.RecordedAt().Before(*since) {
+		return errors.New("location not found")
+	}
+	return nil
+}
+
+// sharesWithRequester reports whether the target currently shares their
+// location with a circle they have in common with the requester
+func (ls *LocationService) sharesWithRequester(ctx context.Context, requesterID, targetUserID string, sharing models.LocationSharing) (bool, error) {
+	requesterCircles, err := ls.circleRepo.GetUserCircles(ctx, requesterID)
+	if err != nil {
+		return false, err
+	}
+
+	for i := range requesterCircles {
+		circle := &requesterCircles[i]
+		if circle.Settings.LocationSharing && findCircleMember(circle, targetUserID) != nil &&
+			sharingIncludesCircle(sharing, circle.ID.Hex()) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
 func (ls *LocationService) GetLocationHistory(ctx context.Context, requesterID, targetUserID string, startTime, endTime *time.Time, page, pageSize int) (*models.LocationHistoryResponse, error) {
 	// Check permissions
 	hasPermission, err := ls.hasLocationPermission(ctx, requesterID, targetUserID)
@@ -136,6 +354,14 @@ func (ls *LocationService) GetLocationHistory(ctx context.Context, requesterID,
 		return nil, errors.New("access denied")
 	}
 
+	since, err := ls.historySince(ctx, requesterID, targetUserID)
+	if err != nil {
+		return nil, err
+	}
+	if since != nil && (startTime == nil || startTime.Before(*since)) {
+		startTime = since
+	}
+
 	history, total, err := ls.locationRepo.GetLocationHistory(ctx, targetUserID, startTime, endTime, page, pageSize)
 	if err != nil {
 		return nil, err
@@ -154,8 +380,103 @@ func (ls *LocationService) GetLocationHistory(ctx context.Context, requesterID,
 	return response, nil
 }
 
+// GetViewportHistory returns a member's points inside a map viewport and
+// time window, downsampled for the window. The member's sharing settings
+// apply as on the map, and the box is matched against fuzzed points.
+func (ls *LocationService) GetViewportHistory(ctx context.Context, requesterID string, req models.ViewportHistoryRequest) (*models.ViewportHistory, error) {
+	targetUserID := req.UserID
+	if targetUserID == "" {
+		targetUserID = requesterID
+	}
+
+	box, from, to, maxPoints, err := parseViewportHistory(req)
+	if err != nil {
+		return nil, err
+	}
+
+	hasPermission, err := ls.hasLocationPermission(ctx, requesterID, targetUserID)
+	if err != nil {
+		return nil, err
+	}
+	if !hasPermission {
+		return nil, errors.New("access denied")
+	}
+
+	user, err := ls.userRepo.GetByID(ctx, targetUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	sharing := user.LocationSharing
+	if requesterID != targetUserID {
+		shared, err := ls.sharesWithRequester(ctx, requesterID, targetUserID, sharing)
+		if err != nil {
+			return nil, err
+		}
+		if !shared {
+			return nil, errors.New("location sharing paused")
+		}
+		if since := sharing.HistoryVisibleSince(time.Now()); since != nil && from.Before(*since) {
+			from = *since
+		}
+	} else {
+		sharing.Precision = models.PrecisionExact
+		sharing.SharePlaces, sharing.ShareDriving = true, true
+	}
+	if sharing.Precision == "" {
+		sharing.Precision = models.PrecisionExact
+	}
+
+	locations, total, err := ls.locationRepo.GetViewportHistory(ctx, targetUserID, box, from, to, heatmapPrecisionCellSize[sharing.Precision], maxPoints)
+	if err != nil {
+		return nil, err
+	}
+
+	history := &models.ViewportHistory{
+		UserID:      targetUserID,
+		BBox:        box,
+		From:        from,
+		To:          to,
+		Precision:   sharing.Precision,
+		Points:      make([]models.HistoryPoint, 0, len(locations)),
+		TotalPoints: total,
+		MaxPoints:   maxPoints,
+		Downsampled: total > int64(len(locations)),
+	}
+	for i := range locations {
+		shared := sharedLocation(locations[i], sharing)
+		// The database rounds half to even, so a point on a cell edge
+		// can land outside
+		if !box.Contains(shared.Latitude, shared.Longitude) {
+			continue
+		}
+		history.Points = append(history.Points, models.HistoryPoint{
+			SharedLocation: *shared,
+			RecordedAt:     locations[i].RecordedAt(),
+		})
+	}
+
+	return history, nil
+}
+
 func (ls *LocationService) ClearLocationHistory(ctx context.Context, userID string) error {
-	return ls.locationRepo.ClearLocationHistory(ctx, userID)
+	if err := ls.locationRepo.ClearLocationHistory(ctx, userID); err != nil {
+		return err
+	}
+	ls.clearPlaceSuggestions(ctx, userID)
+	return nil
+}
+
+// clearPlaceSuggestions removes the open place suggestions found in the
+// user's history when it is cleared
+func (ls *LocationService) clearPlaceSuggestions(ctx context.Context, userID string) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return
+	}
+	if err := ls.placeRepo.DeletePendingPlaceSuggestions(ctx, userObjectID); err != nil {
+		logrus.Warnf("Failed to clear place suggestions of user %s: %v", userID, err)
+	}
 }
 
 // ==================== SHARING METHODS ====================
@@ -265,7 +586,12 @@ func (ls *LocationService) GetNearbyUsers(ctx context.Context, userID string, ra
 		circleIDs = append(circleIDs, circle.ID.Hex())
 	}
 
-	return ls.locationRepo.GetNearbyUsers(ctx, location.Latitude, location.Longitude, radius, circleIDs)
+	nearbyUsers, err := ls.locationRepo.GetNearbyUsers(ctx, location.Latitude, location.Longitude, radius, circleIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	return ls.filterBlockedUsers(ctx, userID, nearbyUsers)
 }
 
 func (ls *LocationService) GetNearbyCircleMembers(ctx context.Context, userID, circleID string, radius float64) ([]models.NearbyUser, error) {
@@ -284,7 +610,12 @@ func (ls *LocationService) GetNearbyCircleMembers(ctx context.Context, userID, c
 		return nil, errors.New("current location not found")
 	}
 
-	return ls.locationRepo.GetNearbyCircleMembers(ctx, location.Latitude, location.Longitude, radius, circleID)
+	nearbyMembers, err := ls.locationRepo.GetNearbyCircleMembers(ctx, location.Latitude, location.Longitude, radius, circleID)
+	if err != nil {
+		return nil, err
+	}
+
+	return ls.filterBlockedUsers(ctx, userID, nearbyMembers)
 }
 
 func (ls *LocationService) CreateProximityAlert(ctx context.Context, userID string, request models.ProximityAlertRequest) (*models.ProximityAlert, error) {
@@ -404,6 +735,8 @@ func (ls *LocationService) EndTrip(ctx context.Context, userID, tripID string) (
 		EndTime:  &now,
 		IsActive: &[]bool{false}[0],
 		Stats:    stats,
+		Tags:     ls.tripHomeTags(ctx, userID, tripID),
+		Safety:   ls.summarizeTripSafety(ctx, trip, stats),
 	}
 
 	err = ls.locationRepo.UpdateTrip(ctx, tripID, update)
@@ -670,6 +1003,281 @@ func (ls *LocationService) GetLocationHeatmap(ctx context.Context, userID, perio
 	return ls.locationRepo.GetLocationHeatmap(ctx, userID, period)
 }
 
+const (
+	memberHeatmapDefaultZoom = 12
+	memberHeatmapMaxRange    = 90 * 24 * time.Hour
+)
+
+// Smallest heatmap cell for each sharing precision, so a fuzzed member can't
+// be pinned down by zooming in
+var heatmapPrecisionCellSize = map[string]float64{
+	models.PrecisionExact:       0,
+	models.PrecisionApproximate: 0.01, // ~1km
+	models.PrecisionCity:        0.1,  // ~11km
+}
+
+// GetMemberVisitHeatmap clusters a circle member's locations and place
+// visits into cells sized for the map zoom. The requester needs location
+// permission in the circle, and the member's sharing settings apply.
+func (ls *LocationService) GetMemberVisitHeatmap(ctx context.Context, requesterID, circleID, memberID string, req models.MemberVisitHeatmapRequest) (*models.MemberVisitHeatmap, error) {
+	period, startDate, endDate, err := parseHeatmapPeriod(req)
+	if err != nil {
+		return nil, err
+	}
+
+	circle, err := ls.circleRepo.GetByID(ctx, circleID)
+	if err != nil {
+		return nil, err
+	}
+
+	requester := findCircleMember(circle, requesterID)
+	if requester == nil {
+		return nil, errors.New("access denied")
+	}
+	if findCircleMember(circle, memberID) == nil {
+		return nil, errors.New("member not found")
+	}
+
+	user, err := ls.userRepo.GetByID(ctx, memberID)
+	if err != nil {
+		return nil, err
+	}
+	sharing := user.LocationSharing
+
+	if requesterID != memberID {
+		if !circle.Settings.LocationSharing {
+			return nil, errors.New("access denied")
+		}
+		if requester.Role != "admin" && !requester.Permissions.CanSeeLocation {
+			return nil, errors.New("access denied")
+		}
+
+		blocked, err := ls.blockRepo.IsBlockedEitherWay(ctx, requesterID, memberID)
+		if err != nil {
+			return nil, err
+		}
+		if blocked {
+			return nil, errors.New("access denied")
+		}
+
+		if !sharingIncludesCircle(sharing, circleID) {
+			return nil, errors.New("location sharing paused")
+		}
+
+		if since := sharing.HistoryVisibleSince(time.Now()); since != nil && startDate.Before(*since) {
+			startDate = *since
+		}
+	}
+
+	zoom := req.Zoom
+	if zoom == 0 {
+		zoom = memberHeatmapDefaultZoom
+	}
+	if zoom < 1 || zoom > 18 {
+		return nil, errors.New("invalid zoom")
+	}
+
+	// Roughly eight cells across a 256px map tile
+	cellSize := 360 / (math.Pow(2, float64(zoom)) * 8)
+	if floor := heatmapPrecisionCellSize[sharing.Precision]; requesterID != memberID && cellSize < floor {
+		cellSize = floor
+	}
+
+	locationCounts, err := ls.locationRepo.GetLocationGridCounts(ctx, memberID, startDate, endDate, cellSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// Place visits reveal where the member's places are, so they follow
+	// the place sharing setting
+	var visitTotals []repositories.PlaceVisitTotal
+	if requesterID == memberID || sharing.SharePlaces {
+		visitTotals, err = ls.placeRepo.GetVisitTotalsByPlace(ctx, memberID, startDate, endDate)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	heatmap := &models.MemberVisitHeatmap{
+		CircleID:  circleID,
+		UserID:    memberID,
+		Period:    period,
+		StartDate: startDate,
+		EndDate:   endDate,
+		Zoom:      zoom,
+		CellSize:  cellSize,
+		Precision: sharing.Precision,
+		Generated: time.Now(),
+	}
+	heatmap.Cells, heatmap.Bounds = clusterHeatmapCells(locationCounts, visitTotals, cellSize)
+
+	for _, count := range locationCounts {
+		heatmap.TotalLocations += count.Count
+	}
+	for _, total := range visitTotals {
+		heatmap.TotalVisits += total.Visits
+	}
+
+	return heatmap, nil
+}
+
+// GetCircleLocations returns every member's latest location in one response.
+// Blocked members are left out, paused members are listed without a
+// location, and locations are fuzzed to each member's sharing precision.
+func (ls *LocationService) GetCircleLocations(ctx context.Context, requesterID, circleID string) (*models.CircleLocationSnapshot, error) {
+	circle, err := ls.circleRepo.GetByID(ctx, circleID)
+	if err != nil {
+		return nil, err
+	}
+
+	requester := findCircleMember(circle, requesterID)
+	if requester == nil || requester.Status != "active" {
+		return nil, errors.New("access denied")
+	}
+	canSeeOthers := circle.Settings.LocationSharing &&
+		(requester.Role == "admin" || requester.Permissions.CanSeeLocation)
+
+	blocked, err := ls.blockRepo.GetRelatedUserIDs(ctx, requesterID)
+	if err != nil {
+		return nil, err
+	}
+
+	var memberIDs []string
+	for _, member := range circle.Members {
+		memberID := member.UserID.Hex()
+		if member.Status != "active" || blocked[memberID] {
+			continue
+		}
+		if memberID != requesterID && !canSeeOthers {
+			continue
+		}
+		memberIDs = append(memberIDs, memberID)
+	}
+
+	users, err := ls.userRepo.GetUsersByIDs(ctx, memberIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	latest, err := ls.locationRepo.GetLatestLocations(ctx, memberIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	staleAfter, offlineAfter := circle.Settings.LocationStaleness()
+	snapshot := &models.CircleLocationSnapshot{
+		CircleID:     circleID,
+		Members:      make([]models.MemberLocationStatus, 0, len(users)),
+		StaleAfter:   int(staleAfter.Seconds()),
+		OfflineAfter: int(offlineAfter.Seconds()),
+		Generated:    now,
+	}
+
+	var watched, sharesPlaces []string
+	for _, user := range users {
+		userID := user.ID.Hex()
+		status := models.MemberLocationStatus{
+			UserID:         userID,
+			FirstName:      user.FirstName,
+			LastName:       user.LastName,
+			ProfilePicture: user.ProfilePicture,
+		}
+
+		self := userID == requesterID
+		location, hasLocation := latest[userID]
+		// A location older than the history the member shows others is
+		// left out, like one they never sent
+		if since := user.LocationSharing.HistoryVisibleSince(now); !self && hasLocation && since != nil && location.RecordedAt().Before(*since) {
+			hasLocation = false
+		}
+		switch {
+		case !self && !sharingIncludesCircle(user.LocationSharing, circleID):
+			status.Status = models.MemberLocationPaused
+		case !hasLocation:
+			status.Status = models.MemberLocationUnavailable
+		default:
+			status.Status = models.MemberLocationSharing
+			sharing := user.LocationSharing
+			if self {
+				sharing.Precision = models.PrecisionExact
+				sharing.SharePlaces, sharing.ShareDriving, sharing.ShareBattery = true, true, true
+			}
+
+			status.Location = sharedLocation(location, sharing)
+			if sharing.ShareBattery {
+				status.BatteryLevel = &location.BatteryLevel
+				status.IsCharging = &location.IsCharging
+			}
+
+			recordedAt := location.RecordedAt()
+			status.LastUpdated = &recordedAt
+			status.Staleness = models.LocationStalenessAt(recordedAt, now, staleAfter, offlineAfter)
+			status.IsStale = status.Staleness != models.LocationFresh
+			watched = append(watched, userID)
+
+			if sharing.SharePlaces {
+				sharesPlaces = append(sharesPlaces, userID)
+			}
+		}
+
+		snapshot.Members = append(snapshot.Members, status)
+	}
+
+	// Open visits, so "at place" displays start live
+	visits, err := ls.currentVisits(ctx, sharesPlaces, now)
+	if err != nil {
+		logrus.Warnf("Failed to get current visits for circle %s: %v", circleID, err)
+	}
+	for i := range snapshot.Members {
+		snapshot.Members[i].CurrentVisit = visits[snapshot.Members[i].UserID]
+	}
+
+	// Members on someone's map should report live while it is open
+	if ls.trackingHints != nil {
+		ls.trackingHints.RecordWatch(ctx, requesterID, watched)
+	}
+
+	return snapshot, nil
+}
+
+// sharedLocation fuzzes a location to the sharing precision and leaves out
+// what the member doesn't share
+func sharedLocation(location models.Location, sharing models.LocationSharing) *models.SharedLocation {
+	shared := &models.SharedLocation{
+		Latitude:  location.Latitude,
+		Longitude: location.Longitude,
+		Accuracy:  location.Accuracy,
+		Precision: sharing.Precision,
+		Address:   location.Address,
+	}
+	if shared.Precision == "" {
+		shared.Precision = models.PrecisionExact
+	}
+
+	if cellSize := heatmapPrecisionCellSize[shared.Precision]; cellSize > 0 {
+		shared.Latitude = math.Round(location.Latitude/cellSize) * cellSize
+		shared.Longitude = math.Round(location.Longitude/cellSize) * cellSize
+		// Half a cell diagonal, in meters
+		shared.Accuracy = math.Max(location.Accuracy, cellSize*111000*math.Sqrt2/2)
+		shared.Address = ""
+		if shared.Precision == models.PrecisionCity {
+			shared.Address = location.City
+		}
+	}
+
+	if sharing.SharePlaces {
+		shared.PlaceName = location.PlaceName
+	}
+	if sharing.ShareDriving {
+		shared.IsDriving = location.IsDriving
+		shared.Speed = location.Speed
+		shared.MovementType = location.MovementType
+	}
+
+	return shared
+}
+
 func (ls *LocationService) GetLocationPatterns(ctx context.Context, userID string) (*models.LocationPatterns, error) {
 	return ls.locationRepo.GetLocationPatterns(ctx, userID)
 }
@@ -769,7 +1377,9 @@ func (ls *LocationService) ExportLocationData(ctx context.Context, userID string
 	}
 
 	// Start background export job
-	go ls.processLocationExport(ctx, export.ID.Hex())
+	Background.Go(ctx, func(ctx context.Context) {
+		ls.processLocationExport(ctx, export.ID.Hex())
+	})
 
 	return export, nil
 }
@@ -813,6 +1423,11 @@ func (ls *LocationService) PurgeLocationData(ctx context.Context, userID string,
 	if err != nil {
 		return nil, err
 	}
+	for _, dataType := range request.DataTypes {
+		if dataType == "locations" {
+			ls.clearPlaceSuggestions(ctx, userID)
+		}
+	}
 
 	return result, nil
 }
@@ -853,7 +1468,15 @@ func (ls *LocationService) GetLastKnownLocation(ctx context.Context, requesterID
 		return nil, errors.New("access denied")
 	}
 
-	return ls.locationRepo.GetLastKnownLocation(ctx, targetUserID)
+	location, err := ls.locationRepo.GetLastKnownLocation(ctx, targetUserID)
+	if err != nil {
+		return nil, err
+	}
+	if err := ls.checkHistoryWindow(ctx, requesterID, targetUserID, location); err != nil {
+		return nil, err
+	}
+
+	return location, nil
 }
 
 func (ls *LocationService) SendLocationPing(ctx context.Context, userID string, request models.LocationPingRequest) (*models.LocationPing, error) {
@@ -973,6 +1596,15 @@ func (ls *LocationService) hasLocationPermission(ctx context.Context, requesterI
 		return true, nil
 	}
 
+	// Blocks hide location in both directions
+	blocked, err := ls.blockRepo.IsBlockedEitherWay(ctx, requesterID, targetUserID)
+	if err != nil {
+		return false, err
+	}
+	if blocked {
+		return false, nil
+	}
+
 	// Check if users are in the same circle
 	requesterCircles, err := ls.circleRepo.GetUserCircles(ctx, requesterID)
 	if err != nil {
@@ -984,18 +1616,233 @@ func (ls *LocationService) hasLocationPermission(ctx context.Context, requesterI
 		return false, err
 	}
 
-	// Check for common circles with location sharing enabled
+	// Check for common circles with location sharing enabled. Deactivated
+	// members share nothing.
 	for _, reqCircle := range requesterCircles {
-		for _, targetCircle := range targetCircles {
-			if reqCircle.ID == targetCircle.ID && targetCircle.Settings.LocationSharing {
-				return true, nil
+		for i := range targetCircles {
+			targetCircle := &targetCircles[i]
+			if reqCircle.ID != targetCircle.ID || !targetCircle.Settings.LocationSharing {
+				continue
+			}
+			if member := findCircleMember(targetCircle, targetUserID); member != nil && member.Status == "deactivated" {
+				continue
 			}
+			return true, nil
 		}
 	}
 
 	return false, nil
 }
 
+func findCircleMember(circle *models.Circle, userID string) *models.CircleMember {
+	for i := range circle.Members {
+		if circle.Members[i].UserID.Hex() == userID {
+			return &circle.Members[i]
+		}
+	}
+	return nil
+}
+
+// sharingIncludesCircle reports whether the member currently shares their
+// location with the circle. An empty share list means every circle.
+func sharingIncludesCircle(sharing models.LocationSharing, circleID string) bool {
+	if !sharing.Enabled || sharing.StealthMode {
+		return false
+	}
+	if len(sharing.ShareWith) == 0 {
+		return true
+	}
+	for _, id := range sharing.ShareWith {
+		if id == circleID {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHeatmapPeriod resolves the heatmap period to a time range. Custom
+// ranges are whole days and capped at memberHeatmapMaxRange.
+func parseHeatmapPeriod(req models.MemberVisitHeatmapRequest) (string, time.Time, time.Time, error) {
+	endDate := time.Now()
+	period := req.Period
+	if period == "" {
+		period = "week"
+	}
+
+	switch period {
+	case "day":
+		return period, endDate.AddDate(0, 0, -1), endDate, nil
+	case "week":
+		return period, endDate.AddDate(0, 0, -7), endDate, nil
+	case "month":
+		return period, endDate.AddDate(0, -1, 0), endDate, nil
+	case "custom":
+		startDate, err := time.Parse("2006-01-02", req.StartDate)
+		if err != nil {
+			return "", time.Time{}, time.Time{}, errors.New("invalid date range")
+		}
+		lastDay, err := time.Parse("2006-01-02", req.EndDate)
+		if err != nil {
+			return "", time.Time{}, time.Time{}, errors.New("invalid date range")
+		}
+		endDate = lastDay.AddDate(0, 0, 1)
+		if !endDate.After(startDate) || endDate.Sub(startDate) > memberHeatmapMaxRange {
+			return "", time.Time{}, time.Time{}, errors.New("invalid date range")
+		}
+		return period, startDate, endDate, nil
+	default:
+		return "", time.Time{}, time.Time{}, errors.New("invalid period")
+	}
+}
+
+// parseViewportHistory checks a viewport history query. The window
+// defaults to the day before to, and to defaults to now.
+func parseViewportHistory(req models.ViewportHistoryRequest) (models.BoundingBox, time.Time, time.Time, int, error) {
+	box, err := parseBoundingBox(req.BBox)
+	if err != nil {
+		return box, time.Time{}, time.Time{}, 0, err
+	}
+
+	to := time.Now()
+	if req.To != "" {
+		if to, err = time.Parse(time.RFC3339, req.To); err != nil {
+			return box, time.Time{}, time.Time{}, 0, errors.New("invalid date range")
+		}
+	}
+	from := to.Add(-24 * time.Hour)
+	if req.From != "" {
+		if from, err = time.Parse(time.RFC3339, req.From); err != nil {
+			return box, time.Time{}, time.Time{}, 0, errors.New("invalid date range")
+		}
+	}
+	if !to.After(from) || to.Sub(from) > models.MaxHistoryWindow {
+		return box, time.Time{}, time.Time{}, 0, errors.New("invalid date range")
+	}
+
+	maxPoints := req.MaxPoints
+	if maxPoints == 0 {
+		maxPoints = models.DefaultHistoryPoints
+	}
+	if maxPoints < 1 || maxPoints > models.MaxHistoryPoints {
+		return box, time.Time{}, time.Time{}, 0, errors.New("invalid max points")
+	}
+
+	return box, from, to, maxPoints, nil
+}
+
+// parseBoundingBox parses minLon,minLat,maxLon,maxLat. A box crossing the
+// antimeridian has its minimum longitude greater than its maximum.
+func parseBoundingBox(value string) (models.BoundingBox, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return models.BoundingBox{}, errors.New("invalid bbox")
+	}
+
+	var coords [4]float64
+	for i, part := range parts {
+		coord, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || math.IsNaN(coord) {
+			return models.BoundingBox{}, errors.New("invalid bbox")
+		}
+		coords[i] = coord
+	}
+
+	box := models.BoundingBox{West: coords[0], South: coords[1], East: coords[2], North: coords[3]}
+	if box.West < -180 || box.West > 180 || box.East < -180 || box.East > 180 ||
+		box.South < -90 || box.North > 90 || box.South >= box.North || box.West == box.East {
+		return models.BoundingBox{}, errors.New("invalid bbox")
+	}
+	return box, nil
+}
+
+// clusterHeatmapCells merges location counts and place visits into grid
+// cells. Visits weigh by the minutes spent, on top of the location samples.
+func clusterHeatmapCells(locationCounts []repositories.HeatmapGridCount, visitTotals []repositories.PlaceVisitTotal, cellSize float64) ([]models.HeatmapCell, models.GeoBounds) {
+	type cellKey struct{ lat, lon int64 }
+	cells := make(map[cellKey]*models.HeatmapCell)
+
+	getCell := func(key cellKey) *models.HeatmapCell {
+		cell, exists := cells[key]
+		if !exists {
+			cell = &models.HeatmapCell{
+				Latitude:  (float64(key.lat) + 0.5) * cellSize,
+				Longitude: (float64(key.lon) + 0.5) * cellSize,
+			}
+			cells[key] = cell
+		}
+		return cell
+	}
+
+	for _, count := range locationCounts {
+		getCell(cellKey{count.LatIndex, count.LonIndex}).LocationCount += count.Count
+	}
+	for _, total := range visitTotals {
+		key := cellKey{
+			lat: int64(math.Floor(total.Latitude / cellSize)),
+			lon: int64(math.Floor(total.Longitude / cellSize)),
+		}
+		cell := getCell(key)
+		cell.VisitCount += total.Visits
+		cell.VisitDuration += total.Duration
+	}
+
+	result := make([]models.HeatmapCell, 0, len(cells))
+	var bounds models.GeoBounds
+	maxScore := 0.0
+	for _, cell := range cells {
+		if score := heatmapCellScore(cell); score > maxScore {
+			maxScore = score
+		}
+
+		if len(result) == 0 {
+			bounds.Southwest = models.Coordinate{Latitude: cell.Latitude, Longitude: cell.Longitude}
+			bounds.Northeast = bounds.Southwest
+		}
+		bounds.Southwest.Latitude = math.Min(bounds.Southwest.Latitude, cell.Latitude)
+		bounds.Southwest.Longitude = math.Min(bounds.Southwest.Longitude, cell.Longitude)
+		bounds.Northeast.Latitude = math.Max(bounds.Northeast.Latitude, cell.Latitude)
+		bounds.Northeast.Longitude = math.Max(bounds.Northeast.Longitude, cell.Longitude)
+
+		result = append(result, *cell)
+	}
+
+	for i := range result {
+		if maxScore > 0 {
+			result[i].Weight = heatmapCellScore(&result[i]) / maxScore
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Weight > result[j].Weight
+	})
+
+	return result, bounds
+}
+
+func heatmapCellScore(cell *models.HeatmapCell) float64 {
+	return float64(cell.LocationCount) + float64(cell.VisitDuration)/60
+}
+
+// filterBlockedUsers removes users on either side of a block with the
+// requester, so neither sees the other on the map
+func (ls *LocationService) filterBlockedUsers(ctx context.Context, userID string, users []models.NearbyUser) ([]models.NearbyUser, error) {
+	relatedIDs, err := ls.blockRepo.GetRelatedUserIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(relatedIDs) == 0 {
+		return users, nil
+	}
+
+	visible := make([]models.NearbyUser, 0, len(users))
+	for _, user := range users {
+		if !relatedIDs[user.UserID] {
+			visible = append(visible, user)
+		}
+	}
+	return visible, nil
+}
+
 func (ls *LocationService) handleGeofenceEvents(ctx context.Context, userID string, prevLocation, newLocation models.Location, circles []models.Circle) {
 	// Get all geofences (places) for the user
 	places, _, err := ls.placeRepo.GetUserPlaces(ctx, userID, models.GetPlacesRequest{})
@@ -1027,6 +1874,30 @@ func (ls *LocationService) handleGeofenceEvents(ctx context.Context, userID stri
 	for _, event := range events {
 		if event.GeofenceIndex < len(places) {
 			place := places[event.GeofenceIndex]
+			for _, circle := range circles {
+				if circle.ID == place.CircleID {
+					place = ApplyPlaceSettings(place, circle.GeofenceDefaults)
+					break
+				}
+			}
+
+			// Open or close the visit first so the events can carry it
+			visit := ls.handlePlaceVisit(ctx, userID, place.ID.Hex(), event.EventType)
+
+			if event.EventType == "enter" && ls.reminders != nil {
+				ls.reminders.HandlePlaceEntry(ctx, userID, place)
+			}
+
+			if event.EventType == "enter" && ls.placeLists != nil {
+				ls.placeLists.HandlePlaceEntry(ctx, userID, place)
+			}
+
+			if event.EventType == "enter" && visit != nil && ls.placeService != nil {
+				ownedPlace := place
+				Background.Go(ctx, func(ctx context.Context) {
+					ls.placeService.NotifyOwnerOfVisit(ctx, &ownedPlace, visit)
+				})
+			}
 
 			// Create place event
 			placeEvent := models.WSPlaceEvent{
@@ -1036,6 +1907,12 @@ func (ls *LocationService) handleGeofenceEvents(ctx context.Context, userID stri
 				EventType: event.EventType,
 				Timestamp: time.Now(),
 			}
+			if visit != nil {
+				arrivalTime := visit.ArrivalTime
+				placeEvent.VisitID = visit.ID.Hex()
+				placeEvent.ArrivalTime = &arrivalTime
+				placeEvent.Duration = visit.Duration
+			}
 
 			// Broadcast to circles if notifications enabled
 			if (event.EventType == "enter" && place.Notifications.OnArrival) ||
@@ -1046,22 +1923,42 @@ func (ls *LocationService) handleGeofenceEvents(ctx context.Context, userID stri
 					circleIDs = append(circleIDs, circle.ID.Hex())
 				}
 
-				ls.websocketHub.BroadcastPlaceEvent(userID, circleIDs, placeEvent)
+				eventKey := fmt.Sprintf("place_event:%s:%s:%s", newLocation.ID.Hex(), place.ID.Hex(), event.EventType)
+				ls.publishPlaceEvent(ctx, eventKey, userID, circleIDs, placeEvent)
 			}
 
-			// Handle place visit tracking
-			go ls.handlePlaceVisit(ctx, userID, place.ID.Hex(), event.EventType)
+			// Live visit displays follow every visit, notifications or not
+			if visit != nil {
+				ls.broadcastVisit(ctx, userID, circles, *visit, place.Name)
+			}
 		}
 	}
 }
 
-func (ls *LocationService) handlePlaceVisit(ctx context.Context, userID, placeID, eventType string) {
+// publishPlaceEvent sends an arrival or departure to the member's circles.
+// With the outbox, the event is stored once the visit is, so a crash
+// doesn't lose it.
+func (ls *LocationService) publishPlaceEvent(ctx context.Context, eventKey, userID string, circleIDs []string, placeEvent models.WSPlaceEvent) {
+	if ls.outbox == nil {
+		ls.websocketHub.BroadcastPlaceEvent(userID, circleIDs, placeEvent)
+		return
+	}
+
+	if err := ls.outbox.EnqueuePlaceEvent(ctx, eventKey, userID, circleIDs, placeEvent); err != nil {
+		logrus.Errorf("Failed to enqueue place event for user %s, broadcasting directly: %v", userID, err)
+		ls.websocketHub.BroadcastPlaceEvent(userID, circleIDs, placeEvent)
+	}
+}
+
+// handlePlaceVisit opens a visit on enter and closes it on exit, returning
+// the visit in its new state. It returns nil when there was nothing to do.
+func (ls *LocationService) handlePlaceVisit(ctx context.Context, userID, placeID, eventType string) *models.PlaceVisit {
 	if eventType == "enter" {
 		// Check for existing active visit
 		activeVisit, err := ls.placeRepo.GetActiveVisit(ctx, userID, placeID)
 		if err != nil {
 			logrus.Error("Failed to check active visit: ", err)
-			return
+			return nil
 		}
 
 		if activeVisit == nil {
@@ -1076,14 +1973,16 @@ func (ls *LocationService) handlePlaceVisit(ctx context.Context, userID, placeID
 			err = ls.placeRepo.CreateVisit(ctx, &visit)
 			if err != nil {
 				logrus.Error("Failed to create place visit: ", err)
+				return nil
 			}
+			return &visit
 		}
 	} else if eventType == "exit" {
 		// End active visit
 		activeVisit, err := ls.placeRepo.GetActiveVisit(ctx, userID, placeID)
 		if err != nil {
 			logrus.Error("Failed to check active visit: ", err)
-			return
+			return nil
 		}
 
 		if activeVisit != nil {
@@ -1098,9 +1997,16 @@ func (ls *LocationService) handlePlaceVisit(ctx context.Context, userID, placeID
 
 			if err != nil {
 				logrus.Error("Failed to update place visit: ", err)
+				return nil
 			}
+
+			activeVisit.DepartureTime = &departureTime
+			activeVisit.Duration = duration
+			activeVisit.IsOngoing = false
+			return activeVisit
 		}
 	}
+	return nil
 }
 
 func (ls *LocationService) broadcastLocationUpdate(userID string, location models.Location, circles []models.Circle) {
@@ -1111,9 +2017,22 @@ func (ls *LocationService) broadcastLocationUpdate(userID string, location model
 		}
 	}
 
-	if len(circleIDs) > 0 {
-		ls.websocketHub.BroadcastLocationUpdate(userID, circleIDs, location)
+	if len(circleIDs) == 0 {
+		return
+	}
+
+	// Users on either side of a block don't receive live updates
+	relatedIDs, err := ls.blockRepo.GetRelatedUserIDs(context.Background(), userID)
+	if err != nil {
+		logrus.Warnf("Failed to get blocks for %s: %v", userID, err)
+	}
+
+	var excludeUserIDs []string
+	for blockedID := range relatedIDs {
+		excludeUserIDs = append(excludeUserIDs, blockedID)
 	}
+
+	ls.websocketHub.BroadcastLocationUpdateExcept(userID, circleIDs, location, excludeUserIDs)
 }
 
 func (ls *LocationService) getAddressFromCoordinates(lat, lon float64) string {
@@ -1122,6 +2041,38 @@ func (ls *LocationService) getAddressFromCoordinates(lat, lon float64) string {
 	return "Address not available"
 }
 
+// tripHomeTags tags a trip that starts or ends at the home the member has
+// set for themselves
+func (ls *LocationService) tripHomeTags(ctx context.Context, userID, tripID string) []string {
+	homeID, err := ls.placeRepo.GetHomePlaceID(ctx, utils.ObjectIDFromHex(userID))
+	if err != nil || homeID.IsZero() {
+		return nil
+	}
+	home, err := ls.placeRepo.GetByID(ctx, homeID.Hex())
+	if err != nil {
+		logrus.Warnf("Failed to get home place of user %s: %v", userID, err)
+		return nil
+	}
+
+	locations, err := ls.locationRepo.GetTripLocations(ctx, tripID)
+	if err != nil || len(locations) == 0 {
+		return nil
+	}
+
+	atHome := func(loc models.Location) bool {
+		return utils.CalculateDistance(home.Latitude, home.Longitude, loc.Latitude, loc.Longitude) <= float64(home.Radius)
+	}
+
+	var tags []string
+	if atHome(locations[0]) {
+		tags = append(tags, models.TripTagFromHome)
+	}
+	if atHome(locations[len(locations)-1]) {
+		tags = append(tags, models.TripTagToHome)
+	}
+	return tags
+}
+
 func (ls *LocationService) calculateTripStats(ctx context.Context, tripID string) (*models.TripStats, error) {
 	// Get trip locations
 	locations, err := ls.locationRepo.GetTripLocations(ctx, tripID)
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"ftrack/models"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ConfigureOfflineAlerts lets circles that opt in tell their members when
+// another member's location stops updating
+func (ls *LocationService) ConfigureOfflineAlerts(notificationService NotificationDispatcher) {
+	ls.notificationService = notificationService
+}
+
+// CheckOfflineMembers goes through the circles with offline alerts on, up
+// to batchSize at a time. Members whose location went offline since the
+// last check are announced to the circle once; those who are back are
+// announced again, without a notification. It returns how many members
+// went offline.
+func (ls *LocationService) CheckOfflineMembers(ctx context.Context, batchSize int) (int, error) {
+	alerted := 0
+	var afterID primitive.ObjectID
+	for {
+		circles, err := ls.circleRepo.GetCirclesWithOfflineAlerts(ctx, afterID, batchSize)
+		if err != nil {
+			return alerted, err
+		}
+
+		for i := range circles {
+			if err := ctx.Err(); err != nil {
+				return alerted, err
+			}
+
+			count, err := ls.checkCircleOfflineMembers(ctx, &circles[i], time.Now())
+			alerted += count
+			if err != nil {
+				logrus.Warnf("Failed to check offline members of circle %s: %v", circles[i].ID.Hex(), err)
+			}
+		}
+
+		if len(circles) < batchSize {
+			return alerted, nil
+		}
+		afterID = circles[len(circles)-1].ID
+	}
+}
+
+func (ls *LocationService) checkCircleOfflineMembers(ctx context.Context, circle *models.Circle, now time.Time) (int, error) {
+	if !circle.Settings.LocationSharing {
+		return 0, nil
+	}
+
+	circleID := circle.ID.Hex()
+	var memberIDs []string
+	for _, member := range circle.Members {
+		if member.Status == "active" {
+			memberIDs = append(memberIDs, member.UserID.Hex())
+		}
+	}
+
+	users, err := ls.userRepo.GetUsersByIDs(ctx, memberIDs)
+	if err != nil {
+		return 0, err
+	}
+	latest, err := ls.locationRepo.GetLatestLocations(ctx, memberIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	staleAfter, offlineAfter := circle.Settings.LocationStaleness()
+	alerted := 0
+	for i := range users {
+		user := &users[i]
+		userID := user.ID.Hex()
+		member := findCircleMember(circle, userID)
+		location, hasLocation := latest[userID]
+		if member == nil || !hasLocation {
+			continue
+		}
+
+		recordedAt := location.RecordedAt()
+		staleness := models.LocationStalenessAt(recordedAt, now, staleAfter, offlineAfter)
+		// Paused sharing is on purpose, not a dead phone
+		tracked := staleness == models.LocationOffline && sharingIncludesCircle(user.LocationSharing, circleID)
+
+		switch {
+		case tracked && member.OfflineAlertedAt == nil:
+			if err := ls.circleRepo.SetMemberOfflineAlerted(ctx, circle.ID, user.ID, &now); err != nil {
+				return alerted, err
+			}
+			ls.broadcastLocationStatus(circleID, userID, staleness, recordedAt)
+			ls.sendOfflineAlert(ctx, circle, user, now.Sub(recordedAt))
+			alerted++
+
+		case !tracked && member.OfflineAlertedAt != nil:
+			if err := ls.circleRepo.SetMemberOfflineAlerted(ctx, circle.ID, user.ID, nil); err != nil {
+				return alerted, err
+			}
+			if staleness != models.LocationOffline {
+				ls.broadcastLocationStatus(circleID, userID, staleness, recordedAt)
+			}
+		}
+	}
+
+	return alerted, nil
+}
+
+// broadcastLocationStatus updates the member's presence on the circle's
+// open maps
+func (ls *LocationService) broadcastLocationStatus(circleID, userID, staleness string, recordedAt time.Time) {
+	if ls.websocketHub == nil {
+		return
+	}
+
+	ls.websocketHub.BroadcastUserStatus([]string{circleID}, models.WSUserStatus{
+		UserID:         userID,
+		IsOnline:       staleness != models.LocationOffline,
+		LastSeen:       recordedAt,
+		Timestamp:      time.Now(),
+		LocationStatus: staleness,
+		LastLocationAt: &recordedAt,
+	})
+}
+
+// sendOfflineAlert tells the circle's members who can see the member's
+// location that it stopped updating
+func (ls *LocationService) sendOfflineAlert(ctx context.Context, circle *models.Circle, user *models.User, offlineFor time.Duration) {
+	if ls.notificationService == nil {
+		return
+	}
+
+	var recipients []string
+	for _, member := range circle.Members {
+		if member.Status != "active" || member.UserID == user.ID {
+			continue
+		}
+		if member.Role == "admin" || member.Permissions.CanSeeLocation {
+			recipients = append(recipients, member.UserID.Hex())
+		}
+	}
+	if len(recipients) == 0 {
+		return
+	}
+
+	userID := user.ID.Hex()
+	name := strings.TrimSpace(user.FirstName + " " + user.LastName)
+	err := ls.notificationService.SendNotification(ctx, models.SendNotificationRequest{
+		Recipients: recipients,
+		Title:      "Member offline",
+		Message:    fmt.Sprintf("%s's location in %s hasn't updated for %s. Their phone may be off or out of battery.", name, circle.Name, formatOfflineDuration(offlineFor)),
+		Type:       models.NotificationTypeMemberOffline,
+		Priority:   "normal",
+		Category:   "circle",
+		Data: map[string]interface{}{
+			"circleId":   circle.ID.Hex(),
+			"userId":     userID,
+			"offlineFor": int(offlineFor.Seconds()),
+		},
+		SubjectUserID: userID,
+	})
+	if err != nil {
+		logrus.Errorf("Failed to send offline alert for user %s in circle %s: %v", userID, circle.ID.Hex(), err)
+	}
+}
+
+func formatOfflineDuration(d time.Duration) string {
+	switch {
+	case d >= 48*time.Hour:
+		return fmt.Sprintf("%d days", int(d/(24*time.Hour)))
+	case d >= 2*time.Hour:
+		return fmt.Sprintf("%d hours", int(d/time.Hour))
+	default:
+		return fmt.Sprintf("%d minutes", int(d/time.Minute))
+	}
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"ftrack/models"
+	"ftrack/testharness"
+	"ftrack/utils"
+)
+
+func TestLocationStaleness(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		settings models.CircleSettings
+		age      time.Duration
+		want     string
+	}{
+		{"just updated", models.CircleSettings{}, time.Minute, models.LocationFresh},
+		{"at the stale threshold", models.CircleSettings{}, 15 * time.Minute, models.LocationFresh},
+		{"past the stale threshold", models.CircleSettings{}, 16 * time.Minute, models.LocationStale},
+		{"at the offline threshold", models.CircleSettings{}, 2 * time.Hour, models.LocationStale},
+		{"phone died", models.CircleSettings{}, 2*time.Hour + time.Second, models.LocationOffline},
+		{"circle's stale threshold", models.CircleSettings{StaleAfterMinutes: 5, OfflineAfterMinutes: 30}, 6 * time.Minute, models.LocationStale},
+		{"circle's offline threshold", models.CircleSettings{StaleAfterMinutes: 5, OfflineAfterMinutes: 30}, 31 * time.Minute, models.LocationOffline},
+		{"stale threshold past the default offline one", models.CircleSettings{StaleAfterMinutes: 180}, 150 * time.Minute, models.LocationFresh},
+		{"offline no sooner than stale", models.CircleSettings{StaleAfterMinutes: 180}, 181 * time.Minute, models.LocationOffline},
+	}
+	for _, tt := range tests {
+		staleAfter, offlineAfter := tt.settings.LocationStaleness()
+		if got := models.LocationStalenessAt(now.Add(-tt.age), now, staleAfter, offlineAfter); got != tt.want {
+			t.Errorf("%s: location %s after %s, want %s", tt.name, got, tt.age, tt.want)
+		}
+	}
+
+	for d, want := range map[time.Duration]string{
+		2*time.Hour + 59*time.Minute: "2 hours",
+		90 * time.Minute:             "90 minutes",
+		50 * time.Hour:               "2 days",
+	} {
+		if got := formatOfflineDuration(d); got != want {
+			t.Errorf("offline for %s reads %q, want %q", d, got, want)
+		}
+	}
+}
+
+func TestCheckLocationStaleness(t *testing.T) {
+	tests := []struct {
+		stale, offline int
+		err            string
+	}{
+		{0, 0, ""},
+		{10, 0, ""},
+		{10, 60, ""},
+		{60, 60, ""},
+		{-1, 0, "staleness thresholds can't be negative"},
+		{60, 30, "offlineAfterMinutes can't be less than staleAfterMinutes"},
+		{0, models.MaxLocationOfflineAfterMinutes + 1, "members can show as offline after at most 10080 minutes"},
+	}
+	for _, tt := range tests {
+		err := checkLocationStaleness(models.CircleSettings{StaleAfterMinutes: tt.stale, OfflineAfterMinutes: tt.offline})
+		if reason := utils.ValidationFailureReason(err); reason != tt.err {
+			t.Errorf("thresholds %d/%d error = %v, want %q", tt.stale, tt.offline, err, tt.err)
+		}
+	}
+}
+
+func TestCircleLocationSnapshotStaleness(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ls := newTestLocationService(env)
+	now := time.Now()
+
+	admin, fresh, stale, dead := env.Factory.User(), env.Factory.User(), env.Factory.User(), env.Factory.User()
+	paused := env.Factory.User(func(user *models.User) { user.LocationSharing.Enabled = false })
+	circle := env.Factory.Circle(admin, []*models.User{fresh, stale, dead, paused}, func(circle *models.Circle) {
+		circle.Settings.StaleAfterMinutes, circle.Settings.OfflineAfterMinutes = 10, 60
+	})
+	storeLocationAt(t, env, fresh, 40.71, -74.00, now.Add(-time.Minute))
+	storeLocationAt(t, env, stale, 40.71, -74.00, now.Add(-30*time.Minute))
+	storeLocationAt(t, env, dead, 40.71, -74.00, now.Add(-3*time.Hour))
+	storeLocationAt(t, env, paused, 40.71, -74.00, now.Add(-3*time.Hour))
+
+	snapshot, err := ls.GetCircleLocations(context.Background(), admin.ID.Hex(), circle.ID.Hex())
+	if err != nil {
+		t.Fatalf("GetCircleLocations: %v", err)
+	}
+	if snapshot.StaleAfter != 600 || snapshot.OfflineAfter != 3600 {
+		t.Errorf("snapshot thresholds %d/%d seconds, want the circle's 600/3600", snapshot.StaleAfter, snapshot.OfflineAfter)
+	}
+
+	want := map[string]string{
+		admin.ID.Hex():  "unavailable/",
+		fresh.ID.Hex():  "sharing/fresh",
+		stale.ID.Hex():  "sharing/stale",
+		dead.ID.Hex():   "sharing/offline",
+		paused.ID.Hex(): "paused/",
+	}
+	for _, member := range snapshot.Members {
+		got := member.Status + "/" + member.Staleness
+		if got != want[member.UserID] || member.IsStale != (member.Staleness == models.LocationStale || member.Staleness == models.LocationOffline) {
+			t.Errorf("member %s is %s (stale %v), want %s", member.UserID, got, member.IsStale, want[member.UserID])
+		}
+		delete(want, member.UserID)
+	}
+	if len(want) != 0 {
+		t.Errorf("snapshot is missing %v", want)
+	}
+}
+
+func TestOfflineMemberAlerts(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ls := newTestLocationService(env)
+	ls.ConfigureOfflineAlerts(env.Notifier)
+	ctx := context.Background()
+	now := time.Now()
+
+	admin, watcher := env.Factory.User(), env.Factory.User()
+	dead := env.Factory.User(named("Ana"))
+	paused := env.Factory.User(func(user *models.User) { user.LocationSharing.Enabled = false })
+	family := env.Factory.Circle(admin, []*models.User{watcher, dead, paused}, func(circle *models.Circle) {
+		circle.Name = "Family"
+		circle.Settings.OfflineAlerts = true
+	})
+	// The same member in a circle that didn't opt in
+	env.Factory.Circle(watcher, []*models.User{dead})
+
+	storeLocationAt(t, env, admin, 40.71, -74.00, now.Add(-time.Minute))
+	storeLocationAt(t, env, watcher, 40.71, -74.00, now.Add(-30*time.Minute))
+	storeLocationAt(t, env, dead, 40.71, -74.00, now.Add(-3*time.Hour-time.Minute))
+	storeLocationAt(t, env, paused, 40.71, -74.00, now.Add(-5*time.Hour))
+
+	check := func(want int) {
+		t.Helper()
+		alerted, err := ls.CheckOfflineMembers(ctx, 1)
+		if err != nil {
+			t.Fatalf("CheckOfflineMembers: %v", err)
+		}
+		if alerted != want {
+			t.Errorf("%d members went offline, want %d", alerted, want)
+		}
+	}
+	alertedAt := func() *time.Time {
+		t.Helper()
+		circle, err := env.Repos.Circle.GetByID(ctx, family.ID.Hex())
+		if err != nil {
+			t.Fatalf("GetByID: %v", err)
+		}
+		return findCircleMember(circle, dead.ID.Hex()).OfflineAlertedAt
+	}
+
+	// Going offline alerts the opted-in circle once, paused members aside
+	check(1)
+	sent := env.Notifier.Sent(models.NotificationTypeMemberOffline)
+	if len(sent) != 1 {
+		t.Fatalf("%d offline alerts, want 1", len(sent))
+	}
+	recipients := append([]string(nil), sent[0].Recipients...)
+	sort.Strings(recipients)
+	wantRecipients := []string{admin.ID.Hex(), watcher.ID.Hex(), paused.ID.Hex()}
+	sort.Strings(wantRecipients)
+	if strings.Join(recipients, ",") != strings.Join(wantRecipients, ",") {
+		t.Errorf("alert sent to %v, want the other members %v", recipients, wantRecipients)
+	}
+	data := sent[0].Data.(map[string]interface{})
+	if !strings.HasPrefix(sent[0].Message, "Ana's location in Family hasn't updated for 3 hours.") ||
+		sent[0].SubjectUserID != dead.ID.Hex() || data["circleId"] != family.ID.Hex() || data["offlineFor"].(int) < 3*60*60 {
+		t.Errorf("alert %q about %s with data %v, want Ana offline for 3 hours", sent[0].Message, sent[0].SubjectUserID, data)
+	}
+	if alertedAt() == nil {
+		t.Error("member not marked as alerted")
+	}
+
+	// Still offline, no repeat
+	check(0)
+	if sent := env.Notifier.Sent(models.NotificationTypeMemberOffline); len(sent) != 1 {
+		t.Errorf("%d offline alerts after checking again, want 1", len(sent))
+	}
+
+	// Back online clears the alert, without a notification
+	storeLocationAt(t, env, dead, 40.71, -74.00, time.Now())
+	check(0)
+	if at := alertedAt(); at != nil {
+		t.Errorf("member back online still alerted at %v", at)
+	}
+	if sent := env.Notifier.Sent(models.NotificationTypeMemberOffline); len(sent) != 1 {
+		t.Errorf("%d offline alerts after coming back, want 1", len(sent))
+	}
+}
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"context"
+	"ftrack/models"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Visits open longer than this most likely missed their departure, so they
+// aren't shown or updated live
+const liveVisitMaxAge = 12 * time.Hour
+
+// currentVisits returns the open visit of each user, the latest one when a
+// user is inside overlapping places
+func (ls *LocationService) currentVisits(ctx context.Context, userIDs []string, now time.Time) (map[string]*models.MemberVisit, error) {
+	current := make(map[string]*models.MemberVisit)
+	if len(userIDs) == 0 {
+		return current, nil
+	}
+
+	visits, err := ls.placeRepo.GetOngoingUserVisits(ctx, userIDs, now.Add(-liveVisitMaxAge))
+	if err != nil {
+		return current, err
+	}
+
+	placeNames, err := ls.visitPlaceNames(ctx, visits)
+	if err != nil {
+		return current, err
+	}
+
+	for _, visit := range visits {
+		userID := visit.UserID.Hex()
+		if existing := current[userID]; existing != nil && existing.ArrivalTime.After(visit.ArrivalTime) {
+			continue
+		}
+
+		current[userID] = &models.MemberVisit{
+			VisitID:     visit.ID.Hex(),
+			PlaceID:     visit.PlaceID.Hex(),
+			PlaceName:   placeNames[visit.PlaceID],
+			ArrivalTime: visit.ArrivalTime,
+			Duration:    int64(now.Sub(visit.ArrivalTime).Seconds()),
+		}
+	}
+
+	return current, nil
+}
+
+// BroadcastOngoingVisits sends the current duration of every open visit to
+// the circles watching it on this instance, and returns how many visits
+// were sent
+func (ls *LocationService) BroadcastOngoingVisits(ctx context.Context, batchSize int) (int, error) {
+	now := time.Now()
+	since := now.Add(-liveVisitMaxAge)
+
+	// A member's circles are looked up once per run
+	userCircles := make(map[string][]models.Circle)
+
+	sent := 0
+	afterID := primitive.NilObjectID
+	for {
+		visits, err := ls.placeRepo.GetOngoingVisits(ctx, since, afterID, batchSize)
+		if err != nil {
+			return sent, err
+		}
+		if len(visits) == 0 {
+			return sent, nil
+		}
+		afterID = visits[len(visits)-1].ID
+
+		placeNames, err := ls.visitPlaceNames(ctx, visits)
+		if err != nil {
+			return sent, err
+		}
+
+		for _, visit := range visits {
+			userID := visit.UserID.Hex()
+			circles, cached := userCircles[userID]
+			if !cached {
+				circles, err = ls.circleRepo.GetUserCircles(ctx, userID)
+				if err != nil {
+					logrus.Warnf("Failed to get circles for visit update of %s: %v", userID, err)
+				}
+				userCircles[userID] = circles
+			}
+
+			visit.Duration = int64(now.Sub(visit.ArrivalTime).Seconds())
+			if ls.broadcastVisit(ctx, userID, circles, visit, placeNames[visit.PlaceID]) {
+				sent++
+			}
+		}
+
+		if len(visits) < batchSize {
+			return sent, nil
+		}
+	}
+}
+
+// broadcastVisit sends a visit's state to the member's circles that see
+// their places and have someone connected. It reports whether anything was
+// sent.
+func (ls *LocationService) broadcastVisit(ctx context.Context, userID string, circles []models.Circle, visit models.PlaceVisit, placeName string) bool {
+	if ls.websocketHub == nil || len(circles) == 0 {
+		return false
+	}
+
+	user, err := ls.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		logrus.Warnf("Failed to get user %s for visit update: %v", userID, err)
+		return false
+	}
+	if !user.LocationSharing.SharePlaces {
+		return false
+	}
+
+	var circleIDs []string
+	for _, circle := range circles {
+		circleID := circle.ID.Hex()
+		if !circle.Settings.LocationSharing || !sharingIncludesCircle(user.LocationSharing, circleID) {
+			continue
+		}
+		if !ls.websocketHub.HasCircleClients(circleID) {
+			continue
+		}
+		circleIDs = append(circleIDs, circleID)
+	}
+	if len(circleIDs) == 0 {
+		return false
+	}
+
+	// Users on either side of a block don't receive live updates
+	relatedIDs, err := ls.blockRepo.GetRelatedUserIDs(ctx, userID)
+	if err != nil {
+		logrus.Warnf("Failed to get blocks for %s: %v", userID, err)
+	}
+
+	excludeUserIDs := []string{userID}
+	for blockedID := range relatedIDs {
+		excludeUserIDs = append(excludeUserIDs, blockedID)
+	}
+
+	ls.websocketHub.BroadcastVisitUpdate(circleIDs, models.WSVisitUpdate{
+		VisitID:       visit.ID.Hex(),
+		UserID:        userID,
+		PlaceID:       visit.PlaceID.Hex(),
+		PlaceName:     placeName,
+		ArrivalTime:   visit.ArrivalTime,
+		DepartureTime: visit.DepartureTime,
+		Duration:      visit.Duration,
+		IsOngoing:     visit.IsOngoing,
+		Timestamp:     time.Now(),
+	}, excludeUserIDs)
+
+	return true
+}
+
+func (ls *LocationService) visitPlaceNames(ctx context.Context, visits []models.PlaceVisit) (map[primitive.ObjectID]string, error) {
+	seen := make(map[primitive.ObjectID]bool)
+	var placeIDs []primitive.ObjectID
+	for _, visit := range visits {
+		if !seen[visit.PlaceID] {
+			seen[visit.PlaceID] = true
+			placeIDs = append(placeIDs, visit.PlaceID)
+		}
+	}
+
+	places, err := ls.placeRepo.GetByIDs(ctx, placeIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[primitive.ObjectID]string, len(places))
+	for _, place := range places {
+		names[place.ID] = place.Name
+	}
+	return names, nil
+}
@@ -0,0 +1,535 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ftrack/models"
+	"ftrack/repositories"
+	"ftrack/utils"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultReconcileBatchSize = 200
+	reconcileBatchPause       = 100 * time.Millisecond
+	reconcileLockKey          = "maintenance:reconcile:lock"
+	reconcileLockTTL          = 2 * time.Hour
+
+	// Entities updated this recently may have an increment in flight
+	reconcileActiveWindow = 2 * time.Minute
+)
+
+// counterSpecs maps counter classes that are derived by counting source
+// documents to where they are stored
+var counterSpecs = map[string]repositories.CounterSpec{
+	models.CounterClassReplyCount: {
+		Collection:       "messages",
+		Field:            "replyCount",
+		SourceCollection: "messages",
+		SourceField:      "replyTo",
+		SourceFilter:     bson.M{"isDeleted": bson.M{"$ne": true}},
+	},
+	models.CounterClassPlaceVisits: {
+		Collection:       "places",
+		Field:            "stats.visitCount",
+		SourceCollection: "place_visits",
+		SourceField:      "placeId",
+	},
+	models.CounterClassPlaceCheckins: {
+		Collection:       "places",
+		Field:            "stats.checkinCount",
+		SourceCollection: "place_checkins",
+		SourceField:      "placeId",
+	},
+	models.CounterClassPlaceReviews: {
+		Collection:       "places",
+		Field:            "stats.reviewCount",
+		SourceCollection: "place_reviews",
+		SourceField:      "placeId",
+	},
+	models.CounterClassTemplateUsage: {
+		Collection:       "message_templates",
+		Field:            "usageCount",
+		SourceCollection: "messages",
+		SourceField:      "templateId",
+		// Messages sent before templateId was recorded can't be attributed
+		IncreaseOnly: true,
+	},
+}
+
+// counterTolerances is how far a recently updated counter may be off before
+// it is treated as drift rather than an increment still in flight
+var counterTolerances = map[string]int64{
+	models.CounterClassReplyCount:        2,
+	models.CounterClassReactions:         0,
+	models.CounterClassPlaceVisits:       1,
+	models.CounterClassPlaceCheckins:     1,
+	models.CounterClassPlaceReviews:      1,
+	models.CounterClassNotificationBadge: 1,
+	models.CounterClassTemplateUsage:     1,
+}
+
+// MaintenanceService recomputes denormalized counters from their source
+// collections and repairs drift
+type MaintenanceService struct {
+	maintenanceRepo  *repositories.MaintenanceRepository
+	notificationRepo *repositories.NotificationRepository
+	redis            *redis.Client
+	validator        *utils.ValidationService
+
+	driftStats map[string]*models.CounterDriftStats
+	statsMutex sync.RWMutex
+}
+
+func NewMaintenanceService(maintenanceRepo *repositories.MaintenanceRepository, notificationRepo *repositories.NotificationRepository, redis *redis.Client) *MaintenanceService {
+	return &MaintenanceService{
+		maintenanceRepo:  maintenanceRepo,
+		notificationRepo: notificationRepo,
+		redis:            redis,
+		validator:        utils.NewValidationService(),
+		driftStats:       make(map[string]*models.CounterDriftStats),
+	}
+}
+
+// StartReconcile begins a reconcile run in the background and returns it
+// immediately so callers can poll its report
+func (ms *MaintenanceService) StartReconcile(ctx context.Context, trigger, triggeredBy string, req models.ReconcileRequest) (*models.ReconcileRun, error) {
+	run, err := ms.beginRun(ctx, trigger, triggeredBy, req)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := *run
+	go ms.executeRun(context.Background(), run, req)
+
+	return &snapshot, nil
+}
+
+// Reconcile runs all requested counter classes and returns the finished report
+func (ms *MaintenanceService) Reconcile(ctx context.Context, trigger, triggeredBy string, req models.ReconcileRequest) (*models.ReconcileRun, error) {
+	run, err := ms.beginRun(ctx, trigger, triggeredBy, req)
+	if err != nil {
+		return nil, err
+	}
+
+	ms.executeRun(ctx, run, req)
+	return run, nil
+}
+
+func (ms *MaintenanceService) GetReconcileRun(ctx context.Context, runID string) (*models.ReconcileRun, error) {
+	return ms.maintenanceRepo.GetRun(ctx, runID)
+}
+
+// GetDriftStats returns drift accumulated per counter class by this process
+func (ms *MaintenanceService) GetDriftStats() map[string]models.CounterDriftStats {
+	ms.statsMutex.RLock()
+	defer ms.statsMutex.RUnlock()
+
+	stats := make(map[string]models.CounterDriftStats, len(ms.driftStats))
+	for class, classStats := range ms.driftStats {
+		stats[class] = *classStats
+	}
+	return stats
+}
+
+func (ms *MaintenanceService) beginRun(ctx context.Context, trigger, triggeredBy string, req models.ReconcileRequest) (*models.ReconcileRun, error) {
+	// Validate request
+	if validationErrors := ms.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	run := &models.ReconcileRun{
+		Trigger:       trigger,
+		TriggeredBy:   triggeredBy,
+		DryRun:        req.DryRun,
+		Status:        models.ReconcileStatusRunning,
+		Classes:       []models.CounterClassReport{},
+		Discrepancies: []models.CounterDiscrepancy{},
+	}
+
+	// Only one run at a time across all instances
+	if ms.redis != nil {
+		acquired, err := ms.redis.SetNX(ctx, reconcileLockKey, trigger, reconcileLockTTL).Result()
+		if err != nil {
+			return nil, err
+		}
+		if !acquired {
+			return nil, errors.New("reconcile already running")
+		}
+	}
+
+	if err := ms.maintenanceRepo.CreateRun(ctx, run); err != nil {
+		ms.releaseLock()
+		logrus.Errorf("Failed to create reconcile run: %v", err)
+		return nil, err
+	}
+
+	return run, nil
+}
+
+func (ms *MaintenanceService) executeRun(ctx context.Context, run *models.ReconcileRun, req models.ReconcileRequest) {
+	defer ms.releaseLock()
+
+	classes := req.CounterClasses
+	if len(classes) == 0 {
+		classes = models.AllCounterClasses
+	}
+
+	batchSize := req.BatchSize
+	if batchSize == 0 {
+		batchSize = defaultReconcileBatchSize
+	}
+
+	logrus.Infof("Starting counter reconciliation %s (dryRun=%v, classes=%s)", run.ID.Hex(), run.DryRun, strings.Join(classes, ","))
+
+	for _, class := range classes {
+		report, err := ms.reconcileClass(ctx, run, class, batchSize)
+		run.Classes = append(run.Classes, report)
+		ms.recordDrift(report)
+
+		if err != nil {
+			logrus.Errorf("Counter reconciliation %s failed on %s: %v", run.ID.Hex(), class, err)
+			run.Status = models.ReconcileStatusFailed
+			run.ErrorMsg = fmt.Sprintf("%s: %v", class, err)
+			break
+		}
+
+		// Persist progress after each class so long runs can be followed
+		if err := ms.maintenanceRepo.UpdateRun(ctx, run); err != nil {
+			logrus.Warnf("Failed to save reconcile run %s progress: %v", run.ID.Hex(), err)
+		}
+	}
+
+	if run.Status == models.ReconcileStatusRunning {
+		run.Status = models.ReconcileStatusCompleted
+	}
+	now := time.Now()
+	run.CompletedAt = &now
+
+	if err := ms.maintenanceRepo.UpdateRun(context.Background(), run); err != nil {
+		logrus.Errorf("Failed to save reconcile run %s: %v", run.ID.Hex(), err)
+	}
+
+	logrus.Infof("Counter reconciliation %s %s", run.ID.Hex(), run.Status)
+}
+
+func (ms *MaintenanceService) reconcileClass(ctx context.Context, run *models.ReconcileRun, class string, batchSize int) (models.CounterClassReport, error) {
+	report := models.CounterClassReport{CounterClass: class}
+
+	var err error
+	switch class {
+	case models.CounterClassReactions:
+		err = ms.reconcileReactions(ctx, run, &report, batchSize)
+	case models.CounterClassNotificationBadge:
+		err = ms.reconcileBadges(ctx, run, &report, batchSize)
+	default:
+		spec, exists := counterSpecs[class]
+		if !exists {
+			return report, errors.New("unknown counter class")
+		}
+		err = ms.reconcileCounter(ctx, run, &report, spec, batchSize)
+	}
+
+	return report, err
+}
+
+// reconcileCounter walks the counter owners in ID order, comparing each
+// stored value with a count of its source documents
+func (ms *MaintenanceService) reconcileCounter(ctx context.Context, run *models.ReconcileRun, report *models.CounterClassReport, spec repositories.CounterSpec, batchSize int) error {
+	var afterID primitive.ObjectID
+
+	for {
+		snapshots, err := ms.maintenanceRepo.ScanCounters(ctx, spec, afterID, batchSize)
+		if err != nil {
+			return err
+		}
+		if len(snapshots) == 0 {
+			return nil
+		}
+
+		ids := make([]primitive.ObjectID, len(snapshots))
+		for i, snapshot := range snapshots {
+			ids[i] = snapshot.ID
+		}
+
+		counts, err := ms.maintenanceRepo.CountSources(ctx, spec, ids)
+		if err != nil {
+			return err
+		}
+
+		for _, snapshot := range snapshots {
+			report.Scanned++
+			computed := counts[snapshot.ID]
+
+			if !ms.isDrift(report, snapshot.Stored, computed, snapshot.UpdatedAt) {
+				continue
+			}
+
+			repaired := false
+			if !run.DryRun && !(spec.IncreaseOnly && computed < snapshot.Stored) {
+				repaired, err = ms.maintenanceRepo.RepairCounter(ctx, spec, snapshot.ID, snapshot.Stored, computed)
+				if err != nil {
+					return err
+				}
+				ms.countRepair(report, repaired)
+			}
+
+			ms.addDiscrepancy(run, report.CounterClass, snapshot.ID.Hex(), snapshot.Stored, computed, repaired)
+		}
+
+		afterID = snapshots[len(snapshots)-1].ID
+		if err := ms.pauseBetweenBatches(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// reconcileReactions removes duplicate reactions (same user and emoji)
+// left behind by concurrent add requests
+func (ms *MaintenanceService) reconcileReactions(ctx context.Context, run *models.ReconcileRun, report *models.CounterClassReport, batchSize int) error {
+	var afterID primitive.ObjectID
+
+	for {
+		messages, err := ms.maintenanceRepo.ScanMessageReactions(ctx, afterID, batchSize)
+		if err != nil {
+			return err
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+
+		for _, message := range messages {
+			report.Scanned++
+
+			seen := make(map[string]bool, len(message.Reactions))
+			unique := make([]models.MessageReaction, 0, len(message.Reactions))
+			for _, reaction := range message.Reactions {
+				key := reaction.UserID.Hex() + ":" + reaction.Emoji
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				unique = append(unique, reaction)
+			}
+
+			stored := int64(len(message.Reactions))
+			computed := int64(len(unique))
+			if !ms.isDrift(report, stored, computed, message.UpdatedAt) {
+				continue
+			}
+
+			repaired := false
+			if !run.DryRun {
+				repaired, err = ms.maintenanceRepo.ReplaceMessageReactions(ctx, message.ID, len(message.Reactions), unique)
+				if err != nil {
+					return err
+				}
+				ms.countRepair(report, repaired)
+			}
+
+			ms.addDiscrepancy(run, report.CounterClass, message.ID.Hex(), stored, computed, repaired)
+		}
+
+		afterID = messages[len(messages)-1].ID
+		if err := ms.pauseBetweenBatches(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// reconcileBadges compares cached badge counts with the notifications
+// collection. Repairing drops the cache entry so the next read recomputes it.
+func (ms *MaintenanceService) reconcileBadges(ctx context.Context, run *models.ReconcileRun, report *models.CounterClassReport, batchSize int) error {
+	if ms.redis == nil {
+		return nil
+	}
+
+	var cursor uint64
+	for {
+		keys, nextCursor, err := ms.redis.Scan(ctx, cursor, "user:badges:*", int64(batchSize)).Result()
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			cached, err := ms.redis.Get(ctx, key).Result()
+			if err != nil {
+				// Expired between scan and read
+				continue
+			}
+
+			var badges models.NotificationBadges
+			if err := json.Unmarshal([]byte(cached), &badges); err != nil {
+				continue
+			}
+
+			report.Scanned++
+			userID := strings.TrimPrefix(key, "user:badges:")
+
+			unread, err := ms.notificationRepo.GetNotificationCount(ctx, userID, "unread")
+			if err != nil {
+				return err
+			}
+
+			if !ms.isDrift(report, int64(badges.Unread), unread, badges.LastUpdated) {
+				continue
+			}
+
+			repaired := false
+			if !run.DryRun {
+				repaired, err = ms.dropBadgeCache(ctx, key, cached)
+				if err != nil {
+					return err
+				}
+				ms.countRepair(report, repaired)
+			}
+
+			ms.addDiscrepancy(run, report.CounterClass, userID, int64(badges.Unread), unread, repaired)
+		}
+
+		cursor = nextCursor
+		if cursor == 0 {
+			return nil
+		}
+		if err := ms.pauseBetweenBatches(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// dropBadgeCache deletes the cached badges only if they haven't been
+// refreshed since they were read
+func (ms *MaintenanceService) dropBadgeCache(ctx context.Context, key, cached string) (bool, error) {
+	deleted := false
+	err := ms.redis.Watch(ctx, func(tx *redis.Tx) error {
+		current, err := tx.Get(ctx, key).Result()
+		if err == redis.Nil || current != cached {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key)
+			return nil
+		})
+		if err == nil {
+			deleted = true
+		}
+		return err
+	}, key)
+
+	if err == redis.TxFailedErr {
+		return false, nil
+	}
+	return deleted, err
+}
+
+// isDrift updates the report for a stored/computed pair and reports whether
+// it is a discrepancy. Small differences on recently updated entities are
+// tolerated since an increment may still be in flight.
+func (ms *MaintenanceService) isDrift(report *models.CounterClassReport, stored, computed int64, updatedAt time.Time) bool {
+	drift := computed - stored
+	if drift < 0 {
+		drift = -drift
+	}
+	if drift == 0 {
+		return false
+	}
+
+	if drift <= counterTolerances[report.CounterClass] && time.Since(updatedAt) < reconcileActiveWindow {
+		report.Skipped++
+		return false
+	}
+
+	report.Discrepancies++
+	report.TotalDrift += drift
+	if drift > report.MaxDrift {
+		report.MaxDrift = drift
+	}
+	return true
+}
+
+// countRepair records the outcome of a repair. A repair that didn't apply
+// means the counter changed mid-scan and is left for the next run.
+func (ms *MaintenanceService) countRepair(report *models.CounterClassReport, repaired bool) {
+	if repaired {
+		report.Repaired++
+	} else {
+		report.Skipped++
+	}
+}
+
+func (ms *MaintenanceService) addDiscrepancy(run *models.ReconcileRun, class, entityID string, stored, computed int64, repaired bool) {
+	if len(run.Discrepancies) >= models.MaxReportedDiscrepancies {
+		run.Truncated = true
+		return
+	}
+
+	run.Discrepancies = append(run.Discrepancies, models.CounterDiscrepancy{
+		CounterClass: class,
+		EntityID:     entityID,
+		Stored:       stored,
+		Computed:     computed,
+		Repaired:     repaired,
+	})
+}
+
+// recordDrift accumulates drift metrics for the counter class and logs them
+func (ms *MaintenanceService) recordDrift(report models.CounterClassReport) {
+	ms.statsMutex.Lock()
+	stats, exists := ms.driftStats[report.CounterClass]
+	if !exists {
+		stats = &models.CounterDriftStats{}
+		ms.driftStats[report.CounterClass] = stats
+	}
+	stats.Runs++
+	stats.Discrepancies += report.Discrepancies
+	stats.Repaired += report.Repaired
+	stats.TotalDrift += report.TotalDrift
+	stats.LastDrift = report.TotalDrift
+	if report.MaxDrift > stats.MaxDrift {
+		stats.MaxDrift = report.MaxDrift
+	}
+	stats.LastRunAt = time.Now()
+	ms.statsMutex.Unlock()
+
+	logrus.WithFields(logrus.Fields{
+		"counterClass":  report.CounterClass,
+		"scanned":       report.Scanned,
+		"discrepancies": report.Discrepancies,
+		"repaired":      report.Repaired,
+		"skipped":       report.Skipped,
+		"totalDrift":    report.TotalDrift,
+		"maxDrift":      report.MaxDrift,
+	}).Info("Counter drift")
+}
+
+func (ms *MaintenanceService) pauseBetweenBatches(ctx context.Context) error {
+	select {
+	case <-time.After(reconcileBatchPause):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (ms *MaintenanceService) releaseLock() {
+	if ms.redis == nil {
+		return
+	}
+
+	if err := ms.redis.Del(context.Background(), reconcileLockKey).Err(); err != nil {
+		logrus.Warnf("Failed to release reconcile lock: %v", err)
+	}
+}
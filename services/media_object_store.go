@@ -0,0 +1,241 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ftrack/utils"
+)
+
+// MediaObjectStoreConfig points at an S3-compatible bucket: AWS S3, MinIO,
+// Cloudflare R2 and the like. Objects are addressed path-style, as
+// Endpoint/Bucket/key.
+type MediaObjectStoreConfig struct {
+	Endpoint  string // e.g. https://s3.eu-west-1.amazonaws.com
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+}
+
+// MediaObjectStore hands out pre-signed URLs for an S3-compatible bucket,
+// and looks at, downloads and deletes its objects through the same kind of
+// URL. Requests are signed with AWS Signature Version 4 as query
+// parameters.
+type MediaObjectStore struct {
+	config     MediaObjectStoreConfig
+	endpoint   *url.URL
+	httpClient *http.Client
+}
+
+// MediaObjectInfo is what the store reports about an object
+type MediaObjectInfo struct {
+	Size        int64
+	ContentType string
+}
+
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// Longest a signed request to the store itself stays valid
+const storeRequestExpiry = 5 * time.Minute
+
+func NewMediaObjectStore(config MediaObjectStoreConfig) (*MediaObjectStore, error) {
+	if config.Endpoint == "" || config.Bucket == "" || config.AccessKey == "" || config.SecretKey == "" {
+		return nil, errors.New("object store endpoint, bucket and keys are required")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+
+	endpoint, err := url.Parse(strings.TrimRight(config.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "https" && endpoint.Scheme != "http") {
+		return nil, fmt.Errorf("invalid object store endpoint %q", config.Endpoint)
+	}
+
+	return &MediaObjectStore{
+		config:   config,
+		endpoint: endpoint,
+		// Downloads of large videos are bounded by their context instead
+		httpClient: &http.Client{},
+	}, nil
+}
+
+// PresignPut returns a URL the client can PUT the object to until it
+// expires, and the headers it must send. The content type and length are
+// signed, so the store refuses an upload that differs from what was
+// declared.
+func (s *MediaObjectStore) PresignPut(key, contentType string, size int64, expires time.Duration) (string, map[string]string) {
+	headers := map[string]string{
+		"Content-Type":   contentType,
+		"Content-Length": strconv.FormatInt(size, 10),
+	}
+	return s.presign(http.MethodPut, key, headers, expires, time.Now()), headers
+}
+
+// Stat returns the object's size and content type, or "object not found"
+func (s *MediaObjectStore) Stat(ctx context.Context, key string) (*MediaObjectInfo, error) {
+	var info *MediaObjectInfo
+	err := utils.Providers.Do(ctx, utils.ProviderStorage, func(ctx context.Context) error {
+		resp, err := s.do(ctx, http.MethodHead, key)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		switch {
+		case resp.StatusCode == http.StatusNotFound:
+			return utils.NewPermanentProviderError(errors.New("object not found"))
+		case resp.StatusCode != http.StatusOK:
+			return utils.ProviderStatusError(fmt.Errorf("object store returned %d", resp.StatusCode), resp.StatusCode)
+		}
+
+		info = &MediaObjectInfo{
+			Size:        resp.ContentLength,
+			ContentType: resp.Header.Get("Content-Type"),
+		}
+		return nil
+	})
+	return info, err
+}
+
+// Open starts downloading the object. Callers must close it. Downloads can
+// take longer than a provider call may, so they aren't retried.
+func (s *MediaObjectStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, errors.New("object not found")
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("object store returned %d", resp.StatusCode)
+	}
+}
+
+// Delete removes the object. Objects that are already gone are fine.
+func (s *MediaObjectStore) Delete(ctx context.Context, key string) error {
+	return utils.Providers.Do(ctx, utils.ProviderStorage, func(ctx context.Context) error {
+		resp, err := s.do(ctx, http.MethodDelete, key)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+			return utils.ProviderStatusError(fmt.Errorf("object store returned %d", resp.StatusCode), resp.StatusCode)
+		}
+		return nil
+	})
+}
+
+func (s *MediaObjectStore) do(ctx context.Context, method, key string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.presign(method, key, nil, storeRequestExpiry, time.Now()), nil)
+	if err != nil {
+		return nil, utils.NewPermanentProviderError(err)
+	}
+	return s.httpClient.Do(req)
+}
+
+// presign builds the URL of a request signed with SigV4 query parameters.
+// The host and the given headers are signed; the payload isn't.
+func (s *MediaObjectStore) presign(method, key string, headers map[string]string, expires time.Duration, now time.Time) string {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", now.Format("20060102"), s.config.Region)
+
+	canonicalHeaders := map[string]string{"host": s.endpoint.Host}
+	for name, value := range headers {
+		canonicalHeaders[strings.ToLower(name)] = strings.TrimSpace(value)
+	}
+	names := make([]string, 0, len(canonicalHeaders))
+	for name := range canonicalHeaders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	signedHeaders := strings.Join(names, ";")
+
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", s.config.AccessKey+"/"+scope)
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires.Seconds())))
+	query.Set("X-Amz-SignedHeaders", signedHeaders)
+
+	path := s.endpoint.Path + "/" + awsURIEncode(s.config.Bucket, true) + "/" + awsURIEncode(key, false)
+	canonicalQuery := awsCanonicalQuery(query)
+
+	var canonical strings.Builder
+	canonical.WriteString(method + "\n" + path + "\n" + canonicalQuery + "\n")
+	for _, name := range names {
+		canonical.WriteString(name + ":" + canonicalHeaders[name] + "\n")
+	}
+	canonical.WriteString("\n" + signedHeaders + "\n" + unsignedPayload)
+
+	hashed := sha256.Sum256([]byte(canonical.String()))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.config.SecretKey), now.Format("20060102"))
+	signingKey = hmacSHA256(signingKey, s.config.Region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	return s.endpoint.Scheme + "://" + s.endpoint.Host + path + "?" + canonicalQuery + "&X-Amz-Signature=" + signature
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsCanonicalQuery encodes the parameters sorted by name, the way SigV4
+// expects them
+func awsCanonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, awsURIEncode(name, true)+"="+awsURIEncode(query.Get(name), true))
+	}
+	return strings.Join(parts, "&")
+}
+
+// awsURIEncode percent-encodes everything but unreserved characters, and
+// slashes too unless they separate the segments of a key
+func awsURIEncode(value string, encodeSlash bool) string {
+	var encoded strings.Builder
+	for _, b := range []byte(value) {
+		switch {
+		case b >= 'A' && b <= 'Z', b >= 'a' && b <= 'z', b >= '0' && b <= '9',
+			b == '-', b == '_', b == '.', b == '~':
+			encoded.WriteByte(b)
+		case b == '/' && !encodeSlash:
+			encoded.WriteByte(b)
+		default:
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+	return encoded.String()
+}
@@ -2,9 +2,12 @@ package services
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"ftrack/models"
+	"ftrack/repositories"
 	"image"
 	"image/jpeg"
 	"image/png"
@@ -26,6 +29,10 @@ type MediaService struct {
 	maxFileSize   int64
 	allowedTypes  map[string]bool
 	thumbnailSize int
+
+	// Content deduplication
+	mediaRepo    *repositories.MediaRepository
+	dedupEnabled bool
 }
 
 type UploadedFile struct {
@@ -36,6 +43,7 @@ type UploadedFile struct {
 	MimeType     string
 	Duration     int
 	Dimensions   *models.MediaDimensions
+	ContentHash  string // set when the content is deduplicated
 }
 
 type CompressedMedia struct {
@@ -74,6 +82,14 @@ func NewMediaService(uploadPath, baseURL string) *MediaService {
 	}
 }
 
+// ConfigureDeduplication stores identical uploads once, as files named by
+// their SHA-256 that are shared by reference. Files stored that way stay
+// reference counted when new uploads are no longer deduplicated.
+func (ms *MediaService) ConfigureDeduplication(mediaRepo *repositories.MediaRepository, enabled bool) {
+	ms.mediaRepo = mediaRepo
+	ms.dedupEnabled = enabled
+}
+
 func (ms *MediaService) UploadFile(ctx context.Context, file multipart.File, header *multipart.FileHeader, userID string) (*UploadedFile, error) {
 	// Validate file type
 	contentType := header.Header.Get("Content-Type")
@@ -86,8 +102,25 @@ func (ms *MediaService) UploadFile(ctx context.Context, file multipart.File, hea
 		return nil, errors.New("file too large")
 	}
 
+	return ms.StoreFile(ctx, file, header.Filename, contentType, userID)
+}
+
+// MaxFileSize is the largest file that can be uploaded, in bytes
+func (ms *MediaService) MaxFileSize() int64 {
+	return ms.maxFileSize
+}
+
+// IsAllowedType reports whether files of the content type can be uploaded
+func (ms *MediaService) IsAllowedType(contentType string) bool {
+	return ms.allowedTypes[contentType]
+}
+
+// StoreFile saves uploaded content of an allowed type and processes it:
+// photo metadata is stripped and thumbnails are made. Content past the
+// size limit is refused.
+func (ms *MediaService) StoreFile(ctx context.Context, src io.Reader, originalFilename, contentType, userID string) (*UploadedFile, error) {
 	// Generate unique filename
-	ext := filepath.Ext(header.Filename)
+	ext := filepath.Ext(originalFilename)
 	filename := fmt.Sprintf("%s_%s%s", userID, uuid.New().String(), ext)
 	filePath := filepath.Join(ms.uploadPath, filename)
 
@@ -97,23 +130,70 @@ func (ms *MediaService) UploadFile(ctx context.Context, file multipart.File, hea
 		logrus.Errorf("Failed to create file %s: %v", filePath, err)
 		return nil, errors.New("failed to save file")
 	}
-	defer dst.Close()
 
 	// Copy the uploaded file to destination
-	_, err = io.Copy(dst, file)
+	size, err := io.Copy(dst, io.LimitReader(src, ms.maxFileSize+1))
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
 		logrus.Errorf("Failed to copy file content: %v", err)
 		os.Remove(filePath) // Clean up
 		return nil, errors.New("failed to save file")
 	}
+	if size > ms.maxFileSize {
+		os.Remove(filePath)
+		return nil, errors.New("file too large")
+	}
+
+	if contentType == "image/jpeg" {
+		stripped, err := stripJPEGMetadataFile(filePath)
+		if err != nil {
+			logrus.Errorf("Failed to strip metadata of %s: %v", filePath, err)
+			os.Remove(filePath)
+			return nil, errors.New("failed to save file")
+		}
+		size = stripped
+	}
+
+	contentHash := ""
+	if ms.dedupEnabled && ms.mediaRepo != nil {
+		hash, err := fileSHA256(filePath)
+		if err != nil {
+			logrus.Errorf("Failed to hash file %s: %v", filePath, err)
+			os.Remove(filePath)
+			return nil, errors.New("failed to save file")
+		}
+
+		blob, err := ms.mediaRepo.ReferenceBlob(ctx, hash)
+		switch {
+		case err == nil:
+			// Identical content is already stored
+			os.Remove(filePath)
+			return ms.blobFile(blob, originalFilename, contentType), nil
+		case err.Error() == "media blob not found":
+			// First copy: store it under its hash
+			blobFilename := hash + strings.ToLower(ext)
+			blobPath := filepath.Join(ms.uploadPath, blobFilename)
+			if err := os.Rename(filePath, blobPath); err != nil {
+				logrus.Errorf("Failed to move file %s to %s: %v", filePath, blobPath, err)
+				os.Remove(filePath)
+				return nil, errors.New("failed to save file")
+			}
+			filename, filePath, contentHash = blobFilename, blobPath, hash
+		default:
+			// Uploads still work without deduplication
+			logrus.Errorf("Failed to look up media blob %s: %v", hash, err)
+		}
+	}
 
 	// Build file URL
 	fileURL := fmt.Sprintf("%s/media/%s", ms.baseURL, filename)
 
 	uploadedFile := &UploadedFile{
 		URL:      fileURL,
-		Size:     header.Size,
-		Filename: header.Filename,
+		Size:     size,
+		Filename: originalFilename,
 		MimeType: contentType,
 	}
 
@@ -148,9 +228,177 @@ func (ms *MediaService) UploadFile(ctx context.Context, file multipart.File, hea
 		}
 	}
 
+	if contentHash != "" {
+		return ms.storeBlob(ctx, uploadedFile, contentHash, filename)
+	}
+
 	return uploadedFile, nil
 }
 
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// stripJPEGMetadataFile strips the JPEG's metadata in place and returns
+// its new size. Files that don't parse as JPEG are left as they are.
+func stripJPEGMetadataFile(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	stripped, ok := stripJPEGMetadata(data)
+	if !ok || len(stripped) == len(data) {
+		return int64(len(data)), nil
+	}
+	if err := os.WriteFile(path, stripped, 0644); err != nil {
+		return 0, err
+	}
+	return int64(len(stripped)), nil
+}
+
+// stripJPEGMetadata drops the APP1 (EXIF, XMP) and APP13 (IPTC) segments
+// of a JPEG, which carry where, when and with what a photo was taken. The
+// image data is copied as is, so the orientation tag goes too. It returns
+// false for data it can't parse.
+func stripJPEGMetadata(data []byte) ([]byte, bool) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, false
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xFF, 0xD8)
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return nil, false
+		}
+
+		marker := data[i+1]
+		switch {
+		case marker == 0xFF:
+			// Fill byte before a marker
+			i++
+			continue
+		case marker == 0xDA:
+			// Start of scan: the rest is image data
+			return append(out, data[i:]...), true
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			// Markers without a length
+			out = append(out, data[i:i+2]...)
+			i += 2
+			continue
+		}
+
+		length := int(data[i+2])<<8 | int(data[i+3])
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, false
+		}
+		if marker != 0xE1 && marker != 0xED {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+
+	return nil, false
+}
+
+// storeBlob records newly stored content. If the same content was stored
+// under another extension meanwhile, that copy is used and this one removed.
+func (ms *MediaService) storeBlob(ctx context.Context, uploadedFile *UploadedFile, hash, filename string) (*UploadedFile, error) {
+	blob, err := ms.mediaRepo.CreateBlob(ctx, &models.MediaBlob{
+		Hash:         hash,
+		Filename:     filename,
+		Size:         uploadedFile.Size,
+		MimeType:     uploadedFile.MimeType,
+		ThumbnailURL: uploadedFile.ThumbnailURL,
+		Duration:     uploadedFile.Duration,
+		Dimensions:   uploadedFile.Dimensions,
+	})
+	if err != nil {
+		// The file may already be shared by a concurrent upload, so it stays
+		logrus.Errorf("Failed to record media blob %s: %v", hash, err)
+		return nil, errors.New("failed to save file")
+	}
+
+	if blob.Filename != filename {
+		ms.DeleteFile(ctx, uploadedFile.URL)
+	}
+
+	return ms.blobFile(blob, uploadedFile.Filename, uploadedFile.MimeType), nil
+}
+
+func (ms *MediaService) blobFile(blob *models.MediaBlob, originalFilename, contentType string) *UploadedFile {
+	return &UploadedFile{
+		URL:          fmt.Sprintf("%s/media/%s", ms.baseURL, blob.Filename),
+		ThumbnailURL: blob.ThumbnailURL,
+		Size:         blob.Size,
+		Filename:     originalFilename,
+		MimeType:     contentType,
+		Duration:     blob.Duration,
+		Dimensions:   blob.Dimensions,
+		ContentHash:  blob.Hash,
+	}
+}
+
+// ReleaseFile drops a media record's hold on its file. Shared content is
+// only deleted with its last reference; other files are deleted right away.
+func (ms *MediaService) ReleaseFile(ctx context.Context, fileURL string) error {
+	hash, shared := ms.sharedFileHash(fileURL)
+	if !shared {
+		return ms.DeleteFile(ctx, fileURL)
+	}
+
+	last, err := ms.mediaRepo.ReleaseBlob(ctx, hash)
+	if err != nil {
+		if err.Error() == "media blob not found" {
+			logrus.Warnf("No references recorded for media blob %s, keeping it", hash)
+			return nil
+		}
+		return err
+	}
+	if !last {
+		return nil
+	}
+
+	return ms.DeleteFile(ctx, fileURL)
+}
+
+// IsSharedFile reports whether the file is deduplicated content
+func (ms *MediaService) IsSharedFile(fileURL string) bool {
+	_, shared := ms.sharedFileHash(fileURL)
+	return shared
+}
+
+// sharedFileHash returns the content hash of a deduplicated file, which is
+// its name without the extension
+func (ms *MediaService) sharedFileHash(fileURL string) (string, bool) {
+	if ms.mediaRepo == nil {
+		return "", false
+	}
+
+	filename := filepath.Base(fileURL)
+	hash := strings.TrimSuffix(filename, filepath.Ext(filename))
+	if len(hash) != sha256.Size*2 {
+		return "", false
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		return "", false
+	}
+
+	return hash, true
+}
+
 func (ms *MediaService) DeleteFile(ctx context.Context, fileURL string) error {
 	// Extract filename from URL
 	filename := filepath.Base(fileURL)
@@ -191,6 +439,48 @@ func (ms *MediaService) DownloadFile(ctx context.Context, fileURL string) ([]byt
 	return data, nil
 }
 
+// OpenFile opens a stored file for streaming. Callers must close it.
+func (ms *MediaService) OpenFile(fileURL string) (*os.File, os.FileInfo, error) {
+	filePath := filepath.Join(ms.uploadPath, filepath.Base(fileURL))
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, errors.New("file not found")
+		}
+		logrus.Errorf("Failed to open file %s: %v", filePath, err)
+		return nil, nil, errors.New("failed to read file")
+	}
+
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		file.Close()
+		return nil, nil, errors.New("file not found")
+	}
+
+	return file, info, nil
+}
+
+// DownloadThumbnail reads a stored thumbnail, or the file itself when the
+// URL isn't a thumbnail
+func (ms *MediaService) DownloadThumbnail(thumbnailURL string) ([]byte, error) {
+	filename := filepath.Base(thumbnailURL)
+	filePath := filepath.Join(ms.uploadPath, filename)
+	if strings.HasPrefix(filename, "thumb_") {
+		filePath = filepath.Join(ms.uploadPath, "thumbnails", filename)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.New("file not found")
+		}
+		return nil, errors.New("failed to read file")
+	}
+
+	return data, nil
+}
+
 func (ms *MediaService) CompressMedia(ctx context.Context, media *models.MessageMedia, quality int, maxSize int64) (*CompressedMedia, error) {
 	// Extract filename from URL
 	filename := filepath.Base(media.URL)
@@ -237,7 +527,9 @@ func (ms *MediaService) CompressMedia(ctx context.Context, media *models.Message
 	// Generate compressed filename
 	ext := filepath.Ext(filename)
 	nameWithoutExt := strings.TrimSuffix(filename, ext)
-	compressedFilename := fmt.Sprintf("%s_compressed%s", nameWithoutExt, ext)
+	// Shared content can be compressed for several records, so every copy
+	// gets its own name
+	compressedFilename := fmt.Sprintf("%s_compressed_%s%s", nameWithoutExt, uuid.New().String(), ext)
 	compressedPath := filepath.Join(ms.uploadPath, compressedFilename)
 
 	// Create compressed file
@@ -431,6 +723,11 @@ func (ms *MediaService) CleanupOldFiles(ctx context.Context, olderThan time.Dura
 			return nil
 		}
 
+		// Shared content goes with its last reference
+		if ms.IsSharedFile(path) {
+			return nil
+		}
+
 		// Check if file is older than cutoff
 		if info.ModTime().Before(cutoffTime) {
 			logrus.Infof("Cleaning up old file: %s", path)
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ftrack/models"
+	"ftrack/testharness"
+)
+
+// uploadFile is an in-memory multipart upload
+type uploadFile struct {
+	*bytes.Reader
+}
+
+func (uploadFile) Close() error { return nil }
+
+func upload(t *testing.T, ms *MediaService, name, contentType string, content []byte) *UploadedFile {
+	t.Helper()
+	header := &multipart.FileHeader{
+		Filename: name,
+		Header:   textproto.MIMEHeader{"Content-Type": {contentType}},
+		Size:     int64(len(content)),
+	}
+	uploaded, err := ms.UploadFile(context.Background(), uploadFile{bytes.NewReader(content)}, header, "user")
+	if err != nil {
+		t.Fatalf("UploadFile(%s): %v", name, err)
+	}
+	return uploaded
+}
+
+func storedFileExists(dir, fileURL string) bool {
+	_, err := os.Stat(filepath.Join(dir, filepath.Base(fileURL)))
+	return err == nil
+}
+
+func TestMediaServiceWithoutDeduplication(t *testing.T) {
+	dir := t.TempDir()
+	ms := NewMediaService(dir, "http://localhost")
+	ctx := context.Background()
+
+	first := upload(t, ms, "a.pdf", "application/pdf", []byte("same content"))
+	second := upload(t, ms, "b.pdf", "application/pdf", []byte("same content"))
+	if first.URL == second.URL || first.ContentHash != "" || second.ContentHash != "" {
+		t.Fatalf("uploads = %s (%q), %s (%q); want separate files without hashes", first.URL, first.ContentHash, second.URL, second.ContentHash)
+	}
+	if ms.IsSharedFile(first.URL) {
+		t.Errorf("%s is shared without deduplication", first.URL)
+	}
+
+	// Files that aren't shared are deleted right away
+	if err := ms.ReleaseFile(ctx, first.URL); err != nil {
+		t.Fatalf("ReleaseFile: %v", err)
+	}
+	if storedFileExists(dir, first.URL) || !storedFileExists(dir, second.URL) {
+		t.Errorf("after release first exists %v, second %v; want only second", storedFileExists(dir, first.URL), storedFileExists(dir, second.URL))
+	}
+}
+
+func TestMediaServiceDeduplication(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	mediaService := NewMediaService(dir, "http://localhost")
+	mediaService.ConfigureDeduplication(env.Repos.Media, true)
+
+	content := []byte("a forwarded meme")
+	first := upload(t, mediaService, "meme.pdf", "application/pdf", content)
+	second := upload(t, mediaService, "copy.pdf", "application/pdf", content)
+	other := upload(t, mediaService, "other.pdf", "application/pdf", []byte("something else"))
+
+	if first.ContentHash == "" || first.URL != second.URL || first.ContentHash != second.ContentHash {
+		t.Fatalf("identical uploads = %s (%q), %s (%q); want one shared file", first.URL, first.ContentHash, second.URL, second.ContentHash)
+	}
+	if second.Filename != "copy.pdf" || second.Size != int64(len(content)) {
+		t.Errorf("second upload = %+v, want its own name and the shared size", second)
+	}
+	if other.URL == first.URL || other.ContentHash == first.ContentHash {
+		t.Errorf("different content shares %s", other.URL)
+	}
+	if !mediaService.IsSharedFile(first.URL) {
+		t.Errorf("%s isn't shared", first.URL)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.pdf"))
+	if len(files) != 2 {
+		t.Errorf("stored files = %v, want one per distinct content", files)
+	}
+
+	// Deleting media releases its reference; the file goes with the last one
+	repos := env.Repos
+	ms := NewMessageService(
+		repos.Message, repos.Circle, repos.User, repos.Media, repos.Template, repos.Draft,
+		repos.Schedule, repos.Report, repos.Automation, repos.Export, repos.Block,
+		env.Hub, mediaService, nil, nil, nil,
+	)
+	owner := env.Factory.User()
+	var mediaIDs []string
+	for _, uploaded := range []*UploadedFile{first, second} {
+		media := &models.MessageMediaExtended{MessageMedia: models.MessageMedia{
+			URL:         uploaded.URL,
+			Type:        "document",
+			Filename:    uploaded.Filename,
+			ContentHash: uploaded.ContentHash,
+			UploadedBy:  owner.ID.Hex(),
+		}}
+		if err := repos.Media.Create(ctx, media); err != nil {
+			t.Fatalf("creating media: %v", err)
+		}
+		mediaIDs = append(mediaIDs, media.ID.Hex())
+	}
+
+	if err := ms.DeleteMedia(ctx, owner.ID.Hex(), mediaIDs[0]); err != nil {
+		t.Fatalf("DeleteMedia: %v", err)
+	}
+	if !storedFileExists(dir, first.URL) {
+		t.Fatal("shared file deleted while still referenced")
+	}
+	if err := ms.DeleteMedia(ctx, owner.ID.Hex(), mediaIDs[1]); err != nil {
+		t.Fatalf("DeleteMedia: %v", err)
+	}
+	if storedFileExists(dir, first.URL) {
+		t.Error("shared file kept after its last reference was deleted")
+	}
+	if !storedFileExists(dir, other.URL) {
+		t.Error("unrelated file deleted")
+	}
+
+	// The same content uploaded again is stored afresh
+	again := upload(t, mediaService, "meme.pdf", "application/pdf", content)
+	if again.URL != first.URL || !storedFileExists(dir, again.URL) {
+		t.Errorf("re-upload = %s, exists %v; want %s stored again", again.URL, storedFileExists(dir, again.URL), first.URL)
+	}
+	if err := mediaService.ReleaseFile(ctx, again.URL); err != nil || storedFileExists(dir, again.URL) {
+		t.Errorf("releasing the only reference = %v, exists %v; want deleted", err, storedFileExists(dir, again.URL))
+	}
+}
@@ -0,0 +1,252 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"ftrack/models"
+	"ftrack/repositories"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// How long after it expires an abandoned upload is garbage collected, so a
+// completion that is still running isn't cut short
+const mediaUploadCleanupGrace = time.Hour
+
+// MediaUploadService handles direct uploads: clients PUT their file to the
+// object store through a pre-signed URL, and on completion the object is
+// checked and ingested through the same pipeline as proxied uploads. The
+// staging object is deleted once the media is stored.
+type MediaUploadService struct {
+	mediaRepo    *repositories.MediaRepository
+	store        *MediaObjectStore
+	mediaService *MediaService
+	ttl          time.Duration
+}
+
+// NewMediaUploadService returns the direct upload service. Without a store
+// direct uploads are unavailable and clients upload through the API.
+func NewMediaUploadService(mediaRepo *repositories.MediaRepository, store *MediaObjectStore, mediaService *MediaService, ttl time.Duration) *MediaUploadService {
+	if ttl <= 0 {
+		ttl = 15 * time.Minute
+	}
+
+	return &MediaUploadService{
+		mediaRepo:    mediaRepo,
+		store:        store,
+		mediaService: mediaService,
+		ttl:          ttl,
+	}
+}
+
+// Enabled reports whether clients can upload directly to the object store
+func (us *MediaUploadService) Enabled() bool {
+	return us != nil && us.store != nil
+}
+
+// CreateIntent records an upload and returns the pre-signed URL to send it
+// to. The request must already be validated.
+func (us *MediaUploadService) CreateIntent(ctx context.Context, userID string, req models.MediaUploadIntentRequest) (*models.MediaUploadIntentResponse, error) {
+	if !us.Enabled() {
+		return nil, errors.New("direct uploads not available")
+	}
+	if !us.mediaService.IsAllowedType(req.ContentType) {
+		return nil, errors.New("invalid file type")
+	}
+	if req.Size > us.mediaService.MaxFileSize() {
+		return nil, errors.New("file too large")
+	}
+
+	mediaType := req.MediaType
+	if mediaType == "" {
+		mediaType = mediaTypeOf(req.ContentType)
+	}
+
+	now := time.Now()
+	intent := &models.MediaUploadIntent{
+		UserID:      userID,
+		ObjectKey:   fmt.Sprintf("uploads/%s/%s%s", userID, uuid.New().String(), strings.ToLower(filepath.Ext(req.Filename))),
+		Filename:    filepath.Base(req.Filename),
+		ContentType: req.ContentType,
+		MediaType:   mediaType,
+		Size:        req.Size,
+		Status:      models.MediaUploadStatusPending,
+		ExpiresAt:   now.Add(us.ttl),
+	}
+	if err := us.mediaRepo.CreateUploadIntent(ctx, intent); err != nil {
+		return nil, err
+	}
+
+	uploadURL, headers := us.store.PresignPut(intent.ObjectKey, intent.ContentType, intent.Size, us.ttl)
+
+	return &models.MediaUploadIntentResponse{
+		UploadID:    intent.ID.Hex(),
+		UploadURL:   uploadURL,
+		Method:      http.MethodPut,
+		Headers:     headers,
+		ContentType: intent.ContentType,
+		MaxSize:     us.mediaService.MaxFileSize(),
+		ExpiresAt:   intent.ExpiresAt,
+	}, nil
+}
+
+// Complete checks the uploaded object against what was declared and stores
+// it as media. The intent is left processing: the caller records the media
+// and then calls MarkCompleted, or Release if that fails.
+func (us *MediaUploadService) Complete(ctx context.Context, userID, uploadID string) (*UploadedFile, *models.MediaUploadIntent, error) {
+	if !us.Enabled() {
+		return nil, nil, errors.New("direct uploads not available")
+	}
+
+	intent, err := us.mediaRepo.GetUploadIntent(ctx, uploadID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if intent.UserID != userID {
+		return nil, nil, errors.New("upload not found")
+	}
+
+	switch {
+	case intent.Status == models.MediaUploadStatusCompleted:
+		return nil, nil, errors.New("upload already completed")
+	case intent.Status == models.MediaUploadStatusRejected:
+		return nil, nil, errors.New("upload rejected")
+	case intent.Status == models.MediaUploadStatusPending && !time.Now().Before(intent.ExpiresAt):
+		return nil, nil, errors.New("upload expired")
+	}
+
+	// Only one completion runs at a time
+	if err := us.mediaRepo.ClaimUploadIntent(ctx, intent.ID, time.Now()); err != nil {
+		return nil, nil, errors.New("upload in progress")
+	}
+
+	info, err := us.store.Stat(ctx, intent.ObjectKey)
+	if err != nil {
+		us.Release(ctx, intent)
+		if err.Error() == "object not found" {
+			return nil, nil, errors.New("upload not received")
+		}
+		return nil, nil, err
+	}
+
+	if info.Size != intent.Size || !sameMediaType(info.ContentType, intent.ContentType) {
+		us.reject(ctx, intent)
+		return nil, nil, errors.New("upload does not match")
+	}
+
+	object, err := us.store.Open(ctx, intent.ObjectKey)
+	if err != nil {
+		us.Release(ctx, intent)
+		return nil, nil, err
+	}
+	defer object.Close()
+
+	// The declared type is only a claim: check it against the content
+	reader := bufio.NewReaderSize(object, 512)
+	head, _ := reader.Peek(512)
+	sniffed := http.DetectContentType(head)
+	if strings.HasPrefix(intent.ContentType, "image/") && !sameMediaType(sniffed, intent.ContentType) ||
+		strings.HasPrefix(sniffed, "text/html") {
+		us.reject(ctx, intent)
+		return nil, nil, errors.New("upload does not match")
+	}
+
+	uploaded, err := us.mediaService.StoreFile(ctx, reader, intent.Filename, intent.ContentType, userID)
+	if err != nil {
+		if err.Error() == "file too large" {
+			us.reject(ctx, intent)
+		} else {
+			us.Release(ctx, intent)
+		}
+		return nil, nil, err
+	}
+
+	return uploaded, intent, nil
+}
+
+// MarkCompleted records the media an upload became and deletes its staging
+// object
+func (us *MediaUploadService) MarkCompleted(ctx context.Context, intent *models.MediaUploadIntent, mediaID primitive.ObjectID) error {
+	if err := us.mediaRepo.SetUploadIntentStatus(ctx, intent.ID, models.MediaUploadStatusCompleted, &mediaID); err != nil {
+		return err
+	}
+
+	// Left behind objects are deleted with the intent when it expires
+	if err := us.store.Delete(ctx, intent.ObjectKey); err != nil {
+		logrus.Errorf("Failed to delete uploaded object %s: %v", intent.ObjectKey, err)
+	}
+	return nil
+}
+
+// Release puts an upload back to pending, so completing it can be retried
+func (us *MediaUploadService) Release(ctx context.Context, intent *models.MediaUploadIntent) {
+	if err := us.mediaRepo.SetUploadIntentStatus(ctx, intent.ID, models.MediaUploadStatusPending, nil); err != nil {
+		logrus.Errorf("Failed to release upload %s: %v", intent.ID.Hex(), err)
+	}
+}
+
+func (us *MediaUploadService) reject(ctx context.Context, intent *models.MediaUploadIntent) {
+	if err := us.mediaRepo.SetUploadIntentStatus(ctx, intent.ID, models.MediaUploadStatusRejected, nil); err != nil {
+		logrus.Errorf("Failed to reject upload %s: %v", intent.ID.Hex(), err)
+	}
+	if err := us.store.Delete(ctx, intent.ObjectKey); err != nil {
+		logrus.Errorf("Failed to delete uploaded object %s: %v", intent.ObjectKey, err)
+	}
+}
+
+// CleanupExpired deletes up to limit expired uploads and whatever object
+// they left in the store. It returns how many were deleted.
+func (us *MediaUploadService) CleanupExpired(ctx context.Context, limit int) (int, error) {
+	if !us.Enabled() {
+		return 0, nil
+	}
+
+	intents, err := us.mediaRepo.GetExpiredUploadIntents(ctx, time.Now().Add(-mediaUploadCleanupGrace), limit)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, intent := range intents {
+		if err := us.store.Delete(ctx, intent.ObjectKey); err != nil {
+			// Kept, so the object is tried again next time
+			logrus.Errorf("Failed to delete uploaded object %s: %v", intent.ObjectKey, err)
+			continue
+		}
+		if err := us.mediaRepo.DeleteUploadIntent(ctx, intent.ID); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+func mediaTypeOf(contentType string) string {
+	switch {
+	case strings.HasPrefix(contentType, "image/"):
+		return "image"
+	case strings.HasPrefix(contentType, "video/"):
+		return "video"
+	case strings.HasPrefix(contentType, "audio/"):
+		return "audio"
+	default:
+		return "document"
+	}
+}
+
+// sameMediaType compares content types without their parameters
+func sameMediaType(a, b string) bool {
+	typeA, _, errA := mime.ParseMediaType(a)
+	typeB, _, errB := mime.ParseMediaType(b)
+	return errA == nil && errB == nil && typeA == typeB
+}
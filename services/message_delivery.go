@@ -0,0 +1,244 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"ftrack/models"
+	"ftrack/repositories"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// Deliveries are written this often, one summary update per message
+	messageDeliveryFlushInterval = 5 * time.Second
+
+	// Or sooner, once this many are waiting
+	maxPendingMessageDeliveries = 5000
+)
+
+// deliveryRecorder collects deliveries in memory and writes them to the
+// messages' summaries in batches, so a message reaching a busy circle costs
+// one write per flush rather than one per member. Deliveries still waiting
+// when the process stops are lost, and those messages show as sent.
+type deliveryRecorder struct {
+	repo    *repositories.MessageDeliveryRepository
+	mutex   sync.Mutex
+	pending map[primitive.ObjectID]*models.PendingMessageDelivery
+	count   int
+	full    chan struct{}
+}
+
+func newDeliveryRecorder(repo *repositories.MessageDeliveryRepository) *deliveryRecorder {
+	return &deliveryRecorder{
+		repo:    repo,
+		pending: make(map[primitive.ObjectID]*models.PendingMessageDelivery),
+		full:    make(chan struct{}, 1),
+	}
+}
+
+func (dr *deliveryRecorder) record(messageID, circleID primitive.ObjectID, userID string, deliveredAt time.Time) {
+	dr.mutex.Lock()
+	delivery, exists := dr.pending[messageID]
+	if !exists {
+		delivery = &models.PendingMessageDelivery{
+			MessageID: messageID,
+			CircleID:  circleID,
+			Members:   make(map[string]time.Time),
+		}
+		dr.pending[messageID] = delivery
+	}
+	if _, seen := delivery.Members[userID]; !seen {
+		delivery.Members[userID] = deliveredAt
+		dr.count++
+	}
+	full := dr.count >= maxPendingMessageDeliveries
+	dr.mutex.Unlock()
+
+	if full {
+		select {
+		case dr.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (dr *deliveryRecorder) run() {
+	ticker := time.NewTicker(messageDeliveryFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-dr.full:
+		}
+		Background.Go(context.Background(), dr.flush)
+	}
+}
+
+func (dr *deliveryRecorder) flush(ctx context.Context) {
+	dr.mutex.Lock()
+	if dr.count == 0 {
+		dr.mutex.Unlock()
+		return
+	}
+	pending := make([]models.PendingMessageDelivery, 0, len(dr.pending))
+	for _, delivery := range dr.pending {
+		pending = append(pending, *delivery)
+	}
+	dr.pending = make(map[primitive.ObjectID]*models.PendingMessageDelivery)
+	dr.count = 0
+	dr.mutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	if err := dr.repo.RecordDeliveries(ctx, pending); err != nil {
+		logrus.Errorf("Failed to record deliveries of %d messages: %v", len(pending), err)
+	}
+}
+
+// ConfigureDeliveryTracking records when each member gets a message: when
+// the hub writes it to their socket, or their device reports the push
+func (ms *MessageService) ConfigureDeliveryTracking(deliveryRepo *repositories.MessageDeliveryRepository) {
+	ms.deliveryRepo = deliveryRepo
+	ms.deliveries = newDeliveryRecorder(deliveryRepo)
+	go ms.deliveries.run()
+
+	if ms.websocketHub != nil {
+		ms.websocketHub.OnMessageDelivered(ms.recordSocketDelivery)
+	}
+}
+
+func (ms *MessageService) recordSocketDelivery(userID, circleID, messageID string) {
+	messageObjectID, err := primitive.ObjectIDFromHex(messageID)
+	if err != nil {
+		return
+	}
+	circleObjectID, _ := primitive.ObjectIDFromHex(circleID)
+	ms.deliveries.record(messageObjectID, circleObjectID, userID, time.Now())
+}
+
+// MarkAsDelivered records that the message's push reached the user's
+// device. Only the first delivery to a member counts.
+func (ms *MessageService) MarkAsDelivered(ctx context.Context, userID, messageID string) error {
+	if ms.deliveries == nil {
+		return nil
+	}
+
+	message, err := ms.messageRepo.GetByID(ctx, messageID)
+	if err != nil {
+		return err
+	}
+	if message.SenderID.Hex() == userID {
+		return nil
+	}
+
+	isMember, err := ms.circleRepo.IsMember(ctx, message.CircleID.Hex(), userID)
+	if err != nil {
+		return err
+	}
+	if !isMember {
+		return errors.New("access denied")
+	}
+
+	ms.deliveries.record(message.ID, message.CircleID, userID, time.Now())
+	return nil
+}
+
+// GetDeliveryStatus tells the sender how many of the circle's other active
+// members the message reached and how many read it, member by member.
+// Past models.MessageDeliveryDetailDays only the counts are kept.
+func (ms *MessageService) GetDeliveryStatus(ctx context.Context, userID, messageID string) (*models.DeliveryStatusResponse, error) {
+	message, err := ms.messageRepo.GetByID(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only sender can check delivery status
+	if message.SenderID.Hex() != userID {
+		return nil, errors.New("access denied")
+	}
+
+	var delivery *models.MessageDelivery
+	if ms.deliveryRepo != nil {
+		delivery, err = ms.deliveryRepo.GetByMessageID(ctx, messageID)
+		if err != nil && err.Error() != "message delivery not found" {
+			return nil, err
+		}
+	}
+
+	if delivery != nil && delivery.CompactedAt != nil {
+		return &models.DeliveryStatusResponse{
+			MessageID: messageID,
+			Status:    deliveryStatus(delivery.DeliveredCount, delivery.ReadCount, delivery.RecipientCount),
+			Delivered: delivery.DeliveredCount,
+			Read:      delivery.ReadCount,
+			Total:     delivery.RecipientCount,
+			Compacted: true,
+			Details:   []models.DeliveryStatusDetail{},
+		}, nil
+	}
+
+	circle, err := ms.circleRepo.GetByID(ctx, message.CircleID.Hex())
+	if err != nil {
+		return nil, err
+	}
+
+	readAt := make(map[primitive.ObjectID]time.Time)
+	for _, readStatus := range message.ReadBy {
+		readAt[readStatus.UserID] = readStatus.ReadAt
+	}
+
+	response := &models.DeliveryStatusResponse{
+		MessageID: messageID,
+		Details:   []models.DeliveryStatusDetail{},
+	}
+	for _, member := range circle.Members {
+		if member.UserID == message.SenderID || member.Status != "active" {
+			continue
+		}
+
+		detail := models.DeliveryStatusDetail{
+			UserID: member.UserID.Hex(),
+			Status: "sent",
+		}
+		if delivery != nil {
+			if memberDelivery, delivered := delivery.Members[detail.UserID]; delivered {
+				deliveredAt := memberDelivery.DeliveredAt
+				detail.DeliveredAt = &deliveredAt
+				detail.Status = "delivered"
+			}
+		}
+		// Reading it means it got there, even without a record of when
+		if at, isRead := readAt[member.UserID]; isRead {
+			detail.ReadAt = &at
+			detail.Status = "read"
+			response.Read++
+		}
+		if detail.Status != "sent" {
+			response.Delivered++
+		}
+
+		response.Total++
+		response.Details = append(response.Details, detail)
+	}
+
+	response.Status = deliveryStatus(response.Delivered, response.Read, response.Total)
+	return response, nil
+}
+
+// deliveryStatus is how far a message got with all of its recipients
+func deliveryStatus(delivered, read, total int) string {
+	switch {
+	case total > 0 && read >= total:
+		return "read"
+	case total > 0 && delivered >= total:
+		return "delivered"
+	default:
+		return "sent"
+	}
+}
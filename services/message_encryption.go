@@ -0,0 +1,183 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"ftrack/models"
+	"ftrack/utils"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultEncryptionBatchSize = 200
+	encryptionLockKey          = "maintenance:message-encryption:lock"
+	encryptionLockTTL          = 6 * time.Hour
+)
+
+// encryptionCollections are walked in order by a message encryption run
+var encryptionCollections = []struct {
+	phase      string
+	collection string
+}{
+	{models.MessageEncryptionPhaseMessages, "messages"},
+	{models.MessageEncryptionPhaseDrafts, "message_drafts"},
+}
+
+// StartMessageEncryption begins a run in the background that brings stored
+// message and draft content in line with the circles' settings: content of
+// circles that turned encryption on is encrypted, and content under an
+// older key is re-encrypted with the current one. New content is written
+// that way already, so the run is needed after turning encryption on for a
+// circle with history, and after rotating the key.
+func (ms *MaintenanceService) StartMessageEncryption(ctx context.Context, triggeredBy string, req models.MessageEncryptionRequest) (*models.MessageEncryptionRun, error) {
+	if validationErrors := ms.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	keyID := utils.CurrentContentKeyID()
+	if keyID == "" {
+		return nil, errors.New("message encryption not configured")
+	}
+
+	circleIDs, err := ms.maintenanceRepo.GetEncryptedCircleIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only one run at a time across all instances
+	if ms.redis != nil {
+		acquired, err := ms.redis.SetNX(ctx, encryptionLockKey, triggeredBy, encryptionLockTTL).Result()
+		if err != nil {
+			return nil, err
+		}
+		if !acquired {
+			return nil, errors.New("message encryption already running")
+		}
+	}
+
+	run := &models.MessageEncryptionRun{
+		TriggeredBy: triggeredBy,
+		KeyID:       keyID,
+		Status:      models.ReconcileStatusRunning,
+		Phase:       encryptionCollections[0].phase,
+		Circles:     len(circleIDs),
+	}
+
+	for _, target := range encryptionCollections {
+		count, err := ms.maintenanceRepo.CountContentToEncrypt(ctx, target.collection, circleIDs, keyID)
+		if err != nil {
+			ms.releaseEncryptionLock()
+			return nil, err
+		}
+		run.Total += count
+	}
+
+	if err := ms.maintenanceRepo.CreateEncryptionRun(ctx, run); err != nil {
+		ms.releaseEncryptionLock()
+		logrus.Errorf("Failed to create message encryption run: %v", err)
+		return nil, err
+	}
+
+	batchSize := req.BatchSize
+	if batchSize == 0 {
+		batchSize = defaultEncryptionBatchSize
+	}
+
+	snapshot := *run
+	go ms.executeEncryptionRun(context.Background(), run, circleIDs, batchSize)
+
+	return &snapshot, nil
+}
+
+func (ms *MaintenanceService) GetMessageEncryptionRun(ctx context.Context, runID string) (*models.MessageEncryptionRun, error) {
+	return ms.maintenanceRepo.GetEncryptionRun(ctx, runID)
+}
+
+func (ms *MaintenanceService) executeEncryptionRun(ctx context.Context, run *models.MessageEncryptionRun, circleIDs []primitive.ObjectID, batchSize int) {
+	defer ms.releaseEncryptionLock()
+
+	logrus.Infof("Starting message encryption %s (key=%s, circles=%d, documents=%d)", run.ID.Hex(), run.KeyID, run.Circles, run.Total)
+
+	for _, target := range encryptionCollections {
+		run.Phase = target.phase
+		if err := ms.encryptCollection(ctx, run, target.collection, circleIDs, batchSize); err != nil {
+			logrus.Errorf("Message encryption %s failed on %s: %v", run.ID.Hex(), target.collection, err)
+			run.Status = models.ReconcileStatusFailed
+			run.ErrorMsg = fmt.Sprintf("%s: %v", target.collection, err)
+			break
+		}
+	}
+
+	if run.Status == models.ReconcileStatusRunning {
+		run.Status = models.ReconcileStatusCompleted
+	}
+	now := time.Now()
+	run.CompletedAt = &now
+
+	if err := ms.maintenanceRepo.UpdateEncryptionRun(context.Background(), run); err != nil {
+		logrus.Errorf("Failed to save message encryption run %s: %v", run.ID.Hex(), err)
+	}
+
+	logrus.Infof("Message encryption %s %s (done=%d, skipped=%d)", run.ID.Hex(), run.Status, run.Done, run.Skipped)
+}
+
+// encryptCollection walks the collection's content to write in ID order,
+// saving progress after each batch
+func (ms *MaintenanceService) encryptCollection(ctx context.Context, run *models.MessageEncryptionRun, collection string, circleIDs []primitive.ObjectID, batchSize int) error {
+	var afterID primitive.ObjectID
+
+	for {
+		batch, err := ms.maintenanceRepo.ScanContentToEncrypt(ctx, collection, circleIDs, run.KeyID, afterID, batchSize)
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		for _, scanned := range batch {
+			plaintext, err := utils.DecryptContent(scanned.Content, scanned.ContentKeyID)
+			if err != nil {
+				return fmt.Errorf("decrypt %s: %w", scanned.ID.Hex(), err)
+			}
+
+			content, keyID, err := utils.EncryptContent(plaintext)
+			if err != nil {
+				return err
+			}
+
+			replaced, err := ms.maintenanceRepo.ReplaceStoredContent(ctx, collection, scanned, content, keyID)
+			if err != nil {
+				return err
+			}
+			if replaced {
+				run.Done++
+			} else {
+				run.Skipped++
+			}
+		}
+		afterID = batch[len(batch)-1].ID
+
+		if err := ms.maintenanceRepo.UpdateEncryptionRun(ctx, run); err != nil {
+			logrus.Warnf("Failed to save message encryption run %s progress: %v", run.ID.Hex(), err)
+		}
+
+		if err := ms.pauseBetweenBatches(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+func (ms *MaintenanceService) releaseEncryptionLock() {
+	if ms.redis == nil {
+		return
+	}
+
+	if err := ms.redis.Del(context.Background(), encryptionLockKey).Err(); err != nil {
+		logrus.Warnf("Failed to release message encryption lock: %v", err)
+	}
+}
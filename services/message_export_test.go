@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"ftrack/models"
+	"ftrack/testharness"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// waitForExport waits until the export finished and returns its first file
+func waitForExport(t *testing.T, es *ExportService, userID, exportID string) []byte {
+	t.Helper()
+	ctx := context.Background()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, err := es.GetExportStatus(ctx, userID, exportID)
+		if err != nil {
+			t.Fatalf("GetExportStatus: %v", err)
+		}
+		switch status.Status {
+		case models.ExportStatusCompleted:
+			_, data, err := es.ReadExport(ctx, userID, exportID)
+			if err != nil {
+				t.Fatalf("ReadExport: %v", err)
+			}
+			return data
+		case models.ExportStatusFailed, models.ExportStatusCancelled:
+			t.Fatalf("export %s", status.Status)
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("export still %s", status.Status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestExportSearchResults(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	repos := env.Repos
+	es := NewExportService(repos.Export, t.TempDir())
+	ms := NewMessageService(
+		repos.Message, repos.Circle, repos.User, repos.Media, repos.Template, repos.Draft,
+		repos.Schedule, repos.Report, repos.Automation, repos.Export, repos.Block,
+		env.Hub, nil, NewSearchService(env.DB), es, nil,
+	)
+	ctx := context.Background()
+
+	dad, mom, blocked, stranger := env.Factory.User(), env.Factory.User(), env.Factory.User(), env.Factory.User()
+	family := env.Factory.Circle(dad, []*models.User{mom, blocked})
+	other := env.Factory.Circle(stranger, []*models.User{dad})
+	if err := repos.Block.Create(ctx, &models.BlockedUser{UserID: dad.ID, BlockedUserID: blocked.ID}); err != nil {
+		t.Fatalf("blocking: %v", err)
+	}
+
+	post := func(circle *models.Circle, sender *models.User, content, day string) *models.Message {
+		t.Helper()
+		message := env.Factory.Message(circle, sender, content)
+		at, _ := time.Parse("2006-01-02", day)
+		if _, err := env.DB.Collection("messages").UpdateOne(ctx, bson.M{"_id": message.ID}, bson.M{"$set": bson.M{"createdAt": at.Add(12 * time.Hour)}}); err != nil {
+			t.Fatalf("dating message: %v", err)
+		}
+		return message
+	}
+	moved := post(family, mom, "soccer practice moved to 5", "2026-09-10")
+	cleats := post(family, mom, "soccer cleats are in the car", "2026-09-30")
+	byDad := post(family, dad, "soccer practice is cancelled", "2026-09-20")
+	early := post(family, mom, "soccer season starts soon", "2026-08-15")
+	post(family, mom, "dinner at 7", "2026-09-12")
+	post(family, blocked, "soccer tickets for sale", "2026-09-12")
+	post(other, stranger, "soccer in the other circle", "2026-09-12")
+
+	criteria := models.MessageSearchCriteria{
+		Query:    "soccer",
+		SenderID: mom.ID.Hex(),
+		DateFrom: "2026-09-01",
+		DateTo:   "2026-09-30",
+	}
+	export, err := ms.ExportSearchResults(ctx, dad.ID.Hex(), family.ID.Hex(), models.ExportSearchResultsRequest{Format: "json", MessageSearchCriteria: criteria})
+	if err != nil {
+		t.Fatalf("ExportSearchResults: %v", err)
+	}
+
+	// Only mom's September soccer messages, oldest first; the last day
+	// counts in full
+	var file struct {
+		Records []models.Message `json:"records"`
+	}
+	if err := json.Unmarshal(waitForExport(t, es, dad.ID.Hex(), export.ID.Hex()), &file); err != nil {
+		t.Fatalf("decoding export: %v", err)
+	}
+	expectMessageIDs(t, "json export", file.Records, moved, cleats)
+
+	// The manifest says which search the export holds
+	download, err := es.DownloadExportManifest(ctx, dad.ID.Hex(), export.ID.Hex())
+	if err != nil {
+		t.Fatalf("DownloadExportManifest: %v", err)
+	}
+	var manifest models.ExportManifest
+	if err := json.Unmarshal(download.Data, &manifest); err != nil {
+		t.Fatalf("decoding manifest: %v", err)
+	}
+	if manifest.SearchCriteria == nil || *manifest.SearchCriteria != criteria || manifest.RecordCount != 2 {
+		t.Errorf("manifest criteria %+v with %d records, want %+v with 2", manifest.SearchCriteria, manifest.RecordCount, criteria)
+	}
+
+	// Without filters every match the user can see is exported, leaving
+	// out blocked senders and other circles
+	export, err = ms.ExportSearchResults(ctx, dad.ID.Hex(), family.ID.Hex(), models.ExportSearchResultsRequest{
+		Format:                "csv",
+		MessageSearchCriteria: models.MessageSearchCriteria{Query: "soccer"},
+	})
+	if err != nil {
+		t.Fatalf("ExportSearchResults: %v", err)
+	}
+	data := string(waitForExport(t, es, dad.ID.Hex(), export.ID.Hex()))
+	rows, err := csv.NewReader(strings.NewReader(data[strings.Index(data, "\n")+1:])).ReadAll()
+	if err != nil {
+		t.Fatalf("reading CSV export: %v", err)
+	}
+	var ids []string
+	for _, row := range rows[1:] {
+		ids = append(ids, row[0])
+	}
+	want := []string{early.ID.Hex(), moved.ID.Hex(), byDad.ID.Hex(), cleats.ID.Hex()}
+	if strings.Join(ids, ",") != strings.Join(want, ",") {
+		t.Errorf("CSV export rows %v, want %v", ids, want)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		userID string
+		req    models.ExportSearchResultsRequest
+		err    string
+	}{
+		{"by a stranger", stranger.ID.Hex(), models.ExportSearchResultsRequest{Format: "json", MessageSearchCriteria: criteria}, "access denied"},
+		{"without a search", dad.ID.Hex(), models.ExportSearchResultsRequest{Format: "json"}, "search query or filter is required"},
+		{"with a bad sender", dad.ID.Hex(), models.ExportSearchResultsRequest{Format: "json", MessageSearchCriteria: models.MessageSearchCriteria{SenderID: "mom"}}, "invalid sender ID"},
+		{"in a bad format", dad.ID.Hex(), models.ExportSearchResultsRequest{Format: "xml", MessageSearchCriteria: criteria}, "validation failed"},
+	} {
+		if _, err := ms.ExportSearchResults(ctx, tt.userID, family.ID.Hex(), tt.req); err == nil || err.Error() != tt.err {
+			t.Errorf("export %s error = %v, want %s", tt.name, err, tt.err)
+		}
+	}
+}
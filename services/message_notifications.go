@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"ftrack/models"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultMessageNotificationWindow is how long a sender has to be quiet in
+// a circle before their next message starts a new notification
+const DefaultMessageNotificationWindow = time.Minute
+
+// Longest message text shown in a notification
+const messageNotificationPreviewLength = 100
+
+// ConfigureMessageNotifications pushes new messages to the other members of
+// the circle. Messages a sender posts to a circle within the window of each
+// other are coalesced into one notification, which updates on the device
+// instead of stacking. Mentions and direct messages are always notified on
+// their own, and right away.
+func (ms *MessageService) ConfigureMessageNotifications(notificationService NotificationDispatcher, window time.Duration) {
+	if window < 0 {
+		logrus.Errorf("Ignoring invalid message notification window %s", window)
+		window = DefaultMessageNotificationWindow
+	}
+
+	ms.notificationService = notificationService
+	ms.notificationWindow = window
+}
+
+// notifyNewMessage notifies the circle's other members of a new message
+func (ms *MessageService) notifyNewMessage(ctx context.Context, message models.Message) {
+	if ms.notificationService == nil {
+		return
+	}
+
+	circle, err := ms.circleRepo.GetByID(ctx, message.CircleID.Hex())
+	if err != nil {
+		logrus.Errorf("Failed to get circle %s to notify message %s: %v", message.CircleID.Hex(), message.ID.Hex(), err)
+		return
+	}
+
+	var recipientIDs []string
+	for _, member := range circle.Members {
+		if member.UserID != message.SenderID && member.Status == "active" {
+			recipientIDs = append(recipientIDs, member.UserID.Hex())
+		}
+	}
+	if len(recipientIDs) == 0 {
+		return
+	}
+
+	sender, err := ms.userRepo.GetByID(ctx, message.SenderID.Hex())
+	if err != nil {
+		logrus.Errorf("Failed to get sender of message %s: %v", message.ID.Hex(), err)
+		return
+	}
+	recipients, err := ms.userRepo.GetUsersByIDs(ctx, recipientIDs)
+	if err != nil {
+		logrus.Errorf("Failed to get recipients of message %s: %v", message.ID.Hex(), err)
+		return
+	}
+
+	senderName := strings.TrimSpace(sender.FirstName + " " + sender.LastName)
+	direct := isDirectMessageCircle(circle)
+
+	// Everyone not mentioned gets the burst's notification, the others
+	// this message's own
+	var coalesced, individual []string
+	for _, recipient := range recipients {
+		if direct || messageMentions(message.Content, recipient) {
+			individual = append(individual, recipient.ID.Hex())
+		} else {
+			coalesced = append(coalesced, recipient.ID.Hex())
+		}
+	}
+
+	single := ms.messageNotification(&message, circle, senderName, direct)
+	if len(individual) > 0 {
+		req := single
+		req.Recipients = individual
+		req.Immediate = true
+		ms.sendMessageNotification(ctx, &message, req)
+	}
+	if len(coalesced) == 0 {
+		return
+	}
+
+	count, collapseKey, err := ms.coalesceMessageNotification(ctx, &message)
+	if err != nil {
+		logrus.Warnf("Failed to coalesce notification of message %s: %v", message.ID.Hex(), err)
+	}
+
+	req := single
+	req.Recipients = coalesced
+	req.CollapseKey = collapseKey
+	if count > 1 {
+		req.Message = fmt.Sprintf("%d new messages from %s", count, senderName)
+		req.Attachment = nil
+	}
+	ms.sendMessageNotification(ctx, &message, req)
+}
+
+// messageNotification is the notification of the message on its own
+func (ms *MessageService) messageNotification(message *models.Message, circle *models.Circle, senderName string, direct bool) models.SendNotificationRequest {
+	title := circle.Name
+	text := senderName + ": " + messageNotificationPreview(message)
+	if direct {
+		title = senderName
+		text = messageNotificationPreview(message)
+	}
+
+	return models.SendNotificationRequest{
+		Title:      title,
+		Message:    text,
+		Type:       models.NotificationTypeMessage,
+		Priority:   "normal",
+		Category:   "communication",
+		CircleID:   message.CircleID.Hex(),
+		Attachment: models.MessageAttachment(message),
+		Data: map[string]interface{}{
+			"circleId":  message.CircleID.Hex(),
+			"messageId": message.ID.Hex(),
+			"senderId":  message.SenderID.Hex(),
+		},
+		DeliveryChannels: []string{"push"},
+		SubjectUserID:    message.SenderID.Hex(),
+	}
+}
+
+func (ms *MessageService) sendMessageNotification(ctx context.Context, message *models.Message, req models.SendNotificationRequest) {
+	if err := ms.notificationService.SendNotification(ctx, req); err != nil {
+		logrus.Errorf("Failed to send notification of message %s: %v", message.ID.Hex(), err)
+	}
+}
+
+// coalesceMessageNotification counts the message in its sender's burst to
+// the circle, and returns how many messages the burst has and the collapse
+// key its notifications share. A burst ends once the sender has been quiet
+// for the window. Without Redis every message is its own burst.
+func (ms *MessageService) coalesceMessageNotification(ctx context.Context, message *models.Message) (int64, string, error) {
+	cache, _ := ms.redisClient.(*redis.Client)
+	if cache == nil || ms.notificationWindow == 0 {
+		return 1, "", nil
+	}
+
+	key := fmt.Sprintf("message_notifications:%s:%s", message.CircleID.Hex(), message.SenderID.Hex())
+
+	pipe := cache.TxPipeline()
+	count := pipe.HIncrBy(ctx, key, "count", 1)
+	pipe.HSetNX(ctx, key, "collapseKey", "messages:"+message.ID.Hex())
+	collapseKey := pipe.HGet(ctx, key, "collapseKey")
+	pipe.Expire(ctx, key, ms.notificationWindow)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 1, "", err
+	}
+
+	return count.Val(), collapseKey.Val(), nil
+}
+
+// messageNotificationPreview is how the message reads in a notification
+func messageNotificationPreview(message *models.Message) string {
+	switch message.Type {
+	case "photo":
+		return "Sent a photo"
+	case "location":
+		return "Shared a location"
+	case "voice":
+		return "Sent a voice message"
+	case "sticker":
+		return "Sent a sticker"
+	}
+
+	text := []rune(strings.TrimSpace(message.Content))
+	if len(text) > messageNotificationPreviewLength {
+		return string(text[:messageNotificationPreviewLength]) + "…"
+	}
+	return string(text)
+}
+
+// messageMentions reports whether the message content mentions the user,
+// by @ and their first name or ID
+func messageMentions(content string, user models.User) bool {
+	content = strings.ToLower(content)
+	if strings.Contains(content, "@"+user.ID.Hex()) {
+		return true
+	}
+	return user.FirstName != "" && strings.Contains(content, "@"+strings.ToLower(user.FirstName))
+}
+
+func isDirectMessageCircle(circle *models.Circle) bool {
+	return len(circle.Members) == 2 && circle.Name == "Direct Message"
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"ftrack/models"
+	"ftrack/testharness"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newTestCoalescingMessageService coalesces message notifications in Redis
+// within the window
+func newTestCoalescingMessageService(t *testing.T, env *testharness.Env, window time.Duration) *MessageService {
+	repos := env.Repos
+	ms := NewMessageService(
+		repos.Message, repos.Circle, repos.User, repos.Media, repos.Template, repos.Draft,
+		repos.Schedule, repos.Report, repos.Automation, repos.Export, repos.Block,
+		env.Hub, nil, nil, nil, testharness.Redis(t),
+	)
+	ms.ConfigureMessageNotifications(env.Notifier, window)
+	return ms
+}
+
+func named(firstName string) func(*models.User) {
+	return func(user *models.User) {
+		user.FirstName, user.LastName = firstName, ""
+	}
+}
+
+func TestMessageNotificationsCoalesce(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ms := newTestCoalescingMessageService(t, env, time.Second)
+	ctx := context.Background()
+
+	dad, mom := env.Factory.User(named("Dad")), env.Factory.User(named("Mom"))
+	family := env.Factory.Circle(dad, []*models.User{mom}, func(circle *models.Circle) { circle.Name = "Family" })
+
+	// Each message is notified before the next is sent, so the counts
+	// come in order
+	sent := 0
+	send := func(sender *models.User, content string) models.SendNotificationRequest {
+		t.Helper()
+		if _, err := ms.SendMessage(ctx, sender.ID.Hex(), models.SendMessageRequest{CircleID: family.ID.Hex(), Type: "text", Content: content}); err != nil {
+			t.Fatalf("SendMessage: %v", err)
+		}
+		sent++
+		return env.Notifier.WaitForSent(t, models.NotificationTypeMessage, sent)[sent-1]
+	}
+
+	first := send(dad, "leaving now")
+	second := send(dad, "traffic is bad")
+	third := send(dad, "there in 10")
+
+	if first.Message != "Dad: leaving now" || first.Title != "Family" {
+		t.Errorf("first notification %q: %q, want the message itself", first.Title, first.Message)
+	}
+	if second.Message != "2 new messages from Dad" || third.Message != "3 new messages from Dad" {
+		t.Errorf("burst notifications = %q, %q; want the running count", second.Message, third.Message)
+	}
+	if first.CollapseKey == "" || second.CollapseKey != first.CollapseKey || third.CollapseKey != first.CollapseKey {
+		t.Errorf("collapse keys = %q, %q, %q; want one shared key", first.CollapseKey, second.CollapseKey, third.CollapseKey)
+	}
+	for _, req := range []models.SendNotificationRequest{first, second, third} {
+		if len(req.Recipients) != 1 || req.Recipients[0] != mom.ID.Hex() || req.Immediate {
+			t.Errorf("burst notification to %v (immediate %v), want mom, batched", req.Recipients, req.Immediate)
+		}
+	}
+
+	// Another sender's messages are a burst of their own
+	reply := send(mom, "ok")
+	if reply.Message != "Mom: ok" || reply.CollapseKey == first.CollapseKey {
+		t.Errorf("reply notification %q with key %q, want its own burst", reply.Message, reply.CollapseKey)
+	}
+
+	// Once the sender has been quiet for the window a new burst starts
+	time.Sleep(1100 * time.Millisecond)
+	later := send(dad, "parked")
+	if later.Message != "Dad: parked" || later.CollapseKey == "" || later.CollapseKey == first.CollapseKey {
+		t.Errorf("notification after the window %q with key %q, want a new burst", later.Message, later.CollapseKey)
+	}
+}
+
+func TestMessageNotificationsMentionsBypassCoalescing(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ms := newTestCoalescingMessageService(t, env, time.Minute)
+	ctx := context.Background()
+
+	dad, mom, kid := env.Factory.User(named("Dad")), env.Factory.User(named("Mom")), env.Factory.User(named("Kid"))
+	family := env.Factory.Circle(dad, []*models.User{mom, kid})
+
+	send := func(circle *models.Circle, content string, notifications int) []models.SendNotificationRequest {
+		t.Helper()
+		before := len(env.Notifier.Sent(models.NotificationTypeMessage))
+		if _, err := ms.SendMessage(ctx, dad.ID.Hex(), models.SendMessageRequest{CircleID: circle.ID.Hex(), Type: "text", Content: content}); err != nil {
+			t.Fatalf("SendMessage: %v", err)
+		}
+		return env.Notifier.WaitForSent(t, models.NotificationTypeMessage, before+notifications)[before:]
+	}
+
+	send(family, "dinner at 7", 1)
+	sent := send(family, "@Kid pick up milk", 2)
+
+	// The mentioned member gets the message itself, right away; the
+	// others' notification keeps counting
+	byRecipient := map[string]models.SendNotificationRequest{}
+	for _, req := range sent {
+		for _, recipient := range req.Recipients {
+			byRecipient[recipient] = req
+		}
+	}
+	mention, burst := byRecipient[kid.ID.Hex()], byRecipient[mom.ID.Hex()]
+	if mention.Message != "Dad: @Kid pick up milk" || mention.CollapseKey != "" || !mention.Immediate || len(mention.Recipients) != 1 {
+		t.Errorf("mention notification = %+v, want the message to the kid alone, uncollapsed and immediate", mention)
+	}
+	if burst.Message != "2 new messages from Dad" || burst.CollapseKey == "" || len(burst.Recipients) != 1 {
+		t.Errorf("burst notification = %+v, want mom's count", burst)
+	}
+
+	// Direct messages are never coalesced
+	direct := env.Factory.Circle(dad, []*models.User{mom}, func(circle *models.Circle) { circle.Name = "Direct Message" })
+	for _, content := range []string{"hi", "are you there"} {
+		req := send(direct, content, 1)[0]
+		if req.Title != "Dad" || req.Message != content || req.CollapseKey != "" || !req.Immediate {
+			t.Errorf("direct message notification = %+v, want %q on its own", req, content)
+		}
+	}
+}
+
+func TestMessageMentions(t *testing.T) {
+	kid := models.User{ID: primitive.NewObjectID(), FirstName: "Kid"}
+	tests := []struct {
+		content string
+		want    bool
+	}{
+		{"@Kid pick up milk", true},
+		{"pick up milk @kid", true},
+		{"@" + kid.ID.Hex() + " hi", true},
+		{"Kid pick up milk", false},
+		{"@Mom pick up milk", false},
+	}
+	for _, tt := range tests {
+		if got := messageMentions(tt.content, kid); got != tt.want {
+			t.Errorf("messageMentions(%q) = %v, want %v", tt.content, got, tt.want)
+		}
+	}
+
+	if messageMentions("@ hello", models.User{ID: primitive.NewObjectID()}) {
+		t.Error("a user without a first name is mentioned by a bare @")
+	}
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"ftrack/models"
+	"ftrack/testharness"
+)
+
+func TestMessageComposedAt(t *testing.T) {
+	received := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		composed := received.Add(d)
+		return &composed
+	}
+
+	tests := []struct {
+		name       string
+		composedAt *time.Time
+		want       time.Time
+	}{
+		{"not given", nil, received},
+		{"offline for an hour", at(-time.Hour), received.Add(-time.Hour)},
+		{"a week ago", at(-models.MaxMessageComposedAge), received.Add(-models.MaxMessageComposedAge)},
+		{"longer ago than a week", at(-models.MaxMessageComposedAge - time.Second), received},
+		{"in the future", at(time.Minute), received},
+	}
+	for _, tt := range tests {
+		if got := messageComposedAt(tt.composedAt, received); !got.Equal(tt.want) {
+			t.Errorf("%s: composed at %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSendMessageClientIDDedup(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ms := newTestMessageService(env)
+	ctx := context.Background()
+
+	alice, bob := env.Factory.User(), env.Factory.User()
+	circle := env.Factory.Circle(alice, []*models.User{bob})
+	send := func(sender *models.User, clientID, content string) *models.Message {
+		t.Helper()
+		message, err := ms.SendMessage(ctx, sender.ID.Hex(), models.SendMessageRequest{CircleID: circle.ID.Hex(), Type: "text", Content: content, ClientID: clientID})
+		if err != nil {
+			t.Fatalf("SendMessage: %v", err)
+		}
+		return message
+	}
+
+	first := send(alice, "draft-1", "on my way")
+	if first.IsDuplicate || first.ClientID != "draft-1" {
+		t.Errorf("first send = %+v, want it stored under the client ID", first)
+	}
+
+	// The resend maps the client ID to the stored message
+	resent := send(alice, "draft-1", "on my way")
+	if !resent.IsDuplicate || resent.ID != first.ID || resent.Content != "on my way" {
+		t.Errorf("resend = %+v, want the stored message marked a duplicate", resent)
+	}
+
+	// Client IDs are the sender's own
+	theirs := send(bob, "draft-1", "me too")
+	if theirs.IsDuplicate || theirs.ID == first.ID {
+		t.Errorf("another sender's message with the same client ID = %+v, want a new message", theirs)
+	}
+
+	var clientIDs []string
+	for _, broadcast := range env.Hub.WaitForBroadcasts(t, models.WSTypeMessage, 2) {
+		data := broadcast.Message.Data.(models.WSMessageData)
+		clientIDs = append(clientIDs, data.SenderID+"/"+data.ClientID)
+	}
+	sort.Strings(clientIDs)
+	want := []string{alice.ID.Hex() + "/draft-1", bob.ID.Hex() + "/draft-1"}
+	sort.Strings(want)
+	if strings.Join(clientIDs, ",") != strings.Join(want, ",") {
+		t.Errorf("broadcasts for %v, want one per stored message", clientIDs)
+	}
+
+	// Resends racing each other store the message once
+	var wg sync.WaitGroup
+	ids := make([]string, 8)
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			message, err := ms.SendMessage(ctx, alice.ID.Hex(), models.SendMessageRequest{CircleID: circle.ID.Hex(), Type: "text", Content: "flaky network", ClientID: "draft-2"})
+			if err != nil {
+				t.Errorf("SendMessage: %v", err)
+				return
+			}
+			ids[i] = message.ID.Hex()
+		}(i)
+	}
+	wg.Wait()
+	for _, id := range ids {
+		if id != ids[0] {
+			t.Fatalf("concurrent resends returned messages %v, want one", ids)
+		}
+	}
+
+	response, err := ms.GetCircleMessages(ctx, bob.ID.Hex(), models.GetMessagesRequest{CircleID: circle.ID.Hex(), Page: 1, PageSize: 50})
+	if err != nil {
+		t.Fatalf("GetCircleMessages: %v", err)
+	}
+	if response.Total != 3 {
+		t.Errorf("circle has %d messages, want 3", response.Total)
+	}
+
+	if _, err := ms.SendMessage(ctx, alice.ID.Hex(), models.SendMessageRequest{
+		CircleID: circle.ID.Hex(), Type: "text", Content: "x", ClientID: strings.Repeat("c", 65),
+	}); err == nil || err.Error() != "validation failed" {
+		t.Errorf("send with a long client ID error = %v, want validation failed", err)
+	}
+}
+
+func TestSendMessageComposedOrder(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ms := newTestMessageService(env)
+	ctx := context.Background()
+
+	alice, bob := env.Factory.User(), env.Factory.User()
+	circle := env.Factory.Circle(alice, []*models.User{bob})
+	send := func(sender *models.User, content string, composedAt *time.Time) *models.Message {
+		t.Helper()
+		message, err := ms.SendMessage(ctx, sender.ID.Hex(), models.SendMessageRequest{
+			CircleID: circle.ID.Hex(), Type: "text", Content: content, ComposedAt: composedAt,
+		})
+		if err != nil {
+			t.Fatalf("SendMessage: %v", err)
+		}
+		return message
+	}
+	ago := func(d time.Duration) *time.Time {
+		at := time.Now().Add(-d)
+		return &at
+	}
+
+	// Bob was online; alice's phone sends what she wrote offline later,
+	// two of the messages in the same second
+	sameSecond := ago(10 * time.Minute)
+	online := send(bob, "where are you?", nil)
+	reply := send(alice, "in the tunnel", ago(20*time.Minute))
+	tied := send(alice, "no signal", sameSecond)
+	tiedLater := send(alice, "be there soon", sameSecond)
+	skewed := send(alice, "clock ahead", ago(-time.Hour))
+
+	history := func(before string) []models.Message {
+		t.Helper()
+		response, err := ms.GetCircleMessages(ctx, bob.ID.Hex(), models.GetMessagesRequest{CircleID: circle.ID.Hex(), Page: 1, PageSize: 50, Before: before})
+		if err != nil {
+			t.Fatalf("GetCircleMessages: %v", err)
+		}
+		return response.Messages
+	}
+
+	// Newest first, by composed time and then by arrival; a clock ahead
+	// counts as arriving now
+	expectMessageIDs(t, "history", history(""), skewed, online, tiedLater, tied, reply)
+	expectMessageIDs(t, "history before the tie", history(tiedLater.ID.Hex()), tied, reply)
+	expectMessageIDs(t, "history before the online message", history(online.ID.Hex()), tiedLater, tied, reply)
+
+	if skewed.ComposedAt.After(time.Now()) {
+		t.Errorf("message composed at %v, in the future", skewed.ComposedAt)
+	}
+}
@@ -0,0 +1,576 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"ftrack/models"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font/sfnt"
+)
+
+// PDF layout, in millimetres and points
+const (
+	pdfMargin          = 15.0
+	pdfLineHeight      = 5.0
+	pdfBodyFontSize    = 10.0
+	pdfMetaFontSize    = 8.0
+	pdfTitleFontSize   = 16.0
+	pdfThumbnailMaxDim = 50.0
+	pdfThumbnailPixels = 400
+
+	pdfBuiltinFont  = "Helvetica"
+	pdfMainFont     = "ExportMain"
+	pdfFallbackFont = "ExportFallback"
+)
+
+// pdfFace is a font usable in an export document. A nil sfnt font means
+// the built-in Helvetica, which only covers Latin-1.
+type pdfFace struct {
+	family string
+	data   []byte
+	font   *sfnt.Font
+	buf    sfnt.Buffer
+}
+
+func (pf *pdfFace) covers(r rune) bool {
+	// The PDF library only handles the Basic Multilingual Plane, most emoji
+	// lie outside it
+	if r > 0xFFFF {
+		return false
+	}
+	if pf.font == nil {
+		return r < 0x100
+	}
+	index, err := pf.font.GlyphIndex(&pf.buf, r)
+	return err == nil && index != 0
+}
+
+// loadPDFFace loads a TrueType font and checks that the PDF library can
+// embed it
+func loadPDFFace(family, path string) (*pdfFace, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	font, err := sfnt.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	probe := fpdf.New("P", "mm", "A4", "")
+	probe.AddUTF8FontFromBytes(family, "", data)
+	if err := probe.Error(); err != nil {
+		return nil, err
+	}
+
+	return &pdfFace{family: family, data: data, font: font}, nil
+}
+
+// pdfTextRun is a piece of text drawn with a single font
+type pdfTextRun struct {
+	face *pdfFace
+	text string
+}
+
+// messagePDFRenderer renders circle messages into PDF files of at most
+// PagesPerFile pages. Only the current file is kept in memory.
+type messagePDFRenderer struct {
+	ms     *MessageService
+	config PDFExportConfig
+
+	main       *pdfFace
+	fallback   *pdfFace
+	translator func(string) string
+
+	circleName   string
+	includeMedia bool
+	senderNames  map[primitive.ObjectID]string
+
+	// The search the export is limited to, if any
+	search *models.SearchMessagesRequest
+
+	// Stamped into each file's metadata, and the watermark printed in the
+	// footer of every page
+	stamp     string
+	watermark string
+
+	pdf     *fpdf.Fpdf
+	part    int
+	lastDay string
+	images  int
+}
+
+func (ms *MessageService) newMessagePDFRenderer(ctx context.Context, circleID string, includeMedia bool) *messagePDFRenderer {
+	r := &messagePDFRenderer{
+		ms:           ms,
+		config:       ms.exportService.PDFConfig(),
+		main:         &pdfFace{family: pdfBuiltinFont},
+		circleName:   "Circle",
+		includeMedia: includeMedia,
+		senderNames:  make(map[primitive.ObjectID]string),
+	}
+
+	if r.config.FontPath != "" {
+		face, err := loadPDFFace(pdfMainFont, r.config.FontPath)
+		if err != nil {
+			logrus.Warnf("Failed to load PDF export font %s, using built-in font: %v", r.config.FontPath, err)
+		} else {
+			r.main = face
+		}
+	}
+	if r.config.FallbackFontPath != "" {
+		face, err := loadPDFFace(pdfFallbackFont, r.config.FallbackFontPath)
+		if err != nil {
+			logrus.Warnf("Failed to load PDF export fallback font %s: %v", r.config.FallbackFontPath, err)
+		} else {
+			r.fallback = face
+		}
+	}
+
+	if circle, err := ms.circleRepo.GetByID(ctx, circleID); err == nil {
+		r.circleName = circle.Name
+	}
+
+	return r
+}
+
+// writeMessagePDFExport renders circle messages batch by batch, starting a
+// new file whenever the current one reaches the page limit
+func (ms *MessageService) writeMessagePDFExport(ctx context.Context, nextPart func() (io.Writer, error), circleID string, req models.ExportMessagesRequest, progress func(int)) (written int, err error) {
+	// The PDF library panics on some malformed input, fail the export
+	// rather than the process
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("pdf rendering failed: %v", recovered)
+		}
+	}()
+
+	r := ms.newMessagePDFRenderer(ctx, circleID, req.IncludeMedia)
+	r.search = req.Search
+	r.stamp = exportHeader(ctx, models.ExportTypeMessages).Stamp()
+	if watermark, ok := watermarkFromContext(ctx); ok {
+		requestedBy, _ := primitive.ObjectIDFromHex(watermark.RequestedBy)
+		r.watermark = fmt.Sprintf("Exported by %s on %s UTC, export %s",
+			r.senderName(ctx, requestedBy), watermark.RequestedAt.UTC().Format("2006-01-02 15:04"), watermark.ExportID)
+	}
+	r.startPart(req.DateRange)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+
+		messages, total, err := ms.messageExportBatch(ctx, circleID, req, written, messageExportBatchSize)
+		if err != nil {
+			return written, err
+		}
+		if len(messages) == 0 {
+			break
+		}
+
+		for _, message := range messages {
+			if r.pdf.PageNo() >= r.config.PagesPerFile {
+				if err := r.finishPart(nextPart); err != nil {
+					return written, err
+				}
+				r.startPart(req.DateRange)
+			}
+
+			r.renderMessage(ctx, message)
+			if err := r.pdf.Error(); err != nil {
+				return written, err
+			}
+			written++
+		}
+
+		if total > 0 {
+			progress(int(int64(written) * 99 / total))
+		}
+		if len(messages) < messageExportBatchSize {
+			break
+		}
+	}
+
+	if written == 0 {
+		r.setFont(r.main, "", pdfBodyFontSize)
+		r.pdf.MultiCell(0, pdfLineHeight, "No messages in this period.", "", "C", false)
+	}
+
+	return written, r.finishPart(nextPart)
+}
+
+func (r *messagePDFRenderer) startPart(dateRange models.ExportDateRange) {
+	r.part++
+	r.lastDay = ""
+	r.images = 0
+
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(pdfMargin, pdfMargin, pdfMargin)
+	pdf.SetAutoPageBreak(true, pdfMargin)
+	pdf.AliasNbPages("")
+	pdf.SetTitle(r.circleName+" chat", true)
+	pdf.SetCreator("FTrack", true)
+	pdf.SetKeywords(r.stamp, true)
+
+	for _, face := range []*pdfFace{r.main, r.fallback} {
+		if face != nil && face.font != nil {
+			// The bold style reuses the regular font file, UTF-8 fonts have no
+			// synthetic bold
+			pdf.AddUTF8FontFromBytes(face.family, "", face.data)
+			pdf.AddUTF8FontFromBytes(face.family, "B", face.data)
+		}
+	}
+	if r.main.font == nil {
+		r.translator = pdf.UnicodeTranslatorFromDescriptor("")
+	}
+
+	r.pdf = pdf
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-pdfMargin + 3)
+		r.setFont(r.main, "", pdfMetaFontSize)
+		pdf.SetTextColor(128, 128, 128)
+		if r.watermark != "" {
+			pdf.CellFormat(0, pdfLineHeight, r.encode(r.main, r.watermark), "", 2, "C", false, 0, "")
+		}
+		pdf.CellFormat(0, pdfLineHeight, fmt.Sprintf("Page %d of {nb}", pdf.PageNo()), "", 0, "C", false, 0, "")
+	})
+
+	pdf.AddPage()
+
+	r.pdf.SetTextColor(0, 0, 0)
+	r.writeRuns(r.splitRuns(r.circleName), "B", pdfTitleFontSize, pdfLineHeight*2)
+	pdf.Ln(pdfLineHeight * 2)
+
+	subtitle := "Chat export"
+	if r.search != nil {
+		subtitle = "Search results"
+		if r.search.Query != "" {
+			subtitle = fmt.Sprintf("Messages matching %q", r.search.Query)
+		}
+	}
+	if from, to := dateRange.From, dateRange.To; from != nil || to != nil {
+		subtitle += ", " + formatPDFDateRange(from, to)
+	}
+	if r.part > 1 {
+		subtitle += fmt.Sprintf(", part %d", r.part)
+	}
+	subtitle += ". Times are in UTC."
+
+	pdf.SetTextColor(110, 110, 110)
+	r.setFont(r.main, "", pdfMetaFontSize)
+	pdf.CellFormat(0, pdfLineHeight, r.encode(r.main, subtitle), "", 1, "L", false, 0, "")
+	pdf.Ln(pdfLineHeight)
+	pdf.SetTextColor(0, 0, 0)
+}
+
+func (r *messagePDFRenderer) finishPart(nextPart func() (io.Writer, error)) error {
+	w, err := nextPart()
+	if err != nil {
+		return err
+	}
+	return r.pdf.Output(w)
+}
+
+func (r *messagePDFRenderer) renderMessage(ctx context.Context, message models.Message) {
+	pdf := r.pdf
+	createdAt := message.CreatedAt.UTC()
+
+	if day := createdAt.Format("2006-01-02"); day != r.lastDay {
+		r.lastDay = day
+		r.renderDaySeparator(createdAt)
+	}
+
+	// Sender and time
+	pdf.SetTextColor(0, 0, 0)
+	r.writeRuns(r.splitRuns(r.senderName(ctx, message.SenderID)), "B", pdfBodyFontSize, pdfLineHeight)
+	pdf.SetTextColor(128, 128, 128)
+	r.setFont(r.main, "", pdfMetaFontSize)
+	pdf.Write(pdfLineHeight, r.encode(r.main, "  "+createdAt.Format("15:04")))
+	pdf.Ln(pdfLineHeight)
+	pdf.SetTextColor(0, 0, 0)
+
+	switch message.Type {
+	case "photo":
+		if !r.includeMedia || !r.renderThumbnail(message.Media) {
+			r.renderPlaceholder("[Photo]")
+		}
+	case "voice":
+		r.renderPlaceholder("[Voice message]")
+	case "sticker":
+		r.renderPlaceholder("[Sticker]")
+	case "location":
+		place := message.Location.PlaceName
+		if place == "" {
+			place = message.Location.Address
+		}
+		if place == "" {
+			place = fmt.Sprintf("%.5f, %.5f", message.Location.Latitude, message.Location.Longitude)
+		}
+		r.renderPlaceholder("[Location: " + place + "]")
+	}
+
+	if content := strings.TrimSpace(message.Content); content != "" {
+		r.renderParagraph(content)
+	}
+
+	pdf.Ln(pdfLineHeight / 2)
+}
+
+func (r *messagePDFRenderer) renderDaySeparator(day time.Time) {
+	pdf := r.pdf
+	pageWidth, _ := pdf.GetPageSize()
+
+	pdf.Ln(pdfLineHeight / 2)
+	y := pdf.GetY() + pdfLineHeight/2
+	pdf.SetDrawColor(200, 200, 200)
+	pdf.Line(pdfMargin, y, pageWidth-pdfMargin, y)
+
+	label := " " + day.Format("Monday, January 2, 2006") + " "
+	r.setFont(r.main, "B", pdfMetaFontSize)
+	labelWidth := pdf.GetStringWidth(label) + 4
+	pdf.SetFillColor(255, 255, 255)
+	pdf.SetTextColor(110, 110, 110)
+	pdf.SetX((pageWidth - labelWidth) / 2)
+	pdf.CellFormat(labelWidth, pdfLineHeight, r.encode(r.main, label), "", 1, "C", true, 0, "")
+	pdf.Ln(pdfLineHeight / 2)
+	pdf.SetTextColor(0, 0, 0)
+}
+
+func (r *messagePDFRenderer) renderPlaceholder(text string) {
+	r.pdf.SetTextColor(110, 110, 110)
+	r.renderParagraph(text)
+	r.pdf.SetTextColor(0, 0, 0)
+}
+
+// renderParagraph draws message text. Right-to-left paragraphs are drawn
+// right-aligned in the main font; left-to-right text switches to the
+// fallback font for characters the main font can't show.
+func (r *messagePDFRenderer) renderParagraph(text string) {
+	runs := r.splitRuns(text)
+
+	if isRTLText(text) {
+		box, face := r.placeholder()
+		if face != r.main {
+			box = '?'
+		}
+
+		var b strings.Builder
+		for _, run := range runs {
+			if run.face == r.main {
+				b.WriteString(run.text)
+			} else {
+				b.WriteString(strings.Repeat(string(box), len([]rune(run.text))))
+			}
+		}
+
+		r.setFont(r.main, "", pdfBodyFontSize)
+		r.pdf.RTL()
+		r.pdf.MultiCell(0, pdfLineHeight, r.encode(r.main, b.String()), "", "R", false)
+		r.pdf.LTR()
+		return
+	}
+
+	r.writeRuns(runs, "", pdfBodyFontSize, pdfLineHeight)
+	r.pdf.Ln(pdfLineHeight)
+}
+
+func (r *messagePDFRenderer) writeRuns(runs []pdfTextRun, style string, size, lineHeight float64) {
+	for _, run := range runs {
+		r.setFont(run.face, style, size)
+		r.pdf.Write(lineHeight, r.encode(run.face, run.text))
+	}
+}
+
+// splitRuns splits text into runs by the font that can draw each
+// character. Characters no font covers become a placeholder box, emoji
+// modifiers that no font covers are dropped.
+func (r *messagePDFRenderer) splitRuns(text string) []pdfTextRun {
+	var (
+		runs    []pdfTextRun
+		current *pdfFace
+		b       strings.Builder
+	)
+
+	flush := func() {
+		if b.Len() > 0 {
+			runs = append(runs, pdfTextRun{face: current, text: b.String()})
+			b.Reset()
+		}
+	}
+
+	for _, ch := range text {
+		if ch != '\n' && unicode.IsControl(ch) {
+			continue
+		}
+
+		face := r.main
+		switch {
+		case ch == '\n' || ch == ' ' || r.main.covers(ch):
+		case r.fallback != nil && r.fallback.covers(ch):
+			face = r.fallback
+		case isEmojiModifier(ch):
+			continue
+		default:
+			ch, face = r.placeholder()
+		}
+
+		if face != current {
+			flush()
+			current = face
+		}
+		b.WriteRune(ch)
+	}
+	flush()
+
+	return runs
+}
+
+// renderThumbnail draws a photo's thumbnail and reports whether it could
+func (r *messagePDFRenderer) renderThumbnail(media models.MessageMedia) bool {
+	source := media.ThumbnailURL
+	if source == "" {
+		source = media.URL
+	}
+	if source == "" {
+		return false
+	}
+
+	data, err := r.ms.mediaService.DownloadThumbnail(source)
+	if err != nil {
+		logrus.Debugf("Skipping thumbnail %s in PDF export: %v", source, err)
+		return false
+	}
+
+	// Re-encode as a small JPEG so any decodable image embeds safely and
+	// the part stays small
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return false
+	}
+
+	bounds := img.Bounds()
+	if bounds.Dx() == 0 || bounds.Dy() == 0 {
+		return false
+	}
+
+	scale := float64(pdfThumbnailPixels) / float64(max(bounds.Dx(), bounds.Dy()))
+	if scale < 1 {
+		scaled := image.NewRGBA(image.Rect(0, 0, int(float64(bounds.Dx())*scale), int(float64(bounds.Dy())*scale)))
+		draw.ApproxBiLinear.Scale(scaled, scaled.Bounds(), img, bounds, draw.Over, nil)
+		img = scaled
+		bounds = scaled.Bounds()
+	}
+
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, &jpeg.Options{Quality: 75}); err != nil {
+		return false
+	}
+
+	width, height := pdfThumbnailMaxDim, pdfThumbnailMaxDim
+	if bounds.Dx() >= bounds.Dy() {
+		height = width * float64(bounds.Dy()) / float64(bounds.Dx())
+	} else {
+		width = height * float64(bounds.Dx()) / float64(bounds.Dy())
+	}
+
+	pdf := r.pdf
+	_, pageHeight := pdf.GetPageSize()
+	if pdf.GetY()+height > pageHeight-pdfMargin {
+		pdf.AddPage()
+	}
+
+	r.images++
+	name := fmt.Sprintf("thumb%d", r.images)
+	options := fpdf.ImageOptions{ImageType: "JPG"}
+	pdf.RegisterImageOptionsReader(name, options, &encoded)
+	pdf.ImageOptions(name, pdfMargin, pdf.GetY(), width, height, true, options, 0, "")
+	pdf.Ln(pdfLineHeight / 2)
+
+	return pdf.Ok()
+}
+
+// placeholder returns the glyph drawn for characters no font covers
+func (r *messagePDFRenderer) placeholder() (rune, *pdfFace) {
+	const box = '\u25A1'
+	if r.main.covers(box) {
+		return box, r.main
+	}
+	if r.fallback != nil && r.fallback.covers(box) {
+		return box, r.fallback
+	}
+	return '?', r.main
+}
+
+func (r *messagePDFRenderer) senderName(ctx context.Context, senderID primitive.ObjectID) string {
+	if name, exists := r.senderNames[senderID]; exists {
+		return name
+	}
+
+	name := "Former member"
+	if user, err := r.ms.userRepo.GetByID(ctx, senderID.Hex()); err == nil {
+		name = strings.TrimSpace(user.FirstName + " " + user.LastName)
+	}
+
+	r.senderNames[senderID] = name
+	return name
+}
+
+func (r *messagePDFRenderer) setFont(face *pdfFace, style string, size float64) {
+	r.pdf.SetFont(face.family, style, size)
+}
+
+// encode converts text for the built-in font, UTF-8 fonts take it as is
+func (r *messagePDFRenderer) encode(face *pdfFace, text string) string {
+	if face.font == nil && r.translator != nil {
+		return r.translator(text)
+	}
+	return text
+}
+
+// isRTLText reports whether the first strongly directional character of
+// the text belongs to a right-to-left script
+func isRTLText(text string) bool {
+	for _, ch := range text {
+		switch {
+		case unicode.In(ch, unicode.Hebrew, unicode.Arabic, unicode.Syriac, unicode.Thaana, unicode.Nko):
+			return true
+		case unicode.IsLetter(ch):
+			return false
+		}
+	}
+	return false
+}
+
+// isEmojiModifier reports whether the rune only changes how the previous
+// emoji is drawn: joiners, variation selectors and skin tones
+func isEmojiModifier(ch rune) bool {
+	return ch == 0x200D ||
+		(ch >= 0xFE00 && ch <= 0xFE0F) ||
+		(ch >= 0x1F3FB && ch <= 0x1F3FF)
+}
+
+func formatPDFDateRange(from, to *time.Time) string {
+	const layout = "January 2, 2006"
+	switch {
+	case from != nil && to != nil:
+		return from.UTC().Format(layout) + " to " + to.UTC().Format(layout)
+	case from != nil:
+		return "since " + from.UTC().Format(layout)
+	default:
+		return "until " + to.UTC().Format(layout)
+	}
+}
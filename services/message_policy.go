@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"ftrack/models"
+	"ftrack/utils"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// effectiveMessagePolicy fills the values a circle's policy leaves unset
+// with the defaults
+func effectiveMessagePolicy(policy *models.MessagePolicy) models.MessagePolicy {
+	effective := models.MessagePolicy{}
+	if policy != nil {
+		effective = *policy
+	}
+	if effective.Edit == "" {
+		effective.Edit = models.MessagePolicySenders
+	}
+	if effective.EditWindow == nil {
+		window := models.DefaultMessageEditWindow
+		effective.EditWindow = &window
+	}
+	if effective.Delete == "" {
+		effective.Delete = models.MessagePolicySenders
+	}
+	return effective
+}
+
+// circleMessagePolicy returns the effective policy of the message's circle
+func (ms *MessageService) circleMessagePolicy(ctx context.Context, message *models.Message) (models.MessagePolicy, error) {
+	circle, err := ms.circleRepo.GetByID(ctx, message.CircleID.Hex())
+	if err != nil {
+		return models.MessagePolicy{}, err
+	}
+	return effectiveMessagePolicy(circle.MessagePolicy), nil
+}
+
+// checkEditPolicy returns why the user may not edit the message, if the
+// circle's policy forbids it. Only senders edit, whatever the policy.
+func (ms *MessageService) checkEditPolicy(ctx context.Context, message *models.Message, userID string) error {
+	if message.SenderID.Hex() != userID {
+		return errors.New("access denied")
+	}
+
+	policy, err := ms.circleMessagePolicy(ctx, message)
+	if err != nil {
+		return err
+	}
+
+	switch policy.Edit {
+	case models.MessagePolicyNone:
+		return errors.New("editing disabled")
+	case models.MessagePolicyAdmins:
+		role, err := ms.circleRepo.GetMemberRole(ctx, message.CircleID.Hex(), userID)
+		if err != nil || role != "admin" {
+			return errors.New("editing restricted to admins")
+		}
+	}
+
+	if *policy.EditWindow > 0 && time.Since(message.CreatedAt) > time.Duration(*policy.EditWindow)*time.Minute {
+		return errors.New("edit time expired")
+	}
+	return nil
+}
+
+// checkDeletePolicy returns why the user may not delete the message, if the
+// circle's policy forbids it. Circle admins, and members whose role allows
+// it, delete other members' messages unless the policy lets nobody delete.
+func (ms *MessageService) checkDeletePolicy(ctx context.Context, message *models.Message, userID string) error {
+	policy, err := ms.circleMessagePolicy(ctx, message)
+	if err != nil {
+		return err
+	}
+	if policy.Delete == models.MessagePolicyNone {
+		return errors.New("deleting disabled")
+	}
+
+	isSender := message.SenderID.Hex() == userID
+	if isSender && policy.Delete == models.MessagePolicySenders {
+		return nil
+	}
+
+	allowed, err := ms.circleRepo.HasPermission(ctx, message.CircleID.Hex(), userID, models.CirclePermissionDeleteMessages)
+	if err != nil && err.Error() != "member not found" {
+		return err
+	}
+	if allowed {
+		return nil
+	}
+	if isSender {
+		return errors.New("deleting restricted to admins")
+	}
+	return errors.New("access denied")
+}
+
+// GetMessagePolicy returns who may edit and delete messages in the circle,
+// with the defaults filled in
+func (ms *MessageService) GetMessagePolicy(ctx context.Context, userID, circleID string) (*models.MessagePolicy, error) {
+	isMember, err := ms.circleRepo.IsMember(ctx, circleID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !isMember {
+		return nil, errors.New("access denied")
+	}
+
+	circle, err := ms.circleRepo.GetByID(ctx, circleID)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := effectiveMessagePolicy(circle.MessagePolicy)
+	return &policy, nil
+}
+
+// UpdateMessagePolicy replaces the circle's message policy. It applies to
+// messages already sent, too.
+func (ms *MessageService) UpdateMessagePolicy(ctx context.Context, userID, circleID string, req models.MessagePolicy) (*models.MessagePolicy, error) {
+	role, err := ms.circleRepo.GetMemberRole(ctx, circleID, userID)
+	if err != nil {
+		if err.Error() == "member not found" {
+			return nil, errors.New("access denied")
+		}
+		return nil, err
+	}
+	if role != "admin" {
+		return nil, errors.New("access denied")
+	}
+
+	if validationErrors := ms.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	req.UpdatedBy, _ = primitive.ObjectIDFromHex(userID)
+	req.UpdatedAt = time.Now()
+
+	if err := ms.circleRepo.Update(ctx, circleID, bson.M{"messagePolicy": req}); err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("Message policy of circle %s updated by %s", circleID, userID)
+
+	policy := effectiveMessagePolicy(&req)
+	return &policy, nil
+}
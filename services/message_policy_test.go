@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"ftrack/models"
+	"ftrack/testharness"
+	"ftrack/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestEffectiveMessagePolicy(t *testing.T) {
+	none, hour := 0, 60
+	tests := []struct {
+		name   string
+		policy *models.MessagePolicy
+		edit   string
+		window int
+		delete string
+	}{
+		{"no policy", nil, models.MessagePolicySenders, models.DefaultMessageEditWindow, models.MessagePolicySenders},
+		{"edits off", &models.MessagePolicy{Edit: models.MessagePolicyNone}, models.MessagePolicyNone, models.DefaultMessageEditWindow, models.MessagePolicySenders},
+		{"no edit limit", &models.MessagePolicy{EditWindow: &none}, models.MessagePolicySenders, 0, models.MessagePolicySenders},
+		{"admins only", &models.MessagePolicy{Edit: models.MessagePolicyAdmins, EditWindow: &hour, Delete: models.MessagePolicyAdmins}, models.MessagePolicyAdmins, 60, models.MessagePolicyAdmins},
+	}
+	for _, tt := range tests {
+		got := effectiveMessagePolicy(tt.policy)
+		if got.Edit != tt.edit || *got.EditWindow != tt.window || got.Delete != tt.delete {
+			t.Errorf("%s: policy edit %s for %d minutes, delete %s; want %s for %d, %s", tt.name,
+				got.Edit, *got.EditWindow, got.Delete, tt.edit, tt.window, tt.delete)
+		}
+	}
+}
+
+func TestMessagePolicyForbidsEdits(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ms := newTestMessageService(env)
+	ctx := context.Background()
+
+	admin, member, stranger := env.Factory.User(), env.Factory.User(), env.Factory.User()
+	announcements := env.Factory.Circle(admin, []*models.User{member})
+	circleID := announcements.ID.Hex()
+
+	setPolicy := func(policy models.MessagePolicy) {
+		t.Helper()
+		if _, err := ms.UpdateMessagePolicy(ctx, admin.ID.Hex(), circleID, policy); err != nil {
+			t.Fatalf("UpdateMessagePolicy: %v", err)
+		}
+	}
+	edit := func(user *models.User, message *models.Message) error {
+		_, err := ms.UpdateMessage(ctx, user.ID.Hex(), message.ID.Hex(), models.EditMessageRequest{Content: "edited"})
+		return err
+	}
+
+	// With edits off, the sender's edit is rejected and the message stays
+	setPolicy(models.MessagePolicy{Edit: models.MessagePolicyNone})
+	post := env.Factory.Message(announcements, member, "meeting at 5")
+	if err := edit(member, post); err == nil || err.Error() != "editing disabled" {
+		t.Errorf("edit in a circle forbidding edits error = %v, want editing disabled", err)
+	}
+	if err := edit(admin, env.Factory.Message(announcements, admin, "welcome")); err == nil || err.Error() != "editing disabled" {
+		t.Errorf("admin edit in a circle forbidding edits error = %v, want editing disabled", err)
+	}
+	stored, err := ms.GetMessage(ctx, admin.ID.Hex(), post.ID.Hex())
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	if stored.Content != "meeting at 5" || stored.IsEdited {
+		t.Errorf("message after a rejected edit = %q (edited %v), want it unchanged", stored.Content, stored.IsEdited)
+	}
+	if edits := env.Hub.Broadcasts(models.WSTypeMessageEdit); len(edits) != 0 {
+		t.Errorf("%d edit broadcasts for rejected edits", len(edits))
+	}
+
+	// Only admins edit, their own messages
+	setPolicy(models.MessagePolicy{Edit: models.MessagePolicyAdmins})
+	if err := edit(member, post); err == nil || err.Error() != "editing restricted to admins" {
+		t.Errorf("member edit error = %v, want editing restricted to admins", err)
+	}
+	if err := edit(admin, post); err == nil || err.Error() != "access denied" {
+		t.Errorf("admin editing a member's message error = %v, want access denied", err)
+	}
+	if err := edit(admin, env.Factory.Message(announcements, admin, "welcome")); err != nil {
+		t.Errorf("admin edit: %v", err)
+	}
+
+	// The edit window is the circle's, and 0 lifts it
+	old := env.Factory.Message(announcements, member, "an hour ago")
+	if _, err := env.DB.Collection("messages").UpdateOne(ctx, bson.M{"_id": old.ID}, bson.M{"$set": bson.M{"createdAt": time.Now().Add(-time.Hour)}}); err != nil {
+		t.Fatalf("dating message: %v", err)
+	}
+	window := 90
+	setPolicy(models.MessagePolicy{EditWindow: &window})
+	if err := edit(member, old); err != nil {
+		t.Errorf("edit within a 90 minute window: %v", err)
+	}
+	window = 30
+	setPolicy(models.MessagePolicy{EditWindow: &window})
+	if err := edit(member, old); err == nil || err.Error() != "edit time expired" {
+		t.Errorf("edit after a 30 minute window error = %v, want edit time expired", err)
+	}
+	window = 0
+	setPolicy(models.MessagePolicy{EditWindow: &window})
+	if err := edit(member, old); err != nil {
+		t.Errorf("edit without a window: %v", err)
+	}
+
+	// Only admins set the policy, and members read it
+	if _, err := ms.UpdateMessagePolicy(ctx, member.ID.Hex(), circleID, models.MessagePolicy{}); err == nil || err.Error() != "access denied" {
+		t.Errorf("member setting the policy error = %v, want access denied", err)
+	}
+	_, err = ms.UpdateMessagePolicy(ctx, admin.ID.Hex(), circleID, models.MessagePolicy{Edit: "moderators"})
+	if fields := utils.ValidationFailureFields(err); len(fields) != 1 || fields[0].Field != "edit" {
+		t.Errorf("unknown edit policy error = %v with fields %+v, want edit named", err, fields)
+	}
+	policy, err := ms.GetMessagePolicy(ctx, member.ID.Hex(), circleID)
+	if err != nil {
+		t.Fatalf("GetMessagePolicy: %v", err)
+	}
+	if policy.Edit != models.MessagePolicySenders || *policy.EditWindow != 0 || policy.Delete != models.MessagePolicySenders || policy.UpdatedBy != admin.ID {
+		t.Errorf("policy = %+v, want the last one set with defaults filled in", policy)
+	}
+	if _, err := ms.GetMessagePolicy(ctx, stranger.ID.Hex(), circleID); err == nil || err.Error() != "access denied" {
+		t.Errorf("stranger reading the policy error = %v, want access denied", err)
+	}
+}
+
+func TestMessagePolicyDeletes(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ms := newTestMessageService(env)
+	ctx := context.Background()
+
+	admin, member, other := env.Factory.User(), env.Factory.User(), env.Factory.User()
+	circle := env.Factory.Circle(admin, []*models.User{member, other})
+	setPolicy := func(policy models.MessagePolicy) {
+		t.Helper()
+		if _, err := ms.UpdateMessagePolicy(ctx, admin.ID.Hex(), circle.ID.Hex(), policy); err != nil {
+			t.Fatalf("UpdateMessagePolicy: %v", err)
+		}
+	}
+	remove := func(user *models.User, message *models.Message) error {
+		return ms.DeleteMessage(ctx, user.ID.Hex(), message.ID.Hex())
+	}
+
+	// Nobody deletes, admins included
+	setPolicy(models.MessagePolicy{Delete: models.MessagePolicyNone})
+	post := env.Factory.Message(circle, member, "minutes")
+	for _, user := range []*models.User{member, admin} {
+		if err := remove(user, post); err == nil || err.Error() != "deleting disabled" {
+			t.Errorf("delete in a circle forbidding deletes error = %v, want deleting disabled", err)
+		}
+	}
+
+	// Admins delete, senders don't
+	setPolicy(models.MessagePolicy{Delete: models.MessagePolicyAdmins})
+	if err := remove(member, post); err == nil || err.Error() != "deleting restricted to admins" {
+		t.Errorf("sender delete error = %v, want deleting restricted to admins", err)
+	}
+	if err := remove(other, post); err == nil || err.Error() != "access denied" {
+		t.Errorf("another member's delete error = %v, want access denied", err)
+	}
+	if err := remove(admin, post); err != nil {
+		t.Errorf("admin delete: %v", err)
+	}
+
+	// By default senders delete their own, and admins anyone's
+	setPolicy(models.MessagePolicy{})
+	if err := remove(member, env.Factory.Message(circle, member, "typo")); err != nil {
+		t.Errorf("sender delete under the default policy: %v", err)
+	}
+	if err := remove(admin, env.Factory.Message(circle, other, "spam")); err != nil {
+		t.Errorf("admin delete under the default policy: %v", err)
+	}
+}
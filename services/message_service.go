@@ -2,16 +2,21 @@ package services
 
 import (
 	"context"
+	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"ftrack/models"
 	"ftrack/repositories"
 	"ftrack/utils"
 	"ftrack/websocket"
-	"mime/multipart"
+	"io"
+	"os"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/go-redis/redis/v8"
 	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -28,12 +33,33 @@ type MessageService struct {
 	reportRepo     *repositories.ReportRepository
 	automationRepo *repositories.AutomationRepository
 	exportRepo     *repositories.ExportRepository
+	blockRepo      *repositories.BlockRepository
 	mediaService   *MediaService
+	uploads        *MediaUploadService
 	searchService  *SearchService
-	websocketHub   *websocket.Hub
+	exportService  *ExportService
+	websocketHub   MessageHub
 	validator      *utils.ValidationService
+	outbox         *OutboxService
+	albumRepo      *repositories.AlbumRepository
+
+	// Delivery summaries, written in batches
+	deliveryRepo *repositories.MessageDeliveryRepository
+	deliveries   *deliveryRecorder
 
 	redisClient interface{} // For typing indicators and caching
+
+	// Push notifications for new messages, coalesced per sender and circle
+	notificationService NotificationDispatcher
+	notificationWindow  time.Duration
+}
+
+// MessageHub is the part of the WebSocket hub the message service uses
+type MessageHub interface {
+	BroadcastMessage(roomID string, message models.WSMessage)
+	BroadcastFiltered(roomID string, message models.WSMessage, filter websocket.MessageFilter)
+	SendNotificationToUser(userID string, notification interface{})
+	OnMessageDelivered(fn func(userID, circleID, messageID string))
 }
 
 func NewMessageService(
@@ -47,9 +73,11 @@ func NewMessageService(
 	reportRepo *repositories.ReportRepository,
 	automationRepo *repositories.AutomationRepository,
 	exportRepo *repositories.ExportRepository,
-	websocketHub *websocket.Hub,
+	blockRepo *repositories.BlockRepository,
+	websocketHub MessageHub,
 	mediaService *MediaService,
 	searchService *SearchService,
+	exportService *ExportService,
 	redisClient interface{},
 ) *MessageService {
 	return &MessageService{
@@ -63,14 +91,28 @@ func NewMessageService(
 		reportRepo:     reportRepo,
 		automationRepo: automationRepo,
 		exportRepo:     exportRepo,
+		blockRepo:      blockRepo,
 		websocketHub:   websocketHub,
 		validator:      utils.NewValidationService(),
 		mediaService:   mediaService,
 		searchService:  searchService,
+		exportService:  exportService,
 		redisClient:    redisClient,
 	}
 }
 
+// ConfigureOutbox delivers new messages to the circle through the outbox
+// instead of a fire-and-forget broadcast
+func (ms *MessageService) ConfigureOutbox(outbox *OutboxService) {
+	ms.outbox = outbox
+}
+
+// ConfigureDirectUploads lets clients upload media straight to the object
+// store
+func (ms *MessageService) ConfigureDirectUploads(uploads *MediaUploadService) {
+	ms.uploads = uploads
+}
+
 // =============================================================================
 // BASIC MESSAGE OPERATIONS
 // =============================================================================
@@ -78,7 +120,10 @@ func NewMessageService(
 func (ms *MessageService) SendMessage(ctx context.Context, userID string, req models.SendMessageRequest) (*models.Message, error) {
 	// Validate request
 	if validationErrors := ms.validator.ValidateStruct(req); len(validationErrors) > 0 {
-		return nil, errors.New("validation failed")
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+	if err := ms.validator.ValidateMessageContent(req.Type, req.Content, req.Media, req.Location); err != nil {
+		return nil, err
 	}
 
 	// Check if user is a member of the circle
@@ -91,6 +136,15 @@ func (ms *MessageService) SendMessage(ctx context.Context, userID string, req mo
 		return nil, errors.New("access denied")
 	}
 
+	// Circles merged into another one are read-only
+	archived, err := ms.circleRepo.IsArchived(ctx, req.CircleID)
+	if err != nil {
+		return nil, err
+	}
+	if archived {
+		return nil, errors.New("circle is archived")
+	}
+
 	userObjectID, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
 		return nil, errors.New("invalid user ID")
@@ -101,15 +155,26 @@ func (ms *MessageService) SendMessage(ctx context.Context, userID string, req mo
 		return nil, errors.New("invalid circle ID")
 	}
 
+	// A client resending a message it composed offline gets the one
+	// already stored
+	if req.ClientID != "" {
+		if existing, err := ms.sentMessage(ctx, userObjectID, req.ClientID); existing != nil || err != nil {
+			return existing, err
+		}
+	}
+
 	// Create message
+	now := time.Now()
 	message := models.Message{
-		CircleID:  circleObjectID,
-		SenderID:  userObjectID,
-		Type:      req.Type,
-		Content:   req.Content,
-		Status:    "sent",
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		CircleID:   circleObjectID,
+		SenderID:   userObjectID,
+		Type:       req.Type,
+		Content:    req.Content,
+		Status:     "sent",
+		ClientID:   req.ClientID,
+		ComposedAt: messageComposedAt(req.ComposedAt, now),
+		CreatedAt:  now,
+		UpdatedAt:  now,
 	}
 
 	// Set media if provided
@@ -130,23 +195,68 @@ func (ms *MessageService) SendMessage(ctx context.Context, userID string, req mo
 		}
 	}
 
+	// Set template reference so usage counts can be recomputed
+	if req.TemplateID != "" {
+		templateObjectID, err := primitive.ObjectIDFromHex(req.TemplateID)
+		if err == nil {
+			message.TemplateID = templateObjectID
+		}
+	}
+
 	err = ms.messageRepo.Create(ctx, &message)
 	if err != nil {
+		// The same message sent twice at once: the other send stored it
+		if err.Error() == "client ID already used" {
+			return ms.sentMessage(ctx, userObjectID, req.ClientID)
+		}
 		return nil, err
 	}
+	ms.dropCircleOverview(ctx, message.CircleID.Hex())
 
 	// Process automation rules
-	go ms.ProcessAutomationRules(ctx, &message)
+	Background.Go(ctx, func(ctx context.Context) {
+		ms.ProcessAutomationRules(ctx, &message)
+	})
 
 	// Broadcast message to circle members via WebSocket
-	go ms.broadcastMessage(userID, req.CircleID, message)
+	ms.publishMessage(ctx, userID, req.CircleID, message)
+
+	// Push it to the other members
+	Background.Go(ctx, func(ctx context.Context) {
+		ms.notifyNewMessage(ctx, message)
+	})
 
 	// Update circle last activity
-	go ms.circleRepo.UpdateLastActivity(ctx, req.CircleID, userID)
+	Background.Go(ctx, func(ctx context.Context) {
+		ms.circleRepo.UpdateLastActivity(ctx, req.CircleID, userID)
+	})
 
 	return &message, nil
 }
 
+// sentMessage returns the message the sender stored under the client ID,
+// marked as a duplicate, or nil if there is none
+func (ms *MessageService) sentMessage(ctx context.Context, senderID primitive.ObjectID, clientID string) (*models.Message, error) {
+	message, err := ms.messageRepo.GetByClientID(ctx, senderID, clientID)
+	if err != nil {
+		if err.Error() == "message not found" {
+			return nil, nil
+		}
+		return nil, err
+	}
+	message.IsDuplicate = true
+	return message, nil
+}
+
+// messageComposedAt is when the client says the message was written, kept
+// between models.MaxMessageComposedAge ago and when the server got it
+func messageComposedAt(composedAt *time.Time, receivedAt time.Time) time.Time {
+	if composedAt == nil || composedAt.After(receivedAt) || receivedAt.Sub(*composedAt) > models.MaxMessageComposedAge {
+		return receivedAt
+	}
+	return *composedAt
+}
+
 func (ms *MessageService) GetCircleMessages(ctx context.Context, userID string, req models.GetMessagesRequest) (*models.MessagesResponse, error) {
 	// Check if user is a member of the circle
 	isMember, err := ms.circleRepo.IsMember(ctx, req.CircleID, userID)
@@ -158,18 +268,15 @@ func (ms *MessageService) GetCircleMessages(ctx context.Context, userID string,
 		return nil, errors.New("access denied")
 	}
 
-	if req.Page < 1 {
-		req.Page = 1
-	}
-	if req.PageSize < 1 || req.PageSize > 100 {
-		req.PageSize = 50
-	}
+	req.Page, req.PageSize = models.NormalizePageWithDefault(req.Page, req.PageSize, models.DefaultHistoryPageSize)
 
 	messages, total, err := ms.messageRepo.GetCircleMessagesPaginated(ctx, req)
 	if err != nil {
 		return nil, err
 	}
 
+	ms.collapseBlockedMessages(ctx, userID, messages)
+
 	return &models.MessagesResponse{
 		Messages:    messages,
 		Total:       total,
@@ -196,6 +303,10 @@ func (ms *MessageService) GetMessage(ctx context.Context, userID, messageID stri
 		return nil, errors.New("access denied")
 	}
 
+	if ms.getBlockedUserIDs(ctx, userID)[message.SenderID.Hex()] {
+		message.Collapse()
+	}
+
 	return message, nil
 }
 
@@ -205,14 +316,9 @@ func (ms *MessageService) UpdateMessage(ctx context.Context, userID, messageID s
 		return nil, err
 	}
 
-	// Check if user is the sender
-	if message.SenderID.Hex() != userID {
-		return nil, errors.New("access denied")
-	}
-
-	// Check if message can be edited (time limit, etc.)
-	if time.Since(message.CreatedAt) > 15*time.Minute {
-		return nil, errors.New("edit time expired")
+	// Check the sender may still edit it under the circle's policy
+	if err := ms.checkEditPolicy(ctx, message, userID); err != nil {
+		return nil, err
 	}
 
 	// Check message type (only text messages can be edited)
@@ -220,6 +326,10 @@ func (ms *MessageService) UpdateMessage(ctx context.Context, userID, messageID s
 		return nil, errors.New("only text messages can be edited")
 	}
 
+	if err := ms.validator.ValidateMessageContent(message.Type, req.Content, nil, nil); err != nil {
+		return nil, err
+	}
+
 	// Update message
 	update := bson.M{
 		"content":   req.Content,
@@ -234,7 +344,10 @@ func (ms *MessageService) UpdateMessage(ctx context.Context, userID, messageID s
 	}
 
 	// Broadcast edit to circle members
-	go ms.broadcastMessageEdit(userID, message.CircleID.Hex(), messageID, req.Content)
+	blockerIDs := ms.getBlockerIDs(ctx, userID)
+	Background.Go(ctx, func(context.Context) {
+		ms.broadcastMessageEdit(userID, message.CircleID.Hex(), messageID, req.Content, blockerIDs)
+	})
 
 	return ms.messageRepo.GetByID(ctx, messageID)
 }
@@ -245,25 +358,22 @@ func (ms *MessageService) DeleteMessage(ctx context.Context, userID, messageID s
 		return err
 	}
 
-	// Check if user is the sender or circle admin
-	if message.SenderID.Hex() != userID {
-		role, err := ms.circleRepo.GetMemberRole(ctx, message.CircleID.Hex(), userID)
-		if err != nil {
-			return err
-		}
-
-		if role != "admin" {
-			return errors.New("access denied")
-		}
+	// Check the circle's policy lets the user delete it
+	if err := ms.checkDeletePolicy(ctx, message, userID); err != nil {
+		return err
 	}
 
 	err = ms.messageRepo.SoftDelete(ctx, messageID)
 	if err != nil {
 		return err
 	}
+	ms.removeMessageFromAlbums(ctx, messageID)
+	ms.dropCircleOverview(ctx, message.CircleID.Hex())
 
 	// Broadcast deletion to circle members
-	go ms.broadcastMessageDeletion(userID, message.CircleID.Hex(), messageID)
+	Background.Go(ctx, func(context.Context) {
+		ms.broadcastMessageDeletion(userID, message.CircleID.Hex(), messageID)
+	})
 
 	return nil
 }
@@ -288,18 +398,15 @@ func (ms *MessageService) GetReplies(ctx context.Context, userID, messageID stri
 		return nil, errors.New("access denied")
 	}
 
-	if page < 1 {
-		page = 1
-	}
-	if pageSize < 1 || pageSize > 100 {
-		pageSize = 20
-	}
+	page, pageSize = models.NormalizePage(page, pageSize)
 
 	replies, total, err := ms.messageRepo.GetReplies(ctx, messageID, page, pageSize)
 	if err != nil {
 		return nil, err
 	}
 
+	ms.collapseBlockedMessages(ctx, userID, replies)
+
 	return &models.RepliesResponse{
 		Replies:     replies,
 		Total:       total,
@@ -342,7 +449,9 @@ func (ms *MessageService) ReplyToMessage(ctx context.Context, userID, messageID
 	}
 
 	// Update parent message reply count
-	go ms.messageRepo.IncrementReplyCount(ctx, messageID)
+	Background.Go(ctx, func(ctx context.Context) {
+		ms.messageRepo.IncrementReplyCount(ctx, messageID)
+	})
 
 	return reply, nil
 }
@@ -407,8 +516,14 @@ func (ms *MessageService) AddReaction(ctx context.Context, userID, messageID, em
 		return err
 	}
 
+	if err := ms.messageRepo.RecordReaction(ctx, message.CircleID, message.ID, userID, emoji); err != nil {
+		logrus.Warnf("Failed to record reaction on message %s: %v", messageID, err)
+	}
+
 	// Broadcast reaction to circle members
-	go ms.broadcastReaction(userID, message.CircleID.Hex(), messageID, emoji, "add")
+	Background.Go(ctx, func(context.Context) {
+		ms.broadcastReaction(userID, message.CircleID.Hex(), messageID, emoji, "add")
+	})
 
 	return nil
 }
@@ -433,12 +548,62 @@ func (ms *MessageService) RemoveReaction(ctx context.Context, userID, messageID,
 		return err
 	}
 
+	if err := ms.messageRepo.DeleteReactionRecord(ctx, message.ID, userID, emoji); err != nil {
+		logrus.Warnf("Failed to delete reaction record on message %s: %v", messageID, err)
+	}
+
 	// Broadcast reaction removal to circle members
-	go ms.broadcastReaction(userID, message.CircleID.Hex(), messageID, emoji, "remove")
+	Background.Go(ctx, func(context.Context) {
+		ms.broadcastReaction(userID, message.CircleID.Hex(), messageID, emoji, "remove")
+	})
 
 	return nil
 }
 
+// ToggleReaction flips the user's reaction atomically, so repeated or
+// concurrent taps never fail with an already-exists or not-found race
+func (ms *MessageService) ToggleReaction(ctx context.Context, userID, messageID, emoji string) (*models.ReactionToggleResponse, error) {
+	message, err := ms.messageRepo.GetByID(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	isMember, err := ms.circleRepo.IsMember(ctx, message.CircleID.Hex(), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isMember {
+		return nil, errors.New("access denied")
+	}
+
+	reacted, count, err := ms.messageRepo.ToggleReaction(ctx, messageID, userID, emoji)
+	if err != nil {
+		return nil, err
+	}
+
+	action := "remove"
+	if reacted {
+		action = "add"
+		err = ms.messageRepo.RecordReaction(ctx, message.CircleID, message.ID, userID, emoji)
+	} else {
+		err = ms.messageRepo.DeleteReactionRecord(ctx, message.ID, userID, emoji)
+	}
+	if err != nil {
+		logrus.Warnf("Failed to update reaction record on message %s: %v", messageID, err)
+	}
+	Background.Go(ctx, func(context.Context) {
+		ms.broadcastReactionChange(userID, message.CircleID.Hex(), messageID, emoji, action, &count)
+	})
+
+	return &models.ReactionToggleResponse{
+		MessageID: messageID,
+		Emoji:     emoji,
+		Reacted:   reacted,
+		Count:     count,
+	}, nil
+}
+
 func (ms *MessageService) GetReactionUsers(ctx context.Context, userID, messageID, emoji string) (*models.ReactionUsersResponse, error) {
 	message, err := ms.messageRepo.GetByID(ctx, messageID)
 	if err != nil {
@@ -497,6 +662,7 @@ func (ms *MessageService) UploadMedia(ctx context.Context, userID string, req mo
 		ThumbnailURL: media.ThumbnailURL,
 		Duration:     media.Duration,
 		Dimensions:   media.Dimensions,
+		ContentHash:  media.ContentHash,
 		UploadedBy:   userID,
 		UploadedAt:   time.Now(),
 	}
@@ -534,9 +700,53 @@ func (ms *MessageService) GetMedia(ctx context.Context, userID, mediaID string)
 		}
 	}
 
+	media.StreamURL = utils.SignURL(MediaStreamPath(media.ID.Hex()), MediaStreamURLTTL)
 	return media, nil
 }
 
+// Signed stream URLs are handed out with media and stay valid this long
+const MediaStreamURLTTL = 1 * time.Hour
+
+// MediaStreamPath returns the path media is streamed from
+func MediaStreamPath(mediaID string) string {
+	return fmt.Sprintf("/api/v1/messages/media/%s/stream", mediaID)
+}
+
+// MediaStream is an open media file to be served to a user. Callers must
+// close the file.
+type MediaStream struct {
+	File        *os.File
+	Name        string
+	ContentType string
+	ModTime     time.Time
+}
+
+// GetMediaStream opens media the user has access to for streaming
+func (ms *MessageService) GetMediaStream(ctx context.Context, userID, mediaID string) (*MediaStream, error) {
+	media, err := ms.GetMedia(ctx, userID, mediaID)
+	if err != nil {
+		return nil, err
+	}
+	if media.IsDeleted {
+		return nil, errors.New("media not found")
+	}
+
+	file, info, err := ms.mediaService.OpenFile(media.URL)
+	if err != nil {
+		if err.Error() == "file not found" {
+			return nil, errors.New("media not found")
+		}
+		return nil, err
+	}
+
+	return &MediaStream{
+		File:        file,
+		Name:        media.Filename,
+		ContentType: media.MimeType,
+		ModTime:     info.ModTime(),
+	}, nil
+}
+
 func (ms *MessageService) DeleteMedia(ctx context.Context, userID, mediaID string) error {
 	media, err := ms.mediaRepo.GetByID(ctx, mediaID)
 	if err != nil {
@@ -547,14 +757,22 @@ func (ms *MessageService) DeleteMedia(ctx context.Context, userID, mediaID strin
 		return errors.New("access denied")
 	}
 
-	// Delete from storage
-	err = ms.mediaService.DeleteFile(ctx, media.URL)
+	// Release the stored file; shared content stays until its last reference
+	err = ms.mediaService.ReleaseFile(ctx, media.URL)
 	if err != nil {
-		logrus.Errorf("Failed to delete media file: %v", err)
+		logrus.Errorf("Failed to release media file: %v", err)
 	}
 
 	// Delete from database
-	return ms.mediaRepo.Delete(ctx, mediaID)
+	if err := ms.mediaRepo.Delete(ctx, mediaID); err != nil {
+		return err
+	}
+
+	if err := ms.messageRepo.MarkMediaDeleted(ctx, media.ID); err != nil {
+		logrus.Errorf("Failed to mark deleted media on messages: %v", err)
+	}
+	ms.removeFromAlbums(ctx, bson.M{"mediaId": media.ID})
+	return nil
 }
 
 func (ms *MessageService) GetMediaThumbnail(ctx context.Context, userID, mediaID string) (*models.MediaThumbnail, error) {
@@ -590,8 +808,10 @@ func (ms *MessageService) CompressMedia(ctx context.Context, userID, mediaID str
 	}
 
 	// Update media record
+	originalURL := media.URL
 	media.URL = compressedMedia.URL
 	media.Size = compressedMedia.Size
+	media.ContentHash = ""
 	media.UpdatedAt = time.Now()
 
 	err = ms.mediaRepo.Update(ctx, mediaID, media)
@@ -599,6 +819,13 @@ func (ms *MessageService) CompressMedia(ctx context.Context, userID, mediaID str
 		return nil, err
 	}
 
+	// The record no longer references the shared original
+	if ms.mediaService.IsSharedFile(originalURL) {
+		if err := ms.mediaService.ReleaseFile(ctx, originalURL); err != nil {
+			logrus.Errorf("Failed to release media file: %v", err)
+		}
+	}
+
 	return media, nil
 }
 
@@ -607,6 +834,10 @@ func (ms *MessageService) CompressMedia(ctx context.Context, userID, mediaID str
 // =============================================================================
 
 func (ms *MessageService) SearchMessages(ctx context.Context, userID string, req models.SearchMessagesRequest) (*models.SearchResponse, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
 	// Get user's accessible circles
 	circles, err := ms.circleRepo.GetUserCircles(ctx, userID)
 	if err != nil {
@@ -618,6 +849,8 @@ func (ms *MessageService) SearchMessages(ctx context.Context, userID string, req
 		circleIDs[i] = circle.ID.Hex()
 	}
 
+	req.ExcludeSenderIDs = mapKeys(ms.getBlockedUserIDs(ctx, userID))
+
 	return ms.searchService.SearchMessages(ctx, req, circleIDs)
 }
 
@@ -631,6 +864,8 @@ func (ms *MessageService) SearchInCircle(ctx context.Context, userID string, req
 		return nil, errors.New("access denied")
 	}
 
+	req.ExcludeSenderIDs = mapKeys(ms.getBlockedUserIDs(ctx, userID))
+
 	return ms.searchService.SearchInCircle(ctx, req)
 }
 
@@ -659,6 +894,13 @@ func (ms *MessageService) SearchMentions(ctx context.Context, userID string, req
 		circleIDs[i] = circle.ID.Hex()
 	}
 
+	// Drop mentions across a block in either direction
+	relatedIDs, err := ms.blockRepo.GetRelatedUserIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	req.ExcludeSenderIDs = mapKeys(relatedIDs)
+
 	return ms.searchService.SearchMentions(ctx, userID, req, circleIDs)
 }
 
@@ -715,7 +957,9 @@ func (ms *MessageService) MarkAsRead(ctx context.Context, userID, messageID stri
 	}
 
 	// Broadcast read receipt
-	go ms.broadcastReadReceipt(userID, message.CircleID.Hex(), messageID)
+	Background.Go(ctx, func(context.Context) {
+		ms.broadcastReadReceipt(userID, message.CircleID.Hex(), messageID)
+	})
 
 	return nil
 }
@@ -766,30 +1010,13 @@ func (ms *MessageService) BulkMarkAsRead(ctx context.Context, userID string, req
 	}
 
 	// Broadcast bulk read receipts
-	go ms.broadcastBulkReadReceipts(userID, validMessageIDs)
+	Background.Go(ctx, func(context.Context) {
+		ms.broadcastBulkReadReceipts(userID, validMessageIDs)
+	})
 
 	return count, nil
 }
 
-func (ms *MessageService) GetDeliveryStatus(ctx context.Context, userID, messageID string) (*models.DeliveryStatusResponse, error) {
-	message, err := ms.messageRepo.GetByID(ctx, messageID)
-	if err != nil {
-		return nil, err
-	}
-
-	// Only sender can check delivery status
-	if message.SenderID.Hex() != userID {
-		return nil, errors.New("access denied")
-	}
-
-	status, err := ms.messageRepo.GetDeliveryStatus(ctx, messageID)
-	if err != nil {
-		return nil, err
-	}
-
-	return status, nil
-}
-
 func (ms *MessageService) GetReadReceipts(ctx context.Context, userID, messageID string) (*models.ReadReceiptsResponse, error) {
 	message, err := ms.messageRepo.GetByID(ctx, messageID)
 	if err != nil {
@@ -854,7 +1081,9 @@ func (ms *MessageService) ForwardToCircle(ctx context.Context, userID, messageID
 	}
 
 	// Record forward history
-	go ms.recordForwardHistory(messageID, forwardedMessage.ID.Hex(), userID, circleID)
+	Background.Go(ctx, func(context.Context) {
+		ms.recordForwardHistory(messageID, forwardedMessage.ID.Hex(), userID, circleID)
+	})
 
 	return forwardedMessage, nil
 }
@@ -910,7 +1139,7 @@ func (ms *MessageService) GetForwardHistory(ctx context.Context, userID, message
 func (ms *MessageService) ScheduleMessage(ctx context.Context, userID string, req models.ScheduleMessageRequest) (*models.ScheduledMessage, error) {
 	// Validate request
 	if validationErrors := ms.validator.ValidateStruct(req); len(validationErrors) > 0 {
-		return nil, errors.New("validation failed")
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
 	}
 
 	// Check if scheduled time is in the future
@@ -953,12 +1182,7 @@ func (ms *MessageService) ScheduleMessage(ctx context.Context, userID string, re
 }
 
 func (ms *MessageService) GetScheduledMessages(ctx context.Context, userID string, req models.GetScheduledMessagesRequest) (*models.ScheduledMessagesResponse, error) {
-	if req.Page < 1 {
-		req.Page = 1
-	}
-	if req.PageSize < 1 || req.PageSize > 100 {
-		req.PageSize = 20
-	}
+	req.Page, req.PageSize = models.NormalizePage(req.Page, req.PageSize)
 
 	messages, total, err := ms.scheduleRepo.GetUserScheduledMessages(ctx, userID, req)
 	if err != nil {
@@ -1011,11 +1235,8 @@ func (ms *MessageService) UpdateScheduledMessage(ctx context.Context, userID, sc
 	if req.Content != "" {
 		update["content"] = req.Content
 	}
-	if req.Media != nil {
-		update["media"] = req.Media
-	}
-	if req.Location != nil {
-		update["location"] = req.Location
+	if err := applyAttachmentUpdate(update, req.AttachmentUpdate); err != nil {
+		return nil, err
 	}
 	if req.ScheduledAt != nil {
 		update["scheduledAt"] = req.ScheduledAt
@@ -1029,6 +1250,33 @@ func (ms *MessageService) UpdateScheduledMessage(ctx context.Context, userID, sc
 	return ms.scheduleRepo.GetByID(ctx, scheduleID)
 }
 
+// applyAttachmentUpdate adds the media and location changes of a partial
+// update. Cleared fields are set to null, which decodes as no value.
+func applyAttachmentUpdate(update bson.M, req models.AttachmentUpdate) error {
+	if req.ClearMedia && req.Media != nil && !req.Media.IsEmpty() {
+		return utils.NewValidationFailedError("cannot both set and clear media")
+	}
+	if req.ClearLocation && req.Location != nil && !req.Location.IsEmpty() {
+		return utils.NewValidationFailedError("cannot both set and clear location")
+	}
+
+	switch {
+	case req.ClearMedia:
+		update["media"] = nil
+	case req.Media != nil && !req.Media.IsEmpty():
+		update["media"] = req.Media
+	}
+
+	switch {
+	case req.ClearLocation:
+		update["location"] = nil
+	case req.Location != nil && !req.Location.IsEmpty():
+		update["location"] = req.Location
+	}
+
+	return nil
+}
+
 func (ms *MessageService) CancelScheduledMessage(ctx context.Context, userID, scheduleID string) error {
 	scheduledMessage, err := ms.scheduleRepo.GetByID(ctx, scheduleID)
 	if err != nil {
@@ -1056,12 +1304,7 @@ func (ms *MessageService) CancelScheduledMessage(ctx context.Context, userID, sc
 // =============================================================================
 
 func (ms *MessageService) GetMessageTemplates(ctx context.Context, userID string, req models.GetTemplatesRequest) (*models.TemplatesResponse, error) {
-	if req.Page < 1 {
-		req.Page = 1
-	}
-	if req.PageSize < 1 || req.PageSize > 100 {
-		req.PageSize = 20
-	}
+	req.Page, req.PageSize = models.NormalizePage(req.Page, req.PageSize)
 
 	templates, total, err := ms.templateRepo.GetUserTemplates(ctx, userID, req)
 	if err != nil {
@@ -1080,7 +1323,7 @@ func (ms *MessageService) GetMessageTemplates(ctx context.Context, userID string
 
 func (ms *MessageService) CreateMessageTemplate(ctx context.Context, userID string, req models.CreateTemplateRequest) (*models.MessageTemplate, error) {
 	if validationErrors := ms.validator.ValidateStruct(req); len(validationErrors) > 0 {
-		return nil, errors.New("validation failed")
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
 	}
 
 	// Check if template name already exists for user
@@ -1209,10 +1452,11 @@ func (ms *MessageService) UseMessageTemplate(ctx context.Context, userID, templa
 
 	// Create message request
 	messageReq := models.SendMessageRequest{
-		CircleID: req.CircleID,
-		Type:     template.Type,
-		Content:  content,
-		Media:    template.Media,
+		CircleID:   req.CircleID,
+		Type:       template.Type,
+		Content:    content,
+		Media:      template.Media,
+		TemplateID: templateID,
 	}
 
 	message, err := ms.SendMessage(ctx, userID, messageReq)
@@ -1221,7 +1465,9 @@ func (ms *MessageService) UseMessageTemplate(ctx context.Context, userID, templa
 	}
 
 	// Increment template usage count
-	go ms.templateRepo.IncrementUsage(context.Background(), templateID)
+	Background.Go(ctx, func(ctx context.Context) {
+		ms.templateRepo.IncrementUsage(ctx, templateID)
+	})
 
 	return message, nil
 }
@@ -1231,12 +1477,7 @@ func (ms *MessageService) UseMessageTemplate(ctx context.Context, userID, templa
 // =============================================================================
 
 func (ms *MessageService) GetDrafts(ctx context.Context, userID string, req models.GetDraftsRequest) (*models.DraftsResponse, error) {
-	if req.Page < 1 {
-		req.Page = 1
-	}
-	if req.PageSize < 1 || req.PageSize > 100 {
-		req.PageSize = 20
-	}
+	req.Page, req.PageSize = models.NormalizePage(req.Page, req.PageSize)
 
 	drafts, total, err := ms.draftRepo.GetUserDrafts(ctx, userID, req)
 	if err != nil {
@@ -1255,7 +1496,7 @@ func (ms *MessageService) GetDrafts(ctx context.Context, userID string, req mode
 
 func (ms *MessageService) SaveDraft(ctx context.Context, userID string, req models.SaveDraftRequest) (*models.MessageDraft, error) {
 	if validationErrors := ms.validator.ValidateStruct(req); len(validationErrors) > 0 {
-		return nil, errors.New("validation failed")
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
 	}
 
 	// Check access to circle
@@ -1319,11 +1560,8 @@ func (ms *MessageService) UpdateDraft(ctx context.Context, userID, draftID strin
 	if req.Content != "" {
 		update["content"] = req.Content
 	}
-	if req.Media != nil {
-		update["media"] = req.Media
-	}
-	if req.Location != nil {
-		update["location"] = req.Location
+	if err := applyAttachmentUpdate(update, req.AttachmentUpdate); err != nil {
+		return nil, err
 	}
 
 	err = ms.draftRepo.Update(ctx, draftID, update)
@@ -1376,7 +1614,9 @@ func (ms *MessageService) SendDraft(ctx context.Context, userID, draftID string)
 	}
 
 	// Delete draft after successful send
-	go ms.draftRepo.Delete(context.Background(), draftID)
+	Background.Go(ctx, func(ctx context.Context) {
+		ms.draftRepo.Delete(ctx, draftID)
+	})
 
 	return message, nil
 }
@@ -1386,6 +1626,10 @@ func (ms *MessageService) SendDraft(ctx context.Context, userID, draftID string)
 // =============================================================================
 
 func (ms *MessageService) ExportCircleMessages(ctx context.Context, userID, circleID string, req models.ExportMessagesRequest) (*models.MessageExport, error) {
+	if validationErrors := ms.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
 	// Check access to circle
 	isMember, err := ms.circleRepo.IsMember(ctx, circleID, userID)
 	if err != nil || !isMember {
@@ -1398,77 +1642,142 @@ func (ms *MessageService) ExportCircleMessages(ctx context.Context, userID, circ
 	export := models.MessageExport{
 		UserID:       userObjectID,
 		CircleID:     circleObjectID,
+		Type:         models.ExportTypeMessages,
 		Format:       req.Format,
-		Status:       "processing",
-		Progress:     0,
 		DateRange:    req.DateRange,
 		IncludeMedia: req.IncludeMedia,
 		ExpiresAt:    time.Now().Add(7 * 24 * time.Hour), // 7 days
-		CreatedAt:    time.Now(),
-		UpdatedAt:    time.Now(),
 	}
 
-	err = ms.exportRepo.Create(ctx, &export)
-	if err != nil {
+	if err := ms.startMessageExport(ctx, &export, circleID, req); err != nil {
 		return nil, err
 	}
 
-	// Start export process in background
-	go ms.processExport(export.ID.Hex())
-
 	return &export, nil
 }
 
-func (ms *MessageService) GetExportStatus(ctx context.Context, userID, exportID string) (*models.ExportStatusResponse, error) {
-	export, err := ms.exportRepo.GetExport(ctx, exportID)
-	if err != nil {
+// ExportSearchResults exports the circle's messages that match a search,
+// leaving out senders blocked either way like the search itself does
+func (ms *MessageService) ExportSearchResults(ctx context.Context, userID, circleID string, req models.ExportSearchResultsRequest) (*models.MessageExport, error) {
+	if validationErrors := ms.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	search := req.SearchRequest()
+	if err := search.Validate(); err != nil {
 		return nil, err
 	}
+	if search.SenderID != "" && !primitive.IsValidObjectID(search.SenderID) {
+		return nil, errors.New("invalid sender ID")
+	}
 
-	if export.UserID.Hex() != userID {
+	// Check access to circle
+	isMember, err := ms.circleRepo.IsMember(ctx, circleID, userID)
+	if err != nil || !isMember {
 		return nil, errors.New("access denied")
 	}
 
-	response := &models.ExportStatusResponse{
-		ExportID:     exportID,
-		Status:       export.Status,
-		Progress:     export.Progress,
-		FileURL:      export.FileURL,
-		FileSize:     export.FileSize,
-		MessageCount: export.MessageCount,
-		CreatedAt:    export.CreatedAt,
-		ExpiresAt:    export.ExpiresAt,
+	search.ExcludeSenderIDs = mapKeys(ms.getBlockedUserIDs(ctx, userID))
+
+	userObjectID, _ := primitive.ObjectIDFromHex(userID)
+	circleObjectID, _ := primitive.ObjectIDFromHex(circleID)
+	criteria := req.MessageSearchCriteria
+
+	exportReq := models.ExportMessagesRequest{
+		Format:       req.Format,
+		DateRange:    searchDateRange(search),
+		IncludeMedia: req.IncludeMedia,
+		Search:       &search,
+	}
+
+	export := models.MessageExport{
+		UserID:         userObjectID,
+		CircleID:       circleObjectID,
+		Type:           models.ExportTypeMessages,
+		Format:         exportReq.Format,
+		DateRange:      exportReq.DateRange,
+		IncludeMedia:   exportReq.IncludeMedia,
+		SearchCriteria: &criteria,
+		ExpiresAt:      time.Now().Add(7 * 24 * time.Hour), // 7 days
 	}
 
-	return response, nil
+	if err := ms.startMessageExport(ctx, &export, circleID, exportReq); err != nil {
+		return nil, err
+	}
+
+	return &export, nil
 }
 
-func (ms *MessageService) DownloadMessageExport(ctx context.Context, userID, exportID string) (*models.ExportDownload, error) {
-	export, err := ms.exportRepo.GetByID(ctx, exportID)
-	if err != nil {
-		return nil, err
+// startMessageExport runs the export in the background. PDF exports may
+// span several files.
+func (ms *MessageService) startMessageExport(ctx context.Context, export *models.MessageExport, circleID string, req models.ExportMessagesRequest) error {
+	if req.Format == "pdf" {
+		return ms.exportService.StartPartedExport(ctx, export, func(jobCtx context.Context, nextPart func() (io.Writer, error), progress func(int)) (int, error) {
+			return ms.writeMessagePDFExport(jobCtx, nextPart, circleID, req, progress)
+		})
 	}
+	return ms.exportService.StartExport(ctx, export, func(jobCtx context.Context, w io.Writer, progress func(int)) (int, error) {
+		return ms.writeMessageExport(jobCtx, w, circleID, req, progress)
+	})
+}
 
-	if export.UserID.Hex() != userID {
-		return nil, errors.New("access denied")
+// messageExportBatch returns the next batch of messages to export, oldest
+// first: the search's matches if the export has one, otherwise every
+// message in the date range
+func (ms *MessageService) messageExportBatch(ctx context.Context, circleID string, req models.ExportMessagesRequest, skip, limit int) ([]models.Message, int64, error) {
+	if req.Search != nil {
+		return ms.searchService.MatchingMessages(ctx, *req.Search, []string{circleID}, skip, limit)
 	}
+	return ms.messageRepo.GetCircleMessagesInRange(ctx, circleID, req.DateRange, skip, limit)
+}
 
-	if export.Status != "completed" {
-		return nil, errors.New("export not ready")
+// searchDateRange is the search's dates as an export range. Both ends are
+// inclusive.
+func searchDateRange(search models.SearchMessagesRequest) models.ExportDateRange {
+	var dateRange models.ExportDateRange
+	if from, err := time.Parse("2006-01-02", search.DateFrom); err == nil {
+		dateRange.From = &from
 	}
+	if to, err := time.Parse("2006-01-02", search.DateTo); err == nil {
+		to = to.Add(24*time.Hour - time.Nanosecond)
+		dateRange.To = &to
+	}
+	return dateRange
+}
+
+func (ms *MessageService) GetExportStatus(ctx context.Context, userID, exportID string) (*models.ExportStatusResponse, error) {
+	return ms.exportService.GetExportStatus(ctx, userID, exportID)
+}
+
+// DownloadMessageExportManifest returns the manifest listing a completed
+// export's files and record count
+func (ms *MessageService) DownloadMessageExportManifest(ctx context.Context, userID, exportID string) (*models.ExportDownload, error) {
+	return ms.exportService.DownloadExportManifest(ctx, userID, exportID)
+}
 
-	// Download file from storage
-	data, err := ms.mediaService.DownloadFile(ctx, export.FileURL)
+// DownloadMessageExport returns one file of a completed export. Parts are
+// numbered from 1; only PDF exports have more than one.
+func (ms *MessageService) DownloadMessageExport(ctx context.Context, userID, exportID string, part int) (*models.ExportDownload, error) {
+	export, data, err := ms.exportService.ReadExportPart(ctx, userID, exportID, part)
 	if err != nil {
 		return nil, err
 	}
 
 	filename := fmt.Sprintf("messages_export_%s.%s", exportID, export.Format)
+	if part > 1 {
+		filename = fmt.Sprintf("messages_export_%s_part%d.%s", exportID, part, export.Format)
+	}
+
 	contentType := "application/octet-stream"
-	if export.Format == "json" {
+	switch export.Format {
+	case "json":
 		contentType = "application/json"
-	} else if export.Format == "csv" {
+	case "csv":
 		contentType = "text/csv"
+	case "txt":
+		contentType = "text/plain; charset=utf-8"
+	case "pdf":
+		contentType = "application/pdf"
 	}
 
 	return &models.ExportDownload{
@@ -1486,20 +1795,43 @@ func (ms *MessageService) ImportMessages(ctx context.Context, userID string, req
 	}
 
 	// Validate file format
-	if !ms.isValidImportFormat(req.Header.Header.Get("Content-Type")) {
+	format := importFormat(req.Header.Header.Get("Content-Type"), req.Header.Filename)
+	if format == "" {
 		return nil, errors.New("invalid file format")
 	}
 
+	// The file is closed once the request ends, so it is read up front
+	data, err := io.ReadAll(io.LimitReader(req.File, maxMessageImportSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxMessageImportSize {
+		return nil, errors.New("file too large")
+	}
+
+	// Files from other releases are upgraded, or rejected with the reason
+	export, err := decodeExport(data, format, models.ExportTypeMessages)
+	if err != nil {
+		return nil, err
+	}
+	records, err := export.RecordCount()
+	if err != nil {
+		return nil, err
+	}
+
 	// Create import job
 	job := &models.ImportJob{
-		UserID:    userID,
-		CircleID:  req.CircleID,
-		Status:    "processing",
-		CreatedAt: time.Now(),
+		UserID:        userID,
+		CircleID:      req.CircleID,
+		Status:        "processing",
+		SchemaVersion: export.SourceVersion,
+		Migrated:      export.Migrated,
+		Records:       records,
+		CreatedAt:     time.Now(),
 	}
 
 	// Process import in background
-	go ms.processImport(job, req.File)
+	go ms.processImport(job, export)
 
 	return job, nil
 }
@@ -1589,17 +1921,23 @@ func (ms *MessageService) AdminDeleteMessage(ctx context.Context, userID, messag
 	}
 
 	// Record admin action
-	go ms.recordAdminAction(userID, "delete_message", messageID, req.Reason)
+	Background.Go(ctx, func(context.Context) {
+		ms.recordAdminAction(userID, "delete_message", messageID, req.Reason)
+	})
 
 	// Delete message
 	err = ms.messageRepo.SoftDelete(ctx, messageID)
 	if err != nil {
 		return err
 	}
+	ms.removeMessageFromAlbums(ctx, messageID)
+	ms.dropCircleOverview(ctx, message.CircleID.Hex())
 
 	// Notify if requested
 	if req.Notify {
-		go ms.notifyMessageDeleted(message.SenderID.Hex(), messageID, req.Reason)
+		Background.Go(ctx, func(context.Context) {
+			ms.notifyMessageDeleted(message.SenderID.Hex(), messageID, req.Reason)
+		})
 	}
 
 	return nil
@@ -1612,12 +1950,7 @@ func (ms *MessageService) GetMessageReports(ctx context.Context, userID string,
 		return nil, errors.New("access denied")
 	}
 
-	if req.Page < 1 {
-		req.Page = 1
-	}
-	if req.PageSize < 1 || req.PageSize > 100 {
-		req.PageSize = 20
-	}
+	req.Page, req.PageSize = models.NormalizePage(req.Page, req.PageSize)
 
 	reports, total, err := ms.reportRepo.GetReports(ctx, req)
 	if err != nil {
@@ -1682,6 +2015,9 @@ func (ms *MessageService) HandleMessageReport(ctx context.Context, userID, repor
 	if err != nil {
 		return nil, err
 	}
+	if req.Action == "hide" || req.Action == "delete" {
+		ms.removeMessageFromAlbums(ctx, report.MessageID.Hex())
+	}
 
 	return &models.ReportHandleResult{
 		ReportID:    reportID,
@@ -1902,6 +2238,188 @@ func (ms *MessageService) GetCircleMessageStats(ctx context.Context, userID stri
 	return ms.GetMessageStats(ctx, userID, statsReq)
 }
 
+const (
+	reactionStatsCacheTTL = 1 * time.Hour
+	reactionStatsTopN     = 5
+
+	// Extra candidates are cached so there are enough left after leaving
+	// out users blocked by the requester
+	reactionStatsCandidates = 10
+
+	reactionPreviewLength = 80
+)
+
+// GetCircleReactionStats returns the circle's most used emojis, top
+// reactors and most-reacted messages. Stats are cached per circle for an
+// hour, and users blocked either way are left out for each requester.
+func (ms *MessageService) GetCircleReactionStats(ctx context.Context, userID, circleID, period string) (*models.ReactionStats, error) {
+	isMember, err := ms.circleRepo.IsMember(ctx, circleID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !isMember {
+		return nil, errors.New("access denied")
+	}
+
+	stats, err := ms.cachedReactionStats(ctx, circleID, period)
+	if err != nil {
+		return nil, err
+	}
+
+	blocked, err := ms.blockRepo.GetRelatedUserIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	reactors := make([]models.TopReactor, 0, reactionStatsTopN)
+	for _, reactor := range stats.TopReactors {
+		if !blocked[reactor.User.ID] && len(reactors) < reactionStatsTopN {
+			reactors = append(reactors, reactor)
+		}
+	}
+	stats.TopReactors = reactors
+
+	messages := make([]models.TopReactedMessage, 0, reactionStatsTopN)
+	for _, message := range stats.TopMessages {
+		if !blocked[message.Sender.ID] && len(messages) < reactionStatsTopN {
+			messages = append(messages, message)
+		}
+	}
+	stats.TopMessages = messages
+
+	return stats, nil
+}
+
+func (ms *MessageService) cachedReactionStats(ctx context.Context, circleID, period string) (*models.ReactionStats, error) {
+	var since time.Time
+	now := time.Now()
+	switch period {
+	case "1d":
+		since = now.AddDate(0, 0, -1)
+	case "7d":
+		since = now.AddDate(0, 0, -7)
+	case "30d":
+		since = now.AddDate(0, 0, -30)
+	case "90d":
+		since = now.AddDate(0, 0, -90)
+	default:
+		return nil, errors.New("invalid period")
+	}
+
+	cache, _ := ms.redisClient.(*redis.Client)
+	cacheKey := fmt.Sprintf("reactions:stats:%s:%s", circleID, period)
+	if cache != nil {
+		if cached, err := cache.Get(ctx, cacheKey).Bytes(); err == nil {
+			var stats models.ReactionStats
+			if err := json.Unmarshal(cached, &stats); err == nil {
+				return &stats, nil
+			}
+		}
+	}
+
+	counts, err := ms.messageRepo.GetReactionStats(ctx, circleID, since, reactionStatsCandidates)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &models.ReactionStats{
+		CircleID:       circleID,
+		Period:         period,
+		StartDate:      since,
+		TotalReactions: counts.Total,
+		Emojis:         counts.Emojis,
+		TopReactors:    []models.TopReactor{},
+		TopMessages:    []models.TopReactedMessage{},
+		Generated:      now,
+	}
+	if stats.Emojis == nil {
+		stats.Emojis = []models.EmojiCount{}
+	}
+
+	messageIDs := make([]primitive.ObjectID, len(counts.Messages))
+	for i, count := range counts.Messages {
+		messageIDs[i] = count.ID
+	}
+	messages, err := ms.messageRepo.GetByIDs(ctx, messageIDs)
+	if err != nil {
+		return nil, err
+	}
+	messagesByID := make(map[primitive.ObjectID]models.Message, len(messages))
+	for _, message := range messages {
+		messagesByID[message.ID] = message
+	}
+
+	userIDs := make([]string, 0, len(counts.Reactors)+len(messages))
+	for _, count := range counts.Reactors {
+		userIDs = append(userIDs, count.ID.Hex())
+	}
+	for _, message := range messages {
+		userIDs = append(userIDs, message.SenderID.Hex())
+	}
+	users, err := ms.userRepo.GetUsersByIDs(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	usersByID := make(map[string]models.UserInfo, len(users))
+	for _, user := range users {
+		usersByID[user.ID.Hex()] = models.UserInfo{
+			ID:        user.ID.Hex(),
+			FirstName: user.FirstName,
+			LastName:  user.LastName,
+			Avatar:    user.ProfilePicture,
+		}
+	}
+
+	for _, count := range counts.Reactors {
+		user, ok := usersByID[count.ID.Hex()]
+		if !ok {
+			continue
+		}
+		stats.TopReactors = append(stats.TopReactors, models.TopReactor{User: user, Count: count.Count})
+	}
+
+	for _, count := range counts.Messages {
+		message, ok := messagesByID[count.ID]
+		if !ok {
+			continue
+		}
+		sender, ok := usersByID[message.SenderID.Hex()]
+		if !ok {
+			sender = models.UserInfo{ID: message.SenderID.Hex()}
+		}
+		stats.TopMessages = append(stats.TopMessages, models.TopReactedMessage{
+			MessageID:     message.ID.Hex(),
+			Sender:        sender,
+			Type:          message.Type,
+			Preview:       reactionMessagePreview(message),
+			ReactionCount: count.Count,
+			CreatedAt:     message.CreatedAt,
+		})
+	}
+
+	if cache != nil {
+		if encoded, err := json.Marshal(stats); err == nil {
+			cache.Set(ctx, cacheKey, encoded, reactionStatsCacheTTL)
+		}
+	}
+
+	return stats, nil
+}
+
+// reactionMessagePreview is the start of a text message, or the kind of
+// message for anything else
+func reactionMessagePreview(message models.Message) string {
+	if message.Type != "text" || message.Content == "" {
+		return "[" + message.Type + "]"
+	}
+
+	runes := []rune(strings.TrimSpace(message.Content))
+	if len(runes) <= reactionPreviewLength {
+		return string(runes)
+	}
+	return strings.TrimSpace(string(runes[:reactionPreviewLength-1])) + "…"
+}
+
 func (ms *MessageService) GetMessageActivity(ctx context.Context, userID string, req models.GetActivityRequest) (*models.ActivityResponse, error) {
 	// Get user's accessible circles
 	circles, err := ms.circleRepo.GetUserCircles(ctx, userID)
@@ -2073,12 +2591,7 @@ func (ms *MessageService) GetPopularMessages(ctx context.Context, userID string,
 // =============================================================================
 
 func (ms *MessageService) GetAutomationRules(ctx context.Context, userID string, req models.GetAutomationRulesRequest) (*models.AutomationRulesResponse, error) {
-	if req.Page < 1 {
-		req.Page = 1
-	}
-	if req.PageSize < 1 || req.PageSize > 100 {
-		req.PageSize = 20
-	}
+	req.Page, req.PageSize = models.NormalizePage(req.Page, req.PageSize)
 
 	rules, total, err := ms.automationRepo.GetUserRules(ctx, userID, req)
 	if err != nil {
@@ -2097,7 +2610,7 @@ func (ms *MessageService) GetAutomationRules(ctx context.Context, userID string,
 
 func (ms *MessageService) CreateAutomationRule(ctx context.Context, userID string, req models.CreateAutomationRuleRequest) (*models.AutomationRule, error) {
 	if validationErrors := ms.validator.ValidateStruct(req); len(validationErrors) > 0 {
-		return nil, errors.New("validation failed")
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
 	}
 
 	// Check rule limit
@@ -2130,6 +2643,8 @@ func (ms *MessageService) CreateAutomationRule(ctx context.Context, userID strin
 		CreatedAt:  time.Now(),
 		UpdatedAt:  time.Now(),
 	}
+	rule.Priority = req.Priority
+	rule.StopOnMatch = req.StopOnMatch
 
 	if req.CircleID != "" {
 		circleObjectID, _ := primitive.ObjectIDFromHex(req.CircleID)
@@ -2167,6 +2682,15 @@ func (ms *MessageService) UpdateAutomationRule(ctx context.Context, userID, rule
 	if req.IsActive != nil {
 		update["isActive"] = *req.IsActive
 	}
+	if req.Priority != nil {
+		if *req.Priority < models.MinAutomationRulePriority || *req.Priority > models.MaxAutomationRulePriority {
+			return nil, errors.New("validation failed")
+		}
+		update["priority"] = *req.Priority
+	}
+	if req.StopOnMatch != nil {
+		update["stopOnMatch"] = *req.StopOnMatch
+	}
 
 	err = ms.automationRepo.Update(ctx, ruleID, update)
 	if err != nil {
@@ -2226,25 +2750,92 @@ func (ms *MessageService) TestAutomationRule(ctx context.Context, userID, ruleID
 // HELPER FUNCTIONS
 // =============================================================================
 
-func (ms *MessageService) broadcastMessage(senderID, circleID string, message models.Message) {
-	wsMessage := models.WSMessage{
-		Type: models.WSTypeMessage,
-		Data: models.WSMessageData{
-			MessageID: message.ID.Hex(),
-			CircleID:  message.CircleID.Hex(),
-			SenderID:  message.SenderID.Hex(),
-			Type:      message.Type,
-			Content:   message.Content,
-			Media:     &message.Media,
-			Timestamp: message.CreatedAt,
-		},
-		Timestamp: time.Now(),
+// publishMessage sends a new message to the circle. With the outbox, the
+// broadcast is stored before the send returns, so a crash doesn't lose it.
+// Members who blocked the sender get the collapsed placeholder, as they do
+// when reading the conversation.
+func (ms *MessageService) publishMessage(ctx context.Context, senderID, circleID string, message models.Message) {
+	broadcasts := messageBroadcasts(circleID, message, ms.getBlockerIDs(ctx, senderID))
+
+	if ms.outbox == nil {
+		Background.Go(ctx, func(context.Context) {
+			ms.broadcastMessage(broadcasts)
+		})
+		return
 	}
 
-	ms.websocketHub.BroadcastMessage(circleID, wsMessage)
+	for i, broadcast := range broadcasts {
+		key := "message:" + message.ID.Hex()
+		if len(broadcast.IncludeUserIDs) > 0 {
+			key += ":collapsed"
+		}
+
+		if err := ms.outbox.EnqueueFilteredBroadcast(ctx, key, broadcast); err != nil {
+			logrus.Errorf("Failed to enqueue message %s, broadcasting directly: %v", message.ID.Hex(), err)
+			rest := broadcasts[i:]
+			Background.Go(ctx, func(context.Context) {
+				ms.broadcastMessage(rest)
+			})
+			return
+		}
+	}
+}
+
+func (ms *MessageService) broadcastMessage(broadcasts []models.OutboxCircleBroadcast) {
+	for _, broadcast := range broadcasts {
+		ms.websocketHub.BroadcastFiltered(broadcast.CircleID, broadcast.Message, websocket.MessageFilter{
+			ExcludeUsers: broadcast.ExcludeUserIDs,
+			IncludeUsers: broadcast.IncludeUserIDs,
+		})
+	}
+}
+
+// messageBroadcasts returns the broadcasts of a new message: the message for
+// the circle, and the collapsed placeholder for the members who blocked its
+// sender
+func messageBroadcasts(circleID string, message models.Message, blockerIDs []string) []models.OutboxCircleBroadcast {
+	broadcasts := []models.OutboxCircleBroadcast{{
+		CircleID:       circleID,
+		Message:        newMessageBroadcast(message),
+		ExcludeUserIDs: blockerIDs,
+	}}
+	if len(blockerIDs) == 0 {
+		return broadcasts
+	}
+
+	message.Collapse()
+	return append(broadcasts, models.OutboxCircleBroadcast{
+		CircleID:       circleID,
+		Message:        newMessageBroadcast(message),
+		IncludeUserIDs: blockerIDs,
+	})
+}
+
+func newMessageBroadcast(message models.Message) models.WSMessage {
+	data := models.WSMessageData{
+		MessageID:   message.ID.Hex(),
+		CircleID:    message.CircleID.Hex(),
+		SenderID:    message.SenderID.Hex(),
+		Type:        message.Type,
+		Content:     message.Content,
+		ClientID:    message.ClientID,
+		Timestamp:   message.CreatedAt,
+		IsCollapsed: message.IsCollapsed,
+	}
+	if !message.IsCollapsed {
+		data.Media = &message.Media
+	}
+
+	return models.WSMessage{
+		Type:      models.WSTypeMessage,
+		Data:      data,
+		Timestamp: time.Now(),
+	}
 }
 
-func (ms *MessageService) broadcastMessageEdit(senderID, circleID, messageID, newContent string) {
+// broadcastMessageEdit sends the new content to the circle, except to the
+// members who blocked the sender, who keep the placeholder
+func (ms *MessageService) broadcastMessageEdit(senderID, circleID, messageID, newContent string, blockerIDs []string) {
 	wsMessage := models.WSMessage{
 		Type: models.WSTypeMessageEdit,
 		Data: models.WSMessageEditData{
@@ -2257,7 +2848,9 @@ func (ms *MessageService) broadcastMessageEdit(senderID, circleID, messageID, ne
 		Timestamp: time.Now(),
 	}
 
-	ms.websocketHub.BroadcastMessage(circleID, wsMessage)
+	ms.websocketHub.BroadcastFiltered(circleID, wsMessage, websocket.MessageFilter{
+		ExcludeUsers: blockerIDs,
+	})
 }
 
 func (ms *MessageService) broadcastMessageDeletion(senderID, circleID, messageID string) {
@@ -2276,6 +2869,10 @@ func (ms *MessageService) broadcastMessageDeletion(senderID, circleID, messageID
 }
 
 func (ms *MessageService) broadcastReaction(userID, circleID, messageID, emoji, action string) {
+	ms.broadcastReactionChange(userID, circleID, messageID, emoji, action, nil)
+}
+
+func (ms *MessageService) broadcastReactionChange(userID, circleID, messageID, emoji, action string, count *int) {
 	wsMessage := models.WSMessage{
 		Type: models.WSTypeReaction,
 		Data: models.WSReactionData{
@@ -2284,6 +2881,7 @@ func (ms *MessageService) broadcastReaction(userID, circleID, messageID, emoji,
 			UserID:    userID,
 			Emoji:     emoji,
 			Action:    action,
+			Count:     count,
 			Timestamp: time.Now(),
 		},
 		Timestamp: time.Now(),
@@ -2338,6 +2936,61 @@ func (ms *MessageService) broadcastBulkReadReceipts(userID string, messageIDs []
 	}
 }
 
+// getBlockedUserIDs returns the users whose messages are collapsed for the
+// viewer. Lookup failures are logged and treated as no blocks.
+func (ms *MessageService) getBlockedUserIDs(ctx context.Context, userID string) map[string]bool {
+	blockedIDs, err := ms.blockRepo.GetBlockedUserIDs(ctx, userID)
+	if err != nil {
+		logrus.Warnf("Failed to get blocked users for %s: %v", userID, err)
+		return map[string]bool{}
+	}
+	return blockedIDs
+}
+
+// getBlockerIDs returns the users who blocked the sender, in order
+func (ms *MessageService) getBlockerIDs(ctx context.Context, senderID string) []string {
+	blockerIDs, err := ms.blockRepo.GetBlockerIDs(ctx, senderID)
+	if err != nil {
+		logrus.Warnf("Failed to get users blocking %s: %v", senderID, err)
+		return nil
+	}
+
+	ids := make([]string, 0, len(blockerIDs))
+	for id := range blockerIDs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (ms *MessageService) collapseBlockedMessages(ctx context.Context, userID string, messages []models.Message) {
+	blockedIDs := ms.getBlockedUserIDs(ctx, userID)
+	if len(blockedIDs) == 0 {
+		return
+	}
+
+	for i := range messages {
+		if blockedIDs[messages[i].SenderID.Hex()] {
+			messages[i].Collapse()
+		}
+	}
+}
+
+// dropCircleOverview drops the circle's cached overview after its latest
+// message may have changed
+func (ms *MessageService) dropCircleOverview(ctx context.Context, circleID string) {
+	cache, _ := ms.redisClient.(*redis.Client)
+	dropCircleOverview(ctx, cache, circleID)
+}
+
+func mapKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
 func (ms *MessageService) aggregateReactions(reactions []models.MessageReaction) map[string]models.ReactionSummary {
 	aggregated := make(map[string]models.ReactionSummary)
 
@@ -2358,6 +3011,70 @@ func (ms *MessageService) aggregateReactions(reactions []models.MessageReaction)
 	return aggregated
 }
 
+// CreateMediaUploadIntent returns a pre-signed URL the client uploads the
+// file to, to be finished with CompleteMediaUpload
+func (ms *MessageService) CreateMediaUploadIntent(ctx context.Context, userID string, req models.MediaUploadIntentRequest) (*models.MediaUploadIntentResponse, error) {
+	if validationErrors := ms.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+	if !ms.uploads.Enabled() {
+		return nil, errors.New("direct uploads not available")
+	}
+	if !ms.isValidMediaType(req.ContentType) {
+		return nil, errors.New("invalid file type")
+	}
+
+	return ms.uploads.CreateIntent(ctx, userID, req)
+}
+
+// CompleteMediaUpload stores a direct upload as media, like UploadMedia
+func (ms *MessageService) CompleteMediaUpload(ctx context.Context, userID string, req models.MediaUploadCompleteRequest) (*models.MessageMedia, error) {
+	if validationErrors := ms.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+	if !ms.uploads.Enabled() {
+		return nil, errors.New("direct uploads not available")
+	}
+
+	media, intent, err := ms.uploads.Complete(ctx, userID, req.UploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	messageMedia := &models.MessageMedia{
+		URL:          media.URL,
+		Type:         intent.MediaType,
+		Size:         media.Size,
+		Filename:     intent.Filename,
+		MimeType:     intent.ContentType,
+		ThumbnailURL: media.ThumbnailURL,
+		Duration:     media.Duration,
+		Dimensions:   media.Dimensions,
+		ContentHash:  media.ContentHash,
+		UploadedBy:   userID,
+		UploadedAt:   time.Now(),
+	}
+
+	messageMediaExtended := &models.MessageMediaExtended{
+		MessageMedia: *messageMedia,
+	}
+
+	if err := ms.mediaRepo.Create(ctx, messageMediaExtended); err != nil {
+		ms.uploads.Release(ctx, intent)
+		if releaseErr := ms.mediaService.ReleaseFile(ctx, media.URL); releaseErr != nil {
+			logrus.Errorf("Failed to release media file %s: %v", media.URL, releaseErr)
+		}
+		return nil, err
+	}
+
+	if err := ms.uploads.MarkCompleted(ctx, intent, messageMediaExtended.ID); err != nil {
+		logrus.Errorf("Failed to complete upload %s: %v", intent.ID.Hex(), err)
+	}
+
+	messageMedia.ID = messageMediaExtended.ID
+	return messageMedia, nil
+}
+
 func (ms *MessageService) isValidMediaType(contentType string) bool {
 	validTypes := []string{
 		"image/jpeg", "image/png", "image/gif", "image/webp",
@@ -2513,9 +3230,14 @@ func (ms *MessageService) ProcessAutomationRules(ctx context.Context, message *m
 		return
 	}
 
+	// Rules come highest priority first, and run one after another so a
+	// later rule sees what an earlier one did. Stopping only skips the
+	// rules of the same owner, so one member's rules can't silence
+	// another's.
+	stopped := make(map[primitive.ObjectID]bool)
 	for _, rule := range rules {
 		// Skip if rule belongs to message sender (to avoid self-triggering)
-		if rule.UserID == message.SenderID {
+		if rule.UserID == message.SenderID || stopped[rule.UserID] {
 			continue
 		}
 
@@ -2528,10 +3250,16 @@ func (ms *MessageService) ProcessAutomationRules(ctx context.Context, message *m
 
 		if triggered {
 			// Execute rule actions
-			go ms.executeRuleActions(rule, message)
+			ms.executeRuleActions(rule, message)
 
 			// Update rule statistics
-			go ms.automationRepo.IncrementTriggerCount(context.Background(), rule.ID.Hex())
+			Background.Go(ctx, func(ctx context.Context) {
+				ms.automationRepo.IncrementTriggerCount(ctx, rule.ID.Hex())
+			})
+
+			if rule.StopOnMatch {
+				stopped[rule.UserID] = true
+			}
 		}
 	}
 }
@@ -2616,8 +3344,10 @@ func (ms *MessageService) executeReplyAction(action models.RuleAction, rule mode
 	replyContent := action.Config["content"].(string)
 
 	// Replace placeholders in reply content
-	replyContent = strings.ReplaceAll(replyContent, "{sender}", triggerMessage.SenderID.Hex())
-	replyContent = strings.ReplaceAll(replyContent, "{time}", time.Now().Format("15:04"))
+	replyContent = utils.RenderNotificationTemplate(replyContent, map[string]string{
+		"sender": triggerMessage.SenderID.Hex(),
+		"time":   time.Now().Format("15:04"),
+	})
 
 	// Send reply message
 	sendReq := models.SendMessageRequest{
@@ -2696,25 +3426,107 @@ func (ms *MessageService) simulateActions(actions []models.RuleAction, testMessa
 
 // Additional helper functions for processing
 
-func (ms *MessageService) processExport(exportID string) {
-	// Implementation for background export processing
-	logrus.Infof("Starting export process for ID: %s", exportID)
-	// This would handle the actual export logic
-}
+const messageExportBatchSize = 500
 
-func (ms *MessageService) processImport(job *models.ImportJob, file multipart.File) {
-	// Implementation for background import processing
-	logrus.Infof("Starting import process for job: %s", job.ID)
-}
+// Largest message export file accepted for import
+const maxMessageImportSize = 50 << 20
 
-func (ms *MessageService) isValidImportFormat(contentType string) bool {
-	validTypes := []string{"application/json", "text/csv", "text/plain"}
-	for _, validType := range validTypes {
-		if contentType == validType {
-			return true
+// writeMessageExport writes circle messages in batches, checking for
+// cancellation between batches
+func (ms *MessageService) writeMessageExport(ctx context.Context, w io.Writer, circleID string, req models.ExportMessagesRequest, progress func(int)) (int, error) {
+	var csvWriter *csv.Writer
+	switch req.Format {
+	case "json":
+		if err := writeJSONExportStart(ctx, w, models.ExportTypeMessages); err != nil {
+			return 0, err
+		}
+	case "csv":
+		if err := writeExportStamp(ctx, w, models.ExportTypeMessages); err != nil {
+			return 0, err
+		}
+		csvWriter = csv.NewWriter(w)
+		if err := csvWriter.Write([]string{"id", "senderId", "type", "content", "createdAt"}); err != nil {
+			return 0, err
+		}
+	default:
+		if err := writeExportStamp(ctx, w, models.ExportTypeMessages); err != nil {
+			return 0, err
 		}
 	}
-	return false
+
+	written := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+
+		messages, total, err := ms.messageExportBatch(ctx, circleID, req, written, messageExportBatchSize)
+		if err != nil {
+			return written, err
+		}
+		if len(messages) == 0 {
+			break
+		}
+
+		for _, message := range messages {
+			switch req.Format {
+			case "json":
+				data, err := json.Marshal(message)
+				if err != nil {
+					return written, err
+				}
+				if written > 0 {
+					if _, err := io.WriteString(w, ","); err != nil {
+						return written, err
+					}
+				}
+				if _, err := w.Write(data); err != nil {
+					return written, err
+				}
+			case "csv":
+				err = csvWriter.Write([]string{
+					message.ID.Hex(),
+					message.SenderID.Hex(),
+					message.Type,
+					message.Content,
+					message.CreatedAt.Format(time.RFC3339),
+				})
+			default:
+				_, err = fmt.Fprintf(w, "[%s] %s: %s\n", message.CreatedAt.Format(time.RFC3339), message.SenderID.Hex(), message.Content)
+			}
+			if err != nil {
+				return written, err
+			}
+			written++
+		}
+
+		if csvWriter != nil {
+			csvWriter.Flush()
+			if err := csvWriter.Error(); err != nil {
+				return written, err
+			}
+		}
+
+		if total > 0 {
+			progress(int(int64(written) * 99 / total))
+		}
+		if len(messages) < messageExportBatchSize {
+			break
+		}
+	}
+
+	if req.Format == "json" {
+		if err := writeJSONExportEnd(w); err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+func (ms *MessageService) processImport(job *models.ImportJob, export *decodedExport) {
+	// Implementation for background import processing
+	logrus.Infof("Starting import process for job: %s (%d records, schema version %d)", job.ID, job.Records, export.SourceVersion)
 }
 
 func (ms *MessageService) calculateSeverity(reason string) string {
@@ -0,0 +1,599 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"ftrack/models"
+	"ftrack/testharness"
+	"ftrack/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func newTestMessageService(env *testharness.Env) *MessageService {
+	repos := env.Repos
+	ms := NewMessageService(
+		repos.Message, repos.Circle, repos.User, repos.Media, repos.Template, repos.Draft,
+		repos.Schedule, repos.Report, repos.Automation, repos.Export, repos.Block,
+		env.Hub, nil, nil, nil, nil,
+	)
+	ms.ConfigureMessageNotifications(env.Notifier, 0)
+	return ms
+}
+
+func TestMessageBroadcasts(t *testing.T) {
+	circleID := primitive.NewObjectID().Hex()
+	message := models.Message{
+		ID:       primitive.NewObjectID(),
+		SenderID: primitive.NewObjectID(),
+		Type:     "photo",
+		Content:  "look at this",
+		Media:    models.MessageMedia{URL: "https://cdn.example.com/photo.jpg"},
+	}
+
+	t.Run("nobody blocks the sender", func(t *testing.T) {
+		broadcasts := messageBroadcasts(circleID, message, nil)
+		if len(broadcasts) != 1 {
+			t.Fatalf("%d broadcasts, want 1", len(broadcasts))
+		}
+		if len(broadcasts[0].ExcludeUserIDs) != 0 || len(broadcasts[0].IncludeUserIDs) != 0 {
+			t.Errorf("broadcast filtered: %+v", broadcasts[0])
+		}
+	})
+
+	t.Run("blockers get the placeholder", func(t *testing.T) {
+		blockerIDs := []string{primitive.NewObjectID().Hex()}
+		broadcasts := messageBroadcasts(circleID, message, blockerIDs)
+		if len(broadcasts) != 2 {
+			t.Fatalf("%d broadcasts, want 2", len(broadcasts))
+		}
+
+		full, collapsed := broadcasts[0], broadcasts[1]
+		if len(full.ExcludeUserIDs) != 1 || full.ExcludeUserIDs[0] != blockerIDs[0] || len(full.IncludeUserIDs) != 0 {
+			t.Errorf("full message filter = exclude %v include %v, want the blocker excluded", full.ExcludeUserIDs, full.IncludeUserIDs)
+		}
+		if data := full.Message.Data.(models.WSMessageData); data.Content != message.Content || data.Media == nil || data.IsCollapsed {
+			t.Errorf("full message data = %+v", data)
+		}
+
+		if len(collapsed.IncludeUserIDs) != 1 || collapsed.IncludeUserIDs[0] != blockerIDs[0] || len(collapsed.ExcludeUserIDs) != 0 {
+			t.Errorf("placeholder filter = exclude %v include %v, want only the blocker", collapsed.ExcludeUserIDs, collapsed.IncludeUserIDs)
+		}
+		data := collapsed.Message.Data.(models.WSMessageData)
+		if data.Content != "" || data.Media != nil || !data.IsCollapsed {
+			t.Errorf("placeholder data = %+v, want content and media cleared", data)
+		}
+		if data.MessageID != message.ID.Hex() || data.SenderID != message.SenderID.Hex() {
+			t.Errorf("placeholder data = %+v, want the message and sender IDs", data)
+		}
+	})
+
+	if message.Content == "" {
+		t.Error("messageBroadcasts collapsed the caller's message")
+	}
+}
+
+func TestMessageServiceSend(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ms := newTestMessageService(env)
+	ctx := context.Background()
+
+	alice, bob, carol := env.Factory.User(), env.Factory.User(), env.Factory.User()
+	circle := env.Factory.Circle(alice, []*models.User{bob})
+
+	message, err := ms.SendMessage(ctx, alice.ID.Hex(), models.SendMessageRequest{
+		CircleID: circle.ID.Hex(),
+		Type:     "text",
+		Content:  "hello",
+	})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	stored, err := ms.GetMessage(ctx, bob.ID.Hex(), message.ID.Hex())
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	if stored.Content != "hello" || stored.SenderID != alice.ID {
+		t.Errorf("stored message = %+v", stored)
+	}
+
+	broadcast := env.Hub.WaitForBroadcasts(t, models.WSTypeMessage, 1)[0]
+	if broadcast.RoomID != circle.ID.Hex() {
+		t.Errorf("broadcast to room %s, want the circle's", broadcast.RoomID)
+	}
+	if data := broadcast.Message.Data.(models.WSMessageData); data.MessageID != message.ID.Hex() || data.Content != "hello" {
+		t.Errorf("broadcast data = %+v", data)
+	}
+
+	notification := env.Notifier.WaitForSent(t, models.NotificationTypeMessage, 1)[0]
+	if len(notification.Recipients) != 1 || notification.Recipients[0] != bob.ID.Hex() {
+		t.Errorf("notified %v, want only the other member", notification.Recipients)
+	}
+
+	_, err = ms.SendMessage(ctx, carol.ID.Hex(), models.SendMessageRequest{
+		CircleID: circle.ID.Hex(),
+		Type:     "text",
+		Content:  "let me in",
+	})
+	if err == nil || err.Error() != "access denied" {
+		t.Errorf("SendMessage by a non-member error = %v, want access denied", err)
+	}
+}
+
+func TestMessageServiceSendToBlocker(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ms := newTestMessageService(env)
+	ctx := context.Background()
+
+	alice, bob := env.Factory.User(), env.Factory.User()
+	circle := env.Factory.Circle(alice, []*models.User{bob})
+	if err := env.Repos.Block.Create(ctx, &models.BlockedUser{UserID: bob.ID, BlockedUserID: alice.ID}); err != nil {
+		t.Fatalf("blocking: %v", err)
+	}
+
+	if _, err := ms.SendMessage(ctx, alice.ID.Hex(), models.SendMessageRequest{
+		CircleID: circle.ID.Hex(),
+		Type:     "text",
+		Content:  "hello",
+	}); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	for _, broadcast := range env.Hub.WaitForBroadcasts(t, models.WSTypeMessage, 2) {
+		data := broadcast.Message.Data.(models.WSMessageData)
+		switch {
+		case data.IsCollapsed:
+			if len(broadcast.Filter.IncludeUsers) != 1 || broadcast.Filter.IncludeUsers[0] != bob.ID.Hex() || data.Content != "" {
+				t.Errorf("placeholder %+v sent with filter %+v, want it only to the blocker", data, broadcast.Filter)
+			}
+		default:
+			if len(broadcast.Filter.ExcludeUsers) != 1 || broadcast.Filter.ExcludeUsers[0] != bob.ID.Hex() {
+				t.Errorf("message sent with filter %+v, want the blocker excluded", broadcast.Filter)
+			}
+		}
+	}
+}
+
+func TestMessageServiceEdit(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ms := newTestMessageService(env)
+	ctx := context.Background()
+
+	alice, bob := env.Factory.User(), env.Factory.User()
+	circle := env.Factory.Circle(alice, []*models.User{bob})
+	message := env.Factory.Message(circle, alice, "helo")
+
+	if _, err := ms.UpdateMessage(ctx, bob.ID.Hex(), message.ID.Hex(), models.EditMessageRequest{Content: "mine now"}); err == nil || err.Error() != "access denied" {
+		t.Errorf("UpdateMessage by another member error = %v, want access denied", err)
+	}
+
+	edited, err := ms.UpdateMessage(ctx, alice.ID.Hex(), message.ID.Hex(), models.EditMessageRequest{Content: "hello"})
+	if err != nil {
+		t.Fatalf("UpdateMessage: %v", err)
+	}
+	if edited.Content != "hello" || !edited.IsEdited {
+		t.Errorf("edited message = %+v, want the new content marked edited", edited)
+	}
+
+	broadcast := env.Hub.WaitForBroadcasts(t, models.WSTypeMessageEdit, 1)[0]
+	if data := broadcast.Message.Data.(models.WSMessageEditData); data.NewContent != "hello" {
+		t.Errorf("edit broadcast data = %+v", data)
+	}
+}
+
+func TestMessageServiceDelete(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ms := newTestMessageService(env)
+	ctx := context.Background()
+
+	alice, bob := env.Factory.User(), env.Factory.User()
+	circle := env.Factory.Circle(alice, []*models.User{bob})
+	message := env.Factory.Message(circle, bob, "oops")
+
+	if err := ms.DeleteMessage(ctx, bob.ID.Hex(), message.ID.Hex()); err != nil {
+		t.Fatalf("DeleteMessage: %v", err)
+	}
+	if _, err := ms.GetMessage(ctx, alice.ID.Hex(), message.ID.Hex()); err == nil || err.Error() != "message not found" {
+		t.Errorf("GetMessage after delete error = %v, want message not found", err)
+	}
+
+	broadcast := env.Hub.WaitForBroadcasts(t, models.WSTypeMessageDelete, 1)[0]
+	if data := broadcast.Message.Data.(models.WSMessageDeleteData); data.MessageID != message.ID.Hex() {
+		t.Errorf("delete broadcast data = %+v", data)
+	}
+
+	if err := ms.DeleteMessage(ctx, bob.ID.Hex(), message.ID.Hex()); err == nil {
+		t.Error("deleting a deleted message succeeded")
+	}
+}
+
+func TestMessageServiceReactions(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ms := newTestMessageService(env)
+	ctx := context.Background()
+
+	alice, bob, carol := env.Factory.User(), env.Factory.User(), env.Factory.User()
+	circle := env.Factory.Circle(alice, []*models.User{bob})
+	message := env.Factory.Message(circle, alice, "hello")
+	messageID := message.ID.Hex()
+
+	if err := ms.AddReaction(ctx, bob.ID.Hex(), messageID, "👍"); err != nil {
+		t.Fatalf("AddReaction: %v", err)
+	}
+	if err := ms.AddReaction(ctx, carol.ID.Hex(), messageID, "👍"); err == nil || err.Error() != "access denied" {
+		t.Errorf("AddReaction by a non-member error = %v, want access denied", err)
+	}
+
+	stored, err := env.Repos.Message.GetByID(ctx, messageID)
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	if len(stored.Reactions) != 1 || stored.Reactions[0].UserID != bob.ID {
+		t.Errorf("reactions = %+v, want bob's", stored.Reactions)
+	}
+
+	if err := ms.RemoveReaction(ctx, bob.ID.Hex(), messageID, "👍"); err != nil {
+		t.Fatalf("RemoveReaction: %v", err)
+	}
+	env.Hub.WaitForBroadcasts(t, models.WSTypeReaction, 2)
+
+	toggled, err := ms.ToggleReaction(ctx, alice.ID.Hex(), messageID, "🎉")
+	if err != nil {
+		t.Fatalf("ToggleReaction: %v", err)
+	}
+	if !toggled.Reacted || toggled.Count != 1 {
+		t.Errorf("first toggle = %+v, want reacted with count 1", toggled)
+	}
+	toggled, err = ms.ToggleReaction(ctx, alice.ID.Hex(), messageID, "🎉")
+	if err != nil {
+		t.Fatalf("ToggleReaction: %v", err)
+	}
+	if toggled.Reacted || toggled.Count != 0 {
+		t.Errorf("second toggle = %+v, want removed with count 0", toggled)
+	}
+}
+
+func TestMessageServiceConcurrentToggles(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ms := newTestMessageService(env)
+	ctx := context.Background()
+
+	alice := env.Factory.User()
+	members := []*models.User{env.Factory.User(), env.Factory.User(), env.Factory.User(), env.Factory.User()}
+	circle := env.Factory.Circle(alice, members)
+	message := env.Factory.Message(circle, alice, "hello")
+
+	// Each member taps three times at once with the others: one reaction
+	// each is left, whatever the interleaving
+	var wg sync.WaitGroup
+	for _, member := range members {
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func(userID string) {
+				defer wg.Done()
+				if _, err := ms.ToggleReaction(ctx, userID, message.ID.Hex(), "❤️"); err != nil {
+					t.Errorf("ToggleReaction: %v", err)
+				}
+			}(member.ID.Hex())
+		}
+	}
+	wg.Wait()
+
+	stored, err := env.Repos.Message.GetByID(ctx, message.ID.Hex())
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	if len(stored.Reactions) != len(members) {
+		t.Errorf("%d reactions after odd toggles, want one per member", len(stored.Reactions))
+	}
+}
+
+func TestMessageServiceDoubleTapToggle(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ms := newTestMessageService(env)
+	ctx := context.Background()
+
+	alice, bob := env.Factory.User(), env.Factory.User()
+	circle := env.Factory.Circle(alice, []*models.User{bob})
+	message := env.Factory.Message(circle, alice, "hello")
+
+	// Two taps at once flip the reaction on and back off, rather than one
+	// failing as already added or not found
+	results := make(chan *models.ReactionToggleResponse, 2)
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := ms.ToggleReaction(ctx, bob.ID.Hex(), message.ID.Hex(), "👍")
+			if err != nil {
+				t.Errorf("ToggleReaction: %v", err)
+				return
+			}
+			results <- result
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	var added, removed int
+	for result := range results {
+		if result.Reacted {
+			added++
+			if result.Count != 1 {
+				t.Errorf("count after adding = %d, want 1", result.Count)
+			}
+		} else {
+			removed++
+			if result.Count != 0 {
+				t.Errorf("count after removing = %d, want 0", result.Count)
+			}
+		}
+	}
+	if added != 1 || removed != 1 {
+		t.Errorf("toggles added %d and removed %d, want one of each", added, removed)
+	}
+
+	stored, err := env.Repos.Message.GetByID(ctx, message.ID.Hex())
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	if len(stored.Reactions) != 0 {
+		t.Errorf("%d reactions left after a double tap, want 0", len(stored.Reactions))
+	}
+
+	// Each toggle broadcasts its own net change once
+	env.Hub.WaitForBroadcasts(t, models.WSTypeReaction, 2)
+}
+
+func TestApplyAttachmentUpdate(t *testing.T) {
+	media := &models.MessageMedia{URL: "/media/1", Type: "image"}
+	location := &models.MessageLocation{Latitude: 40.7, Longitude: -74}
+
+	// Each field is left alone, set or cleared
+	tests := []struct {
+		name           string
+		body           string
+		setsMedia      bool
+		setsLocation   bool
+		clearsMedia    bool
+		clearsLocation bool
+		reason         string
+	}{
+		{name: "missing fields", body: `{}`},
+		{name: "empty values", body: `{"media":{},"location":{}}`},
+		{name: "null values", body: `{"media":null,"location":null}`},
+		{name: "set", body: `{"media":{"url":"/media/1","type":"image"},"location":{"latitude":40.7,"longitude":-74}}`, setsMedia: true, setsLocation: true},
+		{name: "clear", body: `{"clearMedia":true,"clearLocation":true}`, clearsMedia: true, clearsLocation: true},
+		{name: "clear with empty values", body: `{"media":{},"clearMedia":true,"location":{},"clearLocation":true}`, clearsMedia: true, clearsLocation: true},
+		{name: "set media, clear location", body: `{"media":{"url":"/media/1","type":"image"},"clearLocation":true}`, setsMedia: true, clearsLocation: true},
+		{name: "set and clear media", body: `{"media":{"url":"/media/1"},"clearMedia":true}`, reason: "cannot both set and clear media"},
+		{name: "set and clear location", body: `{"location":{"latitude":1,"longitude":1},"clearLocation":true}`, reason: "cannot both set and clear location"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req models.AttachmentUpdate
+			if err := json.Unmarshal([]byte(tt.body), &req); err != nil {
+				t.Fatalf("decoding %s: %v", tt.body, err)
+			}
+
+			update := bson.M{}
+			err := applyAttachmentUpdate(update, req)
+			if tt.reason != "" {
+				if reason := utils.ValidationFailureReason(err); reason != tt.reason {
+					t.Errorf("error = %v (%q), want %q", err, reason, tt.reason)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyAttachmentUpdate: %v", err)
+			}
+
+			expectAttachment(t, update, "media", tt.clearsMedia, tt.setsMedia, func(v interface{}) bool {
+				got, ok := v.(*models.MessageMedia)
+				return ok && got.URL == media.URL && got.Type == media.Type
+			})
+			expectAttachment(t, update, "location", tt.clearsLocation, tt.setsLocation, func(v interface{}) bool {
+				got, ok := v.(*models.MessageLocation)
+				return ok && *got == *location
+			})
+		})
+	}
+}
+
+// expectAttachment checks whether the update leaves the key alone, clears
+// it with null, or sets a value matching
+func expectAttachment(t *testing.T, update bson.M, key string, cleared, set bool, matches func(interface{}) bool) {
+	t.Helper()
+	value, present := update[key]
+	switch {
+	case cleared:
+		if !present || value != nil {
+			t.Errorf("%s = %v (present %v), want cleared to null", key, value, present)
+		}
+	case set:
+		if !present || !matches(value) {
+			t.Errorf("%s = %v, want the new value", key, value)
+		}
+	case present:
+		t.Errorf("%s = %v, want it left unchanged", key, value)
+	}
+}
+
+func TestMessageServiceUpdateDraftAttachments(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ms := newTestMessageService(env)
+	ctx := context.Background()
+
+	alice := env.Factory.User()
+	circle := env.Factory.Circle(alice, nil)
+	userID := alice.ID.Hex()
+
+	draft, err := ms.SaveDraft(ctx, userID, models.SaveDraftRequest{
+		CircleID: circle.ID.Hex(),
+		Type:     "photo",
+		Content:  "look",
+		Media:    &models.MessageMedia{URL: "/media/1", Type: "image"},
+		Location: &models.MessageLocation{Latitude: 40.7, Longitude: -74},
+	})
+	if err != nil {
+		t.Fatalf("SaveDraft: %v", err)
+	}
+	draftID := draft.ID.Hex()
+
+	// Empty values leave the attachments alone
+	updated, err := ms.UpdateDraft(ctx, userID, draftID, models.UpdateDraftRequest{
+		Content:          "look at this",
+		AttachmentUpdate: models.AttachmentUpdate{Media: &models.MessageMedia{}, Location: &models.MessageLocation{}},
+	})
+	if err != nil {
+		t.Fatalf("UpdateDraft: %v", err)
+	}
+	if updated.Media == nil || updated.Media.URL != "/media/1" || updated.Location == nil || updated.Location.Latitude != 40.7 {
+		t.Errorf("attachments after an empty update = %+v, %+v; want unchanged", updated.Media, updated.Location)
+	}
+
+	// Set one, clear the other
+	updated, err = ms.UpdateDraft(ctx, userID, draftID, models.UpdateDraftRequest{
+		AttachmentUpdate: models.AttachmentUpdate{Media: &models.MessageMedia{URL: "/media/2", Type: "image"}, ClearLocation: true},
+	})
+	if err != nil {
+		t.Fatalf("UpdateDraft: %v", err)
+	}
+	if updated.Media == nil || updated.Media.URL != "/media/2" {
+		t.Errorf("media = %+v, want /media/2", updated.Media)
+	}
+	if updated.Location != nil {
+		t.Errorf("location = %+v, want cleared", updated.Location)
+	}
+	if updated.Content != "look at this" {
+		t.Errorf("content = %q, want it kept", updated.Content)
+	}
+
+	// Conflicting intents are rejected without changing anything
+	_, err = ms.UpdateDraft(ctx, userID, draftID, models.UpdateDraftRequest{
+		AttachmentUpdate: models.AttachmentUpdate{Media: &models.MessageMedia{URL: "/media/3"}, ClearMedia: true},
+	})
+	if err == nil || err.Error() != "validation failed" {
+		t.Errorf("set and clear error = %v, want validation failed", err)
+	}
+	stored, err := ms.GetDraft(ctx, userID, draftID)
+	if err != nil {
+		t.Fatalf("GetDraft: %v", err)
+	}
+	if stored.Media == nil || stored.Media.URL != "/media/2" {
+		t.Errorf("media after a rejected update = %+v, want /media/2", stored.Media)
+	}
+}
+
+func TestMessageServiceMediaStreamRange(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ctx := context.Background()
+
+	uploadDir := t.TempDir()
+	repos := env.Repos
+	ms := NewMessageService(
+		repos.Message, repos.Circle, repos.User, repos.Media, repos.Template, repos.Draft,
+		repos.Schedule, repos.Report, repos.Automation, repos.Export, repos.Block,
+		env.Hub, NewMediaService(uploadDir, "http://localhost"), nil, nil, nil,
+	)
+
+	alice, bob, carol := env.Factory.User(), env.Factory.User(), env.Factory.User()
+	circle := env.Factory.Circle(alice, []*models.User{bob})
+
+	data := make([]byte, 1024)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	if err := os.WriteFile(filepath.Join(uploadDir, "clip.mp4"), data, 0644); err != nil {
+		t.Fatalf("writing media file: %v", err)
+	}
+	media := &models.MessageMediaExtended{MessageMedia: models.MessageMedia{
+		URL:        "/uploads/clip.mp4",
+		Type:       "video",
+		Size:       int64(len(data)),
+		Filename:   "clip.mp4",
+		MimeType:   "video/mp4",
+		UploadedBy: alice.ID.Hex(),
+	}}
+	if err := repos.Media.Create(ctx, media); err != nil {
+		t.Fatalf("creating media: %v", err)
+	}
+	shared := &models.Message{CircleID: circle.ID, SenderID: alice.ID, Type: "photo", Media: media.MessageMedia}
+	shared.Media.ID = media.ID
+	if err := repos.Message.Create(ctx, shared); err != nil {
+		t.Fatalf("creating message: %v", err)
+	}
+	mediaID := media.ID.Hex()
+
+	if _, err := ms.GetMediaStream(ctx, carol.ID.Hex(), mediaID); err == nil || err.Error() != "access denied" {
+		t.Errorf("stream for a non-member error = %v, want access denied", err)
+	}
+
+	// A member of the circle it was shared in can seek through it
+	stream, err := ms.GetMediaStream(ctx, bob.ID.Hex(), mediaID)
+	if err != nil {
+		t.Fatalf("GetMediaStream: %v", err)
+	}
+	defer stream.File.Close()
+	if stream.ContentType != "video/mp4" || stream.Name != "clip.mp4" {
+		t.Errorf("stream = %s (%s), want clip.mp4 (video/mp4)", stream.Name, stream.ContentType)
+	}
+
+	tests := []struct {
+		rangeHeader  string
+		start, end   int // inclusive
+		contentRange string
+	}{
+		{"bytes=100-199", 100, 199, "bytes 100-199/1024"},
+		{"bytes=1000-", 1000, 1023, "bytes 1000-1023/1024"},
+		{"bytes=-24", 1000, 1023, "bytes 1000-1023/1024"},
+	}
+	for _, tt := range tests {
+		request := httptest.NewRequest(http.MethodGet, MediaStreamPath(mediaID), nil)
+		request.Header.Set("Range", tt.rangeHeader)
+		recorder := httptest.NewRecorder()
+		recorder.Header().Set("Content-Type", stream.ContentType)
+		http.ServeContent(recorder, request, stream.Name, stream.ModTime, stream.File)
+
+		if recorder.Code != http.StatusPartialContent {
+			t.Errorf("%s: status = %d, want 206", tt.rangeHeader, recorder.Code)
+			continue
+		}
+		if got := recorder.Header().Get("Content-Range"); got != tt.contentRange {
+			t.Errorf("%s: Content-Range = %q, want %q", tt.rangeHeader, got, tt.contentRange)
+		}
+		body, _ := io.ReadAll(recorder.Body)
+		if want := data[tt.start : tt.end+1]; string(body) != string(want) {
+			t.Errorf("%s: got %d bytes starting %v, want bytes %d-%d", tt.rangeHeader, len(body), body[:min(len(body), 4)], tt.start, tt.end)
+		}
+		if got := recorder.Header().Get("Content-Length"); got != fmt.Sprint(tt.end-tt.start+1) {
+			t.Errorf("%s: Content-Length = %s, want %d", tt.rangeHeader, got, tt.end-tt.start+1)
+		}
+	}
+
+	if _, err := ms.GetMediaStream(ctx, bob.ID.Hex(), "not-an-id"); err == nil {
+		t.Error("stream of an invalid media ID succeeded")
+	}
+}
@@ -0,0 +1,279 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"ftrack/models"
+	"ftrack/repositories"
+	"ftrack/utils"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// A pair is told about each other at most this often
+	nearbyAlertCooldown = 2 * time.Hour
+	// Latest positions older than this say nothing about where a member is
+	nearbyAlertMaxPositionAge = 15 * time.Minute
+)
+
+// NearbyAlertService tells two circle members when they happen to be close
+// to each other somewhere other than the circle's places. Both members must
+// have opted in, which they do per circle through the circle service, and
+// neither learns more than a rounded distance.
+type NearbyAlertService struct {
+	circleRepo          *repositories.CircleRepository
+	locationRepo        *repositories.LocationRepository
+	placeRepo           *repositories.PlaceRepository
+	userRepo            *repositories.UserRepository
+	notificationService *NotificationService
+	redis               *redis.Client
+}
+
+func NewNearbyAlertService(
+	circleRepo *repositories.CircleRepository,
+	locationRepo *repositories.LocationRepository,
+	placeRepo *repositories.PlaceRepository,
+	userRepo *repositories.UserRepository,
+	notificationService *NotificationService,
+	redis *redis.Client,
+) *NearbyAlertService {
+	return &NearbyAlertService{
+		circleRepo:          circleRepo,
+		locationRepo:        locationRepo,
+		placeRepo:           placeRepo,
+		userRepo:            userRepo,
+		notificationService: notificationService,
+		redis:               redis,
+	}
+}
+
+// nearbyPair is a circle mate the user may be alerted about, with the
+// circles they share with alerts on for both
+type nearbyPair struct {
+	distance  float64
+	circleIDs []string
+}
+
+// CheckNearby compares the user's new position with the latest positions of
+// the circle mates who opted in along with them, and alerts both sides of
+// each pair that is within distance. Pairs where either member is at one of
+// their shared circles' places are left alone: being near each other at
+// home is not news.
+func (ns *NearbyAlertService) CheckNearby(ctx context.Context, userID string, location models.Location) error {
+	// Without Redis the cooldown can't be kept, and alerts would repeat on
+	// every update
+	if ns.redis == nil || ns.notificationService == nil {
+		return nil
+	}
+
+	circles, err := ns.circleRepo.GetUserCircles(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	pairs := make(map[string]*nearbyPair)
+	for i := range circles {
+		circle := &circles[i]
+		if circle.IsArchived() || !circle.Settings.LocationSharing {
+			continue
+		}
+
+		self := findCircleMember(circle, userID)
+		if self == nil || self.Status != "active" || !self.NearbyAlerts.Enabled {
+			continue
+		}
+
+		for _, other := range circle.Members {
+			mateID := other.UserID.Hex()
+			if mateID == userID || other.Status != "active" || !other.NearbyAlerts.Enabled {
+				continue
+			}
+
+			// The smaller of the two distances, so neither is alerted
+			// further out than they asked for. Across the circles they
+			// share, the largest of those applies.
+			distance := float64(min(nearbyAlertDistance(self.NearbyAlerts), nearbyAlertDistance(other.NearbyAlerts)))
+
+			pair, exists := pairs[mateID]
+			if !exists {
+				pair = &nearbyPair{}
+				pairs[mateID] = pair
+			}
+			pair.distance = math.Max(pair.distance, distance)
+			pair.circleIDs = append(pair.circleIDs, circle.ID.Hex())
+		}
+	}
+	if len(pairs) == 0 {
+		return nil
+	}
+
+	userIDs := []string{userID}
+	for mateID := range pairs {
+		userIDs = append(userIDs, mateID)
+	}
+
+	users, err := ns.userRepo.GetUsersByIDs(ctx, userIDs)
+	if err != nil {
+		return err
+	}
+	usersByID := make(map[string]*models.User, len(users))
+	for i := range users {
+		usersByID[users[i].ID.Hex()] = &users[i]
+	}
+
+	self, exists := usersByID[userID]
+	if !exists {
+		return nil
+	}
+
+	mateIDs := make([]string, 0, len(pairs))
+	for mateID, pair := range pairs {
+		mate, exists := usersByID[mateID]
+		if !exists {
+			continue
+		}
+
+		// Only circles both still share their location with count
+		shared := pair.circleIDs[:0]
+		for _, circleID := range pair.circleIDs {
+			if sharingIncludesCircle(self.LocationSharing, circleID) && sharingIncludesCircle(mate.LocationSharing, circleID) {
+				shared = append(shared, circleID)
+			}
+		}
+		if len(shared) == 0 {
+			continue
+		}
+		pair.circleIDs = shared
+		mateIDs = append(mateIDs, mateID)
+	}
+	if len(mateIDs) == 0 {
+		return nil
+	}
+
+	latest, err := ns.locationRepo.GetLatestLocations(ctx, mateIDs)
+	if err != nil {
+		return err
+	}
+
+	places := make(map[string][]models.Place)
+	for _, mateID := range mateIDs {
+		position, exists := latest[mateID]
+		if !exists || time.Since(position.ServerTime) > nearbyAlertMaxPositionAge {
+			continue
+		}
+
+		pair := pairs[mateID]
+		distance := utils.CalculateDistance(location.Latitude, location.Longitude, position.Latitude, position.Longitude)
+		if distance > pair.distance {
+			continue
+		}
+
+		atPlace, err := ns.eitherAtPlace(ctx, places, pair.circleIDs, location, position)
+		if err != nil {
+			return err
+		}
+		if atPlace {
+			continue
+		}
+
+		claimed, err := ns.claimPair(ctx, userID, mateID)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			continue
+		}
+
+		mate := usersByID[mateID]
+		ns.notifyNearby(ctx, userID, mate, pair.circleIDs[0], distance)
+		ns.notifyNearby(ctx, mateID, self, pair.circleIDs[0], distance)
+	}
+
+	return nil
+}
+
+// eitherAtPlace reports whether either position is inside one of the
+// circles' places. Places are loaded once per check.
+func (ns *NearbyAlertService) eitherAtPlace(ctx context.Context, places map[string][]models.Place, circleIDs []string, positions ...models.Location) (bool, error) {
+	for _, circleID := range circleIDs {
+		circlePlaces, loaded := places[circleID]
+		if !loaded {
+			var err error
+			circlePlaces, err = ns.placeRepo.GetCirclePlaces(ctx, circleID)
+			if err != nil {
+				return false, err
+			}
+			places[circleID] = circlePlaces
+		}
+
+		for _, place := range circlePlaces {
+			for _, position := range positions {
+				if utils.CalculateDistance(position.Latitude, position.Longitude, place.Latitude, place.Longitude) <= float64(place.Radius) {
+					return true, nil
+				}
+			}
+		}
+	}
+	return false, nil
+}
+
+// claimPair starts the pair's cooldown. It reports false when the pair was
+// alerted within the cooldown, whichever of them moved.
+func (ns *NearbyAlertService) claimPair(ctx context.Context, userID, mateID string) (bool, error) {
+	first, second := userID, mateID
+	if second < first {
+		first, second = second, first
+	}
+
+	key := fmt.Sprintf("nearby:pair:%s:%s", first, second)
+	return ns.redis.SetNX(ctx, key, time.Now().Unix(), nearbyAlertCooldown).Result()
+}
+
+// notifyNearby tells the recipient that the mate is close by. Only the
+// rounded distance is sent, never where either of them is.
+func (ns *NearbyAlertService) notifyNearby(ctx context.Context, recipientID string, mate *models.User, circleID string, distance float64) {
+	if ns.notificationService.inQuietHours(ctx, recipientID) {
+		return
+	}
+
+	approximate := coarseDistance(distance)
+	err := ns.notificationService.SendNotification(ctx, models.SendNotificationRequest{
+		Recipients: []string{recipientID},
+		Title:      fmt.Sprintf("%s is nearby", mate.FirstName),
+		Message:    fmt.Sprintf("You're about %s from %s", utils.FormatDistance(approximate), mate.FirstName),
+		Type:       models.NotificationTypeNearbyMember,
+		Priority:   "low",
+		Category:   "location",
+		Data: map[string]interface{}{
+			"userId":   mate.ID.Hex(),
+			"circleId": circleID,
+			"distance": approximate,
+		},
+		DeliveryChannels: []string{"push"},
+		SubjectUserID:    mate.ID.Hex(),
+	})
+	if err != nil {
+		logrus.Errorf("Failed to send nearby alert to %s: %v", recipientID, err)
+	}
+}
+
+func nearbyAlertDistance(settings models.NearbyAlertSettings) int {
+	if settings.Distance == 0 {
+		return models.DefaultNearbyAlertDistance
+	}
+	return settings.Distance
+}
+
+// coarseDistance rounds up to the next 100 m, or to the nearest 500 m from
+// a kilometer on
+func coarseDistance(meters float64) float64 {
+	if meters < 1000 {
+		return math.Max(100, math.Ceil(meters/100)*100)
+	}
+	return math.Round(meters/500) * 500
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"strings"
+
+	"ftrack/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Channels announcements go out on, where the recipient has them enabled
+var announcementChannels = []string{
+	models.DeliveryChannelPush,
+	models.DeliveryChannelInApp,
+	models.DeliveryChannelEmail,
+}
+
+// SendAnnouncement fans an announcement out to its recipients, each on the
+// channels they have enabled. Recipients who muted the notification type,
+// or all notifications, are skipped unless it is high priority. High
+// priority doesn't get past quiet hours or do not disturb. It returns how
+// many recipients were skipped for muting.
+func (ns *NotificationService) SendAnnouncement(ctx context.Context, req models.SendNotificationRequest) (int, error) {
+	groups := make(map[string][]string)
+	var order []string
+	muted := 0
+
+	for _, recipientID := range req.Recipients {
+		channels := []string{models.DeliveryChannelPush, models.DeliveryChannelInApp}
+
+		preferences, err := ns.GetNotificationPreferences(ctx, recipientID)
+		if err != nil {
+			logrus.Warnf("Failed to get notification preferences of user %s: %v", recipientID, err)
+		} else {
+			if announcementMuted(preferences, req.Type) && req.Priority != "high" {
+				muted++
+				continue
+			}
+			channels = enabledAnnouncementChannels(preferences)
+		}
+		if len(channels) == 0 {
+			continue
+		}
+
+		key := strings.Join(channels, ",")
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], recipientID)
+	}
+
+	for _, key := range order {
+		group := req
+		group.Recipients = groups[key]
+		group.DeliveryChannels = strings.Split(key, ",")
+		if err := ns.SendNotification(ctx, group); err != nil {
+			return muted, err
+		}
+	}
+
+	return muted, nil
+}
+
+// GetAnnouncementStats counts the notifications sent for an announcement,
+// and how many were delivered and read
+func (ns *NotificationService) GetAnnouncementStats(ctx context.Context, announcementID string) (*models.AnnouncementStats, error) {
+	return ns.notificationRepo.GetAnnouncementStats(ctx, announcementID)
+}
+
+func announcementMuted(preferences *models.NotificationPreferences, notificationType string) bool {
+	if !preferences.GlobalEnabled {
+		return true
+	}
+	preference, ok := preferences.TypePreferences[notificationType]
+	return ok && !preference.Enabled
+}
+
+func enabledAnnouncementChannels(preferences *models.NotificationPreferences) []string {
+	enabled := map[string]bool{
+		models.DeliveryChannelPush:  preferences.PushEnabled,
+		models.DeliveryChannelInApp: preferences.InAppEnabled,
+		models.DeliveryChannelEmail: preferences.EmailEnabled,
+	}
+
+	var channels []string
+	for _, channel := range announcementChannels {
+		if enabled[channel] {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"ftrack/models"
+	"ftrack/utils"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ConfigureBatchWindows overrides the default batching windows of
+// notification types with "type:seconds" entries, e.g. "message:30". 0
+// sends the type's notifications right away.
+func (ns *NotificationService) ConfigureBatchWindows(entries []string) {
+	windows := make(map[string]time.Duration, len(models.DefaultNotificationBatchWindows)+len(entries))
+	for notificationType, window := range models.DefaultNotificationBatchWindows {
+		windows[notificationType] = window
+	}
+
+	for _, entry := range entries {
+		notificationType, secondsText, found := strings.Cut(entry, ":")
+		notificationType = strings.TrimSpace(notificationType)
+		seconds, err := strconv.Atoi(strings.TrimSpace(secondsText))
+		if !found || notificationType == "" || err != nil || validateBatchWindow(seconds) != nil {
+			logrus.Errorf("Ignoring notification batch window %q, expected type:seconds", entry)
+			continue
+		}
+		windows[notificationType] = time.Duration(seconds) * time.Second
+	}
+
+	ns.batchWindows = windows
+}
+
+func validateBatchWindow(seconds int) error {
+	if seconds < 0 || time.Duration(seconds)*time.Second > models.MaxNotificationBatchWindow {
+		return utils.NewValidationFailedError(fmt.Sprintf("batch windows must be between 0 and %d seconds", int(models.MaxNotificationBatchWindow.Seconds())))
+	}
+	return nil
+}
+
+// batchWindow returns how long notifications of the type are batched for
+// the user, and whether the window is the user's own
+func (ns *NotificationService) batchWindow(preferences *models.NotificationPreferences, notificationType string) (time.Duration, string) {
+	if preference, ok := preferences.TypePreferences[notificationType]; ok && preference.BatchWindowSeconds != nil {
+		return time.Duration(*preference.BatchWindowSeconds) * time.Second, models.BatchPolicySourceUser
+	}
+
+	windows := ns.batchWindows
+	if windows == nil {
+		windows = models.DefaultNotificationBatchWindows
+	}
+	return windows[notificationType], models.BatchPolicySourceDefault
+}
+
+// batchingPolicy is the effective batching of every notification type the
+// user may get or has a window for
+func (ns *NotificationService) batchingPolicy(ctx context.Context, preferences *models.NotificationPreferences) map[string]models.NotificationBatchPolicy {
+	notificationTypes := make(map[string]bool)
+	if types, err := ns.GetNotificationTypes(ctx); err == nil {
+		for _, t := range types {
+			notificationTypes[t.ID] = true
+		}
+	}
+	for notificationType := range ns.batchWindows {
+		notificationTypes[notificationType] = true
+	}
+	for notificationType := range models.DefaultNotificationBatchWindows {
+		notificationTypes[notificationType] = true
+	}
+	for notificationType, preference := range preferences.TypePreferences {
+		if preference.BatchWindowSeconds != nil {
+			notificationTypes[notificationType] = true
+		}
+	}
+
+	policy := make(map[string]models.NotificationBatchPolicy, len(notificationTypes))
+	for notificationType := range notificationTypes {
+		window, source := ns.batchWindow(preferences, notificationType)
+		policy[notificationType] = models.NotificationBatchPolicy{
+			WindowSeconds: int(window.Seconds()),
+			Source:        source,
+		}
+	}
+	return policy
+}
+
+// applyBatching holds a notification that would go out now until the user's
+// open batch of its type goes out, or opens a batch for the type's window.
+// Immediate notifications and urgent or high priority ones skip batching.
+func (ns *NotificationService) applyBatching(ctx context.Context, decision *models.DeliveryDecision, preferences *models.NotificationPreferences, in deliveryInput) error {
+	window, _ := ns.batchWindow(preferences, in.Type)
+	switch {
+	case window <= 0:
+		decision.AddStep(models.DecisionLayerBatching, 0, models.DecisionEffectNone, "")
+		return nil
+	case in.Immediate:
+		decision.AddStep(models.DecisionLayerBatching, int(window.Seconds()), models.DecisionEffectBypassed, "sent immediately")
+		return nil
+	case decision.Priority == "urgent" || decision.Priority == "critical" || decision.Priority == "high":
+		decision.AddStep(models.DecisionLayerBatching, int(window.Seconds()), models.DecisionEffectBypassed, decision.Priority+" notifications are never batched")
+		return nil
+	}
+
+	deliverAt := in.At.Add(window)
+	openUntil, err := ns.notificationRepo.GetOpenBatchUntil(ctx, in.UserID, in.Type, in.At)
+	if err != nil {
+		return err
+	}
+	detail := fmt.Sprintf("batched for %s with other %s notifications", window, in.Type)
+	if openUntil != nil {
+		deliverAt = *openUntil
+		detail = "joined the open batch of " + in.Type + " notifications"
+	}
+
+	decision.Outcome = models.DeliveryOutcomeBatched
+	decision.DeferredUntil = &deliverAt
+	decision.AddStep(models.DecisionLayerBatching, int(window.Seconds()), models.DecisionEffectBatched, detail)
+	return nil
+}
+
+// deliverBatch claims the rest of the batch the notification opened and
+// sends it in the order it was created. The app gets each notification;
+// the other channels get one for the batch, the newest, saying how many
+// more it stands for.
+func (ns *NotificationService) deliverBatch(ctx context.Context, first *models.Notification) {
+	batch := []*models.Notification{first}
+	for {
+		next, err := ns.notificationRepo.ClaimBatchedNotification(ctx, first)
+		if err != nil {
+			logrus.Errorf("Failed to claim batch of notification %s: %v", first.ID.Hex(), err)
+			break
+		}
+		if next == nil {
+			break
+		}
+		batch = append(batch, next)
+	}
+
+	var pending []*models.Notification
+	for _, notification := range batch {
+		if deferredNotificationWanted(notification) {
+			pending = append(pending, notification)
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+	if len(pending) == 1 {
+		ns.deliverOnChannels(ctx, pending[0])
+		ns.updateBadgeCount(ctx, first.UserID)
+		return
+	}
+
+	if ns.hub != nil {
+		for _, notification := range pending {
+			if utils.StringSliceContains(notification.DeliveryChannels, models.DeliveryChannelInApp) {
+				ns.hub.SendNotificationToUser(notification.UserID, notification)
+			}
+		}
+	}
+
+	newest := pending[len(pending)-1]
+	summary := *newest
+	summary.Message = fmt.Sprintf("%s (+%d more)", newest.Message, len(pending)-1)
+	summary.DeliveryChannels = nil
+	for _, channel := range newest.DeliveryChannels {
+		if channel != models.DeliveryChannelInApp {
+			summary.DeliveryChannels = append(summary.DeliveryChannels, channel)
+		}
+	}
+
+	ids := make([]string, len(pending))
+	for i, notification := range pending {
+		ids[i] = notification.ID.Hex()
+	}
+	summary.Metadata = make(map[string]interface{}, len(newest.Metadata)+2)
+	for key, value := range newest.Metadata {
+		summary.Metadata[key] = value
+	}
+	summary.Metadata["batch_count"] = len(pending)
+	summary.Metadata["batch_notification_ids"] = ids // oldest first
+
+	ns.deliverOnChannels(ctx, &summary)
+	ns.updateBadgeCount(ctx, first.UserID)
+}
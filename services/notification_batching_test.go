@@ -0,0 +1,271 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"ftrack/models"
+	"ftrack/testharness"
+	"ftrack/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestNotificationBatchWindows(t *testing.T) {
+	seconds := func(n int) *int { return &n }
+	userPreferences := &models.NotificationPreferences{TypePreferences: map[string]models.TypePreference{
+		models.NotificationTypeMessage:     {Enabled: true, BatchWindowSeconds: seconds(0)},
+		models.NotificationTypePlaceReview: {Enabled: true},
+		"geofence":                         {Enabled: true, BatchWindowSeconds: seconds(300)},
+	}}
+	defaults := &models.NotificationPreferences{}
+
+	unconfigured := &NotificationService{}
+	configured := &NotificationService{}
+	configured.ConfigureBatchWindows([]string{"message:10", " geofence : 60 ", "place_review:0", "broken", "emergency:-5", "digest:86401", ":30"})
+
+	tests := []struct {
+		name             string
+		ns               *NotificationService
+		preferences      *models.NotificationPreferences
+		notificationType string
+		window           time.Duration
+		source           string
+	}{
+		{"chat by default", unconfigured, defaults, models.NotificationTypeMessage, 30 * time.Second, "default"},
+		{"reviews by default", unconfigured, defaults, models.NotificationTypePlaceReview, time.Hour, "default"},
+		{"arrivals by default", unconfigured, defaults, "geofence", 0, "default"},
+		{"configured chat", configured, defaults, models.NotificationTypeMessage, 10 * time.Second, "default"},
+		{"configured arrivals", configured, defaults, "geofence", time.Minute, "default"},
+		{"configured off", configured, defaults, models.NotificationTypePlaceReview, 0, "default"},
+		{"invalid entries ignored", configured, defaults, "emergency", 0, "default"},
+		{"user turned chat batching off", configured, userPreferences, models.NotificationTypeMessage, 0, "user"},
+		{"user batches arrivals", unconfigured, userPreferences, "geofence", 5 * time.Minute, "user"},
+		{"type preference without a window", unconfigured, userPreferences, models.NotificationTypePlaceReview, time.Hour, "default"},
+	}
+	for _, tt := range tests {
+		window, source := tt.ns.batchWindow(tt.preferences, tt.notificationType)
+		if window != tt.window || source != tt.source {
+			t.Errorf("%s: window %s from %s, want %s from %s", tt.name, window, source, tt.window, tt.source)
+		}
+	}
+	if _, ok := configured.batchWindows["digest"]; ok {
+		t.Error("a window over a day was configured")
+	}
+
+	for _, seconds := range []int{-1, 86401} {
+		if err := validateBatchWindow(seconds); utils.ValidationFailureReason(err) != "batch windows must be between 0 and 86400 seconds" {
+			t.Errorf("window of %d seconds error = %v", seconds, err)
+		}
+	}
+}
+
+func TestNotificationBatchingBypass(t *testing.T) {
+	ns := &NotificationService{}
+	preferences := &models.NotificationPreferences{}
+	at := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+	// None of these reach the store, so no repository is needed
+	tests := []struct {
+		name             string
+		notificationType string
+		priority         string
+		immediate        bool
+		effect           string
+	}{
+		{"type without a window", "geofence", "normal", false, models.DecisionEffectNone},
+		{"immediate", models.NotificationTypeMessage, "normal", true, models.DecisionEffectBypassed},
+		{"high priority", models.NotificationTypeMessage, "high", false, models.DecisionEffectBypassed},
+		{"urgent", models.NotificationTypePlaceReview, "urgent", false, models.DecisionEffectBypassed},
+	}
+	for _, tt := range tests {
+		decision := &models.DeliveryDecision{Priority: tt.priority, Outcome: models.DeliveryOutcomeDelivered}
+		err := ns.applyBatching(context.Background(), decision, preferences, deliveryInput{UserID: "u", Type: tt.notificationType, At: at, Immediate: tt.immediate})
+		if err != nil {
+			t.Fatalf("%s: applyBatching: %v", tt.name, err)
+		}
+		step := decision.Trace[len(decision.Trace)-1]
+		if decision.Outcome != models.DeliveryOutcomeDelivered || decision.DeferredUntil != nil || step.Layer != models.DecisionLayerBatching || step.Effect != tt.effect {
+			t.Errorf("%s: %s with batching step %+v, want delivered now with effect %s", tt.name, decision.Outcome, step, tt.effect)
+		}
+	}
+}
+
+func TestNotificationBatching(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ns, repo := newTestNotificationService(t, env)
+	ctx := context.Background()
+
+	user := env.Factory.User()
+	userID := user.ID.Hex()
+	send := func(notificationType, title, priority string, immediate bool) {
+		t.Helper()
+		err := ns.SendNotification(ctx, models.SendNotificationRequest{
+			Recipients: []string{userID}, Type: notificationType, Title: title, Message: title,
+			Priority: priority, Immediate: immediate, DeliveryChannels: []string{models.DeliveryChannelInApp},
+		})
+		if err != nil {
+			t.Fatalf("SendNotification: %v", err)
+		}
+	}
+	stored := func() map[string]models.Notification {
+		t.Helper()
+		cursor, err := env.DB.Collection("notifications").Find(ctx, bson.M{"user_id": userID})
+		if err != nil {
+			t.Fatalf("finding notifications: %v", err)
+		}
+		var notifications []models.Notification
+		if err := cursor.All(ctx, &notifications); err != nil {
+			t.Fatalf("reading notifications: %v", err)
+		}
+		byTitle := make(map[string]models.Notification)
+		for _, notification := range notifications {
+			byTitle[notification.Title] = notification
+		}
+		return byTitle
+	}
+
+	start := time.Now()
+	send(models.NotificationTypeMessage, "chat 1", "", false)
+	send("geofence", "arrived", "", false)
+	send(models.NotificationTypeMessage, "chat 2", "", false)
+	send(models.NotificationTypeMessage, "mention", "", true)
+	send(models.NotificationTypeMessage, "sos", "high", false)
+	send(models.NotificationTypePlaceReview, "review", "", false)
+	send(models.NotificationTypeMessage, "chat 3", "", false)
+
+	notifications := stored()
+	chatBatch := notifications["chat 1"].DeferredUntil
+	if chatBatch == nil || chatBatch.Sub(start) < 29*time.Second || chatBatch.Sub(start) > 31*time.Second {
+		t.Fatalf("chat batch goes out at %v, want 30 seconds after the first message", chatBatch)
+	}
+	for title, want := range map[string]string{
+		"chat 1":  "batched until " + chatBatch.String(),
+		"chat 2":  "batched until " + chatBatch.String(),
+		"chat 3":  "batched until " + chatBatch.String(),
+		"arrived": "delivered",
+		"mention": "delivered",
+		"sos":     "delivered",
+	} {
+		notification := notifications[title]
+		got := "delivered"
+		if notification.Batched && notification.DeferredUntil != nil {
+			got = "batched until " + notification.DeferredUntil.String()
+		} else if notification.DeliveredAt == nil {
+			got = "undelivered"
+		}
+		if got != want {
+			t.Errorf("%s: %s, want %s", title, got, want)
+		}
+	}
+	if review := notifications["review"]; !review.Batched || review.DeferredUntil == nil || review.DeferredUntil.Sub(start) < 59*time.Minute {
+		t.Errorf("review held until %v, want an hour", review.DeferredUntil)
+	}
+	if !notifications["mention"].Immediate {
+		t.Error("immediate flag not stored")
+	}
+
+	// A due batch is claimed in the order its notifications were created
+	if _, err := env.DB.Collection("notifications").UpdateMany(ctx, bson.M{"user_id": userID, "batched": true},
+		bson.M{"$set": bson.M{"deferred_until": time.Now().Add(-time.Second)}}); err != nil {
+		t.Fatalf("making batches due: %v", err)
+	}
+	first, err := repo.ClaimDueDeferredNotification(ctx)
+	if err != nil || first == nil {
+		t.Fatalf("ClaimDueDeferredNotification = %v, %v", first, err)
+	}
+	claimed := []string{first.Title}
+	for {
+		next, err := repo.ClaimBatchedNotification(ctx, first)
+		if err != nil {
+			t.Fatalf("ClaimBatchedNotification: %v", err)
+		}
+		if next == nil {
+			break
+		}
+		claimed = append(claimed, next.Title)
+	}
+	if got := strings.Join(claimed, ","); got != "chat 1,chat 2,chat 3" {
+		t.Errorf("batch claimed as %s, want the chat messages in order", got)
+	}
+
+	// The poller sends what's left, the review batch
+	if err := ns.DeliverDeferredNotifications(ctx); err != nil {
+		t.Fatalf("DeliverDeferredNotifications: %v", err)
+	}
+	for title, notification := range stored() {
+		if notification.DeliveredAt == nil {
+			t.Errorf("%s not delivered", title)
+		}
+	}
+}
+
+func TestNotificationBatchingUserOverride(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ns, _ := newTestNotificationService(t, env)
+	ctx := context.Background()
+
+	user := env.Factory.User()
+	userID := user.ID.Hex()
+	setWindow := func(notificationType string, seconds int) {
+		t.Helper()
+		if _, err := ns.UpdateTypePreferences(ctx, userID, notificationType, models.UpdateTypePreferencesRequest{BatchWindowSeconds: &seconds}); err != nil {
+			t.Fatalf("UpdateTypePreferences: %v", err)
+		}
+	}
+	policy := func() string {
+		t.Helper()
+		preferences, err := ns.GetNotificationPreferences(ctx, userID)
+		if err != nil {
+			t.Fatalf("GetNotificationPreferences: %v", err)
+		}
+		var policies []string
+		for _, notificationType := range []string{"message", "place_review", "geofence"} {
+			p := preferences.BatchingPolicy[notificationType]
+			policies = append(policies, fmt.Sprintf("%s=%d/%s", notificationType, p.WindowSeconds, p.Source))
+		}
+		return strings.Join(policies, " ")
+	}
+
+	if got := policy(); got != "message=30/default place_review=3600/default geofence=0/default" {
+		t.Errorf("default policy %s", got)
+	}
+
+	// Reviews right away, arrivals batched over two minutes
+	setWindow("place_review", 0)
+	setWindow("geofence", 120)
+	if got := policy(); got != "message=30/default place_review=0/user geofence=120/user" {
+		t.Errorf("policy after overrides %s", got)
+	}
+
+	decision, err := ns.resolveDelivery(ctx, deliveryInput{UserID: userID, Type: "geofence", Priority: "normal", At: time.Now(), Channels: []string{models.DeliveryChannelInApp}})
+	if err != nil {
+		t.Fatalf("resolveDelivery: %v", err)
+	}
+	if decision.Outcome != models.DeliveryOutcomeBatched {
+		t.Errorf("arrival outcome %s, want batched by the user's window", decision.Outcome)
+	}
+	decision, err = ns.resolveDelivery(ctx, deliveryInput{UserID: userID, Type: "place_review", Priority: "normal", At: time.Now(), Channels: []string{models.DeliveryChannelInApp}})
+	if err != nil {
+		t.Fatalf("resolveDelivery: %v", err)
+	}
+	if decision.Outcome != models.DeliveryOutcomeDelivered {
+		t.Errorf("review outcome %s, want delivered with batching off", decision.Outcome)
+	}
+
+	// -1 goes back to the default
+	setWindow("place_review", -1)
+	if got := policy(); got != "message=30/default place_review=3600/default geofence=120/user" {
+		t.Errorf("policy after resetting reviews %s", got)
+	}
+
+	seconds := 90000
+	if _, err := ns.UpdateTypePreferences(ctx, userID, "message", models.UpdateTypePreferencesRequest{BatchWindowSeconds: &seconds}); utils.ValidationFailureReason(err) == "" {
+		t.Errorf("window over a day error = %v, want a validation failure", err)
+	}
+}
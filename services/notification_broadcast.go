@@ -0,0 +1,598 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"ftrack/models"
+	"ftrack/utils"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// Users are delivered to in batches; progress is saved after each one
+	broadcastBatchSize = 200
+
+	// A running broadcast without a heartbeat for this long died with its
+	// instance, and another one picks it up where it stopped
+	broadcastStaleAfter = 2 * time.Minute
+
+	maxBroadcastUserIDs    = 10000
+	maxBroadcastScheduleIn = 30 * 24 * time.Hour
+
+	// A user gets at most this many broadcasts a day, however many admins send
+	maxBroadcastsPerUserPerDay = 3
+)
+
+var broadcastTemplateVariables = []string{"firstName", "lastName", "fullName"}
+
+// Reasons a targeted user was skipped
+const (
+	broadcastSkipInactive     = "inactive_account"
+	broadcastSkipDeactivated  = "deactivated"
+	broadcastSkipDisabled     = "notifications_disabled"
+	broadcastSkipTypeDisabled = "type_disabled"
+	broadcastSkipQuietHours   = "quiet_hours"
+	broadcastSkipNoChannels   = "no_channels"
+	broadcastSkipRateLimited  = "rate_limited"
+)
+
+// CreateBroadcast validates a broadcast and schedules it. The notification
+// worker sends it once it is due.
+func (ns *NotificationService) CreateBroadcast(ctx context.Context, adminID string, req models.CreateBroadcastRequest) (*models.NotificationBroadcast, error) {
+	target, err := ns.validateBroadcastTarget(ctx, req.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	template, err := validateBroadcastTemplate(req.Notification)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	scheduledAt := now
+	if req.ScheduledAt != nil {
+		if req.ScheduledAt.Before(now.Add(-time.Minute)) {
+			return nil, utils.NewValidationFailedError("scheduled_at is in the past")
+		}
+		if req.ScheduledAt.After(now.Add(maxBroadcastScheduleIn)) {
+			return nil, utils.NewValidationFailedError("scheduled_at can be at most 30 days ahead")
+		}
+		scheduledAt = *req.ScheduledAt
+	}
+
+	broadcast := &models.NotificationBroadcast{
+		CreatedBy:   adminID,
+		Target:      target,
+		Template:    template,
+		Status:      models.BroadcastStatusScheduled,
+		ScheduledAt: scheduledAt,
+	}
+	if target.Type == models.BroadcastTargetUsers {
+		broadcast.Stats.Targeted = int64(len(target.UserIDs))
+	}
+
+	if err := ns.notificationRepo.CreateBroadcast(ctx, broadcast); err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("Admin %s scheduled broadcast %s to %s for %s",
+		adminID, broadcast.ID.Hex(), target.Type, scheduledAt.Format(time.RFC3339))
+
+	return withBroadcastStatsMaps(broadcast), nil
+}
+
+func (ns *NotificationService) validateBroadcastTarget(ctx context.Context, target models.BroadcastTarget) (models.BroadcastTarget, error) {
+	switch target.Type {
+	case models.BroadcastTargetAll:
+		return models.BroadcastTarget{Type: target.Type}, nil
+
+	case models.BroadcastTargetCircle:
+		if _, err := ns.circleRepo.GetByID(ctx, target.CircleID); err != nil {
+			return target, utils.NewValidationFailedError("target circle not found")
+		}
+		return models.BroadcastTarget{Type: target.Type, CircleID: target.CircleID}, nil
+
+	case models.BroadcastTargetUsers:
+		if len(target.UserIDs) == 0 {
+			return target, utils.NewValidationFailedError("target needs at least one user")
+		}
+		if len(target.UserIDs) > maxBroadcastUserIDs {
+			return target, utils.NewValidationFailedError(fmt.Sprintf("target can list at most %d users", maxBroadcastUserIDs))
+		}
+
+		// Sorted, so the worker can resume after the last user it reached
+		seen := make(map[string]bool, len(target.UserIDs))
+		userIDs := make([]string, 0, len(target.UserIDs))
+		for _, userID := range target.UserIDs {
+			if !primitive.IsValidObjectID(userID) {
+				return target, utils.NewValidationFailedError(fmt.Sprintf("invalid user ID %q", userID))
+			}
+			userID = strings.ToLower(userID)
+			if !seen[userID] {
+				seen[userID] = true
+				userIDs = append(userIDs, userID)
+			}
+		}
+		sort.Strings(userIDs)
+
+		return models.BroadcastTarget{Type: target.Type, UserIDs: userIDs}, nil
+	}
+
+	return target, utils.NewValidationFailedError("target type must be all, circle or users")
+}
+
+func validateBroadcastTemplate(template models.BroadcastTemplate) (models.BroadcastTemplate, error) {
+	template.Title = strings.TrimSpace(template.Title)
+	template.Message = strings.TrimSpace(template.Message)
+
+	if template.Title == "" || template.Message == "" {
+		return template, utils.NewValidationFailedError("notification needs a title and a message")
+	}
+	if len(template.Title) > 200 || len(template.Message) > 2000 {
+		return template, utils.NewValidationFailedError("notification title or message is too long")
+	}
+	if strings.TrimSpace(template.Type) == "" {
+		return template, utils.NewValidationFailedError("notification type is required")
+	}
+	if err := utils.ValidateNotificationTemplate(template.Title, broadcastTemplateVariables); err != nil {
+		return template, err
+	}
+	if err := utils.ValidateNotificationTemplate(template.Message, broadcastTemplateVariables); err != nil {
+		return template, err
+	}
+
+	if template.Priority == "" {
+		template.Priority = "normal"
+	}
+	if !notificationPriorities[template.Priority] {
+		return template, utils.NewValidationFailedError(fmt.Sprintf("unknown priority %q", template.Priority))
+	}
+
+	// Custom channels belong to single users, so only the built in ones
+	if len(template.DeliveryChannels) == 0 {
+		template.DeliveryChannels = []string{models.DeliveryChannelPush, models.DeliveryChannelInApp}
+	}
+	for _, channel := range template.DeliveryChannels {
+		switch channel {
+		case models.DeliveryChannelPush, models.DeliveryChannelEmail, models.DeliveryChannelSMS, models.DeliveryChannelInApp:
+		default:
+			return template, utils.NewValidationFailedError(fmt.Sprintf("unknown channel %q", channel))
+		}
+	}
+
+	return template, nil
+}
+
+func (ns *NotificationService) GetBroadcast(ctx context.Context, broadcastID string) (*models.NotificationBroadcast, error) {
+	broadcast, err := ns.notificationRepo.GetBroadcast(ctx, broadcastID)
+	if err != nil {
+		return nil, err
+	}
+	return withBroadcastStatsMaps(broadcast), nil
+}
+
+// CancelBroadcast stops a broadcast. Users already delivered to keep their
+// notification.
+func (ns *NotificationService) CancelBroadcast(ctx context.Context, broadcastID string) (*models.NotificationBroadcast, error) {
+	cancelled, err := ns.notificationRepo.CancelBroadcast(ctx, broadcastID)
+	if err != nil {
+		return nil, err
+	}
+	if !cancelled {
+		if _, err := ns.notificationRepo.GetBroadcast(ctx, broadcastID); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("broadcast already finished")
+	}
+
+	return ns.GetBroadcast(ctx, broadcastID)
+}
+
+func (ns *NotificationService) GetBroadcastDeliveries(ctx context.Context, req models.GetBroadcastDeliveriesRequest) (*models.BroadcastDeliveries, error) {
+	switch req.Status {
+	case "", models.BroadcastDeliveryDelivered, models.BroadcastDeliverySkipped, models.BroadcastDeliveryFailed:
+	default:
+		return nil, utils.NewValidationFailedError("status must be delivered, skipped or failed")
+	}
+
+	broadcast, err := ns.notificationRepo.GetBroadcast(ctx, req.BroadcastID)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Page, req.PageSize = models.NormalizePageWithDefault(req.Page, req.PageSize, models.DefaultHistoryPageSize)
+
+	deliveries, total, err := ns.notificationRepo.GetBroadcastDeliveries(ctx, broadcast.ID, req.Status, req.Page, req.PageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	totalPages := int((total + int64(req.PageSize) - 1) / int64(req.PageSize))
+
+	return &models.BroadcastDeliveries{
+		Deliveries: deliveries,
+		Page:       req.Page,
+		PageSize:   req.PageSize,
+		Total:      total,
+		TotalPages: totalPages,
+		HasNext:    req.Page < totalPages,
+		HasPrev:    req.Page > 1,
+	}, nil
+}
+
+func withBroadcastStatsMaps(broadcast *models.NotificationBroadcast) *models.NotificationBroadcast {
+	if broadcast.Stats.SkippedByReason == nil {
+		broadcast.Stats.SkippedByReason = map[string]int64{}
+	}
+	if broadcast.Stats.Channels == nil {
+		broadcast.Stats.Channels = map[string]models.BroadcastChannelStats{}
+	}
+	return broadcast
+}
+
+// ProcessDueBroadcasts sends the broadcasts that are due, one at a time,
+// until there are none left or ctx is done
+func (ns *NotificationService) ProcessDueBroadcasts(ctx context.Context) error {
+	for ctx.Err() == nil {
+		broadcast, err := ns.notificationRepo.ClaimDueBroadcast(ctx, time.Now().Add(-broadcastStaleAfter))
+		if err != nil {
+			return err
+		}
+		if broadcast == nil {
+			return nil
+		}
+
+		ns.runBroadcast(ctx, broadcast)
+	}
+	return nil
+}
+
+func (ns *NotificationService) runBroadcast(ctx context.Context, broadcast *models.NotificationBroadcast) {
+	if broadcast.StartedAt == nil {
+		if err := ns.startBroadcast(ctx, broadcast); err != nil {
+			logrus.Errorf("Broadcast %s failed to start: %v", broadcast.ID.Hex(), err)
+			ns.notificationRepo.UpdateRunningBroadcast(ctx, broadcast.ID, bson.M{
+				"status":       models.BroadcastStatusFailed,
+				"error_msg":    err.Error(),
+				"completed_at": time.Now(),
+			})
+			return
+		}
+		logrus.Infof("Starting broadcast %s to %d users", broadcast.ID.Hex(), broadcast.Stats.Targeted)
+	} else {
+		logrus.Infof("Resuming broadcast %s after user %s", broadcast.ID.Hex(), broadcast.Cursor)
+	}
+
+	for ctx.Err() == nil {
+		userIDs, err := ns.nextBroadcastBatch(ctx, broadcast)
+		if err != nil {
+			// Left running; it is resumed once its heartbeat goes stale
+			logrus.Errorf("Broadcast %s failed to load users: %v", broadcast.ID.Hex(), err)
+			return
+		}
+
+		if len(userIDs) == 0 {
+			ns.notificationRepo.UpdateRunningBroadcast(ctx, broadcast.ID, bson.M{
+				"status":       models.BroadcastStatusCompleted,
+				"completed_at": time.Now(),
+			})
+			logrus.Infof("Broadcast %s completed", broadcast.ID.Hex())
+			return
+		}
+
+		ns.deliverBroadcastBatch(ctx, broadcast, userIDs)
+
+		broadcast.Cursor = userIDs[len(userIDs)-1]
+		running, err := ns.notificationRepo.UpdateRunningBroadcast(ctx, broadcast.ID, bson.M{"cursor": broadcast.Cursor})
+		if err != nil {
+			logrus.Errorf("Broadcast %s failed to save progress: %v", broadcast.ID.Hex(), err)
+			return
+		}
+		if !running {
+			logrus.Infof("Broadcast %s was cancelled", broadcast.ID.Hex())
+			return
+		}
+	}
+}
+
+// startBroadcast fixes who the broadcast goes to. Circle members are taken
+// when it starts rather than when it was scheduled.
+func (ns *NotificationService) startBroadcast(ctx context.Context, broadcast *models.NotificationBroadcast) error {
+	now := time.Now()
+	fields := bson.M{"started_at": now}
+
+	switch broadcast.Target.Type {
+	case models.BroadcastTargetAll:
+		count, err := ns.userRepo.CountActiveUsers(ctx)
+		if err != nil {
+			return err
+		}
+		broadcast.Stats.Targeted = count
+
+	case models.BroadcastTargetCircle:
+		circle, err := ns.circleRepo.GetByID(ctx, broadcast.Target.CircleID)
+		if err != nil {
+			return err
+		}
+		recipients := make([]string, 0, len(circle.Members))
+		for _, member := range circle.Members {
+			if member.Status != "" && member.Status != "active" {
+				continue
+			}
+			recipients = append(recipients, member.UserID.Hex())
+		}
+		sort.Strings(recipients)
+		broadcast.Recipients = recipients
+		broadcast.Stats.Targeted = int64(len(recipients))
+		fields["recipients"] = recipients
+
+	case models.BroadcastTargetUsers:
+		broadcast.Recipients = broadcast.Target.UserIDs
+		broadcast.Stats.Targeted = int64(len(broadcast.Target.UserIDs))
+	}
+
+	fields["stats.targeted"] = broadcast.Stats.Targeted
+	broadcast.StartedAt = &now
+
+	running, err := ns.notificationRepo.UpdateRunningBroadcast(ctx, broadcast.ID, fields)
+	if err != nil {
+		return err
+	}
+	if !running {
+		return errors.New("broadcast is no longer running")
+	}
+	return nil
+}
+
+// nextBroadcastBatch returns the next users after the broadcast's cursor
+func (ns *NotificationService) nextBroadcastBatch(ctx context.Context, broadcast *models.NotificationBroadcast) ([]string, error) {
+	if broadcast.Target.Type == models.BroadcastTargetAll {
+		return ns.userRepo.GetActiveUserIDsAfter(ctx, broadcast.Cursor, broadcastBatchSize)
+	}
+
+	recipients := broadcast.Recipients
+	if len(recipients) == 0 {
+		recipients = broadcast.Target.UserIDs
+	}
+
+	start := sort.SearchStrings(recipients, broadcast.Cursor)
+	if start < len(recipients) && recipients[start] == broadcast.Cursor {
+		start++
+	}
+	end := start + broadcastBatchSize
+	if end > len(recipients) {
+		end = len(recipients)
+	}
+	return recipients[start:end], nil
+}
+
+func (ns *NotificationService) deliverBroadcastBatch(ctx context.Context, broadcast *models.NotificationBroadcast, userIDs []string) {
+	// Users reached before the broadcast was interrupted
+	done, err := ns.notificationRepo.GetBroadcastRecipientsDone(ctx, broadcast.ID, userIDs)
+	if err != nil {
+		logrus.Warnf("Failed to check broadcast %s deliveries: %v", broadcast.ID.Hex(), err)
+		done = map[string]bool{}
+	}
+
+	users, err := ns.userRepo.GetUsersByIDs(ctx, userIDs)
+	if err != nil {
+		logrus.Errorf("Failed to get users for broadcast %s: %v", broadcast.ID.Hex(), err)
+		return
+	}
+	usersByID := make(map[string]*models.User, len(users))
+	for i := range users {
+		usersByID[users[i].ID.Hex()] = &users[i]
+	}
+
+	deactivatedIDs, err := ns.userRepo.GetDeactivatedUserIDs(ctx, userIDs)
+	if err != nil {
+		logrus.Warnf("Failed to check deactivated broadcast recipients: %v", err)
+	}
+
+	for _, userID := range userIDs {
+		if done[userID] {
+			continue
+		}
+
+		delivery := ns.deliverBroadcast(ctx, broadcast, userID, usersByID[userID], deactivatedIDs[userID])
+		if err := ns.notificationRepo.RecordBroadcastDelivery(ctx, delivery, broadcastStatsIncrement(delivery)); err != nil {
+			logrus.Errorf("Failed to record broadcast %s delivery to %s: %v", broadcast.ID.Hex(), userID, err)
+		}
+	}
+}
+
+// deliverBroadcast sends the broadcast to one user the way any notification
+// is sent to them: their preferences, quiet hours and routing rules apply
+func (ns *NotificationService) deliverBroadcast(ctx context.Context, broadcast *models.NotificationBroadcast, userID string, user *models.User, deactivated bool) *models.BroadcastDelivery {
+	delivery := &models.BroadcastDelivery{
+		BroadcastID: broadcast.ID,
+		UserID:      userID,
+		Status:      models.BroadcastDeliverySkipped,
+	}
+	template := broadcast.Template
+
+	if user == nil {
+		delivery.Reason = broadcastSkipInactive
+		return delivery
+	}
+	if deactivated {
+		delivery.Reason = broadcastSkipDeactivated
+		return delivery
+	}
+
+	prefs, err := ns.notificationRepo.GetNotificationPreferences(ctx, userID)
+	if err != nil && err.Error() != "not found" {
+		delivery.Status = models.BroadcastDeliveryFailed
+		delivery.Reason = "preferences unavailable"
+		return delivery
+	}
+	if prefs != nil {
+		if !prefs.GlobalEnabled {
+			delivery.Reason = broadcastSkipDisabled
+			return delivery
+		}
+		if typePref, exists := prefs.TypePreferences[template.Type]; exists && !typePref.Enabled {
+			delivery.Reason = broadcastSkipTypeDisabled
+			return delivery
+		}
+	}
+
+	channels := template.DeliveryChannels
+	resolution, err := ns.ResolveDeliveryChannels(ctx, userID, models.ResolveChannelsRequest{
+		Type:             template.Type,
+		Priority:         template.Priority,
+		DeliveryChannels: channels,
+	})
+	if err != nil {
+		logrus.Warnf("Failed to resolve delivery channels for user %s: %v", userID, err)
+	} else {
+		channels = resolution.Channels
+	}
+
+	channels = filterEnabledChannels(channels, prefs)
+	if len(channels) == 0 {
+		delivery.Reason = broadcastSkipNoChannels
+		return delivery
+	}
+
+	// During quiet hours only the inbox gets it, unless it is urgent
+	if template.Priority != "urgent" && ns.inQuietHours(ctx, userID) {
+		channels = inAppOnly(channels)
+		if len(channels) == 0 {
+			delivery.Reason = broadcastSkipQuietHours
+			return delivery
+		}
+	}
+
+	if !ns.allowBroadcast(ctx, userID) {
+		delivery.Reason = broadcastSkipRateLimited
+		return delivery
+	}
+
+	values := map[string]string{
+		"firstName": user.FirstName,
+		"lastName":  user.LastName,
+		"fullName":  strings.TrimSpace(user.FirstName + " " + user.LastName),
+	}
+	notification := &models.Notification{
+		ID:               primitive.NewObjectID(),
+		UserID:           userID,
+		Title:            utils.RenderNotificationTemplate(template.Title, values),
+		Message:          utils.RenderNotificationTemplate(template.Message, values),
+		Type:             template.Type,
+		Priority:         template.Priority,
+		Category:         template.Category,
+		Status:           "unread",
+		Data:             template.Data,
+		ImageURL:         template.ImageURL,
+		DeepLink:         template.DeepLink,
+		DeliveryChannels: channels,
+		Metadata:         map[string]interface{}{"broadcast_id": broadcast.ID.Hex()},
+	}
+
+	if err := ns.notificationRepo.Create(ctx, notification); err != nil {
+		logrus.Errorf("Failed to save broadcast notification for user %s: %v", userID, err)
+		delivery.Status = models.BroadcastDeliveryFailed
+		delivery.Reason = "notification could not be saved"
+		return delivery
+	}
+
+	sent, failed := ns.deliverOnChannels(ctx, notification)
+	ns.updateBadgeCount(ctx, userID)
+
+	delivery.NotificationID = notification.ID.Hex()
+	delivery.Channels = sent
+	delivery.FailedChannels = failed
+	if len(sent) > 0 {
+		delivery.Status = models.BroadcastDeliveryDelivered
+	} else {
+		delivery.Status = models.BroadcastDeliveryFailed
+		delivery.Reason = "every channel failed"
+	}
+	return delivery
+}
+
+// filterEnabledChannels drops the channels the user turned off. Users who
+// never saved preferences get everything.
+func filterEnabledChannels(channels []string, prefs *models.NotificationPreferences) []string {
+	if prefs == nil {
+		return channels
+	}
+
+	enabled := map[string]bool{
+		models.DeliveryChannelPush:  prefs.PushEnabled,
+		models.DeliveryChannelEmail: prefs.EmailEnabled,
+		models.DeliveryChannelSMS:   prefs.SMSEnabled,
+		models.DeliveryChannelInApp: prefs.InAppEnabled,
+	}
+
+	filtered := []string{}
+	for _, channel := range channels {
+		if on, builtIn := enabled[channel]; !builtIn || on {
+			filtered = append(filtered, channel)
+		}
+	}
+	return filtered
+}
+
+func inAppOnly(channels []string) []string {
+	for _, channel := range channels {
+		if channel == models.DeliveryChannelInApp {
+			return []string{models.DeliveryChannelInApp}
+		}
+	}
+	return nil
+}
+
+func (ns *NotificationService) inQuietHours(ctx context.Context, userID string) bool {
+	settings, err := ns.notificationRepo.GetPushSettings(ctx, userID)
+	if err != nil || settings == nil {
+		return false
+	}
+	return settings.QuietHours.ActiveAt(time.Now())
+}
+
+// allowBroadcast counts a broadcast against the user's daily limit. Without
+// Redis there is no limit.
+func (ns *NotificationService) allowBroadcast(ctx context.Context, userID string) bool {
+	if ns.redis == nil {
+		return true
+	}
+
+	key := fmt.Sprintf("notifications:broadcasts:%s:%s", userID, time.Now().UTC().Format("2006-01-02"))
+	count, err := ns.redis.Incr(ctx, key).Result()
+	if err != nil {
+		logrus.Warnf("Failed to check broadcast limit of user %s: %v", userID, err)
+		return true
+	}
+	if count == 1 {
+		ns.redis.Expire(ctx, key, 24*time.Hour)
+	}
+	return count <= maxBroadcastsPerUserPerDay
+}
+
+func broadcastStatsIncrement(delivery *models.BroadcastDelivery) bson.M {
+	inc := bson.M{
+		"stats.processed":          1,
+		"stats." + delivery.Status: 1,
+	}
+	if delivery.Status == models.BroadcastDeliverySkipped {
+		inc["stats.skipped_by_reason."+delivery.Reason] = 1
+	}
+	for _, channel := range delivery.Channels {
+		inc["stats.channels."+channel+".sent"] = 1
+	}
+	for _, channel := range delivery.FailedChannels {
+		inc["stats.channels."+channel+".failed"] = 1
+	}
+	return inc
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"ftrack/models"
+	"ftrack/testharness"
+	"ftrack/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestValidateBulkFilter(t *testing.T) {
+	tests := []struct {
+		name string
+		req  models.BulkNotificationFilterRequest
+		err  string
+	}{
+		{"type", models.BulkNotificationFilterRequest{Types: []string{"message"}}, ""},
+		{"read and old", models.BulkNotificationFilterRequest{Status: "read", OlderThanDays: 30}, ""},
+		{"circle", models.BulkNotificationFilterRequest{CircleID: primitive.NewObjectID().Hex()}, ""},
+		{"no criteria", models.BulkNotificationFilterRequest{DryRun: true}, "at least one of types, status, circle_id or older_than_days is required"},
+		{"too many types", models.BulkNotificationFilterRequest{Types: make([]string, 51)}, "at most 50 types can be given"},
+		{"unknown status", models.BulkNotificationFilterRequest{Status: "deleted"}, "status must be read, unread or archived"},
+		{"bad circle", models.BulkNotificationFilterRequest{CircleID: "family"}, "invalid circle_id"},
+		{"negative age", models.BulkNotificationFilterRequest{Types: []string{"message"}, OlderThanDays: -1}, "older_than_days must be positive"},
+	}
+	for _, tt := range tests {
+		if reason := utils.ValidationFailureReason(validateBulkFilter(tt.req)); reason != tt.err {
+			t.Errorf("%s: reason %q, want %q", tt.name, reason, tt.err)
+		}
+	}
+}
+
+func TestNotificationsByFilter(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ns, repo := newTestNotificationService(t, env)
+	ctx := context.Background()
+
+	user, other := env.Factory.User(), env.Factory.User()
+	userID := user.ID.Hex()
+	family, friends := primitive.NewObjectID().Hex(), primitive.NewObjectID().Hex()
+	day := 24 * time.Hour
+
+	seed := func(owner *models.User, title, notificationType, status, circleID string, archived bool, age time.Duration) {
+		seedNotification(t, env, repo, models.Notification{
+			UserID: owner.ID.Hex(), Title: title, Type: notificationType, Status: status, CircleID: circleID, IsArchived: archived,
+		}, age)
+	}
+	seed(user, "old read chat", "message", "read", family, false, 40*day)
+	seed(user, "old unread chat", "message", "unread", family, false, 40*day)
+	seed(user, "archived chat", "message", "read", family, true, 40*day)
+	seed(user, "recent review", "place_review", "read", friends, false, day)
+	seed(user, "old review", "place_review", "read", "", false, 60*day)
+	seed(other, "someone else's chat", "message", "read", family, false, 40*day)
+
+	count := func(filter bson.M) int64 {
+		t.Helper()
+		n, err := env.DB.Collection("notifications").CountDocuments(ctx, filter)
+		if err != nil {
+			t.Fatalf("counting notifications: %v", err)
+		}
+		return n
+	}
+
+	// Dry runs count what each filter selects, only among the caller's, and
+	// change nothing
+	tests := []struct {
+		name    string
+		req     models.BulkNotificationFilterRequest
+		deleted int64
+	}{
+		{"read and older than 30 days", models.BulkNotificationFilterRequest{Status: "read", OlderThanDays: 30}, 3},
+		{"reviews", models.BulkNotificationFilterRequest{Types: []string{"place_review"}}, 2},
+		{"family circle", models.BulkNotificationFilterRequest{CircleID: family}, 3},
+		{"unread in the family circle", models.BulkNotificationFilterRequest{CircleID: family, Status: "unread"}, 1},
+		{"archived", models.BulkNotificationFilterRequest{Status: "archived"}, 1},
+		{"old read chats", models.BulkNotificationFilterRequest{Types: []string{"message", "emergency"}, Status: "read", OlderThanDays: 30}, 2},
+		{"nothing that old", models.BulkNotificationFilterRequest{OlderThanDays: 90}, 0},
+	}
+	for _, tt := range tests {
+		tt.req.DryRun = true
+		result, err := ns.DeleteNotificationsByFilter(ctx, userID, tt.req)
+		if err != nil {
+			t.Fatalf("%s: DeleteNotificationsByFilter: %v", tt.name, err)
+		}
+		if result.Affected != tt.deleted || !result.DryRun {
+			t.Errorf("%s: dry run would delete %d, want %d", tt.name, result.Affected, tt.deleted)
+		}
+	}
+	if n := count(bson.M{}); n != 6 {
+		t.Fatalf("%d notifications after dry runs, want all 6", n)
+	}
+
+	// Archiving skips what's already archived, so the dry run matches
+	archive := models.BulkNotificationFilterRequest{CircleID: family, DryRun: true}
+	for _, want := range []int64{2, 2, 0} {
+		result, err := ns.ArchiveNotificationsByFilter(ctx, userID, archive)
+		if err != nil {
+			t.Fatalf("ArchiveNotificationsByFilter: %v", err)
+		}
+		if result.Affected != want {
+			t.Errorf("archiving the family circle (dry run %v) affected %d, want %d", archive.DryRun, result.Affected, want)
+		}
+		archive.DryRun = false
+	}
+	if n := count(bson.M{"user_id": other.ID.Hex(), "is_archived": true}); n != 0 {
+		t.Errorf("%d of someone else's notifications archived", n)
+	}
+
+	result, err := ns.DeleteNotificationsByFilter(ctx, userID, models.BulkNotificationFilterRequest{Status: "read", OlderThanDays: 30})
+	if err != nil {
+		t.Fatalf("DeleteNotificationsByFilter: %v", err)
+	}
+	if result.Affected != 3 || result.DryRun {
+		t.Errorf("deleted %d, want 3", result.Affected)
+	}
+	if n := count(bson.M{"user_id": userID}); n != 2 {
+		t.Errorf("%d notifications left, want the old unread chat and the recent review", n)
+	}
+	if n := count(bson.M{"user_id": other.ID.Hex()}); n != 1 {
+		t.Errorf("someone else's notification deleted")
+	}
+
+	if _, err := ns.DeleteNotificationsByFilter(ctx, userID, models.BulkNotificationFilterRequest{}); err == nil || err.Error() != "validation failed" {
+		t.Errorf("deleting without criteria error = %v, want validation failed", err)
+	}
+}
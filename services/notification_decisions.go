@@ -0,0 +1,260 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"ftrack/models"
+	"ftrack/utils"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Channels an explained notification is assumed to be sent on when its type
+// doesn't say
+var defaultExplainChannels = []string{models.DeliveryChannelPush, models.DeliveryChannelInApp}
+
+// deliveryInput is a notification for one recipient, before their settings
+// are applied
+type deliveryInput struct {
+	UserID   string
+	Type     string
+	Priority string
+	CircleID string
+	Channels []string
+	At       time.Time
+
+	// Worked out for all recipients at once by the sender
+	Blocked     bool
+	Deactivated bool
+
+	Immediate bool // skips batching
+}
+
+// resolveDelivery decides how a notification reaches the user, consulting
+// each layer of their settings in turn: the recipient, their global and
+// per-type switches, the circle, do not disturb, routing rules, channel
+// switches, send-time optimization, quiet hours and batching. It sends
+// nothing, so it both drives delivery and explains it.
+//
+// Urgent and critical notifications get past mutes, do not disturb and quiet
+// hours.
+func (ns *NotificationService) resolveDelivery(ctx context.Context, in deliveryInput) (*models.DeliveryDecision, error) {
+	priority := in.Priority
+	if priority == "" {
+		priority = "normal"
+	}
+	overrides := priority == "urgent" || priority == "critical"
+
+	decision := &models.DeliveryDecision{
+		UserID:   in.UserID,
+		Type:     in.Type,
+		Priority: priority,
+		CircleID: in.CircleID,
+		At:       in.At,
+		Outcome:  models.DeliveryOutcomeDelivered,
+		Channels: append([]string{}, in.Channels...),
+		Trace:    []models.DecisionStep{},
+	}
+
+	switch {
+	case in.Deactivated:
+		return decision.Suppress(models.DecisionLayerRecipient, "deactivated", "the account is deactivated"), nil
+	case in.Blocked:
+		return decision.Suppress(models.DecisionLayerRecipient, "blocked", "a block is in place with the user it is about"), nil
+	}
+	decision.AddStep(models.DecisionLayerRecipient, "active", models.DecisionEffectNone, "")
+
+	preferences, err := ns.GetNotificationPreferences(ctx, in.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !preferences.GlobalEnabled {
+		if !overrides {
+			return decision.Suppress(models.DecisionLayerGlobal, false, "all notifications are turned off"), nil
+		}
+		decision.AddStep(models.DecisionLayerGlobal, false, models.DecisionEffectBypassed, priority+" notifications are always sent")
+	} else {
+		decision.AddStep(models.DecisionLayerGlobal, true, models.DecisionEffectNone, "")
+	}
+
+	if preference, ok := preferences.TypePreferences[in.Type]; ok && !preference.Enabled {
+		if !overrides {
+			return decision.Suppress(models.DecisionLayerType, false, in.Type+" notifications are turned off"), nil
+		}
+		decision.AddStep(models.DecisionLayerType, false, models.DecisionEffectBypassed, priority+" notifications are always sent")
+	} else {
+		decision.AddStep(models.DecisionLayerType, true, models.DecisionEffectNone, "")
+	}
+
+	if in.CircleID != "" {
+		isMember, err := ns.circleRepo.IsMember(ctx, in.CircleID, in.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if !isMember {
+			return decision.Suppress(models.DecisionLayerCircle, false, "not a member of the circle"), nil
+		}
+		decision.AddStep(models.DecisionLayerCircle, true, models.DecisionEffectNone, "")
+	}
+
+	dnd, err := ns.GetDoNotDisturbStatus(ctx, in.UserID)
+	if err != nil {
+		return nil, err
+	}
+	// Place-driven DND may only cover some types or circles
+	inScope := matchesAny(dnd.Types, in.Type) && (len(dnd.CircleIDs) == 0 || (in.CircleID != "" && matchesAny(dnd.CircleIDs, in.CircleID)))
+	switch {
+	case !dnd.IsActive(in.At):
+		decision.AddStep(models.DecisionLayerDoNotDisturb, false, models.DecisionEffectNone, "")
+	case !inScope:
+		decision.AddStep(models.DecisionLayerDoNotDisturb, false, models.DecisionEffectNone, "not covered by do not disturb at "+dnd.PlaceName)
+	case overrides:
+		decision.AddStep(models.DecisionLayerDoNotDisturb, true, models.DecisionEffectBypassed, priority+" notifications are always sent")
+	case len(dnd.Exceptions) > 0 && matchesAny(dnd.Exceptions, in.Type):
+		decision.AddStep(models.DecisionLayerDoNotDisturb, true, models.DecisionEffectBypassed, in.Type+" is an exception")
+	default:
+		decision.Channels = inAppOnly(decision.Channels)
+		decision.AddStep(models.DecisionLayerDoNotDisturb, true, models.DecisionEffectInAppOnly, "do not disturb is on")
+	}
+
+	resolution, err := ns.ResolveDeliveryChannels(ctx, in.UserID, models.ResolveChannelsRequest{
+		Type:             in.Type,
+		Priority:         priority,
+		DeliveryChannels: decision.Channels,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resolution.RuleID != "" {
+		detail := fmt.Sprintf("rule %q matched", resolution.RuleName)
+		for _, skipped := range resolution.Skipped {
+			detail += fmt.Sprintf("; %s skipped: %s", skipped.Channel, skipped.Reason)
+		}
+		decision.Channels = resolution.Channels
+		decision.AddStep(models.DecisionLayerRouting, resolution, models.DecisionEffectRerouted, detail)
+	} else {
+		decision.AddStep(models.DecisionLayerRouting, nil, models.DecisionEffectNone, "no routing rule matched")
+	}
+
+	enabled := filterEnabledChannels(decision.Channels, preferences)
+	if dropped := channelsMissing(decision.Channels, enabled); len(dropped) > 0 {
+		decision.AddStep(models.DecisionLayerChannels, enabled, models.DecisionEffectFiltered, strings.Join(dropped, ", ")+" turned off")
+	} else {
+		decision.AddStep(models.DecisionLayerChannels, enabled, models.DecisionEffectNone, "")
+	}
+	decision.Channels = enabled
+
+	// A deferred notification is sent at the user's usual reading time,
+	// which already avoids quiet hours
+	if len(decision.Channels) == 0 {
+		decision.Outcome = models.DeliveryOutcomeInboxOnly
+		return decision, nil
+	}
+	pending := &models.Notification{UserID: in.UserID, Type: in.Type, Priority: priority}
+	if deliverAt, deferred := ns.sendTimeDeferral(ctx, pending, in.At); deferred {
+		decision.Outcome = models.DeliveryOutcomeDeferred
+		decision.DeferredUntil = &deliverAt
+		decision.AddStep(models.DecisionLayerSendTime, deliverAt, models.DecisionEffectDeferred, "held for the user's usual reading time")
+		return decision, nil
+	}
+	decision.AddStep(models.DecisionLayerSendTime, nil, models.DecisionEffectNone, "")
+
+	var quietHours models.QuietHours
+	if settings, err := ns.notificationRepo.GetPushSettings(ctx, in.UserID); err == nil && settings != nil {
+		quietHours = settings.QuietHours
+	}
+	window := quietHours.WindowAt(in.At)
+	switch {
+	case window == nil:
+		decision.AddStep(models.DecisionLayerQuietHours, false, models.DecisionEffectNone, "")
+	case overrides:
+		decision.AddStep(models.DecisionLayerQuietHours, true, models.DecisionEffectBypassed, priority+" notifications are always sent")
+	default:
+		decision.Channels = inAppOnly(decision.Channels)
+		decision.AddStep(models.DecisionLayerQuietHours, true, models.DecisionEffectInAppOnly, quietHoursReason(window))
+	}
+
+	// With no channel left it still lands in the inbox, silently
+	if len(decision.Channels) == 0 {
+		decision.Channels = []string{}
+		decision.Outcome = models.DeliveryOutcomeInboxOnly
+		return decision, nil
+	}
+
+	if err := ns.applyBatching(ctx, decision, preferences, in); err != nil {
+		return nil, err
+	}
+
+	return decision, nil
+}
+
+// ExplainNotificationDelivery works out how a notification would reach the
+// user without sending it, with the trace of every layer consulted
+func (ns *NotificationService) ExplainNotificationDelivery(ctx context.Context, userID string, req models.ExplainNotificationRequest) (*models.DeliveryDecision, error) {
+	if strings.TrimSpace(req.Type) == "" {
+		return nil, utils.NewValidationFailedError("type is required")
+	}
+	if req.Priority != "" && !notificationPriorities[req.Priority] && req.Priority != "critical" {
+		return nil, utils.NewValidationFailedError(fmt.Sprintf("unknown priority %q", req.Priority))
+	}
+	if req.CircleID != "" {
+		if _, err := primitive.ObjectIDFromHex(req.CircleID); err != nil {
+			return nil, utils.NewValidationFailedError("invalid circle ID")
+		}
+	}
+	if req.At.IsZero() {
+		req.At = time.Now()
+	}
+
+	channels := req.DeliveryChannels
+	if len(channels) == 0 {
+		channels = ns.typeChannels(ctx, req.Type)
+	}
+
+	decision, err := ns.resolveDelivery(ctx, deliveryInput{
+		UserID:   userID,
+		Type:     req.Type,
+		Priority: req.Priority,
+		CircleID: req.CircleID,
+		Channels: channels,
+		At:       req.At,
+	})
+	if err != nil {
+		return nil, err
+	}
+	decision.DryRun = true
+	decision.CreatedAt = time.Now()
+
+	return decision, nil
+}
+
+// typeChannels returns the channels a notification type is sent on
+func (ns *NotificationService) typeChannels(ctx context.Context, notificationType string) []string {
+	types, _ := ns.GetNotificationTypes(ctx)
+	for _, t := range types {
+		if t.ID == notificationType {
+			return t.Channels
+		}
+	}
+	return defaultExplainChannels
+}
+
+// channelsMissing returns the channels in before that aren't in after
+func channelsMissing(before, after []string) []string {
+	kept := make(map[string]bool, len(after))
+	for _, channel := range after {
+		kept[channel] = true
+	}
+
+	var missing []string
+	for _, channel := range before {
+		if !kept[channel] {
+			missing = append(missing, channel)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,379 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"ftrack/models"
+	"ftrack/utils"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SystemTemplate is the text a system template has for each user: the
+// variant of its running experiment they are in, or its base text
+type SystemTemplate struct {
+	Key        string
+	Base       string
+	Experiment *models.NotificationExperiment
+}
+
+// For returns the template for the user, and the experiment variant it
+// came from, if any
+func (t *SystemTemplate) For(userID string) (string, string) {
+	if t.Experiment != nil {
+		if variant := t.Experiment.VariantFor(userID); variant != nil && variant.StoppedAt == nil {
+			return variant.Template, variant.ID
+		}
+	}
+	return t.Base, ""
+}
+
+// SystemTemplate looks up the text of a system template and its running
+// experiment. Lookup failures fall back to the built in text.
+func (ns *NotificationService) SystemTemplate(ctx context.Context, key string) *SystemTemplate {
+	template := &SystemTemplate{Key: key, Base: models.DefaultSystemTemplates[key]}
+
+	base, err := ns.notificationRepo.GetSystemTemplate(ctx, key)
+	if err != nil {
+		logrus.Warnf("Failed to get system template %s: %v", key, err)
+	} else if base != nil {
+		template.Base = base.Content
+	}
+
+	template.Experiment, err = ns.notificationRepo.GetRunningExperiment(ctx, key)
+	if err != nil {
+		logrus.Warnf("Failed to get experiment on system template %s: %v", key, err)
+	}
+
+	return template
+}
+
+// CreateExperiment validates an experiment and schedules it. The
+// notification worker starts it once it is due.
+func (ns *NotificationService) CreateExperiment(ctx context.Context, adminID string, req models.CreateNotificationExperimentRequest) (*models.NotificationExperiment, error) {
+	validator := utils.NewValidationService()
+	if validationErrors := validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	if _, ok := models.DefaultSystemTemplates[req.Template]; !ok {
+		return nil, utils.NewValidationFailedError(fmt.Sprintf("unknown system template %q", req.Template))
+	}
+	if len(req.Variants) > models.MaxExperimentVariants {
+		return nil, utils.NewValidationFailedError(fmt.Sprintf("an experiment can have at most %d variants", models.MaxExperimentVariants))
+	}
+
+	seen := make(map[string]bool, len(req.Variants))
+	variants := make([]models.NotificationVariant, 0, len(req.Variants))
+	for i, variantReq := range req.Variants {
+		template := strings.TrimSpace(variantReq.Template)
+		if err := utils.ValidateNotificationTemplate(template, models.PlaceNotificationVariables); err != nil {
+			return nil, err
+		}
+		if seen[template] {
+			return nil, utils.NewValidationFailedError("variants must have different text")
+		}
+		seen[template] = true
+
+		variants = append(variants, models.NotificationVariant{
+			ID:       string(rune('a' + i)),
+			Template: template,
+			Weight:   variantReq.Weight,
+		})
+	}
+
+	now := time.Now()
+	startsAt := now
+	if req.StartsAt != nil {
+		if req.StartsAt.Before(now.Add(-time.Minute)) {
+			return nil, utils.NewValidationFailedError("starts_at is in the past")
+		}
+		startsAt = *req.StartsAt
+	}
+	if !req.EndsAt.After(startsAt) {
+		return nil, utils.NewValidationFailedError("ends_at must be after starts_at")
+	}
+
+	overlapping, err := ns.notificationRepo.HasOverlappingExperiment(ctx, req.Template, startsAt, req.EndsAt)
+	if err != nil {
+		return nil, err
+	}
+	if overlapping {
+		return nil, errors.New("experiment overlaps another")
+	}
+
+	guardrailRate := models.DefaultExperimentGuardrailRate
+	if req.GuardrailRate != nil {
+		guardrailRate = *req.GuardrailRate
+	}
+
+	experiment := &models.NotificationExperiment{
+		Name:          strings.TrimSpace(req.Name),
+		Template:      req.Template,
+		Variants:      variants,
+		GuardrailRate: guardrailRate,
+		Status:        models.ExperimentStatusScheduled,
+		StartsAt:      startsAt,
+		EndsAt:        req.EndsAt,
+		CreatedBy:     adminID,
+	}
+	if err := ns.notificationRepo.CreateExperiment(ctx, experiment); err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("Admin %s scheduled notification experiment %s on %s with %d variants",
+		adminID, experiment.ID.Hex(), experiment.Template, len(variants))
+
+	return experiment, nil
+}
+
+func (ns *NotificationService) GetExperiments(ctx context.Context) ([]models.NotificationExperiment, error) {
+	return ns.notificationRepo.GetExperiments(ctx)
+}
+
+func (ns *NotificationService) GetExperiment(ctx context.Context, experimentID string) (*models.NotificationExperiment, error) {
+	return ns.notificationRepo.GetExperiment(ctx, experimentID)
+}
+
+// GetExperimentResults reports the engagement with each variant so far
+func (ns *NotificationService) GetExperimentResults(ctx context.Context, experimentID string) (*models.NotificationExperimentResults, error) {
+	experiment, err := ns.notificationRepo.GetExperiment(ctx, experimentID)
+	if err != nil {
+		return nil, err
+	}
+
+	return ns.experimentResults(ctx, experiment)
+}
+
+func (ns *NotificationService) experimentResults(ctx context.Context, experiment *models.NotificationExperiment) (*models.NotificationExperimentResults, error) {
+	counts, err := ns.notificationRepo.GetExperimentCounts(ctx, experiment.ID)
+	if err != nil {
+		return nil, err
+	}
+	optOuts, err := ns.notificationRepo.GetExperimentOptOutCounts(ctx, experiment.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	results := &models.NotificationExperimentResults{
+		ExperimentID:  experiment.ID.Hex(),
+		Template:      experiment.Template,
+		Status:        experiment.Status,
+		StartsAt:      experiment.StartsAt,
+		EndsAt:        experiment.EndsAt,
+		WinnerVariant: experiment.WinnerVariant,
+		Variants:      make([]models.NotificationVariantResults, 0, len(experiment.Variants)),
+		Generated:     time.Now(),
+	}
+	for _, variant := range experiment.Variants {
+		count := counts[variant.ID]
+		variantResults := models.NotificationVariantResults{
+			VariantID:   variant.ID,
+			Template:    variant.Template,
+			Weight:      variant.Weight,
+			StoppedAt:   variant.StoppedAt,
+			StopReason:  variant.StopReason,
+			Delivered:   count.Delivered,
+			Opened:      count.Opened,
+			ActionTaken: count.ActionTaken,
+			Disabled:    optOuts[variant.ID],
+		}
+		if count.Delivered > 0 {
+			delivered := float64(count.Delivered)
+			variantResults.OpenRate = float64(count.Opened) / delivered
+			variantResults.ActionRate = float64(count.ActionTaken) / delivered
+			variantResults.DisableRate = float64(variantResults.Disabled) / delivered
+		}
+		results.Variants = append(results.Variants, variantResults)
+	}
+	results.Leader = experimentLeader(results.Variants)
+
+	return results, nil
+}
+
+// experimentLeader is the variant still running whose notifications were
+// acted on most often, then opened most often
+func experimentLeader(variants []models.NotificationVariantResults) string {
+	var leader *models.NotificationVariantResults
+	for i := range variants {
+		variant := &variants[i]
+		if variant.StoppedAt != nil || variant.Delivered == 0 {
+			continue
+		}
+		if leader == nil || variant.ActionRate > leader.ActionRate ||
+			(variant.ActionRate == leader.ActionRate && variant.OpenRate > leader.OpenRate) {
+			leader = variant
+		}
+	}
+
+	if leader == nil {
+		return ""
+	}
+	return leader.VariantID
+}
+
+// EndExperiment ends an experiment early, or at its end, and makes the
+// winning variant the base text of its template. Without a winner named
+// the leading one wins; with no variant delivered the text stays as it was.
+func (ns *NotificationService) EndExperiment(ctx context.Context, adminID, experimentID string, req models.EndNotificationExperimentRequest) (*models.NotificationExperimentResults, error) {
+	experiment, err := ns.notificationRepo.GetExperiment(ctx, experimentID)
+	if err != nil {
+		return nil, err
+	}
+	if experiment.Status == models.ExperimentStatusEnded {
+		return nil, errors.New("experiment already ended")
+	}
+
+	if req.WinnerVariant != "" {
+		variant := experiment.Variant(req.WinnerVariant)
+		if variant == nil {
+			return nil, utils.NewValidationFailedError(fmt.Sprintf("unknown variant %q", req.WinnerVariant))
+		}
+		if variant.StoppedAt != nil {
+			return nil, utils.NewValidationFailedError("the guardrail stopped this variant")
+		}
+	}
+
+	if err := ns.endExperiment(ctx, experiment, req.WinnerVariant, adminID); err != nil {
+		return nil, err
+	}
+
+	return ns.GetExperimentResults(ctx, experimentID)
+}
+
+func (ns *NotificationService) endExperiment(ctx context.Context, experiment *models.NotificationExperiment, winner, endedBy string) error {
+	if winner == "" {
+		results, err := ns.experimentResults(ctx, experiment)
+		if err != nil {
+			return err
+		}
+		winner = results.Leader
+	}
+
+	ended, err := ns.notificationRepo.EndExperiment(ctx, experiment.ID, winner)
+	if err != nil {
+		return err
+	}
+	if !ended {
+		return errors.New("experiment already ended")
+	}
+
+	if variant := experiment.Variant(winner); variant != nil {
+		if err := ns.notificationRepo.SetSystemTemplate(ctx, experiment.Template, variant.Template, endedBy); err != nil {
+			return fmt.Errorf("failed to promote variant %s: %w", winner, err)
+		}
+	}
+
+	logrus.Infof("Notification experiment %s on %s ended, winner %q", experiment.ID.Hex(), experiment.Template, winner)
+	return nil
+}
+
+// ProcessExperiments starts experiments once they are due and ends those
+// past their end, promoting their leading variant
+func (ns *NotificationService) ProcessExperiments(ctx context.Context) error {
+	now := time.Now()
+
+	// Expired ones first, so one that is due and expired at once ends
+	// without starting
+	expired, err := ns.notificationRepo.GetExpiredExperiments(ctx, now)
+	if err != nil {
+		return err
+	}
+	for i := range expired {
+		if err := ns.endExperiment(ctx, &expired[i], "", "system"); err != nil && err.Error() != "experiment already ended" {
+			logrus.Errorf("Failed to end notification experiment %s: %v", expired[i].ID.Hex(), err)
+		}
+	}
+
+	started, err := ns.notificationRepo.StartDueExperiments(ctx, now)
+	if err != nil {
+		return err
+	}
+	if started > 0 {
+		logrus.Infof("Started %d notification experiments", started)
+	}
+
+	return nil
+}
+
+// recordExperimentOptOut counts a user turning notifications of the type
+// off, or all of them for "", against the variants they got, and stops a
+// variant that crossed its guardrail
+func (ns *NotificationService) recordExperimentOptOut(ctx context.Context, userID, notificationType string) {
+	if notificationType != "" && notificationType != models.NotificationTypeGeofence {
+		return
+	}
+
+	templates := make([]string, 0, len(models.DefaultSystemTemplates))
+	for key := range models.DefaultSystemTemplates {
+		templates = append(templates, key)
+	}
+	experiments, err := ns.notificationRepo.GetRunningExperiments(ctx, templates)
+	if err != nil {
+		logrus.Warnf("Failed to get running experiments: %v", err)
+		return
+	}
+
+	for i := range experiments {
+		experiment := &experiments[i]
+		variantID, err := ns.notificationRepo.GetExperimentVariantOf(ctx, userID, experiment.ID)
+		if err != nil {
+			logrus.Warnf("Failed to get experiment variant of user %s: %v", userID, err)
+			continue
+		}
+		if variantID == "" {
+			continue
+		}
+
+		recorded, err := ns.notificationRepo.RecordExperimentOptOut(ctx, &models.NotificationExperimentOptOut{
+			ExperimentID: experiment.ID,
+			UserID:       userID,
+			Variant:      variantID,
+		})
+		if err != nil {
+			logrus.Warnf("Failed to record experiment opt-out of user %s: %v", userID, err)
+			continue
+		}
+		if recorded {
+			ns.checkExperimentGuardrail(ctx, experiment, variantID)
+		}
+	}
+}
+
+// checkExperimentGuardrail stops the variant once enough of its users got
+// it and too many of them turned its notifications off
+func (ns *NotificationService) checkExperimentGuardrail(ctx context.Context, experiment *models.NotificationExperiment, variantID string) {
+	counts, err := ns.notificationRepo.GetExperimentCounts(ctx, experiment.ID)
+	if err != nil {
+		logrus.Warnf("Failed to check guardrail of experiment %s: %v", experiment.ID.Hex(), err)
+		return
+	}
+	optOuts, err := ns.notificationRepo.GetExperimentOptOutCounts(ctx, experiment.ID)
+	if err != nil {
+		logrus.Warnf("Failed to check guardrail of experiment %s: %v", experiment.ID.Hex(), err)
+		return
+	}
+
+	delivered := counts[variantID].Delivered
+	if delivered < models.ExperimentGuardrailMinDelivered {
+		return
+	}
+	rate := float64(optOuts[variantID]) / float64(delivered)
+	if rate <= experiment.GuardrailRate {
+		return
+	}
+
+	reason := fmt.Sprintf("%.1f%% of its users turned the notifications off, over the %.1f%% guardrail", rate*100, experiment.GuardrailRate*100)
+	stopped, err := ns.notificationRepo.StopExperimentVariant(ctx, experiment.ID, variantID, reason)
+	if err != nil {
+		logrus.Errorf("Failed to stop variant %s of experiment %s: %v", variantID, experiment.ID.Hex(), err)
+		return
+	}
+	if stopped {
+		logrus.Warnf("Stopped variant %s of notification experiment %s: %s", variantID, experiment.ID.Hex(), reason)
+	}
+}
@@ -0,0 +1,214 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"ftrack/models"
+	"ftrack/repositories"
+	"ftrack/testharness"
+	"ftrack/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func newTestNotificationService(t *testing.T, env *testharness.Env) (*NotificationService, *repositories.NotificationRepository) {
+	notificationRepo := repositories.NewNotificationRepository(env.DB)
+	ns := NewNotificationService(notificationRepo, env.Repos.User, env.Repos.Circle, env.Repos.Block, testharness.Redis(t), nil, nil, nil, nil)
+	return ns, notificationRepo
+}
+
+// seedNotification stores a notification in the user's inbox, created age
+// ago
+func seedNotification(t *testing.T, env *testharness.Env, repo *repositories.NotificationRepository, notification models.Notification, age time.Duration) *models.Notification {
+	t.Helper()
+	ctx := context.Background()
+	if notification.Status == "" {
+		notification.Status = "unread"
+	}
+	if notification.Priority == "" {
+		notification.Priority = "normal"
+	}
+	if err := repo.Create(ctx, &notification); err != nil {
+		t.Fatalf("creating notification: %v", err)
+	}
+	if _, err := env.DB.Collection("notifications").UpdateOne(ctx, bson.M{"user_id": notification.UserID, "title": notification.Title},
+		bson.M{"$set": bson.M{"created_at": time.Now().Add(-age)}}); err != nil {
+		t.Fatalf("dating notification: %v", err)
+	}
+	return &notification
+}
+
+// groupSummary is each group as key=total/unread:preview titles
+func groupSummary(grouped *models.GroupedNotifications) string {
+	var groups []string
+	for _, group := range grouped.Groups {
+		var titles []string
+		for _, notification := range group.Notifications {
+			titles = append(titles, notification.Title)
+		}
+		key := group.Key
+		if group.Label != "" {
+			key = group.Label
+		}
+		groups = append(groups, fmt.Sprintf("%s=%d/%d:%s", key, group.Total, group.Unread, strings.Join(titles, "+")))
+	}
+	return strings.Join(groups, " ")
+}
+
+func TestNotificationInboxGrouped(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ns, repo := newTestNotificationService(t, env)
+	ctx := context.Background()
+
+	user, other := env.Factory.User(), env.Factory.User()
+	family := env.Factory.Circle(user, []*models.User{other}, func(circle *models.Circle) { circle.Name = "Family" })
+	work := env.Factory.Circle(user, nil, func(circle *models.Circle) { circle.Name = "Work" })
+	userID, familyID, workID := user.ID.Hex(), family.ID.Hex(), work.ID.Hex()
+
+	seed := func(title, circleID, notificationType, priority, status string, age time.Duration) {
+		seedNotification(t, env, repo, models.Notification{
+			UserID: userID, Title: title, CircleID: circleID, Type: notificationType, Priority: priority, Status: status,
+		}, age)
+	}
+	seed("f1", familyID, "message", "", "", time.Hour)
+	seed("f2", familyID, "message", "", "read", 2*time.Hour)
+	seed("f3", familyID, "place_checkin", "high", "", 3*time.Hour)
+	seed("w1", workID, "message", "", "", 10*time.Minute)
+	seed("w2", workID, "geofence_enter", "", "", 4*time.Hour)
+	seed("s1", "", "system", "", "", 30*time.Minute)
+	seedNotification(t, env, repo, models.Notification{UserID: userID, Title: "archived", CircleID: familyID, Type: "message", Status: "unread", IsArchived: true}, time.Minute)
+	seedNotification(t, env, repo, models.Notification{UserID: other.ID.Hex(), Title: "theirs", CircleID: familyID, Type: "message"}, time.Minute)
+
+	get := func(req models.GetNotificationsRequest) *models.GroupedNotifications {
+		t.Helper()
+		req.UserID = userID
+		if req.Page == 0 {
+			req.Page, req.PageSize = 1, 10
+		}
+		grouped, err := ns.GetGroupedNotifications(ctx, req)
+		if err != nil {
+			t.Fatalf("GetGroupedNotifications: %v", err)
+		}
+		return grouped
+	}
+
+	tests := []struct {
+		name   string
+		req    models.GetNotificationsRequest
+		groups string
+		total  int64
+		unread int64
+	}{
+		{"by circle, first page", models.GetNotificationsRequest{GroupBy: "circle", Page: 1, PageSize: 2},
+			"Work=2/2:w1+w2 =1/1:s1", 6, 5},
+		{"by circle, second page", models.GetNotificationsRequest{GroupBy: "circle", Page: 2, PageSize: 2},
+			"Family=3/2:f1+f2+f3", 6, 5},
+		{"by type", models.GetNotificationsRequest{GroupBy: "type"},
+			"message=3/2:w1+f1+f2 system=1/1:s1 place_checkin=1/1:f3 geofence_enter=1/1:w2", 6, 5},
+		{"by type in a circle", models.GetNotificationsRequest{GroupBy: "type", CircleID: familyID},
+			"message=2/1:f1+f2 place_checkin=1/1:f3", 3, 2},
+		{"by circle, high priority", models.GetNotificationsRequest{GroupBy: "circle", Priority: "high"},
+			"Family=1/1:f3", 1, 1},
+		{"unread by circle", models.GetNotificationsRequest{GroupBy: "circle", Status: "unread"},
+			"Work=2/2:w1+w2 =1/1:s1 Family=2/2:f1+f3", 5, 5},
+	}
+	for _, tt := range tests {
+		grouped := get(tt.req)
+		if got := groupSummary(grouped); got != tt.groups || grouped.Total != tt.total || grouped.Unread != tt.unread {
+			t.Errorf("%s: groups %s with %d/%d, want %s with %d/%d", tt.name, got, grouped.Total, grouped.Unread, tt.groups, tt.total, tt.unread)
+		}
+	}
+
+	first := get(models.GetNotificationsRequest{GroupBy: "circle", Page: 1, PageSize: 2})
+	if first.TotalGroups != 3 || first.TotalPages != 2 || !first.HasNext || first.HasPrev {
+		t.Errorf("first page of groups = %+v, want 3 groups on 2 pages", first)
+	}
+
+	if _, err := ns.GetGroupedNotifications(ctx, models.GetNotificationsRequest{UserID: userID, GroupBy: "priority", Page: 1, PageSize: 10}); utils.ValidationFailureReason(err) != "groupBy must be circle or type" {
+		t.Errorf("grouping by priority error = %v", err)
+	}
+}
+
+func TestNotificationInboxGroupPreview(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ns, repo := newTestNotificationService(t, env)
+
+	user := env.Factory.User()
+	circle := env.Factory.Circle(user, nil)
+	for i := 0; i < models.NotificationGroupPreviewSize+2; i++ {
+		seedNotification(t, env, repo, models.Notification{UserID: user.ID.Hex(), Title: fmt.Sprintf("n%d", i), CircleID: circle.ID.Hex(), Type: "message"}, time.Duration(i)*time.Minute)
+	}
+
+	grouped, err := ns.GetGroupedNotifications(context.Background(), models.GetNotificationsRequest{UserID: user.ID.Hex(), GroupBy: "circle", Page: 1, PageSize: 10})
+	if err != nil {
+		t.Fatalf("GetGroupedNotifications: %v", err)
+	}
+	if len(grouped.Groups) != 1 || grouped.Groups[0].Total != 7 || len(grouped.Groups[0].Notifications) != models.NotificationGroupPreviewSize ||
+		grouped.Groups[0].Notifications[0].Title != "n0" {
+		t.Errorf("groups = %s, want all 7 counted and the newest 5 shown", groupSummary(grouped))
+	}
+}
+
+func TestNotificationInboxMarkGroupRead(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ns, repo := newTestNotificationService(t, env)
+	ctx := context.Background()
+
+	user, other := env.Factory.User(), env.Factory.User()
+	family := env.Factory.Circle(user, []*models.User{other}, func(circle *models.Circle) { circle.Name = "Family" })
+	userID, familyID := user.ID.Hex(), family.ID.Hex()
+
+	seed := func(title, circleID, notificationType, priority string) {
+		seedNotification(t, env, repo, models.Notification{UserID: userID, Title: title, CircleID: circleID, Type: notificationType, Priority: priority}, time.Minute)
+	}
+	seed("f1", familyID, "message", "")
+	seed("f2", familyID, "message", "high")
+	seed("f3", familyID, "place_checkin", "")
+	seed("s1", "", "system", "")
+	seed("s2", "", "message", "")
+	seedNotification(t, env, repo, models.Notification{UserID: other.ID.Hex(), Title: "theirs", CircleID: familyID, Type: "message"}, time.Minute)
+
+	markRead := func(req models.MarkGroupReadRequest, marked, unread int64) {
+		t.Helper()
+		result, err := ns.MarkGroupRead(ctx, userID, req)
+		if err != nil {
+			t.Fatalf("MarkGroupRead: %v", err)
+		}
+		if result.MarkedRead != marked || result.Unread != unread {
+			t.Errorf("marking %+v read marked %d leaving %d unread, want %d leaving %d", req, result.MarkedRead, result.Unread, marked, unread)
+		}
+	}
+
+	// Within the inbox's priority filter, then the rest of the type
+	markRead(models.MarkGroupReadRequest{GroupBy: "type", Key: "message", Priority: "high"}, 1, 4)
+	markRead(models.MarkGroupReadRequest{GroupBy: "type", Key: "message"}, 2, 2)
+	markRead(models.MarkGroupReadRequest{GroupBy: "type", Key: "message"}, 0, 2)
+
+	// A circle, and the notifications outside circles
+	markRead(models.MarkGroupReadRequest{GroupBy: "circle", Key: familyID}, 1, 1)
+	markRead(models.MarkGroupReadRequest{GroupBy: "circle"}, 1, 0)
+
+	grouped, err := ns.GetGroupedNotifications(ctx, models.GetNotificationsRequest{UserID: userID, GroupBy: "circle", Page: 1, PageSize: 10})
+	if err != nil {
+		t.Fatalf("GetGroupedNotifications: %v", err)
+	}
+	if grouped.Total != 5 || grouped.Unread != 0 {
+		t.Errorf("inbox after reading every group has %d/%d, want 5 read", grouped.Total, grouped.Unread)
+	}
+
+	// Another user's inbox is left alone
+	if unread, err := repo.GetNotificationCount(ctx, other.ID.Hex(), "unread"); err != nil || unread != 1 {
+		t.Errorf("other user's unread count = %d (%v), want 1", unread, err)
+	}
+
+	if _, err := ns.MarkGroupRead(ctx, userID, models.MarkGroupReadRequest{GroupBy: "status"}); utils.ValidationFailureReason(err) != "groupBy must be circle or type" {
+		t.Errorf("marking a status group read error = %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"ftrack/models"
+	"ftrack/utils"
+)
+
+const minutesPerWeek = 7 * 24 * 60
+
+// GetQuietHours returns the user's quiet hours, with older single-window
+// settings as a window
+func (ns *NotificationService) GetQuietHours(ctx context.Context, userID string) (*models.QuietHours, error) {
+	settings, err := ns.GetPushSettings(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	quietHours := settings.QuietHours
+	quietHours.Normalize()
+	return &quietHours, nil
+}
+
+func (ns *NotificationService) UpdateQuietHours(ctx context.Context, userID string, req models.UpdateQuietHoursRequest) (*models.QuietHours, error) {
+	settings, err := ns.GetPushSettings(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	quietHours := settings.QuietHours
+	quietHours.Normalize()
+
+	if req.Enabled != nil {
+		quietHours.Enabled = *req.Enabled
+	}
+	if req.Timezone != "" {
+		quietHours.Timezone = req.Timezone
+	}
+	switch {
+	case req.Windows != nil:
+		quietHours.Windows = req.Windows
+	case req.StartTime != "" || req.EndTime != "":
+		quietHours.Windows = []models.QuietHoursWindow{{Days: req.Days, StartTime: req.StartTime, EndTime: req.EndTime}}
+	}
+
+	if err := validateQuietHours(quietHours); err != nil {
+		return nil, err
+	}
+
+	settings.QuietHours = quietHours
+	settings.UpdatedAt = time.Now()
+	if err := ns.notificationRepo.UpdatePushSettings(ctx, settings); err != nil {
+		return nil, fmt.Errorf("failed to update push settings: %w", err)
+	}
+
+	return &quietHours, nil
+}
+
+// validateQuietHours checks the windows' times and days, and that no two
+// windows overlap, counting the part of a window past midnight against the
+// next day
+func validateQuietHours(quietHours models.QuietHours) error {
+	if quietHours.Timezone != "" {
+		if _, err := time.LoadLocation(quietHours.Timezone); err != nil {
+			return utils.NewValidationFailedError("unknown timezone " + quietHours.Timezone)
+		}
+	}
+	if len(quietHours.Windows) > models.MaxQuietHoursWindows {
+		return utils.NewValidationFailedError(fmt.Sprintf("at most %d quiet hours windows are allowed", models.MaxQuietHoursWindows))
+	}
+
+	type span struct {
+		window     int
+		start, end int // minutes from the start of Sunday
+	}
+	var spans []span
+
+	for i, window := range quietHours.Windows {
+		start, end, ok := window.Minutes()
+		if !ok {
+			return utils.NewValidationFailedError(quietHoursWindowLabel(quietHours.Windows, i) + " needs a start and end time in HH:MM format")
+		}
+		if start == end {
+			return utils.NewValidationFailedError(quietHoursWindowLabel(quietHours.Windows, i) + " starts and ends at the same time")
+		}
+		if end < start {
+			end += 24 * 60
+		}
+
+		days := window.Days
+		if len(days) == 0 {
+			days = []int{0, 1, 2, 3, 4, 5, 6}
+		}
+		seen := make(map[int]bool, len(days))
+		for _, day := range days {
+			if day < 0 || day > 6 {
+				return utils.NewValidationFailedError(quietHoursWindowLabel(quietHours.Windows, i) + " has a day outside 0 (Sunday) to 6 (Saturday)")
+			}
+			if seen[day] {
+				continue
+			}
+			seen[day] = true
+			spans = append(spans, span{window: i, start: day*24*60 + start, end: day*24*60 + end})
+		}
+	}
+
+	for a := range spans {
+		for b := a + 1; b < len(spans); b++ {
+			if spans[a].window == spans[b].window {
+				continue
+			}
+			// Saturday night runs into Sunday morning
+			for _, shift := range []int{-minutesPerWeek, 0, minutesPerWeek} {
+				if spans[a].start < spans[b].end+shift && spans[b].start+shift < spans[a].end {
+					overlap := spans[a].start
+					if spans[b].start+shift > overlap {
+						overlap = spans[b].start + shift
+					}
+					day := ((overlap%minutesPerWeek + minutesPerWeek) % minutesPerWeek) / (24 * 60)
+					return utils.NewValidationFailedError(fmt.Sprintf("%s and %s overlap on %s",
+						quietHoursWindowLabel(quietHours.Windows, spans[a].window),
+						quietHoursWindowLabel(quietHours.Windows, spans[b].window),
+						time.Weekday(day)))
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func quietHoursWindowLabel(windows []models.QuietHoursWindow, i int) string {
+	if windows[i].Name != "" {
+		return fmt.Sprintf("quiet hours window %q", windows[i].Name)
+	}
+	return fmt.Sprintf("quiet hours window %d", i+1)
+}
+
+// quietHoursReason explains which window held a notification back
+func quietHoursReason(window *models.QuietHoursWindow) string {
+	reason := "within quiet hours"
+	if window.Name != "" {
+		reason += " (" + window.Name + ")"
+	}
+	return reason + ", " + window.StartTime + " to " + window.EndTime
+}
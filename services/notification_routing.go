@@ -0,0 +1,259 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"ftrack/models"
+	"ftrack/utils"
+)
+
+const maxNotificationRules = 50
+
+var notificationPriorities = map[string]bool{
+	"low":    true,
+	"normal": true,
+	"high":   true,
+	"urgent": true,
+}
+
+var notificationWebhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// validateRouting checks the routing of a rule. Every channel must exist and
+// be verified now, so users find out when they set the rule up rather than
+// when a notification silently goes nowhere.
+func (ns *NotificationService) validateRouting(ctx context.Context, userID string, routing *models.NotificationRouting) error {
+	if len(routing.Channels) == 0 {
+		return utils.NewValidationFailedError("routing needs at least one channel")
+	}
+
+	for _, notificationType := range routing.Types {
+		if strings.TrimSpace(notificationType) == "" {
+			return utils.NewValidationFailedError("routing types can't be empty")
+		}
+	}
+	for _, priority := range routing.Priorities {
+		if !notificationPriorities[priority] {
+			return utils.NewValidationFailedError(fmt.Sprintf("unknown priority %q", priority))
+		}
+	}
+
+	seen := make(map[string]bool, len(routing.Channels))
+	for _, channel := range routing.Channels {
+		if seen[channel] {
+			return utils.NewValidationFailedError(fmt.Sprintf("channel %q is listed twice", channel))
+		}
+		seen[channel] = true
+
+		reason, err := ns.channelUnavailableReason(ctx, userID, channel)
+		if err != nil {
+			return err
+		}
+		if reason != "" {
+			return utils.NewValidationFailedError(fmt.Sprintf("channel %q: %s", channel, reason))
+		}
+	}
+
+	return nil
+}
+
+// ResolveDeliveryChannels returns the channels a notification for the user is
+// delivered on. The highest priority active routing rule matching its type
+// and priority picks the channels; without one, the notification keeps the
+// channels it was sent with. Channels of the rule that are no longer usable
+// are skipped, and if none is left the notification keeps its own channels.
+func (ns *NotificationService) ResolveDeliveryChannels(ctx context.Context, userID string, req models.ResolveChannelsRequest) (*models.ChannelResolution, error) {
+	priority := req.Priority
+	if priority == "" {
+		priority = "normal"
+	}
+
+	resolution := &models.ChannelResolution{
+		Type:     req.Type,
+		Priority: priority,
+		Channels: req.DeliveryChannels,
+		Skipped:  []models.SkippedChannel{},
+	}
+	if resolution.Channels == nil {
+		resolution.Channels = []string{}
+	}
+
+	rules, err := ns.notificationRepo.GetActiveRoutingRules(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rule := range rules {
+		if !routingMatches(rule.Routing, req.Type, priority) {
+			continue
+		}
+
+		channels := []string{}
+		for _, channel := range rule.Routing.Channels {
+			reason, err := ns.channelUnavailableReason(ctx, userID, channel)
+			if err != nil {
+				return nil, err
+			}
+			if reason != "" {
+				resolution.Skipped = append(resolution.Skipped, models.SkippedChannel{Channel: channel, Reason: reason})
+				continue
+			}
+			channels = append(channels, channel)
+		}
+
+		resolution.RuleID = rule.ID.Hex()
+		resolution.RuleName = rule.Name
+		if len(channels) > 0 {
+			resolution.Channels = channels
+		}
+		break
+	}
+
+	return resolution, nil
+}
+
+func routingMatches(routing *models.NotificationRouting, notificationType, priority string) bool {
+	if routing == nil {
+		return false
+	}
+	return matchesAny(routing.Types, notificationType) && matchesAny(routing.Priorities, priority)
+}
+
+// matchesAny reports whether value is in values, where no values match any
+func matchesAny(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// channelUnavailableReason returns why the user can't receive notifications
+// on the channel, or an empty string if they can
+func (ns *NotificationService) channelUnavailableReason(ctx context.Context, userID, channel string) (string, error) {
+	switch channel {
+	case models.DeliveryChannelInApp:
+		return "", nil
+
+	case models.DeliveryChannelPush:
+		devices, err := ns.notificationRepo.GetUserPushDevices(ctx, userID)
+		if err != nil {
+			return "", err
+		}
+		if len(devices) == 0 {
+			return "no active push device", nil
+		}
+		return "", nil
+
+	case models.DeliveryChannelEmail:
+		settings, err := ns.notificationRepo.GetEmailSettings(ctx, userID)
+		if err != nil && err.Error() != "not found" {
+			return "", err
+		}
+		if settings != nil && settings.EmailAddress != "" {
+			if !settings.IsVerified {
+				return "email address is not verified", nil
+			}
+			return "", nil
+		}
+
+		// Without a notification address, emails go to the account's address
+		user, err := ns.userRepo.GetByID(ctx, userID)
+		if err != nil {
+			return "", err
+		}
+		if !user.IsVerified {
+			return "email address is not verified", nil
+		}
+		return "", nil
+
+	case models.DeliveryChannelSMS:
+		settings, err := ns.notificationRepo.GetSMSSettings(ctx, userID)
+		if err != nil && err.Error() != "not found" {
+			return "", err
+		}
+		if settings == nil || settings.PhoneNumber == "" {
+			return "no phone number", nil
+		}
+		if !settings.IsVerified {
+			return "phone number is not verified", nil
+		}
+		return "", nil
+	}
+
+	custom, err := ns.notificationRepo.GetChannel(ctx, channel)
+	if err != nil {
+		if err.Error() == "invalid channel ID" || err.Error() == "channel not found" {
+			return "unknown channel", nil
+		}
+		return "", err
+	}
+	if custom.UserID != userID {
+		return "unknown channel", nil
+	}
+	if !custom.IsActive {
+		return "channel is disabled", nil
+	}
+	if custom.TestResult == nil || !custom.TestResult.Success {
+		return "channel has not passed a test", nil
+	}
+	return "", nil
+}
+
+// deliverToCustomChannel sends a notification to one of the user's own
+// channels. Only webhooks can be delivered to so far.
+func (ns *NotificationService) deliverToCustomChannel(ctx context.Context, channelID string, notification *models.Notification) error {
+	channel, err := ns.notificationRepo.GetChannel(ctx, channelID)
+	if err != nil {
+		return err
+	}
+	if channel.UserID != notification.UserID || !channel.IsActive {
+		return errors.New("channel not available")
+	}
+	if channel.Type != "webhook" {
+		return fmt.Errorf("delivery to %s channels is not supported", channel.Type)
+	}
+
+	target, _ := channel.Config["url"].(string)
+	parsed, err := url.Parse(target)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+		return errors.New("webhook channel has no valid url")
+	}
+
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	// Each host has its own circuit, so one user's dead endpoint doesn't
+	// stop everyone else's webhooks
+	return utils.Providers.Do(ctx, utils.ProviderWebhook+":"+parsed.Host, func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+		if err != nil {
+			return utils.NewPermanentProviderError(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := notificationWebhookClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode >= 300 {
+			return utils.ProviderStatusError(fmt.Errorf("webhook responded with status %d", resp.StatusCode), resp.StatusCode)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"unicode"
+
+	"ftrack/models"
+	"ftrack/utils"
+)
+
+const maxNotificationSearchQuery = 200
+
+// SearchNotifications finds the user's notifications whose title or message
+// contain the query words, and marks where they matched
+func (ns *NotificationService) SearchNotifications(ctx context.Context, req models.SearchNotificationsRequest) (*models.NotificationSearchResults, error) {
+	req.Query = strings.TrimSpace(req.Query)
+	if req.Query == "" {
+		return nil, utils.NewValidationFailedError("search query is required")
+	}
+	if len(req.Query) > maxNotificationSearchQuery {
+		return nil, utils.NewValidationFailedError("search query is too long")
+	}
+
+	switch req.Status {
+	case "", "read", "unread":
+	case "archived":
+		if req.ExcludeArchived {
+			return nil, utils.NewValidationFailedError("can't search archived notifications while excluding them")
+		}
+	default:
+		return nil, utils.NewValidationFailedError("status must be read, unread or archived")
+	}
+	if req.From != nil && req.To != nil && req.From.After(*req.To) {
+		return nil, utils.NewValidationFailedError("from must be before to")
+	}
+
+	req.Page, req.PageSize = models.NormalizePage(req.Page, req.PageSize)
+
+	notifications, total, err := ns.notificationRepo.SearchNotificationsText(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	terms := notificationSearchTerms(req.Query)
+	results := make([]models.NotificationSearchResult, 0, len(notifications))
+	for _, notification := range notifications {
+		result := models.NotificationSearchResult{
+			Notification: notification,
+			Highlights:   []models.NotificationHighlight{},
+		}
+		if matches := highlightTerms(notification.Title, terms); len(matches) > 0 {
+			result.Highlights = append(result.Highlights, models.NotificationHighlight{Field: "title", Matches: matches})
+		}
+		if matches := highlightTerms(notification.Message, terms); len(matches) > 0 {
+			result.Highlights = append(result.Highlights, models.NotificationHighlight{Field: "message", Matches: matches})
+		}
+		results = append(results, result)
+	}
+
+	totalPages := int((total + int64(req.PageSize) - 1) / int64(req.PageSize))
+
+	return &models.NotificationSearchResults{
+		Results:    results,
+		Query:      req.Query,
+		Page:       req.Page,
+		PageSize:   req.PageSize,
+		Total:      total,
+		TotalPages: totalPages,
+		HasNext:    req.Page < totalPages,
+		HasPrev:    req.Page > 1,
+	}, nil
+}
+
+// notificationSearchTerms returns the lowercase words of a text search,
+// leaving out negated ones like "-school"
+func notificationSearchTerms(query string) []string {
+	var terms []string
+	for _, field := range strings.Fields(query) {
+		if strings.HasPrefix(field, "-") {
+			continue
+		}
+		terms = append(terms, splitWords(strings.ToLower(field))...)
+	}
+	return terms
+}
+
+func splitWords(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// highlightTerms returns the words of text that match a search term. The
+// text index matches word stems, so "geofences" matches "geofence" and the
+// other way around; a shared prefix approximates that.
+func highlightTerms(text string, terms []string) []models.HighlightRange {
+	var matches []models.HighlightRange
+	runes := []rune(text)
+
+	for start := 0; start < len(runes); {
+		if !unicode.IsLetter(runes[start]) && !unicode.IsDigit(runes[start]) {
+			start++
+			continue
+		}
+
+		end := start
+		for end < len(runes) && (unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end])) {
+			end++
+		}
+
+		word := strings.ToLower(string(runes[start:end]))
+		for _, term := range terms {
+			if stemMatch(word, term) {
+				matches = append(matches, models.HighlightRange{Start: start, Length: end - start})
+				break
+			}
+		}
+		start = end
+	}
+
+	return matches
+}
+
+func stemMatch(word, term string) bool {
+	if strings.HasPrefix(word, term) {
+		return true
+	}
+	return len(word) >= 4 && strings.HasPrefix(term, word)
+}
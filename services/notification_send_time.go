@@ -0,0 +1,230 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"ftrack/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// How long a computed send-time profile is cached
+const sendTimeProfileTTL = 6 * time.Hour
+
+// Deferred notifications delivered per run of the worker
+const deferredDeliveryBatchSize = 200
+
+func sendTimeProfileKey(userID string) string {
+	return "notifications:send_time:" + userID
+}
+
+// GetSendTimeProfile returns when the user usually reads notifications,
+// learned from the reads of the last month
+func (ns *NotificationService) GetSendTimeProfile(ctx context.Context, userID string) (*models.SendTimeProfile, error) {
+	if ns.redis != nil {
+		if cached, err := ns.redis.Get(ctx, sendTimeProfileKey(userID)).Result(); err == nil {
+			var profile models.SendTimeProfile
+			if err := json.Unmarshal([]byte(cached), &profile); err == nil {
+				return &profile, nil
+			}
+		}
+	}
+
+	preferences, err := ns.GetNotificationPreferences(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	location := preferencesLocation(preferences)
+
+	counts, err := ns.notificationRepo.GetHourlyReadCounts(ctx, userID, time.Now().Add(-models.SendTimeLookback), location)
+	if err != nil {
+		return nil, err
+	}
+	profile := buildSendTimeProfile(userID, location, counts)
+
+	if ns.redis != nil {
+		if data, err := json.Marshal(profile); err == nil {
+			ns.redis.Set(ctx, sendTimeProfileKey(userID), data, sendTimeProfileTTL)
+		}
+	}
+
+	return profile, nil
+}
+
+// preferencesLocation is the user's timezone, UTC when unset or unknown
+func preferencesLocation(preferences *models.NotificationPreferences) *time.Location {
+	for _, name := range []string{preferences.Timezone, preferences.Schedule.Timezone} {
+		if name == "" {
+			continue
+		}
+		if location, err := time.LoadLocation(name); err == nil {
+			return location
+		}
+	}
+	return time.UTC
+}
+
+// buildSendTimeProfile prefers the hours with at least half as many reads
+// as the busiest one
+func buildSendTimeProfile(userID string, location *time.Location, counts []int64) *models.SendTimeProfile {
+	var samples, peak int64
+	for _, count := range counts {
+		samples += count
+		if count > peak {
+			peak = count
+		}
+	}
+
+	preferred := []int{}
+	for hour, count := range counts {
+		if count > 0 && count*2 >= peak {
+			preferred = append(preferred, hour)
+		}
+	}
+
+	return &models.SendTimeProfile{
+		UserID:         userID,
+		Timezone:       location.String(),
+		Samples:        samples,
+		HourlyReads:    counts,
+		PreferredHours: preferred,
+		Windows:        sendTimeWindows(preferred),
+		Active:         samples >= models.SendTimeMinSamples && len(preferred) > 0,
+		ComputedAt:     time.Now(),
+	}
+}
+
+// sendTimeWindows joins consecutive preferred hours into time ranges,
+// running past midnight where they do
+func sendTimeWindows(hours []int) []models.TimeRange {
+	windows := []models.TimeRange{}
+	if len(hours) == 24 {
+		return append(windows, models.TimeRange{StartTime: "00:00", EndTime: "00:00"})
+	}
+
+	preferred := make([]bool, 24)
+	for _, hour := range hours {
+		preferred[hour] = true
+	}
+
+	for start := 0; start < 24; start++ {
+		if !preferred[start] || preferred[(start+23)%24] {
+			continue
+		}
+		end := start
+		for preferred[(end+1)%24] {
+			end++
+		}
+		windows = append(windows, models.TimeRange{
+			StartTime: fmt.Sprintf("%02d:00", start),
+			EndTime:   fmt.Sprintf("%02d:00", (end+1)%24),
+		})
+	}
+	return windows
+}
+
+// sendTimeDeferral returns when to deliver a notification that should wait
+// for the user's usual reading time. Only eligible types that the user
+// hasn't opted out of wait, never urgent ones, and never past the max
+// delay.
+func (ns *NotificationService) sendTimeDeferral(ctx context.Context, notification *models.Notification, now time.Time) (time.Time, bool) {
+	if !models.SendTimeOptimizedTypes[notification.Type] {
+		return time.Time{}, false
+	}
+	if notification.Priority == "urgent" || notification.Priority == "high" {
+		return time.Time{}, false
+	}
+
+	preferences, err := ns.GetNotificationPreferences(ctx, notification.UserID)
+	if err != nil {
+		logrus.Warnf("Failed to get notification preferences of user %s: %v", notification.UserID, err)
+		return time.Time{}, false
+	}
+	if preference, ok := preferences.TypePreferences[notification.Type]; ok &&
+		preference.OptimizeSendTime != nil && !*preference.OptimizeSendTime {
+		return time.Time{}, false
+	}
+
+	profile, err := ns.GetSendTimeProfile(ctx, notification.UserID)
+	if err != nil {
+		logrus.Warnf("Failed to get send-time profile of user %s: %v", notification.UserID, err)
+		return time.Time{}, false
+	}
+	if !profile.Active {
+		return time.Time{}, false
+	}
+
+	var quietHours models.QuietHours
+	if settings, err := ns.notificationRepo.GetPushSettings(ctx, notification.UserID); err == nil && settings != nil {
+		quietHours = settings.QuietHours
+	}
+
+	location := preferencesLocation(preferences)
+	return nextSendTime(profile.PreferredHours, quietHours, now.In(location))
+}
+
+// nextSendTime returns the start of the next preferred hour outside quiet
+// hours, or the max delay if none comes sooner. It returns false when now
+// is already a good time.
+func nextSendTime(preferredHours []int, quietHours models.QuietHours, now time.Time) (time.Time, bool) {
+	preferred := make(map[int]bool, len(preferredHours))
+	for _, hour := range preferredHours {
+		preferred[hour] = true
+	}
+
+	if preferred[now.Hour()] && !quietHours.ActiveAt(now) {
+		return time.Time{}, false
+	}
+
+	latest := now.Add(models.SendTimeMaxDelay)
+	hourStart := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 0, 0, now.Location())
+	for candidate := hourStart.Add(time.Hour); !candidate.After(latest); candidate = candidate.Add(time.Hour) {
+		if preferred[candidate.Hour()] && !quietHours.ActiveAt(candidate) {
+			return candidate, true
+		}
+	}
+	return latest, true
+}
+
+// DeliverDeferredNotifications sends the deferred notifications whose time
+// has come, and the batches that are due. Ones the user has already read in
+// the app, or that have expired, are not sent.
+func (ns *NotificationService) DeliverDeferredNotifications(ctx context.Context) error {
+	for i := 0; i < deferredDeliveryBatchSize; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		notification, err := ns.notificationRepo.ClaimDueDeferredNotification(ctx)
+		if err != nil {
+			return err
+		}
+		if notification == nil {
+			return nil
+		}
+
+		if notification.Batched {
+			ns.deliverBatch(ctx, notification)
+			continue
+		}
+		if !deferredNotificationWanted(notification) {
+			continue
+		}
+
+		ns.deliverOnChannels(ctx, notification)
+		ns.updateBadgeCount(ctx, notification.UserID)
+	}
+	return nil
+}
+
+// deferredNotificationWanted reports whether a held notification is still
+// worth sending: not already read in the app, archived or expired
+func deferredNotificationWanted(notification *models.Notification) bool {
+	if notification.ReadAt != nil || notification.IsArchived {
+		return false
+	}
+	return notification.ExpiresAt == nil || notification.ExpiresAt.After(time.Now())
+}
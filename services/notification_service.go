@@ -7,7 +7,9 @@ import (
 	"fmt"
 	"ftrack/models"
 	"ftrack/repositories"
+	"ftrack/utils"
 	"ftrack/websocket"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -15,21 +17,32 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// NotificationDispatcher sends notifications. Services that notify take it
+// rather than the notification service, so tests can record what was sent.
+type NotificationDispatcher interface {
+	SendNotification(ctx context.Context, req models.SendNotificationRequest) error
+}
+
 type NotificationService struct {
 	notificationRepo *repositories.NotificationRepository
 	userRepo         *repositories.UserRepository
 	circleRepo       *repositories.CircleRepository
+	blockRepo        *repositories.BlockRepository
 	redis            *redis.Client
 	hub              *websocket.Hub
 	emailService     EmailService // Remove the pointer (*) for interface
 	smsService       *SMSService
 	pushService      *PushService
+
+	// Batching windows by notification type; the defaults until configured
+	batchWindows map[string]time.Duration
 }
 
 func NewNotificationService(
 	notificationRepo *repositories.NotificationRepository,
 	userRepo *repositories.UserRepository,
 	circleRepo *repositories.CircleRepository,
+	blockRepo *repositories.BlockRepository,
 	redis *redis.Client,
 	hub *websocket.Hub,
 	emailService EmailService, // Remove the pointer (*) for interface
@@ -40,6 +53,7 @@ func NewNotificationService(
 		notificationRepo: notificationRepo,
 		userRepo:         userRepo,
 		circleRepo:       circleRepo,
+		blockRepo:        blockRepo,
 		redis:            redis,
 		hub:              hub,
 		emailService:     emailService,
@@ -53,7 +67,7 @@ func NewNotificationService(
 // ========================
 
 func (ns *NotificationService) GetNotifications(ctx context.Context, req models.GetNotificationsRequest) (*models.PaginatedNotifications, error) {
-	notifications, total, err := ns.notificationRepo.GetUserNotifications(ctx, req.UserID, req.Page, req.PageSize, req.Type, req.Status)
+	notifications, total, err := ns.notificationRepo.GetUserNotifications(ctx, req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get notifications: %w", err)
 	}
@@ -71,6 +85,63 @@ func (ns *NotificationService) GetNotifications(ctx context.Context, req models.
 	}, nil
 }
 
+// GetGroupedNotifications returns the inbox in sections by circle or by
+// type, each with its counts and most recent notifications, and the unread
+// total across all of them
+func (ns *NotificationService) GetGroupedNotifications(ctx context.Context, req models.GetNotificationsRequest) (*models.GroupedNotifications, error) {
+	if req.GroupBy != models.NotificationGroupByCircle && req.GroupBy != models.NotificationGroupByType {
+		return nil, utils.NewValidationFailedError("groupBy must be circle or type")
+	}
+
+	grouped, err := ns.notificationRepo.GetGroupedNotifications(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get notifications: %w", err)
+	}
+
+	if req.GroupBy == models.NotificationGroupByCircle {
+		for i := range grouped.Groups {
+			if grouped.Groups[i].Key == "" {
+				continue
+			}
+			if circle, err := ns.circleRepo.GetByID(ctx, grouped.Groups[i].Key); err == nil {
+				grouped.Groups[i].Label = circle.Name
+			}
+		}
+	}
+
+	totalPages := int((grouped.TotalGroups + int64(req.PageSize) - 1) / int64(req.PageSize))
+	grouped.Page = req.Page
+	grouped.PageSize = req.PageSize
+	grouped.TotalPages = totalPages
+	grouped.HasNext = req.Page < totalPages
+	grouped.HasPrev = req.Page > 1
+
+	return grouped, nil
+}
+
+// MarkGroupRead marks every unread notification of an inbox group read
+func (ns *NotificationService) MarkGroupRead(ctx context.Context, userID string, req models.MarkGroupReadRequest) (*models.MarkGroupReadResult, error) {
+	if req.GroupBy != models.NotificationGroupByCircle && req.GroupBy != models.NotificationGroupByType {
+		return nil, utils.NewValidationFailedError("groupBy must be circle or type")
+	}
+
+	marked, err := ns.notificationRepo.MarkGroupRead(ctx, userID, req)
+	if err != nil {
+		return nil, err
+	}
+
+	unread, err := ns.notificationRepo.GetNotificationCount(ctx, userID, "unread")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get unread count: %w", err)
+	}
+
+	if marked > 0 {
+		ns.updateBadgeCount(ctx, userID)
+	}
+
+	return &models.MarkGroupReadResult{MarkedRead: marked, Unread: unread}, nil
+}
+
 func (ns *NotificationService) GetNotification(ctx context.Context, userID, notificationID string) (*models.Notification, error) {
 	notification, err := ns.notificationRepo.GetByID(ctx, notificationID)
 	if err != nil {
@@ -278,6 +349,69 @@ func (ns *NotificationService) BulkArchiveNotifications(ctx context.Context, use
 	return result, nil
 }
 
+// DeleteNotificationsByFilter deletes the user's notifications matching the
+// filter in one query
+func (ns *NotificationService) DeleteNotificationsByFilter(ctx context.Context, userID string, req models.BulkNotificationFilterRequest) (*models.BulkNotificationFilterResult, error) {
+	if err := validateBulkFilter(req); err != nil {
+		return nil, err
+	}
+
+	deleted, err := ns.notificationRepo.DeleteByFilter(ctx, userID, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if deleted > 0 && !req.DryRun {
+		ns.updateBadgeCount(ctx, userID)
+	}
+
+	return &models.BulkNotificationFilterResult{Affected: deleted, DryRun: req.DryRun}, nil
+}
+
+// ArchiveNotificationsByFilter archives the user's notifications matching
+// the filter in one query
+func (ns *NotificationService) ArchiveNotificationsByFilter(ctx context.Context, userID string, req models.BulkNotificationFilterRequest) (*models.BulkNotificationFilterResult, error) {
+	if err := validateBulkFilter(req); err != nil {
+		return nil, err
+	}
+
+	archived, err := ns.notificationRepo.ArchiveByFilter(ctx, userID, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if archived > 0 && !req.DryRun {
+		ns.updateBadgeCount(ctx, userID)
+	}
+
+	return &models.BulkNotificationFilterResult{Affected: archived, DryRun: req.DryRun}, nil
+}
+
+// validateBulkFilter requires at least one criterion, so a request can't
+// empty the whole inbox by leaving the filter out
+func validateBulkFilter(req models.BulkNotificationFilterRequest) error {
+	if len(req.Types) == 0 && req.Status == "" && req.CircleID == "" && req.OlderThanDays == 0 {
+		return utils.NewValidationFailedError("at least one of types, status, circle_id or older_than_days is required")
+	}
+	if len(req.Types) > 50 {
+		return utils.NewValidationFailedError("at most 50 types can be given")
+	}
+	switch req.Status {
+	case "", "read", "unread", "archived":
+	default:
+		return utils.NewValidationFailedError("status must be read, unread or archived")
+	}
+	if req.CircleID != "" {
+		if _, err := primitive.ObjectIDFromHex(req.CircleID); err != nil {
+			return utils.NewValidationFailedError("invalid circle_id")
+		}
+	}
+	if req.OlderThanDays < 0 {
+		return utils.NewValidationFailedError("older_than_days must be positive")
+	}
+	return nil
+}
+
 // ========================
 // Filtering Operations
 // ========================
@@ -462,7 +596,12 @@ func (ns *NotificationService) UpdatePushSettings(ctx context.Context, userID st
 		settings.TypeSettings = req.TypeSettings
 	}
 	if req.QuietHours != nil {
-		settings.QuietHours = *req.QuietHours
+		quietHours := *req.QuietHours
+		quietHours.Normalize()
+		if err := validateQuietHours(quietHours); err != nil {
+			return nil, err
+		}
+		settings.QuietHours = quietHours
 	}
 
 	settings.UpdatedAt = time.Now()
@@ -619,11 +758,13 @@ func (ns *NotificationService) GetNotificationPreferences(ctx context.Context, u
 				return nil, fmt.Errorf("failed to create default preferences: %w", err)
 			}
 
+			defaultPrefs.BatchingPolicy = ns.batchingPolicy(ctx, defaultPrefs)
 			return defaultPrefs, nil
 		}
 		return nil, fmt.Errorf("failed to get preferences: %w", err)
 	}
 
+	preferences.BatchingPolicy = ns.batchingPolicy(ctx, preferences)
 	return preferences, nil
 }
 
@@ -633,6 +774,18 @@ func (ns *NotificationService) UpdateNotificationPreferences(ctx context.Context
 		return nil, err
 	}
 
+	// Turning notifications off counts against the experiment variants the
+	// user got
+	var optedOut []string
+	if req.GlobalEnabled != nil && !*req.GlobalEnabled && preferences.GlobalEnabled {
+		optedOut = append(optedOut, "")
+	}
+	for notificationType, preference := range req.TypePreferences {
+		if current, ok := preferences.TypePreferences[notificationType]; !preference.Enabled && (!ok || current.Enabled) {
+			optedOut = append(optedOut, notificationType)
+		}
+	}
+
 	// Update fields that are provided
 	if req.GlobalEnabled != nil {
 		preferences.GlobalEnabled = *req.GlobalEnabled
@@ -650,6 +803,13 @@ func (ns *NotificationService) UpdateNotificationPreferences(ctx context.Context
 		preferences.InAppEnabled = *req.InAppEnabled
 	}
 	if req.TypePreferences != nil {
+		for _, preference := range req.TypePreferences {
+			if preference.BatchWindowSeconds != nil {
+				if err := validateBatchWindow(*preference.BatchWindowSeconds); err != nil {
+					return nil, err
+				}
+			}
+		}
 		preferences.TypePreferences = req.TypePreferences
 	}
 	if req.Schedule != nil {
@@ -668,6 +828,15 @@ func (ns *NotificationService) UpdateNotificationPreferences(ctx context.Context
 		return nil, fmt.Errorf("failed to update preferences: %w", err)
 	}
 
+	if len(optedOut) > 0 {
+		Background.Go(ctx, func(ctx context.Context) {
+			for _, notificationType := range optedOut {
+				ns.recordExperimentOptOut(ctx, userID, notificationType)
+			}
+		})
+	}
+
+	preferences.BatchingPolicy = ns.batchingPolicy(ctx, preferences)
 	return preferences, nil
 }
 
@@ -698,6 +867,14 @@ func (ns *NotificationService) GetNotificationTypes(ctx context.Context) ([]mode
 			Channels:    []string{"push", "email", "in-app"},
 			IsSystem:    true,
 		},
+		{
+			ID:          models.NotificationTypeCircleAnnouncement,
+			Name:        "Circle Announcement",
+			Description: "Announcements from circle admins",
+			Category:    "social",
+			Channels:    []string{"push", "email", "in-app"},
+			IsSystem:    true,
+		},
 		{
 			ID:          "message",
 			Name:        "Message",
@@ -714,6 +891,62 @@ func (ns *NotificationService) GetNotificationTypes(ctx context.Context) ([]mode
 			Channels:    []string{"push", "in-app"},
 			IsSystem:    true,
 		},
+		{
+			ID:          models.NotificationTypeLocationIntegrity,
+			Name:        "Location Integrity",
+			Description: "Alerts when a circle member's locations look spoofed",
+			Category:    "location",
+			Channels:    []string{"push", "in-app"},
+			IsSystem:    true,
+		},
+		{
+			ID:          models.NotificationTypeMemberOffline,
+			Name:        "Member Offline",
+			Description: "Alerts when a circle member's location stops updating",
+			Category:    "location",
+			Channels:    []string{"push", "in-app"},
+			IsSystem:    true,
+		},
+		{
+			ID:          models.NotificationTypeDigest,
+			Name:        "Digest",
+			Description: "Summaries of recent activity",
+			Category:    "summary",
+			Channels:    []string{"push", "email", "in-app"},
+			IsSystem:    true,
+		},
+		{
+			ID:          models.NotificationTypeRecommendation,
+			Name:        "Recommendation",
+			Description: "Suggested places and features",
+			Category:    "summary",
+			Channels:    []string{"push", "in-app"},
+			IsSystem:    true,
+		},
+		{
+			ID:          models.NotificationTypePlaceReview,
+			Name:        "Place Review",
+			Description: "New reviews of your places",
+			Category:    "place",
+			Channels:    []string{"push", "in-app"},
+			IsSystem:    true,
+		},
+		{
+			ID:          models.NotificationTypePlaceListNudge,
+			Name:        "List Reminder",
+			Description: "Items still on a circle list when you arrive at its place",
+			Category:    "place",
+			Channels:    []string{"push", "in-app"},
+			IsSystem:    true,
+		},
+		{
+			ID:          models.NotificationTypeWeeklySummary,
+			Name:        "Weekly Summary",
+			Description: "Weekly summaries of your circles",
+			Category:    "summary",
+			Channels:    []string{"push", "email", "in-app"},
+			IsSystem:    true,
+		},
 	}
 
 	return types, nil
@@ -752,6 +985,18 @@ func (ns *NotificationService) UpdateTypePreferences(ctx context.Context, userID
 	if req.Vibration != nil {
 		typePreference.Vibration = *req.Vibration
 	}
+	if req.OptimizeSendTime != nil {
+		typePreference.OptimizeSendTime = req.OptimizeSendTime
+	}
+	if req.BatchWindowSeconds != nil {
+		if *req.BatchWindowSeconds == -1 {
+			typePreference.BatchWindowSeconds = nil
+		} else if err := validateBatchWindow(*req.BatchWindowSeconds); err != nil {
+			return nil, err
+		} else {
+			typePreference.BatchWindowSeconds = req.BatchWindowSeconds
+		}
+	}
 
 	preferences.TypePreferences[notificationType] = typePreference
 	preferences.UpdatedAt = time.Now()
@@ -760,6 +1005,12 @@ func (ns *NotificationService) UpdateTypePreferences(ctx context.Context, userID
 		return nil, fmt.Errorf("failed to update type preferences: %w", err)
 	}
 
+	if req.Enabled != nil && !*req.Enabled {
+		Background.Go(ctx, func(ctx context.Context) {
+			ns.recordExperimentOptOut(ctx, userID, notificationType)
+		})
+	}
+
 	return &typePreference, nil
 }
 
@@ -961,6 +1212,9 @@ func (ns *NotificationService) UpdateEmailTemplate(ctx context.Context, userID,
 // ========================
 
 func (ns *NotificationService) updateBadgeCount(ctx context.Context, userID string) {
+	// Drop the cached counts, which no longer hold
+	ns.redis.Del(ctx, fmt.Sprintf("user:badges:%s", userID))
+
 	// Get current badge counts
 	badges, err := ns.GetNotificationBadges(ctx, userID)
 	if err != nil {
@@ -1104,55 +1358,216 @@ func (ns *NotificationService) TestNotificationChannel(ctx context.Context, user
 }
 
 func (ns *NotificationService) GetNotificationRules(ctx context.Context, userID string) ([]models.NotificationRule, error) {
-	return []models.NotificationRule{}, nil
+	return ns.notificationRepo.GetUserRules(ctx, userID)
 }
 
 func (ns *NotificationService) CreateNotificationRule(ctx context.Context, userID string, req models.CreateRuleRequest) (*models.NotificationRule, error) {
-	return &models.NotificationRule{}, nil
+	if strings.TrimSpace(req.Name) == "" {
+		return nil, utils.NewValidationFailedError("name is required")
+	}
+	if req.Routing == nil && (len(req.Conditions) == 0 || len(req.Actions) == 0) {
+		return nil, utils.NewValidationFailedError("a rule needs conditions and actions, or routing")
+	}
+	if req.Routing != nil {
+		if err := ns.validateRouting(ctx, userID, req.Routing); err != nil {
+			return nil, err
+		}
+	}
+
+	count, err := ns.notificationRepo.CountUserRules(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if count >= maxNotificationRules {
+		return nil, fmt.Errorf("rule limit reached")
+	}
+
+	rule := &models.NotificationRule{
+		UserID:      userID,
+		Name:        strings.TrimSpace(req.Name),
+		Description: req.Description,
+		Conditions:  req.Conditions,
+		Actions:     req.Actions,
+		Routing:     req.Routing,
+		Priority:    req.Priority,
+		IsActive:    true,
+	}
+
+	if err := ns.notificationRepo.CreateRule(ctx, rule); err != nil {
+		return nil, err
+	}
+
+	return rule, nil
 }
 
 func (ns *NotificationService) GetNotificationRule(ctx context.Context, userID, ruleID string) (*models.NotificationRule, error) {
-	return &models.NotificationRule{}, nil
+	rule, err := ns.notificationRepo.GetRule(ctx, ruleID)
+	if err != nil {
+		return nil, err
+	}
+	if rule.UserID != userID {
+		return nil, fmt.Errorf("access denied")
+	}
+
+	return rule, nil
 }
 
 func (ns *NotificationService) UpdateNotificationRule(ctx context.Context, userID, ruleID string, req models.UpdateRuleRequest) (*models.NotificationRule, error) {
-	return &models.NotificationRule{}, nil
+	rule, err := ns.GetNotificationRule(ctx, userID, ruleID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name != "" {
+		rule.Name = strings.TrimSpace(req.Name)
+	}
+	if req.Description != "" {
+		rule.Description = req.Description
+	}
+	if req.Conditions != nil {
+		rule.Conditions = req.Conditions
+	}
+	if req.Actions != nil {
+		rule.Actions = req.Actions
+	}
+	if req.Routing != nil {
+		// Routing without channels removes it from the rule
+		if len(req.Routing.Channels) == 0 {
+			rule.Routing = nil
+		} else {
+			if err := ns.validateRouting(ctx, userID, req.Routing); err != nil {
+				return nil, err
+			}
+			rule.Routing = req.Routing
+		}
+	}
+	if req.Priority != nil {
+		rule.Priority = *req.Priority
+	}
+	if req.IsActive != nil {
+		rule.IsActive = *req.IsActive
+	}
+
+	if rule.Name == "" {
+		return nil, utils.NewValidationFailedError("name is required")
+	}
+	if rule.Routing == nil && (len(rule.Conditions) == 0 || len(rule.Actions) == 0) {
+		return nil, utils.NewValidationFailedError("a rule needs conditions and actions, or routing")
+	}
+
+	if err := ns.notificationRepo.UpdateRule(ctx, rule); err != nil {
+		return nil, err
+	}
+
+	return rule, nil
 }
 
 func (ns *NotificationService) DeleteNotificationRule(ctx context.Context, userID, ruleID string) error {
-	return nil
+	if _, err := ns.GetNotificationRule(ctx, userID, ruleID); err != nil {
+		return err
+	}
+
+	return ns.notificationRepo.DeleteRule(ctx, ruleID)
 }
 
 func (ns *NotificationService) TestNotificationRule(ctx context.Context, userID, ruleID string) (*models.RuleTestResult, error) {
 	return &models.RuleTestResult{}, nil
 }
 
+// GetDoNotDisturbStatus returns the user's do not disturb status. DND that
+// ran past its expiry shows as off.
 func (ns *NotificationService) GetDoNotDisturbStatus(ctx context.Context, userID string) (*models.DoNotDisturbStatus, error) {
-	return &models.DoNotDisturbStatus{}, nil
+	status, err := ns.notificationRepo.GetDNDStatus(ctx, userID)
+	if err != nil {
+		if err.Error() != "not found" {
+			return nil, err
+		}
+		return &models.DoNotDisturbStatus{UserID: userID, Exceptions: []string{}}, nil
+	}
+
+	if status.Enabled && !status.IsActive(time.Now()) {
+		status.Enabled = false
+		status.DisabledAt = status.ExpiresAt
+	}
+	return status, nil
 }
 
+// EnableDoNotDisturb turns on do not disturb for everything. It takes over
+// from place-driven DND until the user's next place arrival or departure.
 func (ns *NotificationService) EnableDoNotDisturb(ctx context.Context, userID string, req models.EnableDNDRequest) (*models.DoNotDisturbStatus, error) {
-	return &models.DoNotDisturbStatus{}, nil
+	if req.Duration < 0 {
+		return nil, utils.NewValidationFailedError("duration can't be negative")
+	}
+
+	status, err := ns.GetDoNotDisturbStatus(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	status.Enabled = true
+	status.EnabledAt = &now
+	status.DisabledAt = nil
+	status.ExpiresAt = nil
+	if req.Duration > 0 {
+		expiresAt := now.Add(time.Duration(req.Duration) * time.Minute)
+		status.ExpiresAt = &expiresAt
+	}
+	status.Reason = req.Reason
+	setDNDSource(status, models.DNDSourceManual, nil, nil)
+
+	if err := ns.notificationRepo.UpsertDNDStatus(ctx, status); err != nil {
+		return nil, err
+	}
+	return status, nil
 }
 
+// DisableDoNotDisturb turns off do not disturb, place-driven DND included,
+// until the user's next place arrival turns it back on
 func (ns *NotificationService) DisableDoNotDisturb(ctx context.Context, userID string) (*models.DoNotDisturbStatus, error) {
-	return &models.DoNotDisturbStatus{}, nil
-}
+	status, err := ns.GetDoNotDisturbStatus(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
 
-func (ns *NotificationService) GetQuietHours(ctx context.Context, userID string) (*models.QuietHours, error) {
-	return &models.QuietHours{}, nil
-}
+	now := time.Now()
+	status.Enabled = false
+	status.DisabledAt = &now
+	status.ExpiresAt = nil
+	status.Reason = ""
+	setDNDSource(status, models.DNDSourceManual, nil, nil)
 
-func (ns *NotificationService) UpdateQuietHours(ctx context.Context, userID string, req models.UpdateQuietHoursRequest) (*models.QuietHours, error) {
-	return &models.QuietHours{}, nil
+	if err := ns.notificationRepo.UpsertDNDStatus(ctx, status); err != nil {
+		return nil, err
+	}
+	return status, nil
 }
 
 func (ns *NotificationService) GetDNDExceptions(ctx context.Context, userID string) ([]string, error) {
-	return []string{}, nil
+	status, err := ns.GetDoNotDisturbStatus(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if status.Exceptions == nil {
+		return []string{}, nil
+	}
+	return status.Exceptions, nil
 }
 
 func (ns *NotificationService) UpdateDNDExceptions(ctx context.Context, userID string, req models.UpdateDNDExceptionsRequest) ([]string, error) {
-	return req.Exceptions, nil
+	status, err := ns.GetDoNotDisturbStatus(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	status.Exceptions = req.Exceptions
+	if status.Exceptions == nil {
+		status.Exceptions = []string{}
+	}
+	if err := ns.notificationRepo.UpsertDNDStatus(ctx, status); err != nil {
+		return nil, err
+	}
+	return status.Exceptions, nil
 }
 
 func (ns *NotificationService) GetNotificationTemplates(ctx context.Context, userID, templateType, category string) ([]models.NotificationTemplate, error) {
@@ -1180,7 +1595,18 @@ func (ns *NotificationService) PreviewNotificationTemplate(ctx context.Context,
 }
 
 func (ns *NotificationService) GetNotificationStats(ctx context.Context, userID string, days int, groupBy string) (*models.NotificationStats, error) {
-	return &models.NotificationStats{}, nil
+	if days <= 0 {
+		days = 30
+	}
+
+	endDate := time.Now()
+	stats, err := ns.notificationRepo.GetNotificationStatsByDateRange(ctx, userID, endDate.AddDate(0, 0, -days), endDate)
+	if err != nil {
+		return nil, err
+	}
+	stats.Period = fmt.Sprintf("%dd", days)
+
+	return stats, nil
 }
 
 func (ns *NotificationService) GetDeliveryStats(ctx context.Context, userID string, days int, channel string) (*models.DeliveryStats, error) {
@@ -1203,8 +1629,32 @@ func (ns *NotificationService) GetNotificationHistory(ctx context.Context, req m
 	return &models.NotificationHistory{}, nil
 }
 
+// GetDeliveryHistory returns how a notification was delivered, with the
+// decision trace that governed it while that is kept
 func (ns *NotificationService) GetDeliveryHistory(ctx context.Context, userID, notificationID string) (*models.DeliveryHistory, error) {
-	return &models.DeliveryHistory{}, nil
+	notification, err := ns.GetNotification(ctx, userID, notificationID)
+	if err != nil {
+		return nil, err
+	}
+
+	history := &models.DeliveryHistory{
+		NotificationID: notification.ID.Hex(),
+		Attempts:       []models.DeliveryAttempt{},
+	}
+	if notification.DecisionID == "" {
+		return history, nil
+	}
+
+	decision, err := ns.notificationRepo.GetDeliveryDecision(ctx, notification.DecisionID)
+	if err != nil {
+		if err.Error() == "decision not found" {
+			return history, nil
+		}
+		return nil, err
+	}
+	history.Decision = decision
+
+	return history, nil
 }
 
 func (ns *NotificationService) ExportNotificationHistory(ctx context.Context, req models.ExportHistoryRequest) (*models.ExportResult, error) {
@@ -1244,6 +1694,16 @@ func (ns *NotificationService) GetNotificationActions(ctx context.Context, userI
 }
 
 func (ns *NotificationService) ExecuteNotificationAction(ctx context.Context, userID, notificationID, actionID string, req models.ExecuteActionRequest) (*models.ActionResult, error) {
+	notification, err := ns.GetNotification(ctx, userID, notificationID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Counted as engagement, e.g. by notification experiments
+	if err := ns.notificationRepo.MarkActedOn(ctx, notification.ID); err != nil {
+		logrus.Warnf("Failed to mark notification %s acted on: %v", notificationID, err)
+	}
+
 	return &models.ActionResult{}, nil
 }
 
@@ -1298,8 +1758,57 @@ func (ns *NotificationService) SendNotification(ctx context.Context, req models.
 		return fmt.Errorf("no recipients")
 	}
 
+	// Notifications about a user are suppressed across blocks both ways
+	var blockedIDs map[string]bool
+	if req.SubjectUserID != "" && ns.blockRepo != nil {
+		var err error
+		blockedIDs, err = ns.blockRepo.GetRelatedUserIDs(ctx, req.SubjectUserID)
+		if err != nil {
+			logrus.Warnf("Failed to get blocks for %s: %v", req.SubjectUserID, err)
+		}
+	}
+
+	// Deactivated accounts neither receive notifications nor trigger them
+	var deactivatedIDs map[string]bool
+	if ns.userRepo != nil {
+		userIDs := req.Recipients
+		if req.SubjectUserID != "" {
+			userIDs = append([]string{req.SubjectUserID}, req.Recipients...)
+		}
+
+		var err error
+		deactivatedIDs, err = ns.userRepo.GetDeactivatedUserIDs(ctx, userIDs)
+		if err != nil {
+			logrus.Warnf("Failed to check deactivated recipients: %v", err)
+		}
+		if deactivatedIDs[req.SubjectUserID] {
+			return nil
+		}
+	}
+
 	// Send notification to each recipient
 	for _, recipientID := range req.Recipients {
+		now := time.Now()
+
+		// The recipient's settings decide whether and how it goes out.
+		// Without a decision it goes out as sent.
+		decision, err := ns.resolveDelivery(ctx, deliveryInput{
+			UserID:      recipientID,
+			Type:        req.Type,
+			Priority:    req.Priority,
+			CircleID:    req.CircleID,
+			Channels:    req.DeliveryChannels,
+			At:          now,
+			Blocked:     blockedIDs[recipientID],
+			Deactivated: deactivatedIDs[recipientID],
+			Immediate:   req.Immediate,
+		})
+		if err != nil {
+			logrus.Warnf("Failed to resolve delivery for user %s: %v", recipientID, err)
+		} else if decision.Outcome == models.DeliveryOutcomeSuppressed {
+			continue
+		}
+
 		notification := &models.Notification{
 			ID:               primitive.NewObjectID(),
 			UserID:           recipientID,
@@ -1309,16 +1818,48 @@ func (ns *NotificationService) SendNotification(ctx context.Context, req models.
 			Priority:         req.Priority,
 			Category:         req.Category,
 			Status:           "unread",
+			CircleID:         req.CircleID,
 			Data:             req.Data,
 			ActionButtons:    req.ActionButtons,
 			ImageURL:         req.ImageURL,
+			Attachment:       req.Attachment,
 			DeepLink:         req.DeepLink,
+			CollapseKey:      req.CollapseKey,
 			ScheduledAt:      req.ScheduledAt,
 			ExpiresAt:        req.ExpiresAt,
 			DeliveryChannels: req.DeliveryChannels,
 			Metadata:         req.Metadata,
-			CreatedAt:        time.Now(),
-			UpdatedAt:        time.Now(),
+			Immediate:        req.Immediate,
+			DeliveredAt:      &now,
+
+			ExperimentID:      req.ExperimentID,
+			ExperimentVariant: req.ExperimentVariant,
+			CreatedAt:         now,
+			UpdatedAt:         now,
+		}
+
+		// Deferred notifications wait for the user's usual reading time,
+		// batched ones for their batch. The notification worker sends them
+		// then.
+		if decision != nil {
+			decision.ID = primitive.NewObjectID()
+			decision.NotificationID = notification.ID.Hex()
+			notification.DecisionID = decision.ID.Hex()
+			notification.DeliveryChannels = decision.Channels
+			switch decision.Outcome {
+			case models.DeliveryOutcomeDeferred, models.DeliveryOutcomeBatched:
+				notification.DeferredUntil = decision.DeferredUntil
+				notification.Batched = decision.Outcome == models.DeliveryOutcomeBatched
+				notification.DeliveredAt = nil
+			}
+		}
+
+		// A collapsing notification replaces the unread one before it, as
+		// it does on the device
+		if notification.CollapseKey != "" {
+			if err := ns.notificationRepo.DeleteUnreadCollapsed(ctx, recipientID, notification.CollapseKey); err != nil {
+				logrus.Warnf("Failed to replace collapsed notifications of user %s: %v", recipientID, err)
+			}
 		}
 
 		// Save notification to database
@@ -1326,29 +1867,17 @@ func (ns *NotificationService) SendNotification(ctx context.Context, req models.
 			logrus.Errorf("Failed to save notification for user %s: %v", recipientID, err)
 			continue
 		}
-
-		// Send via configured channels
-		for _, channel := range req.DeliveryChannels {
-			switch channel {
-			case "push":
-				if err := ns.pushService.SendNotification(ctx, notification); err != nil {
-					logrus.Errorf("Failed to send push notification: %v", err)
-				}
-			case "email":
-				if err := ns.emailService.SendNotification(ctx, notification); err != nil {
-					logrus.Errorf("Failed to send email notification: %v", err)
-				}
-			case "sms":
-				if err := ns.smsService.SendNotification(ctx, notification); err != nil {
-					logrus.Errorf("Failed to send SMS notification: %v", err)
-				}
-			case "in-app":
-				// Send real-time notification via WebSocket
-				if ns.hub != nil {
-					ns.hub.SendNotificationToUser(recipientID, notification)
-				}
+		if decision != nil {
+			if err := ns.notificationRepo.CreateDeliveryDecision(ctx, decision); err != nil {
+				logrus.Warnf("Failed to save delivery decision of notification %s: %v", notification.ID.Hex(), err)
 			}
 		}
+		if notification.DeferredUntil != nil {
+			continue
+		}
+
+		// Send via configured channels
+		ns.deliverOnChannels(ctx, notification)
 
 		// Update badge count
 		ns.updateBadgeCount(ctx, recipientID)
@@ -1356,3 +1885,44 @@ func (ns *NotificationService) SendNotification(ctx context.Context, req models.
 
 	return nil
 }
+
+// deliverOnChannels sends a saved notification on each of its delivery
+// channels, and returns the channels it was sent and failed on
+func (ns *NotificationService) deliverOnChannels(ctx context.Context, notification *models.Notification) ([]string, []string) {
+	var sent, failed []string
+
+	for _, channel := range notification.DeliveryChannels {
+		var err error
+		switch channel {
+		case "push":
+			if err = ns.pushService.SendNotification(ctx, notification); err != nil {
+				logrus.Errorf("Failed to send push notification: %v", err)
+			}
+		case "email":
+			if err = ns.emailService.SendNotification(ctx, notification); err != nil {
+				logrus.Errorf("Failed to send email notification: %v", err)
+			}
+		case "sms":
+			if err = ns.smsService.SendNotification(ctx, notification); err != nil {
+				logrus.Errorf("Failed to send SMS notification: %v", err)
+			}
+		case "in-app":
+			// Send real-time notification via WebSocket
+			if ns.hub != nil {
+				ns.hub.SendNotificationToUser(notification.UserID, notification)
+			}
+		default:
+			if err = ns.deliverToCustomChannel(ctx, channel, notification); err != nil {
+				logrus.Errorf("Failed to send notification to channel %s: %v", channel, err)
+			}
+		}
+
+		if err != nil {
+			failed = append(failed, channel)
+		} else {
+			sent = append(sent, channel)
+		}
+	}
+
+	return sent, failed
+}
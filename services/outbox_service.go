@@ -0,0 +1,262 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"ftrack/models"
+	"ftrack/repositories"
+	"ftrack/websocket"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// How long an instance holds an event it is delivering. An instance
+	// that dies mid-delivery releases it when the lease runs out.
+	outboxLease = 30 * time.Second
+
+	// Events still failing after this many attempts are given up on
+	maxOutboxAttempts = 10
+
+	maxOutboxRetryDelay = 5 * time.Minute
+)
+
+// OutboxService records the side effects of writes and delivers them. An
+// event is stored right after its write, before the request returns, and
+// handed to the hub or the notification service straight away; the outbox
+// worker retries whatever wasn't delivered, including events left behind
+// by a crash or restart.
+type OutboxService struct {
+	outboxRepo          *repositories.OutboxRepository
+	websocketHub        OutboxHub
+	notificationService NotificationDispatcher
+}
+
+// OutboxHub is the part of the WebSocket hub the outbox delivers through.
+// Its sends report a full broadcast channel so the event can be retried.
+type OutboxHub interface {
+	TryBroadcastMessage(roomID string, message models.WSMessage) bool
+	TryBroadcastFiltered(roomID string, message models.WSMessage, filter websocket.MessageFilter) bool
+}
+
+func NewOutboxService(
+	outboxRepo *repositories.OutboxRepository,
+	websocketHub OutboxHub,
+	notificationService NotificationDispatcher,
+) *OutboxService {
+	return &OutboxService{
+		outboxRepo:          outboxRepo,
+		websocketHub:        websocketHub,
+		notificationService: notificationService,
+	}
+}
+
+// EnqueueCircleBroadcast records a WebSocket message for a circle's members
+func (ob *OutboxService) EnqueueCircleBroadcast(ctx context.Context, idempotencyKey, circleID string, message models.WSMessage) error {
+	return ob.EnqueueFilteredBroadcast(ctx, idempotencyKey, models.OutboxCircleBroadcast{
+		CircleID: circleID,
+		Message:  message,
+	})
+}
+
+// EnqueueFilteredBroadcast records a message for the circle members the
+// broadcast's user lists let through
+func (ob *OutboxService) EnqueueFilteredBroadcast(ctx context.Context, idempotencyKey string, broadcast models.OutboxCircleBroadcast) error {
+	return ob.enqueue(ctx, models.OutboxKindCircleBroadcast, idempotencyKey, broadcast)
+}
+
+// EnqueuePlaceEvent records a member's arrival or departure for their circles
+func (ob *OutboxService) EnqueuePlaceEvent(ctx context.Context, idempotencyKey, userID string, circleIDs []string, placeEvent models.WSPlaceEvent) error {
+	return ob.enqueue(ctx, models.OutboxKindPlaceEvent, idempotencyKey, models.OutboxPlaceEvent{
+		UserID:     userID,
+		CircleIDs:  circleIDs,
+		PlaceEvent: placeEvent,
+	})
+}
+
+func (ob *OutboxService) EnqueueNotification(ctx context.Context, idempotencyKey string, req models.SendNotificationRequest) error {
+	return ob.enqueue(ctx, models.OutboxKindNotification, idempotencyKey, req)
+}
+
+// enqueue stores an event and starts delivering it. Enqueueing a key that
+// is already stored does nothing, so a retried write doesn't repeat its
+// side effects.
+func (ob *OutboxService) enqueue(ctx context.Context, kind, idempotencyKey string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	event := &models.OutboxEvent{
+		Kind:           kind,
+		IdempotencyKey: idempotencyKey,
+		Payload:        data,
+	}
+
+	inserted, err := ob.outboxRepo.Enqueue(ctx, event)
+	if err != nil {
+		return err
+	}
+	if inserted {
+		go ob.dispatchEvent(event.ID)
+	}
+
+	return nil
+}
+
+// DispatchDue delivers up to limit events that are due, oldest first, and
+// returns how many were delivered
+func (ob *OutboxService) DispatchDue(ctx context.Context, limit int) (int, error) {
+	delivered := 0
+	for i := 0; i < limit; i++ {
+		event, err := ob.outboxRepo.ClaimNext(ctx, outboxLease)
+		if err != nil {
+			return delivered, err
+		}
+		if event == nil {
+			break
+		}
+
+		if ob.deliver(ctx, *event) {
+			delivered++
+		}
+	}
+
+	return delivered, nil
+}
+
+func (ob *OutboxService) PendingCount(ctx context.Context) (int64, error) {
+	return ob.outboxRepo.CountPending(ctx)
+}
+
+// dispatchEvent delivers a newly stored event. It runs detached from the
+// request that stored it, which may be long finished.
+func (ob *OutboxService) dispatchEvent(eventID primitive.ObjectID) {
+	ctx, cancel := context.WithTimeout(context.Background(), outboxLease)
+	defer cancel()
+
+	event, err := ob.outboxRepo.Claim(ctx, eventID, outboxLease)
+	if err != nil {
+		logrus.Warnf("Failed to claim outbox event %s, leaving it to the worker: %v", eventID.Hex(), err)
+		return
+	}
+	if event == nil {
+		return
+	}
+
+	ob.deliver(ctx, *event)
+}
+
+// deliver makes an attempt at a claimed event and records how it went
+func (ob *OutboxService) deliver(ctx context.Context, event models.OutboxEvent) bool {
+	err := ob.send(ctx, event)
+	if err == nil {
+		if err := ob.outboxRepo.MarkDispatched(ctx, event.ID); err != nil {
+			// The lease runs out and the event goes out again, which
+			// receivers handle through its idempotency key
+			logrus.Errorf("Failed to mark outbox event %s dispatched: %v", event.ID.Hex(), err)
+		}
+		return true
+	}
+
+	if event.Attempts >= maxOutboxAttempts {
+		logrus.Errorf("Giving up on outbox event %s (%s) after %d attempts: %v", event.ID.Hex(), event.Kind, event.Attempts, err)
+		if err := ob.outboxRepo.MarkFailed(ctx, event.ID, err.Error()); err != nil {
+			logrus.Errorf("Failed to mark outbox event %s failed: %v", event.ID.Hex(), err)
+		}
+		return false
+	}
+
+	logrus.Warnf("Outbox event %s (%s) attempt %d failed: %v", event.ID.Hex(), event.Kind, event.Attempts, err)
+	retryAt := time.Now().Add(outboxRetryDelay(event.Attempts))
+	if err := ob.outboxRepo.MarkRetry(ctx, event.ID, retryAt, err.Error()); err != nil {
+		logrus.Errorf("Failed to reschedule outbox event %s: %v", event.ID.Hex(), err)
+	}
+	return false
+}
+
+// outboxRetryDelay doubles the wait after each failed attempt, from 2s
+// up to maxOutboxRetryDelay
+func outboxRetryDelay(attempts int) time.Duration {
+	if attempts > 8 {
+		return maxOutboxRetryDelay
+	}
+	return time.Duration(1<<uint(attempts)) * time.Second
+}
+
+// send hands an event to the hub or the notification service. Events go
+// out with their idempotency key, so a repeat after a lost acknowledgement
+// can be recognised.
+func (ob *OutboxService) send(ctx context.Context, event models.OutboxEvent) error {
+	switch event.Kind {
+	case models.OutboxKindCircleBroadcast:
+		if ob.websocketHub == nil {
+			return errors.New("websocket hub not configured")
+		}
+
+		var payload models.OutboxCircleBroadcast
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			return err
+		}
+
+		payload.Message.EventID = event.IdempotencyKey
+		filter := websocket.MessageFilter{
+			ExcludeUsers: payload.ExcludeUserIDs,
+			IncludeUsers: payload.IncludeUserIDs,
+		}
+		if !ob.websocketHub.TryBroadcastFiltered(payload.CircleID, payload.Message, filter) {
+			return errors.New("broadcast channel full")
+		}
+		return nil
+
+	case models.OutboxKindPlaceEvent:
+		if ob.websocketHub == nil {
+			return errors.New("websocket hub not configured")
+		}
+
+		var payload models.OutboxPlaceEvent
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			return err
+		}
+
+		message := models.WSMessage{
+			Type:      models.WSTypePlaceEvent,
+			Data:      payload.PlaceEvent,
+			Timestamp: payload.PlaceEvent.Timestamp,
+			EventID:   event.IdempotencyKey,
+		}
+
+		// A retry goes to every circle again; the ones that already got
+		// it drop the repeat by its event id
+		for _, circleID := range payload.CircleIDs {
+			if !ob.websocketHub.TryBroadcastMessage(circleID, message) {
+				return errors.New("broadcast channel full")
+			}
+		}
+		return nil
+
+	case models.OutboxKindNotification:
+		if ob.notificationService == nil {
+			return errors.New("notification service not configured")
+		}
+
+		var req models.SendNotificationRequest
+		if err := json.Unmarshal(event.Payload, &req); err != nil {
+			return err
+		}
+
+		if req.Metadata == nil {
+			req.Metadata = make(map[string]interface{})
+		}
+		req.Metadata["idempotencyKey"] = event.IdempotencyKey
+		return ob.notificationService.SendNotification(ctx, req)
+
+	default:
+		return fmt.Errorf("unknown outbox event kind %q", event.Kind)
+	}
+}
@@ -0,0 +1,273 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"ftrack/models"
+	"ftrack/testharness"
+	"ftrack/websocket"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// deadProcess stands in for an instance killed while delivering: its hub
+// and notification service take events and never return
+type deadProcess struct {
+	claimed chan string
+	release chan struct{}
+}
+
+func newDeadProcess(t *testing.T) *deadProcess {
+	p := &deadProcess{claimed: make(chan string, 16), release: make(chan struct{})}
+	t.Cleanup(func() { close(p.release) })
+	return p
+}
+
+func (p *deadProcess) hang(what string) bool {
+	p.claimed <- what
+	<-p.release
+	return false
+}
+
+func (p *deadProcess) TryBroadcastMessage(roomID string, message models.WSMessage) bool {
+	return p.hang(message.Type)
+}
+
+func (p *deadProcess) TryBroadcastFiltered(roomID string, message models.WSMessage, filter websocket.MessageFilter) bool {
+	return p.hang(message.Type)
+}
+
+func (p *deadProcess) SendNotification(ctx context.Context, req models.SendNotificationRequest) error {
+	p.hang(req.Type)
+	return errors.New("killed")
+}
+
+// waitForClaims waits until the dead process took n events
+func (p *deadProcess) waitForClaims(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-p.claimed:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("dead process took %d events, want %d", i, n)
+		}
+	}
+}
+
+// restartOutbox starts a new instance once the dead one's leases ran out
+// and has it dispatch what is due
+func restartOutbox(t *testing.T, env *testharness.Env) (*OutboxService, int) {
+	t.Helper()
+	ctx := context.Background()
+
+	restarted := NewOutboxService(env.Repos.Outbox, env.Hub, env.Notifier)
+	if delivered, err := restarted.DispatchDue(ctx, 10); err != nil || delivered != 0 {
+		t.Fatalf("dispatching leased events = %d, %v; want none delivered", delivered, err)
+	}
+
+	expireOutboxLeases(t, env)
+	delivered, err := restarted.DispatchDue(ctx, 10)
+	if err != nil {
+		t.Fatalf("DispatchDue: %v", err)
+	}
+	return restarted, delivered
+}
+
+func expireOutboxLeases(t *testing.T, env *testharness.Env) {
+	t.Helper()
+	_, err := env.DB.Collection("outbox_events").UpdateMany(context.Background(),
+		bson.M{"status": models.OutboxStatusPending},
+		bson.M{"$set": bson.M{"availableAt": time.Now().Add(-time.Second)}},
+	)
+	if err != nil {
+		t.Fatalf("expiring leases: %v", err)
+	}
+}
+
+func TestOutboxMessageDeliveredAfterCrash(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ctx := context.Background()
+
+	dead := newDeadProcess(t)
+	ms := newTestMessageService(env)
+	ms.ConfigureOutbox(NewOutboxService(env.Repos.Outbox, dead, dead))
+
+	alice, bob := env.Factory.User(), env.Factory.User()
+	circle := env.Factory.Circle(alice, []*models.User{bob})
+
+	message, err := ms.SendMessage(ctx, alice.ID.Hex(), models.SendMessageRequest{CircleID: circle.ID.Hex(), Type: "text", Content: "hello"})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	dead.waitForClaims(t, 1)
+
+	restarted, delivered := restartOutbox(t, env)
+	if delivered != 1 {
+		t.Fatalf("restart delivered %d events, want 1", delivered)
+	}
+	broadcasts := env.Hub.Broadcasts(models.WSTypeMessage)
+	if len(broadcasts) != 1 || broadcasts[0].RoomID != circle.ID.Hex() || broadcasts[0].Message.EventID != "message:"+message.ID.Hex() {
+		t.Fatalf("broadcasts after restart = %+v, want the message to the circle with its key", broadcasts)
+	}
+	if data := broadcasts[0].Message.Data.(models.WSMessageData); data.MessageID != message.ID.Hex() || data.Content != "hello" {
+		t.Errorf("broadcast data = %+v, want the message", data)
+	}
+	if pending, err := restarted.PendingCount(ctx); err != nil || pending != 0 {
+		t.Errorf("pending after restart = %d, %v; want 0", pending, err)
+	}
+}
+
+func TestOutboxMembershipChangeDeliveredAfterCrash(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ctx := context.Background()
+
+	dead := newDeadProcess(t)
+	cs := newTestCircleService(env)
+	cs.ConfigureOutbox(NewOutboxService(env.Repos.Outbox, dead, dead))
+
+	alice, bob := env.Factory.User(), env.Factory.User()
+	circle := env.Factory.Circle(alice, []*models.User{bob})
+
+	if err := cs.RemoveMember(ctx, alice.ID.Hex(), circle.ID.Hex(), bob.ID.Hex()); err != nil {
+		t.Fatalf("RemoveMember: %v", err)
+	}
+	dead.waitForClaims(t, 2)
+
+	if _, delivered := restartOutbox(t, env); delivered != 2 {
+		t.Fatalf("restart delivered %d events, want 2", delivered)
+	}
+	updates := env.Hub.Broadcasts(models.WSTypeCircleUpdate)
+	if len(updates) != 1 || updates[0].Message.Data.(models.WSCircleUpdate).UserID != bob.ID.Hex() {
+		t.Errorf("circle updates after restart = %+v, want bob leaving", updates)
+	}
+	removed := env.Notifier.Sent("circle_member_removed")
+	if len(removed) != 1 || removed[0].Recipients[0] != bob.ID.Hex() || removed[0].Metadata["idempotencyKey"] == nil {
+		t.Errorf("removal notifications after restart = %+v, want one to bob with its key", removed)
+	}
+}
+
+func TestOutboxEventStoredBeforeDispatch(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ctx := context.Background()
+
+	// The process died after storing the event, before dispatching it
+	payload, _ := json.Marshal(models.OutboxPlaceEvent{
+		UserID:     "user-1",
+		CircleIDs:  []string{"circle-1", "circle-2"},
+		PlaceEvent: models.WSPlaceEvent{UserID: "user-1", PlaceID: "place-1", EventType: "enter"},
+	})
+	inserted, err := env.Repos.Outbox.Enqueue(ctx, &models.OutboxEvent{
+		Kind:           models.OutboxKindPlaceEvent,
+		IdempotencyKey: "place_event:location-1:place-1:enter",
+		Payload:        payload,
+	})
+	if err != nil || !inserted {
+		t.Fatalf("Enqueue = %v, %v", inserted, err)
+	}
+
+	ob := NewOutboxService(env.Repos.Outbox, env.Hub, env.Notifier)
+	if delivered, err := ob.DispatchDue(ctx, 10); err != nil || delivered != 1 {
+		t.Fatalf("DispatchDue = %d, %v; want 1 delivered", delivered, err)
+	}
+	events := env.Hub.Broadcasts(models.WSTypePlaceEvent)
+	if len(events) != 2 {
+		t.Fatalf("%d place events broadcast, want one per circle", len(events))
+	}
+	for _, event := range events {
+		if event.Message.EventID != "place_event:location-1:place-1:enter" {
+			t.Errorf("place event id = %q, want the idempotency key", event.Message.EventID)
+		}
+	}
+
+	// Delivered events aren't delivered again
+	if delivered, err := ob.DispatchDue(ctx, 10); err != nil || delivered != 0 {
+		t.Errorf("second DispatchDue = %d, %v; want nothing left", delivered, err)
+	}
+}
+
+func TestOutboxIdempotencyAndRetry(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ctx := context.Background()
+
+	// A notification service that is down
+	down := &testharness.FakeNotifier{Err: errors.New("push down")}
+	ob := NewOutboxService(env.Repos.Outbox, env.Hub, down)
+
+	req := models.SendNotificationRequest{Recipients: []string{"user-1"}, Title: "Hi", Type: "test"}
+	for i := 0; i < 2; i++ {
+		if err := ob.EnqueueNotification(ctx, "notification:1", req); err != nil {
+			t.Fatalf("EnqueueNotification: %v", err)
+		}
+	}
+	down.WaitForSent(t, "test", 1)
+
+	// The failed attempt is scheduled for a retry
+	var event models.OutboxEvent
+	waitForOutboxEvent(t, env, "notification:1", func(e models.OutboxEvent) bool { return e.LastError != "" }, &event)
+	if count, _ := env.DB.Collection("outbox_events").CountDocuments(ctx, bson.M{}); count != 1 {
+		t.Errorf("%d events stored for one key, want 1", count)
+	}
+	if event.Status != models.OutboxStatusPending || event.Attempts != 1 || event.LastError != "push down" || !event.AvailableAt.After(time.Now()) {
+		t.Errorf("failed event = %+v, want pending with a later retry", event)
+	}
+	if len(down.Sent("test")) != 1 {
+		t.Errorf("%d sends for one key, want 1", len(down.Sent("test")))
+	}
+
+	// Once the service is back the retry goes through
+	expireOutboxLeases(t, env)
+	ob = NewOutboxService(env.Repos.Outbox, env.Hub, env.Notifier)
+	if delivered, err := ob.DispatchDue(ctx, 10); err != nil || delivered != 1 {
+		t.Fatalf("retry DispatchDue = %d, %v; want 1 delivered", delivered, err)
+	}
+	sent := env.Notifier.Sent("test")
+	if len(sent) != 1 || sent[0].Metadata["idempotencyKey"] != "notification:1" {
+		t.Errorf("sent = %+v, want the notification with its key", sent)
+	}
+	waitForOutboxEvent(t, env, "notification:1", func(e models.OutboxEvent) bool { return e.Status == models.OutboxStatusDispatched }, &event)
+	if event.LastError != "" || event.DispatchedAt == nil {
+		t.Errorf("delivered event = %+v, want dispatched without an error", event)
+	}
+}
+
+func TestOutboxRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{8, 256 * time.Second},
+		{9, maxOutboxRetryDelay},
+		{30, maxOutboxRetryDelay},
+	}
+	for _, tt := range tests {
+		if got := outboxRetryDelay(tt.attempts); got != tt.want {
+			t.Errorf("outboxRetryDelay(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+// waitForOutboxEvent waits until the event with the key is in the state
+func waitForOutboxEvent(t *testing.T, env *testharness.Env, key string, state func(models.OutboxEvent) bool, event *models.OutboxEvent) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := env.DB.Collection("outbox_events").FindOne(context.Background(), bson.M{"idempotencyKey": key}).Decode(event)
+		if err == nil && state(*event) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("outbox event %s = %+v, %v; not in the expected state", key, *event, err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"ftrack/models"
+	"ftrack/utils"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SetPlaceAlias labels a place the member can see with their own name for
+// it, or makes it their home. The label is private to the member. It
+// returns nil when the label ends up empty and is removed.
+func (ps *PlaceService) SetPlaceAlias(ctx context.Context, userID, placeID string, req models.PlaceAliasRequest) (*models.PlaceAlias, error) {
+	place, err := ps.GetPlace(ctx, userID, placeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if validationErrors := ps.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+	if req.Alias == nil && req.IsHome == nil {
+		return nil, utils.NewValidationFailedError("an alias or isHome is needed")
+	}
+
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	if req.Alias != nil {
+		alias := strings.TrimSpace(*req.Alias)
+		req.Alias = &alias
+	}
+
+	return ps.placeRepo.SetPlaceAlias(ctx, userObjectID, place.ID, req.Alias, req.IsHome)
+}
+
+// DeletePlaceAlias removes the member's alias and home mark from a place
+func (ps *PlaceService) DeletePlaceAlias(ctx context.Context, userID, placeID string) error {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return errors.New("invalid user ID")
+	}
+	placeObjectID, err := primitive.ObjectIDFromHex(placeID)
+	if err != nil {
+		return errors.New("invalid place ID")
+	}
+
+	return ps.placeRepo.DeletePlaceAlias(ctx, userObjectID, placeObjectID)
+}
+
+// applyPlaceAliases fills in the member's own alias and home mark on the
+// places. Failures are logged and leave the places as they are.
+func (ps *PlaceService) applyPlaceAliases(ctx context.Context, userID string, places ...*models.Place) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil || len(places) == 0 {
+		return
+	}
+
+	placeIDs := make([]primitive.ObjectID, 0, len(places))
+	for _, place := range places {
+		placeIDs = append(placeIDs, place.ID)
+	}
+
+	aliases, err := ps.placeRepo.GetUserPlaceAliases(ctx, userObjectID, placeIDs)
+	if err != nil {
+		logrus.Warnf("Failed to get place aliases of user %s: %v", userID, err)
+		return
+	}
+
+	for _, place := range places {
+		if label, ok := aliases[place.ID]; ok {
+			place.Alias = label.Alias
+			place.IsHome = label.IsHome
+		}
+	}
+}
+
+// clearPlaceAliases removes the members' labels for a deleted place, and
+// lets them know, since their home or alias is gone with it
+func (ps *PlaceService) clearPlaceAliases(ctx context.Context, place *models.Place) {
+	labels, err := ps.placeRepo.DeletePlaceAliases(ctx, place.ID)
+	if err != nil {
+		logrus.Errorf("Failed to clear aliases of place %s: %v", place.ID.Hex(), err)
+		return
+	}
+	if ps.notificationService == nil {
+		return
+	}
+
+	for _, label := range labels {
+		name := place.Name
+		if label.Alias != "" {
+			name = label.Alias
+		}
+
+		message := fmt.Sprintf("%s was deleted, so your alias for it was removed.", name)
+		if label.IsHome {
+			message = fmt.Sprintf("%s, your home place, was deleted. Choose another place as your home.", name)
+		}
+
+		err := ps.notificationService.SendNotification(ctx, models.SendNotificationRequest{
+			Recipients: []string{label.UserID.Hex()},
+			Title:      "Place deleted",
+			Message:    message,
+			Type:       models.NotificationTypePlaceDeleted,
+			Priority:   "normal",
+			Category:   "place",
+			Data: map[string]interface{}{
+				"placeId": place.ID.Hex(),
+				"wasHome": label.IsHome,
+			},
+			DeliveryChannels: []string{"push"},
+		})
+		if err != nil {
+			logrus.Errorf("Failed to notify user %s of deleted place %s: %v", label.UserID.Hex(), place.ID.Hex(), err)
+		}
+	}
+}
+
+// RenderMemberPlaceNotification renders a place notification for one
+// recipient. The place goes by the recipient's alias for it, and a member
+// arriving at or leaving their own home is said to have arrived home or
+// left home, unless the place has templates of its own. systemTemplate,
+// the recipient's text of the event's system template, replaces the
+// default text when set.
+func RenderMemberPlaceNotification(place *models.Place, eventType, memberName string, at time.Time, recipientAlias string, memberHome bool, systemTemplate string) (string, string) {
+	labelled := *place
+	if recipientAlias != "" {
+		labelled.Name = recipientAlias
+	}
+	if systemTemplate == "" && memberHome {
+		systemTemplate = models.DefaultSystemTemplates[models.SystemTemplateKey(eventType, true)]
+	}
+	if systemTemplate != "" {
+		if eventType == "departure" && labelled.Notifications.DepartureTemplate == "" {
+			labelled.Notifications.DepartureTemplate = systemTemplate
+		}
+		if eventType != "departure" && labelled.Notifications.ArrivalTemplate == "" {
+			labelled.Notifications.ArrivalTemplate = systemTemplate
+		}
+	}
+
+	return RenderPlaceNotification(&labelled, eventType, memberName, at)
+}
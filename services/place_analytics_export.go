@@ -0,0 +1,575 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"ftrack/models"
+	"ftrack/utils"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PDF chart layout, in millimetres
+const (
+	pdfChartHeight   = 40.0
+	pdfChartMaxLabel = 16 // bars labelled at most, the rest every few
+)
+
+var placeAnalyticsWeekdays = []string{"Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday", "Sunday"}
+
+// ExportPlaceAnalytics starts a report of the visits, check-ins and
+// ratings of the place over a date range, as CSV or as a PDF with charts.
+// Only the place's owner, the members it's shared with as admins and the
+// admins of its circle can export it. Visitors are only ever counted, and
+// periods or peak times with fewer than models.MinPlaceAnalyticsVisitors
+// visitors are left out.
+func (ps *PlaceService) ExportPlaceAnalytics(ctx context.Context, userID, placeID string, req models.ExportPlaceAnalyticsRequest) (*models.MessageExport, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+	if validationErrors := ps.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+	if req.Format == "" {
+		req.Format = "csv"
+	}
+
+	from, to, err := placeAnalyticsRange(req, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	place, err := ps.placeRepo.GetByID(ctx, placeID)
+	if err != nil {
+		return nil, err
+	}
+	if err := ps.requirePlaceAnalyticsAccess(ctx, userID, place); err != nil {
+		return nil, err
+	}
+
+	export := models.MessageExport{
+		UserID:    userObjectID,
+		PlaceID:   place.ID,
+		Type:      models.ExportTypePlaceAnalytics,
+		Format:    req.Format,
+		DateRange: models.ExportDateRange{From: &from, To: &to},
+		ExpiresAt: time.Now().Add(7 * 24 * time.Hour), // 7 days
+	}
+
+	err = ps.exportService.StartExport(ctx, &export, func(jobCtx context.Context, w io.Writer, progress func(int)) (int, error) {
+		report, err := ps.placeAnalyticsReport(jobCtx, userID, place, from, to)
+		if err != nil {
+			return 0, err
+		}
+		progress(50)
+
+		if req.Format == "pdf" {
+			return len(report.Periods), ps.writePlaceAnalyticsPDF(jobCtx, w, report)
+		}
+		return len(report.Periods), writePlaceAnalyticsCSV(jobCtx, w, report)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("User %s started an analytics export of place %s", userID, placeID)
+	return &export, nil
+}
+
+// placeAnalyticsRange returns the range a report covers, the last
+// models.DefaultPlaceAnalyticsDays days unless the request says otherwise
+func placeAnalyticsRange(req models.ExportPlaceAnalyticsRequest, now time.Time) (time.Time, time.Time, error) {
+	to := now
+	if req.To != nil {
+		to = *req.To
+	}
+	from := to.AddDate(0, 0, -models.DefaultPlaceAnalyticsDays)
+	if req.From != nil {
+		from = *req.From
+	}
+
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, utils.NewValidationFailedError("from must be before to")
+	}
+	if to.Sub(from) > models.MaxPlaceAnalyticsDays*24*time.Hour {
+		return time.Time{}, time.Time{}, utils.NewValidationFailedError(fmt.Sprintf("a report can cover at most %d days", models.MaxPlaceAnalyticsDays))
+	}
+	return from, to, nil
+}
+
+// requirePlaceAnalyticsAccess returns "access denied" unless the user owns
+// the place, it's shared with them as an admin, or they're an admin of its
+// circle
+func (ps *PlaceService) requirePlaceAnalyticsAccess(ctx context.Context, userID string, place *models.Place) error {
+	if place.UserID.Hex() == userID {
+		return nil
+	}
+	for _, member := range place.Sharing.SharedWith {
+		if member.UserID.Hex() == userID && member.Role == "admin" {
+			return nil
+		}
+	}
+	if place.IsShared && !place.CircleID.IsZero() {
+		return ps.requireCircleAdmin(ctx, place.CircleID.Hex(), userID)
+	}
+	return errors.New("access denied")
+}
+
+// placeAnalyticsReport adds up the place's activity over the range, day by
+// day or, for long ranges, week by week in the place's timezone. Check-ins
+// count if the user may see them, reviews if they're public.
+func (ps *PlaceService) placeAnalyticsReport(ctx context.Context, userID string, place *models.Place, from, to time.Time) (*models.PlaceAnalyticsReport, error) {
+	location, err := time.LoadLocation(place.Hours.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	timezone := location.String()
+
+	period := models.PlaceAnalyticsPeriodDay
+	if to.Sub(from) > models.MaxDailyPlaceAnalyticsDays*24*time.Hour {
+		period = models.PlaceAnalyticsPeriodWeek
+	}
+
+	visits, err := ps.placeRepo.GetPlaceVisitAnalytics(ctx, place.ID, from, to, period, timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	viewerID, _ := primitive.ObjectIDFromHex(userID)
+	mateIDs, err := ps.circleMateIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	checkins, err := ps.placeRepo.GetPlaceCheckinTrend(ctx, place.ID, from, to, period, timezone, viewerID, mateIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	reviews, err := ps.placeRepo.GetPlaceReviewTrend(ctx, place.ID, from, to, period, timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &models.PlaceAnalyticsReport{
+		PlaceID:   place.ID.Hex(),
+		PlaceName: place.Name,
+		From:      from,
+		To:        to,
+		Timezone:  timezone,
+		Period:    period,
+		Totals:    placeAnalyticsPeriod(visits.Totals),
+	}
+
+	byStart := make(map[int64]*models.PlaceAnalyticsPeriod)
+	for start := placeAnalyticsPeriodStart(from.In(location), period); start.Before(to); start = nextPlaceAnalyticsPeriod(start, period) {
+		report.Periods = append(report.Periods, placeAnalyticsPeriod(models.PlaceVisitBucket{Start: start}))
+	}
+	for i := range report.Periods {
+		byStart[report.Periods[i].Start.Unix()] = &report.Periods[i]
+	}
+
+	for _, bucket := range visits.Periods {
+		if p := byStart[bucket.Start.Unix()]; p != nil {
+			start := p.Start
+			*p = placeAnalyticsPeriod(bucket)
+			p.Start = start
+		}
+	}
+	for _, bucket := range checkins {
+		if p := byStart[bucket.Start.Unix()]; p != nil {
+			p.Checkins = bucket.Count
+		}
+		report.Totals.Checkins += bucket.Count
+	}
+	ratingSum := 0
+	for _, bucket := range reviews {
+		if p := byStart[bucket.Start.Unix()]; p != nil {
+			p.Reviews = bucket.Count
+			p.AverageRating = averageRating(bucket.RatingSum, bucket.Count)
+		}
+		report.Totals.Reviews += bucket.Count
+		ratingSum += bucket.RatingSum
+	}
+	report.Totals.AverageRating = averageRating(ratingSum, report.Totals.Reviews)
+
+	hours := make(map[int]models.PlaceVisitBucket)
+	for _, bucket := range visits.Hours {
+		hours[bucket.Hour] = bucket
+	}
+	for hour := 0; hour < 24; hour++ {
+		report.Hours = append(report.Hours, models.PlaceAnalyticsPeak{
+			Label:  fmt.Sprintf("%02d:00", hour),
+			Visits: placeAnalyticsVisits(hours[hour]),
+		})
+	}
+
+	weekdays := make(map[int]models.PlaceVisitBucket)
+	for _, bucket := range visits.Weekdays {
+		weekdays[bucket.Weekday] = bucket
+	}
+	for i, name := range placeAnalyticsWeekdays {
+		report.Weekdays = append(report.Weekdays, models.PlaceAnalyticsPeak{
+			Label:  name,
+			Visits: placeAnalyticsVisits(weekdays[i+1]),
+		})
+	}
+
+	return report, nil
+}
+
+// placeAnalyticsPeriod reports the visits of a bucket, or nothing about
+// them when too few people made them
+func placeAnalyticsPeriod(bucket models.PlaceVisitBucket) models.PlaceAnalyticsPeriod {
+	period := models.PlaceAnalyticsPeriod{Start: bucket.Start}
+	if visits := placeAnalyticsVisits(bucket); visits != nil {
+		visitors := bucket.Visitors
+		period.Visits = visits
+		period.UniqueVisitors = &visitors
+		if bucket.Visits > 0 {
+			duration := bucket.Duration / int64(bucket.Visits)
+			period.AverageDuration = &duration
+		}
+	}
+	return period
+}
+
+// placeAnalyticsVisits is the bucket's visit count, nil when it has fewer
+// than models.MinPlaceAnalyticsVisitors visitors. A bucket without visits
+// gives nothing away and reports zero.
+func placeAnalyticsVisits(bucket models.PlaceVisitBucket) *int {
+	visits := bucket.Visits
+	if visits > 0 && bucket.Visitors < models.MinPlaceAnalyticsVisitors {
+		return nil
+	}
+	return &visits
+}
+
+func averageRating(sum, count int) *float64 {
+	if count == 0 {
+		return nil
+	}
+	average := math.Round(float64(sum)/float64(count)*10) / 10
+	return &average
+}
+
+// placeAnalyticsPeriodStart is the start of the day or week the time falls
+// in, in its location. Weeks start on Monday, as in the database.
+func placeAnalyticsPeriodStart(t time.Time, period string) time.Time {
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	if period == models.PlaceAnalyticsPeriodWeek {
+		start = start.AddDate(0, 0, -((int(start.Weekday()) + 6) % 7))
+	}
+	return start
+}
+
+func nextPlaceAnalyticsPeriod(start time.Time, period string) time.Time {
+	if period == models.PlaceAnalyticsPeriodWeek {
+		return start.AddDate(0, 0, 7)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+func formatOptionalInt(value *int) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.Itoa(*value)
+}
+
+func formatOptionalRating(value *float64) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*value, 'f', 1, 64)
+}
+
+func formatOptionalMinutes(seconds *int64) string {
+	if seconds == nil {
+		return ""
+	}
+	return strconv.FormatInt(*seconds/60, 10)
+}
+
+// writePlaceAnalyticsCSV writes the report as a summary followed by the
+// periods, hours and weekdays, each under its own header. Hidden figures
+// are left empty.
+func writePlaceAnalyticsCSV(ctx context.Context, w io.Writer, report *models.PlaceAnalyticsReport) error {
+	if err := writeExportStamp(ctx, w, models.ExportTypePlaceAnalytics); err != nil {
+		return err
+	}
+
+	totals := report.Totals
+	rows := [][]string{
+		{"metric", "value"},
+		{"place", report.PlaceName},
+		{"from", report.From.UTC().Format(time.RFC3339)},
+		{"to", report.To.UTC().Format(time.RFC3339)},
+		{"timezone", report.Timezone},
+		{"period", report.Period},
+		{"visits", formatOptionalInt(totals.Visits)},
+		{"uniqueVisitors", formatOptionalInt(totals.UniqueVisitors)},
+		{"averageDurationMinutes", formatOptionalMinutes(totals.AverageDuration)},
+		{"checkins", strconv.Itoa(totals.Checkins)},
+		{"reviews", strconv.Itoa(totals.Reviews)},
+		{"averageRating", formatOptionalRating(totals.AverageRating)},
+		{"minimumVisitors", strconv.Itoa(models.MinPlaceAnalyticsVisitors)},
+		{},
+		{"periodStart", "visits", "uniqueVisitors", "averageDurationMinutes", "checkins", "reviews", "averageRating"},
+	}
+	for _, period := range report.Periods {
+		rows = append(rows, []string{
+			period.Start.Format("2006-01-02"),
+			formatOptionalInt(period.Visits),
+			formatOptionalInt(period.UniqueVisitors),
+			formatOptionalMinutes(period.AverageDuration),
+			strconv.Itoa(period.Checkins),
+			strconv.Itoa(period.Reviews),
+			formatOptionalRating(period.AverageRating),
+		})
+	}
+
+	rows = append(rows, []string{}, []string{"hour", "visits"})
+	for _, peak := range report.Hours {
+		rows = append(rows, []string{peak.Label, formatOptionalInt(peak.Visits)})
+	}
+	rows = append(rows, []string{}, []string{"weekday", "visits"})
+	for _, peak := range report.Weekdays {
+		rows = append(rows, []string{peak.Label, formatOptionalInt(peak.Visits)})
+	}
+
+	csvWriter := csv.NewWriter(w)
+	if err := csvWriter.WriteAll(rows); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// writePlaceAnalyticsPDF renders the report as a summary and bar charts of
+// visits, check-ins, ratings and peak times
+func (ps *PlaceService) writePlaceAnalyticsPDF(ctx context.Context, w io.Writer, report *models.PlaceAnalyticsReport) (err error) {
+	// The PDF library panics on some malformed input, fail the export
+	// rather than the process
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("pdf rendering failed: %v", recovered)
+		}
+	}()
+
+	face := &pdfFace{family: pdfBuiltinFont}
+	if config := ps.exportService.PDFConfig(); config.FontPath != "" {
+		if loaded, err := loadPDFFace(pdfMainFont, config.FontPath); err == nil {
+			face = loaded
+		} else {
+			logrus.Warnf("Failed to load PDF export font %s, using built-in font: %v", config.FontPath, err)
+		}
+	}
+
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(pdfMargin, pdfMargin, pdfMargin)
+	pdf.SetAutoPageBreak(true, pdfMargin)
+	pdf.AliasNbPages("")
+	pdf.SetTitle(report.PlaceName+" analytics", true)
+	pdf.SetCreator("FTrack", true)
+	pdf.SetKeywords(exportHeader(ctx, models.ExportTypePlaceAnalytics).Stamp(), true)
+
+	encode := func(text string) string { return text }
+	if face.font != nil {
+		pdf.AddUTF8FontFromBytes(face.family, "", face.data)
+		pdf.AddUTF8FontFromBytes(face.family, "B", face.data)
+	} else {
+		encode = pdf.UnicodeTranslatorFromDescriptor("")
+	}
+
+	watermark := ""
+	if mark, ok := watermarkFromContext(ctx); ok {
+		requestedBy := mark.RequestedBy
+		if ps.userRepo != nil {
+			if user, err := ps.userRepo.GetByID(ctx, mark.RequestedBy); err == nil {
+				requestedBy = strings.TrimSpace(user.FirstName + " " + user.LastName)
+			}
+		}
+		watermark = fmt.Sprintf("Exported by %s on %s UTC, export %s",
+			requestedBy, mark.RequestedAt.UTC().Format("2006-01-02 15:04"), mark.ExportID)
+	}
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-pdfMargin + 3)
+		pdf.SetFont(face.family, "", pdfMetaFontSize)
+		pdf.SetTextColor(128, 128, 128)
+		if watermark != "" {
+			pdf.CellFormat(0, pdfLineHeight, encode(watermark), "", 2, "C", false, 0, "")
+		}
+		pdf.CellFormat(0, pdfLineHeight, fmt.Sprintf("Page %d of {nb}", pdf.PageNo()), "", 0, "C", false, 0, "")
+	})
+
+	pdf.AddPage()
+	pdf.SetTextColor(0, 0, 0)
+	pdf.SetFont(face.family, "B", pdfTitleFontSize)
+	pdf.CellFormat(0, pdfLineHeight*2, encode(report.PlaceName), "", 1, "L", false, 0, "")
+
+	to := report.To.Add(-time.Nanosecond)
+	subtitle := fmt.Sprintf("Place analytics, %s, by %s. Times are in %s. Figures for fewer than %d visitors are hidden.",
+		formatPDFDateRange(&report.From, &to), report.Period, report.Timezone, models.MinPlaceAnalyticsVisitors)
+	pdf.SetFont(face.family, "", pdfMetaFontSize)
+	pdf.SetTextColor(110, 110, 110)
+	pdf.MultiCell(0, pdfLineHeight, encode(subtitle), "", "L", false)
+	pdf.Ln(pdfLineHeight)
+
+	totals := report.Totals
+	summary := [][2]string{
+		{"Visits", placeAnalyticsPDFValue(formatOptionalInt(totals.Visits))},
+		{"Unique visitors", placeAnalyticsPDFValue(formatOptionalInt(totals.UniqueVisitors))},
+		{"Average visit", placeAnalyticsPDFValue(formatOptionalMinutes(totals.AverageDuration), " min")},
+		{"Check-ins", strconv.Itoa(totals.Checkins)},
+		{"Reviews", strconv.Itoa(totals.Reviews)},
+		{"Average rating", placeAnalyticsPDFValue(formatOptionalRating(totals.AverageRating), " / 5")},
+	}
+	pdf.SetTextColor(0, 0, 0)
+	for _, row := range summary {
+		pdf.SetFont(face.family, "", pdfBodyFontSize)
+		pdf.CellFormat(45, pdfLineHeight+1, row[0], "", 0, "L", false, 0, "")
+		pdf.SetFont(face.family, "B", pdfBodyFontSize)
+		pdf.CellFormat(0, pdfLineHeight+1, row[1], "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(pdfLineHeight)
+
+	labels := make([]string, len(report.Periods))
+	visits := make([]*float64, len(report.Periods))
+	checkins := make([]*float64, len(report.Periods))
+	ratings := make([]*float64, len(report.Periods))
+	for i, period := range report.Periods {
+		labels[i] = period.Start.Format("Jan 2")
+		visits[i] = optionalFloat(period.Visits)
+		checkin := float64(period.Checkins)
+		checkins[i] = &checkin
+		ratings[i] = period.AverageRating
+	}
+	unit := "day"
+	if report.Period == models.PlaceAnalyticsPeriodWeek {
+		unit = "week"
+	}
+
+	drawPDFBarChart(pdf, face.family, "Visits per "+unit, labels, visits)
+	drawPDFBarChart(pdf, face.family, "Check-ins per "+unit, labels, checkins)
+	drawPDFBarChart(pdf, face.family, "Average rating per "+unit, labels, ratings)
+
+	peakLabels, peakVisits := placeAnalyticsPeakSeries(report.Hours)
+	drawPDFBarChart(pdf, face.family, "Visits by hour of the day", peakLabels, peakVisits)
+	peakLabels, peakVisits = placeAnalyticsPeakSeries(report.Weekdays)
+	drawPDFBarChart(pdf, face.family, "Visits by day of the week", peakLabels, peakVisits)
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := pdf.Error(); err != nil {
+		return err
+	}
+	return pdf.Output(w)
+}
+
+// placeAnalyticsPDFValue shows a hidden figure as such in the summary
+func placeAnalyticsPDFValue(value string, suffix ...string) string {
+	if value == "" {
+		return "Hidden"
+	}
+	return value + strings.Join(suffix, "")
+}
+
+func optionalFloat(value *int) *float64 {
+	if value == nil {
+		return nil
+	}
+	f := float64(*value)
+	return &f
+}
+
+func placeAnalyticsPeakSeries(peaks []models.PlaceAnalyticsPeak) ([]string, []*float64) {
+	labels := make([]string, len(peaks))
+	values := make([]*float64, len(peaks))
+	for i, peak := range peaks {
+		labels[i] = peak.Label
+		if len(peaks) == 24 {
+			labels[i] = strconv.Itoa(i)
+		} else if len(peak.Label) > 3 {
+			labels[i] = peak.Label[:3]
+		}
+		values[i] = optionalFloat(peak.Visits)
+	}
+	return labels, values
+}
+
+// drawPDFBarChart draws a titled bar chart the width of the page, moving
+// to a new page when it doesn't fit. Nil values are hidden figures and are
+// marked in grey along the axis.
+func drawPDFBarChart(pdf *fpdf.Fpdf, family, title string, labels []string, values []*float64) {
+	if len(values) == 0 {
+		return
+	}
+
+	_, pageHeight := pdf.GetPageSize()
+	if pdf.GetY()+pdfChartHeight+pdfLineHeight*4 > pageHeight-pdfMargin*2 {
+		pdf.AddPage()
+	}
+
+	pdf.SetTextColor(0, 0, 0)
+	pdf.SetFont(family, "B", pdfBodyFontSize)
+	pdf.CellFormat(0, pdfLineHeight+1, title, "", 1, "L", false, 0, "")
+
+	maxValue := 0.0
+	for _, value := range values {
+		if value != nil && *value > maxValue {
+			maxValue = *value
+		}
+	}
+
+	pageWidth, _ := pdf.GetPageSize()
+	left := pdfMargin + 10
+	width := pageWidth - pdfMargin - left
+	top := pdf.GetY() + 2
+	bottom := top + pdfChartHeight
+	slot := width / float64(len(values))
+
+	// Scale
+	pdf.SetFont(family, "", pdfMetaFontSize)
+	pdf.SetTextColor(110, 110, 110)
+	pdf.SetDrawColor(200, 200, 200)
+	pdf.Line(left, bottom, left+width, bottom)
+	pdf.Line(left, top, left, bottom)
+	pdf.SetXY(pdfMargin, top-pdfLineHeight/2)
+	pdf.CellFormat(9, pdfLineHeight, strconv.FormatFloat(maxValue, 'f', -1, 64), "", 0, "R", false, 0, "")
+	pdf.SetXY(pdfMargin, bottom-pdfLineHeight/2)
+	pdf.CellFormat(9, pdfLineHeight, "0", "", 0, "R", false, 0, "")
+
+	every := (len(values) + pdfChartMaxLabel - 1) / pdfChartMaxLabel
+	for i, value := range values {
+		x := left + float64(i)*slot + slot*0.15
+		barWidth := slot * 0.7
+
+		switch {
+		case value == nil:
+			pdf.SetFillColor(210, 210, 210)
+			pdf.Rect(x, bottom-1, barWidth, 1, "F")
+		case maxValue > 0 && *value > 0:
+			height := *value / maxValue * pdfChartHeight
+			pdf.SetFillColor(66, 133, 244)
+			pdf.Rect(x, bottom-height, barWidth, height, "F")
+		}
+
+		if i%every == 0 {
+			pdf.SetXY(left+float64(i)*slot-slot, bottom+1)
+			pdf.CellFormat(slot*3, pdfLineHeight, labels[i], "", 0, "C", false, 0, "")
+		}
+	}
+
+	pdf.SetXY(pdfMargin, bottom+pdfLineHeight+3)
+}
@@ -0,0 +1,339 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+
+	"ftrack/models"
+	"ftrack/testharness"
+	"ftrack/utils"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// placeAnalyticsRows reads an analytics CSV, after its stamp line, keyed by
+// the first column of each row
+func placeAnalyticsRows(t *testing.T, data []byte) map[string][]string {
+	t.Helper()
+	_, body, _ := bytes.Cut(data, []byte("\n"))
+	reader := csv.NewReader(bytes.NewReader(body))
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		t.Fatalf("reading CSV: %v", err)
+	}
+	rows := make(map[string][]string)
+	for _, record := range records {
+		if len(record) > 0 && record[0] != "" {
+			rows[record[0]] = record[1:]
+		}
+	}
+	return rows
+}
+
+func TestPlaceAnalyticsRange(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	at := func(days int) *time.Time {
+		day := now.AddDate(0, 0, days)
+		return &day
+	}
+
+	tests := []struct {
+		name     string
+		from, to *time.Time
+		wantFrom time.Time
+		wantTo   time.Time
+		err      string
+	}{
+		{"default", nil, nil, now.AddDate(0, 0, -30), now, ""},
+		{"from only", at(-7), nil, now.AddDate(0, 0, -7), now, ""},
+		{"to only", nil, at(-10), now.AddDate(0, 0, -40), now.AddDate(0, 0, -10), ""},
+		{"a year", at(-366), nil, now.AddDate(0, 0, -366), now, ""},
+		{"longer than a year", at(-367), nil, time.Time{}, time.Time{}, "a report can cover at most 366 days"},
+		{"backwards", at(-1), at(-2), time.Time{}, time.Time{}, "from must be before to"},
+		{"empty", at(-1), at(-1), time.Time{}, time.Time{}, "from must be before to"},
+	}
+	for _, tt := range tests {
+		from, to, err := placeAnalyticsRange(models.ExportPlaceAnalyticsRequest{From: tt.from, To: tt.to}, now)
+		if reason := utils.ValidationFailureReason(err); reason != tt.err {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			continue
+		}
+		if !from.Equal(tt.wantFrom) || !to.Equal(tt.wantTo) {
+			t.Errorf("%s: range %v to %v, want %v to %v", tt.name, from, to, tt.wantFrom, tt.wantTo)
+		}
+	}
+}
+
+func TestPlaceAnalyticsHidesFewVisitors(t *testing.T) {
+	tests := []struct {
+		name     string
+		bucket   models.PlaceVisitBucket
+		visits   string
+		visitors string
+		minutes  string
+	}{
+		{"no visits", models.PlaceVisitBucket{}, "0", "0", ""},
+		{"one visitor", models.PlaceVisitBucket{Visits: 5, Visitors: 1, Duration: 3000}, "", "", ""},
+		{"two visitors", models.PlaceVisitBucket{Visits: 2, Visitors: 2, Duration: 1200}, "", "", ""},
+		{"three visitors", models.PlaceVisitBucket{Visits: 4, Visitors: 3, Duration: 9000}, "4", "3", "37"},
+	}
+	for _, tt := range tests {
+		period := placeAnalyticsPeriod(tt.bucket)
+		visits, visitors, minutes := formatOptionalInt(period.Visits), formatOptionalInt(period.UniqueVisitors), formatOptionalMinutes(period.AverageDuration)
+		if visits != tt.visits || visitors != tt.visitors || minutes != tt.minutes {
+			t.Errorf("%s: %q visits by %q visitors for %q minutes, want %q by %q for %q", tt.name, visits, visitors, minutes, tt.visits, tt.visitors, tt.minutes)
+		}
+	}
+
+	if rating := averageRating(0, 0); rating != nil {
+		t.Errorf("average of no ratings = %v, want none", *rating)
+	}
+	if rating := averageRating(14, 3); rating == nil || *rating != 4.7 {
+		t.Errorf("average of 14 over 3 ratings = %v, want 4.7", rating)
+	}
+}
+
+func TestPlaceAnalyticsPeriodStart(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no timezone data: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		t      time.Time
+		period string
+		want   string
+	}{
+		{"day", time.Date(2026, 10, 17, 23, 30, 0, 0, ny), models.PlaceAnalyticsPeriodDay, "2026-10-17 Sat"},
+		{"week from a saturday", time.Date(2026, 10, 17, 23, 30, 0, 0, ny), models.PlaceAnalyticsPeriodWeek, "2026-10-12 Mon"},
+		{"week from a monday", time.Date(2026, 10, 12, 0, 0, 0, 0, ny), models.PlaceAnalyticsPeriodWeek, "2026-10-12 Mon"},
+		{"week from a sunday", time.Date(2026, 10, 18, 8, 0, 0, 0, ny), models.PlaceAnalyticsPeriodWeek, "2026-10-12 Mon"},
+	}
+	for _, tt := range tests {
+		start := placeAnalyticsPeriodStart(tt.t, tt.period)
+		if got := start.Format("2006-01-02 Mon"); got != tt.want || start.Location() != ny || start.Hour() != 0 {
+			t.Errorf("%s: period starts %v, want midnight %s", tt.name, start, tt.want)
+		}
+	}
+
+	// Days stay midnight to midnight across the end of daylight saving
+	day := time.Date(2026, 10, 31, 0, 0, 0, 0, ny)
+	for i := 0; i < 3; i++ {
+		day = nextPlaceAnalyticsPeriod(day, models.PlaceAnalyticsPeriodDay)
+		if day.Hour() != 0 {
+			t.Errorf("day after daylight saving starts at %v", day)
+		}
+	}
+}
+
+func TestWritePlaceAnalyticsReport(t *testing.T) {
+	four, three, zero := 4, 3, 0
+	duration := int64(2250)
+	rating := 4.5
+	start := time.Date(2026, 10, 12, 0, 0, 0, 0, time.UTC)
+	report := &models.PlaceAnalyticsReport{
+		PlaceName: "Corner Café",
+		From:      start,
+		To:        start.AddDate(0, 0, 2),
+		Timezone:  "UTC",
+		Period:    models.PlaceAnalyticsPeriodDay,
+		Totals:    models.PlaceAnalyticsPeriod{Visits: &four, UniqueVisitors: &three, AverageDuration: &duration, Checkins: 2, Reviews: 2, AverageRating: &rating},
+		Periods: []models.PlaceAnalyticsPeriod{
+			{Start: start, Visits: &four, UniqueVisitors: &three, AverageDuration: &duration, Reviews: 2, AverageRating: &rating},
+			{Start: start.AddDate(0, 0, 1), Checkins: 2},
+		},
+		Hours:    []models.PlaceAnalyticsPeak{{Label: "09:00", Visits: &zero}, {Label: "10:00", Visits: &four}, {Label: "18:00"}},
+		Weekdays: []models.PlaceAnalyticsPeak{{Label: "Monday", Visits: &four}, {Label: "Tuesday"}},
+	}
+	ctx := context.Background()
+
+	var out bytes.Buffer
+	if err := writePlaceAnalyticsCSV(ctx, &out, report); err != nil {
+		t.Fatalf("writePlaceAnalyticsCSV: %v", err)
+	}
+	_, body, _ := strings.Cut(out.String(), "\n")
+	want := strings.Join([]string{
+		"metric,value",
+		"place,Corner Café",
+		"from,2026-10-12T00:00:00Z",
+		"to,2026-10-14T00:00:00Z",
+		"timezone,UTC",
+		"period,day",
+		"visits,4",
+		"uniqueVisitors,3",
+		"averageDurationMinutes,37",
+		"checkins,2",
+		"reviews,2",
+		"averageRating,4.5",
+		"minimumVisitors,3",
+		"",
+		"periodStart,visits,uniqueVisitors,averageDurationMinutes,checkins,reviews,averageRating",
+		"2026-10-12,4,3,37,0,2,4.5",
+		"2026-10-13,,,,2,0,",
+		"",
+		"hour,visits",
+		"09:00,0",
+		"10:00,4",
+		"18:00,",
+		"",
+		"weekday,visits",
+		"Monday,4",
+		"Tuesday,",
+		"",
+	}, "\n")
+	if body != want {
+		t.Errorf("CSV =\n%s\nwant\n%s", body, want)
+	}
+
+	ps := &PlaceService{exportService: NewExportService(nil, t.TempDir())}
+	out.Reset()
+	if err := ps.writePlaceAnalyticsPDF(ctx, &out, report); err != nil {
+		t.Fatalf("writePlaceAnalyticsPDF: %v", err)
+	}
+	if !bytes.HasPrefix(out.Bytes(), []byte("%PDF-")) {
+		t.Errorf("PDF starts %q", out.Bytes()[:min(out.Len(), 8)])
+	}
+}
+
+func TestExportPlaceAnalytics(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	es := NewExportService(env.Repos.Export, t.TempDir())
+	ps := NewPlaceService(env.Repos.Place, env.Repos.Circle, es)
+	ctx := context.Background()
+
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no timezone data: %v", err)
+	}
+
+	owner, circleAdmin, member, sharedAdmin, viewer, stranger := env.Factory.User(), env.Factory.User(), env.Factory.User(), env.Factory.User(), env.Factory.User(), env.Factory.User()
+	circle := env.Factory.Circle(circleAdmin, []*models.User{owner, member})
+	place := env.Factory.Place(owner, testharness.InCircle(circle), func(place *models.Place) {
+		place.Name = "Corner Café"
+		place.IsShared = true
+		place.Hours.Timezone = "America/New_York"
+		place.Sharing.SharedWith = []models.PlaceMember{{UserID: sharedAdmin.ID, Role: "admin"}, {UserID: viewer.ID, Role: "viewer"}}
+	})
+	elsewhere := env.Factory.Place(owner)
+
+	// Three days ago three people came in the morning and one of them again
+	// in the evening; two days ago only two came
+	today := placeAnalyticsPeriodStart(time.Now().In(ny), models.PlaceAnalyticsPeriodDay)
+	busyDay, quietDay := today.AddDate(0, 0, -3), today.AddDate(0, 0, -2)
+	at := func(day time.Time, hour int) time.Time {
+		return time.Date(day.Year(), day.Month(), day.Day(), hour, 0, 0, 0, ny)
+	}
+	visit := func(place *models.Place, visitor *models.User, arrival time.Time, minutes int64) {
+		t.Helper()
+		if err := env.Repos.Place.CreateVisit(ctx, &models.PlaceVisit{PlaceID: place.ID, UserID: visitor.ID, ArrivalTime: arrival, Duration: minutes * 60}); err != nil {
+			t.Fatalf("CreateVisit: %v", err)
+		}
+	}
+	a, b, c := env.Factory.User(), env.Factory.User(), env.Factory.User()
+	visit(place, a, at(busyDay, 10), 30)
+	visit(place, b, at(busyDay, 10), 30)
+	visit(place, c, at(busyDay, 10), 30)
+	visit(place, a, at(busyDay, 18), 60)
+	visit(place, a, at(quietDay, 10), 20)
+	visit(place, b, at(quietDay, 10), 20)
+	visit(place, c, today.AddDate(0, 0, -20), 20)
+	visit(elsewhere, c, at(busyDay, 10), 20)
+
+	// Check-ins the owner may see, and one they may not
+	checkin := func(author *models.User, visibility string) {
+		t.Helper()
+		if err := env.Repos.Place.CreateCheckin(ctx, &models.PlaceCheckin{PlaceID: place.ID, UserID: author.ID, Visibility: visibility}); err != nil {
+			t.Fatalf("CreateCheckin: %v", err)
+		}
+	}
+	checkin(owner, models.CheckinVisibilityPrivate)
+	checkin(member, models.CheckinVisibilityCircle)
+	checkin(stranger, models.CheckinVisibilityCircle)
+
+	// Public reviews count, private and hidden ones don't
+	review := func(author *models.User, rating int, public bool, moderation string) {
+		t.Helper()
+		if err := env.Repos.Place.CreateReview(ctx, &models.PlaceReview{PlaceID: place.ID, UserID: author.ID, Rating: rating, IsPublic: public, ModerationStatus: moderation}); err != nil {
+			t.Fatalf("CreateReview: %v", err)
+		}
+	}
+	review(a, 4, true, "")
+	review(b, 5, true, "")
+	review(c, 1, false, "")
+	review(member, 1, true, models.ReviewModerationHidden)
+
+	from := today.AddDate(0, 0, -7)
+	export, err := ps.ExportPlaceAnalytics(ctx, owner.ID.Hex(), place.ID.Hex(), models.ExportPlaceAnalyticsRequest{From: &from})
+	if err != nil {
+		t.Fatalf("ExportPlaceAnalytics: %v", err)
+	}
+	if export.Type != models.ExportTypePlaceAnalytics || export.Format != "csv" || export.PlaceID != place.ID {
+		t.Errorf("export = %+v, want a CSV place analytics export", export)
+	}
+	rows := placeAnalyticsRows(t, waitForExport(t, es, owner.ID.Hex(), export.ID.Hex()))
+
+	expect := func(key string, want ...string) {
+		t.Helper()
+		if got := strings.Join(rows[key], ","); got != strings.Join(want, ",") {
+			t.Errorf("row %s = %s, want %s", key, got, strings.Join(want, ","))
+		}
+	}
+	expect("place", "Corner Café")
+	expect("timezone", "America/New_York")
+	expect("period", "day")
+	expect("visits", "6")
+	expect("uniqueVisitors", "3")
+	expect("averageDurationMinutes", "31")
+	expect("checkins", "2")
+	expect("reviews", "2")
+	expect("averageRating", "4.5")
+	expect(busyDay.Format("2006-01-02"), "4", "3", "37", "0", "0", "")
+	expect(quietDay.Format("2006-01-02"), "", "", "", "0", "0", "")
+	expect(today.AddDate(0, 0, -1).Format("2006-01-02"), "0", "0", "", "0", "0", "")
+	expect(today.Format("2006-01-02"), "0", "0", "", "2", "2", "4.5")
+	expect("10:00", "5")
+	expect("18:00", "")
+	expect("11:00", "0")
+	expect(busyDay.Weekday().String(), "4")
+	expect(quietDay.Weekday().String(), "")
+	if _, ok := rows[today.AddDate(0, 0, -8).Format("2006-01-02")]; ok {
+		t.Error("report has a day before the range")
+	}
+
+	// Long ranges are reported by week, and as PDF on request
+	longAgo := today.AddDate(0, 0, -90)
+	export, err = ps.ExportPlaceAnalytics(ctx, sharedAdmin.ID.Hex(), place.ID.Hex(), models.ExportPlaceAnalyticsRequest{From: &longAgo, Format: "pdf"})
+	if err != nil {
+		t.Fatalf("ExportPlaceAnalytics as PDF: %v", err)
+	}
+	if data := waitForExport(t, es, sharedAdmin.ID.Hex(), export.ID.Hex()); !bytes.HasPrefix(data, []byte("%PDF-")) {
+		t.Errorf("PDF export starts %q", data[:min(len(data), 8)])
+	}
+	export, err = ps.ExportPlaceAnalytics(ctx, circleAdmin.ID.Hex(), place.ID.Hex(), models.ExportPlaceAnalyticsRequest{From: &longAgo})
+	if err != nil {
+		t.Fatalf("ExportPlaceAnalytics by the circle admin: %v", err)
+	}
+	if period := placeAnalyticsRows(t, waitForExport(t, es, circleAdmin.ID.Hex(), export.ID.Hex()))["period"]; strings.Join(period, "") != "week" {
+		t.Errorf("90 day report by %v, want week", period)
+	}
+
+	for _, user := range []*models.User{member, viewer, stranger} {
+		if _, err := ps.ExportPlaceAnalytics(ctx, user.ID.Hex(), place.ID.Hex(), models.ExportPlaceAnalyticsRequest{}); err == nil || err.Error() != "access denied" {
+			t.Errorf("export by a user who can't manage the place error = %v, want access denied", err)
+		}
+	}
+	if _, err := ps.ExportPlaceAnalytics(ctx, owner.ID.Hex(), place.ID.Hex(), models.ExportPlaceAnalyticsRequest{Format: "xlsx"}); err == nil || err.Error() != "validation failed" {
+		t.Errorf("export as xlsx error = %v, want validation failed", err)
+	}
+	notID := primitive.NewObjectID().Hex()
+	if _, err := ps.ExportPlaceAnalytics(ctx, owner.ID.Hex(), notID, models.ExportPlaceAnalyticsRequest{}); err == nil {
+		t.Error("export of an unknown place succeeded")
+	}
+}
@@ -0,0 +1,387 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"ftrack/models"
+	"ftrack/repositories"
+	"ftrack/utils"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const checkinLeaderboardSize = 10
+
+// ConfigureCheckinNotifications notifies a check-in's audience when it is
+// made
+func (ps *PlaceService) ConfigureCheckinNotifications(notificationService NotificationDispatcher) {
+	ps.notificationService = notificationService
+}
+
+// ConfigureCheckinMedia lets check-ins carry a photo from the media
+// endpoint. Without it check-ins with a mediaId are rejected.
+func (ps *PlaceService) ConfigureCheckinMedia(mediaRepo *repositories.MediaRepository) {
+	ps.mediaRepo = mediaRepo
+}
+
+func (ps *PlaceService) CheckIn(ctx context.Context, userID, placeID string, req models.CheckInToPlaceRequest) (*models.CheckinResponse, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	if validationErrors := ps.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	place, err := ps.placeRepo.GetByID(ctx, placeID)
+	if err != nil {
+		return nil, err
+	}
+
+	media, err := ps.checkinMedia(ctx, userID, req.MediaID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Older clients only send isPublic
+	visibility := req.Visibility
+	if visibility == "" {
+		visibility = models.CheckinVisibilityCircle
+		if req.IsPublic != nil && !*req.IsPublic {
+			visibility = models.CheckinVisibilityPrivate
+		}
+	}
+
+	mateIDs, err := ps.circleMateIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	sharedWith, err := checkinSharedWith(visibility, req.SharedWith, mateIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	checkin := &models.PlaceCheckin{
+		PlaceID:    place.ID,
+		UserID:     userObjectID,
+		Message:    req.Message,
+		IsPublic:   visibility == models.CheckinVisibilityCircle,
+		Location:   req.Location,
+		Visibility: visibility,
+		SharedWith: sharedWith,
+		Status:     strings.TrimSpace(req.Status),
+		Mood:       strings.TrimSpace(req.Mood),
+		Caption:    strings.TrimSpace(req.Caption),
+		Media:      media,
+	}
+
+	err = ps.placeRepo.CreateCheckin(ctx, checkin)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &models.CheckinResponse{
+		PlaceCheckin: checkin,
+		Audience:     checkinAudience(checkin, mateIDs),
+	}
+
+	ps.notifyCheckinAudience(ctx, place, checkin, response.Audience.Viewers)
+
+	return response, nil
+}
+
+// UpdateCheckinVisibility changes who sees one of the user's check-ins.
+// Listings, leaderboards and feeds follow right away; nobody is notified
+// again.
+func (ps *PlaceService) UpdateCheckinVisibility(ctx context.Context, userID, placeID, checkinID string, req models.UpdateCheckinRequest) (*models.CheckinResponse, error) {
+	if validationErrors := ps.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	checkin, err := ps.placeRepo.GetCheckinByID(ctx, checkinID)
+	if err != nil {
+		return nil, err
+	}
+	if checkin.PlaceID.Hex() != placeID {
+		return nil, errors.New("checkin not found")
+	}
+	if checkin.UserID.Hex() != userID {
+		return nil, errors.New("access denied")
+	}
+
+	mateIDs, err := ps.circleMateIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	sharedWith, err := checkinSharedWith(req.Visibility, req.SharedWith, mateIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	checkin.Visibility = req.Visibility
+	checkin.SharedWith = sharedWith
+	checkin.IsPublic = req.Visibility == models.CheckinVisibilityCircle
+
+	if err := ps.placeRepo.UpdateCheckinVisibility(ctx, checkin); err != nil {
+		return nil, err
+	}
+
+	return &models.CheckinResponse{
+		PlaceCheckin: checkin,
+		Audience:     checkinAudience(checkin, mateIDs),
+	}, nil
+}
+
+// GetPlaceCheckins returns the place's check-ins the viewer may see
+func (ps *PlaceService) GetPlaceCheckins(ctx context.Context, userID, placeID string, page, pageSize int) ([]models.PlaceCheckin, int64, error) {
+	viewerID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, 0, errors.New("invalid user ID")
+	}
+
+	mateIDs, err := ps.circleMateIDs(ctx, userID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return ps.placeRepo.GetPlaceCheckins(ctx, placeID, viewerID, mateIDs, page, pageSize)
+}
+
+// GetCheckinLeaderboard ranks the place's visitors by the check-ins the
+// viewer may see. The viewer's own private check-ins count for them.
+func (ps *PlaceService) GetCheckinLeaderboard(ctx context.Context, userID, placeID string) ([]models.CheckinLeaderboardEntry, error) {
+	viewerID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	mateIDs, err := ps.circleMateIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return ps.placeRepo.GetCheckinLeaderboard(ctx, placeID, viewerID, mateIDs, checkinLeaderboardSize)
+}
+
+// GetCircleCheckinFeed returns the check-ins by the given members of the
+// circle that the viewer may see, as activity feed entries
+func (ps *PlaceService) GetCircleCheckinFeed(ctx context.Context, userID string, circle *models.Circle, authorIDs []primitive.ObjectID, page, pageSize int) ([]models.CircleActivity, int64, error) {
+	viewerID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, 0, errors.New("invalid user ID")
+	}
+
+	mateIDs, err := ps.circleMateIDs(ctx, userID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	checkins, total, err := ps.placeRepo.GetCheckinFeed(ctx, authorIDs, viewerID, mateIDs, page, pageSize)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	activities := make([]models.CircleActivity, 0, len(checkins))
+	for _, checkin := range checkins {
+		activities = append(activities, models.CircleActivity{
+			ID:       checkin.ID,
+			CircleID: circle.ID,
+			UserID:   checkin.UserID,
+			Type:     "place",
+			Action:   "checkin",
+			Data: map[string]interface{}{
+				"checkinId":  checkin.ID.Hex(),
+				"placeId":    checkin.PlaceID.Hex(),
+				"message":    checkin.Message,
+				"visibility": checkin.Visibility,
+				"status":     checkin.Status,
+				"mood":       checkin.Mood,
+				"caption":    checkin.Caption,
+				"media":      checkin.Media,
+			},
+			CreatedAt: checkin.CreatedAt,
+		})
+	}
+
+	return activities, total, nil
+}
+
+// checkinMedia resolves the photo attached to a check-in. Only images the
+// user uploaded themselves may be attached.
+func (ps *PlaceService) checkinMedia(ctx context.Context, userID, mediaID string) (*models.CheckinMedia, error) {
+	if mediaID == "" {
+		return nil, nil
+	}
+	if ps.mediaRepo == nil {
+		return nil, utils.NewValidationFailedError("check-in photos are not available")
+	}
+
+	media, err := ps.mediaRepo.GetByID(ctx, mediaID)
+	if err != nil {
+		if err.Error() == "media not found" || err.Error() == "invalid media ID" {
+			return nil, utils.NewValidationFailedError("mediaId does not refer to an uploaded photo")
+		}
+		return nil, err
+	}
+	if media.UploadedBy != userID {
+		return nil, errors.New("access denied")
+	}
+	if media.Type != "image" && !strings.HasPrefix(media.MimeType, "image/") {
+		return nil, utils.NewValidationFailedError("check-in media must be an image")
+	}
+
+	return &models.CheckinMedia{
+		ID:           media.ID,
+		URL:          media.URL,
+		ThumbnailURL: media.ThumbnailURL,
+	}, nil
+}
+
+// circleMateIDs returns the users who share an active circle membership
+// with the user, the audience of their circle check-ins
+func (ps *PlaceService) circleMateIDs(ctx context.Context, userID string) ([]primitive.ObjectID, error) {
+	circles, err := ps.circleRepo.GetUserCircles(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[primitive.ObjectID]bool)
+	mateIDs := []primitive.ObjectID{}
+	for i := range circles {
+		self := findCircleMember(&circles[i], userID)
+		if self == nil || self.Status != "active" {
+			continue
+		}
+
+		for _, member := range circles[i].Members {
+			if member.Status != "active" || member.UserID.Hex() == userID || seen[member.UserID] {
+				continue
+			}
+			seen[member.UserID] = true
+			mateIDs = append(mateIDs, member.UserID)
+		}
+	}
+
+	return mateIDs, nil
+}
+
+// checkinSharedWith validates a visibility and returns the members a
+// selected check-in is shared with, who must be the author's circle mates
+func checkinSharedWith(visibility string, sharedWith []string, mateIDs []primitive.ObjectID) ([]primitive.ObjectID, error) {
+	switch visibility {
+	case models.CheckinVisibilityCircle, models.CheckinVisibilityPrivate:
+		return nil, nil
+	case models.CheckinVisibilitySelected:
+	default:
+		return nil, utils.NewValidationFailedError("visibility must be circle, selected or private")
+	}
+
+	if len(sharedWith) == 0 {
+		return nil, utils.NewValidationFailedError("selected check-ins need at least one member in sharedWith")
+	}
+
+	mates := make(map[string]bool, len(mateIDs))
+	for _, mateID := range mateIDs {
+		mates[mateID.Hex()] = true
+	}
+
+	seen := make(map[string]bool)
+	var objectIDs []primitive.ObjectID
+	for _, id := range sharedWith {
+		if !mates[id] {
+			return nil, utils.NewValidationFailedError("sharedWith may only contain members of your circles")
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		objectIDs = append(objectIDs, utils.ObjectIDFromHex(id))
+	}
+
+	return objectIDs, nil
+}
+
+func checkinAudience(checkin *models.PlaceCheckin, mateIDs []primitive.ObjectID) models.CheckinAudience {
+	var viewerIDs []primitive.ObjectID
+	switch checkin.Visibility {
+	case models.CheckinVisibilityCircle:
+		viewerIDs = mateIDs
+	case models.CheckinVisibilitySelected:
+		viewerIDs = checkin.SharedWith
+	}
+
+	viewers := make([]string, 0, len(viewerIDs))
+	for _, id := range viewerIDs {
+		viewers = append(viewers, id.Hex())
+	}
+
+	return models.CheckinAudience{
+		Visibility:  checkin.Visibility,
+		ViewerCount: len(viewers),
+		Viewers:     viewers,
+	}
+}
+
+// notifyCheckinAudience tells the members who can see a check-in about it.
+// Private check-ins notify nobody.
+func (ps *PlaceService) notifyCheckinAudience(ctx context.Context, place *models.Place, checkin *models.PlaceCheckin, viewers []string) {
+	if ps.notificationService == nil || len(viewers) == 0 {
+		return
+	}
+
+	authorName := "A circle member"
+	if ps.userRepo != nil {
+		if author, err := ps.userRepo.GetByID(ctx, checkin.UserID.Hex()); err == nil {
+			authorName = author.FirstName
+		}
+	}
+
+	message := fmt.Sprintf("%s checked in at %s", authorName, place.Name)
+	if checkin.Status != "" {
+		message = fmt.Sprintf("%s: %s", message, checkin.Status)
+	}
+
+	data := map[string]interface{}{
+		"checkinId": checkin.ID.Hex(),
+		"placeId":   place.ID.Hex(),
+	}
+	if checkin.Status != "" {
+		data["status"] = checkin.Status
+	}
+	if checkin.Mood != "" {
+		data["mood"] = checkin.Mood
+	}
+	if checkin.Caption != "" {
+		data["caption"] = checkin.Caption
+	}
+
+	// The photo shows in the push
+	var attachment *models.NotificationAttachment
+	if checkin.Media != nil {
+		data["mediaId"] = checkin.Media.ID.Hex()
+		attachment = &models.NotificationAttachment{Kind: models.AttachmentMediaThumbnail, ResourceID: checkin.Media.ID.Hex()}
+	}
+
+	err := ps.notificationService.SendNotification(ctx, models.SendNotificationRequest{
+		Recipients:       viewers,
+		Title:            fmt.Sprintf("%s checked in", authorName),
+		Message:          message,
+		Type:             models.NotificationTypeCheckin,
+		Priority:         "low",
+		Category:         "place",
+		Data:             data,
+		Attachment:       attachment,
+		DeliveryChannels: []string{"push"},
+		SubjectUserID:    checkin.UserID.Hex(),
+	})
+	if err != nil {
+		logrus.Errorf("Failed to send check-in notification: %v", err)
+	}
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"ftrack/models"
+	"ftrack/testharness"
+	"ftrack/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// uploadedMedia stores media as the media endpoint would
+func uploadedMedia(t *testing.T, env *testharness.Env, uploader *models.User, mimeType string) primitive.ObjectID {
+	t.Helper()
+	media := &models.MessageMediaExtended{MessageMedia: models.MessageMedia{
+		URL:          "https://cdn.example.com/" + primitive.NewObjectID().Hex(),
+		ThumbnailURL: "https://cdn.example.com/thumb.jpg",
+		Type:         strings.SplitN(mimeType, "/", 2)[0],
+		MimeType:     mimeType,
+		UploadedBy:   uploader.ID.Hex(),
+		UploadedAt:   time.Now(),
+	}}
+	if err := env.Repos.Media.Create(context.Background(), media); err != nil {
+		t.Fatalf("creating media: %v", err)
+	}
+	return media.ID
+}
+
+func TestPlaceCheckInWithStatusAndMedia(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ps := newTestPlaceService(env)
+	ps.ConfigureCheckinMedia(env.Repos.Media)
+	ctx := context.Background()
+
+	alice, bob := env.Factory.User(), env.Factory.User()
+	circle := env.Factory.Circle(alice, []*models.User{bob})
+	place := env.Factory.Place(alice, func(place *models.Place) { place.Name = "Corner Cafe" })
+	here := models.Location{Latitude: place.Latitude, Longitude: place.Longitude}
+
+	// Without a photo
+	plain, err := ps.CheckIn(ctx, alice.ID.Hex(), place.ID.Hex(), models.CheckInToPlaceRequest{
+		Status: "  grabbing coffee ☕ ", Mood: "sleepy", Location: here,
+	})
+	if err != nil {
+		t.Fatalf("CheckIn: %v", err)
+	}
+	if plain.Status != "grabbing coffee ☕" || plain.Mood != "sleepy" || plain.Media != nil {
+		t.Errorf("check-in status %q, mood %q, media %+v; want the trimmed status and no photo", plain.Status, plain.Mood, plain.Media)
+	}
+	sent := env.Notifier.Sent(models.NotificationTypeCheckin)
+	if len(sent) != 1 {
+		t.Fatalf("%d check-in notifications, want 1", len(sent))
+	}
+	data := sent[0].Data.(map[string]interface{})
+	if sent[0].Message != "A circle member checked in at Corner Cafe: grabbing coffee ☕" || sent[0].Attachment != nil ||
+		data["mood"] != "sleepy" || data["mediaId"] != nil {
+		t.Errorf("notification %q with data %v and attachment %+v, want the status and no photo", sent[0].Message, data, sent[0].Attachment)
+	}
+
+	// The plain check-in is an hour old by the time of the next one
+	if _, err := env.DB.Collection("place_checkins").UpdateOne(ctx, bson.M{"_id": plain.ID}, bson.M{"$set": bson.M{"createdAt": time.Now().Add(-time.Hour)}}); err != nil {
+		t.Fatalf("dating check-in: %v", err)
+	}
+
+	// With a photo
+	photoID := uploadedMedia(t, env, alice, "image/jpeg")
+	withPhoto, err := ps.CheckIn(ctx, alice.ID.Hex(), place.ID.Hex(), models.CheckInToPlaceRequest{
+		Status: "second cup", Caption: "latte art", MediaID: photoID.Hex(), Location: here,
+	})
+	if err != nil {
+		t.Fatalf("CheckIn: %v", err)
+	}
+	if withPhoto.Caption != "latte art" || withPhoto.Media == nil || withPhoto.Media.ID != photoID ||
+		withPhoto.Media.ThumbnailURL != "https://cdn.example.com/thumb.jpg" {
+		t.Errorf("check-in caption %q with media %+v, want the photo", withPhoto.Caption, withPhoto.Media)
+	}
+	notification := env.Notifier.WaitForSent(t, models.NotificationTypeCheckin, 2)[1]
+	data = notification.Data.(map[string]interface{})
+	if notification.Attachment == nil || notification.Attachment.Kind != models.AttachmentMediaThumbnail ||
+		notification.Attachment.ResourceID != photoID.Hex() || data["caption"] != "latte art" || data["mediaId"] != photoID.Hex() {
+		t.Errorf("notification with data %v and attachment %+v, want the photo's thumbnail", data, notification.Attachment)
+	}
+
+	// Circle mates see the latest status on the leaderboard, and both in
+	// the feed
+	leaderboard, err := ps.GetCheckinLeaderboard(ctx, bob.ID.Hex(), place.ID.Hex())
+	if err != nil {
+		t.Fatalf("GetCheckinLeaderboard: %v", err)
+	}
+	if len(leaderboard) != 1 || leaderboard[0].Checkins != 2 || leaderboard[0].Status != "second cup" || leaderboard[0].Mood != "" {
+		t.Errorf("leaderboard = %+v, want alice's two check-ins with her latest status", leaderboard)
+	}
+
+	feed, _, err := ps.GetCircleCheckinFeed(ctx, bob.ID.Hex(), circle, []primitive.ObjectID{alice.ID}, 1, 10)
+	if err != nil {
+		t.Fatalf("GetCircleCheckinFeed: %v", err)
+	}
+	if len(feed) != 2 {
+		t.Fatalf("%d feed entries, want 2", len(feed))
+	}
+	media, _ := feed[0].Data["media"].(*models.CheckinMedia)
+	if feed[0].Data["status"] != "second cup" || media == nil || media.ID != photoID {
+		t.Errorf("latest feed entry = %v, want the status and photo", feed[0].Data)
+	}
+	if feed[1].Data["status"] != "grabbing coffee ☕" || feed[1].Data["mood"] != "sleepy" || feed[1].Data["media"].(*models.CheckinMedia) != nil {
+		t.Errorf("earlier feed entry = %v, want the status without a photo", feed[1].Data)
+	}
+}
+
+func TestPlaceCheckInMediaValidation(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ps := newTestPlaceService(env)
+	ctx := context.Background()
+
+	alice, bob := env.Factory.User(), env.Factory.User()
+	place := env.Factory.Place(alice)
+	here := models.Location{Latitude: place.Latitude, Longitude: place.Longitude}
+	photoID := uploadedMedia(t, env, alice, "image/png")
+
+	checkIn := func(req models.CheckInToPlaceRequest) error {
+		req.Location = here
+		_, err := ps.CheckIn(ctx, alice.ID.Hex(), place.ID.Hex(), req)
+		return err
+	}
+
+	// Photos need the media store
+	if err := checkIn(models.CheckInToPlaceRequest{MediaID: photoID.Hex()}); utils.ValidationFailureReason(err) != "check-in photos are not available" {
+		t.Errorf("check-in with a photo and no media store error = %v", err)
+	}
+	ps.ConfigureCheckinMedia(env.Repos.Media)
+
+	for _, tt := range []struct {
+		name   string
+		req    models.CheckInToPlaceRequest
+		err    string
+		reason string
+	}{
+		{"with another user's photo", models.CheckInToPlaceRequest{MediaID: uploadedMedia(t, env, bob, "image/jpeg").Hex()}, "access denied", ""},
+		{"with a video", models.CheckInToPlaceRequest{MediaID: uploadedMedia(t, env, alice, "video/mp4").Hex()}, "validation failed", "check-in media must be an image"},
+		{"with unknown media", models.CheckInToPlaceRequest{MediaID: primitive.NewObjectID().Hex()}, "validation failed", "mediaId does not refer to an uploaded photo"},
+		{"with a bad media ID", models.CheckInToPlaceRequest{MediaID: "photo"}, "validation failed", "mediaId does not refer to an uploaded photo"},
+	} {
+		err := checkIn(tt.req)
+		if err == nil || err.Error() != tt.err || utils.ValidationFailureReason(err) != tt.reason {
+			t.Errorf("check-in %s error = %v (%q), want %s", tt.name, err, utils.ValidationFailureReason(err), tt.err)
+		}
+	}
+
+	// Long captions and statuses name their field
+	for field, req := range map[string]models.CheckInToPlaceRequest{
+		"caption": {Caption: strings.Repeat("a", 281)},
+		"status":  {Status: strings.Repeat("a", 61)},
+		"mood":    {Mood: strings.Repeat("a", 31)},
+	} {
+		err := checkIn(req)
+		fields := utils.ValidationFailureFields(err)
+		if err == nil || err.Error() != "validation failed" || len(fields) != 1 || fields[0].Field != field {
+			t.Errorf("check-in with a long %s error = %v with fields %+v", field, err, fields)
+		}
+	}
+	if err := checkIn(models.CheckInToPlaceRequest{Caption: strings.Repeat("a", 280), MediaID: photoID.Hex()}); err != nil {
+		t.Errorf("check-in with the longest caption: %v", err)
+	}
+}
@@ -0,0 +1,351 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ftrack/models"
+	"ftrack/utils"
+)
+
+const (
+	// Places are clustered on a grid of cells this many pixels wide, on
+	// 256 pixel map tiles
+	placeClusterCellsPerTile = 4
+
+	// Above this zoom every place is shown on its own
+	placeClusterMaxZoom = 16
+
+	// Viewports are cached briefly; panning around reuses them
+	placeClusterTTL = 30 * time.Second
+
+	maxMercatorLatitude = 85.05112878
+)
+
+// placeClusterCache keeps computed viewports keyed by circle, zoom and the
+// grid cells they cover
+type placeClusterCache struct {
+	entries map[string]*placeClusterEntry
+	mutex   sync.Mutex
+}
+
+type placeClusterEntry struct {
+	response *models.PlaceClustersResponse
+	cachedAt time.Time
+}
+
+func newPlaceClusterCache() *placeClusterCache {
+	return &placeClusterCache{
+		entries: make(map[string]*placeClusterEntry),
+	}
+}
+
+func (cc *placeClusterCache) get(key string) (*models.PlaceClustersResponse, bool) {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+
+	entry, exists := cc.entries[key]
+	if !exists || time.Since(entry.cachedAt) > placeClusterTTL {
+		return nil, false
+	}
+	return entry.response, true
+}
+
+func (cc *placeClusterCache) set(key string, response *models.PlaceClustersResponse) {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+
+	// Drop expired viewports, so panning doesn't grow the cache without bound
+	for k, entry := range cc.entries {
+		if time.Since(entry.cachedAt) > placeClusterTTL {
+			delete(cc.entries, k)
+		}
+	}
+
+	cc.entries[key] = &placeClusterEntry{
+		response: response,
+		cachedAt: time.Now(),
+	}
+}
+
+// placeCell is a cell of the clustering grid at a zoom
+type placeCell struct {
+	zoom int
+	x, y int
+}
+
+// cellsPerSide is the number of grid cells across the world at a zoom
+func cellsPerSide(zoom int) int {
+	return placeClusterCellsPerTile << zoom
+}
+
+// cellAt returns the grid cell of a coordinate in web mercator, the
+// projection maps are drawn in
+func cellAt(zoom int, latitude, longitude float64) placeCell {
+	n := float64(cellsPerSide(zoom))
+	latitude = math.Max(-maxMercatorLatitude, math.Min(maxMercatorLatitude, latitude))
+
+	x := (longitude + 180) / 360
+	sinLat := math.Sin(latitude * math.Pi / 180)
+	y := 0.5 - math.Log((1+sinLat)/(1-sinLat))/(4*math.Pi)
+
+	return placeCell{
+		zoom: zoom,
+		x:    clampCell(int(math.Floor(x*n)), zoom),
+		y:    clampCell(int(math.Floor(y*n)), zoom),
+	}
+}
+
+func clampCell(i, zoom int) int {
+	if i < 0 {
+		return 0
+	}
+	if last := cellsPerSide(zoom) - 1; i > last {
+		return last
+	}
+	return i
+}
+
+func (cell placeCell) bounds() models.GeoBounds {
+	n := float64(cellsPerSide(cell.zoom))
+	return models.GeoBounds{
+		Southwest: models.Coordinate{
+			Latitude:  mercatorLatitude(float64(cell.y+1) / n),
+			Longitude: float64(cell.x)/n*360 - 180,
+		},
+		Northeast: models.Coordinate{
+			Latitude:  mercatorLatitude(float64(cell.y) / n),
+			Longitude: float64(cell.x+1)/n*360 - 180,
+		},
+	}
+}
+
+func mercatorLatitude(y float64) float64 {
+	return math.Atan(math.Sinh(math.Pi*(1-2*y))) * 180 / math.Pi
+}
+
+// clusterID names a cell of a circle's grid, e.g. "<circleId>-12-2048-1361"
+func clusterID(circleID string, cell placeCell) string {
+	return fmt.Sprintf("%s-%d-%d-%d", circleID, cell.zoom, cell.x, cell.y)
+}
+
+func parseClusterID(id string) (string, placeCell, error) {
+	parts := strings.Split(id, "-")
+	if len(parts) != 4 {
+		return "", placeCell{}, errors.New("invalid cluster ID")
+	}
+
+	var numbers [3]int
+	for i, part := range parts[1:] {
+		number, err := strconv.Atoi(part)
+		if err != nil {
+			return "", placeCell{}, errors.New("invalid cluster ID")
+		}
+		numbers[i] = number
+	}
+
+	cell := placeCell{zoom: numbers[0], x: numbers[1], y: numbers[2]}
+	if cell.zoom < 0 || cell.zoom > placeClusterMaxZoom {
+		return "", placeCell{}, errors.New("invalid cluster ID")
+	}
+	if cell.x != clampCell(cell.x, cell.zoom) || cell.y != clampCell(cell.y, cell.zoom) {
+		return "", placeCell{}, errors.New("invalid cluster ID")
+	}
+
+	return parts[0], cell, nil
+}
+
+// parseBBox parses "minLon,minLat,maxLon,maxLat". A minimum longitude
+// greater than the maximum crosses the antimeridian.
+func parseBBox(bbox string) (models.GeoBounds, error) {
+	parts := strings.Split(bbox, ",")
+	if len(parts) != 4 {
+		return models.GeoBounds{}, utils.NewValidationFailedError("bbox must be minLon,minLat,maxLon,maxLat")
+	}
+
+	var values [4]float64
+	for i, part := range parts {
+		value, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || math.IsNaN(value) {
+			return models.GeoBounds{}, utils.NewValidationFailedError("bbox must be minLon,minLat,maxLon,maxLat")
+		}
+		values[i] = value
+	}
+
+	bounds := models.GeoBounds{
+		Southwest: models.Coordinate{Latitude: values[1], Longitude: values[0]},
+		Northeast: models.Coordinate{Latitude: values[3], Longitude: values[2]},
+	}
+	if bounds.Southwest.Latitude < -90 || bounds.Northeast.Latitude > 90 || bounds.Southwest.Latitude > bounds.Northeast.Latitude {
+		return models.GeoBounds{}, utils.NewValidationFailedError("bbox latitudes must be between -90 and 90, minimum first")
+	}
+	if bounds.Southwest.Longitude < -180 || bounds.Northeast.Longitude > 180 {
+		return models.GeoBounds{}, utils.NewValidationFailedError("bbox longitudes must be between -180 and 180")
+	}
+
+	return bounds, nil
+}
+
+// GetPlaceClusters returns the circle's places in a map viewport, clustered
+// on a grid at low zooms. The viewport is widened to whole grid cells, so a
+// cluster always counts all places of its cell wherever the viewport ends.
+func (ps *PlaceService) GetPlaceClusters(ctx context.Context, userID, circleID string, req models.PlaceClustersRequest) (*models.PlaceClustersResponse, error) {
+	bounds, err := parseBBox(req.BBox)
+	if err != nil {
+		return nil, err
+	}
+	if validationErrors := ps.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewValidationFailedError("zoom must be between 0 and 22")
+	}
+
+	isMember, err := ps.circleRepo.IsMember(ctx, circleID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !isMember {
+		return nil, errors.New("access denied")
+	}
+
+	clustered := req.Zoom <= placeClusterMaxZoom
+	var cacheKey string
+	if clustered {
+		topLeft := cellAt(req.Zoom, bounds.Northeast.Latitude, bounds.Southwest.Longitude)
+		bottomRight := cellAt(req.Zoom, bounds.Southwest.Latitude, bounds.Northeast.Longitude)
+
+		topLeftBounds := topLeft.bounds()
+		bottomRightBounds := bottomRight.bounds()
+		bounds = models.GeoBounds{
+			Southwest: models.Coordinate{
+				Latitude:  bottomRightBounds.Southwest.Latitude,
+				Longitude: topLeftBounds.Southwest.Longitude,
+			},
+			Northeast: models.Coordinate{
+				Latitude:  topLeftBounds.Northeast.Latitude,
+				Longitude: bottomRightBounds.Northeast.Longitude,
+			},
+		}
+		cacheKey = fmt.Sprintf("%s:%d:%d:%d:%d:%d", circleID, req.Zoom, topLeft.x, topLeft.y, bottomRight.x, bottomRight.y)
+	} else {
+		cacheKey = fmt.Sprintf("%s:%d:%v:%v:%v:%v", circleID, req.Zoom, bounds.Southwest.Latitude, bounds.Southwest.Longitude, bounds.Northeast.Latitude, bounds.Northeast.Longitude)
+	}
+
+	if response, ok := ps.clusters.get(cacheKey); ok {
+		return response, nil
+	}
+
+	markers, err := ps.placeRepo.GetCirclePlaceMarkers(ctx, circleID, bounds)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &models.PlaceClustersResponse{
+		CircleID:    circleID,
+		Zoom:        req.Zoom,
+		Clusters:    []models.PlaceCluster{},
+		Places:      []models.PlaceMarker{},
+		GeneratedAt: time.Now(),
+	}
+
+	if !clustered {
+		response.Places = markers
+		ps.clusters.set(cacheKey, response)
+		return response, nil
+	}
+
+	cells := make(map[placeCell][]models.PlaceMarker)
+	var order []placeCell
+	for _, marker := range markers {
+		cell := cellAt(req.Zoom, marker.Latitude, marker.Longitude)
+		if _, exists := cells[cell]; !exists {
+			order = append(order, cell)
+		}
+		cells[cell] = append(cells[cell], marker)
+	}
+
+	for _, cell := range order {
+		members := cells[cell]
+		if len(members) == 1 {
+			response.Places = append(response.Places, members[0])
+			continue
+		}
+		response.Clusters = append(response.Clusters, buildPlaceCluster(circleID, cell, members))
+	}
+
+	sort.Slice(response.Clusters, func(i, j int) bool {
+		return response.Clusters[i].ID < response.Clusters[j].ID
+	})
+
+	ps.clusters.set(cacheKey, response)
+	return response, nil
+}
+
+func buildPlaceCluster(circleID string, cell placeCell, members []models.PlaceMarker) models.PlaceCluster {
+	var latitude, longitude float64
+	categories := make(map[string]int)
+	for _, member := range members {
+		latitude += member.Latitude
+		longitude += member.Longitude
+		categories[member.Category]++
+	}
+
+	// The most common category, alphabetically first on a tie
+	category := ""
+	for name, count := range categories {
+		if count > categories[category] || (count == categories[category] && name < category) {
+			category = name
+		}
+	}
+
+	return models.PlaceCluster{
+		ID:        clusterID(circleID, cell),
+		Latitude:  latitude / float64(len(members)),
+		Longitude: longitude / float64(len(members)),
+		Count:     len(members),
+		Category:  category,
+		Bounds:    cell.bounds(),
+	}
+}
+
+// GetPlaceClusterMembers returns the places of a cluster. The cluster ID
+// names its grid cell, so the viewport isn't needed.
+func (ps *PlaceService) GetPlaceClusterMembers(ctx context.Context, userID, clusterID string) (*models.PlaceClusterMembers, error) {
+	circleID, cell, err := parseClusterID(clusterID)
+	if err != nil {
+		return nil, err
+	}
+
+	isMember, err := ps.circleRepo.IsMember(ctx, circleID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !isMember {
+		return nil, errors.New("access denied")
+	}
+
+	markers, err := ps.placeRepo.GetCirclePlaceMarkers(ctx, circleID, cell.bounds())
+	if err != nil {
+		return nil, err
+	}
+
+	// Places on a cell's edge are loaded with both cells; keep those the
+	// grid puts in this one
+	members := []models.PlaceMarker{}
+	for _, marker := range markers {
+		if cellAt(cell.zoom, marker.Latitude, marker.Longitude) == cell {
+			members = append(members, marker)
+		}
+	}
+
+	return &models.PlaceClusterMembers{
+		ClusterID: clusterID,
+		CircleID:  circleID,
+		Places:    members,
+	}, nil
+}
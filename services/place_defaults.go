@@ -0,0 +1,416 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"ftrack/models"
+	"ftrack/utils"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Settings used when neither the place nor its circle sets them
+const (
+	defaultPlaceOnArrival        = true
+	defaultPlaceOnDeparture      = true
+	defaultPlaceOnLongStay       = false
+	defaultPlaceLongStayDuration = 60 // minutes
+	defaultPlaceCooldown         = 5  // minutes
+	defaultPlaceDwellTime        = 30 // seconds
+	defaultPlaceExitDelay        = 60 // seconds
+)
+
+// Settings stored under the place's notifications rather than its geofence
+var placeNotificationSettings = []string{
+	models.PlaceSettingOnArrival,
+	models.PlaceSettingOnDeparture,
+	models.PlaceSettingOnLongStay,
+	models.PlaceSettingLongStayDuration,
+	models.PlaceSettingNotifyMembers,
+	models.PlaceSettingCooldown,
+}
+
+// ResolvePlaceSettings returns the settings of a place's geofence events.
+// Each setting comes from the place, unless the place inherits it; then from
+// the circle's defaults, and without one from the system defaults.
+func ResolvePlaceSettings(place *models.Place, defaults *models.GeofenceDefaults) *models.PlaceGeofenceSettings {
+	if defaults == nil {
+		defaults = &models.GeofenceDefaults{}
+	}
+
+	inherited := make(map[string]bool, len(place.InheritedSettings))
+	for _, key := range place.InheritedSettings {
+		inherited[key] = true
+	}
+
+	settings := &models.PlaceGeofenceSettings{
+		PlaceID:       place.ID.Hex(),
+		Notifications: place.Notifications,
+		Geofence:      place.Geofence,
+		Sources:       make(map[string]string, len(models.PlaceSettingKeys)),
+	}
+	if !place.CircleID.IsZero() {
+		settings.CircleID = place.CircleID.Hex()
+	}
+
+	// source records where a setting comes from and reports whether the
+	// place's own value is replaced
+	source := func(key string, circleSet bool) (bool, bool) {
+		switch {
+		case !inherited[key]:
+			settings.Sources[key] = models.SettingSourcePlace
+			return false, false
+		case circleSet:
+			settings.Sources[key] = models.SettingSourceCircle
+			return true, true
+		default:
+			settings.Sources[key] = models.SettingSourceSystem
+			return true, false
+		}
+	}
+	resolveBool := func(key string, value *bool, circleValue *bool, systemValue bool) {
+		if replace, fromCircle := source(key, circleValue != nil); replace {
+			*value = systemValue
+			if fromCircle {
+				*value = *circleValue
+			}
+		}
+	}
+	resolveInt := func(key string, value *int, circleValue *int, systemValue int) {
+		if replace, fromCircle := source(key, circleValue != nil); replace {
+			*value = systemValue
+			if fromCircle {
+				*value = *circleValue
+			}
+		}
+	}
+
+	notifications := &settings.Notifications
+	resolveBool(models.PlaceSettingOnArrival, &notifications.OnArrival, defaults.OnArrival, defaultPlaceOnArrival)
+	resolveBool(models.PlaceSettingOnDeparture, &notifications.OnDeparture, defaults.OnDeparture, defaultPlaceOnDeparture)
+	resolveBool(models.PlaceSettingOnLongStay, &notifications.OnLongStay, defaults.OnLongStay, defaultPlaceOnLongStay)
+	resolveInt(models.PlaceSettingLongStayDuration, &notifications.LongStayDuration, defaults.LongStayDuration, defaultPlaceLongStayDuration)
+	resolveInt(models.PlaceSettingCooldown, &notifications.Cooldown, defaults.Cooldown, defaultPlaceCooldown)
+	resolveInt(models.PlaceSettingDwellTime, &settings.Geofence.DwellTime, defaults.DwellTime, defaultPlaceDwellTime)
+	resolveInt(models.PlaceSettingExitDelay, &settings.Geofence.ExitDelay, defaults.ExitDelay, defaultPlaceExitDelay)
+
+	if replace, fromCircle := source(models.PlaceSettingNotifyMembers, defaults.NotifyMembers != nil); replace {
+		notifications.NotifyMembers = nil
+		if fromCircle {
+			notifications.NotifyMembers = *defaults.NotifyMembers
+		}
+	}
+
+	return settings
+}
+
+// ApplyPlaceSettings returns the place with the settings its geofence events
+// use, for handling an event at it
+func ApplyPlaceSettings(place models.Place, defaults *models.GeofenceDefaults) models.Place {
+	settings := ResolvePlaceSettings(&place, defaults)
+	place.Notifications = settings.Notifications
+	place.Geofence = settings.Geofence
+	return place
+}
+
+// unsetPlaceSettings returns the settings a new place leaves at their zero
+// value. In a circle those follow the circle's defaults.
+func unsetPlaceSettings(req models.CreatePlaceRequest) []string {
+	unset := map[string]bool{
+		models.PlaceSettingOnArrival:        !req.Notifications.OnArrival,
+		models.PlaceSettingOnDeparture:      !req.Notifications.OnDeparture,
+		models.PlaceSettingOnLongStay:       !req.Notifications.OnLongStay,
+		models.PlaceSettingLongStayDuration: req.Notifications.LongStayDuration == 0,
+		models.PlaceSettingNotifyMembers:    len(req.Notifications.NotifyMembers) == 0,
+		models.PlaceSettingCooldown:         req.Notifications.Cooldown == 0,
+		models.PlaceSettingDwellTime:        req.Geofence.DwellTime == 0,
+		models.PlaceSettingExitDelay:        req.Geofence.ExitDelay == 0,
+	}
+
+	keys := []string{}
+	for _, key := range models.PlaceSettingKeys {
+		if unset[key] {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// withoutSettings returns the inherited settings minus the given ones
+func withoutSettings(inherited []string, settings ...string) []string {
+	drop := make(map[string]bool, len(settings))
+	for _, key := range settings {
+		drop[key] = true
+	}
+
+	kept := []string{}
+	for _, key := range inherited {
+		if !drop[key] {
+			kept = append(kept, key)
+		}
+	}
+	return kept
+}
+
+func validatePlaceSettingKeys(keys []string) error {
+	known := make(map[string]bool, len(models.PlaceSettingKeys))
+	for _, key := range models.PlaceSettingKeys {
+		known[key] = true
+	}
+	for _, key := range keys {
+		if !known[key] {
+			return utils.NewValidationFailedError(fmt.Sprintf("unknown setting %q", key))
+		}
+	}
+	return nil
+}
+
+// circleGeofenceDefaults returns the defaults of the place's circle, or nil
+// for places outside a circle
+func (ps *PlaceService) circleGeofenceDefaults(ctx context.Context, place *models.Place) *models.GeofenceDefaults {
+	if place.CircleID.IsZero() {
+		return nil
+	}
+
+	circle, err := ps.circleRepo.GetByID(ctx, place.CircleID.Hex())
+	if err != nil {
+		logrus.Warnf("Failed to get geofence defaults of circle %s: %v", place.CircleID.Hex(), err)
+		return nil
+	}
+	return circle.GeofenceDefaults
+}
+
+// validateNotifyMembers checks that the members to notify belong to the
+// circle. Places outside a circle can name anyone.
+func (ps *PlaceService) validateNotifyMembers(ctx context.Context, circleID string, members []string) error {
+	for _, userID := range members {
+		if !primitive.IsValidObjectID(userID) {
+			return utils.NewValidationFailedError(fmt.Sprintf("invalid member ID %q", userID))
+		}
+	}
+	if circleID == "" || len(members) == 0 {
+		return nil
+	}
+
+	circle, err := ps.circleRepo.GetByID(ctx, circleID)
+	if err != nil {
+		return err
+	}
+	inCircle := make(map[string]bool, len(circle.Members))
+	for _, member := range circle.Members {
+		inCircle[member.UserID.Hex()] = true
+	}
+	for _, userID := range members {
+		if !inCircle[userID] {
+			return utils.NewValidationFailedError(fmt.Sprintf("%s is not a member of the circle", userID))
+		}
+	}
+	return nil
+}
+
+func placeCircleID(place *models.Place) string {
+	if place.CircleID.IsZero() {
+		return ""
+	}
+	return place.CircleID.Hex()
+}
+
+// requireCircleAdmin returns "access denied" unless the user is an admin of
+// the circle
+func (ps *PlaceService) requireCircleAdmin(ctx context.Context, circleID, userID string) error {
+	role, err := ps.circleRepo.GetMemberRole(ctx, circleID, userID)
+	if err != nil {
+		if err.Error() == "member not found" {
+			return errors.New("access denied")
+		}
+		return err
+	}
+	if role != "admin" {
+		return errors.New("access denied")
+	}
+	return nil
+}
+
+// GetCircleGeofenceDefaults returns the settings the circle's places inherit.
+// Unset values use the system defaults.
+func (ps *PlaceService) GetCircleGeofenceDefaults(ctx context.Context, userID, circleID string) (*models.GeofenceDefaults, error) {
+	isMember, err := ps.circleRepo.IsMember(ctx, circleID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !isMember {
+		return nil, errors.New("access denied")
+	}
+
+	circle, err := ps.circleRepo.GetByID(ctx, circleID)
+	if err != nil {
+		return nil, err
+	}
+	if circle.GeofenceDefaults == nil {
+		return &models.GeofenceDefaults{}, nil
+	}
+	return circle.GeofenceDefaults, nil
+}
+
+// UpdateCircleGeofenceDefaults replaces the circle's defaults. Places that
+// inherit a setting pick up the new value with their next event.
+func (ps *PlaceService) UpdateCircleGeofenceDefaults(ctx context.Context, userID, circleID string, req models.GeofenceDefaults) (*models.GeofenceDefaults, error) {
+	if err := ps.requireCircleAdmin(ctx, circleID, userID); err != nil {
+		return nil, err
+	}
+
+	if validationErrors := ps.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+	if req.NotifyMembers != nil {
+		if err := ps.validateNotifyMembers(ctx, circleID, *req.NotifyMembers); err != nil {
+			return nil, err
+		}
+	}
+
+	req.UpdatedBy, _ = primitive.ObjectIDFromHex(userID)
+	req.UpdatedAt = time.Now()
+
+	if err := ps.circleRepo.Update(ctx, circleID, bson.M{"geofenceDefaults": req}); err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("Geofence defaults of circle %s updated by %s", circleID, userID)
+	return &req, nil
+}
+
+// ResetPlacesToCircleDefaults makes the circle's places inherit the given
+// settings from the circle again, dropping their own values
+func (ps *PlaceService) ResetPlacesToCircleDefaults(ctx context.Context, userID, circleID string, req models.ResetPlaceSettingsRequest) (*models.ResetPlaceSettingsResult, error) {
+	if err := ps.requireCircleAdmin(ctx, circleID, userID); err != nil {
+		return nil, err
+	}
+
+	if validationErrors := ps.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+	if err := validatePlaceSettingKeys(req.Settings); err != nil {
+		return nil, err
+	}
+
+	settings := req.Settings
+	if len(settings) == 0 {
+		settings = models.PlaceSettingKeys
+	}
+
+	placeIDs := make([]primitive.ObjectID, 0, len(req.PlaceIDs))
+	for _, placeID := range req.PlaceIDs {
+		objectID, err := primitive.ObjectIDFromHex(placeID)
+		if err != nil {
+			return nil, errors.New("invalid place ID")
+		}
+		placeIDs = append(placeIDs, objectID)
+	}
+
+	circleObjectID, _ := primitive.ObjectIDFromHex(circleID)
+	updated, err := ps.placeRepo.InheritCircleDefaults(ctx, circleObjectID, placeIDs, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("Reset %d places of circle %s to circle defaults", updated, circleID)
+	return &models.ResetPlaceSettingsResult{
+		CircleID: circleID,
+		Settings: settings,
+		Updated:  updated,
+	}, nil
+}
+
+// GetPlaceGeofenceSettings returns the settings the place's events use and
+// where each comes from
+func (ps *PlaceService) GetPlaceGeofenceSettings(ctx context.Context, userID, placeID string) (*models.PlaceGeofenceSettings, error) {
+	place, err := ps.GetPlace(ctx, userID, placeID)
+	if err != nil {
+		return nil, err
+	}
+
+	return ResolvePlaceSettings(place, ps.circleGeofenceDefaults(ctx, place)), nil
+}
+
+// UpdatePlaceGeofenceSettings sets the given settings on the place and makes
+// those in req.Inherit follow the circle again
+func (ps *PlaceService) UpdatePlaceGeofenceSettings(ctx context.Context, userID, placeID string, req models.UpdatePlaceGeofenceSettingsRequest) (*models.PlaceGeofenceSettings, error) {
+	place, err := ps.placeRepo.GetByID(ctx, placeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if place.UserID.Hex() != userID {
+		return nil, errors.New("access denied")
+	}
+
+	if validationErrors := ps.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+	if err := validatePlaceSettingKeys(req.Inherit); err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{}
+	var overridden []string
+	set := func(key, field string, value interface{}) {
+		updates[field] = value
+		overridden = append(overridden, key)
+	}
+
+	if req.OnArrival != nil {
+		set(models.PlaceSettingOnArrival, "notifications.onArrival", *req.OnArrival)
+	}
+	if req.OnDeparture != nil {
+		set(models.PlaceSettingOnDeparture, "notifications.onDeparture", *req.OnDeparture)
+	}
+	if req.OnLongStay != nil {
+		set(models.PlaceSettingOnLongStay, "notifications.onLongStay", *req.OnLongStay)
+	}
+	if req.LongStayDuration != nil {
+		set(models.PlaceSettingLongStayDuration, "notifications.longStayDuration", *req.LongStayDuration)
+	}
+	if req.Cooldown != nil {
+		set(models.PlaceSettingCooldown, "notifications.cooldown", *req.Cooldown)
+	}
+	if req.DwellTime != nil {
+		set(models.PlaceSettingDwellTime, "geofence.dwellTime", *req.DwellTime)
+	}
+	if req.ExitDelay != nil {
+		set(models.PlaceSettingExitDelay, "geofence.exitDelay", *req.ExitDelay)
+	}
+	if req.NotifyMembers != nil {
+		if err := ps.validateNotifyMembers(ctx, placeCircleID(place), *req.NotifyMembers); err != nil {
+			return nil, err
+		}
+		set(models.PlaceSettingNotifyMembers, "notifications.notifyMembers", *req.NotifyMembers)
+	}
+
+	for _, key := range req.Inherit {
+		for _, setKey := range overridden {
+			if key == setKey {
+				return nil, utils.NewValidationFailedError(fmt.Sprintf("setting %q can't be set and inherited at once", key))
+			}
+		}
+	}
+
+	inherited := withoutSettings(place.InheritedSettings, overridden...)
+	inherited = append(withoutSettings(inherited, req.Inherit...), req.Inherit...)
+	updates["inheritedSettings"] = inherited
+
+	if err := ps.placeRepo.Update(ctx, placeID, updates); err != nil {
+		return nil, err
+	}
+
+	updated, err := ps.placeRepo.GetByID(ctx, placeID)
+	if err != nil {
+		return nil, err
+	}
+	ps.recordPlaceVersion(ctx, userID, place, updated, models.PlaceVersionSettings, nil)
+	return ResolvePlaceSettings(updated, ps.circleGeofenceDefaults(ctx, updated)), nil
+}
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"ftrack/models"
+	"ftrack/utils"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// setDNDSource records what last changed the user's do not disturb. Only
+// place-driven DND is scoped or names a place.
+func setDNDSource(status *models.DoNotDisturbStatus, source string, place *models.Place, rule *models.AutomationRule) {
+	status.Source = source
+	status.PlaceID = ""
+	status.PlaceName = ""
+	status.RuleID = ""
+	status.Types = nil
+	status.CircleIDs = nil
+
+	if place != nil {
+		status.PlaceID = place.ID.Hex()
+		status.PlaceName = place.Name
+	}
+	if rule != nil {
+		status.RuleID = rule.ID.Hex()
+		status.Types, status.CircleIDs = placeDNDScope(rule)
+	}
+}
+
+// placeDNDScope returns the notification types and circles the rule's
+// do_not_disturb action covers; none means all of them
+func placeDNDScope(rule *models.AutomationRule) (types, circleIDs []string) {
+	for _, action := range rule.Actions {
+		if action.Type == models.RuleActionDoNotDisturb {
+			return configStrings(action.Config, "types"), configStrings(action.Config, "circleIds")
+		}
+	}
+	return nil, nil
+}
+
+// configStrings reads a list of strings from an action's config, as it
+// comes from a request or from the database
+func configStrings(config map[string]interface{}, key string) []string {
+	var values []interface{}
+	switch list := config[key].(type) {
+	case []string:
+		return list
+	case []interface{}:
+		values = list
+	case primitive.A:
+		values = list
+	}
+
+	var result []string
+	for _, value := range values {
+		if s, ok := value.(string); ok && s != "" {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// normalizePlaceDNDRule checks a place_dnd rule is tied to a place or a
+// tag, and cleans up the scope of its do_not_disturb action
+func normalizePlaceDNDRule(conditions []models.RuleCondition, actions []models.RuleAction) error {
+	hasTag := false
+	for _, condition := range conditions {
+		if condition.Type == models.RuleConditionPlaceTag {
+			hasTag = true
+		}
+	}
+	if !hasTag && !hasPlaceCondition(conditions) {
+		return utils.NewValidationFailedError("do not disturb rules need a place or a place_tag condition")
+	}
+
+	found := false
+	for i := range actions {
+		if actions[i].Type != models.RuleActionDoNotDisturb {
+			continue
+		}
+		found = true
+
+		config := actions[i].Config
+		if config == nil {
+			config = make(map[string]interface{})
+		}
+		circleIDs := configStrings(config, "circleIds")
+		for _, circleID := range circleIDs {
+			if _, err := primitive.ObjectIDFromHex(circleID); err != nil {
+				return utils.NewValidationFailedError(fmt.Sprintf("invalid circle ID %q", circleID))
+			}
+		}
+		config["types"] = configStrings(config, "types")
+		config["circleIds"] = circleIDs
+		actions[i].Config = config
+	}
+	if !found {
+		return utils.NewValidationFailedError("do not disturb rules need a do_not_disturb action")
+	}
+	return nil
+}
+
+func hasPlaceCondition(conditions []models.RuleCondition) bool {
+	for _, condition := range conditions {
+		if condition.Type == "place" && condition.PlaceID != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// StartPlaceDND turns on the rule's do not disturb as the user arrives at
+// the place, for at most models.MaxPlaceDNDDuration. DND the user turned on
+// themselves is left alone.
+func (ns *NotificationService) StartPlaceDND(ctx context.Context, userID string, place *models.Place, rule *models.AutomationRule) error {
+	status, err := ns.GetDoNotDisturbStatus(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if status.Source == models.DNDSourceManual && status.IsActive(now) {
+		return nil
+	}
+
+	expiresAt := now.Add(models.MaxPlaceDNDDuration)
+	status.Enabled = true
+	status.EnabledAt = &now
+	status.DisabledAt = nil
+	status.ExpiresAt = &expiresAt
+	status.Reason = "At " + place.Name
+	setDNDSource(status, models.DNDSourcePlace, place, rule)
+
+	if err := ns.notificationRepo.UpsertDNDStatus(ctx, status); err != nil {
+		return err
+	}
+	logrus.Infof("Turned on do not disturb for user %s at place %s", userID, place.ID.Hex())
+	return nil
+}
+
+// EndPlaceDND turns off do not disturb as the user leaves the place, if it
+// was that place that turned it on
+func (ns *NotificationService) EndPlaceDND(ctx context.Context, userID, placeID string) error {
+	status, err := ns.GetDoNotDisturbStatus(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !status.Enabled || status.Source != models.DNDSourcePlace || status.PlaceID != placeID {
+		return nil
+	}
+
+	now := time.Now()
+	status.Enabled = false
+	status.DisabledAt = &now
+	status.ExpiresAt = nil
+	status.Reason = ""
+	setDNDSource(status, models.DNDSourcePlace, nil, nil)
+
+	if err := ns.notificationRepo.UpsertDNDStatus(ctx, status); err != nil {
+		return err
+	}
+	logrus.Infof("Turned off do not disturb for user %s leaving place %s", userID, placeID)
+	return nil
+}
@@ -0,0 +1,265 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"ftrack/models"
+	"ftrack/utils"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// Places closer than this are suspected duplicates unless configured
+	// otherwise
+	DefaultPlaceDuplicateDistance = 75.0
+
+	placeDuplicateCandidateLimit = 5
+)
+
+// Words that don't tell places apart, like "our" in "Our House"
+var placeNameFillerWords = map[string]bool{
+	"the": true,
+	"our": true,
+	"my":  true,
+}
+
+// DuplicatePlaceError is returned when a new place looks like places the
+// user can already see. Creating it again with force set skips the check.
+type DuplicatePlaceError struct {
+	Candidates []models.DuplicatePlaceCandidate
+}
+
+func (e *DuplicatePlaceError) Error() string {
+	return "duplicate place"
+}
+
+// ConfigureDuplicateDetection sets how close places must be to count as
+// suspected duplicates
+func (ps *PlaceService) ConfigureDuplicateDetection(distance float64) {
+	if distance > 0 {
+		ps.duplicateDistance = distance
+	}
+}
+
+// findDuplicatePlaces returns the accessible places that are within the
+// duplicate distance of the new place, or have the same name in the same
+// circle, closest first
+func (ps *PlaceService) findDuplicatePlaces(ctx context.Context, userID string, req models.CreatePlaceRequest) ([]models.DuplicatePlaceCandidate, error) {
+	names, err := ps.typeaheadNames(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	name := normalizePlaceName(req.Name)
+	candidates := []models.DuplicatePlaceCandidate{}
+	for _, entry := range names {
+		place := entry.place
+		distance := utils.CalculateDistance(req.Latitude, req.Longitude, place.Latitude, place.Longitude)
+		nameMatch := name != "" && normalizePlaceName(place.Name) == name && placeInScope(place, userID, req.CircleID)
+		if distance > ps.duplicateDistance && !nameMatch {
+			continue
+		}
+
+		candidates = append(candidates, models.DuplicatePlaceCandidate{
+			PlaceID:   place.ID.Hex(),
+			Name:      place.Name,
+			Address:   place.Address,
+			Latitude:  place.Latitude,
+			Longitude: place.Longitude,
+			Distance:  distance,
+			NameMatch: nameMatch,
+		})
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Distance < candidates[j].Distance
+	})
+	if len(candidates) > placeDuplicateCandidateLimit {
+		candidates = candidates[:placeDuplicateCandidateLimit]
+	}
+
+	return candidates, nil
+}
+
+// placeInScope reports whether the place is in the circle the new place is
+// created for, or is one of the user's own places when it is personal
+func placeInScope(place models.Place, userID, circleID string) bool {
+	if circleID != "" {
+		return place.CircleID.Hex() == circleID
+	}
+	return place.CircleID.IsZero() && place.UserID.Hex() == userID
+}
+
+// normalizePlaceName lowercases the name and drops punctuation and filler
+// words, so "Home", "home!" and "My Home" compare equal
+func normalizePlaceName(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	kept := words[:0]
+	for _, word := range words {
+		if !placeNameFillerWords[word] {
+			kept = append(kept, word)
+		}
+	}
+	return strings.Join(kept, " ")
+}
+
+// GetPlaceDuplicates groups a circle's places that are probably the same
+// place, by distance or by name
+func (ps *PlaceService) GetPlaceDuplicates(ctx context.Context, userID, circleID string) (*models.PlaceDuplicatesReport, error) {
+	isMember, err := ps.circleRepo.IsMember(ctx, circleID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !isMember {
+		return nil, errors.New("access denied")
+	}
+
+	places, err := ps.placeRepo.GetCirclePlaces(ctx, circleID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Union places that look alike, so chains of near places form a group
+	parent := make([]int, len(places))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	normalized := make([]string, len(places))
+	for i, place := range places {
+		normalized[i] = normalizePlaceName(place.Name)
+	}
+
+	for i := range places {
+		for j := i + 1; j < len(places); j++ {
+			distance := utils.CalculateDistance(places[i].Latitude, places[i].Longitude, places[j].Latitude, places[j].Longitude)
+			sameName := normalized[i] != "" && normalized[i] == normalized[j]
+			if distance <= ps.duplicateDistance || sameName {
+				parent[find(i)] = find(j)
+			}
+		}
+	}
+
+	members := make(map[int][]int)
+	var roots []int
+	for i := range places {
+		root := find(i)
+		if _, exists := members[root]; !exists {
+			roots = append(roots, root)
+		}
+		members[root] = append(members[root], i)
+	}
+
+	report := &models.PlaceDuplicatesReport{
+		CircleID:  circleID,
+		Distance:  ps.duplicateDistance,
+		Groups:    []models.PlaceDuplicateGroup{},
+		Generated: time.Now(),
+	}
+
+	for _, root := range roots {
+		indexes := members[root]
+		if len(indexes) < 2 {
+			continue
+		}
+
+		group := models.PlaceDuplicateGroup{}
+		canonical := indexes[0]
+		for n, i := range indexes {
+			group.Places = append(group.Places, places[i])
+			if places[i].Stats.VisitCount > places[canonical].Stats.VisitCount {
+				canonical = i
+			}
+			for _, j := range indexes[n+1:] {
+				distance := utils.CalculateDistance(places[i].Latitude, places[i].Longitude, places[j].Latitude, places[j].Longitude)
+				if distance > group.MaxDistance {
+					group.MaxDistance = distance
+				}
+				if normalized[i] != "" && normalized[i] == normalized[j] {
+					group.NameMatch = true
+				}
+			}
+		}
+		group.SuggestedCanonicalID = places[canonical].ID.Hex()
+
+		report.Groups = append(report.Groups, group)
+	}
+
+	return report, nil
+}
+
+// MergePlaces folds duplicate circle places into a canonical one. Circle
+// admins can merge any of the circle's places, other members only their own.
+func (ps *PlaceService) MergePlaces(ctx context.Context, userID, circleID string, req models.MergePlacesRequest) (*models.MergePlacesResult, error) {
+	if validationErrors := ps.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	role, err := ps.circleRepo.GetMemberRole(ctx, circleID, userID)
+	if err != nil {
+		if err.Error() == "member not found" {
+			return nil, errors.New("access denied")
+		}
+		return nil, err
+	}
+
+	canonical, err := ps.placeRepo.GetByID(ctx, req.CanonicalPlaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	places := []*models.Place{canonical}
+	duplicateIDs := make([]primitive.ObjectID, 0, len(req.DuplicatePlaceIDs))
+	seen := map[string]bool{req.CanonicalPlaceID: true}
+	for _, placeID := range req.DuplicatePlaceIDs {
+		if seen[placeID] {
+			return nil, utils.NewValidationFailedError("places to merge must be distinct from each other and the canonical place")
+		}
+		seen[placeID] = true
+
+		place, err := ps.placeRepo.GetByID(ctx, placeID)
+		if err != nil {
+			return nil, err
+		}
+		places = append(places, place)
+		duplicateIDs = append(duplicateIDs, place.ID)
+	}
+
+	for _, place := range places {
+		if place.CircleID.Hex() != circleID {
+			return nil, errors.New("place not found")
+		}
+		if role != "admin" && place.UserID.Hex() != userID {
+			return nil, errors.New("access denied")
+		}
+	}
+
+	result, err := ps.placeRepo.MergePlaces(ctx, canonical.ID, duplicateIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, place := range places {
+		ps.invalidatePlaceTypeahead(ctx, place)
+	}
+
+	logrus.Infof("Merged %d places into %s in circle %s by user %s", len(duplicateIDs), canonical.ID.Hex(), circleID, userID)
+	return result, nil
+}
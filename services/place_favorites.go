@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"ftrack/models"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// FavoritePlace adds a place the member can see to their favorites.
+// Favorites are private to the member, also on shared places. Favoriting a
+// place twice changes nothing.
+func (ps *PlaceService) FavoritePlace(ctx context.Context, userID, placeID string) (*models.Place, error) {
+	place, err := ps.GetPlace(ctx, userID, placeID)
+	if err != nil {
+		return nil, err
+	}
+
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	if err := ps.placeRepo.SetPlaceFavorite(ctx, userObjectID, place.ID, true); err != nil {
+		return nil, err
+	}
+
+	ps.applyPlaceAliases(ctx, userID, place)
+	place.IsFavorite = true
+	return place, nil
+}
+
+// UnfavoritePlace removes a place from the member's favorites. It works
+// for places the member can no longer see, and for places that aren't
+// among their favorites.
+func (ps *PlaceService) UnfavoritePlace(ctx context.Context, userID, placeID string) error {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return errors.New("invalid user ID")
+	}
+	placeObjectID, err := primitive.ObjectIDFromHex(placeID)
+	if err != nil {
+		return errors.New("invalid place ID")
+	}
+
+	return ps.placeRepo.SetPlaceFavorite(ctx, userObjectID, placeObjectID, false)
+}
+
+// GetFavoritePlaces lists the member's favorite places, most recently
+// favorited first. Places the member can no longer see are left out.
+func (ps *PlaceService) GetFavoritePlaces(ctx context.Context, userID string, req models.GetFavoritePlacesRequest) (*models.PlacesResponse, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	page, pageSize := models.NormalizePage(req.Page, req.PageSize)
+	favorites, total, err := ps.placeRepo.GetFavoritePlaces(ctx, userObjectID, page, pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	placeIDs := make([]primitive.ObjectID, 0, len(favorites))
+	for _, favorite := range favorites {
+		placeIDs = append(placeIDs, favorite.PlaceID)
+	}
+
+	places, err := ps.placeRepo.GetByIDs(ctx, placeIDs)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[primitive.ObjectID]*models.Place, len(places))
+	for i := range places {
+		byID[places[i].ID] = &places[i]
+	}
+
+	visible := make([]*models.Place, 0, len(favorites))
+	for _, favorite := range favorites {
+		place, ok := byID[favorite.PlaceID]
+		if !ok {
+			continue
+		}
+		if place.UserID != userObjectID {
+			if hasAccess, err := ps.hasPlaceAccess(ctx, userID, place); err != nil || !hasAccess {
+				continue
+			}
+		}
+		place.IsFavorite = true
+		visible = append(visible, place)
+	}
+	ps.applyPlaceAliases(ctx, userID, visible...)
+
+	responses := make([]models.PlaceResponse, 0, len(visible))
+	for _, place := range visible {
+		responses = append(responses, models.PlaceResponse{Place: *place})
+	}
+
+	return &models.PlacesResponse{
+		Places: responses,
+		Meta: models.PaginationMeta{
+			Page:       page,
+			PageSize:   pageSize,
+			Total:      total,
+			TotalPages: int((total + int64(pageSize) - 1) / int64(pageSize)),
+		},
+	}, nil
+}
+
+// applyPlaceFavorites marks the places that are among the member's
+// favorites. Failures are logged and leave the places unmarked.
+func (ps *PlaceService) applyPlaceFavorites(ctx context.Context, userID string, places ...*models.Place) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil || len(places) == 0 {
+		return
+	}
+
+	placeIDs := make([]primitive.ObjectID, 0, len(places))
+	for _, place := range places {
+		placeIDs = append(placeIDs, place.ID)
+	}
+
+	favorited, err := ps.placeRepo.GetUserFavoritePlaceIDs(ctx, userObjectID, placeIDs)
+	if err != nil {
+		logrus.Warnf("Failed to get favorite places of user %s: %v", userID, err)
+		return
+	}
+
+	for _, place := range places {
+		place.IsFavorite = favorited[place.ID]
+	}
+}
+
+// clearPlaceFavorites removes a deleted place from the members' favorites
+func (ps *PlaceService) clearPlaceFavorites(ctx context.Context, place *models.Place) {
+	if err := ps.placeRepo.DeletePlaceFavorites(ctx, place.ID); err != nil {
+		logrus.Errorf("Failed to clear favorites of place %s: %v", place.ID.Hex(), err)
+	}
+}
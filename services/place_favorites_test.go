@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"ftrack/models"
+	"ftrack/testharness"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestPlaceFavorites(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ps := newTestPlaceService(env)
+	ctx := context.Background()
+
+	owner, mate, outsider := env.Factory.User(), env.Factory.User(), env.Factory.User()
+	circle := env.Factory.Circle(owner, []*models.User{mate})
+	shared := env.Factory.Place(owner, testharness.InCircle(circle), func(place *models.Place) { place.IsShared = true })
+	gym := env.Factory.Place(mate)
+	private := env.Factory.Place(outsider)
+	mateID := mate.ID.Hex()
+
+	favorites := func(user *models.User) string {
+		t.Helper()
+		response, err := ps.GetFavoritePlaces(ctx, user.ID.Hex(), models.GetFavoritePlacesRequest{})
+		if err != nil {
+			t.Fatalf("GetFavoritePlaces: %v", err)
+		}
+		var ids []string
+		for _, place := range response.Places {
+			if !place.Place.IsFavorite {
+				t.Errorf("favorite %s not marked as one", place.Place.ID.Hex())
+			}
+			ids = append(ids, place.Place.ID.Hex())
+		}
+		if response.Meta.Total != int64(len(ids)) {
+			t.Errorf("%d favorites counted, %d listed", response.Meta.Total, len(ids))
+		}
+		return strings.Join(ids, ",")
+	}
+	stored := func() int64 {
+		t.Helper()
+		count, err := env.DB.Collection("place_favorites").CountDocuments(ctx, bson.M{"userId": mate.ID})
+		if err != nil {
+			t.Fatalf("counting favorites: %v", err)
+		}
+		return count
+	}
+	isFavorite := func(user *models.User, req models.GetPlacesRequest, place *models.Place) (listed, favorite bool) {
+		t.Helper()
+		response, err := ps.GetUserPlaces(ctx, user.ID.Hex(), req)
+		if err != nil {
+			t.Fatalf("GetUserPlaces: %v", err)
+		}
+		for _, listedPlace := range response.Places {
+			if listedPlace.Place.ID == place.ID {
+				return true, listedPlace.Place.IsFavorite
+			}
+		}
+		return false, false
+	}
+
+	// Favoriting twice keeps one favorite
+	for i := 0; i < 2; i++ {
+		place, err := ps.FavoritePlace(ctx, mateID, shared.ID.Hex())
+		if err != nil {
+			t.Fatalf("FavoritePlace: %v", err)
+		}
+		if !place.IsFavorite {
+			t.Error("favorited place not marked as a favorite")
+		}
+	}
+	if count := stored(); count != 1 {
+		t.Errorf("%d favorites stored after favoriting twice, want 1", count)
+	}
+	if _, err := ps.FavoritePlace(ctx, mateID, gym.ID.Hex()); err != nil {
+		t.Fatalf("FavoritePlace: %v", err)
+	}
+	if _, err := ps.FavoritePlace(ctx, mateID, private.ID.Hex()); err == nil || err.Error() != "access denied" {
+		t.Errorf("favoriting someone's private place error = %v, want access denied", err)
+	}
+
+	// Newest first, and private to the member who favorited
+	if got, want := favorites(mate), gym.ID.Hex()+","+shared.ID.Hex(); got != want {
+		t.Errorf("mate's favorites %s, want %s", got, want)
+	}
+	if got := favorites(owner); got != "" {
+		t.Errorf("owner's favorites %s, want none", got)
+	}
+	if listed, favorite := isFavorite(owner, models.GetPlacesRequest{}, shared); !listed || favorite {
+		t.Errorf("owner's shared place listed %v as favorite %v, want listed and not a favorite", listed, favorite)
+	}
+	yes, no := true, false
+	if listed, favorite := isFavorite(mate, models.GetPlacesRequest{}, gym); !listed || !favorite {
+		t.Errorf("mate's gym listed %v as favorite %v, want a listed favorite", listed, favorite)
+	}
+	if listed, _ := isFavorite(mate, models.GetPlacesRequest{IsFavorite: &yes}, gym); !listed {
+		t.Error("gym left out of the mate's favorites filter")
+	}
+	if listed, _ := isFavorite(mate, models.GetPlacesRequest{IsFavorite: &no}, gym); listed {
+		t.Error("gym listed among places that aren't favorites")
+	}
+
+	// Unfavoriting twice is fine too
+	for i := 0; i < 2; i++ {
+		if err := ps.UnfavoritePlace(ctx, mateID, gym.ID.Hex()); err != nil {
+			t.Fatalf("UnfavoritePlace: %v", err)
+		}
+	}
+	if got := favorites(mate); got != shared.ID.Hex() {
+		t.Errorf("favorites after unfavoriting the gym %s, want the shared place", got)
+	}
+
+	// Deleting a place removes it from everyone's favorites
+	if err := ps.DeletePlace(ctx, owner.ID.Hex(), shared.ID.Hex()); err != nil {
+		t.Fatalf("DeletePlace: %v", err)
+	}
+	if got := favorites(mate); got != "" {
+		t.Errorf("favorites after the place was deleted %s, want none", got)
+	}
+	if count := stored(); count != 0 {
+		t.Errorf("%d favorites stored for a deleted place", count)
+	}
+}
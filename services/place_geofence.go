@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"ftrack/repositories"
+	"ftrack/utils"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// Default geofence radius bounds in meters. Smaller geofences never
+	// trigger with GPS accuracy; larger ones trigger everywhere.
+	DefaultPlaceRadiusMin = 10
+	DefaultPlaceRadiusMax = 5000
+
+	// A geofence overlapping this many places is flagged on the place
+	placeOverlapWarningCount = 5
+
+	metersPerDegreeLatitude = 111320.0
+)
+
+// RadiusBounds are the smallest and largest geofence radius allowed, in meters
+type RadiusBounds struct {
+	Min int
+	Max int
+}
+
+// parsePlanRadiusBounds parses "plan:min-max" entries, e.g.
+// "premium:10-20000"
+func parsePlanRadiusBounds(entries []string) map[string]RadiusBounds {
+	plans := make(map[string]RadiusBounds, len(entries))
+	for _, entry := range entries {
+		plan, bounds, found := strings.Cut(entry, ":")
+		minText, maxText, rangeFound := strings.Cut(bounds, "-")
+		plan = strings.TrimSpace(plan)
+		if !found || !rangeFound || plan == "" {
+			logrus.Errorf("Ignoring plan radius bounds %q, expected plan:min-max", entry)
+			continue
+		}
+
+		minRadius, minErr := strconv.Atoi(strings.TrimSpace(minText))
+		maxRadius, maxErr := strconv.Atoi(strings.TrimSpace(maxText))
+		if minErr != nil || maxErr != nil || minRadius < 1 || maxRadius < minRadius {
+			logrus.Errorf("Ignoring plan radius bounds %q, expected plan:min-max", entry)
+			continue
+		}
+
+		plans[plan] = RadiusBounds{Min: minRadius, Max: maxRadius}
+	}
+	return plans
+}
+
+// ConfigureRadiusBounds sets the geofence radius bounds, and the bounds of
+// plans that differ from them as "plan:min-max" entries. The user's plan is
+// looked up in userRepo.
+func (ps *PlaceService) ConfigureRadiusBounds(defaults RadiusBounds, planBounds []string, userRepo *repositories.UserRepository) {
+	if defaults.Min > 0 && defaults.Max >= defaults.Min {
+		ps.radiusBounds = defaults
+	} else {
+		logrus.Errorf("Ignoring invalid place radius bounds %d-%d", defaults.Min, defaults.Max)
+	}
+	ps.planRadiusBounds = parsePlanRadiusBounds(planBounds)
+	ps.userRepo = userRepo
+}
+
+// radiusBoundsFor returns the radius bounds of the user's plan
+func (ps *PlaceService) radiusBoundsFor(ctx context.Context, userID string) RadiusBounds {
+	if len(ps.planRadiusBounds) == 0 || ps.userRepo == nil {
+		return ps.radiusBounds
+	}
+
+	user, err := ps.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		logrus.Warnf("Failed to get plan of user %s: %v", userID, err)
+		return ps.radiusBounds
+	}
+
+	if bounds, exists := ps.planRadiusBounds[user.Plan]; exists {
+		return bounds
+	}
+	return ps.radiusBounds
+}
+
+// validateGeofence checks that a geofence is usable: the radius within the
+// bounds of the user's plan, and the circle somewhere on the map
+func (ps *PlaceService) validateGeofence(ctx context.Context, userID string, latitude, longitude float64, radius int) error {
+	if math.IsNaN(latitude) || math.IsNaN(longitude) || math.IsInf(latitude, 0) || math.IsInf(longitude, 0) {
+		return utils.NewValidationFailedError("coordinates must be numbers")
+	}
+	if latitude < -90 || latitude > 90 {
+		return utils.NewValidationFailedError("latitude must be between -90 and 90")
+	}
+	if longitude < -180 || longitude > 180 {
+		return utils.NewValidationFailedError("longitude must be between -180 and 180")
+	}
+
+	// 0,0 is in the ocean; it's what clients send when they have no location
+	if latitude == 0 && longitude == 0 {
+		return utils.NewValidationFailedError("coordinates 0,0 are not a real location")
+	}
+
+	bounds := ps.radiusBoundsFor(ctx, userID)
+	if radius < bounds.Min {
+		return utils.NewValidationFailedError(fmt.Sprintf("radius must be at least %d meters", bounds.Min))
+	}
+	if radius > bounds.Max {
+		return utils.NewValidationFailedError(fmt.Sprintf("radius must be at most %d meters", bounds.Max))
+	}
+
+	// Distances stop making sense for circles around a pole
+	if math.Abs(latitude)+float64(radius)/metersPerDegreeLatitude > 90 {
+		return utils.NewValidationFailedError("geofence can't extend past a pole")
+	}
+
+	return nil
+}
+
+// geofenceWarnings flags a geofence overlapping many of the places the user
+// can see, since they would trigger together
+func (ps *PlaceService) geofenceWarnings(ctx context.Context, userID string, placeID primitive.ObjectID, latitude, longitude float64, radius int) []string {
+	names, err := ps.typeaheadNames(ctx, userID)
+	if err != nil {
+		logrus.Warnf("Failed to check geofence overlaps of user %s: %v", userID, err)
+		return nil
+	}
+
+	overlapping := 0
+	for _, entry := range names {
+		other := entry.place
+		if other.ID == placeID {
+			continue
+		}
+		distance := utils.CalculateDistance(latitude, longitude, other.Latitude, other.Longitude)
+		if distance < float64(radius+other.Radius) {
+			overlapping++
+		}
+	}
+
+	if overlapping < placeOverlapWarningCount {
+		return nil
+	}
+	return []string{fmt.Sprintf("geofence overlaps %d other places, so they will trigger together", overlapping)}
+}
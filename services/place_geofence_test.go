@@ -0,0 +1,116 @@
+package services
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"testing"
+
+	"ftrack/models"
+	"ftrack/testharness"
+	"ftrack/utils"
+)
+
+func TestValidateGeofenceRadiusEdges(t *testing.T) {
+	ps := &PlaceService{radiusBounds: RadiusBounds{Min: DefaultPlaceRadiusMin, Max: DefaultPlaceRadiusMax}}
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		latitude  float64
+		longitude float64
+		radius    int
+		reason    string // empty when valid
+	}{
+		{"at the minimum", 40.7, -74, DefaultPlaceRadiusMin, ""},
+		{"below the minimum", 40.7, -74, DefaultPlaceRadiusMin - 1, "radius must be at least 10 meters"},
+		{"at the maximum", 40.7, -74, DefaultPlaceRadiusMax, ""},
+		{"above the maximum", 40.7, -74, DefaultPlaceRadiusMax + 1, "radius must be at most 5000 meters"},
+		{"zero radius", 40.7, -74, 0, "radius must be at least 10 meters"},
+		{"null island", 0, 0, 100, "coordinates 0,0 are not a real location"},
+		{"latitude out of range", 90.5, 10, 100, "latitude must be between -90 and 90"},
+		{"longitude out of range", 10, -180.5, 100, "longitude must be between -180 and 180"},
+		{"not a number", math.NaN(), 10, 100, "coordinates must be numbers"},
+		{"past a pole", 89.99, 10, 5000, "geofence can't extend past a pole"},
+		{"near a pole", 89.9, 10, 1000, ""},
+	}
+
+	for _, tt := range tests {
+		err := ps.validateGeofence(ctx, "user", tt.latitude, tt.longitude, tt.radius)
+		if tt.reason == "" {
+			if err != nil {
+				t.Errorf("%s: error = %v, want none", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != "validation failed" {
+			t.Errorf("%s: error = %v, want validation failed", tt.name, err)
+			continue
+		}
+		if reason := utils.ValidationFailureReason(err); reason != tt.reason {
+			t.Errorf("%s: reason = %q, want %q", tt.name, reason, tt.reason)
+		}
+	}
+}
+
+func TestParsePlanRadiusBounds(t *testing.T) {
+	got := parsePlanRadiusBounds([]string{
+		"premium:10-20000",
+		" family : 25 - 8000 ",
+		"broken",
+		"nomax:10",
+		"inverted:100-50",
+		"zero:0-100",
+		":10-100",
+	})
+	want := map[string]RadiusBounds{
+		"premium": {Min: 10, Max: 20000},
+		"family":  {Min: 25, Max: 8000},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parsePlanRadiusBounds = %v, want %v", got, want)
+	}
+}
+
+func TestPlaceServicePlanRadiusBounds(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ps := NewPlaceService(env.Repos.Place, env.Repos.Circle, nil)
+	ps.ConfigureRadiusBounds(RadiusBounds{Min: 50, Max: 1000}, []string{"premium:20-20000"}, env.Repos.User)
+	ctx := context.Background()
+
+	free := env.Factory.User()
+	premium := env.Factory.User(func(user *models.User) { user.Plan = "premium" })
+
+	tests := []struct {
+		name   string
+		user   *models.User
+		radius int
+		valid  bool
+	}{
+		{"free at the configured minimum", free, 50, true},
+		{"free below the configured minimum", free, 49, false},
+		{"free at the configured maximum", free, 1000, true},
+		{"free above the configured maximum", free, 1001, false},
+		{"premium at the plan minimum", premium, 20, true},
+		{"premium below the plan minimum", premium, 19, false},
+		{"premium at the plan maximum", premium, 20000, true},
+		{"premium above the plan maximum", premium, 20001, false},
+	}
+	for _, tt := range tests {
+		_, err := ps.CreatePlace(ctx, tt.user.ID.Hex(), models.CreatePlaceRequest{
+			Name:      tt.name,
+			Latitude:  40.7128,
+			Longitude: -74.0060,
+			Radius:    tt.radius,
+			Category:  "other",
+			Force:     true, // the places share a location
+		})
+		if tt.valid && err != nil {
+			t.Errorf("%s: CreatePlace: %v", tt.name, err)
+		}
+		if !tt.valid && (err == nil || err.Error() != "validation failed") {
+			t.Errorf("%s: error = %v, want validation failed", tt.name, err)
+		}
+	}
+}
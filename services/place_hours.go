@@ -0,0 +1,689 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"ftrack/models"
+	"ftrack/utils"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GetPlaceHours returns the place's hours to anyone who can see the place
+func (ps *PlaceService) GetPlaceHours(ctx context.Context, userID, placeID string) (*models.PlaceHours, error) {
+	place, err := ps.GetPlace(ctx, userID, placeID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &place.Hours, nil
+}
+
+// UpdatePlaceHours changes the place's regular hours and timezone. Its
+// overrides are kept.
+func (ps *PlaceService) UpdatePlaceHours(ctx context.Context, userID, placeID string, req models.UpdatePlaceHoursRequest) (*models.PlaceHours, error) {
+	place, err := ps.getOwnPlace(ctx, userID, placeID)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{}
+	if req.IsAlwaysOpen != nil {
+		updates["hours.isAlwaysOpen"] = *req.IsAlwaysOpen
+	}
+	if req.Schedule != nil {
+		if err := validatePlaceSchedule(req.Schedule); err != nil {
+			return nil, err
+		}
+		updates["hours.schedule"] = req.Schedule
+	}
+	if req.Timezone != nil {
+		if _, err := time.LoadLocation(*req.Timezone); err != nil {
+			return nil, utils.NewValidationFailedError(fmt.Sprintf("unknown timezone %q", *req.Timezone))
+		}
+		updates["hours.timezone"] = *req.Timezone
+	}
+	if len(updates) == 0 {
+		return &place.Hours, nil
+	}
+
+	updated, err := ps.savePlaceHours(ctx, userID, place, updates)
+	if err != nil {
+		return nil, err
+	}
+	return &updated.Hours, nil
+}
+
+// GetCurrentStatus returns whether the place is open now, how it is open
+// today and when that next changes
+func (ps *PlaceService) GetCurrentStatus(ctx context.Context, userID, placeID string) (*models.PlaceHoursStatus, error) {
+	place, err := ps.GetPlace(ctx, userID, placeID)
+	if err != nil {
+		return nil, err
+	}
+
+	holidaySets, err := ps.placeHolidaySets(ctx, place.Hours)
+	if err != nil {
+		return nil, err
+	}
+
+	return placeHoursStatus(place.Hours, holidaySets, time.Now()), nil
+}
+
+// CreateHoursOverride changes the place's hours on one date
+func (ps *PlaceService) CreateHoursOverride(ctx context.Context, userID, placeID string, req models.CreateHoursOverrideRequest) (*models.HoursOverride, error) {
+	place, err := ps.getOwnPlace(ctx, userID, placeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if validationErrors := ps.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+	if err := validateHoursTimes(req.IsOpen, req.StartTime, req.EndTime); err != nil {
+		return nil, err
+	}
+
+	date, _ := time.Parse("2006-01-02", req.Date)
+	for _, existing := range place.Hours.Overrides {
+		if existing.Date.UTC().Format("2006-01-02") == req.Date {
+			return nil, errors.New("hours override already exists")
+		}
+	}
+
+	override := models.HoursOverride{
+		ID:        primitive.NewObjectID(),
+		Date:      date,
+		IsOpen:    req.IsOpen,
+		StartTime: req.StartTime,
+		EndTime:   req.EndTime,
+		Note:      req.Note,
+		CreatedAt: time.Now(),
+	}
+
+	overrides := append(append([]models.HoursOverride{}, place.Hours.Overrides...), override)
+	if _, err := ps.savePlaceHours(ctx, userID, place, map[string]interface{}{"hours.overrides": overrides}); err != nil {
+		return nil, err
+	}
+
+	return &override, nil
+}
+
+// CreateRecurringHoursOverride changes the place's hours on the days of a
+// recurrence or the holidays of a holiday set. It is turned down if it
+// would fall on a day with an equally specific override that has other
+// hours, since the day's hours would then depend on which came first.
+func (ps *PlaceService) CreateRecurringHoursOverride(ctx context.Context, userID, placeID string, req models.CreateRecurringHoursOverrideRequest) (*models.RecurringHoursOverride, error) {
+	place, err := ps.getOwnPlace(ctx, userID, placeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if validationErrors := ps.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+	if (req.Recurrence == nil) == (req.HolidaySetID == "") {
+		return nil, utils.NewValidationFailedError("an override needs either a recurrence or a holiday set")
+	}
+	if err := validateHoursTimes(req.IsOpen, req.StartTime, req.EndTime); err != nil {
+		return nil, err
+	}
+	if len(place.Hours.RecurringOverrides) >= models.MaxRecurringHoursOverrides {
+		return nil, utils.NewValidationFailedError(fmt.Sprintf("a place can have at most %d recurring overrides", models.MaxRecurringHoursOverrides))
+	}
+
+	override := models.RecurringHoursOverride{
+		ID:        primitive.NewObjectID(),
+		Name:      req.Name,
+		IsOpen:    req.IsOpen,
+		StartTime: req.StartTime,
+		EndTime:   req.EndTime,
+		Note:      req.Note,
+		CreatedAt: time.Now(),
+	}
+
+	if req.Recurrence != nil {
+		if err := validateHoursRecurrence(*req.Recurrence); err != nil {
+			return nil, err
+		}
+		override.Recurrence = req.Recurrence
+	} else {
+		set, err := ps.getOwnHolidaySet(ctx, userID, req.HolidaySetID)
+		if err != nil {
+			return nil, err
+		}
+		override.HolidaySetID = &set.ID
+	}
+
+	holidaySets, err := ps.placeHolidaySets(ctx, models.PlaceHours{
+		RecurringOverrides: append(append([]models.RecurringHoursOverride{}, place.Hours.RecurringOverrides...), override),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	location := placeHoursLocation(place.Hours)
+	now := time.Now().In(location)
+	from := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if err := checkRecurringOverrideClash(override, place.Hours.RecurringOverrides, holidaySets, from); err != nil {
+		return nil, err
+	}
+
+	overrides := append(append([]models.RecurringHoursOverride{}, place.Hours.RecurringOverrides...), override)
+	if _, err := ps.savePlaceHours(ctx, userID, place, map[string]interface{}{"hours.recurringOverrides": overrides}); err != nil {
+		return nil, err
+	}
+
+	return &override, nil
+}
+
+// DeleteHoursOverride removes a dated or recurring override
+func (ps *PlaceService) DeleteHoursOverride(ctx context.Context, userID, placeID, overrideID string) error {
+	place, err := ps.getOwnPlace(ctx, userID, placeID)
+	if err != nil {
+		return err
+	}
+
+	overrideObjectID, err := primitive.ObjectIDFromHex(overrideID)
+	if err != nil {
+		return errors.New("invalid override ID")
+	}
+
+	for i, override := range place.Hours.Overrides {
+		if override.ID == overrideObjectID {
+			overrides := append(append([]models.HoursOverride{}, place.Hours.Overrides[:i]...), place.Hours.Overrides[i+1:]...)
+			_, err := ps.savePlaceHours(ctx, userID, place, map[string]interface{}{"hours.overrides": overrides})
+			return err
+		}
+	}
+	for i, override := range place.Hours.RecurringOverrides {
+		if override.ID == overrideObjectID {
+			overrides := append(append([]models.RecurringHoursOverride{}, place.Hours.RecurringOverrides[:i]...), place.Hours.RecurringOverrides[i+1:]...)
+			_, err := ps.savePlaceHours(ctx, userID, place, map[string]interface{}{"hours.recurringOverrides": overrides})
+			return err
+		}
+	}
+
+	return errors.New("hours override not found")
+}
+
+// ==================== HOLIDAY SETS ====================
+
+func (ps *PlaceService) GetHolidaySets(ctx context.Context, userID string) ([]models.HolidaySet, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	return ps.placeRepo.GetUserHolidaySets(ctx, userObjectID)
+}
+
+func (ps *PlaceService) CreateHolidaySet(ctx context.Context, userID string, req models.HolidaySetRequest) (*models.HolidaySet, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	if err := ps.validateHolidaySet(req); err != nil {
+		return nil, err
+	}
+
+	set := &models.HolidaySet{
+		UserID:   userObjectID,
+		Name:     req.Name,
+		Holidays: req.Holidays,
+	}
+	if set.Holidays == nil {
+		set.Holidays = []models.Holiday{}
+	}
+
+	if err := ps.placeRepo.CreateHolidaySet(ctx, set); err != nil {
+		return nil, err
+	}
+
+	return set, nil
+}
+
+// UpdateHolidaySet replaces the set's name and holidays. Places following
+// the set pick up the change at once.
+func (ps *PlaceService) UpdateHolidaySet(ctx context.Context, userID, setID string, req models.HolidaySetRequest) (*models.HolidaySet, error) {
+	set, err := ps.getOwnHolidaySet(ctx, userID, setID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ps.validateHolidaySet(req); err != nil {
+		return nil, err
+	}
+
+	set.Name = req.Name
+	set.Holidays = req.Holidays
+	if set.Holidays == nil {
+		set.Holidays = []models.Holiday{}
+	}
+
+	if err := ps.placeRepo.UpdateHolidaySet(ctx, set.ID, set.Name, set.Holidays); err != nil {
+		return nil, err
+	}
+
+	set.UpdatedAt = time.Now()
+	return set, nil
+}
+
+// DeleteHolidaySet deletes a set no place follows
+func (ps *PlaceService) DeleteHolidaySet(ctx context.Context, userID, setID string) error {
+	set, err := ps.getOwnHolidaySet(ctx, userID, setID)
+	if err != nil {
+		return err
+	}
+
+	inUse, err := ps.placeRepo.CountPlacesUsingHolidaySet(ctx, set.ID)
+	if err != nil {
+		return err
+	}
+	if inUse > 0 {
+		return errors.New("holiday set in use")
+	}
+
+	return ps.placeRepo.DeleteHolidaySet(ctx, set.ID)
+}
+
+// getOwnHolidaySet returns a holiday set of the user. Other users' sets
+// are reported as missing.
+func (ps *PlaceService) getOwnHolidaySet(ctx context.Context, userID, setID string) (*models.HolidaySet, error) {
+	set, err := ps.placeRepo.GetHolidaySet(ctx, setID)
+	if err != nil {
+		return nil, err
+	}
+
+	if set.UserID.Hex() != userID {
+		return nil, errors.New("holiday set not found")
+	}
+
+	return set, nil
+}
+
+func (ps *PlaceService) validateHolidaySet(req models.HolidaySetRequest) error {
+	if validationErrors := ps.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	for _, holiday := range req.Holidays {
+		if (holiday.Date == "") == (holiday.Recurrence == nil) {
+			return utils.NewValidationFailedError(fmt.Sprintf("holiday %q needs either a date or a recurrence", holiday.Name))
+		}
+		if holiday.Recurrence == nil {
+			continue
+		}
+		if holiday.Recurrence.Month == 0 {
+			return utils.NewValidationFailedError(fmt.Sprintf("holiday %q needs a month", holiday.Name))
+		}
+		if err := validateHoursRecurrence(*holiday.Recurrence); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ==================== HELPERS ====================
+
+func (ps *PlaceService) getOwnPlace(ctx context.Context, userID, placeID string) (*models.Place, error) {
+	place, err := ps.placeRepo.GetByID(ctx, placeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if place.UserID.Hex() != userID {
+		return nil, errors.New("access denied")
+	}
+
+	return place, nil
+}
+
+// savePlaceHours applies changes to the place's hours and records them as
+// a version of the place
+func (ps *PlaceService) savePlaceHours(ctx context.Context, userID string, place *models.Place, updates map[string]interface{}) (*models.Place, error) {
+	placeID := place.ID.Hex()
+	if err := ps.placeRepo.Update(ctx, placeID, updates); err != nil {
+		return nil, err
+	}
+
+	updated, err := ps.placeRepo.GetByID(ctx, placeID)
+	if err != nil {
+		return nil, err
+	}
+	ps.recordPlaceVersion(ctx, userID, place, updated, models.PlaceVersionHours, nil)
+
+	return updated, nil
+}
+
+// placeHolidaySets loads the holiday sets the hours follow, by id. Sets
+// that no longer exist are left out, and their overrides don't apply.
+func (ps *PlaceService) placeHolidaySets(ctx context.Context, hours models.PlaceHours) (map[primitive.ObjectID]models.HolidaySet, error) {
+	var setIDs []primitive.ObjectID
+	for _, override := range hours.RecurringOverrides {
+		if override.HolidaySetID != nil {
+			setIDs = append(setIDs, *override.HolidaySetID)
+		}
+	}
+
+	sets, err := ps.placeRepo.GetHolidaySetsByIDs(ctx, setIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	holidaySets := make(map[primitive.ObjectID]models.HolidaySet, len(sets))
+	for _, set := range sets {
+		holidaySets[set.ID] = set
+	}
+	return holidaySets, nil
+}
+
+// UpcomingHoursExceptions returns the place's days that don't follow its
+// regular hours, from the local date of from, over the given days
+func (ps *PlaceService) UpcomingHoursExceptions(ctx context.Context, place *models.Place, from time.Time, days int) ([]models.PlaceDayHours, error) {
+	hours := place.Hours
+	if len(hours.Overrides) == 0 && len(hours.RecurringOverrides) == 0 {
+		return nil, nil
+	}
+
+	holidaySets, err := ps.placeHolidaySets(ctx, hours)
+	if err != nil {
+		return nil, err
+	}
+
+	location := placeHoursLocation(hours)
+	local := from.In(location)
+	date := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, location)
+
+	var exceptions []models.PlaceDayHours
+	for i := 0; i < days; i++ {
+		day := resolvePlaceDay(hours, holidaySets, date.AddDate(0, 0, i))
+		if day.Source != models.HoursSourceRegular {
+			exceptions = append(exceptions, day)
+		}
+	}
+	return exceptions, nil
+}
+
+func validatePlaceSchedule(schedule map[string]models.DaySchedule) error {
+	known := make(map[string]bool, len(models.Weekdays))
+	for _, day := range models.Weekdays {
+		known[day] = true
+	}
+
+	for day, hours := range schedule {
+		if !known[day] {
+			return utils.NewValidationFailedError(fmt.Sprintf("unknown day %q", day))
+		}
+		if err := validateHoursTimes(hours.Enabled, hours.StartTime, hours.EndTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateHoursTimes checks the hours of an open day: both times, or
+// neither for a day open around the clock. Closed days have no times.
+func validateHoursTimes(isOpen bool, startTime, endTime string) error {
+	if !isOpen {
+		if startTime != "" || endTime != "" {
+			return utils.NewValidationFailedError("closed days have no opening times")
+		}
+		return nil
+	}
+
+	if (startTime == "") != (endTime == "") {
+		return utils.NewValidationFailedError("both an opening and a closing time are needed")
+	}
+	if startTime == "" {
+		return nil
+	}
+
+	start, startErr := parseHoursClock(startTime)
+	end, endErr := parseHoursClock(endTime)
+	if startErr != nil || endErr != nil {
+		return utils.NewValidationFailedError("times must be given as HH:MM")
+	}
+	if start == end {
+		return utils.NewValidationFailedError("opening and closing times must differ")
+	}
+	return nil
+}
+
+func validateHoursRecurrence(recurrence models.HoursRecurrence) error {
+	byDay := recurrence.Day != 0
+	byWeekday := recurrence.Weekday != "" || recurrence.Week != 0
+	if byDay == byWeekday {
+		return utils.NewValidationFailedError("a recurrence needs either a day of the month or a weekday")
+	}
+	if byWeekday && (recurrence.Weekday == "" || recurrence.Week == 0) {
+		return utils.NewValidationFailedError("a weekday recurrence needs both the weekday and the week")
+	}
+	// Leap years have a February 29
+	if byDay && recurrence.Month != 0 && recurrence.Day > time.Date(2024, time.Month(recurrence.Month)+1, 0, 0, 0, 0, 0, time.UTC).Day() {
+		return utils.NewValidationFailedError("the day doesn't exist in that month")
+	}
+	return nil
+}
+
+func parseHoursClock(value string) (int, error) {
+	clock, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, err
+	}
+	return clock.Hour()*60 + clock.Minute(), nil
+}
+
+// placeHoursLocation is the place's timezone, UTC when unset or unknown
+func placeHoursLocation(hours models.PlaceHours) *time.Location {
+	if hours.Timezone != "" {
+		if location, err := time.LoadLocation(hours.Timezone); err == nil {
+			return location
+		}
+	}
+	return time.UTC
+}
+
+// recurringOverrideOn returns how specific the override is if it falls on
+// the date, and the holiday it falls on for holiday sets, or 0 if it
+// doesn't fall on the date
+func recurringOverrideOn(override models.RecurringHoursOverride, holidaySets map[primitive.ObjectID]models.HolidaySet, date time.Time) (int, string) {
+	if override.Recurrence != nil {
+		if override.Recurrence.Matches(date) {
+			return override.Recurrence.Specificity(), ""
+		}
+		return 0, ""
+	}
+
+	if override.HolidaySetID == nil {
+		return 0, ""
+	}
+	for _, holiday := range holidaySets[*override.HolidaySetID].Holidays {
+		if holiday.Matches(date) {
+			return models.HoursOverrideHoliday, holiday.Name
+		}
+	}
+	return 0, ""
+}
+
+// resolvePlaceDay returns how the place is open on the local date. A dated
+// override comes first, then the most specific recurring one, then the
+// regular hours. Equally specific recurring overrides go by the order they
+// were added, though only ones with the same hours can be added. A place
+// without a schedule is open around the clock.
+func resolvePlaceDay(hours models.PlaceHours, holidaySets map[primitive.ObjectID]models.HolidaySet, date time.Time) models.PlaceDayHours {
+	day := models.PlaceDayHours{Date: date.Format("2006-01-02")}
+
+	for _, override := range hours.Overrides {
+		if override.Date.UTC().Format("2006-01-02") != day.Date {
+			continue
+		}
+		overrideID := override.ID
+		day.IsOpen, day.StartTime, day.EndTime = override.IsOpen, override.StartTime, override.EndTime
+		day.Source = models.HoursSourceOverride
+		day.OverrideID = &overrideID
+		day.Note = override.Note
+		return day
+	}
+
+	var best *models.RecurringHoursOverride
+	var bestSpecificity int
+	var bestHoliday string
+	for i := range hours.RecurringOverrides {
+		specificity, holiday := recurringOverrideOn(hours.RecurringOverrides[i], holidaySets, date)
+		if specificity > bestSpecificity {
+			best, bestSpecificity, bestHoliday = &hours.RecurringOverrides[i], specificity, holiday
+		}
+	}
+	if best != nil {
+		overrideID := best.ID
+		day.IsOpen, day.StartTime, day.EndTime = best.IsOpen, best.StartTime, best.EndTime
+		day.Source = models.HoursSourceRecurring
+		if bestSpecificity == models.HoursOverrideHoliday {
+			day.Source = models.HoursSourceHoliday
+		}
+		day.OverrideID = &overrideID
+		day.OverrideName = best.Name
+		day.Holiday = bestHoliday
+		day.Note = best.Note
+		return day
+	}
+
+	day.Source = models.HoursSourceRegular
+	if hours.IsAlwaysOpen || len(hours.Schedule) == 0 {
+		day.IsOpen = true
+		return day
+	}
+	if regular, ok := hours.Schedule[models.Weekdays[date.Weekday()]]; ok && regular.Enabled {
+		day.IsOpen, day.StartTime, day.EndTime = true, regular.StartTime, regular.EndTime
+	}
+	return day
+}
+
+// checkRecurringOverrideClash makes sure the new override resolves one way
+// wherever it meets another: on days both fall on, an equally specific
+// override must have the same hours
+func checkRecurringOverrideClash(added models.RecurringHoursOverride, existing []models.RecurringHoursOverride, holidaySets map[primitive.ObjectID]models.HolidaySet, from time.Time) error {
+	end := from.AddDate(models.HoursOverrideCheckYears, 0, 0)
+	for date := from; date.Before(end); date = date.AddDate(0, 0, 1) {
+		specificity, _ := recurringOverrideOn(added, holidaySets, date)
+		if specificity == 0 {
+			continue
+		}
+
+		for _, other := range existing {
+			if sameOverrideHours(added, other) {
+				continue
+			}
+			if otherSpecificity, _ := recurringOverrideOn(other, holidaySets, date); otherSpecificity == specificity {
+				return utils.NewValidationFailedError(fmt.Sprintf("%q and %q both fall on %s with different hours", added.Name, other.Name, date.Format("2006-01-02")))
+			}
+		}
+	}
+	return nil
+}
+
+func sameOverrideHours(a, b models.RecurringHoursOverride) bool {
+	return a.IsOpen == b.IsOpen && a.StartTime == b.StartTime && a.EndTime == b.EndTime
+}
+
+// hoursInterval is a stretch of time a place is open
+type hoursInterval struct {
+	start, end             time.Time
+	startSource, endSource string
+}
+
+// openInterval returns when the place is open on the day. Days that close
+// at or before they open run past midnight.
+func openInterval(day models.PlaceDayHours, date time.Time) (hoursInterval, bool) {
+	if !day.IsOpen {
+		return hoursInterval{}, false
+	}
+
+	interval := hoursInterval{startSource: day.Source, endSource: day.Source}
+	if day.StartTime == "" {
+		interval.start = date
+		interval.end = date.AddDate(0, 0, 1)
+		return interval, true
+	}
+
+	start, startErr := parseHoursClock(day.StartTime)
+	end, endErr := parseHoursClock(day.EndTime)
+	if startErr != nil || endErr != nil {
+		return hoursInterval{}, false
+	}
+
+	interval.start = time.Date(date.Year(), date.Month(), date.Day(), start/60, start%60, 0, 0, date.Location())
+	interval.end = time.Date(date.Year(), date.Month(), date.Day(), end/60, end%60, 0, 0, date.Location())
+	if !interval.end.After(interval.start) {
+		interval.end = interval.end.AddDate(0, 0, 1)
+	}
+	return interval, true
+}
+
+// placeHoursStatus works out, in the place's timezone, whether the place
+// is open at now and when that next changes. Days are looked at from the
+// day before, whose hours may run past midnight, to the horizon.
+func placeHoursStatus(hours models.PlaceHours, holidaySets map[primitive.ObjectID]models.HolidaySet, now time.Time) *models.PlaceHoursStatus {
+	location := placeHoursLocation(hours)
+	now = now.In(location)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+	horizon := today.AddDate(0, 0, models.HoursTransitionHorizonDays+1)
+
+	var intervals []hoursInterval
+	for offset := -1; offset <= models.HoursTransitionHorizonDays; offset++ {
+		date := today.AddDate(0, 0, offset)
+		if interval, ok := openInterval(resolvePlaceDay(hours, holidaySets, date), date); ok {
+			intervals = append(intervals, interval)
+		}
+	}
+	sort.Slice(intervals, func(i, j int) bool {
+		return intervals[i].start.Before(intervals[j].start)
+	})
+
+	// Overnight hours can run into the next day's, and days open around
+	// the clock meet; either way the place stays open
+	var merged []hoursInterval
+	for _, interval := range intervals {
+		if last := len(merged) - 1; last >= 0 && !interval.start.After(merged[last].end) {
+			if interval.end.After(merged[last].end) {
+				merged[last].end = interval.end
+				merged[last].endSource = interval.endSource
+			}
+			continue
+		}
+		merged = append(merged, interval)
+	}
+
+	status := &models.PlaceHoursStatus{
+		Status:   "closed",
+		Timezone: location.String(),
+		Today:    resolvePlaceDay(hours, holidaySets, today),
+	}
+
+	for _, interval := range merged {
+		if !interval.end.After(now) {
+			continue
+		}
+
+		if interval.start.After(now) {
+			status.NextTransition = &models.HoursTransition{At: interval.start, IsOpen: true, Source: interval.startSource}
+			break
+		}
+
+		status.IsOpen = true
+		status.Status = "open"
+		if interval.end.Before(horizon) {
+			status.NextTransition = &models.HoursTransition{At: interval.end, IsOpen: false, Source: interval.endSource}
+		}
+		break
+	}
+
+	return status
+}
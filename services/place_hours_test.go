@@ -0,0 +1,208 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"ftrack/models"
+	"ftrack/testharness"
+	"ftrack/utils"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPlaceHoursStatusAcrossHolidayWeekdays(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no timezone data: %v", err)
+	}
+
+	publicHolidays := models.HolidaySet{
+		ID:   primitive.NewObjectID(),
+		Name: "US public holidays",
+		Holidays: []models.Holiday{
+			{Name: "Christmas Day", Recurrence: &models.HoursRecurrence{Month: 12, Day: 25}},
+			{Name: "Thanksgiving", Recurrence: &models.HoursRecurrence{Month: 11, Weekday: "thursday", Week: 4}},
+		},
+	}
+	holidaySets := map[primitive.ObjectID]models.HolidaySet{publicHolidays.ID: publicHolidays}
+
+	weekday := models.DaySchedule{Enabled: true, StartTime: "09:00", EndTime: "17:00"}
+	hours := models.PlaceHours{
+		Timezone: "America/New_York",
+		Schedule: map[string]models.DaySchedule{
+			"monday": weekday, "tuesday": weekday, "wednesday": weekday, "thursday": weekday, "friday": weekday,
+			"saturday": {Enabled: true, StartTime: "10:00", EndTime: "14:00"},
+		},
+		RecurringOverrides: []models.RecurringHoursOverride{
+			{ID: primitive.NewObjectID(), Name: "Public holidays", HolidaySetID: &publicHolidays.ID},
+			{ID: primitive.NewObjectID(), Name: "Christmas Eve", Recurrence: &models.HoursRecurrence{Month: 12, Day: 24}, IsOpen: true, StartTime: "09:00", EndTime: "12:00"},
+			{ID: primitive.NewObjectID(), Name: "Late last Friday", Recurrence: &models.HoursRecurrence{Weekday: "friday", Week: -1}, IsOpen: true, StartTime: "09:00", EndTime: "20:00"},
+		},
+	}
+	// In 2026 Christmas is also the last Friday, and opens for the morning
+	withDated := hours
+	withDated.Overrides = []models.HoursOverride{
+		{ID: primitive.NewObjectID(), Date: time.Date(2026, 12, 25, 0, 0, 0, 0, time.UTC), IsOpen: true, StartTime: "10:00", EndTime: "12:00"},
+	}
+
+	at := func(year int, month time.Month, day, hour int) time.Time {
+		return time.Date(year, month, day, hour, 0, 0, 0, newYork)
+	}
+
+	tests := []struct {
+		name     string
+		hours    models.PlaceHours
+		now      time.Time
+		open     bool
+		source   string
+		holiday  string
+		next     time.Time
+		nextOpen bool
+		nextFrom string
+	}{
+		{"Christmas on a Thursday", hours, at(2025, 12, 25, 11), false, models.HoursSourceHoliday, "Christmas Day",
+			at(2025, 12, 26, 9), true, models.HoursSourceRecurring},
+		{"Christmas on a Friday, also the last one", hours, at(2026, 12, 25, 11), false, models.HoursSourceHoliday, "Christmas Day",
+			at(2026, 12, 26, 10), true, models.HoursSourceRegular},
+		{"Christmas on a Friday with a dated override", withDated, at(2026, 12, 25, 11), true, models.HoursSourceOverride, "",
+			at(2026, 12, 25, 12), false, models.HoursSourceOverride},
+		{"Christmas on a Saturday", hours, at(2027, 12, 25, 11), false, models.HoursSourceHoliday, "Christmas Day",
+			at(2027, 12, 27, 9), true, models.HoursSourceRegular},
+		{"Christmas on a Monday", hours, at(2028, 12, 25, 11), false, models.HoursSourceHoliday, "Christmas Day",
+			at(2028, 12, 26, 9), true, models.HoursSourceRegular},
+		{"the Monday before", hours, at(2028, 12, 18, 11), true, models.HoursSourceRegular, "",
+			at(2028, 12, 18, 17), false, models.HoursSourceRegular},
+		{"Christmas Eve", hours, at(2026, 12, 24, 11), true, models.HoursSourceRecurring, "",
+			at(2026, 12, 24, 12), false, models.HoursSourceRecurring},
+		// 03:00 UTC on Christmas is still Christmas Eve in New York
+		{"Christmas Eve night", withDated, time.Date(2026, 12, 25, 3, 0, 0, 0, time.UTC), false, models.HoursSourceRecurring, "",
+			at(2026, 12, 25, 10), true, models.HoursSourceOverride},
+		{"Thanksgiving", hours, at(2026, 11, 26, 11), false, models.HoursSourceHoliday, "Thanksgiving",
+			at(2026, 11, 27, 9), true, models.HoursSourceRecurring},
+	}
+
+	for _, tt := range tests {
+		status := placeHoursStatus(tt.hours, holidaySets, tt.now)
+		if status.IsOpen != tt.open || status.Today.Source != tt.source || status.Today.Holiday != tt.holiday {
+			t.Errorf("%s: open %v from %s (holiday %q), want %v from %s (%q)", tt.name,
+				status.IsOpen, status.Today.Source, status.Today.Holiday, tt.open, tt.source, tt.holiday)
+		}
+		if status.Timezone != "America/New_York" {
+			t.Errorf("%s: timezone %s, want the place's", tt.name, status.Timezone)
+		}
+		next := status.NextTransition
+		if next == nil || !next.At.Equal(tt.next) || next.IsOpen != tt.nextOpen || next.Source != tt.nextFrom {
+			t.Errorf("%s: next transition %+v, want open=%v at %v from %s", tt.name, next, tt.nextOpen, tt.next, tt.nextFrom)
+		}
+	}
+}
+
+func TestHoursRecurrenceMatches(t *testing.T) {
+	date := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d
+	}
+
+	tests := []struct {
+		recurrence models.HoursRecurrence
+		date       string
+		want       bool
+	}{
+		{models.HoursRecurrence{Month: 12, Day: 25}, "2027-12-25", true},
+		{models.HoursRecurrence{Month: 12, Day: 25}, "2027-11-25", false},
+		{models.HoursRecurrence{Day: 1}, "2027-03-01", true},
+		{models.HoursRecurrence{Weekday: "friday", Week: -1}, "2026-07-31", true},
+		{models.HoursRecurrence{Weekday: "friday", Week: -1}, "2026-07-24", false},
+		{models.HoursRecurrence{Month: 11, Weekday: "thursday", Week: 4}, "2026-11-26", true},
+		{models.HoursRecurrence{Month: 11, Weekday: "thursday", Week: 4}, "2027-11-25", true},
+		{models.HoursRecurrence{Month: 11, Weekday: "thursday", Week: 4}, "2027-11-18", false},
+	}
+	for _, tt := range tests {
+		if got := tt.recurrence.Matches(date(tt.date)); got != tt.want {
+			t.Errorf("%+v matches %s = %v, want %v", tt.recurrence, tt.date, got, tt.want)
+		}
+	}
+}
+
+func TestPlaceServiceRecurringHoursOverrides(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ps := NewPlaceService(env.Repos.Place, env.Repos.Circle, nil)
+	ctx := context.Background()
+
+	owner, other := env.Factory.User(), env.Factory.User()
+	ownerID := owner.ID.Hex()
+	place := env.Factory.Place(owner)
+	placeID := place.ID.Hex()
+
+	set, err := ps.CreateHolidaySet(ctx, ownerID, models.HolidaySetRequest{
+		Name:     "Public holidays",
+		Holidays: []models.Holiday{{Name: "New Year's Day", Recurrence: &models.HoursRecurrence{Month: 1, Day: 1}}},
+	})
+	if err != nil {
+		t.Fatalf("CreateHolidaySet: %v", err)
+	}
+	otherSet, err := ps.CreateHolidaySet(ctx, other.ID.Hex(), models.HolidaySetRequest{Name: "Mine"})
+	if err != nil {
+		t.Fatalf("CreateHolidaySet: %v", err)
+	}
+
+	if _, err := ps.CreateRecurringHoursOverride(ctx, ownerID, placeID, models.CreateRecurringHoursOverrideRequest{Name: "Holidays", HolidaySetID: set.ID.Hex()}); err != nil {
+		t.Fatalf("CreateRecurringHoursOverride: %v", err)
+	}
+	if _, err := ps.CreateRecurringHoursOverride(ctx, ownerID, placeID, models.CreateRecurringHoursOverrideRequest{Name: "Closed", HolidaySetID: otherSet.ID.Hex()}); err == nil || err.Error() != "holiday set not found" {
+		t.Errorf("following another user's set error = %v, want holiday set not found", err)
+	}
+
+	// An equally specific override on the same day needs the same hours;
+	// a more specific one wins without clashing
+	closed, err := ps.CreateRecurringHoursOverride(ctx, ownerID, placeID, models.CreateRecurringHoursOverrideRequest{Name: "Christmas", Recurrence: &models.HoursRecurrence{Month: 12, Day: 25}})
+	if err != nil {
+		t.Fatalf("CreateRecurringHoursOverride: %v", err)
+	}
+	_, err = ps.CreateRecurringHoursOverride(ctx, ownerID, placeID, models.CreateRecurringHoursOverrideRequest{
+		Name: "Christmas brunch", Recurrence: &models.HoursRecurrence{Month: 12, Day: 25}, IsOpen: true, StartTime: "10:00", EndTime: "13:00",
+	})
+	if reason := utils.ValidationFailureReason(err); !strings.HasPrefix(reason, `"Christmas brunch" and "Christmas" both fall on `) {
+		t.Errorf("clashing override error = %v (%q), want a clash", err, reason)
+	}
+	if _, err := ps.CreateRecurringHoursOverride(ctx, ownerID, placeID, models.CreateRecurringHoursOverrideRequest{
+		Name: "Late on the 1st", Recurrence: &models.HoursRecurrence{Day: 1}, IsOpen: true, StartTime: "09:00", EndTime: "22:00",
+	}); err != nil {
+		t.Errorf("monthly override under a holiday: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		req    models.CreateRecurringHoursOverrideRequest
+		reason string
+	}{
+		{"with neither", models.CreateRecurringHoursOverrideRequest{Name: "x"}, "an override needs either a recurrence or a holiday set"},
+		{"with a day and a weekday", models.CreateRecurringHoursOverrideRequest{Name: "x", Recurrence: &models.HoursRecurrence{Day: 1, Weekday: "friday", Week: 1}}, "a recurrence needs either a day of the month or a weekday"},
+		{"without a week", models.CreateRecurringHoursOverrideRequest{Name: "x", Recurrence: &models.HoursRecurrence{Weekday: "friday"}}, "a weekday recurrence needs both the weekday and the week"},
+		{"on February 30", models.CreateRecurringHoursOverrideRequest{Name: "x", Recurrence: &models.HoursRecurrence{Month: 2, Day: 30}}, "the day doesn't exist in that month"},
+		{"closed with times", models.CreateRecurringHoursOverrideRequest{Name: "x", Recurrence: &models.HoursRecurrence{Day: 2}, StartTime: "09:00", EndTime: "10:00"}, "closed days have no opening times"},
+	} {
+		if _, err := ps.CreateRecurringHoursOverride(ctx, ownerID, placeID, tt.req); utils.ValidationFailureReason(err) != tt.reason {
+			t.Errorf("override %s error = %v, want %q", tt.name, err, tt.reason)
+		}
+	}
+
+	if _, err := ps.GetCurrentStatus(ctx, ownerID, placeID); err != nil {
+		t.Errorf("GetCurrentStatus: %v", err)
+	}
+
+	// Sets in use stay until nothing follows them
+	if err := ps.DeleteHolidaySet(ctx, ownerID, set.ID.Hex()); err == nil || err.Error() != "holiday set in use" {
+		t.Errorf("deleting a set in use error = %v, want holiday set in use", err)
+	}
+	if err := ps.DeleteHoursOverride(ctx, ownerID, placeID, closed.ID.Hex()); err != nil {
+		t.Errorf("DeleteHoursOverride: %v", err)
+	}
+	if err := ps.DeleteHoursOverride(ctx, ownerID, placeID, closed.ID.Hex()); err == nil || err.Error() != "hours override not found" {
+		t.Errorf("deleting the override again error = %v, want hours override not found", err)
+	}
+}
@@ -0,0 +1,537 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"ftrack/models"
+	"ftrack/repositories"
+	"ftrack/utils"
+	"ftrack/websocket"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PlaceListService keeps a circle's shared shopping and errand lists:
+// "whoever gets to the store next, grab these". A list linked to a place
+// nudges members arriving there while it has items left, and every change
+// is broadcast to the circle so members shopping together stay in sync.
+type PlaceListService struct {
+	listRepo            *repositories.PlaceListRepository
+	circleRepo          *repositories.CircleRepository
+	placeService        *PlaceService
+	notificationService *NotificationService
+	hub                 *websocket.Hub
+	redis               *redis.Client
+	validator           *utils.ValidationService
+}
+
+func NewPlaceListService(
+	listRepo *repositories.PlaceListRepository,
+	circleRepo *repositories.CircleRepository,
+	placeService *PlaceService,
+	notificationService *NotificationService,
+	hub *websocket.Hub,
+	redis *redis.Client,
+) *PlaceListService {
+	return &PlaceListService{
+		listRepo:            listRepo,
+		circleRepo:          circleRepo,
+		placeService:        placeService,
+		notificationService: notificationService,
+		hub:                 hub,
+		redis:               redis,
+		validator:           utils.NewValidationService(),
+	}
+}
+
+// CreateList creates a list in the circle, linked to one of its places if
+// given
+func (ls *PlaceListService) CreateList(ctx context.Context, userID, circleID string, req models.CreatePlaceListRequest) (*models.PlaceList, error) {
+	if validationErrors := ls.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	circleObjectID, userObjectID, _, err := ls.memberRole(ctx, circleID, userID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := ls.listRepo.CountUnarchived(ctx, circleObjectID)
+	if err != nil {
+		return nil, err
+	}
+	if count >= models.MaxCirclePlaceLists {
+		return nil, errors.New("list limit reached")
+	}
+
+	now := time.Now()
+	list := &models.PlaceList{
+		CircleID:   circleObjectID,
+		Name:       req.Name,
+		EditableBy: req.EditableBy,
+		Status:     models.PlaceListStatusActive,
+		Items:      make([]models.PlaceListItem, 0, len(req.Items)),
+		CreatedBy:  userObjectID,
+	}
+	if list.EditableBy == "" {
+		list.EditableBy = models.PlaceListEditMembers
+	}
+	for _, item := range req.Items {
+		list.Items = append(list.Items, newPlaceListItem(item, userObjectID, now))
+	}
+
+	if req.PlaceID != "" {
+		place, err := ls.circlePlace(ctx, userID, circleObjectID, req.PlaceID)
+		if err != nil {
+			return nil, err
+		}
+		list.PlaceID = &place.ID
+		list.PlaceName = place.Name
+	}
+
+	if err := ls.listRepo.Create(ctx, list); err != nil {
+		return nil, err
+	}
+
+	ls.broadcast(list, userID, models.PlaceListActionCreated, nil)
+	return list, nil
+}
+
+// GetLists returns the circle's lists, optionally only those of a place
+func (ls *PlaceListService) GetLists(ctx context.Context, userID, circleID string, req models.GetPlaceListsRequest) ([]models.PlaceList, error) {
+	circleObjectID, _, _, err := ls.memberRole(ctx, circleID, userID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var placeID *primitive.ObjectID
+	if req.PlaceID != "" {
+		objectID, err := primitive.ObjectIDFromHex(req.PlaceID)
+		if err != nil {
+			return nil, errors.New("invalid place ID")
+		}
+		placeID = &objectID
+	}
+
+	return ls.listRepo.GetCircleLists(ctx, circleObjectID, placeID, req.IncludeArchived)
+}
+
+func (ls *PlaceListService) GetList(ctx context.Context, userID, circleID, listID string) (*models.PlaceList, error) {
+	if _, _, _, err := ls.memberRole(ctx, circleID, userID, false); err != nil {
+		return nil, err
+	}
+	return ls.circleList(ctx, circleID, listID)
+}
+
+// UpdateList renames the list, links it to another place or changes who
+// may edit it. Only its creator and circle admins can.
+func (ls *PlaceListService) UpdateList(ctx context.Context, userID, circleID, listID string, req models.UpdatePlaceListRequest) (*models.PlaceList, error) {
+	if validationErrors := ls.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	circleObjectID, userObjectID, role, err := ls.memberRole(ctx, circleID, userID, true)
+	if err != nil {
+		return nil, err
+	}
+	list, err := ls.circleList(ctx, circleID, listID)
+	if err != nil {
+		return nil, err
+	}
+	if list.CreatedBy != userObjectID && role != "admin" {
+		return nil, errors.New("access denied")
+	}
+
+	set := bson.M{}
+	unset := bson.M{}
+	if req.Name != nil {
+		set["name"] = *req.Name
+	}
+	if req.EditableBy != nil {
+		set["editableBy"] = *req.EditableBy
+	}
+	if req.PlaceID != nil {
+		if *req.PlaceID == "" {
+			unset["placeId"] = ""
+			unset["placeName"] = ""
+		} else {
+			place, err := ls.circlePlace(ctx, userID, circleObjectID, *req.PlaceID)
+			if err != nil {
+				return nil, err
+			}
+			set["placeId"] = place.ID
+			set["placeName"] = place.Name
+		}
+	}
+
+	updated, err := ls.listRepo.Update(ctx, list.ID, set, unset)
+	if err != nil {
+		return nil, err
+	}
+
+	ls.broadcast(updated, userID, models.PlaceListActionUpdated, nil)
+	return updated, nil
+}
+
+// DeleteList deletes the list. Only its creator and circle admins can.
+func (ls *PlaceListService) DeleteList(ctx context.Context, userID, circleID, listID string) error {
+	_, userObjectID, role, err := ls.memberRole(ctx, circleID, userID, true)
+	if err != nil {
+		return err
+	}
+	list, err := ls.circleList(ctx, circleID, listID)
+	if err != nil {
+		return err
+	}
+	if list.CreatedBy != userObjectID && role != "admin" {
+		return errors.New("access denied")
+	}
+
+	if err := ls.listRepo.Delete(ctx, list.ID); err != nil {
+		return err
+	}
+
+	ls.hubBroadcast(circleID, models.WSPlaceListUpdate{
+		ListID:    listID,
+		CircleID:  circleID,
+		UserID:    userID,
+		Action:    models.PlaceListActionDeleted,
+		Timestamp: time.Now(),
+	})
+	return nil
+}
+
+// AddItem puts an item on the list, reopening it if it was done
+func (ls *PlaceListService) AddItem(ctx context.Context, userID, circleID, listID string, req models.PlaceListItemRequest) (*models.PlaceListItem, error) {
+	if validationErrors := ls.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	list, userObjectID, err := ls.editableList(ctx, userID, circleID, listID)
+	if err != nil {
+		return nil, err
+	}
+
+	item := newPlaceListItem(req, userObjectID, time.Now())
+	updated, err := ls.listRepo.AddItems(ctx, list.ID, []models.PlaceListItem{item})
+	if err != nil {
+		return nil, err
+	}
+
+	ls.broadcast(updated, userID, models.PlaceListActionItemAdded, &item)
+	return &item, nil
+}
+
+// UpdateItem changes an item or checks it off. Any member can check items
+// off; changing them takes edit rights. The list is completed once every
+// item is checked, and reopened when one is unchecked.
+func (ls *PlaceListService) UpdateItem(ctx context.Context, userID, circleID, listID, itemID string, req models.UpdatePlaceListItemRequest) (*models.PlaceListItem, error) {
+	if validationErrors := ls.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+	itemObjectID, err := primitive.ObjectIDFromHex(itemID)
+	if err != nil {
+		return nil, errors.New("invalid item ID")
+	}
+
+	var list *models.PlaceList
+	var userObjectID primitive.ObjectID
+	if req.Text != nil || req.Quantity != nil {
+		list, userObjectID, err = ls.editableList(ctx, userID, circleID, listID)
+	} else {
+		_, userObjectID, _, err = ls.memberRole(ctx, circleID, userID, true)
+		if err == nil {
+			list, err = ls.circleList(ctx, circleID, listID)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	set := bson.M{}
+	unset := bson.M{}
+	if req.Text != nil {
+		set["text"] = *req.Text
+	}
+	if req.Quantity != nil {
+		set["quantity"] = *req.Quantity
+	}
+	if req.Checked != nil {
+		set["checked"] = *req.Checked
+		if *req.Checked {
+			set["checkedBy"] = userObjectID
+			set["checkedAt"] = time.Now()
+		} else {
+			unset["checkedBy"] = ""
+			unset["checkedAt"] = ""
+		}
+	}
+
+	updated, err := ls.listRepo.UpdateItem(ctx, list.ID, itemObjectID, set, unset)
+	if err != nil {
+		return nil, err
+	}
+	if req.Checked != nil {
+		if updated, err = ls.updateCompletion(ctx, updated); err != nil {
+			return nil, err
+		}
+	}
+
+	item := updated.Item(itemObjectID)
+	if item == nil {
+		return nil, errors.New("item not found")
+	}
+
+	action := models.PlaceListActionItemUpdated
+	if req.Checked != nil && req.Text == nil && req.Quantity == nil {
+		action = models.PlaceListActionItemUnchecked
+		if *req.Checked {
+			action = models.PlaceListActionItemChecked
+		}
+	}
+	ls.broadcast(updated, userID, action, item)
+	return item, nil
+}
+
+// RemoveItem takes an item off the list. Members without edit rights can
+// remove the items they added.
+func (ls *PlaceListService) RemoveItem(ctx context.Context, userID, circleID, listID, itemID string) error {
+	itemObjectID, err := primitive.ObjectIDFromHex(itemID)
+	if err != nil {
+		return errors.New("invalid item ID")
+	}
+
+	list, userObjectID, err := ls.editableList(ctx, userID, circleID, listID)
+	if list == nil {
+		return err
+	}
+	item := list.Item(itemObjectID)
+	if item == nil {
+		return errors.New("item not found")
+	}
+	if err != nil && item.AddedBy != userObjectID {
+		return err
+	}
+
+	updated, err := ls.listRepo.RemoveItem(ctx, list.ID, itemObjectID)
+	if err != nil {
+		return err
+	}
+	if updated, err = ls.updateCompletion(ctx, updated); err != nil {
+		return err
+	}
+
+	ls.broadcast(updated, userID, models.PlaceListActionItemRemoved, item)
+	return nil
+}
+
+// HandlePlaceEntry nudges a member arriving at a place about the lists
+// linked to it that still have items to get. The nudge keeps to the
+// place's notification cooldown, so brief comings and goings don't repeat
+// it.
+func (ls *PlaceListService) HandlePlaceEntry(ctx context.Context, userID string, place models.Place) {
+	if ls.notificationService == nil || place.CircleID.IsZero() {
+		return
+	}
+
+	isMember, err := ls.circleRepo.IsMember(ctx, place.CircleID.Hex(), userID)
+	if err != nil || !isMember {
+		return
+	}
+
+	lists, err := ls.listRepo.GetPendingForPlace(ctx, place.ID, []primitive.ObjectID{place.CircleID})
+	if err != nil {
+		logrus.Errorf("Failed to get lists of place %s: %v", place.ID.Hex(), err)
+		return
+	}
+	if len(lists) == 0 || !ls.nudgeCooldownElapsed(ctx, userID, place) {
+		return
+	}
+
+	for _, list := range lists {
+		count := list.UncheckedCount()
+		noun := "items"
+		if count == 1 {
+			noun = "item"
+		}
+
+		err := ls.notificationService.SendNotification(ctx, models.SendNotificationRequest{
+			Recipients: []string{userID},
+			Title:      place.Name,
+			Message:    fmt.Sprintf("%d %s on the %s list", count, noun, list.Name),
+			Type:       models.NotificationTypePlaceListNudge,
+			Priority:   "normal",
+			Category:   "place",
+			CircleID:   list.CircleID.Hex(),
+			Data: map[string]interface{}{
+				"listId":   list.ID.Hex(),
+				"circleId": list.CircleID.Hex(),
+				"placeId":  place.ID.Hex(),
+			},
+			DeliveryChannels: []string{"push", "in-app"},
+			Immediate:        true,
+		})
+		if err != nil {
+			logrus.Errorf("Failed to nudge user %s about list %s: %v", userID, list.ID.Hex(), err)
+		}
+	}
+}
+
+// nudgeCooldownElapsed reports whether the member may be nudged at the place
+// again, and starts the place's cooldown if so
+func (ls *PlaceListService) nudgeCooldownElapsed(ctx context.Context, userID string, place models.Place) bool {
+	cooldown := place.Notifications.Cooldown
+	if cooldown <= 0 || ls.redis == nil {
+		return true
+	}
+
+	key := fmt.Sprintf("place_cooldown:%s:%s:list_nudge", place.ID.Hex(), userID)
+	started, err := ls.redis.SetNX(ctx, key, 1, time.Duration(cooldown)*time.Minute).Result()
+	if err != nil {
+		logrus.Warnf("Failed to check list nudge cooldown: %v", err)
+		return true
+	}
+	return started
+}
+
+// updateCompletion completes the list once nothing is left to get, and
+// reopens it when something is
+func (ls *PlaceListService) updateCompletion(ctx context.Context, list *models.PlaceList) (*models.PlaceList, error) {
+	done := len(list.Items) > 0 && list.UncheckedCount() == 0
+	switch {
+	case done && list.Status == models.PlaceListStatusActive:
+		return ls.listRepo.Update(ctx, list.ID, bson.M{
+			"status":      models.PlaceListStatusCompleted,
+			"completedAt": time.Now(),
+		}, nil)
+	case !done && list.Status != models.PlaceListStatusActive:
+		return ls.listRepo.Update(ctx, list.ID, bson.M{
+			"status": models.PlaceListStatusActive,
+		}, bson.M{"completedAt": "", "archivedAt": ""})
+	}
+	return list, nil
+}
+
+// memberRole returns the IDs and the user's role in the circle. Writes are
+// refused in circles merged into another one.
+func (ls *PlaceListService) memberRole(ctx context.Context, circleID, userID string, write bool) (primitive.ObjectID, primitive.ObjectID, string, error) {
+	circleObjectID, err := primitive.ObjectIDFromHex(circleID)
+	if err != nil {
+		return primitive.NilObjectID, primitive.NilObjectID, "", errors.New("invalid circle ID")
+	}
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return primitive.NilObjectID, primitive.NilObjectID, "", errors.New("invalid user ID")
+	}
+
+	role, err := ls.circleRepo.GetMemberRole(ctx, circleID, userID)
+	if err != nil {
+		if err.Error() == "member not found" {
+			return primitive.NilObjectID, primitive.NilObjectID, "", errors.New("access denied")
+		}
+		return primitive.NilObjectID, primitive.NilObjectID, "", err
+	}
+
+	if write {
+		archived, err := ls.circleRepo.IsArchived(ctx, circleID)
+		if err != nil {
+			return primitive.NilObjectID, primitive.NilObjectID, "", err
+		}
+		if archived {
+			return primitive.NilObjectID, primitive.NilObjectID, "", errors.New("circle is archived")
+		}
+	}
+
+	return circleObjectID, userObjectID, role, nil
+}
+
+// circleList returns the list if it belongs to the circle
+func (ls *PlaceListService) circleList(ctx context.Context, circleID, listID string) (*models.PlaceList, error) {
+	list, err := ls.listRepo.GetByID(ctx, listID)
+	if err != nil {
+		return nil, err
+	}
+	if list.CircleID.Hex() != circleID {
+		return nil, errors.New("list not found")
+	}
+	return list, nil
+}
+
+// editableList returns the list if the user may change its items. When
+// they may not, the list is still returned with the error.
+func (ls *PlaceListService) editableList(ctx context.Context, userID, circleID, listID string) (*models.PlaceList, primitive.ObjectID, error) {
+	_, userObjectID, role, err := ls.memberRole(ctx, circleID, userID, true)
+	if err != nil {
+		return nil, userObjectID, err
+	}
+	list, err := ls.circleList(ctx, circleID, listID)
+	if err != nil {
+		return nil, userObjectID, err
+	}
+
+	if list.EditableBy == models.PlaceListEditAdmins && role != "admin" && list.CreatedBy != userObjectID {
+		return list, userObjectID, errors.New("access denied")
+	}
+	return list, userObjectID, nil
+}
+
+// circlePlace returns the place if the user can see it and it belongs to
+// the circle
+func (ls *PlaceListService) circlePlace(ctx context.Context, userID string, circleID primitive.ObjectID, placeID string) (*models.Place, error) {
+	if _, err := primitive.ObjectIDFromHex(placeID); err != nil {
+		return nil, errors.New("invalid place ID")
+	}
+
+	place, err := ls.placeService.GetPlace(ctx, userID, placeID)
+	if err != nil {
+		return nil, err
+	}
+	if place.CircleID != circleID {
+		return nil, utils.NewValidationFailedError("the place isn't one of the circle's places")
+	}
+	return place, nil
+}
+
+func (ls *PlaceListService) broadcast(list *models.PlaceList, userID, action string, item *models.PlaceListItem) {
+	update := models.WSPlaceListUpdate{
+		ListID:    list.ID.Hex(),
+		CircleID:  list.CircleID.Hex(),
+		UserID:    userID,
+		Action:    action,
+		Status:    list.Status,
+		Item:      item,
+		Timestamp: time.Now(),
+	}
+	if item == nil {
+		update.List = list
+	}
+	ls.hubBroadcast(list.CircleID.Hex(), update)
+}
+
+func (ls *PlaceListService) hubBroadcast(circleID string, update models.WSPlaceListUpdate) {
+	if ls.hub == nil {
+		return
+	}
+	ls.hub.BroadcastMessage(circleID, models.WSMessage{
+		Type:      models.WSTypePlaceListUpdate,
+		Data:      update,
+		UserID:    update.UserID,
+		Timestamp: update.Timestamp,
+	})
+}
+
+func newPlaceListItem(req models.PlaceListItemRequest, addedBy primitive.ObjectID, at time.Time) models.PlaceListItem {
+	return models.PlaceListItem{
+		ID:       primitive.NewObjectID(),
+		Text:     req.Text,
+		Quantity: req.Quantity,
+		AddedBy:  addedBy,
+		AddedAt:  at,
+	}
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"ftrack/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// NotifyOwnerOfVisit tells a shared place's owner that another member's
+// visit there was detected. It's separate from the place's arrival alerts:
+// the owner hears about every member's visit, whether or not the circle
+// does, and their own visits don't notify them. Preferences and quiet hours
+// apply as for any notification.
+func (ps *PlaceService) NotifyOwnerOfVisit(ctx context.Context, place *models.Place, visit *models.PlaceVisit) {
+	if ps.notificationService == nil || !place.Notifications.NotifyOwner {
+		return
+	}
+	if !place.IsShared && place.CircleID.IsZero() {
+		return
+	}
+
+	ownerID := place.UserID.Hex()
+	visitorID := visit.UserID.Hex()
+	if place.UserID.IsZero() || ownerID == visitorID {
+		return
+	}
+
+	// An owner who left the circle no longer hears about its members
+	circleID := ""
+	if !place.CircleID.IsZero() {
+		circleID = place.CircleID.Hex()
+	}
+
+	visitorName := "A circle member"
+	if ps.userRepo != nil {
+		if visitor, err := ps.userRepo.GetByID(ctx, visitorID); err == nil {
+			visitorName = visitor.FirstName
+		}
+	}
+
+	err := ps.notificationService.SendNotification(ctx, models.SendNotificationRequest{
+		Recipients: []string{ownerID},
+		Title:      fmt.Sprintf("Visit to %s", place.Name),
+		Message:    fmt.Sprintf("%s arrived at %s", visitorName, place.Name),
+		Type:       models.NotificationTypePlaceOwnerVisit,
+		Priority:   "low",
+		Category:   "place",
+		CircleID:   circleID,
+		Data: map[string]interface{}{
+			"placeId":     place.ID.Hex(),
+			"visitId":     visit.ID.Hex(),
+			"visitorId":   visitorID,
+			"arrivalTime": visit.ArrivalTime.Format(time.RFC3339), // shown in the owner's time zone
+		},
+		DeliveryChannels: []string{"push"},
+		SubjectUserID:    visitorID,
+	})
+	if err != nil {
+		logrus.Errorf("Failed to notify owner of place %s of a visit: %v", place.ID.Hex(), err)
+	}
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"ftrack/models"
+	"ftrack/testharness"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestNotifyOwnerOfVisit(t *testing.T) {
+	owner, visitor := primitive.NewObjectID(), primitive.NewObjectID()
+	circleID := primitive.NewObjectID()
+	arrival := time.Date(2026, 10, 17, 15, 4, 0, 0, time.UTC)
+
+	sharedPlace := func(opts ...func(*models.Place)) *models.Place {
+		place := &models.Place{
+			ID:            primitive.NewObjectID(),
+			UserID:        owner,
+			CircleID:      circleID,
+			Name:          "Lake House",
+			IsShared:      true,
+			Notifications: models.PlaceNotifications{NotifyOwner: true},
+		}
+		for _, opt := range opts {
+			opt(place)
+		}
+		return place
+	}
+	visitBy := func(userID primitive.ObjectID) *models.PlaceVisit {
+		return &models.PlaceVisit{ID: primitive.NewObjectID(), UserID: userID, ArrivalTime: arrival, IsOngoing: true}
+	}
+
+	tests := []struct {
+		name   string
+		place  *models.Place
+		visit  *models.PlaceVisit
+		notify bool
+	}{
+		{"member visits a shared place", sharedPlace(), visitBy(visitor), true},
+		{"shared without a circle", sharedPlace(func(place *models.Place) { place.CircleID = primitive.NilObjectID }), visitBy(visitor), true},
+		{"in a circle without the shared flag", sharedPlace(func(place *models.Place) { place.IsShared = false }), visitBy(visitor), true},
+		{"setting off", sharedPlace(func(place *models.Place) { place.Notifications.NotifyOwner = false }), visitBy(visitor), false},
+		{"private place", sharedPlace(func(place *models.Place) { place.IsShared, place.CircleID = false, primitive.NilObjectID }), visitBy(visitor), false},
+		{"owner's own visit", sharedPlace(), visitBy(owner), false},
+	}
+
+	for _, tt := range tests {
+		notifier := testharness.NewFakeNotifier()
+		ps := NewPlaceService(nil, nil, nil)
+		ps.ConfigureCheckinNotifications(notifier)
+
+		ps.NotifyOwnerOfVisit(context.Background(), tt.place, tt.visit)
+
+		sent := notifier.Sent(models.NotificationTypePlaceOwnerVisit)
+		if !tt.notify {
+			if len(sent) != 0 {
+				t.Errorf("%s: owner notified %+v, want no notification", tt.name, sent)
+			}
+			continue
+		}
+		if len(sent) != 1 {
+			t.Errorf("%s: %d notifications, want 1", tt.name, len(sent))
+			continue
+		}
+
+		req := sent[0]
+		data := req.Data.(map[string]interface{})
+		if len(req.Recipients) != 1 || req.Recipients[0] != owner.Hex() || req.SubjectUserID != visitor.Hex() {
+			t.Errorf("%s: notification to %v about %s, want the owner about the visitor", tt.name, req.Recipients, req.SubjectUserID)
+		}
+		if req.Message != "A circle member arrived at Lake House" || data["arrivalTime"] != "2026-10-17T15:04:00Z" ||
+			data["visitorId"] != visitor.Hex() || data["visitId"] != tt.visit.ID.Hex() {
+			t.Errorf("%s: notification %q with data %v, want the member and arrival time", tt.name, req.Message, data)
+		}
+		wantCircle := ""
+		if !tt.place.CircleID.IsZero() {
+			wantCircle = circleID.Hex()
+		}
+		if req.CircleID != wantCircle {
+			t.Errorf("%s: notification in circle %q, want %q", tt.name, req.CircleID, wantCircle)
+		}
+	}
+
+	// Without notifications configured nothing is sent, nor does it fail
+	NewPlaceService(nil, nil, nil).NotifyOwnerOfVisit(context.Background(), sharedPlace(), visitBy(visitor))
+}
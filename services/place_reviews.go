@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"ftrack/models"
+	"ftrack/utils"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GetReviewStats summarizes a place's reviews, leaving out moderated ones
+func (ps *PlaceService) GetReviewStats(ctx context.Context, userID, placeID string) (*models.PlaceReviewStats, error) {
+	place, err := ps.placeRepo.GetByID(ctx, placeID)
+	if err != nil {
+		return nil, err
+	}
+	if place.UserID.Hex() != userID {
+		hasAccess, err := ps.hasPlaceAccess(ctx, userID, place)
+		if err != nil || !hasAccess {
+			return nil, errors.New("access denied")
+		}
+	}
+
+	return ps.placeRepo.GetReviewStats(ctx, placeID)
+}
+
+// ReportReview sends a review to the admins' moderation queue
+func (ps *PlaceService) ReportReview(ctx context.Context, userID, placeID, reviewID string, req models.ReportPlaceReviewRequest) (*models.PlaceReviewReport, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	if validationErrors := ps.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	review, err := ps.placeRepo.GetReviewByID(ctx, reviewID)
+	if err != nil {
+		return nil, err
+	}
+	if review.PlaceID.Hex() != placeID {
+		return nil, errors.New("review not found")
+	}
+	if review.UserID == userObjectID {
+		return nil, errors.New("cannot report own review")
+	}
+
+	place, err := ps.placeRepo.GetByID(ctx, placeID)
+	if err != nil {
+		return nil, err
+	}
+	if place.UserID != userObjectID {
+		hasAccess, err := ps.hasPlaceAccess(ctx, userID, place)
+		if err != nil || !hasAccess {
+			return nil, errors.New("access denied")
+		}
+	}
+
+	report := &models.PlaceReviewReport{
+		ReviewID:    review.ID,
+		PlaceID:     review.PlaceID,
+		ReportedBy:  userObjectID,
+		Reason:      req.Reason,
+		Description: req.Description,
+	}
+	if err := ps.placeRepo.CreateReviewReport(ctx, report); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// GetReviewReports lists review reports for admins, pending ones by default
+func (ps *PlaceService) GetReviewReports(ctx context.Context, status string, page, pageSize int) (*models.PlaceReviewReportsResponse, error) {
+	if status == "" {
+		status = models.ReviewReportPending
+	}
+	if status != models.ReviewReportPending && status != models.ReviewReportResolved && status != models.ReviewReportDismissed {
+		return nil, errors.New("invalid report status")
+	}
+	page, pageSize = models.NormalizePage(page, pageSize)
+
+	reports, total, err := ps.placeRepo.GetReviewReports(ctx, status, page, pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.PlaceReviewReportsResponse{
+		Reports: reports,
+		Meta: models.PaginationMeta{
+			Page:       page,
+			PageSize:   pageSize,
+			Total:      total,
+			TotalPages: int((total + int64(pageSize) - 1) / int64(pageSize)),
+		},
+	}, nil
+}
+
+// HandleReviewReport hides or restores the reported review, or dismisses
+// the report. The decision closes every pending report of the review.
+func (ps *PlaceService) HandleReviewReport(ctx context.Context, adminID, reportID string, req models.HandleReviewReportRequest) (*models.PlaceReviewReport, error) {
+	adminObjectID, err := primitive.ObjectIDFromHex(adminID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	if validationErrors := ps.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	report, err := ps.placeRepo.GetReviewReportByID(ctx, reportID)
+	if err != nil {
+		return nil, err
+	}
+
+	review, err := ps.placeRepo.GetReviewByID(ctx, report.ReviewID.Hex())
+	if err != nil {
+		return nil, err
+	}
+
+	status := models.ReviewReportResolved
+	switch req.Action {
+	case models.ReviewReportActionHide:
+		err = ps.placeRepo.SetReviewModeration(ctx, review, models.ReviewModerationHidden, req.Resolution, adminObjectID)
+	case models.ReviewReportActionRestore:
+		err = ps.placeRepo.SetReviewModeration(ctx, review, models.ReviewModerationVisible, req.Resolution, adminObjectID)
+	case models.ReviewReportActionDismiss:
+		status = models.ReviewReportDismissed
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ps.placeRepo.ResolvePendingReviewReports(ctx, review.ID, status, req.Action, req.Resolution, adminObjectID); err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("Place review %s report %s handled by admin %s: %s", review.ID.Hex(), reportID, adminID, req.Action)
+	return ps.placeRepo.GetReviewReportByID(ctx, reportID)
+}
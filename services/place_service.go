@@ -2,11 +2,16 @@ package services
 
 import (
 	"context"
+	"encoding/csv"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"ftrack/models"
 	"ftrack/repositories"
 	"ftrack/utils"
+	"io"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,14 +20,42 @@ import (
 )
 
 type PlaceService struct {
-	placeRepo  *repositories.PlaceRepository
-	circleRepo *repositories.CircleRepository
+	placeRepo     *repositories.PlaceRepository
+	circleRepo    *repositories.CircleRepository
+	exportService *ExportService
+	validator     *utils.ValidationService
+	typeahead     *placeTypeaheadCache
+	clusters      *placeClusterCache
+
+	duplicateDistance float64 // meters
+
+	radiusBounds     RadiusBounds
+	planRadiusBounds map[string]RadiusBounds
+	userRepo         *repositories.UserRepository
+
+	notificationService NotificationDispatcher           // check-in, deleted place and owner visit notifications, optional
+	mediaRepo           *repositories.MediaRepository    // check-in photos, optional
+	locationRepo        *repositories.LocationRepository // place suggestions, optional
+
+	trendingHalfLife time.Duration
+	popularHalfLife  time.Duration
 }
 
-func NewPlaceService(placeRepo *repositories.PlaceRepository, circleRepo *repositories.CircleRepository) *PlaceService {
+func NewPlaceService(placeRepo *repositories.PlaceRepository, circleRepo *repositories.CircleRepository, exportService *ExportService) *PlaceService {
 	return &PlaceService{
-		placeRepo:  placeRepo,
-		circleRepo: circleRepo,
+		placeRepo:     placeRepo,
+		circleRepo:    circleRepo,
+		exportService: exportService,
+		validator:     utils.NewValidationService(),
+		typeahead:     newPlaceTypeaheadCache(),
+		clusters:      newPlaceClusterCache(),
+
+		duplicateDistance: DefaultPlaceDuplicateDistance,
+
+		radiusBounds: RadiusBounds{Min: DefaultPlaceRadiusMin, Max: DefaultPlaceRadiusMax},
+
+		trendingHalfLife: DefaultPlaceTrendingHalfLife,
+		popularHalfLife:  DefaultPlacePopularHalfLife,
 	}
 }
 
@@ -34,35 +67,86 @@ func (ps *PlaceService) CreatePlace(ctx context.Context, userID string, req mode
 		return nil, errors.New("invalid user ID")
 	}
 
-	// Validate coordinates and radius
-	if req.Latitude < -90 || req.Latitude > 90 || req.Longitude < -180 || req.Longitude > 180 {
-		return nil, errors.New("invalid coordinates")
+	if err := ps.validatePlaceNotifications(req.Notifications); err != nil {
+		return nil, err
+	}
+
+	tags, err := normalizePlaceTags(req.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ps.validateGeofence(ctx, userID, req.Latitude, req.Longitude, req.Radius); err != nil {
+		return nil, err
+	}
+
+	var circleObjectID primitive.ObjectID
+	if req.CircleID != "" {
+		isMember, err := ps.circleRepo.IsMember(ctx, req.CircleID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if !isMember {
+			return nil, errors.New("access denied")
+		}
+		// Circles merged into another one are read-only
+		archived, err := ps.circleRepo.IsArchived(ctx, req.CircleID)
+		if err != nil {
+			return nil, err
+		}
+		if archived {
+			return nil, errors.New("circle is archived")
+		}
+		circleObjectID, _ = primitive.ObjectIDFromHex(req.CircleID)
 	}
-	if req.Radius < 10 || req.Radius > 5000 {
-		return nil, errors.New("radius must be between 10 and 5000 meters")
+	if err := ps.validateNotifyMembers(ctx, req.CircleID, req.Notifications.NotifyMembers); err != nil {
+		return nil, err
+	}
+
+	// Near-identical places multiply geofence notifications, so similar
+	// places are offered instead unless the client insists
+	if !req.Force {
+		candidates, err := ps.findDuplicatePlaces(ctx, userID, req)
+		if err != nil {
+			logrus.Warnf("Failed to check for duplicate places of user %s: %v", userID, err)
+		} else if len(candidates) > 0 {
+			return nil, &DuplicatePlaceError{Candidates: candidates}
+		}
 	}
 
 	place := &models.Place{
-		UserID:        userObjectID,
-		Name:          req.Name,
-		Description:   req.Description,
-		Address:       req.Address,
-		Latitude:      req.Latitude,
-		Longitude:     req.Longitude,
-		Radius:        req.Radius,
-		Category:      req.Category,
-		Color:         req.Color,
-		Icon:          req.Icon,
-		IsPublic:      req.IsPublic,
-		IsShared:      req.IsShared,
-		IsActive:      true,
-		IsFavorite:    false,
-		Tags:          req.Tags,
-		Priority:      req.Priority,
-		Notifications: req.Notifications,
-		Hours:         req.Hours,
-		Geofence:      req.Geofence,
-		Metadata:      req.Metadata,
+		UserID:           userObjectID,
+		CircleID:         circleObjectID,
+		Name:             req.Name,
+		Description:      req.Description,
+		Address:          req.Address,
+		Latitude:         req.Latitude,
+		Longitude:        req.Longitude,
+		Radius:           req.Radius,
+		Category:         req.Category,
+		Color:            req.Color,
+		Icon:             req.Icon,
+		IsPublic:         req.IsPublic,
+		IsShared:         req.IsShared,
+		IsActive:         true,
+		Tags:             tags,
+		Priority:         req.Priority,
+		Notifications:    req.Notifications,
+		Hours:            req.Hours,
+		Geofence:         req.Geofence,
+		Metadata:         req.Metadata,
+		TemplateID:       req.TemplateID,
+		TemplateAuthorID: req.TemplateAuthorID,
+	}
+
+	// Settings left at their zero value in a circle follow the circle's
+	// defaults. They can be set to false or zero later through the place's
+	// geofencing settings.
+	if !circleObjectID.IsZero() {
+		place.InheritedSettings = unsetPlaceSettings(req)
+		resolved := ResolvePlaceSettings(place, ps.circleGeofenceDefaults(ctx, place))
+		place.Notifications = resolved.Notifications
+		place.Geofence = resolved.Geofence
 	}
 
 	// Initialize sharing settings
@@ -81,6 +165,8 @@ func (ps *PlaceService) CreatePlace(ctx context.Context, userID string, req mode
 	if err != nil {
 		return nil, err
 	}
+	ps.invalidatePlaceTypeahead(ctx, place)
+	place.Warnings = ps.geofenceWarnings(ctx, userID, place.ID, place.Latitude, place.Longitude, place.Radius)
 
 	logrus.Infof("Place created: %s for user %s", place.Name, userID)
 	return place, nil
@@ -109,6 +195,13 @@ func (ps *PlaceService) GetUserPlaces(ctx context.Context, userID string, req mo
 		return nil, err
 	}
 
+	labelled := make([]*models.Place, len(places))
+	for i := range places {
+		labelled[i] = &places[i]
+	}
+	ps.applyPlaceAliases(ctx, userID, labelled...)
+	ps.applyPlaceFavorites(ctx, userID, labelled...)
+
 	var placeResponses []models.PlaceResponse
 	for _, place := range places {
 		response := models.PlaceResponse{
@@ -127,14 +220,7 @@ func (ps *PlaceService) GetUserPlaces(ctx context.Context, userID string, req mo
 	}
 
 	// Calculate pagination
-	page := req.Page
-	if page < 1 {
-		page = 1
-	}
-	pageSize := req.PageSize
-	if pageSize < 1 {
-		pageSize = 20
-	}
+	page, pageSize := models.NormalizePage(req.Page, req.PageSize)
 
 	return &models.PlacesResponse{
 		Places: placeResponses,
@@ -170,19 +256,25 @@ func (ps *PlaceService) UpdatePlace(ctx context.Context, userID, placeID string,
 	if req.Address != nil {
 		updates["address"] = *req.Address
 	}
+
+	// The geofence is checked as a whole, with the values that don't change
+	latitude, longitude, radius := place.Latitude, place.Longitude, place.Radius
+	geofenceChanged := false
 	if req.Latitude != nil && req.Longitude != nil {
-		// Validate coordinates
-		if *req.Latitude < -90 || *req.Latitude > 90 || *req.Longitude < -180 || *req.Longitude > 180 {
-			return nil, errors.New("invalid coordinates")
-		}
-		updates["latitude"] = *req.Latitude
-		updates["longitude"] = *req.Longitude
+		latitude, longitude = *req.Latitude, *req.Longitude
+		updates["latitude"] = latitude
+		updates["longitude"] = longitude
+		geofenceChanged = true
 	}
 	if req.Radius != nil {
-		if *req.Radius < 10 || *req.Radius > 5000 {
-			return nil, errors.New("radius must be between 10 and 5000 meters")
+		radius = *req.Radius
+		updates["radius"] = radius
+		geofenceChanged = true
+	}
+	if geofenceChanged {
+		if err := ps.validateGeofence(ctx, userID, latitude, longitude, radius); err != nil {
+			return nil, err
 		}
-		updates["radius"] = *req.Radius
 	}
 	if req.Category != nil {
 		updates["category"] = *req.Category
@@ -202,17 +294,25 @@ func (ps *PlaceService) UpdatePlace(ctx context.Context, userID, placeID string,
 	if req.IsActive != nil {
 		updates["isActive"] = *req.IsActive
 	}
-	if req.IsFavorite != nil {
-		updates["isFavorite"] = *req.IsFavorite
-	}
 	if req.Tags != nil {
-		updates["tags"] = req.Tags
+		tags, err := normalizePlaceTags(req.Tags)
+		if err != nil {
+			return nil, err
+		}
+		updates["tags"] = tags
 	}
 	if req.Priority != nil {
 		updates["priority"] = *req.Priority
 	}
 	if req.Notifications != nil {
+		if err := ps.validatePlaceNotifications(*req.Notifications); err != nil {
+			return nil, err
+		}
+		if err := ps.validateNotifyMembers(ctx, placeCircleID(place), req.Notifications.NotifyMembers); err != nil {
+			return nil, err
+		}
 		updates["notifications"] = *req.Notifications
+		updates["inheritedSettings"] = withoutSettings(place.InheritedSettings, placeNotificationSettings...)
 	}
 	if req.Hours != nil {
 		updates["hours"] = *req.Hours
@@ -225,9 +325,26 @@ func (ps *PlaceService) UpdatePlace(ctx context.Context, userID, placeID string,
 	if err != nil {
 		return nil, err
 	}
+	ps.invalidatePlaceTypeahead(ctx, place)
 
 	// Return updated place
-	return ps.placeRepo.GetByID(ctx, placeID)
+	updated, err := ps.placeRepo.GetByID(ctx, placeID)
+	if err != nil {
+		return nil, err
+	}
+	ps.recordPlaceVersion(ctx, userID, place, updated, models.PlaceVersionUpdate, nil)
+	if geofenceChanged {
+		updated.Warnings = ps.geofenceWarnings(ctx, userID, updated.ID, updated.Latitude, updated.Longitude, updated.Radius)
+	}
+
+	// Favorites are the member's own, not part of the place
+	if req.IsFavorite != nil {
+		if err := ps.placeRepo.SetPlaceFavorite(ctx, place.UserID, place.ID, *req.IsFavorite); err != nil {
+			return nil, err
+		}
+	}
+	ps.applyPlaceFavorites(ctx, userID, updated)
+	return updated, nil
 }
 
 func (ps *PlaceService) DeletePlace(ctx context.Context, userID, placeID string) error {
@@ -245,6 +362,9 @@ func (ps *PlaceService) DeletePlace(ctx context.Context, userID, placeID string)
 	if err != nil {
 		return err
 	}
+	ps.invalidatePlaceTypeahead(ctx, place)
+	ps.clearPlaceAliases(ctx, place)
+	ps.clearPlaceFavorites(ctx, place)
 
 	logrus.Infof("Place deleted: %s by user %s", place.Name, userID)
 	return nil
@@ -381,68 +501,72 @@ func (ps *PlaceService) CreateReview(ctx context.Context, userID, placeID string
 		return nil, errors.New("rating must be between 1 and 5")
 	}
 
-	review := &models.PlaceReview{
-		PlaceID:  placeObjectID,
-		UserID:   userObjectID,
-		Rating:   rating,
-		Title:    title,
-		Comment:  comment,
-		IsPublic: isPublic,
-	}
-
-	err = ps.placeRepo.CreateReview(ctx, review)
+	place, err := ps.placeRepo.GetByID(ctx, placeID)
 	if err != nil {
 		return nil, err
 	}
+	if place.UserID.Hex() != userID {
+		hasAccess, err := ps.hasPlaceAccess(ctx, userID, place)
+		if err != nil || !hasAccess {
+			return nil, errors.New("access denied")
+		}
+	}
 
-	return review, nil
-}
-
-func (ps *PlaceService) GetPlaceReviews(ctx context.Context, placeID string, page, pageSize int) ([]models.PlaceReview, int64, error) {
-	return ps.placeRepo.GetPlaceReviews(ctx, placeID, page, pageSize)
-}
-
-// ==================== CHECKIN OPERATIONS ====================
+	if utils.ContainsProfanity(title) || utils.ContainsProfanity(comment) {
+		return nil, errors.New("review contains inappropriate language")
+	}
 
-func (ps *PlaceService) CheckIn(ctx context.Context, userID, placeID, message string, isPublic bool, location models.Location) (*models.PlaceCheckin, error) {
-	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	// One review per user per place, and only from people who've been there
+	reviewed, err := ps.placeRepo.HasReviewed(ctx, userObjectID, placeObjectID)
 	if err != nil {
-		return nil, errors.New("invalid user ID")
+		return nil, err
+	}
+	if reviewed {
+		return nil, errors.New("already reviewed")
 	}
 
-	placeObjectID, err := primitive.ObjectIDFromHex(placeID)
+	visited, err := ps.placeRepo.HasVisited(ctx, userObjectID, placeObjectID)
 	if err != nil {
-		return nil, errors.New("invalid place ID")
+		return nil, err
+	}
+	if !visited {
+		return nil, errors.New("must visit before reviewing")
 	}
 
-	checkin := &models.PlaceCheckin{
+	review := &models.PlaceReview{
 		PlaceID:  placeObjectID,
 		UserID:   userObjectID,
-		Message:  message,
+		Rating:   rating,
+		Title:    title,
+		Comment:  comment,
 		IsPublic: isPublic,
-		Location: location,
 	}
 
-	err = ps.placeRepo.CreateCheckin(ctx, checkin)
+	err = ps.placeRepo.CreateReview(ctx, review)
 	if err != nil {
 		return nil, err
 	}
 
-	return checkin, nil
+	return review, nil
 }
 
-func (ps *PlaceService) GetPlaceCheckins(ctx context.Context, placeID string, page, pageSize int) ([]models.PlaceCheckin, int64, error) {
-	return ps.placeRepo.GetPlaceCheckins(ctx, placeID, page, pageSize)
+func (ps *PlaceService) GetPlaceReviews(ctx context.Context, placeID string, page, pageSize int) ([]models.PlaceReview, int64, error) {
+	return ps.placeRepo.GetPlaceReviews(ctx, placeID, page, pageSize)
 }
 
 // ==================== AUTOMATION OPERATIONS ====================
 
-func (ps *PlaceService) CreateAutomationRule(ctx context.Context, userID, placeID, name, ruleType string, conditions []models.RuleCondition, actions []models.RuleAction) (*models.AutomationRule, error) {
+func (ps *PlaceService) CreateAutomationRule(ctx context.Context, userID, placeID, name, ruleType string, conditions []models.RuleCondition, actions []models.RuleAction, priority int, stopOnMatch bool) (*models.AutomationRule, error) {
 	userObjectID, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
 		return nil, errors.New("invalid user ID")
 	}
 
+	if priority < models.MinAutomationRulePriority || priority > models.MaxAutomationRulePriority {
+		return nil, utils.NewValidationFailedError(fmt.Sprintf("priority must be between %d and %d",
+			models.MinAutomationRulePriority, models.MaxAutomationRulePriority))
+	}
+
 	var placeObjectID *primitive.ObjectID
 	if placeID != "" {
 		pID, err := primitive.ObjectIDFromHex(placeID)
@@ -462,6 +586,10 @@ func (ps *PlaceService) CreateAutomationRule(ctx context.Context, userID, placeI
 		placeObjectID = &pID
 	}
 
+	if err := normalizeRuleTagConditions(conditions); err != nil {
+		return nil, err
+	}
+
 	// Add placeID to conditions and actions that need it
 	for i := range conditions {
 		if conditions[i].Type == "place" && placeObjectID != nil {
@@ -475,6 +603,15 @@ func (ps *PlaceService) CreateAutomationRule(ctx context.Context, userID, placeI
 		}
 	}
 
+	if ruleType == models.AutomationRulePlaceDND {
+		if placeObjectID != nil && !hasPlaceCondition(conditions) {
+			conditions = append(conditions, models.RuleCondition{Type: "place", PlaceID: placeObjectID})
+		}
+		if err := normalizePlaceDNDRule(conditions, actions); err != nil {
+			return nil, err
+		}
+	}
+
 	rule := &models.AutomationRule{
 		UserID:       userObjectID,
 		Name:         name,
@@ -483,6 +620,8 @@ func (ps *PlaceService) CreateAutomationRule(ctx context.Context, userID, placeI
 		Conditions:   conditions,
 		Actions:      actions,
 		TriggerCount: 0,
+		Priority:     priority,
+		StopOnMatch:  stopOnMatch,
 	}
 
 	// Set CircleID if it's a place-related rule and place has circle
@@ -503,6 +642,7 @@ func (ps *PlaceService) CreateAutomationRule(ctx context.Context, userID, placeI
 
 func (ps *PlaceService) GetAutomationRules(ctx context.Context, userID, placeID string) ([]models.AutomationRule, error) {
 	// If placeID is provided, verify access
+	var placeTags []string
 	if placeID != "" {
 		place, err := ps.placeRepo.GetByID(ctx, placeID)
 		if err != nil {
@@ -512,9 +652,797 @@ func (ps *PlaceService) GetAutomationRules(ctx context.Context, userID, placeID
 		if place.UserID.Hex() != userID {
 			return nil, errors.New("access denied")
 		}
+		placeTags = place.Tags
 	}
 
-	return ps.placeRepo.GetAutomationRules(ctx, userID, placeID)
+	return ps.placeRepo.GetAutomationRules(ctx, userID, placeID, placeTags)
+}
+
+// ==================== TEMPLATE OPERATIONS ====================
+
+func (ps *PlaceService) CreateTemplate(ctx context.Context, userID string, req models.CreatePlaceTemplateRequest) (*models.PlaceTemplate, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	if validationErrors := ps.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	if req.Template.Category == "" {
+		req.Template.Category = req.Category
+	}
+
+	template := &models.PlaceTemplate{
+		UserID:      userObjectID,
+		Name:        req.Name,
+		Description: req.Description,
+		Category:    req.Category,
+		Tags:        req.Tags,
+		Template:    req.Template,
+	}
+
+	err = ps.placeRepo.CreateTemplate(ctx, template)
+	if err != nil {
+		return nil, err
+	}
+
+	return template, nil
+}
+
+func (ps *PlaceService) GetUserTemplates(ctx context.Context, userID string) ([]models.PlaceTemplate, error) {
+	return ps.placeRepo.GetUserTemplates(ctx, userID)
+}
+
+// GetTemplate returns the author's own template, or the gallery view of an
+// approved public template
+func (ps *PlaceService) GetTemplate(ctx context.Context, userID, templateID string) (*models.PlaceTemplate, error) {
+	template, err := ps.placeRepo.GetTemplateByID(ctx, templateID)
+	if err != nil {
+		return nil, err
+	}
+
+	if template.UserID.Hex() == userID {
+		return template, nil
+	}
+
+	if !template.IsInGallery() {
+		return nil, errors.New("access denied")
+	}
+
+	view := template.PublicView()
+	return &view, nil
+}
+
+func (ps *PlaceService) UpdateTemplate(ctx context.Context, userID, templateID string, req models.UpdatePlaceTemplateRequest) (*models.PlaceTemplate, error) {
+	template, err := ps.getOwnTemplate(ctx, userID, templateID)
+	if err != nil {
+		return nil, err
+	}
+
+	if validationErrors := ps.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	updates := make(map[string]interface{})
+	if req.Name != nil {
+		updates["name"] = *req.Name
+	}
+	if req.Description != nil {
+		updates["description"] = *req.Description
+	}
+	if req.Category != nil {
+		updates["category"] = *req.Category
+	}
+	if req.Tags != nil {
+		updates["tags"] = req.Tags
+	}
+	if req.Template != nil {
+		updates["template"] = *req.Template
+	}
+
+	if len(updates) == 0 {
+		return template, nil
+	}
+
+	// Edits to a published template go back through moderation
+	if template.IsPublic {
+		data := template.Template
+		if req.Template != nil {
+			data = *req.Template
+		}
+		updates["galleryTemplate"] = sanitizeTemplateData(data)
+		updates["moderationStatus"] = models.TemplateModerationPending
+		updates["publishedAt"] = time.Now()
+	}
+
+	err = ps.placeRepo.UpdateTemplate(ctx, templateID, updates)
+	if err != nil {
+		return nil, err
+	}
+
+	return ps.placeRepo.GetTemplateByID(ctx, templateID)
+}
+
+func (ps *PlaceService) DeleteTemplate(ctx context.Context, userID, templateID string) error {
+	if _, err := ps.getOwnTemplate(ctx, userID, templateID); err != nil {
+		return err
+	}
+
+	return ps.placeRepo.DeleteTemplate(ctx, templateID)
+}
+
+// PublishTemplate submits the template to the gallery. A sanitized copy is
+// stored for the gallery and held for moderation.
+func (ps *PlaceService) PublishTemplate(ctx context.Context, userID, templateID string) (*models.PlaceTemplate, error) {
+	template, err := ps.getOwnTemplate(ctx, userID, templateID)
+	if err != nil {
+		return nil, err
+	}
+
+	if template.IsPublic {
+		return nil, errors.New("template already published")
+	}
+
+	err = ps.placeRepo.UpdateTemplate(ctx, templateID, map[string]interface{}{
+		"isPublic":         true,
+		"moderationStatus": models.TemplateModerationPending,
+		"publishedAt":      time.Now(),
+		"galleryTemplate":  sanitizeTemplateData(template.Template),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("Place template %s submitted to gallery by user %s", templateID, userID)
+	return ps.placeRepo.GetTemplateByID(ctx, templateID)
+}
+
+func (ps *PlaceService) UnpublishTemplate(ctx context.Context, userID, templateID string) error {
+	template, err := ps.getOwnTemplate(ctx, userID, templateID)
+	if err != nil {
+		return err
+	}
+
+	if !template.IsPublic {
+		return errors.New("template not published")
+	}
+
+	return ps.placeRepo.UnpublishTemplate(ctx, templateID)
+}
+
+func (ps *PlaceService) GetTemplateGallery(ctx context.Context, req models.GetTemplateGalleryRequest) (*models.PlaceTemplatesResponse, error) {
+	req.Page, req.PageSize = models.NormalizePage(req.Page, req.PageSize)
+
+	templates, total, err := ps.placeRepo.GetGalleryTemplates(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	views := make([]models.PlaceTemplate, 0, len(templates))
+	for _, template := range templates {
+		views = append(views, template.PublicView())
+	}
+
+	return &models.PlaceTemplatesResponse{
+		Templates: views,
+		Meta: models.PaginationMeta{
+			Page:       req.Page,
+			PageSize:   req.PageSize,
+			Total:      total,
+			TotalPages: int((total + int64(req.PageSize) - 1) / int64(req.PageSize)),
+		},
+	}, nil
+}
+
+// GetTemplatesForModeration lists gallery submissions for admins
+func (ps *PlaceService) GetTemplatesForModeration(ctx context.Context, status string, page, pageSize int) (*models.PlaceTemplatesResponse, error) {
+	if status == "" {
+		status = models.TemplateModerationPending
+	}
+	if status != models.TemplateModerationPending && status != models.TemplateModerationApproved && status != models.TemplateModerationRejected {
+		return nil, errors.New("invalid moderation status")
+	}
+	page, pageSize = models.NormalizePage(page, pageSize)
+
+	templates, total, err := ps.placeRepo.GetTemplatesForModeration(ctx, status, page, pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.PlaceTemplatesResponse{
+		Templates: templates,
+		Meta: models.PaginationMeta{
+			Page:       page,
+			PageSize:   pageSize,
+			Total:      total,
+			TotalPages: int((total + int64(pageSize) - 1) / int64(pageSize)),
+		},
+	}, nil
+}
+
+func (ps *PlaceService) ModerateTemplate(ctx context.Context, adminID, templateID string, req models.ModeratePlaceTemplateRequest) (*models.PlaceTemplate, error) {
+	adminObjectID, err := primitive.ObjectIDFromHex(adminID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	if validationErrors := ps.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	template, err := ps.placeRepo.GetTemplateByID(ctx, templateID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !template.IsPublic {
+		return nil, errors.New("template not published")
+	}
+
+	err = ps.placeRepo.UpdateTemplate(ctx, templateID, map[string]interface{}{
+		"moderationStatus": req.Status,
+		"moderationNote":   req.Note,
+		"reviewedBy":       adminObjectID,
+		"reviewedAt":       time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("Place template %s %s by admin %s", templateID, req.Status, adminID)
+	return ps.placeRepo.GetTemplateByID(ctx, templateID)
+}
+
+// UsePlaceTemplate creates a place from the user's own template or from an
+// approved gallery template, crediting the template's author
+func (ps *PlaceService) UsePlaceTemplate(ctx context.Context, userID, templateID string, req models.UsePlaceTemplateRequest) (*models.Place, error) {
+	if validationErrors := ps.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	template, err := ps.placeRepo.GetTemplateByID(ctx, templateID)
+	if err != nil {
+		return nil, err
+	}
+
+	isAuthor := template.UserID.Hex() == userID
+	data := template.Template
+	if !isAuthor {
+		if !template.IsInGallery() {
+			return nil, errors.New("access denied")
+		}
+		data = *template.GalleryTemplate
+	}
+
+	radius := req.Radius
+	if radius == 0 {
+		radius = data.Radius
+	}
+
+	place, err := ps.CreatePlace(ctx, userID, models.CreatePlaceRequest{
+		Name:             req.Name,
+		Address:          req.Address,
+		Latitude:         req.Latitude,
+		Longitude:        req.Longitude,
+		Radius:           radius,
+		Category:         data.Category,
+		Color:            data.Color,
+		Icon:             data.Icon,
+		Notifications:    data.Notifications,
+		Hours:            data.Hours,
+		Geofence:         data.Geofence,
+		Force:            req.Force,
+		TemplateID:       template.ID,
+		TemplateAuthorID: template.UserID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Only uses by other people count towards the gallery ranking
+	if !isAuthor {
+		if err := ps.placeRepo.IncrementTemplateUsage(ctx, template.ID); err != nil {
+			logrus.Warnf("Failed to record usage of place template %s: %v", templateID, err)
+		}
+	}
+
+	return place, nil
+}
+
+func (ps *PlaceService) getOwnTemplate(ctx context.Context, userID, templateID string) (*models.PlaceTemplate, error) {
+	template, err := ps.placeRepo.GetTemplateByID(ctx, templateID)
+	if err != nil {
+		return nil, err
+	}
+
+	if template.UserID.Hex() != userID {
+		return nil, errors.New("access denied")
+	}
+
+	return template, nil
+}
+
+// templateSensitiveKeys are automation config keys that can carry the
+// author's coordinates or media, removed before a template is published
+var templateSensitiveKeys = map[string]bool{
+	"latitude":    true,
+	"longitude":   true,
+	"lat":         true,
+	"lon":         true,
+	"lng":         true,
+	"location":    true,
+	"coordinates": true,
+	"address":     true,
+	"media":       true,
+	"mediaUrl":    true,
+	"imageUrl":    true,
+	"attachments": true,
+}
+
+// sanitizeTemplateData strips personal coordinates, place references, dated
+// overrides and media from template data before it goes to the gallery
+func sanitizeTemplateData(data models.PlaceTemplateData) models.PlaceTemplateData {
+	sanitized := data
+	sanitized.Hours.Overrides = nil
+
+	// Custom icons are uploaded media, built-in icons are plain names
+	if strings.Contains(sanitized.Icon, "://") || strings.HasPrefix(sanitized.Icon, "/") {
+		sanitized.Icon = ""
+	}
+
+	sanitized.AutomationRules = make([]models.AutomationRule, 0, len(data.AutomationRules))
+	for _, rule := range data.AutomationRules {
+		clean := models.AutomationRule{
+			Name:        rule.Name,
+			Type:        rule.Type,
+			IsActive:    rule.IsActive,
+			Priority:    rule.Priority,
+			StopOnMatch: rule.StopOnMatch,
+		}
+
+		for _, condition := range rule.Conditions {
+			if templateSensitiveKeys[condition.Field] {
+				continue
+			}
+			if values, ok := condition.Value.(map[string]interface{}); ok {
+				condition.Value = stripSensitiveKeys(values)
+			}
+			condition.PlaceID = nil
+			clean.Conditions = append(clean.Conditions, condition)
+		}
+
+		for _, action := range rule.Actions {
+			action.Config = stripSensitiveKeys(action.Config)
+			action.PlaceID = nil
+			clean.Actions = append(clean.Actions, action)
+		}
+
+		sanitized.AutomationRules = append(sanitized.AutomationRules, clean)
+	}
+
+	return sanitized
+}
+
+func stripSensitiveKeys(values map[string]interface{}) map[string]interface{} {
+	if values == nil {
+		return nil
+	}
+
+	clean := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		if templateSensitiveKeys[key] {
+			continue
+		}
+		if nested, ok := value.(map[string]interface{}); ok {
+			value = stripSensitiveKeys(nested)
+		}
+		clean[key] = value
+	}
+	return clean
+}
+
+// ==================== NOTIFICATION OPERATIONS ====================
+
+func (ps *PlaceService) GetPlaceNotifications(ctx context.Context, userID, placeID string) (*models.PlaceNotifications, error) {
+	place, err := ps.GetPlace(ctx, userID, placeID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &place.Notifications, nil
+}
+
+func (ps *PlaceService) UpdatePlaceNotifications(ctx context.Context, userID, placeID string, req models.PlaceNotifications) (*models.PlaceNotifications, error) {
+	place, err := ps.placeRepo.GetByID(ctx, placeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if place.UserID.Hex() != userID {
+		return nil, errors.New("access denied")
+	}
+
+	if err := ps.validatePlaceNotifications(req); err != nil {
+		return nil, err
+	}
+	if err := ps.validateNotifyMembers(ctx, placeCircleID(place), req.NotifyMembers); err != nil {
+		return nil, err
+	}
+
+	err = ps.placeRepo.Update(ctx, placeID, map[string]interface{}{
+		"notifications":     req,
+		"inheritedSettings": withoutSettings(place.InheritedSettings, placeNotificationSettings...),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if updated, err := ps.placeRepo.GetByID(ctx, placeID); err == nil {
+		ps.recordPlaceVersion(ctx, userID, place, updated, models.PlaceVersionNotifications, nil)
+	}
+
+	return &req, nil
+}
+
+// TestPlaceNotification renders the place's notification, or an unsaved
+// template, with sample data so it can be previewed
+func (ps *PlaceService) TestPlaceNotification(ctx context.Context, userID, placeID string, req models.TestPlaceNotificationRequest) (*models.PlaceNotificationPreview, error) {
+	if validationErrors := ps.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	place, err := ps.GetPlace(ctx, userID, placeID)
+	if err != nil {
+		return nil, err
+	}
+
+	eventType := req.EventType
+	if eventType == "" {
+		eventType = "arrival"
+	}
+
+	if req.Template != "" {
+		if err := utils.ValidateNotificationTemplate(req.Template, models.PlaceNotificationVariables); err != nil {
+			return nil, err
+		}
+		if eventType == "departure" {
+			place.Notifications.DepartureTemplate = req.Template
+		} else {
+			place.Notifications.ArrivalTemplate = req.Template
+		}
+	}
+
+	title, body := RenderPlaceNotification(place, eventType, "Alex Smith", time.Now())
+
+	return &models.PlaceNotificationPreview{
+		EventType: eventType,
+		Template:  place.Notifications.TemplateFor(eventType),
+		Title:     title,
+		Body:      body,
+	}, nil
+}
+
+// RenderPlaceNotification returns the title and body of an arrival or
+// departure notification for a place
+func RenderPlaceNotification(place *models.Place, eventType, memberName string, at time.Time) (string, string) {
+	title := "📍 Arrival Notification"
+	if eventType == "departure" {
+		title = "📍 Departure Notification"
+	}
+
+	// Times are shown in the place's local time when it has one
+	if place.Hours.Timezone != "" {
+		if location, err := time.LoadLocation(place.Hours.Timezone); err == nil {
+			at = at.In(location)
+		}
+	}
+
+	body := utils.RenderNotificationTemplate(place.Notifications.TemplateFor(eventType), map[string]string{
+		"member":  memberName,
+		"place":   place.Name,
+		"address": place.Address,
+		"time":    at.Format("15:04"),
+	})
+
+	return title, body
+}
+
+func (ps *PlaceService) validatePlaceNotifications(notifications models.PlaceNotifications) error {
+	if validationErrors := ps.validator.ValidateStruct(notifications); len(validationErrors) > 0 {
+		return utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	for _, template := range []string{notifications.ArrivalTemplate, notifications.DepartureTemplate} {
+		if err := utils.ValidateNotificationTemplate(template, models.PlaceNotificationVariables); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ==================== EXPORT OPERATIONS ====================
+
+const placeExportBatchSize = 100
+
+// placeExportColumns heads CSV place exports; imports read columns by name
+var placeExportColumns = []string{"id", "name", "address", "latitude", "longitude", "radius", "category"}
+
+func (ps *PlaceService) ExportPlaces(ctx context.Context, userID string, req models.ExportPlacesRequest) (*models.MessageExport, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	if req.Format == "" {
+		req.Format = "json"
+	}
+	if req.Format != "json" && req.Format != "csv" {
+		return nil, errors.New("invalid export format")
+	}
+
+	export := models.MessageExport{
+		UserID:    userObjectID,
+		Type:      models.ExportTypePlaces,
+		Format:    req.Format,
+		ExpiresAt: time.Now().Add(7 * 24 * time.Hour), // 7 days
+	}
+
+	err = ps.exportService.StartExport(ctx, &export, func(jobCtx context.Context, w io.Writer, progress func(int)) (int, error) {
+		return ps.writePlaceExport(jobCtx, w, userID, req.Format, progress)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &export, nil
+}
+
+func (ps *PlaceService) DownloadPlaceExportManifest(ctx context.Context, userID, exportID string) (*models.ExportDownload, error) {
+	return ps.exportService.DownloadExportManifest(ctx, userID, exportID)
+}
+
+func (ps *PlaceService) DownloadPlaceExport(ctx context.Context, userID, exportID string) (*models.ExportDownload, error) {
+	export, data, err := ps.exportService.ReadExport(ctx, userID, exportID)
+	if err != nil {
+		return nil, err
+	}
+
+	contentType := "application/json"
+	switch export.Format {
+	case "csv":
+		contentType = "text/csv"
+	case "pdf":
+		contentType = "application/pdf"
+	}
+
+	name := "places_export"
+	if export.Type == models.ExportTypePlaceAnalytics {
+		name = "place_analytics"
+	}
+
+	return &models.ExportDownload{
+		Filename:    fmt.Sprintf("%s_%s.%s", name, exportID, export.Format),
+		ContentType: contentType,
+		Data:        data,
+	}, nil
+}
+
+// Limits on place imports
+const (
+	maxPlaceImportSize    = 10 << 20
+	maxPlaceImportRecords = 1000
+)
+
+// ImportPlaces creates the places of a place export as the user's own.
+// Exports from older releases are upgraded first and ones this server can't
+// read are rejected. Places similar to ones the user already has are
+// skipped, so importing a file twice doesn't duplicate them.
+func (ps *PlaceService) ImportPlaces(ctx context.Context, userID string, file io.Reader, contentType, filename string) (*models.ImportResult, error) {
+	format := importFormat(contentType, filename)
+	if format == "" {
+		return nil, errors.New("invalid file format")
+	}
+
+	data, err := io.ReadAll(io.LimitReader(file, maxPlaceImportSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxPlaceImportSize {
+		return nil, errors.New("file too large")
+	}
+
+	export, err := decodeExport(data, format, models.ExportTypePlaces)
+	if err != nil {
+		return nil, err
+	}
+
+	requests, err := placeImportRequests(export)
+	if err != nil {
+		return nil, err
+	}
+	if len(requests) > maxPlaceImportRecords {
+		return nil, utils.NewValidationFailedError(fmt.Sprintf("exports of more than %d places can't be imported", maxPlaceImportRecords))
+	}
+
+	result := &models.ImportResult{
+		SchemaVersion: export.SourceVersion,
+		Migrated:      export.Migrated,
+		Total:         len(requests),
+	}
+
+	for i, req := range requests {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		if _, err := ps.CreatePlace(ctx, userID, req); err != nil {
+			reason := utils.ValidationFailureReason(err)
+			if reason == "" {
+				reason = err.Error()
+			}
+			result.Failed++
+			result.Errors = append(result.Errors, fmt.Sprintf("record %d (%s): %s", i+1, req.Name, reason))
+			continue
+		}
+		result.Imported++
+	}
+
+	return result, nil
+}
+
+// placeImportRequests turns the records of a place export into create
+// requests. Circle links aren't imported, the circles may not exist here.
+func placeImportRequests(export *decodedExport) ([]models.CreatePlaceRequest, error) {
+	if export.Rows == nil {
+		var places []models.Place
+		if err := json.Unmarshal(export.Records, &places); err != nil {
+			return nil, utils.NewValidationFailedError("export records are not places")
+		}
+
+		requests := make([]models.CreatePlaceRequest, 0, len(places))
+		for _, place := range places {
+			requests = append(requests, models.CreatePlaceRequest{
+				Name:          place.Name,
+				Description:   place.Description,
+				Address:       place.Address,
+				Latitude:      place.Latitude,
+				Longitude:     place.Longitude,
+				Radius:        place.Radius,
+				Category:      place.Category,
+				Color:         place.Color,
+				Icon:          place.Icon,
+				Tags:          place.Tags,
+				Priority:      place.Priority,
+				Notifications: place.Notifications,
+				Hours:         place.Hours,
+				Geofence:      place.Geofence,
+				Metadata:      place.Metadata,
+			})
+		}
+		return requests, nil
+	}
+
+	columns := make(map[string]int)
+	for i, name := range export.Rows[0] {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, required := range []string{"name", "latitude", "longitude"} {
+		if _, exists := columns[required]; !exists {
+			return nil, utils.NewValidationFailedError(fmt.Sprintf("export has no %s column", required))
+		}
+	}
+
+	value := func(row []string, column string) string {
+		if i, exists := columns[column]; exists && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	requests := make([]models.CreatePlaceRequest, 0, len(export.Rows)-1)
+	for _, row := range export.Rows[1:] {
+		// Unparseable numbers are left at zero and fail validation on create
+		latitude, _ := strconv.ParseFloat(value(row, "latitude"), 64)
+		longitude, _ := strconv.ParseFloat(value(row, "longitude"), 64)
+		radius, _ := strconv.Atoi(value(row, "radius"))
+
+		requests = append(requests, models.CreatePlaceRequest{
+			Name:      value(row, "name"),
+			Address:   value(row, "address"),
+			Latitude:  latitude,
+			Longitude: longitude,
+			Radius:    radius,
+			Category:  value(row, "category"),
+		})
+	}
+	return requests, nil
+}
+
+func (ps *PlaceService) writePlaceExport(ctx context.Context, w io.Writer, userID, format string, progress func(int)) (int, error) {
+	var csvWriter *csv.Writer
+	if format == "csv" {
+		if err := writeExportStamp(ctx, w, models.ExportTypePlaces); err != nil {
+			return 0, err
+		}
+		csvWriter = csv.NewWriter(w)
+		if err := csvWriter.Write(placeExportColumns); err != nil {
+			return 0, err
+		}
+	} else if err := writeJSONExportStart(ctx, w, models.ExportTypePlaces); err != nil {
+		return 0, err
+	}
+
+	written := 0
+	for page := 1; ; page++ {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+
+		places, total, err := ps.placeRepo.GetUserPlaces(ctx, userID, models.GetPlacesRequest{
+			Page:      page,
+			PageSize:  placeExportBatchSize,
+			SortBy:    "createdAt",
+			SortOrder: "asc",
+		})
+		if err != nil {
+			return written, err
+		}
+		if len(places) == 0 {
+			break
+		}
+
+		for _, place := range places {
+			if csvWriter != nil {
+				err = csvWriter.Write([]string{
+					place.ID.Hex(),
+					place.Name,
+					place.Address,
+					strconv.FormatFloat(place.Latitude, 'f', 6, 64),
+					strconv.FormatFloat(place.Longitude, 'f', 6, 64),
+					strconv.Itoa(place.Radius),
+					place.Category,
+				})
+			} else {
+				var data []byte
+				data, err = json.Marshal(place)
+				if err == nil && written > 0 {
+					_, err = io.WriteString(w, ",")
+				}
+				if err == nil {
+					_, err = w.Write(data)
+				}
+			}
+			if err != nil {
+				return written, err
+			}
+			written++
+		}
+
+		if csvWriter != nil {
+			csvWriter.Flush()
+			if err := csvWriter.Error(); err != nil {
+				return written, err
+			}
+		}
+
+		if total > 0 {
+			progress(int(int64(written) * 99 / total))
+		}
+		if len(places) < placeExportBatchSize {
+			break
+		}
+	}
+
+	if csvWriter == nil {
+		if err := writeJSONExportEnd(w); err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
 }
 
 // ==================== HELPER METHODS ====================
@@ -664,6 +1592,13 @@ func (ps *PlaceService) GetNearbyPlaces(ctx context.Context, userID string, lat,
 		responses = responses[:limit]
 	}
 
+	labelled := make([]*models.Place, len(responses))
+	for i := range responses {
+		labelled[i] = &responses[i].Place
+	}
+	ps.applyPlaceAliases(ctx, userID, labelled...)
+	ps.applyPlaceFavorites(ctx, userID, labelled...)
+
 	return responses, nil
 }
 
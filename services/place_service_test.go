@@ -0,0 +1,223 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"ftrack/models"
+	"ftrack/testharness"
+)
+
+func newTestPlaceService(env *testharness.Env) *PlaceService {
+	ps := NewPlaceService(env.Repos.Place, env.Repos.Circle, nil)
+	ps.ConfigureCheckinNotifications(env.Notifier)
+	return ps
+}
+
+func TestPlaceServiceCRUD(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ps := newTestPlaceService(env)
+	ctx := context.Background()
+
+	alice, bob := env.Factory.User(), env.Factory.User()
+	ownerID := alice.ID.Hex()
+
+	place, err := ps.CreatePlace(ctx, ownerID, models.CreatePlaceRequest{
+		Name:      "Home",
+		Latitude:  40.7128,
+		Longitude: -74.0060,
+		Radius:    150,
+		Category:  "home",
+	})
+	if err != nil {
+		t.Fatalf("CreatePlace: %v", err)
+	}
+
+	got, err := ps.GetPlace(ctx, ownerID, place.ID.Hex())
+	if err != nil {
+		t.Fatalf("GetPlace: %v", err)
+	}
+	if got.Name != "Home" || got.Radius != 150 || !got.IsActive {
+		t.Errorf("stored place = %+v", got)
+	}
+	if _, err := ps.GetPlace(ctx, bob.ID.Hex(), place.ID.Hex()); err == nil || err.Error() != "access denied" {
+		t.Errorf("GetPlace by a stranger error = %v, want access denied", err)
+	}
+
+	name, radius := "Home sweet home", 200
+	updated, err := ps.UpdatePlace(ctx, ownerID, place.ID.Hex(), models.UpdatePlaceRequest{Name: &name, Radius: &radius})
+	if err != nil {
+		t.Fatalf("UpdatePlace: %v", err)
+	}
+	if updated.Name != name || updated.Radius != radius {
+		t.Errorf("updated place = %+v", updated)
+	}
+
+	tooLarge := DefaultPlaceRadiusMax + 1
+	if _, err := ps.UpdatePlace(ctx, ownerID, place.ID.Hex(), models.UpdatePlaceRequest{Radius: &tooLarge}); err == nil {
+		t.Error("radius past the maximum accepted")
+	}
+	if _, err := ps.UpdatePlace(ctx, bob.ID.Hex(), place.ID.Hex(), models.UpdatePlaceRequest{Name: &name}); err == nil || err.Error() != "access denied" {
+		t.Errorf("UpdatePlace by a stranger error = %v, want access denied", err)
+	}
+
+	if err := ps.DeletePlace(ctx, bob.ID.Hex(), place.ID.Hex()); err == nil || err.Error() != "access denied" {
+		t.Errorf("DeletePlace by a stranger error = %v, want access denied", err)
+	}
+	if err := ps.DeletePlace(ctx, ownerID, place.ID.Hex()); err != nil {
+		t.Fatalf("DeletePlace: %v", err)
+	}
+	if _, err := ps.GetPlace(ctx, ownerID, place.ID.Hex()); err == nil || err.Error() != "place not found" {
+		t.Errorf("GetPlace after delete error = %v, want place not found", err)
+	}
+}
+
+func TestPlaceServiceGeofenceSettings(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ps := newTestPlaceService(env)
+	ctx := context.Background()
+
+	circleDwell, circleCooldown := 120, 15
+	alice := env.Factory.User()
+	circle := env.Factory.Circle(alice, nil, func(circle *models.Circle) {
+		circle.GeofenceDefaults = &models.GeofenceDefaults{DwellTime: &circleDwell, Cooldown: &circleCooldown}
+	})
+	ownerID := alice.ID.Hex()
+
+	// Settings left unset follow the circle, or the system without a
+	// circle default
+	place, err := ps.CreatePlace(ctx, ownerID, models.CreatePlaceRequest{
+		Name:      "School",
+		Latitude:  40.7306,
+		Longitude: -73.9866,
+		Radius:    100,
+		Category:  "school",
+		CircleID:  circle.ID.Hex(),
+		Geofence:  models.GeofenceSettings{ExitDelay: 90},
+	})
+	if err != nil {
+		t.Fatalf("CreatePlace: %v", err)
+	}
+
+	settings, err := ps.GetPlaceGeofenceSettings(ctx, ownerID, place.ID.Hex())
+	if err != nil {
+		t.Fatalf("GetPlaceGeofenceSettings: %v", err)
+	}
+	expectSetting(t, settings, models.PlaceSettingDwellTime, settings.Geofence.DwellTime, circleDwell, models.SettingSourceCircle)
+	expectSetting(t, settings, models.PlaceSettingCooldown, settings.Notifications.Cooldown, circleCooldown, models.SettingSourceCircle)
+	expectSetting(t, settings, models.PlaceSettingExitDelay, settings.Geofence.ExitDelay, 90, models.SettingSourcePlace)
+	expectSetting(t, settings, models.PlaceSettingLongStayDuration, settings.Notifications.LongStayDuration, defaultPlaceLongStayDuration, models.SettingSourceSystem)
+
+	// Overriding takes the setting from the place, inheriting hands it back
+	dwell := 10
+	settings, err = ps.UpdatePlaceGeofenceSettings(ctx, ownerID, place.ID.Hex(), models.UpdatePlaceGeofenceSettingsRequest{
+		DwellTime: &dwell,
+		Inherit:   []string{models.PlaceSettingExitDelay},
+	})
+	if err != nil {
+		t.Fatalf("UpdatePlaceGeofenceSettings: %v", err)
+	}
+	expectSetting(t, settings, models.PlaceSettingDwellTime, settings.Geofence.DwellTime, dwell, models.SettingSourcePlace)
+	expectSetting(t, settings, models.PlaceSettingExitDelay, settings.Geofence.ExitDelay, defaultPlaceExitDelay, models.SettingSourceSystem)
+
+	settings, err = ps.UpdatePlaceGeofenceSettings(ctx, ownerID, place.ID.Hex(), models.UpdatePlaceGeofenceSettingsRequest{
+		Inherit: []string{models.PlaceSettingDwellTime},
+	})
+	if err != nil {
+		t.Fatalf("UpdatePlaceGeofenceSettings: %v", err)
+	}
+	expectSetting(t, settings, models.PlaceSettingDwellTime, settings.Geofence.DwellTime, circleDwell, models.SettingSourceCircle)
+
+	if _, err := ps.UpdatePlaceGeofenceSettings(ctx, ownerID, place.ID.Hex(), models.UpdatePlaceGeofenceSettingsRequest{
+		DwellTime: &dwell,
+		Inherit:   []string{models.PlaceSettingDwellTime},
+	}); err == nil {
+		t.Error("setting and inheriting the same setting accepted")
+	}
+
+	stranger := env.Factory.User()
+	if _, err := ps.UpdatePlaceGeofenceSettings(ctx, stranger.ID.Hex(), place.ID.Hex(), models.UpdatePlaceGeofenceSettingsRequest{DwellTime: &dwell}); err == nil || err.Error() != "access denied" {
+		t.Errorf("UpdatePlaceGeofenceSettings by a stranger error = %v, want access denied", err)
+	}
+}
+
+func expectSetting(t *testing.T, settings *models.PlaceGeofenceSettings, key string, got, want int, source string) {
+	t.Helper()
+	if got != want {
+		t.Errorf("%s = %d, want %d", key, got, want)
+	}
+	if settings.Sources[key] != source {
+		t.Errorf("%s comes from %q, want %q", key, settings.Sources[key], source)
+	}
+}
+
+func TestPlaceServiceReviewGate(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ps := newTestPlaceService(env)
+	ctx := context.Background()
+
+	alice, bob, carol, stranger := env.Factory.User(), env.Factory.User(), env.Factory.User(), env.Factory.User()
+	circle := env.Factory.Circle(alice, []*models.User{bob, carol})
+	place := env.Factory.Place(alice, testharness.InCircle(circle), func(place *models.Place) { place.IsShared = true })
+	placeID := place.ID.Hex()
+
+	visit := func(user *models.User) {
+		t.Helper()
+		if err := env.Repos.Place.CreateVisit(ctx, &models.PlaceVisit{PlaceID: place.ID, UserID: user.ID, ArrivalTime: time.Now()}); err != nil {
+			t.Fatalf("CreateVisit: %v", err)
+		}
+	}
+
+	if _, err := ps.CreateReview(ctx, stranger.ID.Hex(), placeID, 5, "Nice", "", true); err == nil || err.Error() != "access denied" {
+		t.Errorf("review by a stranger error = %v, want access denied", err)
+	}
+	if _, err := ps.CreateReview(ctx, bob.ID.Hex(), placeID, 5, "Nice", "Lovely spot", true); err == nil || err.Error() != "must visit before reviewing" {
+		t.Errorf("review before visiting error = %v, want must visit before reviewing", err)
+	}
+
+	visit(bob)
+	if _, err := ps.CreateReview(ctx, bob.ID.Hex(), placeID, 5, "Sh1t place", "", true); err == nil || err.Error() != "review contains inappropriate language" {
+		t.Errorf("profane review error = %v, want review contains inappropriate language", err)
+	}
+	review, err := ps.CreateReview(ctx, bob.ID.Hex(), placeID, 5, "Nice", "Lovely spot", true)
+	if err != nil {
+		t.Fatalf("CreateReview after visiting: %v", err)
+	}
+	if review.ModerationStatus != models.ReviewModerationVisible {
+		t.Errorf("moderation status = %q, want %q", review.ModerationStatus, models.ReviewModerationVisible)
+	}
+	if _, err := ps.CreateReview(ctx, bob.ID.Hex(), placeID, 1, "Changed my mind", "", true); err == nil || err.Error() != "already reviewed" {
+		t.Errorf("second review error = %v, want already reviewed", err)
+	}
+
+	// Racing reviews get past the check together; the unique index keeps one
+	visit(carol)
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		succeeded int
+	)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := ps.CreateReview(ctx, carol.ID.Hex(), placeID, 4, "Good", "", true)
+			if err == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			} else if err.Error() != "already reviewed" {
+				t.Errorf("racing review error = %v, want already reviewed", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if succeeded != 1 {
+		t.Errorf("%d racing reviews stored, want 1", succeeded)
+	}
+}
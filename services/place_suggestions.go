@@ -0,0 +1,644 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ftrack/models"
+	"ftrack/repositories"
+	"ftrack/utils"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PlaceSuggestionConfig sets how places are suggested from location
+// history. A stay is time spent within StayRadius for at least MinStay.
+// Stays are clustered DBSCAN-style: a stay with at least MinVisits stays
+// within ClusterRadius of it, itself included, starts or extends a
+// cluster. A cluster is suggested when its stays fall on at least
+// MinVisits different days.
+type PlaceSuggestionConfig struct {
+	Enabled       bool
+	StayRadius    float64 // meters
+	MinStay       time.Duration
+	ClusterRadius float64 // meters
+	MinVisits     int
+
+	// History analyzed, at most the location retention. Open suggestions
+	// are deleted once their last visit is older than the retention.
+	Lookback  time.Duration
+	Retention time.Duration
+}
+
+var DefaultPlaceSuggestionConfig = PlaceSuggestionConfig{
+	Enabled:       true,
+	StayRadius:    100,
+	MinStay:       15 * time.Minute,
+	ClusterRadius: 150,
+	MinVisits:     3,
+	Lookback:      30 * 24 * time.Hour,
+	Retention:     30 * 24 * time.Hour,
+}
+
+var (
+	placeSuggestionConfig = DefaultPlaceSuggestionConfig
+	placeSuggestionMutex  sync.RWMutex
+)
+
+// Locations loaded per query, and at most per user and analysis
+const (
+	suggestionLocationPageSize = 5000
+	maxSuggestionLocations     = 200000
+)
+
+// Users analyzed per page of recently located users
+const suggestionUserPageSize = 200
+
+// ConfigurePlaceSuggestions sets how places are suggested to users
+func ConfigurePlaceSuggestions(cfg PlaceSuggestionConfig) {
+	if cfg.StayRadius <= 0 {
+		cfg.StayRadius = DefaultPlaceSuggestionConfig.StayRadius
+	}
+	if cfg.MinStay <= 0 {
+		cfg.MinStay = DefaultPlaceSuggestionConfig.MinStay
+	}
+	if cfg.ClusterRadius <= 0 {
+		cfg.ClusterRadius = DefaultPlaceSuggestionConfig.ClusterRadius
+	}
+	if cfg.MinVisits < 2 {
+		cfg.MinVisits = DefaultPlaceSuggestionConfig.MinVisits
+	}
+	if cfg.Retention <= 0 {
+		cfg.Retention = DefaultPlaceSuggestionConfig.Retention
+	}
+	if cfg.Lookback <= 0 || cfg.Lookback > cfg.Retention {
+		cfg.Lookback = cfg.Retention
+	}
+
+	placeSuggestionMutex.Lock()
+	placeSuggestionConfig = cfg
+	placeSuggestionMutex.Unlock()
+
+	logrus.Infof("Place suggestions enabled=%t stay=%.0fm/%s cluster=%.0fm min visits=%d lookback=%s",
+		cfg.Enabled, cfg.StayRadius, cfg.MinStay, cfg.ClusterRadius, cfg.MinVisits, cfg.Lookback)
+}
+
+// GetPlaceSuggestionConfig returns the current place suggestion settings
+func GetPlaceSuggestionConfig() PlaceSuggestionConfig {
+	placeSuggestionMutex.RLock()
+	defer placeSuggestionMutex.RUnlock()
+	return placeSuggestionConfig
+}
+
+// ConfigureSuggestionHistory lets places be suggested from the users'
+// location history. Without it there are no suggestions.
+func (ps *PlaceService) ConfigureSuggestionHistory(locationRepo *repositories.LocationRepository) {
+	ps.locationRepo = locationRepo
+}
+
+// suggestionPoint is the part of a location the analysis needs
+type suggestionPoint struct {
+	latitude   float64
+	longitude  float64
+	recordedAt time.Time
+	address    string
+	city       string
+}
+
+// stayPoint is a stretch of time the user spent in one spot
+type stayPoint struct {
+	latitude  float64
+	longitude float64
+	arrival   time.Time
+	departure time.Time
+	address   string
+	city      string
+}
+
+func (s stayPoint) duration() time.Duration {
+	return s.departure.Sub(s.arrival)
+}
+
+// GeneratePlaceSuggestions analyzes the history of the users who reported
+// a location since the given time, and drops open suggestions whose
+// visits have left location history. It returns how many places were
+// newly suggested.
+func (ps *PlaceService) GeneratePlaceSuggestions(ctx context.Context, since time.Time) (int, error) {
+	cfg := GetPlaceSuggestionConfig()
+	if !cfg.Enabled || ps.locationRepo == nil {
+		return 0, nil
+	}
+
+	expired, err := ps.placeRepo.DeleteExpiredPlaceSuggestions(ctx, time.Now().Add(-cfg.Retention))
+	if err != nil {
+		logrus.Warnf("Failed to delete expired place suggestions: %v", err)
+	} else if expired > 0 {
+		logrus.Infof("Deleted %d expired place suggestions", expired)
+	}
+
+	suggested := 0
+	var afterID primitive.ObjectID
+	for {
+		userIDs, err := ps.locationRepo.GetUsersLocatedSince(ctx, since, afterID, suggestionUserPageSize)
+		if err != nil {
+			return suggested, err
+		}
+
+		for _, userID := range userIDs {
+			if ctx.Err() != nil {
+				return suggested, ctx.Err()
+			}
+
+			created, err := ps.suggestUserPlaces(ctx, userID, cfg)
+			if err != nil {
+				logrus.Errorf("Failed to suggest places for user %s: %v", userID.Hex(), err)
+				continue
+			}
+			suggested += created
+		}
+
+		if len(userIDs) < suggestionUserPageSize {
+			return suggested, nil
+		}
+		afterID = userIDs[len(userIDs)-1]
+	}
+}
+
+// suggestUserPlaces clusters the user's stays and suggests the clusters
+// none of their places cover. A cluster near an open suggestion refreshes
+// it; one near a dismissed or accepted suggestion is left alone, so those
+// never come back.
+func (ps *PlaceService) suggestUserPlaces(ctx context.Context, userID primitive.ObjectID, cfg PlaceSuggestionConfig) (int, error) {
+	// Users who turned location off aren't analyzed
+	settings, err := ps.locationRepo.GetLocationSettings(ctx, userID.Hex())
+	if err == nil && !settings.Enabled {
+		return 0, nil
+	}
+
+	to := time.Now()
+	from := to.Add(-cfg.Lookback)
+	points, err := ps.suggestionPoints(ctx, userID.Hex(), from, to, cfg)
+	if err != nil {
+		return 0, err
+	}
+
+	stays := detectStayPoints(points, cfg.StayRadius, cfg.MinStay)
+	clusters := clusterStayPoints(stays, cfg.ClusterRadius, cfg.MinVisits)
+	if len(clusters) == 0 {
+		return 0, nil
+	}
+
+	places, err := ps.suggestionPlaces(ctx, userID.Hex())
+	if err != nil {
+		return 0, err
+	}
+	existing, err := ps.placeRepo.GetUserPlaceSuggestions(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	weeks := math.Max(1, cfg.Lookback.Hours()/(7*24))
+	created := 0
+	for _, cluster := range clusters {
+		candidate := newPlaceSuggestion(userID, cluster, cfg.StayRadius, weeks)
+		if candidate.VisitDays < cfg.MinVisits || placeCoversSuggestion(places, candidate, cfg.StayRadius) {
+			continue
+		}
+
+		match := nearestPlaceSuggestion(existing, candidate, cfg.ClusterRadius)
+		if match == nil {
+			if err := ps.placeRepo.CreatePlaceSuggestion(ctx, candidate); err != nil {
+				return created, err
+			}
+			existing = append(existing, *candidate)
+			created++
+			continue
+		}
+		if match.Status != models.PlaceSuggestionStatusSuggested {
+			continue
+		}
+
+		err := ps.placeRepo.UpdatePlaceSuggestion(ctx, match.ID, bson.M{
+			"latitude":      candidate.Latitude,
+			"longitude":     candidate.Longitude,
+			"radius":        candidate.Radius,
+			"proposedName":  candidate.ProposedName,
+			"address":       candidate.Address,
+			"visitCount":    candidate.VisitCount,
+			"visitDays":     candidate.VisitDays,
+			"visitsPerWeek": candidate.VisitsPerWeek,
+			"totalDuration": candidate.TotalDuration,
+			"firstVisit":    candidate.FirstVisit,
+			"lastVisit":     candidate.LastVisit,
+		})
+		if err != nil && err.Error() != "place suggestion not found" {
+			return created, err
+		}
+	}
+
+	return created, nil
+}
+
+// suggestionPoints loads the user's locations in the window, oldest first.
+// Mock locations and fixes less accurate than a stay are left out.
+func (ps *PlaceService) suggestionPoints(ctx context.Context, userID string, from, to time.Time, cfg PlaceSuggestionConfig) ([]suggestionPoint, error) {
+	var points []suggestionPoint
+	for len(points) < maxSuggestionLocations {
+		locations, err := ps.locationRepo.GetLocationsBetween(ctx, userID, from, to, suggestionLocationPageSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, location := range locations {
+			if location.IsMock || location.Accuracy > cfg.StayRadius {
+				continue
+			}
+			points = append(points, suggestionPoint{
+				latitude:   location.Latitude,
+				longitude:  location.Longitude,
+				recordedAt: location.RecordedAt(),
+				address:    location.Address,
+				city:       location.City,
+			})
+		}
+
+		if len(locations) < suggestionLocationPageSize {
+			break
+		}
+		from = locations[len(locations)-1].CreatedAt
+	}
+
+	// Locations uploaded late are stored in the order they arrived
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].recordedAt.Before(points[j].recordedAt)
+	})
+	return points, nil
+}
+
+// suggestionPlaces returns the places the user can see, which suggestions
+// must stay clear of
+func (ps *PlaceService) suggestionPlaces(ctx context.Context, userID string) ([]models.Place, error) {
+	circles, err := ps.circleRepo.GetUserCircles(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	circleIDs := make([]primitive.ObjectID, 0, len(circles))
+	for _, circle := range circles {
+		circleIDs = append(circleIDs, circle.ID)
+	}
+
+	return ps.placeRepo.GetAccessiblePlaceNames(ctx, userID, circleIDs)
+}
+
+// detectStayPoints finds where the user stayed within stayRadius of a
+// location for at least minStay. The points must be oldest first.
+func detectStayPoints(points []suggestionPoint, stayRadius float64, minStay time.Duration) []stayPoint {
+	var stays []stayPoint
+	for i := 0; i < len(points); {
+		j := i + 1
+		for j < len(points) && utils.CalculateDistance(points[i].latitude, points[i].longitude, points[j].latitude, points[j].longitude) <= stayRadius {
+			j++
+		}
+
+		if points[j-1].recordedAt.Sub(points[i].recordedAt) >= minStay {
+			stays = append(stays, newStayPoint(points[i:j]))
+			i = j
+			continue
+		}
+		i++
+	}
+	return stays
+}
+
+func newStayPoint(points []suggestionPoint) stayPoint {
+	var latitude, longitude float64
+	addresses := make([]string, 0, len(points))
+	cities := make([]string, 0, len(points))
+	for _, point := range points {
+		latitude += point.latitude
+		longitude += point.longitude
+		addresses = append(addresses, point.address)
+		cities = append(cities, point.city)
+	}
+
+	return stayPoint{
+		latitude:  latitude / float64(len(points)),
+		longitude: longitude / float64(len(points)),
+		arrival:   points[0].recordedAt,
+		departure: points[len(points)-1].recordedAt,
+		address:   mostCommonName(addresses),
+		city:      mostCommonName(cities),
+	}
+}
+
+// clusterStayPoints groups stays with DBSCAN: stays with at least minPoints
+// stays within radius, themselves included, are cores; a cluster is the
+// cores reachable from each other and the stays within radius of them.
+// Stays in no cluster are noise.
+func clusterStayPoints(stays []stayPoint, radius float64, minPoints int) [][]stayPoint {
+	const noise = -1
+	labels := make([]int, len(stays)) // 0 until visited, then noise or the cluster number
+
+	neighbors := func(i int) []int {
+		var found []int
+		for j := range stays {
+			if utils.CalculateDistance(stays[i].latitude, stays[i].longitude, stays[j].latitude, stays[j].longitude) <= radius {
+				found = append(found, j)
+			}
+		}
+		return found
+	}
+
+	clusters := 0
+	for i := range stays {
+		if labels[i] != 0 {
+			continue
+		}
+
+		queue := neighbors(i)
+		if len(queue) < minPoints {
+			labels[i] = noise
+			continue
+		}
+
+		clusters++
+		labels[i] = clusters
+		for k := 0; k < len(queue); k++ {
+			j := queue[k]
+			if labels[j] == noise {
+				// Reachable from a core, but not one itself
+				labels[j] = clusters
+				continue
+			}
+			if labels[j] != 0 {
+				continue
+			}
+
+			labels[j] = clusters
+			if reachable := neighbors(j); len(reachable) >= minPoints {
+				queue = append(queue, reachable...)
+			}
+		}
+	}
+
+	grouped := make([][]stayPoint, clusters)
+	for i, label := range labels {
+		if label > 0 {
+			grouped[label-1] = append(grouped[label-1], stays[i])
+		}
+	}
+	return grouped
+}
+
+// newPlaceSuggestion sums up a cluster of stays. The centre is weighted by
+// how long each stay lasted, and the radius reaches every stay.
+func newPlaceSuggestion(userID primitive.ObjectID, cluster []stayPoint, stayRadius, weeks float64) *models.PlaceSuggestion {
+	var latitude, longitude, weight float64
+	var total time.Duration
+	days := make(map[string]bool)
+	addresses := make([]string, 0, len(cluster))
+	cities := make([]string, 0, len(cluster))
+	suggestion := &models.PlaceSuggestion{
+		UserID:     userID,
+		VisitCount: len(cluster),
+		Status:     models.PlaceSuggestionStatusSuggested,
+		FirstVisit: cluster[0].arrival,
+		LastVisit:  cluster[0].departure,
+	}
+
+	for _, stay := range cluster {
+		w := math.Max(1, stay.duration().Seconds())
+		latitude += stay.latitude * w
+		longitude += stay.longitude * w
+		weight += w
+		total += stay.duration()
+
+		days[stay.arrival.UTC().Format("2006-01-02")] = true
+		addresses = append(addresses, stay.address)
+		cities = append(cities, stay.city)
+
+		if stay.arrival.Before(suggestion.FirstVisit) {
+			suggestion.FirstVisit = stay.arrival
+		}
+		if stay.departure.After(suggestion.LastVisit) {
+			suggestion.LastVisit = stay.departure
+		}
+	}
+	suggestion.Latitude = latitude / weight
+	suggestion.Longitude = longitude / weight
+
+	reach := 0.0
+	for _, stay := range cluster {
+		reach = math.Max(reach, utils.CalculateDistance(suggestion.Latitude, suggestion.Longitude, stay.latitude, stay.longitude))
+	}
+	// Rounded up to 10 meters
+	suggestion.Radius = int(math.Ceil(math.Max(stayRadius, reach+stayRadius/2)/10) * 10)
+
+	suggestion.VisitDays = len(days)
+	suggestion.VisitsPerWeek = math.Round(float64(len(cluster))/weeks*10) / 10
+	suggestion.TotalDuration = int64(total.Seconds())
+	suggestion.ProposedName, suggestion.Address = proposePlaceName(mostCommonName(addresses), mostCommonName(cities))
+	return suggestion
+}
+
+// proposePlaceName names a suggestion from the addresses the user's device
+// reverse geocoded while there: the street part of the most common one,
+// the city, or nothing better
+func proposePlaceName(address, city string) (string, string) {
+	if address != "" {
+		name := strings.TrimSpace(strings.SplitN(address, ",", 2)[0])
+		if name == "" {
+			name = address
+		}
+		return truncateRunes(name, 100), address
+	}
+	if city != "" {
+		return truncateRunes("Near "+city, 100), ""
+	}
+	return "Frequent place", ""
+}
+
+// mostCommonName returns the most frequent non-empty value, the first one
+// seen on a tie. Placeholders of failed geocoding don't count.
+func mostCommonName(values []string) string {
+	counts := make(map[string]int)
+	best := ""
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" || value == "Address not available" {
+			continue
+		}
+		counts[value]++
+		if best == "" || counts[value] > counts[best] {
+			best = value
+		}
+	}
+	return best
+}
+
+func truncateRunes(value string, max int) string {
+	runes := []rune(value)
+	if len(runes) <= max {
+		return value
+	}
+	return string(runes[:max])
+}
+
+// placeCoversSuggestion reports whether the suggestion's centre is within
+// reach of one of the places' geofences
+func placeCoversSuggestion(places []models.Place, suggestion *models.PlaceSuggestion, margin float64) bool {
+	for _, place := range places {
+		if utils.CalculateDistance(place.Latitude, place.Longitude, suggestion.Latitude, suggestion.Longitude) <= float64(place.Radius)+margin {
+			return true
+		}
+	}
+	return false
+}
+
+// nearestPlaceSuggestion returns the closest suggestion, in any status,
+// within radius or the suggestion's own radius of the candidate
+func nearestPlaceSuggestion(suggestions []models.PlaceSuggestion, candidate *models.PlaceSuggestion, radius float64) *models.PlaceSuggestion {
+	var nearest *models.PlaceSuggestion
+	nearestDistance := math.MaxFloat64
+	for i := range suggestions {
+		distance := utils.CalculateDistance(suggestions[i].Latitude, suggestions[i].Longitude, candidate.Latitude, candidate.Longitude)
+		if distance <= math.Max(radius, float64(suggestions[i].Radius)) && distance < nearestDistance {
+			nearest = &suggestions[i]
+			nearestDistance = distance
+		}
+	}
+	return nearest
+}
+
+// GetPlaceSuggestions returns the user's open suggestions, most visited
+// first. Ones a place has covered since they were found are left out.
+func (ps *PlaceService) GetPlaceSuggestions(ctx context.Context, userID string) ([]models.PlaceSuggestion, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	cfg := GetPlaceSuggestionConfig()
+	if !cfg.Enabled || ps.locationRepo == nil {
+		return []models.PlaceSuggestion{}, nil
+	}
+
+	suggestions, err := ps.placeRepo.GetPendingPlaceSuggestions(ctx, userObjectID, time.Now().Add(-cfg.Retention))
+	if err != nil {
+		return nil, err
+	}
+	if len(suggestions) == 0 {
+		return suggestions, nil
+	}
+
+	places, err := ps.suggestionPlaces(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	open := suggestions[:0]
+	for i := range suggestions {
+		if !placeCoversSuggestion(places, &suggestions[i], 0) {
+			open = append(open, suggestions[i])
+		}
+	}
+	return open, nil
+}
+
+// AcceptPlaceSuggestion creates a place where the suggestion is, named and
+// sized as suggested unless the request says otherwise
+func (ps *PlaceService) AcceptPlaceSuggestion(ctx context.Context, userID, suggestionID string, req models.AcceptPlaceSuggestionRequest) (*models.AcceptPlaceSuggestionResponse, error) {
+	if validationErrors := ps.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	suggestion, err := ps.ownPlaceSuggestion(ctx, userID, suggestionID)
+	if err != nil {
+		return nil, err
+	}
+
+	name := req.Name
+	if name == "" {
+		name = suggestion.ProposedName
+	}
+	category := req.Category
+	if category == "" {
+		category = "other"
+	}
+	radius := req.Radius
+	if radius == 0 {
+		// The plan's bounds apply to the suggested radius too
+		bounds := ps.radiusBoundsFor(ctx, userID)
+		radius = suggestion.Radius
+		if radius < bounds.Min {
+			radius = bounds.Min
+		}
+		if radius > bounds.Max {
+			radius = bounds.Max
+		}
+	}
+
+	place, err := ps.CreatePlace(ctx, userID, models.CreatePlaceRequest{
+		Name:      name,
+		Address:   truncateRunes(suggestion.Address, 200),
+		Latitude:  suggestion.Latitude,
+		Longitude: suggestion.Longitude,
+		Radius:    radius,
+		Category:  category,
+		CircleID:  req.CircleID,
+		Force:     req.Force,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	placeID := place.ID
+	err = ps.placeRepo.UpdatePlaceSuggestion(ctx, suggestion.ID, bson.M{
+		"status":  models.PlaceSuggestionStatusAccepted,
+		"placeId": placeID,
+	})
+	if err != nil {
+		// The place exists; a suggestion accepted twice at once only
+		// keeps the first place
+		logrus.Warnf("Failed to mark place suggestion %s accepted: %v", suggestionID, err)
+	}
+	suggestion.Status = models.PlaceSuggestionStatusAccepted
+	suggestion.PlaceID = &placeID
+
+	logrus.Infof("Place suggestion %s accepted as place %s by user %s", suggestionID, placeID.Hex(), userID)
+	return &models.AcceptPlaceSuggestionResponse{Suggestion: *suggestion, Place: place}, nil
+}
+
+// DismissPlaceSuggestion hides a suggestion for good. Stays around it
+// aren't suggested again.
+func (ps *PlaceService) DismissPlaceSuggestion(ctx context.Context, userID, suggestionID string) error {
+	suggestion, err := ps.ownPlaceSuggestion(ctx, userID, suggestionID)
+	if err != nil {
+		return err
+	}
+
+	return ps.placeRepo.UpdatePlaceSuggestion(ctx, suggestion.ID, bson.M{
+		"status": models.PlaceSuggestionStatusDismissed,
+	})
+}
+
+// ownPlaceSuggestion returns the user's open suggestion. Other users'
+// suggestions don't exist for them.
+func (ps *PlaceService) ownPlaceSuggestion(ctx context.Context, userID, suggestionID string) (*models.PlaceSuggestion, error) {
+	suggestion, err := ps.placeRepo.GetPlaceSuggestion(ctx, suggestionID)
+	if err != nil {
+		return nil, err
+	}
+	if suggestion.UserID.Hex() != userID || suggestion.Status != models.PlaceSuggestionStatusSuggested {
+		return nil, errors.New("place suggestion not found")
+	}
+	return suggestion, nil
+}
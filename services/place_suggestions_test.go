@@ -0,0 +1,261 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"ftrack/models"
+	"ftrack/repositories"
+	"ftrack/testharness"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// About 100 meters north
+const suggestionStep = 0.0009
+
+// visit returns a point every five minutes from arrival for the duration
+func visit(latitude, longitude float64, arrival time.Time, d time.Duration, address string) []suggestionPoint {
+	var points []suggestionPoint
+	for at := arrival; !at.After(arrival.Add(d)); at = at.Add(5 * time.Minute) {
+		points = append(points, suggestionPoint{latitude: latitude, longitude: longitude, recordedAt: at, address: address, city: "London"})
+	}
+	return points
+}
+
+func stayAt(latitude float64, day int) stayPoint {
+	arrival := time.Date(2026, 10, day, 9, 0, 0, 0, time.UTC)
+	return stayPoint{latitude: latitude, longitude: -0.12, arrival: arrival, departure: arrival.Add(time.Hour)}
+}
+
+func TestDetectStayPoints(t *testing.T) {
+	start := time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC)
+	var points []suggestionPoint
+	points = append(points, visit(51.5, -0.12, start, 20*time.Minute, "12 Oak St")...)
+	// Passing through doesn't count
+	points = append(points, visit(51.51, -0.12, start.Add(25*time.Minute), 5*time.Minute, "")...)
+	points = append(points, visit(51.52, -0.12, start.Add(35*time.Minute), 15*time.Minute, "")...)
+	points = append(points, visit(51.53, -0.12, start.Add(55*time.Minute), 10*time.Minute, "")...)
+
+	stays := detectStayPoints(points, 100, 15*time.Minute)
+	var got []string
+	for _, stay := range stays {
+		got = append(got, fmt.Sprintf("%.2f %s-%s %s", stay.latitude, stay.arrival.Format("15:04"), stay.departure.Format("15:04"), stay.address))
+	}
+	want := []string{"51.50 08:00-08:20 12 Oak St", "51.52 08:35-08:50 "}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("stays %q, want %q", got, want)
+	}
+
+	if stays := detectStayPoints(nil, 100, 15*time.Minute); len(stays) != 0 {
+		t.Errorf("%d stays without points", len(stays))
+	}
+}
+
+func TestClusterStayPoints(t *testing.T) {
+	clusters := func(stays []stayPoint, minPoints int) string {
+		var sizes []string
+		for _, cluster := range clusterStayPoints(stays, 150, minPoints) {
+			sizes = append(sizes, fmt.Sprint(len(cluster)))
+		}
+		return strings.Join(sizes, ",")
+	}
+
+	tests := []struct {
+		name      string
+		stays     []stayPoint
+		minPoints int
+		want      string // cluster sizes
+	}{
+		{"none", nil, 3, ""},
+		{"home three times", []stayPoint{stayAt(51.5, 1), stayAt(51.5, 2), stayAt(51.5+suggestionStep/2, 3)}, 3, "3"},
+		{"twice isn't enough", []stayPoint{stayAt(51.5, 1), stayAt(51.5, 2)}, 3, ""},
+		{"two places", []stayPoint{
+			stayAt(51.5, 1), stayAt(51.6, 1), stayAt(51.5, 2), stayAt(51.6, 2), stayAt(51.5, 3), stayAt(51.6, 3), stayAt(51.7, 3),
+		}, 3, "3,3"},
+		{"chained through cores", []stayPoint{
+			stayAt(51.5, 1), stayAt(51.5+suggestionStep, 2), stayAt(51.5+2*suggestionStep, 3), stayAt(51.5+3*suggestionStep, 4),
+		}, 2, "4"},
+		// The stay 200m out is within reach of the core 100m out without
+		// being one, so the one past it is noise
+		{"border and noise", []stayPoint{
+			stayAt(51.5, 1), stayAt(51.5, 2), stayAt(51.5, 3),
+			stayAt(51.5+suggestionStep, 4), stayAt(51.5+2*suggestionStep, 5), stayAt(51.5+3*suggestionStep, 6),
+		}, 4, "5"},
+	}
+	for _, tt := range tests {
+		if got := clusters(tt.stays, tt.minPoints); got != tt.want {
+			t.Errorf("%s: clusters of %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNewPlaceSuggestion(t *testing.T) {
+	userID := primitive.NewObjectID()
+	home := stayAt(51.5, 1)
+	home.address = "12 Oak St, London"
+	long := stayAt(51.5+suggestionStep, 2)
+	long.departure, long.address = long.arrival.Add(2*time.Hour), "14 Oak St, London"
+	cluster := []stayPoint{home, home, long}
+
+	suggestion := newPlaceSuggestion(userID, cluster, 100, 4)
+	if suggestion.VisitCount != 3 || suggestion.VisitDays != 2 || suggestion.TotalDuration != 4*60*60 || suggestion.VisitsPerWeek != 0.8 {
+		t.Errorf("%d visits on %d days, %d seconds, %.1f a week; want 3 on 2 days, 14400 seconds, 0.8 a week",
+			suggestion.VisitCount, suggestion.VisitDays, suggestion.TotalDuration, suggestion.VisitsPerWeek)
+	}
+	if !suggestion.FirstVisit.Equal(cluster[0].arrival) || !suggestion.LastVisit.Equal(long.departure) {
+		t.Errorf("visited from %v to %v", suggestion.FirstVisit, suggestion.LastVisit)
+	}
+	// Weighted by time spent, the longer stay pulls the centre half way
+	if want := 51.5 + suggestionStep/2; suggestion.Latitude < want-1e-9 || suggestion.Latitude > want+1e-9 {
+		t.Errorf("centre at %.6f, want %.6f", suggestion.Latitude, want)
+	}
+	// Stays 50m out plus half the stay radius, rounded up to 10 meters
+	if suggestion.Radius != 110 {
+		t.Errorf("radius %d, want 110", suggestion.Radius)
+	}
+	if suggestion.ProposedName != "12 Oak St" || suggestion.Address != "12 Oak St, London" || suggestion.Status != models.PlaceSuggestionStatusSuggested {
+		t.Errorf("suggested %q at %q, %s", suggestion.ProposedName, suggestion.Address, suggestion.Status)
+	}
+
+	tests := []struct {
+		address, city string
+		name, kept    string
+	}{
+		{"12 Oak St, London", "London", "12 Oak St", "12 Oak St, London"},
+		{"Oak Park", "", "Oak Park", "Oak Park"},
+		{"", "London", "Near London", ""},
+		{"", "", "Frequent place", ""},
+	}
+	for _, tt := range tests {
+		if name, address := proposePlaceName(tt.address, tt.city); name != tt.name || address != tt.kept {
+			t.Errorf("proposePlaceName(%q, %q) = %q, %q; want %q, %q", tt.address, tt.city, name, address, tt.name, tt.kept)
+		}
+	}
+	if got := mostCommonName([]string{"", "Address not available", "Address not available", "A", "B", "B"}); got != "B" {
+		t.Errorf("most common name %q, want B", got)
+	}
+	if got := mostCommonName([]string{"A", "B"}); got != "A" {
+		t.Errorf("most common name on a tie %q, want the first", got)
+	}
+}
+
+// storeVisit stores a location every five minutes of the visit, with the
+// address the device reverse geocoded
+func storeVisit(t *testing.T, env *testharness.Env, user *models.User, latitude, longitude float64, arrival time.Time, d time.Duration, address string) {
+	t.Helper()
+	ctx := context.Background()
+	for _, point := range visit(latitude, longitude, arrival, d, address) {
+		location := models.Location{
+			ID: primitive.NewObjectID(), UserID: user.ID, Latitude: latitude, Longitude: longitude, Accuracy: 5,
+			Point: models.NewGeoPoint(latitude, longitude), Address: point.address, City: point.city,
+			DeviceTime: point.recordedAt, ServerTime: point.recordedAt, CreatedAt: point.recordedAt,
+		}
+		if _, err := env.DB.Collection(repositories.LocationPartitionName(point.recordedAt)).InsertOne(ctx, location); err != nil {
+			t.Fatalf("storing location: %v", err)
+		}
+	}
+	last := arrival.Add(d)
+	if _, err := env.DB.Collection("latest_locations").ReplaceOne(ctx, bson.M{"_id": user.ID},
+		models.LatestLocation{UserID: user.ID, RecordedAt: last}, options.Replace().SetUpsert(true)); err != nil {
+		t.Fatalf("storing latest location: %v", err)
+	}
+}
+
+func TestGeneratePlaceSuggestions(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ps := newTestPlaceService(env)
+	ps.ConfigureSuggestionHistory(env.Repos.Location)
+	ctx := context.Background()
+
+	user, other := env.Factory.User(), env.Factory.User()
+	gym := env.Factory.Place(user, func(place *models.Place) { place.Latitude, place.Longitude = 51.6, -0.12 })
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	// Evenings at home and mornings at the gym for three days, one coffee
+	for day := 1; day <= 3; day++ {
+		morning := today.AddDate(0, 0, -day).Add(7 * time.Hour)
+		storeVisit(t, env, user, gym.Latitude, gym.Longitude, morning, time.Hour, "Gym, London")
+		storeVisit(t, env, user, 51.5, -0.12, morning.Add(12*time.Hour), 2*time.Hour, "12 Oak St, London")
+	}
+	storeVisit(t, env, user, 51.7, -0.12, today.AddDate(0, 0, -1).Add(10*time.Hour), time.Hour, "Cafe, London")
+	for day := 1; day <= 3; day++ {
+		storeVisit(t, env, other, 51.4, -0.12, today.AddDate(0, 0, -day).Add(12*time.Hour), time.Hour, "")
+	}
+
+	generate := func(want int) {
+		t.Helper()
+		created, err := ps.GeneratePlaceSuggestions(ctx, today.AddDate(0, 0, -7))
+		if err != nil {
+			t.Fatalf("GeneratePlaceSuggestions: %v", err)
+		}
+		if created != want {
+			t.Errorf("%d places suggested, want %d", created, want)
+		}
+	}
+	suggestions := func(user *models.User) []models.PlaceSuggestion {
+		t.Helper()
+		suggestions, err := ps.GetPlaceSuggestions(ctx, user.ID.Hex())
+		if err != nil {
+			t.Fatalf("GetPlaceSuggestions: %v", err)
+		}
+		return suggestions
+	}
+
+	// Home is suggested; the gym has a place and the cafe was visited once
+	generate(2)
+	home := suggestions(user)
+	if len(home) != 1 {
+		t.Fatalf("%d suggestions, want home", len(home))
+	}
+	if home[0].ProposedName != "12 Oak St" || home[0].VisitCount != 3 || home[0].VisitDays != 3 || home[0].TotalDuration != 3*2*60*60 {
+		t.Errorf("suggested %q visited %d times on %d days for %d seconds", home[0].ProposedName, home[0].VisitCount, home[0].VisitDays, home[0].TotalDuration)
+	}
+
+	// Analyzing again refreshes the suggestion instead of adding another
+	generate(0)
+	if got := suggestions(user); len(got) != 1 || got[0].ID != home[0].ID {
+		t.Errorf("suggestions after analyzing again %+v, want home once", got)
+	}
+
+	// Someone else's suggestion can't be dismissed
+	if err := ps.DismissPlaceSuggestion(ctx, other.ID.Hex(), home[0].ID.Hex()); err == nil || err.Error() != "place suggestion not found" {
+		t.Errorf("dismissing someone else's suggestion error = %v", err)
+	}
+
+	// Dismissed suggestions don't come back
+	if err := ps.DismissPlaceSuggestion(ctx, user.ID.Hex(), home[0].ID.Hex()); err != nil {
+		t.Fatalf("DismissPlaceSuggestion: %v", err)
+	}
+	generate(0)
+	if got := suggestions(user); len(got) != 0 {
+		t.Errorf("%d suggestions after dismissing home, want none", len(got))
+	}
+
+	// Accepting creates the place where the stays were
+	park := suggestions(other)
+	if len(park) != 1 || park[0].ProposedName != "Near London" {
+		t.Fatalf("other user's suggestions %+v, want one near London", park)
+	}
+	accepted, err := ps.AcceptPlaceSuggestion(ctx, other.ID.Hex(), park[0].ID.Hex(), models.AcceptPlaceSuggestionRequest{Name: "Park"})
+	if err != nil {
+		t.Fatalf("AcceptPlaceSuggestion: %v", err)
+	}
+	if place := accepted.Place; place.Name != "Park" || place.Latitude != park[0].Latitude || place.Radius != park[0].Radius ||
+		accepted.Suggestion.Status != models.PlaceSuggestionStatusAccepted || *accepted.Suggestion.PlaceID != place.ID {
+		t.Errorf("accepted as %+v with suggestion %+v", place, accepted.Suggestion)
+	}
+	if _, err := ps.AcceptPlaceSuggestion(ctx, other.ID.Hex(), park[0].ID.Hex(), models.AcceptPlaceSuggestionRequest{}); err == nil || err.Error() != "place suggestion not found" {
+		t.Errorf("accepting twice error = %v", err)
+	}
+	generate(0)
+	if got := suggestions(other); len(got) != 0 {
+		t.Errorf("%d suggestions after accepting, want none", len(got))
+	}
+}
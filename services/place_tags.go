@@ -0,0 +1,279 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"unicode/utf8"
+
+	"ftrack/models"
+	"ftrack/utils"
+
+	"github.com/sirupsen/logrus"
+)
+
+const defaultPlaceTagsLimit = 20
+
+// normalizePlaceTags lowercases and trims the tags, drops empty and
+// repeated ones, and enforces the count and length limits
+func normalizePlaceTags(tags []string) ([]string, error) {
+	normalized := make([]string, 0, len(tags))
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = models.NormalizePlaceTag(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if err := validatePlaceTag(tag); err != nil {
+			return nil, err
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+
+	if len(normalized) > models.MaxPlaceTags {
+		return nil, utils.NewValidationFailedError(fmt.Sprintf("a place can have at most %d tags", models.MaxPlaceTags))
+	}
+	return normalized, nil
+}
+
+func validatePlaceTag(tag string) error {
+	if utf8.RuneCountInString(tag) > models.MaxPlaceTagLength {
+		return utils.NewValidationFailedError(fmt.Sprintf("tags can be at most %d characters", models.MaxPlaceTagLength))
+	}
+	return nil
+}
+
+// GetPlaceTags returns the tags of the user's places with how many places
+// carry each, for autocomplete
+func (ps *PlaceService) GetPlaceTags(ctx context.Context, userID string, req models.GetPlaceTagsRequest) ([]models.PlaceTagUsage, error) {
+	if validationErrors := ps.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	limit := req.Limit
+	if limit == 0 {
+		limit = defaultPlaceTagsLimit
+	}
+	return ps.placeRepo.GetPlaceTags(ctx, userID, models.NormalizePlaceTag(req.Prefix), limit)
+}
+
+// RenamePlaceTag renames a tag across all of the user's places and the
+// conditions of their automation rules
+func (ps *PlaceService) RenamePlaceTag(ctx context.Context, userID, tag string, req models.RenamePlaceTagRequest) (*models.RenamePlaceTagResult, error) {
+	from := models.NormalizePlaceTag(tag)
+	to := models.NormalizePlaceTag(req.Name)
+	if from == "" || to == "" {
+		return nil, utils.NewValidationFailedError("tag names can't be empty")
+	}
+	if err := validatePlaceTag(to); err != nil {
+		return nil, err
+	}
+
+	result := &models.RenamePlaceTagResult{Tag: to}
+	if from == to {
+		return result, nil
+	}
+
+	places, err := ps.placeRepo.RenamePlaceTag(ctx, userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	result.Places = places
+
+	rules, err := ps.placeRepo.RenameRuleTag(ctx, userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	result.Rules = rules
+
+	logrus.Infof("User %s renamed place tag %q to %q on %d places and %d rules", userID, from, to, places, rules)
+	return result, nil
+}
+
+// BulkUpdatePlaces applies the same changes to the user's places picked by
+// the request. Each place is updated and versioned on its own, so a place
+// that can't take the change, e.g. one that would have too many tags, is
+// reported without stopping the others.
+func (ps *PlaceService) BulkUpdatePlaces(ctx context.Context, userID string, req models.BulkUpdatePlacesRequest) (*models.BulkUpdatePlacesResult, error) {
+	if validationErrors := ps.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+	if len(req.PlaceIDs) == 0 && len(req.Tags) == 0 && req.Category == "" {
+		return nil, utils.NewValidationFailedError("pick places by ID, tags or category")
+	}
+
+	var err error
+	if req.Tags, err = normalizePlaceTags(req.Tags); err != nil {
+		return nil, err
+	}
+	update := req.Update
+	if update.AddTags, err = normalizePlaceTags(update.AddTags); err != nil {
+		return nil, err
+	}
+	if update.RemoveTags, err = normalizePlaceTags(update.RemoveTags); err != nil {
+		return nil, err
+	}
+
+	places, err := ps.placeRepo.GetPlacesForBulkUpdate(ctx, userID, req, models.MaxBulkPlaceUpdates+1)
+	if err != nil {
+		return nil, err
+	}
+	if len(places) > models.MaxBulkPlaceUpdates {
+		return nil, utils.NewValidationFailedError(fmt.Sprintf("a bulk update can change at most %d places", models.MaxBulkPlaceUpdates))
+	}
+
+	result := &models.BulkUpdatePlacesResult{Matched: len(places)}
+	for i := range places {
+		place := &places[i]
+
+		updates, err := bulkPlaceUpdates(place, update)
+		if err != nil {
+			result.Failed = append(result.Failed, models.BulkPlaceUpdateErr{
+				PlaceID: place.ID,
+				Reason:  utils.ValidationFailureReason(err),
+			})
+			continue
+		}
+		// Favorites are the member's own, not part of the place
+		if update.IsFavorite != nil {
+			if err := ps.placeRepo.SetPlaceFavorite(ctx, place.UserID, place.ID, *update.IsFavorite); err != nil {
+				return result, err
+			}
+		}
+		if len(updates) == 0 {
+			if update.IsFavorite != nil {
+				result.Updated++
+			}
+			continue
+		}
+
+		if err := ps.placeRepo.Update(ctx, place.ID.Hex(), updates); err != nil {
+			return result, err
+		}
+		result.Updated++
+
+		ps.invalidatePlaceTypeahead(ctx, place)
+		if updated, err := ps.placeRepo.GetByID(ctx, place.ID.Hex()); err == nil {
+			ps.recordPlaceVersion(ctx, userID, place, updated, models.PlaceVersionUpdate, nil)
+		}
+	}
+
+	logrus.Infof("User %s bulk updated %d of %d places", userID, result.Updated, result.Matched)
+	return result, nil
+}
+
+// bulkPlaceUpdates returns the fields the update changes on the place
+func bulkPlaceUpdates(place *models.Place, update models.BulkPlaceUpdate) (map[string]interface{}, error) {
+	updates := make(map[string]interface{})
+	if update.Category != nil {
+		updates["category"] = *update.Category
+	}
+	if update.Color != nil {
+		updates["color"] = *update.Color
+	}
+	if update.Icon != nil {
+		updates["icon"] = *update.Icon
+	}
+	if update.IsActive != nil {
+		updates["isActive"] = *update.IsActive
+	}
+
+	if len(update.AddTags) > 0 || len(update.RemoveTags) > 0 {
+		removed := make(map[string]bool)
+		for _, tag := range update.RemoveTags {
+			removed[tag] = true
+		}
+
+		var tags []string
+		for _, tag := range place.Tags {
+			if !removed[tag] {
+				tags = append(tags, tag)
+			}
+		}
+		tags, err := normalizePlaceTags(append(tags, update.AddTags...))
+		if err != nil {
+			return nil, err
+		}
+		updates["tags"] = tags
+	}
+
+	return updates, nil
+}
+
+// placeRuleMatches reports whether the rule's place conditions hold for the
+// place: every place it names is this one, and every tag it asks for is
+// among the place's tags
+func placeRuleMatches(rule *models.AutomationRule, place *models.Place) bool {
+	for _, condition := range rule.Conditions {
+		switch condition.Type {
+		case "place":
+			if condition.PlaceID != nil && *condition.PlaceID != place.ID {
+				return false
+			}
+		case models.RuleConditionPlaceTag:
+			tag, _ := condition.Value.(string)
+			if !placeHasTag(place, tag) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func placeHasTag(place *models.Place, tag string) bool {
+	for _, placeTag := range place.Tags {
+		if placeTag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// RecordPlaceRuleTriggers finds the user's arrival or departure rules that
+// match the place, in the order they run, and records that they fired. A
+// matching rule with StopOnMatch ends the run.
+func (ps *PlaceService) RecordPlaceRuleTriggers(ctx context.Context, userID string, place *models.Place, ruleType string) ([]models.AutomationRule, error) {
+	rules, err := ps.placeRepo.GetActivePlaceRules(ctx, userID, ruleType)
+	if err != nil {
+		return nil, err
+	}
+
+	var triggered []models.AutomationRule
+	for i := range rules {
+		rule := &rules[i]
+		if !placeRuleMatches(rule, place) {
+			continue
+		}
+
+		if err := ps.placeRepo.RecordAutomationTrigger(ctx, rule.ID); err != nil {
+			logrus.Warnf("Failed to record trigger of automation rule %s: %v", rule.ID.Hex(), err)
+		}
+		triggered = append(triggered, *rule)
+		if rule.StopOnMatch {
+			break
+		}
+	}
+	return triggered, nil
+}
+
+// normalizeRuleTagConditions normalizes the tags place_tag conditions ask
+// for. Their rules match places anywhere, so they don't name one.
+func normalizeRuleTagConditions(conditions []models.RuleCondition) error {
+	for i := range conditions {
+		if conditions[i].Type != models.RuleConditionPlaceTag {
+			continue
+		}
+
+		tag, ok := conditions[i].Value.(string)
+		tag = models.NormalizePlaceTag(tag)
+		if !ok || tag == "" {
+			return utils.NewValidationFailedError("place_tag conditions need a tag")
+		}
+		if err := validatePlaceTag(tag); err != nil {
+			return err
+		}
+		conditions[i].Value = tag
+		conditions[i].PlaceID = nil
+	}
+	return nil
+}
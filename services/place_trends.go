@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"ftrack/models"
+	"ftrack/repositories"
+	"ftrack/utils"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// How long it takes a visit or check-in to lose half its weight, unless
+	// configured otherwise
+	DefaultPlaceTrendingHalfLife = 72 * time.Hour
+	DefaultPlacePopularHalfLife  = 30 * 24 * time.Hour
+
+	placeTrendingDays   = 14
+	placePopularDays    = 90
+	placeTrendsLimit    = 10
+	placeTrendsMaxLimit = 50
+)
+
+// ConfigurePlaceTrends sets the half-lives trending and popular places are
+// scored with
+func (ps *PlaceService) ConfigurePlaceTrends(trendingHalfLife, popularHalfLife time.Duration) {
+	if trendingHalfLife > 0 {
+		ps.trendingHalfLife = trendingHalfLife
+	}
+	if popularHalfLife > 0 {
+		ps.popularHalfLife = popularHalfLife
+	}
+}
+
+// GetTrendingPlaces ranks the places the user can see by recent activity.
+// The short half-life lets a place that is getting busier overtake one that
+// has always been busy.
+func (ps *PlaceService) GetTrendingPlaces(ctx context.Context, userID string, req models.GetPlaceTrendsRequest) (*models.PlaceTrendsResponse, error) {
+	return ps.rankPlaces(ctx, userID, req, placeTrendingDays, ps.trendingHalfLife)
+}
+
+// GetPopularPlaces ranks the places the user can see by activity over a
+// longer period, still favoring recent visits
+func (ps *PlaceService) GetPopularPlaces(ctx context.Context, userID string, req models.GetPlaceTrendsRequest) (*models.PlaceTrendsResponse, error) {
+	return ps.rankPlaces(ctx, userID, req, placePopularDays, ps.popularHalfLife)
+}
+
+func (ps *PlaceService) rankPlaces(ctx context.Context, userID string, req models.GetPlaceTrendsRequest, defaultDays int, halfLife time.Duration) (*models.PlaceTrendsResponse, error) {
+	if validationErrors := ps.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	viewerID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	days := req.Days
+	if days == 0 {
+		days = defaultDays
+	}
+	limit := req.Limit
+	if limit == 0 {
+		limit = placeTrendsLimit
+	}
+
+	response := &models.PlaceTrendsResponse{
+		Places:        []models.PlaceTrend{},
+		PeriodDays:    days,
+		HalfLifeHours: halfLife.Hours(),
+		GeneratedAt:   time.Now(),
+	}
+
+	names, err := ps.typeaheadNames(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return response, nil
+	}
+
+	places := make(map[primitive.ObjectID]models.Place, len(names))
+	placeIDs := make([]primitive.ObjectID, 0, len(names))
+	for _, entry := range names {
+		places[entry.place.ID] = entry.place
+		placeIDs = append(placeIDs, entry.place.ID)
+	}
+
+	mateIDs, err := ps.circleMateIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := response.GeneratedAt
+	period := time.Duration(days) * 24 * time.Hour
+	since := now.Add(-period)
+	recentSince := now.Add(-period / 2)
+
+	activity, err := ps.placeRepo.GetPlaceActivity(ctx, placeIDs, viewerID, mateIDs, since, recentSince, now, halfLife)
+	if err != nil {
+		return nil, err
+	}
+
+	trends := scorePlaceActivity(activity, viewerID)
+	for i := range trends {
+		place := places[trends[i].PlaceID]
+		trends[i].Name = place.Name
+		trends[i].Category = place.Category
+		trends[i].Icon = place.Icon
+		trends[i].Latitude = place.Latitude
+		trends[i].Longitude = place.Longitude
+	}
+
+	sort.SliceStable(trends, func(i, j int) bool {
+		if trends[i].Score != trends[j].Score {
+			return trends[i].Score > trends[j].Score
+		}
+		if trends[i].RecentDelta != trends[j].RecentDelta {
+			return trends[i].RecentDelta > trends[j].RecentDelta
+		}
+		return trends[i].Name < trends[j].Name
+	})
+
+	if limit > placeTrendsMaxLimit {
+		limit = placeTrendsMaxLimit
+	}
+	if len(trends) > limit {
+		trends = trends[:limit]
+	}
+	response.Places = trends
+
+	return response, nil
+}
+
+// scorePlaceActivity sums the activity per place. The viewer's own events
+// count once per place, for their latest one, so going somewhere every day
+// doesn't make it trend for them.
+func scorePlaceActivity(activity []repositories.PlaceActivity, viewerID primitive.ObjectID) []models.PlaceTrend {
+	byPlace := make(map[primitive.ObjectID]*models.PlaceTrend)
+	order := []primitive.ObjectID{}
+
+	for _, entry := range activity {
+		trend, exists := byPlace[entry.PlaceID]
+		if !exists {
+			trend = &models.PlaceTrend{PlaceID: entry.PlaceID}
+			byPlace[entry.PlaceID] = trend
+			order = append(order, entry.PlaceID)
+		}
+
+		trend.Visits += entry.Visits
+		trend.Checkins += entry.Checkins
+
+		if entry.UserID == viewerID {
+			trend.Score += entry.MaxWeight
+			trend.RecentEvents += min(entry.Recent, 1)
+			trend.PreviousEvents += min(entry.Previous, 1)
+			continue
+		}
+
+		trend.Score += entry.Score
+		trend.RecentEvents += entry.Recent
+		trend.PreviousEvents += entry.Previous
+	}
+
+	trends := make([]models.PlaceTrend, 0, len(order))
+	for _, placeID := range order {
+		trend := byPlace[placeID]
+		if trend.Score <= 0 {
+			continue
+		}
+		trend.RecentDelta = trend.RecentEvents - trend.PreviousEvents
+		trends = append(trends, *trend)
+	}
+	return trends
+}
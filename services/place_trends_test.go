@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"ftrack/models"
+	"ftrack/repositories"
+	"ftrack/testharness"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestScorePlaceActivity(t *testing.T) {
+	viewer, mate := primitive.NewObjectID(), primitive.NewObjectID()
+	gym, cafe, closed := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+
+	trends := scorePlaceActivity([]repositories.PlaceActivity{
+		// The viewer goes to the gym every day
+		{PlaceID: gym, UserID: viewer, Score: 9.5, MaxWeight: 0.9, Visits: 14, Recent: 7, Previous: 7},
+		{PlaceID: cafe, UserID: viewer, Score: 0.5, MaxWeight: 0.5, Checkins: 1, Recent: 1},
+		{PlaceID: cafe, UserID: mate, Score: 2.5, MaxWeight: 0.9, Visits: 3, Checkins: 1, Recent: 3, Previous: 1},
+		{PlaceID: closed, UserID: mate},
+	}, viewer)
+
+	want := map[primitive.ObjectID]models.PlaceTrend{
+		gym:  {PlaceID: gym, Score: 0.9, Visits: 14, RecentEvents: 1, PreviousEvents: 1, RecentDelta: 0},
+		cafe: {PlaceID: cafe, Score: 3, Visits: 3, Checkins: 2, RecentEvents: 4, PreviousEvents: 1, RecentDelta: 3},
+	}
+	if len(trends) != len(want) {
+		t.Fatalf("trends = %+v, want gym and cafe", trends)
+	}
+	for _, trend := range trends {
+		if trend != want[trend.PlaceID] {
+			t.Errorf("trend = %+v, want %+v", trend, want[trend.PlaceID])
+		}
+	}
+}
+
+func TestPlaceServiceTrendingSteadyVsSurging(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ps := NewPlaceService(env.Repos.Place, env.Repos.Circle, nil)
+	ctx := context.Background()
+
+	viewer, mate := env.Factory.User(), env.Factory.User()
+	circle := env.Factory.Circle(viewer, []*models.User{mate})
+	steady := env.Factory.Place(viewer, testharness.InCircle(circle), func(place *models.Place) { place.Name = "Steady" })
+	surging := env.Factory.Place(viewer, testharness.InCircle(circle), func(place *models.Place) { place.Name = "Surging" })
+	quiet := env.Factory.Place(viewer, testharness.InCircle(circle), func(place *models.Place) { place.Name = "Quiet" })
+
+	now := time.Now()
+	visit := func(place *models.Place, user *models.User, ago time.Duration) {
+		t.Helper()
+		err := env.Repos.Place.CreateVisit(ctx, &models.PlaceVisit{PlaceID: place.ID, UserID: user.ID, ArrivalTime: now.Add(-ago)})
+		if err != nil {
+			t.Fatalf("creating visit: %v", err)
+		}
+	}
+
+	// The steady place has a visit every day, the surging one a burst of
+	// visits in the last two days: fewer in all, but rising. Places without
+	// visits aren't ranked.
+	for day := 0; day < 14; day++ {
+		visit(steady, mate, time.Duration(day)*24*time.Hour+time.Hour)
+	}
+	for i := 0; i < 10; i++ {
+		visit(surging, mate, time.Duration(i)*4*time.Hour+time.Hour)
+	}
+
+	trending, err := ps.GetTrendingPlaces(ctx, viewer.ID.Hex(), models.GetPlaceTrendsRequest{})
+	if err != nil {
+		t.Fatalf("GetTrendingPlaces: %v", err)
+	}
+	if len(trending.Places) != 2 || trending.Places[0].PlaceID != surging.ID {
+		t.Fatalf("trending = %+v, want the surging place first", trending.Places)
+	}
+	top, next := trending.Places[0], trending.Places[1]
+	if top.Visits != 10 || next.Visits != 14 || top.Score <= next.Score {
+		t.Errorf("surging %d visits scored %.2f, steady %d scored %.2f; want fewer visits to score higher", top.Visits, top.Score, next.Visits, next.Score)
+	}
+	if top.RecentDelta != 10 || next.RecentDelta != 0 {
+		t.Errorf("recent deltas = %d surging, %d steady; want 10 and 0", top.RecentDelta, next.RecentDelta)
+	}
+	if trending.PeriodDays != placeTrendingDays || trending.HalfLifeHours != DefaultPlaceTrendingHalfLife.Hours() {
+		t.Errorf("trending period %d days, half-life %vh; want the defaults", trending.PeriodDays, trending.HalfLifeHours)
+	}
+
+	// Over the longer popular period the steady place still leads
+	popular, err := ps.GetPopularPlaces(ctx, viewer.ID.Hex(), models.GetPlaceTrendsRequest{})
+	if err != nil {
+		t.Fatalf("GetPopularPlaces: %v", err)
+	}
+	if len(popular.Places) != 2 || popular.Places[0].PlaceID != steady.ID {
+		t.Errorf("popular = %+v, want the steady place first", popular.Places)
+	}
+
+	// A short enough half-life ranks by little more than the latest visits
+	ps.ConfigurePlaceTrends(time.Hour, 0)
+	trending, err = ps.GetTrendingPlaces(ctx, viewer.ID.Hex(), models.GetPlaceTrendsRequest{Days: 1})
+	if err != nil {
+		t.Fatalf("GetTrendingPlaces: %v", err)
+	}
+	if trending.HalfLifeHours != 1 || len(trending.Places) != 2 || trending.Places[0].PlaceID != surging.ID {
+		t.Errorf("trending with a 1h half-life = %+v", trending)
+	}
+
+	// The viewer's own daily visits don't make a place trend for them
+	for day := 0; day < 14; day++ {
+		visit(quiet, viewer, time.Duration(day)*time.Hour+time.Minute)
+	}
+	ps.ConfigurePlaceTrends(DefaultPlaceTrendingHalfLife, 0)
+	trending, err = ps.GetTrendingPlaces(ctx, viewer.ID.Hex(), models.GetPlaceTrendsRequest{})
+	if err != nil {
+		t.Fatalf("GetTrendingPlaces: %v", err)
+	}
+	for _, trend := range trending.Places {
+		if trend.PlaceID == quiet.ID && (trend.Score > 1 || trend.RecentEvents != 1) {
+			t.Errorf("own visits scored %.2f with %d recent events, want them counted once", trend.Score, trend.RecentEvents)
+		}
+	}
+	if trending.Places[len(trending.Places)-1].PlaceID != quiet.ID {
+		t.Errorf("trending = %+v, want the place only the viewer visits last", trending.Places)
+	}
+}
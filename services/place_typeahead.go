@@ -0,0 +1,187 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ftrack/models"
+	"ftrack/utils"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	placeTypeaheadLimit = 10
+
+	// Entries are dropped on place mutations; the TTL picks up changes the
+	// service does not see, like circle membership changes
+	placeTypeaheadTTL = 5 * time.Minute
+)
+
+// placeTypeaheadCache keeps each user's accessible place names sorted by
+// lowercase name, so a prefix lookup is a binary search
+type placeTypeaheadCache struct {
+	entries map[string]*placeTypeaheadEntry
+	mutex   sync.RWMutex
+}
+
+type placeTypeaheadEntry struct {
+	names    []placeTypeaheadName
+	loadedAt time.Time
+}
+
+type placeTypeaheadName struct {
+	key   string
+	place models.Place
+}
+
+func newPlaceTypeaheadCache() *placeTypeaheadCache {
+	return &placeTypeaheadCache{
+		entries: make(map[string]*placeTypeaheadEntry),
+	}
+}
+
+func (tc *placeTypeaheadCache) get(userID string) ([]placeTypeaheadName, bool) {
+	tc.mutex.RLock()
+	defer tc.mutex.RUnlock()
+
+	entry, exists := tc.entries[userID]
+	if !exists || time.Since(entry.loadedAt) > placeTypeaheadTTL {
+		return nil, false
+	}
+	return entry.names, true
+}
+
+func (tc *placeTypeaheadCache) set(userID string, names []placeTypeaheadName) {
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	tc.entries[userID] = &placeTypeaheadEntry{
+		names:    names,
+		loadedAt: time.Now(),
+	}
+}
+
+func (tc *placeTypeaheadCache) invalidate(userIDs ...string) {
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	for _, userID := range userIDs {
+		delete(tc.entries, userID)
+	}
+}
+
+// TypeaheadPlaces returns up to 10 places whose name starts with the query,
+// closest first when coordinates are given and alphabetical otherwise
+func (ps *PlaceService) TypeaheadPlaces(ctx context.Context, userID string, req models.PlaceTypeaheadRequest) ([]models.PlaceTypeaheadResult, error) {
+	if validationErrors := ps.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	prefix := strings.ToLower(strings.TrimSpace(req.Query))
+	results := []models.PlaceTypeaheadResult{}
+	if prefix == "" {
+		return results, nil
+	}
+
+	names, err := ps.typeaheadNames(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	start := sort.Search(len(names), func(i int) bool {
+		return names[i].key >= prefix
+	})
+
+	hasLocation := req.Latitude != nil && req.Longitude != nil
+	for i := start; i < len(names) && strings.HasPrefix(names[i].key, prefix); i++ {
+		place := names[i].place
+		result := models.PlaceTypeaheadResult{
+			ID:       place.ID,
+			Name:     place.Name,
+			Category: place.Category,
+			Icon:     place.Icon,
+		}
+
+		if hasLocation {
+			distance := utils.CalculateDistance(*req.Latitude, *req.Longitude, place.Latitude, place.Longitude)
+			result.Distance = &distance
+		} else if len(results) == placeTypeaheadLimit {
+			break
+		}
+
+		results = append(results, result)
+	}
+
+	if hasLocation {
+		sort.SliceStable(results, func(i, j int) bool {
+			return *results[i].Distance < *results[j].Distance
+		})
+		if len(results) > placeTypeaheadLimit {
+			results = results[:placeTypeaheadLimit]
+		}
+	}
+
+	return results, nil
+}
+
+func (ps *PlaceService) typeaheadNames(ctx context.Context, userID string) ([]placeTypeaheadName, error) {
+	if names, ok := ps.typeahead.get(userID); ok {
+		return names, nil
+	}
+
+	circles, err := ps.circleRepo.GetUserCircles(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	circleIDs := make([]primitive.ObjectID, 0, len(circles))
+	for _, circle := range circles {
+		circleIDs = append(circleIDs, circle.ID)
+	}
+
+	places, err := ps.placeRepo.GetAccessiblePlaceNames(ctx, userID, circleIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]placeTypeaheadName, 0, len(places))
+	for _, place := range places {
+		names = append(names, placeTypeaheadName{
+			key:   strings.ToLower(place.Name),
+			place: place,
+		})
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return names[i].key < names[j].key
+	})
+
+	ps.typeahead.set(userID, names)
+	return names, nil
+}
+
+// invalidatePlaceTypeahead drops the cached names of everyone who can see
+// the place
+func (ps *PlaceService) invalidatePlaceTypeahead(ctx context.Context, place *models.Place) {
+	userIDs := []string{place.UserID.Hex()}
+	for _, member := range place.Sharing.SharedWith {
+		userIDs = append(userIDs, member.UserID.Hex())
+	}
+
+	if !place.CircleID.IsZero() {
+		circle, err := ps.circleRepo.GetByID(ctx, place.CircleID.Hex())
+		if err != nil {
+			logrus.Warnf("Failed to load circle %s for place typeahead invalidation: %v", place.CircleID.Hex(), err)
+		} else {
+			for _, member := range circle.Members {
+				userIDs = append(userIDs, member.UserID.Hex())
+			}
+		}
+	}
+
+	ps.typeahead.invalidate(userIDs...)
+}
@@ -0,0 +1,223 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+
+	"ftrack/models"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GetPlaceVersions returns the place's change history, newest first, to
+// anyone who can see the place
+func (ps *PlaceService) GetPlaceVersions(ctx context.Context, userID, placeID string, page, pageSize int) (*models.PlaceVersionsResponse, error) {
+	if _, err := ps.GetPlace(ctx, userID, placeID); err != nil {
+		return nil, err
+	}
+
+	page, pageSize = models.NormalizePage(page, pageSize)
+	versions, total, err := ps.placeRepo.GetPlaceVersions(ctx, placeID, page, pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.PlaceVersionsResponse{
+		Versions: versions,
+		Meta: models.PaginationMeta{
+			Page:       page,
+			PageSize:   pageSize,
+			Total:      total,
+			TotalPages: int((total + int64(pageSize) - 1) / int64(pageSize)),
+		},
+	}, nil
+}
+
+// RestorePlaceVersion puts the place's geometry and settings back as they
+// were after the version. It is checked like any other update, and recorded
+// as a new version.
+func (ps *PlaceService) RestorePlaceVersion(ctx context.Context, userID, placeID, versionID string) (*models.Place, error) {
+	place, err := ps.placeRepo.GetByID(ctx, placeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if place.UserID.Hex() != userID {
+		return nil, errors.New("access denied")
+	}
+
+	version, err := ps.placeRepo.GetPlaceVersion(ctx, placeID, versionID)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := version.Snapshot
+
+	if err := ps.validateGeofence(ctx, userID, snapshot.Latitude, snapshot.Longitude, snapshot.Radius); err != nil {
+		return nil, err
+	}
+	if err := ps.validatePlaceNotifications(snapshot.Notifications); err != nil {
+		return nil, err
+	}
+	if err := ps.validateNotifyMembers(ctx, placeCircleID(place), snapshot.Notifications.NotifyMembers); err != nil {
+		return nil, err
+	}
+	if err := validatePlaceSettingKeys(snapshot.InheritedSettings); err != nil {
+		return nil, err
+	}
+
+	err = ps.placeRepo.Update(ctx, placeID, map[string]interface{}{
+		"latitude":          snapshot.Latitude,
+		"longitude":         snapshot.Longitude,
+		"radius":            snapshot.Radius,
+		"geofence":          snapshot.Geofence,
+		"notifications":     snapshot.Notifications,
+		"hours":             snapshot.Hours,
+		"inheritedSettings": snapshot.InheritedSettings,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ps.invalidatePlaceTypeahead(ctx, place)
+
+	updated, err := ps.placeRepo.GetByID(ctx, placeID)
+	if err != nil {
+		return nil, err
+	}
+	ps.recordPlaceVersion(ctx, userID, place, updated, models.PlaceVersionRestore, &version.ID)
+
+	updated.Warnings = ps.geofenceWarnings(ctx, userID, updated.ID, updated.Latitude, updated.Longitude, updated.Radius)
+	return updated, nil
+}
+
+// recordPlaceVersion stores the change from before to after. The first
+// change to a place also stores how it was before, so that can be restored
+// too. Failures are logged; the change itself has already been made.
+func (ps *PlaceService) recordPlaceVersion(ctx context.Context, actorID string, before, after *models.Place, action string, restoredFrom *primitive.ObjectID) {
+	oldSnapshot := models.NewPlaceSnapshot(before)
+	newSnapshot := models.NewPlaceSnapshot(after)
+
+	changes, err := diffPlaceSnapshots(oldSnapshot, newSnapshot)
+	if err != nil {
+		logrus.Warnf("Failed to diff versions of place %s: %v", after.ID.Hex(), err)
+		return
+	}
+	// Restores are recorded even when nothing changed, so the history
+	// shows them
+	if len(changes) == 0 && action != models.PlaceVersionRestore {
+		return
+	}
+
+	hasVersions, err := ps.placeRepo.HasPlaceVersions(ctx, after.ID)
+	if err != nil {
+		logrus.Warnf("Failed to check versions of place %s: %v", after.ID.Hex(), err)
+		return
+	}
+	if !hasVersions {
+		baseline := &models.PlaceVersion{
+			PlaceID:   after.ID,
+			Action:    models.PlaceVersionBaseline,
+			Changes:   []models.PlaceFieldChange{},
+			Snapshot:  oldSnapshot,
+			CreatedAt: before.UpdatedAt,
+		}
+		if err := ps.placeRepo.CreatePlaceVersion(ctx, baseline); err != nil {
+			logrus.Warnf("Failed to record baseline version of place %s: %v", after.ID.Hex(), err)
+			return
+		}
+	}
+
+	version := &models.PlaceVersion{
+		PlaceID:      after.ID,
+		Action:       action,
+		RestoredFrom: restoredFrom,
+		Changes:      changes,
+		Snapshot:     newSnapshot,
+	}
+	if actorObjectID, err := primitive.ObjectIDFromHex(actorID); err == nil {
+		version.ActorID = &actorObjectID
+	}
+	if err := ps.placeRepo.CreatePlaceVersion(ctx, version); err != nil {
+		logrus.Warnf("Failed to record version of place %s: %v", after.ID.Hex(), err)
+		return
+	}
+
+	if _, err := ps.placeRepo.PrunePlaceVersions(ctx, after.ID, models.MaxPlaceVersions); err != nil {
+		logrus.Warnf("Failed to prune versions of place %s: %v", after.ID.Hex(), err)
+	}
+}
+
+// diffPlaceSnapshots lists the fields that differ between two snapshots,
+// naming nested settings by their path
+func diffPlaceSnapshots(oldSnapshot, newSnapshot models.PlaceSnapshot) ([]models.PlaceFieldChange, error) {
+	oldFields, err := flattenSnapshot(oldSnapshot)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := flattenSnapshot(newSnapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]bool, len(newFields))
+	for field := range oldFields {
+		fields[field] = true
+	}
+	for field := range newFields {
+		fields[field] = true
+	}
+
+	changes := []models.PlaceFieldChange{}
+	for field := range fields {
+		oldValue, newValue := oldFields[field], newFields[field]
+		if reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+		changes = append(changes, models.PlaceFieldChange{
+			Field:    field,
+			OldValue: oldValue,
+			NewValue: newValue,
+		})
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	return changes, nil
+}
+
+// flattenSnapshot maps the snapshot's fields by dotted path, the way they
+// are stored
+func flattenSnapshot(snapshot models.PlaceSnapshot) (map[string]interface{}, error) {
+	data, err := bson.Marshal(snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	var document bson.M
+	if err := bson.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]interface{})
+	flattenDocument("", document, fields)
+	return fields, nil
+}
+
+func flattenDocument(prefix string, document bson.M, fields map[string]interface{}) {
+	for key, value := range document {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+
+		if nested, ok := value.(bson.M); ok && len(nested) > 0 {
+			flattenDocument(path, nested, fields)
+			continue
+		}
+		fields[path] = value
+	}
+}
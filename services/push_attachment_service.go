@@ -0,0 +1,212 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"ftrack/models"
+	"ftrack/repositories"
+	"ftrack/utils"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+)
+
+// Push attachment URLs only need to outlive delivery of the notification
+const PushAttachmentURLTTL = 1 * time.Hour
+
+const (
+	staticMapCacheTTL   = 7 * 24 * time.Hour
+	staticMapMaxSize    = 2 * 1024 * 1024
+	staticMapWidth      = 600
+	staticMapHeight     = 300
+	staticMapZoom       = 15
+	staticMapFetchLimit = 10 * time.Second
+)
+
+// PushAttachmentPath returns the path an attachment is served from. It is
+// only reachable through a signed URL.
+func PushAttachmentPath(attachment models.NotificationAttachment) string {
+	return fmt.Sprintf("/api/v1/push/attachments/%s/%s", attachment.Kind, attachment.ResourceID)
+}
+
+// PushAttachment is an image served for a push notification. External
+// images are redirected to instead of proxied.
+type PushAttachment struct {
+	ContentType string `json:"contentType"`
+	Data        []byte `json:"data"`
+	RedirectURL string `json:"-"`
+}
+
+type PushAttachmentService struct {
+	userRepo     *repositories.UserRepository
+	placeRepo    *repositories.PlaceRepository
+	mediaRepo    *repositories.MediaRepository
+	mediaService *MediaService
+	redis        *redis.Client
+	httpClient   *http.Client
+
+	// Static map provider URL with {lat}, {lon}, {zoom}, {width} and
+	// {height} variables. Place maps are disabled when empty.
+	staticMapURL string
+}
+
+func NewPushAttachmentService(
+	userRepo *repositories.UserRepository,
+	placeRepo *repositories.PlaceRepository,
+	mediaRepo *repositories.MediaRepository,
+	mediaService *MediaService,
+	redis *redis.Client,
+	staticMapURL string,
+) *PushAttachmentService {
+	return &PushAttachmentService{
+		userRepo:     userRepo,
+		placeRepo:    placeRepo,
+		mediaRepo:    mediaRepo,
+		mediaService: mediaService,
+		redis:        redis,
+		httpClient:   &http.Client{Timeout: staticMapFetchLimit},
+		staticMapURL: staticMapURL,
+	}
+}
+
+// GetAttachment returns the image of an attachment. Callers must have
+// verified the signed URL it was requested with.
+func (pas *PushAttachmentService) GetAttachment(ctx context.Context, kind, resourceID string) (*PushAttachment, error) {
+	switch kind {
+	case models.AttachmentAvatar:
+		return pas.getAvatar(ctx, resourceID)
+	case models.AttachmentPlaceMap:
+		return pas.getPlaceMap(ctx, resourceID)
+	case models.AttachmentMediaThumbnail:
+		return pas.getMediaThumbnail(ctx, resourceID)
+	default:
+		return nil, errors.New("attachment not found")
+	}
+}
+
+func (pas *PushAttachmentService) getAvatar(ctx context.Context, userID string) (*PushAttachment, error) {
+	user, err := pas.userRepo.GetByID(ctx, userID)
+	if err != nil || user.ProfilePicture == "" {
+		return nil, errors.New("attachment not found")
+	}
+
+	if strings.HasPrefix(user.ProfilePicture, "http://") || strings.HasPrefix(user.ProfilePicture, "https://") {
+		return &PushAttachment{RedirectURL: user.ProfilePicture}, nil
+	}
+
+	return pas.readStoredImage(user.ProfilePicture)
+}
+
+func (pas *PushAttachmentService) getMediaThumbnail(ctx context.Context, mediaID string) (*PushAttachment, error) {
+	media, err := pas.mediaRepo.GetByID(ctx, mediaID)
+	if err != nil || media.IsDeleted || media.ThumbnailURL == "" {
+		return nil, errors.New("attachment not found")
+	}
+
+	return pas.readStoredImage(media.ThumbnailURL)
+}
+
+func (pas *PushAttachmentService) readStoredImage(url string) (*PushAttachment, error) {
+	if pas.mediaService == nil {
+		return nil, errors.New("attachment not found")
+	}
+
+	data, err := pas.mediaService.DownloadThumbnail(url)
+	if err != nil {
+		return nil, errors.New("attachment not found")
+	}
+
+	return &PushAttachment{
+		ContentType: http.DetectContentType(data),
+		Data:        data,
+	}, nil
+}
+
+// getPlaceMap returns a static map of the place. Maps are cached per place
+// and version, so moving the place renders a new one.
+func (pas *PushAttachmentService) getPlaceMap(ctx context.Context, placeID string) (*PushAttachment, error) {
+	if pas.staticMapURL == "" {
+		return nil, errors.New("attachment not found")
+	}
+
+	place, err := pas.placeRepo.GetByID(ctx, placeID)
+	if err != nil {
+		return nil, errors.New("attachment not found")
+	}
+
+	cacheKey := fmt.Sprintf("push:place_map:%s:%d", placeID, place.UpdatedAt.Unix())
+	if pas.redis != nil {
+		if cached, err := pas.redis.Get(ctx, cacheKey).Bytes(); err == nil {
+			var attachment PushAttachment
+			if err := json.Unmarshal(cached, &attachment); err == nil {
+				return &attachment, nil
+			}
+		}
+	}
+
+	attachment, err := pas.fetchStaticMap(ctx, place)
+	if err != nil {
+		logrus.Warnf("Failed to render map for place %s: %v", placeID, err)
+		return nil, errors.New("attachment not found")
+	}
+
+	if pas.redis != nil {
+		if encoded, err := json.Marshal(attachment); err == nil {
+			pas.redis.Set(ctx, cacheKey, encoded, staticMapCacheTTL)
+		}
+	}
+
+	return attachment, nil
+}
+
+func (pas *PushAttachmentService) fetchStaticMap(ctx context.Context, place *models.Place) (*PushAttachment, error) {
+	url := utils.RenderNotificationTemplate(pas.staticMapURL, map[string]string{
+		"lat":    strconv.FormatFloat(place.Latitude, 'f', 6, 64),
+		"lon":    strconv.FormatFloat(place.Longitude, 'f', 6, 64),
+		"zoom":   strconv.Itoa(staticMapZoom),
+		"width":  strconv.Itoa(staticMapWidth),
+		"height": strconv.Itoa(staticMapHeight),
+	})
+
+	var data []byte
+	err := utils.Providers.Do(ctx, utils.ProviderStaticMaps, func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return utils.NewPermanentProviderError(err)
+		}
+
+		resp, err := pas.httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return utils.ProviderStatusError(fmt.Errorf("static map provider returned %d", resp.StatusCode), resp.StatusCode)
+		}
+
+		data, err = io.ReadAll(io.LimitReader(resp.Body, staticMapMaxSize+1))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > staticMapMaxSize {
+		return nil, errors.New("static map too large")
+	}
+
+	contentType := http.DetectContentType(data)
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, fmt.Errorf("static map provider returned %s", contentType)
+	}
+
+	return &PushAttachment{ContentType: contentType, Data: data}, nil
+}
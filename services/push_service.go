@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"ftrack/models"
 	"ftrack/repositories"
+	"ftrack/utils"
 
 	"firebase.google.com/go/v4/messaging"
 	"github.com/sirupsen/logrus"
@@ -97,8 +98,9 @@ func (ps *PushService) buildFCMMessage(notification *models.Notification, device
 	}
 
 	// Add image if present
-	if notification.ImageURL != "" {
-		fcmNotification.ImageURL = notification.ImageURL
+	imageURL := ps.imageURL(notification)
+	if imageURL != "" {
+		fcmNotification.ImageURL = imageURL
 	}
 
 	// Build data payload
@@ -211,6 +213,24 @@ func (ps *PushService) buildFCMMessage(notification *models.Notification, device
 		iosConfig.Headers["apns-priority"] = "5"
 	}
 
+	// Notifications with a collapse key update the one already shown
+	// instead of stacking on it
+	if notification.CollapseKey != "" {
+		data["collapse_key"] = notification.CollapseKey
+		androidConfig.CollapseKey = notification.CollapseKey
+		androidConfig.Notification.Tag = notification.CollapseKey
+		iosConfig.Headers["apns-collapse-id"] = notification.CollapseKey
+	}
+
+	// Platforms without attachment support ignore the image fields. iOS
+	// needs a notification service extension to download the image.
+	if imageURL != "" {
+		data["attachment_url"] = imageURL
+		androidConfig.Notification.ImageURL = imageURL
+		iosConfig.Payload.Aps.MutableContent = true
+		iosConfig.FCMOptions = &messaging.APNSFCMOptions{ImageURL: imageURL}
+	}
+
 	// Build the final message
 	message := &messaging.Message{
 		Token:        device.DeviceToken,
@@ -223,6 +243,19 @@ func (ps *PushService) buildFCMMessage(notification *models.Notification, device
 	return message
 }
 
+// imageURL returns the image to show with a notification. Attachments get
+// a short-lived signed URL so the payload never holds a permanent link.
+func (ps *PushService) imageURL(notification *models.Notification) string {
+	if notification.ImageURL != "" {
+		return notification.ImageURL
+	}
+	if notification.Attachment == nil {
+		return ""
+	}
+
+	return utils.SignURL(PushAttachmentPath(*notification.Attachment), PushAttachmentURLTTL)
+}
+
 // sendFCMMessages sends the prepared FCM messages
 func (ps *PushService) sendFCMMessages(ctx context.Context, messages []*messaging.Message, notification *models.Notification) error {
 	if ps.fcmClient == nil {
@@ -231,7 +264,12 @@ func (ps *PushService) sendFCMMessages(ctx context.Context, messages []*messagin
 
 	// For single message
 	if len(messages) == 1 {
-		response, err := ps.fcmClient.Send(ctx, messages[0])
+		var response string
+		err := utils.Providers.Do(ctx, utils.ProviderPush, func(ctx context.Context) error {
+			var err error
+			response, err = ps.fcmClient.Send(ctx, messages[0])
+			return fcmError(err)
+		})
 		if err != nil {
 			logrus.Errorf("Failed to send FCM message: %v", err)
 			return fmt.Errorf("failed to send push notification: %w", err)
@@ -241,7 +279,12 @@ func (ps *PushService) sendFCMMessages(ctx context.Context, messages []*messagin
 	}
 
 	// For multiple messages
-	batchResponse, err := ps.fcmClient.SendAll(ctx, messages)
+	var batchResponse *messaging.BatchResponse
+	err := utils.Providers.Do(ctx, utils.ProviderPush, func(ctx context.Context) error {
+		var err error
+		batchResponse, err = ps.fcmClient.SendAll(ctx, messages)
+		return fcmError(err)
+	})
 	if err != nil {
 		logrus.Errorf("Failed to send FCM batch: %v", err)
 		return fmt.Errorf("failed to send push notifications: %w", err)
@@ -310,6 +353,41 @@ func (ps *PushService) SendTestNotification(ctx context.Context, userID string,
 	return ps.SendNotification(ctx, notification)
 }
 
+// SendSilentData sends a data-only push that wakes the app without showing
+// anything to the user. Quiet hours and notification settings don't apply.
+func (ps *PushService) SendSilentData(ctx context.Context, userID string, data map[string]string) error {
+	devices, err := ps.notificationRepo.GetUserPushDevices(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to get user devices: %w", err)
+	}
+
+	var messages []*messaging.Message
+	for _, device := range devices {
+		messages = append(messages, &messaging.Message{
+			Token: device.DeviceToken,
+			Data:  data,
+			Android: &messaging.AndroidConfig{
+				Priority: "high",
+			},
+			APNS: &messaging.APNSConfig{
+				Headers: map[string]string{
+					"apns-push-type": "background",
+					"apns-priority":  "5",
+				},
+				Payload: &messaging.APNSPayload{
+					Aps: &messaging.Aps{ContentAvailable: true},
+				},
+			},
+		})
+	}
+
+	if len(messages) == 0 {
+		return nil
+	}
+
+	return ps.sendFCMMessages(ctx, messages, nil)
+}
+
 // GetDeliveryStatus gets the delivery status of a notification
 func (ps *PushService) GetDeliveryStatus(ctx context.Context, notificationID string) (*models.DeliveryHistory, error) {
 	// This would require storing delivery attempts in the database
@@ -377,7 +455,10 @@ func (ps *PushService) UpdateBadgeCount(ctx context.Context, userID string, coun
 			}
 
 			if ps.fcmClient != nil {
-				_, err := ps.fcmClient.Send(ctx, message)
+				err := utils.Providers.Do(ctx, utils.ProviderPush, func(ctx context.Context) error {
+					_, err := ps.fcmClient.Send(ctx, message)
+					return fcmError(err)
+				})
 				if err != nil {
 					logrus.Errorf("Failed to update badge count for device %s: %v", device.ID.Hex(), err)
 				}
@@ -387,3 +468,15 @@ func (ps *PushService) UpdateBadgeCount(ctx context.Context, userID string, coun
 
 	return nil
 }
+
+// fcmError makes errors about the message or the device permanent, so they
+// aren't retried and don't count against FCM
+func fcmError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if messaging.IsInvalidArgument(err) || messaging.IsUnregistered(err) || messaging.IsSenderIDMismatch(err) {
+		return utils.NewPermanentProviderError(err)
+	}
+	return err
+}
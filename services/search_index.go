@@ -0,0 +1,355 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"ftrack/models"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	messageSearchIndexName = "message_text_search"
+
+	searchIndexHeartbeatInterval = 10 * time.Second
+
+	// A running build without a heartbeat for this long died with its instance
+	searchIndexBuildStaleAfter = 1 * time.Minute
+
+	// Searches recheck whether the text index is usable this often
+	textSearchCheckInterval = 5 * time.Second
+)
+
+// Fields the message text index covers. Changing them makes the status
+// report the index as outdated until it is rebuilt.
+var messageSearchIndexFields = []string{"content", "media.filename"}
+
+func messageSearchIndexModel() mongo.IndexModel {
+	keys := bson.D{}
+	for _, field := range messageSearchIndexFields {
+		keys = append(keys, bson.E{Key: field, Value: "text"})
+	}
+
+	return mongo.IndexModel{
+		Keys:    keys,
+		Options: options.Index().SetName(messageSearchIndexName),
+	}
+}
+
+// textIndexInfo is a text index on the messages collection
+type textIndexInfo struct {
+	Name    string   `bson:"name"`
+	Weights bson.M   `bson:"weights"`
+	Fields  []string `bson:"-"`
+}
+
+// StartSearchIndexRebuild drops and rebuilds the message text index in the
+// background. Searches scan content with a regex until it is done.
+func (ss *SearchService) StartSearchIndexRebuild(ctx context.Context, triggeredBy string) (*models.SearchIndexBuild, error) {
+	if err := ss.failStaleBuilds(ctx); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	build := &models.SearchIndexBuild{
+		ID:          primitive.NewObjectID(),
+		TriggeredBy: triggeredBy,
+		IndexName:   messageSearchIndexName,
+		Status:      models.ReconcileStatusRunning,
+		Phase:       models.SearchIndexPhaseDropping,
+		StartedAt:   now,
+		HeartbeatAt: now,
+	}
+
+	// Only one build can be running, enforced by a unique index on status
+	if _, err := ss.buildCollection.InsertOne(ctx, build); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, errors.New("search index rebuild already running")
+		}
+		return nil, err
+	}
+	ss.invalidateTextSearch()
+
+	snapshot := *build
+	go ss.executeRebuild(build)
+
+	return &snapshot, nil
+}
+
+func (ss *SearchService) executeRebuild(build *models.SearchIndexBuild) {
+	ctx := context.Background()
+	defer ss.invalidateTextSearch()
+
+	logrus.Infof("Starting search index rebuild %s", build.ID.Hex())
+
+	stop := make(chan struct{})
+	go ss.heartbeat(build.ID, stop)
+
+	err := ss.dropTextIndexes(ctx)
+	if err == nil {
+		ss.updateBuild(ctx, build.ID, bson.M{"phase": models.SearchIndexPhaseBuilding})
+		_, err = ss.messageCollection.Indexes().CreateOne(ctx, messageSearchIndexModel())
+	}
+	close(stop)
+
+	now := time.Now()
+	update := bson.M{
+		"status":      models.ReconcileStatusCompleted,
+		"heartbeatAt": now,
+		"completedAt": now,
+	}
+	if err != nil {
+		logrus.Errorf("Search index rebuild %s failed: %v", build.ID.Hex(), err)
+		update["status"] = models.ReconcileStatusFailed
+		update["errorMsg"] = err.Error()
+	}
+	ss.updateBuild(ctx, build.ID, update)
+
+	logrus.Infof("Search index rebuild %s %s", build.ID.Hex(), update["status"])
+}
+
+// heartbeat keeps the build marked alive and records the server's progress
+// until stop is closed
+func (ss *SearchService) heartbeat(buildID primitive.ObjectID, stop <-chan struct{}) {
+	ticker := time.NewTicker(searchIndexHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			update := bson.M{"heartbeatAt": time.Now()}
+			if done, total, ok := ss.indexBuildProgress(ctx); ok {
+				update["done"] = done
+				update["total"] = total
+			}
+			ss.updateBuild(ctx, buildID, update)
+			cancel()
+		}
+	}
+}
+
+// indexBuildProgress reads the progress of the index build on messages.
+// Reading other operations needs privileges the app may not have.
+func (ss *SearchService) indexBuildProgress(ctx context.Context) (int64, int64, bool) {
+	var result struct {
+		InProg []struct {
+			Progress struct {
+				Done  int64 `bson:"done"`
+				Total int64 `bson:"total"`
+			} `bson:"progress"`
+		} `bson:"inprog"`
+	}
+
+	command := bson.D{
+		{Key: "currentOp", Value: 1},
+		{Key: "command.createIndexes", Value: ss.messageCollection.Name()},
+	}
+	if err := ss.db.Client().Database("admin").RunCommand(ctx, command).Decode(&result); err != nil {
+		return 0, 0, false
+	}
+
+	for _, op := range result.InProg {
+		if op.Progress.Total > 0 {
+			return op.Progress.Done, op.Progress.Total, true
+		}
+	}
+	return 0, 0, false
+}
+
+func (ss *SearchService) dropTextIndexes(ctx context.Context) error {
+	indexes, err := ss.textIndexes(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, index := range indexes {
+		if _, err := ss.messageCollection.Indexes().DropOne(ctx, index.Name); err != nil {
+			return fmt.Errorf("drop index %s: %w", index.Name, err)
+		}
+	}
+	return nil
+}
+
+// textIndexes returns the text indexes on messages. A collection has at most
+// one, but older deployments may name it differently.
+func (ss *SearchService) textIndexes(ctx context.Context) ([]textIndexInfo, error) {
+	cursor, err := ss.messageCollection.Indexes().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var indexes []textIndexInfo
+	for cursor.Next(ctx) {
+		var index textIndexInfo
+		if err := cursor.Decode(&index); err != nil {
+			return nil, err
+		}
+		if len(index.Weights) == 0 {
+			continue
+		}
+
+		for field := range index.Weights {
+			index.Fields = append(index.Fields, field)
+		}
+		sort.Strings(index.Fields)
+		indexes = append(indexes, index)
+	}
+
+	return indexes, cursor.Err()
+}
+
+// failStaleBuilds marks builds whose instance stopped mid-build as failed,
+// so they don't block new builds or keep search on the fallback
+func (ss *SearchService) failStaleBuilds(ctx context.Context) error {
+	filter := bson.M{
+		"status":      models.ReconcileStatusRunning,
+		"heartbeatAt": bson.M{"$lt": time.Now().Add(-searchIndexBuildStaleAfter)},
+	}
+	update := bson.M{"$set": bson.M{
+		"status":   models.ReconcileStatusFailed,
+		"errorMsg": "build stopped responding",
+	}}
+
+	_, err := ss.buildCollection.UpdateMany(ctx, filter, update)
+	return err
+}
+
+func (ss *SearchService) updateBuild(ctx context.Context, buildID primitive.ObjectID, fields bson.M) {
+	_, err := ss.buildCollection.UpdateOne(ctx, bson.M{"_id": buildID}, bson.M{"$set": fields})
+	if err != nil {
+		logrus.Warnf("Failed to update search index build %s: %v", buildID.Hex(), err)
+	}
+}
+
+func (ss *SearchService) GetSearchIndexBuild(ctx context.Context, buildID string) (*models.SearchIndexBuild, error) {
+	objectID, err := primitive.ObjectIDFromHex(buildID)
+	if err != nil {
+		return nil, errors.New("invalid build ID")
+	}
+
+	var build models.SearchIndexBuild
+	err = ss.buildCollection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&build)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("build not found")
+		}
+		return nil, err
+	}
+
+	return &build, nil
+}
+
+// GetSearchIndexStatus reports the state of the message text index
+func (ss *SearchService) GetSearchIndexStatus(ctx context.Context) (*models.SearchIndexStatus, error) {
+	if err := ss.failStaleBuilds(ctx); err != nil {
+		return nil, err
+	}
+
+	expected := append([]string(nil), messageSearchIndexFields...)
+	sort.Strings(expected)
+
+	status := &models.SearchIndexStatus{
+		IndexName:      messageSearchIndexName,
+		IndexedFields:  []string{},
+		ExpectedFields: expected,
+		CheckedAt:      time.Now(),
+	}
+
+	indexes, err := ss.textIndexes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(indexes) > 0 {
+		status.Exists = true
+		status.IndexName = indexes[0].Name
+		status.IndexedFields = indexes[0].Fields
+		status.DefinitionCurrent = indexes[0].Name == messageSearchIndexName && equalStrings(indexes[0].Fields, expected)
+	}
+
+	var current models.SearchIndexBuild
+	err = ss.buildCollection.FindOne(ctx, bson.M{"status": models.ReconcileStatusRunning}).Decode(&current)
+	if err == nil {
+		status.Rebuilding = true
+		status.CurrentBuild = &current
+	} else if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	var last models.SearchIndexBuild
+	opts := options.FindOne().SetSort(bson.M{"completedAt": -1})
+	err = ss.buildCollection.FindOne(ctx, bson.M{"status": models.ReconcileStatusCompleted}, opts).Decode(&last)
+	if err == nil {
+		status.LastBuild = &last
+		status.LastBuiltAt = last.CompletedAt
+	} else if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	status.FallbackActive = status.Rebuilding || !status.Exists
+	return status, nil
+}
+
+// textSearchAvailable reports whether searches can use the text index. It
+// can't while the index is missing or being rebuilt.
+func (ss *SearchService) textSearchAvailable(ctx context.Context) bool {
+	ss.textSearchMutex.Lock()
+	defer ss.textSearchMutex.Unlock()
+
+	if time.Since(ss.textSearchCheckedAt) < textSearchCheckInterval {
+		return ss.textSearchUsable
+	}
+
+	usable := true
+	count, err := ss.buildCollection.CountDocuments(ctx, bson.M{
+		"status":      models.ReconcileStatusRunning,
+		"heartbeatAt": bson.M{"$gte": time.Now().Add(-searchIndexBuildStaleAfter)},
+	})
+	if err != nil {
+		logrus.Warnf("Failed to check search index builds: %v", err)
+	} else if count > 0 {
+		usable = false
+	}
+
+	if usable {
+		indexes, err := ss.textIndexes(ctx)
+		if err != nil {
+			logrus.Warnf("Failed to list search indexes: %v", err)
+		} else if len(indexes) == 0 {
+			usable = false
+		}
+	}
+
+	ss.textSearchUsable = usable
+	ss.textSearchCheckedAt = time.Now()
+	return usable
+}
+
+func (ss *SearchService) invalidateTextSearch() {
+	ss.textSearchMutex.Lock()
+	defer ss.textSearchMutex.Unlock()
+	ss.textSearchCheckedAt = time.Time{}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
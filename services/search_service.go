@@ -3,10 +3,13 @@ package services
 import (
 	"context"
 	"errors"
+	"ftrack/database"
 	"ftrack/models"
+	"ftrack/repositories"
 	"net/url"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -17,62 +20,28 @@ import (
 )
 
 type SearchService struct {
-	messageCollection *mongo.Collection
+	messageCollection *database.Collection
+	buildCollection   *database.Collection
 	db                *mongo.Database
+
+	// Whether searches can use the text index, checked every few seconds
+	textSearchMutex     sync.Mutex
+	textSearchUsable    bool
+	textSearchCheckedAt time.Time
 }
 
 func NewSearchService(db *mongo.Database) *SearchService {
 	return &SearchService{
-		messageCollection: db.Collection("messages"),
+		messageCollection: database.NewCollection(db, "messages"),
+		buildCollection:   database.NewCollection(db, "search_index_builds"),
 		db:                db,
 	}
 }
 
 func (ss *SearchService) SearchMessages(ctx context.Context, req models.SearchMessagesRequest, circleIDs []string) (*models.SearchResponse, error) {
-	circleObjectIDs := make([]primitive.ObjectID, len(circleIDs))
-	for i, id := range circleIDs {
-		objectID, err := primitive.ObjectIDFromHex(id)
-		if err != nil {
-			continue
-		}
-		circleObjectIDs[i] = objectID
-	}
-
-	filter := bson.M{
-		"circleId":  bson.M{"$in": circleObjectIDs},
-		"isDeleted": bson.M{"$ne": true},
-		"isHidden":  bson.M{"$ne": true},
-	}
-
-	// Add text search
-	if req.Query != "" {
-		filter["$text"] = bson.M{"$search": req.Query}
-	}
-
-	// Add message type filter
-	if req.MessageType != "" {
-		filter["type"] = req.MessageType
-	}
-
-	// Add date range filters
-	if req.DateFrom != "" || req.DateTo != "" {
-		dateFilter := bson.M{}
-
-		if req.DateFrom != "" {
-			if fromDate, err := time.Parse("2006-01-02", req.DateFrom); err == nil {
-				dateFilter["$gte"] = fromDate
-			}
-		}
-
-		if req.DateTo != "" {
-			if toDate, err := time.Parse("2006-01-02", req.DateTo); err == nil {
-				dateFilter["$lte"] = toDate.Add(24 * time.Hour) // End of day
-			}
-		}
-
-		if len(dateFilter) > 0 {
-			filter["createdAt"] = dateFilter
-		}
+	filter, useTextIndex, err := ss.messageSearchFilter(ctx, req, circleIDs)
+	if err != nil {
+		return nil, err
 	}
 
 	// Get total count
@@ -81,16 +50,20 @@ func (ss *SearchService) SearchMessages(ctx context.Context, req models.SearchMe
 		return nil, err
 	}
 
-	// Get messages
+	// Get messages. _id breaks ties so pages don't overlap.
 	skip := (req.Page - 1) * req.PageSize
 	opts := options.Find().
-		SetSort(bson.D{{"createdAt", -1}}).
+		SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}).
 		SetSkip(int64(skip)).
 		SetLimit(int64(req.PageSize))
 
 	// Add text search score sorting if searching
-	if req.Query != "" {
-		opts.SetSort(bson.D{{"score", bson.M{"$meta": "textScore"}}, {"createdAt", -1}})
+	if useTextIndex {
+		opts.SetSort(bson.D{
+			{Key: "score", Value: bson.M{"$meta": "textScore"}},
+			{Key: "createdAt", Value: -1},
+			{Key: "_id", Value: -1},
+		})
 	}
 
 	cursor, err := ss.messageCollection.Find(ctx, filter, opts)
@@ -104,6 +77,9 @@ func (ss *SearchService) SearchMessages(ctx context.Context, req models.SearchMe
 	if err != nil {
 		return nil, err
 	}
+	if err := repositories.DecryptMessages(messages); err != nil {
+		return nil, err
+	}
 
 	return &models.SearchResponse{
 		Messages:    messages,
@@ -116,6 +92,116 @@ func (ss *SearchService) SearchMessages(ctx context.Context, req models.SearchMe
 	}, nil
 }
 
+// MatchingMessages returns a batch of the messages matching the search,
+// oldest first, and how many match in all. Exports page through results
+// with it.
+func (ss *SearchService) MatchingMessages(ctx context.Context, req models.SearchMessagesRequest, circleIDs []string, skip, limit int) ([]models.Message, int64, error) {
+	filter, _, err := ss.messageSearchFilter(ctx, req, circleIDs)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total, err := ss.messageCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}).
+		SetSkip(int64(skip)).
+		SetLimit(int64(limit))
+
+	cursor, err := ss.messageCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var messages []models.Message
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, 0, err
+	}
+	if err := repositories.DecryptMessages(messages); err != nil {
+		return nil, 0, err
+	}
+
+	return messages, total, nil
+}
+
+// messageSearchFilter builds the filter of a message search over the
+// circles, and reports whether it uses the text index
+func (ss *SearchService) messageSearchFilter(ctx context.Context, req models.SearchMessagesRequest, circleIDs []string) (bson.M, bool, error) {
+	circleObjectIDs := make([]primitive.ObjectID, len(circleIDs))
+	for i, id := range circleIDs {
+		objectID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			continue
+		}
+		circleObjectIDs[i] = objectID
+	}
+
+	filter := bson.M{
+		"circleId":  bson.M{"$in": circleObjectIDs},
+		"isDeleted": bson.M{"$ne": true},
+		"isHidden":  bson.M{"$ne": true},
+	}
+
+	// Add text search, or scan content while the text index is unusable
+	useTextIndex := req.Query != "" && ss.textSearchAvailable(ctx)
+	if useTextIndex {
+		filter["$text"] = bson.M{"$search": req.Query}
+	} else if req.Query != "" {
+		filter["content"] = bson.M{"$regex": regexp.QuoteMeta(req.Query), "$options": "i"}
+	}
+	if req.Query != "" {
+		excludeEncryptedContent(filter)
+	}
+
+	// Add sender filter
+	if req.SenderID != "" {
+		senderObjectID, err := primitive.ObjectIDFromHex(req.SenderID)
+		if err != nil {
+			return nil, false, errors.New("invalid sender ID")
+		}
+		filter["senderId"] = senderObjectID
+	}
+
+	// Add message type and media type filters
+	if req.MessageType != "" {
+		filter["type"] = req.MessageType
+	}
+	if req.MediaType != "" {
+		filter["media.type"] = req.MediaType
+	}
+
+	// Add date range filters. DateTo is inclusive of the whole day.
+	if req.DateFrom != "" || req.DateTo != "" {
+		dateFilter := bson.M{}
+
+		if req.DateFrom != "" {
+			fromDate, err := time.Parse("2006-01-02", req.DateFrom)
+			if err != nil {
+				return nil, false, errors.New("invalid date range")
+			}
+			dateFilter["$gte"] = fromDate
+		}
+
+		if req.DateTo != "" {
+			toDate, err := time.Parse("2006-01-02", req.DateTo)
+			if err != nil {
+				return nil, false, errors.New("invalid date range")
+			}
+			dateFilter["$lt"] = toDate.Add(24 * time.Hour)
+		}
+
+		filter["createdAt"] = dateFilter
+	}
+
+	excludeSenders(filter, req.ExcludeSenderIDs)
+
+	return filter, useTextIndex, nil
+}
+
 func (ss *SearchService) SearchInCircle(ctx context.Context, req models.SearchInCircleRequest) (*models.SearchResponse, error) {
 	circleObjectID, err := primitive.ObjectIDFromHex(req.CircleID)
 	if err != nil {
@@ -128,10 +214,19 @@ func (ss *SearchService) SearchInCircle(ctx context.Context, req models.SearchIn
 		"isHidden":  bson.M{"$ne": true},
 	}
 
-	// Add text search
-	if req.Query != "" {
+	// Add text search, or scan the circle's content while the text index
+	// is unusable
+	useTextIndex := req.Query != "" && ss.textSearchAvailable(ctx)
+	if useTextIndex {
 		filter["$text"] = bson.M{"$search": req.Query}
+	} else if req.Query != "" {
+		filter["content"] = bson.M{"$regex": regexp.QuoteMeta(req.Query), "$options": "i"}
 	}
+	if req.Query != "" {
+		excludeEncryptedContent(filter)
+	}
+
+	excludeSenders(filter, req.ExcludeSenderIDs)
 
 	// Get total count
 	total, err := ss.messageCollection.CountDocuments(ctx, filter)
@@ -147,7 +242,7 @@ func (ss *SearchService) SearchInCircle(ctx context.Context, req models.SearchIn
 		SetLimit(int64(req.PageSize))
 
 	// Add text search score sorting if searching
-	if req.Query != "" {
+	if useTextIndex {
 		opts.SetSort(bson.D{{Key: "score", Value: bson.M{"$meta": "textScore"}}, {Key: "createdAt", Value: -1}})
 	}
 
@@ -162,6 +257,9 @@ func (ss *SearchService) SearchInCircle(ctx context.Context, req models.SearchIn
 	if err != nil {
 		return nil, err
 	}
+	if err := repositories.DecryptMessages(messages); err != nil {
+		return nil, err
+	}
 
 	return &models.SearchResponse{
 		Messages:    messages,
@@ -226,6 +324,9 @@ func (ss *SearchService) SearchMedia(ctx context.Context, req models.SearchMedia
 	if err != nil {
 		return nil, err
 	}
+	if err := repositories.DecryptMessages(messages); err != nil {
+		return nil, err
+	}
 
 	// Convert to media response
 	media := make([]models.MessageMediaExtended, len(messages))
@@ -266,7 +367,7 @@ func (ss *SearchService) SearchMentions(ctx context.Context, userID string, req
 	}
 
 	// Get user info for additional mention patterns
-	userCollection := ss.db.Collection("users")
+	userCollection := database.NewCollection(ss.db, "users")
 	var user models.User
 	userObjectID, _ := primitive.ObjectIDFromHex(userID)
 	err := userCollection.FindOne(ctx, bson.M{"_id": userObjectID}).Decode(&user)
@@ -294,6 +395,7 @@ func (ss *SearchService) SearchMentions(ctx context.Context, userID string, req
 		orConditions[i] = bson.M{"content": bson.M{"$regex": regexp.QuoteMeta(pattern), "$options": "i"}}
 	}
 	filter["$or"] = orConditions
+	excludeEncryptedContent(filter)
 
 	if req.CircleID != "" {
 		circleObjectID, err := primitive.ObjectIDFromHex(req.CircleID)
@@ -302,6 +404,8 @@ func (ss *SearchService) SearchMentions(ctx context.Context, userID string, req
 		}
 	}
 
+	excludeSenders(filter, req.ExcludeSenderIDs)
+
 	// Get total count
 	total, err := ss.messageCollection.CountDocuments(ctx, filter)
 	if err != nil {
@@ -326,6 +430,9 @@ func (ss *SearchService) SearchMentions(ctx context.Context, userID string, req
 	if err != nil {
 		return nil, err
 	}
+	if err := repositories.DecryptMessages(messages); err != nil {
+		return nil, err
+	}
 
 	return &models.SearchResponse{
 		Messages:    messages,
@@ -370,6 +477,7 @@ func (ss *SearchService) SearchLinks(ctx context.Context, req models.SearchLinks
 		domainPattern := strings.Replace(req.Domain, ".", `\.`, -1)
 		filter["content"] = bson.M{"$regex": `https?://[^/]*` + domainPattern, "$options": "i"}
 	}
+	excludeEncryptedContent(filter)
 
 	// Get total count
 	total, err := ss.messageCollection.CountDocuments(ctx, filter)
@@ -395,6 +503,9 @@ func (ss *SearchService) SearchLinks(ctx context.Context, req models.SearchLinks
 	if err != nil {
 		return nil, err
 	}
+	if err := repositories.DecryptMessages(messages); err != nil {
+		return nil, err
+	}
 
 	// Extract links from messages
 	links := ss.extractLinksFromMessages(messages)
@@ -474,6 +585,9 @@ func (ss *SearchService) SearchFiles(ctx context.Context, req models.SearchFiles
 	if err != nil {
 		return nil, err
 	}
+	if err := repositories.DecryptMessages(messages); err != nil {
+		return nil, err
+	}
 
 	// Convert to file info
 	files := make([]models.FileInfo, len(messages))
@@ -501,6 +615,38 @@ func (ss *SearchService) SearchFiles(ctx context.Context, req models.SearchFiles
 	}, nil
 }
 
+// excludeEncryptedContent leaves out messages of circles that encrypt
+// content at rest. Their ciphertext can't be matched against, so content
+// searches don't cover those circles.
+func excludeEncryptedContent(filter bson.M) {
+	filter["contentKeyId"] = bson.M{"$exists": false}
+}
+
+// excludeSenders drops messages from the given senders, keeping any
+// sender condition already on the filter
+func excludeSenders(filter bson.M, senderIDs []string) {
+	if len(senderIDs) == 0 {
+		return
+	}
+
+	senderObjectIDs := make([]primitive.ObjectID, 0, len(senderIDs))
+	for _, id := range senderIDs {
+		if objectID, err := primitive.ObjectIDFromHex(id); err == nil {
+			senderObjectIDs = append(senderObjectIDs, objectID)
+		}
+	}
+
+	condition, ok := filter["senderId"].(bson.M)
+	if !ok {
+		condition = bson.M{}
+		if existing, exists := filter["senderId"]; exists {
+			condition["$eq"] = existing
+		}
+	}
+	condition["$nin"] = senderObjectIDs
+	filter["senderId"] = condition
+}
+
 func (ss *SearchService) extractLinksFromMessages(messages []models.Message) []models.LinkInfo {
 	var links []models.LinkInfo
 	urlRegex := regexp.MustCompile(`https?://[^\s]+`)
@@ -552,15 +698,7 @@ func (ss *SearchService) extractTitleFromURL(urlStr string) string {
 
 func (ss *SearchService) CreateSearchIndexes(ctx context.Context) error {
 	// Create text search index on message content
-	indexModel := mongo.IndexModel{
-		Keys: bson.D{
-			{"content", "text"},
-			{"media.filename", "text"},
-		},
-		Options: options.Index().SetName("message_text_search"),
-	}
-
-	_, err := ss.messageCollection.Indexes().CreateOne(ctx, indexModel)
+	_, err := ss.messageCollection.Indexes().CreateOne(ctx, messageSearchIndexModel())
 	if err != nil {
 		logrus.Errorf("Failed to create text search index: %v", err)
 		return err
@@ -584,6 +722,19 @@ func (ss *SearchService) CreateSearchIndexes(ctx context.Context) error {
 			Keys:    bson.D{{"media.type", 1}, {"createdAt", -1}},
 			Options: options.Index().SetName("media_type_created_idx"),
 		},
+		// Support filtered search, which is always scoped by circle
+		{
+			Keys:    bson.D{{"circleId", 1}, {"senderId", 1}, {"createdAt", -1}},
+			Options: options.Index().SetName("circle_sender_created_idx"),
+		},
+		{
+			Keys:    bson.D{{"circleId", 1}, {"type", 1}, {"createdAt", -1}},
+			Options: options.Index().SetName("circle_type_created_idx"),
+		},
+		{
+			Keys:    bson.D{{"circleId", 1}, {"media.type", 1}, {"createdAt", -1}},
+			Options: options.Index().SetName("circle_media_type_created_idx"),
+		},
 	}
 
 	_, err = ss.messageCollection.Indexes().CreateMany(ctx, indexes)
@@ -613,10 +764,11 @@ func (ss *SearchService) GetSearchSuggestions(ctx context.Context, query string,
 	// Use aggregation to get common terms that start with the query
 	pipeline := mongo.Pipeline{
 		{{"$match", bson.M{
-			"circleId":  bson.M{"$in": circleObjectIDs},
-			"content":   bson.M{"$regex": "^" + regexp.QuoteMeta(query), "$options": "i"},
-			"isDeleted": bson.M{"$ne": true},
-			"isHidden":  bson.M{"$ne": true},
+			"circleId":     bson.M{"$in": circleObjectIDs},
+			"content":      bson.M{"$regex": "^" + regexp.QuoteMeta(query), "$options": "i"},
+			"contentKeyId": bson.M{"$exists": false},
+			"isDeleted":    bson.M{"$ne": true},
+			"isHidden":     bson.M{"$ne": true},
 		}}},
 		{{"$project", bson.M{
 			"words": bson.M{"$split": []interface{}{"$content", " "}},
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"ftrack/models"
+	"ftrack/testharness"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestSearchMessagesCombinedFilters(t *testing.T) {
+	t.Parallel()
+	env := testharness.New(t)
+	ss := NewSearchService(env.DB)
+	ctx := context.Background()
+
+	dad, mom, stranger := env.Factory.User(), env.Factory.User(), env.Factory.User()
+	family := env.Factory.Circle(dad, []*models.User{mom})
+	other := env.Factory.Circle(stranger, []*models.User{dad})
+
+	day := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d.Add(12 * time.Hour)
+	}
+	post := func(circle *models.Circle, sender *models.User, messageType, mediaType, content string, at time.Time) *models.Message {
+		t.Helper()
+		message := &models.Message{
+			CircleID: circle.ID,
+			SenderID: sender.ID,
+			Type:     messageType,
+			Content:  content,
+		}
+		if mediaType != "" {
+			message.Media = models.MessageMedia{Type: mediaType, URL: "/media/" + content}
+		}
+		if err := env.Repos.Message.Create(ctx, message); err != nil {
+			t.Fatalf("creating message: %v", err)
+		}
+		if _, err := env.DB.Collection("messages").UpdateOne(ctx, bson.M{"_id": message.ID}, bson.M{"$set": bson.M{"createdAt": at}}); err != nil {
+			t.Fatalf("dating message: %v", err)
+		}
+		return message
+	}
+
+	beach := post(family, dad, "photo", "image", "beach day", day("2026-09-05"))
+	park := post(family, dad, "photo", "image", "park", day("2026-09-20"))
+	lastDay := post(family, dad, "photo", "image", "beach sunset", day("2026-09-30"))
+	post(family, dad, "photo", "image", "beach before", day("2026-08-31"))
+	post(family, dad, "photo", "image", "beach after", day("2026-10-01"))
+	post(family, dad, "video", "video", "beach clip", day("2026-09-12"))
+	post(family, dad, "text", "", "beach plans", day("2026-09-12"))
+	post(family, mom, "photo", "image", "beach by mom", day("2026-09-12"))
+	// Dad's photo in a circle the searcher isn't in
+	post(other, dad, "photo", "image", "beach elsewhere", day("2026-09-12"))
+
+	base := models.SearchMessagesRequest{
+		SenderID:    dad.ID.Hex(),
+		MessageType: "photo",
+		MediaType:   "image",
+		DateFrom:    "2026-09-01",
+		DateTo:      "2026-09-30",
+		Page:        1,
+		PageSize:    2,
+	}
+	circleIDs := []string{family.ID.Hex()}
+
+	first, err := ss.SearchMessages(ctx, base, circleIDs)
+	if err != nil {
+		t.Fatalf("SearchMessages: %v", err)
+	}
+	if first.Total != 3 || !first.HasNext || first.HasPrevious {
+		t.Errorf("first page total = %d, hasNext %v, hasPrevious %v; want 3, true, false", first.Total, first.HasNext, first.HasPrevious)
+	}
+	expectMessageIDs(t, "first page", first.Messages, lastDay, park)
+
+	second := base
+	second.Page = 2
+	page, err := ss.SearchMessages(ctx, second, circleIDs)
+	if err != nil {
+		t.Fatalf("SearchMessages page 2: %v", err)
+	}
+	if page.Total != 3 || page.HasNext || !page.HasPrevious {
+		t.Errorf("second page total = %d, hasNext %v, hasPrevious %v; want 3, false, true", page.Total, page.HasNext, page.HasPrevious)
+	}
+	expectMessageIDs(t, "second page", page.Messages, beach)
+
+	withText := base
+	withText.Query = "beach"
+	withText.PageSize = 10
+	matched, err := ss.SearchMessages(ctx, withText, circleIDs)
+	if err != nil {
+		t.Fatalf("SearchMessages with text: %v", err)
+	}
+	if matched.Total != 2 {
+		t.Errorf("text and filters total = %d, want 2", matched.Total)
+	}
+	ids := map[string]bool{}
+	for _, message := range matched.Messages {
+		ids[message.ID.Hex()] = true
+	}
+	if len(ids) != 2 || !ids[beach.ID.Hex()] || !ids[lastDay.ID.Hex()] {
+		t.Errorf("text and filters matched %v, want the two September beach photos", ids)
+	}
+
+	invalid := base
+	invalid.SenderID = "not-an-id"
+	if _, err := ss.SearchMessages(ctx, invalid, circleIDs); err == nil || err.Error() != "invalid sender ID" {
+		t.Errorf("invalid sender error = %v, want invalid sender ID", err)
+	}
+	invalid = base
+	invalid.DateTo = "30/09/2026"
+	if _, err := ss.SearchMessages(ctx, invalid, circleIDs); err == nil || err.Error() != "invalid date range" {
+		t.Errorf("invalid date error = %v, want invalid date range", err)
+	}
+}
+
+// expectMessageIDs checks the messages are the wanted ones, in order
+func expectMessageIDs(t *testing.T, name string, got []models.Message, want ...*models.Message) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s has %d messages, want %d", name, len(got), len(want))
+		return
+	}
+	for i := range want {
+		if got[i].ID != want[i].ID {
+			t.Errorf("%s message %d = %q, want %q", name, i, got[i].Content, want[i].Content)
+		}
+	}
+}
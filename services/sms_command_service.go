@@ -0,0 +1,626 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	"ftrack/models"
+	"ftrack/repositories"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// Commands a number can text before further texts are dropped. SOS
+	// from an opted-in number is never dropped.
+	smsCommandRateLimit  = 10
+	smsCommandRateWindow = 15 * time.Minute
+
+	smsOptInCodeTTL      = 10 * time.Minute
+	maxSMSOptInAttempts  = 5
+	smsCommandPlaceLimit = 100
+)
+
+const (
+	smsReplyNotLinked   = "This number isn't set up for Family Tracker commands. Verify it in the app under SMS settings, then turn on SMS commands."
+	smsReplyNotOptedIn  = "SMS commands are off for this number. Turn them on in the app and text START with the code it shows."
+	smsReplyHelp        = "Commands: WHERE <name>, CHECKIN <place>, SOS. Text STOP to turn commands off."
+	smsReplyRateLimited = "Too many texts. Try again in 15 minutes."
+	smsReplyFailed      = "Sorry, something went wrong. Try again in a few minutes."
+)
+
+// SMSCommandService answers commands texted to the Twilio number, for
+// members without a smartphone. A number has to be verified and opted in
+// from the app before it gets any data back, and every text is recorded in
+// the sender's audit log.
+type SMSCommandService struct {
+	smsService       *SMSService
+	notificationRepo *repositories.NotificationRepository
+	userRepo         *repositories.UserRepository
+	circleRepo       *repositories.CircleRepository
+	locationRepo     *repositories.LocationRepository
+	auditLogRepo     *repositories.AuditLogRepository
+	locationService  *LocationService
+	placeService     *PlaceService
+	emergencyService *EmergencyService
+	redis            *redis.Client
+	webhookURL       string
+}
+
+func NewSMSCommandService(
+	smsService *SMSService,
+	notificationRepo *repositories.NotificationRepository,
+	userRepo *repositories.UserRepository,
+	circleRepo *repositories.CircleRepository,
+	locationRepo *repositories.LocationRepository,
+	auditLogRepo *repositories.AuditLogRepository,
+	locationService *LocationService,
+	placeService *PlaceService,
+	emergencyService *EmergencyService,
+	redis *redis.Client,
+	webhookURL string,
+) *SMSCommandService {
+	return &SMSCommandService{
+		smsService:       smsService,
+		notificationRepo: notificationRepo,
+		userRepo:         userRepo,
+		circleRepo:       circleRepo,
+		locationRepo:     locationRepo,
+		auditLogRepo:     auditLogRepo,
+		locationService:  locationService,
+		placeService:     placeService,
+		emergencyService: emergencyService,
+		redis:            redis,
+		webhookURL:       webhookURL,
+	}
+}
+
+// VerifyWebhook reports whether an inbound SMS request was signed by Twilio
+func (scs *SMSCommandService) VerifyWebhook(params url.Values, signature string) bool {
+	return scs.smsService.ValidateWebhookSignature(scs.webhookURL, params, signature)
+}
+
+// StartOptIn hands out the code the user texts from their verified number
+// to turn SMS commands on
+func (scs *SMSCommandService) StartOptIn(ctx context.Context, userID string) (*models.SMSCommandOptIn, error) {
+	settings, err := scs.verifiedSettings(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if scs.redis == nil {
+		return nil, errors.New("SMS commands not available")
+	}
+
+	code := generateSMSOptInCode()
+	if err := scs.redis.Set(ctx, smsOptInCodeKey(userID), code, smsOptInCodeTTL).Err(); err != nil {
+		return nil, err
+	}
+	scs.redis.Del(ctx, smsOptInAttemptsKey(userID))
+
+	scs.audit(ctx, userID, settings.PhoneNumber, "", "sms_commands_opt_in_started", "SMS command opt-in started", "info", nil)
+
+	sendTo := scs.smsService.PhoneNumber()
+	return &models.SMSCommandOptIn{
+		PhoneNumber:  settings.PhoneNumber,
+		SendTo:       sendTo,
+		Code:         code,
+		ExpiresAt:    time.Now().Add(smsOptInCodeTTL),
+		Instructions: fmt.Sprintf("From %s, text START %s to %s", settings.PhoneNumber, code, sendTo),
+	}, nil
+}
+
+// DisableCommands turns SMS commands off; texts get no data until the user
+// opts in again
+func (scs *SMSCommandService) DisableCommands(ctx context.Context, userID string) error {
+	settings, err := scs.notificationRepo.GetSMSSettings(ctx, userID)
+	if err != nil {
+		if err.Error() == "not found" {
+			return nil
+		}
+		return err
+	}
+
+	if scs.redis != nil {
+		scs.redis.Del(ctx, smsOptInCodeKey(userID))
+	}
+	if err := scs.notificationRepo.SetSMSCommandsEnabled(ctx, userID, false); err != nil {
+		return err
+	}
+
+	scs.audit(ctx, userID, settings.PhoneNumber, "", "sms_commands_disabled", "SMS commands turned off in the app", "info", nil)
+	return nil
+}
+
+func (scs *SMSCommandService) GetStatus(ctx context.Context, userID string) (*models.SMSCommandStatus, error) {
+	settings, err := scs.notificationRepo.GetSMSSettings(ctx, userID)
+	if err != nil {
+		if err.Error() == "not found" {
+			return &models.SMSCommandStatus{}, nil
+		}
+		return nil, err
+	}
+
+	status := &models.SMSCommandStatus{
+		PhoneNumber:   settings.PhoneNumber,
+		PhoneVerified: settings.IsVerified,
+		Enabled:       settings.IsVerified && settings.CommandsEnabled,
+		OptedInAt:     settings.CommandsOptedInAt,
+	}
+	if scs.redis != nil && !status.Enabled {
+		pending, err := scs.redis.Exists(ctx, smsOptInCodeKey(userID)).Result()
+		status.PendingOptIn = err == nil && pending > 0
+	}
+
+	return status, nil
+}
+
+// HandleInbound runs a texted command and returns the reply, or "" to send
+// none
+func (scs *SMSCommandService) HandleInbound(ctx context.Context, sms models.InboundSMS) string {
+	from := strings.TrimSpace(sms.From)
+	command, understood := parseSMSCommand(sms.Body)
+
+	// Count every text, before looking the number up, so an unknown number
+	// can't hammer the database
+	withinLimit, firstOverLimit := scs.allowNumber(ctx, from)
+	if !withinLimit && command.Name != models.SMSCommandSOS {
+		scs.audit(ctx, "", from, sms.MessageSID, "sms_command_rate_limited", "SMS command dropped by rate limit", "warning", command)
+		if firstOverLimit {
+			return smsReplyRateLimited
+		}
+		return ""
+	}
+
+	settings, err := scs.notificationRepo.GetVerifiedSMSSettingsByPhone(ctx, from)
+	if err != nil {
+		if err.Error() != "not found" {
+			logrus.Errorf("Failed to look up SMS sender %s: %v", from, err)
+			return smsReplyFailed
+		}
+		scs.audit(ctx, "", from, sms.MessageSID, "sms_command_rejected", "SMS command from an unverified number", "warning", command)
+		if !withinLimit {
+			return ""
+		}
+		return smsReplyNotLinked
+	}
+
+	userID := settings.UserID
+	scs.smsService.updateUsageTracking(ctx, userID)
+
+	var reply, outcome string
+	switch {
+	case !understood:
+		reply, outcome = unknownSMSCommandReply(command.Argument), "not understood"
+	case command.Name == models.SMSCommandStop:
+		reply, outcome = scs.handleStop(ctx, settings)
+	case command.Name == models.SMSCommandStart:
+		reply, outcome = scs.handleStart(ctx, settings, command.Argument)
+	case command.Name == models.SMSCommandHelp:
+		reply, outcome = smsReplyHelp, "answered"
+	case !settings.CommandsEnabled:
+		reply, outcome = smsReplyNotOptedIn, "not opted in"
+	case command.Name == models.SMSCommandWhere:
+		reply, outcome = scs.handleWhere(ctx, userID, command.Argument)
+	case command.Name == models.SMSCommandCheckin:
+		reply, outcome = scs.handleCheckin(ctx, userID, command.Argument)
+	case command.Name == models.SMSCommandSOS:
+		reply, outcome = scs.handleSOS(ctx, userID, command.Argument)
+	}
+
+	severity := "info"
+	if command.Name == models.SMSCommandSOS {
+		severity = "critical"
+	}
+	scs.audit(ctx, userID, from, sms.MessageSID, "sms_command", fmt.Sprintf("SMS command %s: %s", displaySMSCommand(command), outcome), severity, command)
+
+	if reply == "" {
+		return ""
+	}
+	if err := scs.smsService.checkUsageLimits(ctx, userID, settings); err != nil {
+		logrus.Warnf("Not replying to SMS command from user %s: %v", userID, err)
+		return ""
+	}
+	scs.smsService.updateUsageTracking(ctx, userID)
+
+	return reply
+}
+
+func (scs *SMSCommandService) handleStart(ctx context.Context, settings *models.SMSSettings, code string) (string, string) {
+	if settings.CommandsEnabled {
+		return "SMS commands are already on. " + smsReplyHelp, "already opted in"
+	}
+	if scs.redis == nil {
+		return smsReplyFailed, "opt-in unavailable"
+	}
+
+	userID := settings.UserID
+	expected, err := scs.redis.Get(ctx, smsOptInCodeKey(userID)).Result()
+	if err != nil && err != redis.Nil {
+		logrus.Errorf("Failed to get SMS opt-in code of user %s: %v", userID, err)
+		return smsReplyFailed, "failed"
+	}
+
+	code = strings.TrimSpace(code)
+	if expected == "" || subtle.ConstantTimeCompare([]byte(code), []byte(expected)) != 1 {
+		// A handful of guesses, then the code has to be fetched again
+		attempts, _ := scs.redis.Incr(ctx, smsOptInAttemptsKey(userID)).Result()
+		scs.redis.Expire(ctx, smsOptInAttemptsKey(userID), smsOptInCodeTTL)
+		if attempts >= maxSMSOptInAttempts {
+			scs.redis.Del(ctx, smsOptInCodeKey(userID))
+		}
+		return "That code didn't match. Get a new one in the app under SMS commands, then text START and the code.", "wrong opt-in code"
+	}
+
+	if err := scs.notificationRepo.SetSMSCommandsEnabled(ctx, userID, true); err != nil {
+		logrus.Errorf("Failed to turn on SMS commands for user %s: %v", userID, err)
+		return smsReplyFailed, "failed"
+	}
+	scs.redis.Del(ctx, smsOptInCodeKey(userID), smsOptInAttemptsKey(userID))
+
+	return "SMS commands are on. " + smsReplyHelp, "opted in"
+}
+
+func (scs *SMSCommandService) handleStop(ctx context.Context, settings *models.SMSSettings) (string, string) {
+	if !settings.CommandsEnabled {
+		return "SMS commands are already off.", "already opted out"
+	}
+
+	if err := scs.notificationRepo.SetSMSCommandsEnabled(ctx, settings.UserID, false); err != nil {
+		logrus.Errorf("Failed to turn off SMS commands for user %s: %v", settings.UserID, err)
+		return smsReplyFailed, "failed"
+	}
+
+	return "SMS commands are off. Turn them back on in the app.", "opted out"
+}
+
+func (scs *SMSCommandService) handleWhere(ctx context.Context, userID, name string) (string, string) {
+	if name == "" {
+		return "Who are you looking for? Text WHERE and a name, like WHERE Alice", "missing name"
+	}
+
+	matches, err := scs.findCircleMates(ctx, userID, name)
+	if err != nil {
+		logrus.Errorf("Failed to find circle mates of user %s: %v", userID, err)
+		return smsReplyFailed, "failed"
+	}
+
+	switch len(matches) {
+	case 0:
+		return fmt.Sprintf("No one called %s in your circles. Text WHERE and a first name, like WHERE Alice", name), "no match"
+	case 1:
+	default:
+		names := make([]string, 0, len(matches))
+		for _, match := range matches {
+			names = append(names, strings.TrimSpace(match.FirstName+" "+match.LastName))
+		}
+		return fmt.Sprintf("More than one %s: %s. Text WHERE and their full name.", name, strings.Join(names, ", ")), "ambiguous name"
+	}
+
+	target := matches[0]
+	whereabouts, err := scs.locationService.GetWhereabouts(ctx, userID, target.ID.Hex())
+	if err != nil {
+		switch err.Error() {
+		case "access denied", "location sharing paused":
+			return fmt.Sprintf("%s isn't sharing their location with you right now.", target.FirstName), "not shared"
+		case "location not found":
+			return fmt.Sprintf("%s's location isn't known yet.", target.FirstName), "no location"
+		default:
+			logrus.Errorf("Failed to get whereabouts of user %s: %v", target.ID.Hex(), err)
+			return smsReplyFailed, "failed"
+		}
+	}
+
+	age := smsAge(time.Since(whereabouts.RecordedAt))
+	switch {
+	case whereabouts.PlaceName != "":
+		return fmt.Sprintf("%s is at %s (%s).", whereabouts.FirstName, whereabouts.PlaceName, age), "answered"
+	case whereabouts.Area != "":
+		return fmt.Sprintf("%s is around %s (%s).", whereabouts.FirstName, whereabouts.Area, age), "answered"
+	default:
+		return fmt.Sprintf("%s was last seen %s, away from any known place.", whereabouts.FirstName, age), "answered"
+	}
+}
+
+func (scs *SMSCommandService) handleCheckin(ctx context.Context, userID, placeName string) (string, string) {
+	if placeName == "" {
+		return "Where are you? Text CHECKIN and one of your places, like CHECKIN Home", "missing place"
+	}
+
+	places, err := scs.placeService.GetUserPlaces(ctx, userID, models.GetPlacesRequest{PageSize: smsCommandPlaceLimit})
+	if err != nil {
+		logrus.Errorf("Failed to get places of user %s: %v", userID, err)
+		return smsReplyFailed, "failed"
+	}
+
+	place := matchPlaceName(places.Places, placeName)
+	if place == nil {
+		names := make([]string, 0, 3)
+		for i := 0; i < len(places.Places) && i < 3; i++ {
+			names = append(names, places.Places[i].Place.Name)
+		}
+		if len(names) == 0 {
+			return "You don't have any places yet. Add one in the app first.", "no places"
+		}
+		return fmt.Sprintf("You have no place called %s. Try one of: %s", placeName, strings.Join(names, ", ")), "no match"
+	}
+
+	_, err = scs.placeService.CheckIn(ctx, userID, place.ID.Hex(), models.CheckInToPlaceRequest{
+		Message: "Checked in by text message",
+		Location: models.Location{
+			Latitude:  place.Latitude,
+			Longitude: place.Longitude,
+		},
+	})
+	if err != nil {
+		logrus.Errorf("SMS check-in of user %s failed: %v", userID, err)
+		return smsReplyFailed, "failed"
+	}
+
+	return fmt.Sprintf("Checked in at %s.", place.Name), "checked in"
+}
+
+func (scs *SMSCommandService) handleSOS(ctx context.Context, userID, note string) (string, string) {
+	req := models.TriggerSOSRequest{
+		Type:    "manual",
+		Message: "SOS sent by text message",
+	}
+	if note != "" {
+		req.Message += ": " + note
+	}
+
+	// The phone has no GPS to send, so use the last location we know
+	if location, err := scs.locationRepo.GetCurrentLocation(ctx, userID); err == nil {
+		req.Location = models.EmergencyLocation{
+			Latitude:  location.Latitude,
+			Longitude: location.Longitude,
+			Accuracy:  location.Accuracy,
+			Address:   location.Address,
+			PlaceName: location.PlaceName,
+		}
+	}
+
+	// The alert goes out after Twilio has its reply
+	if _, err := scs.emergencyService.TriggerSOS(context.WithoutCancel(ctx), userID, req); err != nil {
+		logrus.Errorf("SMS SOS of user %s failed: %v", userID, err)
+		return "Your SOS could not be sent. Call your local emergency number now.", "failed"
+	}
+
+	return "SOS sent. Your circles and emergency contacts are being alerted. Call your local emergency number if you can.", "sos triggered"
+}
+
+// findCircleMates returns the active members of the user's circles whose
+// full name, or else first name, is the name
+func (scs *SMSCommandService) findCircleMates(ctx context.Context, userID, name string) ([]models.User, error) {
+	circles, err := scs.circleRepo.GetUserCircles(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var mateIDs []string
+	for i := range circles {
+		for _, member := range circles[i].Members {
+			memberID := member.UserID.Hex()
+			if member.Status != "active" || memberID == userID || seen[memberID] {
+				continue
+			}
+			seen[memberID] = true
+			mateIDs = append(mateIDs, memberID)
+		}
+	}
+	if len(mateIDs) == 0 {
+		return nil, nil
+	}
+
+	mates, err := scs.userRepo.GetUsersByIDs(ctx, mateIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var byFullName, byFirstName []models.User
+	for _, mate := range mates {
+		if strings.EqualFold(strings.TrimSpace(mate.FirstName+" "+mate.LastName), name) {
+			byFullName = append(byFullName, mate)
+		}
+		if strings.EqualFold(mate.FirstName, name) {
+			byFirstName = append(byFirstName, mate)
+		}
+	}
+	if len(byFullName) > 0 {
+		return byFullName, nil
+	}
+	return byFirstName, nil
+}
+
+// allowNumber counts a text against its number's limit. It also reports
+// whether this is the first text over the limit, which alone gets told so.
+func (scs *SMSCommandService) allowNumber(ctx context.Context, phoneNumber string) (bool, bool) {
+	if scs.redis == nil {
+		return true, false
+	}
+
+	key := fmt.Sprintf("sms_commands:rate:%s", phoneNumber)
+	count, err := scs.redis.Incr(ctx, key).Result()
+	if err != nil {
+		logrus.Warnf("Failed to check SMS command limit of %s: %v", phoneNumber, err)
+		return true, false
+	}
+	if count == 1 {
+		scs.redis.Expire(ctx, key, smsCommandRateWindow)
+	}
+
+	return count <= smsCommandRateLimit, count == smsCommandRateLimit+1
+}
+
+// audit records a text in the sender's audit log. Texts from unknown
+// numbers are recorded without a user.
+func (scs *SMSCommandService) audit(ctx context.Context, userID, phoneNumber, messageSID, eventType, description, severity string, command interface{}) {
+	entry := &models.AuditLogEntry{
+		EventType:   eventType,
+		Description: description,
+		DeviceType:  "sms",
+		Severity:    severity,
+		Details: map[string]interface{}{
+			"phoneNumber": phoneNumber,
+		},
+	}
+	if userID != "" {
+		entry.UserID, _ = primitive.ObjectIDFromHex(userID)
+	}
+	if messageSID != "" {
+		entry.Details["messageSid"] = messageSID
+	}
+	if command != nil {
+		entry.Details["command"] = command
+	}
+
+	if err := scs.auditLogRepo.Create(ctx, entry); err != nil {
+		logrus.Errorf("Failed to audit SMS command from %s: %v", phoneNumber, err)
+	}
+}
+
+// verifiedSettings returns the user's SMS settings if their number is
+// verified
+func (scs *SMSCommandService) verifiedSettings(ctx context.Context, userID string) (*models.SMSSettings, error) {
+	settings, err := scs.notificationRepo.GetSMSSettings(ctx, userID)
+	if err != nil {
+		if err.Error() == "not found" {
+			return nil, errors.New("phone number not verified")
+		}
+		return nil, err
+	}
+	if settings.PhoneNumber == "" || !settings.IsVerified {
+		return nil, errors.New("phone number not verified")
+	}
+	return settings, nil
+}
+
+// parseSMSCommand reads a text as a command word followed by its argument.
+// It is forgiving about case, punctuation and a few natural phrasings
+// ("where is Alice", "check in Home"). An unknown command comes back with
+// the word in Argument and false.
+func parseSMSCommand(body string) (models.SMSCommand, bool) {
+	words := strings.Fields(body)
+	if len(words) == 0 {
+		return models.SMSCommand{}, false
+	}
+
+	word := strings.ToUpper(strings.Trim(words[0], ".,!?:;'\""))
+	rest := words[1:]
+
+	name := ""
+	switch word {
+	case "WHERE", "WHERES", "WHERE'S", "FIND":
+		name = models.SMSCommandWhere
+		if len(rest) > 0 && strings.EqualFold(rest[0], "is") {
+			rest = rest[1:]
+		}
+	case "CHECKIN":
+		name = models.SMSCommandCheckin
+	case "CHECK":
+		if len(rest) > 0 && strings.EqualFold(rest[0], "in") {
+			name = models.SMSCommandCheckin
+			rest = rest[1:]
+		}
+	case "SOS", "EMERGENCY":
+		name = models.SMSCommandSOS
+	case "HELP", "INFO", "COMMANDS", "?":
+		name = models.SMSCommandHelp
+	case "START", "YES", "UNSTOP":
+		name = models.SMSCommandStart
+	// Twilio's standard opt-out words
+	case "STOP", "STOPALL", "UNSUBSCRIBE", "CANCEL", "END", "QUIT":
+		name = models.SMSCommandStop
+	}
+
+	if name == "" {
+		return models.SMSCommand{Argument: words[0]}, false
+	}
+
+	if name == models.SMSCommandCheckin && len(rest) > 0 && strings.EqualFold(rest[0], "at") {
+		rest = rest[1:]
+	}
+
+	return models.SMSCommand{
+		Name:     name,
+		Argument: strings.Trim(strings.Join(rest, " "), ".,!?"),
+	}, true
+}
+
+func unknownSMSCommandReply(word string) string {
+	if word == "" {
+		return "Empty message. " + smsReplyHelp
+	}
+	if len(word) > 20 {
+		word = word[:20]
+	}
+	return fmt.Sprintf("Sorry, %q isn't a command. %s", word, smsReplyHelp)
+}
+
+func displaySMSCommand(command models.SMSCommand) string {
+	if command.Name == "" {
+		return "(unknown)"
+	}
+	return command.Name
+}
+
+// matchPlaceName finds a place by its name, or else by the only name that
+// starts with it
+func matchPlaceName(places []models.PlaceResponse, name string) *models.Place {
+	var prefixMatch *models.Place
+	prefixMatches := 0
+	for i := range places {
+		place := &places[i].Place
+		if strings.EqualFold(place.Name, name) {
+			return place
+		}
+		if strings.HasPrefix(strings.ToLower(place.Name), strings.ToLower(name)) {
+			prefixMatch = place
+			prefixMatches++
+		}
+	}
+	if prefixMatches == 1 {
+		return prefixMatch
+	}
+	return nil
+}
+
+// smsAge says how long ago something was, briefly
+func smsAge(age time.Duration) string {
+	switch {
+	case age < time.Minute:
+		return "just now"
+	case age < time.Hour:
+		return fmt.Sprintf("%d min ago", int(age.Minutes()))
+	case age < 48*time.Hour:
+		return fmt.Sprintf("%d h ago", int(age.Hours()))
+	default:
+		return fmt.Sprintf("%d days ago", int(age.Hours()/24))
+	}
+}
+
+func generateSMSOptInCode() string {
+	code := ""
+	for i := 0; i < 6; i++ {
+		digit, _ := rand.Int(rand.Reader, big.NewInt(10))
+		code += digit.String()
+	}
+	return code
+}
+
+func smsOptInCodeKey(userID string) string {
+	return fmt.Sprintf("sms_commands:opt_in:%s", userID)
+}
+
+func smsOptInAttemptsKey(userID string) string {
+	return fmt.Sprintf("sms_commands:opt_in_attempts:%s", userID)
+}
@@ -3,13 +3,18 @@ package services
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"ftrack/models"
 	"ftrack/repositories"
+	"ftrack/utils"
 	"io"
 	"net/http"
 	"net/url"
+	"sort"
 	"strings"
 	"time"
 
@@ -76,7 +81,7 @@ func (ss *SMSService) SendNotification(ctx context.Context, notification *models
 	smsContent := ss.formatSMSContent(notification)
 
 	// Send SMS
-	err = ss.sendSMS(smsSettings.PhoneNumber, smsContent)
+	err = ss.sendSMS(ctx, smsSettings.PhoneNumber, smsContent)
 	if err != nil {
 		return fmt.Errorf("failed to send SMS: %w", err)
 	}
@@ -94,13 +99,19 @@ func (ss *SMSService) SendTestSMS(ctx context.Context, phoneNumber, message stri
 		return fmt.Errorf("Twilio not configured")
 	}
 
-	return ss.sendSMS(phoneNumber, fmt.Sprintf("Test SMS: %s", message))
+	return ss.sendSMS(ctx, phoneNumber, fmt.Sprintf("Test SMS: %s", message))
 }
 
 // SendVerificationSMS sends an SMS verification code
 func (ss *SMSService) SendVerificationSMS(ctx context.Context, phoneNumber, verificationCode string) error {
 	message := fmt.Sprintf("Your Family Tracker verification code is: %s. This code expires in 10 minutes.", verificationCode)
-	return ss.sendSMS(phoneNumber, message)
+	return ss.sendSMS(ctx, phoneNumber, message)
+}
+
+// SendEmergencyContactVerificationSMS asks a person to confirm being someone's emergency contact
+func (ss *SMSService) SendEmergencyContactVerificationSMS(ctx context.Context, phoneNumber, ownerName, confirmURL, declineURL string) error {
+	message := fmt.Sprintf("%s added you as an emergency contact on Family Tracker. Confirm: %s Decline: %s", ownerName, confirmURL, declineURL)
+	return ss.sendSMS(ctx, phoneNumber, message)
 }
 
 // checkUsageLimits checks if the user has exceeded their SMS limits
@@ -145,8 +156,9 @@ func (ss *SMSService) formatSMSContent(notification *models.Notification) string
 	return content
 }
 
-// sendSMS sends an SMS using Twilio API
-func (ss *SMSService) sendSMS(phoneNumber, message string) error {
+// sendSMS sends an SMS using Twilio API. Numbers and messages Twilio
+// rejects aren't retried.
+func (ss *SMSService) sendSMS(ctx context.Context, phoneNumber, message string) error {
 	if ss.twilioAccountSID == "" {
 		logrus.Warn("Twilio not configured, skipping SMS send")
 		return nil
@@ -161,33 +173,40 @@ func (ss *SMSService) sendSMS(phoneNumber, message string) error {
 	data.Set("To", phoneNumber)
 	data.Set("Body", message)
 
-	// Create request
-	req, err := http.NewRequest("POST", apiURL, strings.NewReader(data.Encode()))
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
+	var body []byte
+	err := utils.Providers.Do(ctx, utils.ProviderSMS, func(ctx context.Context) error {
+		// Create request
+		req, err := http.NewRequestWithContext(ctx, "POST", apiURL, strings.NewReader(data.Encode()))
+		if err != nil {
+			return utils.NewPermanentProviderError(fmt.Errorf("failed to create request: %w", err))
+		}
 
-	// Set headers
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.SetBasicAuth(ss.twilioAccountSID, ss.twilioAuthToken)
+		// Set headers
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(ss.twilioAccountSID, ss.twilioAuthToken)
 
-	// Send request
-	resp, err := ss.httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
-	}
-	defer resp.Body.Close()
+		// Send request
+		resp, err := ss.httpClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to send request: %w", err)
+		}
+		defer resp.Body.Close()
 
-	// Read response
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read response: %w", err)
-	}
+		// Read response
+		body, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("failed to read response: %w", err)
+		}
 
-	// Check response status
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		logrus.Errorf("Twilio API error: %s", string(body))
-		return fmt.Errorf("SMS API error: %s", resp.Status)
+		// Check response status
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			logrus.Errorf("Twilio API error: %s", string(body))
+			return utils.ProviderStatusError(fmt.Errorf("SMS API error: %s", resp.Status), resp.StatusCode)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	// Parse response
@@ -316,7 +335,7 @@ func (ss *SMSService) SendBulkSMS(ctx context.Context, phoneNumbers []string, me
 	successCount := 0
 
 	for _, phoneNumber := range phoneNumbers {
-		if err := ss.sendSMS(phoneNumber, message); err != nil {
+		if err := ss.sendSMS(ctx, phoneNumber, message); err != nil {
 			errors = append(errors, fmt.Sprintf("%s: %v", phoneNumber, err))
 			logrus.Errorf("Failed to send SMS to %s: %v", phoneNumber, err)
 		} else {
@@ -356,6 +375,41 @@ func (ss *SMSService) CheckTwilioWebhook(ctx context.Context, webhookData map[st
 	return nil
 }
 
+// ValidateWebhookSignature checks the X-Twilio-Signature of a webhook
+// request: the HMAC-SHA1, keyed with the auth token, of the webhook URL
+// followed by the form parameters sorted by name
+func (ss *SMSService) ValidateWebhookSignature(webhookURL string, params url.Values, signature string) bool {
+	if ss.twilioAuthToken == "" || signature == "" {
+		return false
+	}
+
+	var payload strings.Builder
+	payload.WriteString(webhookURL)
+
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range params[key] {
+			payload.WriteString(key)
+			payload.WriteString(value)
+		}
+	}
+
+	mac := hmac.New(sha1.New, []byte(ss.twilioAuthToken))
+	mac.Write([]byte(payload.String()))
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// PhoneNumber is the number texts are sent from, and commands sent to
+func (ss *SMSService) PhoneNumber() string {
+	return ss.twilioPhoneNumber
+}
+
 // EstimateSMSCost estimates the cost of sending an SMS
 func (ss *SMSService) EstimateSMSCost(message string, phoneNumber string) (float64, error) {
 	// Basic cost estimation (Twilio pricing varies by destination)
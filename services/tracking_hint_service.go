@@ -0,0 +1,255 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"ftrack/models"
+	"ftrack/repositories"
+	"ftrack/utils"
+	"ftrack/websocket"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+)
+
+// Recommended location reporting intervals
+const (
+	TrackingIntervalLive    = 30 * time.Second
+	TrackingIntervalDefault = 2 * time.Minute
+	TrackingIntervalAtPlace = 15 * time.Minute
+)
+
+const (
+	// A user is watched while someone requested their location this recently
+	trackingWatchWindow = 2 * time.Minute
+
+	// Longer intervals only apply once they have been recommended this long,
+	// so the interval doesn't thrash. Shorter intervals apply at once.
+	trackingRelaxDelay = 5 * time.Minute
+
+	trackingStateTTL = 24 * time.Hour
+)
+
+// trackingState is the hint a user was last given, plus a longer interval
+// waiting out the relax delay
+type trackingState struct {
+	Hint         models.TrackingHint  `json:"hint"`
+	PendingHint  *models.TrackingHint `json:"pendingHint,omitempty"`
+	PendingSince time.Time            `json:"pendingSince,omitempty"`
+}
+
+type TrackingHintService struct {
+	redis        *redis.Client
+	locationRepo *repositories.LocationRepository
+	placeRepo    *repositories.PlaceRepository
+	websocketHub *websocket.Hub
+	pushService  *PushService
+}
+
+func NewTrackingHintService(
+	redis *redis.Client,
+	locationRepo *repositories.LocationRepository,
+	placeRepo *repositories.PlaceRepository,
+	websocketHub *websocket.Hub,
+	pushService *PushService,
+) *TrackingHintService {
+	return &TrackingHintService{
+		redis:        redis,
+		locationRepo: locationRepo,
+		placeRepo:    placeRepo,
+		websocketHub: websocketHub,
+		pushService:  pushService,
+	}
+}
+
+// Evaluate recommends a reporting interval for a user who just reported a
+// location, and tells their devices when it changes
+func (ths *TrackingHintService) Evaluate(ctx context.Context, userID string, location models.Location) (*models.TrackingHint, error) {
+	interval, reason := ths.recommend(ctx, userID, location)
+	return ths.apply(ctx, userID, interval, reason)
+}
+
+// RecordWatch notes that the watcher looked at the targets' locations. Users
+// who are watched are asked to report at the live interval right away.
+func (ths *TrackingHintService) RecordWatch(ctx context.Context, watcherID string, targetIDs []string) {
+	now := time.Now()
+
+	for _, targetID := range targetIDs {
+		if targetID == watcherID {
+			continue
+		}
+
+		key := trackingWatchersKey(targetID)
+		pipe := ths.redis.TxPipeline()
+		pipe.ZAdd(ctx, key, &redis.Z{Score: float64(now.Unix()), Member: watcherID})
+		pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.Add(-trackingWatchWindow).Unix(), 10))
+		pipe.Expire(ctx, key, trackingWatchWindow)
+		if _, err := pipe.Exec(ctx); err != nil {
+			logrus.Warnf("Failed to record watch of user %s: %v", targetID, err)
+			continue
+		}
+
+		if _, err := ths.apply(ctx, targetID, TrackingIntervalLive, models.TrackingReasonLiveView); err != nil {
+			logrus.Warnf("Failed to escalate tracking for user %s: %v", targetID, err)
+		}
+	}
+}
+
+// CurrentHint returns the interval the user was last told to report at
+func (ths *TrackingHintService) CurrentHint(ctx context.Context, userID string) (*models.TrackingHint, error) {
+	state, err := ths.loadState(ctx, userID)
+	if err != nil || state == nil {
+		return nil, err
+	}
+	return &state.Hint, nil
+}
+
+func (ths *TrackingHintService) recommend(ctx context.Context, userID string, location models.Location) (time.Duration, string) {
+	watchers, err := ths.redis.ZCount(ctx, trackingWatchersKey(userID),
+		strconv.FormatInt(time.Now().Add(-trackingWatchWindow).Unix(), 10), "+inf").Result()
+	if err != nil {
+		logrus.Warnf("Failed to count watchers of user %s: %v", userID, err)
+	}
+	if watchers > 0 {
+		return TrackingIntervalLive, models.TrackingReasonLiveView
+	}
+
+	if location.IsDriving {
+		return TrackingIntervalLive, models.TrackingReasonActiveTrip
+	}
+	hasTrip, err := ths.locationRepo.HasActiveTrip(ctx, userID)
+	if err != nil {
+		logrus.Warnf("Failed to check active trips of user %s: %v", userID, err)
+	}
+	if hasTrip {
+		return TrackingIntervalLive, models.TrackingReasonActiveTrip
+	}
+
+	if !location.IsMoving && ths.isAtPlace(ctx, userID, location) {
+		return TrackingIntervalAtPlace, models.TrackingReasonAtPlace
+	}
+
+	return TrackingIntervalDefault, models.TrackingReasonDefault
+}
+
+func (ths *TrackingHintService) isAtPlace(ctx context.Context, userID string, location models.Location) bool {
+	places, _, err := ths.placeRepo.GetUserPlaces(ctx, userID, models.GetPlacesRequest{})
+	if err != nil {
+		logrus.Warnf("Failed to get places of user %s: %v", userID, err)
+		return false
+	}
+
+	for _, place := range places {
+		distance := utils.CalculateDistance(location.Latitude, location.Longitude, place.Latitude, place.Longitude)
+		if distance <= float64(place.Radius) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// apply moves the user's hint towards the recommendation. Shorter intervals
+// apply at once, longer ones after the relax delay.
+func (ths *TrackingHintService) apply(ctx context.Context, userID string, interval time.Duration, reason string) (*models.TrackingHint, error) {
+	state, err := ths.loadState(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	recommended := models.TrackingHint{
+		Interval:  int(interval.Seconds()),
+		Reason:    reason,
+		UpdatedAt: now,
+	}
+
+	changed := false
+	switch {
+	case state == nil:
+		state = &trackingState{Hint: recommended}
+		changed = true
+
+	case recommended.Interval < state.Hint.Interval:
+		state.Hint = recommended
+		state.PendingHint = nil
+		changed = true
+
+	case recommended.Interval == state.Hint.Interval:
+		state.Hint.Reason = reason
+		state.PendingHint = nil
+
+	case state.PendingHint == nil || state.PendingHint.Interval != recommended.Interval:
+		state.PendingHint = &recommended
+		state.PendingSince = now
+
+	case now.Sub(state.PendingSince) >= trackingRelaxDelay:
+		state.Hint = recommended
+		state.PendingHint = nil
+		changed = true
+	}
+
+	if err := ths.saveState(ctx, userID, state); err != nil {
+		return nil, err
+	}
+
+	if changed {
+		ths.notify(ctx, userID, state.Hint)
+	}
+
+	return &state.Hint, nil
+}
+
+// notify sends the new hint over WebSocket and as a silent push, for apps
+// that are in the background without a connection
+func (ths *TrackingHintService) notify(ctx context.Context, userID string, hint models.TrackingHint) {
+	if ths.websocketHub != nil {
+		ths.websocketHub.SendTrackingHint(userID, hint)
+	}
+
+	if ths.pushService != nil {
+		err := ths.pushService.SendSilentData(ctx, userID, map[string]string{
+			"type":     models.WSTypeTrackingHint,
+			"interval": strconv.Itoa(hint.Interval),
+			"reason":   hint.Reason,
+		})
+		if err != nil {
+			logrus.Warnf("Failed to push tracking hint to user %s: %v", userID, err)
+		}
+	}
+}
+
+func (ths *TrackingHintService) loadState(ctx context.Context, userID string) (*trackingState, error) {
+	data, err := ths.redis.Get(ctx, trackingStateKey(userID)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var state trackingState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, nil
+	}
+	return &state, nil
+}
+
+func (ths *TrackingHintService) saveState(ctx context.Context, userID string, state *trackingState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return ths.redis.Set(ctx, trackingStateKey(userID), data, trackingStateTTL).Err()
+}
+
+func trackingStateKey(userID string) string {
+	return "tracking:hint:" + userID
+}
+
+func trackingWatchersKey(userID string) string {
+	return "tracking:watchers:" + userID
+}
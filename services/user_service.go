@@ -6,6 +6,8 @@ package services
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -17,22 +19,43 @@ import (
 	"ftrack/repositories"
 	"ftrack/utils"
 
+	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type UserService struct {
-	userRepo   *repositories.UserRepository
-	validator  *utils.ValidationService
-	friendRepo *repositories.FriendRepository // You'll need to create this
-	reportRepo *repositories.ReportRepository // You'll need to create this
-	exportRepo *repositories.ExportRepository // You'll need to create this
+	userRepo      *repositories.UserRepository
+	emergencyRepo *repositories.EmergencyRepository
+	auditRepo     *repositories.AuditLogRepository
+	blockRepo     *repositories.BlockRepository
+	emailService  EmailService
+	smsService    *SMSService
+	baseURL       string
+	validator     *utils.ValidationService
+	friendRepo    *repositories.FriendRepository // You'll need to create this
+	reportRepo    *repositories.ReportRepository // You'll need to create this
+	exportRepo    *repositories.ExportRepository // You'll need to create this
 }
 
-func NewUserService(userRepo *repositories.UserRepository) *UserService {
+func NewUserService(
+	userRepo *repositories.UserRepository,
+	emergencyRepo *repositories.EmergencyRepository,
+	auditRepo *repositories.AuditLogRepository,
+	blockRepo *repositories.BlockRepository,
+	emailService EmailService,
+	smsService *SMSService,
+	baseURL string,
+) *UserService {
 	return &UserService{
-		userRepo:  userRepo,
-		validator: utils.NewValidationService(),
+		userRepo:      userRepo,
+		emergencyRepo: emergencyRepo,
+		auditRepo:     auditRepo,
+		blockRepo:     blockRepo,
+		emailService:  emailService,
+		smsService:    smsService,
+		baseURL:       baseURL,
+		validator:     utils.NewValidationService(),
 		// Initialize other repositories as needed
 	}
 }
@@ -55,7 +78,7 @@ func (us *UserService) GetUserProfile(ctx context.Context, userID string) (*mode
 func (us *UserService) UpdateUserProfile(ctx context.Context, userID string, req models.UpdateUserRequest) (*models.User, error) {
 	// Validate request
 	if validationErrors := us.validator.ValidateStruct(req); len(validationErrors) > 0 {
-		return nil, errors.New("validation failed")
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
 	}
 
 	// Build update document
@@ -266,7 +289,7 @@ func (us *UserService) GetPrivacySettings(ctx context.Context, userID string) (*
 func (us *UserService) UpdatePrivacySettings(ctx context.Context, userID string, settings models.PrivacySettings) (*models.PrivacySettings, error) {
 	// Validate the settings
 	if validationErrors := us.validator.ValidateStruct(settings); len(validationErrors) > 0 {
-		return nil, errors.New("validation failed")
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
 	}
 
 	// Update the user's privacy settings
@@ -316,7 +339,7 @@ func (us *UserService) GetLocationSettings(ctx context.Context, userID string) (
 func (us *UserService) UpdateLocationSettings(ctx context.Context, userID string, settings models.LocationSharing) (*models.LocationSharing, error) {
 	// Validate the settings
 	if validationErrors := us.validator.ValidateStruct(settings); len(validationErrors) > 0 {
-		return nil, errors.New("validation failed")
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
 	}
 
 	// Additional validation for precision values
@@ -373,63 +396,293 @@ func (us *UserService) UpdateDrivingSettings(ctx context.Context, userID string,
 // =============================================
 
 func (us *UserService) GetEmergencyContacts(ctx context.Context, userID string) ([]models.EmergencyContact, error) {
-	user, err := us.userRepo.GetByID(ctx, userID)
+	contacts, err := us.emergencyRepo.GetUserEmergencyContacts(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
 
-	// Return array with single emergency contact for backward compatibility
-	// You might want to modify your User model to support multiple emergency contacts
-	contacts := []models.EmergencyContact{}
-	if user.EmergencyContact.Name != "" {
-		contacts = append(contacts, user.EmergencyContact)
+	if contacts == nil {
+		contacts = []models.EmergencyContact{}
 	}
 
 	return contacts, nil
 }
 
-func (us *UserService) AddEmergencyContact(ctx context.Context, userID string, contact models.EmergencyContact) (*models.EmergencyContact, error) {
+func (us *UserService) AddEmergencyContact(ctx context.Context, userID string, req models.AddEmergencyContactRequest) (*models.EmergencyContact, error) {
 	// Validate contact
-	if validationErrors := us.validator.ValidateStruct(contact); len(validationErrors) > 0 {
-		return nil, errors.New("validation failed")
+	if validationErrors := us.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
 	}
 
-	update := bson.M{
-		"emergencyContact": contact,
-		"updatedAt":        time.Now(),
+	// The limit itself is enforced when the contact is stored
+	count, err := us.emergencyRepo.CountUserEmergencyContacts(ctx, userID)
+	if err != nil {
+		return nil, err
 	}
 
-	err := us.userRepo.Update(ctx, userID, update)
-	if err != nil {
+	priority := req.Priority
+	if priority == 0 {
+		priority = int(count) + 1
+	}
+
+	phone := ""
+	if req.Phone != "" {
+		phone = utils.NormalizePhoneNumber(req.Phone)
+	}
+
+	contact := &models.EmergencyContact{
+		ContactID:    primitive.NewObjectID(),
+		Name:         req.Name,
+		Phone:        phone,
+		Email:        strings.ToLower(strings.TrimSpace(req.Email)),
+		Relationship: req.Relationship,
+		Priority:     priority,
+		UpdatedAt:    time.Now(),
+	}
+
+	if err := us.resetContactVerification(contact); err != nil {
+		return nil, err
+	}
+
+	if err := us.emergencyRepo.AddEmergencyContact(ctx, userID, contact); err != nil {
 		return nil, err
 	}
 
-	return &contact, nil
+	Background.Go(ctx, func(context.Context) {
+		us.sendContactVerification(userID, *contact)
+	})
+
+	us.auditEmergencyContactChange(ctx, userID, "emergency_contact_added", "Emergency contact added", req.IPAddress, req.UserAgent, contact)
+
+	return contact, nil
 }
 
-func (us *UserService) UpdateEmergencyContact(ctx context.Context, userID string, contactID string, contact models.EmergencyContact) (*models.EmergencyContact, error) {
-	// For simplicity, treating contactID as single contact update
-	return us.AddEmergencyContact(ctx, userID, contact)
+func (us *UserService) UpdateEmergencyContact(ctx context.Context, userID string, contactID string, req models.UpdateEmergencyContactRequest) (*models.EmergencyContact, error) {
+	if validationErrors := us.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	contact, err := us.getEmergencyContact(ctx, userID, contactID)
+	if err != nil {
+		return nil, err
+	}
+
+	detailsChanged := false
+	if req.Name != "" {
+		contact.Name = req.Name
+	}
+	if req.Relationship != "" {
+		contact.Relationship = req.Relationship
+	}
+	if req.Priority != 0 {
+		contact.Priority = req.Priority
+	}
+	if req.Phone != "" {
+		if phone := utils.NormalizePhoneNumber(req.Phone); phone != contact.Phone {
+			contact.Phone = phone
+			detailsChanged = true
+		}
+	}
+	if req.Email != "" {
+		if email := strings.ToLower(strings.TrimSpace(req.Email)); email != contact.Email {
+			contact.Email = email
+			detailsChanged = true
+		}
+	}
+
+	// New phone or email means the person receiving alerts may have changed,
+	// so the contact has to confirm again before being used in escalations.
+	if detailsChanged {
+		if err := us.resetContactVerification(contact); err != nil {
+			return nil, err
+		}
+	}
+
+	contact.UpdatedAt = time.Now()
+
+	if err := us.emergencyRepo.UpdateEmergencyContact(ctx, userID, contactID, contact); err != nil {
+		return nil, err
+	}
+
+	if detailsChanged {
+		Background.Go(ctx, func(context.Context) {
+			us.sendContactVerification(userID, *contact)
+		})
+	}
+
+	us.auditEmergencyContactChange(ctx, userID, "emergency_contact_updated", "Emergency contact updated", req.IPAddress, req.UserAgent, contact)
+
+	return contact, nil
 }
 
-func (us *UserService) DeleteEmergencyContact(ctx context.Context, userID string, contactID string) error {
-	update := bson.M{
-		"emergencyContact": models.EmergencyContact{},
-		"updatedAt":        time.Now(),
+func (us *UserService) DeleteEmergencyContact(ctx context.Context, userID string, contactID string, ipAddress, userAgent string) error {
+	contact, err := us.getEmergencyContact(ctx, userID, contactID)
+	if err != nil {
+		return err
 	}
 
-	return us.userRepo.Update(ctx, userID, update)
+	if err := us.emergencyRepo.DeleteEmergencyContact(ctx, userID, contactID); err != nil {
+		return err
+	}
+
+	us.auditEmergencyContactChange(ctx, userID, "emergency_contact_removed", "Emergency contact removed", ipAddress, userAgent, contact)
+
+	return nil
 }
 
+// VerifyEmergencyContact re-sends the confirmation link to a contact that has not answered yet
 func (us *UserService) VerifyEmergencyContact(ctx context.Context, userID string, contactID string) error {
-	// Implementation depends on your verification system
-	// For now, just mark as verified
-	update := bson.M{
-		"emergencyContact.verified": true,
-		"updatedAt":                 time.Now(),
+	contact, err := us.getEmergencyContact(ctx, userID, contactID)
+	if err != nil {
+		return err
 	}
 
-	return us.userRepo.Update(ctx, userID, update)
+	switch contact.VerificationStatus {
+	case models.ContactVerificationVerified:
+		return errors.New("already verified")
+	case models.ContactVerificationDeclined:
+		return errors.New("contact declined")
+	}
+
+	if err := us.resetContactVerification(contact); err != nil {
+		return err
+	}
+
+	if err := us.emergencyRepo.UpdateEmergencyContact(ctx, userID, contactID, contact); err != nil {
+		return err
+	}
+
+	Background.Go(ctx, func(context.Context) {
+		us.sendContactVerification(userID, *contact)
+	})
+
+	return nil
+}
+
+// RespondToEmergencyContactRequest handles the confirm/decline link sent to a contact
+func (us *UserService) RespondToEmergencyContactRequest(ctx context.Context, req models.EmergencyContactResponseRequest) error {
+	if validationErrors := us.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return utils.NewFieldValidationFailedError(validationErrors)
+	}
+
+	contact, ownerID, err := us.emergencyRepo.GetEmergencyContactByVerificationToken(ctx, req.Token)
+	if err != nil {
+		return errors.New("invalid or expired token")
+	}
+
+	if time.Since(contact.VerificationSentAt) > emergencyContactVerificationTTL {
+		return errors.New("invalid or expired token")
+	}
+
+	status := models.ContactVerificationVerified
+	eventType := "emergency_contact_verified"
+	if req.Action == "decline" {
+		status = models.ContactVerificationDeclined
+		eventType = "emergency_contact_declined"
+	}
+
+	if err := us.emergencyRepo.SetEmergencyContactVerificationStatus(ctx, req.Token, status); err != nil {
+		return err
+	}
+
+	if us.auditRepo != nil {
+		details := map[string]interface{}{
+			"contactId": contact.ContactID.Hex(),
+			"status":    status,
+		}
+		if err := us.auditRepo.LogSecurityEvent(ctx, ownerID.Hex(), eventType, "Emergency contact responded to verification", "", "", "", "info", details); err != nil {
+			logrus.Warnf("Failed to write audit log for user %s: %v", ownerID.Hex(), err)
+		}
+	}
+
+	return nil
+}
+
+const emergencyContactVerificationTTL = 7 * 24 * time.Hour
+
+func (us *UserService) getEmergencyContact(ctx context.Context, userID, contactID string) (*models.EmergencyContact, error) {
+	contacts, err := us.emergencyRepo.GetUserEmergencyContacts(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, contact := range contacts {
+		if contact.ContactID.Hex() == contactID {
+			return &contact, nil
+		}
+	}
+
+	return nil, errors.New("contact not found")
+}
+
+// resetContactVerification puts the contact back into pending state with a fresh token
+func (us *UserService) resetContactVerification(contact *models.EmergencyContact) error {
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return err
+	}
+
+	contact.VerificationStatus = models.ContactVerificationPending
+	contact.VerificationToken = hex.EncodeToString(tokenBytes)
+	contact.VerificationSentAt = time.Now()
+	return nil
+}
+
+func (us *UserService) sendContactVerification(userID string, contact models.EmergencyContact) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	owner, err := us.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		logrus.Errorf("Failed to load user %s for contact verification: %v", userID, err)
+		return
+	}
+	ownerName := strings.TrimSpace(owner.FirstName + " " + owner.LastName)
+
+	respondURL := fmt.Sprintf("%s/api/v1/emergency-contacts/respond?token=%s", us.baseURL, contact.VerificationToken)
+	confirmURL := respondURL + "&action=confirm"
+	declineURL := respondURL + "&action=decline"
+
+	if contact.Phone != "" && us.smsService != nil {
+		if err := us.smsService.SendEmergencyContactVerificationSMS(ctx, contact.Phone, ownerName, confirmURL, declineURL); err != nil {
+			logrus.Errorf("Failed to send contact verification SMS for user %s: %v", userID, err)
+		}
+	}
+
+	if contact.Email != "" && us.emailService != nil {
+		err := us.emailService.SendEmail(EmailData{
+			To:       contact.Email,
+			Subject:  fmt.Sprintf("%s added you as an emergency contact - FTrack", ownerName),
+			Template: "emergency_contact_verification",
+			Data: map[string]interface{}{
+				"Name":       contact.Name,
+				"OwnerName":  ownerName,
+				"ConfirmURL": confirmURL,
+				"DeclineURL": declineURL,
+			},
+		})
+		if err != nil {
+			logrus.Errorf("Failed to send contact verification email for user %s: %v", userID, err)
+		}
+	}
+}
+
+func (us *UserService) auditEmergencyContactChange(ctx context.Context, userID, eventType, description, ipAddress, userAgent string, contact *models.EmergencyContact) {
+	if us.auditRepo == nil {
+		return
+	}
+
+	details := map[string]interface{}{
+		"contactId":    contact.ContactID.Hex(),
+		"name":         contact.Name,
+		"relationship": contact.Relationship,
+		"phone":        utils.MaskPhoneNumber(contact.Phone),
+		"email":        utils.MaskEmail(contact.Email),
+	}
+
+	if err := us.auditRepo.LogSecurityEvent(ctx, userID, eventType, description, ipAddress, userAgent, "", "info", details); err != nil {
+		logrus.Warnf("Failed to write audit log for user %s: %v", userID, err)
+	}
 }
 
 // =============================================
@@ -467,7 +720,7 @@ func (us *UserService) GetUserDevices(ctx context.Context, userID string) ([]mod
 func (us *UserService) RegisterDevice(ctx context.Context, userID string, deviceReq models.RegisterDeviceRequest) (*models.UserDevice, error) {
 	// Validate request
 	if validationErrors := us.validator.ValidateStruct(deviceReq); len(validationErrors) > 0 {
-		return nil, errors.New("validation failed")
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
 	}
 
 	// Update user's device token
@@ -777,50 +1030,52 @@ func (us *UserService) RemoveFriend(ctx context.Context, userID string, friendUs
 // =============================================
 
 func (us *UserService) GetBlockedUsers(ctx context.Context, userID string) ([]models.BlockedUser, error) {
-	// TODO: Implement getting blocked users from repository
-	// return us.reportRepo.GetBlockedUsers(ctx, userID)
+	blockedUsers, err := us.blockRepo.GetBlockedUsers(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
 
-	// Placeholder implementation
-	return []models.BlockedUser{}, nil
+	if blockedUsers == nil {
+		blockedUsers = []models.BlockedUser{}
+	}
+	return blockedUsers, nil
 }
 
+// BlockUser hides the target's messages from the user and stops location
+// and notifications flowing between them, without either leaving a circle
 func (us *UserService) BlockUser(ctx context.Context, userID string, targetUserID string, req models.BlockUserRequest) (*models.BlockedUser, error) {
 	if userID == targetUserID {
 		return nil, errors.New("cannot block yourself")
 	}
 
+	// Validate request
+	if validationErrors := us.validator.ValidateStruct(req); len(validationErrors) > 0 {
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
+	}
+
 	// Check if target user exists
 	_, err := us.userRepo.GetByID(ctx, targetUserID)
 	if err != nil {
 		return nil, errors.New("user not found")
 	}
 
-	// TODO: Check if already blocked
-	// TODO: Remove friendship if exists
-	// TODO: Create block record
-
 	userObjectID, _ := primitive.ObjectIDFromHex(userID)
 	targetObjectID, _ := primitive.ObjectIDFromHex(targetUserID)
 
 	blockedUser := &models.BlockedUser{
-		ID:            primitive.NewObjectID(),
 		UserID:        userObjectID,
 		BlockedUserID: targetObjectID,
 		Reason:        req.Reason,
-		CreatedAt:     time.Now(),
 	}
 
-	// TODO: Save to database
-	// err = us.reportRepo.CreateBlockedUser(ctx, blockedUser)
+	if err := us.blockRepo.Create(ctx, blockedUser); err != nil {
+		return nil, err
+	}
 
 	return blockedUser, nil
 }
 
 func (us *UserService) UnblockUser(ctx context.Context, userID string, targetUserID string) error {
-	// TODO: Implement user unblocking
-	// 1. Find block record
-	// 2. Remove block record
-
 	if userID == targetUserID {
 		return errors.New("cannot unblock yourself")
 	}
@@ -831,8 +1086,7 @@ func (us *UserService) UnblockUser(ctx context.Context, userID string, targetUse
 		return errors.New("user not found")
 	}
 
-	// TODO: Remove block record
-	return nil
+	return us.blockRepo.Delete(ctx, userID, targetUserID)
 }
 
 func (us *UserService) ReportUser(ctx context.Context, userID string, targetUserID string, req models.ReportUserRequest) (*models.UserReport, error) {
@@ -842,7 +1096,7 @@ func (us *UserService) ReportUser(ctx context.Context, userID string, targetUser
 
 	// Validate request
 	if validationErrors := us.validator.ValidateStruct(req); len(validationErrors) > 0 {
-		return nil, errors.New("validation failed")
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
 	}
 
 	// Check if target user exists
@@ -878,7 +1132,7 @@ func (us *UserService) ReportUser(ctx context.Context, userID string, targetUser
 func (us *UserService) ExportUserData(ctx context.Context, userID string, req models.ExportUserDataRequest) (*models.UserDataExport, error) {
 	// Validate request
 	if validationErrors := us.validator.ValidateStruct(req); len(validationErrors) > 0 {
-		return nil, errors.New("validation failed")
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
 	}
 
 	// Check if export already in progress
@@ -891,12 +1145,14 @@ func (us *UserService) ExportUserData(ctx context.Context, userID string, req mo
 	userObjectID, _ := primitive.ObjectIDFromHex(userID)
 
 	export := &models.UserDataExport{
-		ID:        primitive.NewObjectID(),
-		UserID:    userObjectID,
-		Status:    "pending",
-		DataTypes: req.DataTypes,
-		ExpiresAt: time.Now().Add(7 * 24 * time.Hour), // 7 days
-		CreatedAt: time.Now(),
+		ID:            primitive.NewObjectID(),
+		UserID:        userObjectID,
+		Status:        "pending",
+		DataTypes:     req.DataTypes,
+		SchemaVersion: models.ExportSchemaVersionLatest,
+		AppVersion:    models.AppVersion,
+		ExpiresAt:     time.Now().Add(7 * 24 * time.Hour), // 7 days
+		CreatedAt:     time.Now(),
 	}
 
 	// TODO: Save to database and start background export process
@@ -947,7 +1203,7 @@ func (us *UserService) DownloadExport(ctx context.Context, userID string, export
 func (us *UserService) RequestDataPurge(ctx context.Context, userID string, req models.DataPurgeRequest) (*models.DataPurgeRequest, error) {
 	// Validate request
 	if validationErrors := us.validator.ValidateStruct(req); len(validationErrors) > 0 {
-		return nil, errors.New("validation failed")
+		return nil, utils.NewFieldValidationFailedError(validationErrors)
 	}
 
 	// Check if purge already requested
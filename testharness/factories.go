@@ -0,0 +1,207 @@
+package testharness
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"ftrack/models"
+	"ftrack/repositories"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Repositories are the repositories of a test database
+type Repositories struct {
+	User       *repositories.UserRepository
+	Circle     *repositories.CircleRepository
+	Message    *repositories.MessageRepository
+	Place      *repositories.PlaceRepository
+	Block      *repositories.BlockRepository
+	Media      *repositories.MediaRepository
+	Template   *repositories.TemplateRepository
+	Draft      *repositories.DraftRepository
+	Schedule   *repositories.ScheduleRepository
+	Report     *repositories.ReportRepository
+	Automation *repositories.AutomationRepository
+	Export     *repositories.ExportRepository
+	Location   *repositories.LocationRepository
+	Outbox     *repositories.OutboxRepository
+}
+
+func NewRepositories(db *mongo.Database) *Repositories {
+	return &Repositories{
+		User:       repositories.NewUserRepository(db),
+		Circle:     repositories.NewCircleRepository(db),
+		Message:    repositories.NewMessageRepository(db),
+		Place:      repositories.NewPlaceRepository(db),
+		Block:      repositories.NewBlockRepository(db),
+		Media:      repositories.NewMediaRepository(db),
+		Template:   repositories.NewTemplateRepository(db),
+		Draft:      repositories.NewDraftRepository(db),
+		Schedule:   repositories.NewScheduleRepository(db),
+		Report:     repositories.NewReportRepository(db),
+		Automation: repositories.NewAutomationRepository(db),
+		Export:     repositories.NewExportRepository(db),
+		Location:   repositories.NewLocationRepository(db),
+		Outbox:     repositories.NewOutboxRepository(db),
+	}
+}
+
+// Env is a test's database and the fakes services send through
+type Env struct {
+	DB       *mongo.Database
+	Repos    *Repositories
+	Hub      *FakeHub
+	Notifier *FakeNotifier
+	Factory  *Factory
+}
+
+// New sets up a test's own database with its repositories and fakes. Redis
+// is left out; tests that use it call Redis.
+func New(t testing.TB) *Env {
+	t.Helper()
+
+	db := Mongo(t)
+	repos := NewRepositories(db)
+	return &Env{
+		DB:       db,
+		Repos:    repos,
+		Hub:      NewFakeHub(),
+		Notifier: NewFakeNotifier(),
+		Factory:  &Factory{t: t, repos: repos},
+	}
+}
+
+// Factory stores users, circles, places and messages for a test. Options
+// change a record before it is stored.
+type Factory struct {
+	t     testing.TB
+	repos *Repositories
+	seq   int
+}
+
+// User stores an active, verified user sharing their location
+func (f *Factory) User(opts ...func(*models.User)) *models.User {
+	f.t.Helper()
+
+	n := f.next()
+	user := &models.User{
+		Email:      fmt.Sprintf("user%d@example.com", n),
+		Phone:      fmt.Sprintf("+1555%07d", n),
+		FirstName:  "User",
+		LastName:   fmt.Sprint(n),
+		IsActive:   true,
+		IsVerified: true,
+		LocationSharing: models.LocationSharing{
+			Enabled:     true,
+			Precision:   models.PrecisionExact,
+			SharePlaces: true,
+		},
+	}
+	for _, opt := range opts {
+		opt(user)
+	}
+
+	if err := f.repos.User.Create(context.Background(), user); err != nil {
+		f.t.Fatalf("creating user: %v", err)
+	}
+	return user
+}
+
+// Circle stores a circle the admin runs, with the members active in it
+func (f *Factory) Circle(admin *models.User, members []*models.User, opts ...func(*models.Circle)) *models.Circle {
+	f.t.Helper()
+
+	n := f.next()
+	circle := &models.Circle{
+		Name:       fmt.Sprintf("Circle %d", n),
+		AdminID:    admin.ID,
+		InviteCode: fmt.Sprintf("INV%05d", n),
+		Members:    []models.CircleMember{circleMember(admin, "admin")},
+		Settings: models.CircleSettings{
+			MaxMembers:         20,
+			LocationSharing:    true,
+			PlaceNotifications: true,
+		},
+	}
+	for _, member := range members {
+		circle.Members = append(circle.Members, circleMember(member, "member"))
+	}
+	for _, opt := range opts {
+		opt(circle)
+	}
+
+	if err := f.repos.Circle.Create(context.Background(), circle); err != nil {
+		f.t.Fatalf("creating circle: %v", err)
+	}
+	return circle
+}
+
+// Place stores an active place of the owner's with a 100m geofence
+func (f *Factory) Place(owner *models.User, opts ...func(*models.Place)) *models.Place {
+	f.t.Helper()
+
+	n := f.next()
+	place := &models.Place{
+		UserID:    owner.ID,
+		Name:      fmt.Sprintf("Place %d", n),
+		Latitude:  40.7128 + float64(n)*0.01,
+		Longitude: -74.0060,
+		Radius:    100,
+		Category:  "home",
+		IsActive:  true,
+	}
+	for _, opt := range opts {
+		opt(place)
+	}
+
+	if err := f.repos.Place.Create(context.Background(), place); err != nil {
+		f.t.Fatalf("creating place: %v", err)
+	}
+	return place
+}
+
+// InCircle makes a place the circle's
+func InCircle(circle *models.Circle) func(*models.Place) {
+	return func(place *models.Place) {
+		place.CircleID = circle.ID
+	}
+}
+
+// Message stores a text message the sender posted to the circle
+func (f *Factory) Message(circle *models.Circle, sender *models.User, content string) *models.Message {
+	f.t.Helper()
+
+	message := &models.Message{
+		CircleID: circle.ID,
+		SenderID: sender.ID,
+		Type:     "text",
+		Content:  content,
+	}
+	if err := f.repos.Message.Create(context.Background(), message); err != nil {
+		f.t.Fatalf("creating message: %v", err)
+	}
+	return message
+}
+
+func (f *Factory) next() int {
+	f.seq++
+	return f.seq
+}
+
+func circleMember(user *models.User, role string) models.CircleMember {
+	return models.CircleMember{
+		UserID: user.ID,
+		Role:   role,
+		Status: "active",
+		Permissions: models.MemberPermissions{
+			CanSeeLocation:   true,
+			CanSeeDriving:    true,
+			CanSendMessages:  true,
+			CanManagePlaces:  true,
+			CanReceiveAlerts: true,
+			CanSendEmergency: true,
+		},
+	}
+}
@@ -0,0 +1,184 @@
+package testharness
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"ftrack/models"
+	"ftrack/websocket"
+)
+
+// How long to wait for side effects services start in the background
+const waitTimeout = 5 * time.Second
+
+// HubBroadcast is a message a service sent to a circle's room
+type HubBroadcast struct {
+	RoomID  string
+	Message models.WSMessage
+	Filter  websocket.MessageFilter
+}
+
+// HubUserMessage is a message a service sent to one user's connection
+type HubUserMessage struct {
+	UserID       string
+	Notification interface{}
+}
+
+// FakeHub stands in for the WebSocket hub and records what services send
+// through it
+type FakeHub struct {
+	mu           sync.Mutex
+	broadcasts   []HubBroadcast
+	userMessages []HubUserMessage
+}
+
+func NewFakeHub() *FakeHub {
+	return &FakeHub{}
+}
+
+func (h *FakeHub) BroadcastMessage(roomID string, message models.WSMessage) {
+	h.BroadcastFiltered(roomID, message, websocket.MessageFilter{})
+}
+
+func (h *FakeHub) TryBroadcastMessage(roomID string, message models.WSMessage) bool {
+	h.BroadcastMessage(roomID, message)
+	return true
+}
+
+func (h *FakeHub) BroadcastFiltered(roomID string, message models.WSMessage, filter websocket.MessageFilter) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.broadcasts = append(h.broadcasts, HubBroadcast{RoomID: roomID, Message: message, Filter: filter})
+}
+
+func (h *FakeHub) TryBroadcastFiltered(roomID string, message models.WSMessage, filter websocket.MessageFilter) bool {
+	h.BroadcastFiltered(roomID, message, filter)
+	return true
+}
+
+func (h *FakeHub) BroadcastPlaceEvent(userID string, circleIDs []string, placeEvent models.WSPlaceEvent) {
+	for _, circleID := range circleIDs {
+		h.BroadcastMessage(circleID, models.WSMessage{
+			Type:      models.WSTypePlaceEvent,
+			Data:      placeEvent,
+			Timestamp: placeEvent.Timestamp,
+		})
+	}
+}
+
+func (h *FakeHub) SendNotificationToUser(userID string, notification interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.userMessages = append(h.userMessages, HubUserMessage{UserID: userID, Notification: notification})
+}
+
+// OnMessageDelivered does nothing; the fake delivers to no one
+func (h *FakeHub) OnMessageDelivered(fn func(userID, circleID, messageID string)) {}
+
+// Broadcasts returns the broadcasts of the event type sent so far
+func (h *FakeHub) Broadcasts(eventType string) []HubBroadcast {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var matched []HubBroadcast
+	for _, broadcast := range h.broadcasts {
+		if broadcast.Message.Type == eventType {
+			matched = append(matched, broadcast)
+		}
+	}
+	return matched
+}
+
+// UserMessages returns the messages sent to the user's connection so far
+func (h *FakeHub) UserMessages(userID string) []HubUserMessage {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var matched []HubUserMessage
+	for _, message := range h.userMessages {
+		if message.UserID == userID {
+			matched = append(matched, message)
+		}
+	}
+	return matched
+}
+
+// WaitForBroadcasts waits until n broadcasts of the event type were sent,
+// and returns them
+func (h *FakeHub) WaitForBroadcasts(t testing.TB, eventType string, n int) []HubBroadcast {
+	t.Helper()
+
+	var broadcasts []HubBroadcast
+	if !waitFor(func() bool {
+		broadcasts = h.Broadcasts(eventType)
+		return len(broadcasts) >= n
+	}) {
+		t.Fatalf("got %d %q broadcasts, want %d", len(broadcasts), eventType, n)
+	}
+	return broadcasts
+}
+
+// FakeNotifier stands in for the notification service and records the
+// notifications services send. Err, when set, is returned from every send.
+type FakeNotifier struct {
+	Err error
+
+	mu   sync.Mutex
+	sent []models.SendNotificationRequest
+}
+
+func NewFakeNotifier() *FakeNotifier {
+	return &FakeNotifier{}
+}
+
+func (n *FakeNotifier) SendNotification(ctx context.Context, req models.SendNotificationRequest) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sent = append(n.sent, req)
+	return n.Err
+}
+
+// Sent returns the notifications of the type sent so far
+func (n *FakeNotifier) Sent(notificationType string) []models.SendNotificationRequest {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var matched []models.SendNotificationRequest
+	for _, req := range n.sent {
+		if req.Type == notificationType {
+			matched = append(matched, req)
+		}
+	}
+	return matched
+}
+
+// WaitForSent waits until count notifications of the type were sent, and
+// returns them
+func (n *FakeNotifier) WaitForSent(t testing.TB, notificationType string, count int) []models.SendNotificationRequest {
+	t.Helper()
+
+	var sent []models.SendNotificationRequest
+	if !waitFor(func() bool {
+		sent = n.Sent(notificationType)
+		return len(sent) >= count
+	}) {
+		t.Fatalf("got %d %q notifications, want %d", len(sent), notificationType, count)
+	}
+	return sent
+}
+
+// waitFor polls the condition until it holds or waitTimeout passes
+func waitFor(condition func() bool) bool {
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		if condition() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
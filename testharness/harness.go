@@ -0,0 +1,206 @@
+// Package testharness runs service tests against real MongoDB and Redis.
+//
+// The servers come from TEST_MONGO_URI and TEST_REDIS_URL when set, as in
+// CI, and otherwise from containers started once per test binary. Tests
+// skip when neither is available, and with -short.
+//
+// Every test gets a database of its own, dropped when it ends, so tests
+// can run with t.Parallel without seeing each other's data. Containers are
+// removed by the testcontainers reaper when the test binary exits.
+package testharness
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ftrack/database"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"github.com/testcontainers/testcontainers-go/modules/mongodb"
+	tcredis "github.com/testcontainers/testcontainers-go/modules/redis"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	mongoImage = "mongo:7"
+	redisImage = "redis:7-alpine"
+
+	// Redis has 16 databases; each test holds one while it runs
+	redisDatabases = 16
+
+	startTimeout = 2 * time.Minute
+)
+
+var (
+	mongoOnce   sync.Once
+	mongoClient *mongo.Client
+	mongoErr    error
+
+	redisOnce sync.Once
+	redisOpts *redis.Options
+	redisErr  error
+	redisDBs  chan int
+
+	databaseSeq atomic.Int64
+)
+
+// Mongo returns an empty database for the test, migrated like a new
+// deployment's
+func Mongo(t testing.TB) *mongo.Database {
+	t.Helper()
+	skipShort(t)
+
+	mongoOnce.Do(startMongo)
+	if mongoErr != nil {
+		t.Skipf("MongoDB not available: %v", mongoErr)
+	}
+
+	db := mongoClient.Database(databaseName(t))
+	if err := database.RunMigrations(db); err != nil {
+		t.Fatalf("migrating test database: %v", err)
+	}
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := db.Drop(ctx); err != nil {
+			t.Logf("dropping test database %s: %v", db.Name(), err)
+		}
+	})
+	return db
+}
+
+// Redis returns a client on an empty Redis database the test has to
+// itself. Tests wait for one while all are taken.
+func Redis(t testing.TB) *redis.Client {
+	t.Helper()
+	skipShort(t)
+
+	redisOnce.Do(startRedis)
+	if redisErr != nil {
+		t.Skipf("Redis not available: %v", redisErr)
+	}
+
+	index := <-redisDBs
+	opts := *redisOpts
+	opts.DB = index
+	client := redis.NewClient(&opts)
+
+	ctx := context.Background()
+	if err := client.FlushDB(ctx).Err(); err != nil {
+		redisDBs <- index
+		t.Fatalf("flushing Redis database %d: %v", index, err)
+	}
+
+	t.Cleanup(func() {
+		if err := client.FlushDB(context.Background()).Err(); err != nil {
+			t.Logf("flushing Redis database %d: %v", index, err)
+		}
+		client.Close()
+		redisDBs <- index
+	})
+	return client
+}
+
+func skipShort(t testing.TB) {
+	if testing.Short() {
+		t.Skip("database test skipped with -short")
+	}
+}
+
+func startMongo() {
+	// Migrations log every step; tests only need to hear about problems
+	logrus.SetLevel(logrus.WarnLevel)
+
+	ctx, cancel := context.WithTimeout(context.Background(), startTimeout)
+	defer cancel()
+
+	uri := os.Getenv("TEST_MONGO_URI")
+	if uri == "" {
+		uri, mongoErr = startContainer(func() (string, error) {
+			container, err := mongodb.Run(ctx, mongoImage)
+			if err != nil {
+				return "", err
+			}
+			return container.ConnectionString(ctx)
+		})
+		if mongoErr != nil {
+			return
+		}
+	}
+
+	mongoClient, mongoErr = mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if mongoErr == nil {
+		mongoErr = mongoClient.Ping(ctx, nil)
+	}
+}
+
+func startRedis() {
+	ctx, cancel := context.WithTimeout(context.Background(), startTimeout)
+	defer cancel()
+
+	url := os.Getenv("TEST_REDIS_URL")
+	if url == "" {
+		url, redisErr = startContainer(func() (string, error) {
+			container, err := tcredis.Run(ctx, redisImage)
+			if err != nil {
+				return "", err
+			}
+			return container.ConnectionString(ctx)
+		})
+		if redisErr != nil {
+			return
+		}
+	}
+
+	if redisOpts, redisErr = redis.ParseURL(url); redisErr != nil {
+		return
+	}
+	client := redis.NewClient(redisOpts)
+	defer client.Close()
+	if redisErr = client.Ping(ctx).Err(); redisErr != nil {
+		return
+	}
+
+	redisDBs = make(chan int, redisDatabases)
+	for i := 0; i < redisDatabases; i++ {
+		redisDBs <- i
+	}
+}
+
+// startContainer starts a container and returns its connection string.
+// testcontainers panics when it finds no Docker; that is reported as an
+// error, so tests skip.
+func startContainer(start func() (string, error)) (uri string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("starting container: %v", r)
+		}
+	}()
+
+	if uri, err = start(); err != nil {
+		return "", fmt.Errorf("starting container: %w", err)
+	}
+	return uri, nil
+}
+
+var unsafeName = regexp.MustCompile(`[^a-zA-Z0-9_]+`)
+
+// databaseName is unique per test, and within MongoDB's 63 byte limit
+func databaseName(t testing.TB) string {
+	name := strings.Trim(unsafeName.ReplaceAllString(t.Name(), "_"), "_")
+	suffix := fmt.Sprintf("_%d_%d", os.Getpid(), databaseSeq.Add(1))
+	if max := 63 - len("test_") - len(suffix); len(name) > max {
+		name = name[:max]
+	}
+	return "test_" + name + suffix
+}
@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// ContentKeyProvider supplies the keys message content is encrypted with at
+// rest. Each key has an ID that is stored next to the ciphertext, so old
+// content stays readable after the current key is rotated. A KMS-backed
+// provider can stand in for the static one.
+type ContentKeyProvider interface {
+	// CurrentKeyID is the key new content is encrypted with
+	CurrentKeyID() string
+	// Key returns a 32-byte AES-256 key
+	Key(keyID string) ([]byte, error)
+}
+
+// StaticContentKeys is a key provider configured from the environment
+type StaticContentKeys struct {
+	currentKeyID string
+	keys         map[string][]byte
+}
+
+// NewStaticContentKeys reads keys given as "id:base64key" entries. The
+// current key must be one of them; the others only decrypt.
+func NewStaticContentKeys(currentKeyID string, entries []string) (*StaticContentKeys, error) {
+	keys := make(map[string][]byte, len(entries))
+	for _, entry := range entries {
+		keyID, encoded, found := strings.Cut(entry, ":")
+		if !found || keyID == "" {
+			return nil, errors.New("content keys must be given as id:base64key")
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("content key %s must be 32 bytes, base64 encoded", keyID)
+		}
+		keys[keyID] = key
+	}
+
+	if _, exists := keys[currentKeyID]; !exists {
+		return nil, fmt.Errorf("current content key %q is not configured", currentKeyID)
+	}
+
+	return &StaticContentKeys{currentKeyID: currentKeyID, keys: keys}, nil
+}
+
+func (sk *StaticContentKeys) CurrentKeyID() string {
+	return sk.currentKeyID
+}
+
+func (sk *StaticContentKeys) Key(keyID string) ([]byte, error) {
+	key, exists := sk.keys[keyID]
+	if !exists {
+		return nil, fmt.Errorf("content key %q not configured", keyID)
+	}
+	return key, nil
+}
+
+var (
+	contentKeys      ContentKeyProvider
+	contentKeysMutex sync.RWMutex
+)
+
+// ConfigureContentEncryption sets where content keys come from. Without a
+// provider, content of circles that ask for encryption can't be written.
+func ConfigureContentEncryption(provider ContentKeyProvider) {
+	contentKeysMutex.Lock()
+	defer contentKeysMutex.Unlock()
+	contentKeys = provider
+}
+
+// ContentEncryptionConfigured reports whether content can be encrypted
+func ContentEncryptionConfigured() bool {
+	return CurrentContentKeyID() != ""
+}
+
+// CurrentContentKeyID is the ID of the key new content is encrypted with,
+// or "" when encryption isn't configured
+func CurrentContentKeyID() string {
+	contentKeysMutex.RLock()
+	defer contentKeysMutex.RUnlock()
+
+	if contentKeys == nil {
+		return ""
+	}
+	return contentKeys.CurrentKeyID()
+}
+
+// EncryptContent encrypts content with the current key and returns the
+// ciphertext and the key's ID, both to be stored
+func EncryptContent(plaintext string) (string, string, error) {
+	keyID := CurrentContentKeyID()
+	if keyID == "" {
+		return "", "", errors.New("message encryption not configured")
+	}
+
+	gcm, err := contentCipher(keyID)
+	if err != nil {
+		return "", "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", "", err
+	}
+
+	// The key ID is authenticated, so ciphertext can't be passed off as
+	// encrypted with another key
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), []byte(keyID))
+	return base64.StdEncoding.EncodeToString(sealed), keyID, nil
+}
+
+// DecryptContent returns the plain content of stored content. Content
+// without a key ID was stored in plain text and is returned as is.
+func DecryptContent(stored, keyID string) (string, error) {
+	if keyID == "" {
+		return stored, nil
+	}
+
+	gcm, err := contentCipher(keyID)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(stored)
+	if err != nil || len(sealed) < gcm.NonceSize() {
+		return "", errors.New("invalid encrypted content")
+	}
+
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, []byte(keyID))
+	if err != nil {
+		return "", errors.New("invalid encrypted content")
+	}
+
+	return string(plaintext), nil
+}
+
+func contentCipher(keyID string) (cipher.AEAD, error) {
+	contentKeysMutex.RLock()
+	provider := contentKeys
+	contentKeysMutex.RUnlock()
+
+	if provider == nil {
+		return nil, errors.New("message encryption not configured")
+	}
+
+	key, err := provider.Key(keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -1,8 +1,10 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
 )
 
 // ServiceError represents a service-level error with context
@@ -14,6 +16,153 @@ type ServiceError struct {
 	Cause      error  `json:"-"` // Original error, not exposed in JSON
 }
 
+// ValidationFailedError reads "validation failed" so callers can keep
+// matching on the message, and carries the reason for the client and, if
+// it came from struct validation, the fields that failed
+type ValidationFailedError struct {
+	Reason string            `json:"reason"`
+	Fields []ValidationError `json:"fields,omitempty"`
+}
+
+func (e ValidationFailedError) Error() string {
+	return "validation failed"
+}
+
+// NewValidationFailedError creates a "validation failed" error with a reason
+func NewValidationFailedError(reason string) error {
+	return ValidationFailedError{Reason: reason}
+}
+
+// NewFieldValidationFailedError creates a "validation failed" error naming
+// the fields that failed, with the first one's message as the reason
+func NewFieldValidationFailedError(fields []ValidationError) error {
+	err := ValidationFailedError{Fields: fields}
+	if len(fields) > 0 {
+		err.Reason = fields[0].Message
+	}
+	return err
+}
+
+// ValidationFailureReason returns the reason of a "validation failed" error,
+// or an empty string if there is none
+func ValidationFailureReason(err error) string {
+	if validationErr, ok := err.(ValidationFailedError); ok {
+		return validationErr.Reason
+	}
+	return ""
+}
+
+// ValidationFailureFields returns the fields a "validation failed" error
+// names, if any
+func ValidationFailureFields(err error) []ValidationError {
+	if validationErr, ok := err.(ValidationFailedError); ok {
+		return validationErr.Fields
+	}
+	return nil
+}
+
+// DatabaseUnavailableError reads "database unavailable" and carries how long
+// clients should wait before retrying
+type DatabaseUnavailableError struct {
+	RetryAfter time.Duration `json:"retryAfter"`
+}
+
+func (e DatabaseUnavailableError) Error() string {
+	return "database unavailable"
+}
+
+// NewDatabaseUnavailableError creates a "database unavailable" error
+func NewDatabaseUnavailableError(retryAfter time.Duration) error {
+	return DatabaseUnavailableError{RetryAfter: retryAfter}
+}
+
+// DatabaseRetryAfter returns how long to wait after a "database unavailable"
+// error, defaulting to a second
+func DatabaseRetryAfter(err error) time.Duration {
+	if unavailableErr, ok := err.(DatabaseUnavailableError); ok && unavailableErr.RetryAfter > 0 {
+		return unavailableErr.RetryAfter
+	}
+	return time.Second
+}
+
+// ProviderUnavailableError reads "provider unavailable" while an external
+// provider's circuit is open, and carries how long until it is tried again
+type ProviderUnavailableError struct {
+	Provider   string        `json:"provider"`
+	RetryAfter time.Duration `json:"retryAfter"`
+}
+
+func (e ProviderUnavailableError) Error() string {
+	return "provider unavailable"
+}
+
+// NewProviderUnavailableError creates a "provider unavailable" error
+func NewProviderUnavailableError(provider string, retryAfter time.Duration) error {
+	return ProviderUnavailableError{Provider: provider, RetryAfter: retryAfter}
+}
+
+// IsProviderUnavailable reports whether the error is, or wraps, a "provider
+// unavailable"
+func IsProviderUnavailable(err error) bool {
+	var unavailableErr ProviderUnavailableError
+	return errors.As(err, &unavailableErr)
+}
+
+// LoginLockedError reads "too many login attempts" while an account or IP
+// address is locked out of logging in, and carries when it may try again
+type LoginLockedError struct {
+	RetryAfter time.Duration `json:"retryAfter"`
+}
+
+func (e LoginLockedError) Error() string {
+	return "too many login attempts"
+}
+
+// NewLoginLockedError creates a "too many login attempts" error
+func NewLoginLockedError(retryAfter time.Duration) error {
+	return LoginLockedError{RetryAfter: retryAfter}
+}
+
+// PermanentProviderError is a provider's answer that retrying won't change,
+// like a rejected phone number. It doesn't count against the provider.
+type PermanentProviderError struct {
+	Err error
+}
+
+func (e PermanentProviderError) Error() string {
+	return e.Err.Error()
+}
+
+func (e PermanentProviderError) Unwrap() error {
+	return e.Err
+}
+
+// NewPermanentProviderError marks a provider error as not worth retrying
+func NewPermanentProviderError(err error) error {
+	return PermanentProviderError{Err: err}
+}
+
+// QueryTimeoutError reads "query timeout" and names the collection whose
+// query ran out of time
+type QueryTimeoutError struct {
+	Collection string `json:"collection"`
+}
+
+func (e QueryTimeoutError) Error() string {
+	return "query timeout"
+}
+
+// NewQueryTimeoutError creates a "query timeout" error
+func NewQueryTimeoutError(collection string) error {
+	return QueryTimeoutError{Collection: collection}
+}
+
+// IsQueryTimeout reports whether the error is, or wraps, a "query timeout"
+func IsQueryTimeout(err error) bool {
+	var timeoutErr QueryTimeoutError
+	return errors.As(err, &timeoutErr)
+}
+
 // AppError represents a custom application error
 type AppError struct {
 	// Type represents the error category
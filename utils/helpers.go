@@ -631,6 +631,11 @@ func IsValidMediaFile(filename, mediaType string) bool {
 }
 
 func HandleServiceError(c *gin.Context, err error) {
+	if IsProviderUnavailable(err) {
+		ProviderUnavailableResponse(c, err)
+		return
+	}
+
 	switch err.Error() {
 	case "access denied":
 		ForbiddenResponse(c, "Access denied")
@@ -644,8 +649,14 @@ func HandleServiceError(c *gin.Context, err error) {
 		BadRequestResponse(c, "Invalid place ID")
 	case "invalid coordinates":
 		BadRequestResponse(c, "Invalid coordinates")
+	case "database unavailable":
+		DatabaseUnavailableResponse(c, DatabaseRetryAfter(err))
+	case "query timeout":
+		QueryTimeoutResponse(c)
 	case "radius must be between 10 and 5000 meters":
 		BadRequestResponse(c, "Radius must be between 10 and 5000 meters")
+	case "validation failed":
+		ValidationFailedResponse(c, "Validation failed", err)
 	default:
 		InternalServerErrorResponse(c, "Internal server error")
 	}
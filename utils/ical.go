@@ -0,0 +1,242 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	icalDateFormat     = "20060102"
+	icalDateTimeFormat = "20060102T150405"
+	icalLineLimit      = 75 // octets, before folding
+)
+
+// ICalEvent is one VEVENT of a calendar. Timed events are written in their
+// TimeZone, or in UTC when it's nil, and without an End they take no time.
+// All-day events end the day after their last day, as iCalendar expects.
+type ICalEvent struct {
+	UID          string
+	Summary      string
+	Description  string
+	Location     string
+	Start        time.Time
+	End          time.Time
+	AllDay       bool
+	TimeZone     *time.Location
+	LastModified time.Time
+}
+
+// ICalendar builds an iCalendar (RFC 5545) document. Every timezone the
+// events use gets a VTIMEZONE block covering their dates, so clients don't
+// need to know the zone names.
+type ICalendar struct {
+	Name   string
+	events []ICalEvent
+}
+
+func NewICalendar(name string) *ICalendar {
+	return &ICalendar{Name: name}
+}
+
+func (ic *ICalendar) AddEvent(event ICalEvent) {
+	ic.events = append(ic.events, event)
+}
+
+// Render writes the calendar, stamped with the given time
+func (ic *ICalendar) Render(now time.Time) []byte {
+	var builder strings.Builder
+	write := func(name, value string) {
+		builder.WriteString(foldICalLine(name + ":" + value))
+	}
+
+	write("BEGIN", "VCALENDAR")
+	write("VERSION", "2.0")
+	write("PRODID", "-//ftrack//Calendar Feed//EN")
+	write("CALSCALE", "GREGORIAN")
+	write("METHOD", "PUBLISH")
+	if ic.Name != "" {
+		write("X-WR-CALNAME", escapeICalText(ic.Name))
+	}
+
+	for _, zone := range ic.zoneRanges() {
+		for _, line := range icalTimezone(zone.location, zone.from, zone.to) {
+			builder.WriteString(foldICalLine(line))
+		}
+	}
+
+	stamp := now.UTC().Format(icalDateTimeFormat) + "Z"
+	for _, event := range ic.events {
+		write("BEGIN", "VEVENT")
+		write("UID", event.UID)
+		write("DTSTAMP", stamp)
+		switch {
+		case event.AllDay:
+			write("DTSTART;VALUE=DATE", event.Start.Format(icalDateFormat))
+			write("DTEND;VALUE=DATE", event.End.Format(icalDateFormat))
+		case isUTCZone(event.TimeZone):
+			write("DTSTART", event.Start.UTC().Format(icalDateTimeFormat)+"Z")
+			if !event.End.IsZero() {
+				write("DTEND", event.End.UTC().Format(icalDateTimeFormat)+"Z")
+			}
+		default:
+			tzid := event.TimeZone.String()
+			write("DTSTART;TZID="+tzid, event.Start.In(event.TimeZone).Format(icalDateTimeFormat))
+			if !event.End.IsZero() {
+				write("DTEND;TZID="+tzid, event.End.In(event.TimeZone).Format(icalDateTimeFormat))
+			}
+		}
+		write("SUMMARY", escapeICalText(event.Summary))
+		if event.Description != "" {
+			write("DESCRIPTION", escapeICalText(event.Description))
+		}
+		if event.Location != "" {
+			write("LOCATION", escapeICalText(event.Location))
+		}
+		if !event.LastModified.IsZero() {
+			write("LAST-MODIFIED", event.LastModified.UTC().Format(icalDateTimeFormat)+"Z")
+		}
+		write("END", "VEVENT")
+	}
+
+	write("END", "VCALENDAR")
+	return []byte(builder.String())
+}
+
+type icalZoneRange struct {
+	location *time.Location
+	from, to time.Time
+}
+
+// zoneRanges returns the timezones the timed events use, with the span of
+// their dates, by name
+func (ic *ICalendar) zoneRanges() []icalZoneRange {
+	ranges := make(map[string]*icalZoneRange)
+	for _, event := range ic.events {
+		if event.AllDay || isUTCZone(event.TimeZone) {
+			continue
+		}
+		end := event.Start
+		if event.End.After(end) {
+			end = event.End
+		}
+
+		name := event.TimeZone.String()
+		zone, exists := ranges[name]
+		if !exists {
+			ranges[name] = &icalZoneRange{location: event.TimeZone, from: event.Start, to: end}
+			continue
+		}
+		if event.Start.Before(zone.from) {
+			zone.from = event.Start
+		}
+		if end.After(zone.to) {
+			zone.to = end
+		}
+	}
+
+	sorted := make([]icalZoneRange, 0, len(ranges))
+	for _, zone := range ranges {
+		sorted = append(sorted, *zone)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].location.String() < sorted[j].location.String() })
+	return sorted
+}
+
+// icalTimezone writes the zone's VTIMEZONE for the span: the offset in
+// effect at its start, then every change of offset within it
+func icalTimezone(location *time.Location, from, to time.Time) []string {
+	from = from.Add(-24 * time.Hour)
+	to = to.Add(24 * time.Hour)
+
+	lines := []string{"BEGIN:VTIMEZONE", "TZID:" + location.String()}
+	addObservance := func(at time.Time, offsetFrom int) {
+		name, offset := at.In(location).Zone()
+		kind := "STANDARD"
+		if at.In(location).IsDST() {
+			kind = "DAYLIGHT"
+		}
+		lines = append(lines,
+			"BEGIN:"+kind,
+			// Onset in the local time that was in effect before it
+			"DTSTART:"+at.UTC().Add(time.Duration(offsetFrom)*time.Second).Format(icalDateTimeFormat),
+			"TZOFFSETFROM:"+icalOffset(offsetFrom),
+			"TZOFFSETTO:"+icalOffset(offset),
+			"TZNAME:"+name,
+			"END:"+kind,
+		)
+	}
+
+	_, offset := from.In(location).Zone()
+	addObservance(from, offset)
+
+	for day := from; day.Before(to); day = day.Add(24 * time.Hour) {
+		next := day.Add(24 * time.Hour)
+		_, nextOffset := next.In(location).Zone()
+		if nextOffset == offset {
+			continue
+		}
+
+		// Narrow the change down to the second it happens
+		low, high := day, next
+		for high.Sub(low) > time.Second {
+			middle := low.Add(high.Sub(low) / 2)
+			if _, middleOffset := middle.In(location).Zone(); middleOffset == offset {
+				low = middle
+			} else {
+				high = middle
+			}
+		}
+
+		addObservance(high.Truncate(time.Second), offset)
+		offset = nextOffset
+	}
+
+	return append(lines, "END:VTIMEZONE")
+}
+
+func isUTCZone(location *time.Location) bool {
+	return location == nil || location == time.UTC || location.String() == "UTC"
+}
+
+// icalOffset formats a UTC offset in seconds as +HHMM
+func icalOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign = "-"
+		seconds = -seconds
+	}
+	return fmt.Sprintf("%s%02d%02d", sign, seconds/3600, seconds%3600/60)
+}
+
+func escapeICalText(text string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	).Replace(text)
+}
+
+// foldICalLine splits a content line into lines of at most 75 octets,
+// without splitting a character, and ends it with CRLF
+func foldICalLine(line string) string {
+	var builder strings.Builder
+	limit := icalLineLimit
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		builder.WriteString(line[:cut])
+		builder.WriteString("\r\n ")
+		line = line[cut:]
+		limit = icalLineLimit - 1 // the leading space counts
+	}
+	builder.WriteString(line)
+	builder.WriteString("\r\n")
+	return builder.String()
+}
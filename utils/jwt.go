@@ -1,23 +1,75 @@
 package utils
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"errors"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// Token audiences. A token is only accepted for the audience it was issued
+// for, so e.g. a WebSocket ticket can't be used as an access token.
+const (
+	AudienceAccess   = "access"
+	AudienceRefresh  = "refresh"
+	AudienceWSTicket = "ws-ticket"
+	AudienceInvite   = "invite"
+)
+
+const (
+	tokenIssuer = "ftrack"
+
+	signingKeySize = 32
+)
+
+// SigningKey is a key of the JWT key ring. The active key signs new tokens;
+// retired keys only verify tokens until they expire.
+type SigningKey struct {
+	ID        string
+	Secret    []byte
+	CreatedAt time.Time
+	ExpiresAt time.Time // zero if the key doesn't expire
+}
+
 type JWTService struct {
-	secretKey       []byte
 	accessTokenTTL  time.Duration
 	refreshTokenTTL time.Duration
+	wsTicketTTL     time.Duration
+
+	mutex sync.RWMutex
+
+	// Keys from config. The JWT secret signs until a stored key exists.
+	configKeys  []SigningKey
+	keys        map[string]SigningKey
+	activeKeyID string
+
+	// Tokens issued before key IDs carry no kid header. They are verified
+	// with the JWT secret until legacyUntil.
+	legacySecret []byte
+	legacyUntil  time.Time
 }
 
 type Claims struct {
 	UserID    string `json:"userId"`
 	Email     string `json:"email"`
 	Role      string `json:"role"`
-	TokenType string `json:"tokenType"` // access, refresh
+	TokenType string `json:"tokenType"`          // access, refresh, ws-ticket, invite
+	AuthTime  int64  `json:"authTime,omitempty"` // unix time of the last interactive login
+
+	// Invite tokens only: the invitation a link stands for, and its circle
+	CircleID     string `json:"circleId,omitempty"`
+	InvitationID string `json:"invitationId,omitempty"`
+
+	// Impersonation tokens only: the admin acting as the user, and the
+	// session that lets them
+	ImpersonatorID  string `json:"impersonatorId,omitempty"`
+	ImpersonationID string `json:"impersonationId,omitempty"`
+
 	jwt.RegisteredClaims
 }
 
@@ -30,22 +82,137 @@ type TokenPair struct {
 }
 
 func NewJWTService(secretKey string) *JWTService {
-	return &JWTService{
-		secretKey:       []byte(secretKey),
+	j := &JWTService{
 		accessTokenTTL:  15 * time.Minute,   // Short-lived access token
 		refreshTokenTTL: 7 * 24 * time.Hour, // 7 days refresh token
+		wsTicketTTL:     30 * time.Second,   // Only needs to outlive the connection upgrade
+		legacySecret:    []byte(secretKey),
+	}
+
+	j.configKeys = []SigningKey{{ID: SigningKeyID([]byte(secretKey)), Secret: []byte(secretKey)}}
+	j.SetKeys(nil)
+	return j
+}
+
+// RefreshTokenTTL is how long tokens stay valid after they are issued, so
+// retired keys must keep verifying for at least this long
+func (j *JWTService) RefreshTokenTTL() time.Duration {
+	return j.refreshTokenTTL
+}
+
+// WSTicketTTL is how long WebSocket tickets are valid
+func (j *JWTService) WSTicketTTL() time.Duration {
+	return j.wsTicketTTL
+}
+
+// AddVerificationSecrets accepts tokens signed with previous JWT secrets, so
+// changing the secret in config doesn't log everyone out
+func (j *JWTService) AddVerificationSecrets(secrets []string) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		key := SigningKey{ID: SigningKeyID([]byte(secret)), Secret: []byte(secret)}
+		j.configKeys = append(j.configKeys, key)
+		j.keys[key.ID] = key
+	}
+}
+
+// AcceptLegacyTokens accepts tokens without a key ID until the given time
+func (j *JWTService) AcceptLegacyTokens(until time.Time) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.legacyUntil = until
+}
+
+// SetKeys replaces the stored keys of the ring. The newest key that doesn't
+// expire signs new tokens. Once a stored key exists the JWT secret from
+// config is retired, and verifies until tokens signed before can expire.
+func (j *JWTService) SetKeys(stored []SigningKey) {
+	j.mutex.RLock()
+	configKeys := append([]SigningKey(nil), j.configKeys...)
+	j.mutex.RUnlock()
+
+	keys := make(map[string]SigningKey, len(stored)+len(configKeys))
+
+	activeKeyID := configKeys[0].ID
+	var firstStored time.Time
+	if len(stored) > 0 {
+		sorted := append([]SigningKey(nil), stored...)
+		sort.Slice(sorted, func(a, b int) bool {
+			return sorted[a].CreatedAt.Before(sorted[b].CreatedAt)
+		})
+
+		firstStored = sorted[0].CreatedAt
+		for _, key := range sorted {
+			keys[key.ID] = key
+			if key.ExpiresAt.IsZero() {
+				activeKeyID = key.ID
+			}
+		}
+	}
+
+	for i, key := range configKeys {
+		if i == 0 && !firstStored.IsZero() {
+			key.ExpiresAt = firstStored.Add(j.refreshTokenTTL)
+		}
+		if _, exists := keys[key.ID]; !exists {
+			keys[key.ID] = key
+		}
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.keys = keys
+	j.activeKeyID = activeKeyID
+}
+
+// ActiveKeyID returns the ID of the key new tokens are signed with
+func (j *JWTService) ActiveKeyID() string {
+	j.mutex.RLock()
+	defer j.mutex.RUnlock()
+	return j.activeKeyID
+}
+
+// NewSigningKey generates a random key for the ring
+func NewSigningKey() (SigningKey, error) {
+	secret := make([]byte, signingKeySize)
+	if _, err := rand.Read(secret); err != nil {
+		return SigningKey{}, err
 	}
+
+	return SigningKey{
+		ID:        SigningKeyID(secret),
+		Secret:    secret,
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+// SigningKeyID derives the kid of a key from its secret, so every instance
+// gives a key from config the same ID
+func SigningKeyID(secret []byte) string {
+	sum := sha256.Sum256(append([]byte("kid:"), secret...))
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
 }
 
 func (j *JWTService) GenerateTokenPair(userID, email, role string) (*TokenPair, error) {
+	return j.generateTokenPair(userID, email, role, time.Now().Unix())
+}
+
+// generateTokenPair issues a token pair carrying the given authentication time,
+// so refreshed tokens keep the time of the original login.
+func (j *JWTService) generateTokenPair(userID, email, role string, authTime int64) (*TokenPair, error) {
 	// Generate access token
-	accessToken, err := j.generateToken(userID, email, role, "access", j.accessTokenTTL)
+	accessToken, err := j.generateToken(Claims{UserID: userID, Email: email, Role: role, AuthTime: authTime}, AudienceAccess, j.accessTokenTTL)
 	if err != nil {
 		return nil, err
 	}
 
 	// Generate refresh token
-	refreshToken, err := j.generateToken(userID, email, role, "refresh", j.refreshTokenTTL)
+	refreshToken, err := j.generateToken(Claims{UserID: userID, Email: email, Role: role, AuthTime: authTime}, AudienceRefresh, j.refreshTokenTTL)
 	if err != nil {
 		return nil, err
 	}
@@ -59,71 +226,146 @@ func (j *JWTService) GenerateTokenPair(userID, email, role string) (*TokenPair,
 	}, nil
 }
 
-func (j *JWTService) generateToken(userID, email, role, tokenType string, ttl time.Duration) (string, error) {
+// GenerateWSTicket issues a short-lived ticket for opening a WebSocket, so
+// access tokens don't end up in URLs
+func (j *JWTService) GenerateWSTicket(userID string) (string, error) {
+	return j.generateToken(Claims{UserID: userID}, AudienceWSTicket, j.wsTicketTTL)
+}
+
+// GenerateInviteToken issues a token for a circle invite link, valid until
+// the invitation expires
+func (j *JWTService) GenerateInviteToken(invitationID, circleID, inviterID string, expiresAt time.Time) (string, error) {
+	claims := Claims{UserID: inviterID, CircleID: circleID, InvitationID: invitationID}
+	return j.generateToken(claims, AudienceInvite, time.Until(expiresAt))
+}
+
+// GenerateImpersonationToken issues an access token for the user carrying
+// the admin and session it was issued for. There is no refresh token: the
+// session ends when it expires.
+func (j *JWTService) GenerateImpersonationToken(userID, email, role, adminID, sessionID string, ttl time.Duration) (string, error) {
+	claims := Claims{
+		UserID:          userID,
+		Email:           email,
+		Role:            role,
+		ImpersonatorID:  adminID,
+		ImpersonationID: sessionID,
+	}
+	return j.generateToken(claims, AudienceAccess, ttl)
+}
+
+func (j *JWTService) generateToken(claims Claims, audience string, ttl time.Duration) (string, error) {
 	now := time.Now()
 	expiresAt := now.Add(ttl)
 
-	claims := Claims{
-		UserID:    userID,
-		Email:     email,
-		Role:      role,
-		TokenType: tokenType,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expiresAt),
-			IssuedAt:  jwt.NewNumericDate(now),
-			NotBefore: jwt.NewNumericDate(now),
-			Issuer:    "ftrack",
-			Subject:   userID,
-			ID:        GenerateUUID(),
-		},
+	claims.TokenType = audience
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		Issuer:    tokenIssuer,
+		Subject:   claims.UserID,
+		Audience:  jwt.ClaimStrings{audience},
+		ID:        GenerateUUID(),
 	}
 
+	j.mutex.RLock()
+	key := j.keys[j.activeKeyID]
+	j.mutex.RUnlock()
+
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(j.secretKey)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Secret)
 }
 
-func (j *JWTService) ValidateToken(tokenString string) (*Claims, error) {
+// ValidateToken verifies a token and checks it was issued for the audience
+func (j *JWTService) ValidateToken(tokenString, audience string) (*Claims, error) {
+	legacy := false
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, errors.New("invalid signing method")
 		}
-		return j.secretKey, nil
-	})
+
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			legacy = true
+			return j.legacyKey()
+		}
+		return j.verificationKey(kid)
+	}, jwt.WithIssuer(tokenIssuer))
 
 	if err != nil {
 		return nil, err
 	}
 
-	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
-		return claims, nil
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	if claims.TokenType != audience {
+		return nil, errors.New("invalid token type")
+	}
+
+	// Legacy tokens have no audience, only their type
+	if !legacy || len(claims.Audience) > 0 {
+		if len(claims.Audience) != 1 || claims.Audience[0] != audience {
+			return nil, errors.New("invalid token audience")
+		}
 	}
 
-	return nil, errors.New("invalid token")
+	return claims, nil
+}
+
+func (j *JWTService) verificationKey(kid string) ([]byte, error) {
+	j.mutex.RLock()
+	key, exists := j.keys[kid]
+	j.mutex.RUnlock()
+
+	if !exists {
+		return nil, errors.New("unknown signing key")
+	}
+	if !key.ExpiresAt.IsZero() && time.Now().After(key.ExpiresAt) {
+		return nil, errors.New("signing key expired")
+	}
+	return key.Secret, nil
+}
+
+func (j *JWTService) legacyKey() ([]byte, error) {
+	j.mutex.RLock()
+	defer j.mutex.RUnlock()
+
+	if time.Now().After(j.legacyUntil) {
+		return nil, errors.New("legacy token no longer accepted")
+	}
+	return j.legacySecret, nil
 }
 
 func (j *JWTService) RefreshToken(refreshTokenString string) (*TokenPair, error) {
-	claims, err := j.ValidateToken(refreshTokenString)
+	claims, err := j.ValidateToken(refreshTokenString, AudienceRefresh)
 	if err != nil {
 		return nil, err
 	}
-
-	if claims.TokenType != "refresh" {
-		return nil, errors.New("invalid token type")
+	if claims.ImpersonationID != "" {
+		return nil, errors.New("impersonation tokens can't be refreshed")
 	}
 
-	// Generate new token pair
-	return j.GenerateTokenPair(claims.UserID, claims.Email, claims.Role)
+	// Generate new token pair, preserving the original authentication time
+	authTime := claims.AuthTime
+	if authTime == 0 && claims.IssuedAt != nil {
+		authTime = claims.IssuedAt.Unix()
+	}
+	return j.generateTokenPair(claims.UserID, claims.Email, claims.Role, authTime)
 }
 
 func (j *JWTService) RevokeToken(tokenString string) error {
 	// In a production environment, you would store revoked tokens in Redis
 	// For now, we'll just validate the token format
-	_, err := j.ValidateToken(tokenString)
+	_, err := j.ValidateToken(tokenString, AudienceAccess)
 	return err
 }
 
 func (j *JWTService) ExtractUserID(tokenString string) (string, error) {
-	claims, err := j.ValidateToken(tokenString)
+	claims, err := j.ValidateToken(tokenString, AudienceAccess)
 	if err != nil {
 		return "", err
 	}
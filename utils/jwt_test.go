@@ -0,0 +1,154 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// legacyToken signs a token the way tokens were issued before key IDs: no
+// kid header and no audience
+func legacyToken(t *testing.T, secret, tokenType string) string {
+	t.Helper()
+
+	now := time.Now()
+	claims := Claims{
+		UserID:    "user-1",
+		TokenType: tokenType,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Issuer:    tokenIssuer,
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatalf("signing legacy token: %v", err)
+	}
+	return token
+}
+
+func TestValidateTokenAudience(t *testing.T) {
+	j := NewJWTService("secret")
+
+	ticket, err := j.GenerateWSTicket("user-1")
+	if err != nil {
+		t.Fatalf("GenerateWSTicket: %v", err)
+	}
+
+	if _, err := j.ValidateToken(ticket, AudienceWSTicket); err != nil {
+		t.Errorf("ticket rejected for its own audience: %v", err)
+	}
+	for _, audience := range []string{AudienceAccess, AudienceRefresh, AudienceInvite} {
+		if _, err := j.ValidateToken(ticket, audience); err == nil {
+			t.Errorf("ticket accepted as %s token", audience)
+		}
+	}
+}
+
+func TestValidateTokenKeyRotation(t *testing.T) {
+	j := NewJWTService("secret")
+
+	before, err := j.GenerateTokenPair("user-1", "user@example.com", "user")
+	if err != nil {
+		t.Fatalf("GenerateTokenPair: %v", err)
+	}
+
+	key, err := NewSigningKey()
+	if err != nil {
+		t.Fatalf("NewSigningKey: %v", err)
+	}
+	j.SetKeys([]SigningKey{key})
+	if j.ActiveKeyID() != key.ID {
+		t.Fatalf("active key = %q, want the stored key %q", j.ActiveKeyID(), key.ID)
+	}
+
+	after, err := j.GenerateTokenPair("user-1", "user@example.com", "user")
+	if err != nil {
+		t.Fatalf("GenerateTokenPair: %v", err)
+	}
+
+	// The retired config key keeps verifying tokens it signed
+	for name, token := range map[string]string{"before rotation": before.AccessToken, "after rotation": after.AccessToken} {
+		if _, err := j.ValidateToken(token, AudienceAccess); err != nil {
+			t.Errorf("token signed %s rejected: %v", name, err)
+		}
+	}
+
+	// Once the stored key is gone its tokens are rejected
+	j.SetKeys(nil)
+	if _, err := j.ValidateToken(after.AccessToken, AudienceAccess); err == nil {
+		t.Error("token signed with a removed key accepted")
+	}
+}
+
+func TestValidateTokenUnknownKey(t *testing.T) {
+	other := NewJWTService("other-secret")
+	token, err := other.GenerateWSTicket("user-1")
+	if err != nil {
+		t.Fatalf("GenerateWSTicket: %v", err)
+	}
+
+	if _, err := NewJWTService("secret").ValidateToken(token, AudienceWSTicket); err == nil {
+		t.Error("token with an unknown kid accepted")
+	}
+}
+
+func TestValidateTokenLegacy(t *testing.T) {
+	tests := []struct {
+		name      string
+		until     time.Time
+		secret    string
+		tokenType string
+		audience  string
+		valid     bool
+	}{
+		{"within grace", time.Now().Add(time.Hour), "secret", AudienceAccess, AudienceAccess, true},
+		{"after grace", time.Now().Add(-time.Hour), "secret", AudienceAccess, AudienceAccess, false},
+		{"no grace configured", time.Time{}, "secret", AudienceAccess, AudienceAccess, false},
+		{"wrong type", time.Now().Add(time.Hour), "secret", AudienceRefresh, AudienceAccess, false},
+		{"wrong secret", time.Now().Add(time.Hour), "other-secret", AudienceAccess, AudienceAccess, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := NewJWTService("secret")
+			j.AcceptLegacyTokens(tt.until)
+
+			_, err := j.ValidateToken(legacyToken(t, tt.secret, tt.tokenType), tt.audience)
+			if (err == nil) != tt.valid {
+				t.Errorf("ValidateToken error = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestGenerateInviteToken(t *testing.T) {
+	j := NewJWTService("secret")
+
+	token, err := j.GenerateInviteToken("invitation-1", "circle-1", "user-1", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GenerateInviteToken: %v", err)
+	}
+
+	claims, err := j.ValidateToken(token, AudienceInvite)
+	if err != nil {
+		t.Fatalf("invite token rejected: %v", err)
+	}
+	if claims.InvitationID != "invitation-1" || claims.CircleID != "circle-1" || claims.UserID != "user-1" {
+		t.Errorf("claims = %+v, want the invitation, circle and inviter", claims)
+	}
+
+	if _, err := j.ValidateToken(token, AudienceAccess); err == nil {
+		t.Error("invite token accepted as an access token")
+	}
+
+	expired, err := j.GenerateInviteToken("invitation-1", "circle-1", "user-1", time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("GenerateInviteToken: %v", err)
+	}
+	if _, err := j.ValidateToken(expired, AudienceInvite); err == nil {
+		t.Error("invite token of an expired invitation accepted")
+	}
+}
@@ -0,0 +1,22 @@
+package utils
+
+import (
+	"time"
+
+	"ftrack/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ConfigureLocationStaleness sets how long without an update a member's
+// location shows as stale and as offline, in circles that don't set their
+// own. Call it at startup, before serving requests.
+func ConfigureLocationStaleness(staleAfter, offlineAfter time.Duration) {
+	if staleAfter <= 0 || offlineAfter < staleAfter {
+		logrus.Errorf("Ignoring invalid location staleness settings %s/%s", staleAfter, offlineAfter)
+		return
+	}
+
+	models.DefaultLocationStaleAfter = staleAfter
+	models.DefaultLocationOfflineAfter = offlineAfter
+}
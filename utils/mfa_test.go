@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+)
+
+func TestMFASecretEncryption(t *testing.T) {
+	ConfigureMFAEncryption("test mfa key")
+
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: "FTrack", AccountName: "user@example.com"})
+	if err != nil {
+		t.Fatalf("generating secret: %v", err)
+	}
+	secret := key.Secret()
+
+	stored, err := EncryptMFASecret(secret)
+	if err != nil {
+		t.Fatalf("EncryptMFASecret: %v", err)
+	}
+	if !strings.HasPrefix(stored, encryptedMFASecretPrefix) || strings.Contains(stored, secret) {
+		t.Fatalf("stored secret %q is not encrypted", stored)
+	}
+	if again, _ := EncryptMFASecret(secret); again == stored {
+		t.Error("encrypting twice gave the same ciphertext")
+	}
+
+	decrypted, err := DecryptMFASecret(stored)
+	if err != nil || decrypted != secret {
+		t.Errorf("DecryptMFASecret = %q, %v; want the secret", decrypted, err)
+	}
+
+	// Secrets stored before encryption are read as is
+	if plain, err := DecryptMFASecret(secret); err != nil || plain != secret {
+		t.Errorf("DecryptMFASecret of a plain secret = %q, %v", plain, err)
+	}
+
+	tampered := stored[:len(stored)-4] + "AAAA"
+	if _, err := DecryptMFASecret(tampered); err == nil {
+		t.Error("tampered secret decrypted")
+	}
+
+	code, err := totp.GenerateCode(secret, time.Now())
+	if err != nil {
+		t.Fatalf("generating code: %v", err)
+	}
+	tests := []struct {
+		name   string
+		stored string
+		code   string
+		want   bool
+	}{
+		{"encrypted secret", stored, code, true},
+		{"plain secret", secret, code, true},
+		{"padded code", stored, " " + code + " ", true},
+		{"wrong code", stored, "abcdef", false},
+		{"no code", stored, "", false},
+		{"no secret", "", code, false},
+		{"tampered secret", tampered, code, false},
+	}
+	for _, tt := range tests {
+		if got := ValidateTOTPCode(tt.stored, tt.code); got != tt.want {
+			t.Errorf("%s: ValidateTOTPCode = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Notification templates are plain text with {variable} placeholders
+var notificationTemplateVariablePattern = regexp.MustCompile(`\{([a-zA-Z_]+)\}`)
+
+// NotificationTemplateVariables returns the variables a template uses, in
+// order of first use
+func NotificationTemplateVariables(template string) []string {
+	seen := make(map[string]bool)
+	variables := []string{}
+
+	for _, match := range notificationTemplateVariablePattern.FindAllStringSubmatch(template, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			variables = append(variables, match[1])
+		}
+	}
+
+	return variables
+}
+
+// ValidateNotificationTemplate returns a "validation failed" error naming the
+// first variable of the template that is not in the allowed set
+func ValidateNotificationTemplate(template string, allowed []string) error {
+	allowedSet := make(map[string]bool, len(allowed))
+	for _, variable := range allowed {
+		allowedSet[variable] = true
+	}
+
+	for _, variable := range NotificationTemplateVariables(template) {
+		if !allowedSet[variable] {
+			return NewValidationFailedError(fmt.Sprintf("unknown template variable {%s}", variable))
+		}
+	}
+
+	return nil
+}
+
+// RenderNotificationTemplate replaces the template variables with their
+// values. Variables without a value are left as they are.
+func RenderNotificationTemplate(template string, values map[string]string) string {
+	return notificationTemplateVariablePattern.ReplaceAllStringFunc(template, func(placeholder string) string {
+		if value, ok := values[placeholder[1:len(placeholder)-1]]; ok {
+			return value
+		}
+		return placeholder
+	})
+}
@@ -101,7 +101,12 @@ func (ns *NotificationService) SendPushNotification(ctx context.Context, deviceT
 		},
 	}
 
-	response, err := ns.fcmClient.Send(ctx, message)
+	var response string
+	err := Providers.Do(ctx, ProviderPush, func(ctx context.Context) error {
+		var err error
+		response, err = ns.fcmClient.Send(ctx, message)
+		return err
+	})
 	if err != nil {
 		return &NotificationResult{
 			Success: false,
@@ -125,7 +130,12 @@ func (ns *NotificationService) SendPushToMultipleDevices(ctx context.Context, de
 		Data: notification.Data,
 	}
 
-	response, err := ns.fcmClient.SendMulticast(ctx, message)
+	var response *messaging.BatchResponse
+	err := Providers.Do(ctx, ProviderPush, func(ctx context.Context) error {
+		var err error
+		response, err = ns.fcmClient.SendMulticast(ctx, message)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -155,7 +165,12 @@ func (ns *NotificationService) SendSMS(ctx context.Context, sms SMSMessage) (*No
 	params.SetFrom(ns.twilioNumber)
 	params.SetBody(sms.Message)
 
-	resp, err := ns.twilioClient.Api.CreateMessage(params)
+	var resp *twilioApi.ApiV2010Message
+	err := Providers.Do(ctx, ProviderSMS, func(ctx context.Context) error {
+		var err error
+		resp, err = ns.twilioClient.Api.CreateMessage(params)
+		return err
+	})
 	if err != nil {
 		return &NotificationResult{
 			Success: false,
@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"strconv"
+
+	"ftrack/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Pagination is a list request's page and page size
+type Pagination struct {
+	Page     int `json:"page"`
+	PageSize int `json:"pageSize"`
+}
+
+// Offset is the number of items before the page
+func (p Pagination) Offset() int {
+	return (p.Page - 1) * p.PageSize
+}
+
+// ConfigurePagination sets the default page sizes and the largest page size
+// clients may ask for. Call it at startup, before serving requests.
+func ConfigurePagination(defaultPageSize, historyPageSize, maxPageSize int) {
+	if defaultPageSize < 1 || historyPageSize < 1 || maxPageSize < 1 {
+		logrus.Errorf("Ignoring invalid pagination settings %d/%d/%d", defaultPageSize, historyPageSize, maxPageSize)
+		return
+	}
+
+	models.MaxPageSize = maxPageSize
+	models.DefaultPageSize = min(defaultPageSize, maxPageSize)
+	models.DefaultHistoryPageSize = min(historyPageSize, maxPageSize)
+}
+
+// ParsePagination reads the page and pageSize query parameters. Missing or
+// invalid values get the defaults and page sizes are capped.
+func ParsePagination(c *gin.Context) Pagination {
+	return ParsePaginationWithDefault(c, models.DefaultPageSize)
+}
+
+// ParseHistoryPagination is ParsePagination for history lists like chat,
+// which default to longer pages
+func ParseHistoryPagination(c *gin.Context) Pagination {
+	return ParsePaginationWithDefault(c, models.DefaultHistoryPageSize)
+}
+
+// ParsePaginationWithDefault is ParsePagination with a list's own default
+// page size
+func ParsePaginationWithDefault(c *gin.Context, defaultPageSize int) Pagination {
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("pageSize"))
+
+	page, pageSize = models.NormalizePageWithDefault(page, pageSize, defaultPageSize)
+	return Pagination{Page: page, PageSize: pageSize}
+}
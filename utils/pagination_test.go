@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"ftrack/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func paginationContext(query string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/items?"+query, nil)
+	return c
+}
+
+// restorePagination puts back the page sizes a test configures
+func restorePagination(t *testing.T) {
+	defaults, history, maxSize := models.DefaultPageSize, models.DefaultHistoryPageSize, models.MaxPageSize
+	t.Cleanup(func() {
+		models.DefaultPageSize, models.DefaultHistoryPageSize, models.MaxPageSize = defaults, history, maxSize
+	})
+}
+
+func TestParsePagination(t *testing.T) {
+	restorePagination(t)
+	ConfigurePagination(20, 50, 100)
+
+	tests := []struct {
+		query  string
+		want   Pagination
+		offset int
+	}{
+		{"", Pagination{Page: 1, PageSize: 20}, 0},
+		{"page=3&pageSize=10", Pagination{Page: 3, PageSize: 10}, 20},
+		{"page=0&pageSize=0", Pagination{Page: 1, PageSize: 20}, 0},
+		{"page=-1&pageSize=-10", Pagination{Page: 1, PageSize: 20}, 0},
+		{"page=abc&pageSize=xyz", Pagination{Page: 1, PageSize: 20}, 0},
+		{"page=2&pageSize=100", Pagination{Page: 2, PageSize: 100}, 100},
+		{"page=2&pageSize=101", Pagination{Page: 2, PageSize: 100}, 100},
+		{"pageSize=5000", Pagination{Page: 1, PageSize: 100}, 0},
+	}
+	for _, tt := range tests {
+		got := ParsePagination(paginationContext(tt.query))
+		if got != tt.want {
+			t.Errorf("ParsePagination(%q) = %+v, want %+v", tt.query, got, tt.want)
+		}
+		if got.Offset() != tt.offset {
+			t.Errorf("ParsePagination(%q).Offset() = %d, want %d", tt.query, got.Offset(), tt.offset)
+		}
+	}
+
+	if got := ParseHistoryPagination(paginationContext("")); got.PageSize != 50 {
+		t.Errorf("history default page size = %d, want 50", got.PageSize)
+	}
+	if got := ParsePaginationWithDefault(paginationContext(""), 35); got.PageSize != 35 {
+		t.Errorf("list default page size = %d, want 35", got.PageSize)
+	}
+}
+
+func TestConfigurePagination(t *testing.T) {
+	restorePagination(t)
+
+	ConfigurePagination(10, 40, 25)
+	if got := ParsePagination(paginationContext("")); got.PageSize != 10 {
+		t.Errorf("configured default page size = %d, want 10", got.PageSize)
+	}
+	// Defaults larger than the cap are capped
+	if got := ParseHistoryPagination(paginationContext("")); got.PageSize != 25 {
+		t.Errorf("history default over the cap = %d, want 25", got.PageSize)
+	}
+	if got := ParsePagination(paginationContext("pageSize=30")); got.PageSize != 25 {
+		t.Errorf("page size over the configured cap = %d, want 25", got.PageSize)
+	}
+
+	// Invalid settings are ignored
+	ConfigurePagination(0, 40, 200)
+	if models.MaxPageSize != 25 || models.DefaultPageSize != 10 {
+		t.Errorf("invalid settings applied: default %d, max %d", models.DefaultPageSize, models.MaxPageSize)
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"regexp"
 	"strings"
 
 	"golang.org/x/crypto/argon2"
@@ -38,12 +37,9 @@ func NewPasswordService() *PasswordService {
 	}
 }
 
+// HashPassword hashes any password. New user passwords are checked against
+// the password policy first, with ValidatePasswordStrength.
 func (p *PasswordService) HashPassword(password string) (string, error) {
-	// Validate password strength
-	if err := p.ValidatePasswordStrength(password); err != nil {
-		return "", err
-	}
-
 	// Generate random salt
 	salt, err := p.generateRandomBytes(p.config.SaltLength)
 	if err != nil {
@@ -78,40 +74,10 @@ func (p *PasswordService) ComparePassword(password, encodedHash string) (bool, e
 	return subtle.ConstantTimeCompare(hash, otherHash) == 1, nil
 }
 
-func (p *PasswordService) ValidatePasswordStrength(password string) error {
-	if len(password) < 8 {
-		return errors.New("password must be at least 8 characters long")
-	}
-
-	if len(password) > 128 {
-		return errors.New("password must be less than 128 characters long")
-	}
-
-	// Check for at least one uppercase letter
-	hasUpper := regexp.MustCompile(`[A-Z]`).MatchString(password)
-	if !hasUpper {
-		return errors.New("password must contain at least one uppercase letter")
-	}
-
-	// Check for at least one lowercase letter
-	hasLower := regexp.MustCompile(`[a-z]`).MatchString(password)
-	if !hasLower {
-		return errors.New("password must contain at least one lowercase letter")
-	}
-
-	// Check for at least one number
-	hasNumber := regexp.MustCompile(`[0-9]`).MatchString(password)
-	if !hasNumber {
-		return errors.New("password must contain at least one number")
-	}
-
-	// Check for at least one special character
-	hasSpecial := regexp.MustCompile(`[!@#$%^&*()_+\-=\[\]{};':"\\|,.<>\/?]`).MatchString(password)
-	if !hasSpecial {
-		return errors.New("password must contain at least one special character")
-	}
-
-	return nil
+// ValidatePasswordStrength checks a new password against the password
+// policy, see CheckPasswordPolicy
+func (p *PasswordService) ValidatePasswordStrength(password string, userInputs ...string) error {
+	return CheckPasswordPolicy(password, userInputs...)
 }
 
 func (p *PasswordService) GenerateRandomPassword(length int) (string, error) {
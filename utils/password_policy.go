@@ -0,0 +1,262 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PasswordPolicy is what new passwords are checked against. MinScore is a
+// zxcvbn style score from 0 (guessable in under a thousand tries) to 4
+// (more than ten billion).
+type PasswordPolicy struct {
+	MinLength        int
+	MaxLength        int
+	MinScore         int
+	BreachedListPath string // one password per line, e.g. the top 100k of breach corpora
+}
+
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength: 10,
+	MaxLength: 128,
+	MinScore:  3,
+}
+
+// Codes of policy violations, returned as the code of the password field
+const (
+	PasswordCodeTooShort     = "PASSWORD_TOO_SHORT"
+	PasswordCodeTooLong      = "PASSWORD_TOO_LONG"
+	PasswordCodeBreached     = "PASSWORD_BREACHED"
+	PasswordCodePersonalInfo = "PASSWORD_CONTAINS_PERSONAL_INFO"
+	PasswordCodeTooWeak      = "PASSWORD_TOO_WEAK"
+)
+
+var (
+	passwordPolicy      = DefaultPasswordPolicy
+	breachedPasswords   = map[string]bool{}
+	passwordPolicyMutex sync.RWMutex
+)
+
+// ConfigurePasswordPolicy sets the policy and loads its breached password
+// list. Without the list passwords are only scored.
+func ConfigurePasswordPolicy(policy PasswordPolicy) {
+	if policy.MinLength <= 0 {
+		policy.MinLength = DefaultPasswordPolicy.MinLength
+	}
+	if policy.MaxLength < policy.MinLength {
+		policy.MaxLength = DefaultPasswordPolicy.MaxLength
+	}
+	if policy.MinScore < 0 || policy.MinScore > 4 {
+		policy.MinScore = DefaultPasswordPolicy.MinScore
+	}
+
+	breached := map[string]bool{}
+	if policy.BreachedListPath != "" {
+		var err error
+		if breached, err = loadBreachedPasswords(policy.BreachedListPath); err != nil {
+			logrus.Warnf("Breached password list not loaded: %v", err)
+			breached = map[string]bool{}
+		}
+	}
+
+	passwordPolicyMutex.Lock()
+	passwordPolicy = policy
+	breachedPasswords = breached
+	passwordPolicyMutex.Unlock()
+
+	logrus.Infof("Password policy min length=%d min score=%d breached passwords=%d",
+		policy.MinLength, policy.MinScore, len(breached))
+}
+
+func loadBreachedPasswords(path string) (map[string]bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	passwords := make(map[string]bool, 100000)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if password := strings.ToLower(strings.TrimSpace(scanner.Text())); password != "" {
+			passwords[password] = true
+		}
+	}
+	return passwords, scanner.Err()
+}
+
+// CheckPasswordPolicy returns a "validation failed" error naming what is
+// wrong with the password and how to fix it, or nil if it is allowed.
+// userInputs are the user's own details, like their name and email, which
+// don't count towards the password's strength.
+func CheckPasswordPolicy(password string, userInputs ...string) error {
+	passwordPolicyMutex.RLock()
+	policy := passwordPolicy
+	breached := breachedPasswords
+	passwordPolicyMutex.RUnlock()
+
+	length := len([]rune(password))
+	switch {
+	case length < policy.MinLength:
+		return passwordPolicyError(PasswordCodeTooShort,
+			fmt.Sprintf("Use at least %d characters. A few unrelated words make a long password that is easy to remember.", policy.MinLength))
+	case length > policy.MaxLength:
+		return passwordPolicyError(PasswordCodeTooLong,
+			fmt.Sprintf("Use at most %d characters.", policy.MaxLength))
+	case breached[strings.ToLower(password)]:
+		return passwordPolicyError(PasswordCodeBreached,
+			"This password has appeared in a data breach and is among the first ones attackers try. Choose one you haven't used anywhere else.")
+	}
+
+	lower := strings.ToLower(password)
+	for _, input := range personalInputs(userInputs) {
+		if strings.Contains(lower, input) {
+			return passwordPolicyError(PasswordCodePersonalInfo,
+				"Don't use your name, email or phone number in your password.")
+		}
+	}
+
+	if PasswordStrengthScore(password, breached) < policy.MinScore {
+		return passwordPolicyError(PasswordCodeTooWeak,
+			"This password is easy to guess. Avoid common words, repeated characters and sequences like abc or 123, or add another unrelated word.")
+	}
+
+	return nil
+}
+
+func passwordPolicyError(code, guidance string) error {
+	return NewFieldValidationFailedError([]ValidationError{{
+		Field:   "password",
+		Tag:     "password_policy",
+		Message: guidance,
+		Code:    code,
+	}})
+}
+
+// personalInputs splits the user's details into the parts long enough to
+// matter, e.g. an email into its local part and domain name
+func personalInputs(userInputs []string) []string {
+	var inputs []string
+	for _, input := range userInputs {
+		for _, part := range strings.FieldsFunc(strings.ToLower(input), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			if len(part) >= 4 {
+				inputs = append(inputs, part)
+			}
+		}
+	}
+	return inputs
+}
+
+// PasswordStrengthScore scores a password from 0 to 4 by an estimate of
+// the guesses needed to find it: under 10^3, 10^6, 10^8, 10^10, or more.
+// Like zxcvbn, parts that are common passwords, repeats or sequences are
+// counted as a few guesses rather than by their length.
+func PasswordStrengthScore(password string, dictionary map[string]bool) int {
+	guesses := passwordGuessesLog10(password, dictionary)
+	switch {
+	case guesses < 3:
+		return 0
+	case guesses < 6:
+		return 1
+	case guesses < 8:
+		return 2
+	case guesses < 10:
+		return 3
+	}
+	return 4
+}
+
+// passwordGuessesLog10 estimates log10 of the guesses needed for the password
+func passwordGuessesLog10(password string, dictionary map[string]bool) float64 {
+	runes := []rune(password)
+	lower := []rune(strings.ToLower(password))
+	perChar := math.Log10(float64(passwordCharsetSize(runes)))
+
+	var guesses float64
+	for i := 0; i < len(runes); {
+		// The longest common password starting here counts as one guess
+		// among the dictionary's entries
+		if length := longestDictionaryMatch(lower[i:], dictionary); length > 0 {
+			guesses += math.Log10(float64(len(dictionary)))
+			i += length
+			continue
+		}
+
+		// Repeats and steps from the previous character barely add to it
+		if i > 0 {
+			step := lower[i] - lower[i-1]
+			if step >= -1 && step <= 1 {
+				guesses += math.Log10(2)
+				i++
+				continue
+			}
+		}
+
+		guesses += perChar
+		i++
+	}
+	return guesses
+}
+
+const minDictionaryMatch = 4
+
+func longestDictionaryMatch(runes []rune, dictionary map[string]bool) int {
+	if len(dictionary) == 0 {
+		return 0
+	}
+	for length := len(runes); length >= minDictionaryMatch; length-- {
+		if dictionary[string(runes[:length])] {
+			return length
+		}
+	}
+	return 0
+}
+
+// passwordCharsetSize is the number of characters an attacker has to try
+// for each position, from the kinds of characters the password uses
+func passwordCharsetSize(runes []rune) int {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range runes {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	size := 0
+	if lower {
+		size += 26
+	}
+	if upper {
+		size += 26
+	}
+	if digit {
+		size += 10
+	}
+	if symbol {
+		size += 33
+	}
+	if other {
+		size += 100
+	}
+	if size == 0 {
+		size = 1
+	}
+	return size
+}
@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Words rejected in user content like place reviews. Matching is by whole
+// word after undoing common letter substitutions, so "class" doesn't match
+// "ass" but "a$$" does.
+var defaultProfanity = []string{
+	"arse", "arsehole", "ass", "asshole", "bastard", "bitch", "bollocks",
+	"bullshit", "cock", "crap", "cunt", "dick", "dickhead", "fag", "faggot",
+	"fuck", "fucker", "fucking", "motherfucker", "nigger", "piss", "prick",
+	"pussy", "retard", "shit", "shitty", "slut", "twat", "wanker", "whore",
+}
+
+var (
+	profanity      = wordSet(defaultProfanity)
+	profanityMutex sync.RWMutex
+)
+
+var leetReplacer = strings.NewReplacer(
+	"0", "o", "1", "i", "3", "e", "4", "a", "5", "s", "7", "t", "@", "a", "$", "s", "!", "i",
+)
+
+func wordSet(words []string) map[string]bool {
+	set := make(map[string]bool, len(words))
+	for _, word := range words {
+		if word = strings.ToLower(strings.TrimSpace(word)); word != "" {
+			set[word] = true
+		}
+	}
+	return set
+}
+
+// ConfigureProfanityFilter adds words to the built-in list
+func ConfigureProfanityFilter(extraWords []string) {
+	profanityMutex.Lock()
+	defer profanityMutex.Unlock()
+
+	profanity = wordSet(append(append([]string{}, defaultProfanity...), extraWords...))
+}
+
+// ContainsProfanity reports whether text contains a filtered word
+func ContainsProfanity(text string) bool {
+	profanityMutex.RLock()
+	defer profanityMutex.RUnlock()
+
+	normalized := leetReplacer.Replace(strings.ToLower(text))
+	words := strings.FieldsFunc(normalized, func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+
+	for _, word := range words {
+		if profanity[word] || profanity[collapseRepeats(word)] {
+			return true
+		}
+		for _, suffix := range []string{"s", "es", "ed", "ing"} {
+			if stem := strings.TrimSuffix(word, suffix); stem != word && profanity[stem] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// collapseRepeats shortens runs of a letter to one, e.g. "shiiit" to "shit"
+func collapseRepeats(word string) string {
+	var b strings.Builder
+	var last rune
+	for i, r := range word {
+		if i > 0 && r == last {
+			continue
+		}
+		b.WriteRune(r)
+		last = r
+	}
+	return b.String()
+}
@@ -0,0 +1,389 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// External providers the API calls
+const (
+	ProviderPush       = "push"
+	ProviderSMS        = "sms"
+	ProviderEmail      = "email"
+	ProviderStaticMaps = "static_maps"
+	ProviderWebhook    = "webhook" // one circuit per host, e.g. "webhook:hooks.example.com"
+	ProviderStorage    = "storage" // the object store direct media uploads go to
+)
+
+// Provider circuit states
+const (
+	ProviderCircuitClosed   = "closed"
+	ProviderCircuitOpen     = "open"
+	ProviderCircuitHalfOpen = "half_open"
+)
+
+// ProviderConfig controls how long a provider call may take, how it is
+// retried and when the provider's circuit opens
+type ProviderConfig struct {
+	// Longest a single attempt may take
+	Timeout time.Duration `json:"timeout"`
+
+	// Attempts after the first; the wait doubles from BaseBackoff up to
+	// MaxBackoff, with jitter
+	MaxRetries  int           `json:"maxRetries"`
+	BaseBackoff time.Duration `json:"baseBackoff"`
+	MaxBackoff  time.Duration `json:"maxBackoff"`
+
+	// Consecutive failed attempts that open the circuit
+	FailureThreshold int `json:"failureThreshold"`
+
+	// Time the circuit stays open before a trial call is let through
+	Cooldown time.Duration `json:"cooldown"`
+}
+
+// ProviderStats are a provider's circuit state and counters since start
+type ProviderStats struct {
+	Name                string           `json:"name"`
+	State               string           `json:"state"`
+	Since               time.Time        `json:"since"`
+	ConsecutiveFailures int              `json:"consecutiveFailures"`
+	Calls               int64            `json:"calls"`
+	Failures            int64            `json:"failures"`
+	Retries             int64            `json:"retries"`
+	Rejected            int64            `json:"rejected"`
+	Transitions         map[string]int64 `json:"transitions"` // by state entered
+	LastFailure         string           `json:"lastFailure,omitempty"`
+	LastFailureAt       *time.Time       `json:"lastFailureAt,omitempty"`
+}
+
+// ProviderClient wraps calls to an external provider with a timeout per
+// attempt, retries with backoff, and a circuit breaker. Once the provider
+// keeps failing the circuit opens and calls fail fast with "provider
+// unavailable", instead of holding request goroutines on a provider that
+// won't answer. After the cooldown one trial call decides whether it closes.
+type ProviderClient struct {
+	name string
+
+	mutex               sync.Mutex
+	config              ProviderConfig
+	state               string
+	since               time.Time
+	consecutiveFailures int
+	trialInFlight       bool
+	calls               int64
+	failures            int64
+	retries             int64
+	rejected            int64
+	transitions         map[string]int64
+	lastFailure         string
+	lastFailureAt       *time.Time
+}
+
+func NewProviderClient(name string, config ProviderConfig) *ProviderClient {
+	return &ProviderClient{
+		name:        name,
+		config:      config,
+		state:       ProviderCircuitClosed,
+		since:       time.Now(),
+		transitions: make(map[string]int64),
+	}
+}
+
+// Do runs the call, retrying failed attempts while the circuit allows.
+// Errors wrapped with NewPermanentProviderError are returned right away and
+// don't count against the provider, as it did answer.
+func (pc *ProviderClient) Do(ctx context.Context, call func(ctx context.Context) error) error {
+	pc.mutex.Lock()
+	config := pc.config
+	pc.calls++
+	pc.mutex.Unlock()
+
+	var backoff time.Duration
+	for attempt := 0; ; attempt++ {
+		if err := pc.allow(); err != nil {
+			return err
+		}
+
+		attemptCtx, cancel := context.WithTimeout(ctx, config.Timeout)
+		err := call(attemptCtx)
+		cancel()
+
+		if err == nil {
+			pc.recordSuccess()
+			return nil
+		}
+
+		var permanent PermanentProviderError
+		if errors.As(err, &permanent) {
+			pc.recordSuccess()
+			return permanent.Err
+		}
+
+		// The caller giving up isn't the provider's fault
+		if ctx.Err() != nil {
+			pc.releaseTrial()
+			return err
+		}
+
+		pc.recordFailure(err)
+		if attempt >= config.MaxRetries {
+			return err
+		}
+
+		if backoff == 0 {
+			backoff = config.BaseBackoff
+		} else if backoff *= 2; backoff > config.MaxBackoff {
+			backoff = config.MaxBackoff
+		}
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+
+		pc.mutex.Lock()
+		pc.retries++
+		pc.mutex.Unlock()
+	}
+}
+
+// allow returns a "provider unavailable" error while the circuit is open,
+// and while a trial call is deciding whether to close it
+func (pc *ProviderClient) allow() error {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	switch pc.state {
+	case ProviderCircuitClosed:
+		return nil
+	case ProviderCircuitOpen:
+		if time.Since(pc.since) >= pc.config.Cooldown {
+			pc.setState(ProviderCircuitHalfOpen)
+			pc.trialInFlight = true
+			return nil
+		}
+	case ProviderCircuitHalfOpen:
+		if !pc.trialInFlight {
+			pc.trialInFlight = true
+			return nil
+		}
+	}
+
+	pc.rejected++
+	return NewProviderUnavailableError(pc.name, pc.retryAfter())
+}
+
+func (pc *ProviderClient) retryAfter() time.Duration {
+	if pc.state != ProviderCircuitOpen {
+		return time.Second
+	}
+	remaining := pc.config.Cooldown - time.Since(pc.since)
+	if remaining < time.Second {
+		return time.Second
+	}
+	return remaining
+}
+
+func (pc *ProviderClient) recordSuccess() {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	pc.trialInFlight = false
+	pc.consecutiveFailures = 0
+	if pc.state != ProviderCircuitClosed {
+		pc.setState(ProviderCircuitClosed)
+	}
+}
+
+func (pc *ProviderClient) recordFailure(err error) {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	now := time.Now()
+	pc.failures++
+	pc.consecutiveFailures++
+	pc.lastFailure = err.Error()
+	pc.lastFailureAt = &now
+	pc.trialInFlight = false
+
+	switch pc.state {
+	case ProviderCircuitHalfOpen:
+		pc.setState(ProviderCircuitOpen)
+	case ProviderCircuitClosed:
+		if pc.consecutiveFailures >= pc.config.FailureThreshold {
+			pc.setState(ProviderCircuitOpen)
+		}
+	}
+}
+
+// releaseTrial lets another call try the provider when the trial call
+// ended without an answer either way
+func (pc *ProviderClient) releaseTrial() {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	pc.trialInFlight = false
+}
+
+// setState logs the transition once, instead of every failed call logging
+// its own error. Callers hold the mutex.
+func (pc *ProviderClient) setState(state string) {
+	previous := pc.state
+	pc.state = state
+	pc.since = time.Now()
+	pc.transitions[state]++
+
+	entry := logrus.WithFields(logrus.Fields{
+		"provider":    pc.name,
+		"from":        previous,
+		"to":          state,
+		"lastFailure": pc.lastFailure,
+	})
+	if state == ProviderCircuitClosed {
+		entry.Info("Provider circuit breaker closed")
+	} else {
+		entry.Warn("Provider circuit breaker state changed")
+	}
+}
+
+// Stats returns the provider's circuit state and counters
+func (pc *ProviderClient) Stats() ProviderStats {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	transitions := make(map[string]int64, len(pc.transitions))
+	for state, count := range pc.transitions {
+		transitions[state] = count
+	}
+
+	return ProviderStats{
+		Name:                pc.name,
+		State:               pc.state,
+		Since:               pc.since,
+		ConsecutiveFailures: pc.consecutiveFailures,
+		Calls:               pc.calls,
+		Failures:            pc.failures,
+		Retries:             pc.retries,
+		Rejected:            pc.rejected,
+		Transitions:         transitions,
+		LastFailure:         pc.lastFailure,
+		LastFailureAt:       pc.lastFailureAt,
+	}
+}
+
+// ProviderRegistry hands out one client per provider, so every caller of a
+// provider shares its circuit
+type ProviderRegistry struct {
+	mutex   sync.RWMutex
+	config  ProviderConfig
+	clients map[string]*ProviderClient
+}
+
+// Providers wraps the API's and the workers' external calls
+var Providers = NewProviderRegistry(ProviderConfig{
+	Timeout:          10 * time.Second,
+	MaxRetries:       2,
+	BaseBackoff:      200 * time.Millisecond,
+	MaxBackoff:       2 * time.Second,
+	FailureThreshold: 5,
+	Cooldown:         30 * time.Second,
+})
+
+func NewProviderRegistry(config ProviderConfig) *ProviderRegistry {
+	return &ProviderRegistry{
+		config:  config,
+		clients: make(map[string]*ProviderClient),
+	}
+}
+
+// Configure sets the timeouts, retries and circuit settings of every
+// provider
+func (pr *ProviderRegistry) Configure(timeout time.Duration, maxRetries, failureThreshold int, cooldown time.Duration) {
+	if timeout <= 0 || maxRetries < 0 || failureThreshold < 1 || cooldown <= 0 {
+		logrus.Errorf("Ignoring invalid provider settings %s/%d/%d/%s", timeout, maxRetries, failureThreshold, cooldown)
+		return
+	}
+
+	pr.mutex.Lock()
+	defer pr.mutex.Unlock()
+
+	pr.config.Timeout = timeout
+	pr.config.MaxRetries = maxRetries
+	pr.config.FailureThreshold = failureThreshold
+	pr.config.Cooldown = cooldown
+	for _, client := range pr.clients {
+		client.mutex.Lock()
+		client.config = pr.config
+		client.mutex.Unlock()
+	}
+}
+
+// Client returns the provider's client, creating it on first use
+func (pr *ProviderRegistry) Client(name string) *ProviderClient {
+	pr.mutex.RLock()
+	client, exists := pr.clients[name]
+	pr.mutex.RUnlock()
+	if exists {
+		return client
+	}
+
+	pr.mutex.Lock()
+	defer pr.mutex.Unlock()
+	if client, exists := pr.clients[name]; exists {
+		return client
+	}
+	client = NewProviderClient(name, pr.config)
+	pr.clients[name] = client
+	return client
+}
+
+// Do runs the call through the provider's client
+func (pr *ProviderRegistry) Do(ctx context.Context, name string, call func(ctx context.Context) error) error {
+	return pr.Client(name).Do(ctx, call)
+}
+
+// Stats returns every provider's circuit state and counters, by name
+func (pr *ProviderRegistry) Stats() []ProviderStats {
+	pr.mutex.RLock()
+	clients := make([]*ProviderClient, 0, len(pr.clients))
+	for _, client := range pr.clients {
+		clients = append(clients, client)
+	}
+	pr.mutex.RUnlock()
+
+	stats := make([]ProviderStats, 0, len(clients))
+	for _, client := range clients {
+		stats = append(stats, client.Stats())
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+// Unavailable returns the providers whose circuit isn't closed
+func (pr *ProviderRegistry) Unavailable() []string {
+	unavailable := []string{}
+	for _, stats := range pr.Stats() {
+		if stats.State != ProviderCircuitClosed {
+			unavailable = append(unavailable, stats.Name)
+		}
+	}
+	return unavailable
+}
+
+// ProviderStatusError makes the error for an unsuccessful HTTP status
+// permanent when retrying can't help: client errors other than timeouts and
+// rate limits
+func ProviderStatusError(err error, status int) error {
+	if status >= 400 && status < 500 && status != http.StatusRequestTimeout && status != http.StatusTooManyRequests {
+		return NewPermanentProviderError(err)
+	}
+	return err
+}
@@ -0,0 +1,224 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testProviderConfig() ProviderConfig {
+	return ProviderConfig{
+		Timeout:          time.Second,
+		MaxRetries:       1,
+		BaseBackoff:      time.Millisecond,
+		MaxBackoff:       2 * time.Millisecond,
+		FailureThreshold: 3,
+		Cooldown:         50 * time.Millisecond,
+	}
+}
+
+// flakyProvider fails every call with err until it is set to nil
+type flakyProvider struct {
+	err   error
+	calls int
+}
+
+func (p *flakyProvider) call(ctx context.Context) error {
+	p.calls++
+	return p.err
+}
+
+func TestProviderClientTripsOnSustainedFailures(t *testing.T) {
+	client := NewProviderClient(ProviderSMS, testProviderConfig())
+	provider := &flakyProvider{err: errors.New("502 bad gateway")}
+	ctx := context.Background()
+
+	// Two attempts fail without reaching the threshold
+	if err := client.Do(ctx, provider.call); err == nil || err.Error() != "502 bad gateway" {
+		t.Fatalf("first call error = %v, want the provider's", err)
+	}
+	if stats := client.Stats(); stats.State != ProviderCircuitClosed || stats.ConsecutiveFailures != 2 || stats.Retries != 1 {
+		t.Errorf("after two failures stats = %+v, want closed with 2 failures and a retry", stats)
+	}
+
+	// The third trips the circuit, and the retry is refused
+	err := client.Do(ctx, provider.call)
+	var unavailable ProviderUnavailableError
+	if !errors.As(err, &unavailable) || unavailable.Provider != ProviderSMS || unavailable.RetryAfter != time.Second {
+		t.Fatalf("tripping call error = %#v, want provider unavailable for a second", err)
+	}
+	if provider.calls != 3 {
+		t.Errorf("provider called %d times, want 3", provider.calls)
+	}
+
+	// Open, calls fail fast without reaching the provider
+	for i := 0; i < 5; i++ {
+		if err := client.Do(ctx, provider.call); !IsProviderUnavailable(err) {
+			t.Fatalf("call while open error = %v, want provider unavailable", err)
+		}
+	}
+	stats := client.Stats()
+	if provider.calls != 3 || stats.State != ProviderCircuitOpen || stats.Calls != 7 || stats.Failures != 3 ||
+		stats.Rejected != 6 || stats.Transitions[ProviderCircuitOpen] != 1 || stats.LastFailure != "502 bad gateway" || stats.LastFailureAt == nil {
+		t.Errorf("while open stats = %+v after %d provider calls, want open with 3 failures and 6 rejected", stats, provider.calls)
+	}
+}
+
+func TestProviderClientHalfOpenTrial(t *testing.T) {
+	config := testProviderConfig()
+	config.MaxRetries = 0
+	config.FailureThreshold = 1
+	client := NewProviderClient(ProviderPush, config)
+	provider := &flakyProvider{err: errors.New("connection refused")}
+	ctx := context.Background()
+
+	client.Do(ctx, provider.call)
+	if state := client.Stats().State; state != ProviderCircuitOpen {
+		t.Fatalf("state after a failure %s, want open", state)
+	}
+
+	// A failed trial opens the circuit for another cooldown
+	time.Sleep(config.Cooldown)
+	if err := client.Do(ctx, provider.call); err == nil || err.Error() != "connection refused" {
+		t.Errorf("failed trial error = %v, want the provider's", err)
+	}
+	if err := client.Do(ctx, provider.call); !IsProviderUnavailable(err) {
+		t.Errorf("call after a failed trial error = %v, want provider unavailable", err)
+	}
+
+	// While a trial is in flight the others still fail fast
+	time.Sleep(config.Cooldown)
+	provider.err = nil
+	trialStarted, finishTrial := make(chan struct{}), make(chan struct{})
+	trialDone := make(chan error)
+	go func() {
+		trialDone <- client.Do(ctx, func(ctx context.Context) error {
+			close(trialStarted)
+			<-finishTrial
+			return nil
+		})
+	}()
+	<-trialStarted
+	if state := client.Stats().State; state != ProviderCircuitHalfOpen {
+		t.Errorf("state during the trial %s, want half open", state)
+	}
+	if err := client.Do(ctx, provider.call); !IsProviderUnavailable(err) {
+		t.Errorf("call during the trial error = %v, want provider unavailable", err)
+	}
+	close(finishTrial)
+	if err := <-trialDone; err != nil {
+		t.Fatalf("successful trial: %v", err)
+	}
+
+	// A successful trial closes it
+	if err := client.Do(ctx, provider.call); err != nil {
+		t.Errorf("call after the circuit closed: %v", err)
+	}
+	stats := client.Stats()
+	if stats.State != ProviderCircuitClosed || stats.ConsecutiveFailures != 0 ||
+		stats.Transitions[ProviderCircuitOpen] != 2 || stats.Transitions[ProviderCircuitHalfOpen] != 2 || stats.Transitions[ProviderCircuitClosed] != 1 {
+		t.Errorf("after recovery stats = %+v, want closed after opening twice", stats)
+	}
+}
+
+func TestProviderClientTimesOutSlowProviders(t *testing.T) {
+	config := testProviderConfig()
+	config.Timeout = 20 * time.Millisecond
+	config.FailureThreshold = 2
+	client := NewProviderClient(ProviderEmail, config)
+
+	// A provider that never answers holds each attempt for the timeout only
+	hang := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	start := time.Now()
+	if err := client.Do(context.Background(), hang); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("hanging call error = %v, want the deadline", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("hanging call took %s", elapsed)
+	}
+	if stats := client.Stats(); stats.State != ProviderCircuitOpen || stats.Failures != 2 {
+		t.Errorf("after timeouts stats = %+v, want open with 2 failures", stats)
+	}
+}
+
+func TestProviderClientFailuresNotCounted(t *testing.T) {
+	client := NewProviderClient(ProviderSMS, testProviderConfig())
+
+	// A rejected request is the provider answering; it isn't retried
+	calls := 0
+	rejected := errors.New("invalid phone number")
+	err := client.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		return NewPermanentProviderError(rejected)
+	})
+	if err != rejected || calls != 1 {
+		t.Errorf("permanent error = %v after %d calls, want the provider's answer after 1", err, calls)
+	}
+
+	// Nor is the caller giving up
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 5; i++ {
+		if err := client.Do(ctx, func(ctx context.Context) error { return ctx.Err() }); !errors.Is(err, context.Canceled) {
+			t.Errorf("cancelled call error = %v", err)
+		}
+	}
+
+	if stats := client.Stats(); stats.State != ProviderCircuitClosed || stats.Failures != 0 || stats.Retries != 0 {
+		t.Errorf("stats = %+v, want closed without failures", stats)
+	}
+}
+
+func TestProviderStatusError(t *testing.T) {
+	for _, tt := range []struct {
+		status    int
+		permanent bool
+	}{
+		{http.StatusBadRequest, true},
+		{http.StatusUnauthorized, true},
+		{http.StatusNotFound, true},
+		{http.StatusRequestTimeout, false},
+		{http.StatusTooManyRequests, false},
+		{http.StatusInternalServerError, false},
+		{http.StatusServiceUnavailable, false},
+	} {
+		var permanent PermanentProviderError
+		if got := errors.As(ProviderStatusError(errors.New("status"), tt.status), &permanent); got != tt.permanent {
+			t.Errorf("status %d permanent = %v, want %v", tt.status, got, tt.permanent)
+		}
+	}
+}
+
+func TestProviderRegistry(t *testing.T) {
+	registry := NewProviderRegistry(testProviderConfig())
+	ctx := context.Background()
+
+	if registry.Client(ProviderPush) != registry.Client(ProviderPush) {
+		t.Error("callers of a provider got different clients")
+	}
+
+	// Settings apply to clients already handed out; invalid ones are
+	// ignored
+	registry.Configure(time.Second, 0, 1, time.Minute)
+	registry.Configure(0, 0, 0, 0)
+	registry.Do(ctx, "webhook:hooks.example.com", func(ctx context.Context) error { return errors.New("timeout") })
+	registry.Do(ctx, ProviderPush, func(ctx context.Context) error { return errors.New("timeout") })
+	registry.Do(ctx, ProviderEmail, func(ctx context.Context) error { return nil })
+
+	var names []string
+	for _, stats := range registry.Stats() {
+		names = append(names, stats.Name+"="+stats.State)
+	}
+	if got := strings.Join(names, ","); got != "email=closed,push=open,webhook:hooks.example.com=open" {
+		t.Errorf("stats = %s, want every provider by name, the failed ones open", got)
+	}
+	if got := strings.Join(registry.Unavailable(), ","); got != "push,webhook:hooks.example.com" {
+		t.Errorf("unavailable providers = %s", got)
+	}
+}
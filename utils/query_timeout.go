@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+type queryTimeoutKey struct{}
+
+// TrackQueryTimeouts returns a context that remembers whether any database
+// query made with it ran out of time, so the response can say so even when
+// the error was wrapped on the way up
+func TrackQueryTimeouts(ctx context.Context) context.Context {
+	return context.WithValue(ctx, queryTimeoutKey{}, new(atomic.Bool))
+}
+
+// MarkQueryTimeout records on a tracking context that a query timed out
+func MarkQueryTimeout(ctx context.Context) {
+	if flag, ok := ctx.Value(queryTimeoutKey{}).(*atomic.Bool); ok {
+		flag.Store(true)
+	}
+}
+
+// QueryTimedOut reports whether a query made with the context timed out
+func QueryTimedOut(ctx context.Context) bool {
+	flag, ok := ctx.Value(queryTimeoutKey{}).(*atomic.Bool)
+	return ok && flag.Load()
+}
@@ -1,11 +1,18 @@
 package utils
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"ftrack/models"
+	"math"
 	"net/http"
+	"reflect"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
 )
 
 // Success responses
@@ -51,19 +58,78 @@ func ErrorResponse(c *gin.Context, statusCode int, message string, details inter
 	})
 }
 
-func ValidationErrorResponse(c *gin.Context, validationErrors []ValidationError) {
-	c.JSON(http.StatusBadRequest, models.APIResponse{
+// ValidationErrorResponse sends a 422 naming each field that failed
+// validation, under the message for clients that only show that
+func ValidationErrorResponse(c *gin.Context, message string, validationErrors []ValidationError) {
+	fieldErrors := make([]models.FieldError, 0, len(validationErrors))
+	for _, validationErr := range validationErrors {
+		fieldErrors = append(fieldErrors, models.FieldError{
+			Field:   validationErr.Field,
+			Rule:    validationErr.Tag,
+			Code:    validationErr.Code,
+			Message: validationErr.Message,
+		})
+	}
+
+	c.JSON(http.StatusUnprocessableEntity, models.APIResponse{
 		Success: false,
-		Message: "Validation failed",
+		Message: message,
 		Error: &models.APIError{
 			Code:    models.ErrCodeValidation,
-			Message: "Validation failed",
-			Details: validationErrors,
+			Message: message,
 		},
+		Errors:    fieldErrors,
 		Timestamp: time.Now(),
 	})
 }
 
+// ValidationFailedResponse answers a "validation failed" error with a 422
+// naming the fields that failed. The message ends with the reason.
+func ValidationFailedResponse(c *gin.Context, message string, err error) {
+	if reason := ValidationFailureReason(err); reason != "" {
+		message += ": " + reason
+	}
+	ValidationErrorResponse(c, message, ValidationFailureFields(err))
+}
+
+// BindErrorResponse answers a request body that couldn't be bound. A value
+// of the wrong type or a failed binding rule gets a 422 naming the field,
+// malformed JSON a 400.
+func BindErrorResponse(c *gin.Context, message string, err error) {
+	var typeErr *json.UnmarshalTypeError
+	var fieldErrors validator.ValidationErrors
+
+	switch {
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		ValidationErrorResponse(c, message, []ValidationError{{
+			Field:   typeErr.Field,
+			Tag:     "type",
+			Message: fmt.Sprintf("%s must be %s", typeErr.Field, jsonTypeName(typeErr.Type)),
+		}})
+	case errors.As(err, &fieldErrors):
+		ValidationErrorResponse(c, message, fieldValidationErrors(fieldErrors))
+	default:
+		BadRequestResponse(c, message)
+	}
+}
+
+// jsonTypeName describes the JSON value a Go type is bound from
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "a list"
+	}
+	return "an object"
+}
+
 func UnauthorizedResponse(c *gin.Context, message string) {
 	if message == "" {
 		message = "Unauthorized access"
@@ -94,6 +160,24 @@ func ForbiddenResponse(c *gin.Context, message string) {
 	})
 }
 
+// ExportPermissionRequiredResponse sends a 403 the client can turn into a
+// request for permission to export the circle
+func ExportPermissionRequiredResponse(c *gin.Context, circleID string) {
+	message := "This circle's export policy doesn't let you export it, ask an admin for permission"
+	c.JSON(http.StatusForbidden, models.APIResponse{
+		Success: false,
+		Message: message,
+		Error: &models.APIError{
+			Code:    models.ErrCodeExportPermission,
+			Message: message,
+			Details: map[string]string{
+				"requestUrl": "/api/v1/circles/" + circleID + "/export-requests",
+			},
+		},
+		Timestamp: time.Now(),
+	})
+}
+
 func NotFoundResponse(c *gin.Context, resource string) {
 	message := resource + " not found"
 	c.JSON(http.StatusNotFound, models.APIResponse{
@@ -133,6 +217,12 @@ func RateLimitResponse(c *gin.Context) {
 }
 
 func InternalServerErrorResponse(c *gin.Context, message string) {
+	// Failures caused by a query running out of time are reported as such
+	if c.Request != nil && QueryTimedOut(c.Request.Context()) {
+		QueryTimeoutResponse(c)
+		return
+	}
+
 	if message == "" {
 		message = "Internal server error"
 	}
@@ -160,6 +250,70 @@ func ServiceUnavailableResponse(c *gin.Context, service string) {
 	})
 }
 
+// DatabaseUnavailableResponse sends a 503 telling the client when to retry
+func DatabaseUnavailableResponse(c *gin.Context, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	ServiceUnavailableResponse(c, "Database")
+}
+
+// LoginLockedResponse sends a 429 for a login attempt while the account or
+// IP address is locked out, with a Retry-After of when it is let in again
+func LoginLockedResponse(c *gin.Context, err error) {
+	lockedErr := LoginLockedError{RetryAfter: time.Second}
+	errors.As(err, &lockedErr)
+
+	seconds := int(math.Ceil(lockedErr.RetryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	TooManyRequestsResponse(c, fmt.Sprintf("Too many failed login attempts. Try again in %s, or reset your password.", lockoutWait(seconds)))
+}
+
+func lockoutWait(seconds int) string {
+	if seconds < 60 {
+		return fmt.Sprintf("%d seconds", seconds)
+	}
+	minutes := (seconds + 59) / 60
+	if minutes == 1 {
+		return "a minute"
+	}
+	return fmt.Sprintf("%d minutes", minutes)
+}
+
+// ProviderUnavailableResponse sends a 503 while an external provider's
+// circuit is open, with a Retry-After of when it is tried again
+func ProviderUnavailableResponse(c *gin.Context, err error) {
+	unavailableErr := ProviderUnavailableError{RetryAfter: time.Second}
+	errors.As(err, &unavailableErr)
+
+	seconds := int(math.Ceil(unavailableErr.RetryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	ServiceUnavailableResponse(c, "External")
+}
+
+// QueryTimeoutResponse sends a 504 for a request whose database query ran
+// out of time
+func QueryTimeoutResponse(c *gin.Context) {
+	message := "The request took too long to complete"
+	c.JSON(http.StatusGatewayTimeout, models.APIResponse{
+		Success: false,
+		Message: message,
+		Error: &models.APIError{
+			Code:    models.ErrCodeQueryTimeout,
+			Message: message,
+		},
+		Timestamp: time.Now(),
+	})
+}
+
 // WebSocket responses
 func WSSuccessResponse(requestID string, data interface{}) models.WSResponse {
 	return models.WSResponse{
@@ -200,6 +354,8 @@ func getErrorCode(statusCode int) string {
 		return models.ErrCodeInternal
 	case http.StatusServiceUnavailable:
 		return models.ErrCodeExternal
+	case http.StatusGatewayTimeout:
+		return models.ErrCodeQueryTimeout
 	default:
 		return models.ErrCodeInternal
 	}
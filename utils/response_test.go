@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ftrack/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func respond(t *testing.T, body string, handle func(c *gin.Context)) (int, models.APIResponse) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest("POST", "/circles", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	handle(c)
+
+	var response models.APIResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	return recorder.Code, response
+}
+
+func TestValidationErrorResponses(t *testing.T) {
+	vs := NewValidationService()
+	bind := func(c *gin.Context) {
+		var req models.CreatePlaceRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			BindErrorResponse(c, "Invalid request body", err)
+			return
+		}
+		if errs := vs.ValidateStruct(req); len(errs) > 0 {
+			ValidationFailedResponse(c, "Validation failed", NewFieldValidationFailedError(errs))
+		}
+	}
+
+	tests := []struct {
+		name    string
+		body    string
+		status  int
+		message string
+		errors  []models.FieldError
+	}{
+		{"invalid fields", `{"name": "Home", "latitude": 40.7, "longitude": -200, "radius": 100}`, http.StatusUnprocessableEntity,
+			"Validation failed: longitude must be greater than or equal to -180", []models.FieldError{
+				{Field: "longitude", Rule: "gte", Message: "longitude must be greater than or equal to -180"},
+				{Field: "category", Rule: "required", Message: "category is required"},
+			}},
+		{"wrong type", `{"name": "Home", "radius": "wide"}`, http.StatusUnprocessableEntity,
+			"Invalid request body", []models.FieldError{{Field: "radius", Rule: "type", Message: "radius must be a number"}}},
+		{"malformed JSON", `{"name": `, http.StatusBadRequest, "Invalid request body", nil},
+	}
+	for _, tt := range tests {
+		status, response := respond(t, tt.body, bind)
+		if status != tt.status || response.Message != tt.message || response.Success {
+			t.Errorf("%s: %d %q, want %d %q", tt.name, status, response.Message, tt.status, tt.message)
+		}
+		if len(response.Errors) != len(tt.errors) {
+			t.Errorf("%s: errors %+v, want %+v", tt.name, response.Errors, tt.errors)
+			continue
+		}
+		for i := range tt.errors {
+			if response.Errors[i] != tt.errors[i] {
+				t.Errorf("%s: error %d %+v, want %+v", tt.name, i, response.Errors[i], tt.errors[i])
+			}
+		}
+	}
+
+	// Services' validation failures go out the same way
+	status, response := respond(t, "", func(c *gin.Context) {
+		HandleServiceError(c, NewValidationFailedError("the place is closed"))
+	})
+	if status != http.StatusUnprocessableEntity || response.Message != "Validation failed: the place is closed" || response.Error.Code != models.ErrCodeValidation {
+		t.Errorf("service validation failure: %d %q %+v", status, response.Message, response.Error)
+	}
+}
@@ -3,8 +3,12 @@ package utils
 import (
 	"errors"
 	"fmt"
+	"ftrack/models"
+	"reflect"
 	"regexp"
 	"strings"
+	"sync"
+	"unicode/utf8"
 
 	"github.com/go-playground/validator/v10"
 )
@@ -54,30 +58,146 @@ func NewValidationService() *ValidationService {
 	v.RegisterValidation("message_type", validateMessageType)
 	v.RegisterValidation("notification_priority", validateNotificationPriority)
 
+	// Name fields the way clients send them
+	v.RegisterTagNameFunc(jsonFieldName)
+
 	return &ValidationService{
 		validator: v,
 	}
 }
 
+// ValidateStruct returns an error for each field that failed validation,
+// named by its JSON path, e.g. "variants[0].template"
 func (vs *ValidationService) ValidateStruct(s interface{}) []ValidationError {
-	var validationErrors []ValidationError
-
 	err := vs.validator.Struct(s)
-	if err != nil {
-		for _, err := range err.(validator.ValidationErrors) {
-			validationErrors = append(validationErrors, ValidationError{
-				Field:   err.Field(),
-				Tag:     err.Tag(),
-				Value:   fmt.Sprintf("%v", err.Value()),
-				Message: vs.getErrorMessage(err),
-			})
-		}
+	if err == nil {
+		return nil
 	}
 
+	var fieldErrors validator.ValidationErrors
+	if !errors.As(err, &fieldErrors) {
+		return []ValidationError{{Message: err.Error()}}
+	}
+	return fieldValidationErrors(fieldErrors)
+}
+
+func fieldValidationErrors(fieldErrors validator.ValidationErrors) []ValidationError {
+	validationErrors := make([]ValidationError, 0, len(fieldErrors))
+	for _, fe := range fieldErrors {
+		validationErrors = append(validationErrors, ValidationError{
+			Field:   fieldPath(fe),
+			Tag:     fe.Tag(),
+			Value:   fmt.Sprintf("%v", fe.Value()),
+			Message: validationErrorMessage(fe),
+		})
+	}
 	return validationErrors
 }
 
-func (vs *ValidationService) getErrorMessage(fe validator.FieldError) string {
+// fieldPath is the field's namespace without the struct it starts from
+func fieldPath(fe validator.FieldError) string {
+	namespace := fe.Namespace()
+	if i := strings.Index(namespace, "."); i >= 0 {
+		return namespace[i+1:]
+	}
+	return namespace
+}
+
+// jsonFieldName names a struct field by its JSON key, or its Go name if it
+// has none
+func jsonFieldName(field reflect.StructField) string {
+	name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+// MessageRules limits message content for every message type
+type MessageRules struct {
+	MaxContentLength int // characters
+}
+
+var DefaultMessageRules = MessageRules{
+	MaxContentLength: 4000,
+}
+
+var (
+	messageRules      = DefaultMessageRules
+	messageRulesMutex sync.RWMutex
+)
+
+// ConfigureMessageRules sets the message content limits
+func ConfigureMessageRules(rules MessageRules) {
+	if rules.MaxContentLength <= 0 {
+		rules.MaxContentLength = DefaultMessageRules.MaxContentLength
+	}
+
+	messageRulesMutex.Lock()
+	messageRules = rules
+	messageRulesMutex.Unlock()
+}
+
+// GetMessageRules returns the message content limits
+func GetMessageRules() MessageRules {
+	messageRulesMutex.RLock()
+	defer messageRulesMutex.RUnlock()
+	return messageRules
+}
+
+// ValidateMessageContent checks the content length and the fields each
+// message type requires. It returns a "validation failed" error carrying
+// the reason.
+func (vs *ValidationService) ValidateMessageContent(messageType, content string, media *models.MessageMedia, location *models.MessageLocation) error {
+	rules := GetMessageRules()
+
+	if length := utf8.RuneCountInString(content); length > rules.MaxContentLength {
+		return NewValidationFailedError(fmt.Sprintf("content must be at most %d characters", rules.MaxContentLength))
+	}
+
+	hasContent := strings.TrimSpace(content) != ""
+	hasMedia := media != nil && strings.TrimSpace(media.URL) != ""
+
+	switch messageType {
+	case "text":
+		if !hasContent {
+			return NewValidationFailedError("text messages must have content")
+		}
+	case "photo", "voice", "file":
+		if !hasMedia {
+			return NewValidationFailedError(fmt.Sprintf("%s messages must reference uploaded media", messageType))
+		}
+		if messageType == "voice" && media.Duration < 0 {
+			return NewValidationFailedError("voice message duration is invalid")
+		}
+	case "sticker":
+		if !hasMedia && !hasContent {
+			return NewValidationFailedError("sticker messages must reference a sticker")
+		}
+	case "location":
+		if location == nil || (location.Latitude == 0 && location.Longitude == 0) {
+			return NewValidationFailedError("location messages must have coordinates")
+		}
+		if location.Latitude < -90 || location.Latitude > 90 || location.Longitude < -180 || location.Longitude > 180 {
+			return NewValidationFailedError("location coordinates are out of range")
+		}
+	default:
+		return NewValidationFailedError("unsupported message type")
+	}
+
+	return nil
+}
+
+func validationErrorMessage(fe validator.FieldError) string {
+	// Lengths of text count characters, of lists and maps their items
+	unit := ""
+	switch fe.Kind() {
+	case reflect.String:
+		unit = " characters long"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		unit = " items"
+	}
+
 	switch fe.Tag() {
 	case "required":
 		return fmt.Sprintf("%s is required", fe.Field())
@@ -86,13 +206,23 @@ func (vs *ValidationService) getErrorMessage(fe validator.FieldError) string {
 	case "phone":
 		return "Invalid phone number format"
 	case "min":
-		return fmt.Sprintf("%s must be at least %s characters long", fe.Field(), fe.Param())
+		return fmt.Sprintf("%s must be at least %s%s", fe.Field(), fe.Param(), unit)
 	case "max":
-		return fmt.Sprintf("%s must be at most %s characters long", fe.Field(), fe.Param())
+		return fmt.Sprintf("%s must be at most %s%s", fe.Field(), fe.Param(), unit)
+	case "len":
+		return fmt.Sprintf("%s must be exactly %s%s", fe.Field(), fe.Param(), unit)
+	case "gt":
+		return fmt.Sprintf("%s must be greater than %s", fe.Field(), fe.Param())
 	case "gte":
 		return fmt.Sprintf("%s must be greater than or equal to %s", fe.Field(), fe.Param())
+	case "lt":
+		return fmt.Sprintf("%s must be less than %s", fe.Field(), fe.Param())
 	case "lte":
 		return fmt.Sprintf("%s must be less than or equal to %s", fe.Field(), fe.Param())
+	case "oneof":
+		return fmt.Sprintf("%s must be one of: %s", fe.Field(), strings.ReplaceAll(fe.Param(), " ", ", "))
+	case "url":
+		return fmt.Sprintf("%s must be a URL", fe.Field())
 	case "coordinate":
 		return "Invalid coordinate value"
 	case "invite_code":
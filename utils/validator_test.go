@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"ftrack/models"
+)
+
+func TestValidateMessageContent(t *testing.T) {
+	vs := NewValidationService()
+	media := &models.MessageMedia{URL: "/media/1"}
+
+	tests := []struct {
+		name        string
+		messageType string
+		content     string
+		media       *models.MessageMedia
+		location    *models.MessageLocation
+		reason      string // empty when valid
+	}{
+		{"text", "text", "hello", nil, nil, ""},
+		{"empty text", "text", "", nil, nil, "text messages must have content"},
+		{"blank text", "text", " \n\t", nil, nil, "text messages must have content"},
+		{"text at the limit", "text", strings.Repeat("é", DefaultMessageRules.MaxContentLength), nil, nil, ""},
+		{"text past the limit", "text", strings.Repeat("a", DefaultMessageRules.MaxContentLength+1), nil, nil, "content must be at most 4000 characters"},
+		{"photo", "photo", "", media, nil, ""},
+		{"photo with caption", "photo", "look", media, nil, ""},
+		{"photo without media", "photo", "look", nil, nil, "photo messages must reference uploaded media"},
+		{"photo with blank URL", "photo", "", &models.MessageMedia{URL: " "}, nil, "photo messages must reference uploaded media"},
+		{"voice", "voice", "", &models.MessageMedia{URL: "/media/2", Duration: 12}, nil, ""},
+		{"voice without media", "voice", "", nil, nil, "voice messages must reference uploaded media"},
+		{"voice with negative duration", "voice", "", &models.MessageMedia{URL: "/media/2", Duration: -1}, nil, "voice message duration is invalid"},
+		{"file", "file", "", media, nil, ""},
+		{"file without media", "file", "report.pdf", nil, nil, "file messages must reference uploaded media"},
+		{"sticker by media", "sticker", "", media, nil, ""},
+		{"sticker by name", "sticker", "thumbs-up", nil, nil, ""},
+		{"empty sticker", "sticker", "", nil, nil, "sticker messages must reference a sticker"},
+		{"location", "location", "", nil, &models.MessageLocation{Latitude: 40.7, Longitude: -74}, ""},
+		{"location without coordinates", "location", "here", nil, nil, "location messages must have coordinates"},
+		{"location at null island", "location", "", nil, &models.MessageLocation{}, "location messages must have coordinates"},
+		{"location out of range", "location", "", nil, &models.MessageLocation{Latitude: 91, Longitude: 0}, "location coordinates are out of range"},
+		{"unknown type", "poll", "which?", nil, nil, "unsupported message type"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := vs.ValidateMessageContent(tt.messageType, tt.content, tt.media, tt.location)
+			if tt.reason == "" {
+				if err != nil {
+					t.Fatalf("error = %v, want none", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != "validation failed" {
+				t.Fatalf("error = %v, want validation failed", err)
+			}
+			if reason := ValidationFailureReason(err); reason != tt.reason {
+				t.Errorf("reason = %q, want %q", reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestConfigureMessageRules(t *testing.T) {
+	defer ConfigureMessageRules(GetMessageRules())
+
+	vs := NewValidationService()
+
+	ConfigureMessageRules(MessageRules{MaxContentLength: 5})
+	if err := vs.ValidateMessageContent("text", "hello", nil, nil); err != nil {
+		t.Errorf("content at the configured limit: %v", err)
+	}
+	if reason := ValidationFailureReason(vs.ValidateMessageContent("text", "hello!", nil, nil)); reason != "content must be at most 5 characters" {
+		t.Errorf("content past the configured limit reason = %q", reason)
+	}
+
+	// Unset limits fall back to the default
+	ConfigureMessageRules(MessageRules{})
+	if got := GetMessageRules().MaxContentLength; got != DefaultMessageRules.MaxContentLength {
+		t.Errorf("MaxContentLength = %d, want the default %d", got, DefaultMessageRules.MaxContentLength)
+	}
+}
+
+func TestValidateStructFieldErrors(t *testing.T) {
+	vs := NewValidationService()
+	variant := models.NotificationVariantRequest{Template: "t", Weight: 10}
+
+	tests := []struct {
+		name    string
+		payload interface{}
+		want    []string // field/rule: message
+	}{
+		{"valid circle", models.CreateCircleRequest{Name: "Family"}, nil},
+		{"circle without a name", models.CreateCircleRequest{}, []string{"name/required: name is required"}},
+		{"short circle name", models.CreateCircleRequest{Name: "A"}, []string{"name/min: name must be at least 2 characters long"}},
+		{"place", models.CreatePlaceRequest{Name: "Home", Latitude: 91, Longitude: -74, Radius: 100, Category: "home", Priority: 11}, []string{
+			"latitude/lte: latitude must be less than or equal to 90",
+			"priority/max: priority must be at most 10",
+		}},
+		{"no members", models.BulkAddMembersRequest{}, []string{"members/required: members is required"}},
+		{"bad member entries", models.BulkAddMembersRequest{Members: []models.BulkMemberEntry{{Email: "nope"}, {UserID: "u", Role: "owner"}}}, []string{
+			"members[0].email/email: Invalid email format",
+			"members[1].role/oneof: role must be one of: admin, member",
+		}},
+		{"too many variants", models.CreateNotificationExperimentRequest{
+			Name: "x", Template: "t", EndsAt: time.Now(), Variants: []models.NotificationVariantRequest{variant, variant, variant, variant},
+		}, []string{"variants/max: variants must be at most 3 items"}},
+		{"bad variant", models.CreateNotificationExperimentRequest{
+			Name: "x", Template: "t", EndsAt: time.Now(), Variants: []models.NotificationVariantRequest{variant, {Weight: 0}},
+		}, []string{
+			"variants[1].template/required: template is required",
+			"variants[1].weight/min: weight must be at least 1",
+		}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, fe := range vs.ValidateStruct(tt.payload) {
+			got = append(got, fmt.Sprintf("%s/%s: %s", fe.Field, fe.Tag, fe.Message))
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%s: errors %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// A service's "validation failed" carries the fields, the first as the reason
+	err := NewFieldValidationFailedError(vs.ValidateStruct(models.CreateCircleRequest{}))
+	if err.Error() != "validation failed" || ValidationFailureReason(err) != "name is required" || len(ValidationFailureFields(err)) != 1 {
+		t.Errorf("error %v with reason %q and fields %+v", err, ValidationFailureReason(err), ValidationFailureFields(err))
+	}
+}
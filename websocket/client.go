@@ -6,6 +6,8 @@ import (
 	"ftrack/models"
 	"ftrack/utils"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -27,6 +29,14 @@ const (
 
 	// Buffer size for client send channel
 	sendBufferSize = 256
+
+	// Messages in a row a client's full buffer may drop before it is
+	// closed as too slow
+	maxSendOverflows = 32
+
+	// Sent with the close frame when the server shuts down, so clients
+	// reconnect instead of treating it as an error
+	restartCloseReason = "server restarting, reconnect"
 )
 
 var upgrader = websocket.Upgrader{
@@ -57,9 +67,16 @@ type Client struct {
 	ipAddress    string
 	userAgent    string
 
+	// Protocol version and payload encoding negotiated at connect time
+	version  int
+	encoding string
+
 	// Buffered channel of outbound messages
 	send chan models.WSMessage
 
+	// Messages dropped in a row because send was full
+	sendOverflows int32
+
 	// Hub reference
 	hub *Hub
 
@@ -76,9 +93,22 @@ type Client struct {
 	isAuthenticated bool
 	pingFailCount   int
 
+	// Outbound bandwidth, payload bytes are measured before compression
+	compression        CompressionConfig
+	payloadBytes       int64
+	compressedFrames   int64
+	replayPayloadBytes int64
+	replayWireBytes    int64
+
 	// Context for cleanup
 	ctx    context.Context
 	cancel context.CancelFunc
+
+	// Closed to have the writer drain its buffer and say goodbye, and by
+	// the writer when it has stopped
+	restart     chan struct{}
+	restartOnce sync.Once
+	writerDone  chan struct{}
 }
 
 func NewClient(conn *websocket.Conn, hub *Hub, r *http.Request) *Client {
@@ -97,14 +127,38 @@ func NewClient(conn *websocket.Conn, hub *Hub, r *http.Request) *Client {
 		subscriptions: make(map[string]bool),
 		filters:       make(map[string]interface{}),           // 100 requests per minute
 		rateLimiter:   utils.NewRateLimiter(100, time.Minute), // 100 requests per minute
+		compression:   GetCompressionConfig(),
 		ctx:           ctx,
 		cancel:        cancel,
+		restart:       make(chan struct{}),
+		writerDone:    make(chan struct{}),
 	}
 
 	// Extract device info from headers
 	client.deviceType = r.Header.Get("X-Device-Type")
 	client.appVersion = r.Header.Get("X-App-Version")
 
+	// Clients ask for a protocol version with ?version= or X-WS-Version
+	requestedVersion := r.URL.Query().Get("version")
+	if requestedVersion == "" {
+		requestedVersion = r.Header.Get("X-WS-Version")
+	}
+	client.version = negotiateVersion(requestedVersion)
+
+	// Binary frames for high-frequency events with ?encoding=binary or X-WS-Encoding
+	requestedEncoding := r.URL.Query().Get("encoding")
+	if requestedEncoding == "" {
+		requestedEncoding = r.Header.Get("X-WS-Encoding")
+	}
+	client.encoding = negotiateEncoding(requestedEncoding)
+
+	// No-op unless permessage-deflate was negotiated in the handshake
+	if client.compression.Enabled {
+		if err := conn.SetCompressionLevel(client.compression.Level); err != nil {
+			logrus.Warnf("Failed to set WebSocket compression level: %v", err)
+		}
+	}
+
 	return client
 }
 
@@ -151,6 +205,7 @@ func (c *Client) WritePump() {
 	defer func() {
 		ticker.Stop()
 		c.conn.Close()
+		close(c.writerDone)
 	}()
 
 	for {
@@ -158,6 +213,10 @@ func (c *Client) WritePump() {
 		case <-c.ctx.Done():
 			return
 
+		case <-c.restart:
+			c.drainAndClose()
+			return
+
 		case message, ok := <-c.send:
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
@@ -165,7 +224,7 @@ func (c *Client) WritePump() {
 				return
 			}
 
-			if err := c.conn.WriteJSON(message); err != nil {
+			if err := c.writeMessage(message); err != nil {
 				logrus.Errorf("Write error for user %s: %v", c.userID, err)
 				return
 			}
@@ -183,6 +242,72 @@ func (c *Client) WritePump() {
 	}
 }
 
+// closeForRestart asks the writer to send what is queued and close the
+// connection with a service restart frame
+func (c *Client) closeForRestart() {
+	c.restartOnce.Do(func() {
+		close(c.restart)
+	})
+}
+
+// drainAndClose writes the messages still in the send buffer, then the
+// close frame. Runs on the writer goroutine, the only one writing to conn.
+func (c *Client) drainAndClose() {
+	for drained := false; !drained; {
+		select {
+		case message, ok := <-c.send:
+			if !ok {
+				drained = true
+				continue
+			}
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.writeMessage(message); err != nil {
+				logrus.Debugf("Drain write failed for user %s: %v", c.userID, err)
+				return
+			}
+		default:
+			drained = true
+		}
+	}
+
+	closeFrame := websocket.FormatCloseMessage(websocket.CloseServiceRestart, restartCloseReason)
+	if err := c.conn.WriteControl(websocket.CloseMessage, closeFrame, time.Now().Add(writeWait)); err != nil {
+		logrus.Debugf("Close frame failed for user %s: %v", c.userID, err)
+	}
+}
+
+// writeMessage writes a message at the client's protocol version and
+// encoding, compressing it when it reaches the configured threshold
+func (c *Client) writeMessage(message models.WSMessage) error {
+	data, frameType, supported, err := encodeFrame(message, c.version, c.encoding)
+	if err != nil {
+		return err
+	}
+	if !supported {
+		logrus.Debugf("Skipping %s event for user %s on protocol version %d", message.Type, c.userID, c.version)
+		return nil
+	}
+
+	compress := c.compression.Enabled && len(data) >= c.compression.Threshold
+	c.conn.EnableWriteCompression(compress)
+
+	if err := c.conn.WriteMessage(frameType, data); err != nil {
+		return err
+	}
+	c.hub.messageDelivered(c.userID, message)
+
+	payloadBytes := atomic.AddInt64(&c.payloadBytes, int64(len(data)))
+	if compress {
+		atomic.AddInt64(&c.compressedFrames, 1)
+	}
+	if time.Since(c.connectedAt) <= c.compression.ReplayWindow {
+		atomic.StoreInt64(&c.replayPayloadBytes, payloadBytes)
+		atomic.StoreInt64(&c.replayWireBytes, wireBytesWritten(c.conn))
+	}
+
+	return nil
+}
+
 func (c *Client) handleMessage(messageData []byte) {
 	var wsRequest models.WSRequest
 	if err := json.Unmarshal(messageData, &wsRequest); err != nil {
@@ -248,6 +373,9 @@ func (c *Client) handleAuth(request models.WSRequest) {
 		UserID:    c.userID,
 		CircleIDs: c.circleIDs,
 		ExpiresAt: time.Now().Add(24 * time.Hour),
+
+		Version:           c.version,
+		SupportedVersions: supportedVersions(),
 	}
 
 	c.sendResponse(models.WSTypeAuth, response, request.RequestID)
@@ -391,16 +519,28 @@ func (c *Client) sendResponse(msgType string, data interface{}, requestID string
 	}
 }
 
-func (c *Client) SendMessage(message models.WSMessage) {
+// SendMessage queues the message for the client without waiting, and
+// reports whether there was room for it. A client whose buffer stays full
+// for maxSendOverflows messages in a row is closed, so it reconnects and
+// catches up instead of missing messages one by one.
+func (c *Client) SendMessage(message models.WSMessage) bool {
 	if !c.isActive {
-		return
+		return false
 	}
 
 	select {
 	case c.send <- message:
+		atomic.StoreInt32(&c.sendOverflows, 0)
+		return true
 	default:
-		// Channel full, likely client disconnected
-		logrus.Warnf("Send channel full for user %s", c.userID)
+		if atomic.AddInt32(&c.sendOverflows, 1) == maxSendOverflows {
+			logrus.Warnf("Send channel of user %s stayed full, closing slow client", c.userID)
+			c.hub.recordSlowClient()
+			c.closeForRestart()
+		} else {
+			logrus.Debugf("Send channel full for user %s", c.userID)
+		}
+		return false
 	}
 }
 
@@ -409,7 +549,11 @@ func (c *Client) cleanup() {
 	c.cancel()
 
 	if c.isAuthenticated {
-		c.hub.unregister <- c
+		// The hub stops taking unregistrations once it has shut down
+		select {
+		case c.hub.unregister <- c:
+		case <-c.hub.ctx.Done():
+		}
 
 		// Update user offline status
 		if c.hub.userService != nil {
@@ -420,7 +564,19 @@ func (c *Client) cleanup() {
 	close(c.send)
 	c.conn.Close()
 
-	logrus.Infof("Client disconnected: %s (%s)", c.userID, c.connectionID)
+	payloadBytes := atomic.LoadInt64(&c.payloadBytes)
+	wireBytes := wireBytesWritten(c.conn)
+	compressedFrames := atomic.LoadInt64(&c.compressedFrames)
+	replayPayloadBytes := atomic.LoadInt64(&c.replayPayloadBytes)
+	replayWireBytes := atomic.LoadInt64(&c.replayWireBytes)
+	c.hub.recordBandwidth(payloadBytes, wireBytes, compressedFrames, replayPayloadBytes, replayWireBytes)
+
+	logrus.WithFields(logrus.Fields{
+		"payloadBytes":     payloadBytes,
+		"wireBytes":        wireBytes,
+		"compressedFrames": compressedFrames,
+		"replaySaved":      compressionRatio(replayPayloadBytes, replayWireBytes),
+	}).Infof("Client disconnected: %s (%s)", c.userID, c.connectionID)
 }
 
 func (c *Client) unmarshalData(data map[string]interface{}, target interface{}) error {
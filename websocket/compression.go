@@ -0,0 +1,144 @@
+package websocket
+
+import (
+	"bufio"
+	"compress/flate"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+type Upgrader = websocket.Upgrader
+
+// CompressionConfig controls permessage-deflate on outbound frames. Clients
+// that don't offer the extension in the handshake get uncompressed frames.
+type CompressionConfig struct {
+	Enabled bool
+	Level   int // flate level, 1 (best speed) to 9 (best compression)
+
+	// Frames smaller than this are sent uncompressed, deflate overhead
+	// outweighs the gain on typing pings and acks
+	Threshold int
+
+	// Outbound traffic in this window after connecting is counted as the
+	// reconnect replay when measuring bandwidth savings
+	ReplayWindow time.Duration
+}
+
+var DefaultCompressionConfig = CompressionConfig{
+	Enabled:      true,
+	Level:        flate.BestSpeed,
+	Threshold:    512,
+	ReplayWindow: 30 * time.Second,
+}
+
+var (
+	compressionConfig = DefaultCompressionConfig
+	compressionMutex  sync.RWMutex
+)
+
+// ConfigureCompression sets the compression settings for new connections
+func ConfigureCompression(cfg CompressionConfig) {
+	if cfg.Level < flate.BestSpeed || cfg.Level > flate.BestCompression {
+		logrus.Warnf("Invalid WebSocket compression level %d, using %d", cfg.Level, DefaultCompressionConfig.Level)
+		cfg.Level = DefaultCompressionConfig.Level
+	}
+	if cfg.Threshold < 0 {
+		cfg.Threshold = 0
+	}
+	if cfg.ReplayWindow <= 0 {
+		cfg.ReplayWindow = DefaultCompressionConfig.ReplayWindow
+	}
+
+	compressionMutex.Lock()
+	compressionConfig = cfg
+	compressionMutex.Unlock()
+
+	logrus.Infof("WebSocket compression enabled=%v level=%d threshold=%d bytes",
+		cfg.Enabled, cfg.Level, cfg.Threshold)
+}
+
+// GetCompressionConfig returns the current compression settings
+func GetCompressionConfig() CompressionConfig {
+	compressionMutex.RLock()
+	defer compressionMutex.RUnlock()
+	return compressionConfig
+}
+
+// NewUpgrader creates an upgrader that negotiates permessage-deflate when
+// compression is enabled
+func NewUpgrader(readBufferSize, writeBufferSize int) Upgrader {
+	return Upgrader{
+		ReadBufferSize:    readBufferSize,
+		WriteBufferSize:   writeBufferSize,
+		EnableCompression: GetCompressionConfig().Enabled,
+		CheckOrigin: func(r *http.Request) bool {
+			// In production, implement proper origin checking
+			return true
+		},
+	}
+}
+
+// Upgrade upgrades the connection and counts the bytes written to the
+// socket, so compression savings can be measured per client
+func Upgrade(upgrader *Upgrader, w http.ResponseWriter, r *http.Request) (*websocket.Conn, error) {
+	return upgrader.Upgrade(&countingResponseWriter{ResponseWriter: w}, r, nil)
+}
+
+// countingConn counts the bytes written to the underlying connection,
+// frame headers and compressed payloads included
+type countingConn struct {
+	net.Conn
+	written int64
+}
+
+func (cc *countingConn) Write(b []byte) (int, error) {
+	n, err := cc.Conn.Write(b)
+	atomic.AddInt64(&cc.written, int64(n))
+	return n, err
+}
+
+func (cc *countingConn) BytesWritten() int64 {
+	return atomic.LoadInt64(&cc.written)
+}
+
+type countingResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w *countingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &countingConn{Conn: conn}, rw, nil
+}
+
+// wireBytesWritten returns the bytes written to the socket, or -1 when the
+// connection wasn't upgraded through Upgrade
+func wireBytesWritten(conn *websocket.Conn) int64 {
+	if counted, ok := conn.UnderlyingConn().(*countingConn); ok {
+		return counted.BytesWritten()
+	}
+	return -1
+}
+
+// compressionRatio returns the share of payload bytes saved on the wire
+func compressionRatio(payloadBytes, wireBytes int64) float64 {
+	if payloadBytes <= 0 || wireBytes < 0 {
+		return 0
+	}
+	return 1 - float64(wireBytes)/float64(payloadBytes)
+}
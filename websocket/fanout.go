@@ -0,0 +1,149 @@
+package websocket
+
+import (
+	"context"
+	"ftrack/models"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+// FanoutConfig limits how much delivering room broadcasts can do at once.
+// A broadcast is split into batches of recipients queued for a fixed pool
+// of workers, so a circle with hundreds of members takes turns with the
+// others instead of holding the hub until every member has it.
+type FanoutConfig struct {
+	Workers   int
+	QueueSize int // batches waiting for a worker
+	BatchSize int // recipients per batch
+}
+
+var DefaultFanoutConfig = FanoutConfig{
+	Workers:   8,
+	QueueSize: 1024,
+	BatchSize: 50,
+}
+
+var (
+	fanoutConfig = DefaultFanoutConfig
+	fanoutMutex  sync.RWMutex
+)
+
+// ConfigureFanout sets the broadcast worker pool of hubs created after it
+func ConfigureFanout(cfg FanoutConfig) {
+	if cfg.Workers <= 0 {
+		cfg.Workers = DefaultFanoutConfig.Workers
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = DefaultFanoutConfig.QueueSize
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultFanoutConfig.BatchSize
+	}
+
+	fanoutMutex.Lock()
+	fanoutConfig = cfg
+	fanoutMutex.Unlock()
+
+	logrus.Infof("WebSocket fan-out workers=%d queue=%d batch=%d",
+		cfg.Workers, cfg.QueueSize, cfg.BatchSize)
+}
+
+// GetFanoutConfig returns the current fan-out settings
+func GetFanoutConfig() FanoutConfig {
+	fanoutMutex.RLock()
+	defer fanoutMutex.RUnlock()
+	return fanoutConfig
+}
+
+type fanoutBatch struct {
+	room    *Room
+	clients []*Client
+	message models.WSMessage
+}
+
+type fanoutPool struct {
+	config FanoutConfig
+	queue  chan fanoutBatch
+
+	busy     int64 // workers delivering a batch
+	rejected int64 // batches dropped because the queue was full
+}
+
+func newFanoutPool(cfg FanoutConfig) *fanoutPool {
+	return &fanoutPool{
+		config: cfg,
+		queue:  make(chan fanoutBatch, cfg.QueueSize),
+	}
+}
+
+// start runs the workers until the context is done
+func (p *fanoutPool) start(ctx context.Context) {
+	for i := 0; i < p.config.Workers; i++ {
+		go p.worker(ctx)
+	}
+}
+
+func (p *fanoutPool) worker(ctx context.Context) {
+	for {
+		select {
+		case batch := <-p.queue:
+			atomic.AddInt64(&p.busy, 1)
+			p.deliver(batch)
+			atomic.AddInt64(&p.busy, -1)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// deliver hands the message to each client of the batch. Clients whose
+// buffer is full miss it instead of holding up the rest.
+func (p *fanoutPool) deliver(batch fanoutBatch) {
+	delivered := 0
+	for _, client := range batch.clients {
+		if client.SendMessage(batch.message) {
+			delivered++
+		} else {
+			batch.room.incrementDroppedMessages()
+		}
+	}
+
+	batch.room.incrementMessagesSent(int64(delivered))
+	batch.room.updateLastActivity()
+}
+
+// dispatch queues the message for the clients in batches. It doesn't wait
+// for room in the queue; batches that don't fit are dropped and counted.
+func (p *fanoutPool) dispatch(room *Room, clients []*Client, message models.WSMessage) {
+	for start := 0; start < len(clients); start += p.config.BatchSize {
+		end := start + p.config.BatchSize
+		if end > len(clients) {
+			end = len(clients)
+		}
+
+		select {
+		case p.queue <- fanoutBatch{room: room, clients: clients[start:end], message: message}:
+		default:
+			atomic.AddInt64(&p.rejected, 1)
+			logrus.Warnf("Fan-out queue full, dropping broadcast to %d clients in room %s", end-start, room.ID)
+		}
+	}
+}
+
+// saturated reports whether the queue is full, so callers that can retry
+// hold off instead of having their broadcast dropped
+func (p *fanoutPool) saturated() bool {
+	return len(p.queue) >= cap(p.queue)
+}
+
+func (p *fanoutPool) stats() models.WSFanoutStats {
+	return models.WSFanoutStats{
+		Workers:         p.config.Workers,
+		BusyWorkers:     int(atomic.LoadInt64(&p.busy)),
+		QueueDepth:      len(p.queue),
+		QueueCapacity:   cap(p.queue),
+		RejectedBatches: atomic.LoadInt64(&p.rejected),
+	}
+}
@@ -0,0 +1,64 @@
+package websocket
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+
+	"ftrack/models"
+)
+
+// BenchmarkBroadcastLargeRoom broadcasts to a circle of 1000 connected
+// clients through the hub, each iteration waiting until every client has
+// the message
+func BenchmarkBroadcastLargeRoom(b *testing.B) {
+	const members = 1000
+
+	hub := NewHub(nil, nil, nil, nil, nil, nil)
+	go hub.Run()
+	defer hub.cancel()
+
+	room := NewRoom("circle")
+	defer room.Close()
+
+	var received sync.WaitGroup
+	for i := 0; i < members; i++ {
+		client := &Client{
+			hub:      hub,
+			userID:   fmt.Sprintf("user-%d", i),
+			isActive: true,
+			send:     make(chan models.WSMessage, sendBufferSize),
+		}
+		room.clients[client] = true
+		go func() {
+			for range client.send {
+				received.Done()
+			}
+		}()
+	}
+	hub.mutex.Lock()
+	hub.rooms[room.ID] = room
+	hub.mutex.Unlock()
+
+	message := models.WSMessage{Type: models.WSTypeNotification, Data: map[string]interface{}{"title": "hello"}}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		received.Add(members)
+		// Like the outbox relay, retry while the hub is busy
+		for !hub.TryBroadcastMessage(room.ID, message) {
+			runtime.Gosched()
+		}
+		received.Wait()
+	}
+	b.StopTimer()
+
+	if stats := hub.fanout.stats(); stats.RejectedBatches != 0 {
+		b.Errorf("%d batches rejected", stats.RejectedBatches)
+	}
+	for client := range room.clients {
+		close(client.send)
+	}
+}
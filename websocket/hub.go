@@ -50,6 +50,15 @@ type Hub struct {
 	ctx    context.Context
 	cancel context.CancelFunc
 
+	// Set once Shutdown starts; new connections are turned away
+	shuttingDown bool
+
+	// Told when a message event has been written to a member's socket
+	onMessageDelivered func(userID, circleID, messageID string)
+
+	// Delivers room broadcasts
+	fanout *fanoutPool
+
 	// Background workers
 	cleanupTicker *time.Ticker
 	metricsTicker *time.Ticker
@@ -84,6 +93,15 @@ type HubStats struct {
 	StartTime         time.Time
 	LastUpdate        time.Time
 
+	// Outbound bandwidth of closed connections, for compression savings
+	PayloadBytes       int64
+	CompressedFrames   int64
+	ReplayPayloadBytes int64
+	ReplayWireBytes    int64
+
+	// Clients closed because they stopped reading their messages
+	SlowClientsClosed int64
+
 	mutex sync.RWMutex
 }
 
@@ -114,6 +132,7 @@ func NewHub(
 		stats: HubStats{
 			StartTime: time.Now(),
 		},
+		fanout: newFanoutPool(GetFanoutConfig()),
 		ctx:    ctx,
 		cancel: cancel,
 	}
@@ -130,6 +149,7 @@ func (h *Hub) Run() {
 
 	go h.runCleanup()
 	go h.runMetrics()
+	h.fanout.start(h.ctx)
 
 	for {
 		select {
@@ -169,6 +189,12 @@ func (h *Hub) registerClient(client *Client) {
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
 
+	// Connected while the server was going down
+	if h.shuttingDown {
+		client.closeForRestart()
+		return
+	}
+
 	// Register client
 	h.clients[client] = true
 	h.userClients[client.userID] = client
@@ -227,7 +253,7 @@ func (h *Hub) broadcastToRoom(broadcastMsg BroadcastMessage) {
 	h.mutex.RUnlock()
 
 	if room != nil {
-		room.Broadcast(broadcastMsg.Message, broadcastMsg.Filter)
+		h.fanout.dispatch(room, room.Recipients(broadcastMsg.Filter), broadcastMsg.Message)
 		h.incrementMessagesSent()
 	}
 }
@@ -283,7 +309,41 @@ func (h *Hub) notifyUserStatus(userID string, circleIDs []string, isOnline bool)
 }
 
 // Public broadcasting methods
+
+// BroadcastUserStatus sends a member's status to the circles, except to the
+// member themselves
+func (h *Hub) BroadcastUserStatus(circleIDs []string, status models.WSUserStatus) {
+	message := models.WSMessage{
+		Type:      models.WSTypeUserStatus,
+		Data:      status,
+		UserID:    status.UserID,
+		Timestamp: time.Now(),
+	}
+
+	for _, circleID := range circleIDs {
+		broadcastMsg := BroadcastMessage{
+			RoomID:  circleID,
+			Message: message,
+			Filter: MessageFilter{
+				ExcludeUsers: []string{status.UserID},
+			},
+		}
+
+		select {
+		case h.broadcast <- broadcastMsg:
+		default:
+			logrus.Warn("Broadcast channel full, dropping user status message")
+		}
+	}
+}
+
 func (h *Hub) BroadcastLocationUpdate(userID string, circleIDs []string, location models.Location) {
+	h.BroadcastLocationUpdateExcept(userID, circleIDs, location, nil)
+}
+
+// BroadcastLocationUpdateExcept broadcasts a location update to the circles
+// without sending it to the excluded users
+func (h *Hub) BroadcastLocationUpdateExcept(userID string, circleIDs []string, location models.Location, excludeUserIDs []string) {
 	message := models.WSMessage{
 		Type: models.WSTypeLocationUpdate,
 		Data: models.WSLocationUpdate{
@@ -298,6 +358,9 @@ func (h *Hub) BroadcastLocationUpdate(userID string, circleIDs []string, locatio
 		broadcastMsg := BroadcastMessage{
 			RoomID:  circleID,
 			Message: message,
+			Filter: MessageFilter{
+				ExcludeUsers: excludeUserIDs,
+			},
 		}
 
 		select {
@@ -329,6 +392,43 @@ func (h *Hub) BroadcastPlaceEvent(userID string, circleIDs []string, placeEvent
 	}
 }
 
+// BroadcastVisitUpdate sends a member's visit state to the circles without
+// sending it to the excluded users
+func (h *Hub) BroadcastVisitUpdate(circleIDs []string, update models.WSVisitUpdate, excludeUserIDs []string) {
+	message := models.WSMessage{
+		Type:      models.WSTypeVisitUpdate,
+		Data:      update,
+		UserID:    update.UserID,
+		Timestamp: time.Now(),
+	}
+
+	for _, circleID := range circleIDs {
+		broadcastMsg := BroadcastMessage{
+			RoomID:  circleID,
+			Message: message,
+			Filter: MessageFilter{
+				ExcludeUsers: excludeUserIDs,
+			},
+		}
+
+		select {
+		case h.broadcast <- broadcastMsg:
+		default:
+			logrus.Warn("Broadcast channel full, dropping visit update")
+		}
+	}
+}
+
+// HasCircleClients reports whether anyone in the circle is connected to
+// this instance
+func (h *Hub) HasCircleClients(circleID string) bool {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	room := h.rooms[circleID]
+	return room != nil && len(room.GetClients()) > 0
+}
+
 func (h *Hub) BroadcastEmergencyAlert(circleIDs []string, alert models.WSEmergencyAlert) {
 	message := models.WSMessage{
 		Type:      models.WSTypeEmergencyAlert,
@@ -369,6 +469,25 @@ func (h *Hub) SendNotificationToUser(userID string, notification interface{}) {
 	}
 }
 
+// SendTrackingHint tells the user's devices to change how often they
+// report their location
+func (h *Hub) SendTrackingHint(userID string, hint models.TrackingHint) {
+	userMsg := UserMessage{
+		UserID: userID,
+		Message: models.WSMessage{
+			Type:      models.WSTypeTrackingHint,
+			Data:      hint,
+			Timestamp: time.Now(),
+		},
+	}
+
+	select {
+	case h.sendToUser <- userMsg:
+	default:
+		logrus.Warn("SendToUser channel full, dropping tracking hint")
+	}
+}
+
 func (h *Hub) broadcastTypingIndicator(userID, circleID string, isTyping bool) {
 	message := models.WSMessage{
 		Type: models.WSTypeTypingIndicator,
@@ -508,6 +627,7 @@ func (h *Hub) GetStats() models.WSHubStats {
 		ActiveRooms:       len(roomStats),
 		MessagesPerSecond: h.stats.MessagesPerSecond,
 		RoomStats:         roomStats,
+		Fanout:            h.fanoutStats(),
 		Uptime:            time.Since(h.stats.StartTime),
 		LastUpdate:        time.Now(),
 	}
@@ -519,6 +639,40 @@ func (h *Hub) incrementMessagesSent() {
 	h.stats.mutex.Unlock()
 }
 
+// recordSlowClient counts a client closed for not keeping up with its
+// messages
+func (h *Hub) recordSlowClient() {
+	h.stats.mutex.Lock()
+	h.stats.SlowClientsClosed++
+	h.stats.mutex.Unlock()
+}
+
+// fanoutStats is the broadcast worker pool's load. Called with the stats
+// lock held.
+func (h *Hub) fanoutStats() models.WSFanoutStats {
+	stats := h.fanout.stats()
+	stats.SlowClientsClosed = h.stats.SlowClientsClosed
+	return stats
+}
+
+// recordBandwidth adds a closed connection's outbound traffic to the stats.
+// Wire bytes are -1 when the socket wasn't counted.
+func (h *Hub) recordBandwidth(payloadBytes, wireBytes, compressedFrames, replayPayloadBytes, replayWireBytes int64) {
+	if wireBytes < 0 {
+		return
+	}
+
+	h.stats.mutex.Lock()
+	h.stats.PayloadBytes += payloadBytes
+	h.stats.BytesTransferred += wireBytes
+	h.stats.CompressedFrames += compressedFrames
+	if replayWireBytes >= 0 {
+		h.stats.ReplayPayloadBytes += replayPayloadBytes
+		h.stats.ReplayWireBytes += replayWireBytes
+	}
+	h.stats.mutex.Unlock()
+}
+
 func (h *Hub) runCleanup() {
 	for {
 		select {
@@ -577,33 +731,149 @@ func (h *Hub) updateMetrics() {
 	}
 
 	h.stats.LastUpdate = now
+
+	if fanout := h.fanout.stats(); fanout.QueueDepth > fanout.QueueCapacity/2 {
+		logrus.WithFields(logrus.Fields{
+			"queueDepth":      fanout.QueueDepth,
+			"queueCapacity":   fanout.QueueCapacity,
+			"busyWorkers":     fanout.BusyWorkers,
+			"rejectedBatches": fanout.RejectedBatches,
+		}).Warn("WebSocket fan-out backlog")
+	}
+
+	if h.stats.PayloadBytes > 0 {
+		logrus.WithFields(logrus.Fields{
+			"payloadBytes":     h.stats.PayloadBytes,
+			"wireBytes":        h.stats.BytesTransferred,
+			"compressedFrames": h.stats.CompressedFrames,
+			"saved":            compressionRatio(h.stats.PayloadBytes, h.stats.BytesTransferred),
+			"replaySaved":      compressionRatio(h.stats.ReplayPayloadBytes, h.stats.ReplayWireBytes),
+		}).Info("WebSocket bandwidth")
+	}
+}
+
+// IsShuttingDown reports whether the hub has stopped taking connections
+func (h *Hub) IsShuttingDown() bool {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.shuttingDown
 }
 
-func (h *Hub) Shutdown() {
+// Shutdown stops taking connections, sends every client what is queued for
+// it followed by a service restart close frame, and stops the hub. It
+// returns once all clients are closed, or with the context's error when it
+// runs out first; the hub is stopped either way.
+func (h *Hub) Shutdown(ctx context.Context) error {
 	logrus.Info("Shutting down WebSocket Hub...")
 
+	h.mutex.Lock()
+	h.shuttingDown = true
+	clients := make([]*Client, 0, len(h.clients))
+	for client := range h.clients {
+		clients = append(clients, client)
+	}
+	h.mutex.Unlock()
+
+	for _, client := range clients {
+		client.closeForRestart()
+	}
+
+	var err error
+	for _, client := range clients {
+		select {
+		case <-client.writerDone:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if err != nil {
+			logrus.Warn("WebSocket Hub shutdown timed out, closing remaining connections")
+			break
+		}
+	}
+
+	// The run loop took unregistrations from the closing clients until now
 	h.cleanupTicker.Stop()
 	h.metricsTicker.Stop()
 	h.cancel()
 
-	// Close all client connections
-	h.mutex.Lock()
-	for client := range h.clients {
-		client.cleanup()
+	if err != nil {
+		for _, client := range clients {
+			client.conn.Close()
+		}
+		return err
 	}
-	h.mutex.Unlock()
 
-	logrus.Info("WebSocket Hub shutdown complete")
+	logrus.Infof("WebSocket Hub shutdown complete (%d clients closed)", len(clients))
+	return nil
 }
 func (h *Hub) BroadcastMessage(roomID string, message models.WSMessage) {
+	if !h.TryBroadcastMessage(roomID, message) {
+		logrus.Warn("Broadcast channel full, dropping message")
+	}
+}
+
+// TryBroadcastMessage queues a message for a room. It returns false instead
+// of dropping the message when the broadcast channel or the fan-out queue
+// is full, so callers that must deliver can try again.
+func (h *Hub) TryBroadcastMessage(roomID string, message models.WSMessage) bool {
+	return h.TryBroadcastFiltered(roomID, message, MessageFilter{})
+}
+
+// BroadcastFiltered queues a message for the room's clients the filter
+// lets through
+func (h *Hub) BroadcastFiltered(roomID string, message models.WSMessage, filter MessageFilter) {
+	if !h.TryBroadcastFiltered(roomID, message, filter) {
+		logrus.Warn("Broadcast channel full, dropping message")
+	}
+}
+
+// TryBroadcastFiltered is TryBroadcastMessage for the room's clients the
+// filter lets through
+func (h *Hub) TryBroadcastFiltered(roomID string, message models.WSMessage, filter MessageFilter) bool {
+	if h.fanout.saturated() {
+		return false
+	}
+
 	broadcastMsg := BroadcastMessage{
 		RoomID:  roomID,
 		Message: message,
+		Filter:  filter,
 	}
 
 	select {
 	case h.broadcast <- broadcastMsg:
+		return true
 	default:
-		logrus.Warn("Broadcast channel full, dropping message")
+		return false
+	}
+}
+
+// OnMessageDelivered has the hub report every message event it writes to
+// a member's socket. Set it before clients connect. The sender's own copy
+// isn't reported.
+func (h *Hub) OnMessageDelivered(fn func(userID, circleID, messageID string)) {
+	h.onMessageDelivered = fn
+}
+
+// messageDelivered reports a message event written to the user's socket.
+// Events that came through the outbox carry their data decoded from JSON.
+func (h *Hub) messageDelivered(userID string, message models.WSMessage) {
+	if h.onMessageDelivered == nil || message.Type != models.WSTypeMessage {
+		return
+	}
+
+	var circleID, messageID, senderID string
+	switch data := message.Data.(type) {
+	case models.WSMessageData:
+		circleID, messageID, senderID = data.CircleID, data.MessageID, data.SenderID
+	case map[string]interface{}:
+		circleID, _ = data["circleId"].(string)
+		messageID, _ = data["messageId"].(string)
+		senderID, _ = data["senderId"].(string)
+	}
+	if messageID == "" || senderID == userID {
+		return
 	}
+
+	h.onMessageDelivered(userID, circleID, messageID)
 }
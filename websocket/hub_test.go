@@ -0,0 +1,154 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ftrack/models"
+
+	"github.com/gorilla/websocket"
+)
+
+// newTestConnection connects a client to the hub through a test server.
+// The server side is registered as the user, with the messages queued, and
+// returned without its writer running so the test decides when it starts.
+func newTestConnection(t *testing.T, hub *Hub, userID string, queued ...models.WSMessage) (*Client, *websocket.Conn) {
+	t.Helper()
+
+	clients := make(chan *Client, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrading: %v", err)
+			return
+		}
+		client := NewClient(conn, hub, r)
+		client.userID = userID
+		client.isAuthenticated = true
+		hub.registerClient(client)
+		for _, message := range queued {
+			client.send <- message
+		}
+		clients <- client
+	}))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dialing: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return <-clients, conn
+}
+
+// readUntilClose returns the types of the messages the connection gets
+// before it is closed, and the close error
+func readUntilClose(t *testing.T, conn *websocket.Conn) ([]string, *websocket.CloseError) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var types []string
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) {
+				t.Fatalf("connection ended without a close frame: %v", err)
+			}
+			return types, closeErr
+		}
+
+		var message models.WSMessage
+		if err := json.Unmarshal(data, &message); err != nil {
+			t.Fatalf("decoding %s: %v", data, err)
+		}
+		types = append(types, message.Type)
+	}
+}
+
+func TestHubShutdownSendsCloseFrames(t *testing.T) {
+	hub := NewHub(nil, nil, nil, nil, nil, nil)
+
+	queued := []models.WSMessage{
+		{Type: models.WSTypeNotification, Data: map[string]interface{}{"title": "one"}},
+		{Type: models.WSTypeEmergencyAlert, Data: map[string]interface{}{"title": "two"}},
+	}
+	alice, aliceConn := newTestConnection(t, hub, "alice", queued...)
+	bob, bobConn := newTestConnection(t, hub, "bob")
+
+	done := make(chan error, 1)
+	go func() { done <- hub.Shutdown(context.Background()) }()
+
+	// Writers started once shutdown began still send what was queued first
+	deadline := time.Now().Add(5 * time.Second)
+	for !hub.IsShuttingDown() {
+		if time.Now().After(deadline) {
+			t.Fatal("hub never started shutting down")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	go alice.WritePump()
+	go bob.WritePump()
+
+	for _, tt := range []struct {
+		user string
+		conn *websocket.Conn
+		want []string
+	}{
+		{"alice", aliceConn, []string{models.WSTypeNotification, models.WSTypeEmergencyAlert}},
+		{"bob", bobConn, nil},
+	} {
+		types, closeErr := readUntilClose(t, tt.conn)
+		if strings.Join(types, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s got %v before closing, want %v", tt.user, types, tt.want)
+		}
+		if closeErr.Code != websocket.CloseServiceRestart || closeErr.Text != restartCloseReason {
+			t.Errorf("%s closed with %d %q, want %d %q", tt.user, closeErr.Code, closeErr.Text, websocket.CloseServiceRestart, restartCloseReason)
+		}
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Shutdown: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown didn't return once every client was closed")
+	}
+
+	// Connections made during shutdown are turned away the same way
+	late, lateConn := newTestConnection(t, hub, "carol")
+	go late.WritePump()
+	if _, closeErr := readUntilClose(t, lateConn); closeErr.Code != websocket.CloseServiceRestart {
+		t.Errorf("late connection closed with %d, want %d", closeErr.Code, websocket.CloseServiceRestart)
+	}
+	if hub.IsUserOnline("carol") {
+		t.Error("late connection was registered")
+	}
+}
+
+func TestHubShutdownDeadline(t *testing.T) {
+	hub := NewHub(nil, nil, nil, nil, nil, nil)
+
+	// A client whose writer never runs can't be drained
+	_, conn := newTestConnection(t, hub, "alice")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := hub.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown error = %v, want the deadline", err)
+	}
+
+	// Its connection is closed anyway
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadMessage(); err == nil {
+		t.Error("connection still open after shutdown")
+	}
+}
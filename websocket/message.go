@@ -120,6 +120,9 @@ func (mp *MessageProcessor) ProcessMessage(userID string, data map[string]interf
 		Content:  messageReq.Content,
 		Media:    messageReq.Media,
 		ReplyTo:  messageReq.ReplyTo,
+
+		ClientID:   messageReq.ClientID,
+		ComposedAt: messageReq.ComposedAt,
 	}
 
 	// Process through message service
@@ -130,6 +133,9 @@ func (mp *MessageProcessor) ProcessMessage(userID string, data map[string]interf
 				logrus.Errorf("Failed to process message for user %s: %v", userID, err)
 				return
 			}
+			if message.IsDuplicate {
+				return
+			}
 
 			// Broadcast message to circle members
 			wsMessage := models.WSMessage{
@@ -141,6 +147,7 @@ func (mp *MessageProcessor) ProcessMessage(userID string, data map[string]interf
 					Type:      message.Type,
 					Content:   message.Content,
 					Media:     &message.Media,
+					ClientID:  message.ClientID,
 					Timestamp: message.CreatedAt,
 				},
 				Timestamp: time.Now(),
@@ -121,6 +121,20 @@ func (r *Room) RemoveClient(client *Client) {
 	r.broadcastUserLeft(client)
 }
 
+// Recipients returns the room's clients the filter lets a message through to
+func (r *Room) Recipients(filter MessageFilter) []*Client {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	clients := make([]*Client, 0, len(r.clients))
+	for client := range r.clients {
+		if r.shouldSendToClient(client, filter) {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
+
 // Broadcast sends a message to all clients in the room with optional filtering
 func (r *Room) Broadcast(message models.WSMessage, filter MessageFilter) {
 	r.mutex.RLock()
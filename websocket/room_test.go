@@ -0,0 +1,49 @@
+package websocket
+
+import (
+	"sort"
+	"testing"
+)
+
+func recipientIDs(room *Room, filter MessageFilter) []string {
+	var ids []string
+	for _, client := range room.Recipients(filter) {
+		ids = append(ids, client.userID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestRoomRecipients(t *testing.T) {
+	room := &Room{clients: map[*Client]bool{
+		{userID: "alice", isActive: true}: true,
+		{userID: "bob", isActive: true}:   true,
+		{userID: "carol", isActive: true}: true,
+		{userID: "dave", isActive: false}: true,
+	}}
+
+	tests := []struct {
+		name   string
+		filter MessageFilter
+		want   []string
+	}{
+		{"everyone active", MessageFilter{}, []string{"alice", "bob", "carol"}},
+		{"excluded users", MessageFilter{ExcludeUsers: []string{"bob"}}, []string{"alice", "carol"}},
+		{"included users", MessageFilter{IncludeUsers: []string{"bob", "dave"}}, []string{"bob"}},
+		{"excluded wins over included", MessageFilter{ExcludeUsers: []string{"bob"}, IncludeUsers: []string{"bob", "carol"}}, []string{"carol"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := recipientIDs(room, tt.filter)
+			if len(got) != len(tt.want) {
+				t.Fatalf("recipients = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("recipients = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
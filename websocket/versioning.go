@@ -0,0 +1,166 @@
+package websocket
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"ftrack/models"
+
+	"github.com/gorilla/websocket"
+)
+
+// eventSchema records when an event type was introduced and which payload
+// fields were added to it later. Events and fields newer than a client's
+// version are left out of what it receives. Keep docs/WEBSOCKET.md in sync.
+type eventSchema struct {
+	since  int
+	fields map[string]int // payload field -> version it was added in
+}
+
+var eventSchemas = map[string]eventSchema{
+	models.WSTypeAuth: {
+		since: models.WSVersion1,
+		fields: map[string]int{
+			"version":           models.WSVersion2,
+			"supportedVersions": models.WSVersion2,
+		},
+	},
+	models.WSTypeReaction: {
+		since: models.WSVersion1,
+		fields: map[string]int{
+			"count": models.WSVersion2,
+		},
+	},
+	models.WSTypeTrackingHint: {
+		since: models.WSVersion2,
+	},
+	models.WSTypePlaceEvent: {
+		since: models.WSVersion1,
+		fields: map[string]int{
+			"visitId":     models.WSVersion2,
+			"arrivalTime": models.WSVersion2,
+			"duration":    models.WSVersion2,
+		},
+	},
+	models.WSTypeVisitUpdate: {
+		since: models.WSVersion2,
+	},
+}
+
+// supportedVersions lists the protocol versions the hub can serialize
+func supportedVersions() []int {
+	versions := make([]int, 0, models.WSVersionLatest)
+	for version := models.WSVersion1; version <= models.WSVersionLatest; version++ {
+		versions = append(versions, version)
+	}
+	return versions
+}
+
+// negotiateVersion picks the version to talk to a client that asked for
+// requested. Clients that don't ask predate versioning and get version 1;
+// clients newer than the hub get the latest it knows.
+func negotiateVersion(requested string) int {
+	version, err := strconv.Atoi(strings.TrimSpace(requested))
+	if err != nil || version < models.WSVersion1 {
+		return models.WSVersion1
+	}
+	if version > models.WSVersionLatest {
+		return models.WSVersionLatest
+	}
+	return version
+}
+
+// negotiateEncoding picks the payload encoding for a client. Binary is
+// opt-in, anything else gets JSON.
+func negotiateEncoding(requested string) string {
+	if strings.EqualFold(strings.TrimSpace(requested), models.WSEncodingBinary) {
+		return models.WSEncodingBinary
+	}
+	return models.WSEncodingJSON
+}
+
+// encodeFrame serializes a message for a client, returning the frame type
+// to write it as. Binary clients get the events with a binary layout as
+// binary frames and the rest as JSON text frames.
+func encodeFrame(message models.WSMessage, version int, encoding string) ([]byte, int, bool, error) {
+	if encoding == models.WSEncodingBinary {
+		if _, known := eventSchemas[message.Type]; !known {
+			binaryMessage := message
+			binaryMessage.Version = 0
+			if version >= models.WSVersion2 {
+				binaryMessage.Version = version
+			}
+			if frame, ok := models.EncodeWSBinary(binaryMessage); ok {
+				return frame, websocket.BinaryMessage, true, nil
+			}
+		}
+	}
+
+	data, supported, err := encodeMessage(message, version)
+	return data, websocket.TextMessage, supported, err
+}
+
+// encodeMessage serializes a message at a protocol version. It returns
+// false for events the version doesn't have, which aren't sent at all.
+func encodeMessage(message models.WSMessage, version int) ([]byte, bool, error) {
+	schema, known := eventSchemas[message.Type]
+	if known && schema.since > version {
+		return nil, false, nil
+	}
+
+	// The envelope carries its version and event id from version 2
+	message.Version = 0
+	if version >= models.WSVersion2 {
+		message.Version = version
+	} else {
+		message.EventID = ""
+	}
+
+	if known && message.Data != nil {
+		data, err := withoutNewerFields(message.Data, schema.fields, version)
+		if err != nil {
+			return nil, false, err
+		}
+		message.Data = data
+	}
+
+	encoded, err := json.Marshal(message)
+	if err != nil {
+		return nil, false, err
+	}
+	return encoded, true, nil
+}
+
+// withoutNewerFields drops the payload fields added after version. Payloads
+// without such fields are returned as they are.
+func withoutNewerFields(data interface{}, fields map[string]int, version int) (interface{}, error) {
+	newer := false
+	for _, since := range fields {
+		if since > version {
+			newer = true
+			break
+		}
+	}
+	if !newer {
+		return data, nil
+	}
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(encoded, &payload); err != nil {
+		// Not an object, so there are no fields to drop
+		return data, nil
+	}
+
+	for field, since := range fields {
+		if since > version {
+			delete(payload, field)
+		}
+	}
+	return payload, nil
+}
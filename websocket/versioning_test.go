@@ -0,0 +1,107 @@
+package websocket
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"ftrack/models"
+)
+
+func TestNegotiateVersion(t *testing.T) {
+	tests := []struct {
+		requested string
+		want      int
+	}{
+		{"", models.WSVersion1},
+		{"1", models.WSVersion1},
+		{"2", models.WSVersion2},
+		{" 2 ", models.WSVersion2},
+		{"99", models.WSVersionLatest},
+		{"0", models.WSVersion1},
+		{"-3", models.WSVersion1},
+		{"v2", models.WSVersion1},
+	}
+	for _, tt := range tests {
+		if got := negotiateVersion(tt.requested); got != tt.want {
+			t.Errorf("negotiateVersion(%q) = %d, want %d", tt.requested, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeMessageVersions(t *testing.T) {
+	arrival := time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC)
+	placeEvent := models.WSMessage{
+		Type: models.WSTypePlaceEvent,
+		Data: models.WSPlaceEvent{
+			UserID:      "user-1",
+			PlaceID:     "place-1",
+			PlaceName:   "Home",
+			EventType:   "departure",
+			VisitID:     "visit-1",
+			ArrivalTime: &arrival,
+			Duration:    3600,
+		},
+		CircleID: "circle-1",
+		EventID:  "event-1",
+	}
+
+	v1 := decodeEncoded(t, placeEvent, models.WSVersion1)
+	if _, set := v1["version"]; set {
+		t.Errorf("version 1 envelope has a version: %v", v1["version"])
+	}
+	if _, set := v1["eventId"]; set {
+		t.Error("version 1 envelope has an event id")
+	}
+	data := v1["data"].(map[string]interface{})
+	for _, field := range []string{"visitId", "arrivalTime", "duration"} {
+		if _, set := data[field]; set {
+			t.Errorf("version 1 payload has %s", field)
+		}
+	}
+	if data["placeName"] != "Home" || data["eventType"] != "departure" {
+		t.Errorf("version 1 payload = %v, want the version 1 fields kept", data)
+	}
+
+	v2 := decodeEncoded(t, placeEvent, models.WSVersion2)
+	if v2["version"] != float64(models.WSVersion2) || v2["eventId"] != "event-1" {
+		t.Errorf("version 2 envelope = %v, want version 2 and the event id", v2)
+	}
+	data = v2["data"].(map[string]interface{})
+	if data["visitId"] != "visit-1" || data["duration"] != float64(3600) || data["arrivalTime"] == nil {
+		t.Errorf("version 2 payload = %v, want the visit fields", data)
+	}
+
+	// Events added in version 2 aren't sent to version 1 clients at all
+	hint := models.WSMessage{Type: models.WSTypeTrackingHint, Data: map[string]interface{}{"mode": "live"}}
+	if _, supported, err := encodeMessage(hint, models.WSVersion1); err != nil || supported {
+		t.Errorf("tracking hint at version 1 supported = %v, %v; want not sent", supported, err)
+	}
+	if _, supported, err := encodeMessage(hint, models.WSVersion2); err != nil || !supported {
+		t.Errorf("tracking hint at version 2 supported = %v, %v; want sent", supported, err)
+	}
+
+	// Events without a schema are sent unchanged
+	typing := models.WSMessage{Type: models.WSTypeTypingIndicator, Data: map[string]interface{}{"isTyping": true}}
+	for _, version := range supportedVersions() {
+		decoded := decodeEncoded(t, typing, version)
+		if decoded["data"].(map[string]interface{})["isTyping"] != true {
+			t.Errorf("typing at version %d = %v", version, decoded)
+		}
+	}
+}
+
+// decodeEncoded encodes the message at the version and decodes it back as
+// a client would see it
+func decodeEncoded(t *testing.T, message models.WSMessage, version int) map[string]interface{} {
+	t.Helper()
+	encoded, supported, err := encodeMessage(message, version)
+	if err != nil || !supported {
+		t.Fatalf("encodeMessage at version %d = %v, %v", version, supported, err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("decoding version %d: %v", version, err)
+	}
+	return decoded
+}
@@ -0,0 +1,201 @@
+package workers
+
+import (
+	"context"
+	"ftrack/repositories"
+	"ftrack/services"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const accountDeactivationLockKey = "account_deactivation:retention:lock"
+
+type AccountDeactivationWorker struct {
+	// Dependencies
+	db    *mongo.Database
+	redis *redis.Client
+
+	// Services
+	deactivationService *services.AccountDeactivationService
+
+	// Worker configuration
+	config AccountDeactivationWorkerConfig
+
+	// Worker state
+	isRunning bool
+	mutex     sync.RWMutex
+
+	// Context for shutdown
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// Metrics
+	stats      AccountDeactivationWorkerStats
+	statsMutex sync.RWMutex
+}
+
+type AccountDeactivationWorkerConfig struct {
+	// Deactivated accounts are queued for deletion after Retention, with a
+	// warning email WarningPeriod before
+	Retention     time.Duration `json:"retention"`
+	WarningPeriod time.Duration `json:"warningPeriod"`
+
+	CheckInterval time.Duration `json:"checkInterval"`
+	BatchSize     int           `json:"batchSize"`
+}
+
+type AccountDeactivationWorkerStats struct {
+	WarningsSent    int64     `json:"warningsSent"`
+	DeletionsQueued int64     `json:"deletionsQueued"`
+	CheckErrors     int64     `json:"checkErrors"`
+	LastCheckAt     time.Time `json:"lastCheckAt"`
+	StartTime       time.Time `json:"startTime"`
+}
+
+func NewAccountDeactivationWorker(db *mongo.Database, redis *redis.Client, emailService services.EmailService, retention, warningPeriod time.Duration) *AccountDeactivationWorker {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	if retention <= 0 {
+		retention = services.DefaultDeactivationRetention
+	}
+	if warningPeriod <= 0 || warningPeriod >= retention {
+		warningPeriod = services.DefaultDeactivationWarningPeriod
+	}
+
+	config := AccountDeactivationWorkerConfig{
+		Retention:     retention,
+		WarningPeriod: warningPeriod,
+		CheckInterval: 6 * time.Hour,
+		BatchSize:     200,
+	}
+
+	return &AccountDeactivationWorker{
+		db:    db,
+		redis: redis,
+		deactivationService: services.NewAccountDeactivationService(
+			repositories.NewUserRepository(db),
+			repositories.NewUserSessionRepository(db),
+			repositories.NewCircleRepository(db),
+			repositories.NewLocationRepository(db),
+			repositories.NewScheduleRepository(db),
+			repositories.NewAutomationRepository(db),
+			repositories.NewExportRepository(db),
+			emailService,
+		),
+		config: config,
+		ctx:    ctx,
+		cancel: cancel,
+		stats: AccountDeactivationWorkerStats{
+			StartTime: time.Now(),
+		},
+	}
+}
+
+func (aw *AccountDeactivationWorker) Start() error {
+	aw.mutex.Lock()
+	defer aw.mutex.Unlock()
+
+	if aw.isRunning {
+		return nil
+	}
+
+	aw.isRunning = true
+
+	logrus.Info("Starting Account Deactivation Worker...")
+
+	aw.wg.Add(1)
+	go aw.retentionScheduler()
+
+	logrus.Info("Account Deactivation Worker started successfully")
+	return nil
+}
+
+func (aw *AccountDeactivationWorker) Stop() error {
+	aw.mutex.Lock()
+	defer aw.mutex.Unlock()
+
+	if !aw.isRunning {
+		return nil
+	}
+
+	logrus.Info("Stopping Account Deactivation Worker...")
+
+	aw.cancel()
+	aw.isRunning = false
+	aw.wg.Wait()
+
+	logrus.Info("Account Deactivation Worker stopped successfully")
+	return nil
+}
+
+func (aw *AccountDeactivationWorker) retentionScheduler() {
+	defer aw.wg.Done()
+
+	// Catch up right after startup
+	aw.runRetention()
+
+	ticker := time.NewTicker(aw.config.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			aw.runRetention()
+
+		case <-aw.ctx.Done():
+			return
+		}
+	}
+}
+
+func (aw *AccountDeactivationWorker) runRetention() {
+	// Only one instance sends warnings at a time
+	if aw.redis != nil {
+		acquired, err := aw.redis.SetNX(aw.ctx, accountDeactivationLockKey, "1", aw.config.CheckInterval).Result()
+		if err != nil || !acquired {
+			return
+		}
+		defer aw.redis.Del(context.Background(), accountDeactivationLockKey)
+	}
+
+	warned, queued, err := aw.deactivationService.ProcessStaleDeactivations(aw.ctx, aw.config.Retention, aw.config.WarningPeriod, aw.config.BatchSize)
+
+	aw.statsMutex.Lock()
+	defer aw.statsMutex.Unlock()
+
+	aw.stats.WarningsSent += int64(warned)
+	aw.stats.DeletionsQueued += int64(queued)
+	aw.stats.LastCheckAt = time.Now()
+
+	if err != nil {
+		aw.stats.CheckErrors++
+		logrus.Errorf("Deactivated account retention check failed: %v", err)
+		return
+	}
+
+	if warned > 0 || queued > 0 {
+		logrus.Infof("Deactivated accounts - Deletion warnings sent: %d, Queued for deletion: %d", warned, queued)
+	}
+}
+
+func (aw *AccountDeactivationWorker) GetStats() AccountDeactivationWorkerStats {
+	aw.statsMutex.RLock()
+	defer aw.statsMutex.RUnlock()
+	return aw.stats
+}
+
+// Public function to start account deactivation worker
+func StartAccountDeactivationWorker(db *mongo.Database, redis *redis.Client, emailService services.EmailService, retention, warningPeriod time.Duration) *AccountDeactivationWorker {
+	worker := NewAccountDeactivationWorker(db, redis, emailService, retention, warningPeriod)
+
+	if err := worker.Start(); err != nil {
+		logrus.Fatalf("Failed to start account deactivation worker: %v", err)
+	}
+
+	return worker
+}
@@ -0,0 +1,191 @@
+package workers
+
+import (
+	"context"
+	"ftrack/repositories"
+	"ftrack/services"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const albumGenerationLockKey = "circle_albums:generation:lock"
+
+// AlbumWorker suggests circle albums from photos shared in chat during
+// visits to the circles' places
+type AlbumWorker struct {
+	// Dependencies
+	db    *mongo.Database
+	redis *redis.Client
+
+	// Services
+	circleService *services.CircleService
+
+	// Worker configuration
+	config AlbumWorkerConfig
+
+	// Worker state
+	isRunning bool
+	mutex     sync.RWMutex
+
+	// Context for shutdown
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// Metrics
+	stats      AlbumWorkerStats
+	statsMutex sync.RWMutex
+}
+
+type AlbumWorkerConfig struct {
+	GenerationInterval time.Duration `json:"generationInterval"`
+
+	// How far back photos are looked at; later photos of a visit still
+	// join its album
+	Lookback time.Duration `json:"lookback"`
+}
+
+type AlbumWorkerStats struct {
+	AlbumsSuggested  int64     `json:"albumsSuggested"`
+	GenerationErrors int64     `json:"generationErrors"`
+	LastGenerationAt time.Time `json:"lastGenerationAt"`
+	StartTime        time.Time `json:"startTime"`
+}
+
+func NewAlbumWorker(db *mongo.Database, redis *redis.Client) *AlbumWorker {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	config := AlbumWorkerConfig{
+		GenerationInterval: 30 * time.Minute,
+		Lookback:           48 * time.Hour,
+	}
+
+	circleService := services.NewCircleService(
+		repositories.NewCircleRepository(db),
+		repositories.NewUserRepository(db),
+		repositories.NewAuditLogRepository(db),
+		repositories.NewBlockRepository(db),
+		nil, // NotificationService
+	)
+	circleService.ConfigureAlbums(
+		repositories.NewAlbumRepository(db),
+		repositories.NewMessageRepository(db),
+		repositories.NewPlaceRepository(db),
+	)
+
+	return &AlbumWorker{
+		db:            db,
+		redis:         redis,
+		circleService: circleService,
+		config:        config,
+		ctx:           ctx,
+		cancel:        cancel,
+		stats: AlbumWorkerStats{
+			StartTime: time.Now(),
+		},
+	}
+}
+
+func (aw *AlbumWorker) Start() error {
+	aw.mutex.Lock()
+	defer aw.mutex.Unlock()
+
+	if aw.isRunning {
+		return nil
+	}
+
+	aw.isRunning = true
+
+	logrus.Info("Starting Album Worker...")
+
+	aw.wg.Add(1)
+	go aw.generationScheduler()
+
+	logrus.Info("Album Worker started successfully")
+	return nil
+}
+
+func (aw *AlbumWorker) Stop() error {
+	aw.mutex.Lock()
+	defer aw.mutex.Unlock()
+
+	if !aw.isRunning {
+		return nil
+	}
+
+	logrus.Info("Stopping Album Worker...")
+
+	aw.cancel()
+	aw.isRunning = false
+	aw.wg.Wait()
+
+	logrus.Info("Album Worker stopped successfully")
+	return nil
+}
+
+func (aw *AlbumWorker) generationScheduler() {
+	defer aw.wg.Done()
+
+	ticker := time.NewTicker(aw.config.GenerationInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			aw.runGeneration()
+
+		case <-aw.ctx.Done():
+			return
+		}
+	}
+}
+
+func (aw *AlbumWorker) runGeneration() {
+	// Only one instance generates, so a visit isn't suggested twice
+	if aw.redis != nil {
+		acquired, err := aw.redis.SetNX(aw.ctx, albumGenerationLockKey, "1", aw.config.GenerationInterval).Result()
+		if err != nil || !acquired {
+			return
+		}
+		defer aw.redis.Del(context.Background(), albumGenerationLockKey)
+	}
+
+	suggested, err := aw.circleService.GenerateAlbumSuggestions(aw.ctx, time.Now().Add(-aw.config.Lookback))
+
+	aw.statsMutex.Lock()
+	defer aw.statsMutex.Unlock()
+
+	aw.stats.AlbumsSuggested += int64(suggested)
+	aw.stats.LastGenerationAt = time.Now()
+
+	if err != nil {
+		aw.stats.GenerationErrors++
+		logrus.Errorf("Album generation failed: %v", err)
+		return
+	}
+
+	if suggested > 0 {
+		logrus.Infof("Suggested %d circle albums", suggested)
+	}
+}
+
+func (aw *AlbumWorker) GetStats() AlbumWorkerStats {
+	aw.statsMutex.RLock()
+	defer aw.statsMutex.RUnlock()
+	return aw.stats
+}
+
+// Public function to start album worker
+func StartAlbumWorker(db *mongo.Database, redis *redis.Client) *AlbumWorker {
+	worker := NewAlbumWorker(db, redis)
+
+	if err := worker.Start(); err != nil {
+		logrus.Fatalf("Failed to start album worker: %v", err)
+	}
+
+	return worker
+}
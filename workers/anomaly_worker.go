@@ -0,0 +1,193 @@
+package workers
+
+import (
+	"context"
+	"ftrack/repositories"
+	"ftrack/services"
+	"ftrack/websocket"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const anomalyEvaluationLockKey = "anomaly_alerts:evaluation:lock"
+
+// AnomalyWorker checks members' movement against their anomaly profiles
+type AnomalyWorker struct {
+	// Dependencies
+	db    *mongo.Database
+	redis *redis.Client
+
+	// Services
+	anomalyService *services.AnomalyService
+
+	// Worker configuration
+	config AnomalyWorkerConfig
+
+	// Worker state
+	isRunning bool
+	mutex     sync.RWMutex
+
+	// Context for shutdown
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// Metrics
+	stats      AnomalyWorkerStats
+	statsMutex sync.RWMutex
+}
+
+type AnomalyWorkerConfig struct {
+	EvaluationInterval time.Duration `json:"evaluationInterval"`
+	BatchSize          int           `json:"batchSize"`
+}
+
+type AnomalyWorkerStats struct {
+	AlertsRaised     int64     `json:"alertsRaised"`
+	EvaluationErrors int64     `json:"evaluationErrors"`
+	LastEvaluationAt time.Time `json:"lastEvaluationAt"`
+	StartTime        time.Time `json:"startTime"`
+}
+
+func NewAnomalyWorker(db *mongo.Database, redis *redis.Client, hub *websocket.Hub) *AnomalyWorker {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	config := AnomalyWorkerConfig{
+		EvaluationInterval: 5 * time.Minute,
+		BatchSize:          200,
+	}
+
+	circleRepo := repositories.NewCircleRepository(db)
+	userRepo := repositories.NewUserRepository(db)
+	notificationRepo := repositories.NewNotificationRepository(db)
+
+	notificationService := services.NewNotificationService(
+		notificationRepo,
+		userRepo,
+		circleRepo,
+		repositories.NewBlockRepository(db),
+		redis,
+		hub,
+		nil, // EmailService
+		nil, // SMSService
+		services.NewPushService(nil, notificationRepo),
+	)
+
+	return &AnomalyWorker{
+		db:    db,
+		redis: redis,
+		anomalyService: services.NewAnomalyService(
+			repositories.NewAnomalyRepository(db),
+			repositories.NewLocationRepository(db),
+			repositories.NewPlaceRepository(db),
+			circleRepo,
+			userRepo,
+			notificationService,
+		),
+		config: config,
+		ctx:    ctx,
+		cancel: cancel,
+		stats: AnomalyWorkerStats{
+			StartTime: time.Now(),
+		},
+	}
+}
+
+func (aw *AnomalyWorker) Start() error {
+	aw.mutex.Lock()
+	defer aw.mutex.Unlock()
+
+	if aw.isRunning {
+		return nil
+	}
+
+	aw.isRunning = true
+
+	logrus.Info("Starting Anomaly Worker...")
+
+	aw.wg.Add(1)
+	go aw.evaluationScheduler()
+
+	logrus.Info("Anomaly Worker started successfully")
+	return nil
+}
+
+func (aw *AnomalyWorker) Stop() error {
+	aw.mutex.Lock()
+	defer aw.mutex.Unlock()
+
+	if !aw.isRunning {
+		return nil
+	}
+
+	logrus.Info("Stopping Anomaly Worker...")
+
+	aw.cancel()
+	aw.isRunning = false
+	aw.wg.Wait()
+
+	logrus.Info("Anomaly Worker stopped successfully")
+	return nil
+}
+
+func (aw *AnomalyWorker) evaluationScheduler() {
+	defer aw.wg.Done()
+
+	ticker := time.NewTicker(aw.config.EvaluationInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			aw.runEvaluation()
+
+		case <-aw.ctx.Done():
+			return
+		}
+	}
+}
+
+func (aw *AnomalyWorker) runEvaluation() {
+	// Only one instance evaluates, so watchers get each alert once
+	if aw.redis != nil {
+		acquired, err := aw.redis.SetNX(aw.ctx, anomalyEvaluationLockKey, "1", aw.config.EvaluationInterval).Result()
+		if err != nil || !acquired {
+			return
+		}
+		defer aw.redis.Del(context.Background(), anomalyEvaluationLockKey)
+	}
+
+	raised, err := aw.anomalyService.EvaluateProfiles(aw.ctx, aw.config.BatchSize)
+
+	aw.statsMutex.Lock()
+	defer aw.statsMutex.Unlock()
+
+	aw.stats.AlertsRaised += int64(raised)
+	aw.stats.LastEvaluationAt = time.Now()
+
+	if err != nil {
+		aw.stats.EvaluationErrors++
+		logrus.Errorf("Anomaly evaluation failed: %v", err)
+	}
+}
+
+func (aw *AnomalyWorker) GetStats() AnomalyWorkerStats {
+	aw.statsMutex.RLock()
+	defer aw.statsMutex.RUnlock()
+	return aw.stats
+}
+
+// Public function to start anomaly worker
+func StartAnomalyWorker(db *mongo.Database, redis *redis.Client, hub *websocket.Hub) *AnomalyWorker {
+	worker := NewAnomalyWorker(db, redis, hub)
+
+	if err := worker.Start(); err != nil {
+		logrus.Fatalf("Failed to start anomaly worker: %v", err)
+	}
+
+	return worker
+}
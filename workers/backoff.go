@@ -0,0 +1,41 @@
+package workers
+
+import (
+	"context"
+	"time"
+
+	"ftrack/database"
+)
+
+const (
+	minDatabaseBackoff = 500 * time.Millisecond
+	maxDatabaseBackoff = 30 * time.Second
+)
+
+// databaseBackoff holds a worker back while the database circuit is open,
+// waiting twice as long each time it's still open, so workers don't spin on
+// failing jobs during an outage
+type databaseBackoff struct {
+	delay time.Duration
+}
+
+// wait returns true once the circuit lets calls through, or false when ctx
+// is done first
+func (b *databaseBackoff) wait(ctx context.Context) bool {
+	for database.Breaker.Allow() != nil {
+		if b.delay == 0 {
+			b.delay = minDatabaseBackoff
+		} else if b.delay *= 2; b.delay > maxDatabaseBackoff {
+			b.delay = maxDatabaseBackoff
+		}
+
+		select {
+		case <-time.After(b.delay):
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	b.delay = 0
+	return true
+}
@@ -0,0 +1,234 @@
+package workers
+
+import (
+	"context"
+	"ftrack/repositories"
+	"ftrack/services"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const circleArchiveCleanupLockKey = "circle_merge:archive_cleanup:lock"
+
+type CircleMergeWorker struct {
+	// Dependencies
+	db    *mongo.Database
+	redis *redis.Client
+
+	// Services
+	circleService *services.CircleService
+
+	// Worker configuration
+	config CircleMergeWorkerConfig
+
+	// Worker state
+	isRunning bool
+	mutex     sync.RWMutex
+
+	// Context for shutdown
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// Metrics
+	stats      CircleMergeWorkerStats
+	statsMutex sync.RWMutex
+}
+
+type CircleMergeWorkerConfig struct {
+	// How often merges whose instance stopped are looked for
+	ResumeInterval time.Duration `json:"resumeInterval"`
+
+	// How often archived circles past their retention are deleted
+	CleanupInterval time.Duration `json:"cleanupInterval"`
+	BatchSize       int           `json:"batchSize"`
+}
+
+type CircleMergeWorkerStats struct {
+	MergesResumed  int64     `json:"mergesResumed"`
+	CirclesDeleted int64     `json:"circlesDeleted"`
+	Errors         int64     `json:"errors"`
+	LastResumeAt   time.Time `json:"lastResumeAt"`
+	LastCleanupAt  time.Time `json:"lastCleanupAt"`
+	StartTime      time.Time `json:"startTime"`
+}
+
+func NewCircleMergeWorker(db *mongo.Database, redis *redis.Client) *CircleMergeWorker {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	config := CircleMergeWorkerConfig{
+		ResumeInterval:  1 * time.Minute,
+		CleanupInterval: 1 * time.Hour,
+		BatchSize:       100,
+	}
+
+	circleRepo := repositories.NewCircleRepository(db)
+	placeRepo := repositories.NewPlaceRepository(db)
+
+	circleService := services.NewCircleService(
+		circleRepo,
+		repositories.NewUserRepository(db),
+		repositories.NewAuditLogRepository(db),
+		repositories.NewBlockRepository(db),
+		nil, // NotificationService
+	)
+	circleService.ConfigureMerge(
+		services.NewPlaceService(placeRepo, circleRepo, nil),
+		repositories.NewMessageRepository(db),
+		repositories.NewAutomationRepository(db),
+	)
+
+	return &CircleMergeWorker{
+		db:            db,
+		redis:         redis,
+		circleService: circleService,
+		config:        config,
+		ctx:           ctx,
+		cancel:        cancel,
+		stats: CircleMergeWorkerStats{
+			StartTime: time.Now(),
+		},
+	}
+}
+
+func (cw *CircleMergeWorker) Start() error {
+	cw.mutex.Lock()
+	defer cw.mutex.Unlock()
+
+	if cw.isRunning {
+		return nil
+	}
+
+	cw.isRunning = true
+
+	logrus.Info("Starting Circle Merge Worker...")
+
+	cw.wg.Add(2)
+	go cw.resumeScheduler()
+	go cw.cleanupScheduler()
+
+	logrus.Info("Circle Merge Worker started successfully")
+	return nil
+}
+
+func (cw *CircleMergeWorker) Stop() error {
+	cw.mutex.Lock()
+	defer cw.mutex.Unlock()
+
+	if !cw.isRunning {
+		return nil
+	}
+
+	logrus.Info("Stopping Circle Merge Worker...")
+
+	cw.cancel()
+	cw.isRunning = false
+	cw.wg.Wait()
+
+	logrus.Info("Circle Merge Worker stopped successfully")
+	return nil
+}
+
+func (cw *CircleMergeWorker) resumeScheduler() {
+	defer cw.wg.Done()
+
+	ticker := time.NewTicker(cw.config.ResumeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			cw.resumeMerges()
+
+		case <-cw.ctx.Done():
+			return
+		}
+	}
+}
+
+// resumeMerges restarts merges left running by an instance that stopped.
+// Claiming a merge is atomic, so instances don't need a lock for it.
+func (cw *CircleMergeWorker) resumeMerges() {
+	resumed, err := cw.circleService.ResumeStaleMerges(cw.ctx)
+
+	cw.statsMutex.Lock()
+	defer cw.statsMutex.Unlock()
+
+	cw.stats.MergesResumed += int64(resumed)
+	cw.stats.LastResumeAt = time.Now()
+
+	if err != nil {
+		cw.stats.Errors++
+		logrus.Errorf("Resuming circle merges failed: %v", err)
+	}
+}
+
+func (cw *CircleMergeWorker) cleanupScheduler() {
+	defer cw.wg.Done()
+
+	// Catch up right after startup
+	cw.deleteExpiredCircles()
+
+	ticker := time.NewTicker(cw.config.CleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			cw.deleteExpiredCircles()
+
+		case <-cw.ctx.Done():
+			return
+		}
+	}
+}
+
+func (cw *CircleMergeWorker) deleteExpiredCircles() {
+	// Only one instance deletes at a time
+	if cw.redis != nil {
+		acquired, err := cw.redis.SetNX(cw.ctx, circleArchiveCleanupLockKey, "1", cw.config.CleanupInterval).Result()
+		if err != nil || !acquired {
+			return
+		}
+		defer cw.redis.Del(context.Background(), circleArchiveCleanupLockKey)
+	}
+
+	deleted, err := cw.circleService.DeleteExpiredArchivedCircles(cw.ctx, cw.config.BatchSize)
+
+	cw.statsMutex.Lock()
+	defer cw.statsMutex.Unlock()
+
+	cw.stats.CirclesDeleted += int64(deleted)
+	cw.stats.LastCleanupAt = time.Now()
+
+	if err != nil {
+		cw.stats.Errors++
+		logrus.Errorf("Deleting archived circles failed: %v", err)
+		return
+	}
+
+	if deleted > 0 {
+		logrus.Infof("Deleted %d circles whose post-merge archive period ended", deleted)
+	}
+}
+
+func (cw *CircleMergeWorker) GetStats() CircleMergeWorkerStats {
+	cw.statsMutex.RLock()
+	defer cw.statsMutex.RUnlock()
+	return cw.stats
+}
+
+// Public function to start circle merge worker
+func StartCircleMergeWorker(db *mongo.Database, redis *redis.Client) *CircleMergeWorker {
+	worker := NewCircleMergeWorker(db, redis)
+
+	if err := worker.Start(); err != nil {
+		logrus.Fatalf("Failed to start circle merge worker: %v", err)
+	}
+
+	return worker
+}
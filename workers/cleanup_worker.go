@@ -2,7 +2,9 @@ package workers
 
 import (
 	"context"
+	"ftrack/models"
 	"ftrack/repositories"
+	"ftrack/services"
 	"ftrack/utils"
 	"sync"
 	"time"
@@ -22,6 +24,13 @@ type CleanupWorker struct {
 	notificationRepo *repositories.NotificationRepository
 	emergencyRepo    *repositories.EmergencyRepository
 	messageRepo      *repositories.MessageRepository
+	deliveryRepo     *repositories.MessageDeliveryRepository
+	placeListRepo    *repositories.PlaceListRepository
+
+	// Services
+	exportService        *services.ExportService
+	impersonationService *services.ImpersonationService
+	mediaUploadService   *services.MediaUploadService
 
 	// Worker configuration
 	config CleanupWorkerConfig
@@ -50,12 +59,32 @@ type CleanupWorkerConfig struct {
 	MessageRetentionDays      int `json:"messageRetentionDays"`
 	LogRetentionDays          int `json:"logRetentionDays"`
 
+	// Exports stuck in pending/processing longer than this are failed
+	ExportTimeout time.Duration `json:"exportTimeout"`
+
+	// Impersonation sessions that ran out are closed, and their users sent
+	// a summary, this often
+	ImpersonationCleanupInterval time.Duration `json:"impersonationCleanupInterval"`
+	EnableImpersonationCleanup   bool          `json:"enableImpersonationCleanup"`
+
+	DeliveryCompactionInterval time.Duration `json:"deliveryCompactionInterval"`
+	EnableDeliveryCompaction   bool          `json:"enableDeliveryCompaction"`
+
+	// Lists completed a week ago are archived this often
+	ListArchiveInterval time.Duration `json:"listArchiveInterval"`
+	EnableListArchive   bool          `json:"enableListArchive"`
+
+	// Abandoned direct uploads and their objects are deleted this often
+	MediaUploadCleanupInterval time.Duration `json:"mediaUploadCleanupInterval"`
+	EnableMediaUploadCleanup   bool          `json:"enableMediaUploadCleanup"`
+
 	// Cleanup intervals
 	LocationCleanupInterval     time.Duration `json:"locationCleanupInterval"`
 	NotificationCleanupInterval time.Duration `json:"notificationCleanupInterval"`
 	MessageCleanupInterval      time.Duration `json:"messageCleanupInterval"`
 	RedisCleanupInterval        time.Duration `json:"redisCleanupInterval"`
 	TempFileCleanupInterval     time.Duration `json:"tempFileCleanupInterval"`
+	ExportCleanupInterval       time.Duration `json:"exportCleanupInterval"`
 
 	// Batch sizes
 	CleanupBatchSize int `json:"cleanupBatchSize"`
@@ -66,6 +95,7 @@ type CleanupWorkerConfig struct {
 	EnableMessageCleanup      bool `json:"enableMessageCleanup"`
 	EnableRedisCleanup        bool `json:"enableRedisCleanup"`
 	EnableTempFileCleanup     bool `json:"enableTempFileCleanup"`
+	EnableExportCleanup       bool `json:"enableExportCleanup"`
 }
 
 type CleanupTask struct {
@@ -87,13 +117,14 @@ type CleanupWorkerStats struct {
 	MessagesCleaned      int64            `json:"messagesCleaned"`
 	RedisKeysCleaned     int64            `json:"redisKeysCleaned"`
 	TempFilesCleaned     int64            `json:"tempFilesCleaned"`
+	ExportsFailed        int64            `json:"exportsFailed"`
 	BytesFreed           int64            `json:"bytesFreed"`
 	LastCleanupAt        time.Time        `json:"lastCleanupAt"`
 	TaskExecutionTimes   map[string]int64 `json:"taskExecutionTimes"` // ms
 	StartTime            time.Time        `json:"startTime"`
 }
 
-func NewCleanupWorker(db *mongo.Database, redis *redis.Client) *CleanupWorker {
+func NewCleanupWorker(db *mongo.Database, redis *redis.Client, mediaStore *services.MediaObjectStore) *CleanupWorker {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	config := CleanupWorkerConfig{
@@ -103,12 +134,28 @@ func NewCleanupWorker(db *mongo.Database, redis *redis.Client) *CleanupWorker {
 		MessageRetentionDays:      365,
 		LogRetentionDays:          7,
 
+		// Default export timeout
+		ExportTimeout: 30 * time.Minute,
+
+		ImpersonationCleanupInterval: 5 * time.Minute,
+		EnableImpersonationCleanup:   true,
+
+		DeliveryCompactionInterval: 24 * time.Hour,
+		EnableDeliveryCompaction:   true,
+
+		ListArchiveInterval: 24 * time.Hour,
+		EnableListArchive:   true,
+
+		MediaUploadCleanupInterval: 1 * time.Hour,
+		EnableMediaUploadCleanup:   mediaStore != nil,
+
 		// Default cleanup intervals
 		LocationCleanupInterval:     24 * time.Hour,     // Daily
 		NotificationCleanupInterval: 24 * time.Hour,     // Daily
 		MessageCleanupInterval:      7 * 24 * time.Hour, // Weekly
 		RedisCleanupInterval:        1 * time.Hour,      // Hourly
 		TempFileCleanupInterval:     6 * time.Hour,      // Every 6 hours
+		ExportCleanupInterval:       10 * time.Minute,   // Every 10 minutes
 
 		// Default batch size
 		CleanupBatchSize: 1000,
@@ -119,15 +166,33 @@ func NewCleanupWorker(db *mongo.Database, redis *redis.Client) *CleanupWorker {
 		EnableMessageCleanup:      true,
 		EnableRedisCleanup:        true,
 		EnableTempFileCleanup:     true,
+		EnableExportCleanup:       true,
 	}
 
+	notificationRepo := repositories.NewNotificationRepository(db)
+	userRepo := repositories.NewUserRepository(db)
+	notificationService := services.NewNotificationService(
+		notificationRepo,
+		userRepo,
+		repositories.NewCircleRepository(db),
+		repositories.NewBlockRepository(db),
+		redis,
+		nil, // WebSocket hub
+		nil, // EmailService
+		nil, // SMSService
+		services.NewPushService(nil, notificationRepo),
+	)
+
 	worker := &CleanupWorker{
 		db:               db,
 		redis:            redis,
 		locationRepo:     repositories.NewLocationRepository(db),
-		notificationRepo: repositories.NewNotificationRepository(db),
+		notificationRepo: notificationRepo,
 		emergencyRepo:    repositories.NewEmergencyRepository(db),
 		messageRepo:      repositories.NewMessageRepository(db),
+		deliveryRepo:     repositories.NewMessageDeliveryRepository(db),
+		placeListRepo:    repositories.NewPlaceListRepository(db),
+		exportService:    services.NewExportService(repositories.NewExportRepository(db), services.DefaultExportDir),
 		config:           config,
 		ctx:              ctx,
 		cancel:           cancel,
@@ -137,6 +202,21 @@ func NewCleanupWorker(db *mongo.Database, redis *redis.Client) *CleanupWorker {
 		},
 	}
 
+	worker.impersonationService = services.NewImpersonationService(
+		repositories.NewImpersonationRepository(db),
+		userRepo,
+		repositories.NewAuditLogRepository(db),
+		notificationService,
+		nil, // Tokens are only issued by the API
+	)
+
+	worker.mediaUploadService = services.NewMediaUploadService(
+		repositories.NewMediaRepository(db),
+		mediaStore,
+		nil, // Uploads are only created by the API
+		0,
+	)
+
 	// Initialize cleanup tasks
 	worker.initializeTasks()
 
@@ -222,6 +302,41 @@ func (cw *CleanupWorker) initializeTasks() {
 			Enabled:     cw.config.EnableTempFileCleanup,
 			Function:    cw.cleanupTempFiles,
 		},
+		{
+			Name:        "export_cleanup",
+			Description: "Fail stuck exports and remove their partial files",
+			Interval:    cw.config.ExportCleanupInterval,
+			Enabled:     cw.config.EnableExportCleanup,
+			Function:    cw.cleanupStuckExports,
+		},
+		{
+			Name:        "impersonation_cleanup",
+			Description: "Close impersonation sessions that ran out and send their summaries",
+			Interval:    cw.config.ImpersonationCleanupInterval,
+			Enabled:     cw.config.EnableImpersonationCleanup,
+			Function:    cw.closeExpiredImpersonations,
+		},
+		{
+			Name:        "delivery_compaction",
+			Description: "Compact old message delivery detail into counts",
+			Interval:    cw.config.DeliveryCompactionInterval,
+			Enabled:     cw.config.EnableDeliveryCompaction,
+			Function:    cw.compactMessageDeliveries,
+		},
+		{
+			Name:        "place_list_archive",
+			Description: "Archive lists completed over a week ago",
+			Interval:    cw.config.ListArchiveInterval,
+			Enabled:     cw.config.EnableListArchive,
+			Function:    cw.archiveCompletedLists,
+		},
+		{
+			Name:        "media_upload_cleanup",
+			Description: "Delete abandoned direct uploads and their objects",
+			Interval:    cw.config.MediaUploadCleanupInterval,
+			Enabled:     cw.config.EnableMediaUploadCleanup,
+			Function:    cw.cleanupExpiredUploads,
+		},
 	}
 
 	// Set initial next run times
@@ -378,6 +493,89 @@ func (cw *CleanupWorker) cleanupTempFiles(ctx context.Context) error {
 	return nil
 }
 
+func (cw *CleanupWorker) cleanupStuckExports(ctx context.Context) error {
+	failedCount, err := cw.exportService.FailStuckExports(ctx, cw.config.ExportTimeout)
+	if err != nil {
+		return err
+	}
+
+	cw.statsMutex.Lock()
+	cw.stats.ExportsFailed += int64(failedCount)
+	cw.stats.LastCleanupAt = time.Now()
+	cw.statsMutex.Unlock()
+
+	if failedCount > 0 {
+		logrus.Infof("Failed %d stuck exports", failedCount)
+	}
+	return nil
+}
+
+func (cw *CleanupWorker) closeExpiredImpersonations(ctx context.Context) error {
+	closed, err := cw.impersonationService.CloseExpiredSessions(ctx)
+	if err != nil {
+		return err
+	}
+
+	if closed > 0 {
+		logrus.Infof("Closed %d expired impersonation sessions", closed)
+	}
+	return nil
+}
+
+// compactMessageDeliveries drops the per-member detail of message delivery
+// summaries past the detail period, keeping their counts
+func (cw *CleanupWorker) compactMessageDeliveries(ctx context.Context) error {
+	cutoff := time.Now().AddDate(0, 0, -models.MessageDeliveryDetailDays)
+
+	total := 0
+	for {
+		compacted, err := cw.deliveryRepo.CompactBefore(ctx, cutoff, cw.config.CleanupBatchSize)
+		if err != nil {
+			return err
+		}
+		total += compacted
+		if compacted < cw.config.CleanupBatchSize {
+			break
+		}
+	}
+
+	if total > 0 {
+		logrus.Infof("Compacted delivery detail of %d messages", total)
+	}
+	return nil
+}
+
+func (cw *CleanupWorker) archiveCompletedLists(ctx context.Context) error {
+	archived, err := cw.placeListRepo.ArchiveCompletedBefore(ctx, time.Now().Add(-models.PlaceListArchiveAfter))
+	if err != nil {
+		return err
+	}
+
+	if archived > 0 {
+		logrus.Infof("Archived %d completed lists", archived)
+	}
+	return nil
+}
+
+func (cw *CleanupWorker) cleanupExpiredUploads(ctx context.Context) error {
+	total := 0
+	for {
+		deleted, err := cw.mediaUploadService.CleanupExpired(ctx, cw.config.CleanupBatchSize)
+		total += deleted
+		if err != nil {
+			return err
+		}
+		if deleted < cw.config.CleanupBatchSize {
+			break
+		}
+	}
+
+	if total > 0 {
+		logrus.Infof("Deleted %d abandoned uploads", total)
+	}
+	return nil
+}
+
 func (cw *CleanupWorker) metricsCollector() {
 	defer cw.wg.Done()
 
@@ -441,8 +639,8 @@ func (cw *CleanupWorker) DisableTask(taskName string) error {
 }
 
 // Public function to start cleanup worker
-func StartCleanupWorker(db *mongo.Database, redis *redis.Client) *CleanupWorker {
-	worker := NewCleanupWorker(db, redis)
+func StartCleanupWorker(db *mongo.Database, redis *redis.Client, mediaStore *services.MediaObjectStore) *CleanupWorker {
+	worker := NewCleanupWorker(db, redis, mediaStore)
 
 	if err := worker.Start(); err != nil {
 		logrus.Fatalf("Failed to start cleanup worker: %v", err)
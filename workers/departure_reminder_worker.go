@@ -0,0 +1,260 @@
+package workers
+
+import (
+	"context"
+	"ftrack/repositories"
+	"ftrack/services"
+	"ftrack/websocket"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const departureDispatchLockKey = "departure_reminders:dispatch:lock"
+
+type DepartureReminderWorker struct {
+	// Dependencies
+	db    *mongo.Database
+	redis *redis.Client
+
+	// Services
+	reminderService *services.DepartureReminderService
+
+	// Worker configuration
+	config DepartureReminderWorkerConfig
+
+	// Worker state
+	isRunning bool
+	mutex     sync.RWMutex
+
+	// Context for shutdown
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// Metrics
+	stats      DepartureReminderWorkerStats
+	statsMutex sync.RWMutex
+}
+
+type DepartureReminderWorkerConfig struct {
+	DispatchInterval  time.Duration `json:"dispatchInterval"`
+	RecomputeInterval time.Duration `json:"recomputeInterval"`
+	MetricsInterval   time.Duration `json:"metricsInterval"`
+	BatchSize         int           `json:"batchSize"`
+}
+
+type DepartureReminderWorkerStats struct {
+	RemindersSent      int64     `json:"remindersSent"`
+	PatternsRecomputed int64     `json:"patternsRecomputed"`
+	DispatchErrors     int64     `json:"dispatchErrors"`
+	LastDispatchAt     time.Time `json:"lastDispatchAt"`
+	LastRecomputeAt    time.Time `json:"lastRecomputeAt"`
+	StartTime          time.Time `json:"startTime"`
+}
+
+func NewDepartureReminderWorker(db *mongo.Database, redis *redis.Client, hub *websocket.Hub) *DepartureReminderWorker {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	config := DepartureReminderWorkerConfig{
+		DispatchInterval:  1 * time.Minute,
+		RecomputeInterval: 1 * time.Hour, // Patterns older than a day are refreshed
+		MetricsInterval:   15 * time.Minute,
+		BatchSize:         200,
+	}
+
+	placeRepo := repositories.NewPlaceRepository(db)
+	circleRepo := repositories.NewCircleRepository(db)
+	notificationRepo := repositories.NewNotificationRepository(db)
+
+	notificationService := services.NewNotificationService(
+		notificationRepo,
+		repositories.NewUserRepository(db),
+		circleRepo,
+		repositories.NewBlockRepository(db),
+		redis,
+		hub,
+		nil, // EmailService
+		nil, // SMSService
+		services.NewPushService(nil, notificationRepo),
+	)
+
+	return &DepartureReminderWorker{
+		db:    db,
+		redis: redis,
+		reminderService: services.NewDepartureReminderService(
+			repositories.NewDepartureReminderRepository(db),
+			placeRepo,
+			notificationRepo,
+			services.NewPlaceService(placeRepo, circleRepo, nil),
+			notificationService,
+		),
+		config: config,
+		ctx:    ctx,
+		cancel: cancel,
+		stats: DepartureReminderWorkerStats{
+			StartTime: time.Now(),
+		},
+	}
+}
+
+func (dw *DepartureReminderWorker) Start() error {
+	dw.mutex.Lock()
+	defer dw.mutex.Unlock()
+
+	if dw.isRunning {
+		return nil
+	}
+
+	dw.isRunning = true
+
+	logrus.Info("Starting Departure Reminder Worker...")
+
+	dw.wg.Add(3)
+	go dw.dispatchScheduler()
+	go dw.recomputeScheduler()
+	go dw.metricsCollector()
+
+	logrus.Info("Departure Reminder Worker started successfully")
+	return nil
+}
+
+func (dw *DepartureReminderWorker) Stop() error {
+	dw.mutex.Lock()
+	defer dw.mutex.Unlock()
+
+	if !dw.isRunning {
+		return nil
+	}
+
+	logrus.Info("Stopping Departure Reminder Worker...")
+
+	dw.cancel()
+	dw.isRunning = false
+	dw.wg.Wait()
+
+	logrus.Info("Departure Reminder Worker stopped successfully")
+	return nil
+}
+
+func (dw *DepartureReminderWorker) dispatchScheduler() {
+	defer dw.wg.Done()
+
+	ticker := time.NewTicker(dw.config.DispatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			dw.runDispatch()
+
+		case <-dw.ctx.Done():
+			return
+		}
+	}
+}
+
+func (dw *DepartureReminderWorker) runDispatch() {
+	// Only one instance sends reminders at a time
+	if dw.redis != nil {
+		acquired, err := dw.redis.SetNX(dw.ctx, departureDispatchLockKey, "1", dw.config.DispatchInterval).Result()
+		if err != nil || !acquired {
+			return
+		}
+		defer dw.redis.Del(context.Background(), departureDispatchLockKey)
+	}
+
+	sent, err := dw.reminderService.ProcessDueReminders(dw.ctx, dw.config.BatchSize)
+
+	dw.statsMutex.Lock()
+	defer dw.statsMutex.Unlock()
+
+	if err != nil {
+		dw.stats.DispatchErrors++
+		logrus.Errorf("Departure reminder dispatch failed: %v", err)
+		return
+	}
+
+	dw.stats.RemindersSent += int64(sent)
+	dw.stats.LastDispatchAt = time.Now()
+}
+
+func (dw *DepartureReminderWorker) recomputeScheduler() {
+	defer dw.wg.Done()
+
+	// Catch up on stale patterns right after startup
+	dw.runRecompute()
+
+	ticker := time.NewTicker(dw.config.RecomputeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			dw.runRecompute()
+
+		case <-dw.ctx.Done():
+			return
+		}
+	}
+}
+
+func (dw *DepartureReminderWorker) runRecompute() {
+	refreshed, err := dw.reminderService.RecomputeStalePatterns(dw.ctx, dw.config.BatchSize)
+	if err != nil {
+		logrus.Errorf("Departure pattern recompute failed: %v", err)
+		return
+	}
+
+	dw.statsMutex.Lock()
+	defer dw.statsMutex.Unlock()
+
+	dw.stats.PatternsRecomputed += int64(refreshed)
+	dw.stats.LastRecomputeAt = time.Now()
+}
+
+func (dw *DepartureReminderWorker) metricsCollector() {
+	defer dw.wg.Done()
+
+	ticker := time.NewTicker(dw.config.MetricsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			dw.collectMetrics()
+
+		case <-dw.ctx.Done():
+			return
+		}
+	}
+}
+
+func (dw *DepartureReminderWorker) collectMetrics() {
+	dw.statsMutex.RLock()
+	stats := dw.stats
+	dw.statsMutex.RUnlock()
+
+	logrus.Infof("Departure Reminder Worker Stats - Sent: %d, Patterns recomputed: %d, Dispatch errors: %d",
+		stats.RemindersSent, stats.PatternsRecomputed, stats.DispatchErrors)
+}
+
+func (dw *DepartureReminderWorker) GetStats() DepartureReminderWorkerStats {
+	dw.statsMutex.RLock()
+	defer dw.statsMutex.RUnlock()
+	return dw.stats
+}
+
+// Public function to start departure reminder worker
+func StartDepartureReminderWorker(db *mongo.Database, redis *redis.Client, hub *websocket.Hub) *DepartureReminderWorker {
+	worker := NewDepartureReminderWorker(db, redis, hub)
+
+	if err := worker.Start(); err != nil {
+		logrus.Fatalf("Failed to start departure reminder worker: %v", err)
+	}
+
+	return worker
+}
@@ -0,0 +1,193 @@
+package workers
+
+import (
+	"context"
+	"ftrack/repositories"
+	"ftrack/services"
+	"ftrack/websocket"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const drivingSafetyReportLockKey = "driving_safety:reports:lock"
+
+// DrivingSafetyWorker sends drivers' weekly safety reports to the members
+// they picked
+type DrivingSafetyWorker struct {
+	// Dependencies
+	db    *mongo.Database
+	redis *redis.Client
+
+	// Services
+	locationService *services.LocationService
+
+	// Worker configuration
+	config DrivingSafetyWorkerConfig
+
+	// Worker state
+	isRunning bool
+	mutex     sync.RWMutex
+
+	// Context for shutdown
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// Metrics
+	stats      DrivingSafetyWorkerStats
+	statsMutex sync.RWMutex
+}
+
+type DrivingSafetyWorkerConfig struct {
+	CheckInterval time.Duration `json:"checkInterval"`
+	BatchSize     int           `json:"batchSize"`
+}
+
+type DrivingSafetyWorkerStats struct {
+	ReportsSent int64     `json:"reportsSent"`
+	CheckErrors int64     `json:"checkErrors"`
+	LastCheckAt time.Time `json:"lastCheckAt"`
+	StartTime   time.Time `json:"startTime"`
+}
+
+func NewDrivingSafetyWorker(db *mongo.Database, redis *redis.Client, hub *websocket.Hub) *DrivingSafetyWorker {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	config := DrivingSafetyWorkerConfig{
+		CheckInterval: 1 * time.Hour,
+		BatchSize:     200,
+	}
+
+	locationRepo := repositories.NewLocationRepository(db)
+	circleRepo := repositories.NewCircleRepository(db)
+	placeRepo := repositories.NewPlaceRepository(db)
+	userRepo := repositories.NewUserRepository(db)
+	blockRepo := repositories.NewBlockRepository(db)
+	notificationRepo := repositories.NewNotificationRepository(db)
+
+	notificationService := services.NewNotificationService(
+		notificationRepo,
+		userRepo,
+		circleRepo,
+		blockRepo,
+		redis,
+		hub,
+		nil, // EmailService
+		nil, // SMSService
+		services.NewPushService(nil, notificationRepo),
+	)
+
+	locationService := services.NewLocationService(locationRepo, circleRepo, placeRepo, userRepo, blockRepo, nil, hub)
+	locationService.ConfigureDrivingSafetyReports(notificationService)
+
+	return &DrivingSafetyWorker{
+		db:              db,
+		redis:           redis,
+		locationService: locationService,
+		config:          config,
+		ctx:             ctx,
+		cancel:          cancel,
+		stats: DrivingSafetyWorkerStats{
+			StartTime: time.Now(),
+		},
+	}
+}
+
+func (dw *DrivingSafetyWorker) Start() error {
+	dw.mutex.Lock()
+	defer dw.mutex.Unlock()
+
+	if dw.isRunning {
+		return nil
+	}
+
+	dw.isRunning = true
+
+	logrus.Info("Starting Driving Safety Worker...")
+
+	dw.wg.Add(1)
+	go dw.checkScheduler()
+
+	logrus.Info("Driving Safety Worker started successfully")
+	return nil
+}
+
+func (dw *DrivingSafetyWorker) Stop() error {
+	dw.mutex.Lock()
+	defer dw.mutex.Unlock()
+
+	if !dw.isRunning {
+		return nil
+	}
+
+	logrus.Info("Stopping Driving Safety Worker...")
+
+	dw.cancel()
+	dw.isRunning = false
+	dw.wg.Wait()
+
+	logrus.Info("Driving Safety Worker stopped successfully")
+	return nil
+}
+
+func (dw *DrivingSafetyWorker) checkScheduler() {
+	defer dw.wg.Done()
+
+	ticker := time.NewTicker(dw.config.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			dw.runCheck()
+
+		case <-dw.ctx.Done():
+			return
+		}
+	}
+}
+
+func (dw *DrivingSafetyWorker) runCheck() {
+	// Only one instance checks, so each report goes out once
+	if dw.redis != nil {
+		acquired, err := dw.redis.SetNX(dw.ctx, drivingSafetyReportLockKey, "1", dw.config.CheckInterval).Result()
+		if err != nil || !acquired {
+			return
+		}
+		defer dw.redis.Del(context.Background(), drivingSafetyReportLockKey)
+	}
+
+	sent, err := dw.locationService.SendWeeklySafetyReports(dw.ctx, dw.config.BatchSize)
+
+	dw.statsMutex.Lock()
+	defer dw.statsMutex.Unlock()
+
+	dw.stats.ReportsSent += int64(sent)
+	dw.stats.LastCheckAt = time.Now()
+
+	if err != nil {
+		dw.stats.CheckErrors++
+		logrus.Errorf("Driving safety reports failed: %v", err)
+	}
+}
+
+func (dw *DrivingSafetyWorker) GetStats() DrivingSafetyWorkerStats {
+	dw.statsMutex.RLock()
+	defer dw.statsMutex.RUnlock()
+	return dw.stats
+}
+
+// Public function to start driving safety worker
+func StartDrivingSafetyWorker(db *mongo.Database, redis *redis.Client, hub *websocket.Hub) *DrivingSafetyWorker {
+	worker := NewDrivingSafetyWorker(db, redis, hub)
+
+	if err := worker.Start(); err != nil {
+		logrus.Fatalf("Failed to start driving safety worker: %v", err)
+	}
+
+	return worker
+}
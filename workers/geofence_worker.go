@@ -2,17 +2,21 @@ package workers
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"ftrack/models"
 	"ftrack/repositories"
 	"ftrack/services"
 	"ftrack/utils"
 	"ftrack/websocket"
+	"math"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
@@ -58,6 +62,8 @@ type GeofenceWorker struct {
 	// Metrics
 	stats      GeofenceWorkerStats
 	statsMutex sync.RWMutex
+
+	registration *WorkerHandle
 }
 
 type GeofenceWorkerConfig struct {
@@ -69,6 +75,13 @@ type GeofenceWorkerConfig struct {
 	EnableNotifications      bool          `json:"enableNotifications"`
 	EnableWebSocketBroadcast bool          `json:"enableWebSocketBroadcast"`
 	BatchSize                int           `json:"batchSize"`
+	RetryAttempts            int           `json:"retryAttempts"`
+
+	// Places of different circles whose centres and radii are within the
+	// overlap distance (meters) are the same place, and a member crossing
+	// them in the dedupe window notifies each recipient once
+	OverlapDistance float64       `json:"overlapDistance"`
+	DedupeWindow    time.Duration `json:"dedupeWindow"`
 }
 
 type GeofenceJob struct {
@@ -79,6 +92,7 @@ type GeofenceJob struct {
 	Timestamp        time.Time              `json:"timestamp"`
 	Priority         int                    `json:"priority"`
 	Context          map[string]interface{} `json:"context"`
+	RetryCount       int                    `json:"retryCount"`
 }
 
 type GeofenceEvent struct {
@@ -90,6 +104,8 @@ type GeofenceEvent struct {
 	Location  models.Location `json:"location"`
 	Timestamp time.Time       `json:"timestamp"`
 	Distance  float64         `json:"distance"` // Distance from place center
+
+	RecordID primitive.ObjectID `json:"-"` // the event in the member's geofence event feed
 }
 
 type GeofenceWorkerStats struct {
@@ -126,6 +142,9 @@ func NewGeofenceWorker(
 		EnableNotifications:      true,
 		EnableWebSocketBroadcast: true,
 		BatchSize:                20,
+		RetryAttempts:            3,
+		OverlapDistance:          50,
+		DedupeWindow:             2 * time.Minute,
 	}
 
 	return &GeofenceWorker{
@@ -165,6 +184,11 @@ func (gw *GeofenceWorker) Start() error {
 
 	logrus.Infof("Starting Geofence Worker with %d workers", gw.workers)
 
+	gw.registration = Registry.Register("geofence", 1*time.Minute,
+		func() int { return len(gw.geofenceQueue) },
+		gw.requeueStuckJob,
+	)
+
 	// Start worker goroutines
 	for i := 0; i < gw.workers; i++ {
 		gw.wg.Add(1)
@@ -195,6 +219,7 @@ func (gw *GeofenceWorker) Stop() error {
 
 	gw.cancel()
 	gw.isRunning = false
+	Registry.Unregister("geofence")
 
 	close(gw.geofenceQueue)
 	gw.wg.Wait()
@@ -236,6 +261,7 @@ func (gw *GeofenceWorker) worker(workerID int) {
 
 	logrus.Infof("Geofence worker %d started", workerID)
 
+	var backoff databaseBackoff
 	for {
 		select {
 		case job, ok := <-gw.geofenceQueue:
@@ -244,6 +270,10 @@ func (gw *GeofenceWorker) worker(workerID int) {
 				return
 			}
 
+			if !backoff.wait(gw.ctx) {
+				logrus.Infof("Geofence worker %d stopping due to context cancellation", workerID)
+				return
+			}
 			gw.processGeofenceJob(job, workerID)
 
 		case <-gw.ctx.Done():
@@ -255,10 +285,14 @@ func (gw *GeofenceWorker) worker(workerID int) {
 
 func (gw *GeofenceWorker) processGeofenceJob(job GeofenceJob, workerID int) {
 	startTime := time.Now()
+	success := true
+
+	gw.registration.Begin(job.ID, job.RetryCount, job)
 
 	defer func() {
 		duration := time.Since(startTime)
 		gw.updateStats(duration)
+		gw.registration.Done(job.ID, job.RetryCount, success)
 	}()
 
 	ctx, cancel := context.WithTimeout(gw.ctx, gw.config.ProcessingTimeout)
@@ -270,6 +304,7 @@ func (gw *GeofenceWorker) processGeofenceJob(job GeofenceJob, workerID int) {
 	places, err := gw.getUserPlaces(ctx, job.UserID)
 	if err != nil {
 		logrus.Errorf("Failed to get places for user %s: %v", job.UserID, err)
+		success = false
 		return
 	}
 
@@ -287,8 +322,15 @@ func (gw *GeofenceWorker) processGeofenceJob(job GeofenceJob, workerID int) {
 	}
 
 	// Process each event
-	for _, event := range events {
-		gw.processGeofenceEvent(ctx, event)
+	for i := range events {
+		gw.recordEvent(ctx, &events[i])
+		gw.processGeofenceEvent(ctx, events[i])
+	}
+
+	// Send notifications if enabled, for all the events at once so the
+	// overlapping places of different circles notify each recipient once
+	if gw.config.EnableNotifications {
+		go gw.sendNotifications(ctx, events)
 	}
 
 	// Update location cache
@@ -297,6 +339,27 @@ func (gw *GeofenceWorker) processGeofenceJob(job GeofenceJob, workerID int) {
 	logrus.Debugf("Worker %d processed %d geofence events for user %s", workerID, len(events), job.UserID)
 }
 
+// requeueStuckJob puts a job that hung in processing back on the queue
+func (gw *GeofenceWorker) requeueStuckJob(item InFlightItem) {
+	job, ok := item.Payload.(GeofenceJob)
+	if !ok {
+		return
+	}
+
+	if job.RetryCount >= gw.config.RetryAttempts {
+		logrus.Errorf("Geofence job %s stuck after %d attempts, dropping", job.ID, job.RetryCount)
+		return
+	}
+
+	job.RetryCount++
+
+	select {
+	case gw.geofenceQueue <- job:
+	default:
+		logrus.Errorf("Failed to requeue stuck geofence job %s", job.ID)
+	}
+}
+
 func (gw *GeofenceWorker) detectGeofenceEvents(job GeofenceJob, places []models.Place) []GeofenceEvent {
 	var events []GeofenceEvent
 
@@ -382,11 +445,6 @@ func (gw *GeofenceWorker) processGeofenceEvent(ctx context.Context, event Geofen
 	// Handle place visit tracking
 	go gw.handlePlaceVisit(ctx, event)
 
-	// Send notifications if enabled
-	if gw.config.EnableNotifications {
-		go gw.sendNotifications(ctx, event)
-	}
-
 	// Broadcast WebSocket event if enabled
 	if gw.config.EnableWebSocketBroadcast {
 		go gw.broadcastEvent(ctx, event)
@@ -394,6 +452,74 @@ func (gw *GeofenceWorker) processGeofenceEvent(ctx context.Context, event Geofen
 
 	// Update place statistics
 	go gw.updatePlaceStats(ctx, event)
+
+	go gw.recordRuleTriggers(ctx, event)
+
+	go gw.updatePlaceDND(ctx, event)
+}
+
+// recordEvent stores the event in the member's geofence event feed
+func (gw *GeofenceWorker) recordEvent(ctx context.Context, event *GeofenceEvent) {
+	record := models.GeofenceEvent{
+		UserID:    event.UserID,
+		PlaceID:   event.PlaceID,
+		PlaceName: event.Place.Name,
+		EventType: event.EventType,
+		Location:  event.Location,
+		Timestamp: event.Timestamp,
+	}
+	if !event.Place.CircleID.IsZero() {
+		record.CircleID = event.Place.CircleID.Hex()
+	}
+
+	if err := gw.locationRepo.CreateGeofenceEvent(ctx, &record); err != nil {
+		logrus.Errorf("Failed to record geofence event of user %s at place %s: %v", event.UserID, event.PlaceID, err)
+		return
+	}
+	event.RecordID = record.ID
+}
+
+// updatePlaceDND turns on do not disturb when the user arrives at a place
+// one of their place_dnd rules matches, and off again when they leave it
+func (gw *GeofenceWorker) updatePlaceDND(ctx context.Context, event GeofenceEvent) {
+	if event.EventType == "exit" {
+		if err := gw.notificationService.EndPlaceDND(ctx, event.UserID, event.PlaceID); err != nil {
+			logrus.Errorf("Failed to end do not disturb of user %s at place %s: %v", event.UserID, event.PlaceID, err)
+		}
+		return
+	}
+
+	triggered, err := gw.placeService.RecordPlaceRuleTriggers(ctx, event.UserID, &event.Place, models.AutomationRulePlaceDND)
+	if err != nil {
+		logrus.Errorf("Failed to match do not disturb rules for place %s: %v", event.PlaceID, err)
+		return
+	}
+	if len(triggered) == 0 {
+		return
+	}
+
+	// The first rule to run sets the scope
+	if err := gw.notificationService.StartPlaceDND(ctx, event.UserID, &event.Place, &triggered[0]); err != nil {
+		logrus.Errorf("Failed to start do not disturb of user %s at place %s: %v", event.UserID, event.PlaceID, err)
+	}
+}
+
+// recordRuleTriggers records the user's arrival or departure rules the
+// event sets off, whether they name the place or one of its tags
+func (gw *GeofenceWorker) recordRuleTriggers(ctx context.Context, event GeofenceEvent) {
+	ruleType := "place_arrival"
+	if event.EventType == "exit" {
+		ruleType = "place_departure"
+	}
+
+	triggered, err := gw.placeService.RecordPlaceRuleTriggers(ctx, event.UserID, &event.Place, ruleType)
+	if err != nil {
+		logrus.Errorf("Failed to match automation rules for place %s: %v", event.PlaceID, err)
+		return
+	}
+	if len(triggered) > 0 {
+		logrus.Debugf("Place %s %s triggered %d automation rules of user %s", event.PlaceID, event.EventType, len(triggered), event.UserID)
+	}
 }
 
 func (gw *GeofenceWorker) handlePlaceVisit(ctx context.Context, event GeofenceEvent) {
@@ -409,7 +535,10 @@ func (gw *GeofenceWorker) handlePlaceVisit(ctx context.Context, event GeofenceEv
 		err := gw.placeRepo.CreateVisit(ctx, &visit)
 		if err != nil {
 			logrus.Errorf("Failed to create place visit: %v", err)
+			return
 		}
+
+		gw.placeService.NotifyOwnerOfVisit(ctx, &event.Place, &visit)
 	} else if event.EventType == "exit" {
 		// End place visit
 		visit, err := gw.placeRepo.GetActiveVisit(ctx, event.UserID, event.PlaceID)
@@ -435,35 +564,107 @@ func (gw *GeofenceWorker) handlePlaceVisit(ctx context.Context, event GeofenceEv
 	}
 }
 
-func (gw *GeofenceWorker) sendNotifications(ctx context.Context, event GeofenceEvent) {
+// placeCooldownElapsed reports whether the member may trigger another
+// notification of this kind at the place, and starts the place's cooldown
+// if so
+func (gw *GeofenceWorker) placeCooldownElapsed(ctx context.Context, event GeofenceEvent) bool {
+	cooldown := event.Place.Notifications.Cooldown
+	if cooldown <= 0 || gw.redis == nil {
+		return true
+	}
+
+	key := fmt.Sprintf("place_cooldown:%s:%s:%s", event.PlaceID, event.UserID, event.EventType)
+	started, err := gw.redis.SetNX(ctx, key, 1, time.Duration(cooldown)*time.Minute).Result()
+	if err != nil {
+		logrus.Warnf("Failed to check place notification cooldown: %v", err)
+		return true
+	}
+	return started
+}
+
+// placeNotification is a geofence event to notify members of. Recipients of
+// overlapping events of several circles are notified once, of the event
+// detected first, which names the circles of the events collapsed into it.
+type placeNotification struct {
+	event      GeofenceEvent
+	circleID   string
+	recipients []string
+	circles    map[string][]string // recipient -> circles of the events collapsed into this one
+	collapsed  []models.GeofenceCollapsedNotice
+}
+
+// circleIDsFor returns the circles the recipient's notification names
+func (pn *placeNotification) circleIDsFor(recipient string) []string {
+	var circleIDs []string
+	if pn.circleID != "" {
+		circleIDs = append(circleIDs, pn.circleID)
+	}
+	return append(circleIDs, pn.circles[recipient]...)
+}
+
+// sentPlaceNotification is a place notification a recipient got recently,
+// kept so the overlapping events of other circles detected later in the
+// dedupe window are collapsed into it
+type sentPlaceNotification struct {
+	EventID   string    `json:"eventId"`
+	CircleID  string    `json:"circleId"`
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	Radius    float64   `json:"radius"`
+	SentAt    time.Time `json:"sentAt"`
+}
+
+func (gw *GeofenceWorker) sendNotifications(ctx context.Context, events []GeofenceEvent) {
 	if gw.notificationService == nil {
 		return
 	}
 
+	var pending []*placeNotification
+	for _, event := range events {
+		if notification := gw.preparePlaceNotification(ctx, event); notification != nil {
+			pending = append(pending, notification)
+		}
+	}
+
+	gw.collapseOverlappingNotifications(ctx, pending)
+
+	for _, notification := range pending {
+		gw.dispatchPlaceNotification(ctx, notification)
+	}
+}
+
+// preparePlaceNotification returns who to notify of the event, or nil if
+// the place doesn't notify of it
+func (gw *GeofenceWorker) preparePlaceNotification(ctx context.Context, event GeofenceEvent) *placeNotification {
+	// Settings the place inherits come from its circle's defaults
+	var circle *models.Circle
+	var defaults *models.GeofenceDefaults
+	if !event.Place.CircleID.IsZero() {
+		var err error
+		circle, err = gw.circleRepo.GetByID(ctx, event.Place.CircleID.Hex())
+		if err != nil {
+			logrus.Warnf("Failed to get circle %s of place %s: %v", event.Place.CircleID.Hex(), event.PlaceID, err)
+			circle = nil
+		} else {
+			defaults = circle.GeofenceDefaults
+		}
+	}
+	event.Place = services.ApplyPlaceSettings(event.Place, defaults)
+
 	// Check if notifications are enabled for this place and event type
 	shouldNotify := (event.EventType == "entry" && event.Place.Notifications.OnArrival) ||
 		(event.EventType == "exit" && event.Place.Notifications.OnDeparture)
 
-	if !shouldNotify {
-		return
-	}
-
-	// Get user info
-	user, err := gw.userRepo.GetByID(ctx, event.UserID)
-	if err != nil {
-		logrus.Errorf("Failed to get user for notification: %v", err)
-		return
+	if !shouldNotify || !gw.placeCooldownElapsed(ctx, event) {
+		return nil
 	}
 
 	// Get circle members to notify
 	var notifyUsers []string
-	if event.Place.IsShared && !event.Place.CircleID.IsZero() {
-		circle, err := gw.circleRepo.GetByID(ctx, event.Place.CircleID.Hex())
-		if err == nil {
-			for _, member := range circle.Members {
-				if member.UserID.Hex() != event.UserID && member.Status == "active" {
-					notifyUsers = append(notifyUsers, member.UserID.Hex())
-				}
+	if event.Place.IsShared && circle != nil {
+		for _, member := range circle.Members {
+			if member.UserID.Hex() != event.UserID && member.Status == "active" {
+				notifyUsers = append(notifyUsers, member.UserID.Hex())
 			}
 		}
 	}
@@ -476,46 +677,334 @@ func (gw *GeofenceWorker) sendNotifications(ctx context.Context, event GeofenceE
 	}
 
 	if len(notifyUsers) == 0 {
+		return nil
+	}
+
+	notification := &placeNotification{
+		event:      event,
+		recipients: notifyUsers,
+		circles:    make(map[string][]string),
+	}
+	if !event.Place.CircleID.IsZero() {
+		notification.circleID = event.Place.CircleID.Hex()
+	}
+	return notification
+}
+
+// collapseOverlappingNotifications takes each recipient off all but the
+// first of the notifications of overlapping places of different circles,
+// whether that one is in this batch or was sent in the dedupe window
+func (gw *GeofenceWorker) collapseOverlappingNotifications(ctx context.Context, pending []*placeNotification) {
+	for i, notification := range pending {
+		kept := make([]string, 0, len(notification.recipients))
+		for _, recipient := range notification.recipients {
+			if into := gw.overlappingNotification(pending[:i], notification, recipient); into != nil {
+				if notification.circleID != "" {
+					into.circles[recipient] = append(into.circles[recipient], notification.circleID)
+				}
+				notification.collapsed = append(notification.collapsed, models.GeofenceCollapsedNotice{
+					UserID:     recipient,
+					IntoEvent:  into.event.RecordID.Hex(),
+					IntoCircle: into.circleID,
+				})
+				continue
+			}
+
+			if sent := gw.recentPlaceNotification(ctx, notification, recipient); sent != nil {
+				notification.collapsed = append(notification.collapsed, models.GeofenceCollapsedNotice{
+					UserID:     recipient,
+					IntoEvent:  sent.EventID,
+					IntoCircle: sent.CircleID,
+				})
+				continue
+			}
+
+			kept = append(kept, recipient)
+		}
+		notification.recipients = kept
+	}
+}
+
+// overlappingNotification returns the earlier notification the recipient
+// gets of the same crossing of an overlapping place of another circle
+func (gw *GeofenceWorker) overlappingNotification(earlier []*placeNotification, notification *placeNotification, recipient string) *placeNotification {
+	place := notification.event.Place
+	for _, candidate := range earlier {
+		if candidate.event.EventType != notification.event.EventType || candidate.circleID == notification.circleID {
+			continue
+		}
+		other := candidate.event.Place
+		if !gw.sameGeometry(place.Latitude, place.Longitude, gw.placeRadius(place), other.Latitude, other.Longitude, gw.placeRadius(other)) {
+			continue
+		}
+		for _, candidateRecipient := range candidate.recipients {
+			if candidateRecipient == recipient {
+				return candidate
+			}
+		}
+	}
+	return nil
+}
+
+// recentPlaceNotification returns the notification the recipient got in
+// the dedupe window of the same crossing of an overlapping place of
+// another circle, or nil
+func (gw *GeofenceWorker) recentPlaceNotification(ctx context.Context, notification *placeNotification, recipient string) *sentPlaceNotification {
+	if gw.redis == nil || gw.config.DedupeWindow <= 0 {
+		return nil
+	}
+
+	key := placeNotificationDedupeKey(notification.event, recipient)
+	entries, err := gw.redis.HGetAll(ctx, key).Result()
+	if err != nil {
+		logrus.Warnf("Failed to get recent place notifications of user %s: %v", recipient, err)
+		return nil
+	}
+
+	place := notification.event.Place
+	for _, entry := range entries {
+		var sent sentPlaceNotification
+		if err := json.Unmarshal([]byte(entry), &sent); err != nil {
+			continue
+		}
+		if sent.CircleID == notification.circleID || time.Since(sent.SentAt) > gw.config.DedupeWindow {
+			continue
+		}
+		if gw.sameGeometry(place.Latitude, place.Longitude, gw.placeRadius(place), sent.Latitude, sent.Longitude, sent.Radius) {
+			return &sent
+		}
+	}
+	return nil
+}
+
+// rememberPlaceNotification keeps the notifications sent of the event for
+// the dedupe window
+func (gw *GeofenceWorker) rememberPlaceNotification(ctx context.Context, notification *placeNotification, recipients []string) {
+	if gw.redis == nil || gw.config.DedupeWindow <= 0 || notification.event.RecordID.IsZero() {
 		return
 	}
 
-	// Create notification
-	var title, body string
-	if event.EventType == "entry" {
-		title = "📍 Arrival Notification"
-		body = fmt.Sprintf("%s %s has arrived at %s", user.FirstName, user.LastName, event.Place.Name)
-	} else {
-		title = "📍 Departure Notification"
-		body = fmt.Sprintf("%s %s has left %s", user.FirstName, user.LastName, event.Place.Name)
-	}
-
-	notificationReq := models.SendNotificationRequest{
-		UserIDs:  notifyUsers,
-		Type:     models.NotificationLocationArrival,
-		Title:    title,
-		Body:     body,
-		Priority: "normal",
-		Data: map[string]interface{}{
-			"type":      "place_event",
-			"userId":    event.UserID,
-			"placeId":   event.PlaceID,
-			"placeName": event.Place.Name,
-			"eventType": event.EventType,
-			"latitude":  event.Location.Latitude,
-			"longitude": event.Location.Longitude,
-		},
-		Channels: models.NotificationChannels{
-			Push:  true,
-			InApp: true,
-		},
+	place := notification.event.Place
+	sent, err := json.Marshal(sentPlaceNotification{
+		EventID:   notification.event.RecordID.Hex(),
+		CircleID:  notification.circleID,
+		Latitude:  place.Latitude,
+		Longitude: place.Longitude,
+		Radius:    gw.placeRadius(place),
+		SentAt:    time.Now(),
+	})
+	if err != nil {
+		return
+	}
+
+	pipe := gw.redis.Pipeline()
+	for _, recipient := range recipients {
+		key := placeNotificationDedupeKey(notification.event, recipient)
+		pipe.HSet(ctx, key, notification.event.PlaceID, sent)
+		pipe.Expire(ctx, key, gw.config.DedupeWindow)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		logrus.Warnf("Failed to remember place notifications of event %s: %v", notification.event.RecordID.Hex(), err)
+	}
+}
+
+func placeNotificationDedupeKey(event GeofenceEvent, recipient string) string {
+	return fmt.Sprintf("place_notified:%s:%s:%s", event.UserID, recipient, event.EventType)
+}
+
+// sameGeometry reports whether two geofences are the same place, their
+// centres and radii no further apart than the overlap distance
+func (gw *GeofenceWorker) sameGeometry(latA, lngA, radiusA, latB, lngB, radiusB float64) bool {
+	return utils.CalculateDistance(latA, lngA, latB, lngB) <= gw.config.OverlapDistance &&
+		math.Abs(radiusA-radiusB) <= gw.config.OverlapDistance
+}
+
+func (gw *GeofenceWorker) placeRadius(place models.Place) float64 {
+	if place.Radius == 0 {
+		return gw.config.GeofenceRadius
+	}
+	return float64(place.Radius)
+}
+
+// dispatchPlaceNotification sends the notification to the recipients left
+// on it and records on the event who was notified and who was collapsed
+// into another event
+func (gw *GeofenceWorker) dispatchPlaceNotification(ctx context.Context, notification *placeNotification) {
+	event := notification.event
+	var notified []string
+
+	if len(notification.recipients) > 0 {
+		notified = gw.sendPlaceNotification(ctx, notification)
+		gw.rememberPlaceNotification(ctx, notification, notified)
+	}
+
+	if event.RecordID.IsZero() {
+		return
+	}
+
+	circleIDs := []string{}
+	seen := make(map[string]bool)
+	for _, recipient := range notified {
+		for _, circleID := range notification.circleIDsFor(recipient) {
+			if !seen[circleID] {
+				seen[circleID] = true
+				circleIDs = append(circleIDs, circleID)
+			}
+		}
+	}
+	if notified == nil {
+		notified = []string{}
+	}
+
+	record := models.GeofenceEventNotification{
+		Notified:  notified,
+		CircleIDs: circleIDs,
+		Collapsed: notification.collapsed,
+		SentAt:    time.Now(),
+	}
+	if err := gw.locationRepo.SetGeofenceEventNotification(ctx, event.RecordID, record); err != nil {
+		logrus.Errorf("Failed to record notifications of geofence event %s: %v", event.RecordID.Hex(), err)
+	}
+}
+
+// sendPlaceNotification sends the notification and returns who it was sent
+// to. Recipients of collapsed events get the circles of all of them.
+func (gw *GeofenceWorker) sendPlaceNotification(ctx context.Context, notification *placeNotification) []string {
+	event := notification.event
+
+	// Get user info
+	user, err := gw.userRepo.GetByID(ctx, event.UserID)
+	if err != nil {
+		logrus.Errorf("Failed to get user for notification: %v", err)
+		return nil
+	}
+
+	// Create notification from the place's templates
+	placeEvent := "arrival"
+	if event.EventType == "exit" {
+		placeEvent = "departure"
 	}
+	memberName := strings.TrimSpace(user.FirstName + " " + user.LastName)
+
+	// Recipients of the same circles get the same payload
+	var groups [][]string
+	byCircles := make(map[string]int)
+	for _, recipient := range notification.recipients {
+		key := strings.Join(notification.circleIDsFor(recipient), ",")
+		if i, ok := byCircles[key]; ok {
+			groups[i] = append(groups[i], recipient)
+			continue
+		}
+		byCircles[key] = len(groups)
+		groups = append(groups, []string{recipient})
+	}
+
+	var notified []string
+	for _, group := range groups {
+		circleIDs := notification.circleIDsFor(group[0])
+
+		for _, text := range gw.placeNotificationTexts(ctx, event, placeEvent, memberName, group) {
+			notificationReq := models.SendNotificationRequest{
+				UserIDs:  text.recipients,
+				Type:     models.NotificationLocationArrival,
+				Title:    text.title,
+				Body:     text.body,
+				Priority: "normal",
+				Data: map[string]interface{}{
+					"type":      "place_event",
+					"userId":    event.UserID,
+					"placeId":   event.PlaceID,
+					"placeName": event.Place.Name,
+					"eventType": event.EventType,
+					"eventId":   event.RecordID.Hex(),
+					"circleIds": circleIDs,
+					"latitude":  event.Location.Latitude,
+					"longitude": event.Location.Longitude,
+				},
+				Channels: models.NotificationChannels{
+					Push:  true,
+					InApp: true,
+				},
+				Attachment: &models.NotificationAttachment{
+					Kind:       models.AttachmentPlaceMap,
+					ResourceID: event.PlaceID,
+				},
+				SubjectUserID: event.UserID,
+
+				ExperimentID:      text.experimentID,
+				ExperimentVariant: text.variant,
+			}
 
-	err = gw.notificationService.SendNotification(ctx, notificationReq)
+			err = gw.notificationService.SendNotification(ctx, notificationReq)
+			if err != nil {
+				logrus.Errorf("Failed to send geofence notification: %v", err)
+			} else {
+				gw.incrementNotificationsSent()
+				notified = append(notified, text.recipients...)
+			}
+		}
+	}
+	return notified
+}
+
+// placeNotificationText is the text of a place notification and who gets
+// it, and the experiment variant it came from, if any
+type placeNotificationText struct {
+	title, body           string
+	recipients            []string
+	experimentID, variant string
+}
+
+// placeNotificationTexts renders the notification for each recipient,
+// naming the place by their own alias for it and the member's home as
+// home, in the system template's text for them unless the place has its
+// own, and groups the recipients that get the same text
+func (gw *GeofenceWorker) placeNotificationTexts(ctx context.Context, event GeofenceEvent, placeEvent, memberName string, recipients []string) []placeNotificationText {
+	memberHome := false
+	homeID, err := gw.placeRepo.GetHomePlaceID(ctx, utils.ObjectIDFromHex(event.UserID))
 	if err != nil {
-		logrus.Errorf("Failed to send geofence notification: %v", err)
+		logrus.Warnf("Failed to get home place of user %s: %v", event.UserID, err)
 	} else {
-		gw.incrementNotificationsSent()
+		memberHome = !homeID.IsZero() && homeID == event.Place.ID
 	}
+
+	recipientIDs := make([]primitive.ObjectID, 0, len(recipients))
+	for _, recipient := range recipients {
+		recipientIDs = append(recipientIDs, utils.ObjectIDFromHex(recipient))
+	}
+	aliases, err := gw.placeRepo.GetPlaceAliasesOf(ctx, event.Place.ID, recipientIDs)
+	if err != nil {
+		logrus.Warnf("Failed to get aliases of place %s: %v", event.PlaceID, err)
+	}
+
+	var systemTemplate *services.SystemTemplate
+	if !event.Place.Notifications.HasTemplateFor(placeEvent) {
+		systemTemplate = gw.notificationService.SystemTemplate(ctx, models.SystemTemplateKey(placeEvent, memberHome))
+	}
+
+	var texts []placeNotificationText
+	byText := make(map[string]int)
+	for _, recipient := range recipients {
+		alias := aliases[utils.ObjectIDFromHex(recipient)].Alias
+		template, variant := "", ""
+		if systemTemplate != nil {
+			template, variant = systemTemplate.For(recipient)
+		}
+		title, body := services.RenderMemberPlaceNotification(&event.Place, placeEvent, memberName, event.Timestamp, alias, memberHome, template)
+
+		key := title + "\n" + body + "\n" + variant
+		if i, ok := byText[key]; ok {
+			texts[i].recipients = append(texts[i].recipients, recipient)
+			continue
+		}
+		byText[key] = len(texts)
+		text := placeNotificationText{title: title, body: body, recipients: []string{recipient}, variant: variant}
+		if variant != "" {
+			text.experimentID = systemTemplate.Experiment.ID.Hex()
+		}
+		texts = append(texts, text)
+	}
+	return texts
 }
 
 func (gw *GeofenceWorker) broadcastEvent(ctx context.Context, event GeofenceEvent) {
@@ -643,6 +1132,7 @@ func (gw *GeofenceWorker) metricsCollector() {
 		select {
 		case <-ticker.C:
 			gw.collectMetrics()
+			gw.registration.Heartbeat()
 
 		case <-gw.ctx.Done():
 			return
@@ -718,9 +1208,10 @@ func StartGeofenceWorker(db *mongo.Database, redis *redis.Client, hub *websocket
 	userRepo := repositories.NewUserRepository(db)
 	notificationRepo := repositories.NewNotificationRepository(db)
 
-	circleService := services.NewCircleService(circleRepo, userRepo)
-	placeService := services.NewPlaceService(placeRepo, circleRepo)
+	circleService := services.NewCircleService(circleRepo, userRepo, repositories.NewAuditLogRepository(db), repositories.NewBlockRepository(db), nil)
+	placeService := services.NewPlaceService(placeRepo, circleRepo, nil)
 	geofenceService := services.NewGeofenceService(placeRepo, locationRepo, hub)
+	geofenceService.ConfigureCircleDefaults(circleRepo)
 
 	// Initialize push service for notifications
 	pushService := services.NewPushService(nil, notificationRepo)
@@ -730,6 +1221,7 @@ func StartGeofenceWorker(db *mongo.Database, redis *redis.Client, hub *websocket
 		notificationRepo,
 		userRepo,
 		circleRepo,
+		repositories.NewBlockRepository(db),
 		redis,
 		hub,
 		nil, // EmailService
@@ -737,6 +1229,8 @@ func StartGeofenceWorker(db *mongo.Database, redis *redis.Client, hub *websocket
 		pushService,
 	)
 
+	placeService.ConfigureCheckinNotifications(notificationService)
+
 	worker := NewGeofenceWorker(db, redis, hub, geofenceService, placeService, circleService, notificationService)
 
 	if err := worker.Start(); err != nil {
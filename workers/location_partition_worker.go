@@ -0,0 +1,181 @@
+package workers
+
+import (
+	"context"
+	"ftrack/repositories"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const locationPartitionBackfillLockKey = "location_partitions:backfill:lock"
+
+// LocationPartitionWorker moves location history from the original single
+// collection into the monthly partitions, a batch at a time, while reads
+// cover both. It stops once the original collection is empty.
+type LocationPartitionWorker struct {
+	// Dependencies
+	db    *mongo.Database
+	redis *redis.Client
+
+	// Repositories
+	locationRepo *repositories.LocationRepository
+
+	// Worker configuration
+	config LocationPartitionWorkerConfig
+
+	// Worker state
+	isRunning bool
+	mutex     sync.RWMutex
+
+	// Context for shutdown
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// Metrics
+	stats      LocationPartitionWorkerStats
+	statsMutex sync.RWMutex
+}
+
+type LocationPartitionWorkerConfig struct {
+	BatchInterval time.Duration `json:"batchInterval"`
+	BatchSize     int           `json:"batchSize"`
+}
+
+type LocationPartitionWorkerStats struct {
+	LocationsMoved int64      `json:"locationsMoved"`
+	BatchErrors    int64      `json:"batchErrors"`
+	LastBatchAt    time.Time  `json:"lastBatchAt"`
+	CompletedAt    *time.Time `json:"completedAt,omitempty"`
+	StartTime      time.Time  `json:"startTime"`
+}
+
+func NewLocationPartitionWorker(db *mongo.Database, redis *redis.Client) *LocationPartitionWorker {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	config := LocationPartitionWorkerConfig{
+		BatchInterval: 5 * time.Second,
+		BatchSize:     1000,
+	}
+
+	return &LocationPartitionWorker{
+		db:           db,
+		redis:        redis,
+		locationRepo: repositories.NewLocationRepository(db),
+		config:       config,
+		ctx:          ctx,
+		cancel:       cancel,
+		stats: LocationPartitionWorkerStats{
+			StartTime: time.Now(),
+		},
+	}
+}
+
+func (lw *LocationPartitionWorker) Start() error {
+	lw.mutex.Lock()
+	defer lw.mutex.Unlock()
+
+	if lw.isRunning {
+		return nil
+	}
+
+	lw.isRunning = true
+
+	logrus.Info("Starting Location Partition Worker...")
+
+	lw.wg.Add(1)
+	go lw.backfillScheduler()
+
+	logrus.Info("Location Partition Worker started successfully")
+	return nil
+}
+
+func (lw *LocationPartitionWorker) Stop() error {
+	lw.mutex.Lock()
+	defer lw.mutex.Unlock()
+
+	if !lw.isRunning {
+		return nil
+	}
+
+	logrus.Info("Stopping Location Partition Worker...")
+
+	lw.cancel()
+	lw.isRunning = false
+	lw.wg.Wait()
+
+	logrus.Info("Location Partition Worker stopped successfully")
+	return nil
+}
+
+func (lw *LocationPartitionWorker) backfillScheduler() {
+	defer lw.wg.Done()
+
+	ticker := time.NewTicker(lw.config.BatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if lw.runBatch() {
+				return
+			}
+
+		case <-lw.ctx.Done():
+			return
+		}
+	}
+}
+
+// runBatch moves one batch and reports whether the backfill is done
+func (lw *LocationPartitionWorker) runBatch() bool {
+	// Only one instance moves locations at a time
+	if lw.redis != nil {
+		acquired, err := lw.redis.SetNX(lw.ctx, locationPartitionBackfillLockKey, "1", time.Minute).Result()
+		if err != nil || !acquired {
+			return false
+		}
+		defer lw.redis.Del(context.Background(), locationPartitionBackfillLockKey)
+	}
+
+	moved, done, err := lw.locationRepo.BackfillLocationPartitions(lw.ctx, lw.config.BatchSize)
+
+	lw.statsMutex.Lock()
+	defer lw.statsMutex.Unlock()
+
+	lw.stats.LocationsMoved += int64(moved)
+	lw.stats.LastBatchAt = time.Now()
+
+	if err != nil {
+		lw.stats.BatchErrors++
+		logrus.Errorf("Location partition backfill failed: %v", err)
+		return false
+	}
+	if done {
+		now := time.Now()
+		lw.stats.CompletedAt = &now
+		logrus.Infof("Location partition backfill complete, %d locations moved by this instance", lw.stats.LocationsMoved)
+	}
+	return done
+}
+
+func (lw *LocationPartitionWorker) GetStats() LocationPartitionWorkerStats {
+	lw.statsMutex.RLock()
+	defer lw.statsMutex.RUnlock()
+	return lw.stats
+}
+
+// Public function to start location partition worker
+func StartLocationPartitionWorker(db *mongo.Database, redis *redis.Client) *LocationPartitionWorker {
+	worker := NewLocationPartitionWorker(db, redis)
+
+	if err := worker.Start(); err != nil {
+		logrus.Fatalf("Failed to start location partition worker: %v", err)
+	}
+
+	return worker
+}
@@ -26,6 +26,7 @@ type LocationWorker struct {
 	geofenceService *services.GeofenceService
 	circleService   *services.CircleService
 	userService     *services.UserService
+	nearbyAlerts    *services.NearbyAlertService
 
 	// Repositories
 	locationRepo *repositories.LocationRepository
@@ -51,6 +52,8 @@ type LocationWorker struct {
 	// Metrics
 	stats      LocationWorkerStats
 	statsMutex sync.RWMutex
+
+	registration *WorkerHandle
 }
 
 type LocationWorkerConfig struct {
@@ -133,6 +136,12 @@ func NewLocationWorker(
 	}
 }
 
+// ConfigureNearbyAlerts has each processed location checked for opted-in
+// circle mates close by
+func (lw *LocationWorker) ConfigureNearbyAlerts(nearbyAlerts *services.NearbyAlertService) {
+	lw.nearbyAlerts = nearbyAlerts
+}
+
 func (lw *LocationWorker) Start() error {
 	lw.mutex.Lock()
 	defer lw.mutex.Unlock()
@@ -146,6 +155,11 @@ func (lw *LocationWorker) Start() error {
 
 	logrus.Infof("Starting Location Worker with %d workers", lw.workers)
 
+	lw.registration = Registry.Register("location", 1*time.Minute,
+		func() int { return len(lw.locationQueue) },
+		lw.requeueStuckJob,
+	)
+
 	// Start worker goroutines
 	for i := 0; i < lw.workers; i++ {
 		lw.wg.Add(1)
@@ -182,6 +196,7 @@ func (lw *LocationWorker) Stop() error {
 
 	lw.cancel()
 	lw.isRunning = false
+	Registry.Unregister("location")
 
 	// Close channels
 	close(lw.locationQueue)
@@ -228,6 +243,7 @@ func (lw *LocationWorker) worker(workerID int) {
 
 	logrus.Infof("Location worker %d started", workerID)
 
+	var backoff databaseBackoff
 	for {
 		select {
 		case job, ok := <-lw.locationQueue:
@@ -236,6 +252,10 @@ func (lw *LocationWorker) worker(workerID int) {
 				return
 			}
 
+			if !backoff.wait(lw.ctx) {
+				logrus.Infof("Location worker %d stopping due to context cancellation", workerID)
+				return
+			}
 			lw.processLocation(job, workerID)
 
 		case <-lw.ctx.Done():
@@ -247,10 +267,14 @@ func (lw *LocationWorker) worker(workerID int) {
 
 func (lw *LocationWorker) processLocation(job LocationJob, workerID int) {
 	startTime := time.Now()
+	success := false
+
+	lw.registration.Begin(job.ID, job.RetryCount, job)
 
 	defer func() {
 		duration := time.Since(startTime)
 		lw.updateStats(duration, true)
+		lw.registration.Done(job.ID, job.RetryCount, success)
 	}()
 
 	ctx, cancel := context.WithTimeout(lw.ctx, lw.config.ProcessingTimeout)
@@ -276,12 +300,28 @@ func (lw *LocationWorker) processLocation(job LocationJob, workerID int) {
 		go lw.broadcastLocationUpdate(ctx, job)
 	}
 
+	if lw.nearbyAlerts != nil {
+		go lw.processNearbyAlerts(job)
+	}
+
 	// Update user's last seen
 	go lw.updateUserLastSeen(ctx, job.UserID)
 
+	success = true
 	logrus.Debugf("Worker %d completed location processing for user %s", workerID, job.UserID)
 }
 
+// processNearbyAlerts runs on its own context, as the job's is cancelled
+// once the location is stored
+func (lw *LocationWorker) processNearbyAlerts(job LocationJob) {
+	ctx, cancel := context.WithTimeout(lw.ctx, lw.config.ProcessingTimeout)
+	defer cancel()
+
+	if err := lw.nearbyAlerts.CheckNearby(ctx, job.UserID, job.Location); err != nil {
+		logrus.Errorf("Failed to check nearby circle members for user %s: %v", job.UserID, err)
+	}
+}
+
 func (lw *LocationWorker) processGeofencing(ctx context.Context, job LocationJob) {
 	if lw.geofenceService == nil {
 		return
@@ -371,6 +411,29 @@ func (lw *LocationWorker) retryJob(job LocationJob) {
 	}()
 }
 
+// requeueStuckJob puts a job that hung in processing back on the queue
+func (lw *LocationWorker) requeueStuckJob(item InFlightItem) {
+	job, ok := item.Payload.(LocationJob)
+	if !ok {
+		return
+	}
+
+	if job.RetryCount >= lw.config.RetryAttempts {
+		logrus.Errorf("Job %s stuck after %d attempts, dropping", job.ID, job.RetryCount)
+		lw.incrementFailedJobs()
+		return
+	}
+
+	job.RetryCount++
+	lw.incrementRetriedJobs()
+
+	select {
+	case lw.locationQueue <- job:
+	default:
+		logrus.Errorf("Failed to requeue stuck job %s", job.ID)
+	}
+}
+
 func (lw *LocationWorker) batchProcessor() {
 	defer lw.wg.Done()
 
@@ -429,6 +492,7 @@ func (lw *LocationWorker) metricsCollector() {
 		select {
 		case <-ticker.C:
 			lw.collectMetrics()
+			lw.registration.Heartbeat()
 
 		case <-lw.ctx.Done():
 			return
@@ -520,13 +584,29 @@ func StartLocationWorker(db *mongo.Database, redis *redis.Client, hub *websocket
 	circleRepo := repositories.NewCircleRepository(db)
 	placeRepo := repositories.NewPlaceRepository(db)
 	userRepo := repositories.NewUserRepository(db)
+	blockRepo := repositories.NewBlockRepository(db)
 
-	circleService := services.NewCircleService(circleRepo, userRepo)
-	userService := services.NewUserService(userRepo)
+	circleService := services.NewCircleService(circleRepo, userRepo, repositories.NewAuditLogRepository(db), blockRepo, nil)
+	userService := services.NewUserService(userRepo, repositories.NewEmergencyRepository(db), repositories.NewAuditLogRepository(db), blockRepo, nil, nil, "")
 	geofenceService := services.NewGeofenceService(placeRepo, locationRepo, hub)
-	locationService := services.NewLocationService(locationRepo, circleRepo, placeRepo, userRepo, geofenceService, hub)
+	geofenceService.ConfigureCircleDefaults(circleRepo)
+	locationService := services.NewLocationService(locationRepo, circleRepo, placeRepo, userRepo, blockRepo, geofenceService, hub)
+
+	notificationRepo := repositories.NewNotificationRepository(db)
+	notificationService := services.NewNotificationService(
+		notificationRepo,
+		userRepo,
+		circleRepo,
+		blockRepo,
+		redis,
+		hub,
+		nil, // EmailService
+		nil, // SMSService
+		services.NewPushService(nil, notificationRepo),
+	)
 
 	worker := NewLocationWorker(db, redis, hub, locationService, geofenceService, circleService, userService)
+	worker.ConfigureNearbyAlerts(services.NewNearbyAlertService(circleRepo, locationRepo, placeRepo, userRepo, notificationService, redis))
 
 	if err := worker.Start(); err != nil {
 		logrus.Fatalf("Failed to start location worker: %v", err)
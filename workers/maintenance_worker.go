@@ -0,0 +1,229 @@
+package workers
+
+import (
+	"context"
+	"ftrack/models"
+	"ftrack/repositories"
+	"ftrack/services"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type MaintenanceWorker struct {
+	// Dependencies
+	db    *mongo.Database
+	redis *redis.Client
+
+	// Services
+	maintenanceService *services.MaintenanceService
+
+	// Worker configuration
+	config MaintenanceWorkerConfig
+
+	// Worker state
+	isRunning bool
+	mutex     sync.RWMutex
+
+	// Context for shutdown
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// Metrics
+	stats      MaintenanceWorkerStats
+	statsMutex sync.RWMutex
+}
+
+type MaintenanceWorkerConfig struct {
+	ReconcileInterval time.Duration `json:"reconcileInterval"`
+	MetricsInterval   time.Duration `json:"metricsInterval"`
+
+	// Scheduled runs only report drift when dry run is set
+	DryRun    bool `json:"dryRun"`
+	BatchSize int  `json:"batchSize"`
+
+	EnableReconcile bool `json:"enableReconcile"`
+}
+
+type MaintenanceWorkerStats struct {
+	RunsCompleted   int64     `json:"runsCompleted"`
+	RunsFailed      int64     `json:"runsFailed"`
+	RunsSkipped     int64     `json:"runsSkipped"` // another run held the lock
+	LastRunID       string    `json:"lastRunId"`
+	LastReconcileAt time.Time `json:"lastReconcileAt"`
+	StartTime       time.Time `json:"startTime"`
+}
+
+func NewMaintenanceWorker(db *mongo.Database, redis *redis.Client) *MaintenanceWorker {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	config := MaintenanceWorkerConfig{
+		ReconcileInterval: 24 * time.Hour, // Daily
+		MetricsInterval:   15 * time.Minute,
+		DryRun:            false,
+		BatchSize:         200,
+		EnableReconcile:   true,
+	}
+
+	return &MaintenanceWorker{
+		db:    db,
+		redis: redis,
+		maintenanceService: services.NewMaintenanceService(
+			repositories.NewMaintenanceRepository(db),
+			repositories.NewNotificationRepository(db),
+			redis,
+		),
+		config: config,
+		ctx:    ctx,
+		cancel: cancel,
+		stats: MaintenanceWorkerStats{
+			StartTime: time.Now(),
+		},
+	}
+}
+
+func (mw *MaintenanceWorker) Start() error {
+	mw.mutex.Lock()
+	defer mw.mutex.Unlock()
+
+	if mw.isRunning {
+		return nil
+	}
+
+	mw.isRunning = true
+
+	logrus.Info("Starting Maintenance Worker...")
+
+	if mw.config.EnableReconcile {
+		mw.wg.Add(1)
+		go mw.reconcileScheduler()
+	}
+
+	mw.wg.Add(1)
+	go mw.metricsCollector()
+
+	logrus.Info("Maintenance Worker started successfully")
+	return nil
+}
+
+func (mw *MaintenanceWorker) Stop() error {
+	mw.mutex.Lock()
+	defer mw.mutex.Unlock()
+
+	if !mw.isRunning {
+		return nil
+	}
+
+	logrus.Info("Stopping Maintenance Worker...")
+
+	mw.cancel()
+	mw.isRunning = false
+	mw.wg.Wait()
+
+	logrus.Info("Maintenance Worker stopped successfully")
+	return nil
+}
+
+func (mw *MaintenanceWorker) reconcileScheduler() {
+	defer mw.wg.Done()
+
+	ticker := time.NewTicker(mw.config.ReconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			mw.runReconcile()
+
+		case <-mw.ctx.Done():
+			return
+		}
+	}
+}
+
+func (mw *MaintenanceWorker) runReconcile() {
+	run, err := mw.maintenanceService.Reconcile(mw.ctx, "schedule", "", models.ReconcileRequest{
+		DryRun:    mw.config.DryRun,
+		BatchSize: mw.config.BatchSize,
+	})
+
+	mw.statsMutex.Lock()
+	defer mw.statsMutex.Unlock()
+
+	if err != nil {
+		if err.Error() == "reconcile already running" {
+			mw.stats.RunsSkipped++
+			logrus.Info("Skipping scheduled reconcile, another run is in progress")
+			return
+		}
+		mw.stats.RunsFailed++
+		logrus.Errorf("Scheduled reconcile failed to start: %v", err)
+		return
+	}
+
+	if run.Status == models.ReconcileStatusFailed {
+		mw.stats.RunsFailed++
+	} else {
+		mw.stats.RunsCompleted++
+	}
+	mw.stats.LastRunID = run.ID.Hex()
+	mw.stats.LastReconcileAt = time.Now()
+}
+
+func (mw *MaintenanceWorker) metricsCollector() {
+	defer mw.wg.Done()
+
+	ticker := time.NewTicker(mw.config.MetricsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			mw.collectMetrics()
+
+		case <-mw.ctx.Done():
+			return
+		}
+	}
+}
+
+func (mw *MaintenanceWorker) collectMetrics() {
+	mw.statsMutex.RLock()
+	stats := mw.stats
+	mw.statsMutex.RUnlock()
+
+	logrus.Infof("Maintenance Worker Stats - Runs: %d completed, %d failed, %d skipped",
+		stats.RunsCompleted, stats.RunsFailed, stats.RunsSkipped)
+
+	for class, drift := range mw.maintenanceService.GetDriftStats() {
+		logrus.WithFields(logrus.Fields{
+			"counterClass":  class,
+			"runs":          drift.Runs,
+			"discrepancies": drift.Discrepancies,
+			"repaired":      drift.Repaired,
+			"lastDrift":     drift.LastDrift,
+			"maxDrift":      drift.MaxDrift,
+		}).Info("Counter drift totals")
+	}
+}
+
+func (mw *MaintenanceWorker) GetStats() MaintenanceWorkerStats {
+	mw.statsMutex.RLock()
+	defer mw.statsMutex.RUnlock()
+	return mw.stats
+}
+
+// Public function to start maintenance worker
+func StartMaintenanceWorker(db *mongo.Database, redis *redis.Client) *MaintenanceWorker {
+	worker := NewMaintenanceWorker(db, redis)
+
+	if err := worker.Start(); err != nil {
+		logrus.Fatalf("Failed to start maintenance worker: %v", err)
+	}
+
+	return worker
+}
@@ -2,6 +2,7 @@ package workers
 
 import (
 	"context"
+	"ftrack/database"
 	"ftrack/models"
 	"ftrack/repositories"
 	"ftrack/services"
@@ -46,6 +47,8 @@ type NotificationWorker struct {
 	// Metrics
 	stats      NotificationWorkerStats
 	statsMutex sync.RWMutex
+
+	registration *WorkerHandle
 }
 
 type NotificationWorkerConfig struct {
@@ -129,6 +132,11 @@ func (nw *NotificationWorker) Start() error {
 
 	logrus.Infof("Starting Notification Worker with %d workers", nw.workers)
 
+	nw.registration = Registry.Register("notification", 1*time.Minute,
+		func() int { return len(nw.notificationQueue) },
+		nw.requeueStuckJob,
+	)
+
 	// Start worker goroutines
 	for i := 0; i < nw.workers; i++ {
 		nw.wg.Add(1)
@@ -139,6 +147,18 @@ func (nw *NotificationWorker) Start() error {
 	nw.wg.Add(1)
 	go nw.pendingNotificationPoller()
 
+	// Start broadcast poller
+	nw.wg.Add(1)
+	go nw.broadcastPoller()
+
+	// Start deferred notification poller
+	nw.wg.Add(1)
+	go nw.deferredNotificationPoller()
+
+	// Start notification experiment poller
+	nw.wg.Add(1)
+	go nw.experimentPoller()
+
 	// Start metrics collector
 	nw.wg.Add(1)
 	go nw.metricsCollector()
@@ -159,6 +179,7 @@ func (nw *NotificationWorker) Stop() error {
 
 	nw.cancel()
 	nw.isRunning = false
+	Registry.Unregister("notification")
 
 	close(nw.notificationQueue)
 	nw.wg.Wait()
@@ -194,6 +215,7 @@ func (nw *NotificationWorker) worker(workerID int) {
 
 	logrus.Infof("Notification worker %d started", workerID)
 
+	var backoff databaseBackoff
 	for {
 		select {
 		case job, ok := <-nw.notificationQueue:
@@ -202,6 +224,10 @@ func (nw *NotificationWorker) worker(workerID int) {
 				return
 			}
 
+			if !backoff.wait(nw.ctx) {
+				logrus.Infof("Notification worker %d stopping due to context cancellation", workerID)
+				return
+			}
 			nw.processNotification(job, workerID)
 
 		case <-nw.ctx.Done():
@@ -213,10 +239,14 @@ func (nw *NotificationWorker) worker(workerID int) {
 
 func (nw *NotificationWorker) processNotification(job NotificationJob, workerID int) {
 	startTime := time.Now()
+	failed := false
+
+	nw.registration.Begin(job.ID, job.RetryCount, job)
 
 	defer func() {
 		duration := time.Since(startTime)
 		nw.updateStats(duration, true)
+		nw.registration.Done(job.ID, job.RetryCount, !failed)
 	}()
 
 	ctx, cancel := context.WithTimeout(nw.ctx, nw.config.ProcessingTimeout)
@@ -229,6 +259,7 @@ func (nw *NotificationWorker) processNotification(job NotificationJob, workerID
 	prefs, err := nw.notificationRepo.GetUserPreferences(ctx, job.User.ID.Hex())
 	if err != nil {
 		logrus.Errorf("Failed to get user preferences: %v", err)
+		failed = true
 		nw.retryJob(job)
 		return
 	}
@@ -238,16 +269,24 @@ func (nw *NotificationWorker) processNotification(job NotificationJob, workerID
 		return
 	}
 
+	// Deferred and batched notifications are sent at their time by the
+	// deferred notification poller
+	if until := job.Notification.DeferredUntil; until != nil && until.After(time.Now()) {
+		logrus.Debugf("Notification %s deferred until %s", job.Notification.ID.Hex(), until.Format(time.RFC3339))
+		return
+	}
+
 	// Check quiet hours
-	if nw.isQuietHours(prefs.QuietHours) {
+	if job.Notification.Priority != "urgent" && nw.isQuietHours(ctx, job.User.ID.Hex()) {
 		logrus.Debugf("In quiet hours for user %s, skipping notification", job.User.ID.Hex())
 		return
 	}
 
 	var success bool
+	channels := nw.deliveryChannels(ctx, job)
 
 	// Send push notification
-	if job.Notification.Channels.Push && job.User.DeviceToken != "" {
+	if channels[models.DeliveryChannelPush] && job.User.DeviceToken != "" {
 		if nw.sendPushNotification(ctx, job) {
 			success = true
 			nw.incrementPushSent()
@@ -255,7 +294,7 @@ func (nw *NotificationWorker) processNotification(job NotificationJob, workerID
 	}
 
 	// Send SMS notification
-	if job.Notification.Channels.SMS && job.User.Phone != "" {
+	if channels[models.DeliveryChannelSMS] && job.User.Phone != "" {
 		if nw.sendSMSNotification(ctx, job) {
 			success = true
 			nw.incrementSMSSent()
@@ -263,7 +302,7 @@ func (nw *NotificationWorker) processNotification(job NotificationJob, workerID
 	}
 
 	// Send email notification
-	if job.Notification.Channels.Email && job.User.Email != "" {
+	if channels[models.DeliveryChannelEmail] && job.User.Email != "" {
 		if nw.sendEmailNotification(ctx, job) {
 			success = true
 			nw.incrementEmailSent()
@@ -285,13 +324,40 @@ func (nw *NotificationWorker) processNotification(job NotificationJob, workerID
 		logrus.Errorf("Failed to update notification status: %v", err)
 	}
 
-	if !success && job.RetryCount < nw.config.RetryAttempts {
-		nw.retryJob(job)
+	if !success {
+		failed = true
+		if job.RetryCount < nw.config.RetryAttempts {
+			nw.retryJob(job)
+		}
 	}
 
 	logrus.Debugf("Worker %d completed notification processing", workerID)
 }
 
+// deliveryChannels returns the channels the user's routing rules send the
+// notification on
+func (nw *NotificationWorker) deliveryChannels(ctx context.Context, job NotificationJob) map[string]bool {
+	channels := job.Notification.DeliveryChannels
+	if nw.notificationService != nil {
+		resolution, err := nw.notificationService.ResolveDeliveryChannels(ctx, job.User.ID.Hex(), models.ResolveChannelsRequest{
+			Type:             job.Notification.Type,
+			Priority:         job.Notification.Priority,
+			DeliveryChannels: channels,
+		})
+		if err != nil {
+			logrus.Warnf("Failed to resolve delivery channels for user %s: %v", job.User.ID.Hex(), err)
+		} else {
+			channels = resolution.Channels
+		}
+	}
+
+	set := make(map[string]bool, len(channels))
+	for _, channel := range channels {
+		set[channel] = true
+	}
+	return set
+}
+
 func (nw *NotificationWorker) sendPushNotification(ctx context.Context, job NotificationJob) bool {
 	if nw.pushService == nil {
 		return false
@@ -391,6 +457,29 @@ func (nw *NotificationWorker) retryJob(job NotificationJob) {
 	}()
 }
 
+// requeueStuckJob puts a job that hung in processing back on the queue
+func (nw *NotificationWorker) requeueStuckJob(item InFlightItem) {
+	job, ok := item.Payload.(NotificationJob)
+	if !ok {
+		return
+	}
+
+	if job.RetryCount >= nw.config.RetryAttempts {
+		logrus.Errorf("Notification job %s stuck after %d attempts, dropping", job.ID, job.RetryCount)
+		nw.incrementFailedJobs()
+		return
+	}
+
+	job.RetryCount++
+	nw.incrementRetriedJobs()
+
+	select {
+	case nw.notificationQueue <- job:
+	default:
+		logrus.Errorf("Failed to requeue stuck notification job %s", job.ID)
+	}
+}
+
 func (nw *NotificationWorker) pendingNotificationPoller() {
 	defer nw.wg.Done()
 
@@ -400,6 +489,9 @@ func (nw *NotificationWorker) pendingNotificationPoller() {
 	for {
 		select {
 		case <-ticker.C:
+			if database.Breaker.Allow() != nil {
+				continue
+			}
 			nw.processPendingNotifications()
 
 		case <-nw.ctx.Done():
@@ -440,16 +532,23 @@ func (nw *NotificationWorker) processPendingNotifications() {
 	}
 }
 
-func (nw *NotificationWorker) metricsCollector() {
+// broadcastPoller sends admin broadcasts once they are due, and resumes
+// those another instance stopped sending
+func (nw *NotificationWorker) broadcastPoller() {
 	defer nw.wg.Done()
 
-	ticker := time.NewTicker(1 * time.Minute)
+	ticker := time.NewTicker(nw.config.PollInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			nw.collectMetrics()
+			if database.Breaker.Allow() != nil {
+				continue
+			}
+			if err := nw.notificationService.ProcessDueBroadcasts(nw.ctx); err != nil {
+				logrus.Errorf("Failed to process broadcasts: %v", err)
+			}
 
 		case <-nw.ctx.Done():
 			return
@@ -457,57 +556,88 @@ func (nw *NotificationWorker) metricsCollector() {
 	}
 }
 
-func (nw *NotificationWorker) collectMetrics() {
-	nw.statsMutex.Lock()
-	defer nw.statsMutex.Unlock()
-
-	nw.stats.QueueLength = len(nw.notificationQueue)
-}
+// deferredNotificationPoller sends notifications that send-time
+// optimization held back once their time comes, and notification batches
+// once their window closes
+func (nw *NotificationWorker) deferredNotificationPoller() {
+	defer nw.wg.Done()
 
-func (nw *NotificationWorker) isQuietHours(quietHours models.NotificationQuietHours) bool {
-	if !quietHours.Enabled {
-		return false
-	}
+	ticker := time.NewTicker(nw.config.PollInterval)
+	defer ticker.Stop()
 
-	now := time.Now()
+	for {
+		select {
+		case <-ticker.C:
+			if database.Breaker.Allow() != nil {
+				continue
+			}
+			if err := nw.notificationService.DeliverDeferredNotifications(nw.ctx); err != nil {
+				logrus.Errorf("Failed to deliver deferred notifications: %v", err)
+			}
 
-	// Parse start and end times
-	startTime, err := time.Parse("15:04", quietHours.StartTime)
-	if err != nil {
-		return false
+		case <-nw.ctx.Done():
+			return
+		}
 	}
+}
 
-	endTime, err := time.Parse("15:04", quietHours.EndTime)
-	if err != nil {
-		return false
-	}
+// experimentPoller starts notification experiments once they are due, and
+// ends them, promoting their winning variant, once they are over
+func (nw *NotificationWorker) experimentPoller() {
+	defer nw.wg.Done()
 
-	// Check if current day is in weekdays
-	if len(quietHours.Weekdays) > 0 {
-		currentWeekday := int(now.Weekday())
-		found := false
-		for _, weekday := range quietHours.Weekdays {
-			if weekday == currentWeekday {
-				found = true
-				break
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if database.Breaker.Allow() != nil {
+				continue
+			}
+			if err := nw.notificationService.ProcessExperiments(nw.ctx); err != nil {
+				logrus.Errorf("Failed to process notification experiments: %v", err)
 			}
+
+		case <-nw.ctx.Done():
+			return
 		}
-		if !found {
-			return false
+	}
+}
+
+func (nw *NotificationWorker) metricsCollector() {
+	defer nw.wg.Done()
+
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			nw.collectMetrics()
+			nw.registration.Heartbeat()
+
+		case <-nw.ctx.Done():
+			return
 		}
 	}
+}
 
-	// Check if current time is within quiet hours
-	currentTime := now.Hour()*60 + now.Minute()
-	startMinutes := startTime.Hour()*60 + startTime.Minute()
-	endMinutes := endTime.Hour()*60 + endTime.Minute()
+func (nw *NotificationWorker) collectMetrics() {
+	nw.statsMutex.Lock()
+	defer nw.statsMutex.Unlock()
+
+	nw.stats.QueueLength = len(nw.notificationQueue)
+}
 
-	if startMinutes <= endMinutes {
-		return currentTime >= startMinutes && currentTime <= endMinutes
-	} else {
-		// Overnight quiet hours
-		return currentTime >= startMinutes || currentTime <= endMinutes
+// isQuietHours checks the windows of the user's quiet hours for the
+// current day, and the night before's window running past midnight
+func (nw *NotificationWorker) isQuietHours(ctx context.Context, userID string) bool {
+	settings, err := nw.notificationRepo.GetPushSettings(ctx, userID)
+	if err != nil || settings == nil {
+		return false
 	}
+	return settings.QuietHours.ActiveAt(time.Now())
 }
 
 func (nw *NotificationWorker) getPriority(priority string) int {
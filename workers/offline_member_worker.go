@@ -0,0 +1,193 @@
+package workers
+
+import (
+	"context"
+	"ftrack/repositories"
+	"ftrack/services"
+	"ftrack/websocket"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const offlineMemberCheckLockKey = "offline_members:check:lock"
+
+// OfflineMemberWorker tells circles that opted in when a member's location
+// stops updating
+type OfflineMemberWorker struct {
+	// Dependencies
+	db    *mongo.Database
+	redis *redis.Client
+
+	// Services
+	locationService *services.LocationService
+
+	// Worker configuration
+	config OfflineMemberWorkerConfig
+
+	// Worker state
+	isRunning bool
+	mutex     sync.RWMutex
+
+	// Context for shutdown
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// Metrics
+	stats      OfflineMemberWorkerStats
+	statsMutex sync.RWMutex
+}
+
+type OfflineMemberWorkerConfig struct {
+	CheckInterval time.Duration `json:"checkInterval"`
+	BatchSize     int           `json:"batchSize"`
+}
+
+type OfflineMemberWorkerStats struct {
+	MembersAlerted int64     `json:"membersAlerted"`
+	CheckErrors    int64     `json:"checkErrors"`
+	LastCheckAt    time.Time `json:"lastCheckAt"`
+	StartTime      time.Time `json:"startTime"`
+}
+
+func NewOfflineMemberWorker(db *mongo.Database, redis *redis.Client, hub *websocket.Hub) *OfflineMemberWorker {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	config := OfflineMemberWorkerConfig{
+		CheckInterval: 1 * time.Minute,
+		BatchSize:     200,
+	}
+
+	locationRepo := repositories.NewLocationRepository(db)
+	circleRepo := repositories.NewCircleRepository(db)
+	placeRepo := repositories.NewPlaceRepository(db)
+	userRepo := repositories.NewUserRepository(db)
+	blockRepo := repositories.NewBlockRepository(db)
+	notificationRepo := repositories.NewNotificationRepository(db)
+
+	notificationService := services.NewNotificationService(
+		notificationRepo,
+		userRepo,
+		circleRepo,
+		blockRepo,
+		redis,
+		hub,
+		nil, // EmailService
+		nil, // SMSService
+		services.NewPushService(nil, notificationRepo),
+	)
+
+	locationService := services.NewLocationService(locationRepo, circleRepo, placeRepo, userRepo, blockRepo, nil, hub)
+	locationService.ConfigureOfflineAlerts(notificationService)
+
+	return &OfflineMemberWorker{
+		db:              db,
+		redis:           redis,
+		locationService: locationService,
+		config:          config,
+		ctx:             ctx,
+		cancel:          cancel,
+		stats: OfflineMemberWorkerStats{
+			StartTime: time.Now(),
+		},
+	}
+}
+
+func (ow *OfflineMemberWorker) Start() error {
+	ow.mutex.Lock()
+	defer ow.mutex.Unlock()
+
+	if ow.isRunning {
+		return nil
+	}
+
+	ow.isRunning = true
+
+	logrus.Info("Starting Offline Member Worker...")
+
+	ow.wg.Add(1)
+	go ow.checkScheduler()
+
+	logrus.Info("Offline Member Worker started successfully")
+	return nil
+}
+
+func (ow *OfflineMemberWorker) Stop() error {
+	ow.mutex.Lock()
+	defer ow.mutex.Unlock()
+
+	if !ow.isRunning {
+		return nil
+	}
+
+	logrus.Info("Stopping Offline Member Worker...")
+
+	ow.cancel()
+	ow.isRunning = false
+	ow.wg.Wait()
+
+	logrus.Info("Offline Member Worker stopped successfully")
+	return nil
+}
+
+func (ow *OfflineMemberWorker) checkScheduler() {
+	defer ow.wg.Done()
+
+	ticker := time.NewTicker(ow.config.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ow.runCheck()
+
+		case <-ow.ctx.Done():
+			return
+		}
+	}
+}
+
+func (ow *OfflineMemberWorker) runCheck() {
+	// Only one instance checks, so circles get each alert once
+	if ow.redis != nil {
+		acquired, err := ow.redis.SetNX(ow.ctx, offlineMemberCheckLockKey, "1", ow.config.CheckInterval).Result()
+		if err != nil || !acquired {
+			return
+		}
+		defer ow.redis.Del(context.Background(), offlineMemberCheckLockKey)
+	}
+
+	alerted, err := ow.locationService.CheckOfflineMembers(ow.ctx, ow.config.BatchSize)
+
+	ow.statsMutex.Lock()
+	defer ow.statsMutex.Unlock()
+
+	ow.stats.MembersAlerted += int64(alerted)
+	ow.stats.LastCheckAt = time.Now()
+
+	if err != nil {
+		ow.stats.CheckErrors++
+		logrus.Errorf("Offline member check failed: %v", err)
+	}
+}
+
+func (ow *OfflineMemberWorker) GetStats() OfflineMemberWorkerStats {
+	ow.statsMutex.RLock()
+	defer ow.statsMutex.RUnlock()
+	return ow.stats
+}
+
+// Public function to start offline member worker
+func StartOfflineMemberWorker(db *mongo.Database, redis *redis.Client, hub *websocket.Hub) *OfflineMemberWorker {
+	worker := NewOfflineMemberWorker(db, redis, hub)
+
+	if err := worker.Start(); err != nil {
+		logrus.Fatalf("Failed to start offline member worker: %v", err)
+	}
+
+	return worker
+}
@@ -0,0 +1,203 @@
+package workers
+
+import (
+	"context"
+	"ftrack/repositories"
+	"ftrack/services"
+	"ftrack/websocket"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// OutboxWorker delivers outbox events that weren't delivered when they were
+// stored: failed attempts due for a retry, and events left behind by an
+// instance that crashed or restarted. Every instance runs one, since each
+// has its own hub; leases keep two instances off the same event.
+type OutboxWorker struct {
+	// Dependencies
+	db    *mongo.Database
+	redis *redis.Client
+
+	// Services
+	outboxService *services.OutboxService
+
+	// Worker configuration
+	config OutboxWorkerConfig
+
+	// Worker state
+	isRunning bool
+	mutex     sync.RWMutex
+
+	// Context for shutdown
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// Metrics
+	stats      OutboxWorkerStats
+	statsMutex sync.RWMutex
+}
+
+type OutboxWorkerConfig struct {
+	PollInterval time.Duration `json:"pollInterval"`
+	BatchSize    int           `json:"batchSize"`
+}
+
+type OutboxWorkerStats struct {
+	EventsDelivered int64     `json:"eventsDelivered"`
+	DispatchErrors  int64     `json:"dispatchErrors"`
+	Pending         int64     `json:"pending"`
+	LastDispatchAt  time.Time `json:"lastDispatchAt"`
+	StartTime       time.Time `json:"startTime"`
+}
+
+func NewOutboxWorker(db *mongo.Database, redis *redis.Client, hub *websocket.Hub) *OutboxWorker {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	config := OutboxWorkerConfig{
+		PollInterval: 5 * time.Second,
+		BatchSize:    100,
+	}
+
+	notificationRepo := repositories.NewNotificationRepository(db)
+
+	notificationService := services.NewNotificationService(
+		notificationRepo,
+		repositories.NewUserRepository(db),
+		repositories.NewCircleRepository(db),
+		repositories.NewBlockRepository(db),
+		redis,
+		hub,
+		nil, // EmailService
+		nil, // SMSService
+		services.NewPushService(nil, notificationRepo),
+	)
+
+	return &OutboxWorker{
+		db:    db,
+		redis: redis,
+		outboxService: services.NewOutboxService(
+			repositories.NewOutboxRepository(db),
+			hub,
+			notificationService,
+		),
+		config: config,
+		ctx:    ctx,
+		cancel: cancel,
+		stats: OutboxWorkerStats{
+			StartTime: time.Now(),
+		},
+	}
+}
+
+func (ow *OutboxWorker) Start() error {
+	ow.mutex.Lock()
+	defer ow.mutex.Unlock()
+
+	if ow.isRunning {
+		return nil
+	}
+
+	ow.isRunning = true
+
+	logrus.Info("Starting Outbox Worker...")
+
+	ow.wg.Add(1)
+	go ow.dispatchScheduler()
+
+	logrus.Info("Outbox Worker started successfully")
+	return nil
+}
+
+func (ow *OutboxWorker) Stop() error {
+	ow.mutex.Lock()
+	defer ow.mutex.Unlock()
+
+	if !ow.isRunning {
+		return nil
+	}
+
+	logrus.Info("Stopping Outbox Worker...")
+
+	ow.cancel()
+	ow.isRunning = false
+	ow.wg.Wait()
+
+	logrus.Info("Outbox Worker stopped successfully")
+	return nil
+}
+
+func (ow *OutboxWorker) dispatchScheduler() {
+	defer ow.wg.Done()
+
+	// Pick up what the last run left behind straight away
+	ow.runDispatch()
+
+	ticker := time.NewTicker(ow.config.PollInterval)
+	defer ticker.Stop()
+
+	var backoff databaseBackoff
+	for {
+		select {
+		case <-ticker.C:
+			if !backoff.wait(ow.ctx) {
+				return
+			}
+			ow.runDispatch()
+
+		case <-ow.ctx.Done():
+			return
+		}
+	}
+}
+
+// runDispatch delivers due events in batches until none are left
+func (ow *OutboxWorker) runDispatch() {
+	var delivered int
+	var err error
+	for {
+		var batch int
+		batch, err = ow.outboxService.DispatchDue(ow.ctx, ow.config.BatchSize)
+		delivered += batch
+		if err != nil || batch < ow.config.BatchSize || ow.ctx.Err() != nil {
+			break
+		}
+	}
+
+	pending, countErr := ow.outboxService.PendingCount(ow.ctx)
+
+	ow.statsMutex.Lock()
+	defer ow.statsMutex.Unlock()
+
+	ow.stats.EventsDelivered += int64(delivered)
+	ow.stats.LastDispatchAt = time.Now()
+	if countErr == nil {
+		ow.stats.Pending = pending
+	}
+
+	if err != nil {
+		ow.stats.DispatchErrors++
+		logrus.Errorf("Outbox dispatch failed: %v", err)
+	}
+}
+
+func (ow *OutboxWorker) GetStats() OutboxWorkerStats {
+	ow.statsMutex.RLock()
+	defer ow.statsMutex.RUnlock()
+	return ow.stats
+}
+
+// Public function to start outbox worker
+func StartOutboxWorker(db *mongo.Database, redis *redis.Client, hub *websocket.Hub) *OutboxWorker {
+	worker := NewOutboxWorker(db, redis, hub)
+
+	if err := worker.Start(); err != nil {
+		logrus.Fatalf("Failed to start outbox worker: %v", err)
+	}
+
+	return worker
+}
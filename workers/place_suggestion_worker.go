@@ -0,0 +1,182 @@
+package workers
+
+import (
+	"context"
+	"ftrack/repositories"
+	"ftrack/services"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const placeSuggestionLockKey = "place_suggestions:analysis:lock"
+
+// PlaceSuggestionWorker suggests places where users often stay, from their
+// location history
+type PlaceSuggestionWorker struct {
+	// Dependencies
+	db    *mongo.Database
+	redis *redis.Client
+
+	// Services
+	placeService *services.PlaceService
+
+	// Worker configuration
+	config PlaceSuggestionWorkerConfig
+
+	// Worker state
+	isRunning bool
+	mutex     sync.RWMutex
+
+	// Context for shutdown
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// Metrics
+	stats      PlaceSuggestionWorkerStats
+	statsMutex sync.RWMutex
+}
+
+type PlaceSuggestionWorkerConfig struct {
+	// Users who reported a location since the previous analysis are
+	// analyzed again
+	AnalysisInterval time.Duration `json:"analysisInterval"`
+}
+
+type PlaceSuggestionWorkerStats struct {
+	PlacesSuggested int64     `json:"placesSuggested"`
+	AnalysisErrors  int64     `json:"analysisErrors"`
+	LastAnalysisAt  time.Time `json:"lastAnalysisAt"`
+	StartTime       time.Time `json:"startTime"`
+}
+
+func NewPlaceSuggestionWorker(db *mongo.Database, redis *redis.Client) *PlaceSuggestionWorker {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	config := PlaceSuggestionWorkerConfig{
+		AnalysisInterval: 24 * time.Hour,
+	}
+
+	placeService := services.NewPlaceService(
+		repositories.NewPlaceRepository(db),
+		repositories.NewCircleRepository(db),
+		nil, // ExportService
+	)
+	placeService.ConfigureSuggestionHistory(repositories.NewLocationRepository(db))
+
+	return &PlaceSuggestionWorker{
+		db:           db,
+		redis:        redis,
+		placeService: placeService,
+		config:       config,
+		ctx:          ctx,
+		cancel:       cancel,
+		stats: PlaceSuggestionWorkerStats{
+			StartTime: time.Now(),
+		},
+	}
+}
+
+func (pw *PlaceSuggestionWorker) Start() error {
+	pw.mutex.Lock()
+	defer pw.mutex.Unlock()
+
+	if pw.isRunning {
+		return nil
+	}
+
+	pw.isRunning = true
+
+	logrus.Info("Starting Place Suggestion Worker...")
+
+	pw.wg.Add(1)
+	go pw.analysisScheduler()
+
+	logrus.Info("Place Suggestion Worker started successfully")
+	return nil
+}
+
+func (pw *PlaceSuggestionWorker) Stop() error {
+	pw.mutex.Lock()
+	defer pw.mutex.Unlock()
+
+	if !pw.isRunning {
+		return nil
+	}
+
+	logrus.Info("Stopping Place Suggestion Worker...")
+
+	pw.cancel()
+	pw.isRunning = false
+	pw.wg.Wait()
+
+	logrus.Info("Place Suggestion Worker stopped successfully")
+	return nil
+}
+
+func (pw *PlaceSuggestionWorker) analysisScheduler() {
+	defer pw.wg.Done()
+
+	ticker := time.NewTicker(pw.config.AnalysisInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			pw.runAnalysis()
+
+		case <-pw.ctx.Done():
+			return
+		}
+	}
+}
+
+func (pw *PlaceSuggestionWorker) runAnalysis() {
+	// Only one instance analyzes, so a place isn't suggested twice
+	if pw.redis != nil {
+		acquired, err := pw.redis.SetNX(pw.ctx, placeSuggestionLockKey, "1", pw.config.AnalysisInterval).Result()
+		if err != nil || !acquired {
+			return
+		}
+		defer pw.redis.Del(context.Background(), placeSuggestionLockKey)
+	}
+
+	suggested, err := pw.placeService.GeneratePlaceSuggestions(pw.ctx, time.Now().Add(-pw.config.AnalysisInterval))
+
+	pw.statsMutex.Lock()
+	defer pw.statsMutex.Unlock()
+
+	pw.stats.PlacesSuggested += int64(suggested)
+	pw.stats.LastAnalysisAt = time.Now()
+
+	if err != nil {
+		pw.stats.AnalysisErrors++
+		logrus.Errorf("Place suggestion analysis failed: %v", err)
+		return
+	}
+
+	if suggested > 0 {
+		logrus.Infof("Suggested %d places", suggested)
+	}
+}
+
+func (pw *PlaceSuggestionWorker) GetStats() PlaceSuggestionWorkerStats {
+	pw.statsMutex.RLock()
+	defer pw.statsMutex.RUnlock()
+	return pw.stats
+}
+
+// Public function to start place suggestion worker
+func StartPlaceSuggestionWorker(db *mongo.Database, redis *redis.Client) *PlaceSuggestionWorker {
+	worker := NewPlaceSuggestionWorker(db, redis)
+
+	if err := worker.Start(); err != nil {
+		logrus.Fatalf("Failed to start place suggestion worker: %v", err)
+	}
+
+	return worker
+}
@@ -0,0 +1,256 @@
+package workers
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"ftrack/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// WorkerRegistry tracks the queue workers' heartbeats, throughput and
+// in-flight items, so stuck jobs and dead workers show up before users
+// notice missing events
+type WorkerRegistry struct {
+	config WorkerRegistryConfig
+
+	workers map[string]*WorkerHandle
+	mutex   sync.RWMutex
+
+	monitorOnce sync.Once
+}
+
+type WorkerRegistryConfig struct {
+	// In-flight items older than this are put back on the queue
+	StuckAfter time.Duration `json:"stuckAfter"`
+
+	// Heartbeat intervals a worker may miss before it counts as degraded
+	MissedHeartbeats int `json:"missedHeartbeats"`
+
+	CheckInterval time.Duration `json:"checkInterval"`
+}
+
+// Registry is shared by the workers and the health endpoints
+var Registry = NewWorkerRegistry(WorkerRegistryConfig{
+	StuckAfter:       5 * time.Minute,
+	MissedHeartbeats: 3,
+	CheckInterval:    1 * time.Minute,
+})
+
+// WorkerHandle is a worker's registration. Workers report heartbeats and
+// the start and end of each job through it.
+type WorkerHandle struct {
+	name              string
+	heartbeatInterval time.Duration
+	backlog           func() int
+	requeue           func(item InFlightItem)
+
+	mutex         sync.Mutex
+	lastHeartbeat time.Time
+	processed     int64 // since the last heartbeat
+	failed        int64
+	lastProcessed int64 // during the last full interval
+	lastFailed    int64
+	stuckRequeued int64
+	inFlight      map[string]*InFlightItem
+}
+
+// InFlightItem is a job a worker has picked up but not finished
+type InFlightItem struct {
+	ID        string
+	Attempt   int
+	StartedAt time.Time
+	Payload   interface{}
+}
+
+func NewWorkerRegistry(config WorkerRegistryConfig) *WorkerRegistry {
+	return &WorkerRegistry{
+		config:  config,
+		workers: make(map[string]*WorkerHandle),
+	}
+}
+
+// Register adds a worker. backlog estimates the queued items and requeue
+// puts a stuck item back on the queue with its attempt incremented.
+func (wr *WorkerRegistry) Register(name string, heartbeatInterval time.Duration, backlog func() int, requeue func(item InFlightItem)) *WorkerHandle {
+	handle := &WorkerHandle{
+		name:              name,
+		heartbeatInterval: heartbeatInterval,
+		backlog:           backlog,
+		requeue:           requeue,
+		lastHeartbeat:     time.Now(),
+		inFlight:          make(map[string]*InFlightItem),
+	}
+
+	wr.mutex.Lock()
+	wr.workers[name] = handle
+	wr.mutex.Unlock()
+
+	wr.monitorOnce.Do(func() {
+		go wr.monitor()
+	})
+
+	return handle
+}
+
+// Unregister removes a worker that was stopped on purpose
+func (wr *WorkerRegistry) Unregister(name string) {
+	wr.mutex.Lock()
+	delete(wr.workers, name)
+	wr.mutex.Unlock()
+}
+
+// Statuses returns every registered worker's status, sorted by name
+func (wr *WorkerRegistry) Statuses() []models.WorkerStatus {
+	wr.mutex.RLock()
+	handles := make([]*WorkerHandle, 0, len(wr.workers))
+	for _, handle := range wr.workers {
+		handles = append(handles, handle)
+	}
+	wr.mutex.RUnlock()
+
+	statuses := make([]models.WorkerStatus, 0, len(handles))
+	for _, handle := range handles {
+		statuses = append(statuses, handle.status(wr.config.MissedHeartbeats))
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// DegradedWorkers returns the names of workers that missed too many
+// heartbeats
+func (wr *WorkerRegistry) DegradedWorkers() []string {
+	var degraded []string
+	for _, status := range wr.Statuses() {
+		if status.Status == models.WorkerStatusDegraded {
+			degraded = append(degraded, status.Name)
+		}
+	}
+	return degraded
+}
+
+func (wr *WorkerRegistry) monitor() {
+	ticker := time.NewTicker(wr.config.CheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		wr.mutex.RLock()
+		handles := make([]*WorkerHandle, 0, len(wr.workers))
+		for _, handle := range wr.workers {
+			handles = append(handles, handle)
+		}
+		wr.mutex.RUnlock()
+
+		for _, handle := range handles {
+			handle.requeueStuck(wr.config.StuckAfter)
+
+			if status := handle.status(wr.config.MissedHeartbeats); status.Status == models.WorkerStatusDegraded {
+				logrus.WithFields(logrus.Fields{
+					"worker":        status.Name,
+					"lastHeartbeat": status.LastHeartbeat,
+				}).Warn("Worker missed heartbeats")
+			}
+		}
+	}
+}
+
+// Heartbeat marks the worker alive and closes the current interval
+func (h *WorkerHandle) Heartbeat() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.lastHeartbeat = time.Now()
+	h.lastProcessed = h.processed
+	h.lastFailed = h.failed
+	h.processed = 0
+	h.failed = 0
+}
+
+// Begin records that the worker picked up an item
+func (h *WorkerHandle) Begin(id string, attempt int, payload interface{}) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.inFlight[id] = &InFlightItem{
+		ID:        id,
+		Attempt:   attempt,
+		StartedAt: time.Now(),
+		Payload:   payload,
+	}
+}
+
+// Done records that the worker finished an attempt at an item. Attempts
+// already requeued as stuck are not counted again.
+func (h *WorkerHandle) Done(id string, attempt int, success bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	item, exists := h.inFlight[id]
+	if !exists || item.Attempt != attempt {
+		return
+	}
+	delete(h.inFlight, id)
+
+	if success {
+		h.processed++
+	} else {
+		h.failed++
+	}
+}
+
+func (h *WorkerHandle) requeueStuck(stuckAfter time.Duration) {
+	h.mutex.Lock()
+	var stuck []InFlightItem
+	for id, item := range h.inFlight {
+		if time.Since(item.StartedAt) > stuckAfter {
+			stuck = append(stuck, *item)
+			delete(h.inFlight, id)
+		}
+	}
+	h.stuckRequeued += int64(len(stuck))
+	h.mutex.Unlock()
+
+	for _, item := range stuck {
+		logrus.WithFields(logrus.Fields{
+			"worker":  h.name,
+			"itemId":  item.ID,
+			"attempt": item.Attempt + 1,
+			"stuck":   time.Since(item.StartedAt).Round(time.Second).String(),
+		}).Warn("Requeueing stuck worker item")
+
+		if h.requeue != nil {
+			h.requeue(item)
+		}
+	}
+}
+
+func (h *WorkerHandle) status(missedHeartbeats int) models.WorkerStatus {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	status := models.WorkerStatus{
+		Name:              h.name,
+		Status:            models.WorkerStatusHealthy,
+		LastHeartbeat:     h.lastHeartbeat,
+		HeartbeatInterval: h.heartbeatInterval.String(),
+		Processed:         h.lastProcessed,
+		Failed:            h.lastFailed,
+		InFlight:          len(h.inFlight),
+		StuckRequeued:     h.stuckRequeued,
+	}
+
+	if h.backlog != nil {
+		status.Backlog = h.backlog()
+	}
+
+	if time.Since(h.lastHeartbeat) > time.Duration(missedHeartbeats)*h.heartbeatInterval {
+		status.Status = models.WorkerStatusDegraded
+	}
+
+	return status
+}
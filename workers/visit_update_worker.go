@@ -0,0 +1,175 @@
+package workers
+
+import (
+	"context"
+	"ftrack/repositories"
+	"ftrack/services"
+	"ftrack/websocket"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// VisitUpdateWorker keeps live "at place" durations current. Every instance
+// runs it, since each one only reaches the clients connected to its hub.
+type VisitUpdateWorker struct {
+	// Dependencies
+	db    *mongo.Database
+	redis *redis.Client
+
+	// Services
+	locationService *services.LocationService
+
+	// Worker configuration
+	config VisitUpdateWorkerConfig
+
+	// Worker state
+	isRunning bool
+	mutex     sync.RWMutex
+
+	// Context for shutdown
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// Metrics
+	stats      VisitUpdateWorkerStats
+	statsMutex sync.RWMutex
+}
+
+type VisitUpdateWorkerConfig struct {
+	// How often open visits are re-sent. Clients count up from the arrival
+	// time in between, so this only corrects drift.
+	UpdateInterval time.Duration `json:"updateInterval"`
+	BatchSize      int           `json:"batchSize"`
+}
+
+type VisitUpdateWorkerStats struct {
+	UpdatesSent  int64     `json:"updatesSent"`
+	Errors       int64     `json:"errors"`
+	LastUpdateAt time.Time `json:"lastUpdateAt"`
+	StartTime    time.Time `json:"startTime"`
+}
+
+func NewVisitUpdateWorker(db *mongo.Database, redis *redis.Client, hub *websocket.Hub) *VisitUpdateWorker {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	config := VisitUpdateWorkerConfig{
+		UpdateInterval: 5 * time.Minute,
+		BatchSize:      500,
+	}
+
+	locationRepo := repositories.NewLocationRepository(db)
+	placeRepo := repositories.NewPlaceRepository(db)
+
+	locationService := services.NewLocationService(
+		locationRepo,
+		repositories.NewCircleRepository(db),
+		placeRepo,
+		repositories.NewUserRepository(db),
+		repositories.NewBlockRepository(db),
+		services.NewGeofenceService(placeRepo, locationRepo, hub),
+		hub,
+	)
+
+	return &VisitUpdateWorker{
+		db:              db,
+		redis:           redis,
+		locationService: locationService,
+		config:          config,
+		ctx:             ctx,
+		cancel:          cancel,
+		stats: VisitUpdateWorkerStats{
+			StartTime: time.Now(),
+		},
+	}
+}
+
+func (vw *VisitUpdateWorker) Start() error {
+	vw.mutex.Lock()
+	defer vw.mutex.Unlock()
+
+	if vw.isRunning {
+		return nil
+	}
+
+	vw.isRunning = true
+
+	logrus.Info("Starting Visit Update Worker...")
+
+	vw.wg.Add(1)
+	go vw.updateScheduler()
+
+	logrus.Info("Visit Update Worker started successfully")
+	return nil
+}
+
+func (vw *VisitUpdateWorker) Stop() error {
+	vw.mutex.Lock()
+	defer vw.mutex.Unlock()
+
+	if !vw.isRunning {
+		return nil
+	}
+
+	logrus.Info("Stopping Visit Update Worker...")
+
+	vw.cancel()
+	vw.isRunning = false
+	vw.wg.Wait()
+
+	logrus.Info("Visit Update Worker stopped successfully")
+	return nil
+}
+
+func (vw *VisitUpdateWorker) updateScheduler() {
+	defer vw.wg.Done()
+
+	ticker := time.NewTicker(vw.config.UpdateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			vw.sendUpdates()
+
+		case <-vw.ctx.Done():
+			return
+		}
+	}
+}
+
+func (vw *VisitUpdateWorker) sendUpdates() {
+	sent, err := vw.locationService.BroadcastOngoingVisits(vw.ctx, vw.config.BatchSize)
+
+	vw.statsMutex.Lock()
+	defer vw.statsMutex.Unlock()
+
+	vw.stats.UpdatesSent += int64(sent)
+	vw.stats.LastUpdateAt = time.Now()
+
+	if err != nil {
+		vw.stats.Errors++
+		logrus.Errorf("Sending visit updates failed: %v", err)
+	}
+}
+
+func (vw *VisitUpdateWorker) GetStats() VisitUpdateWorkerStats {
+	vw.statsMutex.RLock()
+	defer vw.statsMutex.RUnlock()
+	return vw.stats
+}
+
+// Public function to start visit update worker
+func StartVisitUpdateWorker(db *mongo.Database, redis *redis.Client, hub *websocket.Hub) *VisitUpdateWorker {
+	worker := NewVisitUpdateWorker(db, redis, hub)
+
+	if err := worker.Start(); err != nil {
+		logrus.Fatalf("Failed to start visit update worker: %v", err)
+	}
+
+	return worker
+}